@@ -5,7 +5,7 @@
 //! in `wafer_block::http_codec` — the same implementation the native axum
 //! listener and the browser adapter use. Only worker-type I/O lives here.
 
-use wafer_block::http_codec::{self, META_HTTP_PATH};
+use wafer_block::http_codec;
 use wafer_run::{InputStream, Message, OutputStream};
 use worker::{Request, Response, Result};
 
@@ -15,22 +15,16 @@ use worker::{Request, Response, Result};
 
 /// Convert a Cloudflare Worker Request into a WAFER `(Message, InputStream)`.
 ///
-/// Also normalizes paths by stripping the `/api` prefix.
+/// The path is passed through as received: the shared pipeline strips the
+/// `/api/v1` prefix and its deprecated `/api` alias (see
+/// `solobase_core::api_version`), so the alias gets its deprecation headers
+/// here too.
 pub async fn worker_request_to_message(req: &Request) -> Result<(Message, InputStream)> {
     let method = req.method().to_string();
     let url = req.url()?;
-    let raw_path = url.path().to_string();
+    let path = url.path().to_string();
     let query = url.query().unwrap_or("").to_string();
 
-    // Normalize path — strip /api prefix
-    let mut path = raw_path.clone();
-    if path.starts_with("/api") {
-        path = path[4..].to_string();
-        if path.is_empty() {
-            path = "/".to_string();
-        }
-    }
-
     // Read body (with size limit). A read error here would otherwise be
     // swallowed and turned into an empty body, silently corrupting POST/PUT.
     const MAX_BODY_SIZE: usize = 10 * 1024 * 1024; // 10 MB
@@ -65,11 +59,7 @@ pub async fn worker_request_to_message(req: &Request) -> Result<(Message, InputS
         .or_else(|| req.headers().get("x-forwarded-for").ok().flatten())
         .unwrap_or_else(|| "unknown".to_string());
 
-    let mut msg =
-        http_codec::build_http_message(&method, &path, &query, &remote_addr, req.headers());
-    // The message kind and normalized `req.resource` use the /api-stripped
-    // path; `http.path` keeps the path as received on the wire.
-    msg.set_meta(META_HTTP_PATH, raw_path);
+    let msg = http_codec::build_http_message(&method, &path, &query, &remote_addr, req.headers());
 
     Ok((msg, InputStream::from_bytes(body)))
 }
@@ -7,6 +7,12 @@
 //!   like wafer-site).
 //!
 //! This crate is wasm-only; building for native targets is not supported.
+//!
+//! Workers have no long-lived task, so background jobs and cron schedules
+//! (`solobase_core::blocks::schedules`) don't run on their own here:
+//! blocks register schedules as on native, but the consumer must drive
+//! them — e.g. a Cron Trigger whose `scheduled` handler sends `POST
+//! /b/admin/api/jobs/run` through the runtime every few minutes.
 
 pub mod config_service;
 pub mod config_source;
@@ -0,0 +1,71 @@
+//! Stamps the build identity `crate::version` reports: the git commit, the
+//! build time and the compiler. A value already set in the environment wins,
+//! so a release pipeline can pin all three; a build outside a git checkout
+//! leaves the commit for `crate::version` to report as unknown.
+
+use std::{env, process::Command};
+
+fn main() {
+    println!("cargo:rerun-if-changed=build.rs");
+    for key in [
+        "SOLOBASE_GIT_COMMIT",
+        "SOLOBASE_BUILD_EPOCH",
+        "SOLOBASE_RUSTC_VERSION",
+        "SOURCE_DATE_EPOCH",
+    ] {
+        println!("cargo:rerun-if-env-changed={key}");
+    }
+
+    let manifest_dir = env::var("CARGO_MANIFEST_DIR").expect("CARGO_MANIFEST_DIR set by cargo");
+    // A new commit moves HEAD (or the branch it points at).
+    if let Some(git_dir) = output("git", &["-C", &manifest_dir, "rev-parse", "--git-dir"]) {
+        let git_dir = std::path::Path::new(&manifest_dir).join(git_dir);
+        println!("cargo:rerun-if-changed={}", git_dir.join("HEAD").display());
+        println!(
+            "cargo:rerun-if-changed={}",
+            git_dir.join("refs/heads").display()
+        );
+    }
+
+    let commit = env::var("SOLOBASE_GIT_COMMIT").ok().or_else(|| {
+        output(
+            "git",
+            &["-C", &manifest_dir, "rev-parse", "--short=12", "HEAD"],
+        )
+    });
+    if let Some(commit) = commit {
+        println!("cargo:rustc-env=SOLOBASE_GIT_COMMIT={commit}");
+    }
+
+    // `SOURCE_DATE_EPOCH` keeps reproducible builds reproducible.
+    let epoch = env::var("SOLOBASE_BUILD_EPOCH")
+        .or_else(|_| env::var("SOURCE_DATE_EPOCH"))
+        .ok()
+        .or_else(|| {
+            std::time::SystemTime::now()
+                .duration_since(std::time::UNIX_EPOCH)
+                .ok()
+                .map(|d| d.as_secs().to_string())
+        });
+    if let Some(epoch) = epoch {
+        println!("cargo:rustc-env=SOLOBASE_BUILD_EPOCH={epoch}");
+    }
+
+    let rustc = env::var("RUSTC").unwrap_or_else(|_| "rustc".to_string());
+    let rustc_version = env::var("SOLOBASE_RUSTC_VERSION")
+        .ok()
+        .or_else(|| output(&rustc, &["--version"]));
+    if let Some(version) = rustc_version {
+        println!("cargo:rustc-env=SOLOBASE_RUSTC_VERSION={version}");
+    }
+}
+
+/// Trimmed stdout of a command that succeeded, `None` otherwise.
+fn output(program: &str, args: &[&str]) -> Option<String> {
+    let out = Command::new(program).args(args).output().ok()?;
+    if !out.status.success() {
+        return None;
+    }
+    let text = String::from_utf8(out.stdout).ok()?.trim().to_string();
+    (!text.is_empty()).then_some(text)
+}
@@ -0,0 +1,305 @@
+//! The v1 compatibility suite: what "breaking" means for `/api/v1`.
+//!
+//! Each test sends a core request through the full pipeline at its
+//! `/api/v1` path and compares the *shape* of the JSON answer — field
+//! names and value types, not values — with a snapshot in `snapshots/`.
+//! A field the snapshot names that is missing or changed type fails the
+//! test; a field the response adds is not breaking and passes.
+//!
+//! So a failure here means v1 clients would break. Either the change is a
+//! mistake, or it belongs under a new API version (see
+//! [`crate::api_version`]). When a shape changes on purpose — a new field
+//! a later check should hold on to, say — rewrite the snapshots and commit
+//! them with the change:
+//!
+//! ```text
+//! SOLOBASE_UPDATE_SNAPSHOTS=1 cargo test -p solobase-core api_compat
+//! ```
+//!
+//! A snapshot is the response with every value replaced by its type:
+//! `"string"`, `"number"`, `"boolean"`, an object of shapes, or an array
+//! holding the shape of its elements (merged over all of them). `"null"`
+//! marks a field that must be present but may hold anything, and `[]` an
+//! array whose elements aren't checked.
+
+use std::sync::Arc;
+
+use serde_json::Value;
+
+use crate::test_support::{TestApp, TestRequest, TestResponse};
+
+/// Set to rewrite the snapshots from the current responses.
+const UPDATE_ENV: &str = "SOLOBASE_UPDATE_SNAPSHOTS";
+
+const PASSWORD: &str = "correct-horse-battery";
+
+/// `value` with every leaf replaced by its type name.
+fn shape(value: &Value) -> Value {
+    match value {
+        Value::Null => Value::from("null"),
+        Value::Bool(_) => Value::from("boolean"),
+        Value::Number(_) => Value::from("number"),
+        Value::String(_) => Value::from("string"),
+        Value::Array(items) => match items.iter().map(shape).reduce(merge) {
+            Some(element) => Value::Array(vec![element]),
+            None => Value::Array(Vec::new()),
+        },
+        Value::Object(map) => Value::Object(
+            map.iter()
+                .map(|(key, value)| (key.clone(), shape(value)))
+                .collect(),
+        ),
+    }
+}
+
+/// Two shapes of the same position combined: objects keep every field,
+/// and a `null` gives way to the type the other side saw.
+fn merge(a: Value, b: Value) -> Value {
+    match (a, b) {
+        (Value::Object(mut a), Value::Object(b)) => {
+            for (key, b_value) in b {
+                let merged = match a.remove(&key) {
+                    Some(a_value) => merge(a_value, b_value),
+                    None => b_value,
+                };
+                a.insert(key, merged);
+            }
+            Value::Object(a)
+        }
+        (Value::Array(mut a), Value::Array(mut b)) => match (a.pop(), b.pop()) {
+            (Some(a), Some(b)) => Value::Array(vec![merge(a, b)]),
+            (a, b) => Value::Array(a.or(b).into_iter().collect()),
+        },
+        (a, b) if a == "null" => b,
+        (a, _) => a,
+    }
+}
+
+/// Every way `actual` (a [`shape`]) breaks `expected`, as `path: problem`.
+fn breaks(expected: &Value, actual: &Value, path: &str, out: &mut Vec<String>) {
+    match (expected, actual) {
+        (Value::String(kind), _) if kind == "null" => {}
+        (Value::Object(expected), Value::Object(actual)) => {
+            for (key, expected) in expected {
+                let at = format!("{path}.{key}");
+                match actual.get(key) {
+                    Some(actual) => breaks(expected, actual, &at, out),
+                    None => out.push(format!("{at}: missing")),
+                }
+            }
+        }
+        (Value::Array(expected), Value::Array(actual)) => {
+            if let Some(expected) = expected.first() {
+                match actual.first() {
+                    Some(actual) => breaks(expected, actual, &format!("{path}[]"), out),
+                    None => out.push(format!("{path}: no elements to check")),
+                }
+            }
+        }
+        (expected, actual) if expected == actual => {}
+        (expected, actual) => out.push(format!("{path}: expected {expected}, got {actual}")),
+    }
+}
+
+/// Check `res` against the `name` snapshot, or rewrite it under
+/// [`UPDATE_ENV`].
+fn assert_shape(name: &str, snapshot: &str, res: &TestResponse) {
+    assert_eq!(res.status, 200, "{name}: {}", res.text());
+    let actual = shape(&res.json());
+    if std::env::var_os(UPDATE_ENV).is_some() {
+        let path = format!(
+            "{}/src/api_compat/snapshots/{name}.json",
+            env!("CARGO_MANIFEST_DIR")
+        );
+        let pretty = serde_json::to_string_pretty(&actual).expect("serialize shape");
+        std::fs::write(&path, pretty + "\n").unwrap_or_else(|e| panic!("write {path}: {e}"));
+        return;
+    }
+    let expected: Value = serde_json::from_str(snapshot).expect("snapshot is valid JSON");
+    let mut out = Vec::new();
+    breaks(&expected, &actual, "$", &mut out);
+    assert!(
+        out.is_empty(),
+        "the v1 response shape of {name} changed:\n  {}\n\nThis breaks v1 clients. If the change \
+         is intended, rerun with {UPDATE_ENV}=1 and commit the updated snapshot.\nResponse: {}",
+        out.join("\n  "),
+        res.text()
+    );
+}
+
+/// An app with the auth, admin and password-hashing blocks, and a user who
+/// signed up with [`PASSWORD`].
+async fn app() -> TestApp {
+    use crate::blocks::{admin::AdminBlock, auth_ui};
+
+    let mut app = TestApp::new().await;
+    let svc = Arc::new(
+        wafer_block_crypto::service::Argon2JwtCryptoService::new(
+            "test-jwt-secret-padded-to-min-32-bytes-aaaa".to_string(),
+        )
+        .expect("test secret is long enough"),
+    );
+    let crypto: Arc<dyn wafer_run::Block> =
+        Arc::new(wafer_core::service_blocks::crypto::CryptoBlock::new(svc));
+    app.load_block("wafer-run/crypto", crypto, &[], &[])
+        .await
+        .unwrap();
+    app.load_block(
+        auth_ui::AUTH_UI_BLOCK_ID,
+        Arc::new(auth_ui::AuthUiBlock::new()),
+        &[],
+        &[],
+    )
+    .await
+    .unwrap();
+    app.load_block("suppers-ai/admin", Arc::new(AdminBlock::new()), &[], &[])
+        .await
+        .unwrap();
+
+    let body = serde_json::json!({ "email": "ada@example.com", "password": PASSWORD });
+    let msg = crate::test_support::anon_msg("create", "/b/auth/api/signup");
+    let out = auth_ui::api::signup::handle(
+        app.ctx(),
+        &msg,
+        wafer_run::InputStream::from_bytes(body.to_string().into_bytes()),
+    )
+    .await;
+    crate::test_support::collect_or_panic(out).await;
+    app
+}
+
+#[tokio::test]
+async fn auth_login() {
+    let app = app().await;
+    let res = app
+        .request(
+            TestRequest::post("/api/v1/b/auth/api/login")
+                .json(&serde_json::json!({ "email": "ada@example.com", "password": PASSWORD })),
+        )
+        .await;
+    assert_shape(
+        "auth_login",
+        include_str!("snapshots/auth_login.json"),
+        &res,
+    );
+}
+
+#[tokio::test]
+async fn admin_user_list() {
+    let app = app().await;
+    let admin = app.create_user("admin@example.com", "admin").await;
+    let res = app
+        .request(TestRequest::get("/api/v1/b/admin/api/users").as_user(&admin))
+        .await;
+    assert_shape(
+        "admin_user_list",
+        include_str!("snapshots/admin_user_list.json"),
+        &res,
+    );
+}
+
+/// [`app`] with the files block and a `docs` bucket holding
+/// `reports/q1.txt`.
+#[cfg(feature = "block-files")]
+async fn files_app() -> TestApp {
+    use crate::blocks::files::{migrations, repo, FilesBlock};
+
+    let mut app = app().await;
+    app.load_block(
+        "suppers-ai/files",
+        Arc::new(FilesBlock::new()),
+        migrations::SQLITE_MIGRATIONS,
+        migrations::POSTGRES_MIGRATIONS,
+    )
+    .await
+    .expect("load files block");
+    let bucket = crate::util::json_map(serde_json::json!({
+        "name": "docs",
+        "public": false,
+        "created_by": "ada",
+        "created_at": crate::util::now_rfc3339(),
+    }));
+    repo::buckets::seed(app.ctx(), bucket)
+        .await
+        .expect("seed bucket");
+    let object = crate::util::json_map(serde_json::json!({
+        "bucket": "docs",
+        "key": "reports/q1.txt",
+        "size": 12,
+        "content_type": "text/plain",
+        "sha256": crate::util::sha256_hex(b"hello, world"),
+        "status": "complete",
+        "uploaded_by": "ada",
+        "modified_by": "ada",
+        "uploaded_at": crate::util::now_rfc3339(),
+    }));
+    repo::objects::seed(app.ctx(), object)
+        .await
+        .expect("seed object");
+    app
+}
+
+#[cfg(feature = "block-files")]
+#[tokio::test]
+async fn storage_object_list() {
+    let app = files_app().await;
+    let admin = app.create_user("admin@example.com", "admin").await;
+    let res = app
+        .request(TestRequest::get("/api/v1/b/storage/api/buckets/docs/objects").as_user(&admin))
+        .await;
+    assert_shape(
+        "storage_object_list",
+        include_str!("snapshots/storage_object_list.json"),
+        &res,
+    );
+}
+
+#[cfg(feature = "block-files")]
+#[tokio::test]
+async fn storage_object_info() {
+    let app = files_app().await;
+    let admin = app.create_user("admin@example.com", "admin").await;
+    let res = app
+        .request(
+            TestRequest::get("/api/v1/b/storage/api/buckets/docs/info/reports/q1.txt")
+                .as_user(&admin),
+        )
+        .await;
+    assert_shape(
+        "storage_object_info",
+        include_str!("snapshots/storage_object_info.json"),
+        &res,
+    );
+}
+
+#[test]
+fn added_fields_pass_and_removed_or_retyped_ones_fail() {
+    let expected = serde_json::json!({
+        "id": "string",
+        "tags": ["string"],
+        "owner": { "name": "string" },
+        "extra": "null",
+    });
+    let check = |actual: Value| {
+        let mut out = Vec::new();
+        breaks(&expected, &shape(&actual), "$", &mut out);
+        out.sort();
+        out
+    };
+
+    let same = serde_json::json!({
+        "id": "a", "tags": [null, "x"], "owner": { "name": "n", "new": 1 }, "extra": 5,
+    });
+    assert!(check(same).is_empty());
+
+    let broken = serde_json::json!({ "id": 1, "tags": [], "owner": {} });
+    assert_eq!(
+        check(broken),
+        [
+            "$.extra: missing",
+            "$.id: expected \"string\", got \"number\"",
+            "$.owner.name: missing",
+            "$.tags: no elements to check",
+        ]
+    );
+}
@@ -0,0 +1,209 @@
+//! The versioned API prefix.
+//!
+//! Every route is reachable at `/api/v1/...` (`/api/v1/b/auth/api/login`,
+//! `/api/v1/version`); [`crate::pipeline::handle_request`] takes the
+//! prefix off before routing, so blocks see the same root-relative paths
+//! they always have. A breaking change to a response shape ships under a
+//! new version instead of changing v1 in place — the compatibility suite
+//! in `crate::api_compat` is what decides whether a change is breaking.
+//!
+//! The unversioned `/api/...` prefix is the v1 surface too, kept for
+//! existing integrations. Under [`ApiVersionPolicy::Alias`] (the default)
+//! it answers as before plus a `Deprecation` / `Sunset` header pair
+//! ([RFC 9745], [RFC 8594]) and a `Link` to the `/api/v1` successor. A new
+//! deployment with no clients to migrate can choose
+//! [`ApiVersionPolicy::VersionedOnly`] (native:
+//! `SOLOBASE_API_VERSION_POLICY=versioned-only`), and `/api/...` outside
+//! `/api/v1` answers 404.
+//!
+//! Paths without any `/api` prefix (`/b/...`, `/health`) are the pages and
+//! the platform endpoints, not the versioned API, and are unaffected.
+//!
+//! [RFC 9745]: https://www.rfc-editor.org/rfc/rfc9745
+//! [RFC 8594]: https://www.rfc-editor.org/rfc/rfc8594
+
+use wafer_run::{Message, MetaEntry};
+
+/// The current API version's prefix.
+pub const V1_PREFIX: &str = "/api/v1";
+
+/// The unversioned prefix [`ApiVersionPolicy::Alias`] keeps serving.
+const ALIAS_PREFIX: &str = "/api";
+
+/// When the unversioned alias was deprecated, as an RFC 9745 `@epoch`
+/// (2026-10-16T00:00:00Z).
+const DEPRECATION: &str = "@1792108800";
+
+/// When the unversioned alias is due to go away (RFC 8594 HTTP-date).
+const SUNSET: &str = "Fri, 01 Oct 2027 00:00:00 GMT";
+
+/// Whether the unversioned `/api/...` alias is served.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum ApiVersionPolicy {
+    /// Serve `/api/...` as v1, marked deprecated.
+    #[default]
+    Alias,
+    /// Serve only `/api/v1/...`.
+    VersionedOnly,
+}
+
+impl ApiVersionPolicy {
+    /// Parse a configured policy: `alias` (or empty) or `versioned-only`.
+    pub fn parse(raw: &str) -> Result<Self, String> {
+        match raw.trim().to_ascii_lowercase().as_str() {
+            "" | "alias" => Ok(Self::Alias),
+            "versioned-only" => Ok(Self::VersionedOnly),
+            _ => Err(format!(
+                "API version policy {raw:?} must be \"alias\" or \"versioned-only\""
+            )),
+        }
+    }
+}
+
+#[cfg(not(test))]
+static INSTALLED: std::sync::OnceLock<ApiVersionPolicy> = std::sync::OnceLock::new();
+
+// Per thread, so a test that turns the alias off doesn't leak into others.
+#[cfg(test)]
+thread_local! {
+    static INSTALLED: std::cell::Cell<Option<ApiVersionPolicy>> =
+        const { std::cell::Cell::new(None) };
+}
+
+/// Record the deployment's policy. Only the first call wins.
+#[cfg(not(test))]
+pub fn install(policy: ApiVersionPolicy) {
+    let _ = INSTALLED.set(policy);
+}
+
+/// Record the deployment's policy. Only the first call wins.
+#[cfg(test)]
+pub fn install(policy: ApiVersionPolicy) {
+    INSTALLED.with(|p| {
+        if p.get().is_none() {
+            p.set(Some(policy));
+        }
+    });
+}
+
+/// The [`install`]ed policy, [`ApiVersionPolicy::Alias`] when none was.
+pub fn policy() -> ApiVersionPolicy {
+    #[cfg(not(test))]
+    let installed = INSTALLED.get().copied();
+    #[cfg(test)]
+    let installed = INSTALLED.with(|p| p.get());
+    installed.unwrap_or_default()
+}
+
+/// How a request path addresses the API.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Addressed<'a> {
+    /// Under `/api/v1`; carries the path after it.
+    Versioned(&'a str),
+    /// Under the unversioned `/api` alias; carries the path after it.
+    Alias(&'a str),
+    /// Not under `/api` at all.
+    Bare,
+}
+
+/// Classify `path`. The prefixes only match whole segments (`/apifoo` and
+/// `/api/v10` are not them), and a bare prefix leaves `/`.
+pub fn classify(path: &str) -> Addressed<'_> {
+    if let Some(rest) = under(V1_PREFIX, path) {
+        return Addressed::Versioned(rest);
+    }
+    match under(ALIAS_PREFIX, path) {
+        Some(rest) => Addressed::Alias(rest),
+        None => Addressed::Bare,
+    }
+}
+
+fn under<'a>(prefix: &str, path: &'a str) -> Option<&'a str> {
+    match path.strip_prefix(prefix)? {
+        "" => Some("/"),
+        rest if rest.starts_with('/') => Some(rest),
+        _ => None,
+    }
+}
+
+/// `path` with a `/api/v1` prefix rewritten to the unversioned `/api`, for
+/// the few paths the pipeline matches before it strips the prefix.
+pub fn unversioned(path: &str) -> std::borrow::Cow<'_, str> {
+    match under(V1_PREFIX, path) {
+        Some(rest) => format!("{ALIAS_PREFIX}{rest}").into(),
+        None => path.into(),
+    }
+}
+
+/// The headers marking a response served through the alias as deprecated,
+/// with `rest` (the path after `/api`) as the successor's path.
+pub fn alias_headers(msg: &Message, rest: &str) -> Vec<MetaEntry> {
+    let successor = crate::base_path::url(msg, &format!("{V1_PREFIX}{rest}"));
+    [
+        ("Deprecation", DEPRECATION.to_string()),
+        ("Sunset", SUNSET.to_string()),
+        ("Link", format!("<{successor}>; rel=\"successor-version\"")),
+    ]
+    .into_iter()
+    .map(|(name, value)| MetaEntry {
+        key: format!("resp.header.{name}"),
+        value,
+    })
+    .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn prefixes_match_whole_segments() {
+        assert_eq!(
+            classify("/api/v1/b/auth/api/me"),
+            Addressed::Versioned("/b/auth/api/me")
+        );
+        assert_eq!(classify("/api/v1"), Addressed::Versioned("/"));
+        assert_eq!(classify("/api/v10/x"), Addressed::Alias("/v10/x"));
+        assert_eq!(classify("/api/version"), Addressed::Alias("/version"));
+        assert_eq!(classify("/api"), Addressed::Alias("/"));
+        assert_eq!(classify("/apifoo"), Addressed::Bare);
+        assert_eq!(classify("/b/auth/login"), Addressed::Bare);
+
+        assert_eq!(unversioned("/api/v1/events/schema"), "/api/events/schema");
+        assert_eq!(unversioned("/api/events/schema"), "/api/events/schema");
+    }
+
+    #[test]
+    fn policy_parses_and_first_install_wins() {
+        assert_eq!(ApiVersionPolicy::parse(""), Ok(ApiVersionPolicy::Alias));
+        assert_eq!(
+            ApiVersionPolicy::parse(" Versioned-Only "),
+            Ok(ApiVersionPolicy::VersionedOnly)
+        );
+        assert!(ApiVersionPolicy::parse("v1").is_err());
+
+        assert_eq!(policy(), ApiVersionPolicy::Alias);
+        install(ApiVersionPolicy::VersionedOnly);
+        install(ApiVersionPolicy::Alias);
+        assert_eq!(policy(), ApiVersionPolicy::VersionedOnly);
+    }
+
+    #[test]
+    fn alias_headers_point_at_the_versioned_path_under_the_base() {
+        let mut msg = crate::test_support::anon_msg("retrieve", "/api/b/auth/api/me");
+        msg.set_meta(crate::base_path::META, "/solobase");
+        let headers = alias_headers(&msg, "/b/auth/api/me");
+        let get = |name: &str| {
+            headers
+                .iter()
+                .find(|e| e.key == format!("resp.header.{name}"))
+                .map(|e| e.value.as_str())
+        };
+        assert_eq!(get("Deprecation"), Some(DEPRECATION));
+        assert_eq!(get("Sunset"), Some(SUNSET));
+        assert_eq!(
+            get("Link"),
+            Some("</solobase/api/v1/b/auth/api/me>; rel=\"successor-version\"")
+        );
+    }
+}
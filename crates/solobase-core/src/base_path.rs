@@ -0,0 +1,351 @@
+//! Serving Solobase under a path prefix.
+//!
+//! A host app that mounts Solobase at, say, `/solobase/` sets
+//! [`crate::SolobaseBuilder::base_path`] (native: `SOLOBASE_BASE_PATH`).
+//! Every path Solobase hands out is root-relative (`/b/auth/login`,
+//! `/b/storage/direct/{token}`), so behind the prefix they would all miss;
+//! this module puts the prefix back on the way out:
+//!
+//! - The router block stamps the base on each request as [`META`].
+//!   [`crate::pipeline::handle_request`] strips it from the path before
+//!   routing, so blocks see the same paths they do at the root. A host
+//!   that strips the prefix itself sends paths without it, which pass
+//!   through unchanged — mounting either way never double-prefixes.
+//! - [`rewrite_response`] prefixes a root-relative `Location` and the
+//!   URL attributes of HTML pages and fragments, and injects a script
+//!   that prefixes the pages' own `fetch` and XHR calls. JSON bodies are
+//!   left alone: handlers that return a URL build it with [`url`].
+//! - Code with no request at hand (emails, jobs) reads the base
+//!   [`install`]ed at build time; [`public_url`] adds it to an origin.
+//!
+//! The user site served by `wafer-run/web` stays at the root.
+
+use std::sync::OnceLock;
+
+use wafer_block::http_codec::{self, ResponseMetaPart};
+use wafer_run::{Message, MetaEntry};
+
+/// Request meta carrying the normalized base path; unset at the root.
+pub const META: &str = "req.base_path";
+
+/// HTML attributes whose root-relative values [`rewrite_response`]
+/// prefixes.
+const URL_ATTRIBUTES: &[&str] = &[
+    "href",
+    "src",
+    "action",
+    "formaction",
+    "poster",
+    "data-href",
+    "hx-get",
+    "hx-post",
+    "hx-put",
+    "hx-patch",
+    "hx-delete",
+    "hx-push-url",
+];
+
+static INSTALLED: OnceLock<String> = OnceLock::new();
+
+/// Canonical form of a configured base: `""` for the root, otherwise a
+/// leading `/`, no trailing `/`, and only unreserved URL characters in
+/// each segment (the value is spliced into HTML and a script unescaped).
+pub fn normalize(raw: &str) -> Result<String, String> {
+    let trimmed = raw.trim().trim_end_matches('/');
+    if trimmed.is_empty() {
+        return Ok(String::new());
+    }
+    let Some(rest) = trimmed.strip_prefix('/') else {
+        return Err(format!("base path {raw:?} must start with '/'"));
+    };
+    let valid = rest.split('/').all(|segment| {
+        !segment.is_empty()
+            && segment != "."
+            && segment != ".."
+            && segment
+                .chars()
+                .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '.' | '_' | '~'))
+    });
+    if !valid {
+        return Err(format!(
+            "base path {raw:?} may only hold letters, digits and - . _ ~ between slashes"
+        ));
+    }
+    Ok(trimmed.to_string())
+}
+
+/// Record the deployment's (normalized) base for code that has no request
+/// to read [`META`] from. Only the first call wins.
+pub fn install(base: &str) {
+    let _ = INSTALLED.set(base.to_string());
+}
+
+/// The [`install`]ed base, `""` when none was.
+pub fn installed() -> &'static str {
+    INSTALLED.get().map_or("", String::as_str)
+}
+
+/// `origin` (e.g. `SOLOBASE_SHARED__FRONTEND_URL`) with the installed base
+/// appended, for absolute links in emails and redirects. An origin that
+/// already ends with the base is returned as is.
+pub fn public_url(origin: &str) -> String {
+    let origin = origin.trim_end_matches('/');
+    let base = installed();
+    if origin.ends_with(base) {
+        origin.to_string()
+    } else {
+        format!("{origin}{base}")
+    }
+}
+
+/// `path` with `base` taken off: `Some("/")` for the base itself, `None`
+/// when `path` isn't under it (or there is no base).
+pub fn strip<'a>(base: &str, path: &'a str) -> Option<&'a str> {
+    if base.is_empty() {
+        return None;
+    }
+    match path.strip_prefix(base)? {
+        "" => Some("/"),
+        rest if rest.starts_with('/') => Some(rest),
+        _ => None,
+    }
+}
+
+/// The root-relative `path` as the client must request it: with the
+/// request's base in front.
+pub fn url(msg: &Message, path: &str) -> String {
+    format!("{}{path}", msg.get_meta(META))
+}
+
+/// Whether `value` is a root-relative URL that still lacks `base`.
+fn needs_base(base: &str, value: &str) -> bool {
+    value.starts_with('/')
+        && !value.starts_with("//")
+        && !value
+            .strip_prefix(base)
+            .is_some_and(|rest| rest.is_empty() || rest.starts_with(['/', '?', '#', '"']))
+}
+
+/// Prefix a buffered response for a deployment under `base` (see the
+/// module docs). No-op without a base.
+pub fn rewrite_response(base: &str, body: &mut Vec<u8>, meta: &mut [MetaEntry]) {
+    if base.is_empty() {
+        return;
+    }
+    for entry in meta.iter_mut() {
+        let is_location = entry
+            .key
+            .strip_prefix("resp.header.")
+            .is_some_and(|h| h.eq_ignore_ascii_case("location"));
+        if is_location && needs_base(base, &entry.value) {
+            entry.value.insert_str(0, base);
+        }
+    }
+    let is_html = http_codec::response_meta_parts(meta).any(|part| {
+        matches!(part, ResponseMetaPart::ContentType(ct) if ct.trim_start().starts_with("text/html"))
+    });
+    if !is_html {
+        return;
+    }
+    if let Ok(html) = std::str::from_utf8(body) {
+        *body = rewrite_html(base, html).into_bytes();
+    }
+}
+
+/// Prefix the root-relative values of [`URL_ATTRIBUTES`] and, in a full
+/// document, inject the client-side shim right after `<head>`.
+fn rewrite_html(base: &str, html: &str) -> String {
+    let mut out = String::with_capacity(html.len() + 512);
+    let mut rest = html;
+    while let Some(at) = rest.find("=\"/") {
+        let (before, value) = rest.split_at(at + 2);
+        out.push_str(before);
+        let name_start = before[..at]
+            .rfind(|c: char| !(c.is_ascii_alphanumeric() || c == '-'))
+            .map_or(0, |i| i + 1);
+        let name = &before[name_start..at];
+        if URL_ATTRIBUTES.iter().any(|a| a.eq_ignore_ascii_case(name)) && needs_base(base, value) {
+            out.push_str(base);
+        }
+        rest = value;
+    }
+    out.push_str(rest);
+
+    if let Some(at) = out.find("<head>") {
+        out.insert_str(at + "<head>".len(), &shim(base));
+    }
+    out
+}
+
+/// Script that exposes the base as `window.SOLOBASE_BASE_PATH` and
+/// prefixes root-relative URLs passed to `fetch` and
+/// `XMLHttpRequest.open`, which the page scripts call with `/b/...`
+/// paths. Scripts that navigate by assigning `location.href` call the same
+/// prefixer as `window.solobaseUrl` (falling back to `String` at the
+/// root). `base` is [`normalize`]d, so it needs no escaping.
+fn shim(base: &str) -> String {
+    format!(
+        "<script>(function(b){{window.SOLOBASE_BASE_PATH=b;\
+         function p(u){{return typeof u==\"string\"&&u[0]==\"/\"&&u[1]!=\"/\"\
+         &&u!=b&&u.indexOf(b+\"/\")!=0&&u.indexOf(b+\"?\")!=0?b+u:u}}\
+         window.solobaseUrl=p;\
+         var f=window.fetch;if(f)window.fetch=function(u,o){{return f.call(this,p(u),o)}};\
+         var x=XMLHttpRequest.prototype.open;\
+         XMLHttpRequest.prototype.open=function(m,u){{arguments[1]=p(u);\
+         return x.apply(this,arguments)}}}})(\"{base}\");</script>"
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn normalize_accepts_prefixes_and_rejects_unsafe_ones() {
+        assert_eq!(normalize("").unwrap(), "");
+        assert_eq!(normalize("/").unwrap(), "");
+        assert_eq!(normalize(" /solobase/ ").unwrap(), "/solobase");
+        assert_eq!(normalize("/apps/solo-base_1").unwrap(), "/apps/solo-base_1");
+        for bad in ["solobase", "/a//b", "/a/../b", "/a b", "/a\"b", "/<x>"] {
+            assert!(normalize(bad).is_err(), "{bad:?} accepted");
+        }
+    }
+
+    #[test]
+    fn strip_only_whole_segments() {
+        assert_eq!(strip("/solobase", "/solobase"), Some("/"));
+        assert_eq!(
+            strip("/solobase", "/solobase/b/auth/login"),
+            Some("/b/auth/login")
+        );
+        assert_eq!(strip("/solobase", "/solobase2/b"), None);
+        assert_eq!(strip("/solobase", "/b/auth/login"), None);
+        assert_eq!(strip("", "/b/auth/login"), None);
+    }
+
+    fn response(content_type: &str, location: Option<&str>) -> Vec<MetaEntry> {
+        let mut meta = vec![MetaEntry {
+            key: wafer_run::META_RESP_CONTENT_TYPE.to_string(),
+            value: content_type.to_string(),
+        }];
+        if let Some(location) = location {
+            meta.push(MetaEntry {
+                key: "resp.header.Location".to_string(),
+                value: location.to_string(),
+            });
+        }
+        meta
+    }
+
+    #[test]
+    fn rewrites_locations_and_html_attributes_once() {
+        let mut meta = response("text/plain", Some("/b/auth/login"));
+        let mut body = Vec::new();
+        rewrite_response("/solobase", &mut body, &mut meta);
+        assert_eq!(meta[1].value, "/solobase/b/auth/login");
+        // Absolute and already-prefixed targets are left alone.
+        for location in [
+            "https://idp.example/authorize",
+            "/solobase/b/admin/",
+            "/solobase",
+        ] {
+            let mut meta = response("text/plain", Some(location));
+            rewrite_response("/solobase", &mut body, &mut meta);
+            assert_eq!(meta[1].value, location);
+        }
+
+        let html = concat!(
+            r#"<html><head><link href="/b/static/app.css"></head><body>"#,
+            r#"<a href="/b/admin/">a</a><form action="/b/auth/api/login" hx-post="/b/x">"#,
+            r#"<img src="//cdn.example/i.png"><a href="https://x.example/">x</a>"#,
+            r#"<a href="/solobase/b/done">d</a><input value="/b/not-a-url"></form></body></html>"#,
+        );
+        let mut body = html.as_bytes().to_vec();
+        let mut meta = response("text/html; charset=utf-8", None);
+        rewrite_response("/solobase", &mut body, &mut meta);
+        let out = String::from_utf8(body).unwrap();
+        assert!(
+            out.contains(r#"href="/solobase/b/static/app.css""#),
+            "{out}"
+        );
+        assert!(out.contains(r#"href="/solobase/b/admin/""#));
+        assert!(out.contains(r#"action="/solobase/b/auth/api/login""#));
+        assert!(out.contains(r#"hx-post="/solobase/b/x""#));
+        assert!(out.contains(r#"src="//cdn.example/i.png""#));
+        assert!(out.contains(r#"href="/solobase/b/done""#));
+        assert!(out.contains(r#"value="/b/not-a-url""#));
+        assert!(out.contains(r#"<head><script>(function(b){window.SOLOBASE_BASE_PATH=b;"#));
+        assert!(out.contains(r#"})("/solobase");</script><link"#));
+    }
+
+    #[test]
+    fn json_and_root_deployments_are_untouched() {
+        let json = br#"{"url":"/b/storage/direct/t"}"#.to_vec();
+        let mut body = json.clone();
+        let mut meta = response("application/json", None);
+        rewrite_response("/solobase", &mut body, &mut meta);
+        assert_eq!(body, json);
+
+        let html = br#"<head></head><a href="/b/admin/">a</a>"#.to_vec();
+        let mut body = html.clone();
+        let mut meta = response("text/html", Some("/b/admin/"));
+        rewrite_response("", &mut body, &mut meta);
+        assert_eq!(body, html);
+        assert_eq!(meta[1].value, "/b/admin/");
+    }
+
+    /// End-to-end through the pipeline: requests arrive with the prefix (or
+    /// without it, from a host that strips it) and everything the browser
+    /// follows comes back with it.
+    #[tokio::test]
+    async fn app_mounted_under_a_prefix_keeps_it_on_the_way_out() {
+        use std::sync::Arc;
+
+        use crate::{
+            blocks::{auth_ui::AuthUiBlock, system::SystemBlock},
+            test_support::{TestApp, TestRequest},
+        };
+
+        let mut app = TestApp::new().await;
+        app.load_block("suppers-ai/system", Arc::new(SystemBlock::new()), &[], &[])
+            .await
+            .unwrap();
+        app.load_block(
+            crate::blocks::auth_ui::AUTH_UI_BLOCK_ID,
+            Arc::new(AuthUiBlock::new()),
+            &[],
+            &[],
+        )
+        .await
+        .unwrap();
+        app.mount_at("/solobase/");
+
+        for root in ["/solobase", "/solobase/", "/"] {
+            let res = app.request(TestRequest::get(root)).await;
+            assert_eq!(res.status, 302, "{root}");
+            assert_eq!(
+                res.header("Location"),
+                Some("/solobase/b/auth/login"),
+                "{root}"
+            );
+        }
+
+        for login in ["/solobase/b/auth/login", "/b/auth/login"] {
+            let res = app.request(TestRequest::get(login)).await;
+            assert_eq!(res.status, 200, "{login}: {}", res.text());
+            let html = res.text();
+            assert!(html.contains(r#"window.SOLOBASE_BASE_PATH=b;"#), "{html}");
+            let css = crate::ui::assets::css_url();
+            assert!(
+                html.contains(&format!(r#"href="/solobase{css}""#)),
+                "{html}"
+            );
+            assert!(!html.contains(&format!(r#"href="{css}""#)));
+        }
+
+        let asset = format!("/solobase{}", crate::ui::assets::css_url());
+        let res = app.request(TestRequest::get(&asset)).await;
+        assert_eq!(res.status, 200, "{asset}");
+        assert!(res.text().contains("--"), "stylesheet body");
+    }
+}
@@ -0,0 +1,167 @@
+//! Per-isolate request metrics for each routed block.
+//!
+//! The pipeline calls [`record`] once per request that [`crate::routing`]
+//! sent to a block — including requests the router itself answered for that
+//! block (access denied, feature off, schema 503) — with the final status
+//! and handler time. 5xx answers, which include handler panics and
+//! timeouts, count as errors. The admin extensions API and the blocks page
+//! read [`get`] / [`snapshot`].
+//!
+//! Counters are atomics and latency is a ring of the last
+//! [`LATENCY_SAMPLES`] requests, so p50/p95 describe recent traffic rather
+//! than the whole uptime. Like [`crate::schema_status`], this is process
+//! memory: a restart (or a fresh Worker isolate) starts from zero.
+
+use std::{
+    collections::BTreeMap,
+    sync::{
+        atomic::{AtomicU64, Ordering},
+        Arc, Mutex, MutexGuard,
+    },
+};
+
+/// Requests kept for the latency percentiles, per block.
+pub const LATENCY_SAMPLES: usize = 256;
+
+#[derive(Default)]
+struct Counters {
+    requests: AtomicU64,
+    errors: AtomicU64,
+    latency: Mutex<Ring>,
+}
+
+#[derive(Default)]
+struct Ring {
+    samples: Vec<u64>,
+    next: usize,
+}
+
+impl Ring {
+    fn push(&mut self, ms: u64) {
+        if self.samples.len() < LATENCY_SAMPLES {
+            self.samples.push(ms);
+        } else {
+            self.samples[self.next] = ms;
+        }
+        self.next = (self.next + 1) % LATENCY_SAMPLES;
+    }
+}
+
+static REGISTRY: Mutex<BTreeMap<String, Arc<Counters>>> = Mutex::new(BTreeMap::new());
+
+fn lock<T>(m: &Mutex<T>) -> MutexGuard<'_, T> {
+    // Every mutation is a single insert or store, so a panic while holding
+    // the lock can't leave the data half-written.
+    m.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+fn counters(block: &str) -> Arc<Counters> {
+    let mut registry = lock(&REGISTRY);
+    if let Some(c) = registry.get(block) {
+        return c.clone();
+    }
+    registry.entry(block.to_string()).or_default().clone()
+}
+
+/// One block's request metrics as reported to admins.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct BlockMetrics {
+    pub block: String,
+    pub requests: u64,
+    /// Requests answered with a 5xx status.
+    pub errors: u64,
+    /// Median handler time over the recent sample, in milliseconds.
+    pub p50_ms: Option<u64>,
+    pub p95_ms: Option<u64>,
+}
+
+/// Record one completed request routed to `block`.
+pub fn record(block: &str, status_code: i64, duration_ms: u64) {
+    let c = counters(block);
+    // `requests` first: a reader that sees this error also sees its request
+    // (see `read`), so a snapshot never reports more errors than requests.
+    c.requests.fetch_add(1, Ordering::Release);
+    if status_code >= 500 {
+        c.errors.fetch_add(1, Ordering::Release);
+    }
+    lock(&c.latency).push(duration_ms);
+}
+
+/// Nearest-rank percentile of an ascending slice.
+fn percentile(sorted: &[u64], pct: usize) -> Option<u64> {
+    let rank = (sorted.len() * pct).div_ceil(100);
+    sorted.get(rank.saturating_sub(1)).copied()
+}
+
+fn read(block: &str, c: &Counters) -> BlockMetrics {
+    let errors = c.errors.load(Ordering::Acquire);
+    let requests = c.requests.load(Ordering::Acquire);
+    let mut samples = lock(&c.latency).samples.clone();
+    samples.sort_unstable();
+    BlockMetrics {
+        block: block.to_string(),
+        requests,
+        errors,
+        p50_ms: percentile(&samples, 50),
+        p95_ms: percentile(&samples, 95),
+    }
+}
+
+/// `block`'s metrics; `None` before its first routed request.
+pub fn get(block: &str) -> Option<BlockMetrics> {
+    let c = lock(&REGISTRY).get(block)?.clone();
+    Some(read(block, &c))
+}
+
+/// Every block that has served a request, sorted by name.
+pub fn snapshot() -> Vec<BlockMetrics> {
+    let blocks: Vec<(String, Arc<Counters>)> = lock(&REGISTRY)
+        .iter()
+        .map(|(name, c)| (name.clone(), c.clone()))
+        .collect();
+    blocks.iter().map(|(name, c)| read(name, c)).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn counts_requests_and_server_errors() {
+        let block = "test/metrics-counts";
+        assert_eq!(get(block), None);
+        record(block, 200, 5);
+        record(block, 404, 5);
+        record(block, 500, 5);
+        record(block, 504, 5);
+        let m = get(block).unwrap();
+        assert_eq!((m.requests, m.errors), (4, 2));
+        assert!(snapshot().iter().any(|s| s.block == block));
+    }
+
+    #[test]
+    fn percentiles_cover_recent_samples_only() {
+        let block = "test/metrics-latency";
+        for ms in 1..=100 {
+            record(block, 200, ms);
+        }
+        let m = get(block).unwrap();
+        assert_eq!((m.p50_ms, m.p95_ms), (Some(50), Some(95)));
+
+        // A full ring of slow requests pushes the fast ones out.
+        for _ in 0..LATENCY_SAMPLES {
+            record(block, 200, 1000);
+        }
+        let m = get(block).unwrap();
+        assert_eq!((m.p50_ms, m.p95_ms), (Some(1000), Some(1000)));
+        assert_eq!(m.requests, 100 + LATENCY_SAMPLES as u64);
+    }
+
+    #[test]
+    fn percentile_uses_nearest_rank() {
+        assert_eq!(percentile(&[], 50), None);
+        assert_eq!(percentile(&[7], 95), Some(7));
+        assert_eq!(percentile(&[1, 2, 3, 4], 50), Some(2));
+        assert_eq!(percentile(&[1, 2, 3, 4], 95), Some(4));
+    }
+}
@@ -0,0 +1,217 @@
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::logs::audit_log;
+use crate::{
+    blocks::{
+        announcements::{self, Announcement, AnnouncementInput},
+        errors,
+    },
+    etag,
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+};
+
+/// Announcement banners (see [`crate::blocks::announcements`]).
+pub(crate) const ANNOUNCEMENTS_TABLE: &str = "suppers_ai__admin__announcements";
+
+/// One row per (user, dismissed announcement).
+pub(crate) const ANNOUNCEMENT_DISMISSALS_TABLE: &str = "suppers_ai__admin__announcement_dismissals";
+
+/// `path` is the normalized `/admin/announcements...` sub-path, passed
+/// explicitly (no `req.resource` rewrite).
+///
+/// - `GET /admin/announcements` — every announcement with its status.
+/// - `POST /admin/announcements` — create.
+/// - `PUT|PATCH /admin/announcements/{id}` — replace content and window.
+/// - `DELETE /admin/announcements/{id}` — delete, with its dismissals.
+pub async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    path: &str,
+    input: InputStream,
+) -> OutputStream {
+    let id = path
+        .strip_prefix("/admin/announcements/")
+        .filter(|id| !id.is_empty() && !id.contains('/'));
+
+    match (msg.action(), path, id) {
+        ("retrieve", "/admin/announcements", _) => handle_list(ctx, msg).await,
+        ("create", "/admin/announcements", _) => handle_create(ctx, msg, input).await,
+        ("update", _, Some(id)) => handle_update(ctx, msg, id, input).await,
+        ("delete", _, Some(id)) => handle_delete(ctx, msg, id).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+fn with_status(a: &Announcement, now: chrono::DateTime<chrono::Utc>) -> serde_json::Value {
+    let mut v = serde_json::json!(a);
+    v["status"] = serde_json::json!(a.status(now));
+    v
+}
+
+/// Tagged by content: a status can change with no write.
+async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let now = chrono::Utc::now();
+    match announcements::list(ctx).await {
+        Ok(all) => {
+            let items: Vec<serde_json::Value> = all.iter().map(|a| with_status(a, now)).collect();
+            etag::ok_json(msg, &serde_json::json!({ "announcements": items }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// Parse and validate an `AnnouncementInput` body, or build the error
+/// response.
+async fn read_input(input: InputStream) -> Result<announcements::ValidAnnouncement, OutputStream> {
+    let raw = input.collect_to_bytes().await;
+    let body: AnnouncementInput =
+        serde_json::from_slice(&raw).map_err(|e| err_bad_request(&format!("Invalid body: {e}")))?;
+    body.validate(chrono::Utc::now())
+        .map_err(|fields| errors::validation_error("Invalid announcement", &fields))
+}
+
+async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let valid = match read_input(input).await {
+        Ok(v) => v,
+        Err(resp) => return resp,
+    };
+    match announcements::create(ctx, &valid, msg.user_id()).await {
+        Ok(created) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "announcements.create",
+                &format!("announcement:{}", created.id),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&with_status(&created, chrono::Utc::now()))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_update(
+    ctx: &dyn Context,
+    msg: &Message,
+    id: &str,
+    input: InputStream,
+) -> OutputStream {
+    let valid = match read_input(input).await {
+        Ok(v) => v,
+        Err(resp) => return resp,
+    };
+    match announcements::update(ctx, id, &valid).await {
+        Ok(Some(updated)) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "announcements.update",
+                &format!("announcement:{id}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&with_status(&updated, chrono::Utc::now()))
+        }
+        Ok(None) => err_not_found("Announcement not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_delete(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    match announcements::delete(ctx, id).await {
+        Ok(true) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "announcements.delete",
+                &format!("announcement:{id}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&serde_json::json!({ "deleted": true }))
+        }
+        Ok(false) => err_not_found("Announcement not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{
+        admin_msg, output_is_error, output_json, output_status, TestContext,
+    };
+
+    fn body(v: serde_json::Value) -> InputStream {
+        InputStream::from_bytes(serde_json::to_vec(&v).unwrap())
+    }
+
+    #[tokio::test]
+    async fn create_list_update_delete_round_trip() {
+        let ctx = TestContext::with_auth().await;
+        let msg = admin_msg("create", "/b/admin/api/announcements");
+        let created = output_json(
+            handle(
+                &ctx,
+                &msg,
+                "/admin/announcements",
+                body(serde_json::json!({
+                    "message": "Scheduled maintenance",
+                    "severity": "warning",
+                    "target_roles": ["admin"],
+                })),
+            )
+            .await,
+        )
+        .await;
+        assert_eq!(created["severity"], "warning");
+        assert_eq!(created["status"], "active");
+        assert_eq!(created["created_by"], "admin_1");
+        let id = created["id"].as_str().unwrap().to_string();
+
+        let path = format!("/admin/announcements/{id}");
+        let msg = admin_msg("update", &format!("/b/admin/api/announcements/{id}"));
+        let updated = output_json(
+            handle(
+                &ctx,
+                &msg,
+                &path,
+                body(serde_json::json!({
+                    "message": "Maintenance moved",
+                    "starts_at": "2099-01-01T00:00:00Z",
+                })),
+            )
+            .await,
+        )
+        .await;
+        assert_eq!(updated["message"], "Maintenance moved");
+        assert_eq!(updated["status"], "scheduled");
+
+        let msg = admin_msg("retrieve", "/b/admin/api/announcements");
+        let listed =
+            output_json(handle(&ctx, &msg, "/admin/announcements", InputStream::empty()).await)
+                .await;
+        assert_eq!(listed["announcements"].as_array().unwrap().len(), 1);
+
+        let msg = admin_msg("delete", &format!("/b/admin/api/announcements/{id}"));
+        let out = handle(&ctx, &msg, &path, InputStream::empty()).await;
+        assert_eq!(output_status(out).await, 200);
+        let out = handle(&ctx, &msg, &path, InputStream::empty()).await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+
+    #[tokio::test]
+    async fn invalid_body_is_a_validation_error() {
+        let ctx = TestContext::with_auth().await;
+        let msg = admin_msg("create", "/b/admin/api/announcements");
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/announcements",
+            body(serde_json::json!({ "message": "", "severity": "info" })),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 400);
+    }
+}
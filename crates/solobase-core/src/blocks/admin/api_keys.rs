@@ -0,0 +1,179 @@
+//! Every user's API keys and S3 credentials, for oversight. Keys are shown
+//! with their restrictions and last use but never their hash or secret;
+//! admins can revoke any of them, effective on the key's next request.
+
+use wafer_block::db::{Filter, FilterOp};
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::{logs::audit_log, service_accounts::key_view};
+use crate::{
+    blocks::auth::repo::api_keys,
+    http::{err_internal, err_not_found, ok_json},
+    pagination::{self, ListSpec},
+    util::json_map,
+};
+
+/// `path` is the normalized `/admin/api-keys...` sub-path.
+///
+/// - `GET /admin/api-keys` — paged per [`crate::pagination`]; `?user_id=`
+///   narrows to one owner.
+/// - `POST /admin/api-keys/{id}/revoke` — revoke any user's key.
+pub async fn handle(ctx: &dyn Context, msg: &Message, path: &str) -> OutputStream {
+    let rest = path.strip_prefix("/admin/api-keys").unwrap_or("");
+    match (msg.action(), rest.trim_matches('/').split_once('/')) {
+        ("retrieve", None) if rest.trim_matches('/').is_empty() => handle_list(ctx, msg).await,
+        ("create", Some((id, "revoke"))) if !id.is_empty() => handle_revoke(ctx, msg, id).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+/// Sort and page-size limits for `GET /admin/api-keys` (see
+/// [`crate::pagination`]).
+const LIST_SPEC: ListSpec = ListSpec {
+    default_limit: 20,
+    max_limit: 100,
+    sortable: &["created_at"],
+    default_sort: "created_at",
+    default_desc: true,
+};
+
+async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let query = match pagination::parse(msg, &LIST_SPEC) {
+        Ok(q) => q,
+        Err(e) => return e.response(),
+    };
+    let mut filters = Vec::new();
+    let owner = msg.query("user_id");
+    if !owner.is_empty() {
+        filters.push(Filter {
+            field: "user_id".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::json!(owner),
+        });
+    }
+    match pagination::fetch(ctx, api_keys::TABLE, filters, &query).await {
+        Ok(mut page) => {
+            for record in page.records_mut().iter_mut() {
+                // A row that doesn't parse still must not leak its hash.
+                let Ok(row) = api_keys::row_from_map(&record.data) else {
+                    record.data.remove("key_hash");
+                    record.data.remove("secret");
+                    continue;
+                };
+                let mut view = key_view(&row);
+                view["user_id"] = serde_json::json!(row.user_id);
+                view["kind"] = serde_json::json!(row.kind);
+                record.data = json_map(view);
+            }
+            ok_json(&page.into_json(query.fields.as_deref()))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_revoke(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    let key = match api_keys::find_by_id(ctx, id).await {
+        Ok(Some(key)) => key,
+        Ok(None) => return err_not_found("API key not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    if !key.is_revoked() {
+        if let Err(e) = api_keys::revoke(ctx, id).await {
+            return err_internal("Database error", e);
+        }
+        audit_log(
+            ctx,
+            msg.user_id(),
+            "api_key.revoke",
+            &format!("api_keys/{id} (owner: {})", key.user_id),
+            msg.remote_addr(),
+        )
+        .await;
+    }
+    ok_json(&serde_json::json!({"message": "API key revoked"}))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        blocks::auth::repo::users,
+        test_support::{admin_msg, output_json, output_status, TestContext},
+        util::RecordExt,
+    };
+
+    /// A user named `name` with one `storage.read` key of the same name.
+    async fn seed(ctx: &TestContext, name: &str) -> api_keys::ApiKeyRow {
+        let user = users::insert(
+            ctx,
+            users::NewUser {
+                email: format!("{name}@e.co"),
+                display_name: name.into(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap();
+        let scopes = vec!["storage.read".to_string()];
+        api_keys::insert(
+            ctx,
+            api_keys::NewApiKey {
+                user_id: &user.id,
+                name,
+                key_hash: &format!("hash-{name}"),
+                key_prefix: "sb_abcd",
+                scopes: &scopes,
+                ..Default::default()
+            },
+        )
+        .await
+        .unwrap()
+    }
+
+    #[tokio::test]
+    async fn list_shows_every_owner_without_hashes() {
+        let ctx = TestContext::with_auth().await;
+        seed(&ctx, "ci").await;
+        let backup = seed(&ctx, "backup").await;
+
+        let msg = admin_msg("retrieve", "/b/admin/api/api-keys");
+        let list = output_json(handle(&ctx, &msg, "/admin/api-keys").await).await;
+        let records = list["records"].as_array().unwrap();
+        assert_eq!(records.len(), 2);
+        for record in records {
+            assert!(record["data"].get("key_hash").is_none());
+            assert_eq!(
+                record["data"]["scopes"],
+                serde_json::json!(["storage.read"])
+            );
+        }
+
+        let mut msg = admin_msg("retrieve", "/b/admin/api/api-keys");
+        msg.set_meta("req.query.user_id", &backup.user_id);
+        let list = output_json(handle(&ctx, &msg, "/admin/api-keys").await).await;
+        let records = list["records"].as_array().unwrap();
+        assert_eq!(records.len(), 1);
+        assert_eq!(records[0]["data"]["name"], "backup");
+    }
+
+    #[tokio::test]
+    async fn revoke_takes_any_users_key_and_is_audited() {
+        let ctx = TestContext::with_auth().await;
+        let key = seed(&ctx, "ci").await;
+
+        let path = format!("/admin/api-keys/{}/revoke", key.id);
+        let msg = admin_msg("create", &format!("/b/admin/api{}", &path[6..]));
+        assert_eq!(output_status(handle(&ctx, &msg, &path).await).await, 200);
+
+        let stored = api_keys::find_by_id(&ctx, &key.id).await.unwrap().unwrap();
+        assert!(stored.is_revoked());
+        let audits =
+            wafer_core::clients::database::list_all(&ctx, super::super::AUDIT_LOGS_TABLE, vec![])
+                .await
+                .unwrap();
+        assert!(audits
+            .iter()
+            .any(|r| r.str_field("action") == "api_key.revoke"));
+    }
+}
@@ -133,6 +133,22 @@ pub async fn handle(
         {
             handle_columns(ctx, path).await
         }
+        ("retrieve", _)
+            if path.starts_with("/admin/database/tables/") && path.ends_with("/export") =>
+        {
+            match table_from_path(ctx, path, "/export").await {
+                Ok(table) => super::table_io::handle_export(ctx, msg, table).await,
+                Err(resp) => resp,
+            }
+        }
+        ("create", _)
+            if path.starts_with("/admin/database/tables/") && path.ends_with("/import") =>
+        {
+            match table_from_path(ctx, path, "/import").await {
+                Ok(table) => super::table_io::handle_import(ctx, msg, table, input).await,
+                Err(resp) => resp,
+            }
+        }
         ("create", "/admin/database/query") => handle_query(ctx, input).await,
         _ => err_not_found("not found"),
     }
@@ -181,6 +197,27 @@ async fn handle_tables(ctx: &dyn Context) -> OutputStream {
     ok_json(&serde_json::json!(table_info))
 }
 
+/// Extract and validate the table name from
+/// `/admin/database/tables/{name}{suffix}`. The name is user input from the
+/// URL path, so a missing or unquotable identifier is a 400.
+async fn table_from_path<'a>(
+    ctx: &dyn Context,
+    path: &'a str,
+    suffix: &str,
+) -> Result<&'a str, OutputStream> {
+    let table_name = path
+        .strip_prefix("/admin/database/tables/")
+        .and_then(|s| s.strip_suffix(suffix))
+        .unwrap_or("");
+    if table_name.is_empty() {
+        return Err(err_bad_request("Missing table name"));
+    }
+    if introspect::build_table_info(table_name, crate::db_backend(ctx).await).is_err() {
+        return Err(err_bad_request("Invalid table name"));
+    }
+    Ok(table_name)
+}
+
 async fn handle_columns(ctx: &dyn Context, path: &str) -> OutputStream {
     // Extract table name from /admin/database/tables/{name}/columns
     let table_name = path
@@ -0,0 +1,38 @@
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use crate::http::err_not_found;
+
+/// `path` is the normalized `/admin/email` sub-path, passed explicitly (no
+/// `req.resource` rewrite). Both routes forward to the email block, which
+/// owns the outbox and delivery-log tables.
+///
+/// - `GET /admin/email/log?limit=` — recent delivery attempts.
+/// - `POST /admin/email/log/{id}/resend` — re-send a failed outbox message.
+pub async fn handle(ctx: &dyn Context, msg: &Message, path: &str) -> OutputStream {
+    match (msg.action(), path) {
+        ("retrieve", "/admin/email/log") => {
+            let limit = msg.query("limit").parse::<i64>().unwrap_or(50);
+            call_email(ctx, "email.log", serde_json::json!({ "limit": limit })).await
+        }
+        ("create", p) => match p
+            .strip_prefix("/admin/email/log/")
+            .and_then(|rest| rest.strip_suffix("/resend"))
+        {
+            Some(id) if !id.is_empty() && !id.contains('/') => {
+                call_email(ctx, "email.resend", serde_json::json!({ "id": id })).await
+            }
+            _ => err_not_found("not found"),
+        },
+        _ => err_not_found("not found"),
+    }
+}
+
+async fn call_email(ctx: &dyn Context, kind: &str, body: serde_json::Value) -> OutputStream {
+    let msg = Message {
+        kind: kind.to_string(),
+        meta: Vec::new(),
+    };
+    let bytes = serde_json::to_vec(&body).unwrap_or_default();
+    ctx.call_block("suppers-ai/email", msg, InputStream::from_bytes(bytes))
+        .await
+}
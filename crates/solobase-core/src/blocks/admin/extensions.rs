@@ -0,0 +1,496 @@
+use wafer_core::clients::database as db;
+use wafer_run::{context::Context, InputStream, Message, OutputStream, ResourceGrant};
+use wafer_sql_utils::introspect;
+
+use super::WRAP_GRANTS_TABLE;
+use crate::{
+    block_metrics,
+    blocks::errors::{self, ErrorCode},
+    config_ui, etag,
+    http::{err_not_found, ok_json},
+    schema_status, table_scope, transfers,
+    ui::settings_form,
+    util::RecordExt,
+};
+
+/// `path` is the normalized `/admin/extensions` sub-path, passed explicitly
+/// (no `req.resource` rewrite).
+///
+/// - `GET /admin/extensions` — registered blocks with their schema state,
+///   owned table prefix, the grants they hold on other blocks' resources,
+///   and their request metrics since boot (see [`block_metrics`]), with the
+///   busiest principals' concurrent transfers for blocks that count them
+///   (see [`transfers`]).
+/// - `GET /admin/extensions/schema` — per-block migration status plus the
+///   tables each block's migrations declare vs. the ones present.
+/// - `POST /admin/extensions/{block}/migrations/retry` — re-run a block's
+///   migrations (`{block}` uses the `--` for `/` encoding of the blocks
+///   page, e.g. `suppers-ai--files`).
+/// - `POST /admin/extensions/{block}/enable` and `.../disable` — toggle a
+///   block on the running server (see [`block_settings::apply`]).
+/// - `GET /admin/extensions/{block}/config-ui` — the block's settings form:
+///   sectioned fields with labels, widgets and current values, secrets
+///   masked (see [`config_ui`]). `PUT` (or `PATCH`) a JSON object of
+///   `key: value` strings to the same path to save; only the form's keys
+///   are written, and a masked or empty secret leaves the stored one alone.
+///
+/// [`block_settings::apply`]: super::settings::block_settings::apply
+pub async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    path: &str,
+    input: InputStream,
+) -> OutputStream {
+    match (msg.action(), path) {
+        ("retrieve", "/admin/extensions") => handle_list(ctx, msg).await,
+        ("retrieve", "/admin/extensions/schema") => handle_schema(ctx, msg).await,
+        (action, p) => {
+            let Some(rest) = p.strip_prefix("/admin/extensions/") else {
+                return err_not_found("not found");
+            };
+            let Some((encoded, op)) = rest.split_once('/') else {
+                return err_not_found("not found");
+            };
+            if encoded.is_empty() {
+                return err_not_found("not found");
+            }
+            let block = encoded.replace("--", "/");
+            match (action, op) {
+                ("create", "migrations/retry") => handle_retry(ctx, &block).await,
+                ("create", "enable") => handle_toggle(ctx, msg, &block, true).await,
+                ("create", "disable") => handle_toggle(ctx, msg, &block, false).await,
+                ("retrieve", "config-ui") => handle_config_ui(ctx, &block).await,
+                ("update", "config-ui") => handle_save_config(ctx, msg, &block, input).await,
+                _ => err_not_found("not found"),
+            }
+        }
+    }
+}
+
+async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let reports = schema_status::snapshot();
+    let registered = ctx.registered_blocks();
+    let dynamic = admin_grants(ctx).await;
+    let blocks: Vec<_> = registered
+        .iter()
+        .map(|b| {
+            let schema = reports.iter().find(|r| r.block == b.name);
+            let grants: Vec<_> = table_scope::received_grants(registered, &dynamic, &b.name)
+                .iter()
+                .map(grant_json)
+                .collect();
+            serde_json::json!({
+                "name": b.name,
+                "version": b.version,
+                "interface": b.interface,
+                "summary": b.summary,
+                "enabled": crate::features::is_enabled_now(ctx, &b.name)
+                    && schema.map_or(true, |r| r.error.is_none()),
+                "restart_required": crate::features::init_deferred(&b.name),
+                "schema": schema.map(|r| serde_json::json!({
+                    "status": r.status,
+                    "error": r.error,
+                })),
+                "table_prefix": table_scope::table_prefix(&b.name),
+                "grants": grants,
+                "metrics": metrics_json(&b.name),
+            })
+        })
+        .collect();
+    etag::ok_json(msg, &blocks)
+}
+
+async fn handle_schema(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let present = present_tables(ctx).await;
+    let blocks: Vec<_> = schema_status::snapshot()
+        .into_iter()
+        .map(|r| {
+            let missing: Vec<&String> = r
+                .expected_tables
+                .iter()
+                .filter(|t| !present.contains(*t))
+                .collect();
+            serde_json::json!({
+                "block": r.block,
+                "status": r.status,
+                "error": r.error,
+                "expected_tables": r.expected_tables,
+                "missing_tables": missing,
+            })
+        })
+        .collect();
+    etag::ok_json(msg, &serde_json::json!({ "blocks": blocks }))
+}
+
+async fn handle_retry(ctx: &dyn Context, block: &str) -> OutputStream {
+    if schema_status::snapshot().iter().all(|r| r.block != block) {
+        return err_not_found("block has no registered migrations");
+    }
+    match schema_status::retry(ctx, block).await {
+        Ok(()) => ok_json(&serde_json::json!({ "block": block, "status": "applied" })),
+        // Admin-only surface: the DDL error is what the operator needs to
+        // fix the schema, so it is returned rather than logged-and-hidden.
+        Err(e) => errors::error_json(
+            ErrorCode::SchemaNotInitialized,
+            "Migrations failed again",
+            Some(serde_json::json!({ "block": block, "error": e })),
+        ),
+    }
+}
+
+async fn handle_toggle(
+    ctx: &dyn Context,
+    msg: &Message,
+    block: &str,
+    enabled: bool,
+) -> OutputStream {
+    let Some(info) = ctx
+        .registered_blocks()
+        .iter()
+        .find(|b| b.name == block)
+        .cloned()
+    else {
+        return err_not_found("block not found");
+    };
+    if !info.can_disable {
+        return errors::error_json(
+            ErrorCode::Forbidden,
+            "This block is always enabled",
+            Some(serde_json::json!({ "block": block })),
+        );
+    }
+    let restart_required = match super::settings::block_settings::apply(ctx, block, enabled).await {
+        Ok(restart_required) => restart_required,
+        Err(e) => {
+            tracing::error!(block = %block, error = %e, "block toggle failed");
+            return errors::error_json(
+                ErrorCode::DatabaseError,
+                "Failed to save the block setting",
+                None,
+            );
+        }
+    };
+    let action = if enabled {
+        "block.enable"
+    } else {
+        "block.disable"
+    };
+    super::logs::audit_log(
+        ctx,
+        msg.user_id(),
+        action,
+        &format!("blocks/{block}"),
+        msg.remote_addr(),
+    )
+    .await;
+    ok_json(&serde_json::json!({
+        "block": block,
+        "enabled": enabled,
+        "restart_required": restart_required,
+    }))
+}
+
+fn registered_info(ctx: &dyn Context, block: &str) -> Option<wafer_run::BlockInfo> {
+    ctx.registered_blocks()
+        .iter()
+        .find(|b| b.name == block)
+        .cloned()
+}
+
+async fn handle_config_ui(ctx: &dyn Context, block: &str) -> OutputStream {
+    match registered_info(ctx, block) {
+        Some(info) => ok_json(&config_ui::describe(ctx, &info).await),
+        None => err_not_found("block not found"),
+    }
+}
+
+async fn handle_save_config(
+    ctx: &dyn Context,
+    msg: &Message,
+    block: &str,
+    input: InputStream,
+) -> OutputStream {
+    let Some(info) = registered_info(ctx, block) else {
+        return err_not_found("block not found");
+    };
+    super::logs::audit_log(
+        ctx,
+        msg.user_id(),
+        "block.configure",
+        &format!("blocks/{block}/config"),
+        msg.remote_addr(),
+    )
+    .await;
+    settings_form::save_settings(ctx, input, &config_ui::allowed(&info), block).await
+}
+
+/// Grants added by admins on the WRAP grants page. Rows the boot loader
+/// would drop (unknown resource type) are skipped here too.
+pub(super) async fn admin_grants(ctx: &dyn Context) -> Vec<ResourceGrant> {
+    db::list_all(ctx, WRAP_GRANTS_TABLE, vec![])
+        .await
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|r| {
+            let stored = r.data.get("resource_type").and_then(|v| v.as_str());
+            Some(ResourceGrant {
+                grantee: r.str_field("grantee").to_string(),
+                resource: r.str_field("resource").to_string(),
+                write: r.bool_field("write"),
+                resource_type: wafer_run::ResourceType::parse_stored(stored).ok()?,
+            })
+        })
+        .collect()
+}
+
+/// Principals listed under a block's `transfers`.
+const TOP_TRANSFERS: usize = 20;
+
+/// A block's request metrics; zeros before its first routed request.
+fn metrics_json(block: &str) -> serde_json::Value {
+    let m = block_metrics::get(block);
+    let mut transfers = transfers::snapshot(block);
+    transfers.truncate(TOP_TRANSFERS);
+    serde_json::json!({
+        "requests": m.as_ref().map_or(0, |m| m.requests),
+        "errors": m.as_ref().map_or(0, |m| m.errors),
+        "p50_ms": m.as_ref().and_then(|m| m.p50_ms),
+        "p95_ms": m.as_ref().and_then(|m| m.p95_ms),
+        "transfers": transfers,
+    })
+}
+
+fn grant_json(g: &ResourceGrant) -> serde_json::Value {
+    serde_json::json!({
+        "grantee": g.grantee,
+        "resource": g.resource,
+        "access": if g.write { "read_write" } else { "read" },
+        "type": g.resource_type.as_ref().map(ToString::to_string),
+    })
+}
+
+async fn present_tables(ctx: &dyn Context) -> Vec<String> {
+    let sql = introspect::build_list_tables(crate::db_backend(ctx).await);
+    db::query_raw(ctx, &sql, &[])
+        .await
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|r| r.data.get("name").and_then(|v| v.as_str()))
+        .map(str::to_string)
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{admin_msg, output_json, output_status, TestContext};
+
+    static MIGRATIONS: &[(&str, &str)] = &[(
+        "001",
+        "CREATE TABLE IF NOT EXISTS t_ext_retry (id TEXT PRIMARY KEY);",
+    )];
+
+    #[tokio::test]
+    async fn retry_clears_failure_and_schema_lists_tables() {
+        let ctx = TestContext::with_admin().await;
+        let block = "test/ext-retry";
+        schema_status::record(block, MIGRATIONS, &[], &Err("disk full".into()));
+
+        let msg = admin_msg("retrieve", "/b/admin/api/extensions/schema");
+        let body =
+            output_json(handle(&ctx, &msg, "/admin/extensions/schema", InputStream::empty()).await)
+                .await;
+        let entry = body["blocks"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|b| b["block"] == block)
+            .unwrap()
+            .clone();
+        assert_eq!(entry["status"], "failed");
+        assert_eq!(entry["error"], "disk full");
+        assert_eq!(entry["missing_tables"][0], "t_ext_retry");
+
+        let path = "/admin/extensions/test--ext-retry/migrations/retry";
+        let msg = admin_msg("create", path);
+        assert_eq!(
+            output_status(handle(&ctx, &msg, path, InputStream::empty()).await).await,
+            200
+        );
+        assert_eq!(schema_status::failure(block), None);
+    }
+
+    #[tokio::test]
+    async fn list_reports_block_metrics() {
+        let mut ctx = TestContext::with_admin().await;
+        ctx.register_mem_storage();
+        block_metrics::record("wafer-run/storage", 500, 12);
+
+        let msg = admin_msg("retrieve", "/b/admin/api/extensions");
+        let body =
+            output_json(handle(&ctx, &msg, "/admin/extensions", InputStream::empty()).await).await;
+        let storage = body
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|b| b["name"] == "wafer-run/storage")
+            .unwrap();
+        // Counters are process-wide; other tests may have added to them.
+        assert!(storage["metrics"]["errors"].as_u64().unwrap() >= 1);
+        assert!(storage["metrics"]["p95_ms"].is_u64());
+    }
+
+    struct Toggleable(&'static str, bool);
+
+    #[async_trait::async_trait]
+    impl wafer_run::Block for Toggleable {
+        fn info(&self) -> wafer_run::BlockInfo {
+            wafer_run::BlockInfo::new(self.0, "0.0.1", "http-handler@v1", "test")
+                .can_disable(self.1)
+        }
+        async fn handle(
+            &self,
+            _ctx: &dyn Context,
+            _msg: Message,
+            _input: wafer_run::InputStream,
+        ) -> OutputStream {
+            err_not_found("not found")
+        }
+        async fn lifecycle(
+            &self,
+            _ctx: &dyn Context,
+            _e: wafer_run::LifecycleEvent,
+        ) -> Result<(), wafer_run::WaferError> {
+            Ok(())
+        }
+    }
+
+    #[tokio::test]
+    async fn disable_and_enable_apply_live() {
+        let mut ctx = TestContext::with_admin().await;
+        let block = "test/ext-toggle";
+        ctx.register_block(block, std::sync::Arc::new(Toggleable(block, true)));
+        ctx.register_block(
+            "test/ext-core",
+            std::sync::Arc::new(Toggleable("test/ext-core", false)),
+        );
+
+        let path = "/admin/extensions/test--ext-toggle/disable";
+        let body =
+            output_json(handle(&ctx, &admin_msg("create", path), path, InputStream::empty()).await)
+                .await;
+        assert_eq!(body["enabled"], false);
+        assert_eq!(body["restart_required"], false);
+        assert!(!crate::features::is_enabled_now(&ctx, block));
+        assert!(!super::super::settings::block_settings::is_enabled(&ctx, block).await);
+
+        let list_msg = admin_msg("retrieve", "/b/admin/api/extensions");
+        let list =
+            output_json(handle(&ctx, &list_msg, "/admin/extensions", InputStream::empty()).await)
+                .await;
+        let entry = list
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|b| b["name"] == block)
+            .unwrap();
+        assert_eq!(entry["enabled"], false);
+
+        let path = "/admin/extensions/test--ext-toggle/enable";
+        let body =
+            output_json(handle(&ctx, &admin_msg("create", path), path, InputStream::empty()).await)
+                .await;
+        assert_eq!(body["enabled"], true);
+        assert!(crate::features::is_enabled_now(&ctx, block));
+
+        let path = "/admin/extensions/test--ext-core/disable";
+        assert_eq!(
+            output_status(
+                handle(&ctx, &admin_msg("create", path), path, InputStream::empty()).await
+            )
+            .await,
+            403
+        );
+        assert!(crate::features::is_enabled_now(&ctx, "test/ext-core"));
+
+        let path = "/admin/extensions/test--ext-missing/enable";
+        let out = handle(&ctx, &admin_msg("create", path), path, InputStream::empty()).await;
+        assert!(crate::test_support::output_is_error(out, "NotFound").await);
+    }
+
+    struct Configurable;
+
+    #[async_trait::async_trait]
+    impl wafer_run::Block for Configurable {
+        fn info(&self) -> wafer_run::BlockInfo {
+            use wafer_run::{ConfigVar, InputType};
+            wafer_run::BlockInfo::new("test/ext-config", "0.0.1", "http-handler@v1", "test")
+                .config_keys(vec![
+                    ConfigVar::new("TEST__EXT_CONFIG__GREETING", "Greeting", "hi"),
+                    ConfigVar::new("TEST__EXT_CONFIG__PASSWORD", "Upstream password", "")
+                        .input_type(InputType::Password),
+                ])
+        }
+        async fn handle(
+            &self,
+            _ctx: &dyn Context,
+            _msg: Message,
+            _input: InputStream,
+        ) -> OutputStream {
+            err_not_found("not found")
+        }
+        async fn lifecycle(
+            &self,
+            _ctx: &dyn Context,
+            _e: wafer_run::LifecycleEvent,
+        ) -> Result<(), wafer_run::WaferError> {
+            Ok(())
+        }
+    }
+
+    #[tokio::test]
+    async fn config_ui_masks_secrets_and_saves_without_clobbering_them() {
+        let mut ctx = TestContext::with_admin().await;
+        ctx.register_block("test/ext-config", std::sync::Arc::new(Configurable));
+        ctx.set_config("TEST__EXT_CONFIG__PASSWORD", "hunter2");
+        let path = "/admin/extensions/test--ext-config/config-ui";
+
+        let msg = admin_msg("retrieve", path);
+        let body = output_json(handle(&ctx, &msg, path, InputStream::empty()).await).await;
+        let fields = &body["sections"][0]["fields"];
+        assert_eq!(fields[0]["value"], "hi");
+        assert_eq!(fields[1]["value"], crate::util::MASKED_VALUE);
+        assert!(!body.to_string().contains("hunter2"));
+
+        let save = serde_json::json!({
+            "TEST__EXT_CONFIG__GREETING": "hello",
+            "TEST__EXT_CONFIG__PASSWORD": crate::util::MASKED_VALUE,
+            "TEST__OTHER": "x",
+        });
+        let input = InputStream::from_bytes(serde_json::to_vec(&save).unwrap());
+        let out = handle(&ctx, &admin_msg("update", path), path, input).await;
+        assert_eq!(output_status(out).await, 200);
+        let get = |key: &'static str| wafer_core::clients::config::get_default(&ctx, key, "");
+        assert_eq!(get("TEST__EXT_CONFIG__GREETING").await, "hello");
+        assert_eq!(get("TEST__EXT_CONFIG__PASSWORD").await, "hunter2");
+        assert_eq!(get("TEST__OTHER").await, "");
+
+        let path = "/admin/extensions/test--ext-missing/config-ui";
+        let out = handle(
+            &ctx,
+            &admin_msg("retrieve", path),
+            path,
+            InputStream::empty(),
+        )
+        .await;
+        assert!(crate::test_support::output_is_error(out, "NotFound").await);
+    }
+
+    #[tokio::test]
+    async fn retry_of_unknown_block_is_not_found() {
+        let ctx = TestContext::with_admin().await;
+        let path = "/admin/extensions/test--unknown/migrations/retry";
+        let out = handle(&ctx, &admin_msg("create", path), path, InputStream::empty()).await;
+        assert!(crate::test_support::output_is_error(out, "NotFound").await);
+    }
+}
@@ -0,0 +1,329 @@
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::logs::audit_log;
+use crate::{
+    blocks::{
+        errors,
+        feature_flags::{self, FeatureFlag, FlagInput},
+    },
+    etag,
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+};
+
+/// Feature flag definitions (see [`crate::blocks::feature_flags`]).
+pub(crate) const FEATURE_FLAGS_TABLE: &str = "suppers_ai__admin__feature_flags";
+
+/// `path` is the normalized `/admin/feature-flags...` sub-path, passed
+/// explicitly (no `req.resource` rewrite).
+///
+/// - `GET /admin/feature-flags` — every flag definition.
+/// - `POST /admin/feature-flags` — create.
+/// - `PUT|PATCH /admin/feature-flags/{key}` — change the fields given.
+/// - `DELETE /admin/feature-flags/{key}` — delete.
+pub async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    path: &str,
+    input: InputStream,
+) -> OutputStream {
+    let key = path
+        .strip_prefix("/admin/feature-flags/")
+        .filter(|key| !key.is_empty() && !key.contains('/'));
+
+    match (msg.action(), path, key) {
+        ("retrieve", "/admin/feature-flags", _) => handle_list(ctx, msg).await,
+        ("create", "/admin/feature-flags", _) => handle_create(ctx, msg, input).await,
+        ("update", _, Some(key)) => handle_update(ctx, msg, key, input).await,
+        ("delete", _, Some(key)) => handle_delete(ctx, msg, key).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+/// Tagged by the flags' versions, so an unchanged list is a `304`.
+async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    match feature_flags::list(ctx).await {
+        Ok(all) => {
+            let tag = etag::version(all.iter().map(|f| f.updated_at.as_str()));
+            if let Some(unchanged) = etag::not_modified(msg, &tag) {
+                return unchanged;
+            }
+            etag::ok_json_tagged(msg, &tag, &serde_json::json!({ "flags": all }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// Parse a `FlagInput` body and validate it over `existing`, or build the
+/// error response.
+async fn read_input(
+    input: InputStream,
+    existing: Option<&FeatureFlag>,
+) -> Result<feature_flags::ValidFlag, OutputStream> {
+    let raw = input.collect_to_bytes().await;
+    let body: FlagInput =
+        serde_json::from_slice(&raw).map_err(|e| err_bad_request(&format!("Invalid body: {e}")))?;
+    body.validate(existing)
+        .map_err(|fields| errors::validation_error("Invalid feature flag", &fields))
+}
+
+async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let valid = match read_input(input, None).await {
+        Ok(v) => v,
+        Err(resp) => return resp,
+    };
+    match feature_flags::create(ctx, &valid, msg.user_id()).await {
+        Ok(created) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "feature_flags.create",
+                &format!("feature_flag:{}", created.key),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&created)
+        }
+        Err(e) => errors::db_error_response("Feature flag", e),
+    }
+}
+
+async fn handle_update(
+    ctx: &dyn Context,
+    msg: &Message,
+    key: &str,
+    input: InputStream,
+) -> OutputStream {
+    let existing = match feature_flags::get(ctx, key).await {
+        Ok(Some(flag)) => flag,
+        Ok(None) => return err_not_found("Feature flag not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    let valid = match read_input(input, Some(&existing)).await {
+        Ok(v) => v,
+        Err(resp) => return resp,
+    };
+    match feature_flags::update(ctx, &existing.id, &valid).await {
+        Ok(updated) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "feature_flags.update",
+                &format!("feature_flag:{key}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&updated)
+        }
+        Err(e) => errors::db_error_response("Feature flag", e),
+    }
+}
+
+async fn handle_delete(ctx: &dyn Context, msg: &Message, key: &str) -> OutputStream {
+    match feature_flags::delete(ctx, key).await {
+        Ok(true) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "feature_flags.delete",
+                &format!("feature_flag:{key}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&serde_json::json!({ "deleted": true }))
+        }
+        Ok(false) => err_not_found("Feature flag not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{
+        admin_msg, output_is_error, output_json, output_status, TestContext,
+    };
+
+    fn body(v: serde_json::Value) -> InputStream {
+        InputStream::from_bytes(serde_json::to_vec(&v).unwrap())
+    }
+
+    #[tokio::test]
+    async fn create_list_update_delete_round_trip() {
+        let ctx = TestContext::with_auth().await;
+        let msg = admin_msg("create", "/b/admin/api/feature-flags");
+        let created = output_json(
+            handle(
+                &ctx,
+                &msg,
+                "/admin/feature-flags",
+                body(serde_json::json!({
+                    "key": "new-editor",
+                    "description": "Block-based page editor",
+                    "target_roles": ["beta"],
+                })),
+            )
+            .await,
+        )
+        .await;
+        assert_eq!(created["key"], "new-editor");
+        assert_eq!(created["enabled"], false);
+        assert_eq!(created["rollout_percent"], 0);
+        assert_eq!(created["created_by"], "admin_1");
+
+        // Same key again: the unique index answers.
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/feature-flags",
+            body(serde_json::json!({ "key": "new-editor" })),
+        )
+        .await;
+        assert!(output_is_error(out, "AlreadyExists").await);
+
+        let path = "/admin/feature-flags/new-editor";
+        let msg = admin_msg("update", "/b/admin/api/feature-flags/new-editor");
+        let updated = output_json(
+            handle(
+                &ctx,
+                &msg,
+                path,
+                body(serde_json::json!({ "enabled": true, "rollout_percent": 20 })),
+            )
+            .await,
+        )
+        .await;
+        assert_eq!(updated["enabled"], true);
+        assert_eq!(updated["rollout_percent"], 20);
+        assert_eq!(updated["target_roles"], serde_json::json!(["beta"]));
+
+        let msg = admin_msg("retrieve", "/b/admin/api/feature-flags");
+        let listed =
+            output_json(handle(&ctx, &msg, "/admin/feature-flags", InputStream::empty()).await)
+                .await;
+        assert_eq!(listed["flags"].as_array().unwrap().len(), 1);
+
+        let msg = admin_msg("delete", "/b/admin/api/feature-flags/new-editor");
+        let out = handle(&ctx, &msg, path, InputStream::empty()).await;
+        assert_eq!(output_status(out).await, 200);
+        let out = handle(&ctx, &msg, path, InputStream::empty()).await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+
+    #[tokio::test]
+    async fn list_is_revalidated_by_etag() {
+        use wafer_run::{MetaGet, META_RESP_STATUS};
+
+        let ctx = TestContext::with_auth().await;
+        let create = admin_msg("create", "/b/admin/api/feature-flags");
+        let input = body(serde_json::json!({ "key": "beta-search" }));
+        handle(&ctx, &create, "/admin/feature-flags", input).await;
+
+        let list = |tag: Option<&str>| {
+            let mut msg = admin_msg("retrieve", "/b/admin/api/feature-flags");
+            if let Some(tag) = tag {
+                msg.set_meta("http.header.if-none-match", tag);
+            }
+            msg
+        };
+        let first = handle(
+            &ctx,
+            &list(None),
+            "/admin/feature-flags",
+            InputStream::empty(),
+        )
+        .await
+        .collect_buffered()
+        .await
+        .unwrap();
+        let tag = MetaGet::get(&first.meta, "resp.header.ETag")
+            .unwrap()
+            .to_string();
+
+        let again = handle(
+            &ctx,
+            &list(Some(&tag)),
+            "/admin/feature-flags",
+            InputStream::empty(),
+        )
+        .await
+        .collect_buffered()
+        .await
+        .unwrap();
+        assert_eq!(MetaGet::get(&again.meta, META_RESP_STATUS), Some("304"));
+        assert!(again.body.is_empty());
+
+        let update = admin_msg("update", "/b/admin/api/feature-flags/beta-search");
+        let input = body(serde_json::json!({ "enabled": true }));
+        handle(&ctx, &update, "/admin/feature-flags/beta-search", input).await;
+
+        let changed = handle(
+            &ctx,
+            &list(Some(&tag)),
+            "/admin/feature-flags",
+            InputStream::empty(),
+        )
+        .await
+        .collect_buffered()
+        .await
+        .unwrap();
+        assert_ne!(MetaGet::get(&changed.meta, META_RESP_STATUS), Some("304"));
+        assert_ne!(
+            MetaGet::get(&changed.meta, "resp.header.ETag"),
+            Some(tag.as_str())
+        );
+        let listed: serde_json::Value = serde_json::from_slice(&changed.body).unwrap();
+        assert_eq!(listed["flags"][0]["enabled"], true);
+    }
+
+    #[tokio::test]
+    async fn edits_apply_to_evaluation_immediately() {
+        let ctx = TestContext::with_auth().await;
+        let msg = admin_msg("create", "/b/admin/api/feature-flags");
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/feature-flags",
+            body(serde_json::json!({ "key": "checkout-v2", "target_users": ["u1"] })),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 200);
+        assert!(!feature_flags::is_enabled(&ctx, "checkout-v2", "u1", &[]).await);
+
+        let msg = admin_msg("update", "/b/admin/api/feature-flags/checkout-v2");
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/feature-flags/checkout-v2",
+            body(serde_json::json!({ "enabled": true })),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 200);
+        assert!(feature_flags::is_enabled(&ctx, "checkout-v2", "u1", &[]).await);
+        assert!(!feature_flags::is_enabled(&ctx, "checkout-v2", "u2", &[]).await);
+        assert!(!feature_flags::is_enabled(&ctx, "unknown", "u1", &[]).await);
+    }
+
+    #[tokio::test]
+    async fn invalid_body_is_a_validation_error() {
+        let ctx = TestContext::with_auth().await;
+        let msg = admin_msg("create", "/b/admin/api/feature-flags");
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/feature-flags",
+            body(serde_json::json!({ "key": "Bad Key", "rollout_percent": 150 })),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 400);
+
+        let msg = admin_msg("update", "/b/admin/api/feature-flags/missing");
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/feature-flags/missing",
+            body(serde_json::json!({ "enabled": true })),
+        )
+        .await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+}
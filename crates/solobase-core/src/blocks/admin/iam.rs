@@ -6,6 +6,11 @@ use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream}
 
 use super::logs::audit_log;
 use crate::{
+    blocks::{
+        api_quota::{self, QuotaDefinition},
+        errors, permissions,
+    },
+    cache,
     http::{err_bad_request, err_conflict, err_forbidden, err_internal, err_not_found, ok_json},
     util::{json_map, RecordExt},
 };
@@ -19,6 +24,12 @@ pub(crate) const PERMISSIONS_TABLE: &str = "suppers_ai__admin__permissions";
 /// User → role assignment table (many-to-many via row per pair).
 pub(crate) const USER_ROLES_TABLE: &str = "suppers_ai__admin__user_roles";
 
+/// Usage-quota definitions (see [`crate::blocks::api_quota`]).
+pub(crate) const QUOTAS_TABLE: &str = "suppers_ai__admin__quotas";
+
+/// Fixed-window usage counters, one row per `{user_id}:{resource}`.
+pub(crate) const QUOTA_COUNTERS_TABLE: &str = "suppers_ai__admin__quota_counters";
+
 /// `path` is the normalized `/admin/iam/...` sub-path, passed explicitly (no
 /// `req.resource` rewrite). Id-bearing leaves take their id from it.
 pub async fn handle(
@@ -45,6 +56,9 @@ pub async fn handle(
         ("delete", _) if path.starts_with("/admin/iam/permissions/") => {
             handle_delete_permission(ctx, path).await
         }
+        // Usage quotas
+        ("retrieve", "/admin/iam/quotas") => handle_list_quotas(ctx).await,
+        ("update", "/admin/iam/quotas") => handle_replace_quotas(ctx, msg, input).await,
         // User-role assignments
         ("retrieve", "/admin/iam/user-roles") => handle_list_user_roles(ctx, msg).await,
         ("create", "/admin/iam/user-roles") => handle_assign_role(ctx, msg, input).await,
@@ -276,6 +290,7 @@ async fn handle_assign_role(ctx: &dyn Context, msg: &Message, input: InputStream
     }));
     match db::create(ctx, USER_ROLES_TABLE, data).await {
         Ok(record) => {
+            cache::invalidate_table(USER_ROLES_TABLE);
             // Audit-log like every other admin mutation (this JSON path used to
             // write zero audit rows).
             audit_log(
@@ -317,6 +332,7 @@ async fn handle_remove_role(ctx: &dyn Context, msg: &Message, path: &str) -> Out
 
     match db::delete(ctx, USER_ROLES_TABLE, id).await {
         Ok(()) => {
+            cache::invalidate_table(USER_ROLES_TABLE);
             audit_log(
                 ctx,
                 msg.user_id(),
@@ -332,26 +348,293 @@ async fn handle_remove_role(ctx: &dyn Context, msg: &Message, path: &str) -> Out
     }
 }
 
+/// `GET /admin/iam/quotas` — every definition plus the tags routes declare,
+/// so the UI can offer only resources that are actually counted.
+async fn handle_list_quotas(ctx: &dyn Context) -> OutputStream {
+    match api_quota::list_definitions(ctx).await {
+        Ok(quotas) => ok_json(&serde_json::json!({
+            "quotas": quotas,
+            "resources": api_quota::known_resources(),
+        })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `PUT /admin/iam/quotas` (arrives as `update`) — replace the whole
+/// definition set with `{"quotas": [...]}`. Invalid entries reject the
+/// request with per-entry `validation_failed` details; nothing is written.
+async fn handle_replace_quotas(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        quotas: Vec<QuotaDefinition>,
+    }
+    let raw = input.collect_to_bytes().await;
+    let body: Req = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+
+    let mut problems: Vec<(String, &str)> = body
+        .quotas
+        .iter()
+        .enumerate()
+        .flat_map(|(i, q)| q.problems(i))
+        .collect();
+    for (i, q) in body.quotas.iter().enumerate() {
+        let duplicate = body.quotas[..i]
+            .iter()
+            .any(|p| (&p.resource, p.scope, &p.subject) == (&q.resource, q.scope, &q.subject));
+        if duplicate {
+            problems.push((format!("quotas[{i}]"), "duplicates an earlier entry"));
+        }
+    }
+    if !problems.is_empty() {
+        let fields: Vec<(&str, &str)> = problems.iter().map(|(f, r)| (f.as_str(), *r)).collect();
+        return errors::validation_error("Invalid quota definitions", &fields);
+    }
+
+    if let Err(e) = api_quota::replace_definitions(ctx, &body.quotas).await {
+        return err_internal("Database error", e);
+    }
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "iam.quotas.replace",
+        &format!("quotas ({} definitions)", body.quotas.len()),
+        msg.remote_addr(),
+    )
+    .await;
+    handle_list_quotas(ctx).await
+}
+
+/// `GET /api/iam/my-permissions`: the caller's roles, the permissions they
+/// hold, and for each admin sidebar link whether they may open it. The
+/// admin navigation drops the links marked `false` (see
+/// [`crate::ui::assets::nav_permissions_js`]); a link whose route carries
+/// no permission opens for admins only.
+pub async fn handle_my_permissions(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let admin = crate::util::is_admin(msg);
+    let roles = crate::blocks::feature_flags::roles_of(msg);
+    let mut held = match permissions::held_by(ctx, &roles).await {
+        Ok(held) => held,
+        Err(e) => return err_internal("Database error", e),
+    };
+    held.sort();
+    held.dedup();
+    let sections: serde_json::Map<String, serde_json::Value> = crate::ui::nav_groups::admin()
+        .iter()
+        .flat_map(|group| &group.items)
+        .map(|item| {
+            let open = admin
+                || permissions::required("retrieve", &item.href)
+                    .is_some_and(|p| permissions::holds(&held, p));
+            (item.href.clone(), serde_json::json!(open))
+        })
+        .collect();
+    ok_json(&serde_json::json!({
+        "admin": admin,
+        "roles": roles,
+        "permissions": held,
+        "sections": sections,
+    }))
+}
+
+/// Create the system roles on a fresh install and the scoped admin roles
+/// wherever they are missing, then catalogue the core route permissions —
+/// granting each to the default roles the first time it appears (see
+/// [`permissions::seed`]).
 pub async fn seed_defaults(ctx: &dyn Context) {
     let count = db::count(ctx, ROLES_TABLE, &[]).await.unwrap_or(0);
-    if count > 0 {
-        return;
-    }
-
-    let now = crate::util::now_rfc3339();
-    for (name, desc) in &[
-        ("admin", "Full access to all resources"),
-        ("user", "Standard user access"),
-    ] {
-        let data = json_map(serde_json::json!({
-            "name": name,
-            "description": desc,
+    if count == 0 {
+        let now = crate::util::now_rfc3339();
+        for (name, desc, held) in &[
+            (
+                "admin",
+                "Full access to all resources",
+                &[permissions::ALL][..],
+            ),
+            ("user", "Standard user access", &[][..]),
+        ] {
+            let data = json_map(serde_json::json!({
+                "name": name,
+                "description": desc,
+                "is_system": true,
+                "created_at": now,
+                "permissions": held
+            }));
+            if let Err(e) = db::create(ctx, ROLES_TABLE, data).await {
+                tracing::warn!("Failed to seed default role '{name}': {e}");
+            }
+        }
+    }
+    seed_scoped_roles(ctx).await;
+    permissions::seed(ctx).await;
+}
+
+/// Create each [`permissions::SCOPED_ROLES`] entry whose name is free, on
+/// fresh installs and upgrades alike. A role already holding the name,
+/// built-in or not, is left as it is.
+async fn seed_scoped_roles(ctx: &dyn Context) {
+    for role in permissions::SCOPED_ROLES {
+        match db::get_by_field(ctx, ROLES_TABLE, "name", serde_json::json!(role.name)).await {
+            Ok(_) => continue,
+            Err(e) if e.code == ErrorCode::NotFound => {}
+            Err(e) => {
+                tracing::warn!("Failed to look up role '{}': {e}", role.name);
+                continue;
+            }
+        }
+        let mut data = json_map(serde_json::json!({
+            "name": role.name,
+            "description": role.description,
             "is_system": true,
-            "created_at": now,
-            "permissions": []
+            "permissions": role.permissions,
         }));
-        if let Err(e) = db::create(ctx, ROLES_TABLE, data).await {
-            tracing::warn!("Failed to seed default role '{name}': {e}");
+        crate::util::stamp_created(&mut data);
+        match db::create(ctx, ROLES_TABLE, data).await {
+            Ok(_) => {}
+            // Seeded concurrently by another isolate.
+            Err(e) if errors::is_unique_violation(&e) => {}
+            Err(e) => tracing::warn!("Failed to seed role '{}': {e}", role.name),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        blocks::auth::helpers::get_user_roles,
+        test_support::{admin_msg, output_json, TestContext},
+    };
+
+    async fn role_permissions(ctx: &TestContext, name: &str) -> Vec<String> {
+        permissions::held_by(ctx, &[name]).await.unwrap()
+    }
+
+    #[tokio::test]
+    async fn seeding_grants_core_permissions_to_default_roles_once() {
+        let ctx = TestContext::with_admin().await;
+        seed_defaults(&ctx).await;
+        assert_eq!(role_permissions(&ctx, "admin").await, ["*"]);
+        assert_eq!(role_permissions(&ctx, "user").await, ["storage.write"]);
+
+        // An admin takes it away; the next init doesn't hand it back.
+        let row = db::get_by_field(&ctx, ROLES_TABLE, "name", serde_json::json!("user"))
+            .await
+            .unwrap();
+        let data = json_map(serde_json::json!({ "permissions": [] }));
+        db::update(&ctx, ROLES_TABLE, &row.id, data).await.unwrap();
+        seed_defaults(&ctx).await;
+        assert!(role_permissions(&ctx, "user").await.is_empty());
+    }
+
+    #[tokio::test]
+    async fn upgrades_grant_new_core_permissions_to_existing_roles() {
+        let ctx = TestContext::with_admin().await;
+        // Roles from before permissions were enforced: all empty.
+        for name in ["admin", "user"] {
+            let mut data = json_map(serde_json::json!({ "name": name, "permissions": [] }));
+            crate::util::stamp_created(&mut data);
+            db::create(&ctx, ROLES_TABLE, data).await.unwrap();
+        }
+        seed_defaults(&ctx).await;
+        assert_eq!(role_permissions(&ctx, "user").await, ["storage.write"]);
+        let catalogued = db::count(&ctx, PERMISSIONS_TABLE, &[]).await.unwrap();
+        assert_eq!(catalogued as usize, permissions::CORE.len());
+    }
+
+    #[tokio::test]
+    async fn scoped_roles_are_seeded_alongside_existing_ones() {
+        let ctx = TestContext::with_admin().await;
+        // An install from before scoped roles, one of whose own roles
+        // already took a scoped name.
+        for (name, held) in [
+            ("admin", vec!["*"]),
+            ("user", vec![]),
+            ("log_viewer", vec![]),
+        ] {
+            let mut data = json_map(serde_json::json!({ "name": name, "permissions": held }));
+            crate::util::stamp_created(&mut data);
+            db::create(&ctx, ROLES_TABLE, data).await.unwrap();
         }
+        seed_defaults(&ctx).await;
+        seed_defaults(&ctx).await;
+
+        assert_eq!(role_permissions(&ctx, "admin").await, ["*"]);
+        assert_eq!(role_permissions(&ctx, "user_admin").await, ["users.manage"]);
+        assert_eq!(
+            role_permissions(&ctx, "storage_admin").await,
+            ["storage.admin"]
+        );
+        assert_eq!(
+            role_permissions(&ctx, "billing_admin").await,
+            ["billing.manage"]
+        );
+        assert!(role_permissions(&ctx, "log_viewer").await.is_empty());
+        let roles = db::count(&ctx, ROLES_TABLE, &[]).await.unwrap();
+        assert_eq!(roles, 6);
+        let seeded = db::get_by_field(&ctx, ROLES_TABLE, "name", serde_json::json!("user_admin"))
+            .await
+            .unwrap();
+        assert!(seeded.bool_field("is_system"));
+    }
+
+    #[tokio::test]
+    async fn my_permissions_marks_the_sections_a_scoped_admin_can_open() {
+        let ctx = TestContext::with_admin().await;
+        seed_defaults(&ctx).await;
+
+        let mut msg = crate::test_support::auth_msg("retrieve", "/iam/my-permissions", "u1");
+        msg.set_meta("auth.user_roles", "user,user_admin");
+        let body = output_json(handle_my_permissions(&ctx, &msg).await).await;
+        assert_eq!(body["admin"], false);
+        assert_eq!(body["roles"], serde_json::json!(["user", "user_admin"]));
+        assert_eq!(
+            body["permissions"],
+            serde_json::json!(["storage.write", "users.manage"])
+        );
+        assert_eq!(body["sections"]["/b/admin/users"], true);
+        assert_eq!(body["sections"]["/b/admin/"], false);
+        assert_eq!(body["sections"]["/b/admin/database"], false);
+        assert_eq!(body["sections"]["/b/admin/logs"], false);
+
+        let admin = admin_msg("retrieve", "/iam/my-permissions");
+        let body = output_json(handle_my_permissions(&ctx, &admin).await).await;
+        assert_eq!(body["admin"], true);
+        let sections = body["sections"].as_object().unwrap();
+        assert!(!sections.is_empty());
+        assert!(sections.values().all(|open| open == true));
+    }
+
+    #[tokio::test]
+    async fn role_writes_refresh_cached_roles() {
+        cache::enable_for_current_thread();
+        let ctx = TestContext::with_auth().await;
+        let user = uuid::Uuid::now_v7().to_string();
+        assert!(get_user_roles(&ctx, &user).await.unwrap().is_empty());
+
+        // Written behind the cache's back: the cached answer still stands.
+        let raw = json_map(serde_json::json!({ "user_id": user, "role": "editor" }));
+        let raw = db::create(&ctx, USER_ROLES_TABLE, raw).await.unwrap();
+        assert!(get_user_roles(&ctx, &user).await.unwrap().is_empty());
+        db::delete(&ctx, USER_ROLES_TABLE, &raw.id).await.unwrap();
+
+        let msg = admin_msg("create", "/b/admin/api/iam/user-roles");
+        let input = InputStream::from_bytes(
+            serde_json::to_vec(&serde_json::json!({ "user_id": user, "role": "viewer" })).unwrap(),
+        );
+        let assigned = output_json(handle(&ctx, &msg, "/admin/iam/user-roles", input).await).await;
+        assert_eq!(get_user_roles(&ctx, &user).await.unwrap(), ["viewer"]);
+
+        let id = assigned["id"].as_str().unwrap();
+        let msg = admin_msg("delete", &format!("/b/admin/api/iam/user-roles/{id}"));
+        let path = format!("/admin/iam/user-roles/{id}");
+        handle(&ctx, &msg, &path, InputStream::empty()).await;
+        assert!(get_user_roles(&ctx, &user).await.unwrap().is_empty());
     }
 }
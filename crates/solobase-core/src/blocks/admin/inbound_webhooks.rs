@@ -0,0 +1,1441 @@
+//! Inbound webhooks: named endpoints external systems (Zapier, CI
+//! pipelines, payment providers other than Stripe) push JSON into.
+//!
+//! An admin creates an endpoint and gets its secret back once. The sender
+//! POSTs to `/b/hooks/in/{id}` with `X-Webhook-Signature: sha256=<hex>`, the
+//! HMAC-SHA256 of the raw body under that secret — the same scheme the
+//! products block signs its outgoing webhooks with. The signature is checked
+//! before the body is parsed. A verified payload then runs the endpoint's
+//! action:
+//!
+//! - `insert` — `field_map` (column → JSON pointer into the payload; empty
+//!   maps top-level keys onto same-named columns) picks the values, which are
+//!   validated against the target table's introspected columns exactly as a
+//!   CSV import row is, then inserted.
+//! - `block` — the payload is forwarded as `create:/hooks/in/{hook}` to a
+//!   registered non-built-in block, with the endpoint id and name in
+//!   [`META_ENDPOINT_ID`] / [`META_ENDPOINT_NAME`].
+//!
+//! # Single delivery
+//!
+//! The signature alone doesn't stop a captured delivery being sent again.
+//! An endpoint with `single_delivery` set accepts each delivery once: the
+//! sender adds `X-Webhook-Id` (unique per delivery, at most 200 bytes) and
+//! `X-Webhook-Timestamp` (Unix seconds), and signs `{id}.{timestamp}.{body}`
+//! instead of the body alone. A timestamp more than
+//! [`REPLAY_WINDOW_SECS`] away from now is refused with 401, and an id
+//! already received within that window with 409, through
+//! [`crate::blocks::nonces`]. A delivery that fails after its id was
+//! claimed gives the id back, so the sender's retry goes through.
+//!
+//! The route is unauthenticated beyond the signature, so each endpoint is
+//! rate limited (its own `rate_limit` per minute, else the shared
+//! `inbound_webhook` category) and the body is capped at
+//! `SUPPERS_AI__ADMIN__INBOUND_WEBHOOK_MAX_BYTES`. Every attempt past the
+//! rate limit is recorded with its outcome; the most recent
+//! [`KEPT_DELIVERIES`] per endpoint are kept for debugging.
+
+use std::{
+    collections::{BTreeMap, HashMap},
+    time::Duration,
+};
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_block_crypto::primitives;
+use wafer_core::clients::{
+    config,
+    database::{self as db, Record},
+};
+use wafer_run::{
+    context::Context, streams::output::TerminalNotResponse, InputStream, Message, OutputStream,
+    WaferError,
+};
+
+use super::{
+    database::{introspect_columns, ColumnInfo},
+    logs::audit_log,
+    table_io::{coerce_cell, missing_required},
+};
+use crate::{
+    blocks::{
+        errors::{self, ErrorCode},
+        nonces,
+        rate_limit::{rate_limited_response, RateLimit, UserRateLimiter},
+    },
+    http::{
+        err_bad_request, err_internal, err_internal_no_cause, err_not_found, err_unauthorized,
+        ok_json,
+    },
+    util::{hex_encode, RecordExt},
+};
+
+/// Inbound webhook endpoint definitions.
+pub(crate) const INBOUND_WEBHOOKS_TABLE: &str = "suppers_ai__admin__inbound_webhooks";
+
+/// One row per received delivery attempt.
+pub(crate) const INBOUND_WEBHOOK_DELIVERIES_TABLE: &str =
+    "suppers_ai__admin__inbound_webhook_deliveries";
+
+/// Config key capping an inbound webhook body, in bytes.
+pub(in crate::blocks::admin) const MAX_PAYLOAD_BYTES_KEY: &str =
+    "SUPPERS_AI__ADMIN__INBOUND_WEBHOOK_MAX_BYTES";
+
+/// Default for [`MAX_PAYLOAD_BYTES_KEY`]: 256 KiB.
+pub(in crate::blocks::admin) const DEFAULT_MAX_PAYLOAD_BYTES: i64 = 256 * 1024;
+
+/// Meta key carrying the endpoint id on a forwarded `block` delivery.
+pub const META_ENDPOINT_ID: &str = "webhook.endpoint_id";
+
+/// Meta key carrying the endpoint name on a forwarded `block` delivery.
+pub const META_ENDPOINT_NAME: &str = "webhook.endpoint_name";
+
+/// Deliveries kept per endpoint; older rows are pruned on write.
+const KEPT_DELIVERIES: i64 = 100;
+
+/// Leading bytes of a verified payload stored on its delivery row.
+const STORED_PAYLOAD_BYTES: usize = 4096;
+
+/// Rate-limit category (`SOLOBASE_SHARED__RATE_LIMIT_INBOUND_WEBHOOK`).
+const RATE_LIMIT_CATEGORY: &str = "inbound_webhook";
+
+/// Upper bound on an endpoint's own per-minute `rate_limit`.
+const MAX_RATE_LIMIT: i64 = 10_000;
+
+const SIGNATURE_HEADER: &str = "x-webhook-signature";
+const ID_HEADER: &str = "x-webhook-id";
+const TIMESTAMP_HEADER: &str = "x-webhook-timestamp";
+
+/// How far a single-delivery timestamp may be from now, either way; ids are
+/// remembered this long past their timestamp.
+pub(in crate::blocks::admin) const REPLAY_WINDOW_SECS: i64 = 5 * 60;
+
+/// Tables owned by built-in blocks (and SQLite itself) are never insert
+/// targets: a leaked endpoint secret must not be able to write users or
+/// grants.
+const RESERVED_TABLE_PREFIXES: &[&str] = &["suppers_ai__", "wafer_run__", "sqlite_"];
+
+/// Built-in blocks are never forward targets, for the same reason.
+const RESERVED_BLOCK_PREFIXES: &[&str] = &["suppers-ai/", "wafer-run/"];
+
+// ---------------------------------------------------------------------------
+// Model
+// ---------------------------------------------------------------------------
+
+/// What a verified delivery does.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, serde::Serialize)]
+#[serde(rename_all = "lowercase")]
+enum Action {
+    Insert,
+    Block,
+}
+
+impl Action {
+    fn as_str(self) -> &'static str {
+        match self {
+            Self::Insert => "insert",
+            Self::Block => "block",
+        }
+    }
+
+    fn parse(s: &str) -> Option<Self> {
+        match s {
+            "insert" => Some(Self::Insert),
+            "block" => Some(Self::Block),
+            _ => None,
+        }
+    }
+}
+
+#[derive(Debug, Clone, serde::Serialize)]
+struct Endpoint {
+    id: String,
+    name: String,
+    #[serde(skip)]
+    secret: String,
+    action: Action,
+    target_table: String,
+    field_map: BTreeMap<String, String>,
+    target_block: String,
+    hook: String,
+    enabled: bool,
+    rate_limit: i64,
+    single_delivery: bool,
+    created_by: String,
+    created_at: String,
+    updated_at: String,
+}
+
+impl Endpoint {
+    fn from_record(r: &Record) -> Option<Self> {
+        Some(Self {
+            id: r.id.clone(),
+            name: r.str_field("name").to_string(),
+            secret: r.str_field("secret").to_string(),
+            action: Action::parse(r.str_field("action"))?,
+            target_table: r.str_field("target_table").to_string(),
+            field_map: serde_json::from_str(r.str_field("field_map")).unwrap_or_default(),
+            target_block: r.str_field("target_block").to_string(),
+            hook: r.str_field("hook").to_string(),
+            enabled: r.bool_field("enabled"),
+            rate_limit: r.i64_field("rate_limit"),
+            single_delivery: r.bool_field("single_delivery"),
+            created_by: r.str_field("created_by").to_string(),
+            created_at: r.str_field("created_at").to_string(),
+            updated_at: r.str_field("updated_at").to_string(),
+        })
+    }
+
+    fn fields(&self) -> HashMap<String, serde_json::Value> {
+        crate::util::json_map(serde_json::json!({
+            "name": self.name,
+            "secret": self.secret,
+            "action": self.action.as_str(),
+            "target_table": self.target_table,
+            "field_map": serde_json::json!(self.field_map).to_string(),
+            "target_block": self.target_block,
+            "hook": self.hook,
+            "enabled": i64::from(self.enabled),
+            "rate_limit": self.rate_limit,
+            "single_delivery": i64::from(self.single_delivery),
+        }))
+    }
+
+    /// API shape: the stored fields minus the secret, plus the receive path.
+    fn to_json(&self) -> serde_json::Value {
+        let mut v = serde_json::json!(self);
+        v["url"] = serde_json::json!(format!("/b/hooks/in/{}", self.id));
+        v
+    }
+}
+
+/// Create/update body. On update, absent fields keep their stored value.
+#[derive(Debug, Default, serde::Deserialize)]
+struct EndpointInput {
+    name: Option<String>,
+    action: Option<String>,
+    target_table: Option<String>,
+    field_map: Option<BTreeMap<String, String>>,
+    target_block: Option<String>,
+    hook: Option<String>,
+    enabled: Option<bool>,
+    rate_limit: Option<i64>,
+    single_delivery: Option<bool>,
+    /// Replace the secret with a freshly generated one (update only).
+    #[serde(default)]
+    rotate_secret: bool,
+}
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::json!(value),
+    }
+}
+
+fn generate_secret() -> Result<String, String> {
+    let bytes = primitives::random_bytes(32).map_err(|e| e.to_string())?;
+    Ok(hex_encode(&bytes))
+}
+
+async fn get(ctx: &dyn Context, id: &str) -> Result<Option<Endpoint>, WaferError> {
+    match db::get(ctx, INBOUND_WEBHOOKS_TABLE, id).await {
+        Ok(r) => Ok(Endpoint::from_record(&r)),
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Admin API
+// ---------------------------------------------------------------------------
+
+/// `path` is the normalized `/admin/inbound-webhooks...` sub-path, passed
+/// explicitly (no `req.resource` rewrite).
+///
+/// - `GET /admin/inbound-webhooks` — every endpoint (secrets omitted).
+/// - `POST /admin/inbound-webhooks` — create; the response carries the secret.
+/// - `PUT|PATCH /admin/inbound-webhooks/{id}` — update the given fields;
+///   `rotate_secret: true` issues a new secret, returned once.
+/// - `DELETE /admin/inbound-webhooks/{id}` — delete, with its deliveries.
+/// - `GET /admin/inbound-webhooks/{id}/deliveries?limit=` — recent
+///   deliveries, newest first.
+pub async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    path: &str,
+    input: InputStream,
+) -> OutputStream {
+    let rest = path.strip_prefix("/admin/inbound-webhooks").unwrap_or("");
+    let segments: Vec<&str> = rest.split('/').filter(|s| !s.is_empty()).collect();
+
+    match (msg.action(), segments.as_slice()) {
+        ("retrieve", []) => handle_list(ctx).await,
+        ("create", []) => handle_create(ctx, msg, input).await,
+        ("update", [id]) => handle_update(ctx, msg, id, input).await,
+        ("delete", [id]) => handle_delete(ctx, msg, id).await,
+        ("retrieve", [id, "deliveries"]) => handle_deliveries(ctx, msg, id).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+async fn handle_list(ctx: &dyn Context) -> OutputStream {
+    let opts = ListOptions {
+        sort: vec![SortField {
+            field: "name".to_string(),
+            desc: false,
+        }],
+        limit: 1000,
+        ..Default::default()
+    };
+    match db::list(ctx, INBOUND_WEBHOOKS_TABLE, &opts).await {
+        Ok(page) => {
+            let items: Vec<serde_json::Value> = page
+                .records
+                .iter()
+                .filter_map(Endpoint::from_record)
+                .map(|e| e.to_json())
+                .collect();
+            ok_json(&serde_json::json!({ "endpoints": items }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+async fn read_input(input: InputStream) -> Result<EndpointInput, OutputStream> {
+    let raw = input.collect_to_bytes().await;
+    serde_json::from_slice(&raw).map_err(|e| err_bad_request(&format!("Invalid body: {e}")))
+}
+
+async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let body = match read_input(input).await {
+        Ok(b) => b,
+        Err(resp) => return resp,
+    };
+    let secret = match generate_secret() {
+        Ok(s) => s,
+        Err(e) => return err_internal("Failed to generate secret", e),
+    };
+    let base = Endpoint {
+        id: String::new(),
+        name: String::new(),
+        secret,
+        action: Action::Insert,
+        target_table: String::new(),
+        field_map: BTreeMap::new(),
+        target_block: String::new(),
+        hook: String::new(),
+        enabled: true,
+        rate_limit: 0,
+        single_delivery: false,
+        created_by: msg.user_id().to_string(),
+        created_at: String::new(),
+        updated_at: String::new(),
+    };
+    if body.action.is_none() {
+        return errors::validation_error("Invalid webhook endpoint", &[("action", "is required")]);
+    }
+    let endpoint = match apply_input(ctx, base, body).await {
+        Ok(e) => e,
+        Err(resp) => return resp,
+    };
+
+    let mut data = endpoint.fields();
+    data.insert(
+        "created_by".to_string(),
+        serde_json::json!(endpoint.created_by),
+    );
+    crate::util::stamp_created(&mut data);
+    let created = match db::create(ctx, INBOUND_WEBHOOKS_TABLE, data).await {
+        Ok(r) => r,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let Some(created) = Endpoint::from_record(&created) else {
+        return err_internal_no_cause("Stored webhook endpoint is unreadable");
+    };
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "inbound_webhooks.create",
+        &format!("inbound_webhook:{}", created.id),
+        msg.remote_addr(),
+    )
+    .await;
+
+    let mut out = created.to_json();
+    out["secret"] = serde_json::json!(created.secret);
+    ok_json(&out)
+}
+
+async fn handle_update(
+    ctx: &dyn Context,
+    msg: &Message,
+    id: &str,
+    input: InputStream,
+) -> OutputStream {
+    let body = match read_input(input).await {
+        Ok(b) => b,
+        Err(resp) => return resp,
+    };
+    let existing = match get(ctx, id).await {
+        Ok(Some(e)) => e,
+        Ok(None) => return err_not_found("Webhook endpoint not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    let rotate = body.rotate_secret;
+    let mut endpoint = match apply_input(ctx, existing, body).await {
+        Ok(e) => e,
+        Err(resp) => return resp,
+    };
+    if rotate {
+        endpoint.secret = match generate_secret() {
+            Ok(s) => s,
+            Err(e) => return err_internal("Failed to generate secret", e),
+        };
+    }
+
+    let mut data = endpoint.fields();
+    crate::util::stamp_updated(&mut data);
+    let updated = match db::update(ctx, INBOUND_WEBHOOKS_TABLE, id, data).await {
+        Ok(r) => r,
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => {
+            return err_not_found("Webhook endpoint not found")
+        }
+        Err(e) => return err_internal("Database error", e),
+    };
+    let Some(updated) = Endpoint::from_record(&updated) else {
+        return err_internal_no_cause("Stored webhook endpoint is unreadable");
+    };
+    audit_log(
+        ctx,
+        msg.user_id(),
+        if rotate {
+            "inbound_webhooks.rotate_secret"
+        } else {
+            "inbound_webhooks.update"
+        },
+        &format!("inbound_webhook:{id}"),
+        msg.remote_addr(),
+    )
+    .await;
+
+    let mut out = updated.to_json();
+    if rotate {
+        out["secret"] = serde_json::json!(updated.secret);
+    }
+    ok_json(&out)
+}
+
+async fn handle_delete(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    match db::delete(ctx, INBOUND_WEBHOOKS_TABLE, id).await {
+        Ok(()) => {}
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => {
+            return err_not_found("Webhook endpoint not found")
+        }
+        Err(e) => return err_internal("Database error", e),
+    }
+    if let Err(e) = db::delete_by_filters(
+        ctx,
+        INBOUND_WEBHOOK_DELIVERIES_TABLE,
+        vec![eq("endpoint_id", id)],
+    )
+    .await
+    {
+        tracing::warn!(endpoint_id = id, "failed to delete webhook deliveries: {e}");
+    }
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "inbound_webhooks.delete",
+        &format!("inbound_webhook:{id}"),
+        msg.remote_addr(),
+    )
+    .await;
+    ok_json(&serde_json::json!({ "deleted": true }))
+}
+
+async fn handle_deliveries(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    match get(ctx, id).await {
+        Ok(Some(_)) => {}
+        Ok(None) => return err_not_found("Webhook endpoint not found"),
+        Err(e) => return err_internal("Database error", e),
+    }
+    let limit = msg
+        .query("limit")
+        .parse::<i64>()
+        .unwrap_or(50)
+        .clamp(1, KEPT_DELIVERIES);
+    let opts = ListOptions {
+        filters: vec![eq("endpoint_id", id)],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit,
+        skip_count: true,
+        ..Default::default()
+    };
+    match db::list(ctx, INBOUND_WEBHOOK_DELIVERIES_TABLE, &opts).await {
+        Ok(page) => {
+            let items: Vec<serde_json::Value> = page
+                .records
+                .iter()
+                .map(|r| {
+                    let mut v = serde_json::json!(r.data);
+                    v["id"] = serde_json::json!(r.id);
+                    v
+                })
+                .collect();
+            ok_json(&serde_json::json!({ "deliveries": items }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// Overlay `body` onto `base` and validate the result, or build the
+/// `validation_failed` response listing every problem.
+async fn apply_input(
+    ctx: &dyn Context,
+    mut ep: Endpoint,
+    body: EndpointInput,
+) -> Result<Endpoint, OutputStream> {
+    let mut problems: Vec<(&str, String)> = Vec::new();
+
+    if let Some(name) = body.name {
+        ep.name = name.trim().to_string();
+    }
+    if let Some(action) = body.action {
+        match Action::parse(action.trim()) {
+            Some(a) => ep.action = a,
+            None => problems.push(("action", "must be insert or block".to_string())),
+        }
+    }
+    if let Some(table) = body.target_table {
+        ep.target_table = table.trim().to_string();
+    }
+    if let Some(map) = body.field_map {
+        // A bare key is shorthand for a top-level pointer.
+        ep.field_map = map
+            .into_iter()
+            .map(|(column, pointer)| {
+                let pointer = pointer.trim();
+                if pointer.starts_with('/') {
+                    (column, pointer.to_string())
+                } else {
+                    (column, format!("/{pointer}"))
+                }
+            })
+            .collect();
+    }
+    if let Some(block) = body.target_block {
+        ep.target_block = block.trim().to_string();
+    }
+    if let Some(hook) = body.hook {
+        ep.hook = hook.trim().to_string();
+    }
+    if let Some(enabled) = body.enabled {
+        ep.enabled = enabled;
+    }
+    if let Some(limit) = body.rate_limit {
+        ep.rate_limit = limit;
+    }
+    if let Some(single) = body.single_delivery {
+        ep.single_delivery = single;
+    }
+
+    if ep.name.is_empty() || ep.name.chars().count() > 100 {
+        problems.push(("name", "must be 1-100 characters".to_string()));
+    } else if let Ok(page) = db::list(
+        ctx,
+        INBOUND_WEBHOOKS_TABLE,
+        &ListOptions {
+            filters: vec![eq("name", &ep.name)],
+            limit: 1,
+            skip_count: true,
+            ..Default::default()
+        },
+    )
+    .await
+    {
+        if page.records.iter().any(|r| r.id != ep.id) {
+            problems.push(("name", "is already in use".to_string()));
+        }
+    }
+    if !(0..=MAX_RATE_LIMIT).contains(&ep.rate_limit) {
+        problems.push((
+            "rate_limit",
+            format!("must be between 0 and {MAX_RATE_LIMIT} per minute"),
+        ));
+    }
+
+    match ep.action {
+        Action::Insert => {
+            ep.target_block.clear();
+            ep.hook.clear();
+            if let Err(e) = validate_target_table(ctx, &ep.target_table, &ep.field_map).await {
+                problems.push(e);
+            }
+        }
+        Action::Block => {
+            ep.target_table.clear();
+            ep.field_map.clear();
+            if let Err(e) = validate_target_block(ctx, &ep.target_block) {
+                problems.push(e);
+            }
+            let valid_hook = !ep.hook.is_empty()
+                && ep.hook.len() <= 100
+                && ep
+                    .hook
+                    .chars()
+                    .all(|c| c.is_ascii_alphanumeric() || "._-".contains(c));
+            if !valid_hook {
+                problems.push((
+                    "hook",
+                    "must be 1-100 letters, digits, '.', '_' or '-'".to_string(),
+                ));
+            }
+        }
+    }
+
+    if problems.is_empty() {
+        Ok(ep)
+    } else {
+        let fields: Vec<(&str, &str)> = problems.iter().map(|(f, m)| (*f, m.as_str())).collect();
+        Err(errors::validation_error(
+            "Invalid webhook endpoint",
+            &fields,
+        ))
+    }
+}
+
+async fn validate_target_table(
+    ctx: &dyn Context,
+    table: &str,
+    field_map: &BTreeMap<String, String>,
+) -> Result<(), (&'static str, String)> {
+    if table.is_empty() {
+        return Err(("target_table", "is required".to_string()));
+    }
+    if RESERVED_TABLE_PREFIXES.iter().any(|p| table.starts_with(p)) {
+        return Err((
+            "target_table",
+            "built-in tables cannot be targeted".to_string(),
+        ));
+    }
+    let (columns, _) = introspect_columns(ctx, table).await;
+    if columns.is_empty() {
+        return Err(("target_table", "table not found".to_string()));
+    }
+    if let Some(unknown) = field_map
+        .keys()
+        .find(|k| !columns.iter().any(|c| &c.name == *k))
+    {
+        return Err(("field_map", format!("unknown column '{unknown}'")));
+    }
+    Ok(())
+}
+
+fn validate_target_block(ctx: &dyn Context, block: &str) -> Result<(), (&'static str, String)> {
+    if block.is_empty() {
+        return Err(("target_block", "is required".to_string()));
+    }
+    if RESERVED_BLOCK_PREFIXES.iter().any(|p| block.starts_with(p)) {
+        return Err((
+            "target_block",
+            "built-in blocks cannot be targeted".to_string(),
+        ));
+    }
+    if !ctx.registered_blocks().iter().any(|b| b.name == block) {
+        return Err(("target_block", "block is not registered".to_string()));
+    }
+    Ok(())
+}
+
+// ---------------------------------------------------------------------------
+// Receiver
+// ---------------------------------------------------------------------------
+
+/// Why a delivery was not processed: the status and error stored on its
+/// row, and the response returned to the sender.
+struct Failure {
+    status: u16,
+    error: String,
+    response: OutputStream,
+}
+
+impl Failure {
+    fn invalid(problems: Vec<String>) -> Self {
+        let message = "Payload does not match the target table";
+        Self {
+            status: 400,
+            error: format!("{message}: {}", problems.join("; ")),
+            response: errors::error_json(
+                ErrorCode::ValidationFailed,
+                message,
+                Some(serde_json::json!({ "errors": problems })),
+            ),
+        }
+    }
+
+    fn internal(context: &str, cause: impl std::fmt::Display) -> Self {
+        let error = format!("{context}: {cause}");
+        Self {
+            status: 500,
+            response: err_internal(context, error.clone()),
+            error,
+        }
+    }
+}
+
+/// `POST /b/hooks/in/{endpoint_id}` — verify, then process one delivery.
+pub(in crate::blocks::admin) async fn receive(
+    ctx: &dyn Context,
+    msg: &Message,
+    limiter: &UserRateLimiter,
+    endpoint_id: &str,
+    input: InputStream,
+) -> OutputStream {
+    // Disabled and unknown endpoints look the same to the sender.
+    let endpoint = match get(ctx, endpoint_id).await {
+        Ok(Some(e)) if e.enabled => e,
+        Ok(_) => return err_not_found("Unknown webhook endpoint"),
+        Err(e) => return err_internal("Database error", e),
+    };
+
+    // Rate-limited attempts are not recorded: under a flood that would be a
+    // write per request, and it would evict the useful history.
+    if let Some(limit) = endpoint_limit(ctx, &endpoint).await {
+        let key = UserRateLimiter::key(&endpoint.id, RATE_LIMIT_CATEGORY);
+        if let Err(retry_after) = limiter.check(ctx, &key, limit).await {
+            return rate_limited_response(retry_after);
+        }
+    }
+
+    let cap = config::get_default(
+        ctx,
+        MAX_PAYLOAD_BYTES_KEY,
+        &DEFAULT_MAX_PAYLOAD_BYTES.to_string(),
+    )
+    .await
+    .parse::<i64>()
+    .unwrap_or(DEFAULT_MAX_PAYLOAD_BYTES);
+    let Ok(raw) = crate::util::collect_with_cap(input, cap).await else {
+        let message = format!("Payload exceeds maximum size of {cap} bytes");
+        record(ctx, msg, &endpoint.id, "rejected", 413, &message, "", "").await;
+        return errors::error_json(ErrorCode::PayloadTooLarge, &message, None);
+    };
+
+    let delivery = if endpoint.single_delivery {
+        match claim_delivery(ctx, msg, &endpoint, &raw).await {
+            Ok(id) => Some(id),
+            Err(failure) => {
+                record(
+                    ctx,
+                    msg,
+                    &endpoint.id,
+                    "rejected",
+                    failure.status,
+                    &failure.error,
+                    "",
+                    "",
+                )
+                .await;
+                return failure.response;
+            }
+        }
+    } else if verify_signature(&endpoint.secret, &raw, msg.header(SIGNATURE_HEADER)) {
+        None
+    } else {
+        let message = "Missing or invalid signature";
+        record(ctx, msg, &endpoint.id, "rejected", 401, message, "", "").await;
+        return err_unauthorized("Invalid webhook signature");
+    };
+    // A delivery that isn't processed may be sent again.
+    let release = || async {
+        if let Some(id) = &delivery {
+            if let Err(e) = nonces::release(ctx, &nonce_scope(&endpoint), id).await {
+                tracing::warn!(endpoint_id = %endpoint.id, "failed to release a webhook id: {e}");
+            }
+        }
+    };
+
+    // Only verified bodies are stored, and only their head.
+    let excerpt = String::from_utf8_lossy(&raw[..raw.len().min(STORED_PAYLOAD_BYTES)]);
+    let payload: serde_json::Value = match serde_json::from_slice(&raw) {
+        Ok(v @ serde_json::Value::Object(_)) => v,
+        _ => {
+            let message = "Payload must be a JSON object";
+            release().await;
+            record(ctx, msg, &endpoint.id, "failed", 400, message, &excerpt, "").await;
+            return err_bad_request(message);
+        }
+    };
+
+    let result = match endpoint.action {
+        Action::Insert => insert(ctx, &endpoint, &payload).await,
+        Action::Block => forward(ctx, msg, &endpoint, &payload).await,
+    };
+    match result {
+        Ok(record_id) => {
+            let delivery_id = record(
+                ctx,
+                msg,
+                &endpoint.id,
+                "processed",
+                200,
+                "",
+                &excerpt,
+                &record_id,
+            )
+            .await;
+            ok_json(&serde_json::json!({
+                "status": "processed",
+                "delivery_id": delivery_id,
+                "record_id": record_id,
+            }))
+        }
+        Err(failure) => {
+            release().await;
+            record(
+                ctx,
+                msg,
+                &endpoint.id,
+                "failed",
+                failure.status,
+                &failure.error,
+                &excerpt,
+                "",
+            )
+            .await;
+            failure.response
+        }
+    }
+}
+
+/// The endpoint's own per-minute limit, else the shared category (which
+/// config may disable).
+async fn endpoint_limit(ctx: &dyn Context, endpoint: &Endpoint) -> Option<RateLimit> {
+    if endpoint.rate_limit > 0 {
+        return Some(RateLimit {
+            max_requests: endpoint.rate_limit as u32,
+            window: Duration::from_secs(60),
+        });
+    }
+    RateLimit::INBOUND_WEBHOOK
+        .resolve(ctx, RATE_LIMIT_CATEGORY)
+        .await
+}
+
+fn nonce_scope(endpoint: &Endpoint) -> String {
+    format!("webhook:{}", endpoint.id)
+}
+
+/// Verify a single-delivery request's signed id and timestamp and spend
+/// the id. Returns the id.
+async fn claim_delivery(
+    ctx: &dyn Context,
+    msg: &Message,
+    endpoint: &Endpoint,
+    body: &[u8],
+) -> Result<String, Failure> {
+    let unauthorized = |error: &str| Failure {
+        status: 401,
+        error: error.to_string(),
+        response: err_unauthorized("Invalid webhook signature"),
+    };
+    let id = msg.header(ID_HEADER).trim();
+    let timestamp = msg.header(TIMESTAMP_HEADER).trim();
+    if id.is_empty() || id.len() > nonces::MAX_NONCE_LEN {
+        return Err(unauthorized("Missing or invalid delivery id"));
+    }
+    let Ok(sent) = timestamp.parse::<i64>() else {
+        return Err(unauthorized("Missing or invalid timestamp"));
+    };
+    let signed = [id.as_bytes(), b".", timestamp.as_bytes(), b".", body].concat();
+    if !verify_signature(&endpoint.secret, &signed, msg.header(SIGNATURE_HEADER)) {
+        return Err(unauthorized("Missing or invalid signature"));
+    }
+    if (chrono::Utc::now().timestamp() - sent).abs() > REPLAY_WINDOW_SECS {
+        return Err(unauthorized("Timestamp outside the replay window"));
+    }
+
+    let Some(expires_at) = chrono::DateTime::from_timestamp(sent + REPLAY_WINDOW_SECS, 0) else {
+        return Err(unauthorized("Missing or invalid timestamp"));
+    };
+    let expires_at = crate::util::format_rfc3339(expires_at);
+    match nonces::claim(ctx, &nonce_scope(endpoint), id, 1, &expires_at).await {
+        Ok(nonces::Claim::Accepted) => Ok(id.to_string()),
+        Ok(nonces::Claim::Reused) => {
+            let message = "Delivery already received";
+            Err(Failure {
+                status: 409,
+                error: format!("{message}: {id}"),
+                response: errors::error_json(ErrorCode::Conflict, message, None),
+            })
+        }
+        Err(e) => Err(Failure::internal("Database error", e)),
+    }
+}
+
+/// Check `sha256=<hex>` against the HMAC-SHA256 of `body` under `secret`.
+fn verify_signature(secret: &str, body: &[u8], header: &str) -> bool {
+    let Some(given) = header.trim().strip_prefix("sha256=") else {
+        return false;
+    };
+    if secret.is_empty() {
+        return false;
+    }
+    let expected = hex_encode(&primitives::hmac_sha256(secret.as_bytes(), body));
+    primitives::constant_time_eq(expected.as_bytes(), given.to_ascii_lowercase().as_bytes())
+}
+
+async fn insert(
+    ctx: &dyn Context,
+    endpoint: &Endpoint,
+    payload: &serde_json::Value,
+) -> Result<String, Failure> {
+    let (columns, _) = introspect_columns(ctx, &endpoint.target_table).await;
+    if columns.is_empty() {
+        return Err(Failure::internal(
+            "Webhook target is misconfigured",
+            format!("table '{}' no longer exists", endpoint.target_table),
+        ));
+    }
+    let data = map_payload(payload, &endpoint.field_map, &columns).map_err(Failure::invalid)?;
+    db::create(ctx, &endpoint.target_table, data)
+        .await
+        .map(|r| r.id)
+        .map_err(|e| Failure::internal("Failed to store payload", e))
+}
+
+/// Pick each mapped column's value out of `payload` and coerce it by the
+/// column's declared type. Returns every problem rather than the first.
+fn map_payload(
+    payload: &serde_json::Value,
+    field_map: &BTreeMap<String, String>,
+    columns: &[ColumnInfo],
+) -> Result<HashMap<String, serde_json::Value>, Vec<String>> {
+    let picks: Vec<(&ColumnInfo, Option<&serde_json::Value>)> = if field_map.is_empty() {
+        columns.iter().map(|c| (c, payload.get(&c.name))).collect()
+    } else {
+        let mut picks = Vec::with_capacity(field_map.len());
+        for (column, pointer) in field_map {
+            match columns.iter().find(|c| &c.name == column) {
+                Some(c) => picks.push((c, payload.pointer(pointer))),
+                None => return Err(vec![format!("{column}: column no longer exists")]),
+            }
+        }
+        picks
+    };
+
+    let mut data = HashMap::new();
+    let mut problems = Vec::new();
+    for (col, value) in picks {
+        let coerced = match value {
+            None | Some(serde_json::Value::Null) => continue,
+            Some(serde_json::Value::String(s)) => coerce_cell(s, &col.ty),
+            // Numbers, booleans, and (into text columns) nested JSON go
+            // through their JSON text.
+            Some(other) => coerce_cell(&other.to_string(), &col.ty),
+        };
+        match coerced {
+            Ok(v) => {
+                data.insert(col.name.clone(), v);
+            }
+            Err(e) => problems.push(format!("{}: {e}", col.name)),
+        }
+    }
+
+    let now = crate::util::now_rfc3339();
+    for stamp in ["created_at", "updated_at"] {
+        if columns.iter().any(|c| c.name == stamp) {
+            data.entry(stamp.to_string())
+                .or_insert_with(|| serde_json::json!(now));
+        }
+    }
+    problems.extend(missing_required(columns, &data));
+    if problems.is_empty() {
+        Ok(data)
+    } else {
+        Err(problems)
+    }
+}
+
+async fn forward(
+    ctx: &dyn Context,
+    msg: &Message,
+    endpoint: &Endpoint,
+    payload: &serde_json::Value,
+) -> Result<String, Failure> {
+    let resource = format!("/hooks/in/{}", endpoint.hook);
+    let mut request = crate::util::block_request("create", "POST", &resource, msg);
+    request.set_meta("req.content_type", "application/json");
+    request.set_meta(META_ENDPOINT_ID, endpoint.id.as_str());
+    request.set_meta(META_ENDPOINT_NAME, endpoint.name.as_str());
+    let body = serde_json::to_vec(payload).unwrap_or_default();
+    let out = ctx
+        .call_block(
+            &endpoint.target_block,
+            request,
+            InputStream::from_bytes(body),
+        )
+        .await;
+    let cause = match out.collect_buffered().await {
+        Ok(buf) | Err(TerminalNotResponse::Halt(buf)) => {
+            let status = buf
+                .meta
+                .iter()
+                .find(|m| m.key == "resp.status")
+                .and_then(|m| m.value.parse::<u16>().ok())
+                .unwrap_or(200);
+            if status < 400 {
+                return Ok(String::new());
+            }
+            format!("responded {status}")
+        }
+        Err(TerminalNotResponse::Error(e)) => e.message,
+        Err(other) => format!("{other:?}"),
+    };
+    Err(Failure::internal(
+        "Webhook target failed",
+        format!("{}: {cause}", endpoint.target_block),
+    ))
+}
+
+/// Store one delivery attempt and prune the endpoint's history to
+/// [`KEPT_DELIVERIES`]. Returns the delivery id, or `""` when the write
+/// failed (logged; the sender's response does not depend on it).
+#[allow(clippy::too_many_arguments)]
+async fn record(
+    ctx: &dyn Context,
+    msg: &Message,
+    endpoint_id: &str,
+    status: &str,
+    http_status: u16,
+    error: &str,
+    payload: &str,
+    record_id: &str,
+) -> String {
+    let data = crate::util::json_map(serde_json::json!({
+        "endpoint_id": endpoint_id,
+        "status": status,
+        "http_status": http_status,
+        "error": error,
+        "payload": payload,
+        "record_id": record_id,
+        "remote_addr": msg.remote_addr(),
+        "created_at": crate::util::now_rfc3339(),
+    }));
+    let id = match db::create(ctx, INBOUND_WEBHOOK_DELIVERIES_TABLE, data).await {
+        Ok(r) => r.id,
+        Err(e) => {
+            tracing::warn!(endpoint_id, "failed to record webhook delivery: {e}");
+            return String::new();
+        }
+    };
+
+    let stale = ListOptions {
+        filters: vec![eq("endpoint_id", endpoint_id)],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        offset: KEPT_DELIVERIES,
+        limit: KEPT_DELIVERIES,
+        skip_count: true,
+        ..Default::default()
+    };
+    if let Ok(page) = db::list(ctx, INBOUND_WEBHOOK_DELIVERIES_TABLE, &stale).await {
+        for r in page.records {
+            db::delete(ctx, INBOUND_WEBHOOK_DELIVERIES_TABLE, &r.id)
+                .await
+                .ok();
+        }
+    }
+    id
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{
+        admin_msg, anon_msg, output_is_error, output_json, output_status, TestContext,
+    };
+
+    const LEADS: &str = "acme__crm__leads";
+
+    fn body(v: serde_json::Value) -> InputStream {
+        InputStream::from_bytes(serde_json::to_vec(&v).unwrap())
+    }
+
+    async fn ctx_with_leads(config: &[(&str, &str)]) -> TestContext {
+        let mut ctx = TestContext::with_auth().await;
+        for (k, v) in config {
+            ctx.set_config(k, v);
+        }
+        db::exec_raw(
+            &ctx,
+            &format!(
+                "CREATE TABLE {LEADS} (id TEXT PRIMARY KEY, email TEXT NOT NULL, \
+                 score INTEGER, created_at TEXT, updated_at TEXT)"
+            ),
+            &[],
+        )
+        .await
+        .unwrap();
+        ctx
+    }
+
+    /// Create an endpoint through the admin API; returns `(id, secret)`.
+    async fn create(ctx: &TestContext, v: serde_json::Value) -> (String, String) {
+        let msg = admin_msg("create", "/b/admin/api/inbound-webhooks");
+        let out = handle(ctx, &msg, "/admin/inbound-webhooks", body(v)).await;
+        let created = output_json(out).await;
+        (
+            created["id"].as_str().unwrap().to_string(),
+            created["secret"].as_str().unwrap().to_string(),
+        )
+    }
+
+    async fn post(
+        ctx: &TestContext,
+        limiter: &UserRateLimiter,
+        id: &str,
+        raw: &[u8],
+        signature: &str,
+    ) -> OutputStream {
+        let mut msg = anon_msg("create", &format!("/b/hooks/in/{id}"));
+        msg.set_meta("http.header.x-webhook-signature", signature);
+        receive(
+            ctx,
+            &msg,
+            limiter,
+            id,
+            InputStream::from_bytes(raw.to_vec()),
+        )
+        .await
+    }
+
+    fn sign(secret: &str, raw: &[u8]) -> String {
+        format!(
+            "sha256={}",
+            hex_encode(&primitives::hmac_sha256(secret.as_bytes(), raw))
+        )
+    }
+
+    async fn deliveries(ctx: &TestContext, id: &str) -> Vec<serde_json::Value> {
+        let path = format!("/admin/inbound-webhooks/{id}/deliveries");
+        let msg = admin_msg(
+            "retrieve",
+            &format!("/b/admin/api/inbound-webhooks/{id}/deliveries"),
+        );
+        let out = handle(ctx, &msg, &path, InputStream::empty()).await;
+        output_json(out).await["deliveries"]
+            .as_array()
+            .unwrap()
+            .clone()
+    }
+
+    #[tokio::test]
+    async fn signed_delivery_is_mapped_and_inserted() {
+        let ctx = ctx_with_leads(&[]).await;
+        let (id, secret) = create(
+            &ctx,
+            serde_json::json!({
+                "name": "zapier",
+                "action": "insert",
+                "target_table": LEADS,
+                "field_map": { "email": "/contact/email", "score": "score" },
+            }),
+        )
+        .await;
+
+        let msg = admin_msg("retrieve", "/b/admin/api/inbound-webhooks");
+        let listed =
+            output_json(handle(&ctx, &msg, "/admin/inbound-webhooks", InputStream::empty()).await)
+                .await;
+        let listed = &listed["endpoints"][0];
+        assert_eq!(listed["name"], "zapier");
+        assert_eq!(listed["url"], format!("/b/hooks/in/{id}"));
+        assert!(listed.get("secret").is_none());
+
+        let raw = br#"{"contact":{"email":"a@example.com"},"score":"7","extra":true}"#;
+        let limiter = UserRateLimiter::new();
+        let out = post(&ctx, &limiter, &id, raw, &sign(&secret, raw)).await;
+        let result = output_json(out).await;
+        assert_eq!(result["status"], "processed");
+        let record_id = result["record_id"].as_str().unwrap();
+
+        let row = db::get(&ctx, LEADS, record_id).await.unwrap();
+        assert_eq!(row.str_field("email"), "a@example.com");
+        assert_eq!(row.i64_field("score"), 7);
+        assert!(!row.str_field("created_at").is_empty());
+
+        let log = deliveries(&ctx, &id).await;
+        assert_eq!(log.len(), 1);
+        assert_eq!(log[0]["status"], "processed");
+        assert_eq!(log[0]["record_id"], record_id);
+    }
+
+    #[tokio::test]
+    async fn bad_signature_is_rejected_and_recorded() {
+        let ctx = ctx_with_leads(&[]).await;
+        let (id, _) = create(
+            &ctx,
+            serde_json::json!({ "name": "ci", "action": "insert", "target_table": LEADS }),
+        )
+        .await;
+
+        let raw = br#"{"email":"a@example.com"}"#;
+        let limiter = UserRateLimiter::new();
+        let out = post(&ctx, &limiter, &id, raw, &sign("wrong", raw)).await;
+        assert!(output_is_error(out, "Unauthenticated").await);
+        let out = post(&ctx, &limiter, &id, raw, "").await;
+        assert!(output_is_error(out, "Unauthenticated").await);
+
+        let log = deliveries(&ctx, &id).await;
+        assert_eq!(log.len(), 2);
+        assert!(log.iter().all(|d| d["status"] == "rejected"));
+        // An unverified body is never stored.
+        assert!(log.iter().all(|d| d["payload"] == ""));
+        let rows = db::list_all(&ctx, LEADS, vec![]).await.unwrap();
+        assert!(rows.is_empty());
+    }
+
+    #[tokio::test]
+    async fn invalid_payload_records_the_error() {
+        let ctx = ctx_with_leads(&[]).await;
+        let (id, secret) = create(
+            &ctx,
+            serde_json::json!({ "name": "ci", "action": "insert", "target_table": LEADS }),
+        )
+        .await;
+
+        let raw = br#"{"score":"high"}"#;
+        let limiter = UserRateLimiter::new();
+        let out = post(&ctx, &limiter, &id, raw, &sign(&secret, raw)).await;
+        assert_eq!(output_status(out).await, 400);
+
+        let log = deliveries(&ctx, &id).await;
+        assert_eq!(log[0]["status"], "failed");
+        let error = log[0]["error"].as_str().unwrap();
+        assert!(error.contains("score: 'high' is not an integer"), "{error}");
+        assert!(error.contains("email: value is required"), "{error}");
+        assert_eq!(log[0]["payload"], r#"{"score":"high"}"#);
+    }
+
+    #[tokio::test]
+    async fn single_delivery_endpoints_refuse_replays() {
+        let ctx = ctx_with_leads(&[]).await;
+        let (id, secret) = create(
+            &ctx,
+            serde_json::json!({
+                "name": "payments",
+                "action": "insert",
+                "target_table": LEADS,
+                "single_delivery": true,
+            }),
+        )
+        .await;
+        let limiter = UserRateLimiter::new();
+        let send = |delivery: &str, sent: i64, raw: &[u8], signed: &[u8]| {
+            let mut msg = anon_msg("create", &format!("/b/hooks/in/{id}"));
+            msg.set_meta("http.header.x-webhook-id", delivery);
+            msg.set_meta("http.header.x-webhook-timestamp", sent.to_string());
+            msg.set_meta("http.header.x-webhook-signature", sign(&secret, signed));
+            (msg, InputStream::from_bytes(raw.to_vec()))
+        };
+        let signed = |delivery: &str, sent: i64, raw: &[u8]| {
+            [
+                delivery.as_bytes(),
+                b".",
+                sent.to_string().as_bytes(),
+                b".",
+                raw,
+            ]
+            .concat()
+        };
+        let (ctx, limiter, endpoint_id) = (&ctx, &limiter, id.as_str());
+        let status = move |(msg, input): (Message, InputStream)| async move {
+            output_status(receive(ctx, &msg, limiter, endpoint_id, input).await).await
+        };
+        let now = chrono::Utc::now().timestamp();
+        let raw = br#"{"email":"a@example.com"}"#;
+
+        let first = send("d1", now, raw, &signed("d1", now, raw));
+        assert_eq!(status(first).await, 200);
+        let replay = send("d1", now, raw, &signed("d1", now, raw));
+        assert_eq!(status(replay).await, 409);
+        // The body alone is not enough, and old deliveries are refused.
+        assert_eq!(status(send("d2", now, raw, raw)).await, 401);
+        let old = now - REPLAY_WINDOW_SECS - 60;
+        let stale = send("d3", old, raw, &signed("d3", old, raw));
+        assert_eq!(status(stale).await, 401);
+        // A delivery that failed may be retried with its id.
+        let bad = br#"{"score":"high"}"#;
+        for _ in 0..2 {
+            let failing = send("d4", now, bad, &signed("d4", now, bad));
+            assert_eq!(status(failing).await, 400);
+        }
+
+        let log = deliveries(ctx, &id).await;
+        assert_eq!(log.len(), 6);
+        let replay = log.iter().find(|d| d["http_status"] == 409).unwrap();
+        assert_eq!(replay["status"], "rejected");
+        assert_eq!(db::list_all(ctx, LEADS, vec![]).await.unwrap().len(), 1);
+    }
+
+    #[tokio::test]
+    async fn oversized_payload_is_rejected() {
+        let ctx = ctx_with_leads(&[(MAX_PAYLOAD_BYTES_KEY, "16")]).await;
+        let (id, secret) = create(
+            &ctx,
+            serde_json::json!({ "name": "ci", "action": "insert", "target_table": LEADS }),
+        )
+        .await;
+
+        let raw = br#"{"email":"someone@example.com"}"#;
+        let limiter = UserRateLimiter::new();
+        let out = post(&ctx, &limiter, &id, raw, &sign(&secret, raw)).await;
+        assert_eq!(output_status(out).await, 413);
+        assert_eq!(deliveries(&ctx, &id).await[0]["http_status"], 413);
+    }
+
+    #[tokio::test]
+    async fn endpoint_rate_limit_applies_per_endpoint() {
+        let ctx = ctx_with_leads(&[]).await;
+        let (id, secret) = create(
+            &ctx,
+            serde_json::json!({
+                "name": "ci",
+                "action": "insert",
+                "target_table": LEADS,
+                "rate_limit": 1,
+            }),
+        )
+        .await;
+
+        let raw = br#"{"email":"a@example.com"}"#;
+        let limiter = UserRateLimiter::new();
+        let out = post(&ctx, &limiter, &id, raw, &sign(&secret, raw)).await;
+        assert_eq!(output_status(out).await, 200);
+        let out = post(&ctx, &limiter, &id, raw, &sign(&secret, raw)).await;
+        assert!(output_is_error(out, "ResourceExhausted").await);
+        assert_eq!(deliveries(&ctx, &id).await.len(), 1);
+    }
+
+    #[tokio::test]
+    async fn disabled_and_rotated_endpoints() {
+        let ctx = ctx_with_leads(&[]).await;
+        let (id, old_secret) = create(
+            &ctx,
+            serde_json::json!({ "name": "ci", "action": "insert", "target_table": LEADS }),
+        )
+        .await;
+        let path = format!("/admin/inbound-webhooks/{id}");
+        let msg = admin_msg("update", &format!("/b/admin/api/inbound-webhooks/{id}"));
+
+        let out = handle(
+            &ctx,
+            &msg,
+            &path,
+            body(serde_json::json!({ "rotate_secret": true })),
+        );
+        let rotated = output_json(out.await).await;
+        let new_secret = rotated["secret"].as_str().unwrap();
+        assert_ne!(new_secret, old_secret);
+
+        let raw = br#"{"email":"a@example.com"}"#;
+        let limiter = UserRateLimiter::new();
+        let out = post(&ctx, &limiter, &id, raw, &sign(&old_secret, raw)).await;
+        assert!(output_is_error(out, "Unauthenticated").await);
+
+        let out = handle(
+            &ctx,
+            &msg,
+            &path,
+            body(serde_json::json!({ "enabled": false })),
+        );
+        assert_eq!(output_status(out.await).await, 200);
+        let out = post(&ctx, &limiter, &id, raw, &sign(new_secret, raw)).await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+
+    #[tokio::test]
+    async fn create_rejects_reserved_and_unknown_targets() {
+        let ctx = ctx_with_leads(&[]).await;
+        let msg = admin_msg("create", "/b/admin/api/inbound-webhooks");
+        for bad in [
+            serde_json::json!({ "name": "a", "action": "insert",
+                "target_table": "suppers_ai__auth__users" }),
+            serde_json::json!({ "name": "a", "action": "insert", "target_table": "nope" }),
+            serde_json::json!({ "name": "a", "action": "insert", "target_table": LEADS,
+                "field_map": { "missing": "/x" } }),
+            serde_json::json!({ "name": "a", "action": "block",
+                "target_block": "acme/unregistered", "hook": "lead" }),
+            serde_json::json!({ "name": "a", "action": "block",
+                "target_block": "suppers-ai/auth", "hook": "lead" }),
+            serde_json::json!({ "name": "a", "action": "other" }),
+        ] {
+            let out = handle(&ctx, &msg, "/admin/inbound-webhooks", body(bad.clone())).await;
+            assert_eq!(output_status(out).await, 400, "{bad}");
+        }
+
+        create(
+            &ctx,
+            serde_json::json!({ "name": "dup", "action": "insert", "target_table": LEADS }),
+        )
+        .await;
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/inbound-webhooks",
+            body(serde_json::json!({ "name": "dup", "action": "insert", "target_table": LEADS })),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 400);
+    }
+
+    #[tokio::test]
+    async fn delete_removes_endpoint_and_deliveries() {
+        let ctx = ctx_with_leads(&[]).await;
+        let (id, secret) = create(
+            &ctx,
+            serde_json::json!({ "name": "ci", "action": "insert", "target_table": LEADS }),
+        )
+        .await;
+        let raw = br#"{"email":"a@example.com"}"#;
+        let limiter = UserRateLimiter::new();
+        let out = post(&ctx, &limiter, &id, raw, &sign(&secret, raw)).await;
+        assert_eq!(output_status(out).await, 200);
+
+        let path = format!("/admin/inbound-webhooks/{id}");
+        let msg = admin_msg("delete", &format!("/b/admin/api/inbound-webhooks/{id}"));
+        let out = handle(&ctx, &msg, &path, InputStream::empty()).await;
+        assert_eq!(output_status(out).await, 200);
+        let left = db::list_all(
+            &ctx,
+            INBOUND_WEBHOOK_DELIVERIES_TABLE,
+            vec![eq("endpoint_id", &id)],
+        )
+        .await
+        .unwrap();
+        assert!(left.is_empty());
+        let out = post(&ctx, &limiter, &id, raw, &sign(&secret, raw)).await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+
+    #[test]
+    fn signature_requires_the_sha256_prefix() {
+        let raw = b"{}";
+        let sig = hex_encode(&primitives::hmac_sha256(b"s3cret", raw));
+        assert!(verify_signature("s3cret", raw, &format!("sha256={sig}")));
+        assert!(verify_signature(
+            "s3cret",
+            raw,
+            &format!("sha256={}", sig.to_uppercase())
+        ));
+        assert!(!verify_signature("s3cret", raw, &sig));
+        assert!(!verify_signature("", raw, "sha256="));
+    }
+}
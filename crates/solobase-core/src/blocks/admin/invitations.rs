@@ -0,0 +1,390 @@
+//! Signup invitations. Backed by the auth block's
+//! `suppers_ai__auth__invitations` (see
+//! [`crate::blocks::auth::repo::invitations`]); the token is redeemed by
+//! `POST /b/auth/api/signup`, which `SUPPERS_AI__AUTH__SIGNUP_MODE=invite-only`
+//! makes mandatory.
+
+use wafer_block_crypto::primitives;
+use wafer_core::clients::config;
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::logs::audit_log;
+use crate::{
+    blocks::{
+        auth::{
+            config::INVITATION_TTL_DAYS_DEFAULT,
+            repo::{invitations, users},
+        },
+        errors, permissions,
+    },
+    http::{err_bad_request, err_conflict, err_forbidden, err_internal, err_not_found, ok_json},
+    services::Services,
+    util::{format_rfc3339, hex_encode, now_rfc3339, sha256_hex},
+};
+
+/// Most invitations one `GET /admin/invitations` returns.
+const MAX_LIST: i64 = 500;
+/// Longest expiry an admin may set, in days.
+const MAX_TTL_DAYS: i64 = 90;
+
+/// `path` is the normalized `/admin/invitations...` sub-path, passed
+/// explicitly (no `req.resource` rewrite).
+///
+/// - `GET /admin/invitations` — newest first; `?status=` is one of
+///   `pending`, `accepted`, `revoked`, `expired`.
+/// - `POST /admin/invitations` — invite `email` with an optional `role`
+///   (default `user`) and `expires_in_days`. The signup link is emailed
+///   unless `send_email` is `false`, and returned either way.
+/// - `DELETE /admin/invitations/{id}` — revoke a pending invitation.
+pub async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    path: &str,
+    input: InputStream,
+) -> OutputStream {
+    let id = path
+        .strip_prefix("/admin/invitations/")
+        .filter(|id| !id.is_empty() && !id.contains('/'));
+
+    match (msg.action(), path, id) {
+        ("retrieve", "/admin/invitations", _) => handle_list(ctx, msg).await,
+        ("create", "/admin/invitations", _) => handle_create(ctx, msg, input).await,
+        ("delete", _, Some(id)) => handle_revoke(ctx, msg, id).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+fn with_status(row: &invitations::InvitationRow, now: &str) -> serde_json::Value {
+    let mut v = serde_json::json!(row);
+    v["status"] = serde_json::json!(row.effective_status(now));
+    v
+}
+
+async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let status = Some(msg.query("status")).filter(|s| !s.is_empty());
+    if let Some(status) = status {
+        if ![
+            invitations::STATUS_PENDING,
+            invitations::STATUS_ACCEPTED,
+            invitations::STATUS_REVOKED,
+            invitations::STATUS_EXPIRED,
+        ]
+        .contains(&status)
+        {
+            return err_bad_request(&format!("unknown invitation status: {status}"));
+        }
+    }
+    let limit = msg
+        .query("limit")
+        .parse::<i64>()
+        .unwrap_or(100)
+        .clamp(1, MAX_LIST);
+    match invitations::list(ctx, status, limit).await {
+        Ok(rows) => {
+            let now = now_rfc3339();
+            let items: Vec<serde_json::Value> = rows.iter().map(|r| with_status(r, &now)).collect();
+            ok_json(&serde_json::json!({ "invitations": items }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[derive(serde::Deserialize)]
+struct CreateReq {
+    email: String,
+    #[serde(default)]
+    role: Option<String>,
+    #[serde(default)]
+    expires_in_days: Option<i64>,
+    #[serde(default)]
+    send_email: Option<bool>,
+}
+
+/// A role name: 1–64 of `[a-z0-9_-]`.
+pub(super) fn is_valid_role(role: &str) -> bool {
+    !role.is_empty()
+        && role.len() <= 64
+        && role
+            .bytes()
+            .all(|b| b.is_ascii_lowercase() || b.is_ascii_digit() || b == b'_' || b == b'-')
+}
+
+async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let body: CreateReq = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+
+    let email = body.email.trim().to_lowercase();
+    let role = body.role.as_deref().map(str::trim).unwrap_or("user");
+    let days = body.expires_in_days.unwrap_or(INVITATION_TTL_DAYS_DEFAULT);
+    let mut fields: Vec<(&str, String)> = Vec::new();
+    let email_ok = email.len() <= 255
+        && email
+            .split_once('@')
+            .is_some_and(|(local, domain)| !local.is_empty() && domain.contains('.'));
+    if !email_ok {
+        fields.push(("email", "must be a valid email address".to_string()));
+    }
+    if !is_valid_role(role) {
+        fields.push(("role", "must be 1-64 of a-z, 0-9, '_' or '-'".to_string()));
+    }
+    if !(1..=MAX_TTL_DAYS).contains(&days) {
+        fields.push((
+            "expires_in_days",
+            format!("must be between 1 and {MAX_TTL_DAYS}"),
+        ));
+    }
+    if !fields.is_empty() {
+        let fields: Vec<(&str, &str)> = fields.iter().map(|(f, r)| (*f, r.as_str())).collect();
+        return errors::validation_error("Invalid invitation", &fields);
+    }
+    // A scoped admin can't invite someone into more than they hold.
+    match permissions::covers(ctx, msg, &[role]).await {
+        Ok(true) => {}
+        Ok(false) => {
+            return err_forbidden("Cannot invite into a role with permissions you don't hold")
+        }
+        Err(e) => return err_internal("Database error", e),
+    }
+
+    match users::find_by_email(ctx, &email).await {
+        Ok(Some(_)) => return err_conflict("A user with this email already exists"),
+        Ok(None) => {}
+        Err(e) => return err_internal("User lookup failed", e),
+    }
+
+    let token = match primitives::random_bytes(32) {
+        Ok(bytes) => hex_encode(&bytes),
+        Err(e) => return err_internal("Failed to generate invitation token", e),
+    };
+    let expires_at = format_rfc3339(chrono::Utc::now() + chrono::Duration::days(days));
+    let row = match invitations::insert(
+        ctx,
+        invitations::NewInvitation {
+            email: &email,
+            token_hash: &sha256_hex(token.as_bytes()),
+            role,
+            invited_by: msg.user_id(),
+            expires_at: &expires_at,
+        },
+    )
+    .await
+    {
+        Ok(row) => row,
+        Err(e) => return err_internal("Database error", e),
+    };
+
+    let emailed = if body.send_email.unwrap_or(true) {
+        Services::new(ctx, super::ADMIN_BLOCK_ID)
+            .mailer()
+            .send_template("invitation", &email, &token)
+            .await
+    } else {
+        false
+    };
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "invitations.create",
+        &format!("invitation:{}", row.id),
+        msg.remote_addr(),
+    )
+    .await;
+
+    let base_url = config::get_default(
+        ctx,
+        "SOLOBASE_SHARED__FRONTEND_URL",
+        "http://localhost:5173",
+    )
+    .await;
+    let mut resp = with_status(&row, &now_rfc3339());
+    resp["invite_url"] = serde_json::json!(format!(
+        "{}/b/auth/signup?invite={}",
+        crate::base_path::public_url(&base_url),
+        crate::util::urlencode(&token)
+    ));
+    resp["emailed"] = serde_json::json!(emailed);
+    ok_json(&resp)
+}
+
+async fn handle_revoke(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    match invitations::revoke(ctx, id).await {
+        Ok(true) => {}
+        Ok(false) => {
+            return match invitations::find_by_id(ctx, id).await {
+                Ok(Some(_)) => err_conflict("Invitation is no longer pending"),
+                Ok(None) => err_not_found("Invitation not found"),
+                Err(e) => err_internal("Database error", e),
+            }
+        }
+        Err(e) => return err_internal("Database error", e),
+    }
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "invitations.revoke",
+        &format!("invitation:{id}"),
+        msg.remote_addr(),
+    )
+    .await;
+    ok_json(&serde_json::json!({ "revoked": true }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{
+        admin_msg, output_is_error, output_json, output_status, TestContext,
+    };
+
+    fn body(v: serde_json::Value) -> InputStream {
+        InputStream::from_bytes(serde_json::to_vec(&v).unwrap())
+    }
+
+    async fn create(ctx: &TestContext, v: serde_json::Value) -> OutputStream {
+        let msg = admin_msg("create", "/b/admin/api/invitations");
+        handle(ctx, &msg, "/admin/invitations", body(v)).await
+    }
+
+    #[tokio::test]
+    async fn create_returns_a_redeemable_link_and_lists_pending() {
+        let ctx = TestContext::with_auth().await;
+        let out = create(
+            &ctx,
+            serde_json::json!({"email": "New@Example.com", "role": "editor", "send_email": false}),
+        )
+        .await;
+        let created = output_json(out).await;
+        assert_eq!(created["email"], "new@example.com");
+        assert_eq!(created["role"], "editor");
+        assert_eq!(created["status"], "pending");
+        assert_eq!(created["emailed"], false);
+        assert!(created.get("token_hash").is_none());
+        let url = created["invite_url"].as_str().unwrap();
+        let token = url.rsplit_once("invite=").unwrap().1;
+
+        let row = invitations::consume(&ctx, &sha256_hex(token.as_bytes()), "new@example.com")
+            .await
+            .unwrap();
+        assert!(
+            row.is_some(),
+            "the returned link must redeem the invitation"
+        );
+
+        let mut msg = admin_msg("retrieve", "/b/admin/api/invitations");
+        msg.set_meta("req.query.status", "accepted");
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/invitations",
+            body(serde_json::json!({})),
+        )
+        .await;
+        let listed = output_json(out).await;
+        assert_eq!(listed["invitations"].as_array().unwrap().len(), 1);
+
+        msg.set_meta("req.query.status", "bogus");
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/invitations",
+            body(serde_json::json!({})),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 400);
+    }
+
+    #[tokio::test]
+    async fn create_validates_fields() {
+        let ctx = TestContext::with_auth().await;
+        let out = create(
+            &ctx,
+            serde_json::json!({"email": "nope", "role": "Bad Role", "expires_in_days": 0}),
+        )
+        .await;
+        let resp = output_json(out).await;
+        assert_eq!(resp["code"], "validation_failed");
+        for field in ["email", "role", "expires_in_days"] {
+            assert!(
+                resp["details"].get(field).is_some(),
+                "{field} flagged: {resp}"
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn existing_users_cannot_be_invited() {
+        let ctx = TestContext::with_auth().await;
+        users::insert(
+            &ctx,
+            users::NewUser {
+                email: "taken@example.com".into(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap();
+        let out = create(&ctx, serde_json::json!({"email": "taken@example.com"})).await;
+        assert!(output_is_error(out, "AlreadyExists").await);
+    }
+
+    #[tokio::test]
+    async fn scoped_admins_only_invite_into_roles_they_cover() {
+        use wafer_core::clients::database as db;
+
+        let ctx = TestContext::with_auth().await;
+        for (name, held) in [
+            ("user_admin", "users.manage"),
+            ("billing_admin", "billing.manage"),
+        ] {
+            let mut data = crate::util::json_map(serde_json::json!({
+                "name": name,
+                "permissions": [held],
+            }));
+            crate::util::stamp_created(&mut data);
+            db::create(&ctx, super::super::ROLES_TABLE, data)
+                .await
+                .unwrap();
+        }
+        let ctx = &ctx;
+        let invite = |email: &str, role: &str| {
+            let mut msg = crate::test_support::auth_msg("create", "/b/admin/api/invitations", "s1");
+            msg.set_meta("auth.user_roles", "user_admin");
+            let input =
+                body(serde_json::json!({"email": email, "role": role, "send_email": false}));
+            async move { output_status(handle(ctx, &msg, "/admin/invitations", input).await).await }
+        };
+
+        assert_eq!(invite("a@example.com", "user").await, 200);
+        assert_eq!(invite("b@example.com", "user_admin").await, 200);
+        assert_eq!(invite("c@example.com", "billing_admin").await, 403);
+        assert_eq!(invite("d@example.com", "admin").await, 403);
+    }
+
+    #[tokio::test]
+    async fn revoke_only_applies_to_pending_invitations() {
+        let ctx = TestContext::with_auth().await;
+        let out = create(
+            &ctx,
+            serde_json::json!({"email": "a@example.com", "send_email": false}),
+        );
+        let created = output_json(out.await).await;
+        let id = created["id"].as_str().unwrap().to_string();
+        let path = format!("/admin/invitations/{id}");
+        let msg = admin_msg("delete", &format!("/b/admin/api/invitations/{id}"));
+
+        let out = handle(&ctx, &msg, &path, body(serde_json::json!({}))).await;
+        assert_eq!(output_json(out).await["revoked"], true);
+        let out = handle(&ctx, &msg, &path, body(serde_json::json!({}))).await;
+        assert!(output_is_error(out, "AlreadyExists").await);
+
+        let msg = admin_msg("delete", "/b/admin/api/invitations/missing");
+        let path = "/admin/invitations/missing";
+        let out = handle(&ctx, &msg, path, body(serde_json::json!({}))).await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+}
@@ -0,0 +1,259 @@
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::logs::audit_log;
+use crate::{
+    blocks::{
+        jobs::{self, RetryError},
+        schedules::{self, TriggerError},
+    },
+    http::{err_bad_request, err_conflict, err_internal, err_not_found, ok_json},
+};
+
+/// Background jobs (see [`crate::blocks::jobs`]).
+pub(crate) const JOBS_TABLE: &str = "suppers_ai__admin__jobs";
+/// Recurring job schedule state (see [`crate::blocks::schedules`]).
+pub(crate) const SCHEDULES_TABLE: &str = "suppers_ai__admin__schedules";
+
+/// Most jobs one `GET /admin/jobs` returns.
+const MAX_LIST: i64 = 500;
+/// Most due jobs one `POST /admin/jobs/run` drains.
+const MAX_RUN: i64 = 50;
+
+/// `path` is the normalized `/admin/jobs...` sub-path, passed explicitly
+/// (no `req.resource` rewrite).
+///
+/// - `GET /admin/jobs` — recent jobs, newest first; `?status=`, `?type=`
+///   and `?limit=` narrow the list.
+/// - `POST /admin/jobs/run` — run due jobs now and queue the schedules
+///   that are due. The only way jobs and schedules run on platforms
+///   without a worker besides the drain after each enqueue.
+/// - `POST /admin/jobs/{id}/retry` — requeue a dead job.
+/// - `GET /admin/jobs/schedules` — every recurring schedule with its next
+///   and last run.
+/// - `POST /admin/jobs/schedules/{name}/{run,pause,resume}` — queue a
+///   schedule's job now, or stop and restart its runs.
+pub async fn handle(ctx: &dyn Context, msg: &Message, path: &str) -> OutputStream {
+    let retry_id = path
+        .strip_prefix("/admin/jobs/")
+        .and_then(|rest| rest.strip_suffix("/retry"))
+        .filter(|id| !id.is_empty() && !id.contains('/'));
+    let schedule_op = path
+        .strip_prefix("/admin/jobs/schedules/")
+        .and_then(|rest| rest.split_once('/'))
+        .filter(|(name, op)| !name.is_empty() && !op.contains('/'));
+
+    match (msg.action(), path, schedule_op, retry_id) {
+        ("retrieve", "/admin/jobs", _, _) => handle_list(ctx, msg).await,
+        ("create", "/admin/jobs/run", _, _) => handle_run(ctx, msg).await,
+        ("retrieve", "/admin/jobs/schedules", _, _) => handle_list_schedules(ctx).await,
+        ("create", _, Some((name, "run")), _) => handle_trigger(ctx, msg, name).await,
+        ("create", _, Some((name, "pause")), _) => handle_pause(ctx, msg, name, true).await,
+        ("create", _, Some((name, "resume")), _) => handle_pause(ctx, msg, name, false).await,
+        ("create", _, None, Some(id)) => handle_retry(ctx, msg, id).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let status = Some(msg.query("status")).filter(|s| !s.is_empty());
+    if let Some(status) = status {
+        if ![
+            jobs::STATUS_PENDING,
+            jobs::STATUS_RUNNING,
+            jobs::STATUS_SUCCEEDED,
+            jobs::STATUS_DEAD,
+        ]
+        .contains(&status)
+        {
+            return err_bad_request(&format!("unknown job status: {status}"));
+        }
+    }
+    let job_type = Some(msg.query("type")).filter(|s| !s.is_empty());
+    let limit = msg
+        .query("limit")
+        .parse::<i64>()
+        .unwrap_or(100)
+        .clamp(1, MAX_LIST);
+    match jobs::recent(ctx, status, job_type, limit).await {
+        Ok(list) => ok_json(&serde_json::json!({ "jobs": list })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_run(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let succeeded = jobs::run_due(ctx, MAX_RUN).await;
+    schedules::run_if_due(ctx).await;
+    audit_log(ctx, msg.user_id(), "jobs.run", "jobs", msg.remote_addr()).await;
+    ok_json(&serde_json::json!({ "succeeded": succeeded }))
+}
+
+async fn handle_retry(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    match jobs::retry(ctx, id).await {
+        Ok(job) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "jobs.retry",
+                &format!("job:{id}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&job)
+        }
+        Err(RetryError::NotFound) => err_not_found("Job not found"),
+        Err(RetryError::NotDead) => err_conflict("Only dead jobs can be retried"),
+        Err(RetryError::Db(e)) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_list_schedules(ctx: &dyn Context) -> OutputStream {
+    match schedules::list(ctx).await {
+        Ok(list) => ok_json(&serde_json::json!({ "schedules": list })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_trigger(ctx: &dyn Context, msg: &Message, name: &str) -> OutputStream {
+    match schedules::trigger(ctx, name).await {
+        Ok(job_id) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "jobs.schedule.run",
+                &format!("schedule:{name}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&serde_json::json!({ "schedule": name, "job_id": job_id }))
+        }
+        Err(TriggerError::NotFound) => err_not_found("Schedule not found"),
+        Err(TriggerError::Running) => {
+            err_conflict("The schedule's previous run is still pending or running")
+        }
+        Err(TriggerError::Db(e)) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_pause(ctx: &dyn Context, msg: &Message, name: &str, paused: bool) -> OutputStream {
+    match schedules::set_paused(ctx, name, paused).await {
+        Ok(Some(status)) => {
+            let action = if paused {
+                "jobs.schedule.pause"
+            } else {
+                "jobs.schedule.resume"
+            };
+            audit_log(
+                ctx,
+                msg.user_id(),
+                action,
+                &format!("schedule:{name}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&status)
+        }
+        Ok(None) => err_not_found("Schedule not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{admin_msg, output_is_error, output_json, TestContext};
+
+    async fn seed(ctx: &TestContext, status: &str, job_type: &str) -> String {
+        let now = crate::util::now_rfc3339();
+        wafer_core::clients::database::create(
+            ctx,
+            JOBS_TABLE,
+            crate::util::json_map(serde_json::json!({
+                "job_type": job_type,
+                "block": "test/missing",
+                "payload": "{}",
+                "status": status,
+                "attempts": 5,
+                "max_attempts": 5,
+                "next_run_at": &now,
+                "last_error": "boom",
+                "created_at": &now,
+                "updated_at": &now,
+            })),
+        )
+        .await
+        .unwrap()
+        .id
+    }
+
+    #[tokio::test]
+    async fn list_filters_and_rejects_unknown_status() {
+        let ctx = TestContext::with_auth().await;
+        seed(&ctx, jobs::STATUS_DEAD, "files.quota.notify").await;
+        seed(&ctx, jobs::STATUS_SUCCEEDED, "files.lifecycle.run").await;
+
+        let mut msg = admin_msg("retrieve", "/b/admin/api/jobs");
+        msg.set_meta("req.query.status", "dead");
+        let listed = output_json(handle(&ctx, &msg, "/admin/jobs").await).await;
+        let listed = listed["jobs"].as_array().unwrap();
+        assert_eq!(listed.len(), 1);
+        assert_eq!(listed[0]["job_type"], "files.quota.notify");
+        assert_eq!(listed[0]["last_error"], "boom");
+
+        msg.set_meta("req.query.status", "stuck");
+        let out = handle(&ctx, &msg, "/admin/jobs").await;
+        assert!(output_is_error(out, "InvalidArgument").await);
+    }
+
+    #[tokio::test]
+    async fn retry_requeues_only_dead_jobs() {
+        let ctx = TestContext::with_auth().await;
+        let dead = seed(&ctx, jobs::STATUS_DEAD, "files.quota.notify").await;
+        let done = seed(&ctx, jobs::STATUS_SUCCEEDED, "files.quota.notify").await;
+
+        let path = format!("/admin/jobs/{dead}/retry");
+        let msg = admin_msg("create", &format!("/b/admin/api/jobs/{dead}/retry"));
+        let job = output_json(handle(&ctx, &msg, &path).await).await;
+        assert_eq!(job["status"], jobs::STATUS_PENDING);
+        assert_eq!(job["attempts"], 0);
+
+        let path = format!("/admin/jobs/{done}/retry");
+        let out = handle(&ctx, &msg, &path).await;
+        assert!(output_is_error(out, "AlreadyExists").await);
+
+        let out = handle(&ctx, &msg, "/admin/jobs/missing/retry").await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+
+    #[tokio::test]
+    async fn schedules_list_pause_and_resume() {
+        let ctx = TestContext::with_admin().await;
+        let name = crate::blocks::retention::SCHEDULE;
+
+        let msg = admin_msg("retrieve", "/b/admin/api/jobs/schedules");
+        let listed = output_json(handle(&ctx, &msg, "/admin/jobs/schedules").await).await;
+        assert!(listed["schedules"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .any(|s| s["name"] == name && s["paused"] == false));
+
+        let path = format!("/admin/jobs/schedules/{name}/pause");
+        let msg = admin_msg(
+            "create",
+            &format!("/b/admin/api/jobs/schedules/{name}/pause"),
+        );
+        let paused = output_json(handle(&ctx, &msg, &path).await).await;
+        assert_eq!(paused["paused"], true);
+
+        let path = format!("/admin/jobs/schedules/{name}/resume");
+        let resumed = output_json(handle(&ctx, &msg, &path).await).await;
+        assert_eq!(resumed["paused"], false);
+
+        let out = handle(&ctx, &msg, "/admin/jobs/schedules/nope/pause").await;
+        assert!(output_is_error(out, "NotFound").await);
+        let out = handle(&ctx, &msg, "/admin/jobs/schedules/nope/run").await;
+        assert!(output_is_error(out, "NotFound").await);
+        let out = handle(&ctx, &msg, &format!("/admin/jobs/schedules/{name}/explode")).await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+}
@@ -1,8 +1,13 @@
 use wafer_block::db::{Filter, FilterOp, SortField};
 use wafer_core::clients::database as db;
-use wafer_run::{context::Context, Message, OutputStream};
+use wafer_run::{context::Context, HttpMethod, Message, OutputStream};
 
-use crate::http::{err_internal, err_not_found, ok_json};
+use crate::{
+    blocks::auth::repo::users,
+    endpoint_match::EndpointRoute,
+    http::{err_internal, err_not_found, ok_json},
+    pagination::{self, ListSpec},
+};
 
 /// Audit log entries (admin-initiated mutations).
 pub(crate) const AUDIT_LOGS_TABLE: &str = "suppers_ai__admin__audit_logs";
@@ -16,6 +21,13 @@ pub(crate) use crate::admin_schema::REQUEST_LOGS_TABLE;
 /// Storage access log entries (one row per object read/write).
 pub(crate) const STORAGE_ACCESS_LOGS_TABLE: &str = "suppers_ai__admin__storage_access_logs";
 
+/// Permissions for the log viewer (see [`crate::blocks::permissions`]):
+/// `logs.read` opens the page and the API to non-admins who hold it.
+pub(crate) const PERMISSION_ROUTES: &[EndpointRoute<&str>] = &[
+    EndpointRoute::new(HttpMethod::Get, "/b/admin/logs", "logs.read"),
+    EndpointRoute::new(HttpMethod::Get, "/b/admin/api/logs", "logs.read"),
+];
+
 /// `path` is the normalized `/admin/logs` sub-path, passed explicitly (no
 /// `req.resource` rewrite).
 pub async fn handle(ctx: &dyn Context, msg: &Message, path: &str) -> OutputStream {
@@ -28,8 +40,32 @@ pub async fn handle(ctx: &dyn Context, msg: &Message, path: &str) -> OutputStrea
     }
 }
 
+/// Sort and page-size limits for `GET /admin/logs` (see
+/// [`crate::pagination`]).
+const LIST_SPEC: ListSpec = ListSpec {
+    default_limit: 50,
+    max_limit: 200,
+    sortable: &["created_at", "action"],
+    default_sort: "created_at",
+    default_desc: true,
+};
+
+/// `?actor_type=user|service_account` narrows either log to one kind of
+/// caller (see [`users::actor_type`]).
+fn actor_type_filter(msg: &Message) -> Option<Filter> {
+    let actor_type = msg.query("actor_type");
+    (!actor_type.is_empty()).then(|| Filter {
+        field: "actor_type".to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(actor_type.to_string()),
+    })
+}
+
 async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
-    let (page, page_size, _) = msg.pagination_params(50);
+    let query = match pagination::parse(msg, &LIST_SPEC) {
+        Ok(q) => q,
+        Err(e) => return e.response(),
+    };
 
     let mut filters = Vec::new();
     let user_id = msg.query("user_id").to_string();
@@ -40,6 +76,9 @@ async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
             value: serde_json::Value::String(user_id),
         });
     }
+    if let Some(filter) = actor_type_filter(msg) {
+        filters.push(filter);
+    }
     let action_filter = msg.query("action").to_string();
     if !action_filter.is_empty() {
         filters.push(Filter {
@@ -57,22 +96,8 @@ async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
         });
     }
 
-    let sort = vec![SortField {
-        field: "created_at".to_string(),
-        desc: true,
-    }];
-
-    match db::paginated_list(
-        ctx,
-        AUDIT_LOGS_TABLE,
-        page as i64,
-        page_size as i64,
-        filters,
-        sort,
-    )
-    .await
-    {
-        Ok(result) => ok_json(&result),
+    match pagination::fetch(ctx, AUDIT_LOGS_TABLE, filters, &query).await {
+        Ok(page) => ok_json(&page.into_json(query.fields.as_deref())),
         Err(e) => err_internal("Database error", e),
     }
 }
@@ -89,6 +114,9 @@ async fn handle_system_logs(ctx: &dyn Context, msg: &Message) -> OutputStream {
             value: serde_json::Value::String(status),
         });
     }
+    if let Some(filter) = actor_type_filter(msg) {
+        filters.push(filter);
+    }
     let path_filter = msg.query("path").to_string();
     if !path_filter.is_empty() {
         filters.push(Filter {
@@ -133,6 +161,10 @@ pub async fn audit_log(
 ) {
     let mut data = std::collections::HashMap::new();
     data.insert("user_id".to_string(), serde_json::json!(user_id));
+    data.insert(
+        "actor_type".to_string(),
+        serde_json::json!(users::actor_type(user_id)),
+    );
     data.insert("action".to_string(), serde_json::json!(action));
     data.insert("resource".to_string(), serde_json::json!(resource));
     data.insert("ip_address".to_string(), serde_json::json!(ip_address));
@@ -142,3 +174,44 @@ pub async fn audit_log(
         tracing::warn!(action, resource, "audit_log write failed: {}", e.message);
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+
+    use super::REQUEST_LOGS_TABLE as TABLE;
+    use crate::test_support::{assert_uses_index, TestContext};
+
+    #[tokio::test]
+    async fn request_log_listings_read_through_their_indexes() {
+        let ctx = TestContext::with_admin().await;
+        // The system log, newest first, unfiltered and by status.
+        assert_uses_index(
+            &ctx,
+            &format!("SELECT * FROM {TABLE} ORDER BY created_at DESC LIMIT 50 OFFSET 0"),
+            &[],
+            "suppers_ai__admin__request_logs_created_at_idx",
+        )
+        .await;
+        assert_uses_index(
+            &ctx,
+            &format!(
+                "SELECT * FROM {TABLE} WHERE status = ? ORDER BY created_at DESC LIMIT 50 OFFSET 0"
+            ),
+            &[json!("error")],
+            "suppers_ai__admin__request_logs_status_created_at_idx",
+        )
+        .await;
+        // The network page's per-endpoint drill-down.
+        assert_uses_index(
+            &ctx,
+            &format!(
+                "SELECT status_code, duration_ms, client_ip, user_id, created_at FROM {TABLE} \
+                 WHERE method = ? AND path = ? ORDER BY created_at DESC LIMIT 21 OFFSET 0"
+            ),
+            &[json!("GET"), json!("/b/storage/api/buckets")],
+            "suppers_ai__admin__request_logs_path_method_created_at_idx",
+        )
+        .await;
+    }
+}
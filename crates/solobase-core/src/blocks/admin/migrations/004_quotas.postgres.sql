@@ -0,0 +1,27 @@
+-- Mirror of 004_quotas.sqlite.sql for PostgreSQL.
+--
+-- Counter timestamps are TIMESTAMPTZ (as in suppers_ai__auth__rate_limits)
+-- because the windowed upsert stamps them with a SQL `CURRENT_TIMESTAMP`
+-- expression, which PostgreSQL will not assign to a TEXT column.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__quotas (
+    id          TEXT PRIMARY KEY,
+    resource    TEXT NOT NULL,
+    scope       TEXT NOT NULL,
+    subject     TEXT NOT NULL,
+    max_count   BIGINT NOT NULL,
+    window_secs BIGINT NOT NULL,
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__quotas_target_uniq
+    ON suppers_ai__admin__quotas (resource, scope, subject);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__quota_counters (
+    id           TEXT PRIMARY KEY,
+    key          TEXT NOT NULL UNIQUE,
+    count        BIGINT NOT NULL,
+    window_start BIGINT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    updated_at   TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,33 @@
+-- Usage quotas (IAM): admin-defined limits on tagged API resources, plus
+-- the fixed-window counters the request pipeline consumes them against.
+--
+-- `suppers_ai__admin__quotas` holds one row per (resource, scope, subject):
+-- `scope` is `user` (subject = a user id, or `*` for every user) or `role`
+-- (subject = a role name). `suppers_ai__admin__quota_counters` mirrors
+-- `suppers_ai__auth__rate_limits`: one row per `{user_id}:{resource}` key,
+-- written via the atomic `OnConflict::WindowedCounter` upsert, so `key`
+-- must be UNIQUE.
+--
+-- Mirrored to 004_quotas.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__quotas (
+    id          TEXT PRIMARY KEY,
+    resource    TEXT NOT NULL,
+    scope       TEXT NOT NULL,
+    subject     TEXT NOT NULL,
+    max_count   INTEGER NOT NULL,
+    window_secs INTEGER NOT NULL,
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__quotas_target_uniq
+    ON suppers_ai__admin__quotas (resource, scope, subject);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__quota_counters (
+    id           TEXT PRIMARY KEY,
+    key          TEXT NOT NULL UNIQUE,
+    count        INTEGER NOT NULL,
+    window_start INTEGER NOT NULL,
+    created_at   TEXT NOT NULL,
+    updated_at   TEXT NOT NULL
+);
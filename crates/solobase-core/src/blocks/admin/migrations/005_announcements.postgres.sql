@@ -0,0 +1,25 @@
+-- Mirror of 005_announcements.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__announcements (
+    id           TEXT PRIMARY KEY,
+    message      TEXT NOT NULL,
+    severity     TEXT NOT NULL DEFAULT 'info',
+    starts_at    TEXT NOT NULL,
+    ends_at      TEXT,
+    dismissible  INTEGER NOT NULL DEFAULT 1,
+    target_roles TEXT NOT NULL DEFAULT '',
+    created_by   TEXT NOT NULL DEFAULT '',
+    created_at   TEXT NOT NULL,
+    updated_at   TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__announcement_dismissals (
+    id              TEXT PRIMARY KEY,
+    announcement_id TEXT NOT NULL,
+    user_id         TEXT NOT NULL,
+    created_at      TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__announcement_dismissals_uniq
+    ON suppers_ai__admin__announcement_dismissals (user_id, announcement_id);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__announcement_dismissals_announcement_idx
+    ON suppers_ai__admin__announcement_dismissals (announcement_id);
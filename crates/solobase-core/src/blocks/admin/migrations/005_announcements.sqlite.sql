@@ -0,0 +1,34 @@
+-- Announcement banners: admin-authored messages shown to signed-in users
+-- (and, for `critical` ones without a role target, to anonymous visitors).
+--
+-- `starts_at` / `ends_at` are RFC 3339 UTC; a NULL `ends_at` never expires.
+-- Expired rows are filtered at read time rather than swept by a job.
+-- `target_roles` is a comma-separated role list; empty means everyone.
+-- `suppers_ai__admin__announcement_dismissals` records one row per user
+-- per dismissed announcement — the UNIQUE pair makes dismissing idempotent.
+--
+-- Mirrored to 005_announcements.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__announcements (
+    id           TEXT PRIMARY KEY,
+    message      TEXT NOT NULL,
+    severity     TEXT NOT NULL DEFAULT 'info',
+    starts_at    TEXT NOT NULL,
+    ends_at      TEXT,
+    dismissible  INTEGER NOT NULL DEFAULT 1,
+    target_roles TEXT NOT NULL DEFAULT '',
+    created_by   TEXT NOT NULL DEFAULT '',
+    created_at   TEXT NOT NULL,
+    updated_at   TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__announcement_dismissals (
+    id              TEXT PRIMARY KEY,
+    announcement_id TEXT NOT NULL,
+    user_id         TEXT NOT NULL,
+    created_at      TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__announcement_dismissals_uniq
+    ON suppers_ai__admin__announcement_dismissals (user_id, announcement_id);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__announcement_dismissals_announcement_idx
+    ON suppers_ai__admin__announcement_dismissals (announcement_id);
@@ -0,0 +1,188 @@
+-- Mirror of the SQLite migration for PostgreSQL: rewrite timestamp columns
+-- to the `crate::util::format_rfc3339` layout (`2026-07-11T19:13:45.123456Z`).
+--
+-- Values with an offset are cast through TIMESTAMPTZ; offset-less values are
+-- read as UTC (not the session time zone). Only rows that look like a
+-- timestamp are touched, so empty and malformed values stay as stored.
+
+UPDATE suppers_ai__admin__variables SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__variables SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__roles SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__roles SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__permissions SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__permissions SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__user_roles SET assigned_at = to_char(
+        (CASE WHEN assigned_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN assigned_at::timestamptz
+              ELSE assigned_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE assigned_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND assigned_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__user_roles SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__user_roles SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__audit_logs SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__audit_logs SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__request_logs SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__request_logs SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__storage_access_logs SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__storage_access_logs SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__block_settings SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__block_settings SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__wrap_grants SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__wrap_grants SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__quotas SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__quotas SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__announcements SET starts_at = to_char(
+        (CASE WHEN starts_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN starts_at::timestamptz
+              ELSE starts_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE starts_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND starts_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__announcements SET ends_at = to_char(
+        (CASE WHEN ends_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN ends_at::timestamptz
+              ELSE ends_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE ends_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND ends_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__announcements SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__announcements SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__admin__announcement_dismissals SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
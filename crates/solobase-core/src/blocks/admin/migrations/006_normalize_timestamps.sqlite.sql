@@ -0,0 +1,115 @@
+-- Normalize stored timestamps to the single layout `crate::util::format_rfc3339`
+-- writes: UTC, microsecond precision, literal `Z`
+-- (`2026-07-11T19:13:45.123456Z`). Older rows mix `…Z` seconds,
+-- `to_rfc3339()`'s `+00:00` nanoseconds and SQL `CURRENT_TIMESTAMP`'s
+-- `2026-07-11 19:13:45`, which breaks the string comparisons the cleanup and
+-- expiry queries rely on.
+--
+-- SQLite's `strftime` converts any offset to UTC but only keeps milliseconds,
+-- so rewritten rows are padded to six digits. Values `strftime` can't read
+-- are left as stored — `crate::util::parse_timestamp` still reads most of
+-- them. Already-canonical rows are skipped, so re-runs are no-ops.
+
+UPDATE suppers_ai__admin__variables SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__variables SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__roles SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__roles SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__permissions SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__permissions SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__user_roles SET assigned_at = strftime('%Y-%m-%dT%H:%M:%f', assigned_at) || '000Z'
+    WHERE assigned_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', assigned_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__user_roles SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__user_roles SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__audit_logs SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__audit_logs SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__request_logs SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__request_logs SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__storage_access_logs SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__storage_access_logs SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__block_settings SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__block_settings SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__wrap_grants SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__wrap_grants SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__quotas SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__quotas SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__announcements SET starts_at = strftime('%Y-%m-%dT%H:%M:%f', starts_at) || '000Z'
+    WHERE starts_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', starts_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__announcements SET ends_at = strftime('%Y-%m-%dT%H:%M:%f', ends_at) || '000Z'
+    WHERE ends_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', ends_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__announcements SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__announcements SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__admin__announcement_dismissals SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
@@ -0,0 +1,44 @@
+-- Inbound webhooks: admin-defined endpoints that external systems POST to
+-- at `/b/hooks/in/{id}`, signed with the endpoint's shared secret.
+--
+-- `action` is `insert` (map payload fields onto `target_table` columns via
+-- the `field_map` JSON object, column -> JSON pointer) or `block` (forward
+-- the payload to the registered block `target_block` as hook `hook`).
+-- `rate_limit` is deliveries per minute, and 0 uses the shared
+-- `SOLOBASE_SHARED__RATE_LIMIT_INBOUND_WEBHOOK` default.
+-- `suppers_ai__admin__inbound_webhook_deliveries` keeps the most recent
+-- attempts per endpoint (pruned on write) with their outcome and error.
+--
+-- Mirrored to 007_inbound_webhooks.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__inbound_webhooks (
+    id           TEXT PRIMARY KEY,
+    name         TEXT NOT NULL,
+    secret       TEXT NOT NULL,
+    action       TEXT NOT NULL,
+    target_table TEXT NOT NULL DEFAULT '',
+    field_map    TEXT NOT NULL DEFAULT '{}',
+    target_block TEXT NOT NULL DEFAULT '',
+    hook         TEXT NOT NULL DEFAULT '',
+    enabled      INTEGER NOT NULL DEFAULT 1,
+    rate_limit   INTEGER NOT NULL DEFAULT 0,
+    created_by   TEXT NOT NULL DEFAULT '',
+    created_at   TEXT NOT NULL,
+    updated_at   TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__inbound_webhooks_name_uniq
+    ON suppers_ai__admin__inbound_webhooks (name);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__inbound_webhook_deliveries (
+    id          TEXT PRIMARY KEY,
+    endpoint_id TEXT NOT NULL,
+    status      TEXT NOT NULL,
+    http_status INTEGER NOT NULL DEFAULT 0,
+    error       TEXT NOT NULL DEFAULT '',
+    payload     TEXT NOT NULL DEFAULT '',
+    record_id   TEXT NOT NULL DEFAULT '',
+    remote_addr TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__inbound_webhook_deliveries_endpoint_idx
+    ON suppers_ai__admin__inbound_webhook_deliveries (endpoint_id, created_at);
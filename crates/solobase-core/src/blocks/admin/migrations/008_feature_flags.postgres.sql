@@ -0,0 +1,16 @@
+-- Mirror of 008_feature_flags.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__feature_flags (
+    id              TEXT PRIMARY KEY,
+    key             TEXT NOT NULL,
+    description     TEXT NOT NULL DEFAULT '',
+    enabled         INTEGER NOT NULL DEFAULT 0,
+    rollout_percent INTEGER NOT NULL DEFAULT 0,
+    target_roles    TEXT NOT NULL DEFAULT '',
+    target_users    TEXT NOT NULL DEFAULT '',
+    created_by      TEXT NOT NULL DEFAULT '',
+    created_at      TEXT NOT NULL,
+    updated_at      TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__feature_flags_key_uniq
+    ON suppers_ai__admin__feature_flags (key);
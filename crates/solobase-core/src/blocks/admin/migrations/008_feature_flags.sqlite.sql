@@ -0,0 +1,24 @@
+-- Feature flags: per-user rollout switches read by blocks, extensions and
+-- (evaluated) by frontends via GET /b/auth/api/flags.
+--
+-- `key` is the stable name code checks. A flag is on for a caller when
+-- `enabled` is set and the caller is listed in `target_users`, holds one of
+-- `target_roles`, or hashes into the first `rollout_percent` of 100
+-- buckets. `target_roles` and `target_users` are comma-separated lists.
+--
+-- Mirrored to 008_feature_flags.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__feature_flags (
+    id              TEXT PRIMARY KEY,
+    key             TEXT NOT NULL,
+    description     TEXT NOT NULL DEFAULT '',
+    enabled         INTEGER NOT NULL DEFAULT 0,
+    rollout_percent INTEGER NOT NULL DEFAULT 0,
+    target_roles    TEXT NOT NULL DEFAULT '',
+    target_users    TEXT NOT NULL DEFAULT '',
+    created_by      TEXT NOT NULL DEFAULT '',
+    created_at      TEXT NOT NULL,
+    updated_at      TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__feature_flags_key_uniq
+    ON suppers_ai__admin__feature_flags (key);
@@ -0,0 +1,21 @@
+-- Mirror of 009_jobs.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__jobs (
+    id           TEXT PRIMARY KEY,
+    job_type     TEXT NOT NULL,
+    block        TEXT NOT NULL,
+    payload      TEXT NOT NULL DEFAULT '{}',
+    status       TEXT NOT NULL DEFAULT 'pending',
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    next_run_at  TEXT NOT NULL,
+    started_at   TEXT NOT NULL DEFAULT '',
+    finished_at  TEXT NOT NULL DEFAULT '',
+    last_error   TEXT NOT NULL DEFAULT '',
+    created_at   TEXT NOT NULL,
+    updated_at   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__jobs_due_idx
+    ON suppers_ai__admin__jobs (status, next_run_at);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__jobs_created_idx
+    ON suppers_ai__admin__jobs (created_at);
@@ -0,0 +1,27 @@
+-- Mirror of 010_notifications.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__notifications (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    title      TEXT NOT NULL,
+    body       TEXT NOT NULL DEFAULT '',
+    data       TEXT NOT NULL DEFAULT '{}',
+    read_at    TEXT,
+    emailed_at TEXT,
+    created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__notifications_user_idx
+    ON suppers_ai__admin__notifications (user_id, created_at);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__notifications_read_idx
+    ON suppers_ai__admin__notifications (read_at);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__notification_preferences (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    email      INTEGER NOT NULL DEFAULT 0,
+    updated_at TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__notification_preferences_uniq
+    ON suppers_ai__admin__notification_preferences (user_id, type);
@@ -0,0 +1,38 @@
+-- Per-user notification inbox (see blocks/notifications.rs).
+--
+-- One row per notification. `type` is the notifier's dotted kind
+-- (`files.quota_threshold`, ...); `data` is a JSON object the UI may use
+-- for links. `read_at` is NULL until the user reads it; read rows older
+-- than the retention window are pruned by the job worker. `emailed_at`
+-- marks a delivered email fan-out so a retried job doesn't send twice.
+--
+-- `suppers_ai__admin__notification_preferences` holds one row per user per
+-- type the user changed; a missing row means the type's default.
+--
+-- Mirrored to 010_notifications.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__notifications (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    title      TEXT NOT NULL,
+    body       TEXT NOT NULL DEFAULT '',
+    data       TEXT NOT NULL DEFAULT '{}',
+    read_at    TEXT,
+    emailed_at TEXT,
+    created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__notifications_user_idx
+    ON suppers_ai__admin__notifications (user_id, created_at);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__notifications_read_idx
+    ON suppers_ai__admin__notifications (read_at);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__notification_preferences (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    email      INTEGER NOT NULL DEFAULT 0,
+    updated_at TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__notification_preferences_uniq
+    ON suppers_ai__admin__notification_preferences (user_id, type);
@@ -0,0 +1,3 @@
+-- Mirror of 011_actor_type.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__admin__audit_logs ADD COLUMN IF NOT EXISTS actor_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__admin__request_logs ADD COLUMN IF NOT EXISTS actor_type TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- Who made a request or an audited change: `user` (a person), or
+-- `service_account` (a non-interactive principal, see the auth block's
+-- 015_service_accounts migration). Empty for anonymous requests and for
+-- rows written before this column existed.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__admin__audit_logs ADD COLUMN actor_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__admin__request_logs ADD COLUMN actor_type TEXT NOT NULL DEFAULT '';
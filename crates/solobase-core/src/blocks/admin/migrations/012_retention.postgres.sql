@@ -0,0 +1,26 @@
+-- Mirror of 012_retention.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__retention_policies (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    days       BIGINT NOT NULL,
+    enabled    INTEGER NOT NULL DEFAULT 1,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__retention_policies_name_uniq
+    ON suppers_ai__admin__retention_policies (name);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__retention_runs (
+    id         TEXT PRIMARY KEY,
+    run_id     TEXT NOT NULL,
+    policy     TEXT NOT NULL,
+    days       BIGINT NOT NULL,
+    deleted    BIGINT NOT NULL DEFAULT 0,
+    capped     INTEGER NOT NULL DEFAULT 0,
+    error      TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__retention_runs_created_idx
+    ON suppers_ai__admin__retention_runs (created_at);
@@ -0,0 +1,19 @@
+-- Mirror of 013_schedules.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__schedules (
+    id           TEXT PRIMARY KEY,
+    name         TEXT NOT NULL,
+    cron         TEXT NOT NULL,
+    block        TEXT NOT NULL,
+    job_type     TEXT NOT NULL,
+    catch_up     INTEGER NOT NULL DEFAULT 0,
+    paused       INTEGER NOT NULL DEFAULT 0,
+    next_run_at  TEXT NOT NULL DEFAULT '',
+    last_run_at  TEXT NOT NULL DEFAULT '',
+    last_job_id  TEXT NOT NULL DEFAULT '',
+    last_outcome TEXT NOT NULL DEFAULT '',
+    created_at   TEXT NOT NULL,
+    updated_at   TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__schedules_name_uniq
+    ON suppers_ai__admin__schedules (name);
@@ -0,0 +1,26 @@
+-- Recurring job schedules (see blocks/schedules.rs).
+--
+-- One row per schedule name. The schedule itself is registered in code;
+-- the row keeps what must survive a restart: the next and last run, the
+-- job queued last (for overlap protection), an admin's pause, and what
+-- the last due time did.
+--
+-- Mirrored to 013_schedules.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__schedules (
+    id           TEXT PRIMARY KEY,
+    name         TEXT NOT NULL,
+    cron         TEXT NOT NULL,
+    block        TEXT NOT NULL,
+    job_type     TEXT NOT NULL,
+    catch_up     INTEGER NOT NULL DEFAULT 0,
+    paused       INTEGER NOT NULL DEFAULT 0,
+    next_run_at  TEXT NOT NULL DEFAULT '',
+    last_run_at  TEXT NOT NULL DEFAULT '',
+    last_job_id  TEXT NOT NULL DEFAULT '',
+    last_outcome TEXT NOT NULL DEFAULT '',
+    created_at   TEXT NOT NULL,
+    updated_at   TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__schedules_name_uniq
+    ON suppers_ai__admin__schedules (name);
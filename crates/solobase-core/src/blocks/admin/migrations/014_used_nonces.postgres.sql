@@ -0,0 +1,17 @@
+-- Mirror of 014_used_nonces.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__used_nonces (
+    id         TEXT PRIMARY KEY,
+    scope      TEXT NOT NULL,
+    nonce      TEXT NOT NULL,
+    uses       INTEGER NOT NULL DEFAULT 1,
+    max_uses   INTEGER NOT NULL DEFAULT 1,
+    expires_at TEXT NOT NULL,
+    created_at TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__used_nonces_scope_nonce_uniq
+    ON suppers_ai__admin__used_nonces (scope, nonce);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__used_nonces_expires_idx
+    ON suppers_ai__admin__used_nonces (expires_at);
+
+ALTER TABLE suppers_ai__admin__inbound_webhooks ADD COLUMN IF NOT EXISTS single_delivery INTEGER NOT NULL DEFAULT 0;
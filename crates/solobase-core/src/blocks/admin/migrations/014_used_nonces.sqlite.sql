@@ -0,0 +1,29 @@
+-- Replay protection (see blocks/nonces.rs).
+--
+-- `suppers_ai__admin__used_nonces` holds one row per nonce a signed
+-- request spent, unique per scope, until `expires_at`; the retention
+-- runner's `expired_tokens` policy deletes it after that. `uses` counts
+-- up to `max_uses` for tokens allowed more than one use.
+--
+-- Inbound webhook endpoints gain `single_delivery`: deliveries must then
+-- carry a signed id and timestamp, and each id is accepted once.
+--
+-- Mirrored to 014_used_nonces.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__used_nonces (
+    id         TEXT PRIMARY KEY,
+    scope      TEXT NOT NULL,
+    nonce      TEXT NOT NULL,
+    uses       INTEGER NOT NULL DEFAULT 1,
+    max_uses   INTEGER NOT NULL DEFAULT 1,
+    expires_at TEXT NOT NULL,
+    created_at TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__used_nonces_scope_nonce_uniq
+    ON suppers_ai__admin__used_nonces (scope, nonce);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__used_nonces_expires_idx
+    ON suppers_ai__admin__used_nonces (expires_at);
+
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__admin__inbound_webhooks ADD COLUMN single_delivery INTEGER NOT NULL DEFAULT 0;
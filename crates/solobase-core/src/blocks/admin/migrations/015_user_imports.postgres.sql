@@ -0,0 +1,20 @@
+-- Mirror of 015_user_imports.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__user_imports (
+    id          TEXT PRIMARY KEY,
+    status      TEXT NOT NULL DEFAULT 'queued',
+    total       INTEGER NOT NULL DEFAULT 0,
+    processed   INTEGER NOT NULL DEFAULT 0,
+    created     INTEGER NOT NULL DEFAULT 0,
+    failed      INTEGER NOT NULL DEFAULT 0,
+    skipped     INTEGER NOT NULL DEFAULT 0,
+    send_email  INTEGER NOT NULL DEFAULT 1,
+    pending     TEXT NOT NULL DEFAULT '[]',
+    results     TEXT NOT NULL DEFAULT '[]',
+    created_by  TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL,
+    finished_at TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__user_imports_created_idx
+    ON suppers_ai__admin__user_imports (created_at);
@@ -0,0 +1,29 @@
+-- Bulk user imports (see blocks/admin/user_import.rs).
+--
+-- One row per committed `POST /admin/users/import`. `pending` holds the
+-- validated rows still to create, as JSON; it is emptied when the import
+-- finishes, so the passwords it may carry don't outlive the import.
+-- `processed` counts rows done, which lets a retried job resume, and
+-- `results` holds the per-row outcomes polled by
+-- `GET /admin/users/import/{id}`.
+--
+-- Mirrored to 015_user_imports.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__user_imports (
+    id          TEXT PRIMARY KEY,
+    status      TEXT NOT NULL DEFAULT 'queued',
+    total       INTEGER NOT NULL DEFAULT 0,
+    processed   INTEGER NOT NULL DEFAULT 0,
+    created     INTEGER NOT NULL DEFAULT 0,
+    failed      INTEGER NOT NULL DEFAULT 0,
+    skipped     INTEGER NOT NULL DEFAULT 0,
+    send_email  INTEGER NOT NULL DEFAULT 1,
+    pending     TEXT NOT NULL DEFAULT '[]',
+    results     TEXT NOT NULL DEFAULT '[]',
+    created_by  TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL,
+    finished_at TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__user_imports_created_idx
+    ON suppers_ai__admin__user_imports (created_at);
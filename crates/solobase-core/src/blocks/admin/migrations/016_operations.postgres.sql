@@ -0,0 +1,22 @@
+-- Mirror of 016_operations.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__operations (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL,
+    kind        TEXT NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'queued',
+    job_id      TEXT NOT NULL DEFAULT '',
+    processed   INTEGER NOT NULL DEFAULT 0,
+    total       INTEGER NOT NULL DEFAULT 0,
+    result      TEXT NOT NULL DEFAULT '',
+    error       TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL,
+    started_at  TEXT NOT NULL DEFAULT '',
+    finished_at TEXT NOT NULL DEFAULT '',
+    expires_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__operations_user_idx
+    ON suppers_ai__admin__operations (user_id, created_at);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__operations_expires_idx
+    ON suppers_ai__admin__operations (expires_at);
@@ -0,0 +1,30 @@
+-- Long-running operations (see blocks/operations.rs).
+--
+-- One row per operation a request started as a background job: who
+-- started it, its state, the progress the job reports and, once done,
+-- the result or error. `GET /api/operations` lists a user's rows until
+-- `expires_at`, a day after they were created; the `expired_tokens`
+-- retention policy deletes them after that.
+--
+-- Mirrored to 016_operations.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__operations (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL,
+    kind        TEXT NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'queued',
+    job_id      TEXT NOT NULL DEFAULT '',
+    processed   INTEGER NOT NULL DEFAULT 0,
+    total       INTEGER NOT NULL DEFAULT 0,
+    result      TEXT NOT NULL DEFAULT '',
+    error       TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL,
+    started_at  TEXT NOT NULL DEFAULT '',
+    finished_at TEXT NOT NULL DEFAULT '',
+    expires_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__operations_user_idx
+    ON suppers_ai__admin__operations (user_id, created_at);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__operations_expires_idx
+    ON suppers_ai__admin__operations (expires_at);
@@ -0,0 +1,12 @@
+-- Mirror of 017_translations.sqlite.sql for PostgreSQL.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__translations (
+    id         TEXT PRIMARY KEY,
+    locale     TEXT NOT NULL,
+    messages   TEXT NOT NULL DEFAULT '{}',
+    updated_by TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__translations_locale_uniq
+    ON suppers_ai__admin__translations (locale);
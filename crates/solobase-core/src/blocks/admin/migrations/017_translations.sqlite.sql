@@ -0,0 +1,20 @@
+-- Admin translation overrides (see src/i18n).
+--
+-- One row per locale: `messages` is a JSON object of catalog key →
+-- translated text, merged over the embedded `en` catalog when the server
+-- renders an error message or email, and when `GET /api/i18n/{locale}`
+-- serves the catalog to the frontend. Uploading a locale replaces its
+-- whole object.
+--
+-- Mirrored to 017_translations.postgres.sql.
+
+CREATE TABLE IF NOT EXISTS suppers_ai__admin__translations (
+    id         TEXT PRIMARY KEY,
+    locale     TEXT NOT NULL,
+    messages   TEXT NOT NULL DEFAULT '{}',
+    updated_by TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__admin__translations_locale_uniq
+    ON suppers_ai__admin__translations (locale);
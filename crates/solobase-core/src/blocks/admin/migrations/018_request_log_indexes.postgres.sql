@@ -0,0 +1,5 @@
+-- Mirror of 018_request_log_indexes.sqlite.sql for PostgreSQL.
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__request_logs_status_created_at_idx
+    ON suppers_ai__admin__request_logs (status, created_at);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__request_logs_path_method_created_at_idx
+    ON suppers_ai__admin__request_logs (path, method, created_at);
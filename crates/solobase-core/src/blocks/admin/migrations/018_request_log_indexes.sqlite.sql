@@ -0,0 +1,16 @@
+-- Request-log listings that filter before ordering by `created_at`. With
+-- only the created_at index, SQLite walks the whole log newest-first
+-- looking for matches, so a rare status or endpoint reads every row:
+--
+-- * the system log filtered by `status` (`admin::logs`);
+-- * the network page's per-endpoint drill-down, `method` + `path`
+--   (`admin::pages::network`).
+--
+-- The unfiltered listing and retention keep using the created_at index.
+-- The `path` substring search (`LIKE '%...%'`) can't use any index.
+--
+-- Mirrored to 018_request_log_indexes.postgres.sql.
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__request_logs_status_created_at_idx
+    ON suppers_ai__admin__request_logs (status, created_at);
+CREATE INDEX IF NOT EXISTS suppers_ai__admin__request_logs_path_method_created_at_idx
+    ON suppers_ai__admin__request_logs (path, method, created_at);
@@ -14,6 +14,36 @@ const SQL_002_SQLITE: &str = include_str!("002_variables_block_column.sqlite.sql
 const SQL_002_POSTGRES: &str = include_str!("002_variables_block_column.postgres.sql");
 const SQL_003_SQLITE: &str = include_str!("003_block_settings_seed_hash.sqlite.sql");
 const SQL_003_POSTGRES: &str = include_str!("003_block_settings_seed_hash.postgres.sql");
+const SQL_004_SQLITE: &str = include_str!("004_quotas.sqlite.sql");
+const SQL_004_POSTGRES: &str = include_str!("004_quotas.postgres.sql");
+const SQL_005_SQLITE: &str = include_str!("005_announcements.sqlite.sql");
+const SQL_005_POSTGRES: &str = include_str!("005_announcements.postgres.sql");
+const SQL_006_SQLITE: &str = include_str!("006_normalize_timestamps.sqlite.sql");
+const SQL_006_POSTGRES: &str = include_str!("006_normalize_timestamps.postgres.sql");
+const SQL_007_SQLITE: &str = include_str!("007_inbound_webhooks.sqlite.sql");
+const SQL_007_POSTGRES: &str = include_str!("007_inbound_webhooks.postgres.sql");
+const SQL_008_SQLITE: &str = include_str!("008_feature_flags.sqlite.sql");
+const SQL_008_POSTGRES: &str = include_str!("008_feature_flags.postgres.sql");
+const SQL_009_SQLITE: &str = include_str!("009_jobs.sqlite.sql");
+const SQL_009_POSTGRES: &str = include_str!("009_jobs.postgres.sql");
+const SQL_010_SQLITE: &str = include_str!("010_notifications.sqlite.sql");
+const SQL_010_POSTGRES: &str = include_str!("010_notifications.postgres.sql");
+const SQL_011_SQLITE: &str = include_str!("011_actor_type.sqlite.sql");
+const SQL_011_POSTGRES: &str = include_str!("011_actor_type.postgres.sql");
+const SQL_012_SQLITE: &str = include_str!("012_retention.sqlite.sql");
+const SQL_012_POSTGRES: &str = include_str!("012_retention.postgres.sql");
+const SQL_013_SQLITE: &str = include_str!("013_schedules.sqlite.sql");
+const SQL_013_POSTGRES: &str = include_str!("013_schedules.postgres.sql");
+const SQL_014_SQLITE: &str = include_str!("014_used_nonces.sqlite.sql");
+const SQL_014_POSTGRES: &str = include_str!("014_used_nonces.postgres.sql");
+const SQL_015_SQLITE: &str = include_str!("015_user_imports.sqlite.sql");
+const SQL_015_POSTGRES: &str = include_str!("015_user_imports.postgres.sql");
+const SQL_016_SQLITE: &str = include_str!("016_operations.sqlite.sql");
+const SQL_016_POSTGRES: &str = include_str!("016_operations.postgres.sql");
+const SQL_017_SQLITE: &str = include_str!("017_translations.sqlite.sql");
+const SQL_017_POSTGRES: &str = include_str!("017_translations.postgres.sql");
+const SQL_018_SQLITE: &str = include_str!("018_request_log_indexes.sqlite.sql");
+const SQL_018_POSTGRES: &str = include_str!("018_request_log_indexes.postgres.sql");
 
 /// Ordered SQLite migration scripts for this block, as `(basename, content)`
 /// pairs. Feeds the runtime `lifecycle_init` apply path.
@@ -22,13 +52,46 @@ pub(crate) const SQLITE_MIGRATIONS: &[(&str, &str)] = &[
     ("001_admin_schema", SQL_001_SQLITE),
     ("002_variables_block_column", SQL_002_SQLITE),
     ("003_block_settings_seed_hash", SQL_003_SQLITE),
+    ("004_quotas", SQL_004_SQLITE),
+    ("005_announcements", SQL_005_SQLITE),
+    ("006_normalize_timestamps", SQL_006_SQLITE),
+    ("007_inbound_webhooks", SQL_007_SQLITE),
+    ("008_feature_flags", SQL_008_SQLITE),
+    ("009_jobs", SQL_009_SQLITE),
+    ("010_notifications", SQL_010_SQLITE),
+    ("011_actor_type", SQL_011_SQLITE),
+    ("012_retention", SQL_012_SQLITE),
+    ("013_schedules", SQL_013_SQLITE),
+    ("014_used_nonces", SQL_014_SQLITE),
+    ("015_user_imports", SQL_015_SQLITE),
+    ("016_operations", SQL_016_SQLITE),
+    ("017_translations", SQL_017_SQLITE),
+    ("018_request_log_indexes", SQL_018_SQLITE),
 ];
 
 /// Ordered PostgreSQL migration scripts, matching [`SQLITE_MIGRATIONS`] one
 /// for one. Selected at runtime by `apply_migrations` and reused by
 /// [`ddl_files`] for the pre-wafer native CLI path.
-pub(crate) const POSTGRES_MIGRATIONS: &[&str] =
-    &[SQL_001_POSTGRES, SQL_002_POSTGRES, SQL_003_POSTGRES];
+pub(crate) const POSTGRES_MIGRATIONS: &[&str] = &[
+    SQL_001_POSTGRES,
+    SQL_002_POSTGRES,
+    SQL_003_POSTGRES,
+    SQL_004_POSTGRES,
+    SQL_005_POSTGRES,
+    SQL_006_POSTGRES,
+    SQL_007_POSTGRES,
+    SQL_008_POSTGRES,
+    SQL_009_POSTGRES,
+    SQL_010_POSTGRES,
+    SQL_011_POSTGRES,
+    SQL_012_POSTGRES,
+    SQL_013_POSTGRES,
+    SQL_014_POSTGRES,
+    SQL_015_POSTGRES,
+    SQL_016_POSTGRES,
+    SQL_017_POSTGRES,
+    SQL_018_POSTGRES,
+];
 
 /// Apply the admin schema through the shared migration-state gate.
 ///
@@ -46,7 +109,8 @@ pub async fn apply(ctx: &dyn wafer_run::context::Context) -> Result<(), String>
 }
 
 /// The admin migration SQL files for the given `db_type`, in apply order —
-/// the same constants the gated `lifecycle_init` runner feeds. `"postgres"`
+/// read from the same lists the gated `lifecycle_init` runner feeds, so a
+/// new migration can't be left out of the pre-wafer path. `"postgres"`
 /// (case-insensitive) selects the postgres dialect; everything else selects
 /// SQLite, matching [`crate::migration_helper::db_backend`].
 ///
@@ -55,11 +119,11 @@ pub async fn apply(ctx: &dyn wafer_run::context::Context) -> Result<(), String>
 /// [`crate::migration_helper::apply_ddl_via_service`]. Cloudflare and browser
 /// don't need this — their seeders run after the gated apply has already
 /// created the tables at `init_block(admin)`.
-pub fn ddl_files(db_type: &str) -> &'static [&'static str] {
+pub fn ddl_files(db_type: &str) -> Vec<&'static str> {
     if db_type.eq_ignore_ascii_case("postgres") {
-        POSTGRES_MIGRATIONS
+        POSTGRES_MIGRATIONS.to_vec()
     } else {
-        &[SQL_001_SQLITE, SQL_002_SQLITE, SQL_003_SQLITE]
+        SQLITE_MIGRATIONS.iter().map(|(_, sql)| *sql).collect()
     }
 }
 
@@ -67,7 +131,13 @@ pub fn ddl_files(db_type: &str) -> &'static [&'static str] {
 mod tests {
     use super::{
         SQL_001_POSTGRES, SQL_001_SQLITE, SQL_002_POSTGRES, SQL_002_SQLITE, SQL_003_POSTGRES,
-        SQL_003_SQLITE,
+        SQL_003_SQLITE, SQL_004_POSTGRES, SQL_004_SQLITE, SQL_005_POSTGRES, SQL_005_SQLITE,
+        SQL_006_POSTGRES, SQL_006_SQLITE, SQL_007_POSTGRES, SQL_007_SQLITE, SQL_008_POSTGRES,
+        SQL_008_SQLITE, SQL_009_POSTGRES, SQL_009_SQLITE, SQL_010_POSTGRES, SQL_010_SQLITE,
+        SQL_011_POSTGRES, SQL_011_SQLITE, SQL_012_POSTGRES, SQL_012_SQLITE, SQL_013_POSTGRES,
+        SQL_013_SQLITE, SQL_014_POSTGRES, SQL_014_SQLITE, SQL_015_POSTGRES, SQL_015_SQLITE,
+        SQL_016_POSTGRES, SQL_016_SQLITE, SQL_017_POSTGRES, SQL_017_SQLITE, SQL_018_POSTGRES,
+        SQL_018_SQLITE,
     };
 
     #[test]
@@ -79,6 +149,43 @@ mod tests {
         assert!(SQL_002_SQLITE.contains("suppers_ai__admin__variables_block_idx"));
         // 003 follow-up (ADD COLUMN seed_defaults_hash)
         assert!(SQL_003_SQLITE.contains("ADD COLUMN seed_defaults_hash"));
+        // 004 quotas (definitions unique per target + counters keyed UNIQUE)
+        assert!(SQL_004_SQLITE.contains("suppers_ai__admin__quotas_target_uniq"));
+        assert!(SQL_004_SQLITE.contains("key          TEXT NOT NULL UNIQUE"));
+        // 005 announcements (one dismissal per user per announcement)
+        assert!(SQL_005_SQLITE.contains("suppers_ai__admin__announcement_dismissals_uniq"));
+        // 006 timestamp normalization (data-only rewrite)
+        assert!(SQL_006_SQLITE.contains("UPDATE suppers_ai__admin__announcements SET starts_at"));
+        // 007 inbound webhooks (endpoint names unique, deliveries by endpoint)
+        assert!(SQL_007_SQLITE.contains("suppers_ai__admin__inbound_webhooks_name_uniq"));
+        assert!(
+            SQL_007_SQLITE.contains("suppers_ai__admin__inbound_webhook_deliveries_endpoint_idx")
+        );
+        // 008 feature flags (one definition per key)
+        assert!(SQL_008_SQLITE.contains("suppers_ai__admin__feature_flags_key_uniq"));
+        // 009 background jobs (due scan by status + next_run_at)
+        assert!(SQL_009_SQLITE.contains("suppers_ai__admin__jobs_due_idx"));
+        // 010 notifications (one preference row per user per type)
+        assert!(SQL_010_SQLITE.contains("suppers_ai__admin__notification_preferences_uniq"));
+        // 011 actor attribution on audit and request logs
+        assert!(SQL_011_SQLITE.contains("suppers_ai__admin__request_logs ADD COLUMN actor_type"));
+        // 012 retention (one override row per policy)
+        assert!(SQL_012_SQLITE.contains("suppers_ai__admin__retention_policies_name_uniq"));
+        // 013 schedules (one state row per schedule name)
+        assert!(SQL_013_SQLITE.contains("suppers_ai__admin__schedules_name_uniq"));
+        // 014 replay protection (one row per nonce per scope)
+        assert!(SQL_014_SQLITE.contains("suppers_ai__admin__used_nonces_scope_nonce_uniq"));
+        assert!(SQL_014_SQLITE.contains("ADD COLUMN single_delivery"));
+        // 015 bulk user imports
+        assert!(SQL_015_SQLITE.contains("suppers_ai__admin__user_imports_created_idx"));
+        // 016 long-running operations (a user's list, expiry sweep)
+        assert!(SQL_016_SQLITE.contains("suppers_ai__admin__operations_user_idx"));
+        assert!(SQL_016_SQLITE.contains("suppers_ai__admin__operations_expires_idx"));
+        // 017 translation overrides (one row per locale)
+        assert!(SQL_017_SQLITE.contains("suppers_ai__admin__translations_locale_uniq"));
+        // 018 filtered request-log listings
+        assert!(SQL_018_SQLITE.contains("suppers_ai__admin__request_logs_status_created_at_idx"));
+        assert!(SQL_018_SQLITE.contains("request_logs (path, method, created_at)"));
     }
 
     #[test]
@@ -86,5 +193,20 @@ mod tests {
         assert!(SQL_001_POSTGRES.contains("suppers_ai__admin__variables_key_uniq"));
         assert!(SQL_002_POSTGRES.contains("ADD COLUMN"));
         assert!(SQL_003_POSTGRES.contains("seed_defaults_hash"));
+        assert!(SQL_004_POSTGRES.contains("updated_at   TIMESTAMPTZ NOT NULL"));
+        assert!(SQL_005_POSTGRES.contains("suppers_ai__admin__announcement_dismissals_uniq"));
+        assert!(SQL_006_POSTGRES.contains("AT TIME ZONE 'UTC'"));
+        assert!(SQL_007_POSTGRES.contains("suppers_ai__admin__inbound_webhooks_name_uniq"));
+        assert!(SQL_008_POSTGRES.contains("suppers_ai__admin__feature_flags_key_uniq"));
+        assert!(SQL_009_POSTGRES.contains("suppers_ai__admin__jobs_due_idx"));
+        assert!(SQL_010_POSTGRES.contains("suppers_ai__admin__notification_preferences_uniq"));
+        assert!(SQL_011_POSTGRES.contains("ADD COLUMN IF NOT EXISTS actor_type"));
+        assert!(SQL_012_POSTGRES.contains("suppers_ai__admin__retention_policies_name_uniq"));
+        assert!(SQL_013_POSTGRES.contains("suppers_ai__admin__schedules_name_uniq"));
+        assert!(SQL_014_POSTGRES.contains("suppers_ai__admin__used_nonces_scope_nonce_uniq"));
+        assert!(SQL_015_POSTGRES.contains("suppers_ai__admin__user_imports_created_idx"));
+        assert!(SQL_016_POSTGRES.contains("suppers_ai__admin__operations_user_idx"));
+        assert!(SQL_017_POSTGRES.contains("suppers_ai__admin__translations_locale_uniq"));
+        assert!(SQL_018_POSTGRES.contains("suppers_ai__admin__request_logs_status_created_at_idx"));
     }
 }
@@ -1,16 +1,43 @@
+mod announcements;
+mod api_keys;
 mod database;
+mod email_log;
+mod extensions;
+mod feature_flags;
 mod iam;
+mod inbound_webhooks;
+mod invitations;
+mod jobs;
 mod logs;
 pub mod migrations;
 mod ops;
 mod pages;
+mod retention;
 mod route;
+mod runtime;
+mod service_accounts;
 mod settings;
+mod table_io;
+mod translations;
+mod user_import;
 mod users;
 
-pub(crate) use iam::{PERMISSIONS_TABLE, ROLES_TABLE, USER_ROLES_TABLE};
-pub(crate) use logs::{AUDIT_LOGS_TABLE, REQUEST_LOGS_TABLE, STORAGE_ACCESS_LOGS_TABLE};
+pub(crate) use announcements::{ANNOUNCEMENTS_TABLE, ANNOUNCEMENT_DISMISSALS_TABLE};
+pub(crate) use feature_flags::FEATURE_FLAGS_TABLE;
+pub(crate) use iam::{
+    PERMISSIONS_TABLE, QUOTAS_TABLE, QUOTA_COUNTERS_TABLE, ROLES_TABLE, USER_ROLES_TABLE,
+};
+pub(crate) use inbound_webhooks::{INBOUND_WEBHOOKS_TABLE, INBOUND_WEBHOOK_DELIVERIES_TABLE};
+pub use inbound_webhooks::{META_ENDPOINT_ID, META_ENDPOINT_NAME};
+pub(crate) use jobs::{JOBS_TABLE, SCHEDULES_TABLE};
+pub(crate) use logs::{
+    audit_log, AUDIT_LOGS_TABLE, PERMISSION_ROUTES as LOGS_PERMISSION_ROUTES, REQUEST_LOGS_TABLE,
+    STORAGE_ACCESS_LOGS_TABLE,
+};
+pub(crate) use route::STORAGE_PERMISSION_ROUTES;
 pub use settings::{BLOCK_SETTINGS_TABLE, VARIABLES_TABLE};
+pub(crate) use user_import::USER_IMPORTS_TABLE;
+pub(crate) use users::PERMISSION_ROUTES as USERS_PERMISSION_ROUTES;
 
 /// Registered name of the admin block.
 ///
@@ -23,15 +50,33 @@ pub const ADMIN_BLOCK_ID: &str = "suppers-ai/admin";
 /// WRAP grant rows (block-to-resource access tokens).
 pub const WRAP_GRANTS_TABLE: &str = "suppers_ai__admin__wrap_grants";
 
+/// Per-user notification inbox (see [`crate::blocks::notifications`]).
+pub(crate) const NOTIFICATIONS_TABLE: &str = "suppers_ai__admin__notifications";
+/// Per-user, per-type notification email choices.
+pub(crate) const NOTIFICATION_PREFERENCES_TABLE: &str =
+    "suppers_ai__admin__notification_preferences";
+/// Admin-changed retention policies (see [`crate::blocks::retention`]).
+pub(crate) const RETENTION_POLICIES_TABLE: &str = "suppers_ai__admin__retention_policies";
+/// One row per policy per retention run.
+pub(crate) const RETENTION_RUNS_TABLE: &str = "suppers_ai__admin__retention_runs";
+/// Spent nonces of signed requests (see [`crate::blocks::nonces`]).
+pub(crate) const USED_NONCES_TABLE: &str = "suppers_ai__admin__used_nonces";
+/// Long-running operations users poll (see [`crate::blocks::operations`]).
+pub(crate) const OPERATIONS_TABLE: &str = "suppers_ai__admin__operations";
+/// Per-locale message overrides (see [`crate::i18n`]).
+pub(crate) const TRANSLATIONS_TABLE: &str = "suppers_ai__admin__translations";
+
 use wafer_run::{
     context::Context, BlockEndpoint, BlockInfo, InputStream, InstanceMode, Message, OutputStream,
 };
 
-use crate::http::{err_not_found, ok_json};
+use super::rate_limit::UserRateLimiter;
+use crate::http::err_not_found;
 
 crate::solobase_feature_block! {
     /// Admin panel: users, database, IAM, logs, settings (`suppers-ai/admin`).
     pub struct AdminBlock;
+    fields: { limiter: UserRateLimiter },
     name: "suppers-ai/admin",
     info: |_this| {
         use wafer_run::{AuthLevel, CollectionSchema};
@@ -53,6 +98,23 @@ crate::solobase_feature_block! {
                 CollectionSchema::new(ROLES_TABLE),
                 CollectionSchema::new(PERMISSIONS_TABLE),
                 CollectionSchema::new(USER_ROLES_TABLE),
+                CollectionSchema::new(QUOTAS_TABLE),
+                CollectionSchema::new(QUOTA_COUNTERS_TABLE),
+                CollectionSchema::new(ANNOUNCEMENTS_TABLE),
+                CollectionSchema::new(ANNOUNCEMENT_DISMISSALS_TABLE),
+                CollectionSchema::new(FEATURE_FLAGS_TABLE),
+                CollectionSchema::new(INBOUND_WEBHOOKS_TABLE),
+                CollectionSchema::new(INBOUND_WEBHOOK_DELIVERIES_TABLE),
+                CollectionSchema::new(JOBS_TABLE),
+                CollectionSchema::new(SCHEDULES_TABLE),
+                CollectionSchema::new(NOTIFICATIONS_TABLE),
+                CollectionSchema::new(NOTIFICATION_PREFERENCES_TABLE),
+                CollectionSchema::new(RETENTION_POLICIES_TABLE),
+                CollectionSchema::new(RETENTION_RUNS_TABLE),
+                CollectionSchema::new(USED_NONCES_TABLE),
+                CollectionSchema::new(USER_IMPORTS_TABLE),
+                CollectionSchema::new(OPERATIONS_TABLE),
+                CollectionSchema::new(TRANSLATIONS_TABLE),
                 CollectionSchema::new(VARIABLES_TABLE),
                 CollectionSchema::new(AUDIT_LOGS_TABLE),
                 CollectionSchema::new(REQUEST_LOGS_TABLE),
@@ -81,6 +143,61 @@ crate::solobase_feature_block! {
                 // Infrastructure logging: storage wrapper + pipeline write logs
                 wafer_run::ResourceGrant::read_write("*", STORAGE_ACCESS_LOGS_TABLE),
                 wafer_run::ResourceGrant::read_write("*", REQUEST_LOGS_TABLE),
+                // Files records bucket create/delete/visibility changes.
+                wafer_run::ResourceGrant::read_write("suppers-ai/files", AUDIT_LOGS_TABLE),
+                // Auth-ui records API key creation and revocation.
+                wafer_run::ResourceGrant::read_write(
+                    super::auth_ui::AUTH_UI_BLOCK_ID,
+                    AUDIT_LOGS_TABLE,
+                ),
+                // Usage quotas: the pipeline (running as the router) counts
+                // tagged requests; auth-ui serves users their own standing.
+                wafer_run::ResourceGrant::read("suppers-ai/router", QUOTAS_TABLE),
+                wafer_run::ResourceGrant::read_write("suppers-ai/router", QUOTA_COUNTERS_TABLE),
+                wafer_run::ResourceGrant::read(super::auth_ui::AUTH_UI_BLOCK_ID, QUOTAS_TABLE),
+                wafer_run::ResourceGrant::read(
+                    super::auth_ui::AUTH_UI_BLOCK_ID,
+                    QUOTA_COUNTERS_TABLE,
+                ),
+                // Route permissions: the router checks core routes and each
+                // extension its own against the caller's roles; extensions
+                // catalogue the permissions they declare at init.
+                wafer_run::ResourceGrant::read("*", ROLES_TABLE),
+                wafer_run::ResourceGrant::read_write("*", PERMISSIONS_TABLE),
+                // Announcement banners: auth-ui serves the caller's active
+                // banners and records their dismissals.
+                wafer_run::ResourceGrant::read(
+                    super::auth_ui::AUTH_UI_BLOCK_ID,
+                    ANNOUNCEMENTS_TABLE,
+                ),
+                wafer_run::ResourceGrant::read_write(
+                    super::auth_ui::AUTH_UI_BLOCK_ID,
+                    ANNOUNCEMENT_DISMISSALS_TABLE,
+                ),
+                // Feature flags: any block (or extension) may read the
+                // definitions to gate its own behavior; only admin writes.
+                wafer_run::ResourceGrant::read("*", FEATURE_FLAGS_TABLE),
+                // Background jobs: any block may enqueue, and the runner
+                // claims and settles jobs as whichever block drains them.
+                wafer_run::ResourceGrant::read_write("*", JOBS_TABLE),
+                // Notifications: any block (or extension) may post to a
+                // user's inbox and read the preferences that decide the
+                // email; auth-ui serves the inbox and stores preferences.
+                wafer_run::ResourceGrant::read_write("*", NOTIFICATIONS_TABLE),
+                wafer_run::ResourceGrant::read("*", NOTIFICATION_PREFERENCES_TABLE),
+                wafer_run::ResourceGrant::read_write(
+                    super::auth_ui::AUTH_UI_BLOCK_ID,
+                    NOTIFICATION_PREFERENCES_TABLE,
+                ),
+                // Replay protection: any block may spend nonces of the
+                // signed requests it accepts.
+                wafer_run::ResourceGrant::read_write("*", USED_NONCES_TABLE),
+                // Operations: any block may start one for a request and
+                // report its job's progress to it.
+                wafer_run::ResourceGrant::read_write("*", OPERATIONS_TABLE),
+                // Translations: the pipeline, the system block's catalog
+                // endpoint and the email block read the overrides.
+                wafer_run::ResourceGrant::read("*", TRANSLATIONS_TABLE),
                 // Default: allow all blocks to make outbound network requests.
                 // Remove this grant via the admin UI to restrict network access.
                 wafer_run::ResourceGrant::read("*", "*")
@@ -104,6 +221,43 @@ crate::solobase_feature_block! {
                 // Cross-block Storage grants are declared by the owning
                 // block, the same way Db grants are.
             ])
+            .config_keys(vec![
+                wafer_run::ConfigVar::new(
+                    table_io::IMPORT_MAX_ROWS_KEY,
+                    "Maximum data rows accepted by one CSV table import",
+                    "50000",
+                )
+                .name("CSV Import Row Limit")
+                .input_type(wafer_run::InputType::Text),
+                wafer_run::ConfigVar::new(
+                    inbound_webhooks::MAX_PAYLOAD_BYTES_KEY,
+                    "Maximum body size accepted by an inbound webhook, in bytes",
+                    "262144",
+                )
+                .name("Inbound Webhook Size Limit")
+                .input_type(wafer_run::InputType::Text),
+                wafer_run::ConfigVar::new(
+                    crate::blocks::jobs::CONCURRENCY_KEY,
+                    "Background jobs the native worker runs at once",
+                    crate::blocks::jobs::DEFAULT_CONCURRENCY,
+                )
+                .name("Job Concurrency")
+                .input_type(wafer_run::InputType::Text),
+                wafer_run::ConfigVar::new(
+                    crate::version::UPDATE_CHECK_KEY,
+                    "Check daily for a newer Solobase release (native builds only)",
+                    "false",
+                )
+                .name("Update Check")
+                .input_type(wafer_run::InputType::Toggle),
+                wafer_run::ConfigVar::new(
+                    crate::version::UPDATE_URL_KEY,
+                    "Release feed the update check fetches; answers with a JSON tag_name",
+                    crate::version::DEFAULT_UPDATE_URL,
+                )
+                .name("Update Check URL")
+                .input_type(wafer_run::InputType::Url),
+            ])
             .category(wafer_run::BlockCategory::Feature)
             .description("Administration panel for managing users, roles, variables, blocks, and logs. Provides SSR dashboard with stats, user management with role assignment, IAM (roles and API keys), environment variables editor, block management with feature toggles, and system/audit log viewer.")
             .endpoints(vec![
@@ -120,15 +274,96 @@ crate::solobase_feature_block! {
                 BlockEndpoint::get("/b/admin/grants").summary("WRAP grants management").auth(AuthLevel::Admin),
                 BlockEndpoint::get("/b/admin/database").summary("Database admin page").auth(AuthLevel::Admin),
                 BlockEndpoint::post("/b/admin/database/query").summary("Run read-only SQL (SSR)").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/database/tables/{name}/export").summary("Export table rows as CSV").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/database/tables/{name}/import").summary("Import table rows from CSV").auth(AuthLevel::Admin),
                 BlockEndpoint::get("/b/admin/api/users").summary("List users API").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/users/import").summary("Import users from CSV (dry run or commit)").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/users/import/{id}").summary("Progress and results of a user import").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/users/{id}/reset-password").summary("Email or return a single-use password reset link").auth(AuthLevel::Admin),
                 BlockEndpoint::get("/b/admin/api/iam/roles").summary("List roles API").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/iam/quotas").summary("List usage-quota definitions").auth(AuthLevel::Admin),
+                // Replaces the whole set; PUT and PATCH both arrive as `update`.
+                BlockEndpoint::patch("/b/admin/api/iam/quotas").summary("Replace usage-quota definitions").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/announcements").summary("List announcement banners").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/announcements").summary("Create an announcement banner").auth(AuthLevel::Admin),
+                BlockEndpoint::patch("/b/admin/api/announcements/{id}").summary("Update an announcement banner").auth(AuthLevel::Admin),
+                BlockEndpoint::delete("/b/admin/api/announcements/{id}").summary("Delete an announcement banner").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/feature-flags").summary("List feature flags").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/feature-flags").summary("Create a feature flag").auth(AuthLevel::Admin),
+                BlockEndpoint::patch("/b/admin/api/feature-flags/{key}").summary("Update a feature flag's switch, rollout or targets").auth(AuthLevel::Admin),
+                BlockEndpoint::delete("/b/admin/api/feature-flags/{key}").summary("Delete a feature flag").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/inbound-webhooks").summary("List inbound webhook endpoints").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/inbound-webhooks").summary("Create an inbound webhook endpoint").auth(AuthLevel::Admin),
+                BlockEndpoint::patch("/b/admin/api/inbound-webhooks/{id}").summary("Update or rotate an inbound webhook endpoint").auth(AuthLevel::Admin),
+                BlockEndpoint::delete("/b/admin/api/inbound-webhooks/{id}").summary("Delete an inbound webhook endpoint").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/inbound-webhooks/{id}/deliveries").summary("Recent inbound webhook deliveries").auth(AuthLevel::Admin),
+                // Unauthenticated: the HMAC signature is the credential.
+                BlockEndpoint::post("/b/hooks/in/{id}").summary("Receive a signed inbound webhook").auth(AuthLevel::Public),
                 BlockEndpoint::get("/b/admin/api/settings").summary("List variables API").auth(AuthLevel::Admin),
                 BlockEndpoint::get("/b/admin/api/logs").summary("Audit logs API").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/extensions").summary("Registered blocks and schema state").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/extensions/schema").summary("Expected vs present tables per block").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/extensions/{block}/migrations/retry").summary("Retry a block's failed migrations").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/extensions/{block}/enable").summary("Enable a block on the running server").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/extensions/{block}/disable").summary("Disable a block on the running server").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/extensions/{block}/config-ui").summary("A block's settings form: sections, fields and current values").auth(AuthLevel::Admin),
+                BlockEndpoint::patch("/b/admin/api/extensions/{block}/config-ui").summary("Save a block's settings (PUT or PATCH); masked secrets are kept").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/translations").summary("List per-locale message overrides").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/translations/{locale}").summary("Get one locale's message overrides").auth(AuthLevel::Admin),
+                // PUT and PATCH both arrive as `update` and replace the set.
+                BlockEndpoint::patch("/b/admin/api/translations/{locale}").summary("Replace a locale's message overrides (PUT or PATCH)").auth(AuthLevel::Admin),
+                BlockEndpoint::delete("/b/admin/api/translations/{locale}").summary("Delete a locale's message overrides").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/email/log").summary("Email delivery log").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/invitations").summary("List signup invitations").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/invitations").summary("Invite an email address to sign up").auth(AuthLevel::Admin),
+                BlockEndpoint::delete("/b/admin/api/invitations/{id}").summary("Revoke a pending invitation").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/api-keys").summary("List every user's API keys, without secrets").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/api-keys/{id}/revoke").summary("Revoke any user's API key").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/service-accounts").summary("List service accounts").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/service-accounts").summary("Create a service account and its first API key").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/service-accounts/{id}").summary("Get a service account with its roles and keys").auth(AuthLevel::Admin),
+                BlockEndpoint::patch("/b/admin/api/service-accounts/{id}").summary("Rename, disable or re-limit a service account").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/service-accounts/{id}/keys").summary("Rotate a service account's API key").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/jobs").summary("Recent background jobs").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/jobs/run").summary("Run due background jobs now").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/jobs/{id}/retry").summary("Requeue a dead background job").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/jobs/schedules").summary("Recurring job schedules").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/jobs/schedules/{name}/run").summary("Run a scheduled job now").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/jobs/schedules/{name}/pause").summary("Pause a schedule").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/jobs/schedules/{name}/resume").summary("Resume a paused schedule").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/retention").summary("Data retention policies").auth(AuthLevel::Admin),
+                // PUT and PATCH both arrive as `update`.
+                BlockEndpoint::patch("/b/admin/api/retention").summary("Change data retention policies").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/retention/last-run").summary("What the last retention run deleted").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/email/log/{id}/resend").summary("Re-send a failed email").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/admin/api/config/reload").summary("Reload runtime-safe settings").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/config/cache").summary("Query cache hit/miss counters").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/admin/api/diagnostics").summary("Startup preflight report").auth(AuthLevel::Admin),
+                // `/api/iam/my-permissions` with the `/api` prefix stripped.
+                BlockEndpoint::get("/iam/my-permissions").summary("The caller's roles, permissions and openable admin sections").auth(AuthLevel::Authenticated),
+                // `/api/operations...` likewise.
+                BlockEndpoint::get("/operations").summary("The caller's long-running operations").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/operations/{id}").summary("A long-running operation's state, progress and result").auth(AuthLevel::Authenticated),
             ])
     },
-    handle: |_this, ctx, msg, input| {
+    handle: |this, ctx, msg, input| {
         use route::AdminRoute;
 
+        // Deferred work queued for this block (see `blocks::jobs`).
+        if msg.kind == crate::blocks::jobs::RUN_KIND {
+            use crate::blocks::{jobs, notifications, retention};
+            return match jobs::job_type(&msg) {
+                notifications::EMAIL_JOB => {
+                    jobs::respond(notifications::run_email_job(ctx, input).await)
+                }
+                retention::RUN_JOB => jobs::respond(retention::run_job(ctx).await),
+                user_import::IMPORT_JOB => {
+                    jobs::respond(user_import::run_job(ctx, input).await)
+                }
+                _ => jobs::unknown_type(&msg),
+            };
+        }
+
         let path_owned = msg.path().to_string();
         let action_owned = msg.action().to_string();
 
@@ -146,26 +381,36 @@ crate::solobase_feature_block! {
 
         match route::route(&path_owned, &action_owned) {
             // --- /b/admin/api/... ---
-            AdminRoute::UsersApi => users::handle(ctx, &msg, &api_norm, input).await,
+            AdminRoute::UsersApi => users::handle(ctx, &msg, &this.limiter, &api_norm, input).await,
             AdminRoute::DatabaseApi => database::handle(ctx, &msg, &api_norm, input).await,
             AdminRoute::IamApi => iam::handle(ctx, &msg, &api_norm, input).await,
+            AdminRoute::AnnouncementsApi => {
+                announcements::handle(ctx, &msg, &api_norm, input).await
+            }
+            AdminRoute::FeatureFlagsApi => {
+                feature_flags::handle(ctx, &msg, &api_norm, input).await
+            }
+            AdminRoute::InboundWebhooksApi => {
+                inbound_webhooks::handle(ctx, &msg, &api_norm, input).await
+            }
+            AdminRoute::InvitationsApi => {
+                invitations::handle(ctx, &msg, &api_norm, input).await
+            }
+            AdminRoute::JobsApi => jobs::handle(ctx, &msg, &api_norm).await,
             AdminRoute::LogsApi => logs::handle(ctx, &msg, &api_norm).await,
+            AdminRoute::RetentionApi => retention::handle(ctx, &msg, &api_norm, input).await,
+            AdminRoute::ApiKeysApi => api_keys::handle(ctx, &msg, &api_norm).await,
+            AdminRoute::ServiceAccountsApi => {
+                service_accounts::handle(ctx, &msg, &api_norm, input).await
+            }
             AdminRoute::SettingsApi => settings::handle(ctx, &msg, &api_norm, input).await,
-            AdminRoute::ExtensionsApi => {
-                let blocks: Vec<_> = ctx
-                    .registered_blocks()
-                    .iter()
-                    .map(|b| {
-                        serde_json::json!({
-                            "name": b.name,
-                            "version": b.version,
-                            "interface": b.interface,
-                            "summary": b.summary,
-                            "enabled": true,
-                        })
-                    })
-                    .collect();
-                ok_json(&blocks)
+            AdminRoute::TranslationsApi => {
+                translations::handle(ctx, &msg, &api_norm, input).await
+            }
+            AdminRoute::ExtensionsApi => extensions::handle(ctx, &msg, &api_norm, input).await,
+            AdminRoute::EmailApi => email_log::handle(ctx, &msg, &api_norm).await,
+            AdminRoute::ConfigApi | AdminRoute::DiagnosticsApi => {
+                runtime::handle(ctx, &msg, &api_norm).await
             }
             AdminRoute::StorageDelegate => {
                 // The original handler re-set req.resource INSIDE the if branch
@@ -174,14 +419,25 @@ crate::solobase_feature_block! {
                 // from path_owned (NOT msg.path() which is now normalized).
                 let api_rest = path_owned.strip_prefix("/b/admin/api").unwrap_or("");
                 msg.set_meta("req.resource", format!("/admin{api_rest}"));
+                as_storage_admin(&mut msg);
                 ctx.call_block("suppers-ai/files", msg, input).await
             }
             AdminRoute::CloudStorageDelegate { rest } => {
                 msg.set_meta("req.resource", format!("/admin/b/cloudstorage{rest}"));
+                as_storage_admin(&mut msg);
                 ctx.call_block("suppers-ai/files", msg, input).await
             }
             AdminRoute::ApiNotFound => err_not_found("not found"),
 
+            // --- /iam/... ---
+            AdminRoute::MyPermissions => iam::handle_my_permissions(ctx, &msg).await,
+            AdminRoute::Operations => crate::blocks::operations::handle(ctx, &msg).await,
+
+            // --- /b/hooks/in/... ---
+            AdminRoute::InboundWebhook { endpoint_id } => {
+                inbound_webhooks::receive(ctx, &msg, &this.limiter, endpoint_id, input).await
+            }
+
             // --- /b/admin/settings/... ---
             AdminRoute::SettingsRedirect => redirect_308("/b/admin/settings/email"),
             AdminRoute::SettingsPage { tab } => pages::settings_page(ctx, &msg, tab).await,
@@ -261,6 +517,8 @@ crate::solobase_feature_block! {
         if matches!(event.event_type, wafer_run::LifecycleType::Init) {
             iam::seed_defaults(ctx).await;
             settings::seed_defaults(ctx).await;
+            #[cfg(not(target_arch = "wasm32"))]
+            crate::blocks::jobs::set_worker_context(ctx);
         }
         Ok(())
     },
@@ -279,6 +537,24 @@ fn redirect_308(target: &str) -> OutputStream {
         .body(Vec::new(), "text/plain")
 }
 
+/// A non-admin only reaches the storage delegates by holding
+/// `storage.admin` (see [`STORAGE_PERMISSION_ROUTES`]). The files block
+/// scopes buckets and force-deletes by the admin role, so the delegated
+/// call carries it: these routes are the whole of what the permission
+/// grants.
+fn as_storage_admin(msg: &mut Message) {
+    if crate::util::is_admin(msg) {
+        return;
+    }
+    let roles = msg.get_meta("auth.user_roles").to_string();
+    let roles = if roles.is_empty() {
+        "admin".to_string()
+    } else {
+        format!("{roles},admin")
+    };
+    msg.set_meta("auth.user_roles", roles);
+}
+
 // ---------------------------------------------------------------------------
 // WRAP grant handlers
 // ---------------------------------------------------------------------------
@@ -26,7 +26,9 @@ use wafer_block::db::{Filter, FilterOp};
 use wafer_core::clients::database as db;
 use wafer_run::{context::Context, ErrorCode, Message, OutputStream};
 
-use super::{logs::audit_log, settings::VARIABLES_TABLE, ROLES_TABLE, USER_ROLES_TABLE};
+use super::{
+    logs::audit_log, settings::VARIABLES_TABLE, ADMIN_BLOCK_ID, ROLES_TABLE, USER_ROLES_TABLE,
+};
 /// SSRF URL validator for `InputType::Url` writes. The single implementation
 /// lives in [`crate::util::validate_url_value`]; re-exported here so the admin
 /// variable create/update paths and the generic settings form
@@ -43,8 +45,19 @@ pub(super) use crate::util::validate_url_value;
 /// call sites in this module tree keep working.
 pub(super) use crate::util::{is_sensitive_key, MASKED_VALUE};
 use crate::{
-    blocks::auth::USERS_TABLE,
-    http::{err_bad_request, err_forbidden, err_internal, err_not_found},
+    blocks::{
+        auth::{
+            helpers::get_user_roles,
+            repo::{sessions, tokens, users},
+            USERS_TABLE,
+        },
+        auth_ui::api::reset_token,
+        permissions,
+    },
+    cache,
+    http::{err_bad_request, err_forbidden, err_internal, err_internal_no_cause, err_not_found},
+    revisions::{self, Category},
+    services::Services,
     util::RecordExt,
 };
 
@@ -91,6 +104,32 @@ pub(super) async fn fetch_roles(
 // User mutations
 // ---------------------------------------------------------------------------
 
+/// Refuse a caller let in by `users.manage` acting on `user_id` when the
+/// user's roles hold permissions the caller doesn't (see
+/// [`permissions::covers`]): a user admin can't disable an admin or take
+/// over their account with a reset link. Admins pass untouched.
+pub(super) async fn guard_target(
+    ctx: &dyn Context,
+    msg: &Message,
+    user_id: &str,
+) -> Result<(), OutputStream> {
+    if crate::util::is_admin(msg) {
+        return Ok(());
+    }
+    let roles = match get_user_roles(ctx, user_id).await {
+        Ok(roles) => roles,
+        Err(e) => return Err(err_internal("Database error", e)),
+    };
+    let roles: Vec<&str> = roles.iter().map(String::as_str).collect();
+    match permissions::covers(ctx, msg, &roles).await {
+        Ok(true) => Ok(()),
+        Ok(false) => Err(err_forbidden(
+            "Cannot manage a user who holds permissions you don't",
+        )),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
 /// Set the `disabled` flag on a user (true = disable, false = enable), writing
 /// an audit-log row. The self-disable guard only applies when disabling.
 ///
@@ -107,6 +146,7 @@ pub(super) async fn set_user_disabled(
     if disabled && admin_id == user_id {
         return Err(err_bad_request("Cannot disable your own account"));
     }
+    guard_target(ctx, msg, user_id).await?;
 
     let mut data = HashMap::new();
     data.insert("disabled".to_string(), serde_json::json!(disabled));
@@ -120,6 +160,8 @@ pub(super) async fn set_user_disabled(
         Err(e) if e.code == ErrorCode::NotFound => return Err(err_not_found("User not found")),
         Err(e) => return Err(err_internal("Database error", e)),
     };
+    // Drops the cached `users::is_active` answer the auth gate reads.
+    cache::invalidate_table(USERS_TABLE);
 
     let action = if disabled {
         "user.disable"
@@ -138,6 +180,11 @@ pub(super) async fn set_user_disabled(
 }
 
 /// Soft-delete a user, writing an audit-log row. Rejects self-deletion.
+///
+/// The account stops authenticating at once: its sessions are deleted and
+/// its refresh tokens revoked, and the auth gate's cached answer is
+/// dropped. The row, and with it the email address, stays until the
+/// `deleted_users` retention policy purges it.
 pub(super) async fn delete_user(
     ctx: &dyn Context,
     msg: &Message,
@@ -147,12 +194,20 @@ pub(super) async fn delete_user(
     if admin_id == user_id {
         return Err(err_bad_request("Cannot delete your own account"));
     }
+    guard_target(ctx, msg, user_id).await?;
 
     match db::soft_delete(ctx, USERS_TABLE, user_id).await {
         Ok(_) => {}
         Err(e) if e.code == ErrorCode::NotFound => return Err(err_not_found("User not found")),
         Err(e) => return Err(err_internal("Database error", e)),
     }
+    cache::invalidate_table(USERS_TABLE);
+    if let Err(e) = sessions::delete_all_for_user(ctx, user_id).await {
+        tracing::warn!(user_id = %user_id, error = %e, "failed to delete a deleted user's sessions");
+    }
+    if let Err(e) = tokens::revoke_all_for_user(ctx, user_id).await {
+        tracing::warn!(user_id = %user_id, error = %e, "failed to revoke a deleted user's tokens");
+    }
 
     audit_log(
         ctx,
@@ -184,6 +239,7 @@ pub(super) async fn update_user_fields(
             }
         }
     }
+    guard_target(ctx, msg, user_id).await?;
 
     let mut data = HashMap::new();
     for key in &["name", "disabled", "avatar_url"] {
@@ -201,6 +257,8 @@ pub(super) async fn update_user_fields(
         Err(e) if e.code == ErrorCode::NotFound => return Err(err_not_found("User not found")),
         Err(e) => return Err(err_internal("Database error", e)),
     };
+    // Drops the cached `users::is_active` answer the auth gate reads.
+    cache::invalidate_table(USERS_TABLE);
 
     audit_log(
         ctx,
@@ -213,6 +271,83 @@ pub(super) async fn update_user_fields(
     Ok(record)
 }
 
+/// How long an admin-issued reset link stays valid; the same hour as a
+/// self-service one, which the `password_reset` email promises.
+pub(super) const RESET_LINK_HOURS: i64 = 1;
+
+/// How an admin-issued reset link reaches the user.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, serde::Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub(super) enum ResetDelivery {
+    /// Emailed to the account's address, like a self-service reset.
+    #[default]
+    Email,
+    /// Returned to the admin to hand over out of band.
+    Link,
+}
+
+/// Issue a single-use reset link for `user_id` and deliver it, writing an
+/// audit-log row whose action names the delivery: a returned link
+/// (`user.reset_password.link_returned`) is a credential the admin has
+/// seen, so it must stand out from an emailed one. The admin never sees or
+/// sets the password itself; the user picks it on the reset page, which
+/// also signs out every session.
+///
+/// Returns the link for [`ResetDelivery::Link`], `None` once emailed.
+pub(super) async fn issue_password_reset(
+    ctx: &dyn Context,
+    msg: &Message,
+    user_id: &str,
+    delivery: ResetDelivery,
+) -> Result<Option<String>, OutputStream> {
+    guard_target(ctx, msg, user_id).await?;
+    let user = match users::find_by_id(ctx, user_id).await {
+        Ok(Some(user)) if !user.is_deleted() => user,
+        Ok(_) => return Err(err_not_found("User not found")),
+        Err(e) => return Err(err_internal("Database error", e)),
+    };
+    if user.is_service_account() {
+        return Err(err_bad_request("Service accounts have no password"));
+    }
+    if user.disabled {
+        return Err(err_bad_request(
+            "Enable the account before resetting its password",
+        ));
+    }
+
+    let valid_for = chrono::Duration::hours(RESET_LINK_HOURS);
+    let token = match reset_token::issue(ctx, user_id, valid_for).await {
+        Ok(token) => token,
+        Err(e) => return Err(err_internal("Failed to issue reset token", e)),
+    };
+    let (action, link) = match delivery {
+        ResetDelivery::Email => {
+            let mailer = Services::new(ctx, ADMIN_BLOCK_ID).mailer();
+            if !mailer
+                .send_template("password_reset", &user.email, &token)
+                .await
+            {
+                return Err(err_internal_no_cause("The reset email could not be sent"));
+            }
+            ("user.reset_password.email", None)
+        }
+        ResetDelivery::Link => (
+            "user.reset_password.link_returned",
+            Some(reset_token::link(ctx, &token).await),
+        ),
+    };
+
+    audit_log(
+        ctx,
+        msg.user_id(),
+        action,
+        &format!("users/{user_id}"),
+        msg.remote_addr(),
+    )
+    .await;
+    Ok(link)
+}
+
 // ---------------------------------------------------------------------------
 // Role mutations
 // ---------------------------------------------------------------------------
@@ -333,6 +468,7 @@ pub(super) async fn create_variable(
         Ok(record) => record,
         Err(e) => return Err(err_internal("Database error", e)),
     };
+    revisions::bump(Category::Settings);
 
     audit_log(
         ctx,
@@ -435,6 +571,7 @@ pub(super) async fn update_variable(
         Ok(record) => record,
         Err(e) => return Err(err_internal("Database error", e)),
     };
+    revisions::bump(Category::Settings);
 
     audit_log(
         ctx,
@@ -758,4 +895,123 @@ mod tests {
         expect_ok(set_user_disabled(&ctx, &msg, "u2", true).await);
         assert_eq!(audit_count(&ctx, "user.disable").await, 1);
     }
+
+    #[tokio::test]
+    async fn returned_reset_link_is_single_use_and_audited_as_such() {
+        use std::sync::Arc;
+
+        let mut ctx = TestContext::with_auth().await;
+        let svc = Arc::new(
+            wafer_block_crypto::service::Argon2JwtCryptoService::new(
+                "test-jwt-secret-padded-to-min-32-bytes-aaaa".to_string(),
+            )
+            .expect("test secret is long enough"),
+        );
+        ctx.register_block(
+            "wafer-run/crypto",
+            Arc::new(wafer_core::service_blocks::crypto::CryptoBlock::new(svc)),
+        );
+        let user = users::insert(
+            &ctx,
+            users::NewUser {
+                email: "locked-out@example.com".into(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap();
+        let msg = admin_msg("create", "/admin/users/x/reset-password");
+
+        let link = expect_ok(issue_password_reset(&ctx, &msg, &user.id, ResetDelivery::Link).await)
+            .expect("link delivery returns the link");
+        let token = link.split_once("token=").unwrap().1;
+        assert_eq!(reset_token::check(&ctx, token).await, Ok(user.id.clone()));
+        assert_eq!(
+            audit_count(&ctx, "user.reset_password.link_returned").await,
+            1
+        );
+        assert_eq!(audit_count(&ctx, "user.reset_password.email").await, 0);
+
+        // A second link replaces the first.
+        expect_ok(issue_password_reset(&ctx, &msg, &user.id, ResetDelivery::Link).await);
+        assert!(reset_token::check(&ctx, token).await.is_err());
+
+        // Gone accounts get nothing.
+        expect_ok(delete_user(&ctx, &msg, &user.id).await);
+        assert!(
+            issue_password_reset(&ctx, &msg, &user.id, ResetDelivery::Link)
+                .await
+                .is_err()
+        );
+        assert_eq!(
+            audit_count(&ctx, "user.reset_password.link_returned").await,
+            2
+        );
+    }
+
+    #[tokio::test]
+    async fn scoped_user_admins_cannot_act_on_users_above_them() {
+        use crate::test_support::{auth_msg, output_status};
+
+        let ctx = TestContext::with_auth().await;
+        for (name, held) in [
+            ("user", "storage.write"),
+            ("user_admin", "users.manage"),
+            ("storage_admin", "storage.admin"),
+        ] {
+            let mut data = crate::util::json_map(serde_json::json!({
+                "name": name,
+                "permissions": [held],
+            }));
+            crate::util::stamp_created(&mut data);
+            db::create(&ctx, ROLES_TABLE, data).await.unwrap();
+        }
+        let mut ids = HashMap::new();
+        for role in ["user", "admin", "storage_admin"] {
+            let user = users::insert(
+                &ctx,
+                users::NewUser {
+                    email: format!("{role}@example.com"),
+                    display_name: String::new(),
+                    avatar_url: None,
+                    role: role.into(),
+                },
+            )
+            .await
+            .unwrap();
+            ids.insert(role, user.id);
+        }
+        let mut msg = auth_msg("create", "/admin/users/x/disable", "support_1");
+        msg.set_meta("auth.user_roles", "user,user_admin");
+        let status = |res: Result<db::Record, OutputStream>| async move {
+            match res {
+                Ok(_) => 200,
+                Err(out) => output_status(out).await,
+            }
+        };
+
+        assert_eq!(
+            status(set_user_disabled(&ctx, &msg, &ids["user"], true).await).await,
+            200
+        );
+        assert_eq!(
+            status(set_user_disabled(&ctx, &msg, &ids["admin"], true).await).await,
+            403
+        );
+        let other = set_user_disabled(&ctx, &msg, &ids["storage_admin"], true).await;
+        assert_eq!(status(other).await, 403);
+        assert!(delete_user(&ctx, &msg, &ids["admin"]).await.is_err());
+        assert!(
+            issue_password_reset(&ctx, &msg, &ids["admin"], ResetDelivery::Link)
+                .await
+                .is_err()
+        );
+        assert_eq!(audit_count(&ctx, "user.disable").await, 1);
+
+        // Admins stay unrestricted.
+        let admin = admin_msg("create", "/admin/users/x/disable");
+        expect_ok(set_user_disabled(&ctx, &admin, &ids["admin"], true).await);
+    }
 }
@@ -145,7 +145,7 @@ pub async fn blocks_page(ctx: &dyn Context, msg: &Message) -> OutputStream {
                 // Runtime filter dropdown
                 div .block-cards__filter {
                     select .form-input
-                        onchange={"window.location.href='/b/admin/blocks?tab=" (active_tab) "&runtime='+this.value"}
+                        onchange={"window.location.href=(window.solobaseUrl||String)('/b/admin/blocks?tab=" (active_tab) "&runtime='+this.value)"}
                     {
                         option value="" selected[runtime_filter.is_empty()] { "All runtimes" }
                         option value="native" selected[runtime_filter == "native"] { "Native only" }
@@ -246,7 +246,7 @@ pub async fn handle_toggle_feature(
     // Read current state and toggle via shared helper (audit finding #12).
     let current_enabled = super::super::settings::block_settings::is_enabled(ctx, block_name).await;
     let new_enabled = !current_enabled;
-    let _ = super::super::settings::block_settings::set_enabled(ctx, block_name, new_enabled).await;
+    let _ = super::super::settings::block_settings::apply(ctx, block_name, new_enabled).await;
 
     let admin_id = msg.user_id().to_string();
     let ip = msg.remote_addr().to_string();
@@ -315,6 +315,11 @@ pub async fn handle_block_detail(
         return ui::html_response(markup);
     };
 
+    let dynamic = super::super::extensions::admin_grants(ctx).await;
+    let grants = crate::table_scope::received_grants(blocks, &dynamic, &block.name);
+    let table_prefix = crate::table_scope::table_prefix(&block.name);
+    let metrics = crate::block_metrics::get(&block.name);
+
     let markup = html! {
         div .modal-header {
             div {
@@ -355,11 +360,31 @@ pub async fn handle_block_detail(
                 }
             }
 
+            @if is_enabled && crate::features::init_deferred(&block.name) {
+                p .text-sm .text-muted .mb-4 {
+                    "This block was disabled when the server started, so its setup was skipped. Restart the server to finish enabling it."
+                }
+            }
+
             // Description
             @if !block.description.is_empty() {
                 p style="font-size:0.875rem;color:#64748b;line-height:1.6;margin-bottom:1rem" { (block.description) }
             }
 
+            // Traffic since this process (or Worker isolate) started.
+            p .text-sm .text-muted .mb-4 {
+                @match &metrics {
+                    Some(m) => {
+                        (m.requests) " requests, " (m.errors) " errors"
+                        @if let (Some(p50), Some(p95)) = (m.p50_ms, m.p95_ms) {
+                            " \u{00b7} p50 " (p50) " ms, p95 " (p95) " ms"
+                        }
+                        " since this instance started."
+                    }
+                    None => "No requests since this instance started.",
+                }
+            }
+
             // Endpoints
             @if !block.endpoints.is_empty() {
                 h4 style="font-size:0.875rem;font-weight:600;margin:1rem 0 0.5rem" { "Endpoints" }
@@ -425,6 +450,47 @@ pub async fn handle_block_detail(
                 }
             }
 
+            // Data access: the block's own table namespace plus every grant
+            // it holds on other blocks' resources (declared + admin-added).
+            h4 style="font-size:0.875rem;font-weight:600;margin:1rem 0 0.5rem" { "Data Access" }
+            p .text-sm .text-muted .mb-2 {
+                "Owns tables under " code style="font-size:12px" { (table_prefix) "*" }
+                ". Migrations that define tables outside this namespace are rejected."
+            }
+            @if grants.is_empty() {
+                p .text-sm .text-muted { "No grants on other blocks' resources." }
+            } @else {
+                div .table-container {
+                    table .table {
+                        thead {
+                            tr {
+                                th { "Resource" }
+                                th style="width:90px" { "Type" }
+                                th style="width:100px" { "Access" }
+                                th style="width:90px" { "Scope" }
+                            }
+                        }
+                        tbody {
+                            @for g in &grants {
+                                tr {
+                                    td { code style="font-size:12px" { (g.resource) } }
+                                    td .text-sm .text-muted {
+                                        @match &g.resource_type {
+                                            Some(t) => (t),
+                                            None => "any",
+                                        }
+                                    }
+                                    td .text-sm { @if g.write { "read/write" } @else { "read" } }
+                                    td .text-sm .text-muted {
+                                        @if g.grantee == "*" { "all blocks" } @else { "this block" }
+                                    }
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+
             // Technical details
             h4 style="font-size:0.875rem;font-weight:600;margin:1rem 0 0.5rem" { "Technical" }
             div style="font-size:13px;color:#64748b" {
@@ -10,7 +10,10 @@ use wafer_run::{context::Context, Message, OutputStream};
 
 use super::{admin_page, crumb};
 use crate::{
-    blocks::{admin::REQUEST_LOGS_TABLE as REQUEST_LOGS, auth::USERS_TABLE as USERS},
+    blocks::{
+        admin::REQUEST_LOGS_TABLE as REQUEST_LOGS,
+        auth::{repo::users, USERS_TABLE as USERS},
+    },
     ui::{
         shell::Topbar,
         templates::{dashboard_page, PageHeader, StatTile},
@@ -160,11 +163,14 @@ pub async fn dashboard(ctx: &dyn Context, msg: &Message) -> OutputStream {
     // This used to be 7 sequential round-trips on every dashboard load — a
     // measurable D1 amplification source on Cloudflare Workers.
 
-    let user_count_filters = [Filter {
-        field: "deleted_at".into(),
-        operator: FilterOp::IsNull,
-        value: serde_json::Value::Null,
-    }];
+    let user_count_filters = [
+        Filter {
+            field: "deleted_at".into(),
+            operator: FilterOp::IsNull,
+            value: serde_json::Value::Null,
+        },
+        users::people_only(),
+    ];
     let user_count_fut = db::count(ctx, USERS, &user_count_filters);
 
     let new_users_filters = [
@@ -178,6 +184,7 @@ pub async fn dashboard(ctx: &dyn Context, msg: &Message) -> OutputStream {
             operator: FilterOp::GreaterEqual,
             value: serde_json::json!(&today_start),
         },
+        users::people_only(),
     ];
     let new_users_fut = db::count(ctx, USERS, &new_users_filters);
 
@@ -224,11 +231,14 @@ pub async fn dashboard(ctx: &dyn Context, msg: &Message) -> OutputStream {
 
     let recent_users_opts = ListOptions {
         columns: Some(vec!["id".into(), "email".into(), "created_at".into()]),
-        filters: vec![Filter {
-            field: "deleted_at".into(),
-            operator: FilterOp::IsNull,
-            value: serde_json::Value::Null,
-        }],
+        filters: vec![
+            Filter {
+                field: "deleted_at".into(),
+                operator: FilterOp::IsNull,
+                value: serde_json::Value::Null,
+            },
+            users::people_only(),
+        ],
         sort: vec![SortField {
             field: "created_at".into(),
             desc: true,
@@ -306,6 +316,10 @@ pub async fn dashboard(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let requests_str = requests_today.to_string();
     let errors_str = errors_today.to_string();
     let avg_ms_str = format!("{avg_ms:.0}ms");
+    let version_str = format!("v{}", crate::version::VERSION);
+    let update_str = crate::version::update_status()
+        .filter(|s| s.update_available)
+        .map(|s| format!("{} available", s.latest_version));
 
     let stats = vec![
         StatTile {
@@ -333,6 +347,11 @@ pub async fn dashboard(ctx: &dyn Context, msg: &Message) -> OutputStream {
             value: &avg_ms_str,
             trend: None,
         },
+        StatTile {
+            label: "Version",
+            value: &version_str,
+            trend: update_str.as_deref(),
+        },
     ];
 
     let recent_users_card = html! {
@@ -15,7 +15,7 @@ use crate::{
 /// block also declares rate-limit + allowed-recipient vars that aren't
 /// editable from this page). Selected by key via `config_vars::var_in` so
 /// this page never re-declares label/default/input_type/sensitivity in a
-/// parallel table — the `ConfigVar` in `blocks/email.rs` is the single
+/// parallel table — the `ConfigVar` in `blocks/email/mod.rs` is the single
 /// source of truth, shared with `BlockInfo::config_keys` and the admin
 /// Variables page.
 const MAILGUN_KEYS: &[&str] = &[
@@ -26,14 +26,32 @@ const MAILGUN_KEYS: &[&str] = &[
     "SUPPERS_AI__EMAIL__MAILGUN_BASE_URL",
 ];
 
-fn mailgun_vars() -> Vec<ConfigVar> {
+/// Provider selection plus the SES and webhook settings, selected the same
+/// way as [`MAILGUN_KEYS`].
+const PROVIDER_KEYS: &[&str] = &[
+    email::providers::PROVIDER_KEY,
+    email::providers::SES_REGION_KEY,
+    email::providers::SES_ACCESS_KEY_ID_KEY,
+    email::providers::SES_SECRET_ACCESS_KEY_KEY,
+    email::providers::SES_FROM_KEY,
+    email::providers::WEBHOOK_URL_KEY,
+    email::providers::WEBHOOK_SECRET_KEY,
+];
+
+fn vars_for(keys: &[&str]) -> Vec<ConfigVar> {
     let own = email::config_vars();
-    MAILGUN_KEYS
-        .iter()
+    keys.iter()
         .map(|key| config_vars::var_in(&own, key))
         .collect()
 }
 
+/// Every var this page edits — what the save handler accepts.
+fn page_vars() -> Vec<ConfigVar> {
+    let mut vars = vars_for(PROVIDER_KEYS);
+    vars.extend(vars_for(MAILGUN_KEYS));
+    vars
+}
+
 /// Render the email settings tab body. The parent `settings_page` handler
 /// wraps this in the form-LESS `tabbed_page` shell, so this tab owns its
 /// `<form>` outright: the full self-contained `settings_form` (its own
@@ -43,9 +61,13 @@ fn mailgun_vars() -> Vec<ConfigVar> {
 /// block's admin settings page (products / userportal / legalpages /
 /// auth_ui).
 pub async fn settings_body(ctx: &dyn Context, _msg: &Message) -> Markup {
-    let vars = mailgun_vars();
-    let section = SettingsSection::new("Mailgun Configuration", icons::globe(), &vars);
-    settings_form::settings_form(ctx, "/b/admin/email", &[section], maud::html! {}).await
+    let provider = vars_for(PROVIDER_KEYS);
+    let mailgun = vars_for(MAILGUN_KEYS);
+    let sections = [
+        SettingsSection::new("Delivery Provider", icons::network(), &provider),
+        SettingsSection::new("Mailgun Configuration", icons::globe(), &mailgun),
+    ];
+    settings_form::settings_form(ctx, "/b/admin/email", &sections, maud::html! {}).await
 }
 
 pub async fn handle_save_email_settings(
@@ -53,7 +75,7 @@ pub async fn handle_save_email_settings(
     _msg: &Message,
     input: InputStream,
 ) -> OutputStream {
-    settings_form::save_settings(ctx, input, &mailgun_vars(), "email").await
+    settings_form::save_settings(ctx, input, &page_vars(), "email").await
 }
 
 #[cfg(test)]
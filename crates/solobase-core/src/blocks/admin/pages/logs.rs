@@ -5,7 +5,10 @@ use wafer_run::{context::Context, Message, OutputStream};
 
 use super::{admin_page, crumb};
 use crate::{
-    blocks::admin::{AUDIT_LOGS_TABLE as AUDIT_LOGS, REQUEST_LOGS_TABLE as REQUEST_LOGS},
+    blocks::{
+        admin::{AUDIT_LOGS_TABLE as AUDIT_LOGS, REQUEST_LOGS_TABLE as REQUEST_LOGS},
+        directory,
+    },
     ui::{
         components::{self, pagination},
         icons,
@@ -200,6 +203,18 @@ async fn audit_logs_tab(ctx: &dyn Context, msg: &Message) -> Markup {
         sort,
     )
     .await;
+    // Name each actor by their directory profile, in one lookup per page.
+    let users = match &result {
+        Ok(list) => {
+            let ids: Vec<&str> = list
+                .records
+                .iter()
+                .map(|r| r.str_field("user_id"))
+                .collect();
+            directory::lookup(ctx, &ids).await
+        }
+        Err(_) => Default::default(),
+    };
 
     html! {
         div .filter-bar {
@@ -236,7 +251,12 @@ async fn audit_logs_tab(ctx: &dyn Context, msg: &Message) -> Markup {
                                         span .badge .badge-info { (action) }
                                     }
                                     td .text-sm { (resource) }
-                                    td .text-muted .text-sm { (user_id.get(..8).unwrap_or(user_id)) }
+                                    td .text-muted .text-sm title=(user_id) {
+                                        @match users.get(user_id) {
+                                            Some(profile) => (profile.label()),
+                                            None => (user_id.get(..8).unwrap_or(user_id)),
+                                        }
+                                    }
                                     td .text-muted .text-sm { (ip) }
                                     td .text-muted .text-sm { (created.get(..19).unwrap_or(created)) }
                                 }
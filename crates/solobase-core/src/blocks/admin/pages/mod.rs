@@ -56,6 +56,7 @@ pub(crate) fn admin_page(
         current_path: path,
         topbar,
         body: content,
+        filter_nav: user.is_some_and(|u| !u.is_admin()),
     }
     .response(msg)
 }
@@ -7,7 +7,10 @@ use super::{admin_page, crumb};
 use crate::{
     blocks::{
         admin::{ops, ROLES_TABLE},
-        auth::{API_KEYS_TABLE as API_KEYS, USERS_TABLE as USERS},
+        auth::{
+            repo::{api_keys, users},
+            API_KEYS_TABLE as API_KEYS, USERS_TABLE as USERS,
+        },
     },
     http::ResponseBuilder,
     ui::{
@@ -24,33 +27,37 @@ use crate::{
 pub async fn users_page(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let config = SiteConfig::load(ctx).await;
     let user = UserInfo::from_message(msg);
+    // Roles and API keys are IAM, which stays with full admins; a caller
+    // let in by `users.manage` only gets the Users tab.
+    let full_admin = crate::util::is_admin(msg);
     let tab = msg.query("tab");
     let active_tab = match tab {
-        "roles" => "roles",
-        "api-keys" => "api-keys",
+        "roles" if full_admin => "roles",
+        "api-keys" if full_admin => "api-keys",
         _ => "users",
     };
 
-    let tabs_markup = components::tab_navigation(vec![
-        components::Tab {
-            active: active_tab == "users",
-            href: "/b/admin/users",
-            label: "Users",
-            icon: Some(icons::users()),
-        },
-        components::Tab {
+    let mut tabs = vec![components::Tab {
+        active: active_tab == "users",
+        href: "/b/admin/users",
+        label: "Users",
+        icon: Some(icons::users()),
+    }];
+    if full_admin {
+        tabs.push(components::Tab {
             active: active_tab == "roles",
             href: "/b/admin/users?tab=roles",
             label: "Roles",
             icon: Some(icons::shield()),
-        },
-        components::Tab {
+        });
+        tabs.push(components::Tab {
             active: active_tab == "api-keys",
             href: "/b/admin/users?tab=api-keys",
             label: "API Keys",
             icon: Some(icons::key()),
-        },
-    ]);
+        });
+    }
+    let tabs_markup = components::tab_navigation(tabs);
 
     let current_uid = user
         .as_ref()
@@ -121,6 +128,7 @@ async fn users_tab(ctx: &dyn Context, msg: &Message, current_user_id: &str) -> M
                         operator: FilterOp::IsNull,
                         value: serde_json::Value::Null,
                     }),
+                    FilterTree::Leaf(users::people_only()),
                     FilterTree::Any(vec![
                         FilterTree::Leaf(Filter {
                             field: "email".into(),
@@ -146,11 +154,14 @@ async fn users_tab(ctx: &dyn Context, msg: &Message, current_user_id: &str) -> M
         )
         .await
     } else {
-        let filters = vec![Filter {
-            field: "deleted_at".into(),
-            operator: FilterOp::IsNull,
-            value: serde_json::Value::Null,
-        }];
+        let filters = vec![
+            Filter {
+                field: "deleted_at".into(),
+                operator: FilterOp::IsNull,
+                value: serde_json::Value::Null,
+            },
+            users::people_only(),
+        ];
         let sort = vec![SortField {
             field: "created_at".into(),
             desc: true,
@@ -474,7 +485,9 @@ async fn api_keys_tab(ctx: &dyn Context) -> Markup {
                                 th { "Prefix" }
                                 th { "Name" }
                                 th { "User" }
+                                th { "Scopes" }
                                 th { "Created" }
+                                th { "Last used" }
                                 th { "Status" }
                                 th { "Actions" }
                             }
@@ -482,7 +495,7 @@ async fn api_keys_tab(ctx: &dyn Context) -> Markup {
                         tbody {
                             @if list.records.is_empty() {
                                 tr {
-                                    td colspan="6" .text-center .text-muted style="padding: 2rem;" { "No API keys" }
+                                    td colspan="8" .text-center .text-muted style="padding: 2rem;" { "No API keys" }
                                 }
                             }
                             @for record in &list.records {
@@ -491,11 +504,21 @@ async fn api_keys_tab(ctx: &dyn Context) -> Markup {
                                 @let user_id = record.str_field("user_id");
                                 @let created = record.str_field("created_at");
                                 @let revoked = record.str_field("revoked_at");
+                                @let scopes = api_keys::row_from_map(&record.data)
+                                    .map(|k| k.scopes.join(", "))
+                                    .unwrap_or_default();
+                                @let last_used = record.str_field("last_used_at");
                                 tr {
                                     td { code { (prefix) "..." } }
                                     td { (name) }
                                     td .text-muted .text-sm { (user_id.get(..8).unwrap_or(user_id)) }
+                                    td .text-sm {
+                                        @if scopes.is_empty() { span .text-muted { "All" } } @else { (scopes) }
+                                    }
                                     td .text-muted .text-sm { (created.get(..10).unwrap_or(created)) }
+                                    td .text-muted .text-sm {
+                                        @if last_used.is_empty() { "Never" } @else { (last_used.get(..10).unwrap_or(last_used)) }
+                                    }
                                     td {
                                         @if revoked.is_empty() {
                                             (components::status_badge("active"))
@@ -530,6 +553,18 @@ async fn api_keys_tab(ctx: &dyn Context) -> Markup {
                     label .form-label for="key-name" { "Name" }
                     input .form-input type="text" #key-name name="name" placeholder="e.g. CI/CD key" required;
                 }
+                div .form-group {
+                    label .form-label for="key-scopes" { "Scopes" }
+                    input .form-input type="text" #key-scopes name="scopes" placeholder="e.g. storage.read, logs.read (empty for full access)";
+                }
+                div .form-group {
+                    label .form-label for="key-expires" { "Expires in (days)" }
+                    input .form-input type="number" min="1" #key-expires name="expires_in_days" placeholder="Never, or the configured maximum";
+                }
+                div .form-group {
+                    label .form-label for="key-ips" { "Allowed IPs" }
+                    input .form-input type="text" #key-ips name="allowed_ips" placeholder="e.g. 203.0.113.0/24 (empty for any)";
+                }
                 div .form-actions {
                     button .btn .btn-secondary type="button" onclick="closeModal('create-api-key')" { "Cancel" }
                     button .btn .btn-primary type="submit" { "Create" }
@@ -0,0 +1,152 @@
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::logs::audit_log;
+use crate::{
+    blocks::{
+        errors,
+        retention::{self, PolicyUpdate},
+    },
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+};
+
+/// `path` is the normalized `/admin/retention...` sub-path, passed
+/// explicitly (no `req.resource` rewrite).
+///
+/// - `GET /admin/retention` — every policy with its current setting,
+///   default and floor.
+/// - `PUT /admin/retention` (arrives as `update`) — change the policies in
+///   `{"policies": [{"name", "days", "enabled"}]}`; others keep theirs.
+/// - `GET /admin/retention/last-run` — what the newest run deleted, per
+///   policy.
+pub async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    path: &str,
+    input: InputStream,
+) -> OutputStream {
+    match (msg.action(), path) {
+        ("retrieve", "/admin/retention") => handle_list(ctx).await,
+        ("update", "/admin/retention") => handle_update(ctx, msg, input).await,
+        ("retrieve", "/admin/retention/last-run") => handle_last_run(ctx).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+async fn handle_list(ctx: &dyn Context) -> OutputStream {
+    match retention::policies(ctx).await {
+        Ok(policies) => ok_json(&serde_json::json!({ "policies": policies })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// Invalid entries reject the request with per-entry `validation_failed`
+/// details; nothing is written.
+async fn handle_update(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        policies: Vec<PolicyUpdate>,
+    }
+    let raw = input.collect_to_bytes().await;
+    let body: Req = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+
+    let mut problems: Vec<(String, &str)> = body
+        .policies
+        .iter()
+        .enumerate()
+        .flat_map(|(i, p)| p.problems(i))
+        .collect();
+    for (i, p) in body.policies.iter().enumerate() {
+        if body.policies[..i].iter().any(|q| q.name == p.name) {
+            problems.push((format!("policies[{i}].name"), "duplicates an earlier entry"));
+        }
+    }
+    if !problems.is_empty() {
+        let fields: Vec<(&str, &str)> = problems.iter().map(|(f, r)| (f.as_str(), *r)).collect();
+        return errors::validation_error("Invalid retention policies", &fields);
+    }
+
+    if let Err(e) = retention::save(ctx, &body.policies).await {
+        return err_internal("Database error", e);
+    }
+    let names: Vec<&str> = body.policies.iter().map(|p| p.name.as_str()).collect();
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "retention.update",
+        &format!("retention ({})", names.join(", ")),
+        msg.remote_addr(),
+    )
+    .await;
+    handle_list(ctx).await
+}
+
+async fn handle_last_run(ctx: &dyn Context) -> OutputStream {
+    match retention::last_run(ctx).await {
+        Ok(Some(run)) => ok_json(&run),
+        Ok(None) => err_not_found("No retention run has been recorded"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{admin_msg, output_is_error, output_json, TestContext};
+
+    #[tokio::test]
+    async fn update_rejects_values_below_the_floor() {
+        let ctx = TestContext::with_admin().await;
+        let body = br#"{"policies":[{"name":"audit_logs","days":0,"enabled":true}]}"#;
+        let out = handle(
+            &ctx,
+            &admin_msg("update", "/b/admin/api/retention"),
+            "/admin/retention",
+            InputStream::from_bytes(body.to_vec()),
+        )
+        .await;
+        let json = output_json(out).await;
+        assert_eq!(json["code"], "validation_failed");
+        assert!(json["details"]["policies[0].days"].is_string(), "{json}");
+
+        let body = br#"{"policies":[{"name":"audit_logs","days":120,"enabled":true}]}"#;
+        let out = handle(
+            &ctx,
+            &admin_msg("update", "/b/admin/api/retention"),
+            "/admin/retention",
+            InputStream::from_bytes(body.to_vec()),
+        )
+        .await;
+        let json = output_json(out).await;
+        let audit = json["policies"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|p| p["name"] == "audit_logs")
+            .cloned()
+            .unwrap();
+        assert_eq!(audit["days"], 120);
+        assert_eq!(audit["enabled"], true);
+
+        let last = admin_msg("retrieve", "/b/admin/api/retention/last-run");
+        let out = handle(
+            &ctx,
+            &last,
+            "/admin/retention/last-run",
+            InputStream::empty(),
+        )
+        .await;
+        assert!(output_is_error(out, "NotFound").await);
+        retention::run(&ctx).await.unwrap();
+        let out = handle(
+            &ctx,
+            &last,
+            "/admin/retention/last-run",
+            InputStream::empty(),
+        )
+        .await;
+        assert!(output_json(out).await["run_id"].is_string());
+    }
+}
@@ -10,6 +10,10 @@
 //! endpoint table. Every endpoint reachable in `handle()` must appear
 //! as a variant here and be covered by the test table below.
 
+use wafer_run::HttpMethod;
+
+use crate::endpoint_match::EndpointRoute;
+
 /// Classification of an admin HTTP request.
 ///
 /// Lifetime `'a` ties path-extracted slices (user_id, var_key, etc.) to
@@ -25,12 +29,36 @@ pub(super) enum AdminRoute<'a> {
     DatabaseApi,
     /// `/b/admin/api/iam*`
     IamApi,
+    /// `/b/admin/api/announcements*`
+    AnnouncementsApi,
+    /// `/b/admin/api/feature-flags*`
+    FeatureFlagsApi,
+    /// `/b/admin/api/inbound-webhooks*`
+    InboundWebhooksApi,
+    /// `/b/admin/api/invitations*` — signup invitations
+    InvitationsApi,
+    /// `/b/admin/api/jobs*` — background job queue
+    JobsApi,
+    /// `/b/admin/api/retention*` — data retention policies
+    RetentionApi,
     /// `/b/admin/api/logs*`
     LogsApi,
+    /// `/b/admin/api/service-accounts*` — API-key-only principals
+    ServiceAccountsApi,
+    /// `/b/admin/api/api-keys*` — every user's API keys
+    ApiKeysApi,
     /// `/b/admin/api/settings*`
     SettingsApi,
+    /// `/b/admin/api/translations*` — per-locale message overrides
+    TranslationsApi,
     /// `/b/admin/api/extensions*`
     ExtensionsApi,
+    /// `/b/admin/api/email*` — delivery log, forwarded to `suppers-ai/email`
+    EmailApi,
+    /// `/b/admin/api/config*` — live reload of runtime settings
+    ConfigApi,
+    /// `/b/admin/api/diagnostics` — the startup preflight report
+    DiagnosticsApi,
     /// `/b/admin/api/storage*` — delegated to `suppers-ai/files`
     StorageDelegate,
     /// `/b/admin/api/cloudstorage<rest>` — delegated to `suppers-ai/files`.
@@ -42,6 +70,22 @@ pub(super) enum AdminRoute<'a> {
     /// API path under /b/admin/api/ that didn't match any of the above.
     ApiNotFound,
 
+    // --- /iam/... (`/api/iam/...` once the pipeline strips `/api`) ---
+    /// action=retrieve, `/iam/my-permissions` — the caller's own roles,
+    /// permissions and openable admin sections.
+    MyPermissions,
+
+    // --- /operations... (`/api/operations...` once the pipeline strips `/api`) ---
+    /// `/operations` or `/operations/{id}` — the caller's long-running
+    /// operations (see `blocks::operations`), which matches the method.
+    Operations,
+
+    // --- /b/hooks/in/... (public, signature-verified) ---
+    /// action=create, `/b/hooks/in/{endpoint_id}` — inbound webhook delivery.
+    InboundWebhook {
+        endpoint_id: &'a str,
+    },
+
     // --- /b/admin/settings/... (consolidated settings tabs) ---
     /// `/b/admin/settings` or `/b/admin/settings/` — redirect to email tab.
     SettingsRedirect,
@@ -118,6 +162,53 @@ pub(super) enum AdminRoute<'a> {
     NotFound,
 }
 
+/// Permissions for the storage delegates and the storage page (see
+/// [`crate::blocks::permissions`]): `storage.admin` opens buckets, quotas
+/// and the storage reports to non-admins who hold it.
+pub(crate) const STORAGE_PERMISSION_ROUTES: &[EndpointRoute<&str>] = &[
+    EndpointRoute::new(HttpMethod::Get, "/b/admin/storage", "storage.admin"),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/admin/api/storage/{rest...}",
+        "storage.admin",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/admin/api/storage/{rest...}",
+        "storage.admin",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/b/admin/api/storage/{rest...}",
+        "storage.admin",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/admin/api/storage/{rest...}",
+        "storage.admin",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/admin/api/cloudstorage/{rest...}",
+        "storage.admin",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/admin/api/cloudstorage/{rest...}",
+        "storage.admin",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/b/admin/api/cloudstorage/{rest...}",
+        "storage.admin",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/admin/api/cloudstorage/{rest...}",
+        "storage.admin",
+    ),
+];
+
 /// Classify a request by path + action. Pure sync, no allocations
 /// except when an identifier must be normalized (block_name "--" → "/").
 pub(super) fn route<'a>(path: &'a str, action: &str) -> AdminRoute<'a> {
@@ -129,9 +220,21 @@ pub(super) fn route<'a>(path: &'a str, action: &str) -> AdminRoute<'a> {
             "users" => AdminRoute::UsersApi,
             "database" => AdminRoute::DatabaseApi,
             "iam" => AdminRoute::IamApi,
+            "announcements" => AdminRoute::AnnouncementsApi,
+            "feature-flags" => AdminRoute::FeatureFlagsApi,
+            "inbound-webhooks" => AdminRoute::InboundWebhooksApi,
+            "invitations" => AdminRoute::InvitationsApi,
+            "jobs" => AdminRoute::JobsApi,
             "logs" => AdminRoute::LogsApi,
+            "retention" => AdminRoute::RetentionApi,
+            "service-accounts" => AdminRoute::ServiceAccountsApi,
+            "api-keys" => AdminRoute::ApiKeysApi,
             "settings" => AdminRoute::SettingsApi,
+            "translations" => AdminRoute::TranslationsApi,
             "extensions" => AdminRoute::ExtensionsApi,
+            "email" => AdminRoute::EmailApi,
+            "config" => AdminRoute::ConfigApi,
+            "diagnostics" => AdminRoute::DiagnosticsApi,
             "storage" => AdminRoute::StorageDelegate,
             "cloudstorage" => AdminRoute::CloudStorageDelegate {
                 rest: api_rest.strip_prefix("/cloudstorage").unwrap_or(""),
@@ -264,6 +367,23 @@ pub(super) fn route<'a>(path: &'a str, action: &str) -> AdminRoute<'a> {
         };
     }
 
+    // 4) /iam/my-permissions — open to every signed-in caller
+    if path == "/iam/my-permissions" && action == "retrieve" {
+        return AdminRoute::MyPermissions;
+    }
+
+    // 5) /operations[/{id}] — the caller's own operations
+    if path == "/operations" || path.starts_with("/operations/") {
+        return AdminRoute::Operations;
+    }
+
+    // 6) /b/hooks/in/{id} — the one public route the admin block serves
+    if let Some(id) = path.strip_prefix("/b/hooks/in/") {
+        if action == "create" && !id.is_empty() && !id.contains('/') {
+            return AdminRoute::InboundWebhook { endpoint_id: id };
+        }
+    }
+
     AdminRoute::NotFound
 }
 
@@ -310,6 +430,54 @@ mod tests {
                 "retrieve",
                 AdminRoute::IamApi,
             ),
+            (
+                "announcements api",
+                "/b/admin/api/announcements/abc",
+                "delete",
+                AdminRoute::AnnouncementsApi,
+            ),
+            (
+                "feature flags api",
+                "/b/admin/api/feature-flags/new-editor",
+                "update",
+                AdminRoute::FeatureFlagsApi,
+            ),
+            (
+                "inbound webhooks api",
+                "/b/admin/api/inbound-webhooks/abc/deliveries",
+                "retrieve",
+                AdminRoute::InboundWebhooksApi,
+            ),
+            (
+                "invitations api",
+                "/b/admin/api/invitations/abc",
+                "delete",
+                AdminRoute::InvitationsApi,
+            ),
+            (
+                "service accounts api",
+                "/b/admin/api/service-accounts/sa_1/keys",
+                "create",
+                AdminRoute::ServiceAccountsApi,
+            ),
+            (
+                "api keys api",
+                "/b/admin/api/api-keys/k_1/revoke",
+                "create",
+                AdminRoute::ApiKeysApi,
+            ),
+            (
+                "jobs api",
+                "/b/admin/api/jobs/abc/retry",
+                "create",
+                AdminRoute::JobsApi,
+            ),
+            (
+                "retention api",
+                "/b/admin/api/retention/last-run",
+                "retrieve",
+                AdminRoute::RetentionApi,
+            ),
             (
                 "logs api",
                 "/b/admin/api/logs",
@@ -328,6 +496,30 @@ mod tests {
                 "retrieve",
                 AdminRoute::ExtensionsApi,
             ),
+            (
+                "email api",
+                "/b/admin/api/email/log",
+                "retrieve",
+                AdminRoute::EmailApi,
+            ),
+            (
+                "config api",
+                "/b/admin/api/config/reload",
+                "create",
+                AdminRoute::ConfigApi,
+            ),
+            (
+                "diagnostics api",
+                "/b/admin/api/diagnostics",
+                "retrieve",
+                AdminRoute::DiagnosticsApi,
+            ),
+            (
+                "translations api",
+                "/b/admin/api/translations/pt-br",
+                "update",
+                AdminRoute::TranslationsApi,
+            ),
             (
                 "wafer api removed",
                 "/b/admin/api/wafer",
@@ -364,6 +556,63 @@ mod tests {
                 "retrieve",
                 AdminRoute::ApiNotFound,
             ),
+            // /iam/my-permissions — any signed-in caller
+            (
+                "my permissions",
+                "/iam/my-permissions",
+                "retrieve",
+                AdminRoute::MyPermissions,
+            ),
+            (
+                "my permissions wrong method",
+                "/iam/my-permissions",
+                "create",
+                AdminRoute::NotFound,
+            ),
+            // /operations[/{id}] — the caller's operations
+            (
+                "operations list",
+                "/operations",
+                "retrieve",
+                AdminRoute::Operations,
+            ),
+            (
+                "operation",
+                "/operations/op-1",
+                "retrieve",
+                AdminRoute::Operations,
+            ),
+            (
+                "operations lookalike",
+                "/operationsx",
+                "retrieve",
+                AdminRoute::NotFound,
+            ),
+            // /b/hooks/in/{id} — public webhook receiver
+            (
+                "inbound webhook delivery",
+                "/b/hooks/in/abc",
+                "create",
+                AdminRoute::InboundWebhook { endpoint_id: "abc" },
+            ),
+            (
+                "inbound webhook wrong method",
+                "/b/hooks/in/abc",
+                "retrieve",
+                AdminRoute::NotFound,
+            ),
+            (
+                "inbound webhook nested path",
+                "/b/hooks/in/abc/extra",
+                "create",
+                AdminRoute::NotFound,
+            ),
+            (
+                "inbound webhook empty id",
+                "/b/hooks/in/",
+                "create",
+                AdminRoute::NotFound,
+            ),
             // /b/admin/settings — special pre-check
             (
                 "settings root no slash",
@@ -0,0 +1,119 @@
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::logs::audit_log;
+use crate::{
+    blocks::errors::{self, ErrorCode},
+    cache, diagnostics,
+    http::{err_not_found, ok_json},
+    runtime_config::{self, ReloadError},
+    status,
+};
+
+/// `path` is the normalized `/admin/config` or `/admin/diagnostics`
+/// sub-path, passed explicitly (no `req.resource` rewrite).
+///
+/// - `POST /admin/config/reload` — re-read the hot-reloadable settings (the
+///   same work SIGHUP triggers on native) and report which values changed
+///   and which changed settings still need a restart.
+/// - `GET /admin/config/cache` — hit/miss counters of the query caches
+///   (see [`crate::cache`]) and whether caching is on.
+/// - `GET /admin/diagnostics` — the preflight report recorded at startup
+///   (see [`crate::diagnostics`]); `null` on targets that don't run one.
+///   `status` is the live snapshot the native status file is written from
+///   (see [`crate::status`]).
+pub async fn handle(ctx: &dyn Context, msg: &Message, path: &str) -> OutputStream {
+    match (msg.action(), path) {
+        ("create", "/admin/config/reload") => handle_reload(ctx, msg).await,
+        ("retrieve", "/admin/config/cache") => ok_json(&serde_json::json!({
+            "enabled": cache::enabled(),
+            "caches": cache::stats(),
+        })),
+        ("retrieve", "/admin/diagnostics") => ok_json(&serde_json::json!({
+            "startup": diagnostics::startup_report(),
+            "status": status::snapshot(Some(ctx)).await,
+        })),
+        _ => err_not_found("not found"),
+    }
+}
+
+async fn handle_reload(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    match runtime_config::reload() {
+        Ok(report) => {
+            if !report.changed.is_empty() {
+                let keys: Vec<&str> = report.changed.iter().map(|c| c.key).collect();
+                audit_log(
+                    ctx,
+                    msg.user_id(),
+                    "config.reload",
+                    &format!("config:{}", keys.join(",")),
+                    msg.remote_addr(),
+                )
+                .await;
+            }
+            ok_json(&report)
+        }
+        Err(e @ ReloadError::InvalidLogLevel(_)) => errors::validation_error(
+            &e.to_string(),
+            &[(runtime_config::LOG_LEVEL_KEY, "not a valid log filter")],
+        ),
+        Err(e @ ReloadError::Unsupported) => {
+            errors::error_json(ErrorCode::ConfigurationError, &e.to_string(), None)
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{
+        admin_msg, output_is_error, output_json, output_status, TestContext,
+    };
+
+    #[tokio::test]
+    async fn reload_without_platform_hooks_is_reported() {
+        // Unit tests never install reload hooks, like the stateless targets.
+        let ctx = TestContext::new().await;
+        let msg = admin_msg("create", "/b/admin/api/config/reload");
+        let out = handle(&ctx, &msg, "/admin/config/reload").await;
+        assert_eq!(output_status(out).await, 500);
+    }
+
+    #[tokio::test]
+    async fn cache_stats_list_the_query_caches() {
+        let ctx = TestContext::new().await;
+        let msg = admin_msg("retrieve", "/b/admin/api/config/cache");
+        let body = output_json(handle(&ctx, &msg, "/admin/config/cache").await).await;
+        assert_eq!(
+            body["enabled"], false,
+            "caching is off under test by default"
+        );
+        assert!(body["caches"].is_array());
+    }
+
+    #[tokio::test]
+    async fn diagnostics_return_the_startup_report() {
+        let ctx = TestContext::new().await;
+        let msg = admin_msg("retrieve", "/b/admin/api/diagnostics");
+        let mut preflight = diagnostics::Preflight::new("", None);
+        preflight.run_sync(diagnostics::CLOCK, || {
+            diagnostics::Check::pass(diagnostics::CLOCK, "ok")
+        });
+        diagnostics::record_startup(preflight.finish());
+
+        let body = output_json(handle(&ctx, &msg, "/admin/diagnostics").await).await;
+        assert_eq!(body["startup"]["ok"], true);
+        assert_eq!(body["startup"]["checks"][0]["name"], "clock");
+        assert_eq!(body["startup"]["checks"][0]["status"], "pass");
+        // No jobs table here, so the live status reports the database down.
+        assert_eq!(body["status"]["status"], "running");
+        assert_eq!(body["status"]["database"]["connected"], false);
+    }
+
+    #[tokio::test]
+    async fn unknown_config_path_is_not_found() {
+        let ctx = TestContext::new().await;
+        let msg = admin_msg("retrieve", "/b/admin/api/config");
+        let out = handle(&ctx, &msg, "/admin/config").await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+}
@@ -0,0 +1,590 @@
+//! Service accounts: non-interactive principals for CI pipelines and
+//! backend services. Each is a `suppers_ai__auth__users` row of kind
+//! `service_account` (auth migration 015) that never signs in and
+//! authenticates only with the API keys issued here. Roles are assigned
+//! like any user's — at creation or through `/admin/iam/user-roles` — and
+//! usage quotas through a `user`-scoped definition naming its id.
+
+use std::collections::BTreeMap;
+
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::database as db;
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
+
+use super::{invitations::is_valid_role, logs::audit_log, ops, USER_ROLES_TABLE};
+use crate::{
+    blocks::{
+        auth::{
+            repo::{api_keys, users},
+            USERS_TABLE,
+        },
+        errors,
+        rate_limit::is_valid_limit,
+    },
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+    pagination::{self, ListSpec},
+    util::{format_rfc3339, json_map, now_rfc3339, stamp_updated},
+};
+
+/// Longest service-account name.
+const MAX_NAME_CHARS: usize = 100;
+/// Most rate-limit overrides one account may carry.
+const MAX_RATE_LIMITS: usize = 32;
+/// Longest key lifetime an admin may set, in days.
+const MAX_KEY_DAYS: i64 = 3650;
+/// Longest grace period before rotated-out keys stop working, in seconds.
+const MAX_GRACE_SECS: i64 = 7 * 24 * 60 * 60;
+
+/// `path` is the normalized `/admin/service-accounts...` sub-path, passed
+/// explicitly (no `req.resource` rewrite).
+///
+/// - `GET /admin/service-accounts` — paged per [`crate::pagination`].
+/// - `POST /admin/service-accounts` — create from `name` with optional
+///   `roles`, `rate_limits` and `key_expires_in_days`. Answers with the
+///   first API key, which is never shown again.
+/// - `GET /admin/service-accounts/{id}` — the account, its roles and keys.
+/// - `PATCH /admin/service-accounts/{id}` — change `name`, `disabled` or
+///   `rate_limits`.
+/// - `POST /admin/service-accounts/{id}/keys` — rotate: issue a new key and
+///   retire the old ones, at once or after `grace_secs`.
+pub async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    path: &str,
+    input: InputStream,
+) -> OutputStream {
+    let rest = path
+        .strip_prefix("/admin/service-accounts/")
+        .filter(|rest| !rest.is_empty());
+    let (id, sub) = match rest {
+        Some(rest) => match rest.split_once('/') {
+            Some((id, sub)) => (Some(id), sub),
+            None => (Some(rest), ""),
+        },
+        None => (None, ""),
+    };
+
+    match (msg.action(), path, id, sub) {
+        ("retrieve", "/admin/service-accounts", _, _) => handle_list(ctx, msg).await,
+        ("create", "/admin/service-accounts", _, _) => handle_create(ctx, msg, input).await,
+        ("retrieve", _, Some(id), "") => handle_get(ctx, id).await,
+        ("update", _, Some(id), "") => handle_update(ctx, msg, id, input).await,
+        ("create", _, Some(id), "keys") => handle_rotate(ctx, msg, id, input).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+/// The client-facing shape of a service account.
+fn view(row: &users::UserRow, roles: Vec<String>) -> serde_json::Value {
+    serde_json::json!({
+        "id": row.id,
+        "name": row.display_name,
+        "disabled": row.disabled,
+        "roles": roles,
+        "rate_limits": serde_json::from_str::<serde_json::Value>(&row.rate_limits)
+            .unwrap_or_else(|_| serde_json::json!({})),
+        "created_at": row.created_at,
+        "updated_at": row.updated_at,
+    })
+}
+
+/// An API key without its hash.
+pub(super) fn key_view(key: &api_keys::ApiKeyRow) -> serde_json::Value {
+    serde_json::json!({
+        "id": key.id,
+        "name": key.name,
+        "key_prefix": key.key_prefix,
+        "created_at": key.created_at,
+        "expires_at": key.expires_at,
+        "revoked_at": key.revoked_at,
+        "scopes": key.scopes,
+        "bucket": key.bucket,
+        "allowed_ips": key.allowed_ips,
+        "last_used_at": key.last_used_at,
+        "last_used_ip": key.last_used_ip,
+        "use_count": key.use_count,
+    })
+}
+
+/// Load a live service account, or the 404 to answer with. People and
+/// deleted accounts are "not found" here.
+async fn load(ctx: &dyn Context, id: &str) -> Result<users::UserRow, OutputStream> {
+    match users::find_by_id(ctx, id).await {
+        Ok(Some(row)) if row.is_service_account() && !row.is_deleted() => Ok(row),
+        Ok(_) => Err(err_not_found("Service account not found")),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// Check `rate_limits` and encode it for the `rate_limits` column.
+fn encode_rate_limits(
+    rate_limits: &BTreeMap<String, String>,
+    fields: &mut Vec<(&'static str, String)>,
+) -> String {
+    if rate_limits.len() > MAX_RATE_LIMITS {
+        fields.push((
+            "rate_limits",
+            format!("at most {MAX_RATE_LIMITS} categories"),
+        ));
+    }
+    for (category, limit) in rate_limits {
+        let category_ok = !category.is_empty()
+            && category.len() <= 32
+            && category
+                .bytes()
+                .all(|b| b.is_ascii_lowercase() || b.is_ascii_digit() || b == b'_');
+        if !category_ok {
+            fields.push(("rate_limits", format!("unknown category name '{category}'")));
+        } else if !is_valid_limit(limit) {
+            fields.push((
+                "rate_limits",
+                format!("'{category}' must be 0, <requests> or <requests>/<seconds>"),
+            ));
+        }
+    }
+    serde_json::to_string(rate_limits).unwrap_or_else(|_| "{}".to_string())
+}
+
+/// Issue a new API key for `account`, expiring after `days` if given.
+async fn issue_key(
+    ctx: &dyn Context,
+    account: &users::UserRow,
+    days: Option<i64>,
+) -> Result<serde_json::Value, OutputStream> {
+    let generated = api_keys::generate(ctx)
+        .await
+        .map_err(|e| err_internal("Failed to generate key", e))?;
+    let expires_at = days.map(|d| format_rfc3339(chrono::Utc::now() + chrono::Duration::days(d)));
+    let row = api_keys::insert(
+        ctx,
+        api_keys::NewApiKey {
+            user_id: &account.id,
+            name: &account.display_name,
+            key_hash: &generated.key_hash,
+            key_prefix: &generated.key_prefix,
+            expires_at: expires_at.as_deref(),
+            ..Default::default()
+        },
+    )
+    .await
+    .map_err(|e| err_internal("Database error", e))?;
+    let mut key = key_view(&row);
+    key["key"] = serde_json::json!(generated.raw);
+    Ok(key)
+}
+
+/// Sort and page-size limits for `GET /admin/service-accounts` (see
+/// [`crate::pagination`]).
+const LIST_SPEC: ListSpec = ListSpec {
+    default_limit: 20,
+    max_limit: 100,
+    sortable: &["created_at"],
+    default_sort: "created_at",
+    default_desc: true,
+};
+
+async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let query = match pagination::parse(msg, &LIST_SPEC) {
+        Ok(q) => q,
+        Err(e) => return e.response(),
+    };
+    let filters = vec![
+        Filter {
+            field: "deleted_at".to_string(),
+            operator: FilterOp::IsNull,
+            value: serde_json::Value::Null,
+        },
+        Filter {
+            field: "kind".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::json!(users::KIND_SERVICE_ACCOUNT),
+        },
+    ];
+    match pagination::fetch(ctx, USERS_TABLE, filters, &query).await {
+        Ok(mut page) => {
+            let records = page.records_mut();
+            let ids: Vec<&str> = records.iter().map(|r| r.id.as_str()).collect();
+            let roles_by_id = ops::fetch_roles(ctx, &ids).await;
+            for record in records.iter_mut() {
+                let Ok(row) = users::row_from_map(&record.data) else {
+                    continue;
+                };
+                let roles = roles_by_id.get(&record.id).cloned().unwrap_or_default();
+                record.data = json_map(view(&row, roles));
+            }
+            ok_json(&page.into_json(query.fields.as_deref()))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_get(ctx: &dyn Context, id: &str) -> OutputStream {
+    let account = match load(ctx, id).await {
+        Ok(row) => row,
+        Err(out) => return out,
+    };
+    let keys = match api_keys::list_for_user(ctx, id).await {
+        Ok(keys) => keys,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let roles = ops::fetch_roles(ctx, &[id])
+        .await
+        .remove(id)
+        .unwrap_or_default();
+    let mut resp = view(&account, roles);
+    resp["keys"] = keys.iter().map(key_view).collect();
+    ok_json(&resp)
+}
+
+#[derive(serde::Deserialize)]
+struct CreateReq {
+    name: String,
+    #[serde(default)]
+    roles: Vec<String>,
+    #[serde(default)]
+    rate_limits: BTreeMap<String, String>,
+    #[serde(default)]
+    key_expires_in_days: Option<i64>,
+}
+
+async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let body: CreateReq = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+
+    let name = body.name.trim();
+    let mut fields: Vec<(&'static str, String)> = Vec::new();
+    if name.is_empty() || name.chars().count() > MAX_NAME_CHARS {
+        fields.push(("name", format!("must be 1-{MAX_NAME_CHARS} characters")));
+    }
+    if let Some(role) = body.roles.iter().find(|r| !is_valid_role(r)) {
+        fields.push((
+            "roles",
+            format!("'{role}' is not 1-64 of a-z, 0-9, '_' or '-'"),
+        ));
+    }
+    if body
+        .key_expires_in_days
+        .is_some_and(|d| !(1..=MAX_KEY_DAYS).contains(&d))
+    {
+        fields.push((
+            "key_expires_in_days",
+            format!("must be between 1 and {MAX_KEY_DAYS}"),
+        ));
+    }
+    let rate_limits = encode_rate_limits(&body.rate_limits, &mut fields);
+    if !fields.is_empty() {
+        let fields: Vec<(&str, &str)> = fields.iter().map(|(f, r)| (*f, r.as_str())).collect();
+        return errors::validation_error("Invalid service account", &fields);
+    }
+
+    let account = match users::insert_service_account(ctx, name, &rate_limits).await {
+        Ok(row) => row,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let mut roles = body.roles;
+    roles.sort();
+    roles.dedup();
+    for role in &roles {
+        let data = json_map(serde_json::json!({
+            "user_id": account.id,
+            "role": role,
+            "assigned_at": now_rfc3339(),
+            "assigned_by": msg.user_id(),
+        }));
+        if let Err(e) = db::create(ctx, USER_ROLES_TABLE, data).await {
+            return err_internal("Database error", e);
+        }
+    }
+    crate::cache::invalidate_table(USER_ROLES_TABLE);
+    let key = match issue_key(ctx, &account, body.key_expires_in_days).await {
+        Ok(key) => key,
+        Err(out) => return out,
+    };
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "service_account.create",
+        &format!("users/{}", account.id),
+        msg.remote_addr(),
+    )
+    .await;
+
+    let mut resp = view(&account, roles);
+    resp["key"] = key;
+    ok_json(&resp)
+}
+
+#[derive(serde::Deserialize)]
+struct UpdateReq {
+    #[serde(default)]
+    name: Option<String>,
+    #[serde(default)]
+    disabled: Option<bool>,
+    #[serde(default)]
+    rate_limits: Option<BTreeMap<String, String>>,
+}
+
+async fn handle_update(
+    ctx: &dyn Context,
+    msg: &Message,
+    id: &str,
+    input: InputStream,
+) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let body: UpdateReq = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+    let account = match load(ctx, id).await {
+        Ok(row) => row,
+        Err(out) => return out,
+    };
+
+    let mut fields: Vec<(&'static str, String)> = Vec::new();
+    let mut data = std::collections::HashMap::new();
+    if let Some(name) = body.name.as_deref().map(str::trim) {
+        if name.is_empty() || name.chars().count() > MAX_NAME_CHARS {
+            fields.push(("name", format!("must be 1-{MAX_NAME_CHARS} characters")));
+        }
+        data.insert("display_name".to_string(), serde_json::json!(name));
+        data.insert("name".to_string(), serde_json::json!(name));
+    }
+    if let Some(disabled) = body.disabled {
+        data.insert("disabled".to_string(), serde_json::json!(disabled));
+    }
+    if let Some(rate_limits) = &body.rate_limits {
+        let encoded = encode_rate_limits(rate_limits, &mut fields);
+        data.insert("rate_limits".to_string(), serde_json::json!(encoded));
+    }
+    if !fields.is_empty() {
+        let fields: Vec<(&str, &str)> = fields.iter().map(|(f, r)| (*f, r.as_str())).collect();
+        return errors::validation_error("Invalid service account", &fields);
+    }
+    if data.is_empty() {
+        return err_bad_request("Nothing to update");
+    }
+    stamp_updated(&mut data);
+
+    match db::update(ctx, USERS_TABLE, &account.id, data).await {
+        Ok(_) => {}
+        Err(e) if e.code == ErrorCode::NotFound => {
+            return err_not_found("Service account not found")
+        }
+        Err(e) => return err_internal("Database error", e),
+    }
+    crate::cache::invalidate_table(USERS_TABLE);
+    let action = match body.disabled {
+        Some(true) => "service_account.disable",
+        Some(false) => "service_account.enable",
+        None => "service_account.update",
+    };
+    audit_log(
+        ctx,
+        msg.user_id(),
+        action,
+        &format!("users/{id}"),
+        msg.remote_addr(),
+    )
+    .await;
+    handle_get(ctx, id).await
+}
+
+#[derive(serde::Deserialize, Default)]
+struct RotateReq {
+    #[serde(default)]
+    grace_secs: i64,
+    #[serde(default)]
+    expires_in_days: Option<i64>,
+}
+
+async fn handle_rotate(
+    ctx: &dyn Context,
+    msg: &Message,
+    id: &str,
+    input: InputStream,
+) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let body: RotateReq = if raw.iter().all(u8::is_ascii_whitespace) {
+        RotateReq::default()
+    } else {
+        match serde_json::from_slice(&raw) {
+            Ok(b) => b,
+            Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        }
+    };
+    if !(0..=MAX_GRACE_SECS).contains(&body.grace_secs) {
+        return err_bad_request(&format!(
+            "grace_secs must be between 0 and {MAX_GRACE_SECS}"
+        ));
+    }
+    if body
+        .expires_in_days
+        .is_some_and(|d| !(1..=MAX_KEY_DAYS).contains(&d))
+    {
+        return err_bad_request(&format!(
+            "expires_in_days must be between 1 and {MAX_KEY_DAYS}"
+        ));
+    }
+    let account = match load(ctx, id).await {
+        Ok(row) => row,
+        Err(out) => return out,
+    };
+
+    // Retire before issuing, so the new key is never swept up with the old.
+    let until = (body.grace_secs > 0)
+        .then(|| format_rfc3339(chrono::Utc::now() + chrono::Duration::seconds(body.grace_secs)));
+    let retired = match api_keys::retire_active(ctx, &account.id, until.as_deref()).await {
+        Ok(n) => n,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let key = match issue_key(ctx, &account, body.expires_in_days).await {
+        Ok(key) => key,
+        Err(out) => return out,
+    };
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "service_account.rotate_keys",
+        &format!("users/{id}"),
+        msg.remote_addr(),
+    )
+    .await;
+    ok_json(&serde_json::json!({
+        "key": key,
+        "retired": retired,
+        "retired_at": until.unwrap_or_else(now_rfc3339),
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        blocks::auth::authenticate_api_key,
+        test_support::{admin_msg, output_is_error, output_json, output_status, TestContext},
+    };
+
+    fn body(v: serde_json::Value) -> InputStream {
+        InputStream::from_bytes(serde_json::to_vec(&v).unwrap())
+    }
+
+    async fn call(
+        ctx: &TestContext,
+        action: &str,
+        path: &str,
+        input: serde_json::Value,
+    ) -> OutputStream {
+        let msg = admin_msg(action, &format!("/b/admin/api{}", &path[6..]));
+        handle(ctx, &msg, path, body(input)).await
+    }
+
+    /// The user id an `ApiKey` header with `key` authenticates as.
+    async fn key_user(ctx: &TestContext, key: &serde_json::Value) -> String {
+        let mut msg = Message::new("http");
+        authenticate_api_key(ctx, key.as_str().unwrap(), &mut msg).await;
+        msg.user_id().to_string()
+    }
+
+    #[tokio::test]
+    async fn create_issues_a_working_key_and_assigns_roles() {
+        let ctx = TestContext::with_auth().await;
+        let out = call(
+            &ctx,
+            "create",
+            "/admin/service-accounts",
+            serde_json::json!({
+                "name": "deploy bot",
+                "roles": ["deployer"],
+                "rate_limits": { "api_write": "1000/60" },
+            }),
+        )
+        .await;
+        let created = output_json(out).await;
+        let id = created["id"].as_str().unwrap().to_string();
+        assert!(id.starts_with(users::SERVICE_ACCOUNT_ID_PREFIX));
+        assert_eq!(created["roles"], serde_json::json!(["deployer"]));
+        assert_eq!(created["rate_limits"]["api_write"], "1000/60");
+        assert!(created["key"].get("key_hash").is_none());
+        assert_eq!(key_user(&ctx, &created["key"]["key"]).await, id);
+
+        let roles = ops::fetch_roles(&ctx, &[id.as_str()]).await;
+        assert_eq!(roles[&id], vec!["deployer".to_string()]);
+
+        let out = call(
+            &ctx,
+            "retrieve",
+            "/admin/service-accounts",
+            serde_json::Value::Null,
+        )
+        .await;
+        let list = output_json(out).await;
+        assert_eq!(list["records"][0]["data"]["name"], "deploy bot");
+
+        let out = call(
+            &ctx,
+            "create",
+            "/admin/service-accounts",
+            serde_json::json!({ "name": "x", "rate_limits": { "api_read": "lots" } }),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 400);
+    }
+
+    #[tokio::test]
+    async fn rotation_and_disable_cut_off_old_keys() {
+        let ctx = TestContext::with_auth().await;
+        let out = call(
+            &ctx,
+            "create",
+            "/admin/service-accounts",
+            serde_json::json!({ "name": "ci" }),
+        )
+        .await;
+        let created = output_json(out).await;
+        let id = created["id"].as_str().unwrap().to_string();
+        let first = created["key"]["key"].clone();
+
+        let path = format!("/admin/service-accounts/{id}/keys");
+        let rotated = output_json(call(&ctx, "create", &path, serde_json::json!({})).await).await;
+        assert_eq!(rotated["retired"], 1);
+        assert_eq!(key_user(&ctx, &first).await, "");
+        assert_eq!(key_user(&ctx, &rotated["key"]["key"]).await, id);
+
+        let path = format!("/admin/service-accounts/{id}");
+        let updated = output_json(
+            call(
+                &ctx,
+                "update",
+                &path,
+                serde_json::json!({ "disabled": true }),
+            )
+            .await,
+        )
+        .await;
+        assert_eq!(updated["disabled"], true);
+        assert_eq!(key_user(&ctx, &rotated["key"]["key"]).await, "");
+        assert_eq!(updated["keys"].as_array().unwrap().len(), 2);
+    }
+
+    #[tokio::test]
+    async fn people_are_not_service_accounts() {
+        let ctx = TestContext::with_auth().await;
+        let person = users::insert(
+            &ctx,
+            users::NewUser {
+                email: "p@example.com".into(),
+                display_name: "P".into(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap();
+        let path = format!("/admin/service-accounts/{}/keys", person.id);
+        let out = call(&ctx, "create", &path, serde_json::json!({})).await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+}
@@ -1,4 +1,4 @@
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 
 use wafer_core::clients::database as db;
 use wafer_run::{
@@ -7,7 +7,9 @@ use wafer_run::{
 
 use super::ops::{self, MASKED_VALUE};
 use crate::{
+    etag,
     http::{err_bad_request, err_internal, err_not_found, ok_json},
+    revisions::{self, Category},
     util::{json_map, RecordExt},
 };
 
@@ -94,6 +96,22 @@ pub mod block_settings {
         .map(|_| ())
         .map_err(|e| format!("block_settings::set_enabled failed: {e}"))
     }
+
+    /// Enable or disable `block_name` for the running server: persist the
+    /// flag ([`set_enabled`]) and flip the live gate the router and the job
+    /// runner consult, so the change applies without a restart.
+    ///
+    /// Returns whether a restart is still needed: a block that was disabled
+    /// at boot skipped its `Init` (migrations, seeds), so enabling it is
+    /// persisted but its routes stay off until the server restarts.
+    pub async fn apply(ctx: &dyn Context, block_name: &str, enabled: bool) -> Result<bool, String> {
+        set_enabled(ctx, block_name, enabled).await?;
+        if enabled && crate::features::init_deferred(block_name) {
+            return Ok(true);
+        }
+        crate::features::set_live(block_name, enabled);
+        Ok(false)
+    }
 }
 
 // Table-name constants live in the leaf `crate::admin_schema` module (the
@@ -113,12 +131,12 @@ pub async fn handle(
     let action = msg.action();
 
     match (action, path) {
-        ("retrieve", "/admin/settings/all") => handle_list_full(ctx).await,
-        ("retrieve", "/admin/settings") | ("retrieve", "/settings") => handle_list(ctx).await,
+        ("retrieve", "/admin/settings/all") => handle_list_full(ctx, msg).await,
+        ("retrieve", "/admin/settings") | ("retrieve", "/settings") => handle_list(ctx, msg).await,
         ("retrieve", _)
             if path.starts_with("/admin/settings/") || path.starts_with("/settings/") =>
         {
-            handle_get(ctx, path).await
+            handle_get(ctx, msg, path).await
         }
         ("update", _) if path.starts_with("/admin/settings/") => {
             handle_set(ctx, msg, path, input).await
@@ -129,7 +147,7 @@ pub async fn handle(
     }
 }
 
-async fn handle_list_full(ctx: &dyn Context) -> OutputStream {
+async fn handle_list_full(ctx: &dyn Context, msg: &Message) -> OutputStream {
     match db::list_all(ctx, VARIABLES_TABLE, vec![]).await {
         Ok(records) => {
             let vars: Vec<_> = records
@@ -156,17 +174,18 @@ async fn handle_list_full(ctx: &dyn Context) -> OutputStream {
                     })
                 })
                 .collect();
-            ok_json(&vars)
+            etag::ok_json(msg, &vars)
         }
         Err(e) => err_internal("Database error", e),
     }
 }
 
-async fn handle_list(ctx: &dyn Context) -> OutputStream {
+async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
     match db::list_all(ctx, VARIABLES_TABLE, vec![]).await {
         Ok(records) => {
-            // Convert to key-value map, masking sensitive values
-            let mut settings = HashMap::new();
+            // Convert to key-value map, masking sensitive values. Sorted, so
+            // the same settings always hash to the same ETag.
+            let mut settings = BTreeMap::new();
             for record in &records {
                 let key = record.str_field("key");
                 let is_sensitive = ops::is_sensitive_key(key, record.i64_field("sensitive"));
@@ -183,13 +202,13 @@ async fn handle_list(ctx: &dyn Context) -> OutputStream {
                     settings.insert(key.to_string(), value);
                 }
             }
-            ok_json(&settings)
+            etag::ok_json(msg, &settings)
         }
         Err(e) => err_internal("Database error", e),
     }
 }
 
-async fn handle_get(ctx: &dyn Context, path: &str) -> OutputStream {
+async fn handle_get(ctx: &dyn Context, msg: &Message, path: &str) -> OutputStream {
     let key = path
         .strip_prefix("/admin/settings/")
         .or_else(|| path.strip_prefix("/settings/"))
@@ -217,7 +236,7 @@ async fn handle_get(ctx: &dyn Context, path: &str) -> OutputStream {
                     serde_json::Value::String(MASKED_VALUE.to_string()),
                 );
             }
-            ok_json(&record)
+            etag::ok_json(msg, &record)
         }
         Err(e) if e.code == ErrorCode::NotFound => err_not_found("Setting not found"),
         Err(e) => err_internal("Database error", e),
@@ -322,7 +341,10 @@ async fn handle_delete(ctx: &dyn Context, path: &str) -> OutputStream {
     .await
     {
         Ok(record) => match db::delete(ctx, VARIABLES_TABLE, &record.id).await {
-            Ok(_) => ok_json(&serde_json::json!({"deleted": key})),
+            Ok(_) => {
+                revisions::bump(Category::Settings);
+                ok_json(&serde_json::json!({"deleted": key}))
+            }
             Err(e) => err_internal("Database error", e),
         },
         Err(_) => err_not_found("Setting not found"),
@@ -0,0 +1,772 @@
+//! CSV export/import for the admin database table browser.
+//!
+//! - `GET  /admin/database/tables/{name}/export?format=csv` streams every row
+//!   (or the subset matching `?{column}=value` equality filters) as CSV with
+//!   a header row derived from the introspected columns. Rows are paged out
+//!   of the database [`EXPORT_PAGE_SIZE`] at a time inside the stream
+//!   producer, so the table is never held in memory as a whole.
+//! - `POST /admin/database/tables/{name}/import?mode=dry_run|commit` accepts
+//!   a `multipart/form-data` CSV upload (or a raw `text/csv` body). Every row
+//!   is validated against the column types and NOT NULL constraints; dry-run
+//!   (the default) reports per-row errors without writing, commit inserts the
+//!   valid rows in batches of [`IMPORT_BATCH_SIZE`] and reports
+//!   inserted/skipped counts. A batch goes in whole or not at all: the
+//!   database client has no transactions, so when one row is rejected the
+//!   batch's earlier inserts are deleted again and every row in it is
+//!   skipped. A rejection is reported as a field error (a duplicate of a
+//!   unique column, a constraint violation); other database errors are
+//!   logged and reported without their text.
+//!
+//! CSV headers map to columns case-insensitively. An explicit override is
+//! passed as `?map=Header:column,Other Header:other_column`; unmapped headers
+//! are reported and ignored. The row limit comes from
+//! `SUPPERS_AI__ADMIN__IMPORT_MAX_ROWS` (default [`DEFAULT_IMPORT_MAX_ROWS`]).
+
+use std::{collections::HashMap, sync::Arc};
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::{config, database as db};
+use wafer_run::{
+    context::Context, ErrorCode, InputStream, Message, MetaEntry, OutputStream, WaferError,
+    META_RESP_CONTENT_TYPE,
+};
+
+use super::database::{introspect_columns, ColumnInfo};
+use crate::{
+    cache,
+    http::{err_bad_request, err_not_found, ok_json},
+    util::write_csv_row,
+};
+
+/// Rows fetched per database page while streaming an export.
+const EXPORT_PAGE_SIZE: i64 = 500;
+
+/// Valid rows inserted per batch in commit mode.
+const IMPORT_BATCH_SIZE: usize = 500;
+
+/// Default cap on data rows accepted by a single import.
+pub(in crate::blocks::admin) const DEFAULT_IMPORT_MAX_ROWS: usize = 50_000;
+
+/// Config key overriding [`DEFAULT_IMPORT_MAX_ROWS`].
+pub(in crate::blocks::admin) const IMPORT_MAX_ROWS_KEY: &str = "SUPPERS_AI__ADMIN__IMPORT_MAX_ROWS";
+
+/// Per-row errors reported back are capped so a wholly-invalid 50k-row file
+/// doesn't produce a multi-megabyte response; the counts stay exact.
+const MAX_REPORTED_ROW_ERRORS: usize = 1_000;
+
+// ---------------------------------------------------------------------------
+// Export
+// ---------------------------------------------------------------------------
+
+pub(in crate::blocks::admin) async fn handle_export(
+    ctx: &dyn Context,
+    msg: &Message,
+    table: &str,
+) -> OutputStream {
+    let format = msg.query("format");
+    if !format.is_empty() && !format.eq_ignore_ascii_case("csv") {
+        return err_bad_request("Unsupported export format (expected csv)");
+    }
+
+    let (columns, _) = introspect_columns(ctx, table).await;
+    if columns.is_empty() {
+        return err_not_found("Table not found");
+    }
+
+    // Any query parameter named after a column is an equality filter.
+    // `format` is the one reserved name.
+    let filters: Vec<Filter> = columns
+        .iter()
+        .filter(|c| c.name != "format")
+        .filter_map(|c| {
+            let v = msg.query(&c.name);
+            (!v.is_empty()).then(|| Filter {
+                field: c.name.clone(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(v.to_string()),
+            })
+        })
+        .collect();
+
+    // Page by the primary key so OFFSET paging is stable across pages.
+    let sort: Vec<SortField> = columns
+        .iter()
+        .filter(|c| c.pk)
+        .map(|c| SortField {
+            field: c.name.clone(),
+            desc: false,
+        })
+        .collect();
+
+    let names: Vec<String> = columns.into_iter().map(|c| c.name).collect();
+    let table = table.to_string();
+    let filename = format!("{table}.csv");
+    let ctx: Arc<dyn Context> = ctx.clone_arc();
+
+    OutputStream::from_producer(move |sink, _cancel| async move {
+        let _ = sink
+            .send_meta(MetaEntry {
+                key: META_RESP_CONTENT_TYPE.to_string(),
+                value: "text/csv; charset=utf-8".to_string(),
+            })
+            .await;
+        let _ = sink
+            .send_meta(MetaEntry {
+                key: "resp.header.Content-Disposition".to_string(),
+                value: format!("attachment; filename=\"{filename}\""),
+            })
+            .await;
+
+        let mut header = String::new();
+        write_csv_row(&mut header, names.iter().map(String::as_str));
+        if sink.send_chunk(header.into_bytes()).await.is_err() {
+            return;
+        }
+
+        let mut offset = 0;
+        loop {
+            let opts = ListOptions {
+                filters: filters.clone(),
+                sort: sort.clone(),
+                limit: EXPORT_PAGE_SIZE,
+                offset,
+                skip_count: true,
+                ..Default::default()
+            };
+            let page = match db::list(ctx.as_ref(), &table, &opts).await {
+                Ok(page) => page.records,
+                Err(e) => {
+                    // Headers are already on the wire — the best we can do
+                    // is stop early and log; the truncated file is visible
+                    // to the admin as a short export.
+                    tracing::warn!(table = %table, error = %e, "csv export aborted mid-stream");
+                    return;
+                }
+            };
+            let fetched = page.len() as i64;
+
+            let mut chunk = String::new();
+            for record in &page {
+                let cells: Vec<String> = names
+                    .iter()
+                    .map(|name| {
+                        if name == "id" {
+                            record.id.clone()
+                        } else {
+                            csv_cell(record.data.get(name))
+                        }
+                    })
+                    .collect();
+                write_csv_row(&mut chunk, cells.iter().map(String::as_str));
+            }
+            if !chunk.is_empty() && sink.send_chunk(chunk.into_bytes()).await.is_err() {
+                return;
+            }
+            if fetched < EXPORT_PAGE_SIZE {
+                return;
+            }
+            offset += fetched;
+        }
+    })
+}
+
+/// Render a stored value as a CSV cell. NULL/missing is an empty cell;
+/// nested JSON is written as its JSON text.
+fn csv_cell(value: Option<&serde_json::Value>) -> String {
+    match value {
+        None | Some(serde_json::Value::Null) => String::new(),
+        Some(serde_json::Value::String(s)) => s.clone(),
+        Some(other) => other.to_string(),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Import
+// ---------------------------------------------------------------------------
+
+pub(in crate::blocks::admin) async fn handle_import(
+    ctx: &dyn Context,
+    msg: &Message,
+    table: &str,
+    input: InputStream,
+) -> OutputStream {
+    let commit = match msg.query("mode") {
+        "" | "dry_run" | "dry-run" => false,
+        "commit" => true,
+        _ => return err_bad_request("mode must be dry_run or commit"),
+    };
+
+    let (columns, _) = introspect_columns(ctx, table).await;
+    if columns.is_empty() {
+        return err_not_found("Table not found");
+    }
+
+    let max_rows = config::get_default(
+        ctx,
+        IMPORT_MAX_ROWS_KEY,
+        &DEFAULT_IMPORT_MAX_ROWS.to_string(),
+    )
+    .await
+    .parse::<usize>()
+    .unwrap_or(DEFAULT_IMPORT_MAX_ROWS);
+
+    let text = match read_upload(msg, input).await {
+        Ok(t) => t,
+        Err(out) => return out,
+    };
+
+    let rows = match parse_csv(&text) {
+        Ok(rows) => rows,
+        Err(e) => return err_bad_request(&format!("Invalid CSV: {e}")),
+    };
+    let mut rows = rows.into_iter();
+    let Some(header) = rows.next() else {
+        return err_bad_request("CSV is empty");
+    };
+    let rows: Vec<Vec<String>> = rows
+        .filter(|r| !(r.len() == 1 && r[0].is_empty()))
+        .collect();
+    if rows.len() > max_rows {
+        return err_bad_request(&format!(
+            "CSV has {} data rows; the import limit is {max_rows}",
+            rows.len()
+        ));
+    }
+
+    let overrides = match parse_mapping(msg.query("map")) {
+        Ok(m) => m,
+        Err(e) => return err_bad_request(&e),
+    };
+    let mapping = match map_headers(&header, &columns, &overrides) {
+        Ok(m) => m,
+        Err(e) => return err_bad_request(&e),
+    };
+
+    let has_created_at = columns.iter().any(|c| c.name == "created_at");
+    let has_updated_at = columns.iter().any(|c| c.name == "updated_at");
+
+    let mut valid: Vec<(usize, HashMap<String, serde_json::Value>)> = Vec::new();
+    let mut row_errors: Vec<serde_json::Value> = Vec::new();
+    let mut invalid_rows = 0usize;
+    for (i, row) in rows.iter().enumerate() {
+        // Row numbers are 1-based data rows (the header is row 0).
+        let row_no = i + 1;
+        match validate_row(row, &mapping, &columns) {
+            Ok(mut data) => {
+                let now = crate::util::now_rfc3339();
+                if has_created_at {
+                    data.entry("created_at".to_string())
+                        .or_insert_with(|| serde_json::Value::String(now.clone()));
+                }
+                if has_updated_at {
+                    data.entry("updated_at".to_string())
+                        .or_insert_with(|| serde_json::Value::String(now));
+                }
+                valid.push((row_no, data));
+            }
+            Err(errors) => {
+                invalid_rows += 1;
+                if row_errors.len() < MAX_REPORTED_ROW_ERRORS {
+                    row_errors.push(serde_json::json!({"row": row_no, "errors": errors}));
+                }
+            }
+        }
+    }
+
+    let valid_rows = valid.len();
+    let mut inserted = 0usize;
+    let mut failed = 0usize;
+    if commit {
+        for batch in valid.chunks(IMPORT_BATCH_SIZE) {
+            match insert_batch(ctx, table, batch).await {
+                Ok(()) => inserted += batch.len(),
+                Err((row_no, e)) => {
+                    failed += batch.len();
+                    let (first, last) = (batch[0].0, batch[batch.len() - 1].0);
+                    if row_errors.len() < MAX_REPORTED_ROW_ERRORS {
+                        row_errors.push(serde_json::json!({
+                            "row": row_no,
+                            "errors": [
+                                insert_error(table, &e, &columns),
+                                format!("rows {first}-{last} were not imported"),
+                            ],
+                        }));
+                    }
+                }
+            }
+        }
+        // Whatever this table feeds, cached copies predate the import.
+        cache::invalidate_table(table);
+    }
+
+    let ignored_headers: Vec<&str> = header
+        .iter()
+        .zip(mapping.iter())
+        .filter(|(_, m)| m.is_none())
+        .map(|(h, _)| h.as_str())
+        .collect();
+
+    ok_json(&serde_json::json!({
+        "table": table,
+        "mode": if commit { "commit" } else { "dry_run" },
+        "total_rows": rows.len(),
+        "valid_rows": valid_rows,
+        "inserted": inserted,
+        "skipped": invalid_rows + failed,
+        "ignored_headers": ignored_headers,
+        "errors": row_errors,
+    }))
+}
+
+/// Insert one batch of `(row number, record)` pairs, all or nothing. On the
+/// first rejected row the rows already inserted are deleted again, and that
+/// row's number is returned with the error.
+async fn insert_batch(
+    ctx: &dyn Context,
+    table: &str,
+    batch: &[(usize, HashMap<String, serde_json::Value>)],
+) -> Result<(), (usize, WaferError)> {
+    let mut ids = Vec::with_capacity(batch.len());
+    for (row_no, data) in batch {
+        match db::create(ctx, table, data.clone()).await {
+            Ok(record) => ids.push(record.id),
+            Err(e) => {
+                for id in &ids {
+                    if let Err(undo) = db::delete(ctx, table, id).await {
+                        tracing::error!(
+                            table = %table,
+                            id = %id,
+                            error = %undo,
+                            "csv import: failed to undo an insert"
+                        );
+                    }
+                }
+                return Err((*row_no, e));
+            }
+        }
+    }
+    Ok(())
+}
+
+/// A rejected insert as a field error for the import report. Unique and
+/// other constraint violations name the column when the backend's message
+/// does; anything else is logged and reported without the database's text.
+fn insert_error(table: &str, e: &WaferError, columns: &[ColumnInfo]) -> String {
+    let column = violated_column(&e.message, columns);
+    let field = |problem: &str| match column {
+        Some(c) => format!("{c}: {problem}"),
+        None => problem.to_string(),
+    };
+    let lower = e.message.to_lowercase();
+    if e.code == ErrorCode::AlreadyExists || lower.contains("unique") {
+        return field("duplicates an existing row's value");
+    }
+    if lower.contains("constraint") {
+        return field("violates a table constraint");
+    }
+    tracing::warn!(table = %table, error = %e, "csv import: insert failed");
+    "insert failed".to_string()
+}
+
+/// The column a constraint error names: SQLite reports `table.column`
+/// after `failed:`, Postgres `Key (column)=…`.
+fn violated_column<'a>(message: &str, columns: &'a [ColumnInfo]) -> Option<&'a str> {
+    let named = if let Some((_, rest)) = message.split_once("failed:") {
+        rest.split(',')
+            .next()
+            .and_then(|t| t.trim().rsplit('.').next())
+    } else if let Some((_, rest)) = message.split_once("Key (") {
+        rest.split([')', ',']).next()
+    } else {
+        None
+    }?;
+    columns
+        .iter()
+        .find(|c| c.name == named.trim())
+        .map(|c| c.name.as_str())
+}
+
+/// The uploaded CSV document: the file part of a `multipart/form-data`
+/// body, or the raw body otherwise. Must be UTF-8.
+pub(in crate::blocks::admin) async fn read_upload(
+    msg: &Message,
+    input: InputStream,
+) -> Result<String, OutputStream> {
+    let raw = input.collect_to_bytes().await;
+    let content_type = msg.get_meta("req.content_type").to_string();
+    let body = if crate::multipart::multipart_boundary(&content_type).is_some() {
+        match crate::multipart::extract_multipart_file(&raw, &content_type) {
+            Some(file) => file.content,
+            None => {
+                return Err(err_bad_request(
+                    "Malformed multipart body: no CSV file part",
+                ))
+            }
+        }
+    } else {
+        raw
+    };
+    String::from_utf8(body).map_err(|_| err_bad_request("CSV must be UTF-8 encoded"))
+}
+
+/// Parse `map=Header:column,Other:col2` into a lowercase-header → column map.
+fn parse_mapping(raw: &str) -> Result<HashMap<String, String>, String> {
+    let mut out = HashMap::new();
+    for pair in raw.split(',').map(str::trim).filter(|p| !p.is_empty()) {
+        let Some((header, column)) = pair.split_once(':') else {
+            return Err(format!(
+                "Invalid mapping entry '{pair}' (expected Header:column)"
+            ));
+        };
+        out.insert(header.trim().to_lowercase(), column.trim().to_string());
+    }
+    Ok(out)
+}
+
+/// Resolve each CSV header to a column index, or `None` for headers that
+/// match no column. Explicit overrides win; otherwise the header matches a
+/// column name case-insensitively. Two headers landing on the same column
+/// is an error — the result would silently depend on column order.
+fn map_headers(
+    header: &[String],
+    columns: &[ColumnInfo],
+    overrides: &HashMap<String, String>,
+) -> Result<Vec<Option<usize>>, String> {
+    let mut mapping = Vec::with_capacity(header.len());
+    let mut seen = vec![false; columns.len()];
+    for h in header {
+        let key = h.trim().to_lowercase();
+        let target = overrides.get(&key).cloned().unwrap_or_else(|| key.clone());
+        let idx = columns
+            .iter()
+            .position(|c| c.name.eq_ignore_ascii_case(&target));
+        if overrides.contains_key(&key) && idx.is_none() {
+            return Err(format!("Mapping for '{h}' names unknown column '{target}'"));
+        }
+        if let Some(i) = idx {
+            if seen[i] {
+                return Err(format!(
+                    "Column '{}' is mapped more than once",
+                    columns[i].name
+                ));
+            }
+            seen[i] = true;
+        }
+        mapping.push(idx);
+    }
+    if mapping.iter().all(Option::is_none) {
+        return Err("No CSV header matches a table column".to_string());
+    }
+    Ok(mapping)
+}
+
+/// Convert one CSV row to an insertable record, validating each cell against
+/// its column's declared type and NOT NULL constraint. Returns every problem
+/// in the row rather than stopping at the first.
+fn validate_row(
+    row: &[String],
+    mapping: &[Option<usize>],
+    columns: &[ColumnInfo],
+) -> Result<HashMap<String, serde_json::Value>, Vec<String>> {
+    let mut data = HashMap::new();
+    let mut errors = Vec::new();
+    if row.len() != mapping.len() {
+        errors.push(format!(
+            "expected {} cells, found {}",
+            mapping.len(),
+            row.len()
+        ));
+        return Err(errors);
+    }
+    for (cell, idx) in row.iter().zip(mapping) {
+        let Some(idx) = idx else { continue };
+        let col = &columns[*idx];
+        if cell.is_empty() {
+            continue;
+        }
+        match coerce_cell(cell, &col.ty) {
+            Ok(v) => {
+                data.insert(col.name.clone(), v);
+            }
+            Err(e) => errors.push(format!("{}: {e}", col.name)),
+        }
+    }
+    errors.extend(missing_required(columns, &data));
+    if errors.is_empty() {
+        Ok(data)
+    } else {
+        Err(errors)
+    }
+}
+
+/// One error per NOT NULL column without a default that `data` leaves
+/// unset. Primary keys are generated on insert when absent.
+pub(in crate::blocks::admin) fn missing_required(
+    columns: &[ColumnInfo],
+    data: &HashMap<String, serde_json::Value>,
+) -> Vec<String> {
+    columns
+        .iter()
+        .filter(|c| c.notnull && !c.pk && c.default_value.is_none())
+        .filter(|c| !data.contains_key(&c.name))
+        .map(|c| format!("{}: value is required", c.name))
+        .collect()
+}
+
+/// Coerce a non-empty cell to JSON by the column's declared type, using
+/// SQLite type-affinity rules on the type name (so Postgres type names such
+/// as `BIGINT`, `DOUBLE PRECISION` and `BOOLEAN` resolve the same way).
+pub(in crate::blocks::admin) fn coerce_cell(
+    cell: &str,
+    ty: &str,
+) -> Result<serde_json::Value, String> {
+    let ty = ty.to_uppercase();
+    if ty.contains("BOOL") {
+        return match cell.trim().to_lowercase().as_str() {
+            "true" | "1" | "yes" => Ok(serde_json::Value::Bool(true)),
+            "false" | "0" | "no" => Ok(serde_json::Value::Bool(false)),
+            _ => Err(format!("'{cell}' is not a boolean")),
+        };
+    }
+    if ty.contains("INT") {
+        return cell
+            .trim()
+            .parse::<i64>()
+            .map(serde_json::Value::from)
+            .map_err(|_| format!("'{cell}' is not an integer"));
+    }
+    if ["REAL", "FLOA", "DOUB", "NUMERIC", "DECIMAL"]
+        .iter()
+        .any(|t| ty.contains(t))
+    {
+        return cell
+            .trim()
+            .parse::<f64>()
+            .ok()
+            .and_then(serde_json::Number::from_f64)
+            .map(serde_json::Value::Number)
+            .ok_or_else(|| format!("'{cell}' is not a number"));
+    }
+    Ok(serde_json::Value::String(cell.to_string()))
+}
+
+/// Parse an RFC 4180 CSV document into rows of cells. Accepts CRLF or LF
+/// line endings, quoted cells with doubled quotes, and line breaks inside
+/// quoted cells. A leading UTF-8 BOM (as written by spreadsheet exports) is
+/// skipped.
+pub(in crate::blocks::admin) fn parse_csv(text: &str) -> Result<Vec<Vec<String>>, String> {
+    let text = text.strip_prefix('\u{feff}').unwrap_or(text);
+    let mut rows = Vec::new();
+    let mut row = Vec::new();
+    let mut cell = String::new();
+    let mut in_quotes = false;
+    let mut line = 1usize;
+    let mut chars = text.chars().peekable();
+    while let Some(c) = chars.next() {
+        if in_quotes {
+            match c {
+                '"' if chars.peek() == Some(&'"') => {
+                    chars.next();
+                    cell.push('"');
+                }
+                '"' => in_quotes = false,
+                '\n' => {
+                    line += 1;
+                    cell.push(c);
+                }
+                _ => cell.push(c),
+            }
+            continue;
+        }
+        match c {
+            '"' if cell.is_empty() => in_quotes = true,
+            '"' => return Err(format!("unexpected quote on line {line}")),
+            ',' => row.push(std::mem::take(&mut cell)),
+            '\r' if chars.peek() == Some(&'\n') => {}
+            '\n' => {
+                line += 1;
+                row.push(std::mem::take(&mut cell));
+                rows.push(std::mem::take(&mut row));
+            }
+            _ => cell.push(c),
+        }
+    }
+    if in_quotes {
+        return Err(format!("unterminated quoted cell on line {line}"));
+    }
+    if !cell.is_empty() || !row.is_empty() {
+        row.push(cell);
+        rows.push(row);
+    }
+    Ok(rows)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn col(name: &str, ty: &str, notnull: bool, pk: bool) -> ColumnInfo {
+        ColumnInfo {
+            name: name.to_string(),
+            ty: ty.to_string(),
+            notnull,
+            pk,
+            default_value: None,
+        }
+    }
+
+    #[test]
+    fn write_csv_row_escapes_delimiters_quotes_and_newlines() {
+        let mut out = String::new();
+        write_csv_row(
+            &mut out,
+            ["plain", "a,b", "say \"hi\"", "two\nlines"].into_iter(),
+        );
+        assert_eq!(out, "plain,\"a,b\",\"say \"\"hi\"\"\",\"two\nlines\"\r\n");
+    }
+
+    #[test]
+    fn parse_csv_round_trips_written_rows() {
+        let mut out = String::new();
+        write_csv_row(&mut out, ["id", "note"].into_iter());
+        write_csv_row(&mut out, ["1", "a,\"b\"\r\nc"].into_iter());
+        let rows = parse_csv(&out).unwrap();
+        assert_eq!(rows, vec![vec!["id", "note"], vec!["1", "a,\"b\"\r\nc"]]);
+    }
+
+    #[test]
+    fn parse_csv_accepts_lf_bom_and_missing_trailing_newline() {
+        let rows = parse_csv("\u{feff}a,b\n1,2").unwrap();
+        assert_eq!(rows, vec![vec!["a", "b"], vec!["1", "2"]]);
+    }
+
+    #[test]
+    fn parse_csv_rejects_unterminated_quote() {
+        assert!(parse_csv("a\n\"open").is_err());
+        assert!(parse_csv("a\nx\"y").is_err());
+    }
+
+    #[test]
+    fn map_headers_is_case_insensitive_and_honours_overrides() {
+        let columns = vec![
+            col("id", "TEXT", false, true),
+            col("email", "TEXT", true, false),
+        ];
+        let header = vec![
+            "ID".to_string(),
+            "E-mail Address".to_string(),
+            "extra".to_string(),
+        ];
+        let overrides = parse_mapping("E-mail Address:email").unwrap();
+        let mapping = map_headers(&header, &columns, &overrides).unwrap();
+        assert_eq!(mapping, vec![Some(0), Some(1), None]);
+    }
+
+    #[test]
+    fn map_headers_rejects_duplicate_and_unknown_targets() {
+        let columns = vec![col("email", "TEXT", false, false)];
+        let header = vec!["email".to_string(), "Email".to_string()];
+        assert!(map_headers(&header, &columns, &HashMap::new()).is_err());
+
+        let header = vec!["mail".to_string()];
+        let overrides = parse_mapping("mail:nope").unwrap();
+        assert!(map_headers(&header, &columns, &overrides).is_err());
+    }
+
+    #[test]
+    fn validate_row_collects_type_and_required_errors() {
+        let columns = vec![
+            col("id", "TEXT", true, true),
+            col("name", "TEXT", true, false),
+            col("age", "INTEGER", false, false),
+            col("score", "REAL", false, false),
+        ];
+        let mapping = vec![Some(0), Some(1), Some(2), Some(3)];
+
+        let ok = validate_row(
+            &["r1".into(), "Ann".into(), "42".into(), "1.5".into()],
+            &mapping,
+            &columns,
+        )
+        .unwrap();
+        assert_eq!(ok["age"], serde_json::json!(42));
+        assert_eq!(ok["score"], serde_json::json!(1.5));
+
+        let errs = validate_row(
+            &["".into(), "".into(), "forty".into(), "x".into()],
+            &mapping,
+            &columns,
+        )
+        .unwrap_err();
+        assert_eq!(errs.len(), 3, "{errs:?}");
+        assert!(errs.iter().any(|e| e.starts_with("name:")));
+        assert!(errs.iter().any(|e| e.starts_with("age:")));
+        assert!(errs.iter().any(|e| e.starts_with("score:")));
+    }
+
+    #[test]
+    fn validate_row_rejects_wrong_cell_count() {
+        let columns = vec![col("a", "TEXT", false, false)];
+        assert!(validate_row(&["1".into(), "2".into()], &[Some(0)], &columns).is_err());
+    }
+
+    #[test]
+    fn insert_errors_name_the_column_and_hide_other_database_text() {
+        let columns = [
+            col("email", "TEXT", true, false),
+            col("org_id", "TEXT", false, false),
+        ];
+        let error = |code, message: &str| WaferError::new(code, message.to_string());
+        assert_eq!(
+            insert_error(
+                "t",
+                &error(
+                    ErrorCode::AlreadyExists,
+                    "UNIQUE constraint failed: t.email"
+                ),
+                &columns
+            ),
+            "email: duplicates an existing row's value"
+        );
+        assert_eq!(
+            insert_error(
+                "t",
+                &error(
+                    ErrorCode::Internal,
+                    "insert or update violates foreign key constraint \"fk\": Key (org_id)=(o1) is not present"
+                ),
+                &columns
+            ),
+            "org_id: violates a table constraint"
+        );
+        assert_eq!(
+            insert_error(
+                "t",
+                &error(ErrorCode::Internal, "CHECK constraint failed: amount > 0"),
+                &columns
+            ),
+            "violates a table constraint"
+        );
+        assert_eq!(
+            insert_error(
+                "t",
+                &error(ErrorCode::Internal, "disk I/O error at /var/db"),
+                &columns
+            ),
+            "insert failed"
+        );
+    }
+
+    #[test]
+    fn coerce_cell_handles_booleans() {
+        assert_eq!(
+            coerce_cell("yes", "BOOLEAN").unwrap(),
+            serde_json::json!(true)
+        );
+        assert_eq!(
+            coerce_cell("0", "BOOLEAN").unwrap(),
+            serde_json::json!(false)
+        );
+        assert!(coerce_cell("maybe", "BOOLEAN").is_err());
+    }
+}
@@ -0,0 +1,208 @@
+use serde::Deserialize;
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::logs::audit_log;
+use crate::{
+    blocks::errors,
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+    i18n,
+};
+
+/// `path` is the normalized `/admin/translations...` sub-path, passed
+/// explicitly (no `req.resource` rewrite). See [`crate::i18n`].
+///
+/// - `GET /admin/translations` — every overridden locale.
+/// - `GET /admin/translations/{locale}` — one locale's overrides.
+/// - `PUT|PATCH /admin/translations/{locale}` — replace them with the
+///   body's `messages` object.
+/// - `DELETE /admin/translations/{locale}` — drop them.
+pub async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    path: &str,
+    input: InputStream,
+) -> OutputStream {
+    let Some(raw) = path.strip_prefix("/admin/translations/") else {
+        return match (msg.action(), path) {
+            ("retrieve", "/admin/translations") => handle_list(ctx).await,
+            _ => err_not_found("not found"),
+        };
+    };
+    if raw.is_empty() || raw.contains('/') {
+        return err_not_found("not found");
+    }
+    let Some(locale) = i18n::normalize(raw) else {
+        return errors::validation_error(
+            "Invalid locale",
+            &[("locale", "must be a language tag like de or pt-BR")],
+        );
+    };
+
+    match msg.action() {
+        "retrieve" => handle_get(ctx, &locale).await,
+        "update" => handle_put(ctx, msg, &locale, input).await,
+        "delete" => handle_delete(ctx, msg, &locale).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+async fn handle_list(ctx: &dyn Context) -> OutputStream {
+    match i18n::list_overrides(ctx).await {
+        Ok(all) => ok_json(&serde_json::json!({
+            "default_locale": i18n::DEFAULT_LOCALE,
+            "translations": all,
+        })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_get(ctx: &dyn Context, locale: &str) -> OutputStream {
+    match i18n::get_overrides(ctx, locale).await {
+        Ok(Some(overrides)) => ok_json(&overrides),
+        Ok(None) => err_not_found("No translations for this locale"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[derive(Deserialize)]
+struct PutReq {
+    messages: serde_json::Value,
+}
+
+async fn handle_put(
+    ctx: &dyn Context,
+    msg: &Message,
+    locale: &str,
+    input: InputStream,
+) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let body: PutReq = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+    let messages = match i18n::validate_messages(&body.messages) {
+        Ok(m) => m,
+        Err(fields) => return errors::validation_error("Invalid translations", &fields),
+    };
+    match i18n::put_overrides(ctx, locale, &messages, msg.user_id()).await {
+        Ok(stored) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "translations.update",
+                &format!("translations:{locale}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&stored)
+        }
+        Err(e) => errors::db_error_response("Translations", e),
+    }
+}
+
+async fn handle_delete(ctx: &dyn Context, msg: &Message, locale: &str) -> OutputStream {
+    match i18n::delete_overrides(ctx, locale).await {
+        Ok(true) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "translations.delete",
+                &format!("translations:{locale}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&serde_json::json!({ "deleted": true }))
+        }
+        Ok(false) => err_not_found("No translations for this locale"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{admin_msg, output_json, output_status, TestContext};
+
+    fn body(v: serde_json::Value) -> InputStream {
+        InputStream::from_bytes(serde_json::to_vec(&v).unwrap())
+    }
+
+    #[tokio::test]
+    async fn upload_list_replace_delete_round_trip() {
+        let ctx = TestContext::with_admin().await;
+        let msg = admin_msg("update", "/b/admin/api/translations/pt_BR");
+        let stored = output_json(
+            handle(
+                &ctx,
+                &msg,
+                "/admin/translations/pt_BR",
+                body(serde_json::json!({ "messages": { "ui.save": "Salvar" } })),
+            )
+            .await,
+        )
+        .await;
+        assert_eq!(stored["locale"], "pt-br");
+        assert_eq!(stored["messages"]["ui.save"], "Salvar");
+        assert_eq!(stored["updated_by"], "admin_1");
+
+        // A second upload replaces the locale's set.
+        let stored = output_json(
+            handle(
+                &ctx,
+                &msg,
+                "/admin/translations/pt-br",
+                body(serde_json::json!({ "messages": { "ui.cancel": "Cancelar" } })),
+            )
+            .await,
+        )
+        .await;
+        assert_eq!(
+            stored["messages"],
+            serde_json::json!({ "ui.cancel": "Cancelar" })
+        );
+
+        let msg = admin_msg("retrieve", "/b/admin/api/translations");
+        let listed =
+            output_json(handle(&ctx, &msg, "/admin/translations", InputStream::empty()).await)
+                .await;
+        assert_eq!(listed["default_locale"], "en");
+        assert_eq!(listed["translations"].as_array().unwrap().len(), 1);
+        assert_eq!(
+            i18n::load(&ctx).await.get("pt-BR", "ui.cancel"),
+            Some("Cancelar")
+        );
+
+        let msg = admin_msg("delete", "/b/admin/api/translations/pt-br");
+        let path = "/admin/translations/pt-br";
+        let out = handle(&ctx, &msg, path, InputStream::empty()).await;
+        assert_eq!(output_json(out).await["deleted"], true);
+        let msg = admin_msg("retrieve", "/b/admin/api/translations/pt-br");
+        let out = handle(&ctx, &msg, path, InputStream::empty()).await;
+        assert_eq!(output_status(out).await, 404);
+    }
+
+    #[tokio::test]
+    async fn bad_locales_and_messages_are_refused() {
+        let ctx = TestContext::with_admin().await;
+        let msg = admin_msg("update", "/b/admin/api/translations/x");
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/translations/x",
+            body(serde_json::json!({ "messages": {} })),
+        )
+        .await;
+        assert!(output_json(out).await["details"]["locale"].is_string());
+
+        let msg = admin_msg("update", "/b/admin/api/translations/de");
+        let out = handle(
+            &ctx,
+            &msg,
+            "/admin/translations/de",
+            body(serde_json::json!({ "messages": { "ui.save": ["Speichern"] } })),
+        )
+        .await;
+        assert_eq!(output_json(out).await["code"], "validation_failed");
+        assert_eq!(i18n::get_overrides(&ctx, "de").await.unwrap(), None);
+    }
+}
@@ -0,0 +1,944 @@
+//! Bulk user import from CSV.
+//!
+//! - `POST /admin/users/import?mode=dry_run|commit` accepts a CSV upload
+//!   (`multipart/form-data` or a raw `text/csv` body), one account per row.
+//! - `GET /admin/users/import/{id}` reports a committed import's progress
+//!   and per-row outcomes.
+//!
+//! Headers are matched case-insensitively: `email` (required),
+//! `display_name` (or `name`), `role` and `password`; any other header is
+//! reported and ignored. A blank role means `user`. A row with a password
+//! gets a welcome email; a row without one gets a link to set a password,
+//! valid for [`SET_PASSWORD_DAYS`] days. `?send_email=false` sends neither.
+//!
+//! Dry-run (the default) validates every row — address format, addresses
+//! repeated in the file or already taken, role names, the password policy —
+//! and writes nothing. Commit creates the valid rows and reports the rest
+//! as skipped, except that a file naming a role that doesn't exist is
+//! refused before any account is created: a mistyped role would otherwise
+//! import part of a file with the wrong access. So is a file from a scoped
+//! admin that assigns a role holding permissions they don't.
+//!
+//! A commit is recorded in `suppers_ai__admin__user_imports`. Up to
+//! [`SYNC_MAX_ROWS`] accounts are created before the response; a larger
+//! file is answered `202` with the import and an operation to poll (see
+//! [`crate::blocks::operations`]), and created by an [`IMPORT_JOB`] job,
+//! [`BATCH_SIZE`] rows at a time, saving progress after each batch so a
+//! retried job resumes where the last one stopped. The operation's result
+//! is the finished import. The
+//! database client has no transactions, so an account whose later steps
+//! fail is removed again rather than left half-created.
+//!
+//! Passwords never reach a log, an audit entry, the job payload or a
+//! response. They wait in the import row until created and are dropped
+//! when the import finishes. Every imported account carries `imported_at`,
+//! `imported_by` and `import_id` in its server-side metadata.
+
+use std::collections::{BTreeSet, HashMap, HashSet};
+
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::{crypto, database as db};
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream, WaferError};
+
+use super::{
+    invitations::is_valid_role,
+    logs::audit_log,
+    table_io::{parse_csv, read_upload},
+    ADMIN_BLOCK_ID, ROLES_TABLE,
+};
+use crate::{
+    blocks::{
+        auth::{
+            repo::{local_credentials, users},
+            USERS_TABLE,
+        },
+        auth_ui::api::{password_policy::validate_new_password, reset_token},
+        jobs::JobError,
+        operations::{self, Progress},
+        permissions,
+    },
+    http::{
+        err_bad_request, err_forbidden, err_internal, err_internal_no_cause, err_not_found, ok_json,
+    },
+    services::Services,
+    util::{json_map, now_rfc3339, RecordExt},
+};
+
+/// Committed imports, with their pending rows and per-row outcomes.
+pub(crate) const USER_IMPORTS_TABLE: &str = "suppers_ai__admin__user_imports";
+
+/// Job type creating the accounts of a large import.
+pub(super) const IMPORT_JOB: &str = "users.import";
+/// Operation kind of a large import.
+const IMPORT_KIND: &str = "users.import";
+
+/// Most data rows one file may hold.
+const MAX_ROWS: usize = 10_000;
+/// Largest commit whose accounts are created before the response.
+const SYNC_MAX_ROWS: usize = 100;
+/// Accounts created between progress updates.
+const BATCH_SIZE: usize = 50;
+/// How long the set-password link of an account imported without a
+/// password stays valid.
+const SET_PASSWORD_DAYS: i64 = 7;
+/// Longest display name accepted, in characters.
+const MAX_DISPLAY_NAME_LEN: usize = 200;
+/// Addresses per lookup of existing accounts.
+const LOOKUP_CHUNK: usize = 500;
+
+const STATUS_QUEUED: &str = "queued";
+const STATUS_RUNNING: &str = "running";
+const STATUS_COMPLETED: &str = "completed";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Column {
+    Email,
+    DisplayName,
+    Role,
+    Password,
+}
+
+fn column_for(header: &str) -> Option<Column> {
+    match header
+        .trim()
+        .to_lowercase()
+        .replace([' ', '-'], "_")
+        .as_str()
+    {
+        "email" | "email_address" => Some(Column::Email),
+        "display_name" | "name" => Some(Column::DisplayName),
+        "role" => Some(Column::Role),
+        "password" => Some(Column::Password),
+        _ => None,
+    }
+}
+
+/// Resolve each header to its column, or `None` for headers that are
+/// ignored. The email column is required and no column may appear twice.
+fn map_headers(header: &[String]) -> Result<Vec<Option<Column>>, String> {
+    let mut mapping = Vec::with_capacity(header.len());
+    for h in header {
+        let column = column_for(h);
+        if column.is_some() && mapping.contains(&column) {
+            return Err(format!("Column '{}' appears more than once", h.trim()));
+        }
+        mapping.push(column);
+    }
+    if !mapping.contains(&Some(Column::Email)) {
+        return Err("CSV has no email column".to_string());
+    }
+    Ok(mapping)
+}
+
+/// A validated row waiting to be created. Stored in the import row until
+/// it is, which is the only place its password is ever written.
+#[derive(Clone, serde::Serialize, serde::Deserialize)]
+struct PendingUser {
+    /// 1-based data row (the header is row 0).
+    row: usize,
+    email: String,
+    display_name: String,
+    role: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    password: Option<String>,
+}
+
+// Hand-written so a stray `{:?}` can't print a password.
+impl std::fmt::Debug for PendingUser {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("PendingUser")
+            .field("row", &self.row)
+            .field("email", &self.email)
+            .field("display_name", &self.display_name)
+            .field("role", &self.role)
+            .field("password", &self.password.as_ref().map(|_| "<redacted>"))
+            .finish()
+    }
+}
+
+/// What validation made of a file's rows.
+struct Checked {
+    valid: Vec<PendingUser>,
+    /// `{row, email, errors}` for each invalid row.
+    errors: Vec<serde_json::Value>,
+    /// Roles rows name that no role defines, sorted.
+    unknown_roles: Vec<String>,
+}
+
+fn is_valid_email(email: &str) -> bool {
+    email.len() <= 255
+        && email
+            .split_once('@')
+            .is_some_and(|(local, domain)| !local.is_empty() && domain.contains('.'))
+}
+
+/// Validate every row, collecting all of a row's problems rather than
+/// stopping at the first.
+async fn check_rows(
+    ctx: &dyn Context,
+    rows: &[Vec<String>],
+    mapping: &[Option<Column>],
+) -> Result<Checked, WaferError> {
+    let mut candidates: Vec<(PendingUser, Vec<String>)> = Vec::with_capacity(rows.len());
+    let mut first_row: HashMap<String, usize> = HashMap::new();
+    for (i, cells) in rows.iter().enumerate() {
+        let mut user = PendingUser {
+            row: i + 1,
+            email: String::new(),
+            display_name: String::new(),
+            role: "user".to_string(),
+            password: None,
+        };
+        if cells.len() != mapping.len() {
+            let error = format!("expected {} cells, found {}", mapping.len(), cells.len());
+            candidates.push((user, vec![error]));
+            continue;
+        }
+        for (cell, column) in cells.iter().zip(mapping) {
+            match column {
+                Some(Column::Email) => user.email = cell.trim().to_lowercase(),
+                Some(Column::DisplayName) => user.display_name = cell.trim().to_string(),
+                Some(Column::Role) if !cell.trim().is_empty() => {
+                    user.role = cell.trim().to_string();
+                }
+                Some(Column::Password) if !cell.is_empty() => user.password = Some(cell.clone()),
+                _ => {}
+            }
+        }
+
+        let mut errors = Vec::new();
+        if !is_valid_email(&user.email) {
+            errors.push("email: must be a valid email address".to_string());
+        } else if let Some(first) = first_row.get(&user.email) {
+            errors.push(format!("email: duplicate of row {first}"));
+        } else {
+            first_row.insert(user.email.clone(), user.row);
+        }
+        if user.display_name.chars().count() > MAX_DISPLAY_NAME_LEN {
+            errors.push(format!(
+                "display_name: must be at most {MAX_DISPLAY_NAME_LEN} characters"
+            ));
+        }
+        if !is_valid_role(&user.role) {
+            errors.push("role: must be 1-64 of a-z, 0-9, '_' or '-'".to_string());
+        }
+        if let Some(password) = &user.password {
+            if let Err((_, reason)) = validate_new_password(ctx, password).await {
+                errors.push(format!("password: {reason}"));
+            }
+        }
+        candidates.push((user, errors));
+    }
+
+    let emails: Vec<&str> = first_row.keys().map(String::as_str).collect();
+    let taken = existing_emails(ctx, &emails).await?;
+    let roles: HashSet<String> = db::list_all(ctx, ROLES_TABLE, Vec::new())
+        .await?
+        .iter()
+        .map(|r| r.str_field("name").to_string())
+        .collect();
+
+    let mut checked = Checked {
+        valid: Vec::new(),
+        errors: Vec::new(),
+        unknown_roles: Vec::new(),
+    };
+    let mut unknown_roles = BTreeSet::new();
+    for (user, mut errors) in candidates {
+        if first_row.get(&user.email) == Some(&user.row) && taken.contains(&user.email) {
+            errors.push("email: an account with this address already exists".to_string());
+        }
+        if is_valid_role(&user.role) && !roles.contains(&user.role) {
+            errors.push(format!("role: '{}' does not exist", user.role));
+            unknown_roles.insert(user.role.clone());
+        }
+        if errors.is_empty() {
+            checked.valid.push(user);
+        } else {
+            checked.errors.push(serde_json::json!({
+                "row": user.row,
+                "email": user.email,
+                "errors": errors,
+            }));
+        }
+    }
+    checked.unknown_roles = unknown_roles.into_iter().collect();
+    Ok(checked)
+}
+
+/// Which of `emails` already belong to an account, deleted or not (the
+/// address stays taken until the account is purged).
+async fn existing_emails(
+    ctx: &dyn Context,
+    emails: &[&str],
+) -> Result<HashSet<String>, WaferError> {
+    let mut found = HashSet::new();
+    for chunk in emails.chunks(LOOKUP_CHUNK) {
+        let filters = vec![Filter {
+            field: "email".to_string(),
+            operator: FilterOp::In,
+            value: serde_json::json!(chunk),
+        }];
+        for record in db::list_all(ctx, USERS_TABLE, filters).await? {
+            found.insert(record.str_field("email").to_lowercase());
+        }
+    }
+    Ok(found)
+}
+
+pub(super) async fn handle_import(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    let commit = match msg.query("mode") {
+        "" | "dry_run" | "dry-run" => false,
+        "commit" => true,
+        _ => return err_bad_request("mode must be dry_run or commit"),
+    };
+    let send_email = !matches!(msg.query("send_email"), "false" | "0" | "no");
+
+    let text = match read_upload(msg, input).await {
+        Ok(t) => t,
+        Err(out) => return out,
+    };
+    let rows = match parse_csv(&text) {
+        Ok(rows) => rows,
+        Err(e) => return err_bad_request(&format!("Invalid CSV: {e}")),
+    };
+    let mut rows = rows.into_iter();
+    let Some(header) = rows.next() else {
+        return err_bad_request("CSV is empty");
+    };
+    let rows: Vec<Vec<String>> = rows
+        .filter(|r| !(r.len() == 1 && r[0].is_empty()))
+        .collect();
+    if rows.len() > MAX_ROWS {
+        return err_bad_request(&format!(
+            "CSV has {} data rows; the import limit is {MAX_ROWS}",
+            rows.len()
+        ));
+    }
+    let mapping = match map_headers(&header) {
+        Ok(m) => m,
+        Err(e) => return err_bad_request(&e),
+    };
+    let ignored_headers: Vec<&str> = header
+        .iter()
+        .zip(mapping.iter())
+        .filter(|(_, m)| m.is_none())
+        .map(|(h, _)| h.as_str())
+        .collect();
+
+    let checked = match check_rows(ctx, &rows, &mapping).await {
+        Ok(c) => c,
+        Err(e) => return err_internal("Database error", e),
+    };
+
+    if !commit {
+        return ok_json(&serde_json::json!({
+            "mode": "dry_run",
+            "total_rows": rows.len(),
+            "valid_rows": checked.valid.len(),
+            "skipped": checked.errors.len(),
+            "ignored_headers": ignored_headers,
+            "unknown_roles": checked.unknown_roles,
+            "errors": checked.errors,
+        }));
+    }
+    if !checked.unknown_roles.is_empty() {
+        return err_bad_request(&format!(
+            "Unknown role(s): {}; no rows were imported",
+            checked.unknown_roles.join(", ")
+        ));
+    }
+    // Like an invitation, an import can't hand out more than the caller
+    // holds.
+    let roles: BTreeSet<&str> = checked.valid.iter().map(|u| u.role.as_str()).collect();
+    match permissions::covers(ctx, msg, &roles.into_iter().collect::<Vec<_>>()).await {
+        Ok(true) => {}
+        Ok(false) => {
+            return err_forbidden("The file assigns roles with permissions you don't hold")
+        }
+        Err(e) => return err_internal("Database error", e),
+    }
+
+    let now = now_rfc3339();
+    let skipped: Vec<serde_json::Value> = checked
+        .errors
+        .iter()
+        .map(|e| {
+            let mut e = e.clone();
+            e["status"] = serde_json::json!("skipped");
+            e
+        })
+        .collect();
+    let data = json_map(serde_json::json!({
+        "status": STATUS_QUEUED,
+        "total": checked.valid.len(),
+        "processed": 0,
+        "created": 0,
+        "failed": 0,
+        "skipped": skipped.len(),
+        "send_email": i64::from(send_email),
+        "pending": serde_json::to_string(&checked.valid).unwrap_or_default(),
+        "results": serde_json::Value::Array(skipped).to_string(),
+        "created_by": msg.user_id(),
+        "created_at": now,
+        "updated_at": now,
+    }));
+    let import = match db::create(ctx, USER_IMPORTS_TABLE, data).await {
+        Ok(r) => r,
+        Err(e) => return err_internal("Database error", e),
+    };
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "users.import",
+        &format!("user_import:{} ({} rows)", import.id, checked.valid.len()),
+        msg.remote_addr(),
+    )
+    .await;
+
+    if checked.valid.len() > SYNC_MAX_ROWS {
+        let payload = serde_json::json!({ "import_id": import.id });
+        let op = match operations::start(
+            ctx,
+            msg.user_id(),
+            ADMIN_BLOCK_ID,
+            IMPORT_KIND,
+            IMPORT_JOB,
+            payload,
+        )
+        .await
+        {
+            Ok(op) => op,
+            Err(e) => {
+                // Nothing would ever process it, and it holds passwords.
+                let _ = db::delete(ctx, USER_IMPORTS_TABLE, &import.id).await;
+                return err_internal("Failed to queue the import", e);
+            }
+        };
+        let mut view = import_view(&import);
+        view["ignored_headers"] = serde_json::json!(ignored_headers);
+        return operations::accepted(&op, view);
+    }
+
+    if let Err(e) = process(ctx, &import.id, None).await {
+        return err_internal_no_cause(&format!("Import failed: {}", e.message));
+    }
+    match db::get(ctx, USER_IMPORTS_TABLE, &import.id).await {
+        Ok(record) => {
+            let mut view = import_view(&record);
+            view["ignored_headers"] = serde_json::json!(ignored_headers);
+            ok_json(&view)
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+pub(super) async fn handle_status(ctx: &dyn Context, id: &str) -> OutputStream {
+    if id.is_empty() || id.contains('/') {
+        return err_not_found("not found");
+    }
+    match db::get(ctx, USER_IMPORTS_TABLE, id).await {
+        Ok(record) => ok_json(&import_view(&record)),
+        Err(e) if e.code == ErrorCode::NotFound => err_not_found("Import not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// The public shape of an import row: everything but the pending rows.
+fn import_view(record: &db::Record) -> serde_json::Value {
+    let results: serde_json::Value =
+        serde_json::from_str(record.str_field("results")).unwrap_or_else(|_| serde_json::json!([]));
+    serde_json::json!({
+        "id": record.id,
+        "status": record.str_field("status"),
+        "total": record.i64_field("total"),
+        "processed": record.i64_field("processed"),
+        "created": record.i64_field("created"),
+        "failed": record.i64_field("failed"),
+        "skipped": record.i64_field("skipped"),
+        "send_email": record.bool_field("send_email"),
+        "created_by": record.str_field("created_by"),
+        "created_at": record.str_field("created_at"),
+        "updated_at": record.str_field("updated_at"),
+        "finished_at": record.str_field("finished_at"),
+        "results": results,
+        "status_url": format!("/b/admin/api/users/import/{}", record.id),
+    })
+}
+
+/// Run an [`IMPORT_JOB`], reporting to its operation.
+pub(super) async fn run_job(ctx: &dyn Context, input: InputStream) -> Result<(), JobError> {
+    #[derive(serde::Deserialize)]
+    struct Payload {
+        import_id: String,
+    }
+    let raw: serde_json::Value = serde_json::from_slice(&input.collect_to_bytes().await)
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+    let payload: Payload = serde_json::from_value(raw.clone())
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+    let mut progress = Progress::begin(ctx, &raw).await;
+    if progress.already_done() {
+        return Ok(());
+    }
+    process(ctx, &payload.import_id, Some(&mut progress)).await?;
+    let record = db::get(ctx, USER_IMPORTS_TABLE, &payload.import_id).await?;
+    progress.succeed(&import_view(&record)).await;
+    Ok(())
+}
+
+/// Create the pending accounts of import `import_id` from where it last
+/// stopped, saving progress after each batch. Finished imports are left
+/// alone, so a job delivered twice is harmless.
+async fn process(
+    ctx: &dyn Context,
+    import_id: &str,
+    mut progress: Option<&mut Progress<'_>>,
+) -> Result<(), JobError> {
+    let record = match db::get(ctx, USER_IMPORTS_TABLE, import_id).await {
+        Ok(r) => r,
+        Err(e) if e.code == ErrorCode::NotFound => {
+            return Err(JobError::permanent(format!("import {import_id} not found")));
+        }
+        Err(e) => return Err(e.into()),
+    };
+    if record.str_field("status") == STATUS_COMPLETED {
+        return Ok(());
+    }
+    let pending: Vec<PendingUser> = serde_json::from_str(record.str_field("pending"))
+        .map_err(|e| JobError::permanent(format!("invalid pending rows: {e}")))?;
+    let mut results: Vec<serde_json::Value> =
+        serde_json::from_str(record.str_field("results")).unwrap_or_default();
+    let mut processed = usize::try_from(record.i64_field("processed"))
+        .unwrap_or(0)
+        .min(pending.len());
+    let mut created = record.i64_field("created");
+    let mut failed = record.i64_field("failed");
+    let importer = Importer {
+        import_id,
+        imported_by: record.str_field("created_by"),
+        send_email: record.bool_field("send_email"),
+    };
+
+    loop {
+        let end = (processed + BATCH_SIZE).min(pending.len());
+        for user in &pending[processed..end] {
+            match create_account(ctx, &importer, user).await {
+                Ok(account) => {
+                    created += 1;
+                    results.push(serde_json::json!({
+                        "row": user.row,
+                        "email": user.email,
+                        "status": "created",
+                        "user_id": account.user_id,
+                        "emailed": account.emailed,
+                    }));
+                }
+                Err(reason) => {
+                    failed += 1;
+                    results.push(serde_json::json!({
+                        "row": user.row,
+                        "email": user.email,
+                        "status": "failed",
+                        "errors": [reason],
+                    }));
+                }
+            }
+        }
+        processed = end;
+        let done = processed == pending.len();
+
+        let now = now_rfc3339();
+        let mut data = json_map(serde_json::json!({
+            "status": if done { STATUS_COMPLETED } else { STATUS_RUNNING },
+            "processed": processed,
+            "created": created,
+            "failed": failed,
+            "results": serde_json::Value::Array(results.clone()).to_string(),
+            "updated_at": now,
+        }));
+        if done {
+            data.insert("pending".to_string(), serde_json::json!("[]"));
+            data.insert("finished_at".to_string(), serde_json::json!(now));
+        }
+        db::update(ctx, USER_IMPORTS_TABLE, import_id, data).await?;
+        if let Some(progress) = progress.as_mut() {
+            progress
+                .update(processed as u64, pending.len() as u64)
+                .await;
+        }
+        if done {
+            return Ok(());
+        }
+    }
+}
+
+/// Who is importing, shared by every row of one import.
+struct Importer<'a> {
+    import_id: &'a str,
+    imported_by: &'a str,
+    send_email: bool,
+}
+
+struct Account {
+    user_id: String,
+    emailed: bool,
+}
+
+/// Create one account. The error is the row's failure reason; it never
+/// includes the password.
+async fn create_account(
+    ctx: &dyn Context,
+    importer: &Importer<'_>,
+    user: &PendingUser,
+) -> Result<Account, String> {
+    // Checked at validation, but the address may have been taken since —
+    // or by this import's own last attempt, if the job is being retried.
+    if let Some(existing) = users::find_by_email(ctx, &user.email)
+        .await
+        .map_err(|e| e.to_string())?
+    {
+        let ours = users::find_profile(ctx, &existing.id)
+            .await
+            .ok()
+            .flatten()
+            .is_some_and(|p| p.metadata["import_id"] == importer.import_id);
+        return if ours {
+            Ok(Account {
+                user_id: existing.id,
+                emailed: false,
+            })
+        } else {
+            Err("an account with this address already exists".to_string())
+        };
+    }
+
+    let row = users::insert(
+        ctx,
+        users::NewUser {
+            email: user.email.clone(),
+            display_name: user.display_name.clone(),
+            avatar_url: None,
+            role: user.role.clone(),
+        },
+    )
+    .await
+    .map_err(|e| format!("creating the account failed: {e}"))?;
+    if let Err(reason) = complete_account(ctx, importer, user, &row.id).await {
+        if let Err(e) = users::purge(ctx, &row.id).await {
+            tracing::warn!(
+                user_id = %row.id,
+                error = %e,
+                "removing a half-imported account failed"
+            );
+        }
+        return Err(reason);
+    }
+    crate::blocks::auth_ui::api::email_confirmed(ctx, &row.id, &user.email).await;
+
+    let emailed = importer.send_email && send_welcome(ctx, user, &row.id).await;
+    Ok(Account {
+        user_id: row.id,
+        emailed,
+    })
+}
+
+/// Mark the account imported and verified, and store its password.
+async fn complete_account(
+    ctx: &dyn Context,
+    importer: &Importer<'_>,
+    user: &PendingUser,
+    user_id: &str,
+) -> Result<(), String> {
+    let metadata = serde_json::json!({
+        "imported_at": now_rfc3339(),
+        "imported_by": importer.imported_by,
+        "import_id": importer.import_id,
+    });
+    let mut data = json_map(serde_json::json!({
+        "metadata": metadata.to_string(),
+        // The admin vouches for the addresses they import.
+        "email_verified": true,
+    }));
+    crate::util::stamp_updated(&mut data);
+    db::update(ctx, USERS_TABLE, user_id, data)
+        .await
+        .map_err(|e| format!("updating the account failed: {e}"))?;
+
+    if let Some(password) = &user.password {
+        let hash = crypto::hash(ctx, password)
+            .await
+            .map_err(|e| format!("hashing the password failed: {}", e.message))?;
+        local_credentials::insert(ctx, user_id, &hash, false)
+            .await
+            .map_err(|e| format!("storing the password failed: {e}"))?;
+    }
+    Ok(())
+}
+
+/// Email a new account: a welcome if it has a password, otherwise a link
+/// to set one. Best-effort like every account email; returns whether it
+/// went out.
+async fn send_welcome(ctx: &dyn Context, user: &PendingUser, user_id: &str) -> bool {
+    let mailer = Services::new(ctx, ADMIN_BLOCK_ID).mailer();
+    if user.password.is_some() {
+        let body = serde_json::json!({
+            "template": "welcome",
+            "to": user.email,
+            "name": user.display_name,
+        });
+        return mailer.send("email.send_template", body).await;
+    }
+
+    // The reset-password flow sets the first password, with a token minted
+    // like a requested reset's.
+    let valid_for = chrono::Duration::days(SET_PASSWORD_DAYS);
+    let token = match reset_token::issue(ctx, user_id, valid_for).await {
+        Ok(token) => token,
+        Err(e) => {
+            tracing::warn!(user_id, error = %e, "issuing the set-password token failed");
+            return false;
+        }
+    };
+    let body = serde_json::json!({
+        "template": "account_created",
+        "to": user.email,
+        "name": user.display_name,
+        "token": token,
+        "days_remaining": SET_PASSWORD_DAYS,
+    });
+    mailer.send("email.send_template", body).await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{admin_msg, output_json, output_status, TestContext};
+
+    async fn ctx_with_roles() -> TestContext {
+        let ctx = TestContext::with_auth().await;
+        for name in ["admin", "user", "editor"] {
+            let now = now_rfc3339();
+            let role = json_map(serde_json::json!({
+                "name": name,
+                "created_at": now,
+                "updated_at": now,
+            }));
+            db::create(&ctx, ROLES_TABLE, role).await.unwrap();
+        }
+        ctx
+    }
+
+    async fn import(ctx: &TestContext, mode: &str, csv: &str) -> OutputStream {
+        let mut msg = admin_msg("create", "/b/admin/api/users/import");
+        msg.set_meta("req.query.mode", mode);
+        msg.set_meta("req.query.send_email", "false");
+        msg.set_meta("req.content_type", "text/csv");
+        let input = InputStream::from_bytes(csv.as_bytes().to_vec());
+        let limiter = crate::blocks::rate_limit::UserRateLimiter::new();
+        super::super::users::handle(ctx, &msg, &limiter, "/admin/users/import", input).await
+    }
+
+    async fn user_count(ctx: &TestContext) -> usize {
+        db::list_all(ctx, USERS_TABLE, Vec::new())
+            .await
+            .unwrap()
+            .len()
+    }
+
+    #[test]
+    fn headers_match_aliases_and_require_email() {
+        let header = |h: &[&str]| h.iter().map(|s| s.to_string()).collect::<Vec<_>>();
+        assert_eq!(
+            map_headers(&header(&["E-mail Address", "Display Name", "ROLE", "x"])).unwrap(),
+            vec![
+                Some(Column::Email),
+                Some(Column::DisplayName),
+                Some(Column::Role),
+                None
+            ]
+        );
+        assert!(map_headers(&header(&["name", "role"])).is_err());
+        assert!(map_headers(&header(&["email", "name", "display_name"])).is_err());
+    }
+
+    #[test]
+    fn debug_output_redacts_passwords() {
+        let user = PendingUser {
+            row: 1,
+            email: "a@example.com".into(),
+            display_name: String::new(),
+            role: "user".into(),
+            password: Some("hunter2-secret".into()),
+        };
+        assert!(!format!("{user:?}").contains("hunter2-secret"));
+    }
+
+    #[tokio::test]
+    async fn dry_run_reports_every_problem_and_writes_nothing() {
+        let ctx = ctx_with_roles().await;
+        users::insert(
+            &ctx,
+            users::NewUser {
+                email: "taken@example.com".into(),
+                display_name: "Taken".into(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap();
+        let csv = "Email,Name,Role,Password,Notes\n\
+                   new@example.com,New,,,hi\n\
+                   NEW@example.com,Again,,,\n\
+                   taken@example.com,Taken,,,\n\
+                   not-an-email,Bad,,,\n\
+                   ghost@example.com,Ghost,ghost,,\n\
+                   short@example.com,Short,editor,s3cr,\n";
+
+        let body = output_json(import(&ctx, "dry_run", csv).await).await;
+        assert_eq!(body["total_rows"], 6);
+        assert_eq!(body["valid_rows"], 1);
+        assert_eq!(body["skipped"], 5);
+        assert_eq!(body["ignored_headers"], serde_json::json!(["Notes"]));
+        assert_eq!(body["unknown_roles"], serde_json::json!(["ghost"]));
+        let errors = body["errors"].as_array().unwrap();
+        let error_of =
+            |row: u64| errors.iter().find(|e| e["row"] == row).unwrap()["errors"][0].clone();
+        assert_eq!(error_of(2), "email: duplicate of row 1");
+        assert!(error_of(3).as_str().unwrap().contains("already exists"));
+        assert!(error_of(4).as_str().unwrap().starts_with("email:"));
+        assert_eq!(error_of(5), "role: 'ghost' does not exist");
+        assert!(error_of(6).as_str().unwrap().starts_with("password:"));
+        assert!(!body.to_string().contains("s3cr"));
+
+        assert_eq!(user_count(&ctx).await, 1);
+    }
+
+    #[tokio::test]
+    async fn commit_creates_accounts_with_roles_metadata_and_passwords() {
+        let ctx = ctx_with_roles().await;
+        let csv = "email,display_name,role,password\n\
+                   pat@example.com,Pat,editor,correct-horse-battery-7\n\
+                   sam@example.com,Sam,,\n\
+                   broken,Nobody,,\n";
+
+        let out = import(&ctx, "commit", csv).await;
+        let body = output_json(out).await;
+        assert_eq!(body["status"], STATUS_COMPLETED);
+        assert_eq!(body["created"], 2);
+        assert_eq!(body["skipped"], 1);
+        assert!(body.get("pending").is_none());
+        assert!(!body.to_string().contains("correct-horse"));
+
+        let pat = users::find_by_email(&ctx, "pat@example.com")
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(pat.role, "editor");
+        assert!(pat.email_verified);
+        let profile = users::find_profile(&ctx, &pat.id).await.unwrap().unwrap();
+        assert_eq!(profile.metadata["import_id"], body["id"]);
+        assert_eq!(profile.metadata["imported_by"], "admin_1");
+        assert!(profile.metadata["imported_at"].is_string());
+        assert!(local_credentials::find_by_user_id(&ctx, &pat.id)
+            .await
+            .unwrap()
+            .is_some());
+
+        let sam = users::find_by_email(&ctx, "sam@example.com")
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(sam.role, "user");
+        assert!(local_credentials::find_by_user_id(&ctx, &sam.id)
+            .await
+            .unwrap()
+            .is_none());
+
+        let id = body["id"].as_str().unwrap();
+        let stored = db::get(&ctx, USER_IMPORTS_TABLE, id).await.unwrap();
+        assert_eq!(stored.str_field("pending"), "[]");
+        let polled = output_json(handle_status(&ctx, id).await).await;
+        assert_eq!(polled["results"].as_array().unwrap().len(), 3);
+    }
+
+    #[tokio::test]
+    async fn commit_naming_an_unknown_role_imports_nothing() {
+        let ctx = ctx_with_roles().await;
+        let csv = "email,role\nok@example.com,editor\ntypo@example.com,edtor\n";
+        assert_eq!(output_status(import(&ctx, "commit", csv).await).await, 400);
+        assert_eq!(user_count(&ctx).await, 0);
+        let imports = db::list_all(&ctx, USER_IMPORTS_TABLE, Vec::new())
+            .await
+            .unwrap();
+        assert!(imports.is_empty());
+    }
+
+    #[tokio::test]
+    async fn large_imports_run_as_a_resumable_job() {
+        let ctx = ctx_with_roles().await;
+        let mut csv = String::from("email\n");
+        for i in 0..SYNC_MAX_ROWS + 20 {
+            csv.push_str(&format!("user{i}@example.com\n"));
+        }
+
+        let accepted = output_json(import(&ctx, "commit", &csv).await).await;
+        let op_id = accepted["operation"]["id"].as_str().unwrap().to_string();
+        assert_eq!(accepted["total"], SYNC_MAX_ROWS + 20);
+        let out = import(&ctx, "commit", "email\nother@example.com\n").await;
+        assert_eq!(output_json(out).await["created"], 1);
+        let queued = db::list_all(&ctx, super::super::JOBS_TABLE, Vec::new())
+            .await
+            .unwrap();
+        assert_eq!(queued.len(), 1);
+        assert_eq!(queued[0].str_field("job_type"), IMPORT_JOB);
+        let payload: serde_json::Value =
+            serde_json::from_str(queued[0].str_field("payload")).unwrap();
+        let id = payload["import_id"].as_str().unwrap().to_string();
+        assert_eq!(payload[operations::OPERATION_ID_KEY], op_id);
+
+        // A first attempt that stopped after one batch and some of the next.
+        let data = json_map(serde_json::json!({
+            "status": STATUS_RUNNING,
+            "processed": BATCH_SIZE,
+            "created": BATCH_SIZE,
+        }));
+        db::update(&ctx, USER_IMPORTS_TABLE, &id, data)
+            .await
+            .unwrap();
+        let record = db::get(&ctx, USER_IMPORTS_TABLE, &id).await.unwrap();
+        let pending: Vec<PendingUser> = serde_json::from_str(record.str_field("pending")).unwrap();
+        let importer = Importer {
+            import_id: &id,
+            imported_by: "admin_1",
+            send_email: false,
+        };
+        for user in &pending[..BATCH_SIZE + 3] {
+            create_account(&ctx, &importer, user).await.unwrap();
+        }
+
+        let input = InputStream::from_bytes(serde_json::to_vec(&payload).unwrap());
+        run_job(&ctx, input).await.unwrap();
+        let polled = output_json(handle_status(&ctx, &id).await).await;
+        assert_eq!(polled["status"], STATUS_COMPLETED);
+        assert_eq!(polled["processed"], SYNC_MAX_ROWS + 20);
+        assert_eq!(polled["created"], SYNC_MAX_ROWS + 20);
+        assert_eq!(polled["failed"], 0);
+        assert_eq!(user_count(&ctx).await, SYNC_MAX_ROWS + 21);
+        let op = db::get(&ctx, crate::blocks::admin::OPERATIONS_TABLE, &op_id)
+            .await
+            .unwrap();
+        assert_eq!(op.str_field("status"), operations::STATUS_SUCCEEDED);
+        assert_eq!(op.i64_field("processed"), (SYNC_MAX_ROWS + 20) as i64);
+
+        // Delivered again, a finished import is left alone.
+        let input = InputStream::from_bytes(serde_json::to_vec(&payload).unwrap());
+        run_job(&ctx, input).await.unwrap();
+        let again = output_json(handle_status(&ctx, &id).await).await;
+        assert_eq!(again["created"], SYNC_MAX_ROWS + 20);
+    }
+}
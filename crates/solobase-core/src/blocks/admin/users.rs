@@ -1,21 +1,77 @@
 use std::collections::HashMap;
 
-use wafer_block::db::{Filter, FilterOp, SortField};
+use wafer_block::db::{Filter, FilterOp};
 use wafer_core::clients::database as db;
-use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
+use wafer_run::{context::Context, ErrorCode, HttpMethod, InputStream, Message, OutputStream};
 
-use super::ops;
+use super::{ops, user_import};
 use crate::{
-    blocks::auth::USERS_TABLE as COLLECTION,
+    blocks::{
+        auth::{repo::users, USERS_TABLE as COLLECTION},
+        directory,
+        rate_limit::{check_rate_limit, RateLimit, RateLimitOutcome, UserRateLimiter},
+    },
+    endpoint_match::EndpointRoute,
     http::{err_bad_request, err_internal, err_not_found, ok_json},
+    pagination::{self, ListSpec},
 };
 
+/// Permissions for user management (see [`crate::blocks::permissions`]):
+/// `users.manage` opens the users page, its htmx actions, the users API,
+/// imports and password resets to non-admins who hold it. Role assignment
+/// stays on the admin-only IAM API.
+pub(crate) const PERMISSION_ROUTES: &[EndpointRoute<&str>] = &[
+    EndpointRoute::new(HttpMethod::Get, "/b/admin/users", "users.manage"),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/admin/users/{id}/disable",
+        "users.manage",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/admin/users/{id}/enable",
+        "users.manage",
+    ),
+    EndpointRoute::new(HttpMethod::Delete, "/b/admin/users/{id}", "users.manage"),
+    EndpointRoute::new(HttpMethod::Get, "/b/admin/api/users", "users.manage"),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/admin/api/users/import",
+        "users.manage",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/admin/api/users/import/{id}",
+        "users.manage",
+    ),
+    EndpointRoute::new(HttpMethod::Get, "/b/admin/api/users/{id}", "users.manage"),
+    EndpointRoute::new(HttpMethod::Patch, "/b/admin/api/users/{id}", "users.manage"),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/admin/api/users/{id}",
+        "users.manage",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/admin/api/users/{id}/reset-password",
+        "users.manage",
+    ),
+    EndpointRoute::new(HttpMethod::Get, "/b/admin/api/invitations", "users.manage"),
+    EndpointRoute::new(HttpMethod::Post, "/b/admin/api/invitations", "users.manage"),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/admin/api/invitations/{id}",
+        "users.manage",
+    ),
+];
+
 /// `path` is the normalized `/admin/users[...]` sub-path passed explicitly by
 /// the admin dispatcher (no `req.resource` rewrite). The leaf handlers read the
 /// user id from `req.param.id`, which this dispatcher binds from `path`.
 pub async fn handle(
     ctx: &dyn Context,
     msg: &Message,
+    limiter: &UserRateLimiter,
     path: &str,
     input: InputStream,
 ) -> OutputStream {
@@ -23,6 +79,13 @@ pub async fn handle(
 
     match (action, path) {
         ("retrieve", "/admin/users") => handle_list(ctx, msg).await,
+        ("create", "/admin/users/import") => user_import::handle_import(ctx, msg, input).await,
+        ("retrieve", _) if path.starts_with("/admin/users/import/") => {
+            user_import::handle_status(ctx, &path["/admin/users/import/".len()..]).await
+        }
+        ("create", _) if path.starts_with("/admin/users/") && path.ends_with("/reset-password") => {
+            handle_reset_password(ctx, msg, limiter, user_id_from(path), input).await
+        }
         ("retrieve", _) if path.starts_with("/admin/users/") => {
             handle_get(ctx, msg, user_id_from(path)).await
         }
@@ -45,15 +108,32 @@ fn user_id_from(path: &str) -> &str {
     }
 }
 
+/// Sort and page-size limits for `GET /admin/users` (see
+/// [`crate::pagination`]).
+const LIST_SPEC: ListSpec = ListSpec {
+    default_limit: 20,
+    max_limit: 100,
+    sortable: &["created_at", "email"],
+    default_sort: "created_at",
+    default_desc: true,
+};
+
 async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
-    let (page, page_size, _) = msg.pagination_params(20);
+    let query = match pagination::parse(msg, &LIST_SPEC) {
+        Ok(q) => q,
+        Err(e) => return e.response(),
+    };
     let search = msg.query("search").to_string();
 
-    let mut filters = vec![Filter {
-        field: "deleted_at".to_string(),
-        operator: FilterOp::IsNull,
-        value: serde_json::Value::Null,
-    }];
+    // Service accounts are listed by `GET /admin/service-accounts`.
+    let mut filters = vec![
+        Filter {
+            field: "deleted_at".to_string(),
+            operator: FilterOp::IsNull,
+            value: serde_json::Value::Null,
+        },
+        users::people_only(),
+    ];
 
     if !search.is_empty() {
         filters.push(Filter {
@@ -63,34 +143,35 @@ async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
         });
     }
 
-    let sort = vec![SortField {
-        field: "created_at".to_string(),
-        desc: true,
-    }];
-
-    match db::paginated_list(
-        ctx,
-        COLLECTION,
-        page as i64,
-        page_size as i64,
-        filters,
-        sort,
-    )
-    .await
-    {
-        Ok(mut result) => {
+    match pagination::fetch(ctx, COLLECTION, filters, &query).await {
+        Ok(mut page) => {
             // Strip password hashes and bulk-enrich with roles via a single
-            // `In`-filter query (was N+1: one `list_all` per row).
-            let user_ids: Vec<&str> = result.records.iter().map(|r| r.id.as_str()).collect();
+            // `In`-filter query (was N+1: one `list_all` per row). A
+            // directory provider's display name and avatar win over the
+            // stored ones, also in one lookup for the page.
+            let records = page.records_mut();
+            let user_ids: Vec<&str> = records.iter().map(|r| r.id.as_str()).collect();
             let roles_by_user = ops::fetch_roles(ctx, &user_ids).await;
-            for record in &mut result.records {
+            let profiles = directory::lookup(ctx, &user_ids).await;
+            for record in records.iter_mut() {
                 record.data.remove("password_hash");
                 let roles = roles_by_user.get(&record.id).cloned().unwrap_or_default();
                 record
                     .data
                     .insert("roles".to_string(), serde_json::json!(roles));
+                let Some(profile) = profiles.get(&record.id) else {
+                    continue;
+                };
+                for (field, value) in [
+                    ("display_name", &profile.display_name),
+                    ("avatar_url", &profile.avatar_url),
+                ] {
+                    if !value.is_empty() {
+                        record.data.insert(field.to_string(), value.as_str().into());
+                    }
+                }
             }
-            ok_json(&result)
+            ok_json(&page.into_json(query.fields.as_deref()))
         }
         Err(e) => err_internal("Database error", e),
     }
@@ -150,6 +231,64 @@ async fn handle_update(
     }
 }
 
+/// `POST /admin/users/{id}/reset-password` — issue a reset link instead of
+/// setting a password: `{"delivery": "email"}` (the default) emails it,
+/// `{"delivery": "link"}` returns it. Limited per target user, so the
+/// endpoint can't be used to flood an inbox.
+async fn handle_reset_password(
+    ctx: &dyn Context,
+    msg: &Message,
+    limiter: &UserRateLimiter,
+    id: &str,
+    input: InputStream,
+) -> OutputStream {
+    if id.is_empty() {
+        return err_bad_request("Missing user ID");
+    }
+
+    #[derive(Default, serde::Deserialize)]
+    #[serde(deny_unknown_fields)]
+    struct Req {
+        #[serde(default)]
+        delivery: ops::ResetDelivery,
+    }
+    let raw = input.collect_to_bytes().await;
+    let body: Req = if raw.iter().all(u8::is_ascii_whitespace) {
+        Req::default()
+    } else {
+        match serde_json::from_slice(&raw) {
+            Ok(b) => b,
+            Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        }
+    };
+
+    match check_rate_limit(
+        limiter,
+        ctx,
+        id,
+        "password_reset",
+        RateLimit::PASSWORD_RESET,
+    )
+    .await
+    {
+        RateLimitOutcome::Limited(r) => return r,
+        RateLimitOutcome::Allowed(_) | RateLimitOutcome::Disabled => {}
+    }
+
+    match ops::issue_password_reset(ctx, msg, id, body.delivery).await {
+        Ok(Some(link)) => ok_json(&serde_json::json!({
+            "delivery": "link",
+            "link": link,
+            "expires_in_hours": ops::RESET_LINK_HOURS,
+        })),
+        Ok(None) => ok_json(&serde_json::json!({
+            "delivery": "email",
+            "expires_in_hours": ops::RESET_LINK_HOURS,
+        })),
+        Err(out) => out,
+    }
+}
+
 async fn handle_delete(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
     if id.is_empty() {
         return err_bad_request("Missing user ID");
@@ -0,0 +1,565 @@
+//! Announcement banners: admin-authored notices shown across the UI.
+//!
+//! Admins manage [`Announcement`]s via `/b/admin/api/announcements`; the
+//! frontend polls `GET /b/auth/api/announcements` for the ones that apply
+//! to the caller and dismisses them at
+//! `POST /b/auth/api/announcements/{id}/dismiss`.
+//!
+//! An announcement is *live* between `starts_at` and `ends_at` (open-ended
+//! when `ends_at` is unset). Expired rows are filtered at read time, so
+//! nothing has to sweep them. Visibility:
+//!
+//! - signed-in callers see live announcements with no `target_roles`, or
+//!   with a role they hold, minus the dismissible ones they dismissed;
+//! - anonymous callers see only untargeted `critical` announcements, so an
+//!   outage notice reaches the login page too.
+//!
+//! Every request reads the table through a per-instance [`TtlCache`] that
+//! admin writes invalidate. Dismissals are per user and are read uncached.
+
+use std::time::Duration;
+
+use chrono::{DateTime, Utc};
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, Message, WaferError};
+
+use super::admin::{ANNOUNCEMENTS_TABLE, ANNOUNCEMENT_DISMISSALS_TABLE};
+use crate::{
+    cache::TtlCache,
+    revisions::{self, Category},
+    util::RecordExt,
+};
+
+/// Longest accepted banner text, in characters.
+pub const MAX_MESSAGE_CHARS: usize = 2000;
+
+/// How loud a banner is. `critical` ones are also shown to anonymous
+/// visitors.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, serde::Serialize, serde::Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Severity {
+    #[default]
+    Info,
+    Warning,
+    Critical,
+}
+
+impl Severity {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Info => "info",
+            Self::Warning => "warning",
+            Self::Critical => "critical",
+        }
+    }
+
+    fn parse(s: &str) -> Option<Self> {
+        match s {
+            "info" => Some(Self::Info),
+            "warning" => Some(Self::Warning),
+            "critical" => Some(Self::Critical),
+            _ => None,
+        }
+    }
+}
+
+/// A stored announcement.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct Announcement {
+    pub id: String,
+    pub message: String,
+    pub severity: Severity,
+    /// RFC 3339, UTC.
+    pub starts_at: String,
+    /// RFC 3339, UTC; `None` never expires.
+    pub ends_at: Option<String>,
+    pub dismissible: bool,
+    /// Roles the banner is shown to; empty means everyone.
+    pub target_roles: Vec<String>,
+    pub created_by: String,
+    pub created_at: String,
+    pub updated_at: String,
+}
+
+impl Announcement {
+    fn from_record(r: &Record) -> Option<Self> {
+        let ends_at = r.str_field("ends_at");
+        Some(Self {
+            id: r.id.clone(),
+            message: r.str_field("message").to_string(),
+            severity: Severity::parse(r.str_field("severity"))?,
+            starts_at: r.str_field("starts_at").to_string(),
+            ends_at: (!ends_at.is_empty()).then(|| ends_at.to_string()),
+            dismissible: r.i64_field("dismissible") != 0,
+            target_roles: split_roles(r.str_field("target_roles")),
+            created_by: r.str_field("created_by").to_string(),
+            created_at: r.str_field("created_at").to_string(),
+            updated_at: r.str_field("updated_at").to_string(),
+        })
+    }
+
+    /// Whether `now` falls inside the display window. Unparseable bounds
+    /// (hand-edited rows) hide the banner rather than pin it forever.
+    pub fn is_live(&self, now: DateTime<Utc>) -> bool {
+        let Some(starts) = parse_time(&self.starts_at) else {
+            return false;
+        };
+        let ends_ok = match &self.ends_at {
+            None => true,
+            Some(ends) => parse_time(ends).is_some_and(|e| now < e),
+        };
+        starts <= now && ends_ok
+    }
+
+    /// Whether the banner is addressed to a caller holding `roles`.
+    /// Anonymous callers (`None`) only get untargeted critical banners.
+    pub fn is_for(&self, roles: Option<&[&str]>) -> bool {
+        match roles {
+            None => self.severity == Severity::Critical && self.target_roles.is_empty(),
+            Some(roles) => {
+                self.target_roles.is_empty()
+                    || self
+                        .target_roles
+                        .iter()
+                        .any(|t| roles.contains(&t.as_str()))
+            }
+        }
+    }
+
+    /// `scheduled`, `active` or `expired` at `now`, for the admin list.
+    pub fn status(&self, now: DateTime<Utc>) -> &'static str {
+        if self.is_live(now) {
+            "active"
+        } else if parse_time(&self.starts_at).is_some_and(|s| now < s) {
+            "scheduled"
+        } else {
+            "expired"
+        }
+    }
+}
+
+/// Admin create/update body. Omitted fields take their defaults: start
+/// now, never end, dismissible, shown to everyone.
+#[derive(Debug, Clone, Default, serde::Deserialize)]
+#[serde(default)]
+pub struct AnnouncementInput {
+    pub message: String,
+    pub severity: Severity,
+    pub starts_at: Option<String>,
+    pub ends_at: Option<String>,
+    pub dismissible: Option<bool>,
+    pub target_roles: Vec<String>,
+}
+
+/// An [`AnnouncementInput`] that passed [`AnnouncementInput::validate`],
+/// with times normalized to UTC.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ValidAnnouncement {
+    message: String,
+    severity: Severity,
+    starts_at: String,
+    ends_at: Option<String>,
+    dismissible: bool,
+    target_roles: Vec<String>,
+}
+
+impl AnnouncementInput {
+    /// Normalize and check the input. On failure returns `(field, reason)`
+    /// pairs for the admin API's validation response.
+    pub fn validate(
+        self,
+        now: DateTime<Utc>,
+    ) -> Result<ValidAnnouncement, Vec<(&'static str, &'static str)>> {
+        let mut problems = Vec::new();
+        let message = self.message.trim().to_string();
+        if message.is_empty() {
+            problems.push(("message", "required"));
+        } else if message.chars().count() > MAX_MESSAGE_CHARS {
+            problems.push(("message", "too long"));
+        }
+
+        let starts = match self.starts_at.as_deref().map(str::trim) {
+            None | Some("") => Some(now),
+            Some(raw) => {
+                let parsed = parse_time(raw);
+                if parsed.is_none() {
+                    problems.push(("starts_at", "must be an RFC 3339 timestamp"));
+                }
+                parsed
+            }
+        };
+        let ends = match self.ends_at.as_deref().map(str::trim) {
+            None | Some("") => None,
+            Some(raw) => {
+                let parsed = parse_time(raw);
+                if parsed.is_none() {
+                    problems.push(("ends_at", "must be an RFC 3339 timestamp"));
+                }
+                parsed
+            }
+        };
+        if let (Some(s), Some(e)) = (starts, ends) {
+            if e <= s {
+                problems.push(("ends_at", "must be after starts_at"));
+            }
+        }
+
+        let mut target_roles: Vec<String> = Vec::new();
+        for role in self.target_roles.iter().map(|r| r.trim()) {
+            if role.contains(',') {
+                problems.push(("target_roles", "role names cannot contain ','"));
+            } else if !role.is_empty() && !target_roles.iter().any(|r| r == role) {
+                target_roles.push(role.to_string());
+            }
+        }
+
+        match starts {
+            Some(starts) if problems.is_empty() => Ok(ValidAnnouncement {
+                message,
+                severity: self.severity,
+                starts_at: format_time(starts),
+                ends_at: ends.map(format_time),
+                dismissible: self.dismissible.unwrap_or(true),
+                target_roles,
+            }),
+            _ => Err(problems),
+        }
+    }
+}
+
+fn parse_time(s: &str) -> Option<DateTime<Utc>> {
+    crate::util::parse_timestamp(s)
+}
+
+fn format_time(t: DateTime<Utc>) -> String {
+    crate::util::format_rfc3339(t)
+}
+
+fn split_roles(s: &str) -> Vec<String> {
+    s.split(',')
+        .map(str::trim)
+        .filter(|r| !r.is_empty())
+        .map(str::to_string)
+        .collect()
+}
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::json!(value),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Cache
+// ---------------------------------------------------------------------------
+
+/// The announcements table as last read. Only an admin write on this
+/// instance invalidates it, so the TTL bounds how long another instance
+/// keeps showing an edited or deleted banner. Tests disable it: every
+/// `TestContext` has its own database but this cache is process-wide.
+static CACHE: TtlCache<Vec<Announcement>> = TtlCache::new(CACHE_TTL);
+
+#[cfg(not(test))]
+const CACHE_TTL: Duration = Duration::from_secs(60);
+#[cfg(test)]
+const CACHE_TTL: Duration = Duration::ZERO;
+
+/// Every stored announcement, newest start first.
+async fn load_all(ctx: &dyn Context) -> Result<Vec<Announcement>, WaferError> {
+    let rows = db::list_all(ctx, ANNOUNCEMENTS_TABLE, vec![]).await?;
+    let mut all: Vec<Announcement> = rows.iter().filter_map(Announcement::from_record).collect();
+    all.sort_by(|a, b| b.starts_at.cmp(&a.starts_at).then_with(|| a.id.cmp(&b.id)));
+    Ok(all)
+}
+
+/// [`load_all`] through the cache. `Instant` panics on wasm32, so the
+/// browser and Cloudflare builds read the table on every call instead.
+async fn cached(ctx: &dyn Context) -> Result<Vec<Announcement>, WaferError> {
+    if cfg!(target_arch = "wasm32") {
+        return load_all(ctx).await;
+    }
+    let mut failed = None;
+    let slot = &mut failed;
+    let hit = CACHE
+        .get_or_load(|| async move {
+            load_all(ctx).await.unwrap_or_else(|e| {
+                *slot = Some(e);
+                Vec::new()
+            })
+        })
+        .await;
+    if let Some(e) = failed {
+        // Don't pin a transient backend failure for a whole TTL.
+        CACHE.invalidate();
+        return Err(e);
+    }
+    Ok(hit.to_vec())
+}
+
+// ---------------------------------------------------------------------------
+// Admin operations
+// ---------------------------------------------------------------------------
+
+/// Every announcement, including scheduled and expired ones.
+pub async fn list(ctx: &dyn Context) -> Result<Vec<Announcement>, WaferError> {
+    load_all(ctx).await
+}
+
+/// Fetch one announcement by id.
+pub async fn get(ctx: &dyn Context, id: &str) -> Result<Option<Announcement>, WaferError> {
+    match db::get(ctx, ANNOUNCEMENTS_TABLE, id).await {
+        Ok(r) => Ok(Announcement::from_record(&r)),
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+fn fields(a: &ValidAnnouncement) -> std::collections::HashMap<String, serde_json::Value> {
+    crate::util::json_map(serde_json::json!({
+        "message": a.message,
+        "severity": a.severity.as_str(),
+        "starts_at": a.starts_at,
+        "ends_at": a.ends_at,
+        "dismissible": i64::from(a.dismissible),
+        "target_roles": a.target_roles.join(","),
+    }))
+}
+
+/// Store a new announcement authored by `created_by`.
+pub async fn create(
+    ctx: &dyn Context,
+    a: &ValidAnnouncement,
+    created_by: &str,
+) -> Result<Announcement, WaferError> {
+    let mut data = fields(a);
+    data.insert("created_by".to_string(), serde_json::json!(created_by));
+    crate::util::stamp_created(&mut data);
+    let record = db::create(ctx, ANNOUNCEMENTS_TABLE, data).await?;
+    CACHE.invalidate();
+    revisions::bump(Category::Announcements);
+    Announcement::from_record(&record).ok_or_else(|| {
+        WaferError::new(
+            wafer_run::ErrorCode::Internal,
+            "stored announcement is unreadable",
+        )
+    })
+}
+
+/// Replace an announcement's content and window. Existing dismissals are
+/// kept: editing a banner does not re-show it to users who closed it.
+pub async fn update(
+    ctx: &dyn Context,
+    id: &str,
+    a: &ValidAnnouncement,
+) -> Result<Option<Announcement>, WaferError> {
+    let mut data = fields(a);
+    crate::util::stamp_updated(&mut data);
+    match db::update(ctx, ANNOUNCEMENTS_TABLE, id, data).await {
+        Ok(record) => {
+            CACHE.invalidate();
+            revisions::bump(Category::Announcements);
+            Ok(Announcement::from_record(&record))
+        }
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// Delete an announcement and its dismissals. Returns `false` when it did
+/// not exist.
+pub async fn delete(ctx: &dyn Context, id: &str) -> Result<bool, WaferError> {
+    match db::delete(ctx, ANNOUNCEMENTS_TABLE, id).await {
+        Ok(()) => {}
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => return Ok(false),
+        Err(e) => return Err(e),
+    }
+    CACHE.invalidate();
+    revisions::bump(Category::Announcements);
+    db::delete_by_filters(
+        ctx,
+        ANNOUNCEMENT_DISMISSALS_TABLE,
+        vec![eq("announcement_id", id)],
+    )
+    .await?;
+    Ok(true)
+}
+
+// ---------------------------------------------------------------------------
+// User-facing reads
+// ---------------------------------------------------------------------------
+
+fn roles_of(msg: &Message) -> Vec<&str> {
+    msg.get_meta("auth.user_roles")
+        .split(',')
+        .map(str::trim)
+        .filter(|r| !r.is_empty())
+        .collect()
+}
+
+/// Live announcements addressed to the caller, minus the ones they
+/// dismissed. Anonymous callers get only untargeted critical banners.
+pub async fn visible_to(ctx: &dyn Context, msg: &Message) -> Result<Vec<Announcement>, WaferError> {
+    let now = Utc::now();
+    let user_id = msg.user_id();
+    let roles = roles_of(msg);
+    let audience = (!user_id.is_empty()).then_some(roles.as_slice());
+
+    let mut out: Vec<Announcement> = cached(ctx)
+        .await?
+        .into_iter()
+        .filter(|a| a.is_live(now) && a.is_for(audience))
+        .collect();
+    if user_id.is_empty() || !out.iter().any(|a| a.dismissible) {
+        return Ok(out);
+    }
+
+    let dismissed: Vec<String> = db::list_all(
+        ctx,
+        ANNOUNCEMENT_DISMISSALS_TABLE,
+        vec![eq("user_id", user_id)],
+    )
+    .await?
+    .iter()
+    .map(|r| r.str_field("announcement_id").to_string())
+    .collect();
+    out.retain(|a| !(a.dismissible && dismissed.contains(&a.id)));
+    Ok(out)
+}
+
+/// Why [`dismiss`] refused.
+#[derive(Debug)]
+pub enum DismissError {
+    /// No live announcement with that id is shown to the caller.
+    NotFound,
+    /// The announcement cannot be dismissed.
+    NotDismissible,
+    Backend(WaferError),
+}
+
+/// Record that `msg`'s user dismissed announcement `id`. Dismissing twice
+/// is a no-op.
+pub async fn dismiss(ctx: &dyn Context, msg: &Message, id: &str) -> Result<(), DismissError> {
+    let roles = roles_of(msg);
+    let found = cached(ctx)
+        .await
+        .map_err(DismissError::Backend)?
+        .into_iter()
+        .find(|a| a.id == id && a.is_live(Utc::now()) && a.is_for(Some(roles.as_slice())));
+    match found {
+        None => return Err(DismissError::NotFound),
+        Some(a) if !a.dismissible => return Err(DismissError::NotDismissible),
+        Some(_) => {}
+    }
+
+    let mut data = std::collections::HashMap::new();
+    data.insert("announcement_id".to_string(), serde_json::json!(id));
+    data.insert("user_id".to_string(), serde_json::json!(msg.user_id()));
+    data.insert(
+        "created_at".to_string(),
+        serde_json::json!(crate::util::now_rfc3339()),
+    );
+    match db::create(ctx, ANNOUNCEMENT_DISMISSALS_TABLE, data).await {
+        Ok(_) => Ok(()),
+        // UNIQUE (user_id, announcement_id): already dismissed.
+        Err(e) if e.code == wafer_run::ErrorCode::AlreadyExists => Ok(()),
+        Err(e) => Err(DismissError::Backend(e)),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn at(s: &str) -> DateTime<Utc> {
+        parse_time(s).unwrap()
+    }
+
+    fn announcement(severity: Severity, target_roles: &[&str]) -> Announcement {
+        Announcement {
+            id: "a1".into(),
+            message: "Maintenance tonight".into(),
+            severity,
+            starts_at: "2026-01-01T00:00:00Z".into(),
+            ends_at: Some("2026-01-02T00:00:00Z".into()),
+            dismissible: true,
+            target_roles: target_roles.iter().map(|r| r.to_string()).collect(),
+            created_by: String::new(),
+            created_at: String::new(),
+            updated_at: String::new(),
+        }
+    }
+
+    #[test]
+    fn live_window_is_start_inclusive_end_exclusive() {
+        let mut a = announcement(Severity::Info, &[]);
+        assert!(!a.is_live(at("2025-12-31T23:59:59Z")));
+        assert!(a.is_live(at("2026-01-01T00:00:00Z")));
+        assert!(!a.is_live(at("2026-01-02T00:00:00Z")));
+        assert_eq!(a.status(at("2025-12-31T00:00:00Z")), "scheduled");
+        assert_eq!(a.status(at("2026-01-03T00:00:00Z")), "expired");
+
+        a.ends_at = None;
+        assert!(a.is_live(at("2030-01-01T00:00:00Z")));
+    }
+
+    #[test]
+    fn anonymous_callers_only_see_untargeted_critical_banners() {
+        let info = announcement(Severity::Info, &[]);
+        let critical = announcement(Severity::Critical, &[]);
+        let critical_admins = announcement(Severity::Critical, &["admin"]);
+
+        assert!(!info.is_for(None));
+        assert!(critical.is_for(None));
+        assert!(!critical_admins.is_for(None));
+
+        assert!(info.is_for(Some(&[])));
+        assert!(critical_admins.is_for(Some(&["user", "admin"])));
+        assert!(!critical_admins.is_for(Some(&["user"])));
+    }
+
+    #[test]
+    fn validate_normalizes_and_reports_fields() {
+        let now = at("2026-03-01T12:00:00Z");
+        let ok = AnnouncementInput {
+            message: "  Hello  ".into(),
+            ends_at: Some("2026-03-02T00:00:00+02:00".into()),
+            target_roles: vec!["pro".into(), " pro ".into(), String::new()],
+            ..Default::default()
+        }
+        .validate(now)
+        .unwrap();
+        assert_eq!(ok.message, "Hello");
+        assert_eq!(ok.starts_at, "2026-03-01T12:00:00.000000Z");
+        assert_eq!(ok.ends_at.as_deref(), Some("2026-03-01T22:00:00.000000Z"));
+        assert!(ok.dismissible);
+        assert_eq!(ok.target_roles, vec!["pro"]);
+
+        let bad = AnnouncementInput {
+            message: " ".into(),
+            starts_at: Some("2026-03-05T00:00:00Z".into()),
+            ends_at: Some("2026-03-04T00:00:00Z".into()),
+            ..Default::default()
+        }
+        .validate(now)
+        .unwrap_err();
+        assert_eq!(
+            bad,
+            vec![
+                ("message", "required"),
+                ("ends_at", "must be after starts_at")
+            ]
+        );
+
+        let bad = AnnouncementInput {
+            message: "x".into(),
+            starts_at: Some("tomorrow".into()),
+            ..Default::default()
+        }
+        .validate(now)
+        .unwrap_err();
+        assert_eq!(bad, vec![("starts_at", "must be an RFC 3339 timestamp")]);
+    }
+}
@@ -0,0 +1,642 @@
+//! API usage quotas (IAM): admin-defined limits on tagged routes.
+//!
+//! Unlike the per-minute [`super::rate_limit`] buckets, which are fixed in
+//! code and only tunable through `RATE_LIMIT_*` config, quotas are data:
+//! admins manage [`QuotaDefinition`]s via `GET|PUT /b/admin/api/iam/quotas`
+//! and users read their own consumption at `GET /b/auth/api/quotas/usage`.
+//!
+//! A route opts in by appearing in its block's `QUOTA_ROUTES` table, next to
+//! the block's dispatch table — `EndpointRoute`s whose handler is a resource
+//! tag such as `storage.upload`. Untagged routes are never counted, and a
+//! tag with no matching definition costs one definitions read and nothing
+//! more.
+//!
+//! The request pipeline calls [`enforce`] after authentication and before
+//! routing. For an authenticated request on a tagged route it resolves the
+//! caller's definition (see [`applicable`]), consumes one unit from the
+//! fixed-window counter `{user_id}:{resource}` and either attaches
+//! `X-Quota-*` headers to the response or answers 429
+//! `usage_quota_exceeded`. Counters use the same atomic
+//! `OnConflict::WindowedCounter` upsert as the D1 rate limiter, so they are
+//! shared across isolates and survive restarts. Backend failures fail open
+//! with a warning, like the rate limiter.
+//!
+//! Service accounts are users too: a `user`-scoped definition whose subject
+//! is a service account's `sa_…` id caps that integration alone.
+//!
+//! A few resources cap simultaneous use instead ([`concurrency_resources`],
+//! such as `storage.concurrent_downloads`): their definitions' `limit` is
+//! how many may run at once, `window_secs` is unused, and the owning block
+//! reads the cap through [`concurrency_limit`] rather than [`enforce`].
+
+use wafer_block::{
+    db::{Filter, FilterOp},
+    wire::database::OnConflict,
+};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, Message, MetaEntry, OutputStream, WaferError};
+
+use super::{
+    admin::{QUOTAS_TABLE, QUOTA_COUNTERS_TABLE},
+    errors::{self, ErrorCode},
+};
+use crate::{
+    endpoint_match::{self, EndpointRoute},
+    http::ResponseBuilder,
+    util::RecordExt,
+};
+
+/// Subject of a `user`-scoped definition that applies to every user.
+pub const EVERY_USER: &str = "*";
+
+/// Who a [`QuotaDefinition`] applies to.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum QuotaScope {
+    /// `subject` is a user id, or [`EVERY_USER`].
+    User,
+    /// `subject` is a role name.
+    Role,
+}
+
+impl QuotaScope {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::User => "user",
+            Self::Role => "role",
+        }
+    }
+}
+
+/// `limit` uses of `resource` per `window_secs`, for `subject`.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+pub struct QuotaDefinition {
+    pub resource: String,
+    pub scope: QuotaScope,
+    pub subject: String,
+    pub limit: i64,
+    /// Unused (and may be omitted) for a concurrency resource.
+    #[serde(default)]
+    pub window_secs: i64,
+}
+
+impl QuotaDefinition {
+    fn from_record(r: &Record) -> Option<Self> {
+        let scope = match r.str_field("scope") {
+            "user" => QuotaScope::User,
+            "role" => QuotaScope::Role,
+            _ => return None,
+        };
+        Some(Self {
+            resource: r.str_field("resource").to_string(),
+            scope,
+            subject: r.str_field("subject").to_string(),
+            limit: r.i64_field("max_count"),
+            window_secs: r.i64_field("window_secs"),
+        })
+    }
+
+    /// Per-field problems, keyed `quotas[{index}].{field}` for the admin
+    /// API's validation response. Empty when the definition is usable.
+    pub(crate) fn problems(&self, index: usize) -> Vec<(String, &'static str)> {
+        let mut out = Vec::new();
+        let field = |name: &str| format!("quotas[{index}].{name}");
+        if !known_resources().contains(&self.resource.as_str()) {
+            out.push((field("resource"), "not a tagged resource"));
+        }
+        if self.subject.trim().is_empty() {
+            out.push((field("subject"), "required"));
+        } else if self.scope == QuotaScope::Role && self.subject == EVERY_USER {
+            out.push((
+                field("subject"),
+                "role quotas name a role; use scope user for '*'",
+            ));
+        }
+        if self.limit < 1 {
+            out.push((field("limit"), "must be at least 1"));
+        }
+        if self.window_secs < 1 && !is_concurrency(&self.resource) {
+            out.push((field("window_secs"), "must be at least 1"));
+        }
+        out
+    }
+
+    /// Whether `self` allows more uses per second than `other`.
+    fn more_generous_than(&self, other: &Self) -> bool {
+        if is_concurrency(&self.resource) {
+            return self.limit > other.limit;
+        }
+        i128::from(self.limit) * i128::from(other.window_secs)
+            > i128::from(other.limit) * i128::from(self.window_secs)
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Route tagging
+// ---------------------------------------------------------------------------
+
+/// Every block's `QUOTA_ROUTES` table, for the blocks compiled in.
+fn tag_tables() -> Vec<&'static [EndpointRoute<&'static str>]> {
+    #[allow(unused_mut)]
+    let mut tables: Vec<&'static [EndpointRoute<&'static str>]> = Vec::new();
+    #[cfg(feature = "block-files")]
+    tables.push(super::files::storage::QUOTA_ROUTES);
+    #[cfg(feature = "block-llm")]
+    tables.push(super::llm::QUOTA_ROUTES);
+    tables
+}
+
+/// The resource tag of the route `(action, path)` matches, if it has one.
+pub fn resource_for(action: &str, path: &str) -> Option<&'static str> {
+    tag_tables().into_iter().flatten().find_map(|route| {
+        (endpoint_match::action_for_method(route.method) == action
+            && endpoint_match::match_template(route.template, path).is_some())
+        .then_some(route.handler)
+    })
+}
+
+/// Resources whose definitions cap simultaneous use, for the blocks
+/// compiled in.
+pub fn concurrency_resources() -> Vec<&'static str> {
+    #[allow(unused_mut)]
+    let mut out: Vec<&'static str> = Vec::new();
+    #[cfg(feature = "block-files")]
+    out.extend_from_slice(super::files::CONCURRENCY_RESOURCES);
+    out
+}
+
+fn is_concurrency(resource: &str) -> bool {
+    concurrency_resources().contains(&resource)
+}
+
+/// Every resource tag a definition may target, sorted and deduplicated.
+pub fn known_resources() -> Vec<&'static str> {
+    let mut tags: Vec<&'static str> = tag_tables()
+        .into_iter()
+        .flatten()
+        .map(|route| route.handler)
+        .chain(concurrency_resources())
+        .collect();
+    tags.sort_unstable();
+    tags.dedup();
+    tags
+}
+
+// ---------------------------------------------------------------------------
+// Definitions
+// ---------------------------------------------------------------------------
+
+/// The definition that governs `user_id` among `defs` (all for one
+/// resource): a definition naming the user wins; otherwise the most
+/// generous definition among the user's roles; otherwise the `*` default.
+/// `None` means the user is not limited on this resource.
+pub fn applicable<'a>(
+    defs: &'a [QuotaDefinition],
+    user_id: &str,
+    roles: &[&str],
+) -> Option<&'a QuotaDefinition> {
+    let by_user = |subject: &str| {
+        defs.iter()
+            .find(|d| d.scope == QuotaScope::User && d.subject == subject)
+    };
+    if let Some(own) = by_user(user_id) {
+        return Some(own);
+    }
+    let by_role = defs
+        .iter()
+        .filter(|d| d.scope == QuotaScope::Role && roles.contains(&d.subject.as_str()))
+        .fold(None::<&QuotaDefinition>, |best, d| match best {
+            Some(b) if !d.more_generous_than(b) => Some(b),
+            _ => Some(d),
+        });
+    by_role.or_else(|| by_user(EVERY_USER))
+}
+
+/// Every stored definition, ordered by resource then scope and subject.
+pub async fn list_definitions(ctx: &dyn Context) -> Result<Vec<QuotaDefinition>, WaferError> {
+    let rows = db::list_all(ctx, QUOTAS_TABLE, vec![]).await?;
+    let mut defs: Vec<QuotaDefinition> = rows
+        .iter()
+        .filter_map(QuotaDefinition::from_record)
+        .collect();
+    defs.sort_by(|a, b| {
+        let key = |d: &QuotaDefinition| (d.resource.clone(), d.scope.as_str(), d.subject.clone());
+        key(a).cmp(&key(b))
+    });
+    Ok(defs)
+}
+
+async fn definitions_for(
+    ctx: &dyn Context,
+    resource: &str,
+) -> Result<Vec<QuotaDefinition>, WaferError> {
+    let rows = db::list_all(ctx, QUOTAS_TABLE, vec![eq("resource", resource)]).await?;
+    Ok(rows
+        .iter()
+        .filter_map(QuotaDefinition::from_record)
+        .collect())
+}
+
+/// Replace the stored definition set with `defs` (already validated).
+/// Counters are left alone: a user keeps what they consumed in the
+/// current window under the new limit.
+pub async fn replace_definitions(
+    ctx: &dyn Context,
+    defs: &[QuotaDefinition],
+) -> Result<(), WaferError> {
+    for row in db::list_all(ctx, QUOTAS_TABLE, vec![]).await? {
+        db::delete(ctx, QUOTAS_TABLE, &row.id).await?;
+    }
+    for def in defs {
+        let mut data = std::collections::HashMap::new();
+        data.insert("resource".to_string(), serde_json::json!(def.resource));
+        data.insert("scope".to_string(), serde_json::json!(def.scope.as_str()));
+        data.insert("subject".to_string(), serde_json::json!(def.subject));
+        data.insert("max_count".to_string(), serde_json::json!(def.limit));
+        data.insert(
+            "window_secs".to_string(),
+            serde_json::json!(def.window_secs),
+        );
+        crate::util::stamp_created(&mut data);
+        db::create(ctx, QUOTAS_TABLE, data).await?;
+    }
+    Ok(())
+}
+
+// ---------------------------------------------------------------------------
+// Counters
+// ---------------------------------------------------------------------------
+
+/// A user's standing against one definition.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct Usage {
+    pub resource: String,
+    pub scope: QuotaScope,
+    pub limit: i64,
+    pub window_secs: i64,
+    pub used: i64,
+    pub remaining: i64,
+    /// Unix seconds when the current window ends; `None` when no window is
+    /// open (nothing used yet, or the last window expired).
+    pub reset_at: Option<i64>,
+}
+
+impl Usage {
+    /// Standing from the counter row (if any) at unix time `now`.
+    fn from_counter(def: &QuotaDefinition, row: Option<&Record>, now: i64) -> Self {
+        let window = row
+            .map(|r| (r.i64_field("count"), r.i64_field("window_start")))
+            .filter(|(_, start)| *start >= now - def.window_secs);
+        let (used, reset_at) = match window {
+            Some((count, start)) => (count, Some(start + def.window_secs)),
+            None => (0, None),
+        };
+        Self {
+            resource: def.resource.clone(),
+            scope: def.scope,
+            limit: def.limit,
+            window_secs: def.window_secs,
+            used,
+            remaining: (def.limit - used).max(0),
+            reset_at,
+        }
+    }
+
+    fn exceeded(&self) -> bool {
+        self.used > self.limit
+    }
+
+    fn headers(&self) -> Vec<MetaEntry> {
+        let mut headers = vec![
+            ("X-Quota-Resource", self.resource.clone()),
+            ("X-Quota-Limit", self.limit.to_string()),
+            ("X-Quota-Remaining", self.remaining.to_string()),
+        ];
+        if let Some(reset_at) = self.reset_at {
+            headers.push(("X-Quota-Reset", reset_at.to_string()));
+        }
+        headers
+            .into_iter()
+            .map(|(name, value)| MetaEntry {
+                key: format!("resp.header.{name}"),
+                value,
+            })
+            .collect()
+    }
+}
+
+fn counter_key(user_id: &str, resource: &str) -> String {
+    format!("{user_id}:{resource}")
+}
+
+fn now_secs() -> i64 {
+    (crate::util::now_millis() / 1000) as i64
+}
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.into(),
+        operator: FilterOp::Equal,
+        value: serde_json::json!(value),
+    }
+}
+
+async fn read_counter(ctx: &dyn Context, key: &str) -> Result<Option<Record>, WaferError> {
+    Ok(
+        db::list_all(ctx, QUOTA_COUNTERS_TABLE, vec![eq("key", key)])
+            .await?
+            .into_iter()
+            .next(),
+    )
+}
+
+/// Count one use of `def.resource` by `user_id` and return the new standing.
+async fn consume(
+    ctx: &dyn Context,
+    user_id: &str,
+    def: &QuotaDefinition,
+    now: i64,
+) -> Result<Usage, WaferError> {
+    let key = counter_key(user_id, &def.resource);
+    let id = crate::util::sha256_hex(format!("quota:{key}:{now}").as_bytes());
+    db::upsert(
+        ctx,
+        QUOTA_COUNTERS_TABLE,
+        vec![
+            ("id".to_string(), serde_json::json!(id)),
+            ("key".to_string(), serde_json::json!(key)),
+        ],
+        vec!["key".to_string()],
+        OnConflict::WindowedCounter {
+            count_field: "count".to_string(),
+            window_field: "window_start".to_string(),
+            now,
+            window_cutoff: now - def.window_secs,
+            created_fields: vec!["created_at".to_string()],
+            updated_fields: vec!["updated_at".to_string()],
+        },
+    )
+    .await?;
+    let row = read_counter(ctx, &key).await?;
+    Ok(Usage::from_counter(def, row.as_ref(), now))
+}
+
+fn roles_of(msg: &Message) -> Vec<&str> {
+    msg.get_meta("auth.user_roles")
+        .split(',')
+        .map(str::trim)
+        .filter(|r| !r.is_empty())
+        .collect()
+}
+
+// ---------------------------------------------------------------------------
+// Enforcement + self-service usage
+// ---------------------------------------------------------------------------
+
+/// Result of [`enforce`].
+pub enum QuotaOutcome {
+    /// Anonymous caller, untagged route, or no applicable definition.
+    Untracked,
+    /// Counted and within the limit — attach these headers to the response.
+    Counted(Vec<MetaEntry>),
+    /// Over the limit — return this 429 instead of dispatching.
+    Exceeded(OutputStream),
+}
+
+/// Count the request against the caller's quota for its route's resource.
+pub async fn enforce(ctx: &dyn Context, msg: &Message) -> QuotaOutcome {
+    let user_id = msg.user_id();
+    if user_id.is_empty() {
+        return QuotaOutcome::Untracked;
+    }
+    let Some(resource) = resource_for(msg.action(), msg.path()) else {
+        return QuotaOutcome::Untracked;
+    };
+    charge(ctx, user_id, &roles_of(msg), resource).await
+}
+
+/// Count one use of `resource` against `user_id`, who holds `roles`. For
+/// traffic a user pays for without making the request, like an anonymous
+/// visit to their bucket's website; [`enforce`] charges the caller.
+pub async fn charge(
+    ctx: &dyn Context,
+    user_id: &str,
+    roles: &[&str],
+    resource: &str,
+) -> QuotaOutcome {
+    let defs = match definitions_for(ctx, resource).await {
+        Ok(defs) => defs,
+        Err(e) => {
+            tracing::warn!(error = %e, resource, "quota definitions unreadable — failing open");
+            return QuotaOutcome::Untracked;
+        }
+    };
+    let Some(def) = applicable(&defs, user_id, roles) else {
+        return QuotaOutcome::Untracked;
+    };
+    match consume(ctx, user_id, def, now_secs()).await {
+        Ok(usage) if usage.exceeded() => QuotaOutcome::Exceeded(exceeded_response(&usage)),
+        Ok(usage) => QuotaOutcome::Counted(usage.headers()),
+        Err(e) => {
+            tracing::warn!(error = %e, resource, "quota counter update failed — failing open");
+            QuotaOutcome::Untracked
+        }
+    }
+}
+
+/// The cap the caller's definition sets on the concurrency resource
+/// `resource`, or `None` when none applies (anonymous callers included)
+/// and the block's own default stands. Unreadable definitions fall back
+/// to the default with a warning.
+pub async fn concurrency_limit(ctx: &dyn Context, msg: &Message, resource: &str) -> Option<i64> {
+    let user_id = msg.user_id();
+    if user_id.is_empty() {
+        return None;
+    }
+    let defs = match definitions_for(ctx, resource).await {
+        Ok(defs) => defs,
+        Err(e) => {
+            tracing::warn!(error = %e, resource, "quota definitions unreadable — default cap");
+            return None;
+        }
+    };
+    applicable(&defs, user_id, &roles_of(msg)).map(|def| def.limit)
+}
+
+/// 429 `usage_quota_exceeded` with the standing in `details`, the
+/// `X-Quota-*` headers and `Retry-After`.
+fn exceeded_response(usage: &Usage) -> OutputStream {
+    let code = ErrorCode::UsageQuotaExceeded;
+    let retry_after = usage.reset_at.map_or(1, |at| (at - now_secs()).max(1));
+    let mut resp = ResponseBuilder::new()
+        .status(code.status_code())
+        .set_header("Retry-After", &retry_after.to_string());
+    for header in usage.headers() {
+        let name = header.key.trim_start_matches("resp.header.");
+        resp = resp.set_header(name, &header.value);
+    }
+    resp.json(&serde_json::json!({
+        "error": errors::solobase_error_code_to_wafer(code),
+        "message": format!("Usage quota for {} exhausted", usage.resource),
+        "code": code.as_str(),
+        "details": usage,
+    }))
+}
+
+/// The caller's standing on every resource a definition applies to them
+/// for, without consuming anything.
+pub async fn usage_for(ctx: &dyn Context, msg: &Message) -> Result<Vec<Usage>, WaferError> {
+    let user_id = msg.user_id();
+    let roles = roles_of(msg);
+    let defs = list_definitions(ctx).await?;
+    let now = now_secs();
+    let mut out = Vec::new();
+    for resource in known_resources() {
+        if is_concurrency(resource) {
+            continue;
+        }
+        let for_resource: Vec<QuotaDefinition> = defs
+            .iter()
+            .filter(|d| d.resource == resource)
+            .cloned()
+            .collect();
+        let Some(def) = applicable(&for_resource, user_id, &roles) else {
+            continue;
+        };
+        let row = read_counter(ctx, &counter_key(user_id, resource)).await?;
+        out.push(Usage::from_counter(def, row.as_ref(), now));
+    }
+    Ok(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{clock, test_support::TestContext};
+
+    fn def(scope: QuotaScope, subject: &str, limit: i64, window_secs: i64) -> QuotaDefinition {
+        QuotaDefinition {
+            resource: "llm.chat".into(),
+            scope,
+            subject: subject.into(),
+            limit,
+            window_secs,
+        }
+    }
+
+    #[test]
+    fn applicable_prefers_user_then_most_generous_role_then_default() {
+        let defs = vec![
+            def(QuotaScope::User, EVERY_USER, 10, 86_400),
+            def(QuotaScope::Role, "pro", 1_000, 86_400),
+            def(QuotaScope::Role, "beta", 100, 3_600),
+            def(QuotaScope::User, "u-vip", 5, 60),
+        ];
+        let subject =
+            |user: &str, roles: &[&str]| applicable(&defs, user, roles).map(|d| d.subject.clone());
+        assert_eq!(subject("u-vip", &["pro"]).as_deref(), Some("u-vip"));
+        // 100/hour beats 1000/day.
+        assert_eq!(subject("u1", &["pro", "beta"]).as_deref(), Some("beta"));
+        assert_eq!(subject("u1", &["pro"]).as_deref(), Some("pro"));
+        assert_eq!(subject("u1", &["user"]).as_deref(), Some(EVERY_USER));
+        assert_eq!(applicable(&defs[1..3], "u1", &[]), None);
+    }
+
+    #[test]
+    fn usage_ignores_an_expired_window() {
+        let d = def(QuotaScope::User, EVERY_USER, 3, 60);
+        let mut row = Record {
+            id: "c".into(),
+            data: Default::default(),
+        };
+        row.data.insert("count".into(), serde_json::json!(4));
+        row.data
+            .insert("window_start".into(), serde_json::json!(1_000));
+
+        let open = Usage::from_counter(&d, Some(&row), 1_030);
+        assert_eq!(
+            (open.used, open.remaining, open.reset_at),
+            (4, 0, Some(1_060))
+        );
+        assert!(open.exceeded());
+
+        let expired = Usage::from_counter(&d, Some(&row), 1_061);
+        assert_eq!(
+            (expired.used, expired.remaining, expired.reset_at),
+            (0, 3, None)
+        );
+    }
+
+    #[cfg(feature = "block-files")]
+    #[test]
+    fn tagged_routes_resolve_to_their_resource() {
+        assert_eq!(
+            resource_for("create", "/b/storage/api/buckets/docs/objects"),
+            Some("storage.upload")
+        );
+        assert_eq!(
+            resource_for("retrieve", "/b/storage/api/buckets/docs/preview/a/b.txt"),
+            Some("storage.download")
+        );
+        assert_eq!(resource_for("retrieve", "/b/storage/api/buckets"), None);
+        assert!(known_resources().contains(&"storage.upload"));
+    }
+
+    #[cfg(feature = "block-files")]
+    #[test]
+    fn problems_name_the_offending_fields() {
+        let bad = QuotaDefinition {
+            resource: "nope".into(),
+            scope: QuotaScope::Role,
+            subject: EVERY_USER.into(),
+            limit: 0,
+            window_secs: 60,
+        };
+        let fields: Vec<String> = bad.problems(2).into_iter().map(|(f, _)| f).collect();
+        assert_eq!(
+            fields,
+            vec!["quotas[2].resource", "quotas[2].subject", "quotas[2].limit"]
+        );
+    }
+
+    #[cfg(feature = "block-files")]
+    #[test]
+    fn concurrency_definitions_need_no_window_and_compare_by_cap() {
+        let cap = |scope, subject: &str, limit| QuotaDefinition {
+            resource: "storage.concurrent_uploads".into(),
+            scope,
+            subject: subject.into(),
+            limit,
+            window_secs: 0,
+        };
+        assert!(known_resources().contains(&"storage.concurrent_uploads"));
+        assert!(cap(QuotaScope::User, EVERY_USER, 2).problems(0).is_empty());
+        let defs = vec![cap(QuotaScope::Role, "a", 4), cap(QuotaScope::Role, "b", 8)];
+        let best = applicable(&defs, "u1", &["a", "b"]).map(|d| d.limit);
+        assert_eq!(best, Some(8));
+    }
+
+    #[tokio::test]
+    async fn window_resets_only_once_it_has_passed() {
+        let ctx = TestContext::with_admin().await;
+        let hourly = QuotaDefinition {
+            resource: "storage.download".into(),
+            scope: QuotaScope::User,
+            subject: EVERY_USER.into(),
+            limit: 2,
+            window_secs: 3600,
+        };
+        replace_definitions(&ctx, &[hourly]).await.unwrap();
+        let download = || charge(&ctx, "u1", &[], "storage.download");
+
+        clock::freeze(clock::at("2026-05-01T09:00:00Z"));
+        assert!(matches!(download().await, QuotaOutcome::Counted(_)));
+        assert!(matches!(download().await, QuotaOutcome::Counted(_)));
+        assert!(matches!(download().await, QuotaOutcome::Exceeded(_)));
+
+        clock::advance(chrono::Duration::seconds(3599));
+        assert!(matches!(download().await, QuotaOutcome::Exceeded(_)));
+        clock::advance(chrono::Duration::seconds(2));
+        assert!(matches!(download().await, QuotaOutcome::Counted(_)));
+    }
+}
@@ -5,61 +5,121 @@
 //!
 //! 1. `BOOTSTRAP_ADMIN_EMAIL` + `BOOTSTRAP_ADMIN_PASSWORD` — hash the password
 //!    via `wafer-run/crypto` and create an admin user + `local_credentials`.
+//!    The account is flagged `must_change_password`: until the operator picks
+//!    a new password, the pipeline only lets it reach change-password and
+//!    logout (see `helpers::password_change_gate`).
 //! 2. `BOOTSTRAP_ADMIN_TOKEN` — store sha256(token) in `bootstrap_tokens`
 //!    with a 24h expiry. The holder later redeems it by presenting the raw
 //!    token as a `Bearer` header (see `AuthServiceImpl::require_role`).
-//! 3. None set — log and do nothing; leaves the operator to provision via UI
-//!    / CLI.
+//! 3. None set — setup-token mode: generate a random token, install it the
+//!    same way as path 2, and print a one-time `/b/auth/bootstrap?token=…`
+//!    URL to stdout. Each boot replaces the previous token, and redemption
+//!    consumes it.
 //!
 //! If the `users` table is non-empty, [`run`] is a no-op regardless of env —
-//! bootstrap is a first-run mechanism only, never a "re-seed" trigger.
+//! bootstrap is a first-run mechanism only, never a "re-seed" trigger — apart
+//! from warning when `BOOTSTRAP_ADMIN_PASSWORD` is still set. With
+//! `SOLOBASE_SHARED__AUTH__BOOTSTRAP_ONCE` on, an emptied users table is not
+//! re-seeded either once a bootstrap has completed (`bootstrap_state`).
 
 use wafer_core::clients::crypto;
 use wafer_run::{context::Context, ErrorCode, WaferError};
 
 use super::{
-    config::AuthConfig,
-    repo::{bootstrap_tokens, local_credentials, users},
+    config::{AuthConfig, BOOTSTRAP_ADMIN_PASSWORD_KEY, BOOTSTRAP_ONCE_KEY},
+    repo::{bootstrap_state, bootstrap_tokens, local_credentials, users},
     service::hash_token,
 };
+use crate::util::hex_encode;
+
+/// How the first admin was provisioned. Decides whether the account must
+/// change its password on first login and is recorded in `bootstrap_state`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum BootstrapSource {
+    /// `BOOTSTRAP_ADMIN_EMAIL` + `BOOTSTRAP_ADMIN_PASSWORD` from env. The
+    /// password sat in deployment config, so it must be rotated.
+    Env,
+    /// Bootstrap / setup-token redemption. The holder chose the password in
+    /// the redemption form, so no forced change.
+    Token,
+}
+
+impl BootstrapSource {
+    fn as_str(self) -> &'static str {
+        match self {
+            Self::Env => "env",
+            Self::Token => "token",
+        }
+    }
+
+    fn must_change_password(self) -> bool {
+        self == Self::Env
+    }
+}
 
 /// Run the bootstrap step. Idempotent: returns `Ok(())` without side-effects
 /// when the `users` table is already populated.
 pub async fn run(ctx: &dyn Context, cfg: &AuthConfig) -> Result<(), WaferError> {
     let user_count = users::count(ctx).await.map_err(internal)?;
     if user_count > 0 {
+        if cfg.bootstrap_admin_password.is_some() {
+            tracing::warn!(
+                "auth: {BOOTSTRAP_ADMIN_PASSWORD_KEY} is still set but the admin account \
+                 already exists. It is never read again — remove it from the environment \
+                 so the initial password does not linger in deployment config."
+            );
+        }
         tracing::debug!("auth: bootstrap skipped, users table already has {user_count} row(s)");
         return Ok(());
     }
 
+    if cfg.bootstrap_once && bootstrap_state::is_completed(ctx).await.map_err(internal)? {
+        tracing::warn!(
+            "auth: users table is empty but an admin was already bootstrapped and \
+             {BOOTSTRAP_ONCE_KEY} is on; refusing to re-provision an admin from env"
+        );
+        return Ok(());
+    }
+
     match (
         cfg.bootstrap_admin_email.as_deref(),
         cfg.bootstrap_admin_password.as_deref(),
         cfg.bootstrap_admin_token.as_deref(),
     ) {
         (Some(email), Some(password), _) => {
-            bootstrap_with_email_password(ctx, email, password).await?;
-            tracing::info!("auth: bootstrapped admin user: {email}");
+            bootstrap_with_email_password(ctx, email, password, BootstrapSource::Env).await?;
+            tracing::info!("auth: bootstrapped admin user: {email} (password change required)");
         }
         (_, _, Some(token)) => {
             bootstrap_with_token(ctx, token).await?;
             tracing::info!("auth: bootstrap token installed; expires in 24h");
         }
         _ => {
-            tracing::info!("auth: no bootstrap admin configured (users table empty)");
+            let url = install_setup_token(ctx).await?;
+            // The URL carries a live credential: print it to stdout for the
+            // operator at the console, but keep it out of structured logs.
+            println!();
+            println!("  No admin account exists yet. Finish setup within 24h at:");
+            println!();
+            println!("    {url}");
+            println!();
+            tracing::warn!("auth: no admin configured; one-time setup URL printed to stdout");
         }
     }
     Ok(())
 }
 
+/// Create the first admin user with a local password and record the
+/// bootstrap as completed. Returns the new user's id.
 pub(crate) async fn bootstrap_with_email_password(
     ctx: &dyn Context,
     email: &str,
     password: &str,
-) -> Result<(), WaferError> {
+    source: BootstrapSource,
+) -> Result<String, WaferError> {
     let hash = crypto::hash(ctx, password).await?;
     let id = uuid::Uuid::now_v7().to_string();
-    let now = chrono::Utc::now().format("%Y-%m-%dT%H:%M:%SZ").to_string();
+    let now = crate::util::now_rfc3339();
 
     // Write the admin user row directly with the union of Plan A2 columns
     // (display_name, role, email_verified) AND the legacy columns the rest
@@ -98,6 +158,10 @@ pub(crate) async fn bootstrap_with_email_password(
     // set BOOTSTRAP_ADMIN_PASSWORD. Marking verified avoids the unverified
     // state on /b/userportal/security on first login.
     data.insert("email_verified".to_string(), serde_json::Value::Bool(true));
+    data.insert(
+        "must_change_password".to_string(),
+        serde_json::Value::Bool(source.must_change_password()),
+    );
     data.insert(
         "created_at".to_string(),
         serde_json::Value::String(now.clone()),
@@ -115,18 +179,36 @@ pub(crate) async fn bootstrap_with_email_password(
     local_credentials::insert(ctx, &id, &hash, false)
         .await
         .map_err(internal)?;
-    Ok(())
+    // The admin exists at this point; a failed marker write only weakens
+    // BOOTSTRAP_ONCE, so log rather than fail the bootstrap.
+    if let Err(e) = bootstrap_state::mark_completed(ctx, &id, source.as_str()).await {
+        tracing::warn!("auth: failed to record bootstrap completion: {e}");
+    }
+    Ok(id)
 }
 
 async fn bootstrap_with_token(ctx: &dyn Context, token: &str) -> Result<(), WaferError> {
     let expires = chrono::Utc::now() + chrono::Duration::hours(24);
-    let expires_iso = expires.format("%Y-%m-%dT%H:%M:%SZ").to_string();
+    let expires_iso = crate::util::format_rfc3339(expires);
     bootstrap_tokens::insert(ctx, hash_token(token), &expires_iso)
         .await
         .map_err(internal)?;
     Ok(())
 }
 
+/// Install a freshly generated setup token (replacing any printed on an
+/// earlier boot) and return the redemption URL.
+async fn install_setup_token(ctx: &dyn Context) -> Result<String, WaferError> {
+    let token = hex_encode(&crypto::random_bytes(ctx, 32).await?);
+    bootstrap_tokens::delete_all(ctx).await.map_err(internal)?;
+    bootstrap_with_token(ctx, &token).await?;
+    let base = super::helpers::expected_issuer(ctx).await;
+    Ok(format!(
+        "{}/b/auth/bootstrap?token={token}",
+        base.trim_end_matches('/')
+    ))
+}
+
 fn internal<E: std::fmt::Display>(e: E) -> WaferError {
     WaferError::new(ErrorCode::Internal, format!("auth bootstrap: {e}"))
 }
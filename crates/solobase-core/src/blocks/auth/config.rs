@@ -34,6 +34,11 @@ pub const BOOTSTRAP_ADMIN_PASSWORD_KEY: &str = "SOLOBASE_SHARED__AUTH__BOOTSTRAP
 /// the holder redeems it to create the first admin.
 pub const BOOTSTRAP_ADMIN_TOKEN_KEY: &str = "SOLOBASE_SHARED__AUTH__BOOTSTRAP_ADMIN_TOKEN";
 
+/// `SOLOBASE_SHARED__AUTH__BOOTSTRAP_ONCE` — when `true`, admin bootstrap
+/// runs at most once per database: after the first admin is provisioned, an
+/// emptied users table is not re-seeded from the bootstrap env vars.
+pub const BOOTSTRAP_ONCE_KEY: &str = "SOLOBASE_SHARED__AUTH__BOOTSTRAP_ONCE";
+
 /// `SOLOBASE_SHARED__AUTH__PASSWORD_MIN_LENGTH` — minimum password length
 /// enforced at signup. Existing accounts are not re-validated.
 pub const PASSWORD_MIN_LENGTH_KEY: &str = "SOLOBASE_SHARED__AUTH__PASSWORD_MIN_LENGTH";
@@ -52,6 +57,21 @@ pub const REQUIRE_VERIFICATION_KEY: &str = "SUPPERS_AI__AUTH__REQUIRE_VERIFICATI
 /// signup email domains. Empty (the default) allows any domain.
 pub const ALLOWED_EMAIL_DOMAINS_KEY: &str = "SUPPERS_AI__AUTH__ALLOWED_EMAIL_DOMAINS";
 
+/// `SUPPERS_AI__AUTH__SIGNUP_MODE` — who may create an account; see
+/// [`SignupMode`]. Read per request, so a change applies without a restart.
+pub const SIGNUP_MODE_KEY: &str = "SUPPERS_AI__AUTH__SIGNUP_MODE";
+
+/// `SUPPERS_AI__AUTH__LOGIN_ALERTS` — email users when their account is
+/// signed in to from a device it hasn't used before. Users can still opt out
+/// individually.
+pub const LOGIN_ALERTS_KEY: &str = "SUPPERS_AI__AUTH__LOGIN_ALERTS";
+
+/// `SUPPERS_AI__AUTH__API_KEY_MAX_LIFETIME_DAYS` — the longest a user may
+/// make an API key or S3 credential live. `0` (the default) allows keys that
+/// never expire; otherwise a key created without an expiry gets the maximum.
+/// Keys issued before a change keep their expiry.
+pub const API_KEY_MAX_LIFETIME_DAYS_KEY: &str = "SUPPERS_AI__AUTH__API_KEY_MAX_LIFETIME_DAYS";
+
 /// Default session lifetime when the config var is unset.
 pub const SESSION_LIFETIME_DAYS_DEFAULT: u32 = 30;
 
@@ -63,6 +83,47 @@ pub const PASSWORD_MIN_LENGTH_DEFAULT: u32 = 8;
 /// is stolen or a user logs out before the natural expiry.
 pub const ACCESS_TOKEN_LIFETIME_SECS_DEFAULT: u64 = 1800;
 
+/// How long an invitation stays redeemable when the admin does not say.
+pub const INVITATION_TTL_DAYS_DEFAULT: i64 = 7;
+
+/// Who may register (`SUPPERS_AI__AUTH__SIGNUP_MODE`). Independent of the
+/// mode, `SOLOBASE_SHARED__ALLOW_SIGNUP=false` still closes signup, and a
+/// non-empty `ALLOWED_EMAIL_DOMAINS` still restricts open signup.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum SignupMode {
+    /// Anyone may register.
+    Open,
+    /// Nobody may register.
+    Closed,
+    /// Only addresses in `ALLOWED_EMAIL_DOMAINS`; an empty list admits no one.
+    DomainAllowlist,
+    /// Only holders of an admin-issued invitation.
+    InviteOnly,
+}
+
+impl SignupMode {
+    /// Parse the config value. Unknown values are `None`; callers fail
+    /// closed on them.
+    pub fn parse(raw: &str) -> Option<Self> {
+        match raw.trim() {
+            "" | "open" => Some(Self::Open),
+            "closed" => Some(Self::Closed),
+            "domain-allowlist" => Some(Self::DomainAllowlist),
+            "invite-only" => Some(Self::InviteOnly),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Open => "open",
+            Self::Closed => "closed",
+            Self::DomainAllowlist => "domain-allowlist",
+            Self::InviteOnly => "invite-only",
+        }
+    }
+}
+
 /// Config vars contributed by the Plan A2 auth block additions.
 ///
 /// Appended to the existing legacy `config_keys` list; do not duplicate or
@@ -98,6 +159,13 @@ pub fn auth_config_vars() -> Vec<ConfigVar> {
         .name("Bootstrap Admin Token")
         .input_type(InputType::Password)
         .optional(),
+        ConfigVar::new(
+            BOOTSTRAP_ONCE_KEY,
+            "Provision the bootstrap admin at most once. When on, an emptied users table is not re-seeded from the bootstrap env vars.",
+            "false",
+        )
+        .name("Bootstrap Admin Only Once")
+        .input_type(InputType::Toggle),
         ConfigVar::new(
             PASSWORD_MIN_LENGTH_KEY,
             "Minimum password length enforced at signup. Existing accounts are not re-validated.",
@@ -137,6 +205,26 @@ pub fn auth_identity_config_vars() -> Vec<ConfigVar> {
         )
         .name("Allowed Email Domains")
         .input_type(InputType::Text),
+        ConfigVar::new(
+            SIGNUP_MODE_KEY,
+            "Who may create an account: \"open\", \"closed\", \"domain-allowlist\" (only the allowed email domains) or \"invite-only\" (only holders of an admin invitation).",
+            "open",
+        )
+        .name("Signup Mode")
+        .input_type(InputType::Text),
+        ConfigVar::new(
+            LOGIN_ALERTS_KEY,
+            "Email users when their account is signed in to from a new device, with a link to sign that device out.",
+            "true",
+        )
+        .name("New Sign-in Alerts")
+        .input_type(InputType::Toggle),
+        ConfigVar::new(
+            API_KEY_MAX_LIFETIME_DAYS_KEY,
+            "Longest a user-created API key or S3 credential may stay valid, in days. Keys created without an expiry get this lifetime. 0 allows keys that never expire.",
+            "0",
+        )
+        .name("API Key Maximum Lifetime (days)"),
     ]
 }
 
@@ -151,6 +239,7 @@ pub struct AuthConfig {
     pub bootstrap_admin_email: Option<String>,
     pub bootstrap_admin_password: Option<String>,
     pub bootstrap_admin_token: Option<String>,
+    pub bootstrap_once: bool,
 }
 
 impl AuthConfig {
@@ -168,6 +257,9 @@ impl AuthConfig {
             bootstrap_admin_email: non_empty(env.get(BOOTSTRAP_ADMIN_EMAIL_KEY)),
             bootstrap_admin_password: non_empty(env.get(BOOTSTRAP_ADMIN_PASSWORD_KEY)),
             bootstrap_admin_token: non_empty(env.get(BOOTSTRAP_ADMIN_TOKEN_KEY)),
+            bootstrap_once: env
+                .get(BOOTSTRAP_ONCE_KEY)
+                .is_some_and(|v| v == "true" || v == "1"),
         }
     }
 
@@ -183,6 +275,7 @@ impl AuthConfig {
             BOOTSTRAP_ADMIN_EMAIL_KEY,
             BOOTSTRAP_ADMIN_PASSWORD_KEY,
             BOOTSTRAP_ADMIN_TOKEN_KEY,
+            BOOTSTRAP_ONCE_KEY,
         ] {
             let val = config_client::get_default(ctx, key, "").await;
             if !val.is_empty() {
@@ -254,6 +347,14 @@ mod tests {
         assert!(cfg.bootstrap_admin_token.is_none());
     }
 
+    #[test]
+    fn bootstrap_once_defaults_off_and_parses_toggle() {
+        assert!(!AuthConfig::from_env_for_test(&[]).bootstrap_once);
+        assert!(AuthConfig::from_env_for_test(&[(BOOTSTRAP_ONCE_KEY, "true")]).bootstrap_once);
+        assert!(AuthConfig::from_env_for_test(&[(BOOTSTRAP_ONCE_KEY, "1")]).bootstrap_once);
+        assert!(!AuthConfig::from_env_for_test(&[(BOOTSTRAP_ONCE_KEY, "false")]).bootstrap_once);
+    }
+
     #[test]
     fn auth_config_vars_declares_all_four_keys() {
         let vars = auth_config_vars();
@@ -318,6 +419,20 @@ mod tests {
         );
     }
 
+    #[test]
+    fn signup_mode_parses_known_values_only() {
+        for mode in [
+            SignupMode::Open,
+            SignupMode::Closed,
+            SignupMode::DomainAllowlist,
+            SignupMode::InviteOnly,
+        ] {
+            assert_eq!(SignupMode::parse(mode.as_str()), Some(mode));
+        }
+        assert_eq!(SignupMode::parse(""), Some(SignupMode::Open));
+        assert_eq!(SignupMode::parse("invite"), None);
+    }
+
     #[test]
     fn password_min_length_var_defaults_to_eight() {
         let var = auth_config_vars()
@@ -0,0 +1,211 @@
+//! Device fingerprints for new-device sign-in detection.
+//!
+//! A device is its user agent plus the coarse network it signs in from (the
+//! /24 of an IPv4 address, the /48 of an IPv6 one), so a laptop hopping
+//! between DHCP leases stays one device while the same browser on a new
+//! network counts as new. Only the sha256 of the pair is stored, as
+//! `device_info` on refresh-token and session rows (migration 013); the
+//! readable label rides on the session row for the userportal device list.
+//!
+//! Location is whatever the edge reports: Cloudflare's `cf-ipcountry`, or
+//! `x-geo-city` / `x-geo-country` from a reverse proxy with a GeoIP
+//! database. Without those headers alerts simply carry no location.
+
+use std::net::{IpAddr, SocketAddr};
+
+use wafer_run::Message;
+
+use crate::util::sha256_hex;
+
+/// The device a login came from.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Device {
+    /// `sha256_hex` of the user agent and [`coarse_network`].
+    pub fingerprint: String,
+    /// "Firefox on Linux"; "Unknown device" without a user agent.
+    pub label: String,
+    /// See [`coarse_network`].
+    pub network: String,
+    /// "City, CC" (or just one of them) from edge geolocation headers.
+    pub location: Option<String>,
+}
+
+impl Device {
+    /// The device of an HTTP request.
+    pub fn from_message(msg: &Message) -> Self {
+        let location = join_location(
+            msg.get_meta("http.header.x-geo-city"),
+            [
+                msg.get_meta("http.header.cf-ipcountry"),
+                msg.get_meta("http.header.x-geo-country"),
+            ]
+            .into_iter()
+            .find(|c| !c.is_empty() && *c != "XX")
+            .unwrap_or(""),
+        );
+        Self::new(
+            msg.get_meta("http.header.user-agent"),
+            msg.remote_addr(),
+            location,
+        )
+    }
+
+    pub fn new(user_agent: &str, remote_addr: &str, location: Option<String>) -> Self {
+        let user_agent = user_agent.trim();
+        let network = coarse_network(remote_addr);
+        Self {
+            fingerprint: sha256_hex(format!("{user_agent}\n{network}").as_bytes()),
+            label: describe_user_agent(user_agent),
+            network,
+            location,
+        }
+    }
+
+    /// "Firefox on Linux · 203.0.113.0/24", as shown on the device list.
+    pub fn display(&self) -> String {
+        format!("{} · {}", self.label, self.network)
+    }
+}
+
+fn join_location(city: &str, country: &str) -> Option<String> {
+    match (city.trim(), country.trim()) {
+        ("", "") => None,
+        (city, "") => Some(city.to_string()),
+        ("", country) => Some(country.to_string()),
+        (city, country) => Some(format!("{city}, {country}")),
+    }
+}
+
+/// The client address in `remote_addr`, with IPv4-mapped IPv6 unwrapped to
+/// IPv4. Accepts a bare IP, `ip:port`, or a forwarded-for list (the first
+/// entry wins); `None` when missing or unparseable.
+pub fn client_ip(remote_addr: &str) -> Option<IpAddr> {
+    let first = remote_addr.split(',').next().unwrap_or("").trim();
+    let ip = first
+        .parse::<IpAddr>()
+        .ok()
+        .or_else(|| first.parse::<SocketAddr>().ok().map(|s| s.ip()));
+    match ip {
+        Some(IpAddr::V6(v6)) => Some(v6.to_ipv4_mapped().map_or(IpAddr::V6(v6), IpAddr::V4)),
+        other => other,
+    }
+}
+
+/// The network a client address belongs to: `a.b.c.0/24` for IPv4 (including
+/// IPv4-mapped IPv6), `x:y:z::/48` for IPv6, `unknown` when the address is
+/// missing or unparseable (see [`client_ip`]).
+pub fn coarse_network(remote_addr: &str) -> String {
+    match client_ip(remote_addr) {
+        Some(IpAddr::V4(v4)) => {
+            let [a, b, c, _] = v4.octets();
+            format!("{a}.{b}.{c}.0/24")
+        }
+        Some(IpAddr::V6(v6)) => {
+            let s = v6.segments();
+            format!("{:x}:{:x}:{:x}::/48", s[0], s[1], s[2])
+        }
+        None => "unknown".to_string(),
+    }
+}
+
+/// A short "browser on OS" label for a user agent. Order matters: Edge and
+/// Opera also claim Chrome, Chrome also claims Safari, Android also claims
+/// Linux and iOS also claims Mac OS X.
+pub fn describe_user_agent(ua: &str) -> String {
+    if ua.is_empty() {
+        return "Unknown device".to_string();
+    }
+    const BROWSERS: &[(&str, &str)] = &[
+        ("Edg/", "Edge"),
+        ("OPR/", "Opera"),
+        ("Firefox/", "Firefox"),
+        ("FxiOS/", "Firefox"),
+        ("Chrome/", "Chrome"),
+        ("CriOS/", "Chrome"),
+        ("Safari/", "Safari"),
+        ("curl/", "curl"),
+    ];
+    const SYSTEMS: &[(&str, &str)] = &[
+        ("Windows", "Windows"),
+        ("iPhone", "iOS"),
+        ("iPad", "iPadOS"),
+        ("Android", "Android"),
+        ("CrOS", "ChromeOS"),
+        ("Mac OS X", "macOS"),
+        ("Linux", "Linux"),
+    ];
+    let browser = BROWSERS
+        .iter()
+        .find(|(needle, _)| ua.contains(needle))
+        .map_or("Browser", |(_, name)| *name);
+    match SYSTEMS.iter().find(|(needle, _)| ua.contains(needle)) {
+        Some((_, os)) => format!("{browser} on {os}"),
+        None => browser.to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const FIREFOX_LINUX: &str =
+        "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0";
+    const CHROME_ANDROID: &str = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 \
+         (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36";
+    const SAFARI_IPHONE: &str = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) \
+         AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1";
+
+    #[test]
+    fn networks_are_coarse() {
+        assert_eq!(coarse_network("203.0.113.77"), "203.0.113.0/24");
+        assert_eq!(coarse_network("203.0.113.77:51234"), "203.0.113.0/24");
+        assert_eq!(coarse_network("198.51.100.9, 10.0.0.1"), "198.51.100.0/24");
+        assert_eq!(coarse_network("::ffff:203.0.113.5"), "203.0.113.0/24");
+        assert_eq!(coarse_network("2001:db8:abcd:12::1"), "2001:db8:abcd::/48");
+        assert_eq!(coarse_network("[2001:db8::1]:443"), "2001:db8:0::/48");
+        assert_eq!(coarse_network(""), "unknown");
+        assert_eq!(coarse_network("unknown"), "unknown");
+    }
+
+    #[test]
+    fn user_agents_get_readable_labels() {
+        assert_eq!(describe_user_agent(FIREFOX_LINUX), "Firefox on Linux");
+        assert_eq!(describe_user_agent(CHROME_ANDROID), "Chrome on Android");
+        assert_eq!(describe_user_agent(SAFARI_IPHONE), "Safari on iOS");
+        assert_eq!(describe_user_agent("curl/8.5.0"), "curl");
+        assert_eq!(describe_user_agent(""), "Unknown device");
+    }
+
+    #[test]
+    fn fingerprint_follows_agent_and_network_not_host() {
+        let home = Device::new(FIREFOX_LINUX, "203.0.113.10", None);
+        assert_eq!(
+            home.fingerprint,
+            Device::new(FIREFOX_LINUX, "203.0.113.200", None).fingerprint
+        );
+        assert_ne!(
+            home.fingerprint,
+            Device::new(FIREFOX_LINUX, "198.51.100.10", None).fingerprint
+        );
+        assert_ne!(
+            home.fingerprint,
+            Device::new(CHROME_ANDROID, "203.0.113.10", None).fingerprint
+        );
+        assert_eq!(home.display(), "Firefox on Linux · 203.0.113.0/24");
+    }
+
+    #[test]
+    fn location_comes_from_edge_headers() {
+        let mut msg = Message::new("http.request");
+        assert_eq!(Device::from_message(&msg).location, None);
+        msg.set_meta("http.header.cf-ipcountry", "XX");
+        assert_eq!(Device::from_message(&msg).location, None);
+        msg.set_meta("http.header.cf-ipcountry", "NL");
+        assert_eq!(Device::from_message(&msg).location.as_deref(), Some("NL"));
+        msg.set_meta("http.header.x-geo-city", "Utrecht");
+        assert_eq!(
+            Device::from_message(&msg).location.as_deref(),
+            Some("Utrecht, NL")
+        );
+    }
+}
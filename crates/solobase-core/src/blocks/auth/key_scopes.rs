@@ -0,0 +1,335 @@
+//! What a narrowed API key may reach, and where it may be used from.
+//!
+//! A key's `scopes` (auth migration 018) are permission names from the
+//! route-permission vocabulary (see [`crate::blocks::permissions`]),
+//! `{resource}.*` wildcards included, plus [`STORAGE_READ`]. A key without
+//! scopes can do whatever its owner can. One with scopes reaches only:
+//!
+//! - routes whose permission one of its scopes holds;
+//! - storage routes without a permission of their own: reads with
+//!   `storage.read` or `storage.write`, anything else with `storage.write`.
+//!
+//! Everything else — the account's profile, key management, extension
+//! routes — answers 403. A key's `bucket` further confines it to paths
+//! naming that bucket, and its `allowed_ips` to requests from those ranges.
+//!
+//! The owner's roles still apply on top, as for any request, and a key can
+//! only be minted with scopes its owner holds ([`may_grant`]), so a key is
+//! never more powerful than its owner. Keys are minted from a session only:
+//! a request authenticated by a key ([`META_KEY_ID`]) may not create
+//! another, which would otherwise shed its restrictions. Nothing here is cached: the key row
+//! is read on every request, so a revocation takes effect on the next one.
+
+use std::net::IpAddr;
+
+use wafer_run::{Message, OutputStream};
+
+use super::{devices, repo::api_keys::ApiKeyRow};
+use crate::blocks::{
+    errors::{error_json, ErrorCode},
+    permissions,
+};
+
+/// Id of the key that authenticated the request; unset for every other
+/// credential.
+pub const META_KEY_ID: &str = "auth.key_id";
+
+/// Comma-separated scopes of the key that authenticated the request; unset
+/// for unscoped keys and every other credential.
+pub const META_KEY_SCOPES: &str = "auth.key_scopes";
+
+/// The bucket the authenticating key is confined to; unset for any.
+pub const META_KEY_BUCKET: &str = "auth.key_bucket";
+
+/// Read the owner's buckets and objects. Not a route permission: every
+/// signed-in user may read their own storage, so every user may grant it.
+pub const STORAGE_READ: &str = "storage.read";
+
+/// The route permission for storage writes; as a scope it also reads.
+const STORAGE_WRITE: &str = "storage.write";
+
+/// The block serving `/b/storage`, `/b/cloudstorage`, `/s3` and `/sites`.
+const STORAGE_BLOCK: &str = "suppers-ai/files";
+
+/// Most scopes one key may carry.
+pub const MAX_SCOPES: usize = 16;
+
+/// Most address ranges one key may be limited to.
+pub const MAX_ALLOWED_IPS: usize = 32;
+
+/// Whether `scope` is a well-formed key scope: a `{resource}.{action}`
+/// permission name or a `{resource}.*` wildcard.
+pub fn is_valid_scope(scope: &str) -> bool {
+    match scope.strip_suffix(".*") {
+        Some(resource) => permissions::is_valid_name(&format!("{resource}.any")),
+        None => permissions::is_valid_name(scope),
+    }
+}
+
+/// Whether an owner holding `held` (see [`permissions::held_by`]) may
+/// mint a key with `scope`. Admins pass `["*"]`.
+pub fn may_grant(held: &[String], scope: &str) -> bool {
+    scope == STORAGE_READ || permissions::holds(held, scope)
+}
+
+/// Set the request meta [`confine`] reads for an authenticated `key`.
+pub fn stamp(msg: &mut Message, key: &ApiKeyRow) {
+    msg.set_meta(META_KEY_ID, &key.id);
+    if !key.scopes.is_empty() {
+        msg.set_meta(META_KEY_SCOPES, &key.scopes.join(","));
+    }
+    if !key.bucket.is_empty() {
+        msg.set_meta(META_KEY_BUCKET, &key.bucket);
+    }
+}
+
+/// The scope a request to `block` needs: the route's `permission`, or for
+/// an unannotated storage route [`STORAGE_READ`] / `storage.write` by
+/// action. `None` when no scope reaches the route.
+fn needed<'a>(block: &str, action: &str, permission: Option<&'a str>) -> Option<&'a str> {
+    match permission {
+        Some(p) => Some(p),
+        None if block != STORAGE_BLOCK => None,
+        None if action == "retrieve" => Some(STORAGE_READ),
+        None => Some(STORAGE_WRITE),
+    }
+}
+
+/// Whether `scopes` cover `needed`; `storage.write` also reads.
+fn allows(scopes: &[String], needed: &str) -> bool {
+    permissions::holds(scopes, needed)
+        || (needed == STORAGE_READ && permissions::holds(scopes, STORAGE_WRITE))
+}
+
+/// The bucket a storage path names: `/b/storage/api/buckets/{bucket}…` or
+/// `/s3/{bucket}…`.
+fn bucket_in_path(path: &str) -> Option<&str> {
+    let rest = path
+        .strip_prefix("/b/storage/api/buckets/")
+        .or_else(|| path.strip_prefix("/s3/"))?;
+    rest.split('/').next().filter(|b| !b.is_empty())
+}
+
+/// `None` when the key that authenticated `msg` (if any) reaches a route
+/// of `block` requiring `permission`; otherwise the 403 to answer with.
+/// Called by the router after the owner's own access checks.
+pub fn confine(msg: &Message, block: &str, permission: Option<&str>) -> Option<OutputStream> {
+    let scopes: Vec<String> = msg
+        .get_meta(META_KEY_SCOPES)
+        .split(',')
+        .filter(|s| !s.is_empty())
+        .map(str::to_string)
+        .collect();
+    if !scopes.is_empty() {
+        match needed(block, msg.action(), permission) {
+            Some(p) if allows(&scopes, p) => {}
+            Some(p) => return Some(crate::ui::permission_denied_response(msg, p)),
+            None => {
+                return Some(error_json(
+                    ErrorCode::Forbidden,
+                    "This API key's scopes don't cover this route",
+                    Some(serde_json::json!({ "scopes": scopes })),
+                ))
+            }
+        }
+    }
+    let bucket = msg.get_meta(META_KEY_BUCKET);
+    if !bucket.is_empty() && (block != STORAGE_BLOCK || bucket_in_path(msg.path()) != Some(bucket))
+    {
+        return Some(error_json(
+            ErrorCode::Forbidden,
+            &format!("This API key is limited to bucket {bucket}"),
+            Some(serde_json::json!({ "bucket": bucket })),
+        ));
+    }
+    None
+}
+
+// ---------------------------------------------------------------------------
+// Address ranges
+// ---------------------------------------------------------------------------
+
+/// An address range in CIDR notation; a bare address is a range of one.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Cidr {
+    network: IpAddr,
+    prefix: u8,
+}
+
+impl Cidr {
+    /// Parse `10.0.0.0/8`, `2001:db8::/32` or a bare address.
+    pub fn parse(s: &str) -> Option<Self> {
+        let s = s.trim();
+        let (addr, prefix) = match s.split_once('/') {
+            Some((addr, prefix)) => (addr, Some(prefix)),
+            None => (s, None),
+        };
+        let network: IpAddr = addr.parse().ok()?;
+        let max = if network.is_ipv4() { 32 } else { 128 };
+        let prefix = match prefix {
+            Some(p) => p.parse::<u8>().ok().filter(|p| *p <= max)?,
+            None => max,
+        };
+        Some(Self { network, prefix })
+    }
+
+    /// Whether `ip` falls in the range. IPv4 never matches an IPv6 range.
+    pub fn contains(&self, ip: IpAddr) -> bool {
+        let (net, addr, bits) = match (self.network, ip) {
+            (IpAddr::V4(n), IpAddr::V4(a)) => {
+                (u128::from(u32::from(n)), u128::from(u32::from(a)), 32)
+            }
+            (IpAddr::V6(n), IpAddr::V6(a)) => (u128::from(n), u128::from(a), 128),
+            _ => return false,
+        };
+        // A /0 shifts the whole width out, which `checked_shr` refuses.
+        let shift = bits - u32::from(self.prefix);
+        net.checked_shr(shift).unwrap_or(0) == addr.checked_shr(shift).unwrap_or(0)
+    }
+}
+
+/// Whether a key limited to `allowed` is accepted from `remote_addr`. An
+/// empty list accepts any address; an unknown client address matches none.
+pub fn ip_allowed(allowed: &[String], remote_addr: &str) -> bool {
+    if allowed.is_empty() {
+        return true;
+    }
+    let Some(ip) = devices::client_ip(remote_addr) else {
+        return false;
+    };
+    allowed
+        .iter()
+        .filter_map(|range| Cidr::parse(range))
+        .any(|range| range.contains(ip))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::auth_msg;
+
+    fn list(items: &[&str]) -> Vec<String> {
+        items.iter().map(|s| s.to_string()).collect()
+    }
+
+    fn scoped(action: &str, path: &str, scopes: &str, bucket: &str) -> Message {
+        let mut msg = auth_msg(action, path, "user-1");
+        if !scopes.is_empty() {
+            msg.set_meta(META_KEY_SCOPES, scopes);
+        }
+        if !bucket.is_empty() {
+            msg.set_meta(META_KEY_BUCKET, bucket);
+        }
+        msg
+    }
+
+    #[test]
+    fn scopes_are_permission_names_or_wildcards() {
+        assert!(is_valid_scope("storage.read"));
+        assert!(is_valid_scope("storage.*"));
+        assert!(!is_valid_scope("*"));
+        assert!(!is_valid_scope(".*"));
+        assert!(!is_valid_scope("Storage.Read"));
+    }
+
+    #[test]
+    fn owners_grant_only_what_they_hold() {
+        let user = list(&["storage.write"]);
+        assert!(may_grant(&user, STORAGE_READ));
+        assert!(may_grant(&user, "storage.write"));
+        assert!(!may_grant(&user, "storage.*"));
+        assert!(!may_grant(&user, "logs.read"));
+        assert!(may_grant(&list(&["*"]), "logs.read"));
+        assert!(may_grant(&[], STORAGE_READ));
+    }
+
+    #[test]
+    fn unscoped_requests_pass() {
+        let msg = scoped("retrieve", "/b/auth/api/me", "", "");
+        assert!(confine(&msg, "suppers-ai/auth-ui", None).is_none());
+    }
+
+    #[test]
+    fn read_scope_reads_storage_and_nothing_else() {
+        let get = scoped(
+            "retrieve",
+            "/b/storage/api/buckets/docs/objects",
+            "storage.read",
+            "",
+        );
+        assert!(confine(&get, STORAGE_BLOCK, None).is_none());
+        let post = scoped(
+            "create",
+            "/b/storage/api/buckets/docs/objects",
+            "storage.read",
+            "",
+        );
+        assert!(confine(&post, STORAGE_BLOCK, Some("storage.write")).is_some());
+        let acl = scoped(
+            "create",
+            "/b/storage/api/buckets/docs/acl",
+            "storage.read",
+            "",
+        );
+        assert!(confine(&acl, STORAGE_BLOCK, None).is_some());
+        let me = scoped("retrieve", "/b/auth/api/me", "storage.read", "");
+        assert!(confine(&me, "suppers-ai/auth-ui", None).is_some());
+    }
+
+    #[test]
+    fn write_scope_also_reads_and_permissions_match_routes() {
+        let get = scoped("retrieve", "/b/storage/api/buckets", "storage.write", "");
+        assert!(confine(&get, STORAGE_BLOCK, None).is_none());
+        let logs = scoped("retrieve", "/b/admin/api/logs", "logs.read", "");
+        assert!(confine(&logs, "suppers-ai/admin", Some("logs.read")).is_none());
+        assert!(confine(&logs, "suppers-ai/admin", Some("users.manage")).is_some());
+    }
+
+    #[test]
+    fn bucket_confines_to_paths_naming_it() {
+        let inside = scoped(
+            "retrieve",
+            "/b/storage/api/buckets/docs/objects/a.txt",
+            "",
+            "docs",
+        );
+        assert!(confine(&inside, STORAGE_BLOCK, None).is_none());
+        let s3 = scoped("retrieve", "/s3/docs/a.txt", "", "docs");
+        assert!(confine(&s3, STORAGE_BLOCK, None).is_none());
+        let other = scoped(
+            "retrieve",
+            "/b/storage/api/buckets/docs2/objects",
+            "",
+            "docs",
+        );
+        assert!(confine(&other, STORAGE_BLOCK, None).is_some());
+        let listing = scoped("retrieve", "/b/storage/api/buckets", "", "docs");
+        assert!(confine(&listing, STORAGE_BLOCK, None).is_some());
+    }
+
+    #[test]
+    fn cidr_ranges_match_their_addresses() {
+        let range = Cidr::parse("10.1.0.0/16").unwrap();
+        assert!(range.contains("10.1.200.3".parse().unwrap()));
+        assert!(!range.contains("10.2.0.1".parse().unwrap()));
+        assert!(!range.contains("::1".parse().unwrap()));
+        let v6 = Cidr::parse("2001:db8::/32").unwrap();
+        assert!(v6.contains("2001:db8:1::5".parse().unwrap()));
+        assert!(Cidr::parse("0.0.0.0/0")
+            .unwrap()
+            .contains("203.0.113.9".parse().unwrap()));
+        assert!(Cidr::parse("10.0.0.0/33").is_none());
+        assert!(Cidr::parse("example.com").is_none());
+    }
+
+    #[test]
+    fn allowed_ips_check_the_client_address() {
+        assert!(ip_allowed(&[], ""));
+        let office = list(&["203.0.113.0/24", "198.51.100.7"]);
+        assert!(ip_allowed(&office, "203.0.113.40:5123"));
+        assert!(ip_allowed(&office, "198.51.100.7, 10.0.0.1"));
+        assert!(ip_allowed(&office, "::ffff:203.0.113.1"));
+        assert!(!ip_allowed(&office, "198.51.100.8"));
+        assert!(!ip_allowed(&office, ""));
+    }
+}
@@ -0,0 +1,19 @@
+-- Admin-bootstrap hardening.
+--
+-- `must_change_password` forces the env-bootstrapped admin through the
+-- change-password flow before any other route is reachable; cleared on a
+-- successful change or reset.
+--
+-- `bootstrap_state` records that the first admin was provisioned, so
+-- `SOLOBASE_SHARED__AUTH__BOOTSTRAP_ONCE` can refuse to re-create an admin
+-- from env vars after the users table is emptied.
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS suppers_ai__auth__bootstrap_state (
+    id TEXT PRIMARY KEY,
+    admin_user_id TEXT NOT NULL,
+    method TEXT NOT NULL,
+    completed_at TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
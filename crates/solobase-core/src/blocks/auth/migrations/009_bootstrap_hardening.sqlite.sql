@@ -0,0 +1,22 @@
+-- Admin-bootstrap hardening.
+--
+-- `must_change_password` forces the env-bootstrapped admin through the
+-- change-password flow before any other route is reachable; cleared on a
+-- successful change or reset.
+--
+-- `bootstrap_state` records that the first admin was provisioned, so
+-- `SOLOBASE_SHARED__AUTH__BOOTSTRAP_ONCE` can refuse to re-create an admin
+-- from env vars after the users table is emptied.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__auth__users ADD COLUMN must_change_password INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS suppers_ai__auth__bootstrap_state (
+    id TEXT PRIMARY KEY,
+    admin_user_id TEXT NOT NULL,
+    method TEXT NOT NULL,
+    completed_at TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
@@ -0,0 +1,10 @@
+-- Read-only directory of live accounts (`id`, `email`) for other blocks.
+--
+-- Blocks that only need to resolve an address to a user id (folder sharing
+-- in `suppers-ai/files`) are granted read on this view instead of the full
+-- users table, so they never see password/verification columns, roles, or
+-- disabled and soft-deleted accounts.
+CREATE VIEW IF NOT EXISTS suppers_ai__auth__users_directory AS
+SELECT id, email
+FROM suppers_ai__auth__users
+WHERE disabled = 0 AND (deleted_at IS NULL OR deleted_at = '');
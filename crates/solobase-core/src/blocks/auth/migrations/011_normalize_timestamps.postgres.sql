@@ -0,0 +1,202 @@
+-- Mirror of the SQLite migration for PostgreSQL: rewrite timestamp columns
+-- to the `crate::util::format_rfc3339` layout (`2026-07-11T19:13:45.123456Z`).
+--
+-- Values with an offset are cast through TIMESTAMPTZ; offset-less values are
+-- read as UTC (not the session time zone). Only rows that look like a
+-- timestamp are touched, so empty and malformed values stay as stored.
+
+UPDATE suppers_ai__auth__users SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__users SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__users SET last_verification_sent = to_char(
+        (CASE WHEN last_verification_sent ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN last_verification_sent::timestamptz
+              ELSE last_verification_sent::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE last_verification_sent ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND last_verification_sent !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__users SET last_login_at = to_char(
+        (CASE WHEN last_login_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN last_login_at::timestamptz
+              ELSE last_login_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE last_login_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND last_login_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__users SET deleted_at = to_char(
+        (CASE WHEN deleted_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN deleted_at::timestamptz
+              ELSE deleted_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE deleted_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND deleted_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__local_credentials SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__provider_links SET linked_at = to_char(
+        (CASE WHEN linked_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN linked_at::timestamptz
+              ELSE linked_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE linked_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND linked_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__orgs SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__sessions SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__sessions SET last_used_at = to_char(
+        (CASE WHEN last_used_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN last_used_at::timestamptz
+              ELSE last_used_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE last_used_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND last_used_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__sessions SET expires_at = to_char(
+        (CASE WHEN expires_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN expires_at::timestamptz
+              ELSE expires_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE expires_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND expires_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__personal_access_tokens SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__personal_access_tokens SET last_used_at = to_char(
+        (CASE WHEN last_used_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN last_used_at::timestamptz
+              ELSE last_used_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE last_used_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND last_used_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__personal_access_tokens SET expires_at = to_char(
+        (CASE WHEN expires_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN expires_at::timestamptz
+              ELSE expires_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE expires_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND expires_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__bootstrap_tokens SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__bootstrap_tokens SET expires_at = to_char(
+        (CASE WHEN expires_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN expires_at::timestamptz
+              ELSE expires_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE expires_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND expires_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__oauth_pkce_states SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__oauth_pkce_states SET expires_at = to_char(
+        (CASE WHEN expires_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN expires_at::timestamptz
+              ELSE expires_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE expires_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND expires_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__tokens SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__tokens SET expires_at = to_char(
+        (CASE WHEN expires_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN expires_at::timestamptz
+              ELSE expires_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE expires_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND expires_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__jwt_blocklist SET revoked_at = to_char(
+        (CASE WHEN revoked_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN revoked_at::timestamptz
+              ELSE revoked_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE revoked_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND revoked_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__jwt_blocklist SET expires_at = to_char(
+        (CASE WHEN expires_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN expires_at::timestamptz
+              ELSE expires_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE expires_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND expires_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__api_keys SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__api_keys SET expires_at = to_char(
+        (CASE WHEN expires_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN expires_at::timestamptz
+              ELSE expires_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE expires_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND expires_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__api_keys SET revoked_at = to_char(
+        (CASE WHEN revoked_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN revoked_at::timestamptz
+              ELSE revoked_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE revoked_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND revoked_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__bootstrap_state SET completed_at = to_char(
+        (CASE WHEN completed_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN completed_at::timestamptz
+              ELSE completed_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE completed_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND completed_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__bootstrap_state SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__auth__bootstrap_state SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
@@ -0,0 +1,123 @@
+-- Normalize stored timestamps to the single layout `crate::util::format_rfc3339`
+-- writes: UTC, microsecond precision, literal `Z`
+-- (`2026-07-11T19:13:45.123456Z`). Older rows mix `…Z` seconds,
+-- `to_rfc3339()`'s `+00:00` nanoseconds and SQL `CURRENT_TIMESTAMP`'s
+-- `2026-07-11 19:13:45`, which breaks the string comparisons the cleanup and
+-- expiry queries rely on.
+--
+-- SQLite's `strftime` converts any offset to UTC but only keeps milliseconds,
+-- so rewritten rows are padded to six digits. Values `strftime` can't read
+-- are left as stored — `crate::util::parse_timestamp` still reads most of
+-- them. Already-canonical rows are skipped, so re-runs are no-ops.
+
+UPDATE suppers_ai__auth__users SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__users SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__users SET last_verification_sent = strftime('%Y-%m-%dT%H:%M:%f', last_verification_sent) || '000Z'
+    WHERE last_verification_sent NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', last_verification_sent) IS NOT NULL;
+
+UPDATE suppers_ai__auth__users SET last_login_at = strftime('%Y-%m-%dT%H:%M:%f', last_login_at) || '000Z'
+    WHERE last_login_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', last_login_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__users SET deleted_at = strftime('%Y-%m-%dT%H:%M:%f', deleted_at) || '000Z'
+    WHERE deleted_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', deleted_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__local_credentials SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__provider_links SET linked_at = strftime('%Y-%m-%dT%H:%M:%f', linked_at) || '000Z'
+    WHERE linked_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', linked_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__orgs SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__sessions SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__sessions SET last_used_at = strftime('%Y-%m-%dT%H:%M:%f', last_used_at) || '000Z'
+    WHERE last_used_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', last_used_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__sessions SET expires_at = strftime('%Y-%m-%dT%H:%M:%f', expires_at) || '000Z'
+    WHERE expires_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', expires_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__personal_access_tokens SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__personal_access_tokens SET last_used_at = strftime('%Y-%m-%dT%H:%M:%f', last_used_at) || '000Z'
+    WHERE last_used_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', last_used_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__personal_access_tokens SET expires_at = strftime('%Y-%m-%dT%H:%M:%f', expires_at) || '000Z'
+    WHERE expires_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', expires_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__bootstrap_tokens SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__bootstrap_tokens SET expires_at = strftime('%Y-%m-%dT%H:%M:%f', expires_at) || '000Z'
+    WHERE expires_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', expires_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__oauth_pkce_states SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__oauth_pkce_states SET expires_at = strftime('%Y-%m-%dT%H:%M:%f', expires_at) || '000Z'
+    WHERE expires_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', expires_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__tokens SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__tokens SET expires_at = strftime('%Y-%m-%dT%H:%M:%f', expires_at) || '000Z'
+    WHERE expires_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', expires_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__jwt_blocklist SET revoked_at = strftime('%Y-%m-%dT%H:%M:%f', revoked_at) || '000Z'
+    WHERE revoked_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', revoked_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__jwt_blocklist SET expires_at = strftime('%Y-%m-%dT%H:%M:%f', expires_at) || '000Z'
+    WHERE expires_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', expires_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__api_keys SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__api_keys SET expires_at = strftime('%Y-%m-%dT%H:%M:%f', expires_at) || '000Z'
+    WHERE expires_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', expires_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__api_keys SET revoked_at = strftime('%Y-%m-%dT%H:%M:%f', revoked_at) || '000Z'
+    WHERE revoked_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', revoked_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__bootstrap_state SET completed_at = strftime('%Y-%m-%dT%H:%M:%f', completed_at) || '000Z'
+    WHERE completed_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', completed_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__bootstrap_state SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__auth__bootstrap_state SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
@@ -0,0 +1,22 @@
+-- Signup invitations. An admin invites an email address; the invitee
+-- registers with the single-use token (only `token_hash`, the sha256 hex of
+-- it, is stored). `role` is the role the account is created with.
+--
+-- `status` moves `pending` → `accepted` (consumed by a signup) or
+-- `revoked`. A pending row past `expires_at` is expired; nothing rewrites
+-- its status.
+CREATE TABLE IF NOT EXISTS suppers_ai__auth__invitations (
+    id          TEXT PRIMARY KEY,
+    email       TEXT NOT NULL,
+    token_hash  TEXT NOT NULL UNIQUE,
+    role        TEXT NOT NULL DEFAULT 'user',
+    status      TEXT NOT NULL DEFAULT 'pending',
+    invited_by  TEXT NOT NULL DEFAULT '',
+    accepted_by TEXT NOT NULL DEFAULT '',
+    accepted_at TEXT,
+    expires_at  TEXT NOT NULL,
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__auth__invitations_status_idx
+    ON suppers_ai__auth__invitations (status, created_at);
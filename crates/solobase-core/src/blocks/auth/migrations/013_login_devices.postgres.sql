@@ -0,0 +1,18 @@
+-- New-device sign-in alerts.
+--
+-- `device_info` on refresh tokens and sessions is the device fingerprint:
+-- the sha256 hex of the user agent plus the coarse client network (a /24
+-- for IPv4, a /48 for IPv6). A login whose fingerprint matches none of the
+-- user's token rows is a first-seen device. Sessions also keep a readable
+-- `device_label` and the refresh `family` they were issued with, so the
+-- userportal device list can show the device and revoking it signs it out
+-- for good.
+--
+-- `mute_login_alerts` is the per-user opt-out for new sign-in emails.
+ALTER TABLE suppers_ai__auth__tokens ADD COLUMN IF NOT EXISTS device_info TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__sessions ADD COLUMN IF NOT EXISTS device_info TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__sessions ADD COLUMN IF NOT EXISTS device_label TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__sessions ADD COLUMN IF NOT EXISTS family TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS mute_login_alerts BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS suppers_ai__auth__tokens_user_device_idx
+    ON suppers_ai__auth__tokens (user_id, device_info);
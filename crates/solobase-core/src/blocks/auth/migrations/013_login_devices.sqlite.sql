@@ -0,0 +1,21 @@
+-- New-device sign-in alerts.
+--
+-- `device_info` on refresh tokens and sessions is the device fingerprint:
+-- the sha256 hex of the user agent plus the coarse client network (a /24
+-- for IPv4, a /48 for IPv6). A login whose fingerprint matches none of the
+-- user's token rows is a first-seen device. Sessions also keep a readable
+-- `device_label` and the refresh `family` they were issued with, so the
+-- userportal device list can show the device and revoking it signs it out
+-- for good.
+--
+-- `mute_login_alerts` is the per-user opt-out for new sign-in emails.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__auth__tokens ADD COLUMN device_info TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__sessions ADD COLUMN device_info TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__sessions ADD COLUMN device_label TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__sessions ADD COLUMN family TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN mute_login_alerts INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS suppers_ai__auth__tokens_user_device_idx
+    ON suppers_ai__auth__tokens (user_id, device_info);
@@ -0,0 +1,20 @@
+-- Self-service profile fields and the email-change confirmation flow.
+--
+-- `metadata` is a JSON object; the part under its "user" key is
+-- client-writable through PATCH /b/auth/api/profile, the rest is reserved
+-- for the server.
+--
+-- A requested email change waits in `pending_email` until the link sent
+-- there is opened. `email_change_token` stores the sha256 hex of that
+-- link's token, never the raw value; `email_change_expires` is its
+-- absolute expiry. The current `email` stays in use until then.
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS first_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS last_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS phone TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS location TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS metadata TEXT NOT NULL DEFAULT '{}';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS pending_email TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS email_change_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS email_change_expires TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS suppers_ai__auth__users_email_change_token_idx
+    ON suppers_ai__auth__users (email_change_token);
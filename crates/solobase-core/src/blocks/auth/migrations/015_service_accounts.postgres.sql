@@ -0,0 +1,10 @@
+-- Mirror of 015_service_accounts.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN IF NOT EXISTS rate_limits TEXT NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS suppers_ai__auth__users_kind_idx
+    ON suppers_ai__auth__users (kind);
+
+CREATE OR REPLACE VIEW suppers_ai__auth__users_directory AS
+SELECT id, email
+FROM suppers_ai__auth__users
+WHERE disabled = FALSE AND (deleted_at IS NULL OR deleted_at = '') AND kind = 'user';
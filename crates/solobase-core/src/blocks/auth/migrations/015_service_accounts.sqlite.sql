@@ -0,0 +1,29 @@
+-- Service accounts: non-interactive principals for CI pipelines and
+-- backend services.
+--
+-- A service account is a row in `suppers_ai__auth__users` with `kind =
+-- 'service_account'` (humans keep the default `'user'`). It is created by
+-- `POST /b/admin/api/service-accounts` with an id prefixed `sa_`, a
+-- placeholder `@service-accounts.invalid` address that can't receive mail,
+-- `email_verified = 1` and no local credentials, and authenticates only
+-- with the API keys issued there. Role assignment works as for any user.
+--
+-- `rate_limits` is a JSON object of per-category overrides in the
+-- `RATE_LIMIT_*` format (`{"api_read": "1000/60"}`), applied to requests
+-- made with the account's keys. Always `'{}'` for humans.
+--
+-- The users directory view drops service accounts: they aren't people to
+-- share with or email.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__auth__users ADD COLUMN kind TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE suppers_ai__auth__users ADD COLUMN rate_limits TEXT NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS suppers_ai__auth__users_kind_idx
+    ON suppers_ai__auth__users (kind);
+
+DROP VIEW IF EXISTS suppers_ai__auth__users_directory;
+CREATE VIEW IF NOT EXISTS suppers_ai__auth__users_directory AS
+SELECT id, email
+FROM suppers_ai__auth__users
+WHERE disabled = 0 AND (deleted_at IS NULL OR deleted_at = '') AND kind = 'user';
@@ -0,0 +1,3 @@
+-- Mirror of 016_s3_credentials.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'api';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN IF NOT EXISTS secret TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,14 @@
+-- S3 credentials: API keys of `kind = 's3'` that sign requests to the
+-- storage gateway (`/s3/`) with AWS Signature Version 4.
+--
+-- SigV4 verification recomputes the client's signature, so it needs the
+-- secret access key itself — a hash can't be checked against. An S3 key
+-- therefore keeps its secret in `secret`, as inbound webhooks keep theirs.
+-- Its `key_hash` is the SHA-256 of the (public) access key id, and
+-- `key_prefix` holds the access key id in full. Ordinary `sb_…` keys
+-- (`kind = 'api'`) leave `secret` empty.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN kind TEXT NOT NULL DEFAULT 'api';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN secret TEXT NOT NULL DEFAULT '';
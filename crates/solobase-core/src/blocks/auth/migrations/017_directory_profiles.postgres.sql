@@ -0,0 +1,6 @@
+-- Mirror of 017_directory_profiles.sqlite.sql for PostgreSQL.
+DROP VIEW IF EXISTS suppers_ai__auth__users_directory;
+CREATE VIEW suppers_ai__auth__users_directory AS
+SELECT id, email, display_name, avatar_url
+FROM suppers_ai__auth__users
+WHERE disabled = FALSE AND (deleted_at IS NULL OR deleted_at = '') AND kind = 'user';
@@ -0,0 +1,9 @@
+-- The users directory also carries each account's public profile —
+-- `display_name` and `avatar_url` — so blocks that show who someone is
+-- (share dialogs, object history) can name them without a grant on the
+-- users table. See `crate::blocks::directory`.
+DROP VIEW IF EXISTS suppers_ai__auth__users_directory;
+CREATE VIEW IF NOT EXISTS suppers_ai__auth__users_directory AS
+SELECT id, email, display_name, avatar_url
+FROM suppers_ai__auth__users
+WHERE disabled = 0 AND (deleted_at IS NULL OR deleted_at = '') AND kind = 'user';
@@ -0,0 +1,7 @@
+-- Mirror of 018_api_key_scopes.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN IF NOT EXISTS scopes TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN IF NOT EXISTS bucket TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN IF NOT EXISTS allowed_ips TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN IF NOT EXISTS last_used_at TEXT;
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN IF NOT EXISTS last_used_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN IF NOT EXISTS use_count INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,20 @@
+-- Self-service API keys: what a key may reach, where it may be used from,
+-- and when it was last used.
+--
+-- * `scopes` — JSON array of permission names the key is confined to
+--   (`storage.read`, `storage.write`, …). Empty keeps the key as powerful
+--   as its owner, which is what every key issued before this was.
+-- * `bucket` — confines the key to one storage bucket; empty for any.
+-- * `allowed_ips` — JSON array of CIDR ranges (or bare addresses) the key
+--   is accepted from; empty for anywhere.
+-- * `last_used_at` / `last_used_ip` / `use_count` — stamped on every
+--   request the key authenticates.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN scopes TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN bucket TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN allowed_ips TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN last_used_at TEXT;
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN last_used_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__auth__api_keys ADD COLUMN use_count INTEGER NOT NULL DEFAULT 0;
@@ -23,6 +23,26 @@ const SQL_007_SQLITE: &str = include_str!("007_api_keys.sqlite.sql");
 const SQL_007_POSTGRES: &str = include_str!("007_api_keys.postgres.sql");
 const SQL_008_SQLITE: &str = include_str!("008_rate_limits.sqlite.sql");
 const SQL_008_POSTGRES: &str = include_str!("008_rate_limits.postgres.sql");
+const SQL_009_SQLITE: &str = include_str!("009_bootstrap_hardening.sqlite.sql");
+const SQL_009_POSTGRES: &str = include_str!("009_bootstrap_hardening.postgres.sql");
+const SQL_010_SQLITE: &str = include_str!("010_users_directory.sqlite.sql");
+const SQL_010_POSTGRES: &str = include_str!("010_users_directory.postgres.sql");
+const SQL_011_SQLITE: &str = include_str!("011_normalize_timestamps.sqlite.sql");
+const SQL_011_POSTGRES: &str = include_str!("011_normalize_timestamps.postgres.sql");
+const SQL_012_SQLITE: &str = include_str!("012_invitations.sqlite.sql");
+const SQL_012_POSTGRES: &str = include_str!("012_invitations.postgres.sql");
+const SQL_013_SQLITE: &str = include_str!("013_login_devices.sqlite.sql");
+const SQL_013_POSTGRES: &str = include_str!("013_login_devices.postgres.sql");
+const SQL_014_SQLITE: &str = include_str!("014_profile_fields.sqlite.sql");
+const SQL_014_POSTGRES: &str = include_str!("014_profile_fields.postgres.sql");
+const SQL_015_SQLITE: &str = include_str!("015_service_accounts.sqlite.sql");
+const SQL_015_POSTGRES: &str = include_str!("015_service_accounts.postgres.sql");
+const SQL_016_SQLITE: &str = include_str!("016_s3_credentials.sqlite.sql");
+const SQL_016_POSTGRES: &str = include_str!("016_s3_credentials.postgres.sql");
+const SQL_017_SQLITE: &str = include_str!("017_directory_profiles.sqlite.sql");
+const SQL_017_POSTGRES: &str = include_str!("017_directory_profiles.postgres.sql");
+const SQL_018_SQLITE: &str = include_str!("018_api_key_scopes.sqlite.sql");
+const SQL_018_POSTGRES: &str = include_str!("018_api_key_scopes.postgres.sql");
 
 /// Ordered SQLite migration scripts for this block, as `(basename, content)`
 /// pairs. Feeds the runtime `lifecycle(Init)` apply path (auth's `init`).
@@ -36,6 +56,16 @@ pub(crate) const SQLITE_MIGRATIONS: &[(&str, &str)] = &[
     ("006_user_extended_fields", SQL_006_SQLITE),
     ("007_api_keys", SQL_007_SQLITE),
     ("008_rate_limits", SQL_008_SQLITE),
+    ("009_bootstrap_hardening", SQL_009_SQLITE),
+    ("010_users_directory", SQL_010_SQLITE),
+    ("011_normalize_timestamps", SQL_011_SQLITE),
+    ("012_invitations", SQL_012_SQLITE),
+    ("013_login_devices", SQL_013_SQLITE),
+    ("014_profile_fields", SQL_014_SQLITE),
+    ("015_service_accounts", SQL_015_SQLITE),
+    ("016_s3_credentials", SQL_016_SQLITE),
+    ("017_directory_profiles", SQL_017_SQLITE),
+    ("018_api_key_scopes", SQL_018_SQLITE),
 ];
 
 /// Ordered PostgreSQL migration scripts, matching [`SQLITE_MIGRATIONS`] one
@@ -49,6 +79,16 @@ pub(crate) const POSTGRES_MIGRATIONS: &[&str] = &[
     SQL_006_POSTGRES,
     SQL_007_POSTGRES,
     SQL_008_POSTGRES,
+    SQL_009_POSTGRES,
+    SQL_010_POSTGRES,
+    SQL_011_POSTGRES,
+    SQL_012_POSTGRES,
+    SQL_013_POSTGRES,
+    SQL_014_POSTGRES,
+    SQL_015_POSTGRES,
+    SQL_016_POSTGRES,
+    SQL_017_POSTGRES,
+    SQL_018_POSTGRES,
 ];
 
 /// Apply the auth schema through the shared migration-state gate.
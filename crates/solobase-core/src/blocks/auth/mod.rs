@@ -19,12 +19,17 @@
 //! - `brand_panel` — shared UI panel consumed by `auth_ui::pages::*`.
 //! - `authenticate_api_key` — called by `crate::pipeline` to populate auth
 //!   meta from an `Authorization: Bearer <api-key>` header.
+//! - `authenticate_s3` (with `sigv4`) — the same for a request signed with
+//!   an S3 credential (`Authorization: AWS4-HMAC-SHA256 …`).
 
 pub mod bootstrap;
 pub mod config;
+pub mod devices;
+pub mod key_scopes;
 pub mod migrations;
 pub mod repo;
 pub mod service;
+pub mod sigv4;
 
 use std::{collections::HashMap, time::Duration};
 
@@ -91,9 +96,31 @@ pub(crate) mod helpers {
     /// API keys (`authenticate_api_key`). `NotFound` on the inline-role read
     /// is the one case that is genuinely "no role from this source", not a
     /// failure, and stays non-fatal.
+    ///
+    /// Every API-key request resolves roles here, so results are cached
+    /// per user (see [`crate::cache`]). Writers to `USER_ROLES_TABLE` call
+    /// `cache::invalidate_table`; the inline `users.role` is only written
+    /// at signup, before anything could have cached the new id.
     pub(crate) async fn get_user_roles(
         ctx: &dyn wafer_run::context::Context,
         user_id: &str,
+    ) -> Result<Vec<String>, repo::RepoError> {
+        USER_ROLES_CACHE
+            .get_or_try_load(user_id.to_string(), || load_user_roles(ctx, user_id))
+            .await
+    }
+
+    static USER_ROLES_CACHE: crate::cache::LruCache<String, Vec<String>> =
+        crate::cache::LruCache::new(
+            "auth.user_roles",
+            &[USERS_TABLE, USER_ROLES_TABLE],
+            1024,
+            std::time::Duration::from_secs(60),
+        );
+
+    async fn load_user_roles(
+        ctx: &dyn wafer_run::context::Context,
+        user_id: &str,
     ) -> Result<Vec<String>, repo::RepoError> {
         use wafer_block::ErrorCode;
 
@@ -181,6 +208,7 @@ pub(crate) mod helpers {
         }));
         match db::create(ctx, USER_ROLES_TABLE, role_data).await {
             Ok(_) => {
+                crate::cache::invalidate_table(USER_ROLES_TABLE);
                 tracing::info!(
                     user_id = %user_id,
                     email = %email,
@@ -208,13 +236,33 @@ pub(crate) mod helpers {
         raw == "true" || raw == "1"
     }
 
+    /// The effective [`SignupMode`](super::config::SignupMode): `Closed`
+    /// whenever [`signup_allowed`] is off, otherwise
+    /// `SUPPERS_AI__AUTH__SIGNUP_MODE`. An unrecognised value fails closed.
+    /// Read through the config client on every call, so an admin's change
+    /// applies to the next request.
+    pub(crate) async fn signup_mode(
+        ctx: &dyn wafer_run::context::Context,
+    ) -> super::config::SignupMode {
+        use super::config::{SignupMode, SIGNUP_MODE_KEY};
+        if !signup_allowed(ctx).await {
+            return SignupMode::Closed;
+        }
+        let raw = config_client::get_default(ctx, SIGNUP_MODE_KEY, "open").await;
+        SignupMode::parse(&raw).unwrap_or_else(|| {
+            tracing::warn!(value = %raw, "unknown {SIGNUP_MODE_KEY}; treating signup as closed");
+            SignupMode::Closed
+        })
+    }
+
     /// Whether `email`'s domain is permitted to register.
     ///
     /// When `SUPPERS_AI__AUTH__ALLOWED_EMAIL_DOMAINS` is unset (the default)
-    /// every domain is allowed. When set to a comma-separated allow-list, only
-    /// matching domains pass. `email` is expected pre-lowercased; the domain is
-    /// the substring after the last `@` (empty for a malformed address, which
-    /// then fails a non-empty allow-list).
+    /// every domain is allowed, except in `domain-allowlist` signup mode,
+    /// where an empty list admits no one. When set to a comma-separated
+    /// allow-list, only matching domains pass. `email` is expected
+    /// pre-lowercased; the domain is the substring after the last `@` (empty
+    /// for a malformed address, which then fails a non-empty allow-list).
     pub(crate) async fn email_domain_allowed(
         ctx: &dyn wafer_run::context::Context,
         email: &str,
@@ -222,7 +270,7 @@ pub(crate) mod helpers {
         let allowed =
             config_client::get_default(ctx, "SUPPERS_AI__AUTH__ALLOWED_EMAIL_DOMAINS", "").await;
         if allowed.is_empty() {
-            return true;
+            return signup_mode(ctx).await != super::config::SignupMode::DomainAllowlist;
         }
         let domain = email.rsplit_once('@').map(|(_, d)| d).unwrap_or("");
         allowed.split(',').any(|d| d.trim() == domain)
@@ -329,6 +377,17 @@ pub(crate) mod helpers {
         );
         access_claims.insert("jti".to_string(), serde_json::Value::String(jti));
         access_claims.insert("iss".to_string(), serde_json::Value::String(issuer.clone()));
+        // Accounts flagged `must_change_password` (the env-bootstrapped admin)
+        // carry `pwd_change` so the pipeline gate can confine them without a
+        // user-row read on every request. Refresh re-mints through here, so
+        // the claim drops off once the password has been changed.
+        match super::repo::users::must_change_password(ctx, user_id).await {
+            Ok(true) => {
+                access_claims.insert("pwd_change".to_string(), serde_json::Value::Bool(true));
+            }
+            Ok(false) => {}
+            Err(e) => return Err(crate::http::err_internal("must_change_password lookup", e)),
+        }
 
         let access_token = crypto::sign(
             ctx,
@@ -392,20 +451,30 @@ pub(crate) mod helpers {
     /// Stores only the SHA-256 hash of the raw JWT (SEC-032); the JWT itself
     /// never lands in the database. New families start at `generation = 0`;
     /// rotation from `auth_ui::api::refresh::handle` calls this with the same
-    /// `family` and `generation = prev + 1` (SEC-039).
+    /// `family` and `generation = prev + 1` (SEC-039). `device_info` is the
+    /// device fingerprint, empty when the caller has no request to derive it
+    /// from.
     pub(crate) async fn store_refresh_token(
         ctx: &dyn wafer_run::context::Context,
         user_id: &str,
         token: &str,
         family: &str,
         generation: i64,
+        device_info: &str,
     ) {
-        let expires_at = (chrono::Utc::now()
-            + chrono::Duration::seconds(super::REFRESH_TOKEN_TTL_SECS as i64))
-        .format("%Y-%m-%dT%H:%M:%SZ")
-        .to_string();
-        if let Err(e) =
-            super::repo::tokens::insert(ctx, user_id, token, family, generation, &expires_at).await
+        let expires_at = crate::util::format_rfc3339(
+            crate::clock::now() + chrono::Duration::seconds(super::REFRESH_TOKEN_TTL_SECS as i64),
+        );
+        if let Err(e) = super::repo::tokens::insert(
+            ctx,
+            user_id,
+            token,
+            family,
+            generation,
+            &expires_at,
+            device_info,
+        )
+        .await
         {
             tracing::warn!("Failed to store refresh token: {e}");
         }
@@ -458,13 +527,14 @@ pub(crate) mod helpers {
     /// Outcome of [`issue_tokens_and_cookie`]: the freshly minted token pair,
     /// the access-token lifetime (seconds) and the ready-to-set `auth_token`
     /// cookie. Callers add only their response shape (JSON body vs. 302
-    /// redirect). The rotation family is persisted internally (on the refresh
-    /// row); no caller needs it back, so it is intentionally not surfaced here.
+    /// redirect). `family` is the refresh rotation family, which a new
+    /// sign-in alert needs to sign that device out.
     pub(crate) struct IssuedLogin {
         pub access_token: String,
         pub refresh_token: String,
         pub access_lifetime: u64,
         pub cookie: String,
+        pub family: String,
     }
 
     /// Shared token-issuance tail for every login flow (password login, signup,
@@ -481,6 +551,8 @@ pub(crate) mod helpers {
     /// family (initial authentication), `Some(existing)` re-issues within an
     /// established family (refresh rotation). `generation` is the refresh-row
     /// generation to persist (`0` for a new family, `prev + 1` on rotation).
+    /// `device` is the requesting device ([`super::devices`]), stamped on both
+    /// rows; `None` from flows without the request at hand.
     ///
     /// The session-row write failing does not abort issuance — it is a UX
     /// signal, not a security gate (auth is entirely JWT-based today) — but it
@@ -493,17 +565,37 @@ pub(crate) mod helpers {
         auth_method: &str,
         family: Option<&str>,
         generation: i64,
+        device: Option<&super::devices::Device>,
     ) -> std::result::Result<IssuedLogin, wafer_run::OutputStream> {
         use super::{repo::sessions, service::hash_token};
 
         let (access_token, refresh_token, issued_family) =
             generate_tokens(ctx, user_id, email, roles, auth_method, family).await?;
 
-        store_refresh_token(ctx, user_id, &refresh_token, &issued_family, generation).await;
+        let origin = sessions::SessionOrigin {
+            family: issued_family.clone(),
+            device_info: device.map(|d| d.fingerprint.clone()).unwrap_or_default(),
+            device_label: device.map(|d| d.display()).unwrap_or_default(),
+        };
+        store_refresh_token(
+            ctx,
+            user_id,
+            &refresh_token,
+            &issued_family,
+            generation,
+            &origin.device_info,
+        )
+        .await;
 
         let lifetime_days = session_lifetime_days(ctx).await;
-        if let Err(e) =
-            sessions::create_for_user(ctx, user_id, hash_token(&access_token), lifetime_days).await
+        if let Err(e) = sessions::create_for_user(
+            ctx,
+            user_id,
+            hash_token(&access_token),
+            lifetime_days,
+            &origin,
+        )
+        .await
         {
             tracing::warn!(
                 user_id = %user_id,
@@ -520,8 +612,65 @@ pub(crate) mod helpers {
             refresh_token,
             access_lifetime,
             cookie,
+            family: issued_family,
         })
     }
+
+    /// Routes an account with a pending forced password change may still
+    /// reach: the change-password page and API, logout, and the login page
+    /// (so a browser can switch accounts).
+    const PASSWORD_CHANGE_ALLOWED_PATHS: &[&str] = &[
+        "/b/auth/change-password",
+        "/b/auth/api/change-password",
+        "/b/auth/api/logout",
+        "/b/auth/login",
+    ];
+
+    /// Confine a `must_change_password` account to the change-password flow.
+    ///
+    /// Runs in the pipeline after auth meta extraction. Only requests whose
+    /// access JWT carries the `pwd_change` claim
+    /// ([`crate::crypto::META_AUTH_MUST_CHANGE_PASSWORD`]) pay for a user-row
+    /// read; the row is authoritative, so an access token minted before the
+    /// change stops being confined as soon as the flag is cleared. A failed
+    /// read blocks (fail closed).
+    ///
+    /// Returns `Some(response)` to short-circuit: browsers are redirected to
+    /// the change-password page, API callers get a 403 with the stable
+    /// `password_change_required` code.
+    pub(crate) async fn password_change_gate(
+        ctx: &dyn wafer_run::context::Context,
+        msg: &wafer_run::Message,
+    ) -> Option<wafer_run::OutputStream> {
+        use crate::blocks::errors::{error_json, ErrorCode};
+
+        if msg.get_meta(crate::crypto::META_AUTH_MUST_CHANGE_PASSWORD) != "true" {
+            return None;
+        }
+        let path = msg.path();
+        if PASSWORD_CHANGE_ALLOWED_PATHS.contains(&path)
+            || path.starts_with(crate::routing::STATIC_PREFIX)
+            || path == "/health"
+        {
+            return None;
+        }
+        match super::repo::users::must_change_password(ctx, msg.user_id()).await {
+            Ok(false) => return None,
+            Ok(true) => {}
+            Err(e) => tracing::warn!("password_change_gate: user lookup failed: {e}"),
+        }
+
+        let accept = msg.get_meta("http.header.accept");
+        if accept.contains("text/html") && !accept.contains("application/json") {
+            let target = crate::base_path::url(msg, "/b/auth/change-password");
+            return Some(crate::http::redirect(302, &target));
+        }
+        Some(error_json(
+            ErrorCode::PasswordChangeRequired,
+            "You must change your password before continuing",
+            None,
+        ))
+    }
 }
 
 /// Authenticate a request using an API key.
@@ -535,8 +684,6 @@ pub async fn authenticate_api_key(
     api_key: &str,
     msg: &mut wafer_run::Message,
 ) {
-    use wafer_run::*;
-
     use crate::util::sha256_hex;
 
     let key_hash = sha256_hex(api_key.as_bytes());
@@ -554,6 +701,85 @@ pub async fn authenticate_api_key(
         }
     };
 
+    // An S3 credential's id is public; it only ever signs (see
+    // `authenticate_s3`).
+    if key_row.kind != repo::api_keys::KIND_API {
+        return;
+    }
+    authenticate_key_owner(ctx, &key_row, msg).await;
+}
+
+/// Authenticate a request signed with an S3 credential: AWS Signature
+/// Version 4 in the `Authorization` header (see [`sigv4`]). The credential
+/// is found by the header's access key id and the signature recomputed
+/// from the request with its secret; `x-amz-date` must be within
+/// [`sigv4::MAX_CLOCK_SKEW_SECS`] of now. Like [`authenticate_api_key`],
+/// a request that fails any check continues as unauthenticated.
+pub async fn authenticate_s3(
+    ctx: &dyn wafer_run::context::Context,
+    header: &str,
+    msg: &mut wafer_run::Message,
+) {
+    let Some(auth) = sigv4::parse_authorization(header) else {
+        return;
+    };
+    let amz_date = msg.header("x-amz-date").to_string();
+    if !sigv4::is_fresh(&amz_date, auth.date, crate::clock::now()) {
+        return;
+    }
+    let (key_row, secret) = match repo::api_keys::find_s3_secret(ctx, auth.access_key_id).await {
+        Ok(Some(found)) => found,
+        Ok(None) => return,
+        Err(e) => {
+            tracing::warn!("authenticate_s3: lookup failed: {e}");
+            return;
+        }
+    };
+
+    let method = msg.get_meta("http.method").to_ascii_uppercase();
+    // The client signed the path it sent, not the one the pipeline routes
+    // on: a base path is still on it and `//`, `.` and `..` are intact.
+    let path = match msg.get_meta(crate::routing::META_RAW_PATH) {
+        "" => msg.path(),
+        raw => raw,
+    };
+    let request = sigv4::SignedRequest {
+        method: &method,
+        path,
+        query: msg
+            .meta
+            .iter()
+            .filter_map(|entry| {
+                let name = entry.key.strip_prefix("req.query.")?;
+                Some((name.to_string(), entry.value.clone()))
+            })
+            .collect(),
+        headers: auth
+            .signed_headers
+            .iter()
+            .map(|name| (name.to_string(), msg.header(name).to_string()))
+            .collect(),
+        amz_date: &amz_date,
+        payload_hash: msg.header("x-amz-content-sha256"),
+    };
+    if request.payload_hash.is_empty() || !sigv4::verify(&secret, &auth, &request) {
+        return;
+    }
+    authenticate_key_owner(ctx, &key_row, msg).await;
+}
+
+/// Set the auth meta for the owner of a presented key — revoked or expired
+/// keys, keys presented from outside their allowed IPs, and deleted or
+/// disabled owners leave the request anonymous. The key row is read fresh
+/// on every request (nothing is cached), so a revocation applies to the
+/// very next one. Shared by [`authenticate_api_key`] and [`authenticate_s3`].
+async fn authenticate_key_owner(
+    ctx: &dyn wafer_run::context::Context,
+    key_row: &repo::api_keys::ApiKeyRow,
+    msg: &mut wafer_run::Message,
+) {
+    use wafer_run::*;
+
     // Reject revoked or expired keys.
     if key_row.is_revoked() {
         return;
@@ -561,6 +787,9 @@ pub async fn authenticate_api_key(
     if key_row.is_expired(&crate::util::now_rfc3339()) {
         return;
     }
+    if !key_scopes::ip_allowed(&key_row.allowed_ips, msg.remote_addr()) {
+        return;
+    }
 
     // Look up the user to get email and roles.
     if key_row.user_id.is_empty() {
@@ -570,7 +799,7 @@ pub async fn authenticate_api_key(
         Ok(Some(u)) => u,
         Ok(None) => return,
         Err(e) => {
-            tracing::warn!(user_id = %key_row.user_id, "authenticate_api_key: user lookup failed: {e}");
+            tracing::warn!(user_id = %key_row.user_id, "key authentication: user lookup failed: {e}");
             return;
         }
     };
@@ -590,7 +819,7 @@ pub async fn authenticate_api_key(
     let roles = match helpers::get_user_roles(ctx, &key_row.user_id).await {
         Ok(r) => r,
         Err(e) => {
-            tracing::warn!(user_id = %key_row.user_id, "authenticate_api_key: roles lookup failed: {e}");
+            tracing::warn!(user_id = %key_row.user_id, "key authentication: roles lookup failed: {e}");
             return;
         }
     };
@@ -600,6 +829,20 @@ pub async fn authenticate_api_key(
     msg.set_meta(META_AUTH_USER_ID, &key_row.user_id);
     msg.set_meta(META_AUTH_USER_EMAIL, &user.email);
     msg.set_meta(META_AUTH_USER_ROLES, &roles_str);
+    // A service account's own rate limits ride along for the limiter.
+    if user.is_service_account() && user.rate_limits.trim() != "{}" {
+        msg.set_meta(
+            crate::blocks::rate_limit::META_RATE_LIMITS,
+            &user.rate_limits,
+        );
+    }
+    key_scopes::stamp(msg, key_row);
+
+    // Usage is bookkeeping: a failed stamp must not fail the request.
+    let ip = devices::client_ip(msg.remote_addr())
+        .map(|ip| ip.to_string())
+        .unwrap_or_default();
+    repo::api_keys::record_use(ctx, &key_row.id, &ip).await;
 }
 
 use crate::ui::{templates::BrandPanel, SiteConfig};
@@ -624,8 +867,9 @@ mod api_key_lifecycle_tests {
     use wafer_run::{Message, META_AUTH_USER_ID};
 
     use super::{
-        authenticate_api_key,
+        authenticate_api_key, authenticate_s3,
         repo::{api_keys, users},
+        sigv4,
     };
     use crate::{test_support::TestContext, util::sha256_hex};
 
@@ -650,6 +894,7 @@ mod api_key_lifecycle_tests {
                 key_hash: &key_hash,
                 key_prefix: "sb_test",
                 expires_at: None,
+                ..Default::default()
             },
         )
         .await
@@ -700,6 +945,153 @@ mod api_key_lifecycle_tests {
         // No auth meta stamped → request stays anonymous.
         assert_eq!(msg.get_meta(META_AUTH_USER_ID), "");
     }
+
+    #[tokio::test]
+    async fn restricted_key_checks_ip_and_carries_scopes() {
+        let ctx = TestContext::with_auth().await;
+        let uid = seed_user_and_key(&ctx, "raw-unused-key").await;
+        let scopes = vec!["storage.read".to_string()];
+        let allowed_ips = vec!["203.0.113.0/24".to_string()];
+        let key = api_keys::insert(
+            &ctx,
+            api_keys::NewApiKey {
+                user_id: &uid,
+                name: "office-reader",
+                key_hash: &sha256_hex(b"raw-office-key"),
+                key_prefix: "sb_offi",
+                scopes: &scopes,
+                bucket: "docs",
+                allowed_ips: &allowed_ips,
+                ..Default::default()
+            },
+        )
+        .await
+        .unwrap();
+
+        let mut msg = Message::new("http");
+        msg.set_meta("req.client.ip", "198.51.100.4");
+        authenticate_api_key(&ctx, "raw-office-key", &mut msg).await;
+        assert_eq!(msg.get_meta(META_AUTH_USER_ID), "");
+
+        let mut msg = Message::new("http");
+        msg.set_meta("req.client.ip", "203.0.113.9");
+        authenticate_api_key(&ctx, "raw-office-key", &mut msg).await;
+        assert_eq!(msg.get_meta(META_AUTH_USER_ID), uid);
+        assert_eq!(msg.get_meta(key_scopes::META_KEY_ID), key.id);
+        assert_eq!(msg.get_meta(key_scopes::META_KEY_SCOPES), "storage.read");
+        assert_eq!(msg.get_meta(key_scopes::META_KEY_BUCKET), "docs");
+
+        // Only the accepted request counts as a use.
+        let used = api_keys::find_by_id(&ctx, &key.id).await.unwrap().unwrap();
+        assert_eq!(used.use_count, 1);
+        assert_eq!(used.last_used_ip, "203.0.113.9");
+        assert!(used.last_used_at.is_some());
+    }
+
+    /// A request signed with `secret` the way an S3 client signs it.
+    fn signed_msg(access_key_id: &str, secret: &str, path: &str) -> Message {
+        let amz_date = crate::clock::now().format("%Y%m%dT%H%M%SZ").to_string();
+        let payload_hash = sha256_hex(b"");
+        let request = sigv4::SignedRequest {
+            method: "GET",
+            path,
+            query: vec![("list-type".to_string(), "2".to_string())],
+            headers: vec![
+                ("host".to_string(), "files.example.com".to_string()),
+                ("x-amz-content-sha256".to_string(), payload_hash.clone()),
+                ("x-amz-date".to_string(), amz_date.clone()),
+            ],
+            amz_date: &amz_date,
+            payload_hash: &payload_hash,
+        };
+        let header = sigv4::authorization_header(
+            access_key_id,
+            secret,
+            "us-east-1",
+            &request,
+            &["host", "x-amz-content-sha256", "x-amz-date"],
+        );
+        let mut msg = Message::new("http");
+        msg.set_meta("http.method", "GET");
+        msg.set_meta("req.resource", "/s3/docs");
+        msg.set_meta("req.query.list-type", "2");
+        msg.set_meta("http.header.host", "files.example.com");
+        msg.set_meta("http.header.x-amz-content-sha256", &payload_hash);
+        msg.set_meta("http.header.x-amz-date", &amz_date);
+        msg.set_meta("http.header.authorization", &header);
+        msg
+    }
+
+    #[tokio::test]
+    async fn s3_signed_request_authenticates() {
+        let ctx = TestContext::with_auth().await;
+        let uid = seed_user_and_key(&ctx, "raw-api-key").await;
+        let generated = api_keys::generate_s3(&ctx).await.unwrap();
+        api_keys::insert_s3(
+            &ctx,
+            api_keys::NewApiKey {
+                user_id: &uid,
+                name: "backup",
+                key_hash: &generated.key_hash,
+                key_prefix: &generated.access_key_id,
+                expires_at: None,
+                ..Default::default()
+            },
+            &generated.secret,
+        )
+        .await
+        .unwrap();
+        let (id, secret) = (&generated.access_key_id, &generated.secret);
+
+        let mut msg = signed_msg(id, secret, "/s3/docs");
+        let header = msg.header("authorization").to_string();
+        authenticate_s3(&ctx, &header, &mut msg).await;
+        assert_eq!(msg.get_meta(META_AUTH_USER_ID), uid);
+
+        // Signed for another path, or with another secret: anonymous.
+        let mut msg = signed_msg(id, secret, "/s3/other");
+        let header = msg.header("authorization").to_string();
+        authenticate_s3(&ctx, &header, &mut msg).await;
+        assert_eq!(msg.get_meta(META_AUTH_USER_ID), "");
+        let mut msg = signed_msg(id, "not-the-secret", "/s3/docs");
+        let header = msg.header("authorization").to_string();
+        authenticate_s3(&ctx, &header, &mut msg).await;
+        assert_eq!(msg.get_meta(META_AUTH_USER_ID), "");
+
+        // The public access key id is no API key.
+        let mut msg = Message::new("http");
+        authenticate_api_key(&ctx, id, &mut msg).await;
+        assert_eq!(msg.get_meta(META_AUTH_USER_ID), "");
+    }
+
+    #[tokio::test]
+    async fn service_account_key_carries_its_rate_limits() {
+        use crate::blocks::rate_limit::META_RATE_LIMITS;
+
+        let ctx = TestContext::with_auth().await;
+        let limits = r#"{"api_write":"10/60"}"#;
+        let sa = users::insert_service_account(&ctx, "ci", limits)
+            .await
+            .unwrap();
+        api_keys::insert(
+            &ctx,
+            api_keys::NewApiKey {
+                user_id: &sa.id,
+                name: "ci",
+                key_hash: &sha256_hex(b"raw-sa-key"),
+                key_prefix: "sb_sa",
+                expires_at: None,
+                ..Default::default()
+            },
+        )
+        .await
+        .unwrap();
+
+        let mut msg = Message::new("http");
+        authenticate_api_key(&ctx, "raw-sa-key", &mut msg).await;
+        assert_eq!(msg.get_meta(META_AUTH_USER_ID), sa.id);
+        assert_eq!(msg.get_meta(META_RATE_LIMITS), limits);
+    }
 }
 
 // SB-3: `get_user_roles` used to swallow both DB reads with `if let
@@ -754,3 +1146,88 @@ mod get_user_roles_error_surfacing_tests {
         );
     }
 }
+
+#[cfg(test)]
+mod password_change_gate_tests {
+    use super::{helpers::password_change_gate, repo::users};
+    use crate::{
+        crypto::META_AUTH_MUST_CHANGE_PASSWORD,
+        test_support::{auth_msg, output_status, TestContext},
+    };
+
+    async fn seed_flagged_user(ctx: &TestContext) -> String {
+        let user = users::insert(
+            ctx,
+            users::NewUser {
+                email: "root@example.com".into(),
+                display_name: "Admin".into(),
+                avatar_url: None,
+                role: "admin".into(),
+            },
+        )
+        .await
+        .unwrap();
+        users::set_must_change_password(ctx, &user.id, true)
+            .await
+            .unwrap();
+        user.id
+    }
+
+    fn flagged_msg(action: &str, path: &str, user_id: &str) -> wafer_run::Message {
+        let mut msg = auth_msg(action, path, user_id);
+        msg.set_meta(META_AUTH_MUST_CHANGE_PASSWORD, "true");
+        msg
+    }
+
+    #[tokio::test]
+    async fn blocks_other_routes_until_password_changes() {
+        let ctx = TestContext::with_auth().await;
+        let id = seed_flagged_user(&ctx).await;
+
+        let out = password_change_gate(&ctx, &flagged_msg("retrieve", "/b/admin/", &id))
+            .await
+            .expect("admin UI must be blocked");
+        assert_eq!(output_status(out).await, 403);
+
+        // Clearing the flag releases tokens that still carry the claim.
+        users::set_must_change_password(&ctx, &id, false)
+            .await
+            .unwrap();
+        assert!(
+            password_change_gate(&ctx, &flagged_msg("retrieve", "/b/admin/", &id))
+                .await
+                .is_none()
+        );
+    }
+
+    #[tokio::test]
+    async fn change_password_and_logout_stay_reachable() {
+        let ctx = TestContext::with_auth().await;
+        let id = seed_flagged_user(&ctx).await;
+        for (action, path) in [
+            ("create", "/b/auth/api/change-password"),
+            ("retrieve", "/b/auth/change-password"),
+            ("create", "/b/auth/api/logout"),
+        ] {
+            assert!(
+                password_change_gate(&ctx, &flagged_msg(action, path, &id))
+                    .await
+                    .is_none(),
+                "{path} must stay reachable"
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn unflagged_tokens_skip_the_lookup() {
+        let ctx = TestContext::with_auth().await;
+        let id = seed_flagged_user(&ctx).await;
+        // No claim → no gate, even though the row is flagged: the claim is
+        // what opts a request into the check.
+        assert!(
+            password_change_gate(&ctx, &auth_msg("retrieve", "/b/admin/", &id))
+                .await
+                .is_none()
+        );
+    }
+}
@@ -5,18 +5,36 @@
 //! deterministic SHA-256 hex (`key_hash`) is persisted, so the lookup on every
 //! request is by hash. Consumed by `auth_ui/api/api_keys.rs` (the CRUD
 //! endpoints) and `auth::authenticate_api_key` (the pipeline preprocessor).
+//!
+//! An S3 credential ([`KIND_S3`]) is a row of the same table: an access key
+//! id / secret pair that signs requests to the storage gateway (see
+//! `auth::sigv4`). Signature checks need the secret itself, so it is stored
+//! (read back only by [`find_s3_secret`]); `key_hash` hashes the public
+//! access key id and `key_prefix` is that id in full.
+//!
+//! A key may be narrower than its owner: `scopes`, `bucket` and
+//! `allowed_ips` (auth migration 018) confine it, and are enforced by
+//! `auth::key_scopes`. Each use stamps `last_used_at`, `last_used_ip` and
+//! `use_count` ([`record_use`]); buffered, they may trail live traffic by a
+//! [`crate::write_buffer::FLUSH_INTERVAL`].
 
 use std::collections::HashMap;
 
 use serde_json::{json, Value};
 use wafer_block::db::{Filter, FilterOp, SortField};
-use wafer_core::clients::database as db;
-use wafer_run::context::Context;
+use wafer_core::clients::{crypto, database as db};
+use wafer_run::{context::Context, WaferError};
 
 use super::{map_opt_str, map_str, now_iso, RepoError};
+use crate::write_buffer::CounterBuffer;
 
 pub const TABLE: &str = "suppers_ai__auth__api_keys";
 
+/// An ordinary `sb_…` key, presented as `Authorization: ApiKey …`.
+pub const KIND_API: &str = "api";
+/// An S3 credential, used only to sign gateway requests.
+pub const KIND_S3: &str = "s3";
+
 /// A loaded API-key row. `key_hash` is included so the pipeline can compare it,
 /// but the CRUD endpoints strip it before serialising to clients.
 #[derive(Debug, Clone, PartialEq, Eq)]
@@ -24,6 +42,8 @@ pub struct ApiKeyRow {
     pub id: String,
     pub user_id: String,
     pub name: String,
+    /// [`KIND_API`] or [`KIND_S3`].
+    pub kind: String,
     pub key_prefix: String,
     pub key_hash: String,
     pub created_at: String,
@@ -31,6 +51,16 @@ pub struct ApiKeyRow {
     pub expires_at: Option<String>,
     /// Set when the key was revoked; `None` while active.
     pub revoked_at: Option<String>,
+    /// Permission names the key is confined to; empty for everything its
+    /// owner may do.
+    pub scopes: Vec<String>,
+    /// The one storage bucket the key may reach; empty for any.
+    pub bucket: String,
+    /// CIDR ranges the key is accepted from; empty for anywhere.
+    pub allowed_ips: Vec<String>,
+    pub last_used_at: Option<String>,
+    pub last_used_ip: String,
+    pub use_count: i64,
 }
 
 impl ApiKeyRow {
@@ -51,7 +81,7 @@ impl ApiKeyRow {
 }
 
 /// Insert payload for [`insert`]. Borrowed fields — the caller keeps ownership.
-#[derive(Debug, Clone, Copy)]
+#[derive(Debug, Clone, Copy, Default)]
 pub struct NewApiKey<'a> {
     pub user_id: &'a str,
     pub name: &'a str,
@@ -59,25 +89,124 @@ pub struct NewApiKey<'a> {
     pub key_prefix: &'a str,
     /// Optional absolute expiry (ISO-8601).
     pub expires_at: Option<&'a str>,
+    /// See [`ApiKeyRow::scopes`].
+    pub scopes: &'a [String],
+    /// See [`ApiKeyRow::bucket`].
+    pub bucket: &'a str,
+    /// See [`ApiKeyRow::allowed_ips`].
+    pub allowed_ips: &'a [String],
+}
+
+/// A freshly generated key. `raw` is what the caller gets to see, once;
+/// the other two fields are what [`insert`] stores.
+#[derive(Debug, Clone)]
+pub struct GeneratedKey {
+    pub raw: String,
+    pub key_hash: String,
+    pub key_prefix: String,
+}
+
+/// Generate a random `sb_…` key with its lookup hash and display prefix.
+pub async fn generate(ctx: &dyn Context) -> Result<GeneratedKey, WaferError> {
+    let random_bytes = crypto::random_bytes(ctx, 24).await?;
+    let raw = format!("sb_{}", crate::util::hex_encode(&random_bytes));
+    // Deterministic SHA-256 so the pipeline can look the key up by hash
+    // (argon2 is salted, so it can't be searched).
+    let key_hash = crate::util::sha256_hex(raw.as_bytes());
+    let key_prefix = raw[..10].to_string();
+    Ok(GeneratedKey {
+        raw,
+        key_hash,
+        key_prefix,
+    })
+}
+
+/// A freshly generated S3 credential. Both halves are shown to the caller
+/// once; [`insert_s3`] stores the secret and the id's lookup hash.
+#[derive(Debug, Clone)]
+pub struct GeneratedS3Key {
+    pub access_key_id: String,
+    pub secret: String,
+    pub key_hash: String,
+}
+
+/// Generate a random S3 access key id (`SB` plus 18 upper-case hex digits,
+/// the length AWS clients expect) and a 40-character secret.
+pub async fn generate_s3(ctx: &dyn Context) -> Result<GeneratedS3Key, WaferError> {
+    let id_bytes = crypto::random_bytes(ctx, 9).await?;
+    let access_key_id = format!("SB{}", crate::util::hex_encode(&id_bytes).to_uppercase());
+    let secret = crate::util::hex_encode(&crypto::random_bytes(ctx, 20).await?);
+    let key_hash = crate::util::sha256_hex(access_key_id.as_bytes());
+    Ok(GeneratedS3Key {
+        access_key_id,
+        secret,
+        key_hash,
+    })
+}
+
+/// A JSON-array column: the array itself, or the array encoded as a string
+/// by backends that don't parse JSON text.
+fn string_list(v: Option<&Value>) -> Vec<String> {
+    match v {
+        Some(Value::Array(items)) => items
+            .iter()
+            .filter_map(|x| x.as_str().map(str::to_owned))
+            .collect(),
+        Some(Value::String(s)) => serde_json::from_str(s).unwrap_or_default(),
+        _ => Vec::new(),
+    }
 }
 
-fn row_from_map(m: &HashMap<String, Value>) -> Result<ApiKeyRow, RepoError> {
+pub fn row_from_map(m: &HashMap<String, Value>) -> Result<ApiKeyRow, RepoError> {
     Ok(ApiKeyRow {
         id: map_opt_str(m, "id").ok_or_else(|| RepoError::Db("missing id".into()))?,
         user_id: map_str(m, "user_id"),
         name: map_str(m, "name"),
+        kind: map_opt_str(m, "kind")
+            .filter(|k| !k.is_empty())
+            .unwrap_or_else(|| KIND_API.to_string()),
         key_prefix: map_str(m, "key_prefix"),
         key_hash: map_str(m, "key_hash"),
         created_at: map_str(m, "created_at"),
         expires_at: map_opt_str(m, "expires_at"),
         revoked_at: map_opt_str(m, "revoked_at"),
+        scopes: string_list(m.get("scopes")),
+        bucket: map_str(m, "bucket"),
+        allowed_ips: string_list(m.get("allowed_ips")),
+        last_used_at: map_opt_str(m, "last_used_at"),
+        last_used_ip: map_str(m, "last_used_ip"),
+        use_count: m
+            .get("use_count")
+            .and_then(crate::util::json_as_i64)
+            .unwrap_or(0),
     })
 }
 
 /// Insert a new API-key row and return it.
 pub async fn insert(ctx: &dyn Context, new: NewApiKey<'_>) -> Result<ApiKeyRow, RepoError> {
+    insert_row(ctx, new, KIND_API, "").await
+}
+
+/// Insert a new S3 credential row with its `secret` and return it.
+/// `new.key_prefix` is the access key id.
+pub async fn insert_s3(
+    ctx: &dyn Context,
+    new: NewApiKey<'_>,
+    secret: &str,
+) -> Result<ApiKeyRow, RepoError> {
+    insert_row(ctx, new, KIND_S3, secret).await
+}
+
+async fn insert_row(
+    ctx: &dyn Context,
+    new: NewApiKey<'_>,
+    kind: &str,
+    secret: &str,
+) -> Result<ApiKeyRow, RepoError> {
     let mut data: HashMap<String, Value> = HashMap::new();
     data.insert("user_id".into(), json!(new.user_id));
+    data.insert("kind".into(), json!(kind));
+    data.insert("secret".into(), json!(secret));
     data.insert("name".into(), json!(new.name));
     data.insert("key_hash".into(), json!(new.key_hash));
     data.insert("key_prefix".into(), json!(new.key_prefix));
@@ -85,6 +214,12 @@ pub async fn insert(ctx: &dyn Context, new: NewApiKey<'_>) -> Result<ApiKeyRow,
     if let Some(exp) = new.expires_at {
         data.insert("expires_at".into(), json!(exp));
     }
+    data.insert("scopes".into(), json!(json!(new.scopes).to_string()));
+    data.insert("bucket".into(), json!(new.bucket));
+    data.insert(
+        "allowed_ips".into(),
+        json!(json!(new.allowed_ips).to_string()),
+    );
     let rec = db::create(ctx, TABLE, data)
         .await
         .map_err(|e| RepoError::Db(format!("api_keys insert: {e}")))?;
@@ -105,6 +240,27 @@ pub async fn find_by_key_hash(
     }
 }
 
+/// Look up an S3 credential by its access key id, with its secret.
+/// Returns `Ok(None)` when no S3 credential has that id.
+pub async fn find_s3_secret(
+    ctx: &dyn Context,
+    access_key_id: &str,
+) -> Result<Option<(ApiKeyRow, String)>, RepoError> {
+    use wafer_block::ErrorCode;
+    let key_hash = crate::util::sha256_hex(access_key_id.as_bytes());
+    match db::get_by_field(ctx, TABLE, "key_hash", json!(key_hash)).await {
+        Ok(rec) => {
+            let row = row_from_map(&rec.data)?;
+            if row.kind != KIND_S3 {
+                return Ok(None);
+            }
+            Ok(Some((row, map_str(&rec.data, "secret"))))
+        }
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(RepoError::Db(format!("api_keys find_s3_secret: {e}"))),
+    }
+}
+
 /// Look up an API key by its primary `id`. Returns `Ok(None)` when missing.
 pub async fn find_by_id(ctx: &dyn Context, id: &str) -> Result<Option<ApiKeyRow>, RepoError> {
     use wafer_block::ErrorCode;
@@ -137,6 +293,20 @@ pub async fn list_for_user(ctx: &dyn Context, user_id: &str) -> Result<Vec<ApiKe
     records.iter().map(|r| row_from_map(&r.data)).collect()
 }
 
+static USES: CounterBuffer = CounterBuffer::new(TABLE, "use_count");
+
+/// Record that key `id` just authenticated a request from `ip`: bumps
+/// `use_count` and stamps `last_used_at` / `last_used_ip`, through a
+/// [`CounterBuffer`] so a busy key costs one write per flush rather than
+/// two per request. Best-effort: a failed write is logged.
+pub async fn record_use(ctx: &dyn Context, id: &str, ip: &str) {
+    let set = crate::util::json_map(json!({
+        "last_used_at": now_iso(),
+        "last_used_ip": ip,
+    }));
+    USES.record(ctx, id.to_string(), 1, set).await;
+}
+
 /// Mark an API key revoked (stamps `revoked_at` with [`super::now_iso`]).
 pub async fn revoke(ctx: &dyn Context, id: &str) -> Result<(), RepoError> {
     let mut data: HashMap<String, Value> = HashMap::new();
@@ -147,6 +317,43 @@ pub async fn revoke(ctx: &dyn Context, id: &str) -> Result<(), RepoError> {
     Ok(())
 }
 
+/// Retire every usable key of `user_id` (for key rotation). With `at`
+/// (ISO-8601) a key stops working then — unless it already expires
+/// sooner — so callers get a grace period to roll out the replacement;
+/// without it keys are revoked now. Returns how many keys were retired.
+pub async fn retire_active(
+    ctx: &dyn Context,
+    user_id: &str,
+    at: Option<&str>,
+) -> Result<usize, RepoError> {
+    let now = now_iso();
+    let mut retired = 0;
+    for key in list_for_user(ctx, user_id).await? {
+        if key.is_revoked() || key.is_expired(&now) {
+            continue;
+        }
+        match at {
+            None => revoke(ctx, &key.id).await?,
+            Some(at) => {
+                if key
+                    .expires_at
+                    .as_deref()
+                    .is_some_and(|e| !e.is_empty() && *e <= *at)
+                {
+                    continue;
+                }
+                let mut data: HashMap<String, Value> = HashMap::new();
+                data.insert("expires_at".into(), json!(at));
+                db::update(ctx, TABLE, &key.id, data)
+                    .await
+                    .map_err(|e| RepoError::Db(format!("api_keys retire_active: {e}")))?;
+            }
+        }
+        retired += 1;
+    }
+    Ok(retired)
+}
+
 /// Hard-delete an API-key row by id.
 pub async fn delete(ctx: &dyn Context, id: &str) -> Result<(), RepoError> {
     db::delete(ctx, TABLE, id)
@@ -191,6 +398,7 @@ mod tests {
                 key_hash: "deadbeef",
                 key_prefix: "sb_deadbe",
                 expires_at: None,
+                ..Default::default()
             },
         )
         .await
@@ -217,6 +425,7 @@ mod tests {
                 key_hash: "h-a",
                 key_prefix: "sb_a",
                 expires_at: None,
+                ..Default::default()
             },
         )
         .await
@@ -229,6 +438,7 @@ mod tests {
                 key_hash: "h-b",
                 key_prefix: "sb_b",
                 expires_at: None,
+                ..Default::default()
             },
         )
         .await
@@ -245,17 +455,124 @@ mod tests {
         assert_eq!(list_for_user(&ctx, "user-a").await.unwrap().len(), 1);
     }
 
+    #[tokio::test]
+    async fn retire_active_revokes_or_schedules_expiry() {
+        let ctx = TestContext::with_auth().await;
+        seed_user(&ctx, "user-a").await;
+        let new = |name: &'static str, expires_at: Option<&'static str>| NewApiKey {
+            user_id: "user-a",
+            name,
+            key_hash: name,
+            key_prefix: name,
+            expires_at,
+            ..Default::default()
+        };
+        let open = insert(&ctx, new("open", None)).await.unwrap();
+        let soon = insert(&ctx, new("soon", Some("2099-01-01T00:00:00Z")))
+            .await
+            .unwrap();
+        let gone = insert(&ctx, new("gone", Some("2020-01-01T00:00:00Z")))
+            .await
+            .unwrap();
+
+        let at = "2099-06-01T00:00:00Z";
+        assert_eq!(retire_active(&ctx, "user-a", Some(at)).await.unwrap(), 1);
+        let open_row = find_by_id(&ctx, &open.id).await.unwrap().unwrap();
+        assert_eq!(open_row.expires_at.as_deref(), Some(at));
+        let soon_row = find_by_id(&ctx, &soon.id).await.unwrap().unwrap();
+        assert_eq!(soon_row.expires_at.as_deref(), Some("2099-01-01T00:00:00Z"));
+
+        assert_eq!(retire_active(&ctx, "user-a", None).await.unwrap(), 2);
+        assert!(find_by_id(&ctx, &soon.id)
+            .await
+            .unwrap()
+            .unwrap()
+            .is_revoked());
+        assert!(!find_by_id(&ctx, &gone.id)
+            .await
+            .unwrap()
+            .unwrap()
+            .is_revoked());
+    }
+
+    #[tokio::test]
+    async fn generated_keys_hash_to_their_lookup_value() {
+        let ctx = TestContext::with_auth().await;
+        let key = generate(&ctx).await.unwrap();
+        assert!(key.raw.starts_with("sb_"));
+        assert!(key.raw.starts_with(&key.key_prefix));
+        assert_eq!(key.key_hash, crate::util::sha256_hex(key.raw.as_bytes()));
+    }
+
+    #[tokio::test]
+    async fn s3_credentials_keep_their_secret_apart_from_api_keys() {
+        let ctx = TestContext::with_auth().await;
+        seed_user(&ctx, "user-a").await;
+        let generated = generate_s3(&ctx).await.unwrap();
+        assert_eq!(generated.access_key_id.len(), 20);
+        assert!(generated.access_key_id.starts_with("SB"));
+        assert_eq!(generated.secret.len(), 40);
+        let row = insert_s3(
+            &ctx,
+            NewApiKey {
+                user_id: "user-a",
+                name: "backup",
+                key_hash: &generated.key_hash,
+                key_prefix: &generated.access_key_id,
+                expires_at: None,
+                ..Default::default()
+            },
+            &generated.secret,
+        )
+        .await
+        .unwrap();
+        assert_eq!(row.kind, KIND_S3);
+
+        let (found, secret) = find_s3_secret(&ctx, &generated.access_key_id)
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(found.id, row.id);
+        assert_eq!(secret, generated.secret);
+
+        // An ordinary key is never returned as an S3 credential.
+        let api = generate(&ctx).await.unwrap();
+        insert(
+            &ctx,
+            NewApiKey {
+                user_id: "user-a",
+                name: "ci",
+                key_hash: &api.key_hash,
+                key_prefix: &api.key_prefix,
+                expires_at: None,
+                ..Default::default()
+            },
+        )
+        .await
+        .unwrap();
+        assert!(find_s3_secret(&ctx, &api.raw).await.unwrap().is_none());
+        let listed = list_for_user(&ctx, "user-a").await.unwrap();
+        assert!(listed.iter().any(|k| k.kind == KIND_API));
+    }
+
     #[test]
     fn is_expired_compares_string_timestamps() {
         let mut row = ApiKeyRow {
             id: "1".into(),
             user_id: "u".into(),
             name: "n".into(),
+            kind: KIND_API.into(),
             key_prefix: "p".into(),
             key_hash: "h".into(),
             created_at: "2026-01-01T00:00:00Z".into(),
             expires_at: None,
             revoked_at: None,
+            scopes: Vec::new(),
+            bucket: String::new(),
+            allowed_ips: Vec::new(),
+            last_used_at: None,
+            last_used_ip: String::new(),
+            use_count: 0,
         };
         // No expiry → never expired.
         assert!(!row.is_expired("2030-01-01T00:00:00Z"));
@@ -263,4 +580,40 @@ mod tests {
         assert!(row.is_expired("2026-06-02T00:00:00Z"));
         assert!(!row.is_expired("2026-05-31T00:00:00Z"));
     }
+
+    #[tokio::test]
+    async fn restrictions_round_trip_and_uses_are_counted() {
+        let ctx = TestContext::with_auth().await;
+        seed_user(&ctx, "user-a").await;
+        let scopes = vec!["storage.read".to_string(), "logs.*".to_string()];
+        let allowed_ips = vec!["10.0.0.0/8".to_string()];
+        let row = insert(
+            &ctx,
+            NewApiKey {
+                user_id: "user-a",
+                name: "narrow",
+                key_hash: "h-narrow",
+                key_prefix: "sb_narr",
+                scopes: &scopes,
+                bucket: "docs",
+                allowed_ips: &allowed_ips,
+                ..Default::default()
+            },
+        )
+        .await
+        .unwrap();
+        let stored = find_by_id(&ctx, &row.id).await.unwrap().unwrap();
+        assert_eq!(stored.scopes, scopes);
+        assert_eq!(stored.bucket, "docs");
+        assert_eq!(stored.allowed_ips, allowed_ips);
+        assert_eq!(stored.use_count, 0);
+        assert!(stored.last_used_at.is_none());
+
+        record_use(&ctx, &row.id, "10.1.2.3").await;
+        record_use(&ctx, &row.id, "10.1.2.4").await;
+        let used = find_by_id(&ctx, &row.id).await.unwrap().unwrap();
+        assert_eq!(used.use_count, 2);
+        assert_eq!(used.last_used_ip, "10.1.2.4");
+        assert!(used.last_used_at.is_some());
+    }
 }
@@ -0,0 +1,63 @@
+//! Row-level access over `suppers_ai__auth__bootstrap_state`.
+//!
+//! One row per completed admin bootstrap (env email+password, or redemption
+//! of a bootstrap / setup token). The rows outlive the admin account they
+//! describe, which is what lets `bootstrap::run` honour
+//! `SOLOBASE_SHARED__AUTH__BOOTSTRAP_ONCE` after the users table has been
+//! emptied.
+
+use std::collections::HashMap;
+
+use serde_json::{json, Value};
+use wafer_core::clients::database as db;
+use wafer_run::context::Context;
+
+use super::{now_iso, RepoError};
+
+pub const TABLE: &str = "suppers_ai__auth__bootstrap_state";
+
+/// Record that the first admin (`admin_user_id`) was provisioned via
+/// `method` (`"env"` or `"token"`).
+pub async fn mark_completed(
+    ctx: &dyn Context,
+    admin_user_id: &str,
+    method: &str,
+) -> Result<(), RepoError> {
+    let now = now_iso();
+    let mut data: HashMap<String, Value> = HashMap::new();
+    data.insert("id".into(), json!(uuid::Uuid::now_v7().to_string()));
+    data.insert("admin_user_id".into(), json!(admin_user_id));
+    data.insert("method".into(), json!(method));
+    data.insert("completed_at".into(), json!(now));
+    data.insert("created_at".into(), json!(now));
+    data.insert("updated_at".into(), json!(now));
+    db::create(ctx, TABLE, data)
+        .await
+        .map_err(|e| RepoError::Db(format!("bootstrap_state insert: {e}")))?;
+    Ok(())
+}
+
+/// True once any bootstrap has completed on this database.
+pub async fn is_completed(ctx: &dyn Context) -> Result<bool, RepoError> {
+    let n = db::count(ctx, TABLE, &[])
+        .await
+        .map_err(|e| RepoError::Db(format!("bootstrap_state count: {e}")))?;
+    Ok(n > 0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    #[tokio::test]
+    async fn completed_only_after_mark() {
+        let ctx =
+            TestContext::with_auth()
+                .await
+                .with_wrap("suppers-ai/auth", vec![], "suppers-ai/admin");
+        assert!(!is_completed(&ctx).await.unwrap());
+        mark_completed(&ctx, "u-1", "env").await.unwrap();
+        assert!(is_completed(&ctx).await.unwrap());
+    }
+}
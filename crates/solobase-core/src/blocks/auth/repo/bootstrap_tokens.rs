@@ -90,21 +90,32 @@ pub async fn delete_by_hash(ctx: &dyn Context, token_hash: &[u8]) -> Result<(),
     Ok(())
 }
 
+/// Delete every bootstrap-token row. Used before installing a fresh setup
+/// token so a URL printed on an earlier boot stops working once a new one
+/// is issued — only the most recently printed token is redeemable.
+pub async fn delete_all(ctx: &dyn Context) -> Result<(), RepoError> {
+    let records = db::list_all(ctx, TABLE, vec![])
+        .await
+        .map_err(|e| RepoError::Db(format!("bootstrap_tokens list for delete_all: {e}")))?;
+    for record in records {
+        db::delete(ctx, TABLE, &record.id)
+            .await
+            .map_err(|e| RepoError::Db(format!("bootstrap_tokens delete: {e}")))?;
+    }
+    Ok(())
+}
+
 #[cfg(test)]
 mod typed_client_tests {
     use super::*;
     use crate::test_support::TestContext;
 
     fn future_iso(secs: i64) -> String {
-        (chrono::Utc::now() + chrono::Duration::seconds(secs))
-            .format("%Y-%m-%dT%H:%M:%SZ")
-            .to_string()
+        crate::util::format_rfc3339(crate::clock::now() + chrono::Duration::seconds(secs))
     }
 
     fn past_iso(secs: i64) -> String {
-        (chrono::Utc::now() - chrono::Duration::seconds(secs))
-            .format("%Y-%m-%dT%H:%M:%SZ")
-            .to_string()
+        crate::util::format_rfc3339(crate::clock::now() - chrono::Duration::seconds(secs))
     }
 
     #[tokio::test]
@@ -151,4 +162,19 @@ mod typed_client_tests {
         delete_by_hash(&ctx, &hash).await.unwrap();
         assert!(!is_valid(&ctx, &hash).await.unwrap());
     }
+
+    #[tokio::test]
+    async fn delete_all_invalidates_every_token() {
+        let ctx =
+            TestContext::with_auth()
+                .await
+                .with_wrap("suppers-ai/auth", vec![], "suppers-ai/admin");
+        let a = vec![0x11_u8; 32];
+        let b = vec![0x22_u8; 32];
+        insert(&ctx, a.clone(), &future_iso(3600)).await.unwrap();
+        insert(&ctx, b.clone(), &future_iso(3600)).await.unwrap();
+        delete_all(&ctx).await.unwrap();
+        assert!(!is_valid(&ctx, &a).await.unwrap());
+        assert!(!is_valid(&ctx, &b).await.unwrap());
+    }
 }
@@ -0,0 +1,206 @@
+//! Read-only lookups over `suppers_ai__auth__users_directory` — the view
+//! of live accounts (migration 010; profile columns since 017) that other
+//! blocks are granted instead of the full users table.
+
+use serde_json::json;
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::context::Context;
+
+use super::{map_str, RepoError};
+
+pub const TABLE: &str = "suppers_ai__auth__users_directory";
+
+/// Upper bound on [`search`] results, whatever the caller asks for.
+pub const MAX_SEARCH_RESULTS: i64 = 50;
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DirectoryEntry {
+    pub id: String,
+    pub email: String,
+    pub display_name: String,
+    /// `""` when the account has no avatar.
+    pub avatar_url: String,
+}
+
+impl DirectoryEntry {
+    fn from_record(rec: &Record) -> Self {
+        Self {
+            id: map_str(&rec.data, "id"),
+            email: map_str(&rec.data, "email"),
+            display_name: map_str(&rec.data, "display_name"),
+            avatar_url: map_str(&rec.data, "avatar_url"),
+        }
+    }
+}
+
+/// Single-row lookup by `field`; `NotFound` maps to `None`.
+async fn find_by(
+    ctx: &dyn Context,
+    field: &str,
+    value: &str,
+) -> Result<Option<DirectoryEntry>, RepoError> {
+    use wafer_block::ErrorCode;
+    match db::get_by_field(ctx, TABLE, field, json!(value)).await {
+        Ok(rec) => Ok(Some(DirectoryEntry::from_record(&rec))),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(RepoError::Db(format!("directory by {field}: {e}"))),
+    }
+}
+
+/// The live account registered under `email`, if any. Disabled and
+/// soft-deleted accounts are not in the view and resolve to `None`.
+pub async fn find_by_email(
+    ctx: &dyn Context,
+    email: &str,
+) -> Result<Option<DirectoryEntry>, RepoError> {
+    find_by(ctx, "email", email).await
+}
+
+/// The live account with user id `id`, if any.
+pub async fn find_by_id(ctx: &dyn Context, id: &str) -> Result<Option<DirectoryEntry>, RepoError> {
+    find_by(ctx, "id", id).await
+}
+
+/// The live accounts among `ids`, in no particular order; unknown ids are
+/// left out. One query per call.
+pub async fn find_by_ids(
+    ctx: &dyn Context,
+    ids: &[&str],
+) -> Result<Vec<DirectoryEntry>, RepoError> {
+    if ids.is_empty() {
+        return Ok(Vec::new());
+    }
+    let filters = vec![Filter {
+        field: "id".to_string(),
+        operator: FilterOp::In,
+        value: json!(ids),
+    }];
+    let records = db::list_all(ctx, TABLE, filters)
+        .await
+        .map_err(|e| RepoError::Db(format!("directory by ids: {e}")))?;
+    Ok(records.iter().map(DirectoryEntry::from_record).collect())
+}
+
+/// Live accounts whose email starts with `query` (case-insensitive, LIKE
+/// wildcards in `query` match literally), alphabetical by email. `query`
+/// must carry a whole local part and its `@` (`ana@`, `ana@exa`), so only
+/// someone who already knows an address can find its account; anything
+/// else returns nothing, and the directory can't be enumerated a few
+/// letters at a time. `limit` is clamped to `1..=`[`MAX_SEARCH_RESULTS`].
+pub async fn search(
+    ctx: &dyn Context,
+    query: &str,
+    limit: i64,
+) -> Result<Vec<DirectoryEntry>, RepoError> {
+    let query = query.trim().to_lowercase();
+    if !is_address_prefix(&query) {
+        return Ok(Vec::new());
+    }
+    let opts = ListOptions {
+        filters: vec![Filter {
+            field: "email".to_string(),
+            operator: FilterOp::Like,
+            value: json!(format!("{}%", crate::util::escape_like(&query))),
+        }],
+        sort: vec![SortField {
+            field: "email".to_string(),
+            desc: false,
+        }],
+        limit: limit.clamp(1, MAX_SEARCH_RESULTS),
+        ..Default::default()
+    };
+    let list = db::list(ctx, TABLE, &opts)
+        .await
+        .map_err(|e| RepoError::Db(format!("directory search: {e}")))?;
+    Ok(list
+        .records
+        .iter()
+        .map(DirectoryEntry::from_record)
+        .collect())
+}
+
+/// Whether `query` names a whole local part followed by its `@`, the
+/// shortest thing [`search`] answers.
+pub fn is_address_prefix(query: &str) -> bool {
+    query
+        .split_once('@')
+        .is_some_and(|(local, _)| !local.is_empty())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{blocks::auth::repo::users, test_support::TestContext};
+
+    async fn seed(ctx: &TestContext, email: &str) -> users::UserRow {
+        users::insert(
+            ctx,
+            users::NewUser {
+                email: email.to_string(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".to_string(),
+            },
+        )
+        .await
+        .unwrap()
+    }
+
+    #[tokio::test]
+    async fn soft_deleted_and_disabled_accounts_are_not_listed() {
+        let ctx = TestContext::with_auth().await;
+        let live = seed(&ctx, "ana@example.com").await;
+        let gone = seed(&ctx, "ana@example.org").await;
+        let off = seed(&ctx, "ana@example.net").await;
+        db::soft_delete(&ctx, users::TABLE, &gone.id).await.unwrap();
+        let mut patch = std::collections::HashMap::new();
+        patch.insert("disabled".to_string(), json!(true));
+        db::update(&ctx, users::TABLE, &off.id, patch)
+            .await
+            .unwrap();
+
+        let found = search(&ctx, "ana@", 10).await.unwrap();
+        assert_eq!(
+            found,
+            vec![DirectoryEntry {
+                id: live.id.clone(),
+                email: "ana@example.com".to_string(),
+                display_name: String::new(),
+                avatar_url: String::new(),
+            }]
+        );
+        let found = find_by_ids(&ctx, &[live.id.as_str(), gone.id.as_str(), "nobody"])
+            .await
+            .unwrap();
+        assert_eq!(found.len(), 1);
+        assert_eq!(found[0].id, live.id);
+        // Share targeting resolves grantees through the same view.
+        assert!(find_by_email(&ctx, "ana@example.org")
+            .await
+            .unwrap()
+            .is_none());
+        assert!(find_by_id(&ctx, &gone.id).await.unwrap().is_none());
+        assert!(find_by_id(&ctx, &live.id).await.unwrap().is_some());
+    }
+
+    #[tokio::test]
+    async fn search_needs_the_whole_local_part() {
+        let ctx = TestContext::with_auth().await;
+        seed(&ctx, "ana@example.com").await;
+        seed(&ctx, "anabel@example.com").await;
+
+        for query in ["an", "ana", "example.com", "@example.com", "%@"] {
+            let found = search(&ctx, query, 10).await.unwrap();
+            assert!(found.is_empty(), "{query}");
+        }
+        for (query, email) in [
+            ("ANA@", "ana@example.com"),
+            ("anabel@exa", "anabel@example.com"),
+        ] {
+            let found = search(&ctx, query, 10).await.unwrap();
+            let emails: Vec<&str> = found.iter().map(|e| e.email.as_str()).collect();
+            assert_eq!(emails, [email], "{query}");
+        }
+    }
+}
@@ -0,0 +1,354 @@
+//! Row-level access over `suppers_ai__auth__invitations`.
+//!
+//! An admin invites an email address (`admin/invitations.rs`); the invitee
+//! registers through `POST /b/auth/api/signup` with the raw token, which is
+//! shown once and never stored — only its SHA-256 hex (`token_hash`) is.
+//! [`consume`] is the single-use gate: the `pending` → `accepted` flip is
+//! one conditional update, so two signups racing on the same token cannot
+//! both win.
+
+use std::collections::HashMap;
+
+use serde_json::{json, Value};
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database as db;
+use wafer_run::context::Context;
+
+use super::{map_opt_str, map_str, now_iso, RepoError};
+
+pub const TABLE: &str = "suppers_ai__auth__invitations";
+
+/// Issued and not yet used or revoked.
+pub const STATUS_PENDING: &str = "pending";
+/// Consumed by a signup.
+pub const STATUS_ACCEPTED: &str = "accepted";
+/// Withdrawn by an admin.
+pub const STATUS_REVOKED: &str = "revoked";
+/// Reported (never stored) for a pending row past `expires_at`.
+pub const STATUS_EXPIRED: &str = "expired";
+
+/// A loaded invitation. `token_hash` is deliberately not carried, so a row
+/// can be serialized straight to admin clients.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct InvitationRow {
+    pub id: String,
+    pub email: String,
+    pub role: String,
+    /// Stored status; see [`InvitationRow::effective_status`].
+    pub status: String,
+    pub invited_by: String,
+    pub accepted_by: String,
+    pub accepted_at: Option<String>,
+    pub expires_at: String,
+    pub created_at: String,
+}
+
+impl InvitationRow {
+    /// `status`, except a pending row past its expiry reports
+    /// [`STATUS_EXPIRED`]. `now` is an ISO-8601 timestamp, string-compared
+    /// like the column is written.
+    pub fn effective_status(&self, now: &str) -> &str {
+        if self.status == STATUS_PENDING && now > self.expires_at.as_str() {
+            STATUS_EXPIRED
+        } else {
+            &self.status
+        }
+    }
+}
+
+/// Insert payload for [`insert`].
+#[derive(Debug, Clone, Copy)]
+pub struct NewInvitation<'a> {
+    /// Lowercased invitee address.
+    pub email: &'a str,
+    pub token_hash: &'a str,
+    pub role: &'a str,
+    pub invited_by: &'a str,
+    pub expires_at: &'a str,
+}
+
+fn row_from_map(m: &HashMap<String, Value>) -> Result<InvitationRow, RepoError> {
+    Ok(InvitationRow {
+        id: map_opt_str(m, "id").ok_or_else(|| RepoError::Db("missing id".into()))?,
+        email: map_str(m, "email"),
+        role: map_str(m, "role"),
+        status: map_str(m, "status"),
+        invited_by: map_str(m, "invited_by"),
+        accepted_by: map_str(m, "accepted_by"),
+        accepted_at: map_opt_str(m, "accepted_at").filter(|s| !s.is_empty()),
+        expires_at: map_str(m, "expires_at"),
+        created_at: map_str(m, "created_at"),
+    })
+}
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.into(),
+        operator: FilterOp::Equal,
+        value: json!(value),
+    }
+}
+
+/// Insert a pending invitation and return it.
+pub async fn insert(ctx: &dyn Context, new: NewInvitation<'_>) -> Result<InvitationRow, RepoError> {
+    let now = now_iso();
+    let mut data: HashMap<String, Value> = HashMap::new();
+    data.insert("id".into(), json!(uuid::Uuid::now_v7().to_string()));
+    data.insert("email".into(), json!(new.email));
+    data.insert("token_hash".into(), json!(new.token_hash));
+    data.insert("role".into(), json!(new.role));
+    data.insert("status".into(), json!(STATUS_PENDING));
+    data.insert("invited_by".into(), json!(new.invited_by));
+    data.insert("expires_at".into(), json!(new.expires_at));
+    data.insert("created_at".into(), json!(now));
+    data.insert("updated_at".into(), json!(now));
+    let rec = db::create(ctx, TABLE, data)
+        .await
+        .map_err(|e| RepoError::Db(format!("invitations insert: {e}")))?;
+    row_from_map(&rec.data)
+}
+
+/// Newest invitations first, at most `limit`. `status` narrows the list by
+/// [`InvitationRow::effective_status`]: `pending` excludes expired rows and
+/// `expired` selects exactly those.
+pub async fn list(
+    ctx: &dyn Context,
+    status: Option<&str>,
+    limit: i64,
+) -> Result<Vec<InvitationRow>, RepoError> {
+    let now = now_iso();
+    let filters = match status {
+        Some(STATUS_PENDING) => vec![
+            eq("status", STATUS_PENDING),
+            Filter {
+                field: "expires_at".into(),
+                operator: FilterOp::GreaterEqual,
+                value: json!(now),
+            },
+        ],
+        Some(STATUS_EXPIRED) => vec![
+            eq("status", STATUS_PENDING),
+            Filter {
+                field: "expires_at".into(),
+                operator: FilterOp::LessThan,
+                value: json!(now),
+            },
+        ],
+        Some(other) => vec![eq("status", other)],
+        None => Vec::new(),
+    };
+    let opts = ListOptions {
+        filters,
+        sort: vec![SortField {
+            field: "created_at".into(),
+            desc: true,
+        }],
+        limit,
+        ..Default::default()
+    };
+    let list = db::list(ctx, TABLE, &opts)
+        .await
+        .map_err(|e| RepoError::Db(format!("invitations list: {e}")))?;
+    list.records.iter().map(|r| row_from_map(&r.data)).collect()
+}
+
+/// Look up an invitation by `id`. `Ok(None)` when missing.
+pub async fn find_by_id(ctx: &dyn Context, id: &str) -> Result<Option<InvitationRow>, RepoError> {
+    use wafer_block::ErrorCode;
+    match db::get(ctx, TABLE, id).await {
+        Ok(rec) => Ok(Some(row_from_map(&rec.data)?)),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(RepoError::Db(format!("invitations find_by_id: {e}"))),
+    }
+}
+
+/// Redeem the invitation whose token hashes to `token_hash` for `email`
+/// (lowercased). Returns the invitation when this call flipped it from
+/// pending to accepted; `Ok(None)` when the token is unknown, expired,
+/// already used or revoked, or was issued to a different address.
+///
+/// The flip is conditional on `status = 'pending'`, so of two concurrent
+/// redemptions exactly one gets the row back.
+pub async fn consume(
+    ctx: &dyn Context,
+    token_hash: &str,
+    email: &str,
+) -> Result<Option<InvitationRow>, RepoError> {
+    use wafer_block::ErrorCode;
+    let row = match db::get_by_field(ctx, TABLE, "token_hash", json!(token_hash)).await {
+        Ok(rec) => row_from_map(&rec.data)?,
+        Err(e) if e.code == ErrorCode::NotFound => return Ok(None),
+        Err(e) => return Err(RepoError::Db(format!("invitations lookup: {e}"))),
+    };
+    let now = now_iso();
+    if row.effective_status(&now) != STATUS_PENDING || !row.email.eq_ignore_ascii_case(email) {
+        return Ok(None);
+    }
+    let mut data: HashMap<String, Value> = HashMap::new();
+    data.insert("status".into(), json!(STATUS_ACCEPTED));
+    data.insert("accepted_at".into(), json!(now));
+    data.insert("updated_at".into(), json!(now));
+    let flipped = db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![eq("id", &row.id), eq("status", STATUS_PENDING)],
+        data,
+    )
+    .await
+    .map_err(|e| RepoError::Db(format!("invitations consume: {e}")))?;
+    if flipped != 1 {
+        return Ok(None);
+    }
+    Ok(Some(InvitationRow {
+        status: STATUS_ACCEPTED.to_string(),
+        accepted_at: Some(now),
+        ..row
+    }))
+}
+
+/// Record the account a consumed invitation created.
+pub async fn set_accepted_by(ctx: &dyn Context, id: &str, user_id: &str) -> Result<(), RepoError> {
+    let mut data: HashMap<String, Value> = HashMap::new();
+    data.insert("accepted_by".into(), json!(user_id));
+    data.insert("updated_at".into(), json!(now_iso()));
+    db::update(ctx, TABLE, id, data)
+        .await
+        .map_err(|e| RepoError::Db(format!("invitations set_accepted_by: {e}")))?;
+    Ok(())
+}
+
+/// Put a consumed invitation back to pending — the signup that consumed it
+/// failed before the account existed.
+pub async fn release(ctx: &dyn Context, id: &str) -> Result<(), RepoError> {
+    let mut data: HashMap<String, Value> = HashMap::new();
+    data.insert("status".into(), json!(STATUS_PENDING));
+    data.insert("accepted_at".into(), Value::Null);
+    data.insert("updated_at".into(), json!(now_iso()));
+    db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![eq("id", id), eq("status", STATUS_ACCEPTED)],
+        data,
+    )
+    .await
+    .map_err(|e| RepoError::Db(format!("invitations release: {e}")))?;
+    Ok(())
+}
+
+/// Revoke a pending invitation. `false` when it was not pending (already
+/// accepted or revoked).
+pub async fn revoke(ctx: &dyn Context, id: &str) -> Result<bool, RepoError> {
+    let mut data: HashMap<String, Value> = HashMap::new();
+    data.insert("status".into(), json!(STATUS_REVOKED));
+    data.insert("updated_at".into(), json!(now_iso()));
+    let n = db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![eq("id", id), eq("status", STATUS_PENDING)],
+        data,
+    )
+    .await
+    .map_err(|e| RepoError::Db(format!("invitations revoke: {e}")))?;
+    Ok(n == 1)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    fn iso_in(secs: i64) -> String {
+        crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::seconds(secs))
+    }
+
+    async fn invite(ctx: &dyn Context, hash: &str, expires_at: &str) -> InvitationRow {
+        insert(
+            ctx,
+            NewInvitation {
+                email: "new@example.com",
+                token_hash: hash,
+                role: "editor",
+                invited_by: "admin-1",
+                expires_at,
+            },
+        )
+        .await
+        .unwrap()
+    }
+
+    #[tokio::test]
+    async fn consume_is_single_use() {
+        let ctx = TestContext::with_auth().await;
+        invite(&ctx, "h1", &iso_in(3600)).await;
+
+        let first = consume(&ctx, "h1", "NEW@example.com").await.unwrap();
+        assert_eq!(first.map(|r| r.role), Some("editor".to_string()));
+        assert!(consume(&ctx, "h1", "new@example.com")
+            .await
+            .unwrap()
+            .is_none());
+    }
+
+    #[tokio::test]
+    async fn consume_rejects_wrong_email_expired_and_revoked() {
+        let ctx = TestContext::with_auth().await;
+        invite(&ctx, "h1", &iso_in(3600)).await;
+        assert!(consume(&ctx, "h1", "other@example.com")
+            .await
+            .unwrap()
+            .is_none());
+
+        invite(&ctx, "h2", &iso_in(-60)).await;
+        assert!(consume(&ctx, "h2", "new@example.com")
+            .await
+            .unwrap()
+            .is_none());
+
+        let row = invite(&ctx, "h3", &iso_in(3600)).await;
+        assert!(revoke(&ctx, &row.id).await.unwrap());
+        assert!(!revoke(&ctx, &row.id).await.unwrap());
+        assert!(consume(&ctx, "h3", "new@example.com")
+            .await
+            .unwrap()
+            .is_none());
+
+        assert!(consume(&ctx, "unknown", "new@example.com")
+            .await
+            .unwrap()
+            .is_none());
+    }
+
+    #[tokio::test]
+    async fn release_makes_the_token_redeemable_again() {
+        let ctx = TestContext::with_auth().await;
+        invite(&ctx, "h1", &iso_in(3600)).await;
+        let row = consume(&ctx, "h1", "new@example.com")
+            .await
+            .unwrap()
+            .unwrap();
+        release(&ctx, &row.id).await.unwrap();
+        assert!(consume(&ctx, "h1", "new@example.com")
+            .await
+            .unwrap()
+            .is_some());
+    }
+
+    #[tokio::test]
+    async fn list_separates_pending_from_expired() {
+        let ctx = TestContext::with_auth().await;
+        let live = invite(&ctx, "h1", &iso_in(3600)).await;
+        let stale = invite(&ctx, "h2", &iso_in(-60)).await;
+
+        let pending = list(&ctx, Some(STATUS_PENDING), 10).await.unwrap();
+        assert_eq!(
+            pending.iter().map(|r| &r.id).collect::<Vec<_>>(),
+            vec![&live.id]
+        );
+        let expired = list(&ctx, Some(STATUS_EXPIRED), 10).await.unwrap();
+        assert_eq!(
+            expired.iter().map(|r| &r.id).collect::<Vec<_>>(),
+            vec![&stale.id]
+        );
+        assert_eq!(list(&ctx, None, 10).await.unwrap().len(), 2);
+    }
+}
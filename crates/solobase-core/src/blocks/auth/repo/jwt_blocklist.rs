@@ -100,7 +100,7 @@ mod tests {
 
     fn iso_plus_seconds(secs: i64) -> String {
         let dt = chrono::Utc::now() + chrono::Duration::seconds(secs);
-        dt.format("%Y-%m-%dT%H:%M:%SZ").to_string()
+        crate::util::format_rfc3339(dt)
     }
 
     #[tokio::test]
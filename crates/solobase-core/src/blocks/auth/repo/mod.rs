@@ -9,16 +9,18 @@
 //! timestamp writer ([`now_iso`]), hex decoding ([`decode_hex`]), and the
 //! `&HashMap<String, Value>` map accessors ([`map_str`]/[`map_opt_str`]/
 //! [`map_bool`]) — live here so all auth tables share one implementation. In
-//! particular [`now_iso`] is **the** timestamp writer for auth-table rows:
-//! keeping a single `…Z` formatter stops the documented `Z`/`+00:00`
-//! intermixing (see `service::is_expired`) from growing.
+//! particular [`now_iso`] is **the** timestamp writer for auth-table rows; it
+//! delegates to the crate-wide [`crate::util::format_rfc3339`] layout.
 
 use std::collections::HashMap;
 
 use serde_json::Value;
 
 pub mod api_keys;
+pub mod bootstrap_state;
 pub mod bootstrap_tokens;
+pub mod directory;
+pub mod invitations;
 pub mod jwt_blocklist;
 pub mod local_credentials;
 pub mod oauth_pkce;
@@ -42,16 +44,16 @@ pub enum RepoError {
     Db(String),
 }
 
-/// Current UTC time as an ISO-8601 string with a literal `Z` suffix
-/// (`%Y-%m-%dT%H:%M:%SZ`).
+/// Current UTC time for auth-table rows — [`crate::util::now_rfc3339`], so
+/// auth rows share the crate-wide `…Z` layout.
 ///
-/// This is the single timestamp writer for every auth table. Using one
-/// formatter everywhere keeps stored timestamps in one format so the
-/// string-comparison cleanup queries (e.g. `sessions::delete_expired`'s
-/// `expires_at < cutoff`) stay correct, and stops the historical
-/// `Z`-vs-`+00:00` intermixing documented in `service::is_expired`.
+/// Using one formatter everywhere keeps stored timestamps in one format so
+/// the string-comparison cleanup queries (e.g. `sessions::delete_expired`'s
+/// `expires_at < cutoff`) stay correct. Expiry columns computed from a
+/// future instant go through [`crate::util::format_rfc3339`] for the same
+/// reason.
 pub(crate) fn now_iso() -> String {
-    chrono::Utc::now().format("%Y-%m-%dT%H:%M:%SZ").to_string()
+    crate::util::now_rfc3339()
 }
 
 /// Decode a lowercase hex string into raw bytes. Returns `None` for an
@@ -28,7 +28,7 @@ pub struct NewPkceState<'a> {
     pub provider: &'a str,
     pub code_verifier: &'a str,
     pub redirect_uri: &'a str,
-    /// Absolute expiry time as ISO-8601 (`%Y-%m-%dT%H:%M:%SZ`).
+    /// Absolute expiry time, written with [`crate::util::format_rfc3339`].
     pub expires_at: &'a str,
 }
 
@@ -129,7 +129,7 @@ mod tests {
 
     fn iso_plus_seconds(secs: i64) -> String {
         let dt = chrono::Utc::now() + chrono::Duration::seconds(secs);
-        dt.format("%Y-%m-%dT%H:%M:%SZ").to_string()
+        crate::util::format_rfc3339(dt)
     }
 
     #[tokio::test]
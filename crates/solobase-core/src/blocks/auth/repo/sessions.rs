@@ -41,6 +41,22 @@ pub struct SessionRow {
     pub created_at: String,
     pub last_used_at: String,
     pub expires_at: String,
+    /// Refresh-token family the session was issued with; empty for rows
+    /// written before migration 013.
+    pub family: String,
+    /// Device fingerprint (`auth::devices`); empty when unknown.
+    pub device_info: String,
+    /// "Firefox on Linux · 203.0.113.0/24"; empty when unknown.
+    pub device_label: String,
+}
+
+/// The login a session row came from: its refresh family and device.
+/// `Default` (all empty) for issuance paths that don't know the device.
+#[derive(Debug, Clone, Default)]
+pub struct SessionOrigin {
+    pub family: String,
+    pub device_info: String,
+    pub device_label: String,
 }
 
 #[derive(Debug, Clone)]
@@ -79,6 +95,9 @@ fn row_from_map(m: &HashMap<String, Value>) -> Result<SessionRow, RepoError> {
         created_at: map_str(m, "created_at"),
         last_used_at: map_str(m, "last_used_at"),
         expires_at: map_str(m, "expires_at"),
+        family: map_str(m, "family"),
+        device_info: map_str(m, "device_info"),
+        device_label: map_str(m, "device_label"),
     })
 }
 
@@ -98,8 +117,19 @@ fn row_to_data(new: &NewSession, now: &str) -> HashMap<String, Value> {
 /// Each call creates a new row — sessions are not idempotent, every login or
 /// session-issuance call gets a fresh row keyed by its own `token_hash`.
 pub async fn insert(ctx: &dyn Context, new: NewSession) -> Result<(), RepoError> {
+    insert_from(ctx, new, &SessionOrigin::default()).await
+}
+
+async fn insert_from(
+    ctx: &dyn Context,
+    new: NewSession,
+    origin: &SessionOrigin,
+) -> Result<(), RepoError> {
     let now = now_iso();
-    let data = row_to_data(&new, &now);
+    let mut data = row_to_data(&new, &now);
+    data.insert("family".into(), json!(origin.family));
+    data.insert("device_info".into(), json!(origin.device_info));
+    data.insert("device_label".into(), json!(origin.device_label));
     db::create(ctx, TABLE, data)
         .await
         .map_err(|e| RepoError::Db(format!("insert session: {e}")))?;
@@ -108,7 +138,7 @@ pub async fn insert(ctx: &dyn Context, new: NewSession) -> Result<(), RepoError>
 
 /// Convenience wrapper for the JWT login path: hashes nothing (the caller
 /// already computed `token_hash`), defaults `expires_at` to the access-token
-/// lifetime in days, and forwards to [`insert`].
+/// lifetime in days, and records where the login came from.
 ///
 /// Naming mirrors `delete_for_user`/`list_for_user` for symmetry.
 pub async fn create_for_user(
@@ -116,17 +146,19 @@ pub async fn create_for_user(
     user_id: &str,
     token_hash: Vec<u8>,
     lifetime_days: u32,
+    origin: &SessionOrigin,
 ) -> Result<(), RepoError> {
-    let expires_at = (chrono::Utc::now() + chrono::Duration::days(lifetime_days as i64))
-        .format("%Y-%m-%dT%H:%M:%SZ")
-        .to_string();
-    insert(
+    let expires_at = crate::util::format_rfc3339(
+        crate::clock::now() + chrono::Duration::days(lifetime_days as i64),
+    );
+    insert_from(
         ctx,
         NewSession {
             token_hash,
             user_id: user_id.to_string(),
             expires_at,
         },
+        origin,
     )
     .await
 }
@@ -248,6 +280,46 @@ pub async fn delete_for_user(
     Ok(1)
 }
 
+/// Delete every session `user_id` holds in refresh `family` — all the
+/// access tokens one device was issued across its rotations. Returns the
+/// number deleted.
+pub async fn delete_family_for_user(
+    ctx: &dyn Context,
+    user_id: &str,
+    family: &str,
+) -> Result<u64, RepoError> {
+    let filters = vec![
+        Filter {
+            field: "user_id".into(),
+            operator: FilterOp::Equal,
+            value: json!(user_id),
+        },
+        Filter {
+            field: "family".into(),
+            operator: FilterOp::Equal,
+            value: json!(family),
+        },
+    ];
+    let n = db::delete_by_filters_count(ctx, TABLE, filters)
+        .await
+        .map_err(|e| RepoError::Db(format!("session delete_family_for_user: {e}")))?;
+    Ok(n.max(0) as u64)
+}
+
+/// Delete every session `user_id` holds, on every device. Returns the
+/// number deleted.
+pub async fn delete_all_for_user(ctx: &dyn Context, user_id: &str) -> Result<u64, RepoError> {
+    let filters = vec![Filter {
+        field: "user_id".into(),
+        operator: FilterOp::Equal,
+        value: json!(user_id),
+    }];
+    let n = db::delete_by_filters_count(ctx, TABLE, filters)
+        .await
+        .map_err(|e| RepoError::Db(format!("session delete_all_for_user: {e}")))?;
+    Ok(n.max(0) as u64)
+}
+
 #[cfg(test)]
 mod tests_phase_4 {
     use super::*;
@@ -360,7 +432,7 @@ mod tests_phase_4 {
     async fn create_for_user_writes_visible_row() {
         let ctx = TestContext::with_auth().await;
         seed_user(&ctx, "user-a").await;
-        create_for_user(&ctx, "user-a", vec![0x42; 32], 1)
+        create_for_user(&ctx, "user-a", vec![0x42; 32], 1, &SessionOrigin::default())
             .await
             .unwrap();
         let rows = list_for_user(&ctx, "user-a").await.unwrap();
@@ -369,4 +441,44 @@ mod tests_phase_4 {
         // `expires_at` is one day in the future — strictly greater than now.
         assert!(rows[0].expires_at.as_str() > now_iso().as_str());
     }
+
+    /// Rows from one refresh family (a device's rotations) go together;
+    /// other families and other users' rows stay.
+    #[tokio::test]
+    async fn delete_family_for_user_removes_one_device() {
+        let ctx = TestContext::with_auth().await;
+        for user_id in ["user-a", "user-b"] {
+            seed_user(&ctx, user_id).await;
+        }
+        let origin = |family: &str| SessionOrigin {
+            family: family.into(),
+            device_info: format!("fp-{family}"),
+            device_label: "Firefox on Linux · 203.0.113.0/24".into(),
+        };
+        create_for_user(&ctx, "user-a", vec![0x01; 32], 1, &origin("laptop"))
+            .await
+            .unwrap();
+        create_for_user(&ctx, "user-a", vec![0x02; 32], 1, &origin("laptop"))
+            .await
+            .unwrap();
+        create_for_user(&ctx, "user-a", vec![0x03; 32], 1, &origin("phone"))
+            .await
+            .unwrap();
+        create_for_user(&ctx, "user-b", vec![0x04; 32], 1, &origin("laptop"))
+            .await
+            .unwrap();
+
+        assert_eq!(
+            delete_family_for_user(&ctx, "user-a", "laptop")
+                .await
+                .unwrap(),
+            2
+        );
+        let left = list_for_user(&ctx, "user-a").await.unwrap();
+        assert_eq!(left.len(), 1);
+        assert_eq!(left[0].family, "phone");
+        assert_eq!(left[0].device_info, "fp-phone");
+        assert_eq!(left[0].device_label, "Firefox on Linux · 203.0.113.0/24");
+        assert_eq!(list_for_user(&ctx, "user-b").await.unwrap().len(), 1);
+    }
 }
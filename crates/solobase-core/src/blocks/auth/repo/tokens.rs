@@ -15,7 +15,7 @@
 //!
 //! See `migrations/003_refresh_tokens.{sqlite,postgres}.sql` for the schema.
 
-use std::collections::HashMap;
+use std::collections::{HashMap, HashSet};
 
 use serde_json::{json, Value};
 use wafer_block::db::{Filter, FilterOp};
@@ -37,7 +37,8 @@ pub fn hash(raw_token: &str) -> String {
 }
 
 /// Insert a fresh refresh-token row at `generation` (0 for the first token
-/// in a new family, `prev_generation + 1` on rotation).
+/// in a new family, `prev_generation + 1` on rotation). `device_info` is the
+/// issuing device's fingerprint (`auth::devices`), empty when unknown.
 pub async fn insert(
     ctx: &dyn Context,
     user_id: &str,
@@ -45,6 +46,7 @@ pub async fn insert(
     family: &str,
     generation: i64,
     expires_at: &str,
+    device_info: &str,
 ) -> Result<(), RepoError> {
     let id = uuid::Uuid::now_v7().to_string();
     let mut data: HashMap<String, Value> = HashMap::new();
@@ -58,6 +60,7 @@ pub async fn insert(
     // keeps `created_at` consistent with every other auth repo module.
     data.insert("created_at".into(), json!(now_iso()));
     data.insert("expires_at".into(), json!(expires_at));
+    data.insert("device_info".into(), json!(device_info));
 
     db::create(ctx, TABLE, data)
         .await
@@ -117,6 +120,29 @@ pub async fn family_has_live_row(ctx: &dyn Context, family: &str) -> Result<bool
     Ok(!records.is_empty())
 }
 
+/// Every device fingerprint on `user_id`'s refresh-token rows, live or
+/// revoked: the devices the account has signed in from before. Rows minted
+/// without a device (signup, bootstrap) are skipped.
+pub async fn devices_for_user(
+    ctx: &dyn Context,
+    user_id: &str,
+) -> Result<HashSet<String>, RepoError> {
+    let filters = vec![Filter {
+        field: "user_id".into(),
+        operator: FilterOp::Equal,
+        value: json!(user_id),
+    }];
+    let records = db::list_all(ctx, TABLE, filters)
+        .await
+        .map_err(|e| RepoError::Db(format!("tokens devices_for_user: {e}")))?;
+    Ok(records
+        .iter()
+        .map(|r| r.str_field("device_info"))
+        .filter(|d| !d.is_empty())
+        .map(str::to_string)
+        .collect())
+}
+
 /// Mark a single row as revoked.
 pub async fn revoke_by_id(ctx: &dyn Context, id: &str) -> Result<(), RepoError> {
     let mut data: HashMap<String, Value> = HashMap::new();
@@ -184,9 +210,7 @@ mod tests {
     use crate::test_support::TestContext;
 
     fn future_iso(secs: i64) -> String {
-        (chrono::Utc::now() + chrono::Duration::seconds(secs))
-            .format("%Y-%m-%dT%H:%M:%SZ")
-            .to_string()
+        crate::util::format_rfc3339(crate::clock::now() + chrono::Duration::seconds(secs))
     }
 
     async fn seed_user(ctx: &TestContext, id: &str, email: &str) {
@@ -206,7 +230,7 @@ mod tests {
     async fn insert_then_find_by_token_round_trips() {
         let ctx = TestContext::with_auth().await;
         seed_user(&ctx, "user-1", "u1@example.com").await;
-        insert(&ctx, "user-1", "raw-jwt", "fam-1", 0, &future_iso(3600))
+        insert(&ctx, "user-1", "raw-jwt", "fam-1", 0, &future_iso(3600), "")
             .await
             .unwrap();
 
@@ -223,7 +247,7 @@ mod tests {
         let ctx = TestContext::with_auth().await;
         seed_user(&ctx, "user-1", "u1@example.com").await;
         let raw = "secret-refresh-token-do-not-store";
-        insert(&ctx, "user-1", raw, "fam-1", 0, &future_iso(3600))
+        insert(&ctx, "user-1", raw, "fam-1", 0, &future_iso(3600), "")
             .await
             .unwrap();
 
@@ -245,12 +269,12 @@ mod tests {
         // v0 + insert v1 under the same family with generation+1.
         let ctx = TestContext::with_auth().await;
         seed_user(&ctx, "user-1", "u1@example.com").await;
-        insert(&ctx, "user-1", "tok-v0", "fam-1", 0, &future_iso(3600))
+        insert(&ctx, "user-1", "tok-v0", "fam-1", 0, &future_iso(3600), "")
             .await
             .unwrap();
         let old = find_by_token(&ctx, "tok-v0").await.unwrap().unwrap();
         revoke_by_id(&ctx, &old.id).await.unwrap();
-        insert(&ctx, "user-1", "tok-v1", "fam-1", 1, &future_iso(3600))
+        insert(&ctx, "user-1", "tok-v1", "fam-1", 1, &future_iso(3600), "")
             .await
             .unwrap();
 
@@ -273,12 +297,12 @@ mod tests {
         // a live family — the handler's response is to revoke the family.
         let ctx = TestContext::with_auth().await;
         seed_user(&ctx, "user-1", "u1@example.com").await;
-        insert(&ctx, "user-1", "tok-v0", "fam-1", 0, &future_iso(3600))
+        insert(&ctx, "user-1", "tok-v0", "fam-1", 0, &future_iso(3600), "")
             .await
             .unwrap();
         let old = find_by_token(&ctx, "tok-v0").await.unwrap().unwrap();
         revoke_by_id(&ctx, &old.id).await.unwrap();
-        insert(&ctx, "user-1", "tok-v1", "fam-1", 1, &future_iso(3600))
+        insert(&ctx, "user-1", "tok-v1", "fam-1", 1, &future_iso(3600), "")
             .await
             .unwrap();
 
@@ -298,10 +322,10 @@ mod tests {
     async fn revoke_all_for_user_invalidates_every_family() {
         let ctx = TestContext::with_auth().await;
         seed_user(&ctx, "user-1", "u1@example.com").await;
-        insert(&ctx, "user-1", "tok-a", "fam-a", 0, &future_iso(3600))
+        insert(&ctx, "user-1", "tok-a", "fam-a", 0, &future_iso(3600), "")
             .await
             .unwrap();
-        insert(&ctx, "user-1", "tok-b", "fam-b", 0, &future_iso(3600))
+        insert(&ctx, "user-1", "tok-b", "fam-b", 0, &future_iso(3600), "")
             .await
             .unwrap();
 
@@ -310,4 +334,51 @@ mod tests {
         assert!(!family_has_live_row(&ctx, "fam-a").await.unwrap());
         assert!(!family_has_live_row(&ctx, "fam-b").await.unwrap());
     }
+
+    #[tokio::test]
+    async fn devices_for_user_collects_fingerprints_across_families() {
+        let ctx = TestContext::with_auth().await;
+        seed_user(&ctx, "user-1", "u1@example.com").await;
+        seed_user(&ctx, "user-2", "u2@example.com").await;
+        for (user, token, device) in [
+            ("user-1", "tok-a", "laptop"),
+            ("user-1", "tok-b", "phone"),
+            ("user-1", "tok-c", ""),
+            ("user-2", "tok-d", "tablet"),
+        ] {
+            let family = token.replace("tok", "fam");
+            insert(&ctx, user, token, &family, 0, &future_iso(3600), device)
+                .await
+                .unwrap();
+        }
+        revoke_family(&ctx, "fam-a").await.unwrap();
+
+        let devices = devices_for_user(&ctx, "user-1").await.unwrap();
+        assert_eq!(
+            devices,
+            HashSet::from(["laptop".to_string(), "phone".to_string()]),
+            "revoked rows still count as known; device-less rows don't"
+        );
+    }
+
+    #[tokio::test]
+    async fn token_lookups_read_through_their_indexes() {
+        let ctx = TestContext::with_auth().await;
+        // `find_by_token`: every refresh.
+        crate::test_support::assert_uses_index(
+            &ctx,
+            &format!("SELECT * FROM {TABLE} WHERE token_hash = ?"),
+            &[json!(hash("tok"))],
+            "suppers_ai__auth__tokens_token_hash_uniq",
+        )
+        .await;
+        // `family_has_live_row`: reuse detection.
+        crate::test_support::assert_uses_index(
+            &ctx,
+            &format!("SELECT * FROM {TABLE} WHERE family = ? AND revoked = ?"),
+            &[json!("fam-1"), json!(false)],
+            "suppers_ai__auth__tokens_family_idx",
+        )
+        .await;
+    }
 }
@@ -1,16 +1,63 @@
 //! Row-level access over `suppers_ai__auth__users`.
+//!
+//! A soft-deleted account (`deleted_at` set, see [`UserRow::is_deleted`])
+//! keeps its row until the `deleted_users` retention policy purges it
+//! ([`purge`]). Lookups that act for the account — profile and session
+//! endpoints, password resets, token checks — go through
+//! [`find_active_by_id`] / [`find_active_by_email`] / [`is_active`], which
+//! treat a disabled or soft-deleted account as missing. The unfiltered
+//! [`find_by_id`] / [`find_by_email`] are for the paths that must see the
+//! row anyway: login and refresh (uniform failure responses), signup
+//! (a soft-deleted address stays taken until the purge) and admin tools.
 
-use std::collections::HashMap;
+use std::{collections::HashMap, time::Duration};
 
 use serde_json::{json, Value};
 use uuid::Uuid;
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
 use wafer_core::clients::database as db;
 use wafer_run::context::Context;
 
 use super::{map_bool, map_opt_str, map_str, now_iso, RepoError};
+use crate::cache::{self, LruCache};
 
 pub const TABLE: &str = "suppers_ai__auth__users";
 
+/// `kind` of an account belonging to a person.
+pub const KIND_USER: &str = "user";
+/// `kind` of a non-interactive principal (migration 015): no password, no
+/// email, authenticated only by its API keys.
+pub const KIND_SERVICE_ACCOUNT: &str = "service_account";
+/// Prefix of every service-account id, so request and audit logs can tell
+/// the two kinds apart from the id alone (see [`actor_type`]).
+pub const SERVICE_ACCOUNT_ID_PREFIX: &str = "sa_";
+/// Domain of the placeholder address a service account is created with.
+/// `.invalid` is reserved (RFC 2606): nothing is ever delivered there.
+pub const SERVICE_ACCOUNT_EMAIL_DOMAIN: &str = "service-accounts.invalid";
+
+/// The actor type recorded next to `user_id` in request and audit logs:
+/// `""` for anonymous, [`KIND_SERVICE_ACCOUNT`] for a service-account id,
+/// [`KIND_USER`] otherwise.
+pub fn actor_type(user_id: &str) -> &'static str {
+    if user_id.is_empty() {
+        ""
+    } else if user_id.starts_with(SERVICE_ACCOUNT_ID_PREFIX) {
+        KIND_SERVICE_ACCOUNT
+    } else {
+        KIND_USER
+    }
+}
+
+/// List filter that keeps people and drops service accounts — user
+/// listings and counts show the two separately.
+pub fn people_only() -> Filter {
+    Filter {
+        field: "kind".into(),
+        operator: FilterOp::Equal,
+        value: json!(KIND_USER),
+    }
+}
+
 #[derive(Debug, Clone, PartialEq, Eq)]
 pub struct UserRow {
     pub id: String,
@@ -24,6 +71,16 @@ pub struct UserRow {
     /// but must not authenticate.
     pub deleted_at: Option<String>,
     pub email_verified: bool,
+    /// Set on the env-bootstrapped admin (migration 009): until the password
+    /// is changed, only the change-password and logout routes are reachable.
+    pub must_change_password: bool,
+    /// Opted out of new sign-in emails (migration 013).
+    pub mute_login_alerts: bool,
+    /// [`KIND_USER`] or [`KIND_SERVICE_ACCOUNT`] (migration 015).
+    pub kind: String,
+    /// A service account's rate-limit overrides, as a JSON object of
+    /// `RATE_LIMIT_*`-style values keyed by category. `"{}"` for people.
+    pub rate_limits: String,
     pub created_at: String,
     pub updated_at: String,
 }
@@ -41,6 +98,11 @@ impl UserRow {
     pub fn is_active(&self) -> bool {
         !self.disabled && !self.is_deleted()
     }
+
+    /// True for a service account, which must never sign in interactively.
+    pub fn is_service_account(&self) -> bool {
+        self.kind == KIND_SERVICE_ACCOUNT
+    }
 }
 
 #[derive(Debug, Clone)]
@@ -51,7 +113,7 @@ pub struct NewUser {
     pub role: String,
 }
 
-fn row_from_map(m: &HashMap<String, Value>) -> Result<UserRow, RepoError> {
+pub(crate) fn row_from_map(m: &HashMap<String, Value>) -> Result<UserRow, RepoError> {
     Ok(UserRow {
         id: map_opt_str(m, "id").ok_or_else(|| RepoError::Db("missing id".into()))?,
         email: map_opt_str(m, "email").ok_or_else(|| RepoError::Db("missing email".into()))?,
@@ -61,6 +123,10 @@ fn row_from_map(m: &HashMap<String, Value>) -> Result<UserRow, RepoError> {
         disabled: map_bool(m, "disabled"),
         deleted_at: map_opt_str(m, "deleted_at"),
         email_verified: map_bool(m, "email_verified"),
+        must_change_password: map_bool(m, "must_change_password"),
+        mute_login_alerts: map_bool(m, "mute_login_alerts"),
+        kind: map_opt_str(m, "kind").unwrap_or_else(|| KIND_USER.into()),
+        rate_limits: map_opt_str(m, "rate_limits").unwrap_or_else(|| "{}".into()),
         created_at: map_str(m, "created_at"),
         updated_at: map_str(m, "updated_at"),
     })
@@ -87,6 +153,39 @@ pub async fn insert(ctx: &dyn Context, new: NewUser) -> Result<UserRow, RepoErro
     row_from_map(&rec.data)
 }
 
+/// Insert a service account named `name`. Its id carries
+/// [`SERVICE_ACCOUNT_ID_PREFIX`]; its address is a placeholder under
+/// [`SERVICE_ACCOUNT_EMAIL_DOMAIN`], marked verified so no confirmation
+/// gate ever waits on it. No credentials are created — the caller issues
+/// API keys.
+pub async fn insert_service_account(
+    ctx: &dyn Context,
+    name: &str,
+    rate_limits: &str,
+) -> Result<UserRow, RepoError> {
+    let id = format!("{SERVICE_ACCOUNT_ID_PREFIX}{}", Uuid::now_v7());
+    let now = now_iso();
+    let mut data: HashMap<String, Value> = HashMap::new();
+    data.insert("id".into(), json!(id));
+    data.insert(
+        "email".into(),
+        json!(format!("{id}@{SERVICE_ACCOUNT_EMAIL_DOMAIN}")),
+    );
+    data.insert("display_name".into(), json!(name));
+    data.insert("name".into(), json!(name));
+    data.insert("role".into(), json!("user"));
+    data.insert("email_verified".into(), json!(true));
+    data.insert("kind".into(), json!(KIND_SERVICE_ACCOUNT));
+    data.insert("rate_limits".into(), json!(rate_limits));
+    data.insert("created_at".into(), json!(now));
+    data.insert("updated_at".into(), json!(now));
+
+    let rec = db::create(ctx, TABLE, data)
+        .await
+        .map_err(|e| RepoError::Db(format!("insert service account: {e}")))?;
+    row_from_map(&rec.data)
+}
+
 pub async fn find_by_email(ctx: &dyn Context, email: &str) -> Result<Option<UserRow>, RepoError> {
     use wafer_block::ErrorCode;
     match db::get_by_field(ctx, TABLE, "email", json!(email)).await {
@@ -116,6 +215,133 @@ pub async fn find_by_id(ctx: &dyn Context, id: &str) -> Result<Option<UserRow>,
     }
 }
 
+/// [`find_by_id`], with a disabled or soft-deleted account treated as
+/// missing.
+pub async fn find_active_by_id(ctx: &dyn Context, id: &str) -> Result<Option<UserRow>, RepoError> {
+    Ok(find_by_id(ctx, id).await?.filter(UserRow::is_active))
+}
+
+/// [`find_by_email`], with a disabled or soft-deleted account treated as
+/// missing.
+pub async fn find_active_by_email(
+    ctx: &dyn Context,
+    email: &str,
+) -> Result<Option<UserRow>, RepoError> {
+    Ok(find_by_email(ctx, email).await?.filter(UserRow::is_active))
+}
+
+/// Whether `id` names an account that may authenticate: it exists and is
+/// neither disabled nor soft-deleted. Checked on every authenticated
+/// request, so the answer is cached for [`ACTIVE_TTL`]; writers to this
+/// table call `cache::invalidate_table(TABLE)`, so a disable or delete
+/// takes effect on the next request.
+pub async fn is_active(ctx: &dyn Context, id: &str) -> Result<bool, RepoError> {
+    ACTIVE
+        .get_or_try_load(id.to_string(), || load_is_active(ctx, id))
+        .await
+}
+
+async fn load_is_active(ctx: &dyn Context, id: &str) -> Result<bool, RepoError> {
+    Ok(find_by_id(ctx, id)
+        .await?
+        .is_some_and(|user| user.is_active()))
+}
+
+/// Longest a cached [`is_active`] answer is trusted when an invalidation
+/// was missed (another instance's write).
+pub const ACTIVE_TTL: Duration = Duration::from_secs(30);
+
+static ACTIVE: LruCache<String, bool> =
+    LruCache::new("auth.active_users", &[TABLE], 4096, ACTIVE_TTL);
+
+/// Up to `limit` soft-deleted accounts whose `deleted_at` is before
+/// `cutoff` (RFC 3339), longest-deleted first — the `deleted_users`
+/// retention policy's candidates.
+pub async fn list_deleted_before(
+    ctx: &dyn Context,
+    cutoff: &str,
+    limit: i64,
+) -> Result<Vec<UserRow>, RepoError> {
+    let opts = ListOptions {
+        filters: vec![
+            Filter {
+                field: "deleted_at".into(),
+                operator: FilterOp::IsNotNull,
+                value: Value::Null,
+            },
+            Filter {
+                field: "deleted_at".into(),
+                operator: FilterOp::NotEqual,
+                value: json!(""),
+            },
+            Filter {
+                field: "deleted_at".into(),
+                operator: FilterOp::LessThan,
+                value: json!(cutoff),
+            },
+        ],
+        sort: vec![SortField {
+            field: "deleted_at".into(),
+            desc: false,
+        }],
+        limit,
+        skip_count: true,
+        ..Default::default()
+    };
+    let list = db::list(ctx, TABLE, &opts)
+        .await
+        .map_err(|e| RepoError::Db(format!("list deleted: {e}")))?;
+    list.records.iter().map(|r| row_from_map(&r.data)).collect()
+}
+
+/// Hard-delete `user_id` and the rows of theirs this block owns:
+/// credentials, provider links, sessions, refresh tokens, PATs and API
+/// keys. Org claims stay, unowned. Blocklisted JWT ids stay until they
+/// expire, so a revoked token can't come back. Other blocks' rows (roles,
+/// storage) are the caller's. Idempotent.
+pub async fn purge(ctx: &dyn Context, user_id: &str) -> Result<(), RepoError> {
+    let by_user = || {
+        vec![Filter {
+            field: "user_id".into(),
+            operator: FilterOp::Equal,
+            value: json!(user_id),
+        }]
+    };
+    for table in [
+        super::sessions::TABLE,
+        super::tokens::TABLE,
+        super::pats::TABLE,
+        super::api_keys::TABLE,
+        super::provider_links::TABLE,
+        super::local_credentials::TABLE,
+    ] {
+        db::delete_by_filters(ctx, table, by_user())
+            .await
+            .map_err(|e| RepoError::Db(format!("purge {table}: {e}")))?;
+    }
+    let mut unowned: HashMap<String, Value> = HashMap::new();
+    unowned.insert("owner_user_id".into(), Value::Null);
+    db::update_by_filters(
+        ctx,
+        super::orgs::TABLE,
+        vec![Filter {
+            field: "owner_user_id".into(),
+            operator: FilterOp::Equal,
+            value: json!(user_id),
+        }],
+        unowned,
+    )
+    .await
+    .map_err(|e| RepoError::Db(format!("purge orgs: {e}")))?;
+    match db::delete(ctx, TABLE, user_id).await {
+        Ok(()) => {}
+        Err(e) if e.code == wafer_block::ErrorCode::NotFound => {}
+        Err(e) => return Err(RepoError::Db(format!("purge user: {e}"))),
+    }
+    cache::invalidate_table(TABLE);
+    Ok(())
+}
+
 /// Read the `email_verified` flag for a user. Returns `Ok(false)` when the
 /// user row is missing (the doc-claim path) and propagates other DB errors.
 ///
@@ -154,6 +380,50 @@ pub async fn set_email_verified(
     Ok(())
 }
 
+/// Read the `must_change_password` flag for a user. `Ok(false)` when the row
+/// is missing; other DB errors propagate so the request gate can fail closed.
+pub async fn must_change_password(ctx: &dyn Context, user_id: &str) -> Result<bool, RepoError> {
+    use wafer_block::ErrorCode;
+
+    use crate::util::RecordExt;
+
+    match db::get(ctx, TABLE, user_id).await {
+        Ok(r) => Ok(r.bool_field("must_change_password")),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(false),
+        Err(e) => Err(RepoError::Db(format!("get user {user_id}: {e}"))),
+    }
+}
+
+/// Set or clear the `must_change_password` flag. Stamps `updated_at`.
+pub async fn set_must_change_password(
+    ctx: &dyn Context,
+    user_id: &str,
+    required: bool,
+) -> Result<(), RepoError> {
+    let mut data = std::collections::HashMap::new();
+    data.insert("must_change_password".to_string(), json!(required));
+    data.insert("updated_at".to_string(), json!(now_iso()));
+    db::update(ctx, TABLE, user_id, data)
+        .await
+        .map_err(|e| RepoError::Db(format!("set must_change_password for {user_id}: {e}")))?;
+    Ok(())
+}
+
+/// Turn new sign-in emails off (`muted`) or back on. Stamps `updated_at`.
+pub async fn set_mute_login_alerts(
+    ctx: &dyn Context,
+    user_id: &str,
+    muted: bool,
+) -> Result<(), RepoError> {
+    let mut data = std::collections::HashMap::new();
+    data.insert("mute_login_alerts".to_string(), json!(muted));
+    data.insert("updated_at".to_string(), json!(now_iso()));
+    db::update(ctx, TABLE, user_id, data)
+        .await
+        .map_err(|e| RepoError::Db(format!("set mute_login_alerts for {user_id}: {e}")))?;
+    Ok(())
+}
+
 /// Find a user by the SHA-256 hex of their email-verification token.
 ///
 /// The `verification_token` column stores `sha256_hex(raw)`; callers hash the
@@ -309,6 +579,173 @@ pub async fn update_profile(
     row_from_map(&rec.data)
 }
 
+/// The self-service profile columns added by migration 014, beside the
+/// [`UserRow`] they belong to.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Profile {
+    pub user: UserRow,
+    pub first_name: String,
+    pub last_name: String,
+    pub phone: String,
+    pub location: String,
+    /// Always a JSON object; the client-writable part sits under `"user"`.
+    pub metadata: Value,
+    /// Address awaiting confirmation by an email change; empty when none.
+    pub pending_email: String,
+}
+
+/// Profile columns to write; `None` leaves a column untouched.
+#[derive(Debug, Clone, Default)]
+pub struct ProfileUpdate {
+    pub first_name: Option<String>,
+    pub last_name: Option<String>,
+    pub display_name: Option<String>,
+    pub phone: Option<String>,
+    pub location: Option<String>,
+    pub metadata: Option<Value>,
+}
+
+fn profile_from_map(m: &HashMap<String, Value>) -> Result<Profile, RepoError> {
+    // Stored as TEXT; a driver that hands back parsed JSON is taken as-is.
+    let metadata = match m.get("metadata") {
+        Some(Value::String(s)) => serde_json::from_str(s).unwrap_or(Value::Null),
+        Some(v) => v.clone(),
+        None => Value::Null,
+    };
+    Ok(Profile {
+        user: row_from_map(m)?,
+        first_name: map_str(m, "first_name"),
+        last_name: map_str(m, "last_name"),
+        phone: map_str(m, "phone"),
+        location: map_str(m, "location"),
+        metadata: if metadata.is_object() {
+            metadata
+        } else {
+            json!({})
+        },
+        pending_email: map_str(m, "pending_email"),
+    })
+}
+
+pub async fn find_profile(ctx: &dyn Context, user_id: &str) -> Result<Option<Profile>, RepoError> {
+    use wafer_block::ErrorCode;
+    match db::get(ctx, TABLE, user_id).await {
+        Ok(rec) => Ok(Some(profile_from_map(&rec.data)?)),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(RepoError::Db(format!("select profile: {e}"))),
+    }
+}
+
+/// Write the `Some` fields of `update` and return the refreshed profile.
+///
+/// `display_name` dual-writes the legacy `name` alias, as
+/// [`update_profile`] does. `metadata` replaces the whole stored object.
+/// Stamps `updated_at`.
+pub async fn update_profile_fields(
+    ctx: &dyn Context,
+    user_id: &str,
+    update: ProfileUpdate,
+) -> Result<Profile, RepoError> {
+    let mut data = std::collections::HashMap::new();
+    if let Some(n) = update.display_name {
+        data.insert("display_name".to_string(), json!(n));
+        data.insert("name".to_string(), json!(n));
+    }
+    for (column, value) in [
+        ("first_name", update.first_name),
+        ("last_name", update.last_name),
+        ("phone", update.phone),
+        ("location", update.location),
+    ] {
+        if let Some(v) = value {
+            data.insert(column.to_string(), json!(v));
+        }
+    }
+    if let Some(m) = update.metadata {
+        data.insert("metadata".to_string(), json!(m.to_string()));
+    }
+    data.insert("updated_at".to_string(), json!(now_iso()));
+    let rec = db::update(ctx, TABLE, user_id, data)
+        .await
+        .map_err(|e| RepoError::Db(format!("update profile fields for {user_id}: {e}")))?;
+    profile_from_map(&rec.data)
+}
+
+/// A user matched by their email-change token.
+#[derive(Debug, Clone)]
+pub struct EmailChangeUser {
+    pub id: String,
+    /// The address in use until the change completes.
+    pub email: String,
+    pub pending_email: String,
+    /// `email_change_expires` column value (ISO-8601), empty if unset.
+    pub expires: String,
+}
+
+/// Park `new_email` in `pending_email` with the SHA-256 hex of its
+/// confirmation token and the token's absolute expiry. Replaces any earlier
+/// pending change. Stamps `updated_at`.
+pub async fn set_email_change(
+    ctx: &dyn Context,
+    user_id: &str,
+    new_email: &str,
+    token_hash: &str,
+    expires_at: &str,
+) -> Result<(), RepoError> {
+    let mut data = std::collections::HashMap::new();
+    data.insert("pending_email".to_string(), json!(new_email));
+    data.insert("email_change_token".to_string(), json!(token_hash));
+    data.insert("email_change_expires".to_string(), json!(expires_at));
+    data.insert("updated_at".to_string(), json!(now_iso()));
+    db::update(ctx, TABLE, user_id, data)
+        .await
+        .map_err(|e| RepoError::Db(format!("set email change for {user_id}: {e}")))?;
+    Ok(())
+}
+
+/// Find a user by the SHA-256 hex of their email-change token. `Ok(None)`
+/// when no row matches.
+pub async fn find_by_email_change_token(
+    ctx: &dyn Context,
+    token_hash: &str,
+) -> Result<Option<EmailChangeUser>, RepoError> {
+    use wafer_block::ErrorCode;
+
+    use crate::util::RecordExt;
+
+    match db::get_by_field(ctx, TABLE, "email_change_token", json!(token_hash)).await {
+        Ok(rec) => Ok(Some(EmailChangeUser {
+            id: rec.id.clone(),
+            email: rec.str_field("email").to_string(),
+            pending_email: rec.str_field("pending_email").to_string(),
+            expires: rec.str_field("email_change_expires").to_string(),
+        })),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(RepoError::Db(format!("find by email_change_token: {e}"))),
+    }
+}
+
+/// Make `new_email` the account's address and clear the pending change.
+/// Opening the link sent there proves the address, so it is marked
+/// verified. Stamps `updated_at`.
+pub async fn complete_email_change(
+    ctx: &dyn Context,
+    user_id: &str,
+    new_email: &str,
+) -> Result<(), RepoError> {
+    let mut data = std::collections::HashMap::new();
+    data.insert("email".to_string(), json!(new_email));
+    data.insert("email_verified".to_string(), json!(true));
+    data.insert("pending_email".to_string(), json!(""));
+    data.insert("email_change_token".to_string(), json!(""));
+    data.insert("email_change_expires".to_string(), json!(""));
+    data.insert("updated_at".to_string(), json!(now_iso()));
+    db::update(ctx, TABLE, user_id, data)
+        .await
+        .map_err(|e| RepoError::Db(format!("complete email change for {user_id}: {e}")))?;
+    Ok(())
+}
+
 #[cfg(test)]
 mod email_verified_tests {
     use super::*;
@@ -369,6 +806,39 @@ mod email_verified_tests {
         // (verified separately by the per-backend integration tests).
         assert!(!is_email_verified(&ctx, "nonexistent").await.unwrap());
     }
+
+    #[tokio::test]
+    async fn must_change_password_defaults_off_and_round_trips() {
+        let ctx = TestContext::with_auth().await;
+        seed_user(&ctx, "user-a").await;
+        assert!(!must_change_password(&ctx, "user-a").await.unwrap());
+        set_must_change_password(&ctx, "user-a", true)
+            .await
+            .unwrap();
+        assert!(must_change_password(&ctx, "user-a").await.unwrap());
+        assert!(
+            find_by_id(&ctx, "user-a")
+                .await
+                .unwrap()
+                .unwrap()
+                .must_change_password
+        );
+        set_must_change_password(&ctx, "user-a", false)
+            .await
+            .unwrap();
+        assert!(!must_change_password(&ctx, "user-a").await.unwrap());
+    }
+
+    #[tokio::test]
+    async fn login_alerts_default_on_and_can_be_muted() {
+        let ctx = TestContext::with_auth().await;
+        seed_user(&ctx, "user-a").await;
+        let user = find_by_id(&ctx, "user-a").await.unwrap().unwrap();
+        assert!(!user.mute_login_alerts);
+        set_mute_login_alerts(&ctx, "user-a", true).await.unwrap();
+        let user = find_by_id(&ctx, "user-a").await.unwrap().unwrap();
+        assert!(user.mute_login_alerts);
+    }
 }
 
 #[cfg(test)]
@@ -594,4 +1064,103 @@ mod typed_client_tests {
         assert!(row.is_deleted());
         assert!(!row.is_active());
     }
+
+    #[tokio::test]
+    async fn active_lookups_hide_disabled_and_soft_deleted_accounts() {
+        let ctx = TestContext::with_auth().await;
+        let id = seed_active(&ctx).await;
+        let email = find_by_id(&ctx, &id).await.unwrap().unwrap().email;
+        assert!(is_active(&ctx, &id).await.unwrap());
+        assert!(find_active_by_id(&ctx, &id).await.unwrap().is_some());
+        assert!(find_active_by_email(&ctx, &email).await.unwrap().is_some());
+
+        db::soft_delete(&ctx, TABLE, &id).await.unwrap();
+        assert!(!is_active(&ctx, &id).await.unwrap());
+        assert!(find_active_by_id(&ctx, &id).await.unwrap().is_none());
+        assert!(find_active_by_email(&ctx, &email).await.unwrap().is_none());
+        // The unfiltered lookups still see the row.
+        assert!(find_by_email(&ctx, &email).await.unwrap().is_some());
+        assert!(!is_active(&ctx, "missing").await.unwrap());
+    }
+
+    #[tokio::test]
+    async fn purge_removes_the_row_and_its_credentials() {
+        let ctx = TestContext::with_auth().await;
+        let id = seed_active(&ctx).await;
+        let kept = insert(
+            &ctx,
+            NewUser {
+                email: "kept@example.com".into(),
+                display_name: "Kept".into(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap()
+        .id;
+        for user_id in [&id, &kept] {
+            super::super::sessions::insert(
+                &ctx,
+                super::super::sessions::NewSession {
+                    token_hash: user_id.as_bytes().to_vec(),
+                    user_id: user_id.to_string(),
+                    expires_at: "2099-01-01T00:00:00Z".into(),
+                },
+            )
+            .await
+            .unwrap();
+        }
+        let mut deleted_at = HashMap::new();
+        deleted_at.insert("deleted_at".to_string(), json!("2026-01-01T00:00:00Z"));
+        db::update(&ctx, TABLE, &id, deleted_at).await.unwrap();
+
+        let due = list_deleted_before(&ctx, "2026-02-01T00:00:00Z", 10)
+            .await
+            .unwrap();
+        assert_eq!(due.iter().map(|u| &u.id).collect::<Vec<_>>(), [&id]);
+        assert!(list_deleted_before(&ctx, "2025-12-01T00:00:00Z", 10)
+            .await
+            .unwrap()
+            .is_empty());
+
+        purge(&ctx, &id).await.unwrap();
+        purge(&ctx, &id).await.unwrap();
+        assert!(find_by_id(&ctx, &id).await.unwrap().is_none());
+        assert!(super::super::sessions::list_for_user(&ctx, &id)
+            .await
+            .unwrap()
+            .is_empty());
+        assert_eq!(
+            super::super::sessions::list_for_user(&ctx, &kept)
+                .await
+                .unwrap()
+                .len(),
+            1
+        );
+    }
+
+    #[tokio::test]
+    async fn service_accounts_are_verified_and_kept_out_of_the_directory() {
+        let ctx = TestContext::with_auth().await;
+        let human = seed_active(&ctx).await;
+        let sa = insert_service_account(&ctx, "ci", r#"{"api_read":"1000/60"}"#)
+            .await
+            .unwrap();
+        assert!(sa.is_service_account());
+        assert!(sa.email_verified);
+        assert!(sa.email.ends_with(SERVICE_ACCOUNT_EMAIL_DOMAIN));
+        assert_eq!(sa.rate_limits, r#"{"api_read":"1000/60"}"#);
+
+        assert_eq!(actor_type(&sa.id), KIND_SERVICE_ACCOUNT);
+        assert_eq!(actor_type(&human), KIND_USER);
+        assert_eq!(actor_type(""), "");
+
+        assert!(super::super::directory::find_by_id(&ctx, &sa.id)
+            .await
+            .unwrap()
+            .is_none());
+        let human_row = find_by_id(&ctx, &human).await.unwrap().unwrap();
+        assert!(!human_row.is_service_account());
+    }
 }
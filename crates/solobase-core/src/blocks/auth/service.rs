@@ -116,17 +116,16 @@ fn session_cookie_from(msg: &Message) -> Option<String> {
     }
 }
 
-/// Returns `true` iff `expires_at` parses as an RFC3339 timestamp earlier
-/// than now. Parsing the timestamp avoids the mixed-format trap of string
-/// comparison (`+00:00` vs `Z`) — the auth tables intermix both because
-/// some repo helpers write `…Z` and others use `to_rfc3339()`.
+/// Returns `true` iff `expires_at` parses as a timestamp earlier than now.
+/// Parsing (via [`crate::util::parse_timestamp`]) rather than comparing
+/// strings keeps rows written before the single `…Z` layout correct.
 ///
 /// Unparseable inputs are treated as "expired" — a malformed expiry on a
 /// session row is safer to reject than silently grant.
 fn is_expired(expires_at: &str) -> bool {
-    match chrono::DateTime::parse_from_rfc3339(expires_at) {
-        Ok(exp) => chrono::Utc::now() >= exp.with_timezone(&chrono::Utc),
-        Err(_) => true,
+    match crate::util::parse_timestamp(expires_at) {
+        Some(exp) => crate::clock::now() >= exp,
+        None => true,
     }
 }
 
@@ -173,22 +172,70 @@ pub fn auth_grants() -> Vec<wafer_block::types::ResourceGrant> {
         // contains() fail-closed path treats every JWT as blocklisted,
         // 403-ing every signed-in admin request.
         wafer_run::ResourceGrant::read("suppers-ai/router", "suppers_ai__auth__jwt_blocklist"),
+        // It also reads the caller's profile for their preferred locale
+        // when translating an error response (`crate::i18n`), and the email
+        // block does the same for a templated email's recipient.
+        wafer_run::ResourceGrant::read("suppers-ai/router", "suppers_ai__auth__users"),
+        // It stamps each API key's last use as the key authenticates a
+        // request (`repo::api_keys::record_use`).
+        wafer_run::ResourceGrant::read_write("suppers-ai/router", "suppers_ai__auth__api_keys"),
+        wafer_run::ResourceGrant::read("suppers-ai/email", "suppers_ai__auth__users"),
         // Admin block reads auth tables for the admin dashboards. The
         // wildcard mirrors the legacy AuthBlock grant — admin/pages/users
         // reads users, sessions, AND api_keys (the API-key tab) so the
         // narrower per-table list would regress.
         wafer_run::ResourceGrant::read("suppers-ai/admin", "suppers_ai__auth__*"),
+        // Admins issue and revoke signup invitations; signup (auth-ui,
+        // covered by its wildcard) consumes them.
+        wafer_run::ResourceGrant::read_write("suppers-ai/admin", "suppers_ai__auth__invitations"),
+        // Admins create and disable service accounts (users rows of kind
+        // `service_account`) and issue and rotate their API keys. Buffered
+        // key-use counts are also flushed on the admin block's context
+        // (`crate::write_buffer`).
+        wafer_run::ResourceGrant::read_write("suppers-ai/admin", "suppers_ai__auth__users"),
+        wafer_run::ResourceGrant::read_write("suppers-ai/admin", "suppers_ai__auth__api_keys"),
+        // The `expired_tokens` retention policy (blocks/retention.rs) runs
+        // on the admin block and deletes rows long past their expiry.
+        wafer_run::ResourceGrant::read_write("suppers-ai/admin", "suppers_ai__auth__sessions"),
+        wafer_run::ResourceGrant::read_write("suppers-ai/admin", "suppers_ai__auth__tokens"),
+        wafer_run::ResourceGrant::read_write("suppers-ai/admin", "suppers_ai__auth__jwt_blocklist"),
+        // The `deleted_users` policy hard-deletes accounts past retention
+        // with everything still keyed to them (`repo::users::purge`).
+        wafer_run::ResourceGrant::read_write(
+            "suppers-ai/admin",
+            "suppers_ai__auth__personal_access_tokens",
+        ),
+        wafer_run::ResourceGrant::read_write(
+            "suppers-ai/admin",
+            "suppers_ai__auth__provider_links",
+        ),
+        wafer_run::ResourceGrant::read_write(
+            "suppers-ai/admin",
+            "suppers_ai__auth__local_credentials",
+        ),
+        wafer_run::ResourceGrant::read_write("suppers-ai/admin", "suppers_ai__auth__orgs"),
+        wafer_run::ResourceGrant::read_write(
+            "suppers-ai/admin",
+            "suppers_ai__auth__oauth_pkce_states",
+        ),
         // Userportal `/b/userportal/sessions` page lists the caller's
         // sessions and revokes individual rows. Read+write because revoke
         // deletes the row; reads are scoped to the caller's user_id by
         // the repo helper.
         wafer_run::ResourceGrant::read_write("suppers-ai/userportal", "suppers_ai__auth__sessions"),
+        // Revoking a device there also revokes its refresh-token family, so
+        // the device can't mint a fresh session.
+        wafer_run::ResourceGrant::read_write("suppers-ai/userportal", "suppers_ai__auth__tokens"),
         // Userportal `/b/userportal/security` lists the caller's
         // linked OAuth providers. Read-only — unlinking goes
         // through an auth POST endpoint, not the userportal block.
         wafer_run::ResourceGrant::read("suppers-ai/userportal", "suppers_ai__auth__provider_links"),
         wafer_run::ResourceGrant::read_write("suppers-ai/userportal", "suppers_ai__auth__users"),
-        wafer_run::ResourceGrant::read("suppers-ai/products", "suppers_ai__auth__users"),
+        // Folder sharing resolves a grantee's email to a user id. The files
+        // block gets the `(id, email)` directory view (migration 010), not
+        // the users table. (Products used to hold a read grant on the full
+        // users table it never queried; dropped.)
+        wafer_run::ResourceGrant::read("suppers-ai/files", "suppers_ai__auth__users_directory"),
         // Wave 3: rate_limit.rs (called from products + files blocks) writes to
         // suppers_ai__auth__rate_limits on the wasm32 (Cloudflare Workers) path.
         // Native uses an in-memory Mutex<HashMap> counter and never touches the DB.
@@ -205,13 +252,13 @@ pub fn auth_grants() -> Vec<wafer_block::types::ResourceGrant> {
 /// The single lifecycle-state gate shared by every `require_*` credential
 /// path — session cookies and PATs resolve a user id without otherwise
 /// loading the user row, so without this they would authenticate a
-/// deactivated account.
+/// deactivated account. The answer is cached briefly (`users::is_active`),
+/// so this is not a query per request.
 async fn ensure_active(ctx: &dyn Context, user_id: &str) -> Result<(), AuthError> {
-    let user = users::find_by_id(ctx, user_id)
+    let active = users::is_active(ctx, user_id)
         .await
-        .map_err(|e| AuthError::Internal(e.to_string()))?
-        .ok_or(AuthError::Unauthorized)?;
-    if !user.is_active() {
+        .map_err(|e| AuthError::Internal(e.to_string()))?;
+    if !active {
         return Err(AuthError::Unauthorized);
     }
     Ok(())
@@ -242,14 +289,20 @@ impl AuthService for AuthServiceImpl {
             .iter()
             .map(|(_, sql)| *sql)
             .collect();
-        crate::migration_helper::apply_migrations(
+        let applied = crate::migration_helper::apply_migrations(
             ctx,
             "suppers-ai/auth",
             &sqlite,
             super::migrations::POSTGRES_MIGRATIONS,
         )
-        .await
-        .map_err(|e| AuthError::Internal(format!("auth migrations: {e}")))?;
+        .await;
+        crate::schema_status::record(
+            "suppers-ai/auth",
+            super::migrations::SQLITE_MIGRATIONS,
+            super::migrations::POSTGRES_MIGRATIONS,
+            &applied,
+        );
+        applied.map_err(|e| AuthError::Internal(format!("auth migrations: {e}")))?;
         let cfg = super::config::AuthConfig::from_ctx(ctx).await;
         super::bootstrap::run(ctx, &cfg)
             .await
@@ -381,7 +434,7 @@ impl AuthService for AuthServiceImpl {
 
     async fn user_profile(&self, user: UserId) -> Result<UserProfile, AuthError> {
         let ctx = self.ctx()?;
-        let row = users::find_by_id(ctx, &user.0)
+        let row = users::find_active_by_id(ctx, &user.0)
             .await
             .map_err(|e| AuthError::Internal(e.to_string()))?
             .ok_or(AuthError::NotFound)?;
@@ -631,6 +684,25 @@ mod tests {
             Err(AuthError::Unauthorized)
         ));
 
+        // Soft-deleted → Unauthorized.
+        let gone = users::insert(
+            &ctx,
+            users::NewUser {
+                email: "gone@e.co".into(),
+                display_name: "Gone".into(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap();
+        assert!(ensure_active(&ctx, &gone.id).await.is_ok());
+        db::soft_delete(&ctx, users::TABLE, &gone.id).await.unwrap();
+        assert!(matches!(
+            ensure_active(&ctx, &gone.id).await,
+            Err(AuthError::Unauthorized)
+        ));
+
         // Missing user → Unauthorized (not a 500).
         assert!(matches!(
             ensure_active(&ctx, "does-not-exist").await,
@@ -0,0 +1,147 @@
+//! Announcement banners for the current caller:
+//!
+//! - `GET /b/auth/api/announcements` — live banners addressed to the caller.
+//!   Anonymous callers get only untargeted critical ones.
+//! - `POST /b/auth/api/announcements/{id}/dismiss` — hide a dismissible
+//!   banner for the caller. Idempotent.
+
+use wafer_run::{context::Context, Message, OutputStream};
+
+use crate::{
+    blocks::{
+        announcements::{self, DismissError},
+        errors::{error_response, ErrorCode},
+    },
+    etag,
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+};
+
+pub async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    match announcements::visible_to(ctx, msg).await {
+        Ok(active) => etag::ok_json(msg, &serde_json::json!({ "announcements": active })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `id` is the `{id}` segment of the dismiss path.
+pub async fn handle_dismiss(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    if msg.user_id().is_empty() {
+        return error_response(ErrorCode::NotAuthenticated, "Not authenticated");
+    }
+    match announcements::dismiss(ctx, msg, id).await {
+        Ok(()) => ok_json(&serde_json::json!({ "dismissed": true })),
+        Err(DismissError::NotFound) => err_not_found("Announcement not found"),
+        Err(DismissError::NotDismissible) => {
+            err_bad_request("This announcement cannot be dismissed")
+        }
+        Err(DismissError::Backend(e)) => err_internal("Database error", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        blocks::announcements::AnnouncementInput,
+        test_support::{
+            anon_msg, auth_msg, output_is_error, output_json, output_status, TestContext,
+        },
+    };
+
+    async fn seed(ctx: &TestContext, input: serde_json::Value) -> String {
+        let input: AnnouncementInput = serde_json::from_value(input).unwrap();
+        let valid = input.validate(chrono::Utc::now()).unwrap();
+        announcements::create(ctx, &valid, "admin_1")
+            .await
+            .unwrap()
+            .id
+    }
+
+    fn messages(v: &serde_json::Value) -> Vec<&str> {
+        v["announcements"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|a| a["message"].as_str().unwrap())
+            .collect()
+    }
+
+    #[tokio::test]
+    async fn list_filters_by_window_role_and_audience() {
+        let ctx = TestContext::with_auth().await;
+        seed(&ctx, serde_json::json!({ "message": "everyone" })).await;
+        seed(
+            &ctx,
+            serde_json::json!({ "message": "outage", "severity": "critical" }),
+        )
+        .await;
+        seed(
+            &ctx,
+            serde_json::json!({ "message": "pro only", "target_roles": ["pro"] }),
+        )
+        .await;
+        seed(
+            &ctx,
+            serde_json::json!({
+                "message": "expired",
+                "starts_at": "2020-01-01T00:00:00Z",
+                "ends_at": "2020-01-02T00:00:00Z",
+            }),
+        )
+        .await;
+        seed(
+            &ctx,
+            serde_json::json!({ "message": "later", "starts_at": "2099-01-01T00:00:00Z" }),
+        )
+        .await;
+
+        let anon = anon_msg("retrieve", "/b/auth/api/announcements");
+        let body = output_json(handle_list(&ctx, &anon).await).await;
+        assert_eq!(messages(&body), ["outage"]);
+
+        let mut user = auth_msg("retrieve", "/b/auth/api/announcements", "u1");
+        user.set_meta("auth.user_roles", "user");
+        let mut seen = messages(&output_json(handle_list(&ctx, &user).await).await)
+            .into_iter()
+            .map(str::to_string)
+            .collect::<Vec<_>>();
+        seen.sort();
+        assert_eq!(seen, ["everyone", "outage"]);
+
+        user.set_meta("auth.user_roles", "user,pro");
+        let body = output_json(handle_list(&ctx, &user).await).await;
+        assert!(messages(&body).contains(&"pro only"));
+    }
+
+    #[tokio::test]
+    async fn dismiss_hides_a_banner_for_that_user_only() {
+        let ctx = TestContext::with_auth().await;
+        let id = seed(&ctx, serde_json::json!({ "message": "welcome" })).await;
+        let pinned = seed(
+            &ctx,
+            serde_json::json!({ "message": "pinned", "dismissible": false }),
+        )
+        .await;
+
+        let path = format!("/b/auth/api/announcements/{id}/dismiss");
+        let alice = auth_msg("create", &path, "alice");
+        for _ in 0..2 {
+            let out = handle_dismiss(&ctx, &alice, &id).await;
+            assert_eq!(output_status(out).await, 200, "dismiss is idempotent");
+        }
+
+        let list = |user: &str| auth_msg("retrieve", "/b/auth/api/announcements", user);
+        let body = output_json(handle_list(&ctx, &list("alice")).await).await;
+        assert_eq!(messages(&body), ["pinned"]);
+        let body = output_json(handle_list(&ctx, &list("bob")).await).await;
+        assert_eq!(messages(&body).len(), 2);
+
+        let out = handle_dismiss(&ctx, &alice, &pinned).await;
+        assert!(output_is_error(out, "InvalidArgument").await);
+        let out = handle_dismiss(&ctx, &alice, "missing").await;
+        assert!(output_is_error(out, "NotFound").await);
+        let anon = anon_msg("create", &path);
+        let out = handle_dismiss(&ctx, &anon, &id).await;
+        assert!(output_is_error(out, "Unauthenticated").await);
+    }
+}
@@ -4,22 +4,27 @@
 //! `solobase-core/src/blocks/admin/pages/users.rs`). PAT migration is a
 //! follow-up; for PR 5 we relocate rather than delete.
 
-use wafer_core::clients::crypto;
+use wafer_core::clients::config;
 use wafer_run::{context::Context, InputStream, Message, OutputStream};
 
 use crate::{
-    blocks::auth::repo::api_keys,
-    http::{err_bad_request, err_forbidden, err_internal, err_not_found, ok_json},
-    util::{hex_encode, sha256_hex},
+    blocks::{
+        admin::audit_log,
+        auth::{
+            config::API_KEY_MAX_LIFETIME_DAYS_KEY,
+            key_scopes::{self, Cidr, MAX_ALLOWED_IPS, MAX_SCOPES},
+            repo::{api_keys, users},
+        },
+        errors::{error_json, validation_error, ErrorCode},
+        permissions,
+    },
+    http::{err_internal, ok_json},
 };
 
 pub async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let user_id = msg.user_id();
     if user_id.is_empty() {
-        return crate::blocks::errors::error_response(
-            crate::blocks::errors::ErrorCode::NotAuthenticated,
-            "Authentication required",
-        );
+        return error_json(ErrorCode::NotAuthenticated, "Authentication required", None);
     }
     match api_keys::list_for_user(ctx, user_id).await {
         Ok(rows) => {
@@ -35,10 +40,17 @@ pub async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
                         "data": {
                             "user_id": k.user_id,
                             "name": k.name,
+                            "kind": k.kind,
                             "key_prefix": k.key_prefix,
                             "created_at": k.created_at,
                             "expires_at": k.expires_at,
                             "revoked_at": k.revoked_at,
+                            "scopes": k.scopes,
+                            "bucket": k.bucket,
+                            "allowed_ips": k.allowed_ips,
+                            "last_used_at": k.last_used_at,
+                            "last_used_ip": k.last_used_ip,
+                            "use_count": k.use_count,
                         }
                     })
                 })
@@ -59,52 +71,79 @@ pub async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
 pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
     let user_id = msg.user_id();
     if user_id.is_empty() {
-        return crate::blocks::errors::error_response(
-            crate::blocks::errors::ErrorCode::NotAuthenticated,
-            "Authentication required",
+        return error_json(ErrorCode::NotAuthenticated, "Authentication required", None);
+    }
+    // A service account's keys come only from the admin API; a leaked key
+    // that could mint more would survive rotation.
+    if users::actor_type(user_id) == users::KIND_SERVICE_ACCOUNT {
+        return error_json(
+            ErrorCode::Forbidden,
+            "Service account keys are issued by an administrator",
+            None,
         );
     }
-
-    #[derive(serde::Deserialize)]
-    struct CreateKeyReq {
-        name: String,
-        expires_at: Option<String>,
+    // Nor may a key mint keys: the new one wouldn't inherit its scopes,
+    // bucket, address ranges or expiry.
+    if !msg.get_meta(key_scopes::META_KEY_ID).is_empty() {
+        return error_json(
+            ErrorCode::Forbidden,
+            "API keys can't create keys; sign in to create one",
+            None,
+        );
     }
+
     let raw = input.collect_to_bytes().await;
     let parsed = crate::util::parse_body_value(&raw);
     let body: CreateKeyReq = match serde_json::from_value(parsed) {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(e) => return error_json(ErrorCode::InvalidInput, &format!("Invalid body: {e}"), None),
     };
     if body.name.is_empty() {
-        return err_bad_request("API key name is required");
+        return error_json(ErrorCode::InvalidInput, "API key name is required", None);
+    }
+    let limits = match restrictions(ctx, msg, &body).await {
+        Ok(limits) => limits,
+        Err(out) => return out,
+    };
+    match body.kind.as_str() {
+        "" | api_keys::KIND_API => {}
+        api_keys::KIND_S3 => {
+            return create_s3_credential(ctx, msg, &body.name, &limits).await;
+        }
+        _ => {
+            return error_json(
+                ErrorCode::InvalidInput,
+                "kind must be \"api\" or \"s3\"",
+                None,
+            )
+        }
     }
 
-    // Generate random key
-    let random_bytes = match crypto::random_bytes(ctx, 24).await {
-        Ok(b) => b,
+    let generated = match api_keys::generate(ctx).await {
+        Ok(k) => k,
         Err(e) => return err_internal("Failed to generate key", e),
     };
-    let key_string = format!("sb_{}", hex_encode(&random_bytes));
-
-    // Use deterministic SHA-256 hash for key lookup (not argon2, which is non-deterministic)
-    let key_hash = sha256_hex(key_string.as_bytes());
-    let key_prefix = key_string[..10].to_string();
+    let key_string = generated.raw;
 
     let insert_result = api_keys::insert(
         ctx,
         api_keys::NewApiKey {
             user_id,
             name: &body.name,
-            key_hash: &key_hash,
-            key_prefix: &key_prefix,
-            expires_at: body.expires_at.as_deref(),
+            key_hash: &generated.key_hash,
+            key_prefix: &generated.key_prefix,
+            expires_at: limits.expires_at.as_deref(),
+            scopes: &limits.scopes,
+            bucket: &limits.bucket,
+            allowed_ips: &limits.allowed_ips,
+            ..Default::default()
         },
     )
     .await;
 
     match insert_result {
         Ok(record) => {
+            audit_created(ctx, msg, &record).await;
             // htmx form callers want HTML back so the swap renders cleanly.
             // Programmatic JSON callers (no HX-Request header) get the JSON
             // payload as before so existing API consumers don't break.
@@ -168,24 +207,286 @@ pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream)
     }
 }
 
+/// Create an S3 credential for the storage gateway. JSON only: the access
+/// key id and secret are both shown once, here.
+async fn create_s3_credential(
+    ctx: &dyn Context,
+    msg: &Message,
+    name: &str,
+    limits: &Restrictions,
+) -> OutputStream {
+    let generated = match api_keys::generate_s3(ctx).await {
+        Ok(k) => k,
+        Err(e) => return err_internal("Failed to generate key", e),
+    };
+    let insert_result = api_keys::insert_s3(
+        ctx,
+        api_keys::NewApiKey {
+            user_id: msg.user_id(),
+            name,
+            key_hash: &generated.key_hash,
+            key_prefix: &generated.access_key_id,
+            expires_at: limits.expires_at.as_deref(),
+            scopes: &limits.scopes,
+            bucket: &limits.bucket,
+            allowed_ips: &limits.allowed_ips,
+        },
+        &generated.secret,
+    )
+    .await;
+    match insert_result {
+        Ok(record) => {
+            audit_created(ctx, msg, &record).await;
+            ok_json(&serde_json::json!({
+                "id": record.id,
+                "name": record.name,
+                "kind": record.kind,
+                "access_key_id": generated.access_key_id,
+                "secret_access_key": generated.secret,
+                "expires_at": record.expires_at,
+                "message": "Save this secret — it won't be shown again"
+            }))
+        }
+        Err(e) => err_internal("Database error", e.to_string()),
+    }
+}
+
+#[derive(serde::Deserialize)]
+struct CreateKeyReq {
+    name: String,
+    /// RFC 3339; must be in the future.
+    expires_at: Option<String>,
+    /// Alternative to `expires_at` for form callers; wins when both are set.
+    #[serde(default)]
+    expires_in_days: serde_json::Value,
+    /// `"s3"` for an S3 credential; an ordinary key otherwise.
+    #[serde(default)]
+    kind: String,
+    /// Permission names (see [`key_scopes`]); empty for the owner's full
+    /// access. A JSON array, or comma-separated from a form.
+    #[serde(default)]
+    scopes: serde_json::Value,
+    #[serde(default)]
+    bucket: String,
+    /// CIDR ranges or addresses; same encodings as `scopes`.
+    #[serde(default)]
+    allowed_ips: serde_json::Value,
+}
+
+/// A create request's scopes, bucket, address ranges and expiry, checked.
+#[derive(Debug)]
+struct Restrictions {
+    scopes: Vec<String>,
+    bucket: String,
+    allowed_ips: Vec<String>,
+    expires_at: Option<String>,
+}
+
+/// A list field sent as a JSON array or as a comma-separated string.
+fn list_field(value: &serde_json::Value) -> Vec<String> {
+    let items: Vec<&str> = match value {
+        serde_json::Value::Array(items) => items.iter().filter_map(|v| v.as_str()).collect(),
+        serde_json::Value::String(s) => s.split(',').collect(),
+        _ => Vec::new(),
+    };
+    items
+        .into_iter()
+        .map(str::trim)
+        .filter(|s| !s.is_empty())
+        .map(str::to_string)
+        .collect()
+}
+
+/// Check the restrictions a create request asks for: scopes must be ones
+/// the caller holds, and the expiry must fall within
+/// [`API_KEY_MAX_LIFETIME_DAYS_KEY`], which also supplies it when none is
+/// asked for. `Err` is the response to answer with.
+async fn restrictions(
+    ctx: &dyn Context,
+    msg: &Message,
+    body: &CreateKeyReq,
+) -> Result<Restrictions, OutputStream> {
+    let mut scopes = list_field(&body.scopes);
+    scopes.sort();
+    scopes.dedup();
+    if scopes.len() > MAX_SCOPES {
+        return Err(validation_error(
+            "Too many scopes",
+            &[("scopes", &format!("at most {MAX_SCOPES}"))],
+        ));
+    }
+    if let Some(bad) = scopes.iter().find(|s| !key_scopes::is_valid_scope(s)) {
+        return Err(validation_error(
+            "Invalid scope",
+            &[("scopes", &format!("{bad} is not a permission name"))],
+        ));
+    }
+    if !scopes.is_empty() {
+        let held = if crate::util::is_admin(msg) {
+            vec![permissions::ALL.to_string()]
+        } else {
+            let roles = crate::blocks::feature_flags::roles_of(msg);
+            permissions::held_by(ctx, &roles)
+                .await
+                .map_err(|e| err_internal("Database error", e))?
+        };
+        if let Some(denied) = scopes.iter().find(|s| !key_scopes::may_grant(&held, s)) {
+            return Err(error_json(
+                ErrorCode::PermissionDenied,
+                &format!("You can't grant {denied}: you don't hold it"),
+                Some(serde_json::json!({ "permission": denied })),
+            ));
+        }
+    }
+
+    let bucket = body.bucket.trim().to_string();
+    if bucket.len() > 63
+        || !bucket
+            .bytes()
+            .all(|b| b.is_ascii_alphanumeric() || matches!(b, b'-' | b'_' | b'.'))
+    {
+        return Err(validation_error(
+            "Invalid bucket",
+            &[("bucket", "not a bucket name")],
+        ));
+    }
+
+    let allowed_ips = list_field(&body.allowed_ips);
+    if allowed_ips.len() > MAX_ALLOWED_IPS {
+        return Err(validation_error(
+            "Too many address ranges",
+            &[("allowed_ips", &format!("at most {MAX_ALLOWED_IPS}"))],
+        ));
+    }
+    if let Some(bad) = allowed_ips.iter().find(|r| Cidr::parse(r).is_none()) {
+        return Err(validation_error(
+            "Invalid address range",
+            &[(
+                "allowed_ips",
+                &format!("{bad} is not an address or CIDR range"),
+            )],
+        ));
+    }
+
+    let now = crate::clock::now();
+    let requested = match (
+        crate::util::json_as_i64(&body.expires_in_days),
+        body.expires_at.as_deref().filter(|s| !s.is_empty()),
+    ) {
+        (Some(days), _) if (1..=36_500).contains(&days) => Some(now + chrono::Duration::days(days)),
+        (Some(_), _) => {
+            return Err(validation_error(
+                "Invalid expiry",
+                &[("expires_in_days", "must be a positive number of days")],
+            ))
+        }
+        (None, Some(at)) => match crate::util::parse_timestamp(at) {
+            Some(t) if t > now => Some(t),
+            Some(_) => {
+                return Err(validation_error(
+                    "Invalid expiry",
+                    &[("expires_at", "must be in the future")],
+                ))
+            }
+            None => {
+                return Err(validation_error(
+                    "Invalid expiry",
+                    &[("expires_at", "not an RFC 3339 timestamp")],
+                ))
+            }
+        },
+        (None, None) => None,
+    };
+    let max_days: i64 = config::get_default(ctx, API_KEY_MAX_LIFETIME_DAYS_KEY, "0")
+        .await
+        .trim()
+        .parse()
+        .unwrap_or(0);
+    let expires_at = match (requested, max_days > 0) {
+        (requested, false) => requested,
+        (None, true) => Some(now + chrono::Duration::days(max_days)),
+        (Some(t), true) if t <= now + chrono::Duration::days(max_days) => Some(t),
+        (Some(_), true) => {
+            return Err(validation_error(
+                "Invalid expiry",
+                &[(
+                    "expires_at",
+                    &format!("keys may live at most {max_days} days"),
+                )],
+            ))
+        }
+    };
+
+    Ok(Restrictions {
+        scopes,
+        bucket,
+        allowed_ips,
+        expires_at: expires_at.map(crate::util::format_rfc3339),
+    })
+}
+
+/// Audit a new key with what it may reach; `api_keys/{id}` plus its
+/// restrictions, e.g. `api_keys/k1 (scopes: storage.read; bucket: docs)`.
+async fn audit_created(ctx: &dyn Context, msg: &Message, key: &api_keys::ApiKeyRow) {
+    let mut limits = vec![format!(
+        "scopes: {}",
+        if key.scopes.is_empty() {
+            "all".to_string()
+        } else {
+            key.scopes.join(",")
+        }
+    )];
+    if !key.bucket.is_empty() {
+        limits.push(format!("bucket: {}", key.bucket));
+    }
+    if !key.allowed_ips.is_empty() {
+        limits.push(format!("ips: {}", key.allowed_ips.join(",")));
+    }
+    if let Some(expires_at) = &key.expires_at {
+        limits.push(format!("expires: {expires_at}"));
+    }
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "api_key.create",
+        &format!("api_keys/{} ({})", key.id, limits.join("; ")),
+        msg.remote_addr(),
+    )
+    .await;
+}
+
 pub async fn handle_revoke(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let path = msg.path();
     let id = path.rsplit_once('/').map(|(_, id)| id).unwrap_or("");
     if id.is_empty() {
-        return err_bad_request("Missing key ID");
+        return error_json(ErrorCode::InvalidInput, "Missing key ID", None);
     }
     let user_id = msg.user_id();
 
     // Verify ownership
     let Ok(Some(key)) = api_keys::find_by_id(ctx, id).await else {
-        return err_not_found("API key not found");
+        return error_json(ErrorCode::NotFound, "API key not found", None);
     };
     if key.user_id != user_id && !crate::util::is_admin(msg) {
-        return err_forbidden("Cannot revoke another user's API key");
+        return error_json(
+            ErrorCode::Forbidden,
+            "Cannot revoke another user's API key",
+            None,
+        );
     }
 
     match api_keys::revoke(ctx, id).await {
-        Ok(_) => ok_json(&serde_json::json!({"message": "API key revoked"})),
+        Ok(_) => {
+            audit_log(
+                ctx,
+                user_id,
+                "api_key.revoke",
+                &format!("api_keys/{id}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&serde_json::json!({"message": "API key revoked"}))
+        }
         Err(e) => err_internal("Database error", e.to_string()),
     }
 }
@@ -194,20 +495,104 @@ pub async fn handle_delete(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let path = msg.path();
     let id = path.rsplit_once('/').map(|(_, id)| id).unwrap_or("");
     if id.is_empty() {
-        return err_bad_request("Missing key ID");
+        return error_json(ErrorCode::InvalidInput, "Missing key ID", None);
     }
     let user_id = msg.user_id();
 
     // Verify ownership
     let Ok(Some(key)) = api_keys::find_by_id(ctx, id).await else {
-        return err_not_found("API key not found");
+        return error_json(ErrorCode::NotFound, "API key not found", None);
     };
     if key.user_id != user_id && !crate::util::is_admin(msg) {
-        return err_forbidden("Cannot delete another user's API key");
+        return error_json(
+            ErrorCode::Forbidden,
+            "Cannot delete another user's API key",
+            None,
+        );
     }
 
     match api_keys::delete(ctx, id).await {
-        Ok(_) => ok_json(&serde_json::json!({"deleted": true})),
+        Ok(_) => {
+            audit_log(
+                ctx,
+                user_id,
+                "api_key.delete",
+                &format!("api_keys/{id}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&serde_json::json!({"deleted": true}))
+        }
         Err(e) => err_internal("Database error", e.to_string()),
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use super::*;
+    use crate::test_support::{auth_msg, output_json, TestContext};
+
+    async fn ctx_with_crypto() -> TestContext {
+        let mut ctx = TestContext::with_auth().await;
+        let svc = Arc::new(
+            wafer_block_crypto::service::Argon2JwtCryptoService::new(
+                "test-jwt-secret-padded-to-min-32-bytes-aaaa".to_string(),
+            )
+            .expect("test secret is long enough"),
+        );
+        let crypto_block: Arc<dyn wafer_run::Block> =
+            Arc::new(wafer_core::service_blocks::crypto::CryptoBlock::new(svc));
+        ctx.register_block("wafer-run/crypto", crypto_block);
+        ctx
+    }
+
+    async fn seed_user(ctx: &TestContext) -> users::UserRow {
+        users::insert(
+            ctx,
+            users::NewUser {
+                email: "keys@example.com".into(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap()
+    }
+
+    async fn create(ctx: &TestContext, msg: &Message, kind: &str) -> serde_json::Value {
+        let body = serde_json::json!({"name": "ci", "kind": kind}).to_string();
+        output_json(handle_create(ctx, msg, InputStream::from_bytes(body.into_bytes())).await).await
+    }
+
+    #[tokio::test]
+    async fn sessions_create_keys() {
+        let ctx = ctx_with_crypto().await;
+        let user = seed_user(&ctx).await;
+        let msg = auth_msg("create", "/b/auth/api/api-keys", &user.id);
+        let json = create(&ctx, &msg, "").await;
+        assert!(json["key"].as_str().unwrap().starts_with("sb_"));
+        assert_eq!(
+            api_keys::list_for_user(&ctx, &user.id).await.unwrap().len(),
+            1
+        );
+    }
+
+    #[tokio::test]
+    async fn keys_cannot_create_keys() {
+        let ctx = ctx_with_crypto().await;
+        let user = seed_user(&ctx).await;
+        // An address-limited key: unscoped, so the router lets it reach
+        // key management, but a new key would drop the limit.
+        let mut msg = auth_msg("create", "/b/auth/api/api-keys", &user.id);
+        msg.set_meta(key_scopes::META_KEY_ID, "key-1");
+        assert_eq!(create(&ctx, &msg, "").await["code"], "forbidden");
+        assert_eq!(create(&ctx, &msg, "s3").await["code"], "forbidden");
+        assert!(api_keys::list_for_user(&ctx, &user.id)
+            .await
+            .unwrap()
+            .is_empty());
+    }
+}
@@ -11,23 +11,18 @@
 //! Request body is `application/x-www-form-urlencoded` (the GET page
 //! submits a plain HTML form — no JS).
 
-use wafer_core::clients::config;
 use wafer_run::{context::Context, InputStream, OutputStream};
 
 use crate::{
     blocks::{
         auth::{
-            bootstrap,
-            helpers::issue_tokens_and_cookie,
-            repo::{bootstrap_tokens, users},
+            bootstrap, helpers::issue_tokens_and_cookie, repo::bootstrap_tokens,
             service::hash_token,
         },
-        auth_ui::redirect::{default_post_login_redirect, is_safe_local_redirect},
-        errors::error_response,
-    },
-    http::{
-        err_bad_request, err_internal, err_internal_no_cause, err_unauthorized, ResponseBuilder,
+        auth_ui::redirect::post_login_default,
+        errors::{error_json, ErrorCode},
     },
+    http::{err_internal, ResponseBuilder},
     util::parse_form_body,
 };
 
@@ -36,18 +31,18 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     let form = parse_form_body(&raw);
     let token = match form.get("token") {
         Some(t) if !t.is_empty() => t.clone(),
-        _ => return err_bad_request("missing token"),
+        _ => return error_json(ErrorCode::InvalidInput, "missing token", None),
     };
     let email = match form.get("email") {
         Some(e) if !e.is_empty() => e.trim().to_lowercase(),
-        _ => return err_bad_request("missing email"),
+        _ => return error_json(ErrorCode::InvalidInput, "missing email", None),
     };
     let password = match form.get("password") {
         Some(p) if !p.is_empty() => p.clone(),
-        _ => return err_bad_request("missing password"),
+        _ => return error_json(ErrorCode::InvalidInput, "missing password", None),
     };
     if let Err((code, msg)) = super::password_policy::validate_new_password(ctx, &password).await {
-        return error_response(code, &msg);
+        return error_json(code, &msg, None);
     }
 
     let token_hash = hash_token(&token);
@@ -55,17 +50,32 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     // 1. Verify the token row exists and hasn't expired.
     match bootstrap_tokens::is_valid(ctx, &token_hash).await {
         Ok(true) => {}
-        Ok(false) => return err_unauthorized("invalid or expired bootstrap token"),
+        Ok(false) => {
+            return error_json(
+                ErrorCode::InvalidToken,
+                "invalid or expired bootstrap token",
+                None,
+            )
+        }
         Err(e) => return err_internal("bootstrap_tokens lookup", e),
     }
 
     // 2. Create the admin user via the same code path bootstrap-on-init uses.
     //    Reusing this keeps the legacy companion columns (`name`, `disabled`,
     //    `deleted_at`) and the local_credentials row consistent with the
-    //    env-var path.
-    if let Err(e) = bootstrap::bootstrap_with_email_password(ctx, &email, &password).await {
-        return err_internal("create admin", e);
-    }
+    //    env-var path. The holder chose this password just now, so the
+    //    `Token` source skips the forced password change.
+    let user_id = match bootstrap::bootstrap_with_email_password(
+        ctx,
+        &email,
+        &password,
+        bootstrap::BootstrapSource::Token,
+    )
+    .await
+    {
+        Ok(id) => id,
+        Err(e) => return err_internal("create admin", e),
+    };
 
     // 3. Consume the token row. Best-effort: the admin user already exists,
     //    so a delete failure here just leaves a stale row that will expire on
@@ -76,42 +86,26 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
         );
     }
 
-    // 4. Look up the just-created user so we have its id for session minting.
-    let user = match users::find_by_email(ctx, &email).await {
-        Ok(Some(u)) => u,
-        Ok(None) => {
-            return err_internal_no_cause(
-                "bootstrap created admin but find_by_email returned no row",
-            )
-        }
-        Err(e) => return err_internal("users::find_by_email after bootstrap", e),
-    };
-
-    // 5. Mint a session — same shared token-issuance tail as login/signup.
+    // 4. Mint a session — same shared token-issuance tail as login/signup.
     let roles = vec!["admin".to_string()];
     let issued =
-        match issue_tokens_and_cookie(ctx, &user.id, &email, &roles, "password", None, 0).await {
+        match issue_tokens_and_cookie(ctx, &user_id, &email, &roles, "password", None, 0, None)
+            .await
+        {
             Ok(i) => i,
             Err(r) => return r,
         };
 
-    // 6. Set the auth cookie + redirect to a real post-login destination. The
+    // 5. Set the auth cookie + redirect to a real post-login destination. The
     //    form is a plain HTML POST (no JS), so a 302 with Set-Cookie is the
     //    right completion signal. Honor SOLOBASE_SHARED__POST_LOGIN_REDIRECT
     //    (validated) like login/oauth, defaulting to the admin home — the old
     //    `/b/auth/dashboard` target is not a registered route (404).
-    let post_login_raw =
-        config::get_default(ctx, "SOLOBASE_SHARED__POST_LOGIN_REDIRECT", "/b/admin/").await;
-    let admin_default = if is_safe_local_redirect(&post_login_raw) {
-        post_login_raw
-    } else {
-        "/b/admin/".to_string()
-    };
     // Bootstrap redemption always creates the admin account (step 2 above),
     // so `is_admin` is always `true` here — routed through the same
-    // single-sourced rule as login/OAuth (`redirect::default_post_login_redirect`)
+    // single-sourced rule as login/OAuth (`redirect::post_login_default`)
     // for consistency, not because the outcome differs.
-    let dest = default_post_login_redirect(true, &admin_default);
+    let dest = post_login_default(ctx, true).await;
     ResponseBuilder::new()
         .status(302)
         .set_cookie(&issued.cookie)
@@ -124,7 +118,7 @@ mod tests {
     use std::sync::Arc;
 
     use super::*;
-    use crate::test_support::TestContext;
+    use crate::{blocks::auth::repo::users, test_support::TestContext};
 
     /// Register a real crypto block on the test context — bootstrap admin
     /// creation goes through `crypto::hash` for the password, and session
@@ -152,9 +146,7 @@ mod tests {
         // Seed a bootstrap token row (sha256 of "test-token-xyz").
         let raw = "test-token-xyz";
         let hash = hash_token(raw);
-        let expires = (chrono::Utc::now() + chrono::Duration::hours(24))
-            .format("%Y-%m-%dT%H:%M:%SZ")
-            .to_string();
+        let expires = crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::hours(24));
         bootstrap_tokens::insert(&ctx, hash.clone(), &expires)
             .await
             .unwrap();
@@ -170,6 +162,8 @@ mod tests {
             .unwrap()
             .expect("admin user created");
         assert_eq!(user.role, "admin");
+        // The holder picked this password in the form — no forced change.
+        assert!(!user.must_change_password);
 
         // Bootstrap-token row consumed.
         assert!(!bootstrap_tokens::is_valid(&ctx, &hash).await.unwrap());
@@ -194,9 +188,7 @@ mod tests {
         // Even with a valid token row, the handler must reject password <8 chars.
         let raw = "another-token";
         let hash = hash_token(raw);
-        let expires = (chrono::Utc::now() + chrono::Duration::hours(24))
-            .format("%Y-%m-%dT%H:%M:%SZ")
-            .to_string();
+        let expires = crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::hours(24));
         bootstrap_tokens::insert(&ctx, hash.clone(), &expires)
             .await
             .unwrap();
@@ -221,9 +213,7 @@ mod tests {
         // (`validate_new_password`) routed through in Task 5.
         let raw = "common-pw-token";
         let hash = hash_token(raw);
-        let expires = (chrono::Utc::now() + chrono::Duration::hours(24))
-            .format("%Y-%m-%dT%H:%M:%SZ")
-            .to_string();
+        let expires = crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::hours(24));
         bootstrap_tokens::insert(&ctx, hash.clone(), &expires)
             .await
             .unwrap();
@@ -246,9 +236,7 @@ mod tests {
         let ctx = ctx_with_crypto().await;
         let raw = "redirect-token";
         let hash = hash_token(raw);
-        let expires = (chrono::Utc::now() + chrono::Duration::hours(24))
-            .format("%Y-%m-%dT%H:%M:%SZ")
-            .to_string();
+        let expires = crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::hours(24));
         bootstrap_tokens::insert(&ctx, hash, &expires)
             .await
             .unwrap();
@@ -6,18 +6,18 @@ use wafer_run::{context::Context, InputStream, Message, OutputStream};
 use crate::{
     blocks::{
         auth::{
-            repo::{local_credentials, tokens},
+            repo::{local_credentials, tokens, users},
             USERS_TABLE,
         },
-        errors::{error_response, ErrorCode},
+        errors::{error_json, ErrorCode},
     },
-    http::{err_bad_request, err_internal, err_not_found, ok_json},
+    http::{err_internal, ok_json},
 };
 
 pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
     let user_id = msg.user_id();
     if user_id.is_empty() {
-        return error_response(ErrorCode::NotAuthenticated, "Not authenticated");
+        return error_json(ErrorCode::NotAuthenticated, "Not authenticated", None);
     }
 
     #[derive(serde::Deserialize)]
@@ -25,31 +25,31 @@ pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> Out
         current_password: String,
         new_password: String,
     }
-    let raw = input.collect_to_bytes().await;
-    let body: ChangePwReq = match serde_json::from_slice(&raw) {
+    let body: ChangePwReq = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
 
     if let Err((code, msg)) =
         super::password_policy::validate_new_password(ctx, &body.new_password).await
     {
-        return error_response(code, &msg);
+        return error_json(code, &msg, None);
     }
 
     // Verify user exists
     match db::get(ctx, USERS_TABLE, user_id).await {
         Ok(_) => {}
-        Err(_) => return err_not_found("User not found"),
+        Err(_) => return error_json(ErrorCode::NotFound, "User not found", None),
     };
 
     // Fetch existing credential row — must have one to change password.
     let cred = match local_credentials::find_by_user_id(ctx, user_id).await {
         Ok(Some(c)) => c,
         Ok(None) => {
-            return error_response(
+            return error_json(
                 ErrorCode::InvalidCredentials,
                 "No password set for this account",
+                None,
             )
         }
         Err(e) => return err_internal("Credential lookup failed", e),
@@ -59,9 +59,10 @@ pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> Out
         .await
         .is_err()
     {
-        return error_response(
+        return error_json(
             ErrorCode::InvalidCredentials,
             "Current password is incorrect",
+            None,
         );
     }
 
@@ -76,6 +77,11 @@ pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> Out
             // SEC-032/039: mark rows revoked (don't delete) so the
             // reuse-detection tombstones survive.
             tokens::revoke_all_for_user(ctx, user_id).await.ok();
+            // Lift a forced change (bootstrap admin); the pipeline gate
+            // re-reads this flag, so already-issued tokens are released too.
+            if let Err(e) = users::set_must_change_password(ctx, user_id, false).await {
+                return err_internal("Failed to clear password-change requirement", e);
+            }
             ok_json(&serde_json::json!({"message": "Password changed successfully"}))
         }
         Err(e) => err_internal("Update failed", e),
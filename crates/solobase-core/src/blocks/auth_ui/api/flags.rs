@@ -0,0 +1,66 @@
+//! `GET /b/auth/api/flags` — every feature flag evaluated for the caller,
+//! as `{"flags": {key: bool}}`, so a frontend can gate UI without knowing
+//! rollout rules. Anonymous callers see only flags rolled out to everyone.
+
+use wafer_run::{context::Context, Message, OutputStream};
+
+use crate::{
+    blocks::feature_flags,
+    http::{err_internal, ok_json},
+};
+
+pub async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    match feature_flags::evaluate_for(ctx, msg).await {
+        Ok(flags) => ok_json(&serde_json::json!({ "flags": flags })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        blocks::feature_flags::FlagInput,
+        test_support::{anon_msg, auth_msg, output_json, TestContext},
+    };
+
+    async fn seed(ctx: &TestContext, input: serde_json::Value) {
+        let input: FlagInput = serde_json::from_value(input).unwrap();
+        feature_flags::create(ctx, &input.validate(None).unwrap(), "admin_1")
+            .await
+            .unwrap();
+    }
+
+    #[tokio::test]
+    async fn flags_are_evaluated_for_the_caller() {
+        let ctx = TestContext::with_auth().await;
+        seed(
+            &ctx,
+            serde_json::json!({ "key": "dark-mode", "enabled": true, "rollout_percent": 100 }),
+        )
+        .await;
+        seed(
+            &ctx,
+            serde_json::json!({ "key": "beta-search", "enabled": true, "target_roles": ["beta"] }),
+        )
+        .await;
+        seed(
+            &ctx,
+            serde_json::json!({ "key": "unfinished", "rollout_percent": 100 }),
+        )
+        .await;
+
+        let anon = anon_msg("retrieve", "/b/auth/api/flags");
+        let body = output_json(handle_list(&ctx, &anon).await).await;
+        assert_eq!(
+            body["flags"],
+            serde_json::json!({ "beta-search": false, "dark-mode": true, "unfinished": false })
+        );
+
+        let mut user = auth_msg("retrieve", "/b/auth/api/flags", "u1");
+        user.set_meta("auth.user_roles", "user,beta");
+        let body = output_json(handle_list(&ctx, &user).await).await;
+        assert_eq!(body["flags"]["beta-search"], true);
+        assert_eq!(body["flags"]["unfinished"], false);
+    }
+}
@@ -1,32 +1,32 @@
 //! POST /b/auth/api/login — relocated from auth/login.rs in Task 5.
 
 use wafer_core::clients::{config, crypto, database as db};
-use wafer_run::{context::Context, InputStream, OutputStream};
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
 
 use crate::{
     blocks::{
         auth::{
+            devices::Device,
             helpers::{ensure_admin_role, issue_tokens_and_cookie},
             repo::{local_credentials, users},
             DUMMY_HASH, USERS_TABLE,
         },
-        auth_ui::redirect::{default_post_login_redirect, is_safe_local_redirect},
-        errors::{error_response, ErrorCode},
+        auth_ui::{login_alerts, redirect::post_login_default},
+        errors::{error_json, ErrorCode},
     },
-    http::{err_bad_request, err_internal, ResponseBuilder},
+    http::{err_internal, ResponseBuilder},
     util::json_map,
 };
 
-pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
+pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
     #[derive(serde::Deserialize)]
     struct LoginReq {
         email: String,
         password: String,
     }
-    let raw = input.collect_to_bytes().await;
-    let body: LoginReq = match serde_json::from_slice(&raw) {
+    let body: LoginReq = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
 
     let email_lower = body.email.trim().to_lowercase();
@@ -62,23 +62,34 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     // ride on the row, so no second `db::get` is needed.
     let user = match user_row {
         Some(u) if password_ok => u,
-        _ => return error_response(ErrorCode::InvalidCredentials, "Invalid email or password"),
+        _ => {
+            return error_json(
+                ErrorCode::InvalidCredentials,
+                "Invalid email or password",
+                None,
+            )
+        }
     };
 
     // [SEC-034] Disabled accounts return the SAME generic invalid-credentials
     // response as a wrong-password attempt. Surfacing "account is disabled"
     // confirms to an attacker that the email exists, gives them a target for
     // a re-enable social-engineering attack, and signals when an admin has
-    // taken action on a compromised account.
-    if !user.is_active() {
-        return error_response(ErrorCode::InvalidCredentials, "Invalid email or password");
+    // taken action on a compromised account. Service accounts have no
+    // password and never sign in; they get the same answer.
+    if !user.is_active() || user.is_service_account() {
+        return error_json(
+            ErrorCode::InvalidCredentials,
+            "Invalid email or password",
+            None,
+        );
     }
 
     // Check email verification if required
     let require_verification =
         config::get_default(ctx, "SUPPERS_AI__AUTH__REQUIRE_VERIFICATION", "false").await;
     if (require_verification == "true" || require_verification == "1") && !user.email_verified {
-        return error_response(ErrorCode::EmailNotVerified, "Please verify your email before logging in. Check your inbox for the verification link.");
+        return error_json(ErrorCode::EmailNotVerified, "Please verify your email before logging in. Check your inbox for the verification link.", None);
     }
 
     // Get roles, granting admin role idempotently when ADMIN_EMAIL matches.
@@ -90,14 +101,30 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
         Err(e) => return err_internal("Failed to resolve user roles", e),
     };
 
+    // Checked before issuing: the refresh row written below records this
+    // device, after which it is no longer new.
+    let device = Device::from_message(msg);
+    let new_device = login_alerts::is_new_device(ctx, &user.id, &device).await;
+
     // Mint tokens, persist the refresh + session rows, build the cookie.
-    let issued =
-        match issue_tokens_and_cookie(ctx, &user.id, &email_lower, &roles, "password", None, 0)
-            .await
-        {
-            Ok(i) => i,
-            Err(r) => return r,
-        };
+    let issued = match issue_tokens_and_cookie(
+        ctx,
+        &user.id,
+        &email_lower,
+        &roles,
+        "password",
+        None,
+        0,
+        Some(&device),
+    )
+    .await
+    {
+        Ok(i) => i,
+        Err(r) => return r,
+    };
+    if new_device {
+        login_alerts::queue(ctx, &user, &device, &issued.family).await;
+    }
 
     // Update last login
     let upd = json_map(serde_json::json!({"last_login_at": crate::util::now_rfc3339()}));
@@ -109,18 +136,17 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     // is rendered before credentials are known, so it cannot pick between
     // the admin and user-portal destinations itself; this JSON response is
     // where the caller's role first becomes known, so it's where the
-    // single-sourced default (`redirect::default_post_login_redirect`) gets
+    // single-sourced default (`redirect::post_login_default`) gets
     // applied. The client only falls back to this when it has no explicit,
     // already-validated `next`/`redirect` param of its own.
-    let post_login_raw =
-        config::get_default(ctx, "SOLOBASE_SHARED__POST_LOGIN_REDIRECT", "/b/admin/").await;
-    let admin_default = if is_safe_local_redirect(&post_login_raw) {
-        post_login_raw
+    let is_admin = roles.iter().any(|r| r == "admin");
+    // A forced password change (bootstrap admin) trumps the role default:
+    // every other route answers `password_change_required` until it's done.
+    let default_redirect = if user.must_change_password {
+        "/b/auth/change-password".to_string()
     } else {
-        "/b/admin/".to_string()
+        post_login_default(ctx, is_admin).await
     };
-    let is_admin = roles.iter().any(|r| r == "admin");
-    let default_redirect = default_post_login_redirect(is_admin, &admin_default);
 
     ResponseBuilder::new()
         .set_cookie(&issued.cookie)
@@ -130,6 +156,7 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
             "token_type": "Bearer",
             "expires_in": issued.access_lifetime,
             "default_redirect": default_redirect,
+            "must_change_password": user.must_change_password,
             "user": {
                 "id": user.id,
                 "email": email_lower,
@@ -153,10 +180,13 @@ mod tests {
 
     use super::*;
     use crate::{
-        blocks::auth_ui::api::signup,
-        test_support::{collect_or_panic, output_json, TestContext},
+        blocks::{auth_ui::api::signup, jobs},
+        test_support::{anon_msg, collect_or_panic, output_json, TestContext},
     };
 
+    const LAPTOP: &str = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0";
+    const PHONE: &str = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) Safari/604.1";
+
     /// Register a real crypto block — login verifies passwords via
     /// `crypto::compare_hash` and mints tokens via `crypto::sign`/
     /// `random_bytes`. Without this the handler trips on
@@ -180,16 +210,86 @@ mod tests {
     /// here, we only need the user + local_credentials rows it creates).
     async fn signup_user(ctx: &TestContext, email: &str, password: &str) {
         let body = serde_json::json!({"email": email, "password": password}).to_string();
-        let out = signup::handle(ctx, InputStream::from_bytes(body.into_bytes())).await;
+        let msg = anon_msg("create", "/b/auth/api/signup");
+        let out = signup::handle(ctx, &msg, InputStream::from_bytes(body.into_bytes())).await;
         collect_or_panic(out).await;
     }
 
     async fn login(ctx: &TestContext, email: &str, password: &str) -> serde_json::Value {
+        login_from(ctx, LAPTOP, email, password).await
+    }
+
+    async fn login_from(
+        ctx: &TestContext,
+        user_agent: &str,
+        email: &str,
+        password: &str,
+    ) -> serde_json::Value {
+        let mut msg = anon_msg("create", "/b/auth/api/login");
+        msg.set_meta("http.header.user-agent", user_agent);
         let body = serde_json::json!({"email": email, "password": password}).to_string();
-        let out = handle(ctx, InputStream::from_bytes(body.into_bytes())).await;
+        let out = handle(ctx, &msg, InputStream::from_bytes(body.into_bytes())).await;
         output_json(out).await
     }
 
+    async fn alerts_queued(ctx: &TestContext) -> usize {
+        jobs::recent(ctx, None, Some(login_alerts::NOTIFY_JOB), 10)
+            .await
+            .unwrap()
+            .len()
+    }
+
+    #[tokio::test]
+    async fn sign_in_from_a_new_device_queues_an_alert() {
+        let ctx = ctx_with_crypto().await;
+        signup_user(&ctx, "regular@example.com", "correct-horse-battery").await;
+
+        // The first recorded device, and repeat logins from it, stay quiet.
+        login_from(&ctx, LAPTOP, "regular@example.com", "correct-horse-battery").await;
+        login_from(&ctx, LAPTOP, "regular@example.com", "correct-horse-battery").await;
+        assert_eq!(alerts_queued(&ctx).await, 0);
+
+        login_from(&ctx, PHONE, "regular@example.com", "correct-horse-battery").await;
+        let queued = jobs::recent(&ctx, None, Some(login_alerts::NOTIFY_JOB), 10)
+            .await
+            .unwrap();
+        assert_eq!(queued.len(), 1);
+        assert_eq!(queued[0].payload["email"], "regular@example.com");
+        assert_eq!(queued[0].payload["device"], "Safari on iOS");
+    }
+
+    #[tokio::test]
+    async fn muted_users_get_no_alert() {
+        let ctx = ctx_with_crypto().await;
+        signup_user(&ctx, "quiet@example.com", "correct-horse-battery").await;
+        let user = users::find_by_email(&ctx, "quiet@example.com")
+            .await
+            .unwrap()
+            .unwrap();
+        users::set_mute_login_alerts(&ctx, &user.id, true)
+            .await
+            .unwrap();
+
+        login_from(&ctx, LAPTOP, "quiet@example.com", "correct-horse-battery").await;
+        login_from(&ctx, PHONE, "quiet@example.com", "correct-horse-battery").await;
+        assert_eq!(alerts_queued(&ctx).await, 0);
+    }
+
+    #[tokio::test]
+    async fn soft_deleted_account_cannot_sign_in() {
+        let ctx = ctx_with_crypto().await;
+        signup_user(&ctx, "gone@example.com", "correct-horse-battery").await;
+        let user = users::find_by_email(&ctx, "gone@example.com")
+            .await
+            .unwrap()
+            .unwrap();
+        db::soft_delete(&ctx, USERS_TABLE, &user.id).await.unwrap();
+
+        // The same answer as a wrong password ([SEC-034]).
+        let refused = login(&ctx, "gone@example.com", "correct-horse-battery").await;
+        assert_eq!(refused["code"], "invalid_credentials");
+    }
+
     #[tokio::test]
     async fn non_admin_login_defaults_to_userportal_not_admin() {
         let ctx = ctx_with_crypto().await;
@@ -247,4 +347,26 @@ mod tests {
 
         assert_eq!(resp["default_redirect"], "/b/admin/reports");
     }
+
+    /// With the built-in UI disabled the user portal isn't served, so every
+    /// caller defaults to the configured target — here an allowlisted
+    /// external origin.
+    #[tokio::test]
+    async fn disabled_ui_defaults_everyone_to_configured_redirect() {
+        let mut ctx = ctx_with_crypto().await;
+        ctx.set_config("SOLOBASE_SHARED__DISABLE_UI", "true");
+        ctx.set_config(
+            "SOLOBASE_SHARED__REDIRECT_ALLOWED_ORIGINS",
+            "https://app.example.com",
+        );
+        ctx.set_config(
+            "SOLOBASE_SHARED__POST_LOGIN_REDIRECT",
+            "https://app.example.com/welcome",
+        );
+        signup_user(&ctx, "regular@example.com", "correct-horse-battery").await;
+
+        let resp = login(&ctx, "regular@example.com", "correct-horse-battery").await;
+
+        assert_eq!(resp["default_redirect"], "https://app.example.com/welcome");
+    }
 }
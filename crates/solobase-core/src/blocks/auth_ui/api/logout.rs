@@ -48,7 +48,7 @@ pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
                 .unwrap_or_else(|| {
                     chrono::Utc::now() + chrono::Duration::seconds(access_lifetime as i64)
                 });
-            let expires_at_iso = expires_at.format("%Y-%m-%dT%H:%M:%SZ").to_string();
+            let expires_at_iso = crate::util::format_rfc3339(expires_at);
             let _ = jwt_blocklist::insert(
                 ctx,
                 NewBlocklistEntry {
@@ -7,18 +7,18 @@ use wafer_run::{context::Context, InputStream, Message, OutputStream};
 use crate::{
     blocks::{
         auth::{helpers::get_user_roles, repo::users},
-        errors::{error_response, ErrorCode},
+        errors::{error_json, ErrorCode},
     },
-    http::{err_bad_request, err_internal, err_not_found, ok_json},
+    http::{err_internal, ok_json},
 };
 
 pub async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let user_id = msg.user_id();
     if user_id.is_empty() {
-        return error_response(ErrorCode::NotAuthenticated, "Not authenticated");
+        return error_json(ErrorCode::NotAuthenticated, "Not authenticated", None);
     }
-    let Ok(Some(user)) = users::find_by_id(ctx, user_id).await else {
-        return err_not_found("User not found");
+    let Ok(Some(user)) = users::find_active_by_id(ctx, user_id).await else {
+        return error_json(ErrorCode::NotFound, "User not found", None);
     };
     let roles = match get_user_roles(ctx, user_id).await {
         Ok(r) => r,
@@ -31,7 +31,8 @@ pub async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
             "name": user.display_name,
             "roles": roles,
             "created_at": user.created_at,
-            "avatar_url": user.avatar_url.unwrap_or_default()
+            "avatar_url": user.avatar_url.unwrap_or_default(),
+            "login_alerts": !user.mute_login_alerts
         }
     }))
 }
@@ -39,17 +40,21 @@ pub async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
 pub async fn handle_update(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
     let user_id = msg.user_id();
     if user_id.is_empty() {
-        return error_response(ErrorCode::NotAuthenticated, "Not authenticated");
+        return error_json(ErrorCode::NotAuthenticated, "Not authenticated", None);
     }
 
-    let raw = input.collect_to_bytes().await;
-    let body: HashMap<String, serde_json::Value> = match serde_json::from_slice(&raw) {
+    let body: HashMap<String, serde_json::Value> = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
 
-    // Only `name` and `avatar_url` are user-editable. `name` dual-writes
-    // display_name + the legacy name alias inside update_profile.
+    // Only `name`, `avatar_url` and `login_alerts` are user-editable. `name`
+    // dual-writes display_name + the legacy name alias inside update_profile.
+    if let Some(enabled) = body.get("login_alerts").and_then(|v| v.as_bool()) {
+        if let Err(e) = users::set_mute_login_alerts(ctx, user_id, !enabled).await {
+            return err_internal("Update failed", e.to_string());
+        }
+    }
     let name = body.get("name").and_then(|v| v.as_str());
     let avatar_url = body.get("avatar_url").and_then(|v| v.as_str());
 
@@ -63,7 +68,8 @@ pub async fn handle_update(ctx: &dyn Context, msg: &Message, input: InputStream)
                 "id": user.id,
                 "email": user.email,
                 "name": user.display_name,
-                "roles": roles
+                "roles": roles,
+                "login_alerts": !user.mute_login_alerts
             }))
         }
         Err(e) => err_internal("Update failed", e.to_string()),
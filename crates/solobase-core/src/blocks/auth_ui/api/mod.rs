@@ -1,18 +1,25 @@
 //! JSON API handlers for the auth-ui block. One handler per leaf module;
 //! routed from `auth_ui::AuthUiBlock::handle`.
 
-use wafer_run::{context::Context, InputStream, Message};
+use wafer_run::context::Context;
 
+pub mod announcements;
 pub mod api_keys;
 pub mod bootstrap;
 pub mod change_password;
+pub mod flags;
 pub mod forgot_password;
 pub mod login;
 pub mod logout;
 pub mod me;
-mod password_policy;
+pub mod not_me;
+pub mod notifications;
+pub(crate) mod password_policy;
+pub mod profile;
+pub mod quotas;
 pub mod refresh;
 pub mod reset_password;
+pub(crate) mod reset_token;
 pub mod signup;
 pub mod sync_user;
 pub mod verify;
@@ -25,27 +32,21 @@ pub mod verify;
 /// swallowed: email delivery is best-effort, and a 5xx from the email block
 /// must not turn a successful signup / reset request into an error.
 pub(crate) async fn send_template_email(ctx: &dyn Context, template: &str, to: &str, token: &str) {
-    let req = serde_json::json!({
-        "template": template,
-        "to": to,
-        "token": token,
-    });
-    let email_msg = Message {
-        kind: "email.send_template".to_string(),
-        meta: Vec::new(),
-    };
-    let body_bytes = serde_json::to_vec(&req).unwrap_or_default();
-    let out = ctx
-        .call_block(
-            "suppers-ai/email",
-            email_msg,
-            InputStream::from_bytes(body_bytes),
-        )
+    crate::services::Services::new(ctx, super::AUTH_UI_BLOCK_ID)
+        .mailer()
+        .send_template(template, to, token)
         .await;
-    if let Err(e) = out.collect_buffered().await {
-        tracing::warn!(
-            template = %template,
-            "Failed to send {template} email to {to}: {e:?}"
-        );
+}
+
+/// Run once an account has confirmed `email`: signup without verification,
+/// a completed verification, or a new OAuth account. Hands over the file
+/// shares that were waiting for the address. Best-effort like the email
+/// above — a failure is logged and the signup or verification stands.
+pub(crate) async fn email_confirmed(ctx: &dyn Context, user_id: &str, email: &str) {
+    #[cfg(feature = "block-files")]
+    if let Err(e) = crate::blocks::files::attach_pending_grants(ctx, user_id, email).await {
+        tracing::warn!(error = %e, user_id, "attaching pending shares failed");
     }
+    #[cfg(not(feature = "block-files"))]
+    let _ = (ctx, user_id, email);
 }
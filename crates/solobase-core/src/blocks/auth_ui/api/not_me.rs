@@ -0,0 +1,162 @@
+//! POST /b/auth/api/not-me — the "this wasn't me" action of a new-device
+//! sign-in alert (see `auth_ui::login_alerts`).
+//!
+//! Signs the reported device out — revokes its refresh family and deletes the
+//! family's session rows — then emails a password reset link, since whoever
+//! signed in had the password. A plain form POST from `pages::not_me`; the
+//! email link itself only opens that confirmation page, so mail scanners
+//! that prefetch links can't trigger it.
+
+use wafer_run::{context::Context, InputStream, OutputStream};
+
+use crate::{
+    blocks::{
+        auth::repo::{sessions, tokens, users},
+        auth_ui::login_alerts,
+    },
+    http::{err_internal, redirect},
+    util::parse_form_body,
+};
+
+pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let form = parse_form_body(&raw);
+    let token = form.get("token").map(String::as_str).unwrap_or("");
+
+    let Some((user_id, family)) = login_alerts::verify_link(ctx, token).await else {
+        return redirect(303, "/b/auth/not-me?error=invalid");
+    };
+
+    if let Err(e) = tokens::revoke_family(ctx, &family).await {
+        return err_internal("Failed to revoke sign-in", e);
+    }
+    if let Err(e) = sessions::delete_family_for_user(ctx, &user_id, &family).await {
+        return err_internal("Failed to delete sessions", e);
+    }
+
+    // Disabled or deleted accounts can't reset; the sign-out above is all
+    // that's left to do for them.
+    if let Ok(Some(user)) = users::find_active_by_id(ctx, &user_id).await {
+        if let Err(r) = super::forgot_password::send_reset_link(ctx, &user.id, &user.email).await {
+            return r;
+        }
+    }
+
+    redirect(303, "/b/auth/not-me?done=1")
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use wafer_core::clients::crypto;
+
+    use super::*;
+    use crate::{
+        blocks::auth::{
+            devices::Device,
+            helpers::{expected_issuer, issue_tokens_and_cookie},
+        },
+        test_support::{output_status, TestContext},
+        util::json_map,
+    };
+
+    async fn ctx_with_crypto() -> TestContext {
+        let mut ctx = TestContext::with_auth().await;
+        let svc = Arc::new(
+            wafer_block_crypto::service::Argon2JwtCryptoService::new(
+                "test-jwt-secret-padded-to-min-32-bytes-aaaa".to_string(),
+            )
+            .expect("test secret is long enough"),
+        );
+        let crypto_block: Arc<dyn wafer_run::Block> =
+            Arc::new(wafer_core::service_blocks::crypto::CryptoBlock::new(svc));
+        ctx.register_block("wafer-run/crypto", crypto_block);
+        ctx
+    }
+
+    async fn link_token(ctx: &TestContext, user_id: &str, family: &str) -> String {
+        let claims = json_map(serde_json::json!({
+            "sub": user_id,
+            "family": family,
+            "type": login_alerts::LINK_TOKEN_TYPE,
+            "iss": expected_issuer(ctx).await,
+        }));
+        crypto::sign(ctx, &claims, std::time::Duration::from_secs(60))
+            .await
+            .unwrap()
+    }
+
+    fn form(token: &str) -> InputStream {
+        InputStream::from_bytes(format!("token={token}").into_bytes())
+    }
+
+    #[tokio::test]
+    async fn signs_out_the_reported_device_only() {
+        let ctx = ctx_with_crypto().await;
+        let user = users::insert(
+            &ctx,
+            users::NewUser {
+                email: "victim@example.com".into(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap();
+        let roles = vec!["user".to_string()];
+        let laptop = Device::new("Firefox/128.0 (X11; Linux)", "203.0.113.4", None);
+        let stranger = Device::new("Chrome/126.0 (Windows)", "198.51.100.9", None);
+        let mine = issue_tokens_and_cookie(
+            &ctx,
+            &user.id,
+            &user.email,
+            &roles,
+            "password",
+            None,
+            0,
+            Some(&laptop),
+        )
+        .await
+        .unwrap_or_else(|_| panic!("issuing tokens failed"));
+        let theirs = issue_tokens_and_cookie(
+            &ctx,
+            &user.id,
+            &user.email,
+            &roles,
+            "password",
+            None,
+            0,
+            Some(&stranger),
+        )
+        .await
+        .unwrap_or_else(|_| panic!("issuing tokens failed"));
+
+        let token = link_token(&ctx, &user.id, &theirs.family).await;
+        let out = handle(&ctx, form(&token)).await;
+        assert_eq!(output_status(out).await, 303);
+
+        let left = sessions::list_for_user(&ctx, &user.id).await.unwrap();
+        assert_eq!(left.len(), 1);
+        assert_eq!(left[0].family, mine.family);
+        let revoked = tokens::find_by_token(&ctx, &theirs.refresh_token)
+            .await
+            .unwrap()
+            .expect("row kept as a tombstone");
+        assert!(revoked.revoked);
+    }
+
+    #[tokio::test]
+    async fn rejects_tokens_of_another_type() {
+        let ctx = ctx_with_crypto().await;
+        let claims = json_map(serde_json::json!({
+            "sub": "u1", "family": "f1", "type": "access",
+        }));
+        let token = crypto::sign(&ctx, &claims, std::time::Duration::from_secs(60))
+            .await
+            .unwrap();
+        assert_eq!(login_alerts::verify_link(&ctx, &token).await, None);
+        assert_eq!(login_alerts::verify_link(&ctx, "garbage").await, None);
+    }
+}
@@ -0,0 +1,231 @@
+//! The caller's notification inbox:
+//!
+//! - `GET /b/auth/api/notifications` — newest first, paged per
+//!   [`crate::pagination`], with `unread_count` alongside the rows.
+//!   `?unread=true` lists unread ones only.
+//! - `POST /b/auth/api/notifications/{id}/read` — mark one read.
+//!   Idempotent.
+//! - `POST /b/auth/api/notifications/read-all` — mark every unread one
+//!   read.
+//! - `GET|PATCH /b/auth/api/notifications/preferences` — per-type email
+//!   choices, as `{"email": {"<type>": bool}}`. A PATCH only touches the
+//!   types it names.
+
+use std::collections::BTreeMap;
+
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use crate::{
+    blocks::{
+        errors::{error_response, ErrorCode},
+        notifications,
+    },
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+    pagination,
+};
+
+/// Most types one preferences PATCH may set.
+const MAX_PREFERENCE_TYPES: usize = 50;
+
+fn not_authenticated() -> OutputStream {
+    error_response(ErrorCode::NotAuthenticated, "Not authenticated")
+}
+
+pub async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let user_id = msg.user_id();
+    if user_id.is_empty() {
+        return not_authenticated();
+    }
+    let query = match pagination::parse(msg, &notifications::LIST_SPEC) {
+        Ok(q) => q,
+        Err(e) => return e.response(),
+    };
+    let unread_only = matches!(msg.query("unread"), "true" | "1");
+    let mut body = match notifications::list(ctx, user_id, unread_only, &query).await {
+        Ok(body) => body,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let unread = match notifications::unread_count(ctx, user_id).await {
+        Ok(n) => n,
+        Err(e) => return err_internal("Database error", e),
+    };
+    body["unread_count"] = serde_json::json!(unread);
+    ok_json(&body)
+}
+
+/// `id` is the `{id}` segment of the read path.
+pub async fn handle_read(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    if msg.user_id().is_empty() {
+        return not_authenticated();
+    }
+    match notifications::mark_read(ctx, msg.user_id(), id).await {
+        Ok(true) => ok_json(&serde_json::json!({ "read": true })),
+        Ok(false) => err_not_found("Notification not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+pub async fn handle_read_all(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    if msg.user_id().is_empty() {
+        return not_authenticated();
+    }
+    match notifications::mark_all_read(ctx, msg.user_id()).await {
+        Ok(n) => ok_json(&serde_json::json!({ "updated": n })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+pub async fn handle_get_preferences(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    if msg.user_id().is_empty() {
+        return not_authenticated();
+    }
+    match notifications::email_preferences(ctx, msg.user_id()).await {
+        Ok(email) => ok_json(&serde_json::json!({ "email": email })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+pub async fn handle_update_preferences(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Body {
+        email: BTreeMap<String, bool>,
+    }
+    if msg.user_id().is_empty() {
+        return not_authenticated();
+    }
+    let body: Body = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    if body.email.len() > MAX_PREFERENCE_TYPES {
+        return err_bad_request("Too many notification types in one request");
+    }
+    if body.email.keys().any(|k| k.trim().is_empty()) {
+        return err_bad_request("Notification type cannot be empty");
+    }
+    for (kind, email) in &body.email {
+        if let Err(e) =
+            notifications::set_email_preference(ctx, msg.user_id(), kind.trim(), *email).await
+        {
+            return err_internal("Database error", e);
+        }
+    }
+    handle_get_preferences(ctx, msg).await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        blocks::notifications::NotificationPayload,
+        test_support::{anon_msg, auth_msg, output_is_error, output_json, TestContext},
+    };
+
+    async fn seed(ctx: &TestContext, user_id: &str, title: &str) -> String {
+        let payload = NotificationPayload {
+            title: title.into(),
+            ..Default::default()
+        };
+        notifications::notify(ctx, user_id, "test.event", &payload)
+            .await
+            .unwrap()
+            .id
+    }
+
+    fn titles(v: &serde_json::Value, key: &str) -> Vec<String> {
+        v[key]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|n| n["title"].as_str().unwrap().to_string())
+            .collect()
+    }
+
+    #[tokio::test]
+    async fn list_pages_the_callers_inbox_with_unread_count() {
+        let ctx = TestContext::with_auth().await;
+        for title in ["one", "two", "three"] {
+            seed(&ctx, "alice", title).await;
+        }
+        seed(&ctx, "bob", "not yours").await;
+
+        let msg = auth_msg("retrieve", "/b/auth/api/notifications", "alice");
+        let body = output_json(handle_list(&ctx, &msg).await).await;
+        assert_eq!(body["unread_count"], 3);
+        assert_eq!(titles(&body, "records").len(), 3);
+
+        let mut msg = auth_msg("retrieve", "/b/auth/api/notifications", "alice");
+        msg.set_meta("req.query.limit", "2");
+        let body = output_json(handle_list(&ctx, &msg).await).await;
+        assert_eq!(titles(&body, "data").len(), 2);
+        assert!(body["pagination"]["next_cursor"].is_string());
+        assert_eq!(body["unread_count"], 3);
+
+        let anon = anon_msg("retrieve", "/b/auth/api/notifications");
+        let out = handle_list(&ctx, &anon).await;
+        assert!(output_is_error(out, "Unauthenticated").await);
+    }
+
+    #[tokio::test]
+    async fn read_and_read_all_update_the_unread_count() {
+        let ctx = TestContext::with_auth().await;
+        let first = seed(&ctx, "alice", "first").await;
+        seed(&ctx, "alice", "second").await;
+        let bobs = seed(&ctx, "bob", "bob's").await;
+
+        let path = format!("/b/auth/api/notifications/{first}/read");
+        let alice = auth_msg("create", &path, "alice");
+        for _ in 0..2 {
+            let body = output_json(handle_read(&ctx, &alice, &first).await).await;
+            assert_eq!(body["read"], true, "marking read is idempotent");
+        }
+        let out = handle_read(&ctx, &alice, &bobs).await;
+        assert!(output_is_error(out, "NotFound").await);
+
+        let mut unread = auth_msg("retrieve", "/b/auth/api/notifications", "alice");
+        unread.set_meta("req.query.unread", "true");
+        let body = output_json(handle_list(&ctx, &unread).await).await;
+        assert_eq!(titles(&body, "records"), ["second"]);
+        assert_eq!(body["unread_count"], 1);
+
+        let all = auth_msg("create", "/b/auth/api/notifications/read-all", "alice");
+        let body = output_json(handle_read_all(&ctx, &all).await).await;
+        assert_eq!(body["updated"], 1);
+        assert_eq!(notifications::unread_count(&ctx, "bob").await.unwrap(), 1);
+    }
+
+    #[tokio::test]
+    async fn preferences_round_trip() {
+        let ctx = TestContext::with_auth().await;
+        let path = "/b/auth/api/notifications/preferences";
+        let patch = |body: serde_json::Value| {
+            (
+                auth_msg("update", path, "alice"),
+                InputStream::from_bytes(serde_json::to_vec(&body).unwrap()),
+            )
+        };
+
+        let (msg, input) =
+            patch(serde_json::json!({ "email": { "files.quota_threshold": false } }));
+        let body = output_json(handle_update_preferences(&ctx, &msg, input).await).await;
+        assert_eq!(body["email"]["files.quota_threshold"], false);
+
+        let (msg, input) = patch(serde_json::json!({ "email": { "test.event": true } }));
+        handle_update_preferences(&ctx, &msg, input).await;
+        let msg = auth_msg("retrieve", path, "alice");
+        let body = output_json(handle_get_preferences(&ctx, &msg).await).await;
+        assert_eq!(
+            body["email"],
+            serde_json::json!({ "files.quota_threshold": false, "test.event": true })
+        );
+
+        let (msg, input) = patch(serde_json::json!({ "email": "yes" }));
+        let body = output_json(handle_update_preferences(&ctx, &msg, input).await).await;
+        assert_eq!(body["code"], "validation_failed");
+        assert_eq!(body["details"]["email"], "expected a map");
+    }
+}
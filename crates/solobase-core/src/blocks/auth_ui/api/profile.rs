@@ -0,0 +1,563 @@
+//! Self-service profile: GET / PATCH /b/auth/api/profile, plus the
+//! email-change flow — POST /b/auth/api/profile/email and
+//! GET /b/auth/api/confirm-email-change.
+//!
+//! An email change doesn't take effect when requested. The new address gets
+//! a confirmation link and the current one keeps working until that link is
+//! opened; confirming swaps the address, signs every device out, and tells
+//! the old address it was changed.
+
+use serde_json::Value;
+use wafer_core::clients::crypto;
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use crate::{
+    blocks::{
+        auth::{
+            helpers::email_domain_allowed,
+            repo::{
+                sessions, tokens,
+                users::{self, Profile, ProfileUpdate},
+            },
+        },
+        errors::{error_json, validation_error, ErrorCode},
+    },
+    http::{err_internal, ok_json},
+    util::{hex_encode, sha256_hex},
+};
+
+/// Largest profile request body accepted.
+const MAX_BODY_BYTES: i64 = 16 * 1024;
+/// Longest first or last name, in characters.
+const MAX_NAME_CHARS: usize = 100;
+/// Longest display name, in characters — the signup name cap.
+const MAX_DISPLAY_NAME_CHARS: usize = 200;
+/// Longest location, in characters.
+const MAX_LOCATION_CHARS: usize = 200;
+/// Largest client-writable metadata object, serialized.
+const MAX_USER_METADATA_BYTES: usize = 4 * 1024;
+/// How long an email-change confirmation link stays valid.
+const EMAIL_CHANGE_TTL_HOURS: i64 = 24;
+
+pub async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let user_id = msg.user_id();
+    if user_id.is_empty() {
+        return error_json(ErrorCode::NotAuthenticated, "Not authenticated", None);
+    }
+    match users::find_profile(ctx, user_id).await {
+        Ok(Some(profile)) => ok_json(&profile_json(&profile)),
+        Ok(None) => error_json(ErrorCode::NotFound, "User not found", None),
+        Err(e) => err_internal("Failed to load profile", e.to_string()),
+    }
+}
+
+#[derive(serde::Deserialize)]
+#[serde(deny_unknown_fields)]
+struct UpdateReq {
+    first_name: Option<String>,
+    last_name: Option<String>,
+    display_name: Option<String>,
+    phone: Option<String>,
+    location: Option<String>,
+    /// Replaces the client-writable `metadata.user` object. Its `locale`
+    /// is the language API errors and emails are sent in (`crate::i18n`).
+    metadata: Option<Value>,
+}
+
+pub async fn handle_update(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let user_id = msg.user_id();
+    if user_id.is_empty() {
+        return error_json(ErrorCode::NotAuthenticated, "Not authenticated", None);
+    }
+    let body: UpdateReq = match crate::body::decode_capped(msg, input, MAX_BODY_BYTES).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+
+    let update = match validate_update(body) {
+        Ok(update) => update,
+        Err(problems) => return validation_error("Invalid profile", &problems),
+    };
+
+    // Only `metadata.user` is the client's; the rest of the object is kept
+    // as stored.
+    let update = match update.metadata {
+        Some(user_metadata) => {
+            let mut metadata = match users::find_profile(ctx, user_id).await {
+                Ok(Some(profile)) => profile.metadata,
+                Ok(None) => return error_json(ErrorCode::NotFound, "User not found", None),
+                Err(e) => return err_internal("Failed to load profile", e.to_string()),
+            };
+            metadata["user"] = user_metadata;
+            ProfileUpdate {
+                metadata: Some(metadata),
+                ..update
+            }
+        }
+        None => update,
+    };
+
+    match users::update_profile_fields(ctx, user_id, update).await {
+        Ok(profile) => ok_json(&profile_json(&profile)),
+        Err(e) => err_internal("Update failed", e.to_string()),
+    }
+}
+
+/// Trim and check every supplied field. An empty string clears a field.
+/// Returns each offending field with its reason.
+fn validate_update(body: UpdateReq) -> Result<ProfileUpdate, Vec<(&'static str, &'static str)>> {
+    let mut problems = Vec::new();
+    let mut text = |field: &'static str, value: Option<String>, max: usize, reason| {
+        let value = value.map(|v| v.trim().to_string());
+        if value.as_ref().is_some_and(|v| v.chars().count() > max) {
+            problems.push((field, reason));
+        }
+        value
+    };
+    let first_name = text(
+        "first_name",
+        body.first_name,
+        MAX_NAME_CHARS,
+        "must not exceed 100 characters",
+    );
+    let last_name = text(
+        "last_name",
+        body.last_name,
+        MAX_NAME_CHARS,
+        "must not exceed 100 characters",
+    );
+    let display_name = text(
+        "display_name",
+        body.display_name,
+        MAX_DISPLAY_NAME_CHARS,
+        "must not exceed 200 characters",
+    );
+    let location = text(
+        "location",
+        body.location,
+        MAX_LOCATION_CHARS,
+        "must not exceed 200 characters",
+    );
+
+    let phone = body.phone.map(|p| p.trim().to_string());
+    if phone
+        .as_deref()
+        .is_some_and(|p| !p.is_empty() && !is_e164(p))
+    {
+        problems.push(("phone", "must be in E.164 format, e.g. +14155550123"));
+    }
+
+    if let Some(metadata) = &body.metadata {
+        if !metadata.is_object() {
+            problems.push(("metadata", "must be a JSON object"));
+        } else if metadata.to_string().len() > MAX_USER_METADATA_BYTES {
+            problems.push(("metadata", "must not exceed 4096 bytes"));
+        }
+    }
+
+    if !problems.is_empty() {
+        return Err(problems);
+    }
+    Ok(ProfileUpdate {
+        first_name,
+        last_name,
+        display_name,
+        phone,
+        location,
+        metadata: body.metadata,
+    })
+}
+
+/// `+` then 2 to 15 digits, the first not zero.
+fn is_e164(phone: &str) -> bool {
+    let Some(digits) = phone.strip_prefix('+') else {
+        return false;
+    };
+    (2..=15).contains(&digits.len())
+        && digits.bytes().all(|b| b.is_ascii_digit())
+        && !digits.starts_with('0')
+}
+
+fn profile_json(profile: &Profile) -> Value {
+    let user = &profile.user;
+    serde_json::json!({
+        "id": user.id,
+        "email": user.email,
+        "email_verified": user.email_verified,
+        "pending_email": profile.pending_email,
+        "display_name": user.display_name,
+        "first_name": profile.first_name,
+        "last_name": profile.last_name,
+        "phone": profile.phone,
+        "location": profile.location,
+        "avatar_url": user.avatar_url.clone().unwrap_or_default(),
+        "metadata": profile.metadata,
+        "created_at": user.created_at,
+        "updated_at": user.updated_at,
+    })
+}
+
+// ---------------------------------------------------------------------------
+// Email change
+// ---------------------------------------------------------------------------
+
+pub async fn handle_change_email(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    let user_id = msg.user_id();
+    if user_id.is_empty() {
+        return error_json(ErrorCode::NotAuthenticated, "Not authenticated", None);
+    }
+    #[derive(serde::Deserialize)]
+    struct Req {
+        email: String,
+    }
+    let body: Req = match crate::body::decode_capped(msg, input, MAX_BODY_BYTES).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+
+    let new_email = body.email.trim().to_lowercase();
+    let parts: Vec<&str> = new_email.splitn(2, '@').collect();
+    if parts.len() != 2 || parts[0].is_empty() || parts[1].is_empty() || !parts[1].contains('.') {
+        return error_json(ErrorCode::InvalidEmail, "Invalid email address", None);
+    }
+    if new_email.len() > 255 {
+        return error_json(
+            ErrorCode::InvalidEmail,
+            "Email must not exceed 255 characters",
+            None,
+        );
+    }
+    if !email_domain_allowed(ctx, &new_email).await {
+        return error_json(
+            ErrorCode::InvalidEmail,
+            "Email addresses from this domain are not allowed",
+            None,
+        );
+    }
+
+    let user = match users::find_active_by_id(ctx, user_id).await {
+        Ok(Some(user)) => user,
+        Ok(None) => return error_json(ErrorCode::NotFound, "User not found", None),
+        Err(e) => return err_internal("Failed to load user", e.to_string()),
+    };
+    if user.email == new_email {
+        return error_json(
+            ErrorCode::InvalidInput,
+            "That is already your email address",
+            None,
+        );
+    }
+    match users::find_by_email(ctx, &new_email).await {
+        Ok(None) => {}
+        Ok(Some(_)) => {
+            return error_json(
+                ErrorCode::EmailAlreadyExists,
+                "That email address is already in use",
+                None,
+            )
+        }
+        Err(e) => return err_internal("Failed to check email", e.to_string()),
+    }
+
+    // The raw token goes in the link; only its SHA-256 hex is stored.
+    let token = match crypto::random_bytes(ctx, 32).await {
+        Ok(bytes) => hex_encode(&bytes),
+        Err(e) => return err_internal("Token generation failed", e),
+    };
+    let expires = crate::util::format_rfc3339(
+        chrono::Utc::now() + chrono::Duration::hours(EMAIL_CHANGE_TTL_HOURS),
+    );
+    if let Err(e) = users::set_email_change(
+        ctx,
+        user_id,
+        &new_email,
+        &sha256_hex(token.as_bytes()),
+        &expires,
+    )
+    .await
+    {
+        return err_internal("Failed to store email change", e.to_string());
+    }
+
+    super::send_template_email(ctx, "email_change", &new_email, &token).await;
+
+    ok_json(&serde_json::json!({
+        "message": "Check your new email address for a confirmation link.",
+        "pending_email": new_email,
+    }))
+}
+
+pub async fn handle_confirm_email_change(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let logo_url = ctx
+        .config_get("SOLOBASE_SHARED__AUTH_LOGO_URL")
+        .unwrap_or("")
+        .to_string();
+    let invalid = || {
+        super::verify::html_respond(
+            "Invalid Link",
+            "This confirmation link is invalid or has expired. Request the email change again from your profile.",
+            false,
+            &logo_url,
+        )
+    };
+
+    let token = msg.get_meta("req.query.token");
+    if token.is_empty() {
+        return invalid();
+    }
+    let change = match users::find_by_email_change_token(ctx, &sha256_hex(token.as_bytes())).await {
+        Ok(Some(change)) => change,
+        Ok(None) => return invalid(),
+        Err(e) => return err_internal("Failed to look up email change", e.to_string()),
+    };
+    let expired =
+        crate::util::parse_timestamp(&change.expires).map_or(true, |exp| chrono::Utc::now() > exp);
+    if expired || change.pending_email.is_empty() {
+        return invalid();
+    }
+
+    // The address may have been taken since the change was requested.
+    match users::find_by_email(ctx, &change.pending_email).await {
+        Ok(None) => {}
+        Ok(Some(_)) => {
+            return super::verify::html_respond(
+                "Email Unavailable",
+                "That email address is now in use by another account. Your email was not changed.",
+                false,
+                &logo_url,
+            )
+        }
+        Err(e) => return err_internal("Failed to check email", e.to_string()),
+    }
+
+    if let Err(e) = users::complete_email_change(ctx, &change.id, &change.pending_email).await {
+        return err_internal("Failed to change email", e.to_string());
+    }
+
+    // Sign out everywhere: the sessions were opened under the old address.
+    // SEC-032/039: revoke (don't delete) refresh rows so the reuse-detection
+    // tombstones survive.
+    tokens::revoke_all_for_user(ctx, &change.id).await.ok();
+    sessions::delete_all_for_user(ctx, &change.id).await.ok();
+
+    crate::services::Services::new(ctx, super::super::AUTH_UI_BLOCK_ID)
+        .mailer()
+        .send(
+            "email.send_template",
+            serde_json::json!({
+                "template": "email_changed",
+                "to": change.email,
+                "new_email": change.pending_email,
+            }),
+        )
+        .await;
+
+    super::verify::html_respond(
+        "Email Changed",
+        "Your email address has been changed and you have been signed out everywhere. Sign in with your new address.",
+        true,
+        &logo_url,
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use super::*;
+    use crate::{
+        blocks::auth::{devices::Device, helpers::issue_tokens_and_cookie},
+        test_support::{auth_msg, output_body, output_json, output_status, TestContext},
+    };
+
+    async fn ctx_with_crypto() -> TestContext {
+        let mut ctx = TestContext::with_auth().await;
+        let svc = Arc::new(
+            wafer_block_crypto::service::Argon2JwtCryptoService::new(
+                "test-jwt-secret-padded-to-min-32-bytes-aaaa".to_string(),
+            )
+            .expect("test secret is long enough"),
+        );
+        let crypto_block: Arc<dyn wafer_run::Block> =
+            Arc::new(wafer_core::service_blocks::crypto::CryptoBlock::new(svc));
+        ctx.register_block("wafer-run/crypto", crypto_block);
+        ctx
+    }
+
+    async fn seed_user(ctx: &TestContext, email: &str) -> users::UserRow {
+        users::insert(
+            ctx,
+            users::NewUser {
+                email: email.into(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap()
+    }
+
+    fn msg_for(user_id: &str) -> Message {
+        auth_msg("update", "/b/auth/api/profile", user_id)
+    }
+
+    fn body(value: Value) -> InputStream {
+        InputStream::from_bytes(value.to_string().into_bytes())
+    }
+
+    #[test]
+    fn e164_shapes() {
+        assert!(is_e164("+14155550123"));
+        assert!(is_e164("+442071838750"));
+        assert!(!is_e164("14155550123"));
+        assert!(!is_e164("+0123456"));
+        assert!(!is_e164("+1 415 555 0123"));
+        assert!(!is_e164("+1234567890123456"));
+    }
+
+    #[tokio::test]
+    async fn update_writes_fields_and_keeps_server_metadata() {
+        let ctx = TestContext::with_auth().await;
+        let user = seed_user(&ctx, "profile@example.com").await;
+        let server = ProfileUpdate {
+            metadata: Some(serde_json::json!({"plan": "pro"})),
+            ..Default::default()
+        };
+        users::update_profile_fields(&ctx, &user.id, server)
+            .await
+            .unwrap();
+
+        let out = handle_update(
+            &ctx,
+            &msg_for(&user.id),
+            body(serde_json::json!({
+                "first_name": "  Ada ",
+                "last_name": "Lovelace",
+                "phone": "+14155550123",
+                "metadata": {"theme": "dark"},
+            })),
+        )
+        .await;
+        let json = output_json(out).await;
+        assert_eq!(json["first_name"], "Ada");
+        assert_eq!(json["phone"], "+14155550123");
+        assert_eq!(json["metadata"]["plan"], "pro");
+        assert_eq!(json["metadata"]["user"]["theme"], "dark");
+    }
+
+    #[tokio::test]
+    async fn update_rejects_invalid_fields() {
+        let ctx = TestContext::with_auth().await;
+        let user = seed_user(&ctx, "invalid@example.com").await;
+        let long = "x".repeat(MAX_USER_METADATA_BYTES);
+        let out = handle_update(
+            &ctx,
+            &msg_for(&user.id),
+            body(serde_json::json!({
+                "first_name": "a".repeat(101),
+                "phone": "555-0123",
+                "metadata": {"blob": long},
+            })),
+        )
+        .await;
+        let json = output_json(out).await;
+        assert_eq!(json["code"], "validation_failed");
+        assert!(json["details"]["first_name"].is_string());
+        assert!(json["details"]["phone"].is_string());
+        assert!(json["details"]["metadata"].is_string());
+
+        let out = handle_update(
+            &ctx,
+            &msg_for(&user.id),
+            body(serde_json::json!({"email": "sneaky@example.com"})),
+        )
+        .await;
+        let json = output_json(out).await;
+        assert_eq!(json["details"]["email"], "unknown field");
+    }
+
+    #[tokio::test]
+    async fn change_email_refuses_a_taken_address() {
+        let ctx = ctx_with_crypto().await;
+        let user = seed_user(&ctx, "mover@example.com").await;
+        seed_user(&ctx, "taken@example.com").await;
+        let out = handle_change_email(
+            &ctx,
+            &msg_for(&user.id),
+            body(serde_json::json!({"email": "Taken@Example.com"})),
+        )
+        .await;
+        assert_eq!(output_json(out).await["code"], "email_already_exists");
+    }
+
+    #[tokio::test]
+    async fn confirm_swaps_the_email_and_signs_out() {
+        let ctx = ctx_with_crypto().await;
+        let user = seed_user(&ctx, "old@example.com").await;
+        let laptop = Device::new("Firefox/128.0 (X11; Linux)", "203.0.113.4", None);
+        let issued = issue_tokens_and_cookie(
+            &ctx,
+            &user.id,
+            &user.email,
+            &["user".to_string()],
+            "password",
+            None,
+            0,
+            Some(&laptop),
+        )
+        .await
+        .unwrap_or_else(|_| panic!("issuing tokens failed"));
+
+        let out = handle_change_email(
+            &ctx,
+            &msg_for(&user.id),
+            body(serde_json::json!({"email": "new@example.com"})),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 200);
+        // The old address stays in use until the link is opened.
+        let pending = users::find_profile(&ctx, &user.id).await.unwrap().unwrap();
+        assert_eq!(pending.user.email, "old@example.com");
+        assert_eq!(pending.pending_email, "new@example.com");
+
+        // The mailer is unregistered here; plant a known token instead.
+        let expires = crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::hours(1));
+        users::set_email_change(
+            &ctx,
+            &user.id,
+            "new@example.com",
+            &sha256_hex(b"known-token"),
+            &expires,
+        )
+        .await
+        .unwrap();
+        let mut msg = crate::test_support::anon_msg("retrieve", "/b/auth/api/confirm-email-change");
+        msg.set_meta("req.query.token", "known-token");
+        let out = handle_confirm_email_change(&ctx, &msg).await;
+        assert_eq!(output_status(out).await, 200);
+
+        let changed = users::find_profile(&ctx, &user.id).await.unwrap().unwrap();
+        assert_eq!(changed.user.email, "new@example.com");
+        assert!(changed.user.email_verified);
+        assert!(changed.pending_email.is_empty());
+        assert!(sessions::list_for_user(&ctx, &user.id)
+            .await
+            .unwrap()
+            .is_empty());
+        let revoked = tokens::find_by_token(&ctx, &issued.refresh_token)
+            .await
+            .unwrap()
+            .expect("row kept as a tombstone");
+        assert!(revoked.revoked);
+
+        // The link works once.
+        let out = handle_confirm_email_change(&ctx, &msg).await;
+        let html = output_body(out).await;
+        assert!(String::from_utf8_lossy(&html).contains("Invalid Link"));
+    }
+}
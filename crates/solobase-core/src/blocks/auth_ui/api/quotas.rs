@@ -0,0 +1,21 @@
+//! GET /b/auth/api/quotas/usage — the caller's own usage-quota standing.
+
+use wafer_run::{context::Context, Message, OutputStream};
+
+use crate::{
+    blocks::{
+        api_quota,
+        errors::{error_response, ErrorCode},
+    },
+    http::{err_internal, ok_json},
+};
+
+pub async fn handle_usage(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    if msg.user_id().is_empty() {
+        return error_response(ErrorCode::NotAuthenticated, "Not authenticated");
+    }
+    match api_quota::usage_for(ctx, msg).await {
+        Ok(quotas) => ok_json(&serde_json::json!({ "quotas": quotas })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
@@ -12,35 +12,39 @@
 //!    pair.
 
 use wafer_core::clients::{config, crypto};
-use wafer_run::{context::Context, InputStream, OutputStream};
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
 
 use crate::{
     blocks::{
         auth::{
+            devices::Device,
             helpers::{ensure_admin_role, expected_issuer, issue_tokens_and_cookie},
             repo::{tokens, users},
         },
-        errors::{error_response, ErrorCode},
+        errors::{error_json, ErrorCode},
     },
-    http::{err_bad_request, err_internal, ResponseBuilder},
+    http::{err_internal, ResponseBuilder},
 };
 
-pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
+pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
     #[derive(serde::Deserialize)]
     struct RefreshReq {
         refresh_token: String,
     }
-    let raw = input.collect_to_bytes().await;
-    let body: RefreshReq = match serde_json::from_slice(&raw) {
+    let body: RefreshReq = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
 
     // Verify the JWT signature/expiry. A valid signature alone is not enough
     // — the row lookup below is the source of truth for "this token has not
     // been used or revoked yet".
     let Ok(claims) = crypto::verify(ctx, &body.refresh_token).await else {
-        return error_response(ErrorCode::InvalidToken, "Invalid or expired refresh token");
+        return error_json(
+            ErrorCode::InvalidToken,
+            "Invalid or expired refresh token",
+            None,
+        );
     };
 
     let Some(user_id) = claims
@@ -50,12 +54,12 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
         .filter(|s| !s.is_empty())
         .map(str::to_owned)
     else {
-        return error_response(ErrorCode::InvalidToken, "Invalid refresh token");
+        return error_json(ErrorCode::InvalidToken, "Invalid refresh token", None);
     };
 
     let token_type = claims.get("type").and_then(|v| v.as_str()).unwrap_or("");
     if token_type != "refresh" {
-        return error_response(ErrorCode::InvalidToken, "Not a refresh token");
+        return error_json(ErrorCode::InvalidToken, "Not a refresh token", None);
     }
 
     // [SEC-038] Require the iss claim to match this deployment. A refresh
@@ -65,7 +69,11 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     let expected_iss = expected_issuer(ctx).await;
     let iss = claims.get("iss").and_then(|v| v.as_str()).unwrap_or("");
     if iss != expected_iss {
-        return error_response(ErrorCode::InvalidToken, "Invalid or expired refresh token");
+        return error_json(
+            ErrorCode::InvalidToken,
+            "Invalid or expired refresh token",
+            None,
+        );
     }
 
     // SEC-032: look up the row by SHA-256 hash of the JWT — the raw token
@@ -77,11 +85,15 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
             // its tombstone has since been wiped, or this is a forged
             // refresh token whose family we never minted. Either way, no
             // family to revoke; just refuse.
-            return error_response(ErrorCode::InvalidToken, "Refresh token has been revoked");
+            return error_json(
+                ErrorCode::InvalidToken,
+                "Refresh token has been revoked",
+                None,
+            );
         }
         Err(e) => {
             tracing::warn!("refresh: token lookup failed: {e}");
-            return error_response(ErrorCode::InvalidToken, "Invalid refresh token");
+            return error_json(ErrorCode::InvalidToken, "Invalid refresh token", None);
         }
     };
 
@@ -97,7 +109,11 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
             );
             let _ = tokens::revoke_family(ctx, &row.family).await;
         }
-        return error_response(ErrorCode::InvalidToken, "Refresh token has been revoked");
+        return error_json(
+            ErrorCode::InvalidToken,
+            "Refresh token has been revoked",
+            None,
+        );
     }
 
     // Get user and verify account is still active. Use the typed repo so
@@ -105,18 +121,21 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     // raw `db::get`.
     let user = match users::find_by_id(ctx, &user_id).await {
         Ok(Some(u)) => u,
-        Ok(None) => return error_response(ErrorCode::NotAuthenticated, "User not found"),
-        Err(_) => return error_response(ErrorCode::NotAuthenticated, "User not found"),
+        Ok(None) => return error_json(ErrorCode::NotAuthenticated, "User not found", None),
+        Err(_) => return error_json(ErrorCode::NotAuthenticated, "User not found", None),
     };
 
+    // A disabled or soft-deleted account can't refresh, and the family is
+    // burned so re-enabling the account doesn't revive this token.
     if !user.is_active() {
-        return error_response(ErrorCode::AccountDisabled, "Account is disabled");
+        let _ = tokens::revoke_family(ctx, &row.family).await;
+        return error_json(ErrorCode::AccountDisabled, "Account is disabled", None);
     }
 
     let require_verification =
         config::get_default(ctx, "SUPPERS_AI__AUTH__REQUIRE_VERIFICATION", "false").await;
     if (require_verification == "true" || require_verification == "1") && !user.email_verified {
-        return error_response(ErrorCode::EmailNotVerified, "Email not verified");
+        return error_json(ErrorCode::EmailNotVerified, "Email not verified", None);
     }
 
     let email = user.email.clone();
@@ -143,7 +162,11 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     // then fails the user simply gets logged out — a recoverable UX outcome.
     if let Err(e) = tokens::revoke_by_id(ctx, &row.id).await {
         tracing::warn!("refresh: failed to revoke prior token row: {e}");
-        return error_response(ErrorCode::InvalidToken, "Could not rotate refresh token");
+        return error_json(
+            ErrorCode::InvalidToken,
+            "Could not rotate refresh token",
+            None,
+        );
     }
 
     // Re-issue within the *preserved* family (SEC-039): passing
@@ -151,7 +174,8 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     // the new refresh JWT so its `family` claim agrees with the DB row that
     // anchors reuse detection. `generation = row.generation + 1` advances the
     // rotation counter. This is the same shared issuance tail every other
-    // login flow uses, so the userportal session row is written here too.
+    // login flow uses, so the userportal session row is written here too,
+    // labelled with the device doing the refresh.
     let issued = match issue_tokens_and_cookie(
         ctx,
         &user_id,
@@ -160,6 +184,7 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
         &prior_auth_method,
         Some(&row.family),
         row.generation + 1,
+        Some(&Device::from_message(msg)),
     )
     .await
     {
@@ -176,3 +201,72 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
             "expires_in": issued.access_lifetime
         }))
 }
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use wafer_core::clients::database as db;
+
+    use super::*;
+    use crate::{
+        blocks::auth::USERS_TABLE,
+        test_support::{anon_msg, output_json, TestContext},
+    };
+
+    async fn ctx_with_crypto() -> TestContext {
+        let mut ctx = TestContext::with_auth().await;
+        let svc = Arc::new(
+            wafer_block_crypto::service::Argon2JwtCryptoService::new(
+                "test-jwt-secret-padded-to-min-32-bytes-aaaa".to_string(),
+            )
+            .expect("test secret is long enough"),
+        );
+        let crypto_block: Arc<dyn wafer_run::Block> =
+            Arc::new(wafer_core::service_blocks::crypto::CryptoBlock::new(svc));
+        ctx.register_block("wafer-run/crypto", crypto_block);
+        ctx
+    }
+
+    async fn refresh(ctx: &TestContext, token: &str) -> OutputStream {
+        let body = serde_json::json!({ "refresh_token": token }).to_string();
+        let msg = anon_msg("create", "/b/auth/api/refresh");
+        handle(ctx, &msg, InputStream::from_bytes(body.into_bytes())).await
+    }
+
+    #[tokio::test]
+    async fn soft_deleted_account_cannot_refresh() {
+        let ctx = ctx_with_crypto().await;
+        let body = serde_json::json!({
+            "email": "gone@example.com",
+            "password": "correct-horse-battery",
+        });
+        let msg = anon_msg("create", "/b/auth/api/signup");
+        let out = super::super::signup::handle(
+            &ctx,
+            &msg,
+            InputStream::from_bytes(body.to_string().into_bytes()),
+        )
+        .await;
+        let signed_up = output_json(out).await;
+        let token = signed_up["refresh_token"].as_str().expect("auto-login");
+
+        // A live account rotates its token.
+        let rotated = output_json(refresh(&ctx, token).await).await;
+        let token = rotated["refresh_token"].as_str().expect("rotated");
+
+        let family = tokens::find_by_token(&ctx, token)
+            .await
+            .unwrap()
+            .expect("token row")
+            .family;
+
+        let user_id = signed_up["user"]["id"].as_str().unwrap();
+        db::soft_delete(&ctx, USERS_TABLE, user_id).await.unwrap();
+        let refused = output_json(refresh(&ctx, token).await).await;
+        assert_eq!(refused["code"], "account_disabled");
+        // The family is burned, so restoring the account doesn't revive
+        // the token.
+        assert!(!tokens::family_has_live_row(&ctx, &family).await.unwrap());
+    }
+}
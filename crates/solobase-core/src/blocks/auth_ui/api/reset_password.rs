@@ -1,65 +1,54 @@
 //! POST /b/auth/api/reset-password — relocated from auth/login.rs in Task 5.
 
 use wafer_core::clients::crypto;
-use wafer_run::{context::Context, InputStream, OutputStream};
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
 
+use super::reset_token::{self, Rejected};
 use crate::{
     blocks::{
-        auth::repo::{local_credentials, tokens, users},
-        errors::{error_response, ErrorCode},
+        auth::repo::{local_credentials, sessions, tokens, users},
+        errors::{error_json, ErrorCode},
     },
-    http::{err_bad_request, err_internal, ok_json},
-    util::sha256_hex,
+    http::{err_internal, ok_json},
 };
 
-pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
+pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
     #[derive(serde::Deserialize)]
     struct Req {
         token: String,
         new_password: String,
     }
-    let raw = input.collect_to_bytes().await;
-    let body: Req = match serde_json::from_slice(&raw) {
+    let body: Req = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
+    if let Err(r) = crate::body::require(&[("token", &body.token)]) {
+        return r;
+    }
 
     if let Err((code, msg)) =
         super::password_policy::validate_new_password(ctx, &body.new_password).await
     {
-        return error_response(code, &msg);
+        return error_json(code, &msg, None);
     }
 
-    // Find user by reset token. The DB column stores `sha256_hex(raw)`;
-    // hash the supplied token the same way before comparing.
-    let Ok(Some(user)) = users::find_by_reset_token(ctx, &sha256_hex(body.token.as_bytes())).await
-    else {
-        return error_response(ErrorCode::InvalidToken, "Invalid or expired reset token");
-    };
-
-    // Check expiry — reject if missing or malformed (tokens must have an expiry)
-    if user.reset_token_expires.is_empty() {
-        return error_response(
-            ErrorCode::TokenExpired,
-            "Reset token has expired. Please request a new one.",
-        );
-    }
-    match chrono::DateTime::parse_from_rfc3339(&user.reset_token_expires) {
-        Ok(exp) => {
-            if chrono::Utc::now() > exp.with_timezone(&chrono::Utc) {
-                return error_response(
-                    ErrorCode::TokenExpired,
-                    "Reset token has expired. Please request a new one.",
-                );
-            }
+    let user_id = match reset_token::check(ctx, &body.token).await {
+        Ok(id) => id,
+        Err(Rejected::Invalid) => {
+            return error_json(
+                ErrorCode::InvalidToken,
+                "Invalid or expired reset token",
+                None,
+            )
         }
-        Err(_) => {
-            return error_response(
+        Err(Rejected::Expired) => {
+            return error_json(
                 ErrorCode::TokenExpired,
                 "Reset token has expired. Please request a new one.",
-            );
+                None,
+            )
         }
-    }
+    };
 
     // Hash new password
     let new_hash = match crypto::hash(ctx, &body.new_password).await {
@@ -68,19 +57,28 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     };
 
     // Update credential row (typed path, no password_hash on users table).
-    if let Err(e) = local_credentials::update_password(ctx, &user.id, &new_hash).await {
+    if let Err(e) = local_credentials::update_password(ctx, &user_id, &new_hash).await {
         return err_internal("Failed to update password", e);
     }
 
     // Clear reset token on the users row.
-    if let Err(e) = users::clear_reset_token(ctx, &user.id).await {
+    if let Err(e) = users::clear_reset_token(ctx, &user_id).await {
         return err_internal("Failed to clear reset token", e.to_string());
     }
 
+    // A reset picks a fresh password, which satisfies a forced change too.
+    if let Err(e) = users::set_must_change_password(ctx, &user_id, false).await {
+        return err_internal("Failed to clear password-change requirement", e.to_string());
+    }
+
     // Revoke all refresh tokens — invalidate any stolen sessions.
     // SEC-032/039: mark rows revoked (don't delete) so the reuse-detection
     // tombstones survive across the password reset.
-    tokens::revoke_all_for_user(ctx, &user.id).await.ok();
+    tokens::revoke_all_for_user(ctx, &user_id).await.ok();
+    // End every signed-in session too, so whoever else holds one is out.
+    if let Err(e) = sessions::delete_all_for_user(ctx, &user_id).await {
+        tracing::warn!(user_id = %user_id, error = %e, "failed to delete sessions on reset");
+    }
 
     ok_json(&serde_json::json!({"message": "Password reset successfully"}))
 }
@@ -0,0 +1,139 @@
+//! Single source of truth for password-reset tokens: the self-service
+//! forgot/reset flow, admin-issued reset links and the set-password link of
+//! imported accounts all mint and check them here. Only the token's SHA-256
+//! hex is stored (`reset_token` on the users row, next to its absolute
+//! expiry), so a leak of the row (admin SQL explorer, backup, log dump) does
+//! not become a password-reset oracle. Issuing a token replaces any earlier
+//! one, and a successful reset clears it, so each link works once.
+
+use wafer_core::clients::{config, crypto};
+use wafer_run::context::Context;
+
+use crate::{
+    blocks::auth::repo::users,
+    util::{hex_encode, sha256_hex, urlencode},
+};
+
+/// Why a presented token can't be redeemed.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum Rejected {
+    /// No account holds it: mistyped, already used, or replaced by a newer
+    /// one.
+    Invalid,
+    Expired,
+}
+
+/// Mint a reset token for `user_id`, valid for `valid_for`, and return the
+/// raw token for the link.
+pub(crate) async fn issue(
+    ctx: &dyn Context,
+    user_id: &str,
+    valid_for: chrono::Duration,
+) -> Result<String, String> {
+    let token = crypto::random_bytes(ctx, 32)
+        .await
+        .map(|bytes| hex_encode(&bytes))
+        .map_err(|e| format!("token generation failed: {}", e.message))?;
+    let expires = crate::util::format_rfc3339(crate::clock::now() + valid_for);
+    users::set_reset_token(ctx, user_id, &sha256_hex(token.as_bytes()), &expires)
+        .await
+        .map_err(|e| format!("storing the reset token failed: {e}"))?;
+    Ok(token)
+}
+
+/// The id of the account `token` resets. A token without a parseable expiry
+/// counts as expired.
+pub(crate) async fn check(ctx: &dyn Context, token: &str) -> Result<String, Rejected> {
+    let Ok(Some(user)) = users::find_by_reset_token(ctx, &sha256_hex(token.as_bytes())).await
+    else {
+        return Err(Rejected::Invalid);
+    };
+    match crate::util::parse_timestamp(&user.reset_token_expires) {
+        Some(expires) if crate::clock::now() <= expires => Ok(user.id),
+        _ => Err(Rejected::Expired),
+    }
+}
+
+/// The reset-password page link for `token`, as the `password_reset` email
+/// builds it.
+pub(crate) async fn link(ctx: &dyn Context, token: &str) -> String {
+    let base_url = config::get_default(
+        ctx,
+        "SOLOBASE_SHARED__FRONTEND_URL",
+        "http://localhost:5173",
+    )
+    .await;
+    format!(
+        "{}/b/auth/reset-password?token={}",
+        crate::base_path::public_url(&base_url),
+        urlencode(token)
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use super::*;
+    use crate::test_support::TestContext;
+
+    async fn ctx_with_crypto() -> TestContext {
+        let mut ctx = TestContext::with_auth().await;
+        let svc = Arc::new(
+            wafer_block_crypto::service::Argon2JwtCryptoService::new(
+                "test-jwt-secret-padded-to-min-32-bytes-aaaa".to_string(),
+            )
+            .expect("test secret is long enough"),
+        );
+        let crypto_block: Arc<dyn wafer_run::Block> =
+            Arc::new(wafer_core::service_blocks::crypto::CryptoBlock::new(svc));
+        ctx.register_block("wafer-run/crypto", crypto_block);
+        ctx
+    }
+
+    async fn user(ctx: &TestContext) -> String {
+        users::insert(
+            ctx,
+            users::NewUser {
+                email: "locked-out@example.com".into(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap()
+        .id
+    }
+
+    #[tokio::test]
+    async fn issued_token_checks_until_replaced() {
+        let ctx = ctx_with_crypto().await;
+        let id = user(&ctx).await;
+
+        let first = issue(&ctx, &id, chrono::Duration::hours(1)).await.unwrap();
+        assert_eq!(check(&ctx, &first).await, Ok(id.clone()));
+
+        let second = issue(&ctx, &id, chrono::Duration::hours(1)).await.unwrap();
+        assert_eq!(check(&ctx, &first).await, Err(Rejected::Invalid));
+        assert_eq!(check(&ctx, &second).await, Ok(id.clone()));
+
+        users::clear_reset_token(&ctx, &id).await.unwrap();
+        assert_eq!(check(&ctx, &second).await, Err(Rejected::Invalid));
+    }
+
+    #[tokio::test]
+    async fn token_expires_exactly_at_its_lifetime() {
+        use crate::clock;
+
+        let ctx = ctx_with_crypto().await;
+        let id = user(&ctx).await;
+        clock::freeze(clock::at("2026-05-01T09:00:00Z"));
+        let token = issue(&ctx, &id, chrono::Duration::hours(1)).await.unwrap();
+
+        clock::advance(chrono::Duration::hours(1));
+        assert_eq!(check(&ctx, &token).await, Ok(id));
+        clock::advance(chrono::Duration::seconds(1));
+        assert_eq!(check(&ctx, &token).await, Err(Rejected::Expired));
+    }
+}
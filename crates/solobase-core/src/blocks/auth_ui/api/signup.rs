@@ -1,21 +1,22 @@
 //! POST /b/auth/api/signup — relocated from auth/login.rs in Task 5.
 
 use wafer_core::clients::{config, crypto, database as db};
-use wafer_run::{context::Context, InputStream, OutputStream};
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
 
 use crate::{
     blocks::{
         auth::{
+            config::SignupMode,
             helpers::{
-                email_domain_allowed, initial_role_for, issue_tokens_and_cookie, signup_allowed,
+                email_domain_allowed, initial_role_for, issue_tokens_and_cookie, signup_mode,
             },
-            repo::{local_credentials, users},
+            repo::{invitations, local_credentials, users},
             USERS_TABLE,
         },
-        auth_ui::redirect::{default_post_login_redirect, is_safe_local_redirect},
-        errors::{error_response, ErrorCode},
+        auth_ui::redirect::post_login_default,
+        errors::{error_json, ErrorCode},
     },
-    http::{err_bad_request, err_internal, ResponseBuilder},
+    http::{err_internal, ResponseBuilder},
     util::{hex_encode, json_map, sha256_hex},
 };
 
@@ -23,17 +24,33 @@ use crate::{
 /// when not. Any DB failure other than NOT_FOUND propagates — see [SEC-035]
 /// note below; collapsing a WRAP denial or connection blip to "email is free"
 /// would let a duplicate insert race in past the unique-email constraint.
+///
+/// A soft-deleted account still exists here: its address stays blocked
+/// until the `deleted_users` retention policy purges the row. Signup never
+/// restores a deleted account — an admin deleted it, and the person signing
+/// up may not be its owner.
 async fn user_exists(ctx: &dyn Context, email_lower: &str) -> Result<bool, String> {
     match users::find_by_email(ctx, email_lower).await {
-        Ok(opt) => Ok(opt.is_some()),
+        Ok(Some(user)) => {
+            if user.is_deleted() {
+                tracing::info!(user_id = %user.id, "signup refused: address belongs to a deleted account");
+            }
+            Ok(true)
+        }
+        Ok(None) => Ok(false),
         Err(e) => Err(format!("{e}")),
     }
 }
 
-pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
-    // Enforce ALLOW_SIGNUP on the API (not just the page)
-    if !signup_allowed(ctx).await {
-        return error_response(ErrorCode::Forbidden, "Signups are currently disabled");
+pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    // Enforce the signup mode on the API (not just the page)
+    let mode = signup_mode(ctx).await;
+    if mode == SignupMode::Closed {
+        return error_json(
+            ErrorCode::SignupClosed,
+            "Signups are currently disabled",
+            None,
+        );
     }
 
     #[derive(serde::Deserialize)]
@@ -41,49 +58,73 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
         email: String,
         password: String,
         name: Option<String>,
+        /// Raw token from an admin invitation. Required in invite-only mode;
+        /// accepted in the others to pick up the invitation's role.
+        invite_token: Option<String>,
+        /// Sent by `solobase-js`'s `signUp`; accepted and not stored.
+        #[serde(rename = "metadata")]
+        _metadata: Option<serde_json::Value>,
     }
-    let raw = input.collect_to_bytes().await;
-    let body: SignupReq = match serde_json::from_slice(&raw) {
+    let body: SignupReq = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
 
     let email_lower = body.email.trim().to_lowercase();
     let parts: Vec<&str> = email_lower.splitn(2, '@').collect();
     if parts.len() != 2 || parts[0].is_empty() || parts[1].is_empty() || !parts[1].contains('.') {
-        return error_response(ErrorCode::InvalidEmail, "Invalid email address");
+        return error_json(ErrorCode::InvalidEmail, "Invalid email address", None);
+    }
+
+    let invite_token = body
+        .invite_token
+        .as_deref()
+        .map(str::trim)
+        .filter(|t| !t.is_empty());
+    if mode == SignupMode::InviteOnly && invite_token.is_none() {
+        return error_json(
+            ErrorCode::InvitationRequired,
+            "An invitation is required to sign up",
+            None,
+        );
     }
 
-    // Check allowed email domains (if configured)
-    if !email_domain_allowed(ctx, &email_lower).await {
-        return error_response(
+    // Check allowed email domains (if configured). An invitation names the
+    // address itself, so it stands in for the domain check; its token is
+    // verified below.
+    if invite_token.is_none() && !email_domain_allowed(ctx, &email_lower).await {
+        return error_json(
             ErrorCode::InvalidEmail,
             "Signups from this email domain are not allowed",
+            None,
         );
     }
 
     if let Err((code, msg)) =
         super::password_policy::validate_new_password(ctx, &body.password).await
     {
-        return error_response(code, &msg);
+        return error_json(code, &msg, None);
     }
     if email_lower.len() > 255 {
-        return error_response(
+        return error_json(
             ErrorCode::InvalidEmail,
             "Email must not exceed 255 characters",
+            None,
         );
     }
     if let Some(ref name) = body.name {
         if name.len() > 200 {
-            return error_response(
+            return error_json(
                 ErrorCode::InvalidInput,
                 "Name must not exceed 200 characters",
+                None,
             );
         }
     }
 
-    // [SEC-035] If the email is already registered, do NOT confirm that to
-    // the caller — return the same generic "check your email" response a
+    // [SEC-035] If the email is already registered — by a live account or
+    // a soft-deleted one awaiting purge — do NOT confirm that to the
+    // caller: return the same generic "check your email" response a
     // fresh signup would produce. The signup endpoint is otherwise a free
     // email-enumeration oracle for password-reset / phishing campaigns.
     //
@@ -130,9 +171,33 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
         String::new()
     };
 
+    // Redeem the invitation. `consume` flips it to accepted in one
+    // conditional update, so a token can only ever create one account.
+    let invitation = match invite_token {
+        Some(token) => {
+            match invitations::consume(ctx, &sha256_hex(token.as_bytes()), &email_lower).await {
+                Ok(Some(inv)) => Some(inv),
+                Ok(None) => {
+                    return error_json(
+                        ErrorCode::InvitationInvalid,
+                        "This invitation is invalid or has expired",
+                        None,
+                    )
+                }
+                Err(e) => return err_internal("Invitation lookup failed", e),
+            }
+        }
+        None => None,
+    };
+
     // Determine the role: admin if the email matches the configured bootstrap
-    // admin email (re-uses the same key as bootstrap for consistency).
-    let role = initial_role_for(ctx, &email_lower).await;
+    // admin email (re-uses the same key as bootstrap for consistency),
+    // otherwise the role the invitation pre-assigned.
+    let role = match (initial_role_for(ctx, &email_lower).await, &invitation) {
+        ("admin", _) => "admin".to_string(),
+        (_, Some(inv)) => inv.role.clone(),
+        (default, None) => default.to_string(),
+    };
 
     // Insert via typed repo — no password_hash on the users row.
     let user = match users::insert(
@@ -141,14 +206,26 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
             email: email_lower.clone(),
             display_name: body.name.unwrap_or_default(),
             avatar_url: None,
-            role: role.to_string(),
+            role: role.clone(),
         },
     )
     .await
     {
         Ok(u) => u,
-        Err(e) => return err_internal("Failed to create user", e),
+        Err(e) => {
+            if let Some(inv) = &invitation {
+                if let Err(e) = invitations::release(ctx, &inv.id).await {
+                    tracing::warn!("Failed to release invitation {}: {e}", inv.id);
+                }
+            }
+            return err_internal("Failed to create user", e);
+        }
     };
+    if let Some(inv) = &invitation {
+        if let Err(e) = invitations::set_accepted_by(ctx, &inv.id, &user.id).await {
+            tracing::warn!("Failed to record invitation {} acceptance: {e}", inv.id);
+        }
+    }
 
     if let Err(e) = local_credentials::insert(ctx, &user.id, &password_hash, false).await {
         return err_internal("Failed to store credentials", e);
@@ -174,7 +251,10 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
         }
     }
 
-    let roles = vec![role.to_string()];
+    let roles = vec![role];
+    if !require_verification {
+        super::email_confirmed(ctx, &user.id, &email_lower).await;
+    }
 
     // Send verification email if required
     if require_verification {
@@ -194,27 +274,28 @@ pub async fn handle(ctx: &dyn Context, input: InputStream) -> OutputStream {
     // (only when email verification is NOT required) — this is the
     // auto-login path: a brand-new user is fully signed in by the time this
     // response reaches the browser, no separate login step needed.
-    let issued =
-        match issue_tokens_and_cookie(ctx, &user.id, &email_lower, &roles, "password", None, 0)
-            .await
-        {
-            Ok(i) => i,
-            Err(r) => return r,
-        };
+    let issued = match issue_tokens_and_cookie(
+        ctx,
+        &user.id,
+        &email_lower,
+        &roles,
+        "password",
+        None,
+        0,
+        None,
+    )
+    .await
+    {
+        Ok(i) => i,
+        Err(r) => return r,
+    };
 
     // Role-aware post-login default (Fix 2 / signup UX): a brand-new signup
     // is (almost) never an admin, so this sends them to `/b/userportal/`
     // instead of the silent bounce to `/b/auth/login` the page used to do —
     // same single-sourced rule Fix 1 applies to login/OAuth/bootstrap.
-    let post_login_raw =
-        config::get_default(ctx, "SOLOBASE_SHARED__POST_LOGIN_REDIRECT", "/b/admin/").await;
-    let admin_default = if is_safe_local_redirect(&post_login_raw) {
-        post_login_raw
-    } else {
-        "/b/admin/".to_string()
-    };
     let is_admin = roles.iter().any(|r| r == "admin");
-    let default_redirect = default_post_login_redirect(is_admin, &admin_default);
+    let default_redirect = post_login_default(ctx, is_admin).await;
 
     ResponseBuilder::new()
         .status(201)
@@ -250,7 +331,10 @@ mod tests {
     use crate::test_support::{output_json, TestContext};
 
     async fn ctx_with_crypto() -> TestContext {
-        let mut ctx = TestContext::with_auth().await;
+        with_crypto(TestContext::with_auth().await)
+    }
+
+    fn with_crypto(mut ctx: TestContext) -> TestContext {
         let svc = Arc::new(
             wafer_block_crypto::service::Argon2JwtCryptoService::new(
                 "test-jwt-secret-padded-to-min-32-bytes-aaaa".to_string(),
@@ -269,6 +353,37 @@ mod tests {
         output_json(out).await
     }
 
+    #[cfg(feature = "block-files")]
+    #[tokio::test]
+    async fn signup_attaches_shares_pending_for_the_address() {
+        use crate::blocks::files::repo::acls;
+
+        let ctx = with_crypto(TestContext::with_files().await);
+        acls::upsert_pending(
+            &ctx,
+            acls::NewPendingGrant {
+                bucket: "team",
+                path: "docs/",
+                grantee_email: "frank@example.com",
+                permission: "read",
+                granted_by: "alice",
+            },
+        )
+        .await
+        .unwrap();
+
+        let resp = signup(&ctx, "Frank@Example.com", "correct-horse-battery").await;
+        let user_id = resp["user"]["id"].as_str().expect("signed up");
+        let held = acls::find_covering(&ctx, "team", user_id, &["docs/".to_string()])
+            .await
+            .unwrap();
+        assert_eq!(held.len(), 1, "the share follows the confirmed address");
+        assert!(acls::list_pending_for_email(&ctx, "frank@example.com")
+            .await
+            .unwrap()
+            .is_empty());
+    }
+
     #[tokio::test]
     async fn regular_signup_auto_logs_in_and_defaults_to_userportal() {
         let ctx = ctx_with_crypto().await;
@@ -327,6 +442,143 @@ mod tests {
         assert!(!user.email_verified);
     }
 
+    /// The solobase error code an error response carries.
+    async fn error_code(out: OutputStream) -> Option<String> {
+        output_json(out).await["code"].as_str().map(str::to_string)
+    }
+
+    async fn signup_with(
+        ctx: &TestContext,
+        email: &str,
+        invite_token: Option<&str>,
+    ) -> OutputStream {
+        let body = serde_json::json!({
+            "email": email,
+            "password": "correct-horse-battery",
+            "invite_token": invite_token,
+        });
+        handle(ctx, InputStream::from_bytes(body.to_string().into_bytes())).await
+    }
+
+    async fn invite(ctx: &TestContext, email: &str, token: &str, role: &str) {
+        let expires_at =
+            crate::util::format_rfc3339(crate::clock::now() + chrono::Duration::days(1));
+        invitations::insert(
+            ctx,
+            invitations::NewInvitation {
+                email,
+                token_hash: &sha256_hex(token.as_bytes()),
+                role,
+                invited_by: "admin-1",
+                expires_at: &expires_at,
+            },
+        )
+        .await
+        .unwrap();
+    }
+
+    #[tokio::test]
+    async fn closed_mode_rejects_signup_with_a_code() {
+        let mut ctx = ctx_with_crypto().await;
+        ctx.set_config("SUPPERS_AI__AUTH__SIGNUP_MODE", "closed");
+        let out = signup_with(&ctx, "a@example.com", None).await;
+        assert_eq!(error_code(out).await.as_deref(), Some("signup_closed"));
+
+        // The legacy shared toggle closes signup too.
+        let mut ctx = ctx_with_crypto().await;
+        ctx.set_config("SOLOBASE_SHARED__ALLOW_SIGNUP", "false");
+        let out = signup_with(&ctx, "a@example.com", None).await;
+        assert_eq!(error_code(out).await.as_deref(), Some("signup_closed"));
+    }
+
+    #[tokio::test]
+    async fn domain_allowlist_mode_admits_only_listed_domains() {
+        let mut ctx = ctx_with_crypto().await;
+        ctx.set_config("SUPPERS_AI__AUTH__SIGNUP_MODE", "domain-allowlist");
+        let out = signup_with(&ctx, "a@example.com", None).await;
+        assert_eq!(
+            error_code(out).await.as_deref(),
+            Some("invalid_email"),
+            "an empty allowlist admits no one"
+        );
+
+        ctx.set_config("SUPPERS_AI__AUTH__ALLOWED_EMAIL_DOMAINS", "example.com");
+        let resp = signup(&ctx, "a@example.com", "correct-horse-battery").await;
+        assert_eq!(resp["email_verified"], true);
+    }
+
+    #[tokio::test]
+    async fn invite_only_mode_requires_and_consumes_an_invitation() {
+        let mut ctx = ctx_with_crypto().await;
+        ctx.set_config("SUPPERS_AI__AUTH__SIGNUP_MODE", "invite-only");
+        invite(&ctx, "invited@example.com", "tok-1", "editor").await;
+
+        let out = signup_with(&ctx, "invited@example.com", None).await;
+        assert_eq!(
+            error_code(out).await.as_deref(),
+            Some("invitation_required")
+        );
+
+        let out = signup_with(&ctx, "other@example.com", Some("tok-1")).await;
+        assert_eq!(
+            error_code(out).await.as_deref(),
+            Some("invitation_invalid"),
+            "an invitation only admits the address it was issued to"
+        );
+
+        let out = signup_with(&ctx, "invited@example.com", Some("tok-1")).await;
+        let resp = output_json(out).await;
+        assert_eq!(
+            resp["user"]["roles"],
+            serde_json::json!(["editor"]),
+            "{resp}"
+        );
+        let user = users::find_by_email(&ctx, "invited@example.com")
+            .await
+            .unwrap()
+            .unwrap();
+        let listed = invitations::list(&ctx, Some(invitations::STATUS_ACCEPTED), 10)
+            .await
+            .unwrap();
+        assert_eq!(listed[0].accepted_by, user.id);
+
+        let out = signup_with(&ctx, "second@example.com", Some("tok-1")).await;
+        assert_eq!(error_code(out).await.as_deref(), Some("invitation_invalid"));
+    }
+
+    #[tokio::test]
+    async fn soft_deleted_email_stays_taken_until_purged() {
+        let ctx = ctx_with_crypto().await;
+        signup(&ctx, "gone@example.com", "correct-horse-battery").await;
+        let old = users::find_by_email(&ctx, "gone@example.com")
+            .await
+            .unwrap()
+            .unwrap();
+        db::soft_delete(&ctx, USERS_TABLE, &old.id).await.unwrap();
+
+        // Refused with the generic duplicate answer; the deleted account is
+        // neither restored nor replaced.
+        let resp = signup(&ctx, "gone@example.com", "another-horse-battery").await;
+        assert_eq!(resp["user"]["id"], "");
+        assert!(resp.get("access_token").is_none());
+        let row = users::find_by_email(&ctx, "gone@example.com")
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(row.id, old.id);
+        assert!(row.is_deleted());
+
+        // Once purged, the address is free again.
+        users::purge(&ctx, &old.id).await.unwrap();
+        let resp = signup(&ctx, "gone@example.com", "another-horse-battery").await;
+        assert!(resp.get("access_token").is_some());
+        let fresh = users::find_active_by_email(&ctx, "gone@example.com")
+            .await
+            .unwrap()
+            .unwrap();
+        assert_ne!(fresh.id, old.id);
+    }
+
     #[tokio::test]
     async fn duplicate_email_signup_response_has_no_default_redirect() {
         let ctx = ctx_with_crypto().await;
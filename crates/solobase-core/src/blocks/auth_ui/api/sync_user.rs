@@ -4,37 +4,48 @@ use wafer_core::clients::config;
 use wafer_run::{context::Context, InputStream, Message, OutputStream};
 
 use crate::{
-    blocks::auth::repo::users,
-    http::{err_bad_request, err_forbidden, err_internal, err_unauthorized, ok_json},
+    blocks::{
+        auth::repo::users,
+        errors::{error_json, ErrorCode},
+    },
+    http::{err_internal, ok_json},
 };
 
 pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
     // Internal endpoint for OAuth user sync — requires INTERNAL_SECRET
     let expected_secret = config::get_default(ctx, "SUPPERS_AI__AUTH__INTERNAL_SECRET", "").await;
     if expected_secret.is_empty() {
-        return err_forbidden("INTERNAL_SECRET not configured — internal endpoints are disabled");
+        return error_json(
+            ErrorCode::Forbidden,
+            "INTERNAL_SECRET not configured — internal endpoints are disabled",
+            None,
+        );
     }
     let provided_secret = msg.header("x-internal-secret");
     if !wafer_block_crypto::primitives::constant_time_eq(
         provided_secret.as_bytes(),
         expected_secret.as_bytes(),
     ) {
-        return err_unauthorized("Invalid internal secret");
+        return error_json(ErrorCode::InvalidToken, "Invalid internal secret", None);
     }
 
-    // `provider` may still be present in the request body; serde ignores
-    // unknown fields, and the old `oauth_provider` column it fed had no
-    // readers, so it is intentionally not deserialized here.
+    // `provider` may still be present in the request body. The old
+    // `oauth_provider` column it fed had no readers, so it is accepted (the
+    // body decoder rejects undeclared fields) and dropped.
     #[derive(serde::Deserialize)]
     struct SyncReq {
         email: String,
         name: Option<String>,
+        #[serde(rename = "provider")]
+        _provider: Option<String>,
     }
-    let raw = input.collect_to_bytes().await;
-    let body: SyncReq = match serde_json::from_slice(&raw) {
+    let body: SyncReq = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
+    if let Err(r) = crate::body::require(&[("email", &body.email)]) {
+        return r;
+    }
 
     let email_lower = body.email.trim().to_lowercase();
     // `find_by_email` maps NOT_FOUND → Ok(None) and surfaces every other
@@ -42,6 +53,11 @@ pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> Out
     // to "user not found" would race a duplicate insert past the unique-email
     // constraint and corrupt the table.
     let user = match users::find_by_email(ctx, &email_lower).await {
+        // A disabled or soft-deleted account is not revived by a sync; its
+        // address stays taken until the account is purged.
+        Ok(Some(u)) if !u.is_active() => {
+            return error_json(ErrorCode::AccountDisabled, "Account is disabled", None)
+        }
         Ok(Some(u)) => u,
         Ok(None) => {
             // Create through the typed insert so the row matches every other
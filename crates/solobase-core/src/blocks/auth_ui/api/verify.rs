@@ -6,8 +6,11 @@ use wafer_core::clients::crypto;
 use wafer_run::{context::Context, InputStream, Message, OutputStream};
 
 use crate::{
-    blocks::auth::{brand_panel, repo::users},
-    http::{err_bad_request, err_internal, ok_json},
+    blocks::{
+        auth::{brand_panel, repo::users},
+        errors::{error_json, ErrorCode},
+    },
+    http::{err_internal, ok_json},
     ui,
     ui::templates::auth_split,
     util::{hex_encode, sha256_hex},
@@ -32,13 +35,15 @@ pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> Out
             let raw = input.collect_to_bytes().await;
             match serde_json::from_slice::<Req>(&raw) {
                 Ok(r) => r.token,
-                Err(_) => return err_bad_request("Missing verification token"),
+                Err(_) => {
+                    return error_json(ErrorCode::InvalidInput, "Missing verification token", None)
+                }
             }
         }
     };
 
     if token.is_empty() {
-        return err_bad_request("Missing verification token");
+        return error_json(ErrorCode::InvalidInput, "Missing verification token", None);
     }
 
     // Find user by verification token. The DB column stores
@@ -68,6 +73,7 @@ pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> Out
     if let Err(e) = users::mark_email_verified(ctx, &user.id).await {
         return err_internal("Failed to verify email", e.to_string());
     }
+    super::email_confirmed(ctx, &user.id, &user.email).await;
 
     html_respond(
         "Email Verified",
@@ -77,21 +83,20 @@ pub async fn handle(ctx: &dyn Context, msg: &Message, input: InputStream) -> Out
     )
 }
 
-pub async fn handle_resend(ctx: &dyn Context, input: InputStream) -> OutputStream {
+pub async fn handle_resend(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
     #[derive(serde::Deserialize)]
     struct Req {
         email: String,
     }
-    let raw = input.collect_to_bytes().await;
-    let body: Req = match serde_json::from_slice(&raw) {
+    let body: Req = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
 
     let email_lower = body.email.trim().to_lowercase();
     let safe_msg = "If that email is registered, a verification link has been sent.";
 
-    let Ok(Some(user)) = users::find_by_email(ctx, &email_lower).await else {
+    let Ok(Some(user)) = users::find_active_by_email(ctx, &email_lower).await else {
         return ok_json(&serde_json::json!({"message": safe_msg}));
     };
 
@@ -104,8 +109,8 @@ pub async fn handle_resend(ctx: &dyn Context, input: InputStream) -> OutputStrea
         .await
         .unwrap_or_default();
     if !last_sent.is_empty() {
-        if let Ok(last) = chrono::DateTime::parse_from_rfc3339(&last_sent) {
-            let elapsed = chrono::Utc::now() - last.with_timezone(&chrono::Utc);
+        if let Some(last) = crate::util::parse_timestamp(&last_sent) {
+            let elapsed = crate::clock::now() - last;
             let remaining = 60 - elapsed.num_seconds();
             if remaining > 0 {
                 return ok_json(&serde_json::json!({
@@ -135,8 +140,14 @@ pub async fn handle_resend(ctx: &dyn Context, input: InputStream) -> OutputStrea
     ok_json(&serde_json::json!({"message": safe_msg}))
 }
 
-/// Return an HTML page response (for verify endpoints opened in browser).
-fn html_respond(title: &str, message: &str, success: bool, logo_url: &str) -> OutputStream {
+/// Return an HTML page response (for the verify and email-change links
+/// opened in a browser).
+pub(super) fn html_respond(
+    title: &str,
+    message: &str,
+    success: bool,
+    logo_url: &str,
+) -> OutputStream {
     let color = if success { "#10b981" } else { "#ef4444" };
     let config = ui::SiteConfig {
         app_name: "Solobase".into(),
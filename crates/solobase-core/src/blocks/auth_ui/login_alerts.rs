@@ -0,0 +1,149 @@
+//! New-device sign-in alerts.
+//!
+//! A password or OAuth login from a [`Device`] the account has never used
+//! queues a [`NOTIFY_JOB`], which emails the user the device, network,
+//! location and time of the sign-in. The email carries a signed "this wasn't
+//! me" link (`/b/auth/not-me`) that signs out that login's refresh family and
+//! sends a password reset — see `api::not_me`.
+//!
+//! "Never used" means no refresh-token row of the user carries the device
+//! fingerprint. The first device an account records is not alerted on, so a
+//! fresh signup (or the first login after this shipped) stays quiet.
+
+use std::time::Duration;
+
+use wafer_core::clients::{config, crypto};
+use wafer_run::{context::Context, InputStream};
+
+use crate::{
+    blocks::{
+        auth::{
+            config::LOGIN_ALERTS_KEY,
+            devices::Device,
+            helpers::expected_issuer,
+            repo::{tokens, users::UserRow},
+        },
+        jobs::JobError,
+    },
+    services::Services,
+    util::json_map,
+};
+
+/// Job type for the alert email, run by the auth-ui block.
+pub const NOTIFY_JOB: &str = "auth.login_alert";
+
+/// JWT `type` of the "this wasn't me" link. The pipeline only accepts
+/// `access` tokens, so the link can't be replayed as a bearer token.
+pub(crate) const LINK_TOKEN_TYPE: &str = "login_alert";
+
+/// How long the "this wasn't me" link works — one refresh-token lifetime,
+/// the longest the reported session can live without being used.
+const LINK_TTL: Duration = Duration::from_secs(7 * 24 * 3600);
+
+/// Whether `device` is new for `user_id`. Lookup failures count as known:
+/// a missed alert is better than one on every login during an outage.
+pub async fn is_new_device(ctx: &dyn Context, user_id: &str, device: &Device) -> bool {
+    match tokens::devices_for_user(ctx, user_id).await {
+        Ok(known) => !known.is_empty() && !known.contains(&device.fingerprint),
+        Err(e) => {
+            tracing::warn!(error = %e, user_id = %user_id, "login alert device lookup failed");
+            false
+        }
+    }
+}
+
+/// Queue the alert for a sign-in of `user` from `device` that started refresh
+/// family `family`, unless the user or the deployment turned alerts off.
+/// Best-effort: never fails the login.
+pub async fn queue(ctx: &dyn Context, user: &UserRow, device: &Device, family: &str) {
+    if user.mute_login_alerts {
+        return;
+    }
+    let enabled = config::get_default(ctx, LOGIN_ALERTS_KEY, "true").await;
+    if enabled != "true" && enabled != "1" {
+        return;
+    }
+    let payload = serde_json::json!({
+        "user_id": user.id,
+        "email": user.email,
+        "family": family,
+        "signed_in_at": crate::util::now_rfc3339(),
+        "device": device.label,
+        "network": device.network,
+        "location": device.location,
+    });
+    let jobs = Services::new(ctx, super::AUTH_UI_BLOCK_ID).jobs();
+    if let Err(e) = jobs.enqueue(NOTIFY_JOB, &payload, Default::default()).await {
+        tracing::warn!(error = %e, "failed to queue login alert");
+    }
+}
+
+/// Run a [`NOTIFY_JOB`]. The "this wasn't me" token is minted here rather
+/// than at login so no bearer secret sits in the jobs table.
+pub async fn run_job(ctx: &dyn Context, input: InputStream) -> Result<(), JobError> {
+    #[derive(serde::Deserialize)]
+    struct Payload {
+        user_id: String,
+        email: String,
+        family: String,
+        #[serde(default)]
+        signed_in_at: String,
+        #[serde(default)]
+        device: String,
+        #[serde(default)]
+        network: String,
+        #[serde(default)]
+        location: Option<String>,
+    }
+    let raw = input.collect_to_bytes().await;
+    let payload: Payload = serde_json::from_slice(&raw)
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+    if payload.user_id.is_empty() || payload.email.is_empty() || payload.family.is_empty() {
+        return Err(JobError::permanent("missing user_id, email or family"));
+    }
+
+    let claims = json_map(serde_json::json!({
+        "sub": payload.user_id,
+        "family": payload.family,
+        "type": LINK_TOKEN_TYPE,
+        "iss": expected_issuer(ctx).await,
+    }));
+    let token = crypto::sign(ctx, &claims, LINK_TTL)
+        .await
+        .map_err(|e| JobError::transient(format!("signing link failed: {e}")))?;
+
+    let sent = Services::new(ctx, super::AUTH_UI_BLOCK_ID)
+        .mailer()
+        .send(
+            "email.send_template",
+            serde_json::json!({
+                "template": "new_sign_in",
+                "to": payload.email,
+                "token": token,
+                "signed_in_at": payload.signed_in_at,
+                "device": payload.device,
+                "network": payload.network,
+                "location": payload.location,
+            }),
+        )
+        .await;
+    if !sent {
+        return Err(JobError::transient("email delivery failed"));
+    }
+    Ok(())
+}
+
+/// The user and refresh family a "this wasn't me" token names, if the token
+/// is valid, unexpired and was minted by this deployment.
+pub(crate) async fn verify_link(ctx: &dyn Context, token: &str) -> Option<(String, String)> {
+    let claims = crypto::verify(ctx, token).await.ok()?;
+    let claim = |k: &str| claims.get(k).and_then(|v| v.as_str()).unwrap_or("");
+    if claim("type") != LINK_TOKEN_TYPE || claim("iss") != expected_issuer(ctx).await {
+        return None;
+    }
+    let (user_id, family) = (claim("sub"), claim("family"));
+    if user_id.is_empty() || family.is_empty() {
+        return None;
+    }
+    Some((user_id.to_string(), family.to_string()))
+}
@@ -19,6 +19,7 @@
 //! `auth/`) owns the auth *service*; this block owns the HTTP surface.
 
 pub mod api;
+pub(crate) mod login_alerts;
 pub mod oauth;
 pub mod pages;
 pub mod redirect;
@@ -51,13 +52,16 @@ const RATE_LIMIT_ROUTES: &[RouteLimit] = &[
         category: "refresh",
         limit: RateLimit::REFRESH,
     },
-    // Forgot/reset password + verification — keyed by IP, shares the auth bucket.
+    // Forgot/reset password, verification and the sign-in alert's "this
+    // wasn't me" action — keyed by IP, shares the auth bucket.
     RouteLimit {
         matches: |a, p| match p {
             "/auth/api/forgot-password"
             | "/auth/api/reset-password"
-            | "/auth/api/resend-verification" => a == "create",
+            | "/auth/api/resend-verification"
+            | "/auth/api/not-me" => a == "create",
             "/auth/api/verify" => a == "retrieve" || a == "create",
+            "/auth/api/confirm-email-change" => a == "retrieve",
             _ => false,
         },
         key: LimitKey::Ip,
@@ -66,20 +70,39 @@ const RATE_LIMIT_ROUTES: &[RouteLimit] = &[
     },
     // Authenticated read endpoints — keyed by user_id.
     RouteLimit {
-        matches: |a, p| a == "retrieve" && matches!(p, "/auth/api/me" | "/auth/api/api-keys"),
+        matches: |a, p| {
+            a == "retrieve"
+                && matches!(
+                    p,
+                    "/auth/api/me"
+                        | "/auth/api/profile"
+                        | "/auth/api/api-keys"
+                        | "/auth/api/quotas/usage"
+                        | "/auth/api/announcements"
+                        | "/auth/api/notifications"
+                        | "/auth/api/notifications/preferences"
+                        | "/auth/api/flags"
+                )
+        },
         key: LimitKey::User,
         category: "auth_read",
         limit: RateLimit::API_READ,
     },
     // Authenticated write endpoints — keyed by user_id. Catches every update /
-    // delete plus the two non-update write endpoints. Ordered last so the
+    // delete plus the non-update write endpoints. Ordered last so the
     // read rule above wins for retrieves.
     RouteLimit {
         matches: |a, p| {
             a == "update"
                 || a == "delete"
                 || (a == "create"
-                    && matches!(p, "/auth/api/change-password" | "/auth/api/api-keys"))
+                    && (matches!(
+                        p,
+                        "/auth/api/change-password"
+                            | "/auth/api/api-keys"
+                            | "/auth/api/profile/email"
+                    ) || p.starts_with("/auth/api/announcements/")
+                        || p.starts_with("/auth/api/notifications/")))
         },
         key: LimitKey::User,
         category: "auth_write",
@@ -154,6 +177,28 @@ pub(crate) fn config_vars() -> Vec<ConfigVar> {
     ]
 }
 
+/// Output schema shared by GET and PATCH `/b/auth/api/profile`.
+fn profile_schema() -> serde_json::Value {
+    serde_json::json!({
+        "type": "object",
+        "properties": {
+            "id": {"type": "string"},
+            "email": {"type": "string"},
+            "email_verified": {"type": "boolean"},
+            "pending_email": {"type": "string", "description": "Address awaiting confirmation; empty when none"},
+            "display_name": {"type": "string"},
+            "first_name": {"type": "string"},
+            "last_name": {"type": "string"},
+            "phone": {"type": "string"},
+            "location": {"type": "string"},
+            "avatar_url": {"type": "string"},
+            "metadata": {"type": "object", "description": "The client-writable part sits under \"user\""},
+            "created_at": {"type": "string", "format": "date-time"},
+            "updated_at": {"type": "string", "format": "date-time"}
+        }
+    })
+}
+
 crate::solobase_feature_block! {
     /// Solobase auth HTTP surface — SSR pages + JSON API + OAuth + bootstrap
     /// (`suppers-ai/auth-ui`). The auth *service* primitive lives in the
@@ -248,7 +293,8 @@ crate::solobase_feature_block! {
                     "properties": {
                         "email": {"type": "string", "format": "email"},
                         "password": {"type": "string"},
-                        "name": {"type": "string", "description": "Optional display name"}
+                        "name": {"type": "string", "description": "Optional display name"},
+                        "invite_token": {"type": "string", "description": "Invitation token; required when the signup mode is invite-only"}
                     }
                 }))
                 .output_schema(serde_json::json!({
@@ -298,12 +344,58 @@ crate::solobase_feature_block! {
                                 "name": {"type": "string"},
                                 "roles": {"type": "array", "items": {"type": "string"}},
                                 "created_at": {"type": "string", "format": "date-time"},
-                                "avatar_url": {"type": "string"}
+                                "avatar_url": {"type": "string"},
+                                "login_alerts": {"type": "boolean", "description": "Whether new-device sign-in alerts are emailed"}
                             }
                         }
                     }
                 }))
                 .tags(&["auth"]),
+            BlockEndpoint::get("/b/auth/api/profile")
+                .summary("Get the caller's full profile")
+                .auth(AuthLevel::Authenticated)
+                .output_schema(profile_schema())
+                .tags(&["auth"]),
+            BlockEndpoint::patch("/b/auth/api/profile")
+                .summary("Update the caller's profile")
+                .auth(AuthLevel::Authenticated)
+                .input_schema(serde_json::json!({
+                    "type": "object",
+                    "additionalProperties": false,
+                    "properties": {
+                        "first_name": {"type": "string", "maxLength": 100},
+                        "last_name": {"type": "string", "maxLength": 100},
+                        "display_name": {"type": "string", "maxLength": 200},
+                        "phone": {"type": "string", "description": "E.164, e.g. +14155550123; empty clears it"},
+                        "location": {"type": "string", "maxLength": 200},
+                        "metadata": {"type": "object", "description": "Replaces metadata.user; at most 4096 bytes serialized"}
+                    }
+                }))
+                .output_schema(profile_schema())
+                .tags(&["auth"]),
+            BlockEndpoint::post("/b/auth/api/profile/email")
+                .summary("Request an email change; a confirmation link goes to the new address")
+                .auth(AuthLevel::Authenticated)
+                .input_schema(serde_json::json!({
+                    "type": "object",
+                    "required": ["email"],
+                    "properties": {
+                        "email": {"type": "string", "format": "email"}
+                    }
+                }))
+                .output_schema(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "message": {"type": "string"},
+                        "pending_email": {"type": "string"}
+                    }
+                }))
+                .tags(&["auth"]),
+            // Opened from the confirmation email. Public: the token in the
+            // link is the credential.
+            BlockEndpoint::get("/b/auth/api/confirm-email-change")
+                .summary("Confirm an email change")
+                .tags(&["auth"]),
             // Was previously undeclared entirely (dispatched in `handle()`
             // but absent from `.endpoints`), which meant it was excluded
             // from `/openapi.json` AND from the per-endpoint access-tier
@@ -340,6 +432,117 @@ crate::solobase_feature_block! {
             BlockEndpoint::post("/b/auth/api/api-keys")
                 .summary("Create API key")
                 .auth(AuthLevel::Authenticated),
+            BlockEndpoint::get("/b/auth/api/quotas/usage")
+                .summary("Get own usage-quota consumption")
+                .auth(AuthLevel::Authenticated)
+                .output_schema(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "quotas": {
+                            "type": "array",
+                            "items": {
+                                "type": "object",
+                                "properties": {
+                                    "resource": {"type": "string"},
+                                    "scope": {"type": "string", "enum": ["user", "role"]},
+                                    "limit": {"type": "integer"},
+                                    "window_secs": {"type": "integer"},
+                                    "used": {"type": "integer"},
+                                    "remaining": {"type": "integer"},
+                                    "reset_at": {"type": ["integer", "null"], "description": "Unix seconds the current window ends"}
+                                }
+                            }
+                        }
+                    }
+                }))
+                .tags(&["auth"]),
+            // Public: anonymous callers get the untargeted critical banners.
+            BlockEndpoint::get("/b/auth/api/announcements")
+                .summary("Get active announcement banners")
+                .output_schema(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "announcements": {
+                            "type": "array",
+                            "items": {
+                                "type": "object",
+                                "properties": {
+                                    "id": {"type": "string"},
+                                    "message": {"type": "string"},
+                                    "severity": {"type": "string", "enum": ["info", "warning", "critical"]},
+                                    "starts_at": {"type": "string", "format": "date-time"},
+                                    "ends_at": {"type": ["string", "null"], "format": "date-time"},
+                                    "dismissible": {"type": "boolean"},
+                                    "target_roles": {"type": "array", "items": {"type": "string"}}
+                                }
+                            }
+                        }
+                    }
+                }))
+                .tags(&["auth"]),
+            BlockEndpoint::post("/b/auth/api/announcements/{id}/dismiss")
+                .summary("Dismiss an announcement banner")
+                .auth(AuthLevel::Authenticated)
+                .tags(&["auth"]),
+            BlockEndpoint::get("/b/auth/api/notifications")
+                .summary("List own notifications with the unread count")
+                .auth(AuthLevel::Authenticated)
+                .output_schema(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "records": {
+                            "type": "array",
+                            "items": {
+                                "type": "object",
+                                "properties": {
+                                    "id": {"type": "string"},
+                                    "type": {"type": "string"},
+                                    "title": {"type": "string"},
+                                    "body": {"type": "string"},
+                                    "data": {"type": "object"},
+                                    "read_at": {"type": ["string", "null"], "format": "date-time"},
+                                    "created_at": {"type": "string", "format": "date-time"}
+                                }
+                            }
+                        },
+                        "unread_count": {"type": "integer"}
+                    }
+                }))
+                .tags(&["auth"]),
+            BlockEndpoint::post("/b/auth/api/notifications/{id}/read")
+                .summary("Mark a notification read")
+                .auth(AuthLevel::Authenticated)
+                .tags(&["auth"]),
+            BlockEndpoint::post("/b/auth/api/notifications/read-all")
+                .summary("Mark every notification read")
+                .auth(AuthLevel::Authenticated)
+                .tags(&["auth"]),
+            BlockEndpoint::get("/b/auth/api/notifications/preferences")
+                .summary("Get own per-type notification email choices")
+                .auth(AuthLevel::Authenticated)
+                .tags(&["auth"]),
+            BlockEndpoint::patch("/b/auth/api/notifications/preferences")
+                .summary("Change per-type notification email choices")
+                .auth(AuthLevel::Authenticated)
+                .tags(&["auth"]),
+            // Public: anonymous callers get the flags rolled out to everyone.
+            BlockEndpoint::get("/b/auth/api/flags")
+                .summary("Get feature flags evaluated for the caller")
+                .output_schema(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "flags": {
+                            "type": "object",
+                            "additionalProperties": {"type": "boolean"},
+                            "description": "Flag key to whether it is on for the caller"
+                        }
+                    }
+                }))
+                .tags(&["auth"]),
+            // "This wasn't me" link from a new-device sign-in alert. Public:
+            // the signed token in the form is the credential.
+            BlockEndpoint::get("/b/auth/not-me").summary("Report an unrecognized sign-in"),
+            BlockEndpoint::post("/b/auth/api/not-me").summary("Sign out an unrecognized device and send a password reset"),
             // Bootstrap token redemption (filled in Task 6)
             BlockEndpoint::get("/b/auth/bootstrap").summary("Bootstrap token redemption form"),
             BlockEndpoint::post("/b/auth/api/bootstrap").summary("Redeem bootstrap admin token"),
@@ -348,6 +551,15 @@ crate::solobase_feature_block! {
         .admin_url("/b/auth/admin/settings")
     },
     handle: |this, ctx, msg, input| {
+        // Deferred work queued by this block (see `blocks::jobs`).
+        if msg.kind == crate::blocks::jobs::RUN_KIND {
+            use crate::blocks::jobs;
+            return match jobs::job_type(&msg) {
+                login_alerts::NOTIFY_JOB => jobs::respond(login_alerts::run_job(ctx, input).await),
+                _ => jobs::unknown_type(&msg),
+            };
+        }
+
         let action = msg.action().to_string();
         // Normalize: /b/auth/... → /auth/...
         let raw_path = msg.path().to_string();
@@ -393,6 +605,7 @@ crate::solobase_feature_block! {
             }
             ("retrieve", "/auth/orgs") => pages::orgs::handle(ctx, &msg).await,
             ("retrieve", "/auth/reset-password") => pages::reset_password::handle(ctx, &msg).await,
+            ("retrieve", "/auth/not-me") => pages::not_me::handle(ctx, &msg).await,
             // Bootstrap token redemption (NEW — filled in Task 6)
             ("retrieve", "/auth/bootstrap") => pages::bootstrap::handle_get(ctx, &msg).await,
             // OAuth browser redirects
@@ -400,17 +613,62 @@ crate::solobase_feature_block! {
             ("retrieve", "/auth/oauth/callback") => oauth::callback::handle(ctx, &msg).await,
 
             // ── JSON API under /auth/api/ ─────────────────────────────
-            ("create", "/auth/api/login") => api::login::handle(ctx, input).await,
-            ("create", "/auth/api/signup") => api::signup::handle(ctx, input).await,
-            ("create", "/auth/api/refresh") => api::refresh::handle(ctx, input).await,
+            ("create", "/auth/api/login") => api::login::handle(ctx, &msg, input).await,
+            ("create", "/auth/api/signup") => api::signup::handle(ctx, &msg, input).await,
+            ("create", "/auth/api/refresh") => api::refresh::handle(ctx, &msg, input).await,
             ("create", "/auth/api/logout") => api::logout::handle(ctx, &msg).await,
             ("retrieve", "/auth/api/me") => api::me::handle_get(ctx, &msg).await,
             ("update", "/auth/api/me") => api::me::handle_update(ctx, &msg, input).await,
+            ("retrieve", "/auth/api/profile") => api::profile::handle_get(ctx, &msg).await,
+            ("update", "/auth/api/profile") => {
+                api::profile::handle_update(ctx, &msg, input).await
+            }
+            ("create", "/auth/api/profile/email") => {
+                api::profile::handle_change_email(ctx, &msg, input).await
+            }
+            ("retrieve", "/auth/api/confirm-email-change") => {
+                api::profile::handle_confirm_email_change(ctx, &msg).await
+            }
             ("create", "/auth/api/change-password") => {
                 api::change_password::handle(ctx, &msg, input).await
             }
             // API keys (admin user-management still hits these via htmx)
             ("retrieve", "/auth/api/api-keys") => api::api_keys::handle_list(ctx, &msg).await,
+            ("retrieve", "/auth/api/quotas/usage") => api::quotas::handle_usage(ctx, &msg).await,
+            ("retrieve", "/auth/api/announcements") => {
+                api::announcements::handle_list(ctx, &msg).await
+            }
+            ("retrieve", "/auth/api/flags") => api::flags::handle_list(ctx, &msg).await,
+            ("retrieve", "/auth/api/notifications") => {
+                api::notifications::handle_list(ctx, &msg).await
+            }
+            ("create", "/auth/api/notifications/read-all") => {
+                api::notifications::handle_read_all(ctx, &msg).await
+            }
+            ("retrieve", "/auth/api/notifications/preferences") => {
+                api::notifications::handle_get_preferences(ctx, &msg).await
+            }
+            ("update", "/auth/api/notifications/preferences") => {
+                api::notifications::handle_update_preferences(ctx, &msg, input).await
+            }
+            ("create", p)
+                if endpoint_match::match_template("/auth/api/notifications/{id}/read", p)
+                    .is_some() =>
+            {
+                let id = p
+                    .trim_start_matches("/auth/api/notifications/")
+                    .trim_end_matches("/read");
+                api::notifications::handle_read(ctx, &msg, id).await
+            }
+            ("create", p)
+                if endpoint_match::match_template("/auth/api/announcements/{id}/dismiss", p)
+                    .is_some() =>
+            {
+                let id = p
+                    .trim_start_matches("/auth/api/announcements/")
+                    .trim_end_matches("/dismiss");
+                api::announcements::handle_dismiss(ctx, &msg, id).await
+            }
             ("create", "/auth/api/api-keys") => {
                 api::api_keys::handle_create(ctx, &msg, input).await
             }
@@ -429,13 +687,14 @@ crate::solobase_feature_block! {
                 api::verify::handle(ctx, &msg, input).await
             }
             ("create", "/auth/api/resend-verification") => {
-                api::verify::handle_resend(ctx, input).await
+                api::verify::handle_resend(ctx, &msg, input).await
             }
             // Password reset
             ("create", "/auth/api/forgot-password") => {
-                api::forgot_password::handle(ctx, input).await
+                api::forgot_password::handle(ctx, &msg, input).await
             }
-            ("create", "/auth/api/reset-password") => api::reset_password::handle(ctx, input).await,
+            ("create", "/auth/api/reset-password") => api::reset_password::handle(ctx, &msg, input).await,
+            ("create", "/auth/api/not-me") => api::not_me::handle(ctx, input).await,
             // OAuth API
             ("retrieve", "/auth/api/oauth/providers") => oauth::providers::handle(ctx).await,
             ("create", "/auth/api/oauth/sync-user") => {
@@ -9,14 +9,17 @@ use wafer_run::{context::Context, Message, OutputStream};
 use crate::{
     blocks::{
         auth::{
+            config::SignupMode,
+            devices::Device,
             helpers::{
                 email_domain_allowed, ensure_admin_role, initial_role_for, issue_tokens_and_cookie,
-                signup_allowed,
+                signup_mode,
             },
             repo::{oauth_pkce, provider_links, users},
             USERS_TABLE,
         },
-        auth_ui::redirect::{default_post_login_redirect, is_safe_local_redirect},
+        auth_ui::{login_alerts, redirect::post_login_default},
+        errors::{error_response, ErrorCode},
     },
     http::{err_bad_request, err_forbidden, err_internal, err_internal_no_cause, ResponseBuilder},
     util::json_map,
@@ -129,6 +132,8 @@ pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
     // and *omitted* the session row, so OAuth logins were invisible on the
     // userportal device list; routing through `issue_tokens_and_cookie` fixes
     // that by construction.
+    let device = Device::from_message(msg);
+    let new_device = login_alerts::is_new_device(ctx, &user_id, &device).await;
     let issued = match issue_tokens_and_cookie(
         ctx,
         &user_id,
@@ -137,12 +142,18 @@ pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
         &format!("oauth.{provider}"),
         None,
         0,
+        Some(&device),
     )
     .await
     {
         Ok(i) => i,
         Err(r) => return r,
     };
+    if new_device {
+        if let Ok(Some(user)) = users::find_by_id(ctx, &user_id).await {
+            login_alerts::queue(ctx, &user, &device, &issued.family).await;
+        }
+    }
 
     // Redirect to frontend — token is set via HttpOnly cookie only (not URL)
     let frontend_url = config::get_default(
@@ -161,19 +172,21 @@ pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
         );
         return err_internal_no_cause("Frontend URL is not configured correctly");
     }
-    let post_login_raw =
-        config::get_default(ctx, "SOLOBASE_SHARED__POST_LOGIN_REDIRECT", "/b/admin/").await;
-    let admin_default = if is_safe_local_redirect(&post_login_raw) {
-        post_login_raw
-    } else {
-        "/b/admin/".to_string()
-    };
     // Role-aware default (#1 onboarding bug fix): a non-admin OAuth login
     // must never default into the admin-only destination above — see
-    // `redirect::default_post_login_redirect`.
+    // `redirect::post_login_default`.
     let is_admin = roles.iter().any(|r| r == "admin");
-    let post_login = default_post_login_redirect(is_admin, &admin_default);
-    let redirect_url = format!("{}{}", frontend_url.trim_end_matches('/'), post_login);
+    let post_login = post_login_default(ctx, is_admin).await;
+    // An allowlisted absolute target already names its origin.
+    let redirect_url = if post_login.starts_with('/') {
+        format!(
+            "{}{}",
+            crate::base_path::public_url(&frontend_url),
+            post_login
+        )
+    } else {
+        post_login
+    };
 
     ResponseBuilder::new()
         .status(302)
@@ -430,10 +443,24 @@ async fn resolve_user(
             }
             Ok(None) => {
                 // Brand-new user — enforce signup gates. Shared with the JSON
-                // signup handler so the ALLOW_SIGNUP / ALLOWED_EMAIL_DOMAINS /
+                // signup handler so the signup mode / ALLOWED_EMAIL_DOMAINS /
                 // bootstrap-admin rules can't drift between the two flows.
-                if !signup_allowed(ctx).await {
-                    return Err(err_forbidden("Signups are currently disabled"));
+                // The callback carries no invitation token, so invite-only
+                // mode admits existing accounts only.
+                match signup_mode(ctx).await {
+                    SignupMode::Closed => {
+                        return Err(error_response(
+                            ErrorCode::SignupClosed,
+                            "Signups are currently disabled",
+                        ));
+                    }
+                    SignupMode::InviteOnly => {
+                        return Err(error_response(
+                            ErrorCode::InvitationRequired,
+                            "An invitation is required to sign up",
+                        ));
+                    }
+                    SignupMode::Open | SignupMode::DomainAllowlist => {}
                 }
 
                 if !email_domain_allowed(ctx, &info.email).await {
@@ -473,6 +500,10 @@ async fn resolve_user(
                         {
                             tracing::warn!("Failed to assign default role on OAuth signup: {e}");
                         }
+                        crate::cache::invalidate_table(crate::blocks::admin::USER_ROLES_TABLE);
+                        // The provider vouches for the address, as it does
+                        // for the email merge above.
+                        crate::blocks::auth_ui::api::email_confirmed(ctx, &u.id, &u.email).await;
                         u.id
                     }
                     Err(e) => return Err(err_internal("Failed to create user", e)),
@@ -724,9 +755,8 @@ mod security_regression_tests {
         ctx.register_block("wafer-run/config", cfg_block);
 
         // Seed a single-use PKCE state row keyed by the `state` query param.
-        let expires = (chrono::Utc::now() + chrono::Duration::minutes(10))
-            .format("%Y-%m-%dT%H:%M:%SZ")
-            .to_string();
+        let expires =
+            crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::minutes(10));
         oauth_pkce::insert(
             &ctx,
             oauth_pkce::NewPkceState {
@@ -77,9 +77,9 @@ pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
         Ok(s) => s,
         Err(e) => return err_internal("Failed to generate state", e),
     };
-    let expires_at = (chrono::Utc::now() + chrono::Duration::seconds(PKCE_STATE_TTL_SECS))
-        .format("%Y-%m-%dT%H:%M:%SZ")
-        .to_string();
+    let expires_at = crate::util::format_rfc3339(
+        chrono::Utc::now() + chrono::Duration::seconds(PKCE_STATE_TTL_SECS),
+    );
     if let Err(e) = oauth_pkce::insert(
         ctx,
         NewPkceState {
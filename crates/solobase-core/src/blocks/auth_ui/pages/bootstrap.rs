@@ -1,7 +1,9 @@
 //! GET /b/auth/bootstrap — bootstrap admin token redemption form.
 //!
-//! When `BOOTSTRAP_ADMIN_TOKEN` was set on first boot, no admin user was
-//! created — instead, a sha256(token) row was written to
+//! When `BOOTSTRAP_ADMIN_TOKEN` was set on first boot — or no bootstrap
+//! admin was configured at all, in which case a random setup token is
+//! generated and its `?token=` URL printed to stdout — no admin user was
+//! created. Instead, a sha256(token) row was written to
 //! `suppers_ai__auth__bootstrap_tokens` with a 24h expiry. This page is
 //! where the holder of that raw token redeems it: paste the token, choose
 //! an email + password, submit. The POST handler verifies, creates the
@@ -44,7 +46,7 @@ pub async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
                     p style="font-size:.875rem;color:#6b7280;margin-bottom:1.5rem;text-align:center" {
                         "Paste the bootstrap token from your "
                         code style="background:#f3f4f6;padding:.125rem .375rem;border-radius:.25rem;font-size:.813rem" { "BOOTSTRAP_ADMIN_TOKEN" }
-                        " env var, then pick the admin email and password."
+                        " env var (or open the setup URL printed at startup), then pick the admin email and password."
                     }
 
                     form method="post" action="/b/auth/api/bootstrap" .login-form {
@@ -8,24 +8,24 @@ use super::{
     oauth_provider_label, pw_field, pw_toggle_js, site_config,
 };
 use crate::{
-    blocks::{auth::brand_panel, auth_ui::redirect::is_safe_local_redirect},
+    blocks::{
+        auth::{brand_panel, config::SignupMode, helpers::signup_mode},
+        auth_ui::redirect::redirect_param,
+    },
     ui::{self, templates::auth_split},
 };
 
 pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let config = site_config(ctx);
     let app_name = &config.app_name;
-    let allow_signup = ctx
-        .config_get("SOLOBASE_SHARED__ALLOW_SIGNUP")
-        .unwrap_or("true")
-        == "true";
-    let raw_redirect = msg.get_meta("req.query.redirect").to_string();
-    // Validate redirect — only allow relative paths (prevent open redirect)
-    let redirect = if is_safe_local_redirect(&raw_redirect) {
-        raw_redirect
-    } else {
-        String::new()
-    };
+    // Invite-only signup is reached from the invitation link, not from here.
+    let allow_signup = matches!(
+        signup_mode(ctx).await,
+        SignupMode::Open | SignupMode::DomainAllowlist
+    );
+    // Validate redirect — relative paths or an allowlisted origin only
+    // (prevent open redirect).
+    let redirect = redirect_param(ctx, msg);
     // Signup UX (Fix 2): the signup page can send a brand-new user here with
     // `?email=...` after redirecting them for email verification, so they
     // don't have to retype it. Rendered as an attribute value — maud
@@ -42,7 +42,7 @@ pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let signup_redirect = if redirect.is_empty() {
         String::new()
     } else {
-        format!("?redirect={redirect}")
+        format!("?redirect={}", crate::util::urlencode(&redirect))
     };
 
     // OAuth buttons appear only when ENABLE_OAUTH is on AND the provider's
@@ -189,6 +189,26 @@ mod tests {
         assert!(!html.contains("evil.com"));
     }
 
+    /// An absolute `?redirect=` is honored only when its origin is in
+    /// `SOLOBASE_SHARED__REDIRECT_ALLOWED_ORIGINS`.
+    #[tokio::test]
+    async fn absolute_redirect_requires_allowlisted_origin() {
+        let mut ctx = TestContext::new().await;
+        let msg = login_msg(&[("redirect", "https://app.example.com/done")]);
+        let html = output_html(handle(&ctx, &msg).await).await;
+        assert!(!html.contains("app.example.com"), "not yet allowed: {html}");
+
+        ctx.set_config(
+            "SOLOBASE_SHARED__REDIRECT_ALLOWED_ORIGINS",
+            "https://app.example.com",
+        );
+        let html = output_html(handle(&ctx, &msg).await).await;
+        assert!(
+            html.contains(r#"id="redirect" type="hidden" value="https://app.example.com/done""#),
+            "allowlisted redirect must be rendered: {html}"
+        );
+    }
+
     /// Signup UX (Fix 2): the signup page can send a brand-new user here
     /// with `?email=...` so they don't have to retype it.
     #[tokio::test]
@@ -6,6 +6,7 @@
 pub mod bootstrap;
 pub mod change_password;
 pub mod login;
+pub mod not_me;
 pub mod orgs;
 pub mod reset_password;
 pub mod settings;
@@ -186,7 +187,7 @@ async function handleLogin(ev){
       document.cookie='auth_token='+d.access_token+'; Path=/; SameSite=Lax; Max-Age='+maxAge+secure;
     }
     var redir=$('redirect').value||d.default_redirect||'/';
-    window.location.href=redir;
+    window.location.href=(window.solobaseUrl||String)(redir);
   }catch(ex){showErr('Something went wrong');btn.disabled=false;btn.textContent='Sign In'}
   return false;
 }
@@ -214,7 +215,7 @@ async function handleLogin(ev){
     var d=await r.json();
     if(!r.ok||d.error){showErr((d.error&&d.error.message)||d.error||d.message||'Invalid credentials');btn.disabled=false;btn.textContent='Sign In';return false}
     var redir=$('redirect').value||d.default_redirect||'/';
-    window.location.href=redir;
+    window.location.href=(window.solobaseUrl||String)(redir);
   }catch(ex){showErr('Something went wrong');btn.disabled=false;btn.textContent='Sign In'}
   return false;
 }
@@ -261,14 +262,14 @@ async function handleSignup(ev){
   $('error').style.display='none';
   var email=$('email').value,pw=$('password').value;
   try{
-    var r=await fetch('/b/auth/api/signup',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify({email:email,password:pw})});
+    var r=await fetch('/b/auth/api/signup',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify({email:email,password:pw,invite_token:$('invite').value||null})});
     var d=await r.json();
     if(!r.ok||d.error){showErr((d.error&&d.error.message)||d.error||d.message||'Signup failed');btn.disabled=false;btn.textContent='Create Account';return false}
     if(d.email_verified===false){
       $('form').style.display='none';$('signin-link').style.display='none';
       $('verify-msg').textContent='We sent a verification link to '+email+'. Click the link to activate your account.';
       var back=$('back-to-signin');
-      if(back){var qs='email='+encodeURIComponent(email);var r2=$('redirect').value;if(r2){qs+='&redirect='+encodeURIComponent(r2)}back.setAttribute('href','/b/auth/login?'+qs);}
+      if(back){var qs='email='+encodeURIComponent(email);var r2=$('redirect').value;if(r2){qs+='&redirect='+encodeURIComponent(r2)}back.setAttribute('href',(window.solobaseUrl||String)('/b/auth/login?'+qs));}
       $('success').style.display='block';
     }else{
       if(d.access_token){
@@ -277,7 +278,7 @@ async function handleSignup(ev){
         document.cookie='auth_token='+d.access_token+'; Path=/; SameSite=Lax; Max-Age='+maxAge+secure;
       }
       var redir=$('redirect').value||d.default_redirect||'/';
-      window.location.href=redir;
+      window.location.href=(window.solobaseUrl||String)(redir);
     }
   }catch(ex){showErr('Something went wrong');btn.disabled=false;btn.textContent='Create Account'}
   return false;
@@ -295,18 +296,18 @@ async function handleSignup(ev){
   $('error').style.display='none';
   var email=$('email').value,pw=$('password').value;
   try{
-    var r=await fetch('/b/auth/api/signup',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify({email:email,password:pw})});
+    var r=await fetch('/b/auth/api/signup',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify({email:email,password:pw,invite_token:$('invite').value||null})});
     var d=await r.json();
     if(!r.ok||d.error){showErr((d.error&&d.error.message)||d.error||d.message||'Signup failed');btn.disabled=false;btn.textContent='Create Account';return false}
     if(d.email_verified===false){
       $('form').style.display='none';$('signin-link').style.display='none';
       $('verify-msg').textContent='We sent a verification link to '+email+'. Click the link to activate your account.';
       var back=$('back-to-signin');
-      if(back){var qs='email='+encodeURIComponent(email);var r2=$('redirect').value;if(r2){qs+='&redirect='+encodeURIComponent(r2)}back.setAttribute('href','/b/auth/login?'+qs);}
+      if(back){var qs='email='+encodeURIComponent(email);var r2=$('redirect').value;if(r2){qs+='&redirect='+encodeURIComponent(r2)}back.setAttribute('href',(window.solobaseUrl||String)('/b/auth/login?'+qs));}
       $('success').style.display='block';
     }else{
       var redir=$('redirect').value||d.default_redirect||'/';
-      window.location.href=redir;
+      window.location.href=(window.solobaseUrl||String)(redir);
     }
   }catch(ex){showErr('Something went wrong');btn.disabled=false;btn.textContent='Create Account'}
   return false;
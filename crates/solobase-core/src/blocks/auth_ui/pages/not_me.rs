@@ -0,0 +1,80 @@
+//! GET /b/auth/not-me — landing page of the "this wasn't me" link in a
+//! new-device sign-in alert.
+//!
+//! Opening the link only shows a confirmation button; the sign-out happens
+//! on the form POST to `/b/auth/api/not-me`, which redirects back here with
+//! `?done=1` or `?error=invalid`.
+
+use maud::{html, Markup};
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::site_config;
+use crate::{
+    blocks::auth::brand_panel,
+    ui::{self, templates::auth_split},
+};
+
+pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let config = site_config(ctx);
+    let token = msg.get_meta("req.query.token");
+
+    let body = if msg.get_meta("req.query.done") == "1" {
+        outcome(
+            true,
+            "Device signed out",
+            "That sign-in has been ended. We've emailed you a link to choose a new password — do it now, since whoever signed in knew your current one.",
+        )
+    } else if token.is_empty() || !msg.get_meta("req.query.error").is_empty() {
+        outcome(
+            false,
+            "Invalid link",
+            "This link is invalid or has expired. Sign in and review your sessions, or reset your password from the sign-in page.",
+        )
+    } else {
+        html! {
+            h2 style="font-size:1.25rem;font-weight:700;margin:0 0 .5rem;text-align:center" { "Wasn't you?" }
+            p .login-subtitle style="line-height:1.6;margin:0 0 1.5rem;text-align:center" {
+                "We'll sign that device out and email you a link to reset your password."
+            }
+            form method="post" action="/b/auth/api/not-me" .login-form {
+                input type="hidden" name="token" value=(token);
+                button .login-button type="submit" { "Sign that device out" }
+            }
+        }
+    };
+
+    let markup = ui::layout::page(
+        "Secure Your Account",
+        &config,
+        auth_split(
+            brand_panel(&config, "Secure your account."),
+            html! {
+                div .login-container {
+                    div .login-logo {
+                        @if !config.logo_url.is_empty() {
+                            img .logo-image src=(config.logo_url) alt=(config.app_name);
+                        }
+                    }
+                    (body)
+                }
+            },
+        ),
+    );
+    ui::html_response(markup)
+}
+
+fn outcome(success: bool, title: &str, message: &str) -> Markup {
+    let color = if success { "#10b981" } else { "#ef4444" };
+    html! {
+        div style="text-align:center" {
+            div style={"width:48px;height:48px;background:" (color) "15;border-radius:50%;display:flex;align-items:center;justify-content:center;margin:0 auto 1rem;font-size:1.5rem;color:" (color)} {
+                @if success { "✓" } @else { "✗" }
+            }
+            h2 style="font-size:1.25rem;font-weight:700;margin:0 0 .5rem" { (title) }
+            p .login-subtitle style="line-height:1.6;margin:0 0 1.5rem" { (message) }
+            a .login-button href="/b/auth/login" style="display:inline-block;width:auto;padding:.625rem 1.25rem;text-decoration:none" {
+                "Go to Sign In"
+            }
+        }
+    }
+}
@@ -79,7 +79,7 @@ async function handleReset(e){
     var d=await r.json();
     if(d.error){err.textContent=d.error.message||d.error;err.style.display='flex';}
     else{suc.textContent='Password reset successfully. You can now sign in.';suc.style.display='block';$('form').style.display='none';
-      setTimeout(function(){window.location.href='/b/auth/login';},2000);}
+      setTimeout(function(){window.location.href=(window.solobaseUrl||String)('/b/auth/login');},2000);}
   }catch(ex){err.textContent='Something went wrong.';err.style.display='flex';}
   btn.disabled=false;btn.textContent='Reset Password';
   return false;
@@ -13,13 +13,14 @@ use crate::{
     },
 };
 
-/// The config vars rendered on the auth settings page, grouped into the three
+/// The config vars rendered on the auth settings page, grouped into the four
 /// on-page sections. Each var is pulled from its declared [`ConfigVar`] source
 /// — shared vars from `config_vars::shared_var`, the auth-identity vars from
 /// `auth::config::auth_identity_config_vars`, and the OAuth provider creds from
 /// the auth-ui block's own `config_vars()` — so nothing is re-declared here.
 struct Sections {
     registration: Vec<wafer_run::ConfigVar>,
+    security: Vec<wafer_run::ConfigVar>,
     admin: Vec<wafer_run::ConfigVar>,
     oauth: Vec<wafer_run::ConfigVar>,
 }
@@ -37,6 +38,11 @@ fn sections() -> Sections {
             config_vars::var_in(&identity, auth_config::REQUIRE_VERIFICATION_KEY),
             config_vars::var_in(&identity, auth_config::ALLOWED_EMAIL_DOMAINS_KEY),
             config_vars::shared_var("SOLOBASE_SHARED__POST_LOGIN_REDIRECT"),
+            config_vars::shared_var("SOLOBASE_SHARED__REDIRECT_ALLOWED_ORIGINS"),
+        ],
+        security: vec![
+            config_vars::var_in(&identity, auth_config::LOGIN_ALERTS_KEY),
+            config_vars::var_in(&identity, auth_config::API_KEY_MAX_LIFETIME_DAYS_KEY),
         ],
         admin: vec![
             config_vars::shared_var(auth_config::BOOTSTRAP_ADMIN_EMAIL_KEY),
@@ -50,6 +56,7 @@ impl Sections {
     /// Flatten to a single save allowlist.
     fn all(&self) -> Vec<wafer_run::ConfigVar> {
         let mut v = self.registration.clone();
+        v.extend(self.security.iter().cloned());
         v.extend(self.admin.iter().cloned());
         v.extend(self.oauth.iter().cloned());
         v
@@ -60,6 +67,7 @@ pub async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let s = sections();
     let form_sections = [
         SettingsSection::new("Registration", icons::users(), &s.registration),
+        SettingsSection::new("Security", icons::lock(), &s.security),
         SettingsSection::new("Admin", icons::shield(), &s.admin),
         SettingsSection::new("OAuth Providers", icons::globe(), &s.oauth),
     ];
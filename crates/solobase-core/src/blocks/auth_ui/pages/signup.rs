@@ -5,24 +5,27 @@ use wafer_run::{context::Context, Message, OutputStream};
 
 use super::{pw_field, pw_toggle_js, signup_script, site_config};
 use crate::{
-    blocks::{auth::brand_panel, auth_ui::redirect::is_safe_local_redirect},
+    blocks::{
+        auth::{brand_panel, config::SignupMode, helpers::signup_mode},
+        auth_ui::redirect::redirect_param,
+    },
     ui::{self, templates::auth_split},
 };
 
 pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let config = site_config(ctx);
     let app_name = &config.app_name;
-    let allow_signup = ctx
-        .config_get("SOLOBASE_SHARED__ALLOW_SIGNUP")
-        .unwrap_or("true")
-        == "true";
-    let raw_redirect = msg.get_meta("req.query.redirect").to_string();
-    // Validate redirect — only allow relative paths (prevent open redirect)
-    let redirect = if is_safe_local_redirect(&raw_redirect) {
-        raw_redirect
-    } else {
-        String::new()
+    // `?invite=` carries the raw invitation token from the invite email or
+    // link; the form posts it back as `invite_token`.
+    let invite = msg.query("invite").to_string();
+    let allow_signup = match signup_mode(ctx).await {
+        SignupMode::Closed => false,
+        SignupMode::InviteOnly => !invite.is_empty(),
+        SignupMode::Open | SignupMode::DomainAllowlist => true,
     };
+    // Validate redirect — relative paths or an allowlisted origin only
+    // (prevent open redirect).
+    let redirect = redirect_param(ctx, msg);
     let logo_url = &config.logo_url;
 
     if !allow_signup {
@@ -32,7 +35,7 @@ pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let redirect_qs = if redirect.is_empty() {
         String::new()
     } else {
-        format!("?redirect={redirect}")
+        format!("?redirect={}", crate::util::urlencode(&redirect))
     };
 
     let markup = ui::layout::page(
@@ -64,6 +67,7 @@ pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
 
                     form #form .login-form onsubmit="return handleSignup(event)" {
                         input type="hidden" #redirect value=(redirect);
+                        input type="hidden" #invite value=(invite);
 
                         div .form-group {
                             label .form-label for="email" { "Email" }
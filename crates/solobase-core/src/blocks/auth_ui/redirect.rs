@@ -18,6 +18,13 @@
 //! - Contains a backslash anywhere
 //! - Contains `\r`, `\n`, `\t`, or any other ASCII control char
 //! - Contains `%2F%2F` (encoded `//`) or `%5C` (encoded `\`)
+//!
+//! Embedders whose frontend lives on another origin list it in
+//! `SOLOBASE_SHARED__REDIRECT_ALLOWED_ORIGINS`; [`is_allowed_redirect`]
+//! additionally accepts absolute `http(s)` URLs on exactly those origins.
+
+use wafer_core::clients::config;
+use wafer_run::{context::Context, Message};
 
 /// Returns `true` only when `path` is safe to plug into a `Location:` header
 /// or an `<a href>` without enabling an open redirect.
@@ -76,6 +83,75 @@ pub fn default_post_login_redirect(is_admin: bool, configured_admin_default: &st
     }
 }
 
+/// Config key: comma-separated origins absolute redirects may target.
+pub const ALLOWED_ORIGINS_KEY: &str = "SOLOBASE_SHARED__REDIRECT_ALLOWED_ORIGINS";
+
+/// [`is_safe_local_redirect`], or an absolute `http(s)` URL whose origin
+/// (scheme + host + port) is listed in `allowed_origins` (comma-separated,
+/// compared case-insensitively, trailing `/` ignored). URLs carrying
+/// userinfo (`https://app.example.com@evil.com`) are always rejected.
+pub fn is_allowed_redirect(target: &str, allowed_origins: &str) -> bool {
+    if is_safe_local_redirect(target) {
+        return true;
+    }
+    let Some(origin) = url_origin(target) else {
+        return false;
+    };
+    allowed_origins
+        .split(',')
+        .map(|o| o.trim().trim_end_matches('/'))
+        .any(|o| !o.is_empty() && o.eq_ignore_ascii_case(origin))
+}
+
+/// `scheme://authority` of an absolute `http(s)` URL, or `None` for
+/// anything else (or anything with backslashes, control characters or
+/// userinfo).
+fn url_origin(target: &str) -> Option<&str> {
+    if target.chars().any(|c| c == '\\' || c.is_control()) {
+        return None;
+    }
+    let rest = target
+        .strip_prefix("https://")
+        .or_else(|| target.strip_prefix("http://"))?;
+    let end = rest.find(['/', '?', '#']).unwrap_or(rest.len());
+    let authority = &rest[..end];
+    if authority.is_empty() || authority.contains('@') {
+        return None;
+    }
+    Some(&target[..target.len() - rest.len() + end])
+}
+
+/// The `?redirect=` value from `msg` if it passes [`is_allowed_redirect`]
+/// against the configured origins, else empty.
+pub fn redirect_param(ctx: &dyn Context, msg: &Message) -> String {
+    let raw = msg.get_meta("req.query.redirect");
+    let allowed = ctx.config_get(ALLOWED_ORIGINS_KEY).unwrap_or("");
+    if is_allowed_redirect(raw, allowed) {
+        raw.to_string()
+    } else {
+        String::new()
+    }
+}
+
+/// The post-login default for a caller, resolved against config: the
+/// validated `SOLOBASE_SHARED__POST_LOGIN_REDIRECT` (admin home when unset
+/// or unsafe) for admins, [`USER_PORTAL_HOME`] for everyone else — unless
+/// the built-in UI is disabled, in which case the portal isn't served and
+/// everyone gets the configured target.
+pub async fn post_login_default(ctx: &dyn Context, is_admin: bool) -> String {
+    let raw = config::get_default(ctx, "SOLOBASE_SHARED__POST_LOGIN_REDIRECT", "/b/admin/").await;
+    let allowed = ctx.config_get(ALLOWED_ORIGINS_KEY).unwrap_or("");
+    let configured = if is_allowed_redirect(&raw, allowed) {
+        raw
+    } else {
+        "/b/admin/".to_string()
+    };
+    if crate::routing::UiMode::from_ctx(ctx) != crate::routing::UiMode::Full {
+        return configured;
+    }
+    default_post_login_redirect(is_admin, &configured)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -165,4 +241,45 @@ mod tests {
             USER_PORTAL_HOME
         );
     }
+
+    #[test]
+    fn allowed_redirect_accepts_local_paths_and_listed_origins() {
+        let allowed = "https://app.example.com, http://localhost:5173/";
+        assert!(is_allowed_redirect("/b/admin/", ""));
+        assert!(is_allowed_redirect("https://app.example.com", allowed));
+        assert!(is_allowed_redirect(
+            "https://app.example.com/done?x=1",
+            allowed
+        ));
+        assert!(is_allowed_redirect("https://APP.example.com#top", allowed));
+        assert!(is_allowed_redirect("http://localhost:5173/", allowed));
+    }
+
+    #[test]
+    fn allowed_redirect_rejects_other_origins() {
+        let allowed = "https://app.example.com";
+        assert!(!is_allowed_redirect("https://app.example.com", ""));
+        assert!(!is_allowed_redirect("http://app.example.com/", allowed));
+        assert!(!is_allowed_redirect(
+            "https://app.example.com.evil.com/",
+            allowed
+        ));
+        assert!(!is_allowed_redirect(
+            "https://app.example.com:8443/",
+            allowed
+        ));
+        assert!(!is_allowed_redirect(
+            "https://app.example.com@evil.com/",
+            allowed
+        ));
+        assert!(!is_allowed_redirect(
+            "https://app.example.com\\@evil.com",
+            allowed
+        ));
+        assert!(!is_allowed_redirect(
+            "javascript://app.example.com",
+            allowed
+        ));
+        assert!(!is_allowed_redirect("//app.example.com", allowed));
+    }
 }
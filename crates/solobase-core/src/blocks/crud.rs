@@ -2,6 +2,7 @@
 //!
 //! These encapsulate the repeated list/get/create/update/delete patterns
 //! so each handler reduces to a one-liner for pure-CRUD operations.
+//! Every write invalidates the table's cached reads (see [`crate::cache`]).
 //!
 // audit-allow-file: pure pass-through helpers — every db::* call here takes
 // the table name as a `collection: &str` parameter from the caller. WRAP
@@ -15,6 +16,7 @@ use wafer_core::clients::database::{self as db, Record};
 use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
 
 use crate::{
+    cache,
     http::{err_bad_request, err_internal, err_not_found, err_unauthorized, ok_json},
     util::{field_as_string, stamp_created, stamp_updated},
 };
@@ -112,7 +114,9 @@ pub async fn crud_create(
         data.entry(key).or_insert(val);
     }
 
-    match db::create(ctx, collection, data).await {
+    let result = db::create(ctx, collection, data).await;
+    cache::invalidate_table(collection);
+    match result {
         Ok(record) => ok_json(&record),
         Err(e) => err_internal("Database error", e),
     }
@@ -139,7 +143,9 @@ pub async fn crud_update(
     };
     stamp_updated(&mut body);
 
-    match db::update(ctx, collection, id, body).await {
+    let result = db::update(ctx, collection, id, body).await;
+    cache::invalidate_table(collection);
+    match result {
         Ok(record) => ok_json(&record),
         Err(e) if e.code == ErrorCode::NotFound => {
             err_not_found(&format!("{not_found_label} not found"))
@@ -160,7 +166,9 @@ pub async fn crud_delete(
     if id.is_empty() {
         return err_bad_request(&format!("Missing {} ID", not_found_label.to_lowercase()));
     }
-    match db::delete(ctx, collection, id).await {
+    let result = db::delete(ctx, collection, id).await;
+    cache::invalidate_table(collection);
+    match result {
         Ok(()) => ok_json(&serde_json::json!({"deleted": true})),
         Err(e) if e.code == ErrorCode::NotFound => {
             err_not_found(&format!("{not_found_label} not found"))
@@ -282,7 +290,9 @@ pub async fn crud_update_owned(
     }
     stamp_updated(&mut body);
 
-    match db::update(ctx, res.collection, &id, body).await {
+    let result = db::update(ctx, res.collection, &id, body).await;
+    cache::invalidate_table(res.collection);
+    match result {
         Ok(record) => ok_json(&record),
         Err(e) if e.code == ErrorCode::NotFound => {
             err_not_found(&format!("{} not found", res.label))
@@ -313,7 +323,9 @@ pub async fn crud_delete_owned(
     {
         return resp;
     }
-    match db::delete(ctx, res.collection, &id).await {
+    let result = db::delete(ctx, res.collection, &id).await;
+    cache::invalidate_table(res.collection);
+    match result {
         Ok(()) => ok_json(&serde_json::json!({"deleted": true})),
         Err(e) if e.code == ErrorCode::NotFound => {
             err_not_found(&format!("{} not found", res.label))
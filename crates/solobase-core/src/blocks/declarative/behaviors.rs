@@ -0,0 +1,276 @@
+//! Request handling for the three route behaviours a manifest can declare.
+
+use std::collections::HashMap;
+
+use percent_encoding::percent_decode_str;
+use wafer_core::clients::{config, database as db, network};
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::manifest::{placeholders, ConfigEntry, CrudOp, ProxyDef};
+use crate::{
+    blocks::{
+        crud,
+        errors::{error_json, ErrorCode},
+    },
+    http::{err_bad_request, err_internal, err_not_found, ok_json, ResponseBuilder},
+    util::{collect_with_cap, stamp_created, stamp_updated, url_path_encode, urlencode},
+};
+
+/// Largest request body a proxy route forwards, and a CRUD write accepts.
+const MAX_BODY_BYTES: i64 = 1024 * 1024;
+
+/// Request headers a proxy route passes through to the upstream.
+const FORWARDED_HEADERS: &[&str] = &["accept", "content-type"];
+
+/// One file under a static route's `dir`, read at startup.
+pub struct StaticFile {
+    pub body: Vec<u8>,
+    pub content_type: &'static str,
+}
+
+pub enum Behaviour {
+    Proxy(ProxyDef),
+    /// Files keyed by their path relative to `dir`; `param` names the
+    /// route's trailing `{name...}` parameter.
+    Static {
+        param: String,
+        files: HashMap<String, StaticFile>,
+    },
+    Crud {
+        table: String,
+        columns: Vec<String>,
+        op: CrudOp,
+    },
+}
+
+impl Behaviour {
+    pub async fn handle(
+        &self,
+        ctx: &dyn Context,
+        msg: &Message,
+        input: InputStream,
+        config_keys: &[ConfigEntry],
+    ) -> OutputStream {
+        match self {
+            Behaviour::Proxy(proxy) => handle_proxy(ctx, msg, input, proxy, config_keys).await,
+            Behaviour::Static { param, files } => handle_static(msg, param, files),
+            Behaviour::Crud { table, columns, op } => {
+                handle_crud(ctx, msg, input, table, columns, *op).await
+            }
+        }
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Proxy
+// ---------------------------------------------------------------------------
+
+async fn handle_proxy(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+    proxy: &ProxyDef,
+    config_keys: &[ConfigEntry],
+) -> OutputStream {
+    let used: Vec<String> = std::iter::once(&proxy.url)
+        .chain(proxy.headers.values())
+        .flat_map(|v| placeholders(v, "${", "}"))
+        .collect();
+    let mut values: HashMap<String, String> = HashMap::new();
+    for key in config_keys.iter().filter(|k| used.contains(&k.key)) {
+        let value = config::get_default(ctx, &key.key, &key.default).await;
+        if key.required && value.is_empty() {
+            return error_json(
+                ErrorCode::ConfigurationError,
+                &format!("{} is not configured", key.key),
+                None,
+            );
+        }
+        values.insert(key.key.clone(), value);
+    }
+
+    // Route params land in the upstream path one segment at a time: a value
+    // that decodes to a separator or a dot-segment could otherwise walk the
+    // upstream path out of the one the manifest names.
+    let mut url = proxy.url.clone();
+    for name in placeholders(&proxy.url, "{", "}") {
+        let raw = msg.var(&name);
+        let decoded = percent_decode_str(raw).decode_utf8_lossy();
+        if decoded.is_empty() || decoded == "." || decoded == ".." || decoded.contains('/') {
+            return err_bad_request(&format!("Invalid {name}"));
+        }
+        url = url.replace(&format!("{{{name}}}"), &url_path_encode(&decoded));
+    }
+    let url = fill_config(&url, &values);
+
+    let query: Vec<String> = msg
+        .meta
+        .iter()
+        .filter_map(|m| {
+            let name = m.key.strip_prefix("req.query.")?;
+            Some(format!("{}={}", urlencode(name), urlencode(&m.value)))
+        })
+        .collect();
+    let url = match (query.is_empty(), url.contains('?')) {
+        (true, _) => url,
+        (false, true) => format!("{url}&{}", query.join("&")),
+        (false, false) => format!("{url}?{}", query.join("&")),
+    };
+
+    let mut headers: HashMap<String, String> = HashMap::new();
+    for name in FORWARDED_HEADERS {
+        let value = msg.header(name);
+        if !value.is_empty() {
+            headers.insert(name.to_string(), value.to_string());
+        }
+    }
+    for (name, value) in &proxy.headers {
+        headers.insert(name.clone(), fill_config(value, &values));
+    }
+
+    let method = match msg.action() {
+        "create" => "POST",
+        "update" => "PATCH",
+        "delete" => "DELETE",
+        _ => "GET",
+    };
+    let body = if method == "GET" {
+        None
+    } else {
+        match collect_with_cap(input, MAX_BODY_BYTES).await {
+            Ok(body) => Some(body),
+            Err(()) => {
+                return error_json(ErrorCode::PayloadTooLarge, "Request body too large", None)
+            }
+        }
+    };
+
+    let resp = match network::do_request(ctx, method, &url, &headers, body.as_ref()).await {
+        Ok(resp) => resp,
+        Err(e) => return err_internal("Upstream request failed", e),
+    };
+    let content_type = resp
+        .headers
+        .iter()
+        .find(|(k, _)| k.eq_ignore_ascii_case("content-type"))
+        .and_then(|(_, v)| v.first())
+        .map_or("application/octet-stream", String::as_str);
+    ResponseBuilder::new()
+        .status(resp.status_code)
+        .body(resp.body, content_type)
+}
+
+/// Replace every `${KEY}` in `template` with its configured value.
+fn fill_config(template: &str, values: &HashMap<String, String>) -> String {
+    let mut out = template.to_string();
+    for key in placeholders(template, "${", "}") {
+        let value = values.get(&key).map_or("", String::as_str);
+        out = out.replace(&format!("${{{key}}}"), value);
+    }
+    out
+}
+
+// ---------------------------------------------------------------------------
+// Static files
+// ---------------------------------------------------------------------------
+
+fn handle_static(msg: &Message, param: &str, files: &HashMap<String, StaticFile>) -> OutputStream {
+    let decoded = percent_decode_str(msg.var(param)).decode_utf8_lossy();
+    match files.get(decoded.as_ref()) {
+        Some(file) => ResponseBuilder::new().body(file.body.clone(), file.content_type),
+        None => crate::ui::not_found_response(msg),
+    }
+}
+
+/// Content type for a static file, from its extension.
+pub fn content_type_for(path: &str) -> &'static str {
+    let ext = path.rsplit_once('.').map_or("", |(_, ext)| ext);
+    match ext.to_ascii_lowercase().as_str() {
+        "html" | "htm" => "text/html; charset=utf-8",
+        "css" => "text/css; charset=utf-8",
+        "js" | "mjs" => "text/javascript; charset=utf-8",
+        "json" => "application/json",
+        "txt" | "md" => "text/plain; charset=utf-8",
+        "svg" => "image/svg+xml",
+        "png" => "image/png",
+        "jpg" | "jpeg" => "image/jpeg",
+        "gif" => "image/gif",
+        "webp" => "image/webp",
+        "ico" => "image/x-icon",
+        "woff" => "font/woff",
+        "woff2" => "font/woff2",
+        "wasm" => "application/wasm",
+        "pdf" => "application/pdf",
+        _ => "application/octet-stream",
+    }
+}
+
+// ---------------------------------------------------------------------------
+// CRUD
+// ---------------------------------------------------------------------------
+
+async fn handle_crud(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+    table: &str,
+    columns: &[String],
+    op: CrudOp,
+) -> OutputStream {
+    let is_item = matches!(op, CrudOp::Get | CrudOp::Update | CrudOp::Delete);
+    if is_item && msg.var("id").is_empty() {
+        return err_not_found("Record not found");
+    }
+    match op {
+        CrudOp::List => crud::crud_list(ctx, msg, table, vec![], None).await,
+        CrudOp::Get => crud::crud_get(ctx, msg, table, "", "Record").await,
+        CrudOp::Delete => crud::crud_delete(ctx, msg, table, "", "Record").await,
+        CrudOp::Create | CrudOp::Update => {
+            let mut data = match writable_fields(input, columns).await {
+                Ok(data) => data,
+                Err(resp) => return resp,
+            };
+            if op == CrudOp::Create {
+                stamp_created(&mut data);
+                return match db::create(ctx, table, data).await {
+                    Ok(record) => ok_json(&record),
+                    Err(e) => err_internal("Database error", e),
+                };
+            }
+            stamp_updated(&mut data);
+            match db::update(ctx, table, msg.var("id"), data).await {
+                Ok(record) => ok_json(&record),
+                Err(e) if e.code == wafer_run::ErrorCode::NotFound => {
+                    err_not_found("Record not found")
+                }
+                Err(e) => err_internal("Database error", e),
+            }
+        }
+    }
+}
+
+/// The request body as a column map, refusing any field the manifest
+/// didn't list as writable.
+async fn writable_fields(
+    input: InputStream,
+    columns: &[String],
+) -> Result<HashMap<String, serde_json::Value>, OutputStream> {
+    let raw = collect_with_cap(input, MAX_BODY_BYTES)
+        .await
+        .map_err(|()| error_json(ErrorCode::PayloadTooLarge, "Request body too large", None))?;
+    let body: HashMap<String, serde_json::Value> =
+        serde_json::from_slice(&raw).map_err(|e| err_bad_request(&format!("Invalid body: {e}")))?;
+    let mut unknown: Vec<&str> = body
+        .keys()
+        .filter(|k| !columns.contains(k))
+        .map(String::as_str)
+        .collect();
+    if !unknown.is_empty() {
+        unknown.sort_unstable();
+        return Err(err_bad_request(&format!(
+            "Unknown field(s): {}",
+            unknown.join(", ")
+        )));
+    }
+    Ok(body)
+}
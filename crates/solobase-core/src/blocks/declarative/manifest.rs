@@ -0,0 +1,703 @@
+//! `manifest.json` — the format of a declarative extension, and the checks
+//! it must pass before anything is registered.
+//!
+//! ```json
+//! {
+//!   "name": "acme/guestbook",
+//!   "version": "1.0.0",
+//!   "summary": "Guestbook entries and a status badge",
+//!   "config": [
+//!     {"key": "ACME__GUESTBOOK__STATUS_TOKEN", "description": "Status API token",
+//!      "secret": true, "required": true}
+//!   ],
+//!   "migrations": {
+//!     "sqlite": ["migrations/001_entries.sqlite.sql"],
+//!     "postgres": ["migrations/001_entries.postgres.sql"]
+//!   },
+//!   "routes": [
+//!     {"path": "/b/guestbook/entries", "access": "authenticated",
+//!      "crud": {"table": "acme__guestbook__entries", "columns": ["author", "message"]}},
+//!     {"path": "/b/guestbook/status", "access": "public",
+//!      "proxy": {"url": "https://status.example.com/v1/summary",
+//!                "headers": {"Authorization": "Bearer ${ACME__GUESTBOOK__STATUS_TOKEN}"}}},
+//!     {"path": "/b/guestbook/{file...}", "access": "public", "static": {"dir": "public"}}
+//!   ]
+//! }
+//! ```
+//!
+//! - `name` is `{org}/{block}`. The extension is mounted at `/b/{block}/`,
+//!   owns the `{org}__{block}__*` tables and the `{ORG}__{BLOCK}__*` config
+//!   keys, exactly like a compiled-in block.
+//! - Every route names its `access` (`public`, `authenticated` or `admin`),
+//!   which the central router enforces. An `authenticated` route may also
+//!   name a `permission`, `{block}.{action}` (e.g. `guestbook.write`): only
+//!   admins and roles holding it may use the route (see
+//!   [`crate::blocks::permissions`]). Each route has exactly one behaviour:
+//!   - `proxy` forwards the request (`methods`, default `["GET"]`) to `url`.
+//!     `{param}`s from the route path and `${CONFIG_KEY}`s may appear in the
+//!     URL path and query and in header values, never in its host.
+//!   - `static` serves the files under `dir`; the route path must end in a
+//!     `{name...}` parameter.
+//!   - `crud` exposes list/get/create/update/delete (or the `operations`
+//!     listed) over `table`, which the extension's SQLite migrations must
+//!     create with `id`, `created_at` and `updated_at` columns. Writes may
+//!     only set the listed `columns`.
+//!
+//! Unknown fields are rejected, and [`Manifest::problems`] reports every
+//! problem at once rather than the first one.
+
+use std::collections::HashSet;
+
+use serde::Deserialize;
+
+use crate::{blocks::permissions, config_vars::screaming_block, routing, table_scope};
+
+/// Orgs whose blocks ship with solobase and the runtime.
+const RESERVED_ORGS: &[&str] = &["suppers-ai", "wafer-run"];
+
+/// Methods a proxy route may forward.
+const PROXY_METHODS: &[&str] = &["GET", "POST", "PATCH", "DELETE"];
+
+/// Columns every CRUD table carries and the helpers set themselves.
+const MANAGED_COLUMNS: &[&str] = &["id", "created_at", "updated_at"];
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct Manifest {
+    pub name: String,
+    pub version: String,
+    #[serde(default)]
+    pub summary: String,
+    #[serde(default)]
+    pub config: Vec<ConfigEntry>,
+    #[serde(default)]
+    pub migrations: Migrations,
+    #[serde(default)]
+    pub routes: Vec<RouteDef>,
+}
+
+/// One config variable the extension reads.
+#[derive(Debug, Clone, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct ConfigEntry {
+    pub key: String,
+    #[serde(default)]
+    pub description: String,
+    #[serde(default)]
+    pub default: String,
+    /// Proxy routes that use the key fail with `configuration_error`
+    /// while it is unset.
+    #[serde(default)]
+    pub required: bool,
+    /// Shown masked in the admin settings.
+    #[serde(default)]
+    pub secret: bool,
+}
+
+/// Migration files, relative to the extension directory, in apply order.
+#[derive(Debug, Clone, Default, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct Migrations {
+    #[serde(default)]
+    pub sqlite: Vec<String>,
+    #[serde(default)]
+    pub postgres: Vec<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Access {
+    Public,
+    Authenticated,
+    Admin,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct RouteDef {
+    pub path: String,
+    pub access: Access,
+    #[serde(default)]
+    pub permission: Option<String>,
+    #[serde(default)]
+    pub summary: String,
+    #[serde(default)]
+    pub proxy: Option<ProxyDef>,
+    #[serde(rename = "static", default)]
+    pub static_files: Option<StaticDef>,
+    #[serde(default)]
+    pub crud: Option<CrudDef>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct ProxyDef {
+    pub url: String,
+    #[serde(default = "default_proxy_methods")]
+    pub methods: Vec<String>,
+    #[serde(default)]
+    pub headers: std::collections::BTreeMap<String, String>,
+}
+
+fn default_proxy_methods() -> Vec<String> {
+    vec!["GET".to_string()]
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct StaticDef {
+    pub dir: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct CrudDef {
+    pub table: String,
+    pub columns: Vec<String>,
+    #[serde(default = "all_operations")]
+    pub operations: Vec<CrudOp>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum CrudOp {
+    List,
+    Get,
+    Create,
+    Update,
+    Delete,
+}
+
+fn all_operations() -> Vec<CrudOp> {
+    vec![
+        CrudOp::List,
+        CrudOp::Get,
+        CrudOp::Create,
+        CrudOp::Update,
+        CrudOp::Delete,
+    ]
+}
+
+impl Manifest {
+    /// Parse `manifest.json`, reporting JSON and schema errors (unknown or
+    /// missing fields, wrong types) with their line and column.
+    pub fn parse(json: &str) -> Result<Manifest, String> {
+        serde_json::from_str(json).map_err(|e| format!("manifest.json: {e}"))
+    }
+
+    /// The `{block}` half of `name`.
+    pub fn block(&self) -> &str {
+        self.name.split_once('/').map_or("", |(_, block)| block)
+    }
+
+    /// Where the extension's routes live: `/b/{block}/`.
+    pub fn mount_prefix(&self) -> String {
+        format!("/b/{}/", self.block())
+    }
+
+    /// Everything wrong with the manifest, in field order. Empty when it
+    /// can be registered. Files it names are checked by the loader.
+    pub fn problems(&self) -> Vec<String> {
+        let mut out = Vec::new();
+        self.check_name(&mut out);
+        if self.version.trim().is_empty() {
+            out.push("version must not be empty".to_string());
+        }
+        self.check_config(&mut out);
+        for (dialect, files) in [
+            ("sqlite", &self.migrations.sqlite),
+            ("postgres", &self.migrations.postgres),
+        ] {
+            for file in files {
+                if let Err(e) = check_relative_path(file) {
+                    out.push(format!("migrations.{dialect}: {file:?} {e}"));
+                } else if !file.ends_with(".sql") {
+                    out.push(format!("migrations.{dialect}: {file:?} is not a .sql file"));
+                }
+            }
+        }
+        let mut seen = HashSet::new();
+        for (i, route) in self.routes.iter().enumerate() {
+            let at = format!("routes[{i}] ({})", route.path);
+            self.check_route(route, &at, &mut out);
+            for (method, template) in route_endpoints(route) {
+                if !seen.insert((method, template.clone())) {
+                    out.push(format!("{at}: {method} {template} is declared twice"));
+                }
+            }
+        }
+        out
+    }
+
+    fn check_name(&self, out: &mut Vec<String>) {
+        let Some((org, block)) = self.name.split_once('/') else {
+            out.push(format!("name {:?} must be {{org}}/{{block}}", self.name));
+            return;
+        };
+        if !is_slug(org) || !is_slug(block) {
+            out.push(format!(
+                "name {:?}: org and block may only use a-z, 0-9 and '-'",
+                self.name
+            ));
+            return;
+        }
+        if RESERVED_ORGS.contains(&org) {
+            out.push(format!(
+                "name {:?}: the {org} org is reserved for built-in blocks",
+                self.name
+            ));
+        }
+        // Built-in routes win over extensions, so any overlap — a built-in
+        // prefix covering the mount, or one nested inside it — would
+        // shadow some of the extension's paths.
+        let prefix = self.mount_prefix();
+        let owner = routing::block_for(&prefix, &[]).or_else(|| {
+            routing::ROUTES
+                .iter()
+                .find(|r| r.prefix.starts_with(&prefix))
+                .map(|r| r.block)
+        });
+        if let Some(owner) = owner {
+            out.push(format!(
+                "name {:?}: {prefix} is already routed to {owner}",
+                self.name
+            ));
+        }
+    }
+
+    fn check_config(&self, out: &mut Vec<String>) {
+        let prefix = format!("{}__", screaming_block(&self.name));
+        let mut seen = HashSet::new();
+        for entry in &self.config {
+            let valid = entry
+                .key
+                .bytes()
+                .all(|b| b.is_ascii_uppercase() || b.is_ascii_digit() || b == b'_');
+            if !valid || !entry.key.starts_with(&prefix) || entry.key.len() == prefix.len() {
+                out.push(format!(
+                    "config: key {:?} must be {prefix}NAME (A-Z, 0-9, _)",
+                    entry.key
+                ));
+            }
+            if !seen.insert(entry.key.as_str()) {
+                out.push(format!("config: key {:?} is declared twice", entry.key));
+            }
+        }
+    }
+
+    fn check_route(&self, route: &RouteDef, at: &str, out: &mut Vec<String>) {
+        let prefix = self.mount_prefix();
+        if !route.path.starts_with(&prefix) || route.path.len() == prefix.len() {
+            out.push(format!("{at}: path must be below {prefix}"));
+        }
+        if routing::normalize_path(&route.path).as_deref() != Some(route.path.as_str()) {
+            out.push(format!("{at}: path must not contain '//', '.' or '..'"));
+        }
+        let params = match template_params(&route.path) {
+            Ok(params) => params,
+            Err(e) => {
+                out.push(format!("{at}: {e}"));
+                Vec::new()
+            }
+        };
+
+        if let Some(permission) = &route.permission {
+            self.check_permission(route, permission, at, out);
+        }
+
+        let behaviours = [
+            route.proxy.is_some(),
+            route.static_files.is_some(),
+            route.crud.is_some(),
+        ];
+        if behaviours.iter().filter(|b| **b).count() != 1 {
+            out.push(format!(
+                "{at}: set exactly one of \"proxy\", \"static\" or \"crud\""
+            ));
+        }
+        if let Some(proxy) = &route.proxy {
+            self.check_proxy(proxy, &params, at, out);
+        }
+        if let Some(files) = &route.static_files {
+            if !route.path.ends_with("...}") {
+                out.push(format!(
+                    "{at}: a static route's path must end in a {{name...}} parameter"
+                ));
+            }
+            if let Err(e) = check_relative_path(&files.dir) {
+                out.push(format!("{at}: static.dir {:?} {e}", files.dir));
+            }
+        }
+        if let Some(crud) = &route.crud {
+            if !params.is_empty() {
+                out.push(format!("{at}: a crud route's path cannot have parameters"));
+            }
+            self.check_crud(crud, at, out);
+        }
+    }
+
+    fn check_permission(
+        &self,
+        route: &RouteDef,
+        permission: &str,
+        at: &str,
+        out: &mut Vec<String>,
+    ) {
+        let resource = permission.split_once('.').map_or("", |(r, _)| r);
+        if !permissions::is_valid_name(permission) || resource != self.block() {
+            out.push(format!(
+                "{at}: permission {permission:?} must be {}.{{action}} (a-z, 0-9, _, -)",
+                self.block()
+            ));
+        }
+        if route.access != Access::Authenticated {
+            out.push(format!(
+                "{at}: a permission only applies to an \"authenticated\" route"
+            ));
+        }
+    }
+
+    fn check_proxy(&self, proxy: &ProxyDef, params: &[String], at: &str, out: &mut Vec<String>) {
+        for method in &proxy.methods {
+            if !PROXY_METHODS.contains(&method.as_str()) {
+                out.push(format!(
+                    "{at}: proxy.methods: {method:?} is not one of {}",
+                    PROXY_METHODS.join(", ")
+                ));
+            }
+        }
+        if proxy.methods.is_empty() {
+            out.push(format!("{at}: proxy.methods must not be empty"));
+        }
+
+        let check_refs = |value: &str, what: &str, out: &mut Vec<String>| {
+            for name in placeholders(value, "{", "}") {
+                if !params.contains(&name) {
+                    out.push(format!(
+                        "{at}: {what} uses {{{name}}}, which the route path doesn't define"
+                    ));
+                }
+            }
+            for key in placeholders(value, "${", "}") {
+                if !self.config.iter().any(|c| c.key == key) {
+                    out.push(format!(
+                        "{at}: {what} uses ${{{key}}}, which is not in \"config\""
+                    ));
+                }
+            }
+        };
+
+        // Placeholders only fill the path and query: the host is fixed, so
+        // a request can never choose where it is sent.
+        let authority_end = proxy
+            .url
+            .find("://")
+            .map(|i| i + 3)
+            .and_then(|start| proxy.url[start..].find(['/', '?']).map(|end| start + end))
+            .unwrap_or(proxy.url.len());
+        if proxy.url[..authority_end].contains(['{', '$']) {
+            out.push(format!(
+                "{at}: proxy.url may only use placeholders after the host"
+            ));
+        } else if proxy.url.starts_with('/') {
+            out.push(format!("{at}: proxy.url must be an absolute URL"));
+        } else if let Err(e) = crate::util::validate_url_value(&proxy.url[..authority_end]) {
+            out.push(format!("{at}: proxy.url: {e}"));
+        }
+        check_refs(&proxy.url, "proxy.url", out);
+
+        for (name, value) in &proxy.headers {
+            let valid_name = !name.is_empty()
+                && name
+                    .bytes()
+                    .all(|b| b.is_ascii_alphanumeric() || b == b'-' || b == b'_');
+            if !valid_name {
+                out.push(format!(
+                    "{at}: proxy.headers: {name:?} is not a header name"
+                ));
+            }
+            if value.contains(['\r', '\n']) {
+                out.push(format!(
+                    "{at}: proxy.headers.{name} must not contain line breaks"
+                ));
+            }
+            check_refs(value, &format!("proxy.headers.{name}"), out);
+        }
+    }
+
+    fn check_crud(&self, crud: &CrudDef, at: &str, out: &mut Vec<String>) {
+        let prefix = table_scope::table_prefix(&self.name);
+        if !crud.table.starts_with(&prefix) || !is_identifier(&crud.table) {
+            out.push(format!(
+                "{at}: crud.table {:?} must be a {prefix}* table name",
+                crud.table
+            ));
+        }
+        if crud.columns.is_empty() {
+            out.push(format!("{at}: crud.columns must list the writable columns"));
+        }
+        let mut seen = HashSet::new();
+        for column in &crud.columns {
+            if !is_identifier(column) {
+                out.push(format!(
+                    "{at}: crud.columns: {column:?} is not a column name"
+                ));
+            } else if MANAGED_COLUMNS.contains(&column.as_str()) {
+                out.push(format!(
+                    "{at}: crud.columns: {column:?} is set automatically"
+                ));
+            }
+            if !seen.insert(column.as_str()) {
+                out.push(format!("{at}: crud.columns: {column:?} is listed twice"));
+            }
+        }
+        if crud.operations.is_empty() {
+            out.push(format!("{at}: crud.operations must not be empty"));
+        }
+    }
+}
+
+/// The `(method, template)` pairs a route answers.
+pub fn route_endpoints(route: &RouteDef) -> Vec<(&'static str, String)> {
+    if let Some(proxy) = &route.proxy {
+        return proxy
+            .methods
+            .iter()
+            .filter_map(|m| PROXY_METHODS.iter().find(|p| **p == m.as_str()))
+            .map(|m| (*m, route.path.clone()))
+            .collect();
+    }
+    if route.static_files.is_some() {
+        return vec![("GET", route.path.clone())];
+    }
+    let Some(crud) = &route.crud else {
+        return Vec::new();
+    };
+    let item = format!("{}/{{id}}", route.path);
+    crud.operations
+        .iter()
+        .map(|op| match op {
+            CrudOp::List => ("GET", route.path.clone()),
+            CrudOp::Get => ("GET", item.clone()),
+            CrudOp::Create => ("POST", route.path.clone()),
+            CrudOp::Update => ("PATCH", item.clone()),
+            CrudOp::Delete => ("DELETE", item.clone()),
+        })
+        .collect()
+}
+
+/// Names of the `{name}` / `{name...}` parameters in a route path.
+fn template_params(path: &str) -> Result<Vec<String>, String> {
+    let segments: Vec<&str> = path.split('/').collect();
+    let mut params = Vec::new();
+    for (i, segment) in segments.iter().enumerate() {
+        if !segment.contains(['{', '}']) {
+            continue;
+        }
+        let Some(inner) = segment.strip_prefix('{').and_then(|s| s.strip_suffix('}')) else {
+            return Err(format!("{segment:?} must be a whole {{name}} segment"));
+        };
+        let (name, rest) = match inner.strip_suffix("...") {
+            Some(name) => (name, true),
+            None => (inner, false),
+        };
+        if rest && i != segments.len() - 1 {
+            return Err(format!("{{{inner}}} must be the last segment"));
+        }
+        if !is_identifier(name) {
+            return Err(format!("{{{inner}}} is not a parameter name"));
+        }
+        if params.iter().any(|p| p == name) {
+            return Err(format!("{{{name}}} appears twice"));
+        }
+        params.push(name.to_string());
+    }
+    Ok(params)
+}
+
+/// The names between each `open` and the next `close` in `value`. A `{`
+/// that is part of `${` only counts as a config placeholder.
+pub(super) fn placeholders(value: &str, open: &str, close: &str) -> Vec<String> {
+    let mut out = Vec::new();
+    let mut rest = value;
+    while let Some(start) = rest.find(open) {
+        let is_config_brace = open == "{" && rest[..start].ends_with('$');
+        let after = &rest[start + open.len()..];
+        let Some(end) = after.find(close) else {
+            break;
+        };
+        if !is_config_brace {
+            out.push(after[..end].to_string());
+        }
+        rest = &after[end + close.len()..];
+    }
+    out
+}
+
+/// A path inside the extension directory: relative, with no `.`/`..`
+/// segments.
+fn check_relative_path(path: &str) -> Result<(), &'static str> {
+    if path.is_empty() {
+        return Err("is empty");
+    }
+    if path.starts_with('/') || path.contains('\\') || path.contains(':') {
+        return Err("must be relative to the extension directory");
+    }
+    if path
+        .split('/')
+        .any(|s| s.is_empty() || s == "." || s == "..")
+    {
+        return Err("must not contain empty, '.' or '..' segments");
+    }
+    Ok(())
+}
+
+fn is_slug(s: &str) -> bool {
+    !s.is_empty()
+        && !s.starts_with('-')
+        && s.bytes()
+            .all(|b| b.is_ascii_lowercase() || b.is_ascii_digit() || b == b'-')
+}
+
+fn is_identifier(s: &str) -> bool {
+    !s.is_empty()
+        && !s.starts_with(|c: char| c.is_ascii_digit())
+        && s.bytes()
+            .all(|b| b.is_ascii_lowercase() || b.is_ascii_digit() || b == b'_')
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn manifest(json: serde_json::Value) -> Manifest {
+        Manifest::parse(&json.to_string()).unwrap()
+    }
+
+    fn guestbook() -> serde_json::Value {
+        serde_json::json!({
+            "name": "acme/guestbook",
+            "version": "1.0.0",
+            "config": [{"key": "ACME__GUESTBOOK__TOKEN", "required": true}],
+            "migrations": {"sqlite": ["migrations/001.sqlite.sql"]},
+            "routes": [
+                {"path": "/b/guestbook/entries", "access": "authenticated",
+                 "crud": {"table": "acme__guestbook__entries", "columns": ["message"]}},
+                {"path": "/b/guestbook/weather/{city}", "access": "public",
+                 "proxy": {"url": "https://api.example.com/w/{city}?units=metric",
+                           "headers": {"Authorization": "Bearer ${ACME__GUESTBOOK__TOKEN}"}}},
+                {"path": "/b/guestbook/{file...}", "access": "public",
+                 "static": {"dir": "public"}}
+            ]
+        })
+    }
+
+    #[test]
+    fn valid_manifest_has_no_problems() {
+        let m = manifest(guestbook());
+        assert_eq!(m.problems(), Vec::<String>::new());
+        assert_eq!(m.mount_prefix(), "/b/guestbook/");
+        let endpoints: Vec<_> = m.routes.iter().flat_map(route_endpoints).collect();
+        assert!(endpoints.contains(&("PATCH", "/b/guestbook/entries/{id}".to_string())));
+        assert!(endpoints.contains(&("GET", "/b/guestbook/weather/{city}".to_string())));
+    }
+
+    #[test]
+    fn schema_errors_name_the_field() {
+        let mut doc = guestbook();
+        doc["routes"][0]["acess"] = serde_json::json!("public");
+        let err = Manifest::parse(&doc.to_string()).unwrap_err();
+        assert!(err.contains("unknown field `acess`"), "{err}");
+
+        let mut doc = guestbook();
+        doc["routes"][1].as_object_mut().unwrap().remove("access");
+        let err = Manifest::parse(&doc.to_string()).unwrap_err();
+        assert!(err.contains("missing field `access`"), "{err}");
+    }
+
+    #[test]
+    fn every_problem_is_reported() {
+        let m = manifest(serde_json::json!({
+            "name": "suppers-ai/admin",
+            "version": "",
+            "config": [{"key": "OTHER__KEY"}],
+            "migrations": {"sqlite": ["../shared.sql"]},
+            "routes": [
+                {"path": "/b/elsewhere/x", "access": "admin",
+                 "crud": {"table": "suppers_ai__auth__users", "columns": ["id"]}},
+                {"path": "/b/admin/x", "access": "public",
+                 "proxy": {"url": "https://{host}/x"}, "static": {"dir": "public"}},
+                {"path": "/b/admin/p/{id}", "access": "public",
+                 "proxy": {"url": "http://10.0.0.1/{other}", "methods": ["PUT"]}}
+            ]
+        }));
+        let problems = m.problems().join("\n");
+        for expected in [
+            "org is reserved",
+            "/b/admin/ is already routed to suppers-ai/admin",
+            "version must not be empty",
+            "key \"OTHER__KEY\" must be SUPPERS_AI__ADMIN__NAME",
+            "\"../shared.sql\" must not contain",
+            "routes[0] (/b/elsewhere/x): path must be below /b/admin/",
+            "crud.table \"suppers_ai__auth__users\" must be a suppers_ai__admin__* table name",
+            "crud.columns: \"id\" is set automatically",
+            "set exactly one of",
+            "may only use placeholders after the host",
+            "\"PUT\" is not one of",
+            "uses {other}, which the route path doesn't define",
+            "proxy.url: ",
+        ] {
+            assert!(
+                problems.contains(expected),
+                "missing {expected:?} in:\n{problems}"
+            );
+        }
+    }
+
+    #[test]
+    fn route_paths_must_be_canonical_templates() {
+        for (path, expected) in [
+            ("/b/guestbook//x", "must not contain '//'"),
+            ("/b/guestbook/{rest...}/x", "must be the last segment"),
+            ("/b/guestbook/a{id}", "must be a whole {name} segment"),
+            ("/b/guestbook/", "must be below /b/guestbook/"),
+        ] {
+            let mut doc = guestbook();
+            doc["routes"] = serde_json::json!([
+                {"path": path, "access": "public", "proxy": {"url": "https://example.com/"}}
+            ]);
+            let problems = manifest(doc).problems().join("\n");
+            assert!(problems.contains(expected), "{path}: {problems}");
+        }
+    }
+
+    #[test]
+    fn permissions_are_scoped_to_the_block_and_authenticated_routes() {
+        let mut doc = guestbook();
+        doc["routes"][0]["permission"] = serde_json::json!("guestbook.write");
+        assert_eq!(manifest(doc).problems(), Vec::<String>::new());
+
+        for (route, permission, expected) in [
+            (0, "storage.write", "must be guestbook.{action}"),
+            (0, "guestbook", "must be guestbook.{action}"),
+            (0, "guestbook.Write", "must be guestbook.{action}"),
+            (
+                1,
+                "guestbook.read",
+                "only applies to an \"authenticated\" route",
+            ),
+        ] {
+            let mut doc = guestbook();
+            doc["routes"][route]["permission"] = serde_json::json!(permission);
+            let problems = manifest(doc).problems().join("\n");
+            assert!(problems.contains(expected), "{permission}: {problems}");
+        }
+    }
+
+    #[test]
+    fn placeholders_split_params_from_config() {
+        let url = "https://x.test/{a}/${B__C}?q={d}";
+        assert_eq!(placeholders(url, "{", "}"), ["a", "d"]);
+        assert_eq!(placeholders(url, "${", "}"), ["B__C"]);
+    }
+}
@@ -0,0 +1,758 @@
+//! Declarative extensions — blocks described by a manifest instead of code.
+//!
+//! An extension is a directory holding a `manifest.json` (format in
+//! [`manifest`]), the SQL migrations it names and any static files. [`scan`]
+//! loads every subdirectory of the extensions directory
+//! (`SOLOBASE_EXTENSIONS_DIR` on native) and each becomes a
+//! [`DeclarativeBlock`], registered through
+//! [`SolobaseBuilder::extension`](crate::builder::SolobaseBuilder::extension)
+//! like a compiled-in block: its migrations go through the same namespace
+//! check and schema gate, the router enforces each route's declared access,
+//! and it is listed by the admin extensions, status and metrics pages. The
+//! block checks a route's declared permission itself, and catalogues every
+//! permission it declares when it initialises.
+//!
+//! Everything is read and validated up front. A broken extension stops the
+//! server at startup with every problem listed, rather than half-loading;
+//! `solobase extensions validate` runs the same checks ahead of a deploy.
+
+mod behaviors;
+pub mod manifest;
+
+use std::{
+    collections::HashMap,
+    fmt, fs,
+    path::{Path, PathBuf},
+    sync::Arc,
+};
+
+use wafer_run::{
+    context::Context, AuthLevel, Block, BlockEndpoint, BlockInfo, ConfigVar, HttpMethod,
+    InputStream, InputType, InstanceMode, LifecycleEvent, Message, OutputStream, WaferError,
+};
+
+pub use self::manifest::Manifest;
+use self::{
+    behaviors::{Behaviour, StaticFile},
+    manifest::{route_endpoints, Access, ConfigEntry},
+};
+use crate::{
+    blocks::permissions,
+    endpoint_match::{action_for_method, match_template},
+    migration_helper, schema_status, table_scope,
+};
+
+/// Upper bound on the static files one extension keeps in memory.
+const MAX_STATIC_BYTES: u64 = 32 * 1024 * 1024;
+
+/// An extension directory that passed validation, with its files read.
+pub struct Extension {
+    pub manifest: Manifest,
+    pub dir: PathBuf,
+    sqlite: Vec<(String, String)>,
+    postgres: Vec<String>,
+    /// Files of each `static` route, indexed like `manifest.routes`.
+    static_files: Vec<HashMap<String, StaticFile>>,
+}
+
+/// Why an extension directory can't be loaded.
+#[derive(Debug)]
+pub struct LoadError {
+    pub dir: PathBuf,
+    pub problems: Vec<String>,
+}
+
+impl fmt::Display for LoadError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}:", self.dir.display())?;
+        for problem in &self.problems {
+            write!(f, "\n  - {problem}")?;
+        }
+        Ok(())
+    }
+}
+
+/// Load and validate the extension in `dir`.
+pub fn load(dir: &Path) -> Result<Extension, LoadError> {
+    let fail = |problems| LoadError {
+        dir: dir.to_path_buf(),
+        problems,
+    };
+    let json = fs::read_to_string(dir.join("manifest.json"))
+        .map_err(|e| fail(vec![format!("manifest.json: {e}")]))?;
+    let manifest = Manifest::parse(&json).map_err(|e| fail(vec![e]))?;
+    let mut problems = manifest.problems();
+
+    let mut read_sql = |dialect: &str, file: &str| match fs::read_to_string(dir.join(file)) {
+        Ok(sql) => Some(sql),
+        Err(e) => {
+            problems.push(format!("migrations.{dialect}: {file}: {e}"));
+            None
+        }
+    };
+    let sqlite: Vec<(String, String)> = manifest
+        .migrations
+        .sqlite
+        .iter()
+        .filter_map(|file| {
+            let sql = read_sql("sqlite", file)?;
+            let basename = file.rsplit('/').next().unwrap_or(file);
+            Some((basename.to_string(), sql))
+        })
+        .collect();
+    let postgres: Vec<String> = manifest
+        .migrations
+        .postgres
+        .iter()
+        .filter_map(|file| read_sql("postgres", file))
+        .collect();
+
+    if manifest.migrations.postgres.is_empty() != manifest.migrations.sqlite.is_empty() {
+        problems.push("migrations: list both sqlite and postgres scripts, or neither".to_string());
+    }
+    let mut foreign: Vec<String> = Vec::new();
+    for sql in sqlite.iter().map(|(_, sql)| sql).chain(&postgres) {
+        for table in table_scope::ddl_violations(&manifest.name, sql) {
+            if !foreign.contains(&table) {
+                foreign.push(table);
+            }
+        }
+    }
+    if !foreign.is_empty() {
+        problems.push(format!(
+            "migrations: tables must be named {}*, not {}",
+            table_scope::table_prefix(&manifest.name),
+            foreign.join(", ")
+        ));
+    }
+
+    let created: Vec<String> = sqlite
+        .iter()
+        .flat_map(|(_, sql)| schema_status::created_tables(sql))
+        .collect();
+    let mut static_files = Vec::new();
+    let mut static_bytes = 0;
+    for (i, route) in manifest.routes.iter().enumerate() {
+        let mut files = HashMap::new();
+        if let Some(crud) = &route.crud {
+            if !created.contains(&crud.table) {
+                problems.push(format!(
+                    "routes[{i}] ({}): crud.table {} is not created by the sqlite migrations",
+                    route.path, crud.table
+                ));
+            }
+        }
+        if let Some(def) = &route.static_files {
+            let root = dir.join(&def.dir);
+            if let Err(e) = read_static(&root, "", &mut files, &mut static_bytes) {
+                problems.push(format!("routes[{i}] ({}): static.dir: {e}", route.path));
+            }
+        }
+        static_files.push(files);
+    }
+    if static_bytes > MAX_STATIC_BYTES {
+        problems.push(format!(
+            "static files total {static_bytes} bytes, over the {MAX_STATIC_BYTES}-byte limit"
+        ));
+    }
+
+    if !problems.is_empty() {
+        return Err(fail(problems));
+    }
+    Ok(Extension {
+        manifest,
+        dir: dir.to_path_buf(),
+        sqlite,
+        postgres,
+        static_files,
+    })
+}
+
+/// Read the regular files under `root` into `files`, keyed by their
+/// `/`-separated path relative to the static directory. Symlinks are
+/// skipped so a static route can't reach outside its directory.
+fn read_static(
+    root: &Path,
+    prefix: &str,
+    files: &mut HashMap<String, StaticFile>,
+    total: &mut u64,
+) -> Result<(), String> {
+    let entries = fs::read_dir(root).map_err(|e| format!("{}: {e}", root.display()))?;
+    for entry in entries {
+        let entry = entry.map_err(|e| format!("{}: {e}", root.display()))?;
+        let Ok(name) = entry.file_name().into_string() else {
+            continue;
+        };
+        let file_type = entry.file_type().map_err(|e| format!("{name}: {e}"))?;
+        let key = format!("{prefix}{name}");
+        if file_type.is_dir() {
+            read_static(&entry.path(), &format!("{key}/"), files, total)?;
+        } else if file_type.is_file() {
+            let body = fs::read(entry.path()).map_err(|e| format!("{key}: {e}"))?;
+            *total += body.len() as u64;
+            if *total > MAX_STATIC_BYTES {
+                return Ok(());
+            }
+            let content_type = behaviors::content_type_for(&key);
+            files.insert(key, StaticFile { body, content_type });
+        }
+    }
+    Ok(())
+}
+
+/// Load every extension under `root`, one per subdirectory (dot-directories
+/// are ignored), in name order. Two extensions may not share a name or a
+/// mount prefix; the later directory is reported. `Err` only when `root`
+/// itself can't be read.
+pub fn scan(root: &Path) -> Result<Vec<Result<Extension, LoadError>>, String> {
+    let entries = fs::read_dir(root).map_err(|e| format!("{}: {e}", root.display()))?;
+    let mut dirs: Vec<PathBuf> = entries
+        .filter_map(Result::ok)
+        .filter(|e| e.file_type().is_ok_and(|t| t.is_dir()))
+        .filter(|e| !e.file_name().to_string_lossy().starts_with('.'))
+        .map(|e| e.path())
+        .collect();
+    dirs.sort();
+
+    let mut mounted: HashMap<String, String> = HashMap::new();
+    let mut out = Vec::new();
+    for dir in dirs {
+        let result = load(&dir).and_then(|ext| {
+            let prefix = ext.manifest.mount_prefix();
+            if let Some(other) = mounted.get(&prefix) {
+                return Err(LoadError {
+                    dir: dir.clone(),
+                    problems: vec![format!("{prefix} is already used by {other}")],
+                });
+            }
+            mounted.insert(prefix, ext.manifest.name.clone());
+            Ok(ext)
+        });
+        out.push(result);
+    }
+    Ok(out)
+}
+
+/// [`scan`] for startup: every extension under `root`, or one error listing
+/// the problems of each extension that failed.
+pub fn load_dir(root: &Path) -> Result<Vec<Extension>, String> {
+    let mut loaded = Vec::new();
+    let mut failed = Vec::new();
+    for result in scan(root)? {
+        match result {
+            Ok(ext) => loaded.push(ext),
+            Err(e) => failed.push(e.to_string()),
+        }
+    }
+    if !failed.is_empty() {
+        return Err(format!("invalid extensions\n{}", failed.join("\n")));
+    }
+    Ok(loaded)
+}
+
+/// One `(method, template)` an extension answers.
+struct Endpoint {
+    method: HttpMethod,
+    template: String,
+    permission: Option<String>,
+    behaviour: Arc<Behaviour>,
+}
+
+/// A loaded extension, ready to register.
+pub struct DeclarativeBlock {
+    info: BlockInfo,
+    config: Vec<ConfigEntry>,
+    endpoints: Vec<Endpoint>,
+    sqlite: &'static [(&'static str, &'static str)],
+    postgres: &'static [&'static str],
+}
+
+impl DeclarativeBlock {
+    /// Build the block for `ext`. The migration scripts are leaked to get
+    /// the `'static` lifetime the schema registry keeps them under; this
+    /// runs once per extension at startup.
+    pub fn new(ext: Extension) -> Self {
+        let Extension {
+            manifest,
+            sqlite,
+            postgres,
+            static_files,
+            ..
+        } = ext;
+
+        let mut endpoints = Vec::new();
+        let mut declared = Vec::new();
+        for (route, files) in manifest.routes.iter().zip(static_files) {
+            let auth = match route.access {
+                Access::Public => AuthLevel::Public,
+                Access::Authenticated => AuthLevel::Authenticated,
+                Access::Admin => AuthLevel::Admin,
+            };
+            let shared = route.proxy.clone().map(|p| Arc::new(Behaviour::Proxy(p)));
+            let mut files = Some(files);
+            for (method, template) in route_endpoints(route) {
+                let behaviour = if let Some(proxy) = &shared {
+                    proxy.clone()
+                } else if let Some(crud) = &route.crud {
+                    Arc::new(Behaviour::Crud {
+                        table: crud.table.clone(),
+                        columns: crud.columns.clone(),
+                        op: crud_op(method, &template, &route.path),
+                    })
+                } else {
+                    let param = template
+                        .rsplit_once("/{")
+                        .map_or("", |(_, p)| p.trim_end_matches("...}"))
+                        .to_string();
+                    let files = files.take().unwrap_or_default();
+                    Arc::new(Behaviour::Static { param, files })
+                };
+                let method = match method {
+                    "POST" => HttpMethod::Post,
+                    "PATCH" => HttpMethod::Patch,
+                    "DELETE" => HttpMethod::Delete,
+                    _ => HttpMethod::Get,
+                };
+                let endpoint = match method {
+                    HttpMethod::Get => BlockEndpoint::get(template.as_str()),
+                    HttpMethod::Post => BlockEndpoint::post(template.as_str()),
+                    HttpMethod::Patch => BlockEndpoint::patch(template.as_str()),
+                    HttpMethod::Delete => BlockEndpoint::delete(template.as_str()),
+                };
+                let endpoint = if route.summary.is_empty() {
+                    endpoint.auth(auth)
+                } else {
+                    endpoint.summary(route.summary.as_str()).auth(auth)
+                };
+                declared.push(endpoint);
+                endpoints.push(Endpoint {
+                    method,
+                    template,
+                    permission: route.permission.clone(),
+                    behaviour,
+                });
+            }
+        }
+
+        let config_keys = manifest
+            .config
+            .iter()
+            .map(|c| {
+                let var = ConfigVar::new(&c.key, &c.description, &c.default);
+                let var = if c.secret {
+                    var.input_type(InputType::Password)
+                } else {
+                    var
+                };
+                if c.required {
+                    var
+                } else {
+                    var.optional()
+                }
+            })
+            .collect();
+        let info = BlockInfo::new(
+            &manifest.name,
+            &manifest.version,
+            "http-handler@v1",
+            &manifest.summary,
+        )
+        .instance_mode(InstanceMode::Singleton)
+        .category(wafer_run::BlockCategory::Feature)
+        .endpoints(declared)
+        .config_keys(config_keys)
+        .can_disable(true);
+
+        let sqlite: Vec<(&'static str, &'static str)> = sqlite
+            .into_iter()
+            .map(|(name, sql)| (leak(name), leak(sql)))
+            .collect();
+        let postgres: Vec<&'static str> = postgres.into_iter().map(leak).collect();
+        Self {
+            info,
+            config: manifest.config,
+            endpoints,
+            sqlite: Box::leak(sqlite.into_boxed_slice()),
+            postgres: Box::leak(postgres.into_boxed_slice()),
+        }
+    }
+
+    pub fn name(&self) -> &str {
+        &self.info.name
+    }
+
+    /// The route prefix the extension is mounted under, `/b/{block}/`.
+    pub fn mount_prefix(&self) -> String {
+        let block = self.info.name.split_once('/').map_or("", |(_, b)| b);
+        format!("/b/{block}/")
+    }
+
+    /// Catalogue the permissions the routes declare. Failures are logged:
+    /// the routes still check the permission, and an admin can't grant one
+    /// that isn't listed until the next start.
+    async fn register_permissions(&self, ctx: &dyn Context) {
+        let mut declared: Vec<&str> = self
+            .endpoints
+            .iter()
+            .filter_map(|e| e.permission.as_deref())
+            .collect();
+        declared.sort_unstable();
+        declared.dedup();
+        for permission in declared {
+            if let Err(e) = permissions::register(ctx, permission).await {
+                tracing::warn!(
+                    block = %self.info.name,
+                    "Failed to register permission '{permission}': {e}"
+                );
+            }
+        }
+    }
+}
+
+fn leak(s: String) -> &'static str {
+    Box::leak(s.into_boxed_str())
+}
+
+/// Which CRUD operation a generated endpoint performs.
+fn crud_op(method: &str, template: &str, collection: &str) -> manifest::CrudOp {
+    use manifest::CrudOp;
+    match (method, template == collection) {
+        ("GET", true) => CrudOp::List,
+        ("POST", _) => CrudOp::Create,
+        ("PATCH", _) => CrudOp::Update,
+        ("DELETE", _) => CrudOp::Delete,
+        _ => CrudOp::Get,
+    }
+}
+
+#[wafer_block::wafer_async_trait]
+impl Block for DeclarativeBlock {
+    fn info(&self) -> BlockInfo {
+        self.info.clone()
+    }
+
+    async fn lifecycle(&self, ctx: &dyn Context, event: LifecycleEvent) -> Result<(), WaferError> {
+        if !(self.sqlite.is_empty() && self.postgres.is_empty()) {
+            migration_helper::lifecycle_init(
+                ctx,
+                &event,
+                &self.info.name,
+                self.sqlite,
+                self.postgres,
+            )
+            .await?;
+        }
+        if matches!(event.event_type, wafer_run::LifecycleType::Init) {
+            self.register_permissions(ctx).await;
+        }
+        Ok(())
+    }
+
+    async fn handle(
+        &self,
+        ctx: &dyn Context,
+        mut msg: Message,
+        input: InputStream,
+    ) -> OutputStream {
+        // Access is enforced by the router from the declared endpoints,
+        // permissions here; routes are tried in manifest order.
+        let action = msg.action().to_string();
+        let path = msg.path().to_string();
+        for endpoint in &self.endpoints {
+            if action_for_method(endpoint.method) != action {
+                continue;
+            }
+            let Some(params) = match_template(&endpoint.template, &path) else {
+                continue;
+            };
+            if let Some(permission) = &endpoint.permission {
+                if let Some(denied) = permissions::require(ctx, &msg, permission).await {
+                    return denied;
+                }
+            }
+            for (name, value) in params {
+                msg.set_meta(
+                    format!("{}{}", wafer_run::META_REQ_PARAM_PREFIX, name),
+                    value.to_string(),
+                );
+            }
+            return endpoint
+                .behaviour
+                .handle(ctx, &msg, input, &self.config)
+                .await;
+        }
+        crate::ui::not_found_response(&msg)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{
+        admin_msg, anon_msg, auth_msg, output_body, output_json, output_status, TestContext,
+    };
+
+    fn write(dir: &Path, file: &str, contents: &str) {
+        let path = dir.join(file);
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(path, contents).unwrap();
+    }
+
+    const ENTRIES_SQL: &str = "CREATE TABLE IF NOT EXISTS acme__guestbook__entries (\
+        id TEXT PRIMARY KEY, message TEXT NOT NULL DEFAULT '', \
+        created_at TEXT NOT NULL, updated_at TEXT NOT NULL);";
+
+    /// A valid guestbook extension under `root/guestbook`.
+    fn guestbook(root: &Path) -> PathBuf {
+        let dir = root.join("guestbook");
+        write(
+            &dir,
+            "manifest.json",
+            &serde_json::json!({
+                "name": "acme/guestbook",
+                "version": "1.0.0",
+                "summary": "Guestbook",
+                "migrations": {
+                    "sqlite": ["migrations/001.sqlite.sql"],
+                    "postgres": ["migrations/001.postgres.sql"]
+                },
+                "routes": [
+                    {"path": "/b/guestbook/entries", "access": "authenticated",
+                     "crud": {"table": "acme__guestbook__entries", "columns": ["message"],
+                              "operations": ["list", "create", "update"]}},
+                    {"path": "/b/guestbook/{file...}", "access": "public",
+                     "static": {"dir": "public"}}
+                ]
+            })
+            .to_string(),
+        );
+        write(&dir, "migrations/001.sqlite.sql", ENTRIES_SQL);
+        write(&dir, "migrations/001.postgres.sql", ENTRIES_SQL);
+        write(&dir, "public/index.html", "<h1>Sign the guestbook</h1>");
+        write(&dir, "public/css/site.css", "h1 { color: teal }");
+        dir
+    }
+
+    #[test]
+    fn loads_a_valid_extension() {
+        let root = tempfile::tempdir().unwrap();
+        let ext = load(&guestbook(root.path())).unwrap_or_else(|e| panic!("{e}"));
+        assert_eq!(ext.static_files[1].len(), 2);
+
+        let block = DeclarativeBlock::new(ext);
+        assert_eq!(block.mount_prefix(), "/b/guestbook/");
+        let info = block.info();
+        assert_eq!(info.name, "acme/guestbook");
+        let authenticated: Vec<_> = info
+            .endpoints
+            .iter()
+            .filter(|e| matches!(e.auth, AuthLevel::Authenticated))
+            .map(|e| e.path.as_str())
+            .collect();
+        assert_eq!(
+            authenticated,
+            [
+                "/b/guestbook/entries",
+                "/b/guestbook/entries",
+                "/b/guestbook/entries/{id}"
+            ]
+        );
+    }
+
+    #[test]
+    fn reports_missing_files_and_foreign_tables() {
+        let root = tempfile::tempdir().unwrap();
+        let dir = guestbook(root.path());
+        write(
+            &dir,
+            "migrations/001.sqlite.sql",
+            "CREATE TABLE suppers_ai__auth__shadow (id TEXT PRIMARY KEY);",
+        );
+        fs::remove_file(dir.join("migrations/001.postgres.sql")).unwrap();
+        fs::remove_dir_all(dir.join("public")).unwrap();
+
+        let problems = load(&dir).err().unwrap().problems.join("\n");
+        for expected in [
+            "migrations.postgres: migrations/001.postgres.sql:",
+            "tables must be named acme__guestbook__*, not suppers_ai__auth__shadow",
+            "crud.table acme__guestbook__entries is not created by the sqlite migrations",
+            "routes[1] (/b/guestbook/{file...}): static.dir:",
+        ] {
+            assert!(
+                problems.contains(expected),
+                "missing {expected:?} in:\n{problems}"
+            );
+        }
+    }
+
+    #[test]
+    fn scan_reports_each_directory() {
+        let root = tempfile::tempdir().unwrap();
+        guestbook(root.path());
+        fs::create_dir_all(root.path().join("empty")).unwrap();
+        fs::create_dir_all(root.path().join(".git")).unwrap();
+        let copy = root.path().join("other");
+        fs::create_dir_all(&copy).unwrap();
+        for file in [
+            "manifest.json",
+            "migrations/001.sqlite.sql",
+            "migrations/001.postgres.sql",
+        ] {
+            let body = fs::read_to_string(root.path().join("guestbook").join(file)).unwrap();
+            let body = body
+                .replace("acme/guestbook", "other-org/guestbook")
+                .replace("acme__guestbook__", "other_org__guestbook__");
+            write(&copy, file, &body);
+        }
+        fs::create_dir_all(copy.join("public")).unwrap();
+
+        let results = scan(root.path()).unwrap();
+        assert_eq!(results.len(), 3);
+        assert!(results[0].is_err(), "empty has no manifest");
+        assert!(results[1].is_ok());
+        let err = results[2].as_ref().err().unwrap().to_string();
+        assert!(
+            err.contains("/b/guestbook/ is already used by acme/guestbook"),
+            "{err}"
+        );
+
+        let err = load_dir(root.path()).err().unwrap();
+        assert!(err.contains("manifest.json:"), "{err}");
+    }
+
+    async fn guestbook_block(ctx: &mut TestContext) -> Arc<DeclarativeBlock> {
+        let root = tempfile::tempdir().unwrap();
+        let block = Arc::new(DeclarativeBlock::new(
+            load(&guestbook(root.path())).unwrap(),
+        ));
+        ctx.register_block("acme/guestbook", block.clone());
+        migration_helper::apply_scoped(ctx, "acme/guestbook", block.sqlite, block.postgres)
+            .await
+            .unwrap();
+        block
+    }
+
+    #[tokio::test]
+    async fn crud_routes_accept_only_declared_columns() {
+        let mut ctx = TestContext::with_admin().await;
+        let block = guestbook_block(&mut ctx).await;
+
+        let msg = admin_msg("create", "/b/guestbook/entries");
+        let body = serde_json::json!({"message": "hello"}).to_string();
+        let out = block
+            .handle(&ctx, msg, InputStream::from_bytes(body.into_bytes()))
+            .await;
+        let created = output_json(out).await;
+        assert_eq!(created["data"]["message"], "hello");
+
+        let msg = admin_msg("create", "/b/guestbook/entries");
+        let body = serde_json::json!({"message": "x", "id": "forged"}).to_string();
+        let out = block
+            .handle(&ctx, msg, InputStream::from_bytes(body.into_bytes()))
+            .await;
+        assert_eq!(output_status(out).await, 400);
+
+        let msg = admin_msg("retrieve", "/b/guestbook/entries");
+        let out = block.handle(&ctx, msg, InputStream::empty()).await;
+        assert_eq!(output_json(out).await["total_count"], 1);
+
+        // `get` and `delete` weren't listed in `operations`.
+        let msg = admin_msg("delete", "/b/guestbook/entries/abc");
+        let out = block.handle(&ctx, msg, InputStream::empty()).await;
+        assert_eq!(output_status(out).await, 404);
+    }
+
+    #[tokio::test]
+    async fn static_routes_serve_loaded_files() {
+        let mut ctx = TestContext::with_admin().await;
+        let block = guestbook_block(&mut ctx).await;
+
+        let msg = anon_msg("retrieve", "/b/guestbook/css/site.css");
+        let out = block.handle(&ctx, msg, InputStream::empty()).await;
+        assert_eq!(output_body(out).await, b"h1 { color: teal }");
+
+        for path in [
+            "/b/guestbook/missing.html",
+            "/b/guestbook/css/../index.html",
+        ] {
+            let out = block
+                .handle(&ctx, anon_msg("retrieve", path), InputStream::empty())
+                .await;
+            assert_eq!(output_status(out).await, 404, "{path}");
+        }
+    }
+
+    #[tokio::test]
+    async fn declared_permissions_are_registered_and_required() {
+        let mut ctx = TestContext::with_admin().await;
+        let root = tempfile::tempdir().unwrap();
+        let mut ext = load(&guestbook(root.path())).unwrap();
+        ext.manifest.routes[0].permission = Some("guestbook.write".into());
+        let block = Arc::new(DeclarativeBlock::new(ext));
+        ctx.register_block("acme/guestbook", block.clone());
+        migration_helper::apply_scoped(&ctx, "acme/guestbook", block.sqlite, block.postgres)
+            .await
+            .unwrap();
+        block.register_permissions(&ctx).await;
+        assert!(!permissions::register(&ctx, "guestbook.write")
+            .await
+            .unwrap());
+
+        let as_user = |roles: &str| {
+            let mut msg = auth_msg("retrieve", "/b/guestbook/entries", "u1");
+            msg.set_meta("auth.user_roles", roles);
+            msg
+        };
+        let out = block
+            .handle(&ctx, as_user("user"), InputStream::empty())
+            .await;
+        let body = output_json(out).await;
+        assert_eq!(body["details"]["permission"], "guestbook.write");
+
+        let mut data = crate::util::json_map(serde_json::json!({
+            "name": "editor",
+            "permissions": ["guestbook.*"],
+        }));
+        crate::util::stamp_created(&mut data);
+        wafer_core::clients::database::create(&ctx, crate::blocks::admin::ROLES_TABLE, data)
+            .await
+            .unwrap();
+        let out = block
+            .handle(&ctx, as_user("user,editor"), InputStream::empty())
+            .await;
+        assert_eq!(output_status(out).await, 200);
+    }
+
+    #[tokio::test]
+    async fn the_router_enforces_declared_access() {
+        use crate::routing::{route_to_block, ExtraRoute, RouteAccess, RouteSource};
+
+        let mut ctx = TestContext::with_admin().await;
+        let block = guestbook_block(&mut ctx).await;
+        let infos = vec![block.info()];
+        let routes = vec![ExtraRoute {
+            prefix: block.mount_prefix(),
+            access: RouteAccess::Public,
+            block_name: block.name().to_string(),
+            source: RouteSource::Extension,
+        }];
+        let (ctx, infos, routes) = (&ctx, &infos, &routes);
+        let send = move |msg: Message| {
+            route_to_block(
+                ctx,
+                msg,
+                InputStream::empty(),
+                &crate::features::AllEnabled,
+                infos,
+                routes,
+            )
+        };
+
+        let out = send(anon_msg("retrieve", "/b/guestbook/entries")).await;
+        assert_eq!(output_status(out).await, 403);
+        let out = send(auth_msg("retrieve", "/b/guestbook/entries", "u1")).await;
+        assert_eq!(output_status(out).await, 200);
+        let out = send(anon_msg("retrieve", "/b/guestbook/index.html")).await;
+        assert_eq!(output_status(out).await, 200);
+    }
+}
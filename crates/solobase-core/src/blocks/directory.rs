@@ -0,0 +1,374 @@
+//! Who a user is, for display: the profile behind a user id.
+//!
+//! Share dialogs, object history and the admin users and audit-log pages
+//! show people by their [`Profile`] — email, display name, avatar — rather
+//! than by bare ids. By default profiles come from auth's users directory
+//! ([`AuthUsersDirectory`]). An embedder that keeps richer profiles in its
+//! own tables registers its own [`DirectoryProvider`] instead —
+//! `SolobaseBuilder::directory_provider` — which runs behind its own block,
+//! [`DirectoryBlock`] (`suppers-ai/directory`), and is reached through
+//! `ctx.call_block`.
+//!
+//! A provider only decides how people are shown, never who they are: share
+//! targets are still resolved to accounts by auth. Its answer is advisory —
+//! when it fails, or leaves an id out, the users directory answers instead,
+//! so a broken provider costs names, never a request. Callers resolve every
+//! id a response shows in one [`lookup`] (ids are deduplicated), so a page
+//! is one provider call rather than one per row.
+
+use std::{collections::HashMap, sync::Arc};
+
+use wafer_block::{MaybeSend, MaybeSync};
+use wafer_run::{
+    context::Context, Block, BlockInfo, InputStream, InstanceMode, Message, OutputStream,
+};
+
+use super::auth::repo::directory::{self, DirectoryEntry};
+use crate::http::{err_bad_request, err_internal_no_cause, err_not_found, ok_json};
+
+/// Name an external directory provider's block is registered under.
+pub const DIRECTORY_BLOCK: &str = "suppers-ai/directory";
+
+/// Message kind for [`DirectoryProvider::lookup_users`]: the body is
+/// `{"ids": [...]}`, the response a JSON array of [`Profile`]s.
+pub const LOOKUP_KIND: &str = "directory.lookup";
+
+/// Message kind for [`DirectoryProvider::search_users`]: the body is
+/// `{"query", "limit"}`, the response a JSON array of [`Profile`]s.
+pub const SEARCH_KIND: &str = "directory.search";
+
+/// Most profiles one [`search`] returns, whatever the caller asks for.
+pub const MAX_SEARCH_RESULTS: i64 = directory::MAX_SEARCH_RESULTS;
+
+/// How a user is shown.
+#[derive(Debug, Clone, Default, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+pub struct Profile {
+    pub id: String,
+    pub email: String,
+    #[serde(default)]
+    pub display_name: String,
+    /// `""` when there is none.
+    #[serde(default)]
+    pub avatar_url: String,
+}
+
+impl Profile {
+    /// The name to show: the display name, else the email.
+    pub fn label(&self) -> &str {
+        if self.display_name.trim().is_empty() {
+            &self.email
+        } else {
+            &self.display_name
+        }
+    }
+}
+
+impl From<DirectoryEntry> for Profile {
+    fn from(entry: DirectoryEntry) -> Self {
+        Self {
+            id: entry.id,
+            email: entry.email,
+            display_name: entry.display_name,
+            avatar_url: entry.avatar_url,
+        }
+    }
+}
+
+/// A source of user profiles (an embedder's own user tables, say). The
+/// error is a message for the log; the users directory answers instead.
+#[cfg_attr(target_arch = "wasm32", async_trait::async_trait(?Send))]
+#[cfg_attr(not(target_arch = "wasm32"), async_trait::async_trait)]
+pub trait DirectoryProvider: MaybeSend + MaybeSync {
+    /// Profiles for `ids`; ids it doesn't know may be left out.
+    async fn lookup_users(&self, ctx: &dyn Context, ids: &[String])
+        -> Result<Vec<Profile>, String>;
+
+    /// Up to `limit` profiles matching `query`, best match first.
+    async fn search_users(
+        &self,
+        ctx: &dyn Context,
+        query: &str,
+        limit: i64,
+    ) -> Result<Vec<Profile>, String>;
+}
+
+/// The default provider: live accounts from auth's users directory, searched
+/// by email. The caller needs a read grant on
+/// `suppers_ai__auth__users_directory`.
+pub struct AuthUsersDirectory;
+
+#[cfg_attr(target_arch = "wasm32", async_trait::async_trait(?Send))]
+#[cfg_attr(not(target_arch = "wasm32"), async_trait::async_trait)]
+impl DirectoryProvider for AuthUsersDirectory {
+    async fn lookup_users(
+        &self,
+        ctx: &dyn Context,
+        ids: &[String],
+    ) -> Result<Vec<Profile>, String> {
+        let ids: Vec<&str> = ids.iter().map(String::as_str).collect();
+        let entries = directory::find_by_ids(ctx, &ids)
+            .await
+            .map_err(|e| e.to_string())?;
+        Ok(entries.into_iter().map(Profile::from).collect())
+    }
+
+    async fn search_users(
+        &self,
+        ctx: &dyn Context,
+        query: &str,
+        limit: i64,
+    ) -> Result<Vec<Profile>, String> {
+        let entries = directory::search(ctx, query, limit)
+            .await
+            .map_err(|e| e.to_string())?;
+        Ok(entries.into_iter().map(Profile::from).collect())
+    }
+}
+
+/// Serves a [`DirectoryProvider`] as the `suppers-ai/directory` block.
+pub struct DirectoryBlock {
+    provider: Arc<dyn DirectoryProvider>,
+}
+
+impl DirectoryBlock {
+    pub fn new(provider: Arc<dyn DirectoryProvider>) -> Self {
+        Self { provider }
+    }
+}
+
+#[derive(serde::Serialize, serde::Deserialize)]
+struct LookupRequest {
+    ids: Vec<String>,
+}
+
+#[derive(serde::Serialize, serde::Deserialize)]
+struct SearchRequest {
+    query: String,
+    limit: i64,
+}
+
+#[wafer_block::wafer_async_trait]
+impl Block for DirectoryBlock {
+    fn info(&self) -> BlockInfo {
+        BlockInfo::new(
+            DIRECTORY_BLOCK,
+            "0.0.1",
+            "directory@v1",
+            "User profiles for display",
+        )
+        .instance_mode(InstanceMode::Singleton)
+        .category(wafer_run::BlockCategory::Service)
+    }
+
+    async fn handle(&self, ctx: &dyn Context, msg: Message, input: InputStream) -> OutputStream {
+        let raw = input.collect_to_bytes().await;
+        let result = match msg.kind.as_str() {
+            LOOKUP_KIND => match serde_json::from_slice::<LookupRequest>(&raw) {
+                Ok(req) => self.provider.lookup_users(ctx, &req.ids).await,
+                Err(e) => return err_bad_request(&format!("Invalid lookup request: {e}")),
+            },
+            SEARCH_KIND => match serde_json::from_slice::<SearchRequest>(&raw) {
+                Ok(req) => self.provider.search_users(ctx, &req.query, req.limit).await,
+                Err(e) => return err_bad_request(&format!("Invalid search request: {e}")),
+            },
+            _ => return err_not_found("not found"),
+        };
+        match result {
+            Ok(profiles) => ok_json(&profiles),
+            Err(e) => err_internal_no_cause(&format!("Directory lookup failed: {e}")),
+        }
+    }
+}
+
+/// Whether an embedder registered a provider.
+fn has_provider(ctx: &dyn Context) -> bool {
+    ctx.registered_blocks()
+        .iter()
+        .any(|b| b.name == DIRECTORY_BLOCK)
+}
+
+/// Ask the registered provider block.
+async fn call(
+    ctx: &dyn Context,
+    kind: &str,
+    body: &impl serde::Serialize,
+) -> Result<Vec<Profile>, String> {
+    let body = serde_json::to_vec(body).map_err(|e| e.to_string())?;
+    let out = ctx
+        .call_block(
+            DIRECTORY_BLOCK,
+            Message::new(kind),
+            InputStream::from_bytes(body),
+        )
+        .await;
+    let buf = out
+        .collect_buffered()
+        .await
+        .map_err(|e| format!("provider call failed: {e:?}"))?;
+    serde_json::from_slice(&buf.body).map_err(|e| format!("invalid provider response: {e}"))
+}
+
+/// Profiles for `ids`, keyed by id: the provider's, else the users
+/// directory's. Ids neither knows are absent; nothing here fails the caller.
+pub async fn lookup(ctx: &dyn Context, ids: &[&str]) -> HashMap<String, Profile> {
+    let mut ids: Vec<String> = ids
+        .iter()
+        .filter(|id| !id.is_empty())
+        .map(|id| id.to_string())
+        .collect();
+    ids.sort();
+    ids.dedup();
+    let mut found = HashMap::new();
+    if ids.is_empty() {
+        return found;
+    }
+    if has_provider(ctx) {
+        match call(ctx, LOOKUP_KIND, &LookupRequest { ids: ids.clone() }).await {
+            Ok(profiles) => found.extend(
+                profiles
+                    .into_iter()
+                    .filter(|p| ids.binary_search(&p.id).is_ok())
+                    .map(|p| (p.id.clone(), p)),
+            ),
+            Err(e) => tracing::warn!(error = %e, "directory provider lookup failed"),
+        }
+    }
+    let missing: Vec<String> = ids
+        .into_iter()
+        .filter(|id| !found.contains_key(id))
+        .collect();
+    if !missing.is_empty() {
+        match AuthUsersDirectory.lookup_users(ctx, &missing).await {
+            Ok(profiles) => found.extend(profiles.into_iter().map(|p| (p.id.clone(), p))),
+            Err(e) => tracing::warn!(error = %e, "users directory lookup failed"),
+        }
+    }
+    found
+}
+
+/// Up to `limit` (clamped to `1..=`[`MAX_SEARCH_RESULTS`]) profiles
+/// matching `query`: the provider's, else the users directory's. An empty
+/// query finds nothing, so the directory can't be listed wholesale.
+pub async fn search(ctx: &dyn Context, query: &str, limit: i64) -> Vec<Profile> {
+    let query = query.trim();
+    let limit = limit.clamp(1, MAX_SEARCH_RESULTS);
+    if query.is_empty() {
+        return Vec::new();
+    }
+    if has_provider(ctx) {
+        let req = SearchRequest {
+            query: query.to_string(),
+            limit,
+        };
+        match call(ctx, SEARCH_KIND, &req).await {
+            Ok(mut profiles) => {
+                profiles.truncate(limit as usize);
+                return profiles;
+            }
+            Err(e) => tracing::warn!(error = %e, "directory provider search failed"),
+        }
+    }
+    AuthUsersDirectory
+        .search_users(ctx, query, limit)
+        .await
+        .unwrap_or_else(|e| {
+            tracing::warn!(error = %e, "users directory search failed");
+            Vec::new()
+        })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{blocks::auth::repo::users, test_support::TestContext};
+
+    async fn seed(ctx: &TestContext, email: &str, display_name: &str) -> String {
+        users::insert(
+            ctx,
+            users::NewUser {
+                email: email.to_string(),
+                display_name: display_name.to_string(),
+                avatar_url: None,
+                role: "user".to_string(),
+            },
+        )
+        .await
+        .unwrap()
+        .id
+    }
+
+    /// Knows one user under a richer name; fails every search.
+    struct Hr {
+        id: String,
+    }
+
+    #[cfg_attr(target_arch = "wasm32", async_trait::async_trait(?Send))]
+    #[cfg_attr(not(target_arch = "wasm32"), async_trait::async_trait)]
+    impl DirectoryProvider for Hr {
+        async fn lookup_users(
+            &self,
+            _ctx: &dyn Context,
+            ids: &[String],
+        ) -> Result<Vec<Profile>, String> {
+            Ok(ids
+                .iter()
+                .filter(|id| **id == self.id)
+                .map(|id| Profile {
+                    id: id.clone(),
+                    email: "ana@corp.example".to_string(),
+                    display_name: "Ana (Finance)".to_string(),
+                    avatar_url: "https://hr.example/ana.png".to_string(),
+                })
+                .collect())
+        }
+
+        async fn search_users(
+            &self,
+            _ctx: &dyn Context,
+            _query: &str,
+            _limit: i64,
+        ) -> Result<Vec<Profile>, String> {
+            Err("hr service down".to_string())
+        }
+    }
+
+    #[tokio::test]
+    async fn users_directory_answers_by_default() {
+        let ctx = TestContext::with_auth().await;
+        let ana = seed(&ctx, "ana@example.com", "Ana").await;
+        let bo = seed(&ctx, "bo@example.com", "").await;
+
+        let found = lookup(&ctx, &[ana.as_str(), &bo, &ana, "nobody", ""]).await;
+        assert_eq!(found.len(), 2);
+        assert_eq!(found[&ana].label(), "Ana");
+        assert_eq!(found[&bo].label(), "bo@example.com");
+
+        let hits = search(&ctx, "ana@", 10).await;
+        assert_eq!(hits.len(), 1);
+        assert_eq!(hits[0].id, ana);
+        assert!(search(&ctx, "example.com", 10).await.is_empty());
+        assert!(search(&ctx, " ", 10).await.is_empty());
+    }
+
+    #[tokio::test]
+    async fn a_provider_answers_first_and_its_failures_fall_back() {
+        let mut ctx = TestContext::with_auth().await;
+        let ana = seed(&ctx, "ana@example.com", "Ana").await;
+        let bo = seed(&ctx, "bo@example.com", "Bo").await;
+        ctx.register_block(
+            DIRECTORY_BLOCK,
+            Arc::new(DirectoryBlock::new(Arc::new(Hr { id: ana.clone() }))),
+        );
+
+        let found = lookup(&ctx, &[ana.as_str(), &bo]).await;
+        assert_eq!(found[&ana].label(), "Ana (Finance)");
+        assert_eq!(found[&ana].avatar_url, "https://hr.example/ana.png");
+        // The provider doesn't know Bo; the users directory does.
+        assert_eq!(found[&bo].label(), "Bo");
+
+        // A failing search degrades to the users directory.
+        let hits = search(&ctx, "bo@", 10).await;
+        assert_eq!(hits.len(), 1);
+        assert_eq!(hits[0].id, bo);
+    }
+}
@@ -0,0 +1,41 @@
+-- Outbound email queue and delivery log (first schema for suppers-ai/email).
+--
+-- `outbox` holds one row per message: `pending` rows wait for their
+-- `next_attempt_at`, `sent` rows have their bodies blanked, and `failed`
+-- rows keep the rendered message so an admin can re-send it. `log` records
+-- every delivery attempt with the recipient redacted; it is the audit
+-- trail, the outbox is the work queue.
+CREATE TABLE IF NOT EXISTS suppers_ai__email__outbox (
+    id               TEXT PRIMARY KEY,
+    template         TEXT NOT NULL DEFAULT '',
+    recipient        TEXT NOT NULL,
+    subject          TEXT NOT NULL DEFAULT '',
+    html             TEXT NOT NULL DEFAULT '',
+    text_body        TEXT NOT NULL DEFAULT '',
+    status           TEXT NOT NULL DEFAULT 'pending',
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    last_error       TEXT NOT NULL DEFAULT '',
+    next_attempt_at  TEXT NOT NULL DEFAULT '',
+    sent_at          TEXT,
+    created_at       TEXT NOT NULL,
+    updated_at       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_email_outbox_status_next
+    ON suppers_ai__email__outbox (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__email__log (
+    id          TEXT PRIMARY KEY,
+    outbox_id   TEXT NOT NULL DEFAULT '',
+    template    TEXT NOT NULL DEFAULT '',
+    recipient   TEXT NOT NULL DEFAULT '',
+    provider    TEXT NOT NULL DEFAULT '',
+    status      TEXT NOT NULL,
+    error       TEXT NOT NULL DEFAULT '',
+    attempt     INTEGER NOT NULL DEFAULT 1,
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_email_log_created
+    ON suppers_ai__email__log (created_at);
+CREATE INDEX IF NOT EXISTS idx_email_log_outbox
+    ON suppers_ai__email__log (outbox_id);
@@ -0,0 +1,12 @@
+//! Email block migrations. Applied from the block's `Init` lifecycle via
+//! [`crate::migration_helper::lifecycle_init`].
+
+const SQL_001_SQLITE: &str = include_str!("001_email_outbox.sqlite.sql");
+const SQL_001_POSTGRES: &str = include_str!("001_email_outbox.postgres.sql");
+
+/// Ordered SQLite migration scripts for this block, as `(basename, content)`
+/// pairs. Feeds the runtime `lifecycle_init` apply path.
+pub(crate) const SQLITE_MIGRATIONS: &[(&str, &str)] = &[("001_email_outbox", SQL_001_SQLITE)];
+
+/// Ordered PostgreSQL migration scripts, matching [`SQLITE_MIGRATIONS`].
+pub(crate) const POSTGRES_MIGRATIONS: &[&str] = &[SQL_001_POSTGRES];
@@ -0,0 +1,1459 @@
+//! Email block — renders and delivers transactional email.
+//!
+//! Routes:
+//! - `email.send` — Send a raw email (to, subject, html, text)
+//! - `email.send_template` — Send a templated email (template name + variables)
+//! - `email.log` — Recent delivery attempts (`{limit}`), for the admin API
+//! - `email.resend` — Re-send a failed outbox message (`{id}`)
+//! - `email.drain` — Retry every pending message whose backoff has elapsed
+//!
+//! Messages go through the outbox ([`outbox`]) and out via the configured
+//! provider ([`providers`]: Mailgun, SES, a generic webhook, or the
+//! in-memory mock). Settings come from `wafer-run/config`, so the admin
+//! variables table overrides env defaults.
+//!
+//! A template is sent in the recipient's language when an admin has
+//! uploaded its `email.{template}.*` translation (see [`crate::i18n`]);
+//! otherwise the built-in English below is used.
+
+pub(crate) mod migrations;
+pub mod outbox;
+pub mod providers;
+
+use std::time::Duration;
+
+use serde::{Deserialize, Serialize};
+use wafer_core::clients::config;
+use wafer_run::{
+    context::Context, BlockInfo, CollectionSchema, ConfigVar, InputStream, InputType, InstanceMode,
+    LifecycleType, OutputStream,
+};
+
+use self::providers::OutgoingEmail;
+use super::rate_limit::{RateLimit, UserRateLimiter};
+use crate::{
+    http::{err_bad_request, err_conflict, err_internal, err_not_found, ok_json},
+    i18n,
+    util::urlencode,
+};
+
+/// Default per-caller rate limit: 100 emails per hour.
+const DEFAULT_RATE_LIMIT_MAX: u32 = 100;
+const DEFAULT_RATE_LIMIT_WINDOW_SECS: u64 = 3600;
+
+/// Default Mailgun API base URL (US region). EU accounts use
+/// `https://api.eu.mailgun.net`. Single source of truth for the
+/// `SUPPERS_AI__EMAIL__MAILGUN_BASE_URL` config var default, the admin settings
+/// form, and the runtime fallback in [`resolve_base_url`].
+pub(crate) const DEFAULT_MAILGUN_BASE_URL: &str = "https://api.mailgun.net";
+
+/// Resolve the configured Mailgun base URL to an effective host: fall back to
+/// the US default when unset/blank and trim any trailing slash (so the
+/// `{base}/v3/...` join never produces a `//v3` double slash).
+pub(crate) fn resolve_base_url(configured: &str) -> &str {
+    let trimmed = configured.trim();
+    if trimmed.is_empty() {
+        DEFAULT_MAILGUN_BASE_URL
+    } else {
+        trimmed.trim_end_matches('/')
+    }
+}
+
+/// The email block's own declared config vars. Single source of truth for
+/// both `BlockInfo::config_keys` and the admin Email settings page (rendered
+/// via `ui::settings_form`, not a parallel `EmailSettingField` tuple table —
+/// see `blocks/admin/pages/email.rs`, which selects the Mailgun subset by key
+/// via `config_vars::var_in` rather than re-declaring these).
+pub(crate) fn config_vars() -> Vec<ConfigVar> {
+    vec![
+        ConfigVar::new(
+            providers::PROVIDER_KEY,
+            "Delivery provider: mailgun, ses, webhook, or mock (records messages in memory).",
+            "mailgun",
+        )
+        .name("Email Provider")
+        .optional(),
+        ConfigVar::new(
+            "SUPPERS_AI__EMAIL__MAILGUN_API_KEY",
+            "API key from your Mailgun account.",
+            "",
+        )
+        .name("Mailgun API Key")
+        .input_type(InputType::Password)
+        .optional(),
+        ConfigVar::new(
+            "SUPPERS_AI__EMAIL__MAILGUN_DOMAIN",
+            "Sending domain configured in Mailgun (e.g. mg.example.com).",
+            "",
+        )
+        .name("Mailgun Domain")
+        .optional(),
+        ConfigVar::new(
+            "SUPPERS_AI__EMAIL__MAILGUN_FROM",
+            "Sender address for emails. Leave empty for default (noreply@domain).",
+            "",
+        )
+        .name("From Address")
+        .optional(),
+        ConfigVar::new(
+            "SUPPERS_AI__EMAIL__MAILGUN_REPLY_TO",
+            "Reply-to address for emails. Leave empty to omit.",
+            "",
+        )
+        .name("Reply-To Address")
+        .optional(),
+        ConfigVar::new(
+            "SUPPERS_AI__EMAIL__MAILGUN_BASE_URL",
+            "Mailgun API base URL (US: https://api.mailgun.net, EU: https://api.eu.mailgun.net)",
+            DEFAULT_MAILGUN_BASE_URL,
+        )
+        .name("Mailgun Base URL")
+        // A real URL field (unlike the shared helper's other optional/blank
+        // text fields) — SEC/SSRF: mark it `Url` so `settings_form::save_settings`
+        // runs it through `validate_url_value` on write. The old hand-rolled
+        // admin save loop never validated this field at all.
+        .input_type(InputType::Url)
+        .optional(),
+        ConfigVar::new(
+            providers::SES_REGION_KEY,
+            "AWS region of the SES account (e.g. eu-west-1).",
+            "",
+        )
+        .name("SES Region")
+        .optional(),
+        ConfigVar::new(
+            providers::SES_ACCESS_KEY_ID_KEY,
+            "Access key ID of an IAM user allowed to call ses:SendEmail.",
+            "",
+        )
+        .name("SES Access Key ID")
+        .optional(),
+        ConfigVar::new(
+            providers::SES_SECRET_ACCESS_KEY_KEY,
+            "Secret access key for the SES access key ID.",
+            "",
+        )
+        .name("SES Secret Access Key")
+        .input_type(InputType::Password)
+        .optional(),
+        ConfigVar::new(
+            providers::SES_FROM_KEY,
+            "Verified SES sender address (e.g. Solobase <noreply@example.com>).",
+            "",
+        )
+        .name("SES From Address")
+        .optional(),
+        ConfigVar::new(
+            providers::WEBHOOK_URL_KEY,
+            "URL that receives each message as a JSON POST when the provider is `webhook`.",
+            "",
+        )
+        .name("Email Webhook URL")
+        .input_type(InputType::Url)
+        .optional(),
+        ConfigVar::new(
+            providers::WEBHOOK_SECRET_KEY,
+            "Shared secret for the webhook's X-Webhook-Signature HMAC. Empty = unsigned.",
+            "",
+        )
+        .name("Email Webhook Secret")
+        .input_type(InputType::Password)
+        .optional(),
+        ConfigVar::new(
+            "SUPPERS_AI__EMAIL__RATE_LIMIT_MAX",
+            "Maximum emails per caller per window (0 disables rate limiting)",
+            &DEFAULT_RATE_LIMIT_MAX.to_string(),
+        )
+        .name("Rate Limit (max emails)")
+        .optional(),
+        ConfigVar::new(
+            "SUPPERS_AI__EMAIL__RATE_LIMIT_WINDOW_SECS",
+            "Rate limit window in seconds",
+            &DEFAULT_RATE_LIMIT_WINDOW_SECS.to_string(),
+        )
+        .name("Rate Limit Window (seconds)")
+        .optional(),
+        ConfigVar::new(
+            "SUPPERS_AI__EMAIL__ALLOWED_RECIPIENT_PATTERNS",
+            "Comma-separated allow-list of recipient glob patterns (e.g. \
+             `*@example.com,admin@*`). Empty = allow all (with startup warning).",
+            "",
+        )
+        .name("Allowed Recipient Patterns")
+        .optional(),
+    ]
+}
+
+crate::solobase_feature_block! {
+    /// Transactional email delivery (`suppers-ai/email`).
+    pub struct EmailBlock;
+    fields: { limiter: UserRateLimiter },
+    name: "suppers-ai/email",
+    info: |_this| {
+        BlockInfo::new("suppers-ai/email", "0.0.1", "service@v1", "Transactional email delivery")
+            .instance_mode(InstanceMode::Singleton)
+            .requires(vec![
+                "wafer-run/network".into(),
+                "wafer-run/config".into(),
+                "wafer-run/database".into(),
+            ])
+            .collections(vec![
+                CollectionSchema::new(outbox::OUTBOX_TABLE),
+                CollectionSchema::new(outbox::LOG_TABLE),
+            ])
+            .category(wafer_run::BlockCategory::Service)
+            .description("Email delivery service with Mailgun, AWS SES, webhook and mock providers. Supports raw email sending and templated emails for verification, password reset, welcome messages, and payment notifications. Every send goes through an outbox with retry and backoff for transient provider failures, and each attempt is recorded in a redacted delivery log. Used internally by the auth block for email verification and password reset flows.")
+            .config_keys(config_vars())
+    },
+    handle: |this, ctx, msg, input| {
+        // Deferred work queued by this block (see `blocks::jobs`).
+        if msg.kind == crate::blocks::jobs::RUN_KIND {
+            use crate::blocks::jobs;
+            return match jobs::job_type(&msg) {
+                outbox::DRAIN_JOB => jobs::respond(outbox::run_drain_job(ctx).await),
+                _ => jobs::unknown_type(&msg),
+            };
+        }
+        match msg.kind.as_str() {
+            "email.send" => handle_send(&this.limiter, ctx, input).await,
+            "email.send_template" => handle_send_template(&this.limiter, ctx, input).await,
+            "email.log" => handle_log(ctx, input).await,
+            "email.resend" => handle_resend(ctx, input).await,
+            "email.drain" => handle_drain(ctx).await,
+            _ => err_not_found(&format!("unknown email op: {}", msg.kind)),
+        }
+    },
+    lifecycle: |_this, ctx, event| {
+        crate::migration_helper::lifecycle_init(
+            ctx,
+            &event,
+            "suppers-ai/email",
+            migrations::SQLITE_MIGRATIONS,
+            migrations::POSTGRES_MIGRATIONS,
+        )
+        .await?;
+        if event.event_type == LifecycleType::Init {
+            let patterns =
+                config::get_default(ctx, "SUPPERS_AI__EMAIL__ALLOWED_RECIPIENT_PATTERNS", "").await;
+            if patterns.trim().is_empty() {
+                tracing::warn!(
+                    "SUPPERS_AI__EMAIL__ALLOWED_RECIPIENT_PATTERNS is unset — email block \
+                     will accept any recipient address. Set this to limit who can be emailed."
+                );
+            }
+        }
+        Ok(())
+    },
+}
+
+// ---------------------------------------------------------------------------
+// email.send
+// ---------------------------------------------------------------------------
+
+#[derive(Deserialize)]
+struct SendReq {
+    to: String,
+    subject: String,
+    html: String,
+    #[serde(default)]
+    text: Option<String>,
+}
+
+#[derive(Serialize)]
+struct SendResp {
+    sent: bool,
+}
+
+async fn handle_send(
+    limiter: &UserRateLimiter,
+    ctx: &dyn Context,
+    input: InputStream,
+) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let req: SendReq = match serde_json::from_slice(&raw) {
+        Ok(r) => r,
+        Err(e) => return err_bad_request(&format!("invalid email.send: {e}")),
+    };
+
+    if let Err(e) = validate_recipient(&req.to) {
+        return err_bad_request(&e);
+    }
+    if let Err(e) = check_recipient_allowed(ctx, &req.to).await {
+        return err_bad_request(&e);
+    }
+    if let Err(e) = check_caller_rate_limit(limiter, ctx).await {
+        return e;
+    }
+
+    let email = OutgoingEmail {
+        template: String::new(),
+        to: req.to,
+        subject: req.subject,
+        html: req.html,
+        text: req.text,
+    };
+    let sent = outbox::send(ctx, email).await;
+    ok_json(&SendResp { sent })
+}
+
+// ---------------------------------------------------------------------------
+// email.send_template
+// ---------------------------------------------------------------------------
+
+#[derive(Deserialize)]
+struct TemplateReq {
+    template: String,
+    to: String,
+    #[serde(default)]
+    name: Option<String>,
+    #[serde(default)]
+    token: Option<String>,
+    #[serde(default)]
+    days_remaining: Option<u32>,
+    #[serde(default)]
+    threshold: Option<u32>,
+    /// `new_sign_in`: when, from what and from where.
+    #[serde(default)]
+    signed_in_at: Option<String>,
+    #[serde(default)]
+    device: Option<String>,
+    #[serde(default)]
+    network: Option<String>,
+    #[serde(default)]
+    location: Option<String>,
+    /// `email_changed`: the address the account moved to.
+    #[serde(default)]
+    new_email: Option<String>,
+    /// `file_quarantined`: the flagged object and what the scanner found.
+    /// `share_received` reuses `file` for what was shared.
+    #[serde(default)]
+    file: Option<String>,
+    #[serde(default)]
+    signature: Option<String>,
+    /// `share_received`: who shared, and whether the recipient still needs
+    /// to sign up with this address to open it.
+    #[serde(default)]
+    shared_by: Option<String>,
+    #[serde(default)]
+    signup: Option<bool>,
+    /// Language to send in; defaults to the recipient's profile preference
+    /// (see [`crate::i18n`]).
+    #[serde(default)]
+    locale: Option<String>,
+}
+
+async fn handle_send_template(
+    limiter: &UserRateLimiter,
+    ctx: &dyn Context,
+    input: InputStream,
+) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let req: TemplateReq = match serde_json::from_slice(&raw) {
+        Ok(r) => r,
+        Err(e) => return err_bad_request(&format!("invalid email.send_template: {e}")),
+    };
+
+    if let Err(e) = validate_recipient(&req.to) {
+        return err_bad_request(&e);
+    }
+    if let Err(e) = check_recipient_allowed(ctx, &req.to).await {
+        return err_bad_request(&e);
+    }
+    if let Err(e) = check_caller_rate_limit(limiter, ctx).await {
+        return e;
+    }
+
+    let base_url = config::get_default(
+        ctx,
+        "SOLOBASE_SHARED__FRONTEND_URL",
+        "http://localhost:5173",
+    )
+    .await;
+    let base_url = crate::base_path::public_url(&base_url);
+    let site_url =
+        config::get_default(ctx, "SOLOBASE_SHARED__SITE_URL", "https://solobase.dev").await;
+    let app_name = config::get_default(ctx, "SOLOBASE_SHARED__APP_NAME", "Solobase").await;
+
+    let (subject, html, text, action) = match req.template.as_str() {
+        "verification" => {
+            let token = req.token.as_deref().unwrap_or("");
+            let url = format!("{}/b/auth/api/verify?token={}", base_url, urlencode(token));
+            (
+                format!("Verify your {app_name} email"),
+                email_shell(
+                    "Verify your email",
+                    "#1e293b",
+                    r#"<p style="color:#64748b;line-height:1.6">Click the button below to verify your email address. This link expires in 24 hours.</p>"#,
+                    Some((&url, "Verify Email", "#0ea5e9")),
+                    Some("If you didn't create an account, you can ignore this email."),
+                ),
+                format!("Verify your {app_name} email: {url}"),
+                Some(url),
+            )
+        }
+        "password_reset" => {
+            let token = req.token.as_deref().unwrap_or("");
+            let url = format!(
+                "{}/b/auth/reset-password?token={}",
+                base_url,
+                urlencode(token)
+            );
+            (
+                format!("Reset your {app_name} password"),
+                email_shell(
+                    "Reset your password",
+                    "#1e293b",
+                    r#"<p style="color:#64748b;line-height:1.6">Click the button below to reset your password. This link expires in 1 hour.</p>"#,
+                    Some((&url, "Reset Password", "#0ea5e9")),
+                    Some("If you didn't request a password reset, you can ignore this email."),
+                ),
+                format!("Reset your {app_name} password: {url}"),
+                Some(url),
+            )
+        }
+        "email_change" => {
+            let token = req.token.as_deref().unwrap_or("");
+            let url = format!(
+                "{}/b/auth/api/confirm-email-change?token={}",
+                base_url,
+                urlencode(token)
+            );
+            (
+                format!("Confirm your new {app_name} email"),
+                email_shell(
+                    "Confirm your new email",
+                    "#1e293b",
+                    &format!(
+                        r#"<p style="color:#64748b;line-height:1.6">Click the button below to make this the email address of your {app_name} account. Your current address keeps working until you do. This link expires in 24 hours.</p>"#
+                    ),
+                    Some((&url, "Confirm Email", "#0ea5e9")),
+                    Some("If you didn't ask to change your email, you can ignore this email."),
+                ),
+                format!("Confirm your new {app_name} email: {url}"),
+                Some(url),
+            )
+        }
+        "email_changed" => {
+            let new_email = req.new_email.as_deref().unwrap_or("");
+            let body = format!(
+                r#"<p style="color:#64748b;line-height:1.6">The email address of your {app_name} account was changed to <strong>{}</strong>, and every device was signed out. This address will no longer receive account email.</p>"#,
+                escape_html(new_email)
+            );
+            (
+                format!("Your {app_name} email was changed"),
+                email_shell(
+                    "Email changed",
+                    "#1e293b",
+                    &body,
+                    None,
+                    Some("If you didn't make this change, contact your administrator right away."),
+                ),
+                format!(
+                    "The email address of your {app_name} account was changed to {new_email}. If you didn't make this change, contact your administrator right away."
+                ),
+                None,
+            )
+        }
+        "invitation" => {
+            let token = req.token.as_deref().unwrap_or("");
+            let url = format!("{}/b/auth/signup?invite={}", base_url, urlencode(token));
+            (
+                format!("You're invited to {app_name}"),
+                email_shell(
+                    "You're invited",
+                    "#1e293b",
+                    &format!(
+                        r#"<p style="color:#64748b;line-height:1.6">You've been invited to create a {app_name} account. Click the button below to sign up with this email address. The link works once and expires.</p>"#
+                    ),
+                    Some((&url, "Accept Invitation", "#0ea5e9")),
+                    Some("If you weren't expecting this invitation, you can ignore this email."),
+                ),
+                format!("You're invited to {app_name}. Sign up here: {url}"),
+                Some(url),
+            )
+        }
+        "account_created" => {
+            let token = req.token.as_deref().unwrap_or("");
+            let days = req.days_remaining.unwrap_or(7);
+            let url = format!(
+                "{}/b/auth/reset-password?token={}",
+                base_url,
+                urlencode(token)
+            );
+            let name = req.name.as_deref().unwrap_or("");
+            let greeting = if name.is_empty() {
+                "Your account is ready".to_string()
+            } else {
+                format!("Your account is ready, {}", escape_html(name))
+            };
+            let body = format!(
+                r#"<p style="color:#64748b;line-height:1.6">An administrator created a {app_name} account for this email address. Click the button below to choose your password. This link expires in {days} days; after that, use "Forgot password" on the sign-in page.</p>"#
+            );
+            (
+                format!("Set up your {app_name} account"),
+                email_shell(
+                    &greeting,
+                    "#1e293b",
+                    &body,
+                    Some((&url, "Set Password", "#0ea5e9")),
+                    Some("If you weren't expecting this account, you can ignore this email."),
+                ),
+                format!("An administrator created a {app_name} account for you. Set your password: {url}"),
+                Some(url),
+            )
+        }
+        "payment_failed" => {
+            let days = req.days_remaining.unwrap_or(7);
+            let settings_url = format!("{base_url}/b/admin/#settings");
+            let body = format!(
+                r#"<p style="color:#64748b;line-height:1.6">We were unable to process your subscription payment. Your service will remain active for <strong>{days} more days</strong>. After that, your projects will be suspended.</p>"#
+            );
+            (
+                format!("{app_name}: Payment failed — action required"),
+                email_shell(
+                    "Payment failed",
+                    "#dc2626",
+                    &body,
+                    Some((&settings_url, "Update Payment Method", "#dc2626")),
+                    Some(
+                        "If you've already updated your payment method, you can ignore this email.",
+                    ),
+                ),
+                format!(
+                    "Your {app_name} payment failed. Update your payment method within {days} days."
+                ),
+                Some(settings_url),
+            )
+        }
+        "quota_threshold" => {
+            let threshold = req.threshold.unwrap_or(80);
+            let storage_url = format!("{base_url}/b/cloudstorage/");
+            let body = format!(
+                r#"<p style="color:#64748b;line-height:1.6">You've used <strong>{threshold}%</strong> of your storage quota. Uploads will be rejected once the quota is full — delete files you no longer need or ask an administrator for more space.</p>"#
+            );
+            (
+                format!("{app_name}: You've used {threshold}% of your storage"),
+                email_shell(
+                    "Storage almost full",
+                    "#d97706",
+                    &body,
+                    Some((&storage_url, "Manage Storage", "#0ea5e9")),
+                    None,
+                ),
+                format!(
+                    "You've used {threshold}% of your {app_name} storage quota. Manage your files: {storage_url}"
+                ),
+                Some(storage_url),
+            )
+        }
+        "file_quarantined" => {
+            let file = req.file.as_deref().unwrap_or("");
+            let signature = req.signature.as_deref().unwrap_or("");
+            let storage_url = format!("{base_url}/b/cloudstorage/");
+            let body = format!(
+                r#"<p style="color:#64748b;line-height:1.6">The malware scanner flagged <strong>{}</strong> ({}) after you uploaded it. The file has been quarantined: it is hidden from your storage and can't be downloaded or shared.</p>"#,
+                escape_html(file),
+                escape_html(signature)
+            );
+            (
+                format!("{app_name}: An uploaded file was quarantined"),
+                email_shell(
+                    "File quarantined",
+                    "#dc2626",
+                    &body,
+                    Some((&storage_url, "Manage Storage", "#0ea5e9")),
+                    Some("If you believe this is a mistake, contact your administrator — they can review and release the file."),
+                ),
+                format!(
+                    "The malware scanner flagged {file} ({signature}) after you uploaded it to {app_name}. The file has been quarantined. If you believe this is a mistake, contact your administrator."
+                ),
+                Some(storage_url),
+            )
+        }
+        "share_received" => {
+            let file = req.file.as_deref().unwrap_or("");
+            let shared_by = req.shared_by.as_deref().unwrap_or("Someone");
+            let (url, action, next) = if req.signup.unwrap_or(false) {
+                (
+                    format!("{base_url}/b/auth/signup"),
+                    "Create Account",
+                    format!("Create a {app_name} account with this email address to open it."),
+                )
+            } else {
+                (
+                    format!("{base_url}/b/storage/"),
+                    "Open Storage",
+                    "You can accept or decline it from your incoming shares.".to_string(),
+                )
+            };
+            let body = format!(
+                r#"<p style="color:#64748b;line-height:1.6"><strong>{}</strong> shared <strong>{}</strong> with you. {next}</p>"#,
+                escape_html(shared_by),
+                escape_html(file)
+            );
+            (
+                format!("{app_name}: {shared_by} shared {file} with you"),
+                email_shell(
+                    "Shared with you",
+                    "#1e293b",
+                    &body,
+                    Some((&url, action, "#0ea5e9")),
+                    Some("If you weren't expecting this, you can ignore this email."),
+                ),
+                format!("{shared_by} shared {file} with you on {app_name}. {next} {url}"),
+                Some(url),
+            )
+        }
+        "new_sign_in" => {
+            let token = req.token.as_deref().unwrap_or("");
+            let url = format!("{}/b/auth/not-me?token={}", base_url, urlencode(token));
+            let security_url = format!("{base_url}/b/userportal/security");
+            // Location comes from client-influenced edge headers; escape
+            // every detail before it lands in the HTML body.
+            let details: Vec<(&str, String)> = [
+                ("Device", req.device.as_deref()),
+                ("Network", req.network.as_deref()),
+                ("Location", req.location.as_deref()),
+                ("Time", req.signed_in_at.as_deref()),
+            ]
+            .into_iter()
+            .filter_map(|(k, v)| Some((k, v.filter(|v| !v.is_empty())?.to_string())))
+            .collect();
+            let rows: String = details
+                .iter()
+                .map(|(k, v)| {
+                    format!(
+                        r#"<tr><td style="color:#94a3b8;padding:0.25rem 1rem 0.25rem 0">{k}</td><td style="color:#1e293b">{}</td></tr>"#,
+                        escape_html(v)
+                    )
+                })
+                .collect();
+            let body = format!(
+                r#"<p style="color:#64748b;line-height:1.6">Your {app_name} account was just signed in to from a device it hasn't used before.</p>
+<table style="font-size:0.875rem;border-collapse:collapse">{rows}</table>
+<p style="color:#64748b;line-height:1.6">If this was you, there's nothing to do. If not, sign that device out and reset your password.</p>"#
+            );
+            let text_details: String = details.iter().map(|(k, v)| format!("{k}: {v}\n")).collect();
+            (
+                format!("New sign-in to your {app_name} account"),
+                email_shell(
+                    "New sign-in",
+                    "#1e293b",
+                    &body,
+                    Some((&url, "This wasn't me", "#dc2626")),
+                    Some(&format!(
+                        r#"You can turn these alerts off on your <a href="{security_url}" style="color:#0ea5e9">security page</a>."#
+                    )),
+                ),
+                format!(
+                    "New sign-in to your {app_name} account.\n\n{text_details}\nIf this wasn't you, sign that device out: {url}"
+                ),
+                Some(url),
+            )
+        }
+        "welcome" => {
+            let name = req.name.as_deref().unwrap_or("");
+            let greeting = if name.is_empty() {
+                "Welcome!".to_string()
+            } else {
+                format!("Welcome, {name}!")
+            };
+            let pricing_url = format!("{site_url}/pricing/");
+            let dashboard_url = format!("{base_url}/b/admin/");
+            let docs_url = format!("{site_url}/docs/");
+            let body = format!(
+                r#"<p style="color:#64748b;line-height:1.6">Your {app_name} account is ready. Here's how to get started:</p>
+<ol style="color:#64748b;line-height:1.8">
+<li>Choose a plan on the <a href="{pricing_url}" style="color:#0ea5e9">pricing page</a></li>
+<li>Create your first project from the <a href="{dashboard_url}" style="color:#0ea5e9">dashboard</a></li>
+<li>Read the <a href="{docs_url}" style="color:#0ea5e9">documentation</a></li>
+</ol>"#
+            );
+            (
+                format!("Welcome to {app_name}!"),
+                email_shell(&greeting, "#1e293b", &body, None, None),
+                format!("Welcome to {app_name}! Get started: {dashboard_url}"),
+                Some(dashboard_url),
+            )
+        }
+        other => {
+            return err_bad_request(&format!("unknown email template: {other}"));
+        }
+    };
+
+    // An admin's translation, in the recipient's language, replaces the
+    // built-in English.
+    let (subject, html, text) = match localized(ctx, &req, &app_name, action.as_deref()).await {
+        Some(variant) => variant,
+        None => (subject, html, text),
+    };
+
+    let email = OutgoingEmail {
+        template: req.template,
+        to: req.to,
+        subject,
+        html,
+        text: Some(text),
+    };
+    let sent = outbox::send(ctx, email).await;
+    ok_json(&SendResp { sent })
+}
+
+/// The recipient's translation of `req.template` from the admin overrides:
+/// `email.{template}.subject` and `.body` (plain text, blank lines between
+/// paragraphs), plus the optional `.heading` and `.action` (the button
+/// label). All are rendered with the request's fields as `{placeholders}`
+/// and `{url}` as the button's link. `None` when the locale lacks either
+/// required part, so the built-in English is sent.
+async fn localized(
+    ctx: &dyn Context,
+    req: &TemplateReq,
+    app_name: &str,
+    action: Option<&str>,
+) -> Option<(String, String, String)> {
+    let preference = match &req.locale {
+        Some(locale) => locale.clone(),
+        None => i18n::recipient_preference(ctx, &req.to).await,
+    };
+    let translations = i18n::load(ctx).await;
+    let locale = translations.negotiate(&preference, "");
+    let part =
+        |name: &str| translations.translated(&locale, &format!("email.{}.{name}", req.template));
+    let (subject, body) = (part("subject")?, part("body")?);
+
+    let vars = serde_json::json!({
+        "app_name": app_name,
+        "name": req.name,
+        "days": req.days_remaining.unwrap_or(7),
+        "threshold": req.threshold.unwrap_or(80),
+        "signed_in_at": req.signed_in_at,
+        "device": req.device,
+        "network": req.network,
+        "location": req.location,
+        "new_email": req.new_email,
+        "file": req.file,
+        "signature": req.signature,
+        "shared_by": req.shared_by,
+        "url": action,
+    });
+    let subject = i18n::render(subject, &vars);
+    let body = i18n::render(body, &vars);
+    let heading = part("heading").map_or_else(|| subject.clone(), |h| i18n::render(h, &vars));
+    let paragraphs: String = body
+        .split("\n\n")
+        .map(|p| {
+            format!(
+                r#"<p style="color:#64748b;line-height:1.6">{}</p>"#,
+                escape_html(p)
+            )
+        })
+        .collect();
+    // Without a translated label the button shows the link itself.
+    let label = match (part("action"), action) {
+        (Some(label), _) => escape_html(&i18n::render(label, &vars)),
+        (None, url) => escape_html(url.unwrap_or_default()),
+    };
+    let html = email_shell(
+        &escape_html(&heading),
+        "#1e293b",
+        &paragraphs,
+        action.map(|url| (url, label.as_str(), "#0ea5e9")),
+        None,
+    );
+    let text = match action {
+        Some(url) => format!("{body}\n\n{url}"),
+        None => body,
+    };
+    Some((subject, html, text))
+}
+
+// ---------------------------------------------------------------------------
+// email.log / email.resend / email.drain (admin API)
+// ---------------------------------------------------------------------------
+
+/// Most log rows one `email.log` call returns.
+const MAX_LOG_LIMIT: i64 = 200;
+
+#[derive(Deserialize, Default)]
+struct LogReq {
+    #[serde(default)]
+    limit: Option<i64>,
+}
+
+async fn handle_log(ctx: &dyn Context, input: InputStream) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let req: LogReq = if raw.is_empty() {
+        LogReq::default()
+    } else {
+        match serde_json::from_slice(&raw) {
+            Ok(r) => r,
+            Err(e) => return err_bad_request(&format!("invalid email.log: {e}")),
+        }
+    };
+    let limit = req.limit.unwrap_or(50).clamp(1, MAX_LOG_LIMIT);
+    match outbox::recent_log(ctx, limit).await {
+        Ok(list) => ok_json(&serde_json::json!({ "entries": list.records })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[derive(Deserialize)]
+struct ResendReq {
+    id: String,
+}
+
+async fn handle_resend(ctx: &dyn Context, input: InputStream) -> OutputStream {
+    let raw = input.collect_to_bytes().await;
+    let req: ResendReq = match serde_json::from_slice(&raw) {
+        Ok(r) => r,
+        Err(e) => return err_bad_request(&format!("invalid email.resend: {e}")),
+    };
+    match outbox::resend(ctx, &req.id).await {
+        Ok(sent) => ok_json(&SendResp { sent }),
+        Err(outbox::ResendError::NotFound) => err_not_found("email not found"),
+        Err(outbox::ResendError::NotFailed) => err_conflict("Only failed emails can be re-sent"),
+        Err(outbox::ResendError::Db(e)) => err_internal("Database error", e),
+    }
+}
+
+async fn handle_drain(ctx: &dyn Context) -> OutputStream {
+    let provider = providers::Provider::from_config(ctx).await;
+    let delivered = outbox::drain_due(ctx, provider, MAX_LOG_LIMIT).await;
+    ok_json(&serde_json::json!({ "delivered": delivered }))
+}
+
+/// Shared HTML wrapper for the templated emails: the outer card `div`
+/// (font stack, max-width, padding), a colored `<h2>` heading, the
+/// caller-provided body HTML, an optional CTA button
+/// (`(url, label, background-color)`), and an optional small-print footnote.
+/// Keeps the repeated inline-style markup in one place so each template arm
+/// only supplies its content.
+fn email_shell(
+    heading: &str,
+    heading_color: &str,
+    body_html: &str,
+    cta: Option<(&str, &str, &str)>,
+    footnote: Option<&str>,
+) -> String {
+    let mut out = format!(
+        r#"<div style="font-family:-apple-system,BlinkMacSystemFont,'Segoe UI',sans-serif;max-width:500px;margin:0 auto;padding:2rem">
+<h2 style="color:{heading_color}">{heading}</h2>
+{body_html}"#
+    );
+    if let Some((url, label, background)) = cta {
+        out.push('\n');
+        out.push_str(&format!(
+            r#"<a href="{url}" style="display:inline-block;background:{background};color:white;padding:0.75rem 1.5rem;border-radius:8px;text-decoration:none;font-weight:600;margin:1rem 0">{label}</a>"#
+        ));
+    }
+    if let Some(note) = footnote {
+        out.push('\n');
+        out.push_str(&format!(
+            r#"<p style="color:#64748b;font-size:0.813rem">{note}</p>"#
+        ));
+    }
+    out.push_str("\n</div>");
+    out
+}
+
+/// Escape text for interpolation into template HTML.
+fn escape_html(s: &str) -> String {
+    maud::html! { (s) }.into_string()
+}
+
+// ---------------------------------------------------------------------------
+// Validation & rate limiting (SEC-051)
+// ---------------------------------------------------------------------------
+
+/// Reject blatantly malformed recipient addresses:
+/// - empty
+/// - missing `@`
+/// - multiple `@`
+/// - contains CR/LF (SMTP header injection)
+/// - missing local-part or domain-part
+fn validate_recipient(addr: &str) -> Result<(), String> {
+    let trimmed = addr.trim();
+    if trimmed.is_empty() {
+        return Err("recipient address is empty".into());
+    }
+    if trimmed.contains('\r') || trimmed.contains('\n') {
+        return Err("recipient address contains CR/LF (header injection)".into());
+    }
+    let at_count = trimmed.matches('@').count();
+    if at_count == 0 {
+        return Err("recipient address missing '@'".into());
+    }
+    if at_count > 1 {
+        return Err("recipient address contains multiple '@'".into());
+    }
+    // `at_count == 1` already, but use a let-else for explicitness instead of
+    // `.unwrap()`. If this somehow returned None we'd surface a clear error.
+    let Some((local, domain)) = trimmed.split_once('@') else {
+        return Err("recipient address missing '@'".into());
+    };
+    if local.is_empty() || domain.is_empty() {
+        return Err("recipient address has empty local-part or domain".into());
+    }
+    Ok(())
+}
+
+/// Match `value` against a simple glob pattern. Supports `*` (zero-or-more
+/// of any chars). Match is case-insensitive — email addresses are not
+/// case-sensitive in practice.
+fn glob_match(pattern: &str, value: &str) -> bool {
+    let pattern = pattern.trim().to_lowercase();
+    let value = value.trim().to_lowercase();
+    glob_match_inner(pattern.as_bytes(), value.as_bytes())
+}
+
+fn glob_match_inner(pat: &[u8], val: &[u8]) -> bool {
+    // Simple recursive matcher — patterns are short so depth is bounded.
+    if pat.is_empty() {
+        return val.is_empty();
+    }
+    if pat[0] == b'*' {
+        // Match zero or more chars.
+        if glob_match_inner(&pat[1..], val) {
+            return true;
+        }
+        if val.is_empty() {
+            return false;
+        }
+        return glob_match_inner(pat, &val[1..]);
+    }
+    if val.is_empty() {
+        return false;
+    }
+    if pat[0] != val[0] {
+        return false;
+    }
+    glob_match_inner(&pat[1..], &val[1..])
+}
+
+/// Check the recipient against `SUPPERS_AI__EMAIL__ALLOWED_RECIPIENT_PATTERNS`.
+/// Empty/unset = allow (startup warning already emitted in lifecycle).
+async fn check_recipient_allowed(ctx: &dyn Context, to: &str) -> Result<(), String> {
+    let patterns =
+        config::get_default(ctx, "SUPPERS_AI__EMAIL__ALLOWED_RECIPIENT_PATTERNS", "").await;
+    let patterns = patterns.trim();
+    if patterns.is_empty() {
+        return Ok(());
+    }
+    for pattern in patterns.split(',') {
+        let pattern = pattern.trim();
+        if pattern.is_empty() {
+            continue;
+        }
+        if glob_match(pattern, to) {
+            return Ok(());
+        }
+    }
+    Err(format!(
+        "recipient '{to}' does not match any allowed pattern"
+    ))
+}
+
+/// Per-caller email rate limit. Caller identified by `ctx.caller_id()` —
+/// falls back to `"unknown"` when missing (e.g. direct HTTP entry point).
+async fn check_caller_rate_limit(
+    limiter: &UserRateLimiter,
+    ctx: &dyn Context,
+) -> Result<(), OutputStream> {
+    let max = config::get_default(
+        ctx,
+        "SUPPERS_AI__EMAIL__RATE_LIMIT_MAX",
+        &DEFAULT_RATE_LIMIT_MAX.to_string(),
+    )
+    .await
+    .trim()
+    .parse::<u32>()
+    .unwrap_or(DEFAULT_RATE_LIMIT_MAX);
+    if max == 0 {
+        // Rate limiting disabled.
+        return Ok(());
+    }
+
+    let window_secs = config::get_default(
+        ctx,
+        "SUPPERS_AI__EMAIL__RATE_LIMIT_WINDOW_SECS",
+        &DEFAULT_RATE_LIMIT_WINDOW_SECS.to_string(),
+    )
+    .await
+    .trim()
+    .parse::<u64>()
+    .unwrap_or(DEFAULT_RATE_LIMIT_WINDOW_SECS);
+
+    let caller = ctx.caller_id().unwrap_or("unknown");
+    let key = UserRateLimiter::key(caller, "email_send");
+    let limit = RateLimit {
+        max_requests: max,
+        window: Duration::from_secs(window_secs),
+    };
+    match limiter.check(ctx, &key, limit).await {
+        Ok(_) => Ok(()),
+        Err(retry_after) => Err(super::rate_limit::rate_limited_response(retry_after)),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Tests — SEC-051 rate limit + recipient allow-list + validation.
+// ---------------------------------------------------------------------------
+
+#[cfg(test)]
+mod tests {
+    use std::{
+        collections::HashMap,
+        sync::{Arc, Mutex},
+    };
+
+    use wafer_block::{codec, wire::config as cfg_wire};
+    use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
+
+    use super::*;
+
+    /// Minimal Context routing `wafer-run/config` `config.get` to an in-memory
+    /// map. Mirrors `MockContext::handle_config_call` in products' tests but
+    /// trimmed to the surface the email block needs.
+    struct ConfigCtx {
+        cfg: Mutex<HashMap<String, String>>,
+    }
+
+    impl ConfigCtx {
+        fn new() -> Self {
+            Self {
+                cfg: Mutex::new(HashMap::new()),
+            }
+        }
+        fn set(&self, k: &str, v: &str) {
+            self.cfg
+                .lock()
+                .unwrap()
+                .insert(k.to_string(), v.to_string());
+        }
+    }
+
+    impl Clone for ConfigCtx {
+        fn clone(&self) -> Self {
+            let cfg = self.cfg.lock().unwrap().clone();
+            Self {
+                cfg: Mutex::new(cfg),
+            }
+        }
+    }
+
+    #[async_trait::async_trait]
+    impl Context for ConfigCtx {
+        async fn call_block(
+            &self,
+            block_name: &str,
+            msg: Message,
+            input: InputStream,
+        ) -> OutputStream {
+            if block_name == "wafer-run/config" && msg.kind == "config.get" {
+                let data = input.collect_to_bytes().await;
+                let req: cfg_wire::GetRequest = match codec::decode(&data) {
+                    Ok(r) => r,
+                    Err(e) => {
+                        return OutputStream::error(wafer_run::WaferError::new(
+                            ErrorCode::Internal,
+                            e.message,
+                        ));
+                    }
+                };
+                let value = self
+                    .cfg
+                    .lock()
+                    .unwrap()
+                    .get(&req.key)
+                    .cloned()
+                    .unwrap_or_default();
+                return match codec::encode(&cfg_wire::GetResponse { value }) {
+                    Ok(bytes) => OutputStream::respond(bytes),
+                    Err(e) => OutputStream::error(wafer_run::WaferError::new(
+                        wafer_run::ErrorCode::Internal,
+                        e.message,
+                    )),
+                };
+            }
+            OutputStream::error(wafer_run::WaferError::new(
+                ErrorCode::NotFound,
+                format!("unhandled call: {block_name}/{}", msg.kind),
+            ))
+        }
+        fn is_cancelled(&self) -> bool {
+            false
+        }
+        fn config_get(&self, _key: &str) -> Option<&str> {
+            None
+        }
+        fn clone_arc(&self) -> Arc<dyn Context> {
+            Arc::new(self.clone())
+        }
+    }
+
+    // ---- resolve_base_url ---------------------------------------------------
+
+    #[test]
+    fn resolve_base_url_falls_back_and_trims() {
+        // Unset / blank → US default.
+        assert_eq!(resolve_base_url(""), DEFAULT_MAILGUN_BASE_URL);
+        assert_eq!(resolve_base_url("   "), DEFAULT_MAILGUN_BASE_URL);
+        // EU region passes through unchanged.
+        assert_eq!(
+            resolve_base_url("https://api.eu.mailgun.net"),
+            "https://api.eu.mailgun.net"
+        );
+        // Trailing slash trimmed so the `{base}/v3/...` join stays single-slash.
+        assert_eq!(
+            resolve_base_url("https://api.mailgun.net/"),
+            "https://api.mailgun.net"
+        );
+    }
+
+    // ---- email_shell ----------------------------------------------------------
+
+    #[test]
+    fn email_shell_renders_heading_body_cta_and_footnote() {
+        let html = email_shell(
+            "Test heading",
+            "#1e293b",
+            "<p>body content</p>",
+            Some(("https://x.test/go", "Go Now", "#0ea5e9")),
+            Some("Small print."),
+        );
+        assert!(html.starts_with(r#"<div style="font-family:"#));
+        assert!(html.ends_with("</div>"));
+        assert!(html.contains(r##"<h2 style="color:#1e293b">Test heading</h2>"##));
+        assert!(html.contains("<p>body content</p>"));
+        assert!(html.contains(r#"<a href="https://x.test/go""#));
+        assert!(html.contains("background:#0ea5e9"));
+        assert!(html.contains(">Go Now</a>"));
+        assert!(html.contains("Small print."));
+    }
+
+    #[test]
+    fn email_shell_omits_cta_and_footnote_when_absent() {
+        let html = email_shell("H", "#1e293b", "<p>b</p>", None, None);
+        assert!(!html.contains("<a href="), "no CTA expected: {html}");
+        assert!(
+            !html.contains("font-size:0.813rem"),
+            "no footnote expected: {html}"
+        );
+    }
+
+    // ---- templates ------------------------------------------------------------
+
+    #[tokio::test]
+    async fn new_sign_in_lists_details_escaped_with_not_me_link() {
+        let mut ctx = crate::test_support::TestContext::with_email().await;
+        ctx.set_config(providers::PROVIDER_KEY, "mock");
+        let body = serde_json::json!({
+            "template": "new_sign_in",
+            "to": "alerted@example.com",
+            "token": "tok.en",
+            "device": "Firefox on Linux",
+            "network": "203.0.113.0/24",
+            "location": "<b>Utrecht</b>, NL",
+            "signed_in_at": "2026-10-15T09:30:00Z",
+        });
+        let out = handle_send_template(
+            &UserRateLimiter::new(),
+            &ctx,
+            InputStream::from_bytes(body.to_string().into_bytes()),
+        )
+        .await;
+        assert!(out.collect_buffered().await.is_ok());
+
+        let sent = providers::mock_sent();
+        let mail = sent
+            .iter()
+            .find(|m| m.to == "alerted@example.com")
+            .expect("alert sent");
+        assert!(mail.html.contains("/b/auth/not-me?token=tok.en"));
+        assert!(mail.html.contains("Firefox on Linux"));
+        assert!(mail.html.contains("&lt;b&gt;Utrecht&lt;/b&gt;, NL"));
+        assert!(!mail.html.contains("<b>Utrecht"));
+        let text = mail.text.as_deref().unwrap_or("");
+        assert!(text.contains("Network: 203.0.113.0/24"));
+    }
+
+    #[tokio::test]
+    async fn email_changed_names_the_new_address_escaped() {
+        let mut ctx = crate::test_support::TestContext::with_email().await;
+        ctx.set_config(providers::PROVIDER_KEY, "mock");
+        let body = serde_json::json!({
+            "template": "email_changed",
+            "to": "old-owner@example.com",
+            "new_email": "<i>new</i>@example.com",
+        });
+        let out = handle_send_template(
+            &UserRateLimiter::new(),
+            &ctx,
+            InputStream::from_bytes(body.to_string().into_bytes()),
+        )
+        .await;
+        assert!(out.collect_buffered().await.is_ok());
+
+        let sent = providers::mock_sent();
+        let mail = sent
+            .iter()
+            .find(|m| m.to == "old-owner@example.com")
+            .expect("notice sent");
+        assert!(mail.html.contains("&lt;i&gt;new&lt;/i&gt;@example.com"));
+        assert!(!mail.html.contains("<i>new"));
+    }
+
+    #[tokio::test]
+    async fn file_quarantined_names_the_file_and_signature_escaped() {
+        let mut ctx = crate::test_support::TestContext::with_email().await;
+        ctx.set_config(providers::PROVIDER_KEY, "mock");
+        let body = serde_json::json!({
+            "template": "file_quarantined",
+            "to": "uploader@example.com",
+            "file": "docs/<b>invoice</b>.pdf",
+            "signature": "Eicar-Test-Signature",
+        });
+        let out = handle_send_template(
+            &UserRateLimiter::new(),
+            &ctx,
+            InputStream::from_bytes(body.to_string().into_bytes()),
+        )
+        .await;
+        assert!(out.collect_buffered().await.is_ok());
+
+        let sent = providers::mock_sent();
+        let mail = sent
+            .iter()
+            .find(|m| m.to == "uploader@example.com")
+            .expect("notice sent");
+        assert!(mail.html.contains("docs/&lt;b&gt;invoice&lt;/b&gt;.pdf"));
+        assert!(mail.html.contains("Eicar-Test-Signature"));
+        assert!(!mail.html.contains("<b>invoice"));
+    }
+
+    #[tokio::test]
+    async fn uploaded_translations_replace_the_english_template() {
+        let mut ctx = crate::test_support::TestContext::with_email().await;
+        ctx.set_config(providers::PROVIDER_KEY, "mock");
+        let messages = [
+            (
+                "email.quota_threshold.subject",
+                "{app_name}: {threshold}% belegt",
+            ),
+            (
+                "email.quota_threshold.body",
+                "Sie haben {threshold}% belegt.\n\n<Bitte> aufräumen.",
+            ),
+            ("email.quota_threshold.action", "Speicher verwalten"),
+        ]
+        .into_iter()
+        .map(|(k, v)| (k.to_string(), v.to_string()))
+        .collect();
+        i18n::put_overrides(&ctx, "de", &messages, "admin_1")
+            .await
+            .unwrap();
+
+        for (to, locale) in [("de@example.com", Some("de-AT")), ("en@example.com", None)] {
+            let body = serde_json::json!({
+                "template": "quota_threshold",
+                "to": to,
+                "threshold": 90,
+                "locale": locale,
+            });
+            let out = handle_send_template(
+                &UserRateLimiter::new(),
+                &ctx,
+                InputStream::from_bytes(body.to_string().into_bytes()),
+            )
+            .await;
+            assert!(out.collect_buffered().await.is_ok());
+        }
+
+        let sent = providers::mock_sent();
+        let german = sent
+            .iter()
+            .find(|m| m.to == "de@example.com")
+            .expect("sent");
+        assert_eq!(german.subject, "Solobase: 90% belegt");
+        assert!(german.html.contains("&lt;Bitte&gt; aufräumen."));
+        assert!(german.html.contains("/b/cloudstorage/"));
+        assert!(german.html.contains("Speicher verwalten"));
+        let text = german.text.as_deref().unwrap_or("");
+        assert!(text.ends_with("/b/cloudstorage/"));
+        // No account, so no preference: the built-in English.
+        let english = sent
+            .iter()
+            .find(|m| m.to == "en@example.com")
+            .expect("sent");
+        assert_eq!(english.subject, "Solobase: You've used 90% of your storage");
+    }
+
+    #[tokio::test]
+    async fn share_received_links_signup_for_unregistered_recipients() {
+        let mut ctx = crate::test_support::TestContext::with_email().await;
+        ctx.set_config(providers::PROVIDER_KEY, "mock");
+        for (to, signup) in [("member@example.com", false), ("invitee@example.com", true)] {
+            let body = serde_json::json!({
+                "template": "share_received",
+                "to": to,
+                "shared_by": "alice@example.com",
+                "file": "team/<i>docs</i>/",
+                "signup": signup,
+            });
+            let out = handle_send_template(
+                &UserRateLimiter::new(),
+                &ctx,
+                InputStream::from_bytes(body.to_string().into_bytes()),
+            )
+            .await;
+            assert!(out.collect_buffered().await.is_ok());
+        }
+
+        let sent = providers::mock_sent();
+        let find = |to: &str| sent.iter().find(|m| m.to == to).expect("notice sent");
+        let member = find("member@example.com");
+        assert!(member.html.contains("team/&lt;i&gt;docs&lt;/i&gt;/"));
+        assert!(member.html.contains("/b/storage/"));
+        assert!(!member.html.contains("<i>docs"));
+        assert!(find("invitee@example.com").html.contains("/b/auth/signup"));
+    }
+
+    // ---- validate_recipient -------------------------------------------------
+
+    #[test]
+    fn validate_recipient_accepts_normal_address() {
+        assert!(validate_recipient("alice@example.com").is_ok());
+        assert!(validate_recipient("a.b+tag@sub.example.co.uk").is_ok());
+    }
+
+    #[test]
+    fn validate_recipient_rejects_empty() {
+        assert!(validate_recipient("").is_err());
+        assert!(validate_recipient("   ").is_err());
+    }
+
+    #[test]
+    fn validate_recipient_rejects_missing_at() {
+        assert!(validate_recipient("not-an-email").is_err());
+    }
+
+    #[test]
+    fn validate_recipient_rejects_multiple_at() {
+        assert!(validate_recipient("a@b@c.com").is_err());
+    }
+
+    #[test]
+    fn validate_recipient_rejects_crlf_header_injection() {
+        assert!(validate_recipient("alice@example.com\r\nBcc: evil@x.com").is_err());
+        assert!(validate_recipient("alice@example.com\nBcc: evil@x.com").is_err());
+        assert!(validate_recipient("alice@example.com\rBcc: evil@x.com").is_err());
+    }
+
+    #[test]
+    fn validate_recipient_rejects_empty_parts() {
+        assert!(validate_recipient("@example.com").is_err());
+        assert!(validate_recipient("alice@").is_err());
+    }
+
+    // ---- glob_match ---------------------------------------------------------
+
+    #[test]
+    fn glob_match_exact_address() {
+        assert!(glob_match("alice@example.com", "alice@example.com"));
+        assert!(!glob_match("alice@example.com", "bob@example.com"));
+    }
+
+    #[test]
+    fn glob_match_domain_wildcard() {
+        assert!(glob_match("*@example.com", "alice@example.com"));
+        assert!(glob_match("*@example.com", "bob@example.com"));
+        assert!(!glob_match("*@example.com", "alice@other.com"));
+    }
+
+    #[test]
+    fn glob_match_local_wildcard() {
+        assert!(glob_match("admin@*", "admin@example.com"));
+        assert!(glob_match("admin@*", "admin@other.io"));
+        assert!(!glob_match("admin@*", "user@example.com"));
+    }
+
+    #[test]
+    fn glob_match_case_insensitive() {
+        assert!(glob_match("Alice@Example.COM", "alice@example.com"));
+    }
+
+    // ---- check_recipient_allowed -------------------------------------------
+
+    #[tokio::test]
+    async fn allow_list_empty_allows_all() {
+        let ctx = ConfigCtx::new();
+        // No pattern set → allow.
+        assert!(check_recipient_allowed(&ctx, "anyone@anywhere.io")
+            .await
+            .is_ok());
+    }
+
+    #[tokio::test]
+    async fn allow_list_blocks_unmatched_recipient() {
+        let ctx = ConfigCtx::new();
+        ctx.set(
+            "SUPPERS_AI__EMAIL__ALLOWED_RECIPIENT_PATTERNS",
+            "*@example.com, admin@*",
+        );
+        assert!(check_recipient_allowed(&ctx, "intruder@other.io")
+            .await
+            .is_err());
+    }
+
+    #[tokio::test]
+    async fn allow_list_permits_matched_recipient() {
+        let ctx = ConfigCtx::new();
+        ctx.set(
+            "SUPPERS_AI__EMAIL__ALLOWED_RECIPIENT_PATTERNS",
+            "*@example.com, admin@*",
+        );
+        assert!(check_recipient_allowed(&ctx, "alice@example.com")
+            .await
+            .is_ok());
+        assert!(check_recipient_allowed(&ctx, "admin@anywhere.io")
+            .await
+            .is_ok());
+    }
+
+    // ---- check_caller_rate_limit -------------------------------------------
+
+    #[tokio::test]
+    async fn rate_limit_allows_under_threshold() {
+        let ctx = ConfigCtx::new();
+        ctx.set("SUPPERS_AI__EMAIL__RATE_LIMIT_MAX", "3");
+        ctx.set("SUPPERS_AI__EMAIL__RATE_LIMIT_WINDOW_SECS", "60");
+        let limiter = UserRateLimiter::new();
+        // First 3 are allowed.
+        for _ in 0..3 {
+            assert!(check_caller_rate_limit(&limiter, &ctx).await.is_ok());
+        }
+    }
+
+    #[tokio::test]
+    async fn rate_limit_blocks_over_threshold() {
+        let ctx = ConfigCtx::new();
+        ctx.set("SUPPERS_AI__EMAIL__RATE_LIMIT_MAX", "2");
+        ctx.set("SUPPERS_AI__EMAIL__RATE_LIMIT_WINDOW_SECS", "60");
+        let limiter = UserRateLimiter::new();
+        assert!(check_caller_rate_limit(&limiter, &ctx).await.is_ok());
+        assert!(check_caller_rate_limit(&limiter, &ctx).await.is_ok());
+        // 3rd send exceeds the configured cap and is rate-limited.
+        assert!(check_caller_rate_limit(&limiter, &ctx).await.is_err());
+    }
+
+    #[tokio::test]
+    async fn rate_limit_disabled_when_max_is_zero() {
+        let ctx = ConfigCtx::new();
+        ctx.set("SUPPERS_AI__EMAIL__RATE_LIMIT_MAX", "0");
+        let limiter = UserRateLimiter::new();
+        // Should never block.
+        for _ in 0..50 {
+            assert!(check_caller_rate_limit(&limiter, &ctx).await.is_ok());
+        }
+    }
+}
@@ -0,0 +1,479 @@
+//! Outbound queue and delivery log for the email block.
+//!
+//! [`send`] writes the rendered message to `suppers_ai__email__outbox`,
+//! makes the first delivery attempt, and records it in
+//! `suppers_ai__email__log`. A transient provider failure leaves the row
+//! `pending` with a backed-off `next_attempt_at`; a permanent failure, or
+//! running out of attempts, marks it `failed`. Sent rows have their bodies
+//! blanked so the outbox never keeps reset links around longer than needed.
+//!
+//! Due retries are drained off the request path: the built-in
+//! [`DRAIN_SCHEDULE`] queues a [`DRAIN_JOB`] every minute (see
+//! [`crate::blocks::schedules`]; on Workers the host's Cron Trigger drives
+//! it), and admins can drain on demand. Each row is claimed before it is
+//! sent by pushing its `next_attempt_at` out by [`CLAIM_LEASE_SECS`] with a
+//! compare-and-set, so concurrent drains never send one message twice; a
+//! drain that dies mid-send leaves the row to be retried once the lease
+//! runs out.
+
+use std::collections::HashMap;
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, WaferError};
+
+use super::providers::{self, OutgoingEmail, Provider};
+use crate::{blocks::jobs::JobError, util::RecordExt};
+
+pub const OUTBOX_TABLE: &str = "suppers_ai__email__outbox";
+pub const LOG_TABLE: &str = "suppers_ai__email__log";
+
+pub(crate) const STATUS_PENDING: &str = "pending";
+pub(crate) const STATUS_SENT: &str = "sent";
+pub(crate) const STATUS_FAILED: &str = "failed";
+
+/// Total delivery attempts before a transiently-failing message is given up
+/// on and marked `failed`.
+const MAX_ATTEMPTS: i64 = 5;
+/// Delay before retry `n` (1-based), in seconds.
+const BACKOFF_SECS: [i64; 4] = [30, 120, 600, 3600];
+/// Due retries attempted per [`DRAIN_JOB`].
+const DRAIN_BATCH: i64 = 50;
+/// How long a claimed row is left alone before another drain may retry
+/// it; longer than any provider call takes.
+const CLAIM_LEASE_SECS: i64 = 300;
+
+/// Built-in schedule queueing a [`DRAIN_JOB`].
+pub const DRAIN_SCHEDULE: &str = "email.outbox";
+/// Job type retrying due outbox messages.
+pub const DRAIN_JOB: &str = "email.outbox.drain";
+
+/// Fixed-width UTC timestamp ([`crate::util::format_rfc3339`]), so
+/// `next_attempt_at` strings compare correctly as text.
+fn timestamp(at: chrono::DateTime<chrono::Utc>) -> String {
+    crate::util::format_rfc3339(at)
+}
+
+fn backoff_after(attempt: i64) -> chrono::Duration {
+    let idx = (attempt.max(1) - 1) as usize;
+    chrono::Duration::seconds(BACKOFF_SECS[idx.min(BACKOFF_SECS.len() - 1)])
+}
+
+/// Keep enough of an address to recognise it in the log without storing
+/// it: `jane.doe@example.com` → `j***@example.com`.
+pub(crate) fn redact(addr: &str) -> String {
+    match addr.trim().split_once('@') {
+        Some((local, domain)) => {
+            let first: String = local.chars().take(1).collect();
+            format!("{first}***@{domain}")
+        }
+        None => "***".to_string(),
+    }
+}
+
+/// Queue `email` and attempt delivery once; retries are left to
+/// [`DRAIN_JOB`]. Returns whether `email` was delivered.
+pub(crate) async fn send(ctx: &dyn Context, email: OutgoingEmail) -> bool {
+    let provider = Provider::from_config(ctx).await;
+    let sent = match enqueue(ctx, &email).await {
+        Ok(row) => attempt(ctx, provider, &row.id, &email, 0).await,
+        Err(e) => {
+            // Without a queue row there is nothing to retry from; still try
+            // to deliver rather than drop the message.
+            tracing::warn!(error = %e, "email outbox unavailable; sending without queueing");
+            match providers::deliver(ctx, provider, &email).await {
+                Ok(()) => true,
+                Err(e) => {
+                    tracing::warn!(to = %redact(&email.to), error = %e.message, "email not sent");
+                    false
+                }
+            }
+        }
+    };
+    sent
+}
+
+async fn enqueue(ctx: &dyn Context, email: &OutgoingEmail) -> Result<Record, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "template": email.template,
+        "recipient": email.to,
+        "subject": email.subject,
+        "html": email.html,
+        "text_body": email.text.clone().unwrap_or_default(),
+        "status": STATUS_PENDING,
+        "attempts": 0,
+        "next_attempt_at": timestamp(crate::clock::now()),
+        "created_at": &now,
+        "updated_at": &now,
+    }));
+    db::create(ctx, OUTBOX_TABLE, data).await
+}
+
+fn email_from_row(row: &Record) -> OutgoingEmail {
+    let text = row.str_field("text_body");
+    OutgoingEmail {
+        template: row.str_field("template").to_string(),
+        to: row.str_field("recipient").to_string(),
+        subject: row.str_field("subject").to_string(),
+        html: row.str_field("html").to_string(),
+        text: (!text.is_empty()).then(|| text.to_string()),
+    }
+}
+
+/// One delivery attempt for outbox row `id`, which has already been tried
+/// `prior_attempts` times. Updates the row and appends a log entry.
+async fn attempt(
+    ctx: &dyn Context,
+    provider: Provider,
+    id: &str,
+    email: &OutgoingEmail,
+    prior_attempts: i64,
+) -> bool {
+    let attempt_no = prior_attempts + 1;
+    let result = providers::deliver(ctx, provider, email).await;
+    let now = crate::clock::now();
+
+    let mut patch: HashMap<String, serde_json::Value> = HashMap::new();
+    patch.insert("attempts".into(), serde_json::json!(attempt_no));
+    patch.insert(
+        "updated_at".into(),
+        serde_json::json!(crate::util::now_rfc3339()),
+    );
+    let (status, error) = match &result {
+        Ok(()) => {
+            patch.insert("status".into(), serde_json::json!(STATUS_SENT));
+            patch.insert("sent_at".into(), serde_json::json!(timestamp(now)));
+            patch.insert("last_error".into(), serde_json::json!(""));
+            patch.insert("html".into(), serde_json::json!(""));
+            patch.insert("text_body".into(), serde_json::json!(""));
+            (STATUS_SENT, String::new())
+        }
+        Err(e) if e.transient && attempt_no < MAX_ATTEMPTS => {
+            let next = now + backoff_after(attempt_no);
+            patch.insert("status".into(), serde_json::json!(STATUS_PENDING));
+            patch.insert("next_attempt_at".into(), serde_json::json!(timestamp(next)));
+            patch.insert("last_error".into(), serde_json::json!(e.message));
+            ("retrying", e.message.clone())
+        }
+        Err(e) => {
+            patch.insert("status".into(), serde_json::json!(STATUS_FAILED));
+            patch.insert("last_error".into(), serde_json::json!(e.message));
+            (STATUS_FAILED, e.message.clone())
+        }
+    };
+    if let Err(e) = db::update(ctx, OUTBOX_TABLE, id, patch).await {
+        tracing::warn!(outbox_id = %id, error = %e, "failed to update email outbox row");
+    }
+    if !error.is_empty() {
+        tracing::warn!(
+            to = %redact(&email.to),
+            attempt = attempt_no,
+            error = %error,
+            "email not sent"
+        );
+    }
+    write_log(ctx, id, email, provider, status, &error, attempt_no).await;
+    result.is_ok()
+}
+
+async fn write_log(
+    ctx: &dyn Context,
+    outbox_id: &str,
+    email: &OutgoingEmail,
+    provider: Provider,
+    status: &str,
+    error: &str,
+    attempt: i64,
+) {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "outbox_id": outbox_id,
+        "template": email.template,
+        "recipient": redact(&email.to),
+        "provider": provider.as_str(),
+        "status": status,
+        "error": error,
+        "attempt": attempt,
+        "created_at": &now,
+        "updated_at": &now,
+    }));
+    if let Err(e) = db::create(ctx, LOG_TABLE, data).await {
+        tracing::warn!(outbox_id = %outbox_id, error = %e, "failed to write email log entry");
+    }
+}
+
+/// Retry up to `limit` pending messages whose backoff has elapsed. Returns
+/// how many were delivered.
+pub(crate) async fn drain_due(ctx: &dyn Context, provider: Provider, limit: i64) -> usize {
+    let opts = ListOptions {
+        filters: vec![
+            Filter {
+                field: "status".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::json!(STATUS_PENDING),
+            },
+            Filter {
+                field: "attempts".to_string(),
+                operator: FilterOp::GreaterThan,
+                value: serde_json::json!(0),
+            },
+            Filter {
+                field: "next_attempt_at".to_string(),
+                operator: FilterOp::LessEqual,
+                value: serde_json::json!(timestamp(crate::clock::now())),
+            },
+        ],
+        sort: vec![SortField {
+            field: "next_attempt_at".to_string(),
+            desc: false,
+        }],
+        limit,
+        ..Default::default()
+    };
+    let Ok(due) = db::list(ctx, OUTBOX_TABLE, &opts).await else {
+        return 0;
+    };
+    let mut delivered = 0;
+    for row in &due.records {
+        match claim(ctx, row).await {
+            Ok(true) => {}
+            Ok(false) => continue,
+            Err(e) => {
+                tracing::warn!(outbox_id = %row.id, error = %e, "failed to claim email outbox row");
+                continue;
+            }
+        }
+        let email = email_from_row(row);
+        if attempt(ctx, provider, &row.id, &email, row.i64_field("attempts")).await {
+            delivered += 1;
+        }
+    }
+    delivered
+}
+
+/// Take due `row` for one delivery attempt by pushing its
+/// `next_attempt_at` out by [`CLAIM_LEASE_SECS`]. `false` when another
+/// drain claimed or attempted it since it was listed.
+async fn claim(ctx: &dyn Context, row: &Record) -> Result<bool, WaferError> {
+    let lease = crate::clock::now() + chrono::Duration::seconds(CLAIM_LEASE_SECS);
+    let data = crate::util::json_map(serde_json::json!({
+        "next_attempt_at": timestamp(lease),
+        "updated_at": crate::util::now_rfc3339(),
+    }));
+    let eq = |field: &str, value: serde_json::Value| Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value,
+    };
+    let claimed = db::update_by_filters_count(
+        ctx,
+        OUTBOX_TABLE,
+        vec![
+            eq("id", serde_json::json!(row.id)),
+            eq("status", serde_json::json!(STATUS_PENDING)),
+            eq("attempts", serde_json::json!(row.i64_field("attempts"))),
+            eq(
+                "next_attempt_at",
+                serde_json::json!(row.str_field("next_attempt_at")),
+            ),
+        ],
+        data,
+    )
+    .await?;
+    Ok(claimed == 1)
+}
+
+/// Run a [`DRAIN_JOB`].
+pub(crate) async fn run_drain_job(ctx: &dyn Context) -> Result<(), JobError> {
+    let provider = Provider::from_config(ctx).await;
+    drain_due(ctx, provider, DRAIN_BATCH).await;
+    Ok(())
+}
+
+/// Most recent delivery attempts, newest first.
+pub(crate) async fn recent_log(ctx: &dyn Context, limit: i64) -> Result<RecordList, WaferError> {
+    let opts = ListOptions {
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit,
+        ..Default::default()
+    };
+    db::list(ctx, LOG_TABLE, &opts).await
+}
+
+/// Why [`resend`] did not attempt delivery.
+#[derive(Debug)]
+pub(crate) enum ResendError {
+    NotFound,
+    NotFailed,
+    Db(WaferError),
+}
+
+/// Attempt a `failed` outbox message again through the current provider.
+/// Returns whether this attempt delivered it.
+pub(crate) async fn resend(ctx: &dyn Context, id: &str) -> Result<bool, ResendError> {
+    let row = match db::get(ctx, OUTBOX_TABLE, id).await {
+        Ok(row) => row,
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => return Err(ResendError::NotFound),
+        Err(e) => return Err(ResendError::Db(e)),
+    };
+    if row.str_field("status") != STATUS_FAILED {
+        return Err(ResendError::NotFailed);
+    }
+    // Claim it like a drain would, so two resends send it once.
+    let lease = crate::clock::now() + chrono::Duration::seconds(CLAIM_LEASE_SECS);
+    let data = crate::util::json_map(serde_json::json!({
+        "status": STATUS_PENDING,
+        "next_attempt_at": timestamp(lease),
+        "updated_at": crate::util::now_rfc3339(),
+    }));
+    let filters = vec![
+        Filter {
+            field: "id".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::json!(id),
+        },
+        Filter {
+            field: "status".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::json!(STATUS_FAILED),
+        },
+    ];
+    match db::update_by_filters_count(ctx, OUTBOX_TABLE, filters, data).await {
+        Ok(1) => {}
+        Ok(_) => return Err(ResendError::NotFailed),
+        Err(e) => return Err(ResendError::Db(e)),
+    }
+    let provider = Provider::from_config(ctx).await;
+    let email = email_from_row(&row);
+    Ok(attempt(ctx, provider, id, &email, row.i64_field("attempts")).await)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    fn email(to: &str) -> OutgoingEmail {
+        OutgoingEmail {
+            template: "welcome".into(),
+            to: to.into(),
+            subject: "Welcome".into(),
+            html: "<p>hi</p>".into(),
+            text: Some("hi".into()),
+        }
+    }
+
+    #[test]
+    fn redact_keeps_first_letter_and_domain() {
+        assert_eq!(redact("jane.doe@example.com"), "j***@example.com");
+        assert_eq!(redact("not-an-address"), "***");
+    }
+
+    #[test]
+    fn backoff_grows_and_caps() {
+        assert_eq!(backoff_after(1).num_seconds(), 30);
+        assert_eq!(backoff_after(2).num_seconds(), 120);
+        assert_eq!(backoff_after(9).num_seconds(), 3600);
+    }
+
+    #[tokio::test]
+    async fn mock_send_marks_row_sent_and_logs_redacted() {
+        let mut ctx = TestContext::with_email().await;
+        ctx.set_config(providers::PROVIDER_KEY, "mock");
+        assert!(send(&ctx, email("outbox-sent@example.com")).await);
+
+        let rows = db::list_all(&ctx, OUTBOX_TABLE, vec![]).await.unwrap();
+        let row = rows
+            .iter()
+            .find(|r| r.str_field("recipient") == "outbox-sent@example.com")
+            .unwrap();
+        assert_eq!(row.str_field("status"), STATUS_SENT);
+        assert_eq!(row.str_field("html"), "", "sent bodies are blanked");
+
+        let log = recent_log(&ctx, 10).await.unwrap();
+        assert_eq!(log.records[0].str_field("recipient"), "o***@example.com");
+        assert_eq!(log.records[0].str_field("provider"), "mock");
+        assert!(providers::mock_sent()
+            .iter()
+            .any(|m| m.to == "outbox-sent@example.com"));
+    }
+
+    #[tokio::test]
+    async fn unconfigured_provider_fails_permanently_and_can_be_resent() {
+        // Mailgun with no credentials is a permanent failure.
+        let mut ctx = TestContext::with_email().await;
+        assert!(!send(&ctx, email("outbox-failed@example.com")).await);
+        let rows = db::list_all(&ctx, OUTBOX_TABLE, vec![]).await.unwrap();
+        let row = rows.first().unwrap();
+        assert_eq!(row.str_field("status"), STATUS_FAILED);
+        assert!(row.str_field("last_error").contains("not configured"));
+        assert_eq!(
+            row.str_field("html"),
+            "<p>hi</p>",
+            "failed rows keep the body"
+        );
+
+        ctx.set_config(providers::PROVIDER_KEY, "mock");
+        assert!(resend(&ctx, &row.id).await.unwrap());
+        assert!(matches!(
+            resend(&ctx, &row.id).await,
+            Err(ResendError::NotFailed)
+        ));
+        assert!(matches!(
+            resend(&ctx, "missing").await,
+            Err(ResendError::NotFound)
+        ));
+    }
+
+    #[tokio::test]
+    async fn drain_retries_only_due_rows() {
+        let mut ctx = TestContext::with_email().await;
+        ctx.set_config(providers::PROVIDER_KEY, "mock");
+        let due = enqueue(&ctx, &email("outbox-due@example.com"))
+            .await
+            .unwrap();
+        let later = enqueue(&ctx, &email("outbox-later@example.com"))
+            .await
+            .unwrap();
+        let past = timestamp(crate::clock::now() - chrono::Duration::seconds(5));
+        let future = timestamp(crate::clock::now() + chrono::Duration::seconds(600));
+        for (id, next) in [(&due.id, past), (&later.id, future)] {
+            let patch = crate::util::json_map(serde_json::json!({
+                "attempts": 1,
+                "next_attempt_at": next,
+            }));
+            db::update(&ctx, OUTBOX_TABLE, id, patch).await.unwrap();
+        }
+
+        assert_eq!(drain_due(&ctx, Provider::Mock, 10).await, 1);
+        let later_row = db::get(&ctx, OUTBOX_TABLE, &later.id).await.unwrap();
+        assert_eq!(later_row.str_field("status"), STATUS_PENDING);
+    }
+
+    #[tokio::test]
+    async fn a_claimed_row_is_sent_once() {
+        let mut ctx = TestContext::with_email().await;
+        ctx.set_config(providers::PROVIDER_KEY, "mock");
+        let row = enqueue(&ctx, &email("outbox-claim@example.com"))
+            .await
+            .unwrap();
+        let patch = crate::util::json_map(serde_json::json!({
+            "attempts": 1,
+            "next_attempt_at": timestamp(crate::clock::now() - chrono::Duration::seconds(5)),
+        }));
+        db::update(&ctx, OUTBOX_TABLE, &row.id, patch)
+            .await
+            .unwrap();
+        let listed = db::get(&ctx, OUTBOX_TABLE, &row.id).await.unwrap();
+
+        // Two drains listed the row; only the first claim wins, and the
+        // lease keeps it out of the next listing too.
+        assert!(claim(&ctx, &listed).await.unwrap());
+        assert!(!claim(&ctx, &listed).await.unwrap());
+        assert_eq!(drain_due(&ctx, Provider::Mock, 10).await, 0);
+    }
+}
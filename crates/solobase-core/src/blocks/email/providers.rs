@@ -0,0 +1,399 @@
+//! Delivery providers behind the email outbox.
+//!
+//! `SUPPERS_AI__EMAIL__PROVIDER` picks one of:
+//!
+//! - `mailgun` (default) — the Mailgun HTTP API.
+//! - `ses` — the AWS SES v2 `SendEmail` API, SigV4-signed.
+//! - `webhook` — POST the rendered message as JSON to a configured URL, for
+//!   deployments that hand mail to an external sender.
+//! - `mock` — record the message in process memory; [`mock_sent`] reads it
+//!   back. For tests and local development.
+//!
+//! Every provider reads its settings through `config::get_default`, so the
+//! admin variables table overrides the env/config defaults as usual.
+//! [`deliver`] classifies failures as transient (network errors, 429, 5xx —
+//! worth retrying) or permanent (unconfigured, rejected by the provider).
+
+use std::{collections::HashMap, sync::Mutex};
+
+use serde::Serialize;
+use wafer_block_crypto::primitives;
+use wafer_core::clients::{config, network as net};
+use wafer_run::context::Context;
+
+use crate::util::{hex_encode, sha256_hex, urlencode};
+
+pub(crate) const PROVIDER_KEY: &str = "SUPPERS_AI__EMAIL__PROVIDER";
+pub(crate) const SES_REGION_KEY: &str = "SUPPERS_AI__EMAIL__SES_REGION";
+pub(crate) const SES_ACCESS_KEY_ID_KEY: &str = "SUPPERS_AI__EMAIL__SES_ACCESS_KEY_ID";
+pub(crate) const SES_SECRET_ACCESS_KEY_KEY: &str = "SUPPERS_AI__EMAIL__SES_SECRET_ACCESS_KEY";
+pub(crate) const SES_FROM_KEY: &str = "SUPPERS_AI__EMAIL__SES_FROM";
+pub(crate) const WEBHOOK_URL_KEY: &str = "SUPPERS_AI__EMAIL__WEBHOOK_URL";
+pub(crate) const WEBHOOK_SECRET_KEY: &str = "SUPPERS_AI__EMAIL__WEBHOOK_SECRET";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum Provider {
+    Mailgun,
+    Ses,
+    Webhook,
+    Mock,
+}
+
+impl Provider {
+    /// Parse the configured provider name. Unknown values fall back to
+    /// Mailgun (the pre-provider behavior) with a warning.
+    pub(crate) fn parse(value: &str) -> Self {
+        match value.trim().to_ascii_lowercase().as_str() {
+            "" | "mailgun" => Self::Mailgun,
+            "ses" => Self::Ses,
+            "webhook" => Self::Webhook,
+            "mock" => Self::Mock,
+            other => {
+                tracing::warn!(provider = %other, "unknown email provider; using mailgun");
+                Self::Mailgun
+            }
+        }
+    }
+
+    pub(crate) fn as_str(self) -> &'static str {
+        match self {
+            Self::Mailgun => "mailgun",
+            Self::Ses => "ses",
+            Self::Webhook => "webhook",
+            Self::Mock => "mock",
+        }
+    }
+
+    pub(crate) async fn from_config(ctx: &dyn Context) -> Self {
+        Self::parse(&config::get_default(ctx, PROVIDER_KEY, "mailgun").await)
+    }
+}
+
+/// A rendered message ready for a provider.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct OutgoingEmail {
+    /// Template name (`verification`, `password_reset`, …) or `""` for raw
+    /// `email.send` messages.
+    pub template: String,
+    pub to: String,
+    pub subject: String,
+    pub html: String,
+    pub text: Option<String>,
+}
+
+/// Why a delivery failed, and whether retrying may help.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct DeliveryError {
+    pub transient: bool,
+    pub message: String,
+}
+
+impl DeliveryError {
+    fn permanent(message: impl Into<String>) -> Self {
+        Self {
+            transient: false,
+            message: message.into(),
+        }
+    }
+
+    fn transient(message: impl Into<String>) -> Self {
+        Self {
+            transient: true,
+            message: message.into(),
+        }
+    }
+
+    /// Classify a provider's HTTP status: 429 and 5xx are worth retrying,
+    /// any other non-2xx is a rejection.
+    fn from_status(provider: Provider, status: u16) -> Self {
+        let message = format!("{} returned HTTP {status}", provider.as_str());
+        if status == 429 || status >= 500 {
+            Self::transient(message)
+        } else {
+            Self::permanent(message)
+        }
+    }
+}
+
+/// Send `email` through `provider`.
+pub(crate) async fn deliver(
+    ctx: &dyn Context,
+    provider: Provider,
+    email: &OutgoingEmail,
+) -> Result<(), DeliveryError> {
+    match provider {
+        Provider::Mailgun => send_mailgun(ctx, email).await,
+        Provider::Ses => send_ses(ctx, email).await,
+        Provider::Webhook => send_webhook(ctx, email).await,
+        Provider::Mock => {
+            mock_outbox().push(email.clone());
+            Ok(())
+        }
+    }
+}
+
+async fn post(
+    ctx: &dyn Context,
+    provider: Provider,
+    url: &str,
+    headers: &HashMap<String, String>,
+    body: &[u8],
+) -> Result<(), DeliveryError> {
+    match net::do_request(ctx, "POST", url, headers, Some(body)).await {
+        Ok(resp) if (200..300).contains(&resp.status_code) => Ok(()),
+        Ok(resp) => Err(DeliveryError::from_status(provider, resp.status_code)),
+        Err(e) => Err(DeliveryError::transient(format!(
+            "{} request failed: {e}",
+            provider.as_str()
+        ))),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Mailgun
+// ---------------------------------------------------------------------------
+
+async fn send_mailgun(ctx: &dyn Context, email: &OutgoingEmail) -> Result<(), DeliveryError> {
+    let api_key = config::get_default(ctx, "SUPPERS_AI__EMAIL__MAILGUN_API_KEY", "").await;
+    let domain = config::get_default(ctx, "SUPPERS_AI__EMAIL__MAILGUN_DOMAIN", "").await;
+    if api_key.is_empty() || domain.is_empty() {
+        return Err(DeliveryError::permanent(
+            "Mailgun is not configured (SUPPERS_AI__EMAIL__MAILGUN_API_KEY and/or \
+             SUPPERS_AI__EMAIL__MAILGUN_DOMAIN unset)",
+        ));
+    }
+    let from = {
+        let f = config::get_default(ctx, "SUPPERS_AI__EMAIL__MAILGUN_FROM", "").await;
+        if f.is_empty() {
+            format!("Solobase <noreply@{domain}>")
+        } else {
+            f
+        }
+    };
+
+    // Build form-encoded body
+    let mut parts = vec![
+        format!("from={}", urlencode(&from)),
+        format!("to={}", urlencode(&email.to)),
+        format!("subject={}", urlencode(&email.subject)),
+        format!("html={}", urlencode(&email.html)),
+    ];
+    let reply_to = config::get_default(ctx, "SUPPERS_AI__EMAIL__MAILGUN_REPLY_TO", "").await;
+    if !reply_to.is_empty() {
+        parts.push(format!("h:Reply-To={}", urlencode(&reply_to)));
+    }
+    if let Some(text) = &email.text {
+        parts.push(format!("text={}", urlencode(text)));
+    }
+    let body = parts.join("&");
+
+    // Base64-encode "api:{api_key}" for HTTP Basic auth.
+    use base64ct::Encoding;
+    let credentials = base64ct::Base64::encode_string(format!("api:{api_key}").as_bytes());
+
+    let configured = config::get_default(ctx, "SUPPERS_AI__EMAIL__MAILGUN_BASE_URL", "").await;
+    let base = super::resolve_base_url(&configured);
+    let url = format!("{base}/v3/{domain}/messages");
+    let mut headers = HashMap::new();
+    headers.insert("Authorization".to_string(), format!("Basic {credentials}"));
+    headers.insert(
+        "Content-Type".to_string(),
+        "application/x-www-form-urlencoded".to_string(),
+    );
+    post(ctx, Provider::Mailgun, &url, &headers, body.as_bytes()).await
+}
+
+// ---------------------------------------------------------------------------
+// AWS SES (v2 API)
+// ---------------------------------------------------------------------------
+
+const SES_PATH: &str = "/v2/email/outbound-emails";
+
+async fn send_ses(ctx: &dyn Context, email: &OutgoingEmail) -> Result<(), DeliveryError> {
+    let region = config::get_default(ctx, SES_REGION_KEY, "").await;
+    let access_key = config::get_default(ctx, SES_ACCESS_KEY_ID_KEY, "").await;
+    let secret_key = config::get_default(ctx, SES_SECRET_ACCESS_KEY_KEY, "").await;
+    let from = config::get_default(ctx, SES_FROM_KEY, "").await;
+    if region.is_empty() || access_key.is_empty() || secret_key.is_empty() || from.is_empty() {
+        return Err(DeliveryError::permanent(
+            "SES is not configured (region, access key, secret key and from address are required)",
+        ));
+    }
+
+    let mut body_content = serde_json::json!({
+        "Html": {"Data": email.html, "Charset": "UTF-8"},
+    });
+    if let Some(text) = &email.text {
+        body_content["Text"] = serde_json::json!({"Data": text, "Charset": "UTF-8"});
+    }
+    let payload = serde_json::json!({
+        "FromEmailAddress": from,
+        "Destination": {"ToAddresses": [email.to]},
+        "Content": {"Simple": {
+            "Subject": {"Data": email.subject, "Charset": "UTF-8"},
+            "Body": body_content,
+        }},
+    });
+    let body = serde_json::to_vec(&payload).map_err(|e| DeliveryError::permanent(e.to_string()))?;
+
+    let host = format!("email.{region}.amazonaws.com");
+    let amz_date = chrono::Utc::now().format("%Y%m%dT%H%M%SZ").to_string();
+    let authorization =
+        ses_authorization(&region, &access_key, &secret_key, &host, &amz_date, &body);
+
+    let mut headers = HashMap::new();
+    headers.insert("Content-Type".to_string(), "application/json".to_string());
+    headers.insert("X-Amz-Date".to_string(), amz_date);
+    headers.insert("Authorization".to_string(), authorization);
+    let url = format!("https://{host}{SES_PATH}");
+    post(ctx, Provider::Ses, &url, &headers, &body).await
+}
+
+/// AWS Signature Version 4 `Authorization` header for a JSON POST to the
+/// SES v2 send endpoint. Signs `content-type`, `host` and `x-amz-date`.
+fn ses_authorization(
+    region: &str,
+    access_key: &str,
+    secret_key: &str,
+    host: &str,
+    amz_date: &str,
+    body: &[u8],
+) -> String {
+    let date = &amz_date[..8];
+    let signed_headers = "content-type;host;x-amz-date";
+    let canonical_headers =
+        format!("content-type:application/json\nhost:{host}\nx-amz-date:{amz_date}\n");
+    let canonical_request = format!(
+        "POST\n{SES_PATH}\n\n{canonical_headers}\n{signed_headers}\n{}",
+        sha256_hex(body)
+    );
+    let scope = format!("{date}/{region}/ses/aws4_request");
+    let string_to_sign = format!(
+        "AWS4-HMAC-SHA256\n{amz_date}\n{scope}\n{}",
+        sha256_hex(canonical_request.as_bytes())
+    );
+    let k_date = primitives::hmac_sha256(format!("AWS4{secret_key}").as_bytes(), date.as_bytes());
+    let k_region = primitives::hmac_sha256(&k_date, region.as_bytes());
+    let k_service = primitives::hmac_sha256(&k_region, b"ses");
+    let k_signing = primitives::hmac_sha256(&k_service, b"aws4_request");
+    let signature = hex_encode(&primitives::hmac_sha256(
+        &k_signing,
+        string_to_sign.as_bytes(),
+    ));
+    format!(
+        "AWS4-HMAC-SHA256 Credential={access_key}/{scope}, \
+         SignedHeaders={signed_headers}, Signature={signature}"
+    )
+}
+
+// ---------------------------------------------------------------------------
+// Generic webhook
+// ---------------------------------------------------------------------------
+
+async fn send_webhook(ctx: &dyn Context, email: &OutgoingEmail) -> Result<(), DeliveryError> {
+    let url = config::get_default(ctx, WEBHOOK_URL_KEY, "").await;
+    if url.is_empty() {
+        return Err(DeliveryError::permanent(
+            "email webhook is not configured (SUPPERS_AI__EMAIL__WEBHOOK_URL unset)",
+        ));
+    }
+    let payload = serde_json::to_vec(email).map_err(|e| DeliveryError::permanent(e.to_string()))?;
+
+    let mut headers = HashMap::new();
+    headers.insert("Content-Type".to_string(), "application/json".to_string());
+    // Same signature scheme as the products webhook, so receivers can share
+    // verification code.
+    let secret = config::get_default(ctx, WEBHOOK_SECRET_KEY, "").await;
+    if !secret.is_empty() {
+        let sig = primitives::hmac_sha256(secret.as_bytes(), &payload);
+        headers.insert(
+            "X-Webhook-Signature".to_string(),
+            format!("sha256={}", hex_encode(&sig)),
+        );
+    }
+    post(ctx, Provider::Webhook, &url, &headers, &payload).await
+}
+
+// ---------------------------------------------------------------------------
+// Mock
+// ---------------------------------------------------------------------------
+
+static MOCK_OUTBOX: Mutex<Vec<OutgoingEmail>> = Mutex::new(Vec::new());
+
+fn mock_outbox() -> std::sync::MutexGuard<'static, Vec<OutgoingEmail>> {
+    MOCK_OUTBOX.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Messages the `mock` provider has accepted in this process, oldest first.
+/// The buffer is process-wide, so tests should filter by recipient.
+pub fn mock_sent() -> Vec<OutgoingEmail> {
+    mock_outbox().clone()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn provider_parse_defaults_to_mailgun() {
+        assert_eq!(Provider::parse(""), Provider::Mailgun);
+        assert_eq!(Provider::parse("SES"), Provider::Ses);
+        assert_eq!(Provider::parse("webhook"), Provider::Webhook);
+        assert_eq!(Provider::parse("mock"), Provider::Mock);
+        assert_eq!(Provider::parse("carrier-pigeon"), Provider::Mailgun);
+    }
+
+    #[test]
+    fn status_classification() {
+        assert!(DeliveryError::from_status(Provider::Ses, 503).transient);
+        assert!(DeliveryError::from_status(Provider::Ses, 429).transient);
+        assert!(!DeliveryError::from_status(Provider::Ses, 400).transient);
+    }
+
+    /// Worked example from the AWS SigV4 documentation's signing-key
+    /// derivation (`wJalr…EXAMPLEKEY`, 20120215, us-east-1, iam).
+    #[test]
+    fn sigv4_signing_key_matches_aws_example() {
+        let k_date =
+            primitives::hmac_sha256(b"AWS4wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", b"20120215");
+        let k_region = primitives::hmac_sha256(&k_date, b"us-east-1");
+        let k_service = primitives::hmac_sha256(&k_region, b"iam");
+        let k_signing = primitives::hmac_sha256(&k_service, b"aws4_request");
+        assert_eq!(
+            hex_encode(&k_signing),
+            "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+        );
+    }
+
+    #[test]
+    fn ses_authorization_has_scope_and_signed_headers() {
+        let auth = ses_authorization(
+            "eu-west-1",
+            "AKIDEXAMPLE",
+            "secret",
+            "email.eu-west-1.amazonaws.com",
+            "20260101T000000Z",
+            b"{}",
+        );
+        assert!(auth.starts_with(
+            "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20260101/eu-west-1/ses/aws4_request, \
+             SignedHeaders=content-type;host;x-amz-date, Signature="
+        ));
+        let sig = auth.rsplit("Signature=").next().unwrap();
+        assert_eq!(sig.len(), 64);
+    }
+
+    #[tokio::test]
+    async fn mock_provider_records_messages() {
+        let ctx = crate::test_support::TestContext::new().await;
+        let email = OutgoingEmail {
+            template: "welcome".into(),
+            to: "mock-provider@example.com".into(),
+            subject: "Hi".into(),
+            html: "<p>Hi</p>".into(),
+            text: None,
+        };
+        deliver(&ctx, Provider::Mock, &email).await.unwrap();
+        assert!(mock_sent().contains(&email));
+    }
+}
@@ -1,3 +1,69 @@
+//! Standardized error codes for solobase API responses.
+//!
+//! Every JSON error body has the shape the HTTP adapters emit for an error
+//! stream:
+//!
+//! ```json
+//! {"error": "<coarse wafer code>", "message": "<human text>", "code": "<ErrorCode>"}
+//! ```
+//!
+//! `code` is the stable identifier from [`ErrorCode::as_str`] — clients (and
+//! frontend translations) key off it, never off `message`. Validation
+//! failures from [`validation_error`] add a `details` object mapping each
+//! offending field to its reason. Internal failures never carry the
+//! underlying cause: [`db_error_response`] and `http::err_internal` log it
+//! server-side and return a generic message.
+//!
+//! | code | status | meaning |
+//! |---|---|---|
+//! | `invalid_credentials` | 401 | wrong email/password |
+//! | `email_already_exists` | 409 | signup with a taken email |
+//! | `account_disabled` | 403 | user is disabled |
+//! | `not_authenticated` | 401 | no session / token |
+//! | `invalid_token` / `token_expired` | 401 | bad or stale token |
+//! | `email_not_verified` | 403 | login before verification |
+//! | `signup_closed` | 403 | registration is closed (or the signup mode is unknown) |
+//! | `invitation_required` | 403 | invite-only signup without an invitation token |
+//! | `invitation_invalid` | 403 | invitation unknown, expired, used, revoked, or for another email |
+//! | `password_change_required` | 403 | an admin requires a new password before anything else |
+//! | `password_too_short` / `password_too_long` | 400 | password policy |
+//! | `invalid_email` / `invalid_input` | 400 | malformed request value |
+//! | `validation_failed` | 400 | per-field problems in `details` |
+//! | `forbidden` / `admin_required` | 403 | caller lacks access |
+//! | `permission_denied` | 403 | caller lacks the IAM permission named in `details.permission` |
+//! | `not_found` / `conflict` | 404 / 409 | generic resource outcome |
+//! | `object_not_found` | 404 | storage object does not exist |
+//! | `bucket_already_exists` | 409 | bucket name is taken |
+//! | `bucket_not_empty` | 409 | bucket still holds objects (admins may `?force=true`) |
+//! | `bucket_name_reserved` | 400 | bucket name is kept for the server's own use or is the app's id |
+//! | `object_exists` | 409 | a move target or upload-widget file name is already taken |
+//! | `share_revoked` | 410 | share link was revoked by an admin |
+//! | `quota_exceeded` / `file_too_large` | 413 | storage quota limits |
+//! | `payload_too_large` | 413 | request body over the endpoint's size cap |
+//! | `unsupported_media_type` | 415 | JSON endpoint called with a non-JSON `Content-Type` |
+//! | `preview_unsupported` | 415 | object type has no inline preview |
+//! | `scan_pending` | 409 | object is still waiting for its malware scan |
+//! | `malware_detected` | 422 | upload rejected by the malware scanner |
+//! | `object_locked` | 423 | object or folder is under a lock until `details.locked_until` |
+//! | `upload_limit_reached` | 409 | an upload-widget session has stored its maximum number of files |
+//! | `origin_not_allowed` | 403 | upload-widget request from an origin the session doesn't allow |
+//! | `checksum_mismatch` | 400 | upload body doesn't match its declared or required SHA-256 |
+//! | `rate_limit_exceeded` | 429 | too many requests |
+//! | `usage_quota_exceeded` | 429 | an admin-defined usage quota is spent |
+//! | `too_many_transfers` | 429 | the caller already has their maximum of uploads or downloads running |
+//! | `payment_not_configured`, `invalid_purchase_status`, `refund_failed` | 500 / 400 | payments |
+//! | `insufficient_stock` | 409 | requested quantity exceeds available stock |
+//! | `coupon_invalid` | 404 | unknown or deactivated coupon code |
+//! | `coupon_expired` | 410 | coupon is past its `valid_to` |
+//! | `coupon_exhausted` | 409 | coupon (or the caller's share of it) is used up |
+//! | `coupon_not_applicable` | 400 | not yet valid, or nothing in the cart qualifies |
+//! | `database_error` / `internal_error` / `configuration_error` | 500 | server-side failure |
+//! | `schema_not_initialized` | 503 | the block's migrations failed at startup |
+//! | `maintenance_mode` | 503 | writes are paused for non-admins |
+//! | `request_timeout` | 504 | the handler ran past the request timeout |
+
+use wafer_run::{OutputStream, WaferError};
+
 /// Standardized error codes for solobase API responses.
 /// Used in place of string-based error matching for reliable error handling.
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
@@ -10,18 +76,30 @@ pub enum ErrorCode {
     InvalidToken,
     TokenExpired,
     EmailNotVerified,
+    SignupClosed,
+    InvitationRequired,
+    InvitationInvalid,
+    PasswordChangeRequired,
     PasswordTooShort,
     PasswordTooLong,
     InvalidEmail,
     InvalidInput,
+    ValidationFailed,
 
     // Authorization
     Forbidden,
     AdminRequired,
+    PermissionDenied,
 
     // Resource errors
     NotFound,
     Conflict,
+    ObjectNotFound,
+    BucketAlreadyExists,
+    BucketNotEmpty,
+    BucketNameReserved,
+    ObjectExists,
+    ShareRevoked,
 
     // Database
     DatabaseError,
@@ -30,15 +108,34 @@ pub enum ErrorCode {
     PaymentNotConfigured,
     InvalidPurchaseStatus,
     RefundFailed,
+    InsufficientStock,
+    CouponInvalid,
+    CouponExpired,
+    CouponExhausted,
+    CouponNotApplicable,
 
     // Storage
     QuotaExceeded,
     FileTooLarge,
+    PreviewUnsupported,
+    ScanPending,
+    MalwareDetected,
+    ObjectLocked,
+    UploadLimitReached,
+    OriginNotAllowed,
+    ChecksumMismatch,
 
     // System
     InternalError,
     ConfigurationError,
+    PayloadTooLarge,
+    UnsupportedMediaType,
     RateLimitExceeded,
+    UsageQuotaExceeded,
+    TooManyTransfers,
+    SchemaNotInitialized,
+    MaintenanceMode,
+    RequestTimeout,
 }
 
 impl ErrorCode {
@@ -54,23 +151,54 @@ impl ErrorCode {
             Self::InvalidToken => "invalid_token",
             Self::TokenExpired => "token_expired",
             Self::EmailNotVerified => "email_not_verified",
+            Self::SignupClosed => "signup_closed",
+            Self::InvitationRequired => "invitation_required",
+            Self::InvitationInvalid => "invitation_invalid",
+            Self::PasswordChangeRequired => "password_change_required",
             Self::PasswordTooShort => "password_too_short",
             Self::PasswordTooLong => "password_too_long",
             Self::InvalidEmail => "invalid_email",
             Self::InvalidInput => "invalid_input",
+            Self::ValidationFailed => "validation_failed",
             Self::Forbidden => "forbidden",
             Self::AdminRequired => "admin_required",
+            Self::PermissionDenied => "permission_denied",
             Self::NotFound => "not_found",
             Self::Conflict => "conflict",
+            Self::ObjectNotFound => "object_not_found",
+            Self::BucketAlreadyExists => "bucket_already_exists",
+            Self::BucketNotEmpty => "bucket_not_empty",
+            Self::BucketNameReserved => "bucket_name_reserved",
+            Self::ObjectExists => "object_exists",
+            Self::ShareRevoked => "share_revoked",
             Self::DatabaseError => "database_error",
             Self::PaymentNotConfigured => "payment_not_configured",
             Self::InvalidPurchaseStatus => "invalid_purchase_status",
             Self::RefundFailed => "refund_failed",
+            Self::InsufficientStock => "insufficient_stock",
+            Self::CouponInvalid => "coupon_invalid",
+            Self::CouponExpired => "coupon_expired",
+            Self::CouponExhausted => "coupon_exhausted",
+            Self::CouponNotApplicable => "coupon_not_applicable",
             Self::QuotaExceeded => "quota_exceeded",
             Self::FileTooLarge => "file_too_large",
+            Self::PreviewUnsupported => "preview_unsupported",
+            Self::ScanPending => "scan_pending",
+            Self::MalwareDetected => "malware_detected",
+            Self::ObjectLocked => "object_locked",
+            Self::UploadLimitReached => "upload_limit_reached",
+            Self::OriginNotAllowed => "origin_not_allowed",
+            Self::ChecksumMismatch => "checksum_mismatch",
             Self::InternalError => "internal_error",
             Self::ConfigurationError => "configuration_error",
+            Self::PayloadTooLarge => "payload_too_large",
+            Self::UnsupportedMediaType => "unsupported_media_type",
             Self::RateLimitExceeded => "rate_limit_exceeded",
+            Self::UsageQuotaExceeded => "usage_quota_exceeded",
+            Self::TooManyTransfers => "too_many_transfers",
+            Self::SchemaNotInitialized => "schema_not_initialized",
+            Self::MaintenanceMode => "maintenance_mode",
+            Self::RequestTimeout => "request_timeout",
         }
     }
 
@@ -86,21 +214,46 @@ impl ErrorCode {
 
             Self::Forbidden
             | Self::AdminRequired
+            | Self::PermissionDenied
             | Self::AccountDisabled
-            | Self::EmailNotVerified => 403,
-
-            Self::NotFound => 404,
-
-            Self::EmailAlreadyExists | Self::Conflict => 409,
+            | Self::EmailNotVerified
+            | Self::SignupClosed
+            | Self::InvitationRequired
+            | Self::InvitationInvalid
+            | Self::PasswordChangeRequired
+            | Self::OriginNotAllowed => 403,
+
+            Self::NotFound | Self::ObjectNotFound | Self::CouponInvalid => 404,
+
+            Self::EmailAlreadyExists
+            | Self::Conflict
+            | Self::BucketAlreadyExists
+            | Self::BucketNotEmpty
+            | Self::ObjectExists
+            | Self::ScanPending
+            | Self::InsufficientStock
+            | Self::CouponExhausted
+            | Self::UploadLimitReached => 409,
+
+            Self::ShareRevoked | Self::CouponExpired => 410,
 
             Self::PasswordTooShort
             | Self::PasswordTooLong
             | Self::InvalidEmail
             | Self::InvalidInput
-            | Self::InvalidPurchaseStatus => 400,
-
-            Self::QuotaExceeded | Self::FileTooLarge => 413,
-            Self::RateLimitExceeded => 429,
+            | Self::ValidationFailed
+            | Self::BucketNameReserved
+            | Self::InvalidPurchaseStatus
+            | Self::CouponNotApplicable
+            | Self::ChecksumMismatch => 400,
+
+            Self::QuotaExceeded | Self::FileTooLarge | Self::PayloadTooLarge => 413,
+            Self::PreviewUnsupported | Self::UnsupportedMediaType => 415,
+            Self::MalwareDetected => 422,
+            Self::ObjectLocked => 423,
+            Self::RateLimitExceeded | Self::UsageQuotaExceeded | Self::TooManyTransfers => 429,
+            Self::SchemaNotInitialized | Self::MaintenanceMode => 503,
+            Self::RequestTimeout => 504,
 
             Self::PaymentNotConfigured
             | Self::ConfigurationError
@@ -134,6 +287,77 @@ pub fn error_response(code: ErrorCode, message: &str) -> wafer_run::OutputStream
     )
 }
 
+/// Like [`error_response`], but rendered here as a JSON response carrying
+/// the exact [`ErrorCode::status_code`] and optional `details`. Use it when
+/// the coarse wafer code's status would be wrong (413, 410) or when the
+/// client needs structured detail; the body keeps the adapters' envelope.
+pub fn error_json(
+    code: ErrorCode,
+    message: &str,
+    details: Option<serde_json::Value>,
+) -> OutputStream {
+    crate::http::ResponseBuilder::new()
+        .status(code.status_code())
+        .json(&error_body(code, message, details))
+}
+
+/// The JSON body [`error_json`] answers with, for handlers that must set
+/// their own headers on the error response.
+pub fn error_body(
+    code: ErrorCode,
+    message: &str,
+    details: Option<serde_json::Value>,
+) -> serde_json::Value {
+    let mut body = serde_json::json!({
+        "error": solobase_error_code_to_wafer(code),
+        "message": message,
+        "code": code.as_str(),
+    });
+    if let Some(details) = details {
+        body["details"] = details;
+    }
+    body
+}
+
+/// `validation_failed` (400) with a `details` object mapping each offending
+/// field to a human-readable reason.
+pub fn validation_error(message: &str, fields: &[(&str, &str)]) -> OutputStream {
+    let details: serde_json::Map<String, serde_json::Value> = fields
+        .iter()
+        .map(|(field, reason)| ((*field).to_string(), serde_json::json!(reason)))
+        .collect();
+    error_json(
+        ErrorCode::ValidationFailed,
+        message,
+        Some(serde_json::Value::Object(details)),
+    )
+}
+
+/// Whether a database error is a unique-constraint violation. Drivers
+/// differ: some classify it as `AlreadyExists`, others only say so in the
+/// message (SQLite "UNIQUE constraint failed", Postgres "duplicate key").
+pub(crate) fn is_unique_violation(err: &WaferError) -> bool {
+    if err.code == wafer_run::ErrorCode::AlreadyExists {
+        return true;
+    }
+    let msg = err.message.to_ascii_lowercase();
+    msg.contains("unique constraint") || msg.contains("duplicate key")
+}
+
+/// Map a database error on `what` (e.g. `"Bucket"`) to a client response:
+/// not-found → `not_found` 404, unique violation → `conflict` 409, anything
+/// else → a generic 500 whose cause is logged, never returned (raw driver
+/// text like "UNIQUE constraint failed: <table>.<col>" leaks schema).
+pub fn db_error_response(what: &str, err: WaferError) -> OutputStream {
+    if err.code == wafer_run::ErrorCode::NotFound {
+        return error_response(ErrorCode::NotFound, &format!("{what} not found"));
+    }
+    if is_unique_violation(&err) {
+        return error_response(ErrorCode::Conflict, &format!("{what} already exists"));
+    }
+    crate::http::err_internal("Database error", err)
+}
+
 /// Map a solobase `ErrorCode` to a wafer `ErrorCode`.
 pub(crate) fn solobase_error_code_to_wafer(code: ErrorCode) -> wafer_run::ErrorCode {
     match code {
@@ -144,24 +368,56 @@ pub(crate) fn solobase_error_code_to_wafer(code: ErrorCode) -> wafer_run::ErrorC
 
         ErrorCode::Forbidden
         | ErrorCode::AdminRequired
+        | ErrorCode::PermissionDenied
         | ErrorCode::AccountDisabled
-        | ErrorCode::EmailNotVerified => wafer_run::ErrorCode::PermissionDenied,
-
-        ErrorCode::NotFound => wafer_run::ErrorCode::NotFound,
-
-        ErrorCode::EmailAlreadyExists | ErrorCode::Conflict => wafer_run::ErrorCode::AlreadyExists,
+        | ErrorCode::EmailNotVerified
+        | ErrorCode::SignupClosed
+        | ErrorCode::InvitationRequired
+        | ErrorCode::InvitationInvalid
+        | ErrorCode::PasswordChangeRequired
+        | ErrorCode::ObjectLocked
+        | ErrorCode::OriginNotAllowed => wafer_run::ErrorCode::PermissionDenied,
+
+        ErrorCode::NotFound
+        | ErrorCode::ObjectNotFound
+        | ErrorCode::ShareRevoked
+        | ErrorCode::CouponInvalid
+        | ErrorCode::CouponExpired => wafer_run::ErrorCode::NotFound,
+
+        ErrorCode::EmailAlreadyExists
+        | ErrorCode::Conflict
+        | ErrorCode::BucketAlreadyExists
+        | ErrorCode::BucketNotEmpty
+        | ErrorCode::ObjectExists
+        | ErrorCode::ScanPending => wafer_run::ErrorCode::AlreadyExists,
 
         ErrorCode::PasswordTooShort
         | ErrorCode::PasswordTooLong
         | ErrorCode::InvalidEmail
         | ErrorCode::InvalidInput
-        | ErrorCode::InvalidPurchaseStatus => wafer_run::ErrorCode::InvalidArgument,
-
-        ErrorCode::QuotaExceeded | ErrorCode::FileTooLarge => {
-            wafer_run::ErrorCode::ResourceExhausted
-        }
-
-        ErrorCode::RateLimitExceeded => wafer_run::ErrorCode::ResourceExhausted,
+        | ErrorCode::ValidationFailed
+        | ErrorCode::BucketNameReserved
+        | ErrorCode::ChecksumMismatch
+        | ErrorCode::InvalidPurchaseStatus
+        | ErrorCode::InsufficientStock
+        | ErrorCode::CouponNotApplicable
+        | ErrorCode::PreviewUnsupported
+        | ErrorCode::UnsupportedMediaType
+        | ErrorCode::MalwareDetected => wafer_run::ErrorCode::InvalidArgument,
+
+        ErrorCode::QuotaExceeded
+        | ErrorCode::FileTooLarge
+        | ErrorCode::PayloadTooLarge
+        | ErrorCode::CouponExhausted
+        | ErrorCode::UploadLimitReached => wafer_run::ErrorCode::ResourceExhausted,
+
+        ErrorCode::RateLimitExceeded
+        | ErrorCode::UsageQuotaExceeded
+        | ErrorCode::TooManyTransfers => wafer_run::ErrorCode::ResourceExhausted,
+
+        ErrorCode::SchemaNotInitialized
+        | ErrorCode::MaintenanceMode
+        | ErrorCode::RequestTimeout => wafer_run::ErrorCode::Unavailable,
 
         ErrorCode::PaymentNotConfigured
         | ErrorCode::ConfigurationError
@@ -186,7 +442,10 @@ mod tests {
         // Forbidden -> 403
         assert_eq!(ErrorCode::Forbidden.status_code(), 403);
         assert_eq!(ErrorCode::AdminRequired.status_code(), 403);
+        assert_eq!(ErrorCode::PermissionDenied.status_code(), 403);
         assert_eq!(ErrorCode::AccountDisabled.status_code(), 403);
+        assert_eq!(ErrorCode::SignupClosed.status_code(), 403);
+        assert_eq!(ErrorCode::InvitationInvalid.status_code(), 403);
 
         // Not found -> 404
         assert_eq!(ErrorCode::NotFound.status_code(), 404);
@@ -204,9 +463,19 @@ mod tests {
         // Quota -> 413
         assert_eq!(ErrorCode::QuotaExceeded.status_code(), 413);
         assert_eq!(ErrorCode::FileTooLarge.status_code(), 413);
+        assert_eq!(ErrorCode::PayloadTooLarge.status_code(), 413);
 
         // Rate limit -> 429
         assert_eq!(ErrorCode::RateLimitExceeded.status_code(), 429);
+        assert_eq!(ErrorCode::UsageQuotaExceeded.status_code(), 429);
+        assert_eq!(ErrorCode::TooManyTransfers.status_code(), 429);
+
+        // Schema setup failed -> 503
+        assert_eq!(ErrorCode::SchemaNotInitialized.status_code(), 503);
+        assert_eq!(ErrorCode::MaintenanceMode.status_code(), 503);
+
+        // Handler timeout -> 504
+        assert_eq!(ErrorCode::RequestTimeout.status_code(), 504);
 
         // Server errors -> 500
         assert_eq!(ErrorCode::InternalError.status_code(), 500);
@@ -214,6 +483,35 @@ mod tests {
         assert_eq!(ErrorCode::ConfigurationError.status_code(), 500);
     }
 
+    #[test]
+    fn storage_and_validation_codes_have_specific_statuses() {
+        assert_eq!(ErrorCode::ValidationFailed.status_code(), 400);
+        assert_eq!(ErrorCode::ObjectNotFound.status_code(), 404);
+        assert_eq!(ErrorCode::BucketAlreadyExists.status_code(), 409);
+        assert_eq!(ErrorCode::BucketNotEmpty.status_code(), 409);
+        assert_eq!(ErrorCode::BucketNameReserved.status_code(), 400);
+        assert_eq!(ErrorCode::ObjectExists.status_code(), 409);
+        assert_eq!(ErrorCode::ShareRevoked.status_code(), 410);
+        assert_eq!(ErrorCode::PreviewUnsupported.status_code(), 415);
+        assert_eq!(ErrorCode::UnsupportedMediaType.status_code(), 415);
+        assert_eq!(ErrorCode::ScanPending.status_code(), 409);
+        assert_eq!(ErrorCode::MalwareDetected.status_code(), 422);
+        assert_eq!(ErrorCode::UploadLimitReached.status_code(), 409);
+        assert_eq!(ErrorCode::OriginNotAllowed.status_code(), 403);
+        assert_eq!(ErrorCode::ChecksumMismatch.status_code(), 400);
+        assert_eq!(ErrorCode::ObjectLocked.status_code(), 423);
+    }
+
+    #[test]
+    fn coupon_codes_have_specific_statuses() {
+        assert_eq!(ErrorCode::CouponInvalid.status_code(), 404);
+        assert_eq!(ErrorCode::CouponExpired.status_code(), 410);
+        assert_eq!(ErrorCode::CouponExhausted.status_code(), 409);
+        assert_eq!(ErrorCode::CouponNotApplicable.status_code(), 400);
+        assert_eq!(ErrorCode::CouponExpired.as_str(), "coupon_expired");
+        assert_eq!(ErrorCode::CouponExhausted.as_str(), "coupon_exhausted");
+    }
+
     #[test]
     fn test_error_code_as_str() {
         assert_eq!(
@@ -253,4 +551,57 @@ mod tests {
             other => panic!("expected an error stream, got {other:?}"),
         }
     }
+
+    #[tokio::test]
+    async fn validation_error_lists_fields_in_details() {
+        let out = validation_error("Invalid bucket", &[("name", "must be lowercase")]);
+        let status = crate::test_support::output_status(out).await;
+        assert_eq!(status, 400);
+
+        let out = validation_error("Invalid bucket", &[("name", "must be lowercase")]);
+        let body = crate::test_support::output_json(out).await;
+        assert_eq!(body["code"], "validation_failed");
+        assert_eq!(body["message"], "Invalid bucket");
+        assert_eq!(body["details"]["name"], "must be lowercase");
+    }
+
+    #[tokio::test]
+    async fn error_json_uses_the_precise_status() {
+        let out = error_json(ErrorCode::ShareRevoked, "revoked", None);
+        assert_eq!(crate::test_support::output_status(out).await, 410);
+    }
+
+    #[test]
+    fn unique_violations_are_recognised_across_drivers() {
+        let sqlite = WaferError::new(
+            wafer_run::ErrorCode::Internal,
+            "UNIQUE constraint failed: suppers_ai__files__buckets.name",
+        );
+        let postgres = WaferError::new(
+            wafer_run::ErrorCode::Internal,
+            "duplicate key value violates unique constraint \"buckets_name\"",
+        );
+        let typed = WaferError::new(wafer_run::ErrorCode::AlreadyExists, "exists");
+        let other = WaferError::new(wafer_run::ErrorCode::Internal, "disk I/O error");
+        assert!(is_unique_violation(&sqlite));
+        assert!(is_unique_violation(&postgres));
+        assert!(is_unique_violation(&typed));
+        assert!(!is_unique_violation(&other));
+    }
+
+    #[tokio::test]
+    async fn db_error_response_hides_driver_text() {
+        let err = WaferError::new(
+            wafer_run::ErrorCode::AlreadyExists,
+            "UNIQUE constraint failed: suppers_ai__files__buckets.name",
+        );
+        match db_error_response("Bucket", err).collect_buffered().await {
+            Err(wafer_run::TerminalNotResponse::Error(e)) => {
+                assert_eq!(e.code, wafer_run::ErrorCode::AlreadyExists);
+                assert_eq!(e.message, "Bucket already exists");
+                assert_eq!(e.detail_code(), Some("conflict"));
+            }
+            other => panic!("expected an error stream, got {other:?}"),
+        }
+    }
 }
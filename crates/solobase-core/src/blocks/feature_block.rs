@@ -53,6 +53,9 @@
 //!
 //! `lifecycle` is optional; when omitted the `Block` trait's no-op default is
 //! used (the embedding wrappers, which have no migrations, rely on this).
+//! When given, the generated method first skips an `Init` of a block that is
+//! disabled at boot (see [`crate::features::defer_init`]), so `$lexpr` only
+//! runs for blocks that are enabled or can't be disabled.
 
 /// Support module re-exporting every item the [`solobase_feature_block!`]
 /// expansion references through `$crate::…` paths. Hidden: it is an expansion
@@ -134,6 +137,13 @@ macro_rules! solobase_feature_block {
                     (),
                     $crate::blocks::feature_block::__private::WaferError,
                 > {
+                    // A block disabled at boot skips its Init (migrations,
+                    // seeds) until it is enabled and the server restarted.
+                    if $crate::features::defer_init(ctx, $name, &event, || {
+                        $crate::blocks::feature_block::__private::Block::info(self).can_disable
+                    }) {
+                        return ::std::result::Result::Ok(());
+                    }
                     let $lthis = self;
                     let $lctx = ctx;
                     let $levent = event;
@@ -0,0 +1,544 @@
+//! Feature flags: admin-defined switches for rolling a feature out to some
+//! users before everyone.
+//!
+//! Admins manage [`FeatureFlag`]s via `/b/admin/api/feature-flags`. Code
+//! checks them through [`crate::services::Services::flags`] (blocks and
+//! extensions) or [`is_enabled`]; frontends fetch the caller's evaluated set
+//! from `GET /b/auth/api/flags`.
+//!
+//! A flag is on for a caller when it is `enabled` and the caller
+//!
+//! - is listed in `target_users`, or
+//! - holds one of `target_roles`, or
+//! - falls in the first `rollout_percent` of 100 buckets. The bucket is a
+//!   hash of the flag key and user id, so a user keeps their answer as the
+//!   percentage grows, and different flags roll out to different users.
+//!
+//! Anonymous callers only get flags rolled out to 100%. Unknown keys are off.
+//!
+//! Definitions are read through a per-instance [`TtlCache`] that admin
+//! writes invalidate, so evaluation costs no query on the hot path and an
+//! edit applies without a restart.
+
+use std::{collections::BTreeMap, time::Duration};
+
+use sha2::{Digest, Sha256};
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, Message, WaferError};
+
+use super::admin::FEATURE_FLAGS_TABLE;
+use crate::{
+    cache::TtlCache,
+    revisions::{self, Category},
+    util::RecordExt,
+};
+
+/// Longest accepted flag key.
+pub const MAX_KEY_CHARS: usize = 100;
+
+/// Longest accepted description, in characters.
+pub const MAX_DESCRIPTION_CHARS: usize = 500;
+
+/// A stored flag definition.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct FeatureFlag {
+    pub id: String,
+    pub key: String,
+    pub description: String,
+    /// Master switch: a disabled flag is off for everyone.
+    pub enabled: bool,
+    /// 0–100: share of users the flag is on for, beyond the targets.
+    pub rollout_percent: u8,
+    /// Roles the flag is always on for.
+    pub target_roles: Vec<String>,
+    /// User ids the flag is always on for.
+    pub target_users: Vec<String>,
+    pub created_by: String,
+    pub created_at: String,
+    pub updated_at: String,
+}
+
+impl FeatureFlag {
+    fn from_record(r: &Record) -> Self {
+        Self {
+            id: r.id.clone(),
+            key: r.str_field("key").to_string(),
+            description: r.str_field("description").to_string(),
+            enabled: r.i64_field("enabled") != 0,
+            rollout_percent: r.i64_field("rollout_percent").clamp(0, 100) as u8,
+            target_roles: split_list(r.str_field("target_roles")),
+            target_users: split_list(r.str_field("target_users")),
+            created_by: r.str_field("created_by").to_string(),
+            created_at: r.str_field("created_at").to_string(),
+            updated_at: r.str_field("updated_at").to_string(),
+        }
+    }
+
+    /// Whether the flag is on for `user_id` (empty = anonymous) holding
+    /// `roles`.
+    pub fn evaluate(&self, user_id: &str, roles: &[&str]) -> bool {
+        if !self.enabled {
+            return false;
+        }
+        if self.rollout_percent >= 100 {
+            return true;
+        }
+        if user_id.is_empty() {
+            return false;
+        }
+        self.target_users.iter().any(|u| u == user_id)
+            || self
+                .target_roles
+                .iter()
+                .any(|r| roles.contains(&r.as_str()))
+            || bucket(&self.key, user_id) < self.rollout_percent
+    }
+}
+
+/// The user's stable rollout bucket for `key`, in `0..100`.
+pub fn bucket(key: &str, user_id: &str) -> u8 {
+    let digest = Sha256::new()
+        .chain_update(key.as_bytes())
+        .chain_update(b":")
+        .chain_update(user_id.as_bytes())
+        .finalize();
+    let n = u32::from_be_bytes([digest[0], digest[1], digest[2], digest[3]]);
+    (n % 100) as u8
+}
+
+/// Admin create/update body. On update, absent fields keep their stored
+/// value; on create they take the defaults (disabled, 0%, no targets).
+#[derive(Debug, Clone, Default, serde::Deserialize)]
+#[serde(default)]
+pub struct FlagInput {
+    pub key: Option<String>,
+    pub description: Option<String>,
+    pub enabled: Option<bool>,
+    pub rollout_percent: Option<i64>,
+    pub target_roles: Option<Vec<String>>,
+    pub target_users: Option<Vec<String>>,
+}
+
+/// A [`FlagInput`] overlaid on the stored flag (if any) that passed
+/// [`FlagInput::validate`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ValidFlag {
+    key: String,
+    description: String,
+    enabled: bool,
+    rollout_percent: u8,
+    target_roles: Vec<String>,
+    target_users: Vec<String>,
+}
+
+impl FlagInput {
+    /// Overlay the input on `existing` (update) or the defaults (create) and
+    /// check it. The key of an existing flag cannot change. On failure
+    /// returns `(field, reason)` pairs for the admin API's validation
+    /// response.
+    pub fn validate(
+        self,
+        existing: Option<&FeatureFlag>,
+    ) -> Result<ValidFlag, Vec<(&'static str, &'static str)>> {
+        let mut problems = Vec::new();
+
+        let key = match existing {
+            Some(flag) => {
+                if self.key.as_deref().is_some_and(|k| k.trim() != flag.key) {
+                    problems.push(("key", "cannot be changed"));
+                }
+                flag.key.clone()
+            }
+            None => {
+                let key = self.key.unwrap_or_default().trim().to_string();
+                if key.is_empty() {
+                    problems.push(("key", "required"));
+                } else if !valid_key(&key) {
+                    problems.push((
+                        "key",
+                        "must be lowercase letters, digits, '.', '_' or '-' (max 100)",
+                    ));
+                }
+                key
+            }
+        };
+
+        let description = match self.description {
+            Some(d) => d.trim().to_string(),
+            None => existing.map(|f| f.description.clone()).unwrap_or_default(),
+        };
+        if description.chars().count() > MAX_DESCRIPTION_CHARS {
+            problems.push(("description", "too long"));
+        }
+
+        let rollout_percent = match self.rollout_percent {
+            Some(p) if (0..=100).contains(&p) => p as u8,
+            Some(_) => {
+                problems.push(("rollout_percent", "must be between 0 and 100"));
+                0
+            }
+            None => existing.map_or(0, |f| f.rollout_percent),
+        };
+
+        let target_roles = match self.target_roles {
+            Some(list) => clean_list(list, "target_roles", &mut problems),
+            None => existing.map(|f| f.target_roles.clone()).unwrap_or_default(),
+        };
+        let target_users = match self.target_users {
+            Some(list) => clean_list(list, "target_users", &mut problems),
+            None => existing.map(|f| f.target_users.clone()).unwrap_or_default(),
+        };
+
+        if !problems.is_empty() {
+            return Err(problems);
+        }
+        Ok(ValidFlag {
+            key,
+            description,
+            enabled: self
+                .enabled
+                .unwrap_or_else(|| existing.is_some_and(|f| f.enabled)),
+            rollout_percent,
+            target_roles,
+            target_users,
+        })
+    }
+}
+
+fn valid_key(key: &str) -> bool {
+    key.len() <= MAX_KEY_CHARS
+        && key.starts_with(|c: char| c.is_ascii_lowercase() || c.is_ascii_digit())
+        && key
+            .chars()
+            .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || matches!(c, '.' | '_' | '-'))
+}
+
+/// Trim, drop empties and duplicates. Entries are stored comma-joined, so a
+/// comma inside one is rejected.
+fn clean_list(
+    list: Vec<String>,
+    field: &'static str,
+    problems: &mut Vec<(&'static str, &'static str)>,
+) -> Vec<String> {
+    let mut out: Vec<String> = Vec::new();
+    for item in list.iter().map(|s| s.trim()) {
+        if item.contains(',') {
+            problems.push((field, "entries cannot contain ','"));
+        } else if !item.is_empty() && !out.iter().any(|o| o == item) {
+            out.push(item.to_string());
+        }
+    }
+    out
+}
+
+fn split_list(s: &str) -> Vec<String> {
+    s.split(',')
+        .map(str::trim)
+        .filter(|r| !r.is_empty())
+        .map(str::to_string)
+        .collect()
+}
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::json!(value),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Cache
+// ---------------------------------------------------------------------------
+
+/// The flags table as last read. Only an admin write on this instance
+/// invalidates it, so the TTL bounds how long another instance keeps
+/// evaluating an edited flag. Tests disable it: every `TestContext` has its
+/// own database but this cache is process-wide.
+static CACHE: TtlCache<Vec<FeatureFlag>> = TtlCache::new(CACHE_TTL);
+
+#[cfg(not(test))]
+const CACHE_TTL: Duration = Duration::from_secs(30);
+#[cfg(test)]
+const CACHE_TTL: Duration = Duration::ZERO;
+
+/// Every stored flag, by key.
+async fn load_all(ctx: &dyn Context) -> Result<Vec<FeatureFlag>, WaferError> {
+    let rows = db::list_all(ctx, FEATURE_FLAGS_TABLE, vec![]).await?;
+    let mut all: Vec<FeatureFlag> = rows.iter().map(FeatureFlag::from_record).collect();
+    all.sort_by(|a, b| a.key.cmp(&b.key));
+    Ok(all)
+}
+
+/// [`load_all`] through the cache. `Instant` panics on wasm32, so the
+/// browser and Cloudflare builds read the table on every call instead.
+async fn cached(ctx: &dyn Context) -> Result<Vec<FeatureFlag>, WaferError> {
+    if cfg!(target_arch = "wasm32") {
+        return load_all(ctx).await;
+    }
+    let mut failed = None;
+    let slot = &mut failed;
+    let hit = CACHE
+        .get_or_load(|| async move {
+            load_all(ctx).await.unwrap_or_else(|e| {
+                *slot = Some(e);
+                Vec::new()
+            })
+        })
+        .await;
+    if let Some(e) = failed {
+        // Don't pin a transient backend failure for a whole TTL.
+        CACHE.invalidate();
+        return Err(e);
+    }
+    Ok(hit.to_vec())
+}
+
+// ---------------------------------------------------------------------------
+// Admin operations
+// ---------------------------------------------------------------------------
+
+/// Every flag definition, by key.
+pub async fn list(ctx: &dyn Context) -> Result<Vec<FeatureFlag>, WaferError> {
+    load_all(ctx).await
+}
+
+/// Fetch one flag by key.
+pub async fn get(ctx: &dyn Context, key: &str) -> Result<Option<FeatureFlag>, WaferError> {
+    let rows = db::list_all(ctx, FEATURE_FLAGS_TABLE, vec![eq("key", key)]).await?;
+    Ok(rows.first().map(FeatureFlag::from_record))
+}
+
+fn fields(f: &ValidFlag) -> std::collections::HashMap<String, serde_json::Value> {
+    crate::util::json_map(serde_json::json!({
+        "key": f.key,
+        "description": f.description,
+        "enabled": i64::from(f.enabled),
+        "rollout_percent": i64::from(f.rollout_percent),
+        "target_roles": f.target_roles.join(","),
+        "target_users": f.target_users.join(","),
+    }))
+}
+
+/// Store a new flag created by `created_by`. A taken key fails the unique
+/// index (see [`crate::blocks::errors::is_unique_violation`]).
+pub async fn create(
+    ctx: &dyn Context,
+    f: &ValidFlag,
+    created_by: &str,
+) -> Result<FeatureFlag, WaferError> {
+    let mut data = fields(f);
+    data.insert("created_by".to_string(), serde_json::json!(created_by));
+    crate::util::stamp_created(&mut data);
+    let record = db::create(ctx, FEATURE_FLAGS_TABLE, data).await?;
+    CACHE.invalidate();
+    revisions::bump(Category::Flags);
+    Ok(FeatureFlag::from_record(&record))
+}
+
+/// Replace flag `id`'s settings.
+pub async fn update(ctx: &dyn Context, id: &str, f: &ValidFlag) -> Result<FeatureFlag, WaferError> {
+    let mut data = fields(f);
+    crate::util::stamp_updated(&mut data);
+    let record = db::update(ctx, FEATURE_FLAGS_TABLE, id, data).await?;
+    CACHE.invalidate();
+    revisions::bump(Category::Flags);
+    Ok(FeatureFlag::from_record(&record))
+}
+
+/// Delete the flag with `key`. Returns `false` when it did not exist.
+pub async fn delete(ctx: &dyn Context, key: &str) -> Result<bool, WaferError> {
+    let Some(flag) = get(ctx, key).await? else {
+        return Ok(false);
+    };
+    match db::delete(ctx, FEATURE_FLAGS_TABLE, &flag.id).await {
+        Ok(()) => {}
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => return Ok(false),
+        Err(e) => return Err(e),
+    }
+    CACHE.invalidate();
+    revisions::bump(Category::Flags);
+    Ok(true)
+}
+
+// ---------------------------------------------------------------------------
+// Evaluation
+// ---------------------------------------------------------------------------
+
+/// The caller's roles, as the auth middleware stamped them.
+pub(crate) fn roles_of(msg: &Message) -> Vec<&str> {
+    msg.get_meta("auth.user_roles")
+        .split(',')
+        .map(str::trim)
+        .filter(|r| !r.is_empty())
+        .collect()
+}
+
+/// Whether flag `key` is on for `user_id` holding `roles`. Unknown keys are
+/// off; so is every flag when the definitions can't be read (logged), so a
+/// backend failure never turns an unfinished feature on.
+pub async fn is_enabled(ctx: &dyn Context, key: &str, user_id: &str, roles: &[&str]) -> bool {
+    match cached(ctx).await {
+        Ok(flags) => flags
+            .iter()
+            .find(|f| f.key == key)
+            .is_some_and(|f| f.evaluate(user_id, roles)),
+        Err(e) => {
+            tracing::warn!(flag = %key, "feature flags unreadable: {e}");
+            false
+        }
+    }
+}
+
+/// Every flag evaluated for the caller of `msg`, by key.
+pub async fn evaluate_for(
+    ctx: &dyn Context,
+    msg: &Message,
+) -> Result<BTreeMap<String, bool>, WaferError> {
+    let roles = roles_of(msg);
+    Ok(cached(ctx)
+        .await?
+        .iter()
+        .map(|f| (f.key.clone(), f.evaluate(msg.user_id(), &roles)))
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn flag(rollout_percent: u8, target_roles: &[&str], target_users: &[&str]) -> FeatureFlag {
+        FeatureFlag {
+            id: "f1".into(),
+            key: "new-editor".into(),
+            description: String::new(),
+            enabled: true,
+            rollout_percent,
+            target_roles: target_roles.iter().map(|r| r.to_string()).collect(),
+            target_users: target_users.iter().map(|u| u.to_string()).collect(),
+            created_by: String::new(),
+            created_at: String::new(),
+            updated_at: String::new(),
+        }
+    }
+
+    #[test]
+    fn bucket_is_stable_and_spread() {
+        assert_eq!(
+            bucket("new-editor", "user-1"),
+            bucket("new-editor", "user-1")
+        );
+        // Pinned so a change to the hash input (which would reshuffle every
+        // rollout in flight) fails loudly.
+        let pinned: Vec<u8> = ["user-1", "user-2", "user-3"]
+            .iter()
+            .map(|u| bucket("new-editor", u))
+            .collect();
+        assert_eq!(pinned, [76, 96, 5]);
+
+        let mut counts = [0u32; 100];
+        for i in 0..10_000 {
+            counts[bucket("new-editor", &format!("user-{i}")) as usize] += 1;
+        }
+        // 100 per bucket expected; every bucket gets a fair share.
+        assert!(
+            counts.iter().all(|&c| (50..=150).contains(&c)),
+            "{counts:?}"
+        );
+    }
+
+    #[test]
+    fn rollout_only_grows_the_set_of_users() {
+        let users: Vec<String> = (0..1000).map(|i| format!("user-{i}")).collect();
+        let on_at = |percent: u8| -> Vec<&str> {
+            users
+                .iter()
+                .filter(|u| flag(percent, &[], &[]).evaluate(u, &[]))
+                .map(String::as_str)
+                .collect()
+        };
+        let ten = on_at(10);
+        let fifty = on_at(50);
+        assert!((50..=150).contains(&ten.len()), "{}", ten.len());
+        assert!(ten.iter().all(|u| fifty.contains(u)));
+        assert!(on_at(0).is_empty());
+        assert_eq!(on_at(100).len(), users.len());
+    }
+
+    #[test]
+    fn targets_and_switch() {
+        let f = flag(0, &["beta"], &["user-7"]);
+        assert!(f.evaluate("user-7", &[]));
+        assert!(f.evaluate("user-8", &["user", "beta"]));
+        assert!(!f.evaluate("user-8", &["user"]));
+        // Anonymous callers only see flags rolled out to everyone.
+        assert!(!f.evaluate("", &[]));
+        assert!(flag(100, &[], &[]).evaluate("", &[]));
+
+        let off = FeatureFlag {
+            enabled: false,
+            ..flag(100, &["beta"], &["user-7"])
+        };
+        assert!(!off.evaluate("user-7", &["beta"]));
+    }
+
+    #[test]
+    fn different_flags_bucket_independently() {
+        let differs = (0..100)
+            .map(|i| format!("user-{i}"))
+            .any(|u| bucket("a", &u) != bucket("b", &u));
+        assert!(differs);
+    }
+
+    #[test]
+    fn validate_overlays_and_reports_fields() {
+        let created = FlagInput {
+            key: Some(" beta.search ".into()),
+            rollout_percent: Some(25),
+            target_roles: Some(vec!["beta".into(), " beta ".into(), String::new()]),
+            ..Default::default()
+        }
+        .validate(None)
+        .unwrap();
+        assert_eq!(created.key, "beta.search");
+        assert!(!created.enabled);
+        assert_eq!(created.rollout_percent, 25);
+        assert_eq!(created.target_roles, vec!["beta"]);
+
+        let stored = FeatureFlag {
+            key: "beta.search".into(),
+            ..flag(25, &["beta"], &[])
+        };
+        let toggled = FlagInput {
+            enabled: Some(false),
+            ..Default::default()
+        }
+        .validate(Some(&stored))
+        .unwrap();
+        assert!(!toggled.enabled);
+        assert_eq!(toggled.rollout_percent, 25);
+        assert_eq!(toggled.target_roles, vec!["beta"]);
+
+        let bad = FlagInput {
+            key: Some("Beta Search".into()),
+            rollout_percent: Some(101),
+            target_users: Some(vec!["a,b".into()]),
+            ..Default::default()
+        }
+        .validate(None)
+        .unwrap_err();
+        assert_eq!(
+            bad.iter().map(|(f, _)| *f).collect::<Vec<_>>(),
+            ["key", "rollout_percent", "target_users"]
+        );
+
+        let renamed = FlagInput {
+            key: Some("other".into()),
+            ..Default::default()
+        }
+        .validate(Some(&stored))
+        .unwrap_err();
+        assert_eq!(renamed, vec![("key", "cannot be changed")]);
+    }
+}
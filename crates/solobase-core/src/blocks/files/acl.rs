@@ -0,0 +1,933 @@
+//! Per-user folder sharing for the core storage API.
+//!
+//! A bucket owner grants another account `read` or `write` on a path in the
+//! bucket: `''` (the whole bucket), a folder prefix ending in `/`, or one
+//! exact key. A grant covers every key beneath its path, resolved by walking
+//! the key's parent chain ([`covering_paths`]).
+//!
+//! Access is decided in a fixed order by [`is_access_denied`]:
+//! 1. admins — always allowed (the JSON API's existing bypass);
+//! 2. the bucket owner — always allowed;
+//! 3. an ACL grant covering the path with sufficient permission.
+//!
+//! Public share links (`share.rs`, `/b/storage/direct/{token}`, managed
+//! under `/b/cloudstorage`) are a separate, token-authenticated path and
+//! never consult this table; neither affects the other. Deleting objects
+//! and buckets, managing grants, and the SSR portal stay owner-only.
+//!
+//! A new grant posts a [`SHARE_RECEIVED`] notification (emailed by
+//! default). Granting to an email with no account stores a pending grant
+//! and mails the address instead; [`attach_pending_grants`] hands it over
+//! once an account confirms that address. Grantees see unanswered grants
+//! under `/b/storage/api/shares/incoming` and may accept or decline them;
+//! declining deletes the grant and notifies the sharer ([`SHARE_DECLINED`]).
+//!
+//! People are shown by their directory profile ([`crate::blocks::directory`]):
+//! grant listings carry the grantee's and sharer's, notifications name the
+//! sharer by it, and `GET /b/storage/api/users/search` finds accounts for a
+//! share dialog. Grantee emails are still resolved to accounts by auth.
+
+use wafer_core::clients::database::Record;
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream, WaferError};
+
+use super::{repo, storage};
+use crate::{
+    blocks::{directory, errors, notifications::NotificationPayload},
+    events::{self, Event},
+    http::{err_bad_request, err_forbidden, err_internal, err_not_found, ok_json},
+    services::Services,
+    util::RecordExt,
+};
+
+/// Notification type posted to a grantee when a path is shared with them.
+pub const SHARE_RECEIVED: &str = events::ShareReceived::NAME;
+/// Notification type posted to the sharer when a grantee declines.
+pub const SHARE_DECLINED: &str = events::ShareDeclined::NAME;
+
+/// User-search results when the caller doesn't ask for a number.
+const DEFAULT_USER_RESULTS: i64 = 10;
+
+/// What a request needs on a path.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(super) enum Access {
+    Read,
+    Write,
+}
+
+/// The grant paths that cover `path`, outermost first: the whole bucket
+/// (`''`), each enclosing folder (`a/`, `a/b/`), and `path` itself.
+pub(super) fn covering_paths(path: &str) -> Vec<String> {
+    let mut paths = vec![String::new()];
+    for (idx, _) in path.match_indices('/') {
+        paths.push(path[..=idx].to_string());
+    }
+    if !path.is_empty() && !path.ends_with('/') {
+        paths.push(path.to_string());
+    }
+    paths
+}
+
+/// Whether `user_id` holds a grant covering `path` in `bucket` that allows
+/// `access` (`write` implies `read`). DB errors fail closed.
+pub(super) async fn has_grant(
+    ctx: &dyn Context,
+    user_id: &str,
+    bucket: &str,
+    path: &str,
+    access: Access,
+) -> bool {
+    if user_id.is_empty() {
+        return false;
+    }
+    match repo::acls::find_covering(ctx, bucket, user_id, &covering_paths(path)).await {
+        Ok(grants) => grants
+            .iter()
+            .any(|g| access == Access::Read || g.str_field("permission") == "write"),
+        Err(e) => {
+            tracing::warn!(error = %e, bucket = %bucket, "object ACL lookup failed");
+            false
+        }
+    }
+}
+
+/// Which folders a listing of `prefix` in `bucket` may show the caller:
+/// `None` for all of them (admin, owner, or a bucket `visible_to_users`),
+/// otherwise the caller's folder and whole-bucket grants covering `prefix`
+/// — empty when there are none, and the listing is refused. Exact-key
+/// grants never count: `?prefix=a` also matches `ab` and `a/…`, which a
+/// grant on `a` doesn't cover. DB errors fail closed.
+pub(super) async fn listing_grants(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    prefix: &str,
+) -> Option<Vec<String>> {
+    if !storage::is_bucket_access_denied(ctx, msg, bucket).await
+        || repo::buckets::is_visible_to_users(ctx, bucket)
+            .await
+            .unwrap_or(false)
+    {
+        return None;
+    }
+    let folders: Vec<String> = covering_paths(prefix)
+        .into_iter()
+        .filter(|p| p.is_empty() || p.ends_with('/'))
+        .collect();
+    if msg.user_id().is_empty() {
+        return Some(Vec::new());
+    }
+    match repo::acls::find_covering(ctx, bucket, msg.user_id(), &folders).await {
+        Ok(grants) => Some(
+            grants
+                .iter()
+                .map(|g| g.str_field("path").to_string())
+                .collect(),
+        ),
+        Err(e) => {
+            tracing::warn!(error = %e, bucket = %bucket, "object ACL lookup failed");
+            Some(Vec::new())
+        }
+    }
+}
+
+/// True when the caller may not perform `access` on `path` in `bucket`:
+/// not an admin, not the owner, no covering grant, and (for reads) the
+/// bucket is not marked `visible_to_users`. Replaces
+/// [`storage::is_bucket_access_denied`] on the routes grantees can use.
+pub(super) async fn is_access_denied(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    path: &str,
+    access: Access,
+) -> bool {
+    if !storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return false;
+    }
+    if access == Access::Read
+        && repo::buckets::is_visible_to_users(ctx, bucket)
+            .await
+            .unwrap_or(false)
+    {
+        return false;
+    }
+    !has_grant(ctx, msg.user_id(), bucket, path, access).await
+}
+
+fn is_valid_grant_path(path: &str) -> bool {
+    path.is_empty() || storage::is_valid_storage_key(path)
+}
+
+/// Shape check for an address that has no account to vouch for it.
+fn is_plausible_email(email: &str) -> bool {
+    email.len() <= 255
+        && email
+            .split_once('@')
+            .is_some_and(|(local, domain)| !local.is_empty() && domain.contains('.'))
+}
+
+/// `bucket` or `bucket/path`, as shown in notifications and emails.
+fn shared_item(bucket: &str, path: &str) -> String {
+    if path.is_empty() {
+        bucket.to_string()
+    } else {
+        format!("{bucket}/{path}")
+    }
+}
+
+/// How `user_id` is named to the other side of a share: their profile's
+/// name, or "Someone" when there is none.
+async fn display_user(ctx: &dyn Context, user_id: &str) -> String {
+    match directory::lookup(ctx, &[user_id]).await.remove(user_id) {
+        Some(profile) => profile.label().to_string(),
+        None => "Someone".to_string(),
+    }
+}
+
+/// `grants` with the grantee's and the sharer's profiles added as
+/// `grantee` and `shared_by` (null for a pending grant or an unknown
+/// account), from one directory lookup.
+async fn with_profiles(ctx: &dyn Context, mut grants: Vec<Record>) -> Vec<Record> {
+    let ids: Vec<&str> = grants
+        .iter()
+        .flat_map(|g| [g.str_field("grantee_user_id"), g.str_field("granted_by")])
+        .collect();
+    let profiles = directory::lookup(ctx, &ids).await;
+    for grant in &mut grants {
+        let grantee = profiles.get(grant.str_field("grantee_user_id"));
+        let shared_by = profiles.get(grant.str_field("granted_by"));
+        let (grantee, shared_by) = (serde_json::json!(grantee), serde_json::json!(shared_by));
+        grant.data.insert("grantee".to_string(), grantee);
+        grant.data.insert("shared_by".to_string(), shared_by);
+    }
+    grants
+}
+
+/// `GET /b/storage/api/users/search?q=&limit=` — accounts to share with,
+/// for a share dialog: up to `limit` (default 10) directory profiles
+/// matching `q`. `q` must carry a whole local part and its `@`, so a
+/// caller finds only accounts whose address they already know and can't
+/// list the user base a few letters at a time.
+pub(super) async fn handle_user_search(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let query = msg.query("q").trim();
+    if !crate::blocks::auth::repo::directory::is_address_prefix(query) {
+        return errors::validation_error(
+            "Invalid search",
+            &[("q", "a whole address up to its @ at least")],
+        );
+    }
+    let limit = msg.query("limit").parse().unwrap_or(DEFAULT_USER_RESULTS);
+    let users = directory::search(ctx, query, limit).await;
+    ok_json(&serde_json::json!({ "users": users }))
+}
+
+/// Tell a grant's holder it was shared with them, emailing it too when
+/// `email` (the grantee hasn't already been mailed). Best-effort.
+async fn notify_received(ctx: &dyn Context, grant: &Record, sharer: &str, email: bool) {
+    let item = shared_item(grant.str_field("bucket"), grant.str_field("path"));
+    let payload = NotificationPayload {
+        title: format!("{sharer} shared {item} with you"),
+        body: format!(
+            "You can {} it. Accept or decline under incoming shares.",
+            grant.str_field("permission")
+        ),
+        email_by_default: email,
+        email_template: email.then(|| {
+            serde_json::json!({
+                "template": "share_received",
+                "shared_by": sharer,
+                "file": item,
+            })
+        }),
+    };
+    let event = events::ShareReceived {
+        grant_id: grant.id.clone(),
+        bucket: grant.str_field("bucket").to_string(),
+        path: grant.str_field("path").to_string(),
+    };
+    let user_id = grant.str_field("grantee_user_id");
+    if let Err(e) = Services::new(ctx, "suppers-ai/files")
+        .notifications()
+        .notify_event(user_id, &event, payload)
+        .await
+    {
+        tracing::warn!(error = %e, grant = %grant.id, "share notice failed");
+    }
+}
+
+/// `GET /b/storage/api/buckets/{name}/acl[?path=]` — the owner's view of
+/// who has access.
+pub(super) async fn handle_list(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let path = msg.query("path");
+    let path = (!path.is_empty()).then_some(path);
+    match repo::acls::list_for_bucket(ctx, bucket, path).await {
+        Ok(grants) => ok_json(&serde_json::json!({"grants": with_profiles(ctx, grants).await})),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `POST /b/storage/api/buckets/{name}/acl` — grant (or change) a user's
+/// permission on a path. Body: `{grantee_user_id | grantee_email, path?,
+/// permission?}`; an email is resolved through auth's users directory, and
+/// one with no account gets a pending grant plus an email inviting them to
+/// sign up. The grantee is notified either way.
+pub(super) async fn handle_grant(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        #[serde(default)]
+        grantee_user_id: String,
+        #[serde(default)]
+        grantee_email: String,
+        #[serde(default)]
+        path: String,
+        #[serde(default = "default_permission")]
+        permission: String,
+    }
+    fn default_permission() -> String {
+        "read".to_string()
+    }
+
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let mut body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    let services = Services::new(ctx, "suppers-ai/files");
+    // Set when the address has no account: the grant waits for one.
+    let mut pending_email = None;
+    if body.grantee_user_id.is_empty() && !body.grantee_email.is_empty() {
+        let email = body.grantee_email.trim().to_lowercase();
+        match services.users().find_by_email(&email).await {
+            Ok(Some(entry)) => body.grantee_user_id = entry.id,
+            Ok(None) => pending_email = Some(email),
+            Err(e) => return err_internal("Database error", e),
+        }
+    }
+
+    let mut invalid = Vec::new();
+    match &pending_email {
+        Some(email) if !is_plausible_email(email) => {
+            invalid.push(("grantee_email", "must be a valid email address"));
+        }
+        Some(_) => {}
+        None if body.grantee_user_id.is_empty() => invalid.push(("grantee_user_id", "required")),
+        None if body.grantee_user_id == msg.user_id() => {
+            invalid.push(("grantee_user_id", "cannot grant access to yourself"));
+        }
+        None => {}
+    }
+    if !is_valid_grant_path(&body.path) {
+        invalid.push((
+            "path",
+            "must be empty, a folder ending in '/', or an object key",
+        ));
+    }
+    if body.permission != "read" && body.permission != "write" {
+        invalid.push(("permission", "must be 'read' or 'write'"));
+    }
+    if !invalid.is_empty() {
+        return errors::validation_error("Invalid grant", &invalid);
+    }
+
+    let sharer = display_user(ctx, msg.user_id()).await;
+    if let Some(email) = pending_email {
+        let grant = match repo::acls::upsert_pending(
+            ctx,
+            repo::acls::NewPendingGrant {
+                bucket,
+                path: &body.path,
+                grantee_email: &email,
+                permission: &body.permission,
+                granted_by: msg.user_id(),
+            },
+        )
+        .await
+        {
+            Ok(grant) => grant,
+            Err(e) => return errors::db_error_response("Grant", e),
+        };
+        let invite = serde_json::json!({
+            "template": "share_received",
+            "to": email,
+            "shared_by": sharer,
+            "file": shared_item(bucket, &body.path),
+            "signup": true,
+        });
+        services.mailer().send("email.send_template", invite).await;
+        return ok_json(&grant);
+    }
+
+    match repo::acls::upsert(
+        ctx,
+        repo::acls::NewGrant {
+            bucket,
+            path: &body.path,
+            grantee_user_id: &body.grantee_user_id,
+            permission: &body.permission,
+            granted_by: msg.user_id(),
+        },
+    )
+    .await
+    {
+        Ok(grant) => {
+            notify_received(ctx, &grant, &sharer, true).await;
+            ok_json(&grant)
+        }
+        Err(e) => errors::db_error_response("Grant", e),
+    }
+}
+
+/// `DELETE /b/storage/api/buckets/{name}/acl/{id}` — revoke a grant.
+pub(super) async fn handle_revoke(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let id = msg.var("id");
+    if id.is_empty() {
+        return err_bad_request("Missing grant ID");
+    }
+    match repo::acls::find_by_id(ctx, id).await {
+        // A grant id from another bucket answers 404, not 403, so ids can't
+        // be probed across buckets.
+        Ok(grant) if grant.str_field("bucket") == bucket => {}
+        Ok(_) => return err_not_found("Grant not found"),
+        Err(e) if e.code == ErrorCode::NotFound => return err_not_found("Grant not found"),
+        Err(e) => return err_internal("Database error", e),
+    }
+    match repo::acls::delete(ctx, id).await {
+        Ok(()) => ok_json(&serde_json::json!({"deleted": true})),
+        Err(e) => err_internal("Failed to revoke grant", e),
+    }
+}
+
+/// `GET /b/storage/api/shared-with-me` — grants held by the caller, newest
+/// first. Each row names the bucket and path to browse with the regular
+/// object endpoints.
+pub(super) async fn handle_shared_with_me(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let (_, page_size, offset) = msg.pagination_params(50);
+    match repo::acls::list_for_grantee(ctx, msg.user_id(), page_size as i64, offset as i64).await {
+        Ok(result) => ok_json(&result),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `GET /b/storage/api/shares/incoming` — grants shared with the caller
+/// that they haven't accepted or declined yet, newest first. Access doesn't
+/// wait on the answer; the list is what "you have new shares" points at.
+pub(super) async fn handle_incoming(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    match repo::acls::list_received(ctx, msg.user_id()).await {
+        Ok(grants) => ok_json(&serde_json::json!({"grants": with_profiles(ctx, grants).await})),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// The caller's own grant `id`, or the response to send instead. Someone
+/// else's grant answers 404 so ids can't be probed.
+async fn own_grant(ctx: &dyn Context, msg: &Message) -> Result<Record, OutputStream> {
+    let id = msg.var("id");
+    if id.is_empty() {
+        return Err(err_bad_request("Missing grant ID"));
+    }
+    match repo::acls::find_by_id(ctx, id).await {
+        Ok(grant) if grant.str_field("grantee_user_id") == msg.user_id() => Ok(grant),
+        Ok(_) => Err(err_not_found("Grant not found")),
+        Err(e) if e.code == ErrorCode::NotFound => Err(err_not_found("Grant not found")),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// `POST /b/storage/api/shares/incoming/{id}/accept` — keep a share.
+/// Idempotent.
+pub(super) async fn handle_accept(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let grant = match own_grant(ctx, msg).await {
+        Ok(grant) => grant,
+        Err(resp) => return resp,
+    };
+    match repo::acls::accept(ctx, &grant.id).await {
+        Ok(grant) => ok_json(&grant),
+        Err(e) => err_internal("Failed to accept grant", e),
+    }
+}
+
+/// `POST /b/storage/api/shares/incoming/{id}/decline` — give a share back:
+/// the grant is deleted, so access ends, and the sharer is notified.
+pub(super) async fn handle_decline(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let grant = match own_grant(ctx, msg).await {
+        Ok(grant) => grant,
+        Err(resp) => return resp,
+    };
+    if let Err(e) = repo::acls::delete(ctx, &grant.id).await {
+        return err_internal("Failed to decline grant", e);
+    }
+    let grantee = display_user(ctx, msg.user_id()).await;
+    let item = shared_item(grant.str_field("bucket"), grant.str_field("path"));
+    let payload = NotificationPayload {
+        title: format!("{grantee} declined {item}"),
+        body: "They no longer have access to what you shared.".to_string(),
+        ..Default::default()
+    };
+    let event = events::ShareDeclined {
+        bucket: grant.str_field("bucket").to_string(),
+        path: grant.str_field("path").to_string(),
+    };
+    if let Err(e) = Services::new(ctx, "suppers-ai/files")
+        .notifications()
+        .notify_event(grant.str_field("granted_by"), &event, payload)
+        .await
+    {
+        tracing::warn!(error = %e, grant = %grant.id, "decline notice failed");
+    }
+    ok_json(&serde_json::json!({"declined": true}))
+}
+
+/// Hand the grants pending for `email` to `user_id`, who has just confirmed
+/// that address (signup without verification, email verification, or a
+/// new OAuth account). The match is exact after lowercasing: a grant for
+/// `bob+work@example.com` is not Bob's, because only the mailbox owner of
+/// that exact address ever proved anything. A path the user already holds
+/// keeps its existing grant. Returns how many grants were attached.
+pub(crate) async fn attach_pending_grants(
+    ctx: &dyn Context,
+    user_id: &str,
+    email: &str,
+) -> Result<usize, WaferError> {
+    let email = email.trim().to_lowercase();
+    if user_id.is_empty() || email.is_empty() {
+        return Ok(0);
+    }
+    let mut attached = 0;
+    for pending in repo::acls::list_pending_for_email(ctx, &email).await? {
+        let (bucket, path) = (pending.str_field("bucket"), pending.str_field("path"));
+        let held = repo::acls::find_covering(ctx, bucket, user_id, &[path.to_string()]).await?;
+        if !held.is_empty() {
+            repo::acls::delete(ctx, &pending.id).await?;
+            continue;
+        }
+        let grant = repo::acls::attach(ctx, &pending.id, user_id).await?;
+        // The invitation email already went out; this is the inbox entry.
+        let sharer = display_user(ctx, grant.str_field("granted_by")).await;
+        notify_received(ctx, &grant, &sharer, false).await;
+        attached += 1;
+    }
+    Ok(attached)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        blocks::notifications,
+        test_support::{auth_msg, output_is_error, output_json, TestContext},
+    };
+
+    async fn seed_bucket(ctx: &TestContext, name: &str, owner: &str) {
+        let data = crate::util::json_map(serde_json::json!({
+            "name": name,
+            "public": false,
+            "created_by": owner,
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(ctx, data).await.expect("seed bucket");
+    }
+
+    fn acl_msg(action: &str, user: &str, bucket: &str) -> Message {
+        let mut msg = auth_msg(
+            action,
+            &format!("/b/storage/api/buckets/{bucket}/acl"),
+            user,
+        );
+        msg.set_meta("req.param.name", bucket);
+        msg
+    }
+
+    async fn grant(ctx: &TestContext, bucket: &str, body: serde_json::Value) -> serde_json::Value {
+        let msg = acl_msg("create", "alice", bucket);
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        output_json(handle_grant(ctx, &msg, bucket, input).await).await
+    }
+
+    #[test]
+    fn covering_paths_walks_parent_chain() {
+        assert_eq!(covering_paths(""), vec![""]);
+        assert_eq!(covering_paths("a.txt"), vec!["", "a.txt"]);
+        assert_eq!(
+            covering_paths("docs/q1/report.pdf"),
+            vec!["", "docs/", "docs/q1/", "docs/q1/report.pdf"]
+        );
+        // A folder path is its own last covering entry.
+        assert_eq!(covering_paths("docs/q1/"), vec!["", "docs/", "docs/q1/"]);
+    }
+
+    #[tokio::test]
+    async fn folder_grant_covers_descendants_only() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_user_id": "bob", "path": "docs/"}),
+        )
+        .await;
+
+        let bob = auth_msg("retrieve", "/b/storage/api/buckets/team/objects", "bob");
+        assert!(!is_access_denied(&ctx, &bob, "team", "docs/a.txt", Access::Read).await);
+        assert!(!is_access_denied(&ctx, &bob, "team", "docs/sub/b.txt", Access::Read).await);
+        assert!(is_access_denied(&ctx, &bob, "team", "private/c.txt", Access::Read).await);
+        // `docs` without the slash would also match `docs-secret/…`.
+        assert!(is_access_denied(&ctx, &bob, "team", "docs", Access::Read).await);
+        // Read grant doesn't allow writes.
+        assert!(is_access_denied(&ctx, &bob, "team", "docs/a.txt", Access::Write).await);
+
+        let carol = auth_msg("retrieve", "/b/storage/api/buckets/team/objects", "carol");
+        assert!(is_access_denied(&ctx, &carol, "team", "docs/a.txt", Access::Read).await);
+    }
+
+    #[tokio::test]
+    async fn only_folder_grants_authorize_listings() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_user_id": "bob", "path": "a"}),
+        )
+        .await;
+        grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_user_id": "bob", "path": "docs/"}),
+        )
+        .await;
+
+        let bob = auth_msg("retrieve", "/b/storage/api/buckets/team/objects", "bob");
+        // The exact-key grant reads `a` but lists nothing: `?prefix=a`
+        // would also show `ab` and `a/…`.
+        assert!(!is_access_denied(&ctx, &bob, "team", "a", Access::Read).await);
+        assert_eq!(listing_grants(&ctx, &bob, "team", "a").await, Some(vec![]));
+        assert_eq!(listing_grants(&ctx, &bob, "team", "").await, Some(vec![]));
+        assert_eq!(
+            listing_grants(&ctx, &bob, "team", "docs/q1/").await,
+            Some(vec!["docs/".to_string()])
+        );
+        let alice = auth_msg("retrieve", "/b/storage/api/buckets/team/objects", "alice");
+        assert_eq!(listing_grants(&ctx, &alice, "team", "a").await, None);
+    }
+
+    #[tokio::test]
+    async fn regrant_updates_permission_in_place() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let first = grant(&ctx, "team", serde_json::json!({"grantee_user_id": "bob"})).await;
+        let second = grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_user_id": "bob", "permission": "write"}),
+        )
+        .await;
+        assert_eq!(first["id"], second["id"]);
+        assert_eq!(second["data"]["permission"], "write");
+
+        let bob = auth_msg("create", "/b/storage/api/buckets/team/objects", "bob");
+        assert!(!is_access_denied(&ctx, &bob, "team", "any/key.txt", Access::Write).await);
+    }
+
+    async fn seed_user(ctx: &TestContext, email: &str) -> String {
+        use crate::blocks::auth::repo::users;
+        users::insert(
+            ctx,
+            users::NewUser {
+                email: email.into(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap()
+        .id
+    }
+
+    fn share_msg(user: &str, id: &str, verb: &str) -> Message {
+        let path = format!("/b/storage/api/shares/incoming/{id}/{verb}");
+        let mut msg = auth_msg("create", &path, user);
+        msg.set_meta("req.param.id", id);
+        msg
+    }
+
+    async fn incoming(ctx: &TestContext, user: &str) -> Vec<serde_json::Value> {
+        let msg = auth_msg("retrieve", "/b/storage/api/shares/incoming", user);
+        let body = output_json(handle_incoming(ctx, &msg).await).await;
+        body["grants"].as_array().unwrap().clone()
+    }
+
+    #[tokio::test]
+    async fn grantee_email_resolves_through_users_directory() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let bob = seed_user(&ctx, "bob@example.com").await;
+
+        let body = grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_email": "Bob@Example.com"}),
+        )
+        .await;
+        assert_eq!(body["data"]["grantee_user_id"], bob.as_str());
+        assert_eq!(body["data"]["status"], repo::acls::STATUS_RECEIVED);
+        assert_eq!(notifications::unread_count(&ctx, &bob).await.unwrap(), 1);
+
+        let body = grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_email": "not-an-address"}),
+        )
+        .await;
+        assert!(body["details"]["grantee_email"].is_string());
+    }
+
+    /// Grant listings name people by their directory profile, and the user
+    /// search finds accounts for a share dialog.
+    #[tokio::test]
+    async fn grants_carry_profiles_and_accounts_are_searchable() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let bob = seed_user(&ctx, "bob@example.com").await;
+        grant(&ctx, "team", serde_json::json!({"grantee_user_id": bob})).await;
+
+        let got = incoming(&ctx, &bob).await;
+        assert_eq!(got[0]["data"]["grantee"]["email"], "bob@example.com");
+        // The sharer has no account here, so no profile.
+        assert!(got[0]["data"]["shared_by"].is_null());
+
+        let search = |q: &str| {
+            let mut msg = auth_msg("retrieve", "/b/storage/api/users/search", "alice");
+            msg.set_meta("req.query.q", q);
+            msg
+        };
+        let body = output_json(handle_user_search(&ctx, &search("bob@")).await).await;
+        assert_eq!(body["users"][0]["id"], bob.as_str(), "{body}");
+        let body = output_json(handle_user_search(&ctx, &search("bob@exa")).await).await;
+        assert_eq!(body["users"][0]["id"], bob.as_str(), "{body}");
+        for q in ["bo", "bob", "example.com", "@example.com"] {
+            let body = output_json(handle_user_search(&ctx, &search(q)).await).await;
+            assert_eq!(body["code"], "validation_failed", "{q}");
+        }
+    }
+
+    #[tokio::test]
+    async fn pending_grant_attaches_when_the_exact_address_is_confirmed() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let body = grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_email": " Carol@Example.COM ", "path": "docs/"}),
+        )
+        .await;
+        assert_eq!(body["data"]["status"], repo::acls::STATUS_PENDING);
+        assert_eq!(body["data"]["grantee_email"], "carol@example.com");
+        assert_eq!(body["data"]["grantee_user_id"], "");
+
+        // The account registers with different casing; the match ignores it.
+        let carol = seed_user(&ctx, "CAROL@example.com").await;
+        let reader = auth_msg("retrieve", "/b/storage/api/buckets/team/objects", &carol);
+        assert!(is_access_denied(&ctx, &reader, "team", "docs/a.txt", Access::Read).await);
+        assert_eq!(
+            attach_pending_grants(&ctx, &carol, "CAROL@example.com")
+                .await
+                .unwrap(),
+            1
+        );
+        assert!(!is_access_denied(&ctx, &reader, "team", "docs/a.txt", Access::Read).await);
+        assert_eq!(incoming(&ctx, &carol).await.len(), 1);
+        assert_eq!(notifications::unread_count(&ctx, &carol).await.unwrap(), 1);
+
+        // Attached once; confirming again finds nothing left.
+        assert_eq!(
+            attach_pending_grants(&ctx, &carol, "carol@example.com")
+                .await
+                .unwrap(),
+            0
+        );
+    }
+
+    #[tokio::test]
+    async fn plus_addressing_does_not_match_pending_grants() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_email": "bob+work@example.com"}),
+        )
+        .await;
+        grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_email": "dave@example.com"}),
+        )
+        .await;
+
+        let bob = seed_user(&ctx, "bob@example.com").await;
+        assert_eq!(
+            attach_pending_grants(&ctx, &bob, "bob@example.com")
+                .await
+                .unwrap(),
+            0
+        );
+        let dave = seed_user(&ctx, "dave+x@example.com").await;
+        assert_eq!(
+            attach_pending_grants(&ctx, &dave, "dave+x@example.com")
+                .await
+                .unwrap(),
+            0
+        );
+
+        for user in [&bob, &dave] {
+            let msg = auth_msg("retrieve", "/b/storage/api/buckets/team/objects", user);
+            assert!(is_access_denied(&ctx, &msg, "team", "a.txt", Access::Read).await);
+        }
+        let pending = repo::acls::list_pending_for_email(&ctx, "bob+work@example.com").await;
+        assert_eq!(
+            pending.unwrap().len(),
+            1,
+            "still waiting for its own address"
+        );
+    }
+
+    #[tokio::test]
+    async fn pending_grant_for_a_path_already_held_is_dropped() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_email": "erin@example.com"}),
+        )
+        .await;
+        let erin = seed_user(&ctx, "erin@example.com").await;
+        grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_user_id": erin, "permission": "write"}),
+        )
+        .await;
+
+        assert_eq!(
+            attach_pending_grants(&ctx, &erin, "erin@example.com")
+                .await
+                .unwrap(),
+            0
+        );
+        let grants = repo::acls::list_for_bucket(&ctx, "team", None)
+            .await
+            .unwrap();
+        assert_eq!(grants.len(), 1);
+        assert_eq!(grants[0].str_field("permission"), "write");
+    }
+
+    #[tokio::test]
+    async fn accept_and_decline_answer_incoming_shares() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let kept = grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_user_id": "bob", "path": "a/"}),
+        )
+        .await;
+        let kept = kept["id"].as_str().unwrap().to_string();
+        let given_back = grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_user_id": "bob", "path": "b/"}),
+        )
+        .await;
+        let given_back = given_back["id"].as_str().unwrap().to_string();
+        assert_eq!(incoming(&ctx, "bob").await.len(), 2);
+
+        // Only the grantee answers; anyone else gets a 404.
+        let out = handle_decline(&ctx, &share_msg("mallory", &kept, "decline")).await;
+        assert!(output_is_error(out, "NotFound").await);
+
+        for _ in 0..2 {
+            let body =
+                output_json(handle_accept(&ctx, &share_msg("bob", &kept, "accept")).await).await;
+            assert_eq!(body["data"]["status"], repo::acls::STATUS_ACCEPTED);
+        }
+        let body =
+            output_json(handle_decline(&ctx, &share_msg("bob", &given_back, "decline")).await)
+                .await;
+        assert_eq!(body["declined"], true);
+
+        assert!(incoming(&ctx, "bob").await.is_empty());
+        let bob = auth_msg("retrieve", "/b/storage/api/buckets/team/objects", "bob");
+        assert!(!is_access_denied(&ctx, &bob, "team", "a/x.txt", Access::Read).await);
+        assert!(is_access_denied(&ctx, &bob, "team", "b/x.txt", Access::Read).await);
+        assert_eq!(notifications::unread_count(&ctx, "alice").await.unwrap(), 1);
+    }
+
+    #[tokio::test]
+    async fn only_owner_manages_grants() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+
+        let msg = acl_msg("create", "mallory", "team");
+        let body = serde_json::json!({"grantee_user_id": "mallory"});
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        let out = handle_grant(&ctx, &msg, "team", input).await;
+        assert!(output_is_error(out, "PermissionDenied").await);
+    }
+
+    #[tokio::test]
+    async fn invalid_grant_reports_fields() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let body = grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_user_id": "alice", "path": "../x", "permission": "own"}),
+        )
+        .await;
+        assert_eq!(body["code"], "validation_failed");
+        assert!(body["details"]["grantee_user_id"].is_string());
+        assert!(body["details"]["path"].is_string());
+        assert!(body["details"]["permission"].is_string());
+    }
+
+    #[tokio::test]
+    async fn shared_with_me_and_revoke() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let g = grant(
+            &ctx,
+            "team",
+            serde_json::json!({"grantee_user_id": "bob", "path": "docs/"}),
+        )
+        .await;
+        let id = g["id"].as_str().unwrap().to_string();
+
+        let bob = auth_msg("retrieve", "/b/storage/api/shared-with-me", "bob");
+        let listed = output_json(handle_shared_with_me(&ctx, &bob).await).await;
+        assert_eq!(listed["records"][0]["data"]["bucket"], "team");
+        assert_eq!(listed["records"][0]["data"]["path"], "docs/");
+
+        let mut revoke = acl_msg("delete", "alice", "team");
+        revoke.set_meta("req.param.id", &id);
+        output_json(handle_revoke(&ctx, &revoke, "team").await).await;
+
+        let listed = output_json(handle_shared_with_me(&ctx, &bob).await).await;
+        assert_eq!(listed["records"].as_array().map(Vec::len), Some(0));
+        assert!(is_access_denied(&ctx, &bob, "team", "docs/a.txt", Access::Read).await);
+    }
+}
@@ -0,0 +1,326 @@
+//! Admin object search: `GET /admin/storage/search`, reached as
+//! `/b/admin/api/storage/search` through the admin block's storage
+//! delegation.
+//!
+//! Finds objects across every user and bucket, for support and abuse
+//! handling ("a user says their file vanished", a takedown naming content
+//! by hash). Filters, all optional but at least one required:
+//!
+//! - `name` — substring of the object key, ignoring case
+//! - `sha256` — exact content checksum, answered from `idx_objects_sha256`
+//! - `owner` — the uploader's user id, or their email
+//! - `bucket`, `content_type` — exact
+//! - `min_size` / `max_size` — bytes, inclusive
+//! - `created_after` (inclusive) / `created_before` (exclusive) — a date
+//!   or RFC 3339 timestamp
+//!
+//! Pagination and `?sort=` (`created_at`, `size`, `key`) follow
+//! [`crate::pagination`]. Each match carries what an admin needs to act on
+//! it — id, bucket, key, owner (with email), size, checksum, status,
+//! `created_at` — but never content or a download link: acting on a result
+//! goes through the existing admin pages. Every search, including refused
+//! ones, is written to the audit log with its filters, so access to other
+//! people's files is accountable.
+
+use wafer_block::db::{Filter, FilterOp};
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::{checksum, repo};
+use crate::{
+    blocks::{admin::audit_log, directory, errors},
+    http::{err_internal, ok_json},
+    pagination::{self, ListQuery, ListSpec},
+    services::Services,
+    util::{escape_like, format_rfc3339, parse_timestamp, RecordExt},
+};
+
+const LIST_SPEC: ListSpec = ListSpec {
+    default_limit: 50,
+    max_limit: 200,
+    sortable: &["created_at", "size", "key"],
+    default_sort: "created_at",
+    default_desc: true,
+};
+
+/// Query parameters that narrow the search, in the order they are audited.
+const FILTER_PARAMS: &[&str] = &[
+    "name",
+    "sha256",
+    "owner",
+    "bucket",
+    "content_type",
+    "min_size",
+    "max_size",
+    "created_after",
+    "created_before",
+];
+
+/// Object columns a result carries.
+const RESULT_COLUMNS: &[&str] = &[
+    "bucket",
+    "key",
+    "size",
+    "content_type",
+    "sha256",
+    "status",
+    "uploaded_by",
+    "created_at",
+];
+
+/// The search's filters as a query string, for the audit log.
+fn audited_filters(msg: &Message) -> String {
+    let mut out = url::form_urlencoded::Serializer::new(String::new());
+    for param in FILTER_PARAMS {
+        let value = msg.query(param).trim();
+        if !value.is_empty() {
+            out.append_pair(param, value);
+        }
+    }
+    out.finish()
+}
+
+fn filter(field: &str, operator: FilterOp, value: serde_json::Value) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator,
+        value,
+    }
+}
+
+/// The filters every parameter but `owner` asks for, or the first invalid
+/// parameter and why.
+fn parse_filters(msg: &Message) -> Result<Vec<Filter>, (&'static str, &'static str)> {
+    let mut filters = Vec::new();
+    let name = msg.query("name").trim();
+    if !name.is_empty() {
+        let pattern = format!("%{}%", escape_like(&name.to_lowercase()));
+        filters.push(filter("key_lower", FilterOp::Like, pattern.into()));
+    }
+    match checksum::parse_expected(msg.query("sha256")) {
+        Ok(Some(sha256)) => filters.push(filter("sha256", FilterOp::Equal, sha256.into())),
+        Ok(None) => {}
+        Err(reason) => return Err(("sha256", reason)),
+    }
+    for field in ["bucket", "content_type"] {
+        let value = msg.query(field).trim();
+        if !value.is_empty() {
+            filters.push(filter(field, FilterOp::Equal, value.into()));
+        }
+    }
+    for (param, operator) in [
+        ("min_size", FilterOp::GreaterEqual),
+        ("max_size", FilterOp::LessEqual),
+    ] {
+        let raw = msg.query(param).trim();
+        if raw.is_empty() {
+            continue;
+        }
+        match raw.parse::<i64>() {
+            Ok(n) if n >= 0 => filters.push(filter("size", operator, n.into())),
+            _ => return Err((param, "must be a non-negative number of bytes")),
+        }
+    }
+    for (param, operator) in [
+        ("created_after", FilterOp::GreaterEqual),
+        ("created_before", FilterOp::LessThan),
+    ] {
+        let raw = msg.query(param).trim();
+        if raw.is_empty() {
+            continue;
+        }
+        match parse_timestamp(raw) {
+            Some(t) => filters.push(filter("created_at", operator, format_rfc3339(t).into())),
+            None => return Err((param, "must be a date or RFC 3339 timestamp")),
+        }
+    }
+    Ok(filters)
+}
+
+/// An empty page in the shape `query` asked for.
+fn empty_page(query: &ListQuery) -> serde_json::Value {
+    if query.envelope {
+        pagination::envelope(serde_json::json!([]), None, query.with_total.then_some(0))
+    } else {
+        serde_json::json!({
+            "records": [],
+            "total_count": 0,
+            "page": query.page,
+            "page_size": query.limit,
+        })
+    }
+}
+
+/// `GET /admin/storage/search`.
+pub(super) async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let filters = audited_filters(msg);
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "storage.search",
+        &format!("objects?{filters}"),
+        msg.remote_addr(),
+    )
+    .await;
+    if filters.is_empty() {
+        return errors::validation_error(
+            "Invalid search",
+            &[(
+                "filters",
+                "name at least one: name, sha256, owner, bucket, content_type, size or date",
+            )],
+        );
+    }
+    let query = match pagination::parse(msg, &LIST_SPEC) {
+        Ok(q) => q,
+        Err(e) => return e.response(),
+    };
+    let mut conditions = match parse_filters(msg) {
+        Ok(f) => f,
+        Err((param, reason)) => {
+            return errors::validation_error("Invalid search", &[(param, reason)])
+        }
+    };
+
+    let owner = msg.query("owner").trim();
+    if !owner.is_empty() {
+        let owner_id = if owner.contains('@') {
+            let services = Services::new(ctx, "suppers-ai/files");
+            match services.users().find_by_email(&owner.to_lowercase()).await {
+                Ok(Some(entry)) => entry.id,
+                // No such account: nothing of theirs to find.
+                Ok(None) => return ok_json(&empty_page(&query)),
+                Err(e) => return err_internal("Database error", e),
+            }
+        } else {
+            owner.to_string()
+        };
+        conditions.push(filter("uploaded_by", FilterOp::Equal, owner_id.into()));
+    }
+
+    let mut page = match pagination::fetch(ctx, repo::objects::TABLE, conditions, &query).await {
+        Ok(page) => page,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let records = page.records_mut();
+    let owners: Vec<String> = records
+        .iter()
+        .map(|r| r.str_field("uploaded_by").to_string())
+        .collect();
+    let ids: Vec<&str> = owners.iter().map(String::as_str).collect();
+    let profiles = directory::lookup(ctx, &ids).await;
+    for (record, owner_id) in records.iter_mut().zip(&owners) {
+        record
+            .data
+            .retain(|k, _| RESULT_COLUMNS.contains(&k.as_str()));
+        let email = profiles.get(owner_id).map(|p| p.email.as_str());
+        record.data.insert(
+            "owner".to_string(),
+            serde_json::json!({ "id": owner_id, "email": email }),
+        );
+    }
+    ok_json(&page.into_json(query.fields.as_deref()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::{super::blobs, *};
+    use crate::test_support::{admin_msg, output_json, TestContext};
+
+    fn search(params: &[(&str, &str)]) -> Message {
+        let mut msg = admin_msg("retrieve", "/admin/storage/search");
+        for (k, v) in params {
+            msg.set_meta(&format!("req.query.{k}"), v);
+        }
+        msg
+    }
+
+    async fn keys(ctx: &TestContext, params: &[(&str, &str)]) -> Vec<String> {
+        let body = output_json(handle(ctx, &search(params)).await).await;
+        body["records"]
+            .as_array()
+            .unwrap_or_else(|| panic!("{body}"))
+            .iter()
+            .map(|r| r["data"]["key"].as_str().unwrap().to_string())
+            .collect()
+    }
+
+    #[tokio::test]
+    async fn finds_objects_across_owners_by_each_filter() {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let bob = crate::blocks::auth::repo::users::insert(
+            &ctx,
+            crate::blocks::auth::repo::users::NewUser {
+                email: "bob@example.com".into(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap()
+        .id;
+        blobs::seed(
+            &ctx,
+            "a",
+            "Report.pdf",
+            b"report",
+            "application/pdf",
+            "alice",
+        )
+        .await;
+        blobs::seed(&ctx, "b", "photo.jpg", b"a photo", "image/jpeg", &bob).await;
+        blobs::seed(&ctx, "b", "copy.pdf", b"report", "application/pdf", &bob).await;
+
+        assert_eq!(keys(&ctx, &[("name", "report")]).await, ["Report.pdf"]);
+        let mut same = keys(&ctx, &[("sha256", &checksum::digest(b"report"))]).await;
+        same.sort();
+        assert_eq!(same, ["Report.pdf", "copy.pdf"]);
+        let mut bobs = keys(&ctx, &[("owner", "Bob@Example.com")]).await;
+        bobs.sort();
+        assert_eq!(bobs, ["copy.pdf", "photo.jpg"]);
+        assert!(keys(&ctx, &[("owner", "nobody@example.com")])
+            .await
+            .is_empty());
+        assert_eq!(
+            keys(&ctx, &[("bucket", "b"), ("content_type", "image/jpeg")]).await,
+            ["photo.jpg"]
+        );
+        assert_eq!(keys(&ctx, &[("min_size", "7")]).await, ["photo.jpg"]);
+        assert!(keys(&ctx, &[("created_before", "2000-01-01")])
+            .await
+            .is_empty());
+
+        // Results name the owner but carry no content or link.
+        let body = output_json(handle(&ctx, &search(&[("name", "photo")])).await).await;
+        let data = &body["records"][0]["data"];
+        assert_eq!(data["owner"]["email"], "bob@example.com");
+        assert_eq!(data["size"], 7);
+        assert!(data.get("storage_key").is_none());
+    }
+
+    #[tokio::test]
+    async fn every_search_is_audited_with_its_filters() {
+        let ctx = TestContext::with_files().await;
+        let body = output_json(handle(&ctx, &search(&[])).await).await;
+        assert_eq!(body["code"], "validation_failed");
+        let body = output_json(handle(&ctx, &search(&[("sha256", "abc")])).await).await;
+        assert_eq!(body["details"]["sha256"], checksum::INVALID_CHECKSUM);
+        handle(&ctx, &search(&[("name", "a b"), ("bucket", "x")])).await;
+
+        let audited: Vec<String> = wafer_core::clients::database::list_all(
+            &ctx,
+            crate::blocks::admin::AUDIT_LOGS_TABLE,
+            vec![],
+        )
+        .await
+        .unwrap()
+        .iter()
+        .filter(|r| r.str_field("action") == "storage.search")
+        .map(|r| r.str_field("resource").to_string())
+        .collect();
+        assert_eq!(audited.len(), 3);
+        assert!(audited.contains(&"objects?".to_string()));
+        assert!(audited.contains(&"objects?name=a+b&bucket=x".to_string()));
+    }
+}
@@ -0,0 +1,883 @@
+//! Folder upload as one zip: `POST /b/storage/api/buckets/{name}/upload-archive`
+//! (multipart or raw body, optional `?prefix=dir/`).
+//!
+//! The archive is expanded server-side: a zero-byte `dir/` marker object per
+//! directory (so empty folders survive), then every file through the same
+//! reserve → put → complete path as a single upload ([`storage::put_object`]),
+//! so quota usage counts each file as it lands.
+//!
+//! A zip's index sits at its end, so the body itself is buffered, capped at
+//! the space left in the storage quota and at [`MAX_ARCHIVE_BYTES`]; a
+//! multipart body is cut down to its file part in place. Entries are
+//! inflated one at a time into one reused buffer, never held together —
+//! the storage client takes whole objects, so one entry (at most the
+//! quota's file size) is the floor. Before anything is written the whole
+//! archive is vetted:
+//!
+//! - entry count ([`MAX_ENTRIES`]) and per-entry compression ratio
+//!   ([`MAX_RATIO`]) — zip bombs are rejected outright;
+//! - the declared uncompressed total must fit the remaining quota, else 413
+//!   with the size breakdown;
+//! - entries that escape the prefix (zip-slip: `..`, absolute or `\` paths),
+//!   symlinks, over-size entries, blocked extensions and paths under a
+//!   lock (`files::locks`) are skipped and reported, not fatal; a locked
+//!   `prefix` refuses the whole archive.
+//!
+//! With a malware scanner registered, each file is scanned as it is
+//! inflated, exactly like a single upload (see `files::scan`): infected
+//! entries are skipped and reported, large ones land as `pending_scan`.
+//!
+//! Each created object gets a `create` history event; one expansion's
+//! events share a batch id (see `files::history`).
+//!
+//! Inflation is bounded by each entry's declared size, so a lying header
+//! can't exceed what the quota check saw. A storage failure partway stops
+//! the run: what was written stays, and the summary says where it stopped
+//! (`complete: false`, `stopped_at`) so the client can retry the rest.
+//!
+//! An archive of more than [`SYNC_MAX_FILES`] files or [`SYNC_MAX_BYTES`]
+//! is vetted the same way but not expanded before the response: it is
+//! staged in storage and answered `202` with an operation to poll (see
+//! [`crate::blocks::operations`]). An [`EXPAND_JOB`] expands it, reporting
+//! entries done as progress; the operation's result is the summary above.
+
+use std::{
+    collections::BTreeSet,
+    io::{Cursor, Read},
+};
+
+use wafer_core::clients::{config, database::Record, storage as store};
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+use zip::ZipArchive;
+
+use super::{
+    acl::{self, Access},
+    history, locks, quota, repo,
+    scan::{self, Admission},
+    storage,
+};
+use crate::{
+    blocks::{
+        errors::{error_json, ErrorCode},
+        jobs::JobError,
+        operations::{self, Progress},
+    },
+    http::{err_bad_request, err_forbidden, err_internal, ok_json},
+    services::Services,
+};
+
+/// Largest archive body accepted, whatever the quota leaves: the body is
+/// held in memory while it is vetted.
+pub(crate) const MAX_ARCHIVE_BYTES: i64 = 512 * 1024 * 1024;
+
+/// Most entries (files and directories) one archive may hold.
+pub(crate) const MAX_ENTRIES: usize = 10_000;
+/// Highest uncompressed:compressed ratio accepted for one entry...
+pub(crate) const MAX_RATIO: u64 = 100;
+/// ...once it inflates past this size; small text files legitimately
+/// compress far better than that.
+const RATIO_FLOOR_BYTES: u64 = 1024 * 1024;
+
+/// Config key: comma-separated file extensions skipped when expanding an
+/// archive. Empty allows everything.
+pub(crate) const BLOCKED_EXTENSIONS_KEY: &str = "SUPPERS_AI__FILES__ARCHIVE_BLOCKED_EXTENSIONS";
+
+/// Content type of the zero-byte directory markers.
+const FOLDER_CONTENT_TYPE: &str = "application/x-directory";
+
+/// Most files expanded before the response; an archive with more, or
+/// more than [`SYNC_MAX_BYTES`], is expanded by an [`EXPAND_JOB`].
+const SYNC_MAX_FILES: usize = 200;
+/// Most uncompressed bytes expanded before the response.
+const SYNC_MAX_BYTES: u64 = 50 * 1024 * 1024;
+/// Job type expanding an archive staged by a large upload.
+pub const EXPAND_JOB: &str = "files.archive.expand";
+/// Operation kind of an expansion run as a job.
+const EXPAND_KIND: &str = "files.archive";
+/// Storage folder holding archives until their job expands them. Bucket
+/// names start with a letter or digit, so it is never a bucket's folder.
+const STAGING_FOLDER: &str = "_archives";
+
+/// Where an expansion writes, and on whose behalf.
+#[derive(Debug, Clone, serde::Serialize, serde::Deserialize)]
+struct Target {
+    bucket: String,
+    prefix: String,
+    user_id: String,
+    team_id: String,
+}
+
+/// Payload of an [`EXPAND_JOB`].
+#[derive(Debug, serde::Serialize, serde::Deserialize)]
+struct ExpandJob {
+    #[serde(flatten)]
+    target: Target,
+    /// Key of the archive in [`STAGING_FOLDER`].
+    staged: String,
+}
+
+#[derive(Debug, Default, serde::Serialize)]
+struct Summary {
+    bucket: String,
+    prefix: String,
+    folders: Vec<String>,
+    files: Vec<CreatedFile>,
+    skipped: Vec<Skipped>,
+    /// False when a storage failure stopped the run early.
+    complete: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    stopped_at: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<String>,
+}
+
+#[derive(Debug, serde::Serialize)]
+struct CreatedFile {
+    key: String,
+    size: u64,
+}
+
+#[derive(Debug, serde::Serialize)]
+struct Skipped {
+    entry: String,
+    reason: String,
+}
+
+/// A file entry that passed vetting.
+#[derive(Debug)]
+struct FileEntry {
+    index: usize,
+    name: String,
+    key: String,
+    size: u64,
+}
+
+/// The archive's plan: what gets written, and what was skipped and why.
+#[derive(Debug, Default)]
+struct Plan {
+    folders: BTreeSet<String>,
+    files: Vec<FileEntry>,
+    skipped: Vec<Skipped>,
+}
+
+impl Plan {
+    fn total_bytes(&self) -> u64 {
+        self.files.iter().map(|f| f.size).sum()
+    }
+}
+
+/// The storage-relative path of an entry, or `None` when it would escape
+/// the upload prefix or isn't a plain relative path. Directory entries keep
+/// no trailing slash.
+fn entry_path(name: &str) -> Option<&str> {
+    let path = name.strip_suffix('/').unwrap_or(name);
+    let plain = !path.is_empty()
+        && !path.contains('\\')
+        && !path.contains('\0')
+        && path
+            .split('/')
+            .all(|seg| !seg.is_empty() && seg != "." && seg != "..");
+    plain.then_some(path)
+}
+
+fn parse_blocked(raw: &str) -> Vec<String> {
+    raw.split(',')
+        .map(|ext| ext.trim().trim_start_matches('.').to_ascii_lowercase())
+        .filter(|ext| !ext.is_empty())
+        .collect()
+}
+
+fn is_blocked(path: &str, blocked: &[String]) -> bool {
+    std::path::Path::new(path)
+        .extension()
+        .and_then(|ext| ext.to_str())
+        .is_some_and(|ext| blocked.iter().any(|b| b.eq_ignore_ascii_case(ext)))
+}
+
+/// Vet every entry from the central directory without inflating anything.
+/// `Err` rejects the whole archive.
+fn plan(
+    archive: &mut ZipArchive<Cursor<&[u8]>>,
+    prefix: &str,
+    max_file_size: i64,
+    blocked: &[String],
+) -> Result<Plan, String> {
+    if archive.len() > MAX_ENTRIES {
+        return Err(format!("Archive has more than {MAX_ENTRIES} entries"));
+    }
+    let mut plan = Plan::default();
+    for index in 0..archive.len() {
+        let entry = archive
+            .by_index_raw(index)
+            .map_err(|e| format!("Unreadable archive entry: {e}"))?;
+        let name = entry.name().to_string();
+        let (size, compressed) = (entry.size(), entry.compressed_size());
+        if size > RATIO_FLOOR_BYTES && size > compressed.saturating_mul(MAX_RATIO) {
+            return Err(format!(
+                "Entry {name} expands more than {MAX_RATIO}x; refusing a likely zip bomb"
+            ));
+        }
+        let mut skip = |reason: &str| {
+            plan.skipped.push(Skipped {
+                entry: name.clone(),
+                reason: reason.to_string(),
+            })
+        };
+        let Some(path) = entry_path(&name) else {
+            skip("path escapes the upload folder");
+            continue;
+        };
+        let key = format!("{prefix}{path}");
+        if !storage::is_valid_storage_key(&key) {
+            skip("invalid object key");
+            continue;
+        }
+        // Parent directories of every entry become folders too.
+        let mut end = 0;
+        while let Some(slash) = key[end..].find('/') {
+            end += slash + 1;
+            if end > prefix.len() {
+                plan.folders.insert(key[..end].to_string());
+            }
+        }
+        if entry.is_dir() {
+            plan.folders.insert(format!("{key}/"));
+            continue;
+        }
+        if entry
+            .unix_mode()
+            .is_some_and(|mode| mode & 0o170000 == 0o120000)
+        {
+            skip("symbolic links are not supported");
+        } else if entry.encrypted() {
+            skip("encrypted entries are not supported");
+        } else if size > max_file_size.max(0) as u64 {
+            skip(&format!(
+                "exceeds maximum file size of {max_file_size} bytes"
+            ));
+        } else if is_blocked(&key, blocked) {
+            skip("file extension not allowed");
+        } else {
+            plan.files.push(FileEntry {
+                index,
+                name,
+                key,
+                size,
+            });
+        }
+    }
+    Ok(plan)
+}
+
+/// Inflate one file entry into `content` (cleared first, so one buffer
+/// serves the whole expansion), reading at most its declared size so a
+/// header that under-reports can't write more than the quota check allowed.
+fn inflate(
+    archive: &mut ZipArchive<Cursor<&[u8]>>,
+    file: &FileEntry,
+    content: &mut Vec<u8>,
+) -> Result<(), String> {
+    let entry = archive
+        .by_index(file.index)
+        .map_err(|e| format!("unreadable entry: {e}"))?;
+    content.clear();
+    content.reserve(file.size as usize);
+    entry
+        .take(file.size + 1)
+        .read_to_end(content)
+        .map_err(|e| format!("corrupt entry: {e}"))?;
+    if content.len() as u64 != file.size {
+        return Err("entry size does not match its header".to_string());
+    }
+    Ok(())
+}
+
+pub(super) async fn handle_upload(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    input: InputStream,
+) -> OutputStream {
+    if bucket.is_empty() {
+        return err_bad_request("Missing bucket name");
+    }
+    let prefix = msg.query("prefix").to_string();
+    if !prefix.is_empty() && (!prefix.ends_with('/') || !storage::is_valid_storage_key(&prefix)) {
+        return err_bad_request("Invalid prefix (expected a folder path ending in '/')");
+    }
+    if acl::is_access_denied(ctx, msg, bucket, &prefix, Access::Write).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let locks = match locks::LockSet::load(ctx, bucket).await {
+        Ok(locks) => locks,
+        Err(e) => return err_internal("Database error", e),
+    };
+    if let Some(lock) = locks.covering(&prefix) {
+        return lock.response();
+    }
+    let team_id = match repo::buckets::team_of(ctx, bucket).await {
+        Ok(team_id) => team_id.unwrap_or_default(),
+        Err(e) => return err_internal("Database error", e),
+    };
+    quota::sweep_stale_pending(ctx, msg.user_id(), 3600).await;
+    let (limits, used_bytes, file_count) = quota::space_usage(ctx, msg.user_id(), &team_id).await;
+
+    // No archive bigger than the space left can fit once expanded.
+    let available = (limits.max_storage_bytes - used_bytes).max(0);
+    let too_large = |message: &str| {
+        error_json(
+            ErrorCode::QuotaExceeded,
+            message,
+            Some(serde_json::json!({
+                "used_bytes": used_bytes,
+                "limit_bytes": limits.max_storage_bytes,
+                "available_bytes": available,
+                "max_archive_bytes": MAX_ARCHIVE_BYTES,
+            })),
+        )
+    };
+    if available == 0 {
+        return too_large("The storage quota is used up");
+    }
+    let Ok(body) = crate::util::collect_with_cap(input, available.min(MAX_ARCHIVE_BYTES)).await
+    else {
+        return too_large(if available < MAX_ARCHIVE_BYTES {
+            "Archive is larger than the storage space left"
+        } else {
+            "Archive is larger than the upload limit"
+        });
+    };
+    let content_type = msg.get_meta("req.content_type").to_string();
+    let body = if crate::multipart::multipart_boundary(&content_type).is_some() {
+        match crate::multipart::into_multipart_file_content(body, &content_type) {
+            Some(content) => content,
+            None => return err_bad_request("Multipart body contains no file part"),
+        }
+    } else {
+        body
+    };
+    let Ok(mut archive) = ZipArchive::new(Cursor::new(body.as_slice())) else {
+        return err_bad_request("Body is not a zip archive");
+    };
+
+    let blocked = parse_blocked(&config::get_default(ctx, BLOCKED_EXTENSIONS_KEY, "").await);
+    let plan = match plan(&mut archive, &prefix, limits.max_file_size_bytes, &blocked) {
+        Ok(plan) => plan,
+        Err(reason) => return err_bad_request(&reason),
+    };
+
+    let archive_bytes = plan.total_bytes() as i64;
+    if used_bytes.saturating_add(archive_bytes) > limits.max_storage_bytes {
+        return error_json(
+            ErrorCode::QuotaExceeded,
+            "Archive contents exceed the storage quota",
+            Some(serde_json::json!({
+                "archive_bytes": archive_bytes,
+                "used_bytes": used_bytes,
+                "limit_bytes": limits.max_storage_bytes,
+                "available_bytes": (limits.max_storage_bytes - used_bytes).max(0),
+            })),
+        );
+    }
+    if limits.max_files_per_bucket > 0
+        && file_count + plan.files.len() as i64 > limits.max_files_per_bucket
+    {
+        return error_json(
+            ErrorCode::QuotaExceeded,
+            &format!(
+                "Archive would exceed the file count limit (max {})",
+                limits.max_files_per_bucket
+            ),
+            Some(serde_json::json!({
+                "archive_files": plan.files.len(),
+                "file_count": file_count,
+                "limit_files": limits.max_files_per_bucket,
+            })),
+        );
+    }
+
+    let target = Target {
+        bucket: bucket.to_string(),
+        prefix,
+        user_id: msg.user_id().to_string(),
+        team_id,
+    };
+    if plan.files.len() > SYNC_MAX_FILES || plan.total_bytes() > SYNC_MAX_BYTES {
+        return start_expansion(ctx, msg, target, &body, &plan).await;
+    }
+    ok_json(&expand(ctx, &target, &locks, &mut archive, plan, None).await)
+}
+
+/// Stage `body` and hand its expansion to an [`EXPAND_JOB`], answering
+/// `202` with the operation to poll.
+async fn start_expansion(
+    ctx: &dyn Context,
+    msg: &Message,
+    target: Target,
+    body: &[u8],
+    plan: &Plan,
+) -> OutputStream {
+    let staged = crate::clock::new_id();
+    if let Err(e) = store::put(ctx, STAGING_FOLDER, &staged, body, "application/zip").await {
+        return err_internal("Failed to stage the archive", e);
+    }
+    let accepted = serde_json::json!({
+        "bucket": target.bucket,
+        "prefix": target.prefix,
+        "folders": plan.folders.len(),
+        "files": plan.files.len(),
+        "bytes": plan.total_bytes(),
+    });
+    let job = ExpandJob {
+        target,
+        staged: staged.clone(),
+    };
+    let payload = serde_json::to_value(job).unwrap_or_default();
+    let services = Services::new(ctx, "suppers-ai/files");
+    match services
+        .operations()
+        .start(msg, EXPAND_KIND, EXPAND_JOB, payload)
+        .await
+    {
+        Ok(op) => operations::accepted(&op, accepted),
+        Err(e) => {
+            discard_staged(ctx, &staged).await;
+            err_internal("Failed to queue the archive expansion", e)
+        }
+    }
+}
+
+async fn discard_staged(ctx: &dyn Context, staged: &str) {
+    if let Err(e) = store::delete(ctx, STAGING_FOLDER, staged).await {
+        tracing::warn!(staged, error = %e, "failed to remove a staged archive");
+    }
+}
+
+/// Run an [`EXPAND_JOB`]: expand the staged archive, reporting to its
+/// operation, then remove the staged copy. The vetting is redone against
+/// the current locks and limits; the quota was checked at upload and each
+/// file still reserves its own space as it lands.
+pub(super) async fn run_expand_job(ctx: &dyn Context, input: InputStream) -> Result<(), JobError> {
+    let raw: serde_json::Value = serde_json::from_slice(&input.collect_to_bytes().await)
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+    let job: ExpandJob = serde_json::from_value(raw.clone())
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+    let progress = Progress::begin(ctx, &raw).await;
+    if progress.already_done() {
+        discard_staged(ctx, &job.staged).await;
+        return Ok(());
+    }
+    let result = expand_staged(ctx, &job, progress).await;
+    // A transient failure keeps the copy for the retry.
+    if result.as_ref().map_or_else(|e| e.permanent, |_| true) {
+        discard_staged(ctx, &job.staged).await;
+    }
+    result
+}
+
+async fn expand_staged(
+    ctx: &dyn Context,
+    job: &ExpandJob,
+    mut progress: Progress<'_>,
+) -> Result<(), JobError> {
+    let target = &job.target;
+    let body = match store::get(ctx, STAGING_FOLDER, &job.staged).await {
+        Ok((body, _)) => body,
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => {
+            return Err(JobError::permanent("the staged archive is gone"));
+        }
+        Err(e) => return Err(e.into()),
+    };
+    let mut archive = ZipArchive::new(Cursor::new(body.as_slice()))
+        .map_err(|e| JobError::permanent(format!("unreadable archive: {e}")))?;
+    let locks = locks::LockSet::load(ctx, &target.bucket).await?;
+    let (limits, _, _) = quota::space_usage(ctx, &target.user_id, &target.team_id).await;
+    let blocked = parse_blocked(&config::get_default(ctx, BLOCKED_EXTENSIONS_KEY, "").await);
+    let plan = plan(
+        &mut archive,
+        &target.prefix,
+        limits.max_file_size_bytes,
+        &blocked,
+    )
+    .map_err(JobError::permanent)?;
+    let summary = expand(ctx, target, &locks, &mut archive, plan, Some(&mut progress)).await;
+    progress
+        .succeed(&serde_json::to_value(&summary).unwrap_or_default())
+        .await;
+    Ok(())
+}
+
+/// Write a vetted `plan` into `target`: folder markers first, then each
+/// file as it is inflated and scanned.
+async fn expand(
+    ctx: &dyn Context,
+    target: &Target,
+    locks: &locks::LockSet,
+    archive: &mut ZipArchive<Cursor<&[u8]>>,
+    plan: Plan,
+    mut progress: Option<&mut Progress<'_>>,
+) -> Summary {
+    let Target {
+        bucket,
+        user_id,
+        team_id,
+        ..
+    } = target;
+    let total = (plan.folders.len() + plan.files.len()) as u64;
+    let mut processed = 0;
+    let mut summary = Summary {
+        bucket: bucket.clone(),
+        prefix: target.prefix.clone(),
+        skipped: plan.skipped,
+        complete: true,
+        ..Default::default()
+    };
+    let stop = |summary: &mut Summary, at: &str, what: &str, e: wafer_run::WaferError| {
+        tracing::warn!(error = %e, bucket = %bucket, entry = at, "archive expansion stopped");
+        summary.complete = false;
+        summary.stopped_at = Some(at.to_string());
+        summary.error = Some(what.to_string());
+    };
+
+    // Every object the expansion creates shares one history batch.
+    let batch_id = history::new_batch_id();
+    let created = |row: &Record| history::Event::created(row, user_id).batch(&batch_id);
+    let mut content = Vec::new();
+    for folder in &plan.folders {
+        processed += 1;
+        if let Some(progress) = progress.as_mut() {
+            progress.update(processed, total).await;
+        }
+        if let Some(lock) = locks.covering(folder) {
+            summary.skipped.push(Skipped {
+                entry: folder.clone(),
+                reason: lock.message(),
+            });
+            continue;
+        }
+        // Listings read object rows, so a marker needs one; a folder that
+        // already has its marker keeps it.
+        let marker = match repo::objects::find_by_bucket_key(ctx, bucket, folder).await {
+            Ok(Some(_)) => Ok(None),
+            Ok(None) => storage::put_object(
+                ctx,
+                bucket,
+                folder,
+                &[],
+                FOLDER_CONTENT_TYPE,
+                user_id,
+                team_id,
+                false,
+            )
+            .await
+            .map(Some)
+            .map_err(|(_, e)| e),
+            Err(e) => Err(e),
+        };
+        match marker {
+            Ok(Some(row)) => history::record(ctx, created(&row)).await,
+            Ok(None) => {}
+            Err(e) => {
+                stop(&mut summary, folder, "Failed to create folder", e);
+                return summary;
+            }
+        }
+        summary.folders.push(folder.clone());
+    }
+    for file in &plan.files {
+        processed += 1;
+        if let Some(progress) = progress.as_mut() {
+            progress.update(processed, total).await;
+        }
+        if let Some(lock) = locks.covering(&file.key) {
+            summary.skipped.push(Skipped {
+                entry: file.name.clone(),
+                reason: lock.message(),
+            });
+            continue;
+        }
+        if let Err(reason) = inflate(archive, file, &mut content) {
+            summary.skipped.push(Skipped {
+                entry: file.name.clone(),
+                reason,
+            });
+            continue;
+        }
+        let held = match scan::admit(ctx, &content, &file.key).await {
+            Admission::Store => false,
+            Admission::Hold => true,
+            Admission::Reject { signature } => {
+                summary.skipped.push(Skipped {
+                    entry: file.name.clone(),
+                    reason: format!("rejected by the malware scanner ({signature})"),
+                });
+                continue;
+            }
+        };
+        let file_type = wafer_core::mime::mime_for_ext(std::path::Path::new(&file.key));
+        let stored = storage::put_object(
+            ctx, bucket, &file.key, &content, file_type, user_id, team_id, held,
+        )
+        .await;
+        match stored {
+            Ok(row) => history::record(ctx, created(&row)).await,
+            Err((what, e)) => {
+                stop(&mut summary, &file.name, what, e);
+                break;
+            }
+        }
+        summary.files.push(CreatedFile {
+            key: file.key.clone(),
+            size: file.size,
+        });
+    }
+
+    if !summary.files.is_empty() {
+        storage::after_upload(ctx, user_id, team_id).await;
+    }
+    summary
+}
+
+#[cfg(test)]
+mod tests {
+    use std::io::Write;
+
+    use zip::{write::SimpleFileOptions, CompressionMethod, ZipWriter};
+
+    use super::*;
+    use crate::test_support::{auth_msg, output_json, output_status, TestContext};
+
+    fn zip_of(entries: &[(&str, &[u8])]) -> Vec<u8> {
+        let mut zip = ZipWriter::new(Cursor::new(Vec::new()));
+        let opts = SimpleFileOptions::default().compression_method(CompressionMethod::Deflated);
+        for (name, content) in entries {
+            if name.ends_with('/') {
+                zip.add_directory(*name, opts).unwrap();
+            } else {
+                zip.start_file(*name, opts).unwrap();
+                zip.write_all(content).unwrap();
+            }
+        }
+        zip.finish().unwrap().into_inner()
+    }
+
+    async fn ctx_with_bucket() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let data = crate::util::json_map(serde_json::json!({
+            "name": "docs",
+            "public": false,
+            "created_by": "alice",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(&ctx, data).await.unwrap();
+        ctx
+    }
+
+    fn upload_msg(prefix: &str) -> Message {
+        let mut msg = auth_msg(
+            "create",
+            "/b/storage/api/buckets/docs/upload-archive",
+            "alice",
+        );
+        msg.set_meta("req.param.name", "docs");
+        msg.set_meta("req.content_type", "application/zip");
+        if !prefix.is_empty() {
+            msg.set_meta("req.query.prefix", prefix);
+        }
+        msg
+    }
+
+    #[test]
+    fn entry_path_rejects_escapes() {
+        assert_eq!(entry_path("a/b.txt"), Some("a/b.txt"));
+        assert_eq!(entry_path("a/b/"), Some("a/b"));
+        assert_eq!(entry_path("../etc/passwd"), None);
+        assert_eq!(entry_path("a/../../b"), None);
+        assert_eq!(entry_path("/abs"), None);
+        assert_eq!(entry_path("a\\..\\b"), None);
+        assert_eq!(entry_path("a//b"), None);
+        assert_eq!(entry_path("./a"), None);
+    }
+
+    #[test]
+    fn blocked_extensions_match_case_insensitively() {
+        let blocked = parse_blocked(" .EXE, bat ,,");
+        assert_eq!(blocked, ["exe", "bat"]);
+        assert!(is_blocked("tools/setup.Exe", &blocked));
+        assert!(!is_blocked("notes.txt", &blocked));
+        assert!(!is_blocked("exe", &blocked));
+    }
+
+    #[tokio::test]
+    async fn expands_tree_with_folders_and_reports_skips() {
+        let ctx = ctx_with_bucket().await;
+        let body = zip_of(&[
+            ("site/", b""),
+            ("site/empty/", b""),
+            ("site/css/main.css", b"body{}"),
+            ("site/index.html", b"<p>hi</p>"),
+            ("../escape.txt", b"nope"),
+        ]);
+        let out = handle_upload(
+            &ctx,
+            &upload_msg("up/"),
+            "docs",
+            InputStream::from_bytes(body),
+        )
+        .await;
+        let summary = output_json(out).await;
+
+        assert_eq!(summary["complete"], true, "{summary}");
+        assert_eq!(
+            summary["folders"],
+            serde_json::json!(["up/site/", "up/site/css/", "up/site/empty/"])
+        );
+        let keys: Vec<&str> = summary["files"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|f| f["key"].as_str().unwrap())
+            .collect();
+        assert_eq!(keys, ["up/site/css/main.css", "up/site/index.html"]);
+        assert_eq!(summary["skipped"][0]["entry"], "../escape.txt");
+
+        let (stored, content_type) = super::super::blobs::get(&ctx, "docs", "up/site/index.html")
+            .await
+            .unwrap();
+        assert_eq!(stored, b"<p>hi</p>");
+        assert_eq!(content_type, "text/html");
+        let rows = repo::objects::list_all(&ctx).await.unwrap();
+        assert_eq!(rows.len(), 5, "one object row per file and folder marker");
+    }
+
+    #[tokio::test]
+    async fn rejects_archive_over_quota_with_breakdown() {
+        let ctx = ctx_with_bucket().await;
+        let quota = crate::util::json_map(serde_json::json!({
+            "user_id": "alice",
+            "max_storage_bytes": 1000,
+            "max_file_size_bytes": 1000,
+        }));
+        repo::quota::seed(&ctx, quota).await.unwrap();
+        // Compresses well below the 1000-byte body cap; expands past it.
+        let text = vec![b'a'; 600];
+        let body = zip_of(&[("a.txt", &text), ("b.txt", &text)]);
+        assert!(body.len() < 1000);
+
+        let out = handle_upload(&ctx, &upload_msg(""), "docs", InputStream::from_bytes(body)).await;
+        let resp = output_json(out).await;
+        assert_eq!(resp["code"], "quota_exceeded");
+        assert_eq!(resp["details"]["archive_bytes"], 1200);
+        assert_eq!(resp["details"]["available_bytes"], 1000);
+        assert!(repo::objects::list_all(&ctx).await.unwrap().is_empty());
+    }
+
+    #[tokio::test]
+    async fn body_is_capped_at_the_space_left() {
+        let ctx = ctx_with_bucket().await;
+        let quota = crate::util::json_map(serde_json::json!({
+            "user_id": "alice",
+            "max_storage_bytes": 1000,
+            "max_file_size_bytes": 1000,
+        }));
+        repo::quota::seed(&ctx, quota).await.unwrap();
+        // Pseudo-random, so it barely compresses.
+        let mut seed: u32 = 1;
+        let noise: Vec<u8> = (0..700)
+            .map(|_| {
+                seed = seed.wrapping_mul(1_103_515_245).wrapping_add(12_345) & 0x7fff_ffff;
+                (seed >> 16) as u8
+            })
+            .collect();
+        let first = zip_of(&[("a.bin", &noise)]);
+        assert!(first.len() < 1000);
+        let out = handle_upload(
+            &ctx,
+            &upload_msg(""),
+            "docs",
+            InputStream::from_bytes(first),
+        )
+        .await;
+        assert_eq!(output_json(out).await["complete"], true);
+
+        // The same archive again no longer fits in the 300 bytes left.
+        let second = zip_of(&[("b.bin", &noise)]);
+        let out = handle_upload(
+            &ctx,
+            &upload_msg(""),
+            "docs",
+            InputStream::from_bytes(second),
+        )
+        .await;
+        let resp = output_json(out).await;
+        assert_eq!(resp["code"], "quota_exceeded");
+        assert_eq!(resp["details"]["available_bytes"], 300);
+    }
+
+    #[tokio::test]
+    async fn rejects_high_ratio_entries_and_garbage() {
+        let ctx = ctx_with_bucket().await;
+        let bomb = vec![0u8; 4 * RATIO_FLOOR_BYTES as usize];
+        let body = zip_of(&[("zeros.bin", &bomb)]);
+        let out = handle_upload(&ctx, &upload_msg(""), "docs", InputStream::from_bytes(body)).await;
+        assert_eq!(output_status(out).await, 400);
+
+        let out = handle_upload(
+            &ctx,
+            &upload_msg(""),
+            "docs",
+            InputStream::from_bytes(b"not a zip".to_vec()),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 400);
+    }
+
+    #[tokio::test]
+    async fn large_archives_expand_in_a_job_reporting_to_an_operation() {
+        use wafer_core::clients::database as db;
+
+        use crate::{
+            blocks::admin::{JOBS_TABLE, OPERATIONS_TABLE},
+            util::RecordExt,
+        };
+
+        let ctx = ctx_with_bucket().await;
+        let names: Vec<String> = (0..=SYNC_MAX_FILES)
+            .map(|i| format!("bulk/f{i}.txt"))
+            .collect();
+        let entries: Vec<(&str, &[u8])> = names.iter().map(|n| (n.as_str(), &b"x"[..])).collect();
+        let body = zip_of(&entries);
+
+        let out = handle_upload(&ctx, &upload_msg(""), "docs", InputStream::from_bytes(body)).await;
+        let accepted = output_json(out).await;
+        assert_eq!(accepted["files"], SYNC_MAX_FILES + 1, "{accepted}");
+        let op_id = accepted["operation"]["id"].as_str().unwrap().to_string();
+        assert!(repo::objects::list_all(&ctx).await.unwrap().is_empty());
+
+        let jobs = db::list_all(&ctx, JOBS_TABLE, Vec::new()).await.unwrap();
+        assert_eq!(jobs[0].str_field("job_type"), EXPAND_JOB);
+        let payload: serde_json::Value =
+            serde_json::from_str(jobs[0].str_field("payload")).unwrap();
+        let input = InputStream::from_bytes(serde_json::to_vec(&payload).unwrap());
+        run_expand_job(&ctx, input).await.unwrap();
+
+        let op = db::get(&ctx, OPERATIONS_TABLE, &op_id).await.unwrap();
+        assert_eq!(op.str_field("status"), operations::STATUS_SUCCEEDED);
+        // One folder marker and every file.
+        let entries = (SYNC_MAX_FILES + 2) as i64;
+        assert_eq!(op.i64_field("processed"), entries);
+        assert_eq!(op.i64_field("total"), entries);
+        let summary: serde_json::Value = serde_json::from_str(op.str_field("result")).unwrap();
+        assert_eq!(summary["complete"], true);
+        assert_eq!(
+            summary["files"].as_array().unwrap().len(),
+            SYNC_MAX_FILES + 1
+        );
+        let rows = repo::objects::list_all(&ctx).await.unwrap();
+        assert_eq!(rows.len() as i64, entries);
+
+        let staged = payload["staged"].as_str().unwrap();
+        assert!(store::get(&ctx, STAGING_FOLDER, staged).await.is_err());
+    }
+}
@@ -0,0 +1,355 @@
+//! Where object bytes live in storage.
+//!
+//! A blob is stored in its bucket's storage folder under
+//! `{first two id chars}/{id}` of its object row ([`repo::objects::blob_key`],
+//! recorded in the row's `storage_key`), whatever the object is called. The
+//! key users see is row metadata: a rename or move rewrites the row and
+//! never touches storage, and two paths can't collide on disk. Listings,
+//! moves and bucket deletion read the rows, not the storage folder.
+//!
+//! Rows from before this layout have an empty `storage_key` and their blob
+//! at the object key. Reads fall back to it ([`locate`]), and
+//! [`RELAYOUT_JOB`] — queued on every init — copies those blobs into the id
+//! layout a batch at a time. Each row records its own progress, so the job
+//! is idempotent and resumes wherever a restart left it; a move of a legacy
+//! object relays it out first.
+//!
+//! Blobs no row points at, and rows whose blob is gone, are found by the
+//! consistency check (`files::consistency`).
+
+use wafer_core::clients::{database::Record, storage as store};
+use wafer_run::{context::Context, ErrorCode, WaferError};
+
+use super::repo;
+use crate::{blocks::jobs::JobError, services::Services, util::RecordExt};
+
+/// Job type moving legacy blobs into the id layout, [`RELAYOUT_BATCH`] rows
+/// per run.
+pub const RELAYOUT_JOB: &str = "files.blobs.relayout";
+
+/// Legacy rows one relayout run moves before queueing the next.
+const RELAYOUT_BATCH: i64 = 100;
+
+/// The row id an id-layout storage key names, or `None` for any other key
+/// (a legacy object key, or a folder marker).
+pub(super) fn blob_id(storage_key: &str) -> Option<&str> {
+    let (dir, id) = storage_key.split_once('/')?;
+    (dir.len() == 2 && id.starts_with(dir) && uuid::Uuid::parse_str(id).is_ok()).then_some(id)
+}
+
+/// The storage key holding the bytes of `(bucket, key)`: its row's
+/// `storage_key`, or the key itself for a legacy or row-less blob.
+pub async fn locate(ctx: &dyn Context, bucket: &str, key: &str) -> Result<String, WaferError> {
+    Ok(
+        match repo::objects::find_by_bucket_key(ctx, bucket, key).await? {
+            Some(row) => repo::objects::storage_key(&row).to_string(),
+            None => key.to_string(),
+        },
+    )
+}
+
+/// The bytes and content type of the object at `(bucket, key)`.
+pub async fn get(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+) -> Result<(Vec<u8>, String), WaferError> {
+    let (data, content_type) = read(ctx, bucket, &locate(ctx, bucket, key).await?).await?;
+    Ok((data, content_type))
+}
+
+/// Read the blob at `storage_key`. A read that ends short of the size the
+/// provider reported is an error, not a truncated object: nothing has been
+/// sent yet, so the request fails cleanly instead of serving (and counting)
+/// a cut-off body.
+async fn read(
+    ctx: &dyn Context,
+    bucket: &str,
+    storage_key: &str,
+) -> Result<(Vec<u8>, String), WaferError> {
+    let (data, info) = store::get(ctx, bucket, storage_key).await?;
+    if data.len() as i64 != info.size {
+        tracing::warn!(
+            bucket = %bucket,
+            key = %storage_key,
+            read = data.len(),
+            size = info.size,
+            "short blob read"
+        );
+        return Err(WaferError::new(
+            ErrorCode::Internal,
+            "blob read ended before the object's size",
+        ));
+    }
+    Ok((data, info.content_type))
+}
+
+/// [`get`], plus the id of the object's row (`None` for a row-less blob),
+/// for a caller that counts the download once it is served.
+pub async fn get_with_id(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+) -> Result<(Vec<u8>, String, Option<String>), WaferError> {
+    let row = repo::objects::find_by_bucket_key(ctx, bucket, key).await?;
+    let blob = row.as_ref().map_or(key, repo::objects::storage_key);
+    let (data, content_type) = read(ctx, bucket, blob).await?;
+    Ok((data, content_type, row.map(|row| row.id)))
+}
+
+/// Whether the blob of row `id` is stored in `bucket`'s id layout.
+pub async fn exists(ctx: &dyn Context, bucket: &str, id: &str) -> Result<bool, WaferError> {
+    stored(ctx, bucket, &repo::objects::blob_key(id)).await
+}
+
+/// Whether `bucket` holds a blob at exactly `storage_key`.
+pub async fn stored(
+    ctx: &dyn Context,
+    bucket: &str,
+    storage_key: &str,
+) -> Result<bool, WaferError> {
+    let opts = store::ListOptions {
+        prefix: storage_key.to_string(),
+        limit: 1,
+        offset: 0,
+    };
+    let list = store::list(ctx, bucket, &opts).await?;
+    Ok(list.objects.first().is_some_and(|o| o.key == storage_key))
+}
+
+// ---------------------------------------------------------------------------
+// Relayout
+// ---------------------------------------------------------------------------
+
+/// Move one legacy row's blob to its id-layout key: copy, record the new
+/// `storage_key`, then delete the old blob. Safe to repeat after a crash
+/// at any step — a copy that already landed is not redone. A row whose
+/// blob is gone is pointed at its id key all the same, so it stops being
+/// retried.
+pub async fn relayout(ctx: &dyn Context, row: &Record) -> Result<(), WaferError> {
+    let (bucket, key) = (row.str_field("bucket"), row.str_field("key"));
+    let target = repo::objects::blob_key(&row.id);
+    let mut copied = exists(ctx, bucket, &row.id).await?;
+    if !copied {
+        match store::get(ctx, bucket, key).await {
+            Ok((data, info)) => {
+                store::put(ctx, bucket, &target, &data, &info.content_type).await?;
+                copied = true;
+            }
+            Err(e) if e.code == ErrorCode::NotFound => {
+                tracing::warn!(bucket = %bucket, key = %key, "legacy object has no blob to relay out");
+            }
+            Err(e) => return Err(e),
+        }
+    }
+    repo::objects::set_storage_key(ctx, &row.id, &target).await?;
+    if copied {
+        if let Err(e) = store::delete(ctx, bucket, key).await {
+            tracing::warn!(error = %e, bucket = %bucket, key = %key, "relaid-out object's old blob not deleted");
+        }
+    }
+    Ok(())
+}
+
+/// Queue a [`RELAYOUT_JOB`] when legacy rows remain. Best-effort: until the
+/// job runs, reads keep falling back to the legacy keys.
+pub async fn queue_relayout(ctx: &dyn Context) {
+    match repo::objects::count_legacy(ctx).await {
+        Ok(0) => return,
+        Ok(remaining) => tracing::info!(remaining, "blob relayout: legacy objects to move"),
+        Err(e) => {
+            tracing::warn!(error = %e, "blob relayout: counting legacy objects failed");
+            return;
+        }
+    }
+    let jobs = Services::new(ctx, "suppers-ai/files").jobs();
+    if let Err(e) = jobs
+        .enqueue(RELAYOUT_JOB, &serde_json::json!({}), Default::default())
+        .await
+    {
+        tracing::warn!(error = %e, "blob relayout: queueing failed");
+    }
+}
+
+/// Run a [`RELAYOUT_JOB`]: relay out one batch, then queue the next while
+/// other rows remain. Rows that fail stay legacy and come round again in a
+/// later batch; a batch where every row failed is retried with backoff.
+pub async fn run_relayout_job(ctx: &dyn Context) -> Result<(), JobError> {
+    let batch = repo::objects::list_legacy(ctx, RELAYOUT_BATCH).await?;
+    let mut moved = 0;
+    let mut failed = 0;
+    for row in &batch {
+        match relayout(ctx, row).await {
+            Ok(()) => moved += 1,
+            Err(e) => {
+                failed += 1;
+                tracing::warn!(error = %e, id = %row.id, "blob relayout: moving object failed");
+            }
+        }
+    }
+    let remaining = repo::objects::count_legacy(ctx).await?;
+    tracing::info!(moved, failed, remaining, "blob relayout: batch done");
+    if moved == 0 && failed > 0 {
+        return Err(JobError::transient(format!(
+            "blob relayout: all {failed} objects in the batch failed"
+        )));
+    }
+    if remaining > failed {
+        queue_relayout(ctx).await;
+    }
+    Ok(())
+}
+
+/// Test fixture: store `data` as a `complete` object the way an upload
+/// does (row first, blob at its id key); returns the row.
+#[cfg(test)]
+pub async fn seed(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+    data: &[u8],
+    content_type: &str,
+    uploaded_by: &str,
+) -> Record {
+    let row = repo::objects::insert_pending(
+        ctx,
+        bucket,
+        key,
+        data.len(),
+        &crate::util::sha256_hex(data),
+        content_type,
+        uploaded_by,
+        "",
+    )
+    .await
+    .expect("insert row");
+    store::put(
+        ctx,
+        bucket,
+        &repo::objects::blob_key(&row.id),
+        data,
+        content_type,
+    )
+    .await
+    .expect("put blob");
+    repo::objects::mark_complete(ctx, &row.id)
+        .await
+        .expect("complete row");
+    row
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    async fn ctx() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        ctx
+    }
+
+    /// A row as written before the id layout: blob at the object key, no
+    /// `storage_key`.
+    async fn seed_legacy(ctx: &TestContext, key: &str, data: &[u8]) -> Record {
+        store::put(ctx, "docs", key, data, "text/plain")
+            .await
+            .unwrap();
+        let row = repo::objects::insert_legacy(
+            ctx,
+            "docs",
+            key,
+            data.len() as i64,
+            "text/plain",
+            "alice",
+            "",
+        )
+        .await
+        .unwrap();
+        repo::objects::get(ctx, &row.id).await.unwrap()
+    }
+
+    #[test]
+    fn blob_keys_shard_by_id_and_parse_back() {
+        let id = uuid::Uuid::new_v4().to_string();
+        let key = repo::objects::blob_key(&id);
+        assert_eq!(key, format!("{}/{id}", &id[..2]));
+        assert_eq!(blob_id(&key), Some(id.as_str()));
+        assert_eq!(blob_id("ab/report.pdf"), None);
+        assert_eq!(blob_id("docs/a.txt"), None);
+        assert_eq!(blob_id(&format!("zz/{id}")), None);
+    }
+
+    /// New objects live at their id key whatever they are called, and
+    /// resolve through their row.
+    #[tokio::test]
+    async fn uploads_are_stored_by_id() {
+        let ctx = ctx().await;
+        let row = seed(
+            &ctx,
+            "docs",
+            "a/report.pdf",
+            b"pdf",
+            "application/pdf",
+            "alice",
+        )
+        .await;
+        let row = repo::objects::get(&ctx, &row.id).await.unwrap();
+        assert_eq!(
+            row.str_field("storage_key"),
+            repo::objects::blob_key(&row.id)
+        );
+        assert!(exists(&ctx, "docs", &row.id).await.unwrap());
+        assert!(store::get(&ctx, "docs", "a/report.pdf").await.is_err());
+        let (data, content_type) = get(&ctx, "docs", "a/report.pdf").await.unwrap();
+        assert_eq!(data, b"pdf");
+        assert_eq!(content_type, "application/pdf");
+    }
+
+    /// Legacy blobs read in place, then move to their id key; a second run
+    /// is a no-op and the old key is gone.
+    #[tokio::test]
+    async fn relayout_moves_legacy_blobs_once() {
+        let ctx = ctx().await;
+        let row = seed_legacy(&ctx, "old/notes.txt", b"notes").await;
+        assert_eq!(repo::objects::storage_key(&row), "old/notes.txt");
+        assert_eq!(
+            get(&ctx, "docs", "old/notes.txt").await.unwrap().0,
+            b"notes"
+        );
+        assert!(!exists(&ctx, "docs", &row.id).await.unwrap());
+        assert_eq!(repo::objects::count_legacy(&ctx).await.unwrap(), 1);
+
+        run_relayout_job(&ctx).await.unwrap();
+        assert_eq!(repo::objects::count_legacy(&ctx).await.unwrap(), 0);
+        assert!(exists(&ctx, "docs", &row.id).await.unwrap());
+        assert!(store::get(&ctx, "docs", "old/notes.txt").await.is_err());
+        assert_eq!(
+            get(&ctx, "docs", "old/notes.txt").await.unwrap().0,
+            b"notes"
+        );
+
+        let row = repo::objects::get(&ctx, &row.id).await.unwrap();
+        relayout(&ctx, &row).await.unwrap();
+        assert_eq!(
+            get(&ctx, "docs", "old/notes.txt").await.unwrap().0,
+            b"notes"
+        );
+    }
+
+    /// A relayout interrupted after its copy finishes without copying again.
+    #[tokio::test]
+    async fn relayout_resumes_after_a_copied_blob() {
+        let ctx = ctx().await;
+        let row = seed_legacy(&ctx, "a.txt", b"new").await;
+        let target = repo::objects::blob_key(&row.id);
+        store::put(&ctx, "docs", &target, b"new", "text/plain")
+            .await
+            .unwrap();
+
+        relayout(&ctx, &row).await.unwrap();
+        let row = repo::objects::get(&ctx, &row.id).await.unwrap();
+        assert_eq!(row.str_field("storage_key"), target);
+        assert!(store::get(&ctx, "docs", "a.txt").await.is_err());
+    }
+}
@@ -0,0 +1,271 @@
+//! Breadcrumb resolution for the file-manager UI.
+//!
+//! Objects are keyed by their full path and folders are key prefixes, not
+//! rows, so an object's ancestor chain is derived from its key: one row read
+//! per request (one `IN` query for a batch), with no per-ancestor lookups
+//! and no recursive query on either backend.
+//!
+//! Access follows [`acl::is_access_denied`] on the leaf only. Ancestor names
+//! are part of the leaf's key, so a caller who can read the leaf already
+//! knows them.
+
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::{
+    acl::{self, Access},
+    repo, storage,
+};
+use crate::{
+    blocks::errors::{self, ErrorCode},
+    http::{err_bad_request, err_forbidden, err_internal, ok_json},
+    util::RecordExt,
+};
+
+/// Most ids one `?ids=` request may resolve.
+const MAX_BATCH_IDS: usize = 100;
+
+/// One breadcrumb segment. `id` is what the UI navigates with: `""` for the
+/// bucket root, the folder prefix (`docs/q1/`) for folders, and the object
+/// row id for the leaf.
+#[derive(Debug, PartialEq, Eq, serde::Serialize)]
+struct Crumb {
+    id: String,
+    name: String,
+    kind: &'static str,
+}
+
+/// The chain from the bucket root down to the object `id` stored at `key`.
+fn crumbs(bucket: &str, id: &str, key: &str) -> Vec<Crumb> {
+    acl::covering_paths(key)
+        .into_iter()
+        .map(|path| {
+            if path.is_empty() {
+                Crumb {
+                    id: String::new(),
+                    name: bucket.to_string(),
+                    kind: "bucket",
+                }
+            } else if let Some(folder) = path.strip_suffix('/') {
+                Crumb {
+                    name: folder.rsplit('/').next().unwrap_or(folder).to_string(),
+                    id: path,
+                    kind: "folder",
+                }
+            } else {
+                Crumb {
+                    id: id.to_string(),
+                    name: path.rsplit('/').next().unwrap_or(&path).to_string(),
+                    kind: "object",
+                }
+            }
+        })
+        .collect()
+}
+
+/// `GET /b/storage/api/buckets/{name}/paths/{id}` — the breadcrumb chain
+/// for one object.
+pub(super) async fn handle_one(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if !storage::is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+    let id = msg.var("id");
+    if id.is_empty() {
+        return err_bad_request("Missing object ID");
+    }
+    let rows = match repo::objects::find_in_bucket_by_ids(ctx, bucket, &[id.to_string()]).await {
+        Ok(rows) => rows,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let Some(row) = rows.first() else {
+        return errors::error_response(ErrorCode::ObjectNotFound, "Object not found");
+    };
+    let key = row.str_field("key");
+    if acl::is_access_denied(ctx, msg, bucket, key, Access::Read).await {
+        return err_forbidden("Access denied to this object");
+    }
+    ok_json(&serde_json::json!({
+        "id": id,
+        "key": key,
+        "modified_by": row.str_field("modified_by"),
+        "path": crumbs(bucket, id, key),
+    }))
+}
+
+/// `GET /b/storage/api/buckets/{name}/paths?ids=a,b,c` — breadcrumbs for
+/// several objects in one round trip (search results). Returns
+/// `{"paths": {id: {key, path} | {error: {code, message}}}}`: an id that is
+/// missing or unreadable gets its own error entry instead of failing the
+/// whole request.
+pub(super) async fn handle_batch(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if !storage::is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+    let mut ids: Vec<String> = Vec::new();
+    for id in msg.query("ids").split(',').map(str::trim) {
+        if !id.is_empty() && !ids.iter().any(|seen| seen == id) {
+            ids.push(id.to_string());
+        }
+    }
+    if ids.is_empty() {
+        return errors::validation_error("No object IDs given", &[("ids", "required")]);
+    }
+    if ids.len() > MAX_BATCH_IDS {
+        return errors::validation_error(
+            "Too many object IDs",
+            &[("ids", "at most 100 comma-separated IDs")],
+        );
+    }
+
+    let rows = match repo::objects::find_in_bucket_by_ids(ctx, bucket, &ids).await {
+        Ok(rows) => rows,
+        Err(e) => return err_internal("Database error", e),
+    };
+    // Owner/admin is decided once for the bucket; only non-owners pay for a
+    // per-key grant lookup.
+    let bucket_access = !storage::is_bucket_access_denied(ctx, msg, bucket).await;
+
+    let mut paths = serde_json::Map::new();
+    for id in &ids {
+        let entry = match rows.iter().find(|r| &r.id == id) {
+            None => error_entry(ErrorCode::ObjectNotFound, "Object not found"),
+            Some(row) => {
+                let key = row.str_field("key");
+                if bucket_access
+                    || acl::has_grant(ctx, msg.user_id(), bucket, key, Access::Read).await
+                {
+                    serde_json::json!({"key": key, "path": crumbs(bucket, id, key)})
+                } else {
+                    error_entry(ErrorCode::Forbidden, "Access denied to this object")
+                }
+            }
+        };
+        paths.insert(id.clone(), entry);
+    }
+    ok_json(&serde_json::json!({"paths": paths}))
+}
+
+fn error_entry(code: ErrorCode, message: &str) -> serde_json::Value {
+    serde_json::json!({"error": {"code": code.as_str(), "message": message}})
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{auth_msg, output_is_error, output_json, TestContext};
+
+    async fn seed_bucket(ctx: &TestContext, name: &str, owner: &str) {
+        let data = crate::util::json_map(serde_json::json!({
+            "name": name,
+            "public": false,
+            "created_by": owner,
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(ctx, data).await.expect("seed bucket");
+    }
+
+    async fn seed_object(ctx: &TestContext, bucket: &str, key: &str) -> String {
+        let data = crate::util::json_map(serde_json::json!({
+            "bucket": bucket,
+            "key": key,
+            "size": 1,
+            "status": "complete",
+            "uploaded_by": "alice",
+        }));
+        repo::objects::seed(ctx, data)
+            .await
+            .expect("seed object")
+            .id
+    }
+
+    fn paths_msg(user: &str, bucket: &str, id: Option<&str>, ids: Option<&str>) -> Message {
+        let path = format!("/b/storage/api/buckets/{bucket}/paths");
+        let mut msg = auth_msg("retrieve", &path, user);
+        msg.set_meta("req.param.name", bucket);
+        if let Some(id) = id {
+            msg.set_meta("req.param.id", id);
+        }
+        if let Some(ids) = ids {
+            msg.set_meta("req.query.ids", ids);
+        }
+        msg
+    }
+
+    #[test]
+    fn crumbs_run_from_bucket_root_to_leaf() {
+        let chain = crumbs("team", "obj-1", "docs/q1/report.pdf");
+        let ids: Vec<&str> = chain.iter().map(|c| c.id.as_str()).collect();
+        let names: Vec<&str> = chain.iter().map(|c| c.name.as_str()).collect();
+        assert_eq!(ids, vec!["", "docs/", "docs/q1/", "obj-1"]);
+        assert_eq!(names, vec!["team", "docs", "q1", "report.pdf"]);
+        assert_eq!(chain[0].kind, "bucket");
+        assert_eq!(chain[3].kind, "object");
+
+        // A top-level object is just root + leaf.
+        assert_eq!(crumbs("team", "obj-2", "a.txt").len(), 2);
+    }
+
+    #[tokio::test]
+    async fn owner_resolves_single_path() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let id = seed_object(&ctx, "team", "docs/q1/report.pdf").await;
+
+        let msg = paths_msg("alice", "team", Some(&id), None);
+        let body = output_json(handle_one(&ctx, &msg, "team").await).await;
+        assert_eq!(body["key"], "docs/q1/report.pdf");
+        assert_eq!(body["path"][1]["id"], "docs/");
+        assert_eq!(body["path"][3]["id"], id.as_str());
+
+        let out = handle_one(&ctx, &paths_msg("mallory", "team", Some(&id), None), "team").await;
+        assert!(output_is_error(out, "PermissionDenied").await);
+    }
+
+    #[tokio::test]
+    async fn object_from_another_bucket_is_not_found() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        seed_bucket(&ctx, "other", "alice").await;
+        let id = seed_object(&ctx, "other", "x.txt").await;
+
+        let out = handle_one(&ctx, &paths_msg("alice", "team", Some(&id), None), "team").await;
+        assert!(output_is_error(out, "NotFound").await);
+    }
+
+    #[tokio::test]
+    async fn batch_reports_per_id_errors() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let shared = seed_object(&ctx, "team", "docs/a.txt").await;
+        let private = seed_object(&ctx, "team", "private/b.txt").await;
+        repo::acls::upsert(
+            &ctx,
+            repo::acls::NewGrant {
+                bucket: "team",
+                path: "docs/",
+                grantee_user_id: "bob",
+                permission: "read",
+                granted_by: "alice",
+            },
+        )
+        .await
+        .unwrap();
+
+        let ids = format!("{shared},{private},missing,{shared}");
+        let msg = paths_msg("bob", "team", None, Some(&ids));
+        let body = output_json(handle_batch(&ctx, &msg, "team").await).await;
+        let paths = body["paths"].as_object().unwrap();
+        assert_eq!(paths.len(), 3, "duplicates collapse");
+        assert_eq!(paths[&shared]["path"][1]["name"], "docs");
+        assert_eq!(paths[&private]["error"]["code"], "forbidden");
+        assert_eq!(paths["missing"]["error"]["code"], "object_not_found");
+    }
+
+    #[tokio::test]
+    async fn batch_requires_ids() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let msg = paths_msg("alice", "team", None, Some(" , "));
+        let body = output_json(handle_batch(&ctx, &msg, "team").await).await;
+        assert_eq!(body["code"], "validation_failed");
+    }
+}
@@ -0,0 +1,119 @@
+//! Per-bucket statistics for the bucket listing: `object_count`,
+//! `total_bytes` and `last_activity_at` (the newest change to a stored
+//! object, null for an empty bucket).
+//!
+//! They come from [`repo::objects::bucket_usage`], one GROUP BY over the
+//! objects table — the same aggregate the admin storage report breaks
+//! buckets down with, so the two pages can't disagree about a bucket. The
+//! aggregate covers every bucket and is cached for [`CACHE_TTL`]; each
+//! listing picks the buckets its caller sees. `?include_stats=false` skips
+//! it for callers that only need names.
+
+use std::{collections::HashMap, sync::Arc, time::Duration};
+
+use wafer_run::{context::Context, WaferError};
+
+use super::repo;
+use crate::{cache::TtlCache, util::RecordExt};
+
+#[derive(Debug, Default, Clone, PartialEq, Eq, serde::Serialize)]
+pub(super) struct BucketStats {
+    pub object_count: i64,
+    pub total_bytes: i64,
+    pub last_activity_at: Option<String>,
+}
+
+/// Stats per bucket name; empty buckets are absent. Tests disable the
+/// cache: every `TestContext` has its own database but this cache is
+/// process-wide.
+static CACHE: TtlCache<HashMap<String, BucketStats>> = TtlCache::new(CACHE_TTL);
+
+#[cfg(not(test))]
+const CACHE_TTL: Duration = Duration::from_secs(60);
+#[cfg(test)]
+const CACHE_TTL: Duration = Duration::ZERO;
+
+async fn build(ctx: &dyn Context) -> Result<HashMap<String, BucketStats>, WaferError> {
+    let mut stats: HashMap<String, BucketStats> = HashMap::new();
+    // One row per (bucket, team_id): a bucket moved between owners can
+    // hold objects under both.
+    for row in repo::objects::bucket_usage(ctx).await? {
+        let entry = stats
+            .entry(row.str_field("bucket").to_string())
+            .or_default();
+        entry.object_count += row.i64_field("objects");
+        entry.total_bytes += repo::objects::usage_bytes(&row);
+        let last = row.str_field("last_activity");
+        if !last.is_empty() && entry.last_activity_at.as_deref() < Some(last) {
+            entry.last_activity_at = Some(last.to_string());
+        }
+    }
+    Ok(stats)
+}
+
+/// Stats for every bucket, through the cache. `Instant` panics on wasm32,
+/// so the browser and Cloudflare builds aggregate on every call instead.
+pub(super) async fn all(
+    ctx: &dyn Context,
+) -> Result<Arc<HashMap<String, BucketStats>>, WaferError> {
+    if cfg!(target_arch = "wasm32") {
+        return build(ctx).await.map(Arc::new);
+    }
+    let mut failed = None;
+    let slot = &mut failed;
+    let hit = CACHE
+        .get_or_load(|| async move {
+            build(ctx).await.unwrap_or_else(|e| {
+                *slot = Some(e);
+                HashMap::new()
+            })
+        })
+        .await;
+    if let Some(e) = failed {
+        // Don't pin a transient backend failure for a whole TTL.
+        CACHE.invalidate();
+        return Err(e);
+    }
+    Ok(hit)
+}
+
+/// `stats` as the listing reports it for `names`: every bucket present,
+/// an empty one with zeros.
+pub(super) fn for_buckets(
+    stats: &HashMap<String, BucketStats>,
+    names: &[&str],
+) -> serde_json::Map<String, serde_json::Value> {
+    names
+        .iter()
+        .map(|name| {
+            let bucket = stats.get(*name).cloned().unwrap_or_default();
+            (name.to_string(), serde_json::json!(bucket))
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    #[tokio::test]
+    async fn stats_sum_stored_objects_per_bucket() {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        super::super::blobs::seed(&ctx, "a", "x.txt", b"abc", "text/plain", "alice").await;
+        super::super::blobs::seed(&ctx, "a", "y.txt", b"de", "text/plain", "alice").await;
+        super::super::blobs::seed(&ctx, "b", "z.txt", b"f", "text/plain", "bob").await;
+
+        let stats = all(&ctx).await.expect("stats");
+        let listed = for_buckets(&stats, &["a", "b", "empty"]);
+        assert_eq!(listed["a"]["object_count"], 2);
+        assert_eq!(listed["a"]["total_bytes"], 5);
+        assert!(listed["a"]["last_activity_at"].is_string());
+        assert_eq!(listed["b"]["total_bytes"], 1);
+        assert_eq!(
+            listed["empty"],
+            serde_json::json!({"object_count": 0, "total_bytes": 0, "last_activity_at": null})
+        );
+    }
+}
@@ -0,0 +1,309 @@
+//! Content checksums: conditional uploads and the sync diff.
+//!
+//! Every stored object records the SHA-256 of its bytes (`sha256` on the
+//! object row, lowercase hex). Sync clients use it two ways:
+//!
+//! - An upload may name the checksum it expects: `?sha256=` on
+//!   `POST /b/storage/api/buckets/{name}/objects` and on the widget upload,
+//!   or `sha256` when minting a widget session. When the target already
+//!   holds content with that checksum, the upload answers 200 with the
+//!   existing object and `"not_modified": true` and stores nothing — a
+//!   raw-body upload naming its key in the URL answers before reading the
+//!   body. Otherwise the bytes that arrive must hash to it, or the upload is
+//!   refused with `checksum_mismatch` and nothing is stored.
+//! - `POST /b/storage/api/buckets/{name}/objects/diff` takes
+//!   `{"entries": [{"path", "checksum", "size"}]}` and sorts the paths into
+//!   `missing`, `changed` and `identical`, so a client can plan the smallest
+//!   upload set in one round trip. Every path needs read access, as a
+//!   listing of it would.
+//!
+//! Objects stored before checksums were recorded carry none: they never
+//! match an expected checksum and diff as `changed`.
+
+use std::collections::HashMap;
+
+use wafer_core::clients::database::Record;
+use wafer_run::{context::Context, InputStream, Message, OutputStream, WaferError};
+
+use super::{
+    acl::{self, Access},
+    quota::Refusal,
+    repo,
+    storage::{self, is_safe_bucket_name, is_valid_storage_key},
+};
+use crate::{
+    blocks::errors::{self, ErrorCode},
+    http::{err_bad_request, err_forbidden, err_internal, ok_json},
+    util::RecordExt,
+};
+
+/// Most entries one diff request may list.
+const MAX_DIFF_ENTRIES: usize = 1000;
+
+/// Keys per `IN` lookup, under SQLite's historical 999-variable limit.
+const LOOKUP_BATCH: usize = 500;
+
+/// Why an expected checksum was refused, for validation errors.
+pub(super) const INVALID_CHECKSUM: &str = "must be a hex SHA-256 (64 characters)";
+
+/// The hex SHA-256 of `content`, as object rows record it.
+pub(super) fn digest(content: &[u8]) -> String {
+    crate::util::sha256_hex(content).to_ascii_lowercase()
+}
+
+/// The checksum a request expects: `None` when `raw` is empty, else its
+/// lowercase form when it is 64 hex digits.
+pub(super) fn parse_expected(raw: &str) -> Result<Option<String>, &'static str> {
+    let raw = raw.trim();
+    if raw.is_empty() {
+        return Ok(None);
+    }
+    if raw.len() == 64 && raw.bytes().all(|b| b.is_ascii_hexdigit()) {
+        Ok(Some(raw.to_ascii_lowercase()))
+    } else {
+        Err(INVALID_CHECKSUM)
+    }
+}
+
+/// Whether `row` is a stored object with bytes that hash to `expected`. An
+/// in-flight reservation or a quarantined object doesn't count.
+fn holds(row: &Record, expected: &str) -> bool {
+    is_stored(row) && row.str_field("sha256") == expected
+}
+
+/// Whether `row` is an object a sync client already has on the server.
+fn is_stored(row: &Record) -> bool {
+    !matches!(
+        row.str_field("status"),
+        "pending" | repo::objects::STATUS_QUARANTINED
+    )
+}
+
+/// The object at `key` when it already holds content hashing to `expected`.
+pub(super) async fn unchanged(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+    expected: &str,
+) -> Result<Option<Record>, WaferError> {
+    let row = repo::objects::find_by_bucket_key(ctx, bucket, key).await?;
+    Ok(row.filter(|row| holds(row, expected)))
+}
+
+/// What an upload answers when the object already held the expected
+/// content.
+pub(super) fn not_modified_json(row: &Record) -> serde_json::Value {
+    serde_json::json!({
+        "id": row.id,
+        "bucket": row.str_field("bucket"),
+        "key": row.str_field("key"),
+        "size": row.i64_field("size"),
+        "content_type": row.str_field("content_type"),
+        "sha256": row.str_field("sha256"),
+        "last_modified": row.str_field("uploaded_at"),
+        "uploaded": false,
+        "not_modified": true,
+    })
+}
+
+/// The refusal for bytes that don't hash to the checksum the upload named.
+pub(super) fn mismatch(expected: &str, actual: &str) -> Refusal {
+    Refusal {
+        code: ErrorCode::ChecksumMismatch,
+        message: "Uploaded content does not match the expected SHA-256".to_string(),
+        details: Some(serde_json::json!({ "expected": expected, "actual": actual })),
+    }
+}
+
+/// `POST /b/storage/api/buckets/{name}/objects/diff`.
+pub(super) async fn handle_diff(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Entry {
+        #[serde(default)]
+        path: String,
+        #[serde(default)]
+        checksum: String,
+        #[serde(default)]
+        size: Option<i64>,
+    }
+    #[derive(serde::Deserialize)]
+    struct Req {
+        #[serde(default)]
+        entries: Vec<Entry>,
+    }
+
+    if !is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+    let body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    if body.entries.is_empty() || body.entries.len() > MAX_DIFF_ENTRIES {
+        return errors::validation_error(
+            "Invalid diff request",
+            &[("entries", "must list between 1 and 1000 objects")],
+        );
+    }
+    let mut entries = Vec::with_capacity(body.entries.len());
+    for (i, entry) in body.entries.into_iter().enumerate() {
+        let reason = if !is_valid_storage_key(&entry.path) {
+            Some("path must be an object key")
+        } else if entry.size.is_some_and(|size| size < 0) {
+            Some("size must not be negative")
+        } else {
+            match parse_expected(&entry.checksum) {
+                Ok(Some(checksum)) => {
+                    entries.push((entry.path, checksum, entry.size));
+                    None
+                }
+                Ok(None) => Some("checksum is required"),
+                Err(reason) => Some(reason),
+            }
+        };
+        if let Some(reason) = reason {
+            let field = format!("entries[{i}]");
+            return errors::validation_error("Invalid diff request", &[(field.as_str(), reason)]);
+        }
+    }
+
+    // The owner and admins see the whole bucket; anyone else needs read
+    // access to each path, as a listing of it would.
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        for (path, _, _) in &entries {
+            if acl::is_access_denied(ctx, msg, bucket, path, Access::Read).await {
+                return err_forbidden("Access denied to this bucket");
+            }
+        }
+    }
+
+    let keys: Vec<String> = entries.iter().map(|(path, _, _)| path.clone()).collect();
+    let mut rows = Vec::with_capacity(keys.len());
+    for batch in keys.chunks(LOOKUP_BATCH) {
+        match repo::objects::find_by_keys(ctx, bucket, batch).await {
+            Ok(found) => rows.extend(found),
+            Err(e) => return err_internal("Database error", e),
+        }
+    }
+    let stored: HashMap<&str, &Record> = rows
+        .iter()
+        .filter(|row| is_stored(row))
+        .map(|row| (row.str_field("key"), row))
+        .collect();
+
+    let mut missing = Vec::new();
+    let mut changed = Vec::new();
+    let mut identical = Vec::new();
+    for (path, checksum, size) in &entries {
+        match stored.get(path.as_str()) {
+            None => missing.push(path),
+            Some(row)
+                if holds(row, checksum)
+                    && size.is_none_or(|size| size == row.i64_field("size")) =>
+            {
+                identical.push(path)
+            }
+            Some(_) => changed.push(path),
+        }
+    }
+    ok_json(&serde_json::json!({
+        "missing": missing,
+        "changed": changed,
+        "identical": identical,
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::{super::blobs, *};
+    use crate::test_support::{auth_msg, output_json, output_status, TestContext};
+
+    #[test]
+    fn parse_expected_accepts_hex_digests_only() {
+        assert_eq!(parse_expected(""), Ok(None));
+        let upper = "AB".repeat(32);
+        assert_eq!(parse_expected(&upper), Ok(Some("ab".repeat(32))));
+        assert_eq!(parse_expected("abc"), Err(INVALID_CHECKSUM));
+        assert_eq!(parse_expected(&"zz".repeat(32)), Err(INVALID_CHECKSUM));
+    }
+
+    async fn ctx() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let data = crate::util::json_map(serde_json::json!({
+            "name": "team",
+            "public": false,
+            "created_by": "alice",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(&ctx, data).await.expect("seed bucket");
+        ctx
+    }
+
+    fn diff_msg(user: &str) -> Message {
+        let mut msg = auth_msg("create", "/b/storage/api/buckets/team/objects/diff", user);
+        msg.set_meta("req.param.name", "team");
+        msg
+    }
+
+    async fn diff(ctx: &TestContext, user: &str, entries: serde_json::Value) -> OutputStream {
+        let body = serde_json::json!({ "entries": entries });
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        handle_diff(ctx, &diff_msg(user), "team", input).await
+    }
+
+    #[tokio::test]
+    async fn diff_sorts_paths_by_what_the_bucket_holds() {
+        let ctx = ctx().await;
+        blobs::seed(&ctx, "team", "same.txt", b"same", "text/plain", "alice").await;
+        blobs::seed(&ctx, "team", "edited.txt", b"old", "text/plain", "alice").await;
+
+        let entries = serde_json::json!([
+            {"path": "same.txt", "checksum": digest(b"same"), "size": 4},
+            {"path": "edited.txt", "checksum": digest(b"new"), "size": 3},
+            {"path": "new.txt", "checksum": digest(b"x")},
+        ]);
+        let body = output_json(diff(&ctx, "alice", entries).await).await;
+        assert_eq!(body["identical"], serde_json::json!(["same.txt"]), "{body}");
+        assert_eq!(body["changed"], serde_json::json!(["edited.txt"]));
+        assert_eq!(body["missing"], serde_json::json!(["new.txt"]));
+
+        // A matching checksum with a different size is still a change.
+        let entries = serde_json::json!([
+            {"path": "same.txt", "checksum": digest(b"same"), "size": 5},
+        ]);
+        let body = output_json(diff(&ctx, "alice", entries).await).await;
+        assert_eq!(body["changed"], serde_json::json!(["same.txt"]));
+    }
+
+    #[tokio::test]
+    async fn diff_validates_entries_and_checks_access_per_path() {
+        let ctx = ctx().await;
+        let entries = serde_json::json!([{"path": "a.txt", "checksum": "nope"}]);
+        let body = output_json(diff(&ctx, "alice", entries).await).await;
+        assert_eq!(body["code"], "validation_failed");
+        assert_eq!(body["details"]["entries[0]"], INVALID_CHECKSUM);
+
+        // Bob may read `docs/` only, so a diff reaching outside it is refused.
+        let mut grant = auth_msg("create", "/b/storage/api/buckets/team/acl", "alice");
+        grant.set_meta("req.param.name", "team");
+        let body = serde_json::json!({"grantee_user_id": "bob", "path": "docs/"});
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        assert_eq!(
+            output_status(acl::handle_grant(&ctx, &grant, "team", input).await).await,
+            200
+        );
+        let inside = serde_json::json!([{"path": "docs/a.txt", "checksum": digest(b"a")}]);
+        assert_eq!(output_status(diff(&ctx, "bob", inside).await).await, 200);
+        let outside = serde_json::json!([
+            {"path": "docs/a.txt", "checksum": digest(b"a")},
+            {"path": "private/b.txt", "checksum": digest(b"b")},
+        ]);
+        assert_eq!(output_status(diff(&ctx, "bob", outside).await).await, 403);
+    }
+}
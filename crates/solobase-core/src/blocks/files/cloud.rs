@@ -2,29 +2,93 @@ use std::collections::HashMap;
 
 use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
 
-use super::repo;
-use crate::http::{err_bad_request, err_forbidden, err_internal, err_not_found, ok_json};
-
-pub async fn handle(ctx: &dyn Context, msg: Message, input: InputStream) -> OutputStream {
-    let action = msg.action();
-    let path = msg.path();
-
-    match (action, path) {
-        // User-facing cloud storage
-        ("retrieve", "/b/cloudstorage/shares") => handle_list_shares(ctx, &msg).await,
-        ("create", "/b/cloudstorage/shares") => handle_create_share(ctx, &msg, input).await,
-        ("delete", _) if path.starts_with("/b/cloudstorage/shares/") => {
-            handle_delete_share(ctx, &msg).await
+use super::{blobs, repo};
+use crate::{
+    endpoint_match::{self, EndpointRoute},
+    http::{err_bad_request, err_forbidden, err_internal, err_not_found, ok_json},
+    util::RecordExt,
+};
+
+/// In-block dispatch targets for the cloud storage API.
+#[derive(Clone, Copy)]
+enum Route {
+    ListShares,
+    CreateShare,
+    DeleteShare,
+    GetQuota,
+    GetTeamQuota,
+    AdminListShares,
+    AdminActiveShares,
+    AdminCleanupShares,
+    AdminRevokeShare,
+    AdminAccessLogs,
+    AdminQuotas,
+    AdminUpdateQuota,
+    AdminUpdateTeamQuota,
+    AdminQuotaDigest,
+}
+
+/// The user routes answer on the wire path; the `/admin/b/cloudstorage/*`
+/// routes are only reachable through the admin block's delegation, which
+/// has already checked the admin role. `{id}` / `{user_id}` bind into
+/// `req.param.*`.
+const ROUTES: &[EndpointRoute<Route>] = &[
+    EndpointRoute::get("/b/cloudstorage/shares", Route::ListShares),
+    EndpointRoute::post("/b/cloudstorage/shares", Route::CreateShare),
+    EndpointRoute::delete("/b/cloudstorage/shares/{id}", Route::DeleteShare),
+    EndpointRoute::get("/b/cloudstorage/quota", Route::GetQuota),
+    EndpointRoute::get("/b/cloudstorage/teams/{id}/quota", Route::GetTeamQuota),
+    EndpointRoute::get("/admin/b/cloudstorage/shares", Route::AdminListShares),
+    EndpointRoute::get(
+        "/admin/b/cloudstorage/shares/active",
+        Route::AdminActiveShares,
+    ),
+    EndpointRoute::post(
+        "/admin/b/cloudstorage/shares/cleanup",
+        Route::AdminCleanupShares,
+    ),
+    EndpointRoute::delete("/admin/b/cloudstorage/shares/{id}", Route::AdminRevokeShare),
+    EndpointRoute::get("/admin/b/cloudstorage/access-logs", Route::AdminAccessLogs),
+    EndpointRoute::get("/admin/b/cloudstorage/quotas", Route::AdminQuotas),
+    EndpointRoute::patch(
+        "/admin/b/cloudstorage/quotas/{user_id}",
+        Route::AdminUpdateQuota,
+    ),
+    EndpointRoute::patch(
+        "/admin/b/cloudstorage/teams/{id}/quota",
+        Route::AdminUpdateTeamQuota,
+    ),
+    EndpointRoute::post(
+        "/admin/b/cloudstorage/quota-digest",
+        Route::AdminQuotaDigest,
+    ),
+];
+
+pub async fn handle(ctx: &dyn Context, mut msg: Message, input: InputStream) -> OutputStream {
+    let Some(route) = endpoint_match::dispatch(&mut msg, ROUTES) else {
+        return err_not_found("not found");
+    };
+    match route {
+        Route::ListShares => handle_list_shares(ctx, &msg).await,
+        Route::CreateShare => handle_create_share(ctx, &msg, input).await,
+        Route::DeleteShare => handle_delete_share(ctx, &msg).await,
+        Route::GetQuota => handle_get_quota(ctx, &msg).await,
+        Route::GetTeamQuota => handle_get_team_quota(ctx, &msg).await,
+        Route::AdminListShares => handle_admin_list_shares(ctx, &msg).await,
+        Route::AdminActiveShares => handle_admin_active_shares(ctx, &msg).await,
+        Route::AdminCleanupShares => {
+            let deleted = super::share::sweep_stale_shares(ctx).await;
+            ok_json(&serde_json::json!({"deleted": deleted}))
         }
-        ("retrieve", "/b/cloudstorage/quota") => handle_get_quota(ctx, &msg).await,
-        // Admin cloud storage
-        ("retrieve", "/admin/b/cloudstorage/shares") => handle_admin_list_shares(ctx, &msg).await,
-        ("retrieve", "/admin/b/cloudstorage/access-logs") => handle_access_logs(ctx, &msg).await,
-        ("retrieve", "/admin/b/cloudstorage/quotas") => handle_admin_quotas(ctx, &msg).await,
-        ("update", _) if path.starts_with("/admin/b/cloudstorage/quotas/") => {
-            handle_update_quota(ctx, &msg, input).await
+        Route::AdminRevokeShare => handle_admin_revoke_share(ctx, &msg).await,
+        Route::AdminAccessLogs => handle_access_logs(ctx, &msg).await,
+        Route::AdminQuotas => handle_admin_quotas(ctx, &msg).await,
+        Route::AdminUpdateQuota => handle_update_quota(ctx, &msg, input).await,
+        Route::AdminUpdateTeamQuota => handle_update_team_quota(ctx, &msg, input).await,
+        Route::AdminQuotaDigest => {
+            let users = super::quota::send_admin_digest(ctx).await;
+            ok_json(&serde_json::json!({"users": users}))
         }
-        _ => err_not_found("not found"),
     }
 }
 
@@ -50,11 +114,11 @@ async fn handle_create_share(ctx: &dyn Context, msg: &Message, input: InputStrea
         key: String,
         expires_in_hours: Option<i64>,
         max_access_count: Option<i64>,
+        max_uses: Option<i64>,
     }
-    let raw = input.collect_to_bytes().await;
-    let body: Req = match serde_json::from_slice(&raw) {
+    let body: Req = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
 
     // Validate bucket/key through the shared storage validators so the share
@@ -64,12 +128,15 @@ async fn handle_create_share(ctx: &dyn Context, msg: &Message, input: InputStrea
     if body.bucket.is_empty() || body.key.is_empty() {
         return err_bad_request("Bucket and key are required");
     }
-    if !super::storage::is_valid_bucket_name(&body.bucket) {
+    if !super::storage::is_safe_bucket_name(&body.bucket) {
         return err_bad_request("Invalid bucket name");
     }
     if !super::storage::is_valid_storage_key(&body.key) {
         return err_bad_request("Invalid object key");
     }
+    if body.max_uses.is_some_and(|n| n < 1) {
+        return err_bad_request("max_uses must be at least 1");
+    }
 
     // Verify the user owns this bucket (or is admin) — shared helper from
     // storage.rs so the two modules stay in lockstep on what "access
@@ -79,22 +146,22 @@ async fn handle_create_share(ctx: &dyn Context, msg: &Message, input: InputStrea
     }
 
     // Verify the file actually exists before creating a share
-    // audit-allow: bucket arg is &body.bucket (request-supplied); the storage block @-rewrites cross-block paths and the runtime grant check at solobase-core/src/blocks/storage.rs:256 enforces the actual access against typed Storage grants
-    if wafer_core::clients::storage::get(ctx, &body.bucket, &body.key)
-        .await
-        .is_err()
-    {
+    if blobs::get(ctx, &body.bucket, &body.key).await.is_err() {
         return err_not_found("File not found in storage");
     }
+    if let Some(blocked) = super::scan::read_blocked(ctx, "", &body.bucket, &body.key).await {
+        return blocked;
+    }
 
     // Generate share token
-    let token = super::share::generate_share_token(ctx, &body.bucket, &body.key).await;
+    let token =
+        super::share::generate_share_token(ctx, &body.bucket, &body.key, body.max_uses).await;
     let token = match token {
         Ok(t) => t,
         Err(r) => return r,
     };
 
-    let now = chrono::Utc::now();
+    let now = crate::clock::now();
     let expires_at = match body.expires_in_hours {
         None => None,
         Some(h) if !(1..=MAX_SHARE_EXPIRY_HOURS).contains(&h) => {
@@ -114,11 +181,11 @@ async fn handle_create_share(ctx: &dyn Context, msg: &Message, input: InputStrea
             let Some(expiry) = now.checked_add_signed(duration) else {
                 return err_bad_request("expires_in_hours out of range");
             };
-            Some(expiry.to_rfc3339())
+            Some(crate::util::format_rfc3339(expiry))
         }
     };
 
-    let created_at = now.to_rfc3339();
+    let created_at = crate::util::format_rfc3339(now);
     let new_share = repo::shares::NewShare {
         token: &token,
         bucket: &body.bucket,
@@ -132,18 +199,14 @@ async fn handle_create_share(ctx: &dyn Context, msg: &Message, input: InputStrea
         Ok(record) => ok_json(&serde_json::json!({
             "id": record.id,
             "token": token,
-            "direct_url": format!("/b/storage/direct/{}", token)
+            "direct_url": crate::base_path::url(msg, &format!("/b/storage/direct/{token}"))
         })),
         Err(e) => err_internal("Database error", e),
     }
 }
 
 async fn handle_delete_share(ctx: &dyn Context, msg: &Message) -> OutputStream {
-    let path = msg.path();
-    let id = path.strip_prefix("/b/cloudstorage/shares/").unwrap_or("");
-    if id.is_empty() {
-        return err_bad_request("Missing share ID");
-    }
+    let id = msg.var("id");
 
     // Verify ownership
     if let Ok(share) = repo::shares::find_by_id(ctx, id).await {
@@ -167,9 +230,31 @@ async fn handle_delete_share(ctx: &dyn Context, msg: &Message) -> OutputStream {
 async fn handle_get_quota(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let quota = super::quota::get_user_quota(ctx, msg.user_id()).await;
     let usage = super::quota::get_user_usage(ctx, msg.user_id()).await;
+    // Highest crossed alert threshold (or null), so the UI can render a
+    // near-quota banner without a second call.
+    let threshold = super::quota::highest_crossed(
+        usage["total_bytes"].as_i64().unwrap_or(0),
+        quota.max_storage_bytes,
+        &super::quota::alert_thresholds(ctx).await,
+    );
     ok_json(&serde_json::json!({
         "quota": quota,
-        "usage": usage
+        "usage": usage,
+        "threshold": threshold
+    }))
+}
+
+/// A team's storage cap and usage. Members only; anyone else gets 404.
+async fn handle_get_team_quota(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let team_id = msg.var("id");
+    match repo::teams::role_of(ctx, team_id, msg.user_id()).await {
+        Ok(Some(_)) => {}
+        Ok(None) => return err_not_found("Team not found"),
+        Err(e) => return err_internal("Database error", e),
+    }
+    ok_json(&serde_json::json!({
+        "quota": super::quota::get_team_quota(ctx, team_id).await,
+        "usage": super::quota::get_team_usage(ctx, team_id).await,
     }))
 }
 
@@ -182,6 +267,54 @@ async fn handle_admin_list_shares(ctx: &dyn Context, msg: &Message) -> OutputStr
     }
 }
 
+/// Non-revoked shares with an `expired` flag, plus per-state counts.
+async fn handle_admin_active_shares(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let (page, page_size, _) = msg.pagination_params(50);
+    let offset = ((page - 1) * page_size) as i64;
+    let now = crate::util::now_rfc3339();
+    let list = match repo::shares::list_unrevoked(ctx, page_size as i64, offset).await {
+        Ok(list) => list,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let counts = match repo::shares::counts(ctx, &now).await {
+        Ok(c) => c,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let shares: Vec<serde_json::Value> = list
+        .records
+        .iter()
+        .map(|r| {
+            let expires_at = r.str_field("expires_at");
+            serde_json::json!({
+                "id": r.id,
+                "bucket": r.str_field("bucket"),
+                "key": r.str_field("key"),
+                "created_by": r.str_field("created_by"),
+                "created_at": r.str_field("created_at"),
+                "expires_at": (!expires_at.is_empty()).then_some(expires_at),
+                "expired": !expires_at.is_empty() && expires_at < now.as_str(),
+                "access_count": r.i64_field("access_count"),
+                "max_access_count": r.i64_field("max_access_count"),
+            })
+        })
+        .collect();
+    ok_json(&serde_json::json!({
+        "shares": shares,
+        "total": list.total_count,
+        "counts": counts,
+    }))
+}
+
+/// Revoke a share link; the token then answers 410 on direct access.
+async fn handle_admin_revoke_share(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let id = msg.var("id");
+    match repo::shares::revoke(ctx, id).await {
+        Ok(0) => err_not_found("Share not found or already revoked"),
+        Ok(_) => ok_json(&serde_json::json!({"revoked": true})),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
 async fn handle_access_logs(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let (page, page_size, _) = msg.pagination_params(50);
     let share_id = msg.query("share_id").to_string();
@@ -202,18 +335,11 @@ async fn handle_admin_quotas(ctx: &dyn Context, _msg: &Message) -> OutputStream
 }
 
 async fn handle_update_quota(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
-    let path = msg.path();
-    let user_id = path
-        .strip_prefix("/admin/b/cloudstorage/quotas/")
-        .unwrap_or("");
-    if user_id.is_empty() {
-        return err_bad_request("Missing user ID");
-    }
+    let user_id = msg.var("user_id");
 
-    let raw = input.collect_to_bytes().await;
-    let body: HashMap<String, serde_json::Value> = match serde_json::from_slice(&raw) {
+    let body: HashMap<String, serde_json::Value> = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
 
     // SEC-059: whitelist accepted quota fields — never forward arbitrary
@@ -238,15 +364,44 @@ async fn handle_update_quota(ctx: &dyn Context, msg: &Message, input: InputStrea
     }
 }
 
+/// Body `{max_storage_bytes}`: a byte cap for the team, or `null` to fall
+/// back to `SUPPERS_AI__FILES__TEAM_STORAGE_BYTES`.
+async fn handle_update_team_quota(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        max_storage_bytes: Option<i64>,
+    }
+    let body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    if body.max_storage_bytes.is_some_and(|n| n < 0) {
+        return err_bad_request("max_storage_bytes must not be negative");
+    }
+    let team_id = msg.var("id");
+    match repo::teams::set_max_storage_bytes(ctx, team_id, body.max_storage_bytes).await {
+        Ok(0) => err_not_found("Team not found"),
+        Ok(_) => ok_json(&super::quota::get_team_quota(ctx, team_id).await),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use std::sync::Arc;
 
     use wafer_core::interfaces::storage::service as storage_service;
-    use wafer_run::InputStream;
+    use wafer_run::{InputStream, OutputStream};
 
     use super::*;
-    use crate::test_support::{auth_msg, output_is_error, output_json, TestContext};
+    use crate::test_support::{
+        admin_msg, auth_msg, collect_or_panic, output_body, output_is_error, output_json,
+        output_status, TestContext,
+    };
 
     fn share_body(bucket: &str, key: &str) -> Vec<u8> {
         serde_json::to_vec(&serde_json::json!({ "bucket": bucket, "key": key })).unwrap()
@@ -281,7 +436,7 @@ mod tests {
                     key: key.to_string(),
                     size: 9,
                     content_type: "text/plain".to_string(),
-                    last_modified: chrono::Utc::now(),
+                    last_modified: crate::clock::now(),
                 },
             ))
         }
@@ -368,6 +523,33 @@ mod tests {
         ctx
     }
 
+    #[tokio::test]
+    async fn quota_response_includes_highest_crossed_threshold() {
+        let ctx = TestContext::with_files().await;
+        let quota = crate::util::json_map(serde_json::json!({
+            "user_id": "u1",
+            "max_storage_bytes": 1000,
+        }));
+        repo::quota::seed(&ctx, quota).await.expect("seed quota");
+        let object = crate::util::json_map(serde_json::json!({
+            "bucket": "photos",
+            "key": "a",
+            "size": 850,
+            "uploaded_by": "u1",
+        }));
+        repo::objects::seed(&ctx, object)
+            .await
+            .expect("seed object");
+
+        let msg = auth_msg("retrieve", "/b/cloudstorage/quota", "u1");
+        let resp = output_json(handle(&ctx, msg, InputStream::empty()).await).await;
+        assert_eq!(resp["threshold"], serde_json::json!(80));
+
+        let msg = auth_msg("retrieve", "/b/cloudstorage/quota", "u2");
+        let resp = output_json(handle(&ctx, msg, InputStream::empty()).await).await;
+        assert!(resp["threshold"].is_null());
+    }
+
     /// Regression (SEC-064): the share path used to inline its own bucket/key
     /// validation that OMITTED the backslash rejection, so a share could be
     /// created for a key the upload/download path (`is_valid_storage_key`)
@@ -491,7 +673,7 @@ mod tests {
             "expires_in_hours": 24,
         }))
         .unwrap();
-        let before = chrono::Utc::now();
+        let before = crate::clock::now();
         let out = handle_create_share(&ctx, &msg, InputStream::from_bytes(body)).await;
         let resp = output_json(out).await;
         let id = resp
@@ -518,4 +700,261 @@ mod tests {
             "expires_at should be ~24h in the future, got {expires_at}"
         );
     }
+
+    async fn seed_share(
+        ctx: &TestContext,
+        token: &str,
+        created_at: &str,
+        expires_at: Option<&str>,
+    ) -> String {
+        let mut data = crate::util::json_map(serde_json::json!({
+            "token": token,
+            "bucket": "b",
+            "key": "k",
+            "created_by": "u1",
+            "created_at": created_at,
+            "updated_at": created_at,
+            "access_count": 0,
+        }));
+        if let Some(exp) = expires_at {
+            data.insert("expires_at".into(), serde_json::json!(exp));
+        }
+        repo::shares::seed(ctx, data).await.expect("seed share").id
+    }
+
+    fn days_ago(days: i64) -> String {
+        crate::util::format_rfc3339(crate::clock::now() - chrono::Duration::days(days))
+    }
+
+    #[tokio::test]
+    async fn sweep_deletes_only_long_dead_shares() {
+        let ctx = TestContext::with_files().await;
+        let fresh = seed_share(&ctx, "fresh", &days_ago(1), Some(&days_ago(-1))).await;
+        let recently_expired = seed_share(&ctx, "recent", &days_ago(5), Some(&days_ago(3))).await;
+        seed_share(&ctx, "old", &days_ago(20), Some(&days_ago(10))).await;
+        let no_expiry = seed_share(&ctx, "open", &days_ago(20), None).await;
+        seed_share(&ctx, "lapsed", &days_ago(40), None).await;
+
+        assert_eq!(super::super::share::sweep_stale_shares(&ctx).await, 2);
+
+        let left: Vec<String> = repo::shares::list_recent(&ctx, 10, 0)
+            .await
+            .expect("list")
+            .records
+            .into_iter()
+            .map(|r| r.id)
+            .collect();
+        assert_eq!(left.len(), 3, "left: {left:?}");
+        for id in [&fresh, &recently_expired, &no_expiry] {
+            assert!(left.contains(id), "{id} should survive the sweep");
+        }
+    }
+
+    #[tokio::test]
+    async fn admin_revoke_hides_share_from_active_listing_and_counts() {
+        let ctx = TestContext::with_files().await;
+        let live = seed_share(&ctx, "live", &days_ago(1), None).await;
+        seed_share(&ctx, "stale", &days_ago(5), Some(&days_ago(1))).await;
+
+        let path = format!("/admin/b/cloudstorage/shares/{live}");
+        let out = handle(&ctx, admin_msg("delete", &path), InputStream::empty()).await;
+        assert_eq!(output_json(out).await["revoked"], true);
+
+        // A second revoke finds nothing left to revoke.
+        let out = handle(&ctx, admin_msg("delete", &path), InputStream::empty()).await;
+        assert!(output_is_error(out, "NotFound").await);
+
+        let msg = admin_msg("retrieve", "/admin/b/cloudstorage/shares/active");
+        let body = output_json(handle(&ctx, msg, InputStream::empty()).await).await;
+        let shares = body["shares"].as_array().expect("shares");
+        assert_eq!(shares.len(), 1);
+        assert_eq!(shares[0]["expired"], true);
+        assert_eq!(body["counts"]["active"], 0);
+        assert_eq!(body["counts"]["expired"], 1);
+        assert_eq!(body["counts"]["revoked"], 1);
+    }
+
+    #[tokio::test]
+    async fn revoked_share_answers_410_on_direct_access() {
+        let ctx = ctx_with_owned_bucket("my-bucket", "u1").await;
+        let msg = auth_msg("create", "/b/cloudstorage/shares", "u1");
+        let body = InputStream::from_bytes(share_body("my-bucket", "f"));
+        let out = handle_create_share(&ctx, &msg, body).await;
+        let resp = output_json(out).await;
+        let id = resp["id"].as_str().expect("id").to_string();
+        let url = resp["direct_url"].as_str().expect("direct_url").to_string();
+
+        let limiter = crate::blocks::rate_limit::UserRateLimiter::new();
+        let direct = crate::test_support::anon_msg("retrieve", &url);
+        let out = super::super::share::handle_direct_access(&ctx, &direct, &limiter).await;
+        assert_eq!(crate::test_support::output_status(out).await, 200);
+
+        repo::shares::revoke(&ctx, &id).await.expect("revoke");
+        let out = super::super::share::handle_direct_access(&ctx, &direct, &limiter).await;
+        assert_eq!(crate::test_support::output_status(out).await, 410);
+    }
+
+    /// Share `f` (served as `fake body`, 9 bytes) with `extra` merged into
+    /// the create body; returns the share id and its direct URL.
+    async fn share_f(ctx: &TestContext, extra: serde_json::Value) -> (String, String) {
+        let mut body = serde_json::json!({ "bucket": "my-bucket", "key": "f" });
+        body.as_object_mut()
+            .unwrap()
+            .extend(extra.as_object().unwrap().clone());
+        let msg = auth_msg("create", "/b/cloudstorage/shares", "u1");
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        let resp = output_json(handle_create_share(ctx, &msg, input).await).await;
+        (
+            resp["id"].as_str().expect("id").to_string(),
+            resp["direct_url"].as_str().expect("direct_url").to_string(),
+        )
+    }
+
+    async fn fetch(ctx: &TestContext, url: &str, range: Option<&str>) -> OutputStream {
+        let limiter = crate::blocks::rate_limit::UserRateLimiter::new();
+        let mut msg = crate::test_support::anon_msg("retrieve", url);
+        if let Some(range) = range {
+            msg.set_meta("http.header.range", range);
+        }
+        super::super::share::handle_direct_access(ctx, &msg, &limiter).await
+    }
+
+    #[tokio::test]
+    async fn ranged_resume_completes_without_spending_another_access() {
+        use wafer_run::{MetaGet, META_RESP_STATUS};
+
+        let ctx = ctx_with_owned_bucket("my-bucket", "u1").await;
+        let (id, url) = share_f(&ctx, serde_json::json!({ "max_access_count": 1 })).await;
+
+        let first = collect_or_panic(fetch(&ctx, &url, Some("bytes=0-3")).await).await;
+        assert_eq!(MetaGet::get(&first.meta, META_RESP_STATUS), Some("206"));
+        assert_eq!(
+            MetaGet::get(&first.meta, "resp.header.Content-Range"),
+            Some("bytes 0-3/9")
+        );
+        assert_eq!(first.body, b"fake");
+
+        // The connection dropped after 4 bytes; the client resumes.
+        let rest = collect_or_panic(fetch(&ctx, &url, Some("bytes=4-")).await).await;
+        assert_eq!(MetaGet::get(&rest.meta, META_RESP_STATUS), Some("206"));
+        assert_eq!(rest.body, b" body");
+
+        let share = repo::shares::find_by_id(&ctx, &id).await.unwrap();
+        assert_eq!(share.i64_field("access_count"), 1);
+        assert_eq!(share.i64_field("bytes_served"), 9);
+        assert!(!share.str_field("completed_at").is_empty());
+
+        // Served in full once, so a further range is a new access.
+        let out = fetch(&ctx, &url, Some("bytes=4-")).await;
+        assert!(output_is_error(out, "PermissionDenied").await);
+    }
+
+    #[tokio::test]
+    async fn ranges_past_the_served_offset_spend_an_access() {
+        let ctx = ctx_with_owned_bucket("my-bucket", "u1").await;
+        for (range, body) in [("bytes=-3", &b"ody"[..]), ("bytes=1-", &b"ake body"[..])] {
+            let (id, url) = share_f(&ctx, serde_json::json!({ "max_access_count": 1 })).await;
+            let out = collect_or_panic(fetch(&ctx, &url, Some(range)).await).await;
+            assert_eq!(out.body, body, "{range}");
+            let share = repo::shares::find_by_id(&ctx, &id).await.unwrap();
+            assert_eq!(share.i64_field("access_count"), 1, "{range}");
+        }
+    }
+
+    /// A link minted with `max_uses` is spent in the nonce store, whatever
+    /// its access cap.
+    #[tokio::test]
+    async fn links_with_max_uses_are_refused_once_spent() {
+        let ctx = ctx_with_owned_bucket("my-bucket", "u1").await;
+        let (_, url) = share_f(&ctx, serde_json::json!({ "max_uses": 1 })).await;
+        assert_eq!(output_status(fetch(&ctx, &url, None).await).await, 200);
+        assert_eq!(output_status(fetch(&ctx, &url, None).await).await, 409);
+
+        let msg = auth_msg("create", "/b/cloudstorage/shares", "u1");
+        let body = serde_json::json!({ "bucket": "my-bucket", "key": "f", "max_uses": 0 });
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        let out = handle_create_share(&ctx, &msg, input).await;
+        assert_eq!(output_status(out).await, 400);
+    }
+
+    /// A client that hangs up after the first chunk (4 bytes under test)
+    /// leaves the link incomplete.
+    #[tokio::test]
+    async fn download_cut_off_part_way_does_not_complete_the_link() {
+        use futures::StreamExt;
+        use wafer_block::stream::StreamEvent;
+
+        let ctx = ctx_with_owned_bucket("my-bucket", "u1").await;
+        let (id, url) = share_f(&ctx, serde_json::json!({})).await;
+
+        let mut out = fetch(&ctx, &url, None).await;
+        loop {
+            match out.next().await {
+                Some(StreamEvent::Meta(_)) => {}
+                Some(StreamEvent::Chunk(bytes)) => {
+                    assert_eq!(bytes, b"fake");
+                    break;
+                }
+                _ => panic!("expected a body chunk"),
+            }
+        }
+        drop(out);
+        tokio::task::yield_now().await;
+
+        let share = repo::shares::find_by_id(&ctx, &id).await.unwrap();
+        assert_eq!(share.i64_field("access_count"), 1);
+        assert!(share.i64_field("bytes_served") < 9);
+        assert!(share.str_field("completed_at").is_empty());
+    }
+
+    #[tokio::test]
+    async fn direct_access_rejects_a_concurrent_attempt_and_bad_ranges() {
+        let ctx = ctx_with_owned_bucket("my-bucket", "u1").await;
+        let (id, url) = share_f(&ctx, serde_json::json!({})).await;
+
+        let now = crate::clock::now();
+        let until = crate::util::format_rfc3339(now + chrono::Duration::seconds(60));
+        let now = crate::util::format_rfc3339(now);
+        let leased = repo::shares::begin_attempt(&ctx, &id, &now, &until).await;
+        assert!(leased.unwrap(), "an idle share takes the lease");
+        let out = fetch(&ctx, &url, None).await;
+        assert_eq!(output_status(out).await, 409);
+
+        repo::shares::end_attempt(&ctx, &id).await.unwrap();
+        let out = fetch(&ctx, &url, Some("bytes=20-")).await;
+        let buf = collect_or_panic(out).await;
+        assert_eq!(
+            wafer_run::MetaGet::get(&buf.meta, "resp.header.Content-Range"),
+            Some("bytes */9")
+        );
+        let out = fetch(&ctx, &url, None).await;
+        assert_eq!(output_body(out).await, b"fake body");
+    }
+
+    /// A download whose read fails — here cut off part way — answers 500
+    /// without spending an access, logging an access or charging bytes, and
+    /// releases the lease so the next attempt is served.
+    #[tokio::test]
+    async fn failed_read_spends_nothing_on_the_share() {
+        let mut ctx = ctx_with_owned_bucket("my-bucket", "u1").await;
+        let faulty = crate::storage_faults::wrap(Arc::new(AlwaysFoundStorageService));
+        ctx.register_block(
+            "wafer-run/storage",
+            crate::blocks::storage::create(faulty, Arc::from("suppers-ai/admin")),
+        );
+        let (id, url) = share_f(&ctx, serde_json::json!({ "max_access_count": 1 })).await;
+        let rule =
+            serde_json::json!({ "op": "get", "key": "my-bucket/*", "mode": "eof", "count": 1 });
+        crate::test_support::inject_storage_fault(rule).await;
+
+        assert_eq!(output_status(fetch(&ctx, &url, None).await).await, 500);
+        let share = repo::shares::find_by_id(&ctx, &id).await.unwrap();
+        assert_eq!(share.i64_field("access_count"), 0);
+        assert_eq!(share.i64_field("bytes_served"), 0);
+        let logs = repo::shares::list_access_logs(&ctx, Some(&id), 10, 0).await;
+        assert!(logs.unwrap().records.is_empty());
+
+        let out = fetch(&ctx, &url, None).await;
+        assert_eq!(output_body(out).await, b"fake body");
+    }
 }
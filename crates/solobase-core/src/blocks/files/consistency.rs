@@ -0,0 +1,1144 @@
+//! Storage consistency check: blobs without rows, and rows without blobs.
+//!
+//! A failed upload cleanup can leave a blob no object row points at, and a
+//! blob removed behind the server's back (or a write that never landed)
+//! leaves a row pointing at nothing, which nobody notices until its owner
+//! hits a 404. A check walks every bucket both ways — its storage listing
+//! against the rows, then its rows against storage — and records each
+//! problem as a finding:
+//!
+//! - an **orphaned blob** (`orphan_blob`), with its size, so the report
+//!   shows how much space deleting them would reclaim;
+//! - a **dangling row** (`dangling_row`), whose blob is gone.
+//!
+//! A run reports by default. It can also repair ([`Repairs`]):
+//!
+//! - delete orphaned blobs, or re-adopt them as objects under
+//!   [`RECOVERY_PREFIX`] owned by their bucket's owner (a blob's path names
+//!   no owner, so a bucket without one keeps its orphans). Either way only
+//!   blobs older than [`MIN_AGE_KEY`] are touched, so a delete or upload
+//!   still in flight is never mistaken for an orphan;
+//! - move dangling `complete` rows to `missing_blob`: listings skip them
+//!   and the owner gets a [`ObjectMissing`] notification. A later run that
+//!   finds the blob back returns the row to `complete`.
+//!
+//! # Runs
+//!
+//! Admins start a run with `POST /admin/storage/consistency/run` (body:
+//! [`Repairs`]) and read it back from `GET /admin/storage/consistency` and
+//! `.../consistency/findings?run_id=&kind=`. The built-in [`SCHEDULE`]
+//! starts one every week; it only reports unless [`AUTO_REPAIR_KEY`] is
+//! on, in which case it deletes old orphans and marks dangling rows.
+//!
+//! A run is a chain of [`CHECK_JOB`]s, each checking [`BATCH_SIZE_KEY`]
+//! blobs or rows of one bucket and queueing the next [`BATCH_DELAY_KEY`]
+//! seconds later, so a large store is checked without saturating its IO.
+//! The job payload is the whole cursor — bucket, pass, offset and running
+//! totals — so the run resumes wherever a restart left it, and its
+//! progress shows in the admin jobs list (`/b/admin/api/jobs`). The run row
+//! records the position of the next batch; a batch delivered twice finds
+//! it moved on and does nothing.
+//!
+//! The newest finished run's totals are part of the storage report
+//! (`files::report`) and the storage admin overview.
+
+use std::collections::HashSet;
+
+use wafer_core::clients::{config, database::Record, storage as store};
+use wafer_run::{context::Context, InputStream, Message, OutputStream, WaferError};
+
+use super::{blobs, repo};
+use crate::{
+    blocks::{
+        errors,
+        jobs::{EnqueueOptions, JobError},
+        notifications::NotificationPayload,
+    },
+    events::ObjectMissing,
+    http::{err_internal, err_not_found, ok_json},
+    services::Services,
+    util::RecordExt,
+};
+
+/// Job type checking one batch of a run.
+pub const CHECK_JOB: &str = "files.consistency.check";
+/// Job type of the daily blob scrub this check replaced. One still queued
+/// starts a scheduled run.
+pub const LEGACY_SCRUB_JOB: &str = "files.blobs.scrub";
+/// Name of the built-in schedule that starts a run.
+pub const SCHEDULE: &str = "files.consistency";
+
+/// Config key: blobs or rows one batch checks.
+pub(crate) const BATCH_SIZE_KEY: &str = "SUPPERS_AI__FILES__CONSISTENCY_BATCH_SIZE";
+/// Default for [`BATCH_SIZE_KEY`].
+pub(crate) const DEFAULT_BATCH_SIZE: &str = "200";
+/// Config key: seconds between two batches of a run.
+pub(crate) const BATCH_DELAY_KEY: &str = "SUPPERS_AI__FILES__CONSISTENCY_BATCH_DELAY_SECS";
+/// Default for [`BATCH_DELAY_KEY`].
+pub(crate) const DEFAULT_BATCH_DELAY: &str = "5";
+/// Config key: hours an orphaned blob must have gone unmodified before a
+/// repair deletes or adopts it.
+pub(crate) const MIN_AGE_KEY: &str = "SUPPERS_AI__FILES__ORPHAN_MIN_AGE_HOURS";
+/// Default for [`MIN_AGE_KEY`].
+pub(crate) const DEFAULT_MIN_AGE: &str = "24";
+/// Config key: whether scheduled runs repair as well as report.
+pub(crate) const AUTO_REPAIR_KEY: &str = "SUPPERS_AI__FILES__CONSISTENCY_AUTO_REPAIR";
+
+/// Folder re-adopted orphans are listed under, followed by their storage
+/// key.
+pub const RECOVERY_PREFIX: &str = "recovered/";
+
+/// Finding kind of a blob no row points at.
+pub const KIND_ORPHAN: &str = "orphan_blob";
+/// Finding kind of a row whose blob is gone.
+pub const KIND_DANGLING: &str = "dangling_row";
+
+const RUN_RUNNING: &str = "running";
+const RUN_DONE: &str = "done";
+
+/// A `running` run not advanced for this long is taken for abandoned (its
+/// job went dead), and no longer blocks a new one.
+const STALE_RUN_SECS: i64 = 3600;
+
+/// What a run does about orphaned blobs.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum OrphanRepair {
+    /// Record them only.
+    #[default]
+    Report,
+    /// Delete the ones past the age threshold.
+    Delete,
+    /// Re-adopt the ones past the age threshold under [`RECOVERY_PREFIX`].
+    Adopt,
+}
+
+/// The repairs a run makes besides recording its findings.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct Repairs {
+    #[serde(default)]
+    pub orphans: OrphanRepair,
+    /// Move dangling `complete` rows to `missing_blob`.
+    #[serde(default)]
+    pub mark_dangling: bool,
+}
+
+/// Which way a batch walks its bucket.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+#[serde(rename_all = "lowercase")]
+enum Pass {
+    /// The storage listing, against the rows.
+    #[default]
+    Blobs,
+    /// The rows, against storage.
+    Rows,
+}
+
+/// A run's running totals, as stored on its row.
+#[derive(Debug, Clone, Default, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+pub struct Totals {
+    pub blobs_scanned: i64,
+    pub rows_scanned: i64,
+    pub orphans: i64,
+    pub orphan_bytes: i64,
+    /// Orphans left in place, and their bytes.
+    pub reclaimable: i64,
+    pub reclaimable_bytes: i64,
+    pub dangling: i64,
+    pub deleted: i64,
+    pub adopted: i64,
+    pub marked: i64,
+    /// `missing_blob` rows whose blob was found again.
+    pub restored: i64,
+}
+
+impl Totals {
+    fn of(row: &Record) -> Self {
+        Self {
+            blobs_scanned: row.i64_field("blobs_scanned"),
+            rows_scanned: row.i64_field("rows_scanned"),
+            orphans: row.i64_field("orphans"),
+            orphan_bytes: row.i64_field("orphan_bytes"),
+            reclaimable: row.i64_field("reclaimable"),
+            reclaimable_bytes: row.i64_field("reclaimable_bytes"),
+            dangling: row.i64_field("dangling"),
+            deleted: row.i64_field("deleted"),
+            adopted: row.i64_field("adopted"),
+            marked: row.i64_field("marked"),
+            restored: row.i64_field("restored"),
+        }
+    }
+}
+
+/// Where a run is: the payload of its next [`CHECK_JOB`].
+#[derive(Debug, Clone, Default, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+struct Cursor {
+    run_id: String,
+    repairs: Repairs,
+    /// Bucket being checked; empty before the first.
+    bucket: String,
+    pass: Pass,
+    /// Entries of the pass already checked (blobs a repair deleted drop
+    /// out of the listing, so they don't count).
+    offset: i64,
+    #[serde(flatten)]
+    totals: Totals,
+}
+
+impl Cursor {
+    /// The run row's `position` while this cursor is the next batch.
+    fn position(&self) -> String {
+        let pass = match self.pass {
+            Pass::Blobs => "blobs",
+            Pass::Rows => "rows",
+        };
+        format!("{}:{pass}:{}", self.bucket, self.offset)
+    }
+}
+
+/// A run as the admin API and the storage report show it.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct RunSummary {
+    pub id: String,
+    pub status: String,
+    pub repairs: Repairs,
+    /// The admin who started it; empty for a scheduled run.
+    pub started_by: String,
+    #[serde(flatten)]
+    pub totals: Totals,
+    pub created_at: String,
+    pub finished_at: String,
+}
+
+impl RunSummary {
+    fn of(row: &Record) -> Self {
+        Self {
+            id: row.id.clone(),
+            status: row.str_field("status").to_string(),
+            repairs: serde_json::from_str(row.str_field("repairs")).unwrap_or_default(),
+            started_by: row.str_field("started_by").to_string(),
+            totals: Totals::of(row),
+            created_at: row.str_field("created_at").to_string(),
+            finished_at: row.str_field("finished_at").to_string(),
+        }
+    }
+}
+
+/// The newest finished run, for the storage report.
+pub(super) async fn last_finished(ctx: &dyn Context) -> Result<Option<RunSummary>, WaferError> {
+    Ok(repo::consistency::latest_run(ctx, RUN_DONE)
+        .await?
+        .as_ref()
+        .map(RunSummary::of))
+}
+
+/// Tunables read once per batch.
+struct Settings {
+    batch: i64,
+    delay: chrono::Duration,
+    min_age: chrono::Duration,
+}
+
+async fn positive(ctx: &dyn Context, key: &str, default: &str) -> i64 {
+    config::get_default(ctx, key, default)
+        .await
+        .trim()
+        .parse::<i64>()
+        .ok()
+        .filter(|n| *n >= 0)
+        .unwrap_or_else(|| default.parse().unwrap_or(0))
+}
+
+impl Settings {
+    async fn load(ctx: &dyn Context) -> Self {
+        Self {
+            batch: positive(ctx, BATCH_SIZE_KEY, DEFAULT_BATCH_SIZE)
+                .await
+                .max(1),
+            delay: chrono::Duration::seconds(
+                positive(ctx, BATCH_DELAY_KEY, DEFAULT_BATCH_DELAY).await,
+            ),
+            min_age: chrono::Duration::hours(positive(ctx, MIN_AGE_KEY, DEFAULT_MIN_AGE).await),
+        }
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Runs
+// ---------------------------------------------------------------------------
+
+/// Why a run could not be started.
+#[derive(Debug)]
+pub enum StartError {
+    /// Another run is still going.
+    Running(String),
+    Db(WaferError),
+}
+
+impl From<WaferError> for StartError {
+    fn from(e: WaferError) -> Self {
+        StartError::Db(e)
+    }
+}
+
+/// The run still going, if any. One not advanced for [`STALE_RUN_SECS`]
+/// doesn't count.
+async fn active_run(ctx: &dyn Context) -> Result<Option<Record>, WaferError> {
+    let cutoff = crate::clock::now() - chrono::Duration::seconds(STALE_RUN_SECS);
+    Ok(repo::consistency::latest_run(ctx, RUN_RUNNING)
+        .await?
+        .filter(|run| {
+            crate::util::parse_timestamp(run.str_field("updated_at")).is_some_and(|at| at > cutoff)
+        }))
+}
+
+/// Start a run with `repairs` and queue its first batch. Returns the run
+/// id.
+pub async fn start(
+    ctx: &dyn Context,
+    repairs: Repairs,
+    started_by: &str,
+) -> Result<String, StartError> {
+    if let Some(run) = active_run(ctx).await? {
+        return Err(StartError::Running(run.id));
+    }
+    let cursor = Cursor {
+        repairs,
+        ..Default::default()
+    };
+    let data = crate::util::json_map(serde_json::json!({
+        "status": RUN_RUNNING,
+        "repairs": serde_json::to_string(&repairs).unwrap_or_default(),
+        "started_by": started_by,
+        "position": cursor.position(),
+    }));
+    let run = repo::consistency::insert_run(ctx, data).await?;
+    let cursor = Cursor {
+        run_id: run.id.clone(),
+        ..cursor
+    };
+    queue(ctx, &cursor, chrono::Duration::zero()).await?;
+    Ok(run.id)
+}
+
+async fn queue(
+    ctx: &dyn Context,
+    cursor: &Cursor,
+    delay: chrono::Duration,
+) -> Result<String, WaferError> {
+    let payload = serde_json::to_value(cursor).unwrap_or_default();
+    let opts = EnqueueOptions {
+        delay: (delay > chrono::Duration::zero()).then_some(delay),
+        ..Default::default()
+    };
+    Services::new(ctx, "suppers-ai/files")
+        .jobs()
+        .enqueue(CHECK_JOB, &payload, opts)
+        .await
+}
+
+/// Run a [`CHECK_JOB`] (or a still-queued [`LEGACY_SCRUB_JOB`]). A payload
+/// without a run — the schedule's — starts a scheduled run, unless one is
+/// already going; otherwise the job checks the cursor's batch and queues
+/// the next.
+pub async fn run_job(ctx: &dyn Context, input: InputStream) -> Result<(), JobError> {
+    let raw = input.collect_to_bytes().await;
+    let payload: serde_json::Value = serde_json::from_slice(&raw).unwrap_or_default();
+    if payload.get("run_id").is_none() {
+        let repairs = if config::get_default(ctx, AUTO_REPAIR_KEY, "false").await == "true" {
+            Repairs {
+                orphans: OrphanRepair::Delete,
+                mark_dangling: true,
+            }
+        } else {
+            Repairs::default()
+        };
+        return match start(ctx, repairs, "").await {
+            Ok(_) => Ok(()),
+            Err(StartError::Running(id)) => {
+                tracing::info!(
+                    run = %id,
+                    "consistency check: a run is already going; not starting another"
+                );
+                Ok(())
+            }
+            Err(StartError::Db(e)) => Err(e.into()),
+        };
+    }
+    let mut cursor: Cursor = serde_json::from_value(payload)
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+    let settings = Settings::load(ctx).await;
+    if run_batch(ctx, &mut cursor, &settings).await? {
+        queue(ctx, &cursor, settings.delay).await?;
+    }
+    Ok(())
+}
+
+/// Check the batch at `cursor` and record it on the run row. Returns
+/// whether a batch follows: `false` once the run finished, and for a batch
+/// the run has already moved past.
+async fn run_batch(
+    ctx: &dyn Context,
+    cursor: &mut Cursor,
+    settings: &Settings,
+) -> Result<bool, JobError> {
+    let Some(run) = repo::consistency::get_run(ctx, &cursor.run_id).await? else {
+        return Err(JobError::permanent("consistency run not found"));
+    };
+    if run.str_field("status") != RUN_RUNNING || run.str_field("position") != cursor.position() {
+        tracing::info!(run = %cursor.run_id, "consistency check: batch already done");
+        return Ok(false);
+    }
+
+    let finished = step(ctx, cursor, settings).await?;
+    let mut data = crate::util::json_map(serde_json::to_value(&cursor.totals).unwrap_or_default());
+    if finished {
+        data.insert("status".to_string(), RUN_DONE.into());
+        data.insert("finished_at".to_string(), crate::util::now_rfc3339().into());
+        tracing::info!(
+            run = %cursor.run_id,
+            totals = ?cursor.totals,
+            "consistency check: run finished"
+        );
+    } else {
+        data.insert("position".to_string(), cursor.position().into());
+    }
+    repo::consistency::update_run(ctx, &cursor.run_id, data).await?;
+    Ok(!finished)
+}
+
+/// Check one batch at `cursor` and move it on. Returns `true` once every
+/// bucket has been checked both ways.
+async fn step(
+    ctx: &dyn Context,
+    cursor: &mut Cursor,
+    settings: &Settings,
+) -> Result<bool, WaferError> {
+    if cursor.bucket.is_empty() {
+        match next_bucket(ctx, "").await? {
+            Some(bucket) => cursor.bucket = bucket,
+            None => return Ok(true),
+        }
+    }
+    let pass_done = match cursor.pass {
+        Pass::Blobs => check_blobs(ctx, cursor, settings).await?,
+        Pass::Rows => check_rows(ctx, cursor, settings).await?,
+    };
+    if pass_done {
+        cursor.offset = 0;
+        match cursor.pass {
+            Pass::Blobs => cursor.pass = Pass::Rows,
+            Pass::Rows => match next_bucket(ctx, &cursor.bucket).await? {
+                Some(bucket) => {
+                    cursor.bucket = bucket;
+                    cursor.pass = Pass::Blobs;
+                }
+                None => return Ok(true),
+            },
+        }
+    }
+    Ok(false)
+}
+
+/// The first bucket, by name, after `after`. Buckets created mid-run are
+/// checked if they sort later.
+async fn next_bucket(ctx: &dyn Context, after: &str) -> Result<Option<String>, WaferError> {
+    Ok(repo::buckets::list_visible(ctx, None)
+        .await?
+        .iter()
+        .map(|b| b.str_field("name"))
+        .filter(|name| *name > after)
+        .min()
+        .map(str::to_string))
+}
+
+/// `(uploaded_by, team_id)` for orphans adopted into `bucket`: its creator,
+/// and its team for a team bucket. `None` when nobody owns it.
+async fn adoption_owner(
+    ctx: &dyn Context,
+    bucket: &str,
+) -> Result<Option<(String, String)>, WaferError> {
+    Ok(repo::buckets::find_by_name(ctx, bucket)
+        .await?
+        .map(|b| {
+            (
+                b.str_field("created_by").to_string(),
+                b.str_field("team_id").to_string(),
+            )
+        })
+        .filter(|(created_by, _)| !created_by.is_empty()))
+}
+
+/// One finding's fields.
+struct Finding<'a> {
+    kind: &'a str,
+    storage_key: &'a str,
+    object_id: &'a str,
+    object_key: &'a str,
+    size: i64,
+    blob_modified_at: String,
+    repair: &'a str,
+}
+
+async fn record(ctx: &dyn Context, cursor: &Cursor, f: Finding<'_>) {
+    let data = crate::util::json_map(serde_json::json!({
+        "run_id": cursor.run_id,
+        "bucket": cursor.bucket,
+        "kind": f.kind,
+        "storage_key": f.storage_key,
+        "object_id": f.object_id,
+        "object_key": f.object_key,
+        "size": f.size,
+        "blob_modified_at": f.blob_modified_at,
+        "repair": f.repair,
+    }));
+    if let Err(e) = repo::consistency::insert_finding(ctx, data).await {
+        tracing::warn!(
+            error = %e,
+            bucket = %cursor.bucket,
+            key = %f.storage_key,
+            "consistency check: recording finding failed"
+        );
+    }
+}
+
+/// Check one page of the bucket's storage listing against its rows.
+/// Returns `true` at the end of the listing.
+async fn check_blobs(
+    ctx: &dyn Context,
+    cursor: &mut Cursor,
+    settings: &Settings,
+) -> Result<bool, WaferError> {
+    let bucket = cursor.bucket.clone();
+    let opts = store::ListOptions {
+        prefix: String::new(),
+        limit: settings.batch,
+        offset: cursor.offset,
+    };
+    let page = store::list(ctx, &bucket, &opts).await?.objects;
+    cursor.totals.blobs_scanned += page.len() as i64;
+
+    // A blob is tracked by the row it's the id key of, a row pointing at it
+    // by storage key, or a legacy row at its object key.
+    let (ids, named): (Vec<&store::ObjectInfo>, Vec<&store::ObjectInfo>) = page
+        .iter()
+        .filter(|o| !o.key.ends_with('/'))
+        .partition(|o| blobs::blob_id(&o.key).is_some());
+    let ids: Vec<String> = ids
+        .iter()
+        .filter_map(|o| blobs::blob_id(&o.key).map(str::to_string))
+        .collect();
+    let named: Vec<String> = named.iter().map(|o| o.key.clone()).collect();
+    let mut tracked: HashSet<String> = repo::objects::find_by_ids(ctx, &bucket, &ids)
+        .await?
+        .iter()
+        .map(|r| repo::objects::blob_key(&r.id))
+        .collect();
+    for row in repo::objects::find_by_storage_keys(ctx, &bucket, &named).await? {
+        tracked.insert(row.str_field("storage_key").to_string());
+    }
+    for row in repo::objects::find_by_keys(ctx, &bucket, &named).await? {
+        tracked.insert(repo::objects::storage_key(&row).to_string());
+    }
+
+    let cutoff = crate::clock::now() - settings.min_age;
+    let mut owner = None;
+    let mut removed = 0;
+    for blob in &page {
+        if blob.key.ends_with('/') || tracked.contains(&blob.key) {
+            continue;
+        }
+        cursor.totals.orphans += 1;
+        cursor.totals.orphan_bytes += blob.size;
+        let old_enough = blob.last_modified < cutoff;
+        let repair = match cursor.repairs.orphans {
+            OrphanRepair::Delete if old_enough => {
+                match store::delete(ctx, &bucket, &blob.key).await {
+                    Ok(()) => {
+                        removed += 1;
+                        cursor.totals.deleted += 1;
+                        "deleted"
+                    }
+                    Err(e) => {
+                        tracing::warn!(
+                            error = %e,
+                            bucket = %bucket,
+                            key = %blob.key,
+                            "consistency check: deleting orphan failed"
+                        );
+                        ""
+                    }
+                }
+            }
+            OrphanRepair::Adopt if old_enough => {
+                if owner.is_none() {
+                    owner = Some(adoption_owner(ctx, &bucket).await?);
+                }
+                match owner.as_ref().and_then(Option::as_ref) {
+                    Some((uploaded_by, team_id)) => {
+                        match adopt(ctx, &bucket, blob, uploaded_by, team_id).await {
+                            Ok(()) => {
+                                cursor.totals.adopted += 1;
+                                "adopted"
+                            }
+                            Err(e) => {
+                                tracing::warn!(
+                                    error = %e,
+                                    bucket = %bucket,
+                                    key = %blob.key,
+                                    "consistency check: adopting orphan failed"
+                                );
+                                ""
+                            }
+                        }
+                    }
+                    None => "",
+                }
+            }
+            _ => "",
+        };
+        if repair.is_empty() {
+            cursor.totals.reclaimable += 1;
+            cursor.totals.reclaimable_bytes += blob.size;
+        }
+        let finding = Finding {
+            kind: KIND_ORPHAN,
+            storage_key: &blob.key,
+            object_id: blobs::blob_id(&blob.key).unwrap_or_default(),
+            object_key: "",
+            size: blob.size,
+            blob_modified_at: crate::util::format_rfc3339(blob.last_modified),
+            repair,
+        };
+        record(ctx, cursor, finding).await;
+    }
+    cursor.offset += page.len() as i64 - removed;
+    Ok((page.len() as i64) < settings.batch)
+}
+
+/// Give the orphan `blob` a row at [`RECOVERY_PREFIX`] + its storage key,
+/// so it lists again. An id-layout blob gets back the row id its key names.
+async fn adopt(
+    ctx: &dyn Context,
+    bucket: &str,
+    blob: &store::ObjectInfo,
+    uploaded_by: &str,
+    team_id: &str,
+) -> Result<(), WaferError> {
+    repo::objects::insert_recovered(
+        ctx,
+        blobs::blob_id(&blob.key),
+        bucket,
+        &format!("{RECOVERY_PREFIX}{}", blob.key),
+        &blob.key,
+        blob.size,
+        &blob.content_type,
+        uploaded_by,
+        team_id,
+    )
+    .await
+    .map(|_| ())
+}
+
+/// Check one page of the bucket's rows against storage. Returns `true`
+/// after the last row.
+async fn check_rows(
+    ctx: &dyn Context,
+    cursor: &mut Cursor,
+    settings: &Settings,
+) -> Result<bool, WaferError> {
+    let bucket = cursor.bucket.clone();
+    let rows = repo::objects::list_for_check(ctx, &bucket, settings.batch, cursor.offset).await?;
+    cursor.totals.rows_scanned += rows.len() as i64;
+    for row in &rows {
+        let status = row.str_field("status");
+        let present = blobs::stored(ctx, &bucket, repo::objects::storage_key(row)).await?;
+        if present {
+            if status == repo::objects::STATUS_MISSING_BLOB
+                && repo::objects::transition(
+                    ctx,
+                    &row.id,
+                    status,
+                    "complete",
+                    row.str_field("metadata"),
+                )
+                .await?
+            {
+                cursor.totals.restored += 1;
+            }
+            continue;
+        }
+        cursor.totals.dangling += 1;
+        // Only live rows are marked: archived, quarantined and scan-held
+        // ones are hidden already, and a found blob returns a marked row
+        // to `complete`.
+        let repair = if cursor.repairs.mark_dangling
+            && status == "complete"
+            && repo::objects::transition(
+                ctx,
+                &row.id,
+                status,
+                repo::objects::STATUS_MISSING_BLOB,
+                row.str_field("metadata"),
+            )
+            .await?
+        {
+            cursor.totals.marked += 1;
+            warn_owner(ctx, row).await;
+            "marked"
+        } else {
+            ""
+        };
+        let finding = Finding {
+            kind: KIND_DANGLING,
+            storage_key: repo::objects::storage_key(row),
+            object_id: &row.id,
+            object_key: row.str_field("key"),
+            size: row.i64_field("size"),
+            blob_modified_at: String::new(),
+            repair,
+        };
+        record(ctx, cursor, finding).await;
+    }
+    cursor.offset += rows.len() as i64;
+    Ok((rows.len() as i64) < settings.batch)
+}
+
+/// Tell a marked row's uploader their file is gone. Best-effort.
+async fn warn_owner(ctx: &dyn Context, row: &Record) {
+    let user_id = row.str_field("uploaded_by");
+    if user_id.is_empty() {
+        return;
+    }
+    let (bucket, key) = (row.str_field("bucket"), row.str_field("key"));
+    let payload = NotificationPayload {
+        title: format!("A file in {bucket} is missing"),
+        body: format!(
+            "The contents of {key} could not be found in storage, so it has been hidden. \
+             Upload it again, or delete it to free its quota."
+        ),
+        ..Default::default()
+    };
+    let event = ObjectMissing {
+        bucket: bucket.to_string(),
+        key: key.to_string(),
+        object_id: row.id.clone(),
+    };
+    if let Err(e) = Services::new(ctx, "suppers-ai/files")
+        .notifications()
+        .notify_event(user_id, &event, payload)
+        .await
+    {
+        tracing::warn!(error = %e, id = %row.id, "missing file notice failed");
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Admin API
+// ---------------------------------------------------------------------------
+
+/// `POST /admin/storage/consistency/run` — start a run with the posted
+/// [`Repairs`] (`{}` only reports).
+async fn handle_run(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let repairs: Repairs = match crate::body::decode(msg, input).await {
+        Ok(r) => r,
+        Err(r) => return r,
+    };
+    match start(ctx, repairs, msg.user_id()).await {
+        Ok(run_id) => ok_json(&serde_json::json!({ "run_id": run_id, "repairs": repairs })),
+        Err(StartError::Running(id)) => errors::error_json(
+            errors::ErrorCode::Conflict,
+            "A consistency check is already running",
+            Some(serde_json::json!({ "run_id": id })),
+        ),
+        Err(StartError::Db(e)) => err_internal("Database error", e),
+    }
+}
+
+/// `GET /admin/storage/consistency` — the newest run, going or finished.
+async fn handle_latest(ctx: &dyn Context) -> OutputStream {
+    match repo::consistency::latest_run(ctx, "").await {
+        Ok(run) => ok_json(&serde_json::json!({ "run": run.as_ref().map(RunSummary::of) })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `GET /admin/storage/consistency/findings?run_id=&kind=&page=&page_size=`
+/// — a run's findings (the newest run's without `run_id`), oldest first.
+async fn handle_findings(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let kind = msg.query("kind");
+    if !kind.is_empty() && kind != KIND_ORPHAN && kind != KIND_DANGLING {
+        return errors::validation_error(
+            "Invalid finding kind",
+            &[("kind", "must be orphan_blob or dangling_row")],
+        );
+    }
+    let run_id = match msg.query("run_id") {
+        "" => match repo::consistency::latest_run(ctx, "").await {
+            Ok(Some(run)) => run.id,
+            Ok(None) => return err_not_found("No consistency check has run"),
+            Err(e) => return err_internal("Database error", e),
+        },
+        id => id.to_string(),
+    };
+    let (page, page_size, _) = msg.pagination_params(50);
+    match repo::consistency::list_findings(ctx, &run_id, kind, page as i64, page_size as i64).await
+    {
+        Ok(list) => ok_json(&list),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// Route the consistency part of the admin storage API; hands `input` back
+/// when `path` is not a consistency path.
+pub async fn handle_admin(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> Result<OutputStream, InputStream> {
+    Ok(match (msg.action(), msg.path()) {
+        ("create", "/admin/storage/consistency/run") => handle_run(ctx, msg, input).await,
+        ("retrieve", "/admin/storage/consistency") => handle_latest(ctx).await,
+        ("retrieve", "/admin/storage/consistency/findings") => handle_findings(ctx, msg).await,
+        _ => return Err(input),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+
+    use super::*;
+    use crate::test_support::{admin_msg, output_json, output_status, TestContext};
+
+    async fn ctx() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        ctx
+    }
+
+    async fn seed_bucket(ctx: &TestContext, name: &str, owner: &str) {
+        let data = crate::util::json_map(json!({
+            "name": name,
+            "public": false,
+            "created_by": owner,
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(ctx, data).await.expect("seed bucket");
+    }
+
+    async fn put(ctx: &TestContext, bucket: &str, key: &str, data: &[u8]) {
+        store::put(ctx, bucket, key, data, "text/plain")
+            .await
+            .expect("put blob");
+    }
+
+    /// A complete row whose blob is gone.
+    async fn seed_dangling(ctx: &TestContext, bucket: &str, key: &str) -> Record {
+        let row = blobs::seed(ctx, bucket, key, b"gone", "text/plain", "alice").await;
+        store::delete(ctx, bucket, &repo::objects::blob_key(&row.id))
+            .await
+            .expect("delete blob");
+        row
+    }
+
+    /// Start a run with `repairs` and check it batch by batch, as its job
+    /// chain would, returning its row once it finished.
+    async fn check(ctx: &TestContext, repairs: Repairs, batch: i64) -> Record {
+        let run_id = start(ctx, repairs, "admin_1").await.expect("start run");
+        let settings = Settings {
+            batch,
+            delay: chrono::Duration::zero(),
+            min_age: chrono::Duration::hours(24),
+        };
+        let mut cursor = Cursor {
+            run_id: run_id.clone(),
+            repairs,
+            ..Default::default()
+        };
+        while run_batch(ctx, &mut cursor, &settings).await.expect("batch") {}
+        let run = repo::consistency::get_run(ctx, &run_id)
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(run.str_field("status"), RUN_DONE);
+        run
+    }
+
+    async fn status_of(ctx: &TestContext, id: &str) -> String {
+        repo::objects::get(ctx, id)
+            .await
+            .unwrap()
+            .str_field("status")
+            .to_string()
+    }
+
+    /// A report-only run finds an orphaned blob and a dangling row in each
+    /// direction, records them as findings, and changes nothing.
+    #[tokio::test]
+    async fn report_run_records_orphans_and_dangling_rows() {
+        let ctx = ctx().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        blobs::seed(&ctx, "docs", "a.txt", b"kept", "text/plain", "alice").await;
+        put(&ctx, "docs", "stray/left-over.bin", b"0123456789").await;
+        let dangling = seed_dangling(&ctx, "docs", "b.txt").await;
+
+        let run = check(&ctx, Repairs::default(), 50).await;
+        let totals = Totals::of(&run);
+        assert_eq!(totals.blobs_scanned, 2);
+        assert_eq!(totals.rows_scanned, 2);
+        assert_eq!((totals.orphans, totals.orphan_bytes), (1, 10));
+        assert_eq!((totals.reclaimable, totals.reclaimable_bytes), (1, 10));
+        assert_eq!(totals.dangling, 1);
+        assert_eq!(totals.deleted + totals.adopted + totals.marked, 0);
+
+        assert!(blobs::stored(&ctx, "docs", "stray/left-over.bin")
+            .await
+            .unwrap());
+        assert_eq!(status_of(&ctx, &dangling.id).await, "complete");
+
+        let findings = output_json(
+            handle_admin(
+                &ctx,
+                &admin_msg("retrieve", "/admin/storage/consistency/findings"),
+                InputStream::empty(),
+            )
+            .await
+            .unwrap_or_else(|_| panic!("not routed")),
+        )
+        .await;
+        let mut kinds: Vec<(&str, &str)> = findings["records"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|f| {
+                let f = &f["data"];
+                let key = if f["kind"] == KIND_ORPHAN {
+                    &f["storage_key"]
+                } else {
+                    &f["object_id"]
+                };
+                (f["kind"].as_str().unwrap(), key.as_str().unwrap())
+            })
+            .collect();
+        kinds.sort();
+        assert_eq!(
+            kinds,
+            vec![
+                (KIND_DANGLING, dangling.id.as_str()),
+                (KIND_ORPHAN, "stray/left-over.bin"),
+            ]
+        );
+    }
+
+    /// The delete repair removes orphans older than the threshold and
+    /// leaves younger ones (possibly an upload in flight) reclaimable.
+    #[tokio::test]
+    async fn delete_repair_spares_young_orphans() {
+        let ctx = ctx().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        crate::clock::freeze(chrono::Utc::now());
+        put(&ctx, "docs", "old.bin", b"old").await;
+        crate::clock::advance(chrono::Duration::hours(48));
+        put(&ctx, "docs", "new.bin", b"new!").await;
+
+        let repairs = Repairs {
+            orphans: OrphanRepair::Delete,
+            mark_dangling: false,
+        };
+        let totals = Totals::of(&check(&ctx, repairs, 50).await);
+        assert_eq!((totals.orphans, totals.deleted), (2, 1));
+        assert_eq!((totals.reclaimable, totals.reclaimable_bytes), (1, 4));
+        assert!(!blobs::stored(&ctx, "docs", "old.bin").await.unwrap());
+        assert!(blobs::stored(&ctx, "docs", "new.bin").await.unwrap());
+    }
+
+    /// The adopt repair lists an old orphan under `recovered/`, owned by
+    /// the bucket's creator, and a later run no longer finds it.
+    #[tokio::test]
+    async fn adopt_repair_recovers_orphans_for_the_bucket_owner() {
+        let ctx = ctx().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        crate::clock::freeze(chrono::Utc::now());
+        put(&ctx, "docs", "lost/notes.txt", b"notes").await;
+        crate::clock::advance(chrono::Duration::hours(48));
+
+        let repairs = Repairs {
+            orphans: OrphanRepair::Adopt,
+            mark_dangling: false,
+        };
+        assert_eq!(Totals::of(&check(&ctx, repairs, 50).await).adopted, 1);
+
+        let key = format!("{RECOVERY_PREFIX}lost/notes.txt");
+        let rows = repo::objects::find_by_keys(&ctx, "docs", &[key.clone()])
+            .await
+            .unwrap();
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0].str_field("uploaded_by"), "alice");
+        assert_eq!(rows[0].str_field("storage_key"), "lost/notes.txt");
+        let (data, _) = blobs::get(&ctx, "docs", &key).await.unwrap();
+        assert_eq!(data, b"notes");
+
+        assert_eq!(Totals::of(&check(&ctx, repairs, 50).await).orphans, 0);
+    }
+
+    /// A marked row drops out of listings; once its blob is back, the next
+    /// run returns it to `complete`.
+    #[tokio::test]
+    async fn marked_rows_are_hidden_until_their_blob_returns() {
+        let ctx = ctx().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        let row = seed_dangling(&ctx, "docs", "report.pdf").await;
+
+        let repairs = Repairs {
+            orphans: OrphanRepair::Report,
+            mark_dangling: true,
+        };
+        assert_eq!(Totals::of(&check(&ctx, repairs, 50).await).marked, 1);
+        assert_eq!(
+            status_of(&ctx, &row.id).await,
+            repo::objects::STATUS_MISSING_BLOB
+        );
+        let listed = repo::objects::list_under(&ctx, "docs", "", true, 50, 0)
+            .await
+            .unwrap();
+        assert!(listed.records.is_empty());
+
+        put(&ctx, "docs", &repo::objects::blob_key(&row.id), b"back").await;
+        let totals = Totals::of(&check(&ctx, repairs, 50).await);
+        assert_eq!((totals.restored, totals.dangling), (1, 0));
+        assert_eq!(status_of(&ctx, &row.id).await, "complete");
+    }
+
+    /// Small batches walk every bucket both ways, resuming each page where
+    /// the last stopped — deleted blobs included.
+    #[tokio::test]
+    async fn small_batches_cover_every_bucket() {
+        let ctx = ctx().await;
+        crate::clock::freeze(chrono::Utc::now());
+        for bucket in ["alpha", "beta"] {
+            seed_bucket(&ctx, bucket, "alice").await;
+            for i in 0..3 {
+                put(&ctx, bucket, &format!("orphan-{i}"), b"x").await;
+                blobs::seed(
+                    &ctx,
+                    bucket,
+                    &format!("kept-{i}"),
+                    b"y",
+                    "text/plain",
+                    "alice",
+                )
+                .await;
+            }
+        }
+        crate::clock::advance(chrono::Duration::hours(48));
+
+        let repairs = Repairs {
+            orphans: OrphanRepair::Delete,
+            mark_dangling: false,
+        };
+        let totals = Totals::of(&check(&ctx, repairs, 2).await);
+        assert_eq!(totals.blobs_scanned, 12);
+        assert_eq!(totals.rows_scanned, 6);
+        assert_eq!((totals.orphans, totals.deleted), (6, 6));
+        for bucket in ["alpha", "beta"] {
+            let opts = store::ListOptions {
+                prefix: String::new(),
+                limit: 50,
+                offset: 0,
+            };
+            let left = store::list(&ctx, bucket, &opts).await.unwrap();
+            assert_eq!(left.objects.len(), 3, "{bucket}");
+        }
+    }
+
+    /// A batch delivered again after the run moved past it does nothing.
+    #[tokio::test]
+    async fn repeated_batches_are_skipped() {
+        let ctx = ctx().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        put(&ctx, "docs", "stray.bin", b"x").await;
+        let run_id = start(&ctx, Repairs::default(), "").await.unwrap();
+        let settings = Settings {
+            batch: 50,
+            delay: chrono::Duration::zero(),
+            min_age: chrono::Duration::hours(24),
+        };
+        let first = Cursor {
+            run_id: run_id.clone(),
+            ..Default::default()
+        };
+
+        assert!(run_batch(&ctx, &mut first.clone(), &settings)
+            .await
+            .unwrap());
+        assert!(!run_batch(&ctx, &mut first.clone(), &settings)
+            .await
+            .unwrap());
+        let run = repo::consistency::get_run(&ctx, &run_id)
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(Totals::of(&run).blobs_scanned, 1);
+    }
+
+    async fn post_run(ctx: &TestContext, body: serde_json::Value) -> OutputStream {
+        let msg = admin_msg("create", "/admin/storage/consistency/run");
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        handle_admin(ctx, &msg, input)
+            .await
+            .unwrap_or_else(|_| panic!("not routed"))
+    }
+
+    /// The admin API starts one run at a time and reports the latest; the
+    /// storage report carries the last finished run's totals.
+    #[tokio::test]
+    async fn admin_api_starts_and_reports_runs() {
+        let ctx = ctx().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        put(&ctx, "docs", "stray.bin", b"12345").await;
+
+        let started = output_json(post_run(&ctx, json!({})).await).await;
+        let run_id = started["run_id"].as_str().unwrap().to_string();
+        assert_eq!(output_status(post_run(&ctx, json!({})).await).await, 409);
+        assert_eq!(
+            output_status(post_run(&ctx, json!({"orphans": "shred"})).await).await,
+            400
+        );
+
+        let latest = output_json(
+            handle_admin(
+                &ctx,
+                &admin_msg("retrieve", "/admin/storage/consistency"),
+                InputStream::empty(),
+            )
+            .await
+            .ok()
+            .unwrap(),
+        )
+        .await;
+        assert_eq!(latest["run"]["id"], run_id.as_str());
+        assert_eq!(latest["run"]["status"], RUN_RUNNING);
+
+        let settings = Settings::load(&ctx).await;
+        let mut cursor = Cursor {
+            run_id,
+            ..Default::default()
+        };
+        while run_batch(&ctx, &mut cursor, &settings).await.unwrap() {}
+        let summary = last_finished(&ctx).await.unwrap().unwrap();
+        assert_eq!(summary.totals.reclaimable_bytes, 5);
+        assert_eq!(summary.started_by, "admin_1");
+    }
+
+    /// Paths outside the consistency API hand their input back.
+    #[tokio::test]
+    async fn other_paths_fall_through() {
+        let ctx = ctx().await;
+        let msg = admin_msg("retrieve", "/admin/storage/lifecycle/runs");
+        assert!(handle_admin(&ctx, &msg, InputStream::empty())
+            .await
+            .is_err());
+    }
+}
@@ -0,0 +1,267 @@
+//! Download statistics for one object.
+//!
+//! Every time an object's bytes are served from the start — the storage
+//! API, an S3 `GetObject` without a range or with one from byte 0, a
+//! bucket website page (`website`), or a share link that isn't resuming
+//! an unfinished attempt — its row's
+//! `download_count` goes up by one and `last_downloaded_at` is stamped
+//! ([`repo::objects::record_download`]). A ranged retry of a download
+//! already counted, a `HEAD`, and a refused request don't count. The
+//! bucket listing and object info return `download_count` to the bucket's
+//! owner and admins.
+//!
+//! `GET /b/storage/api/buckets/{name}/stats/{id}` — the counters of the
+//! object with row id `id`, for the bucket's owner and admins. While the
+//! share access log is on ([`share::ACCESS_LOG_KEY`]) it adds `daily`,
+//! counted downloads per UTC day over the last [`SERIES_DAYS`] days, and
+//! `top_shares`, the links most of those came through. The access log only
+//! records share-link downloads, so both leave out the storage API and S3.
+
+use std::collections::HashMap;
+
+use chrono::{Duration, NaiveDate};
+use wafer_core::clients::database::Record;
+use wafer_run::{context::Context, ErrorCode, Message, OutputStream};
+
+use super::{repo, share, storage};
+use crate::{
+    blocks::errors,
+    http::{err_bad_request, err_forbidden, err_internal, ok_json},
+    util::RecordExt,
+};
+
+/// Days the daily series covers, today included.
+pub(super) const SERIES_DAYS: i64 = 30;
+
+/// Most share links `top_shares` lists.
+const TOP_SHARES: usize = 5;
+
+/// Most of an object's share links whose access rows are read.
+const MAX_SHARES: i64 = 500;
+
+pub(super) async fn handle(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if !storage::is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+    let id = msg.var("id");
+    if id.is_empty() {
+        return err_bad_request("Missing object ID");
+    }
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let row = match repo::objects::get(ctx, id).await {
+        Ok(row) if row.str_field("bucket") == bucket && row.str_field("status") != "pending" => row,
+        Ok(_) => return not_found(),
+        Err(e) if e.code == ErrorCode::NotFound => return not_found(),
+        Err(e) => return err_internal("Database error", e),
+    };
+    let last = row.str_field("last_downloaded_at");
+    let mut body = serde_json::json!({
+        "object_id": row.id,
+        "key": row.str_field("key"),
+        "download_count": row.i64_field("download_count"),
+        "last_downloaded_at": (!last.is_empty()).then_some(last),
+        "access_log": false,
+    });
+    if !share::access_log_enabled(ctx).await {
+        return ok_json(&body);
+    }
+
+    let shares =
+        match repo::shares::list_for_object(ctx, bucket, row.str_field("key"), MAX_SHARES).await {
+            Ok(list) => list.records,
+            Err(e) => return err_internal("Database error", e),
+        };
+    let today = chrono::Utc::now().date_naive();
+    let since = first_day(today).format("%Y-%m-%d").to_string();
+    let ids: Vec<&str> = shares.iter().map(|s| s.id.as_str()).collect();
+    let rows = match repo::shares::list_counted_access_since(ctx, &ids, &since).await {
+        Ok(rows) => rows,
+        Err(e) => return err_internal("Database error", e),
+    };
+    body["access_log"] = true.into();
+    body["daily"] = daily_series(&rows, today).into();
+    body["top_shares"] = top_shares(&rows, &shares).into();
+    ok_json(&body)
+}
+
+fn not_found() -> OutputStream {
+    errors::error_response(errors::ErrorCode::ObjectNotFound, "Object not found")
+}
+
+/// The oldest day of the series ending `today`.
+fn first_day(today: NaiveDate) -> NaiveDate {
+    today - Duration::days(SERIES_DAYS - 1)
+}
+
+/// Counted access rows per UTC day, oldest first, one entry for each of
+/// the [`SERIES_DAYS`] days ending `today` (zero-filled).
+fn daily_series(rows: &[Record], today: NaiveDate) -> Vec<serde_json::Value> {
+    let mut per_day: HashMap<&str, i64> = HashMap::new();
+    for row in rows {
+        let day = row.str_field("accessed_at").get(..10).unwrap_or("");
+        *per_day.entry(day).or_default() += 1;
+    }
+    first_day(today)
+        .iter_days()
+        .take(SERIES_DAYS as usize)
+        .map(|day| {
+            let date = day.format("%Y-%m-%d").to_string();
+            let downloads = per_day.get(date.as_str()).copied().unwrap_or(0);
+            serde_json::json!({ "date": date, "downloads": downloads })
+        })
+        .collect()
+}
+
+/// The [`TOP_SHARES`] links with the most counted access rows, most first
+/// (ties by token).
+fn top_shares(rows: &[Record], shares: &[Record]) -> Vec<serde_json::Value> {
+    let mut per_share: HashMap<&str, i64> = HashMap::new();
+    for row in rows {
+        *per_share.entry(row.str_field("share_id")).or_default() += 1;
+    }
+    let mut top: Vec<(&Record, i64)> = shares
+        .iter()
+        .filter_map(|share| Some((share, *per_share.get(share.id.as_str())?)))
+        .collect();
+    top.sort_by(|(a, a_count), (b, b_count)| {
+        b_count
+            .cmp(a_count)
+            .then_with(|| a.str_field("token").cmp(b.str_field("token")))
+    });
+    top.into_iter()
+        .take(TOP_SHARES)
+        .map(|(share, downloads)| {
+            serde_json::json!({
+                "share_id": share.id,
+                "token": share.str_field("token"),
+                "downloads": downloads,
+            })
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::{super::blobs, *};
+    use crate::test_support::{auth_msg, output_json, output_status, TestContext};
+
+    fn record(id: &str, data: serde_json::Value) -> Record {
+        Record {
+            id: id.to_string(),
+            data: crate::util::json_map(data),
+        }
+    }
+
+    fn access(share_id: &str, accessed_at: &str) -> Record {
+        record(
+            "",
+            serde_json::json!({ "share_id": share_id, "accessed_at": accessed_at }),
+        )
+    }
+
+    #[test]
+    fn daily_series_zero_fills_thirty_days_ending_today() {
+        let today = NaiveDate::from_ymd_opt(2026, 10, 16).unwrap();
+        let rows = [
+            access("s1", "2026-10-16T08:00:00.000000Z"),
+            access("s1", "2026-10-16T09:00:00.000000Z"),
+            access("s2", "2026-09-17T23:59:59.000000Z"),
+        ];
+        let series = daily_series(&rows, today);
+        assert_eq!(series.len(), 30);
+        assert_eq!(
+            series[0],
+            serde_json::json!({ "date": "2026-09-17", "downloads": 1 })
+        );
+        assert_eq!(
+            series[29],
+            serde_json::json!({ "date": "2026-10-16", "downloads": 2 })
+        );
+        assert!(series[1..29].iter().all(|day| day["downloads"] == 0));
+    }
+
+    #[test]
+    fn top_shares_rank_by_downloads_and_skip_unused_links() {
+        let shares = [
+            record("s1", serde_json::json!({ "token": "b" })),
+            record("s2", serde_json::json!({ "token": "a" })),
+            record("s3", serde_json::json!({ "token": "c" })),
+        ];
+        let rows = [
+            access("s1", "2026-10-16T08:00:00Z"),
+            access("s2", "2026-10-16T08:00:00Z"),
+            access("s3", "2026-10-16T08:00:00Z"),
+            access("s3", "2026-10-16T09:00:00Z"),
+        ];
+        let top = top_shares(&rows, &shares);
+        let tokens: Vec<&str> = top.iter().map(|s| s["token"].as_str().unwrap()).collect();
+        assert_eq!(tokens, ["c", "a", "b"]);
+        assert_eq!(top[0]["downloads"], 2);
+        assert!(top_shares(&[], &shares).is_empty());
+    }
+
+    fn stats_msg(user: &str, id: &str) -> Message {
+        let mut msg = auth_msg(
+            "retrieve",
+            &format!("/b/storage/api/buckets/team/stats/{id}"),
+            user,
+        );
+        msg.set_meta("req.param.name", "team");
+        msg.set_meta("req.param.id", id);
+        msg
+    }
+
+    #[tokio::test]
+    async fn stats_count_downloads_and_series_only_counted_share_access() {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let bucket = crate::util::json_map(serde_json::json!({
+            "name": "team",
+            "public": false,
+            "created_by": "alice",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(&ctx, bucket)
+            .await
+            .expect("seed bucket");
+        let row = blobs::seed(&ctx, "team", "a.txt", b"abc", "text/plain", "alice").await;
+        let share = repo::shares::NewShare {
+            token: "tok1",
+            bucket: "team",
+            key: "a.txt",
+            created_by: "alice",
+            created_at: &crate::util::now_rfc3339(),
+            expires_at: None,
+            max_access_count: None,
+        };
+        let share = repo::shares::insert(&ctx, share).await.unwrap();
+
+        // No flusher runs in tests, so counts are written inline.
+        repo::objects::record_download(&ctx, &row.id).await;
+        repo::objects::record_download(&ctx, &row.id).await;
+        repo::shares::log_access(&ctx, &share.id, "", "", 3, true)
+            .await
+            .unwrap();
+        // A ranged resume logs its bytes but isn't a download.
+        repo::shares::log_access(&ctx, &share.id, "", "", 1, false)
+            .await
+            .unwrap();
+
+        let body = output_json(handle(&ctx, &stats_msg("alice", &row.id), "team").await).await;
+        assert_eq!(body["download_count"], 2, "{body}");
+        assert!(body["last_downloaded_at"].is_string());
+        assert_eq!(body["daily"][SERIES_DAYS as usize - 1]["downloads"], 1);
+        assert_eq!(body["top_shares"][0]["token"], "tok1");
+        assert_eq!(body["top_shares"][0]["downloads"], 1);
+
+        ctx.set_config(share::ACCESS_LOG_KEY, "false");
+        let body = output_json(handle(&ctx, &stats_msg("alice", &row.id), "team").await).await;
+        assert_eq!(body["access_log"], false);
+        assert!(body.get("daily").is_none());
+
+        let out = handle(&ctx, &stats_msg("bob", &row.id), "team").await;
+        assert_eq!(output_status(out).await, 403);
+    }
+}
@@ -0,0 +1,431 @@
+//! The folder tree behind the file manager's sidebar.
+//!
+//! `GET /b/storage/api/buckets/{name}/folders/tree` returns every folder of
+//! a bucket, nested, in one response, so the sidebar no longer fetches one
+//! level per expanded node. Folders are key prefixes (see `breadcrumbs`): a
+//! folder exists when it has a marker row (a key ending in `/`, as archive
+//! expansion writes with content type `application/x-directory`) or any
+//! listed object sits under it. Both come from one query over the bucket's
+//! listed rows; the tree, with each folder's count of direct files, is
+//! assembled in memory.
+//!
+//! The tree stops at [`MAX_DEPTH_KEY`] levels and [`MAX_NODES_KEY`] folders,
+//! keeping the shallowest folders first so a cut tree is still whole at the
+//! top. `truncated` says something was left out; the sidebar falls back to
+//! the object listing below that point.
+//!
+//! Whoever may read the whole bucket ([`acl::is_access_denied`] on `''`)
+//! gets it under `root`. Any other grantee gets `root: null` and, under
+//! `shared`, one subtree per folder granted to them. Grants on single
+//! objects add no folders. Responses carry an ETag, so a sidebar that
+//! re-renders an unchanged bucket gets a `304`.
+
+use std::collections::HashMap;
+
+use wafer_core::clients::config;
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::{
+    acl::{self, Access},
+    repo, storage,
+};
+use crate::{
+    http::{err_bad_request, err_forbidden, err_internal},
+    util::RecordExt,
+};
+
+/// Config key: deepest folder level the tree includes, counted from its
+/// root.
+pub(crate) const MAX_DEPTH_KEY: &str = "SUPPERS_AI__FILES__FOLDER_TREE_MAX_DEPTH";
+/// Default for [`MAX_DEPTH_KEY`].
+pub(crate) const DEFAULT_MAX_DEPTH: &str = "16";
+/// Config key: most folders one tree response includes.
+pub(crate) const MAX_NODES_KEY: &str = "SUPPERS_AI__FILES__FOLDER_TREE_MAX_NODES";
+/// Default for [`MAX_NODES_KEY`].
+pub(crate) const DEFAULT_MAX_NODES: &str = "2000";
+
+/// Most object rows read to build one tree. Past it the tree is built from
+/// the first rows in key order and marked truncated.
+const MAX_ROWS: i64 = 100_000;
+
+/// One folder. `id` is the folder prefix (`docs/q1/`), or `""` for the
+/// bucket root: the id breadcrumbs and `?prefix=` listings use.
+#[derive(Debug, PartialEq, Eq, serde::Serialize)]
+struct Folder {
+    id: String,
+    name: String,
+    /// Objects directly in this folder, not in its subfolders.
+    file_count: u64,
+    children: Vec<Folder>,
+}
+
+#[derive(Debug, Clone, Copy)]
+struct Limits {
+    max_depth: usize,
+    max_nodes: usize,
+}
+
+async fn limit(ctx: &dyn Context, key: &str, default: &str) -> usize {
+    config::get_default(ctx, key, default)
+        .await
+        .trim()
+        .parse::<usize>()
+        .ok()
+        .filter(|n| *n > 0)
+        .or_else(|| default.parse().ok())
+        .unwrap_or(1)
+}
+
+/// Nesting level of a folder id: `""` is 0, `docs/` 1, `docs/q1/` 2.
+fn depth(id: &str) -> usize {
+    id.matches('/').count()
+}
+
+/// The folder holding folder `id` (`docs/q1/` → `docs/`, `docs/` → `""`).
+fn parent(id: &str) -> &str {
+    let trimmed = id.strip_suffix('/').unwrap_or(id);
+    trimmed.rfind('/').map_or("", |idx| &id[..=idx])
+}
+
+/// The granted folder paths that root a grantee's subtrees: exact-object
+/// grants dropped, and folders inside another granted folder folded into
+/// it.
+fn grant_roots(paths: impl IntoIterator<Item = String>) -> Vec<String> {
+    let mut folders: Vec<String> = paths
+        .into_iter()
+        .filter(|p| p.is_empty() || p.ends_with('/'))
+        .collect();
+    folders.sort();
+    folders.dedup();
+    let mut roots: Vec<String> = Vec::new();
+    for folder in folders {
+        // Sorted order puts an enclosing folder before everything in it.
+        if !roots.iter().any(|r| folder.starts_with(r.as_str())) {
+            roots.push(folder);
+        }
+    }
+    roots
+}
+
+/// One tree per entry of `roots` (disjoint folder ids, `""` for the whole
+/// bucket) from the bucket's listed rows, given as `(key, is_folder)`, and
+/// whether `limits` left any folder out.
+fn build<'a>(
+    bucket: &str,
+    rows: impl IntoIterator<Item = (&'a str, bool)>,
+    roots: &[String],
+    limits: Limits,
+) -> (Vec<Folder>, bool) {
+    let root_of = |id: &str| roots.iter().find(|r| id.starts_with(r.as_str()));
+    let mut counts: HashMap<String, u64> = roots.iter().map(|r| (r.clone(), 0)).collect();
+    for (key, is_folder) in rows {
+        let folder = if is_folder {
+            key.to_string()
+        } else {
+            parent(key).to_string()
+        };
+        if root_of(&folder).is_none() {
+            continue;
+        }
+        // A key implies every folder above it; record those inside a root.
+        let mut ancestor = folder.as_str();
+        while root_of(ancestor).is_some() && !counts.contains_key(ancestor) {
+            counts.insert(ancestor.to_string(), 0);
+            ancestor = parent(ancestor);
+        }
+        if !is_folder {
+            *counts.entry(folder).or_insert(0) += 1;
+        }
+    }
+
+    // Shallowest first, so every kept folder's parent is kept too.
+    let relative = |id: &str| depth(id) - root_of(id).map_or(0, |r| depth(r));
+    let mut below: Vec<&str> = counts
+        .keys()
+        .map(String::as_str)
+        .filter(|id| !roots.iter().any(|r| r == id))
+        .collect();
+    below.sort_by(|a, b| relative(a).cmp(&relative(b)).then_with(|| a.cmp(b)));
+    let found = below.len();
+    below.retain(|id| relative(id) <= limits.max_depth);
+    below.truncate(limits.max_nodes);
+    let truncated = below.len() < found;
+
+    let mut children: HashMap<&str, Vec<&str>> = HashMap::new();
+    for id in below {
+        children.entry(parent(id)).or_default().push(id);
+    }
+    let trees = roots
+        .iter()
+        .map(|root| node(bucket, root, &counts, &children))
+        .collect();
+    (trees, truncated)
+}
+
+fn node(
+    bucket: &str,
+    id: &str,
+    counts: &HashMap<String, u64>,
+    children: &HashMap<&str, Vec<&str>>,
+) -> Folder {
+    let name = match id.strip_suffix('/') {
+        Some(path) => path.rsplit('/').next().unwrap_or(path),
+        None => bucket,
+    };
+    let mut kids: Vec<Folder> = children
+        .get(id)
+        .into_iter()
+        .flatten()
+        .map(|child| node(bucket, child, counts, children))
+        .collect();
+    kids.sort_by(|a, b| a.id.cmp(&b.id));
+    Folder {
+        id: id.to_string(),
+        name: name.to_string(),
+        file_count: counts.get(id).copied().unwrap_or(0),
+        children: kids,
+    }
+}
+
+/// `GET /b/storage/api/buckets/{name}/folders/tree` — the caller's folders
+/// in the bucket, nested.
+pub(super) async fn handle(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if !storage::is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+    let whole_bucket = !acl::is_access_denied(ctx, msg, bucket, "", Access::Read).await;
+    let roots = if whole_bucket {
+        vec![String::new()]
+    } else {
+        let grants = match repo::acls::list_for_grantee_in_bucket(ctx, bucket, msg.user_id()).await
+        {
+            Ok(grants) => grants,
+            Err(e) => return err_internal("Database error", e),
+        };
+        let roots = grant_roots(grants.iter().map(|g| g.str_field("path").to_string()));
+        if roots.is_empty() {
+            return err_forbidden("Access denied to this bucket");
+        }
+        roots
+    };
+    let limits = Limits {
+        max_depth: limit(ctx, MAX_DEPTH_KEY, DEFAULT_MAX_DEPTH).await,
+        max_nodes: limit(ctx, MAX_NODES_KEY, DEFAULT_MAX_NODES).await,
+    };
+
+    // One grant narrows the read to its folder; several share the scan.
+    let prefix = match roots.as_slice() {
+        [only] => only.as_str(),
+        _ => "",
+    };
+    let list = match repo::objects::list_under(ctx, bucket, prefix, true, MAX_ROWS, 0).await {
+        Ok(list) => list,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let rows = list.records.iter().map(|row| {
+        let key = row.str_field("key");
+        (key, key.ends_with('/'))
+    });
+    let (mut trees, cut) = build(bucket, rows, &roots, limits);
+    let truncated = cut || list.total_count > list.records.len() as i64;
+
+    let body = if whole_bucket {
+        serde_json::json!({"root": trees.pop(), "shared": [], "truncated": truncated})
+    } else {
+        serde_json::json!({"root": null, "shared": trees, "truncated": truncated})
+    };
+    crate::etag::ok_json(msg, &body)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{
+        admin_msg, auth_msg, output_header, output_is_error, output_json, output_status,
+        TestContext,
+    };
+
+    const WIDE: Limits = Limits {
+        max_depth: 16,
+        max_nodes: 2000,
+    };
+
+    fn ids(folders: &[Folder]) -> Vec<&str> {
+        folders.iter().map(|f| f.id.as_str()).collect()
+    }
+
+    #[test]
+    fn folders_come_from_markers_and_keys() {
+        let rows = [
+            ("docs/", true),
+            ("docs/q1/report.pdf", false),
+            ("docs/q1/notes.txt", false),
+            ("docs/readme.md", false),
+            ("empty/", true),
+            ("media/photos/2024/", true),
+            ("top.txt", false),
+        ];
+        let (trees, truncated) = build("team", rows, &[String::new()], WIDE);
+        assert!(!truncated);
+        let root = &trees[0];
+        assert_eq!((root.id.as_str(), root.name.as_str()), ("", "team"));
+        assert_eq!(root.file_count, 1);
+        assert_eq!(ids(&root.children), ["docs/", "empty/", "media/"]);
+
+        let docs = &root.children[0];
+        assert_eq!(docs.file_count, 1, "only direct files count");
+        assert_eq!(ids(&docs.children), ["docs/q1/"]);
+        assert_eq!(docs.children[0].name, "q1");
+        assert_eq!(docs.children[0].file_count, 2);
+        // Folders implied by a deeper marker exist without rows of their own.
+        assert_eq!(
+            root.children[2].children[0].children[0].id,
+            "media/photos/2024/"
+        );
+    }
+
+    #[test]
+    fn limits_keep_the_shallowest_folders() {
+        let rows = [("a/b/c/d/", true), ("x/", true), ("y/", true)];
+        let shallow = Limits {
+            max_depth: 2,
+            ..WIDE
+        };
+        let (trees, truncated) = build("t", rows, &[String::new()], shallow);
+        assert!(truncated);
+        assert_eq!(ids(&trees[0].children), ["a/", "x/", "y/"]);
+        assert_eq!(ids(&trees[0].children[0].children), ["a/b/"]);
+        assert!(trees[0].children[0].children[0].children.is_empty());
+
+        let few = Limits {
+            max_nodes: 3,
+            ..WIDE
+        };
+        let (trees, truncated) = build("t", rows, &[String::new()], few);
+        assert!(truncated);
+        assert_eq!(ids(&trees[0].children), ["a/", "x/", "y/"]);
+        assert!(trees[0].children[0].children.is_empty());
+    }
+
+    #[test]
+    fn grants_root_disjoint_subtrees() {
+        let paths = ["docs/q1/", "docs/", "a.txt", "media/", "docs/"];
+        let roots = grant_roots(paths.map(String::from));
+        assert_eq!(roots, ["docs/", "media/"]);
+
+        let rows = [
+            ("docs/q1/r.pdf", false),
+            ("private/p.txt", false),
+            ("media/m.png", false),
+        ];
+        let (trees, truncated) = build("t", rows, &roots, WIDE);
+        assert!(!truncated);
+        assert_eq!(ids(&trees), ["docs/", "media/"]);
+        assert_eq!(trees[0].name, "docs");
+        assert_eq!(ids(&trees[0].children), ["docs/q1/"]);
+        assert_eq!(trees[1].file_count, 1);
+    }
+
+    async fn seed_bucket(ctx: &TestContext, name: &str, owner: &str) {
+        let data = crate::util::json_map(serde_json::json!({
+            "name": name,
+            "public": false,
+            "created_by": owner,
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(ctx, data).await.expect("seed bucket");
+    }
+
+    async fn seed_object(ctx: &TestContext, bucket: &str, key: &str) {
+        let content_type = if key.ends_with('/') {
+            "application/x-directory"
+        } else {
+            "text/plain"
+        };
+        let data = crate::util::json_map(serde_json::json!({
+            "bucket": bucket,
+            "key": key,
+            "size": 0,
+            "content_type": content_type,
+            "status": "complete",
+            "uploaded_by": "alice",
+        }));
+        repo::objects::seed(ctx, data).await.expect("seed object");
+    }
+
+    fn tree_msg(mut msg: Message, bucket: &str) -> Message {
+        msg.set_meta("req.param.name", bucket);
+        msg
+    }
+
+    #[tokio::test]
+    async fn whole_tree_takes_one_query_and_revalidates() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        for project in 0..20 {
+            seed_object(&ctx, "team", &format!("p{project:02}/readme.txt")).await;
+            for sub in 0..15 {
+                seed_object(&ctx, "team", &format!("p{project:02}/s{sub:02}/")).await;
+            }
+        }
+        let path = "/b/storage/api/buckets/team/folders/tree";
+        let msg = tree_msg(admin_msg("retrieve", path), "team");
+
+        let before = ctx.database_calls();
+        let body = output_json(handle(&ctx, &msg, "team").await).await;
+        assert_eq!(
+            ctx.database_calls() - before,
+            1,
+            "one query for 320 folders"
+        );
+        assert_eq!(body["truncated"], false);
+        let projects = body["root"]["children"].as_array().unwrap();
+        assert_eq!(projects.len(), 20);
+        assert_eq!(projects[3]["id"], "p03/");
+        assert_eq!(projects[3]["file_count"], 1);
+        assert_eq!(projects[3]["children"].as_array().unwrap().len(), 15);
+        assert_eq!(projects[3]["children"][14]["id"], "p03/s14/");
+
+        let tag = output_header(handle(&ctx, &msg, "team").await, "ETag")
+            .await
+            .expect("tree responses are tagged");
+        let mut again = msg.clone();
+        again.set_meta("http.header.if-none-match", &tag);
+        assert_eq!(output_status(handle(&ctx, &again, "team").await).await, 304);
+    }
+
+    #[tokio::test]
+    async fn grantee_sees_only_shared_folders() {
+        let ctx = TestContext::with_files().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        seed_object(&ctx, "team", "docs/q1/a.txt").await;
+        seed_object(&ctx, "team", "private/b.txt").await;
+        repo::acls::upsert(
+            &ctx,
+            repo::acls::NewGrant {
+                bucket: "team",
+                path: "docs/",
+                grantee_user_id: "bob",
+                permission: "read",
+                granted_by: "alice",
+            },
+        )
+        .await
+        .unwrap();
+        let path = "/b/storage/api/buckets/team/folders/tree";
+
+        let msg = tree_msg(auth_msg("retrieve", path, "bob"), "team");
+        let body = output_json(handle(&ctx, &msg, "team").await).await;
+        assert!(body["root"].is_null());
+        assert_eq!(body["shared"][0]["id"], "docs/");
+        assert_eq!(body["shared"][0]["children"][0]["id"], "docs/q1/");
+        assert_eq!(body["shared"].as_array().unwrap().len(), 1);
+
+        let msg = tree_msg(auth_msg("retrieve", path, "alice"), "team");
+        let body = output_json(handle(&ctx, &msg, "team").await).await;
+        assert_eq!(body["root"]["children"].as_array().unwrap().len(), 2);
+
+        let msg = tree_msg(auth_msg("retrieve", path, "mallory"), "team");
+        assert!(output_is_error(handle(&ctx, &msg, "team").await, "PermissionDenied").await);
+    }
+}
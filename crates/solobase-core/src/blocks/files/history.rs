@@ -0,0 +1,384 @@
+//! Object history: who changed an object, how, and when.
+//!
+//! Every change to an object writes one row to
+//! `suppers_ai__files__object_events` in the same request, after the change
+//! itself succeeded:
+//!
+//! - `create` — stored by an upload or archive expansion;
+//! - `move` — its key changed (a move is also how a file is renamed);
+//! - `metadata` — its client metadata was replaced or patched;
+//! - `delete` — removed by its owner, an admin, or lifecycle expiry;
+//! - `restore` — released from quarantine by an admin;
+//! - `lock` / `unlock` — a lock (`files::locks`) was set, extended or
+//!   lifted. A folder or whole-bucket lock has no row, so its events carry
+//!   the locked path (`docs/`, or `''`) as their `object_id`.
+//!
+//! `before` and `after` summarize only what changed: the old and new key,
+//! or the metadata keys that differ. An event with an empty `actor_id` was
+//! the server's own (lifecycle expiry). Reads are not events — downloads
+//! and previews stay in the access log.
+//!
+//! A request that changes many objects at once — a bulk or folder move, an
+//! archive expansion, one lifecycle rule's expiries — writes one event per
+//! object, all sharing a `batch_id`, so a history view can show them as one
+//! operation. A single change has an empty `batch_id`.
+//!
+//! The actor of an object's newest change is kept on its row as
+//! `modified_by`, which listings and object info return. The history
+//! endpoint adds each actor's directory profile as `actor`
+//! ([`crate::blocks::directory`]; null for the server or an unknown
+//! account).
+//!
+//! `GET /b/storage/api/buckets/{name}/history/{id}` — the events of the
+//! object with row id `id`, newest first, for the bucket's owner and
+//! admins. History outlives the object, so a deleted object's id still
+//! answers; deleting the bucket drops it. The `object_history` retention
+//! policy ages events out.
+
+use wafer_core::clients::database::Record;
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::{repo, storage};
+use crate::{
+    blocks::directory,
+    http::{err_bad_request, err_forbidden, err_internal, ok_json},
+    util::RecordExt,
+};
+
+pub(super) const ACTION_CREATE: &str = "create";
+pub(super) const ACTION_MOVE: &str = "move";
+pub(super) const ACTION_METADATA: &str = "metadata";
+pub(super) const ACTION_DELETE: &str = "delete";
+pub(super) const ACTION_RESTORE: &str = "restore";
+pub(super) const ACTION_LOCK: &str = "lock";
+pub(super) const ACTION_UNLOCK: &str = "unlock";
+
+/// One change to one object, ready to [`record`].
+#[derive(Debug, Clone)]
+pub(super) struct Event {
+    object_id: String,
+    bucket: String,
+    key: String,
+    action: &'static str,
+    actor: String,
+    batch_id: String,
+    before: serde_json::Value,
+    after: serde_json::Value,
+}
+
+impl Event {
+    /// `action` on the object row `row` by `actor`, with nothing changed yet.
+    pub(super) fn new(action: &'static str, row: &Record, actor: &str) -> Self {
+        Self {
+            object_id: row.id.clone(),
+            bucket: row.str_field("bucket").to_string(),
+            key: row.str_field("key").to_string(),
+            action,
+            actor: actor.to_string(),
+            batch_id: String::new(),
+            before: serde_json::json!({}),
+            after: serde_json::json!({}),
+        }
+    }
+
+    /// `action` on a folder or the whole bucket, which have no row: the
+    /// path stands in for the object id.
+    pub(super) fn on_path(action: &'static str, bucket: &str, path: &str, actor: &str) -> Self {
+        Self {
+            object_id: path.to_string(),
+            bucket: bucket.to_string(),
+            key: path.to_string(),
+            action,
+            actor: actor.to_string(),
+            batch_id: String::new(),
+            before: serde_json::json!({}),
+            after: serde_json::json!({}),
+        }
+    }
+
+    /// A new object, summarized by its key, size and type.
+    pub(super) fn created(row: &Record, actor: &str) -> Self {
+        let after = serde_json::json!({
+            "key": row.str_field("key"),
+            "size": row.i64_field("size"),
+            "content_type": row.str_field("content_type"),
+        });
+        Self::new(ACTION_CREATE, row, actor).change(serde_json::json!({}), after)
+    }
+
+    /// A deleted object, summarized by what it was.
+    pub(super) fn deleted(row: &Record, actor: &str) -> Self {
+        let before = serde_json::json!({
+            "key": row.str_field("key"),
+            "size": row.i64_field("size"),
+        });
+        Self::new(ACTION_DELETE, row, actor).change(before, serde_json::json!({}))
+    }
+
+    /// An object moved from `from` to `to`.
+    pub(super) fn moved(row: &Record, actor: &str, from: &str, to: &str) -> Self {
+        let mut event = Self::new(ACTION_MOVE, row, actor).change(
+            serde_json::json!({ "key": from }),
+            serde_json::json!({ "key": to }),
+        );
+        event.key = to.to_string();
+        event
+    }
+
+    /// A metadata update from `current` to `next`: only the top-level keys
+    /// that differ, a removed key missing from `after` and an added one
+    /// from `before`.
+    pub(super) fn metadata_changed(
+        row: &Record,
+        actor: &str,
+        current: &serde_json::Map<String, serde_json::Value>,
+        next: &serde_json::Map<String, serde_json::Value>,
+    ) -> Self {
+        let (mut before, mut after) = (serde_json::Map::new(), serde_json::Map::new());
+        for key in current.keys().chain(next.keys()) {
+            if current.get(key) == next.get(key) {
+                continue;
+            }
+            if let Some(old) = current.get(key) {
+                before.insert(key.clone(), old.clone());
+            }
+            if let Some(new) = next.get(key) {
+                after.insert(key.clone(), new.clone());
+            }
+        }
+        Self::new(ACTION_METADATA, row, actor).change(
+            serde_json::json!({ "metadata": before }),
+            serde_json::json!({ "metadata": after }),
+        )
+    }
+
+    pub(super) fn change(mut self, before: serde_json::Value, after: serde_json::Value) -> Self {
+        self.before = before;
+        self.after = after;
+        self
+    }
+
+    /// Group this event with the others of one bulk request.
+    pub(super) fn batch(mut self, batch_id: &str) -> Self {
+        self.batch_id = batch_id.to_string();
+        self
+    }
+}
+
+/// A fresh id for the events of one bulk request.
+pub(super) fn new_batch_id() -> String {
+    uuid::Uuid::now_v7().to_string()
+}
+
+/// Write `event`, and when it changed the object (not a delete, lock or
+/// unlock), make its actor the object's `modified_by`. The change it describes has already happened, so a
+/// failure is logged rather than returned.
+pub(super) async fn record(ctx: &dyn Context, event: Event) {
+    let mut data = crate::util::json_map(serde_json::json!({
+        "object_id": event.object_id,
+        "bucket": event.bucket,
+        "key": event.key,
+        "action": event.action,
+        "actor_id": event.actor,
+        "batch_id": event.batch_id,
+        "before_state": event.before.to_string(),
+        "after_state": event.after.to_string(),
+    }));
+    crate::util::stamp_created(&mut data);
+    if let Err(e) = repo::events::insert(ctx, data).await {
+        tracing::warn!(
+            error = %e,
+            object_id = %event.object_id,
+            action = event.action,
+            "object event not recorded"
+        );
+    }
+    if !matches!(event.action, ACTION_DELETE | ACTION_LOCK | ACTION_UNLOCK) {
+        if let Err(e) = repo::objects::set_modified_by(ctx, &event.object_id, &event.actor).await {
+            tracing::warn!(error = %e, object_id = %event.object_id, "modified_by not updated");
+        }
+    }
+}
+
+/// An event row as the API returns it, with the summaries parsed.
+pub(super) fn to_json(row: &Record) -> serde_json::Value {
+    let parse = |field: &str| {
+        serde_json::from_str::<serde_json::Value>(row.str_field(field))
+            .unwrap_or_else(|_| serde_json::json!({}))
+    };
+    serde_json::json!({
+        "id": row.id,
+        "object_id": row.str_field("object_id"),
+        "key": row.str_field("key"),
+        "action": row.str_field("action"),
+        "actor_id": row.str_field("actor_id"),
+        "batch_id": row.str_field("batch_id"),
+        "before": parse("before_state"),
+        "after": parse("after_state"),
+        "created_at": row.str_field("created_at"),
+    })
+}
+
+/// `GET /b/storage/api/buckets/{name}/history/{id}` — paginated with
+/// `page` / `page_size`.
+pub(super) async fn handle(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if !storage::is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+    let id = msg.var("id");
+    if id.is_empty() {
+        return err_bad_request("Missing object ID");
+    }
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let (page, page_size, offset) = msg.pagination_params(50);
+    match repo::events::list_for_object(ctx, bucket, id, page_size as i64, offset as i64).await {
+        Ok(list) => {
+            let actors: Vec<&str> = list
+                .records
+                .iter()
+                .map(|r| r.str_field("actor_id"))
+                .collect();
+            let profiles = directory::lookup(ctx, &actors).await;
+            let events: Vec<serde_json::Value> = list
+                .records
+                .iter()
+                .map(|row| {
+                    let mut event = to_json(row);
+                    event["actor"] = serde_json::json!(profiles.get(row.str_field("actor_id")));
+                    event
+                })
+                .collect();
+            ok_json(&serde_json::json!({
+            "object_id": id,
+            "events": events,
+            "total_count": list.total_count,
+            "page": page,
+            "page_size": page_size,
+            }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use wafer_run::InputStream;
+
+    use super::{
+        super::{blobs, metadata, moves},
+        *,
+    };
+    use crate::test_support::{admin_msg, auth_msg, output_json, output_status, TestContext};
+
+    async fn ctx() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let data = crate::util::json_map(serde_json::json!({
+            "name": "team",
+            "public": false,
+            "created_by": "alice",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(&ctx, data).await.expect("seed bucket");
+        ctx
+    }
+
+    fn history_msg(mut msg: Message, id: &str) -> Message {
+        msg.set_meta("req.param.name", "team");
+        msg.set_meta("req.param.id", id);
+        msg
+    }
+
+    async fn events(ctx: &TestContext, id: &str) -> Vec<Record> {
+        repo::events::list_for_object(ctx, "team", id, 100, 0)
+            .await
+            .unwrap()
+            .records
+    }
+
+    #[test]
+    fn metadata_summary_keeps_only_changed_keys() {
+        let row = Record {
+            id: "o1".to_string(),
+            data: crate::util::json_map(serde_json::json!({"bucket": "b", "key": "k"})),
+        };
+        let current = metadata::parse_stored(r#"{"system":{"x":1},"tag":"old","keep":true}"#);
+        let next =
+            metadata::parse_stored(r#"{"system":{"x":1},"tag":"new","added":2,"keep":true}"#);
+        let event = Event::metadata_changed(&row, "alice", &current, &next);
+        assert_eq!(
+            event.before,
+            serde_json::json!({"metadata": {"tag": "old"}})
+        );
+        assert_eq!(
+            event.after,
+            serde_json::json!({"metadata": {"tag": "new", "added": 2}})
+        );
+        assert_eq!(event.action, ACTION_METADATA);
+    }
+
+    #[tokio::test]
+    async fn bulk_move_shares_one_batch_and_updates_modified_by() {
+        let ctx = ctx().await;
+        let a = blobs::seed(&ctx, "team", "a.txt", b"a", "text/plain", "alice").await;
+        let b = blobs::seed(&ctx, "team", "b.txt", b"b", "text/plain", "alice").await;
+        blobs::seed(&ctx, "team", "archive/old.txt", b"o", "text/plain", "alice").await;
+        assert_eq!(a.str_field("modified_by"), "alice");
+
+        let mut msg = admin_msg("create", "/b/storage/api/buckets/team/move");
+        msg.set_meta("req.param.name", "team");
+        let body = serde_json::json!({"ids": [a.id, b.id], "target": "archive/"});
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        let out = output_json(moves::handle(&ctx, &msg, "team", input).await).await;
+        assert_eq!(out["results"][&a.id]["new_key"], "archive/a.txt", "{out}");
+
+        let (ea, eb) = (events(&ctx, &a.id).await, events(&ctx, &b.id).await);
+        assert_eq!(ea.len(), 1);
+        assert_eq!(ea[0].str_field("action"), ACTION_MOVE);
+        assert_eq!(ea[0].str_field("actor_id"), "admin_1");
+        assert_eq!(ea[0].str_field("key"), "archive/a.txt");
+        assert!(!ea[0].str_field("batch_id").is_empty());
+        assert_eq!(ea[0].str_field("batch_id"), eb[0].str_field("batch_id"));
+
+        let row = repo::objects::get(&ctx, &a.id).await.unwrap();
+        assert_eq!(row.str_field("modified_by"), "admin_1");
+    }
+
+    #[tokio::test]
+    async fn history_is_for_the_owner_and_outlives_the_object() {
+        let ctx = ctx().await;
+        let row = blobs::seed(&ctx, "team", "a.txt", b"a", "text/plain", "alice").await;
+        record(&ctx, Event::created(&row, "alice")).await;
+        storage::delete_object_and_metadata(&ctx, "team", "a.txt")
+            .await
+            .unwrap();
+        record(&ctx, Event::deleted(&row, "alice")).await;
+
+        let path = format!("/b/storage/api/buckets/team/history/{}", row.id);
+        let denied = history_msg(auth_msg("retrieve", &path, "bob"), &row.id);
+        assert_eq!(
+            output_status(handle(&ctx, &denied, "team").await).await,
+            403
+        );
+
+        let msg = history_msg(auth_msg("retrieve", &path, "alice"), &row.id);
+        let body = output_json(handle(&ctx, &msg, "team").await).await;
+        assert_eq!(body["total_count"], 2, "{body}");
+        let actions: Vec<&str> = body["events"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .filter_map(|e| e["action"].as_str())
+            .collect();
+        assert!(actions.contains(&ACTION_CREATE) && actions.contains(&ACTION_DELETE));
+        let deleted = body["events"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|e| e["action"] == ACTION_DELETE)
+            .unwrap();
+        assert_eq!(deleted["before"]["key"], "a.txt");
+    }
+}
@@ -0,0 +1,260 @@
+//! Object info: one object's row, lock and metadata as JSON, without the
+//! bytes.
+//!
+//! `GET /b/storage/api/buckets/{name}/info/{key...}` answers with the same
+//! fields an object has in the bucket listing, plus its row `id` (what the
+//! history and lock endpoints take). It follows the detail conventions in
+//! [`crate::detail`]:
+//!
+//! - `?fields=size,content_type` trims the body to those fields (`key`
+//!   always stays);
+//! - `?expand=shares` adds the object's public share links, and
+//!   `?expand=history` its most recent change events.
+//!
+//! Reading the object needs read access. The expansions are for the
+//! bucket's owner and admins — the same people the history endpoint
+//! answers, and share links carry tokens — so anyone else asking for one
+//! gets a 403 rather than a quietly smaller body. `download_count` (see
+//! `files::downloads`) is likewise only in their bodies.
+
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::{
+    acl::{self, Access},
+    history, locks, metadata, repo,
+    storage::{self, is_safe_bucket_name, is_valid_storage_key},
+};
+use crate::{
+    blocks::errors::{self, ErrorCode},
+    detail::{self, DetailSpec},
+    etag,
+    http::{err_bad_request, err_forbidden, err_internal},
+    util::RecordExt,
+};
+
+/// What `?fields=` and `?expand=` may name.
+pub(super) const SPEC: DetailSpec = DetailSpec {
+    id_field: "key",
+    fields: &[
+        "id",
+        "key",
+        "bucket",
+        "size",
+        "content_type",
+        "sha256",
+        "last_modified",
+        "modified_by",
+        "metadata",
+        "locked_until",
+        "locked_by",
+        "download_count",
+    ],
+    expand: &["shares", "history"],
+    default_expand: &[],
+};
+
+/// Most share links the `shares` expansion returns.
+const MAX_SHARES: i64 = 50;
+
+/// Most history events the `history` expansion returns; the history
+/// endpoint pages through the rest.
+const MAX_EVENTS: i64 = 20;
+
+pub(super) async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    key: &str,
+) -> OutputStream {
+    if !is_safe_bucket_name(bucket) || !is_valid_storage_key(key) {
+        return err_bad_request("Invalid bucket name or object key");
+    }
+    let query = match detail::parse(msg, &SPEC) {
+        Ok(query) => query,
+        Err(r) => return r,
+    };
+    if acl::is_access_denied(ctx, msg, bucket, key, Access::Read).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let owner = !storage::is_bucket_access_denied(ctx, msg, bucket).await;
+    if !query.expand.is_empty() && !owner {
+        return err_forbidden("Only the bucket owner can expand shares or history");
+    }
+    let row = match repo::objects::find_by_bucket_key(ctx, bucket, key).await {
+        Ok(Some(row)) if row.str_field("status") != "pending" => row,
+        Ok(_) => return errors::error_response(ErrorCode::ObjectNotFound, "Object not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    let locks = match locks::LockSet::load(ctx, bucket).await {
+        Ok(locks) => locks,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let lock = locks.covering(key);
+    let mut body = serde_json::json!({
+        "id": row.id,
+        "key": key,
+        "bucket": bucket,
+        "size": row.i64_field("size"),
+        "content_type": row.str_field("content_type"),
+        "sha256": Some(row.str_field("sha256")).filter(|s| !s.is_empty()),
+        "last_modified": row.str_field("uploaded_at"),
+        "modified_by": row.str_field("modified_by"),
+        "metadata": metadata::parse_stored(row.str_field("metadata")),
+        "locked_until": lock.map(|l| l.locked_until.as_str()),
+        "locked_by": lock.map(|l| l.locked_by.as_str()),
+    });
+    if owner {
+        body["download_count"] = row.i64_field("download_count").into();
+    }
+
+    if query.expands("shares") {
+        let list = match repo::shares::list_for_object(ctx, bucket, key, MAX_SHARES).await {
+            Ok(list) => list,
+            Err(e) => return err_internal("Database error", e),
+        };
+        body["shares"] = list
+            .records
+            .iter()
+            .map(|row| share_json(msg, row))
+            .collect();
+    }
+    if query.expands("history") {
+        let list = match repo::events::list_for_object(ctx, bucket, &row.id, MAX_EVENTS, 0).await {
+            Ok(list) => list,
+            Err(e) => return err_internal("Database error", e),
+        };
+        body["history"] = list.records.iter().map(history::to_json).collect();
+    }
+
+    query.project(&mut body, &SPEC);
+    etag::ok_json(msg, &body)
+}
+
+/// A share row as the `shares` expansion returns it.
+fn share_json(msg: &Message, row: &wafer_core::clients::database::Record) -> serde_json::Value {
+    let optional = |field: &str| {
+        let value = row.str_field(field);
+        (!value.is_empty()).then(|| value.to_string())
+    };
+    let token = row.str_field("token");
+    serde_json::json!({
+        "id": row.id,
+        "token": token,
+        "direct_url": crate::base_path::url(msg, &format!("/b/storage/direct/{token}")),
+        "created_by": row.str_field("created_by"),
+        "created_at": row.str_field("created_at"),
+        "expires_at": optional("expires_at"),
+        "revoked_at": optional("revoked_at"),
+        "access_count": row.i64_field("access_count"),
+        "max_access_count": row.data.get("max_access_count").filter(|v| !v.is_null()),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use wafer_run::InputStream;
+
+    use super::{super::blobs, *};
+    use crate::test_support::{auth_msg, output_header, output_json, output_status, TestContext};
+
+    async fn ctx() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let data = crate::util::json_map(serde_json::json!({
+            "name": "team",
+            "public": false,
+            "created_by": "alice",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(&ctx, data).await.expect("seed bucket");
+        ctx
+    }
+
+    fn info_msg(user: &str, fields: &str, expand: &str) -> Message {
+        let mut msg = auth_msg("retrieve", "/b/storage/api/buckets/team/info/a.txt", user);
+        msg.set_meta("req.param.name", "team");
+        msg.set_meta("req.query.fields", fields);
+        msg.set_meta("req.query.expand", expand);
+        msg
+    }
+
+    #[tokio::test]
+    async fn info_projects_and_expands() {
+        let ctx = ctx().await;
+        let row = blobs::seed(&ctx, "team", "a.txt", b"abc", "text/plain", "alice").await;
+        history::record(&ctx, history::Event::created(&row, "alice")).await;
+        let share = repo::shares::NewShare {
+            token: "tok1",
+            bucket: "team",
+            key: "a.txt",
+            created_by: "alice",
+            created_at: &crate::util::now_rfc3339(),
+            expires_at: None,
+            max_access_count: None,
+        };
+        repo::shares::insert(&ctx, share).await.unwrap();
+
+        let body =
+            output_json(handle(&ctx, &info_msg("alice", "", ""), "team", "a.txt").await).await;
+        assert_eq!(body["id"], row.id, "{body}");
+        assert_eq!(body["size"], 3);
+        assert_eq!(body["content_type"], "text/plain");
+        assert!(body.get("shares").is_none() && body.get("history").is_none());
+
+        let msg = info_msg("alice", "size", "shares,history");
+        let body = output_json(handle(&ctx, &msg, "team", "a.txt").await).await;
+        let keys: Vec<&String> = body.as_object().unwrap().keys().collect();
+        assert_eq!(keys.len(), 4, "{body}");
+        assert_eq!(body["key"], "a.txt");
+        assert_eq!(body["shares"][0]["token"], "tok1");
+        assert_eq!(body["history"][0]["action"], history::ACTION_CREATE);
+    }
+
+    #[tokio::test]
+    async fn info_rejects_unknown_names_and_non_owner_expansions() {
+        let ctx = ctx().await;
+        blobs::seed(&ctx, "team", "a.txt", b"abc", "text/plain", "alice").await;
+
+        let msg = info_msg("alice", "", "owner");
+        let body = output_json(handle(&ctx, &msg, "team", "a.txt").await).await;
+        assert_eq!(body["code"], "validation_failed");
+        assert!(body["details"]["expand"]
+            .as_str()
+            .unwrap()
+            .ends_with("valid: shares, history"));
+
+        // Bob has read access through a grant but doesn't own the bucket.
+        let mut grant = auth_msg("create", "/b/storage/api/buckets/team/acl", "alice");
+        grant.set_meta("req.param.name", "team");
+        let body = serde_json::json!({"grantee_user_id": "bob", "path": "a.txt"});
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        assert_eq!(
+            output_status(acl::handle_grant(&ctx, &grant, "team", input).await).await,
+            200
+        );
+        let plain = handle(&ctx, &info_msg("bob", "", ""), "team", "a.txt").await;
+        assert_eq!(output_status(plain).await, 200);
+        let expanded = handle(&ctx, &info_msg("bob", "", "shares"), "team", "a.txt").await;
+        assert_eq!(output_status(expanded).await, 403);
+    }
+
+    #[tokio::test]
+    async fn info_etag_varies_with_the_projection() {
+        let ctx = ctx().await;
+        blobs::seed(&ctx, "team", "a.txt", b"abc", "text/plain", "alice").await;
+
+        let full = handle(&ctx, &info_msg("alice", "", ""), "team", "a.txt").await;
+        let size = handle(&ctx, &info_msg("alice", "size", ""), "team", "a.txt").await;
+        let full = output_header(full, "ETag").await.expect("etag");
+        let size = output_header(size, "ETag").await.expect("etag");
+        assert_ne!(full, size);
+
+        // The tag a projection got answers 304 for that projection.
+        let mut again = info_msg("alice", "size", "");
+        again.set_meta("http.header.if-none-match", &size);
+        assert_eq!(
+            output_status(handle(&ctx, &again, "team", "a.txt").await).await,
+            304
+        );
+    }
+}
@@ -0,0 +1,576 @@
+//! Per-bucket object lifecycle rules: delete or archive objects once they
+//! are older than N days, optionally only under a key prefix.
+//!
+//! Rules are a JSON array on the bucket row (`lifecycle_rules`), managed
+//! through the admin storage API:
+//!
+//! - `GET|PUT /admin/storage/buckets/{name}/lifecycle` — read / replace.
+//! - `POST /admin/storage/buckets/{name}/lifecycle/test` — dry-run one rule,
+//!   returning the first [`DRY_RUN_LIMIT`] objects it would act on.
+//! - `POST /admin/storage/lifecycle/run` — evaluate every rule now.
+//! - `GET /admin/storage/lifecycle/runs` — per-rule run summaries.
+//!
+//! The built-in [`SCHEDULE`] (see `blocks::schedules`) queues a
+//! [`RUN_JOB`] every day at midnight UTC, catching up after downtime; the
+//! job evaluates every rule. One run touches at most [`BATCH_SIZE_KEY`] objects across all
+//! rules, so a backlog never holds the SQLite write lock for long; the
+//! remainder is picked up by the next run. Deletes go through the same
+//! blob + metadata cleanup as a user delete, so quota usage (summed from
+//! object rows) drops with them, and each is a `delete` history event
+//! with no actor. Archived objects keep their blob and still count toward
+//! quota, but listings and search skip them.
+//!
+//! Objects under a lock (`files::locks`) are skipped, deleted or archived
+//! by a later run once the lock ends; each run summary counts them as
+//! `locked`.
+//!
+//! Only buckets with rules are evaluated; a bucket is exempt until an
+//! admin adds one.
+
+use wafer_core::clients::config;
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream, WaferError};
+
+use super::{history, locks, repo, storage};
+use crate::{
+    blocks::{errors, jobs::JobError},
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+    util::RecordExt,
+};
+
+/// Config key: most objects one lifecycle run may delete or archive.
+pub(crate) const BATCH_SIZE_KEY: &str = "SUPPERS_AI__FILES__LIFECYCLE_BATCH_SIZE";
+/// Default for [`BATCH_SIZE_KEY`].
+pub(crate) const DEFAULT_BATCH_SIZE: &str = "500";
+
+/// A [`RUN_JOB`] within this long of the last run is taken for a repeat
+/// delivery and skipped.
+const REPEAT_GUARD_MINUTES: i64 = 60;
+
+/// Objects returned by a dry run.
+pub(crate) const DRY_RUN_LIMIT: i64 = 100;
+
+/// Longest accepted `after_days` (ten years).
+const MAX_AFTER_DAYS: i64 = 3650;
+
+/// Rules per bucket.
+const MAX_RULES: usize = 20;
+
+/// What a rule does to a matching object.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum LifecycleAction {
+    /// Remove the blob and its metadata.
+    Delete,
+    /// Keep the blob, hide it from listings and search.
+    Archive,
+}
+
+impl LifecycleAction {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Delete => "delete",
+            Self::Archive => "archive",
+        }
+    }
+
+    /// Object statuses the action applies to: archiving only touches live
+    /// objects, deleting also removes earlier-archived ones.
+    fn statuses(self) -> &'static [&'static str] {
+        match self {
+            Self::Delete => &["complete", "archived"],
+            Self::Archive => &["complete"],
+        }
+    }
+}
+
+fn enabled_by_default() -> bool {
+    true
+}
+
+/// One lifecycle rule as stored on the bucket.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+pub struct LifecycleRule {
+    /// Admin-chosen name, unique within the bucket; recorded on run rows.
+    pub id: String,
+    /// Only keys starting with this prefix; empty matches every key.
+    #[serde(default)]
+    pub prefix: String,
+    pub action: LifecycleAction,
+    /// Age, from upload, at which the rule applies.
+    pub after_days: i64,
+    #[serde(default = "enabled_by_default")]
+    pub enabled: bool,
+}
+
+impl LifecycleRule {
+    /// Per-field problems, keyed `rules[{index}].{field}` for the admin
+    /// API's validation response.
+    fn problems(&self, index: usize) -> Vec<(String, &'static str)> {
+        let mut out = Vec::new();
+        let field = |name: &str| format!("rules[{index}].{name}");
+        if self.id.trim().is_empty() || self.id.len() > 64 {
+            out.push((field("id"), "1-64 characters"));
+        }
+        if !self.prefix.is_empty() && !storage::is_valid_storage_key(&self.prefix) {
+            out.push((field("prefix"), "not a valid key prefix"));
+        }
+        if !(1..=MAX_AFTER_DAYS).contains(&self.after_days) {
+            out.push((field("after_days"), "must be between 1 and 3650"));
+        }
+        out
+    }
+
+    fn matches_key(&self, key: &str) -> bool {
+        self.enabled && key.starts_with(&self.prefix)
+    }
+
+    /// Upload-time cutoff for this rule at `now`.
+    fn cutoff(&self, now: chrono::DateTime<chrono::Utc>) -> String {
+        crate::util::format_rfc3339(now - chrono::Duration::days(self.after_days))
+    }
+}
+
+/// Parse a bucket's stored rules. An empty or corrupt column reads as no
+/// rules (logged), so one bad row can't stop other buckets' runs.
+pub(crate) fn parse_rules(bucket: &str, raw: &str) -> Vec<LifecycleRule> {
+    if raw.trim().is_empty() {
+        return Vec::new();
+    }
+    serde_json::from_str(raw).unwrap_or_else(|e| {
+        tracing::warn!(bucket = %bucket, error = %e, "unreadable lifecycle rules; ignoring");
+        Vec::new()
+    })
+}
+
+/// Validate a full rule set for one bucket.
+fn rule_set_problems(rules: &[LifecycleRule]) -> Vec<(String, &'static str)> {
+    let mut problems: Vec<(String, &'static str)> = rules
+        .iter()
+        .enumerate()
+        .flat_map(|(i, r)| r.problems(i))
+        .collect();
+    if rules.len() > MAX_RULES {
+        problems.push(("rules".to_string(), "at most 20 rules per bucket"));
+    }
+    for (i, r) in rules.iter().enumerate() {
+        if rules[..i].iter().any(|p| p.id == r.id) {
+            problems.push((format!("rules[{i}].id"), "duplicates an earlier rule"));
+        }
+    }
+    problems
+}
+
+/// When an object uploaded at `now` under `key` will be deleted by the
+/// earliest-firing enabled delete rule, if any. Returned in the upload
+/// response so clients can show it.
+pub(crate) fn expiry_for(
+    rules: &[LifecycleRule],
+    key: &str,
+    now: chrono::DateTime<chrono::Utc>,
+) -> Option<String> {
+    rules
+        .iter()
+        .filter(|r| r.action == LifecycleAction::Delete && r.matches_key(key))
+        .map(|r| r.after_days)
+        .min()
+        .map(|days| crate::util::format_rfc3339(now + chrono::Duration::days(days)))
+}
+
+/// [`expiry_for`] against the rules stored on `bucket`. Lookup failures
+/// omit the date rather than fail the upload.
+pub(crate) async fn expiry_for_upload(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+) -> Option<String> {
+    let raw = repo::buckets::lifecycle_rules(ctx, bucket).await.ok()??;
+    expiry_for(&parse_rules(bucket, &raw), key, crate::clock::now())
+}
+
+// ---------------------------------------------------------------------------
+// Evaluation
+// ---------------------------------------------------------------------------
+
+/// Outcome of one rule within a run.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct RuleOutcome {
+    pub bucket: String,
+    pub rule_id: String,
+    pub action: LifecycleAction,
+    pub matched: i64,
+    pub affected: i64,
+    pub errors: i64,
+    /// Matches left alone because a lock covers them.
+    pub locked: i64,
+    /// The run's object budget ran out while this rule still had matches.
+    pub capped: bool,
+}
+
+async fn batch_size(ctx: &dyn Context) -> i64 {
+    config::get_default(ctx, BATCH_SIZE_KEY, DEFAULT_BATCH_SIZE)
+        .await
+        .trim()
+        .parse::<i64>()
+        .ok()
+        .filter(|n| *n > 0)
+        .unwrap_or(500)
+}
+
+/// Name of the built-in schedule that runs the rules.
+pub const SCHEDULE: &str = "files.lifecycle";
+/// Job type for a full rule run, queued by [`SCHEDULE`].
+pub const RUN_JOB: &str = "files.lifecycle.run";
+
+/// Run a [`RUN_JOB`]. A repeat within [`REPEAT_GUARD_MINUTES`] of the last
+/// run is a no-op, so a job delivered twice does not evaluate the rules
+/// twice.
+pub async fn run_job(ctx: &dyn Context) -> Result<(), JobError> {
+    if !is_due(ctx).await? {
+        return Ok(());
+    }
+    run_all(ctx).await?;
+    Ok(())
+}
+
+/// Whether the last run is at least [`REPEAT_GUARD_MINUTES`] old.
+async fn is_due(ctx: &dyn Context) -> Result<bool, WaferError> {
+    let last_run = repo::lifecycle::last_run_at(ctx).await?;
+    Ok(last_run
+        .as_deref()
+        .and_then(crate::util::parse_timestamp)
+        .map_or(true, |l| {
+            crate::clock::now() - l >= chrono::Duration::minutes(REPEAT_GUARD_MINUTES)
+        }))
+}
+
+/// Evaluate every bucket's rules now, within one batch budget, recording a
+/// summary row per rule.
+pub async fn run_all(ctx: &dyn Context) -> Result<Vec<RuleOutcome>, WaferError> {
+    let now = crate::clock::now();
+    let mut budget = batch_size(ctx).await;
+    let run_id = crate::clock::new_id();
+    let mut outcomes = Vec::new();
+
+    for bucket_row in repo::buckets::list_with_lifecycle_rules(ctx).await? {
+        let bucket = bucket_row.str_field("name");
+        let rules = parse_rules(bucket, bucket_row.str_field("lifecycle_rules"));
+        for rule in rules.iter().filter(|r| r.enabled) {
+            let outcome = apply_rule(ctx, bucket, rule, now, budget).await?;
+            budget -= outcome.affected + outcome.errors;
+            let row = repo::lifecycle::NewRun {
+                run_id: &run_id,
+                bucket,
+                rule_id: &rule.id,
+                action: rule.action.as_str(),
+                matched: outcome.matched,
+                affected: outcome.affected,
+                errors: outcome.errors,
+                locked: outcome.locked,
+                capped: outcome.capped,
+            };
+            if let Err(e) = repo::lifecycle::insert_run(ctx, row).await {
+                tracing::warn!(error = %e, "lifecycle: recording run summary failed");
+            }
+            outcomes.push(outcome);
+        }
+    }
+    Ok(outcomes)
+}
+
+/// Apply `rule` to at most `budget` matching objects of `bucket`.
+async fn apply_rule(
+    ctx: &dyn Context,
+    bucket: &str,
+    rule: &LifecycleRule,
+    now: chrono::DateTime<chrono::Utc>,
+    budget: i64,
+) -> Result<RuleOutcome, WaferError> {
+    let mut outcome = RuleOutcome {
+        bucket: bucket.to_string(),
+        rule_id: rule.id.clone(),
+        action: rule.action,
+        matched: 0,
+        affected: 0,
+        errors: 0,
+        locked: 0,
+        capped: false,
+    };
+    if budget <= 0 {
+        outcome.capped = true;
+        return Ok(outcome);
+    }
+    // One extra row tells us whether the budget cut the rule short.
+    let candidates = repo::objects::list_uploaded_before(
+        ctx,
+        bucket,
+        &rule.prefix,
+        &rule.cutoff(now),
+        rule.action.statuses(),
+        budget + 1,
+    )
+    .await?
+    .records;
+    outcome.capped = candidates.len() as i64 > budget;
+    let locks = locks::LockSet::load(ctx, bucket).await?;
+
+    // The server's own deletes: no actor, one history batch per rule run.
+    let batch_id = history::new_batch_id();
+    for object in candidates.iter().take(budget as usize) {
+        outcome.matched += 1;
+        if locks.covering(object.str_field("key")).is_some() {
+            outcome.locked += 1;
+            continue;
+        }
+        let result = match rule.action {
+            LifecycleAction::Delete => {
+                let key = object.str_field("key");
+                match storage::delete_object_and_metadata(ctx, bucket, key).await {
+                    Ok(()) => Ok(true),
+                    // Blob already gone: still drop the row so quota and
+                    // listings stop counting it.
+                    Err(e) if e.code == ErrorCode::NotFound => {
+                        storage::delete_object_metadata(ctx, bucket, key).await;
+                        Ok(true)
+                    }
+                    Err(e) => Err(e),
+                }
+            }
+            LifecycleAction::Archive => repo::objects::mark_archived(ctx, &object.id).await,
+        };
+        match result {
+            Ok(true) => {
+                outcome.affected += 1;
+                if rule.action == LifecycleAction::Delete {
+                    let event = history::Event::deleted(object, "").batch(&batch_id);
+                    history::record(ctx, event).await;
+                }
+            }
+            // Raced with a user delete / another run: nothing left to do.
+            Ok(false) => {}
+            Err(e) => {
+                outcome.errors += 1;
+                tracing::warn!(
+                    bucket = %bucket,
+                    key = %object.str_field("key"),
+                    error = %e,
+                    "lifecycle {} failed",
+                    rule.action.as_str(),
+                );
+            }
+        }
+    }
+    Ok(outcome)
+}
+
+// ---------------------------------------------------------------------------
+// Admin API
+// ---------------------------------------------------------------------------
+
+/// `GET /admin/storage/buckets/{name}/lifecycle`
+pub async fn handle_get_rules(ctx: &dyn Context, bucket: &str) -> OutputStream {
+    match repo::buckets::lifecycle_rules(ctx, bucket).await {
+        Ok(Some(raw)) => ok_json(&serde_json::json!({
+            "bucket": bucket,
+            "rules": parse_rules(bucket, &raw),
+        })),
+        Ok(None) => err_not_found("Bucket not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `PUT /admin/storage/buckets/{name}/lifecycle` (arrives as `update`) —
+/// replace the bucket's rules with `{"rules": [...]}`.
+pub async fn handle_put_rules(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        rules: Vec<LifecycleRule>,
+    }
+    let body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    let problems = rule_set_problems(&body.rules);
+    if !problems.is_empty() {
+        let fields: Vec<(&str, &str)> = problems.iter().map(|(f, r)| (f.as_str(), *r)).collect();
+        return errors::validation_error("Invalid lifecycle rules", &fields);
+    }
+
+    let json = serde_json::to_string(&body.rules).unwrap_or_else(|_| "[]".to_string());
+    match repo::buckets::set_lifecycle_rules(ctx, bucket, &json).await {
+        Ok(0) => err_not_found("Bucket not found"),
+        Ok(_) => handle_get_rules(ctx, bucket).await,
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `POST /admin/storage/buckets/{name}/lifecycle/test` — the first
+/// [`DRY_RUN_LIMIT`] objects the posted rule would act on right now.
+/// Nothing is changed; the rule need not be saved (or enabled).
+pub async fn handle_test_rule(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    input: InputStream,
+) -> OutputStream {
+    let rule: LifecycleRule = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    let problems = rule.problems(0);
+    if !problems.is_empty() {
+        let fields: Vec<(&str, &str)> = problems.iter().map(|(f, r)| (f.as_str(), *r)).collect();
+        return errors::validation_error("Invalid lifecycle rule", &fields);
+    }
+    match repo::buckets::lifecycle_rules(ctx, bucket).await {
+        Ok(Some(_)) => {}
+        Ok(None) => return err_not_found("Bucket not found"),
+        Err(e) => return err_internal("Database error", e),
+    }
+
+    let matches = match repo::objects::list_uploaded_before(
+        ctx,
+        bucket,
+        &rule.prefix,
+        &rule.cutoff(crate::clock::now()),
+        rule.action.statuses(),
+        DRY_RUN_LIMIT,
+    )
+    .await
+    {
+        Ok(list) => list.records,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let objects: Vec<serde_json::Value> = matches
+        .iter()
+        .map(|r| {
+            serde_json::json!({
+                "key": r.str_field("key"),
+                "size": r.i64_field("size"),
+                "status": r.str_field("status"),
+                "uploaded_at": r.str_field("uploaded_at"),
+            })
+        })
+        .collect();
+    ok_json(&serde_json::json!({
+        "bucket": bucket,
+        "action": rule.action,
+        "limit": DRY_RUN_LIMIT,
+        "objects": objects,
+    }))
+}
+
+/// `POST /admin/storage/lifecycle/run` — run every rule now, regardless of
+/// when the last run was.
+pub async fn handle_run_now(ctx: &dyn Context) -> OutputStream {
+    match run_all(ctx).await {
+        Ok(outcomes) => ok_json(&serde_json::json!({ "results": outcomes })),
+        Err(e) => err_internal("Lifecycle run failed", e),
+    }
+}
+
+/// `GET /admin/storage/lifecycle/runs?page=&page_size=` — run summaries,
+/// newest first.
+pub async fn handle_list_runs(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let (page, page_size, _) = msg.pagination_params(50);
+    match repo::lifecycle::list_runs(ctx, page as i64, page_size as i64).await {
+        Ok(list) => ok_json(&list),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// Route the lifecycle part of the admin storage API; `None` when `path`
+/// is not a lifecycle path.
+pub async fn handle_admin(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> Option<OutputStream> {
+    let path = msg.path();
+    match (msg.action(), path) {
+        ("create", "/admin/storage/lifecycle/run") => return Some(handle_run_now(ctx).await),
+        ("retrieve", "/admin/storage/lifecycle/runs") => {
+            return Some(handle_list_runs(ctx, msg).await)
+        }
+        _ => {}
+    }
+    let rest = path.strip_prefix("/admin/storage/buckets/")?;
+    let (bucket, tail) = rest.split_once('/')?;
+    if !storage::is_safe_bucket_name(bucket) {
+        return Some(err_bad_request("Invalid bucket name"));
+    }
+    Some(match (msg.action(), tail) {
+        ("retrieve", "lifecycle") => handle_get_rules(ctx, bucket).await,
+        ("update", "lifecycle") => handle_put_rules(ctx, msg, bucket, input).await,
+        ("create", "lifecycle/test") => handle_test_rule(ctx, msg, bucket, input).await,
+        _ => return None,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rule(id: &str, prefix: &str, action: LifecycleAction, after_days: i64) -> LifecycleRule {
+        LifecycleRule {
+            id: id.to_string(),
+            prefix: prefix.to_string(),
+            action,
+            after_days,
+            enabled: true,
+        }
+    }
+
+    #[test]
+    fn parses_rules_with_defaults() {
+        let rules = parse_rules("b", r#"[{"id":"tmp","action":"delete","after_days":7}]"#);
+        assert_eq!(rules, vec![rule("tmp", "", LifecycleAction::Delete, 7)]);
+        assert!(parse_rules("b", "not json").is_empty());
+        assert!(parse_rules("b", "").is_empty());
+    }
+
+    #[test]
+    fn rule_set_validation_reports_fields() {
+        let rules = vec![
+            rule("a", "logs/", LifecycleAction::Delete, 30),
+            rule("a", "../x", LifecycleAction::Archive, 0),
+        ];
+        let fields: Vec<String> = rule_set_problems(&rules)
+            .into_iter()
+            .map(|(f, _)| f)
+            .collect();
+        assert_eq!(
+            fields,
+            ["rules[1].prefix", "rules[1].after_days", "rules[1].id"]
+        );
+        assert!(rule_set_problems(&rules[..1]).is_empty());
+    }
+
+    #[test]
+    fn expiry_uses_earliest_matching_delete_rule() {
+        let now = chrono::DateTime::parse_from_rfc3339("2026-01-01T00:00:00Z")
+            .unwrap()
+            .with_timezone(&chrono::Utc);
+        let mut disabled = rule("off", "", LifecycleAction::Delete, 1);
+        disabled.enabled = false;
+        let rules = vec![
+            rule("all", "", LifecycleAction::Delete, 90),
+            rule("tmp", "tmp/", LifecycleAction::Delete, 7),
+            rule("cold", "", LifecycleAction::Archive, 2),
+            disabled,
+        ];
+        assert_eq!(
+            expiry_for(&rules, "tmp/a.txt", now).as_deref(),
+            Some("2026-01-08T00:00:00.000000Z")
+        );
+        assert_eq!(
+            expiry_for(&rules, "docs/a.txt", now).as_deref(),
+            Some("2026-04-01T00:00:00.000000Z")
+        );
+        assert_eq!(expiry_for(&rules[2..], "docs/a.txt", now), None);
+    }
+}
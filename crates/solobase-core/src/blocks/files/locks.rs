@@ -0,0 +1,580 @@
+//! Object locks (legal holds): a path nobody may delete or change until a
+//! set time — the bucket owner and admins included.
+//!
+//! A lock is set on an object or a folder, named by the same ids the
+//! breadcrumbs hand out (an object row id, or a folder prefix ending in
+//! `/`). Like a grant, a folder lock covers every key beneath it, resolved
+//! by walking the key's parent chain ([`super::acl::covering_paths`]).
+//! While it is in force:
+//!
+//! - a covered object can't be deleted, overwritten (upload, archive
+//!   expansion, S3 PutObject), moved or renamed, or have its metadata
+//!   changed, and nothing new can be stored under a locked folder;
+//! - a folder with a lock anywhere inside it can't be moved, and a bucket
+//!   with any lock can't be deleted, forced or not;
+//! - lifecycle rules skip covered objects and count them as `locked` in
+//!   the run summary.
+//!
+//! Refusals answer `423 object_locked` with the lock's path, end and
+//! owner in `details`. Lock lookups that fail refuse too.
+//!
+//! - `GET /b/storage/api/buckets/{name}/locks` — every lock on the bucket,
+//!   expired ones marked `active: false`.
+//! - `POST /b/storage/api/buckets/{name}/locks` — `{id, until}` locks the
+//!   item until `until` (RFC 3339, in the future). Locking a locked path
+//!   again may only extend it.
+//! - `DELETE /b/storage/api/buckets/{name}/locks/{id}` — lifts a lock:
+//!   its owner or an admin while it is in force, only an admin after.
+//!
+//! Setting and lifting locks is for the bucket's owner and admins. Each is
+//! a `lock` / `unlock` history event and an audit log entry.
+
+use wafer_core::clients::database::Record;
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream, WaferError};
+
+use super::{acl, history, moves, repo, storage};
+use crate::{
+    blocks::{admin::audit_log, errors},
+    http::{err_bad_request, err_forbidden, err_internal, err_not_found, ok_json},
+    util::RecordExt,
+};
+
+/// One lock in force.
+#[derive(Debug, Clone)]
+pub(super) struct Lock {
+    pub(super) id: String,
+    pub(super) path: String,
+    pub(super) locked_until: String,
+    pub(super) locked_by: String,
+}
+
+impl Lock {
+    fn from_row(row: &Record) -> Self {
+        Self {
+            id: row.id.clone(),
+            path: row.str_field("path").to_string(),
+            locked_until: row.str_field("locked_until").to_string(),
+            locked_by: row.str_field("locked_by").to_string(),
+        }
+    }
+
+    pub(super) fn message(&self) -> String {
+        format!("'{}' is locked until {}", self.path, self.locked_until)
+    }
+
+    pub(super) fn details(&self) -> serde_json::Value {
+        serde_json::json!({
+            "lock_id": self.id,
+            "path": self.path,
+            "locked_until": self.locked_until,
+            "locked_by": self.locked_by,
+        })
+    }
+
+    /// The `423 object_locked` refusal.
+    pub(super) fn response(&self) -> OutputStream {
+        errors::error_json(
+            errors::ErrorCode::ObjectLocked,
+            &self.message(),
+            Some(self.details()),
+        )
+    }
+}
+
+/// The locks in force on one bucket, loaded once per request.
+#[derive(Debug, Default)]
+pub(super) struct LockSet(Vec<Lock>);
+
+impl LockSet {
+    pub(super) async fn load(ctx: &dyn Context, bucket: &str) -> Result<Self, WaferError> {
+        let rows = repo::locks::list_active(ctx, bucket, &crate::util::now_rfc3339()).await?;
+        Ok(Self(rows.iter().map(Lock::from_row).collect()))
+    }
+
+    /// The lock covering `key`, if any: the one that ends last when several
+    /// enclosing paths are locked.
+    pub(super) fn covering(&self, key: &str) -> Option<&Lock> {
+        let paths = acl::covering_paths(key);
+        self.0
+            .iter()
+            .filter(|lock| paths.contains(&lock.path))
+            .max_by(|a, b| a.locked_until.cmp(&b.locked_until))
+    }
+
+    /// The lock that stops `path` from changing: one covering it, or, for a
+    /// folder prefix, any lock inside it.
+    pub(super) fn blocking(&self, path: &str) -> Option<&Lock> {
+        if let Some(lock) = self.covering(path) {
+            return Some(lock);
+        }
+        if !path.is_empty() && !path.ends_with('/') {
+            return None;
+        }
+        self.0
+            .iter()
+            .filter(|lock| lock.path.starts_with(path))
+            .max_by(|a, b| a.locked_until.cmp(&b.locked_until))
+    }
+}
+
+/// Refuse a change to any of `paths` (object keys or folder prefixes) in
+/// `bucket` that a lock blocks. A failed lookup refuses too.
+pub(super) async fn guard(
+    ctx: &dyn Context,
+    bucket: &str,
+    paths: &[&str],
+) -> Result<(), OutputStream> {
+    let locks = match LockSet::load(ctx, bucket).await {
+        Ok(locks) => locks,
+        Err(e) => return Err(err_internal("Database error", e)),
+    };
+    match paths.iter().find_map(|path| locks.blocking(path)) {
+        Some(lock) => Err(lock.response()),
+        None => Ok(()),
+    }
+}
+
+/// A lock row as the API returns it.
+fn to_json(row: &Record, now: &str) -> serde_json::Value {
+    let locked_until = row.str_field("locked_until");
+    serde_json::json!({
+        "id": row.id,
+        "path": row.str_field("path"),
+        "locked_until": locked_until,
+        "locked_by": row.str_field("locked_by"),
+        "active": locked_until > now,
+        "created_at": row.str_field("created_at"),
+        "updated_at": row.str_field("updated_at"),
+    })
+}
+
+/// Record a `lock` / `unlock` event for `path`: on its object row when it
+/// is a key, else on the folder path itself.
+async fn record_event(
+    ctx: &dyn Context,
+    bucket: &str,
+    path: &str,
+    action: &'static str,
+    actor: &str,
+    before: serde_json::Value,
+    after: serde_json::Value,
+) {
+    let row = if path.ends_with('/') {
+        None
+    } else {
+        match repo::objects::find_by_bucket_key(ctx, bucket, path).await {
+            Ok(row) => row,
+            Err(e) => {
+                tracing::warn!(error = %e, bucket = %bucket, "lock event: object lookup failed");
+                None
+            }
+        }
+    };
+    let event = match &row {
+        Some(row) => history::Event::new(action, row, actor),
+        None => history::Event::on_path(action, bucket, path, actor),
+    };
+    history::record(ctx, event.change(before, after)).await;
+}
+
+/// `GET /b/storage/api/buckets/{name}/locks`.
+pub(super) async fn handle_list(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if !storage::is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let now = crate::util::now_rfc3339();
+    match repo::locks::list_for_bucket(ctx, bucket).await {
+        Ok(rows) => ok_json(&serde_json::json!({
+            "locks": rows.iter().map(|row| to_json(row, &now)).collect::<Vec<_>>(),
+        })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `POST /b/storage/api/buckets/{name}/locks` — body `{id, until}`.
+pub(super) async fn handle_lock(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        #[serde(default)]
+        id: String,
+        #[serde(default)]
+        until: String,
+    }
+
+    if !storage::is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+
+    let now = chrono::Utc::now();
+    let until = crate::util::parse_timestamp(&body.until);
+    let mut invalid = Vec::new();
+    if body.id.trim().is_empty() {
+        invalid.push(("id", "required"));
+    }
+    match until {
+        Some(until) if until > now => {}
+        Some(_) => invalid.push(("until", "must be in the future")),
+        None => invalid.push(("until", "must be an RFC 3339 timestamp")),
+    }
+    if !invalid.is_empty() {
+        return errors::validation_error("Invalid lock", &invalid);
+    }
+    let until = crate::util::format_rfc3339(until.unwrap_or(now));
+
+    let path = match resolve(ctx, bucket, body.id.trim()).await {
+        Ok(path) => path,
+        Err(r) => return r,
+    };
+    let previous = match repo::locks::find_by_path(ctx, bucket, &path).await {
+        Ok(previous) => previous,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let previous_until = previous
+        .as_ref()
+        .map(|row| row.str_field("locked_until").to_string())
+        .filter(|prev| *prev > crate::util::format_rfc3339(now));
+    if let Some(prev) = &previous_until {
+        if until < *prev {
+            return errors::error_json(
+                errors::ErrorCode::Conflict,
+                &format!("'{path}' is already locked until {prev}; a lock can only be extended"),
+                Some(serde_json::json!({ "locked_until": prev })),
+            );
+        }
+    }
+
+    let row = match repo::locks::upsert(ctx, bucket, &path, &until, msg.user_id()).await {
+        Ok(row) => row,
+        Err(e) => return errors::db_error_response("Lock", e),
+    };
+    record_event(
+        ctx,
+        bucket,
+        &path,
+        history::ACTION_LOCK,
+        msg.user_id(),
+        serde_json::json!({ "locked_until": previous_until }),
+        serde_json::json!({ "path": path, "locked_until": until }),
+    )
+    .await;
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "storage.lock",
+        &format!("bucket:{bucket}/{path}"),
+        msg.remote_addr(),
+    )
+    .await;
+    ok_json(&to_json(&row, &crate::util::now_rfc3339()))
+}
+
+/// Resolve a breadcrumb id to the path it names: an existing folder
+/// prefix, or the key of an object row in `bucket`.
+async fn resolve(ctx: &dyn Context, bucket: &str, id: &str) -> Result<String, OutputStream> {
+    if id.ends_with('/') {
+        if !storage::is_valid_storage_key(id) {
+            return Err(errors::validation_error(
+                "Invalid lock",
+                &[("id", "must be an object id or a folder path ending in '/'")],
+            ));
+        }
+        return match moves::path_exists(ctx, bucket, id).await {
+            Ok(true) => Ok(id.to_string()),
+            Ok(false) => Err(errors::error_json(
+                errors::ErrorCode::ObjectNotFound,
+                "Folder not found",
+                None,
+            )),
+            Err(e) => Err(err_internal("Database error", e)),
+        };
+    }
+    match repo::objects::find_in_bucket_by_ids(ctx, bucket, &[id.to_string()]).await {
+        Ok(rows) => rows
+            .first()
+            .map(|row| row.str_field("key").to_string())
+            .ok_or_else(|| {
+                errors::error_json(errors::ErrorCode::ObjectNotFound, "Object not found", None)
+            }),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// `DELETE /b/storage/api/buckets/{name}/locks/{id}`.
+pub(super) async fn handle_unlock(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if !storage::is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let id = msg.var("id");
+    if id.is_empty() {
+        return err_bad_request("Missing lock ID");
+    }
+    let row = match repo::locks::find_by_id(ctx, id).await {
+        // A lock id from another bucket answers 404, not 403, so ids can't
+        // be probed across buckets.
+        Ok(row) if row.str_field("bucket") == bucket => row,
+        Ok(_) => return err_not_found("Lock not found"),
+        Err(e) if e.code == ErrorCode::NotFound => return err_not_found("Lock not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    let lock = Lock::from_row(&row);
+    let active = lock.locked_until > crate::util::now_rfc3339();
+    if !crate::util::is_admin(msg) {
+        if !active {
+            return err_forbidden("Only admins can remove an expired lock");
+        }
+        if lock.locked_by != msg.user_id() {
+            return err_forbidden("Only the user who set this lock or an admin can lift it");
+        }
+    }
+    if let Err(e) = repo::locks::delete(ctx, id).await {
+        return err_internal("Failed to remove lock", e);
+    }
+    record_event(
+        ctx,
+        bucket,
+        &lock.path,
+        history::ACTION_UNLOCK,
+        msg.user_id(),
+        serde_json::json!({
+            "path": lock.path,
+            "locked_until": lock.locked_until,
+            "locked_by": lock.locked_by,
+        }),
+        serde_json::json!({}),
+    )
+    .await;
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "storage.unlock",
+        &format!("bucket:{bucket}/{}", lock.path),
+        msg.remote_addr(),
+    )
+    .await;
+    ok_json(&serde_json::json!({"deleted": true, "active": active}))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::{super::blobs, *};
+    use crate::test_support::{admin_msg, auth_msg, output_json, output_status, TestContext};
+
+    async fn ctx() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let data = crate::util::json_map(serde_json::json!({
+            "name": "team",
+            "public": false,
+            "created_by": "alice",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(&ctx, data).await.expect("seed bucket");
+        ctx
+    }
+
+    fn in_a_day() -> String {
+        crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::days(1))
+    }
+
+    fn bucket_msg(mut msg: Message) -> Message {
+        msg.set_meta("req.param.name", "team");
+        msg
+    }
+
+    async fn lock(ctx: &TestContext, user: &str, id: &str, until: &str) -> OutputStream {
+        let msg = bucket_msg(auth_msg(
+            "create",
+            "/b/storage/api/buckets/team/locks",
+            user,
+        ));
+        let body = serde_json::json!({ "id": id, "until": until });
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        handle_lock(ctx, &msg, "team", input).await
+    }
+
+    async fn unlock(ctx: &TestContext, mut msg: Message, id: &str) -> u16 {
+        msg.set_meta("req.param.name", "team");
+        msg.set_meta("req.param.id", id);
+        output_status(handle_unlock(ctx, &msg, "team").await).await
+    }
+
+    #[test]
+    fn folder_locks_block_their_subtree_and_the_folders_above() {
+        let lock = |path: &str| Lock {
+            id: path.to_string(),
+            path: path.to_string(),
+            locked_until: "2999-01-01T00:00:00.000000Z".to_string(),
+            locked_by: "alice".to_string(),
+        };
+        let set = LockSet(vec![lock("docs/q1/"), lock("notes.txt")]);
+        assert_eq!(set.covering("docs/q1/a.txt").unwrap().path, "docs/q1/");
+        assert!(set.covering("docs/q2/a.txt").is_none());
+        assert!(set.covering("docs/q1").is_none(), "not a folder");
+        assert_eq!(set.covering("notes.txt").unwrap().path, "notes.txt");
+        // Moving or deleting an enclosing folder would take the lock along.
+        assert_eq!(set.blocking("docs/").unwrap().path, "docs/q1/");
+        assert!(set.blocking("").is_some());
+        assert!(set.blocking("docs/q2/").is_none());
+        assert!(LockSet::default().blocking("").is_none());
+    }
+
+    #[tokio::test]
+    async fn a_locked_object_cannot_be_deleted_even_by_its_owner() {
+        let ctx = ctx().await;
+        let row = blobs::seed(&ctx, "team", "a.txt", b"a", "text/plain", "alice").await;
+        let until = in_a_day();
+        let locked = output_json(lock(&ctx, "alice", &row.id, &until).await).await;
+        assert_eq!(locked["path"], "a.txt", "{locked}");
+        assert_eq!(locked["active"], true);
+
+        let path = "/b/storage/api/buckets/team/objects/a.txt";
+        let out = storage::handle(
+            &ctx,
+            auth_msg("delete", path, "alice"),
+            InputStream::empty(),
+        );
+        let body = output_json(out.await).await;
+        assert_eq!(body["code"], "object_locked", "{body}");
+        assert_eq!(body["details"]["locked_until"], until.as_str());
+        assert_eq!(body["details"]["locked_by"], "alice");
+        let admin = admin_msg("delete", path);
+        let out = storage::handle(&ctx, admin, InputStream::empty()).await;
+        assert_eq!(output_status(out).await, 423);
+        assert!(repo::objects::find_by_bucket_key(&ctx, "team", "a.txt")
+            .await
+            .unwrap()
+            .is_some());
+
+        // Listings carry the lock; history records who set it.
+        let listing = storage::handle(
+            &ctx,
+            auth_msg("retrieve", "/b/storage/api/buckets/team/objects", "alice"),
+            InputStream::empty(),
+        )
+        .await;
+        let listing = output_json(listing).await;
+        assert_eq!(listing["objects"][0]["locked_until"], until.as_str());
+        let events = repo::events::list_for_object(&ctx, "team", &row.id, 10, 0)
+            .await
+            .unwrap()
+            .records;
+        assert_eq!(events[0].str_field("action"), history::ACTION_LOCK);
+        assert_eq!(events[0].str_field("actor_id"), "alice");
+    }
+
+    #[tokio::test]
+    async fn a_folder_lock_stops_moves_uploads_and_bucket_deletion() {
+        let ctx = ctx().await;
+        let child = blobs::seed(&ctx, "team", "docs/a.txt", b"a", "text/plain", "alice").await;
+        blobs::seed(&ctx, "team", "archive/old.txt", b"o", "text/plain", "alice").await;
+        assert_eq!(
+            output_status(lock(&ctx, "alice", "docs/", &in_a_day()).await).await,
+            200
+        );
+
+        let mut msg = auth_msg("create", "/b/storage/api/buckets/team/move", "alice");
+        msg.set_meta("req.param.name", "team");
+        let body = serde_json::json!({ "id": child.id, "target": "archive/" });
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        assert_eq!(
+            output_status(moves::handle(&ctx, &msg, "team", input).await).await,
+            423
+        );
+
+        let mut upload = auth_msg("create", "/b/storage/api/buckets/team/objects", "alice");
+        upload.set_meta("req.query.key", "docs/new.txt");
+        upload.set_meta("req.content_type", "text/plain");
+        let out = storage::handle(&ctx, upload, InputStream::from_bytes(b"n".to_vec())).await;
+        assert_eq!(output_status(out).await, 423);
+
+        let mut delete = admin_msg("delete", "/b/storage/api/buckets/team");
+        delete.set_meta("req.query.force", "true");
+        let out = storage::handle(&ctx, delete, InputStream::empty()).await;
+        assert_eq!(output_status(out).await, 423);
+    }
+
+    #[tokio::test]
+    async fn locks_only_extend_and_lift_for_their_owner_or_an_admin() {
+        let ctx = ctx().await;
+        let row = blobs::seed(&ctx, "team", "a.txt", b"a", "text/plain", "alice").await;
+        let later = crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::days(7));
+        let locked = output_json(lock(&ctx, "alice", &row.id, &later).await).await;
+        let id = locked["id"].as_str().unwrap().to_string();
+        assert_eq!(
+            output_status(lock(&ctx, "alice", &row.id, &in_a_day()).await).await,
+            409
+        );
+        let past = crate::util::format_rfc3339(chrono::Utc::now() - chrono::Duration::hours(1));
+        assert_eq!(
+            output_status(lock(&ctx, "alice", &row.id, &past).await).await,
+            400
+        );
+        assert_eq!(
+            output_status(lock(&ctx, "bob", &row.id, &later).await).await,
+            403
+        );
+
+        let bob = auth_msg("delete", "/b/storage/api/buckets/team/locks", "bob");
+        assert_eq!(unlock(&ctx, bob, &id).await, 403);
+        let alice = auth_msg("delete", "/b/storage/api/buckets/team/locks", "alice");
+        assert_eq!(unlock(&ctx, alice, &id).await, 200);
+        let events = repo::events::list_for_object(&ctx, "team", &row.id, 10, 0)
+            .await
+            .unwrap()
+            .records;
+        assert_eq!(events[0].str_field("action"), history::ACTION_UNLOCK);
+
+        // Once a lock has run out, only an admin clears its row.
+        let stale = repo::locks::upsert(&ctx, "team", "a.txt", &past, "alice")
+            .await
+            .unwrap();
+        let alice = auth_msg("delete", "/b/storage/api/buckets/team/locks", "alice");
+        assert_eq!(unlock(&ctx, alice, &stale.id).await, 403);
+        let admin = admin_msg("delete", "/b/storage/api/buckets/team/locks");
+        assert_eq!(unlock(&ctx, admin, &stale.id).await, 200);
+    }
+
+    #[tokio::test]
+    async fn lifecycle_runs_skip_and_count_locked_objects() {
+        let ctx = ctx().await;
+        let kept = blobs::seed(&ctx, "team", "keep/a.txt", b"a", "text/plain", "alice").await;
+        blobs::seed(&ctx, "team", "b.txt", b"b", "text/plain", "alice").await;
+        lock(&ctx, "alice", "keep/", &in_a_day()).await;
+        let rules = serde_json::json!([
+            {"id": "expire", "action": "delete", "after_days": 0},
+        ]);
+        repo::buckets::set_lifecycle_rules(&ctx, "team", &rules.to_string())
+            .await
+            .unwrap();
+
+        let outcomes = super::super::lifecycle::run_all(&ctx).await.unwrap();
+        assert_eq!(outcomes[0].matched, 2);
+        assert_eq!(outcomes[0].affected, 1);
+        assert_eq!(outcomes[0].locked, 1);
+        assert!(repo::objects::get(&ctx, &kept.id).await.is_ok());
+        assert!(repo::objects::find_by_bucket_key(&ctx, "team", "b.txt")
+            .await
+            .unwrap()
+            .is_none());
+        let runs = repo::lifecycle::list_runs(&ctx, 1, 10).await.unwrap();
+        assert_eq!(runs.records[0].i64_field("locked"), 1);
+    }
+}
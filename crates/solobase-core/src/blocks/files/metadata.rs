@@ -0,0 +1,295 @@
+//! Structured per-object metadata: a JSON object stored on the object row
+//! (`suppers_ai__files__objects.metadata`, TEXT holding the serialized
+//! object).
+//!
+//! - `GET /b/storage/api/buckets/{name}/metadata/{key...}` — the object's
+//!   metadata.
+//! - `PUT /b/storage/api/buckets/{name}/metadata/{key...}` — replace every
+//!   client key with the body.
+//! - `PATCH /b/storage/api/buckets/{name}/metadata/{key...}` — JSON merge
+//!   patch (RFC 7386): keys in the body are set, `null` deletes a key, and
+//!   nested objects merge recursively.
+//!
+//! The top-level [`SYSTEM_KEY`] namespace is written by the server only
+//! (`detected_content_type`, set at upload); a client body naming it is
+//! rejected, and a full replacement keeps it. Stored metadata is capped at
+//! [`MAX_BYTES`] serialized and [`MAX_KEYS`] top-level keys.
+//!
+//! Reads go through [`parse_stored`], so a value that is not a JSON object
+//! comes back as `{"legacy": "<raw>"}` rather than being dropped. Listings
+//! and search return the parsed object under `metadata`.
+//!
+//! Writes to a locked object answer `423 object_locked` (see `locks`). A
+//! successful write records a `metadata` history event with the keys that
+//! changed (see `history`).
+
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::{
+    acl::{self, Access},
+    history, locks, repo,
+    storage::{is_safe_bucket_name, is_valid_storage_key},
+};
+use crate::{
+    blocks::errors::{self, ErrorCode},
+    http::{err_bad_request, err_forbidden, err_internal, ok_json},
+    util::RecordExt,
+};
+
+/// Largest serialized metadata object, in bytes.
+pub(crate) const MAX_BYTES: usize = 8 * 1024;
+/// Most top-level keys one object's metadata may hold.
+pub(crate) const MAX_KEYS: usize = 64;
+/// Top-level key reserved for server-written values.
+pub(crate) const SYSTEM_KEY: &str = "system";
+/// Key a non-object stored value is wrapped under on read.
+const LEGACY_KEY: &str = "legacy";
+
+type Map = serde_json::Map<String, serde_json::Value>;
+
+/// The stored column value as a JSON object. Empty is `{}`; anything that
+/// does not parse as an object is kept as `{"legacy": raw}`.
+pub(crate) fn parse_stored(raw: &str) -> Map {
+    if raw.trim().is_empty() {
+        return Map::new();
+    }
+    match serde_json::from_str::<serde_json::Value>(raw) {
+        Ok(serde_json::Value::Object(map)) => map,
+        _ => {
+            let mut map = Map::new();
+            map.insert(LEGACY_KEY.to_string(), serde_json::json!(raw));
+            map
+        }
+    }
+}
+
+/// Replace a record's raw `metadata` string with the parsed object, for
+/// listing and search responses. Records without the field get `{}`.
+pub(crate) fn expand(record: &mut wafer_core::clients::database::Record) {
+    let parsed = parse_stored(record.str_field("metadata"));
+    record
+        .data
+        .insert("metadata".to_string(), serde_json::Value::Object(parsed));
+}
+
+/// Apply RFC 7386 JSON merge patch `patch` onto `target`.
+pub(crate) fn merge_patch(target: &mut Map, patch: &Map) {
+    for (key, value) in patch {
+        match value {
+            serde_json::Value::Null => {
+                target.remove(key);
+            }
+            serde_json::Value::Object(inner) => {
+                let slot = target
+                    .entry(key.clone())
+                    .or_insert_with(|| serde_json::Value::Object(Map::new()));
+                if !slot.is_object() {
+                    *slot = serde_json::Value::Object(Map::new());
+                }
+                if let serde_json::Value::Object(slot) = slot {
+                    merge_patch(slot, inner);
+                }
+            }
+            other => {
+                target.insert(key.clone(), other.clone());
+            }
+        }
+    }
+}
+
+/// Check a metadata object against the size and key-count caps.
+pub(crate) fn validate(map: &Map) -> Result<(), String> {
+    if map.len() > MAX_KEYS {
+        return Err(format!("at most {MAX_KEYS} top-level keys"));
+    }
+    if map.keys().any(|k| k.is_empty()) {
+        return Err("keys must not be empty".to_string());
+    }
+    let size = serde_json::to_vec(map)
+        .map(|v| v.len())
+        .unwrap_or(usize::MAX);
+    if size > MAX_BYTES {
+        return Err(format!("at most {MAX_BYTES} bytes when serialized"));
+    }
+    Ok(())
+}
+
+/// How a client body changes the stored metadata.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum Update {
+    /// `PUT`: the body becomes the client keys.
+    Replace,
+    /// `PATCH`: the body is a merge patch.
+    Merge,
+}
+
+/// `current` after a client `update` with `body`. The system namespace is
+/// carried over untouched and may not appear in `body`.
+pub(crate) fn apply_client_update(
+    current: &Map,
+    body: &serde_json::Value,
+    update: Update,
+) -> Result<Map, String> {
+    let serde_json::Value::Object(body) = body else {
+        return Err("metadata must be a JSON object".to_string());
+    };
+    if body.contains_key(SYSTEM_KEY) {
+        return Err(format!(
+            "\"{SYSTEM_KEY}\" is reserved for server-written keys"
+        ));
+    }
+    let next = match update {
+        Update::Replace => {
+            let mut next = body.clone();
+            if let Some(system) = current.get(SYSTEM_KEY) {
+                next.insert(SYSTEM_KEY.to_string(), system.clone());
+            }
+            next
+        }
+        Update::Merge => {
+            let mut next = current.clone();
+            merge_patch(&mut next, body);
+            next
+        }
+    };
+    validate(&next)?;
+    Ok(next)
+}
+
+/// `GET` / `PUT` / `PATCH` on `/b/storage/api/buckets/{name}/metadata/{key...}`.
+pub(super) async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    key: &str,
+    input: InputStream,
+) -> OutputStream {
+    if !is_safe_bucket_name(bucket) || !is_valid_storage_key(key) {
+        return err_bad_request("Invalid bucket name or object key");
+    }
+    let write = msg.action() == "update";
+    let access = if write { Access::Write } else { Access::Read };
+    if acl::is_access_denied(ctx, msg, bucket, key, access).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let row = match repo::objects::find_by_bucket_key(ctx, bucket, key).await {
+        Ok(Some(row)) if row.str_field("status") != "pending" => row,
+        Ok(_) => {
+            return errors::error_response(ErrorCode::ObjectNotFound, "Object not found");
+        }
+        Err(e) => return err_internal("Database error", e),
+    };
+    let current = parse_stored(row.str_field("metadata"));
+    if !write {
+        return ok_json(&serde_json::json!({ "metadata": current }));
+    }
+
+    if let Err(r) = locks::guard(ctx, bucket, &[key]).await {
+        return r;
+    }
+
+    let update = if msg.get_meta("http.method").eq_ignore_ascii_case("PUT") {
+        Update::Replace
+    } else {
+        Update::Merge
+    };
+    if let Err(r) = crate::body::require_json(msg) {
+        return r;
+    }
+    let raw = input.collect_to_bytes().await;
+    if raw.len() > MAX_BYTES {
+        let reason = format!("at most {MAX_BYTES} bytes when serialized");
+        return errors::validation_error("Invalid metadata", &[("metadata", reason.as_str())]);
+    }
+    let body: serde_json::Value = match crate::body::decode_bytes(&raw) {
+        Ok(body) => body,
+        Err(e) => return e.response(),
+    };
+    let next = match apply_client_update(&current, &body, update) {
+        Ok(next) => next,
+        Err(reason) => {
+            return errors::validation_error("Invalid metadata", &[("metadata", reason.as_str())])
+        }
+    };
+    let serialized = serde_json::Value::Object(next.clone()).to_string();
+    match repo::objects::set_metadata(ctx, &row.id, &serialized).await {
+        Ok(()) => {
+            let event = history::Event::metadata_changed(&row, msg.user_id(), &current, &next);
+            history::record(ctx, event).await;
+            ok_json(&serde_json::json!({ "metadata": next }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+
+    use super::*;
+
+    fn map(v: serde_json::Value) -> Map {
+        v.as_object().unwrap().clone()
+    }
+
+    #[test]
+    fn parse_stored_wraps_non_objects() {
+        assert_eq!(parse_stored(""), Map::new());
+        assert_eq!(parse_stored("{\"a\":1}"), map(json!({"a": 1})));
+        assert_eq!(
+            parse_stored("camera=nikon"),
+            map(json!({"legacy": "camera=nikon"}))
+        );
+        assert_eq!(parse_stored("[1,2]"), map(json!({"legacy": "[1,2]"})));
+    }
+
+    #[test]
+    fn merge_patch_follows_rfc_7386() {
+        let mut target = map(json!({"a": "b", "c": {"d": "e", "f": "g"}, "x": 1}));
+        merge_patch(
+            &mut target,
+            &map(json!({"a": "z", "c": {"f": null}, "x": null, "n": {"m": 2}})),
+        );
+        assert_eq!(
+            target,
+            map(json!({"a": "z", "c": {"d": "e"}, "n": {"m": 2}}))
+        );
+    }
+
+    #[test]
+    fn client_updates_cannot_touch_the_system_namespace() {
+        let current = map(json!({"system": {"detected_content_type": "image/png"}, "tag": "old"}));
+
+        let replaced =
+            apply_client_update(&current, &json!({"title": "x"}), Update::Replace).unwrap();
+        assert_eq!(
+            replaced,
+            map(json!({"system": {"detected_content_type": "image/png"}, "title": "x"}))
+        );
+
+        let merged =
+            apply_client_update(&current, &json!({"tag": null, "n": 1}), Update::Merge).unwrap();
+        assert_eq!(
+            merged,
+            map(json!({"system": {"detected_content_type": "image/png"}, "n": 1}))
+        );
+
+        for update in [Update::Replace, Update::Merge] {
+            assert!(apply_client_update(&current, &json!({"system": {}}), update).is_err());
+            assert!(apply_client_update(&current, &json!({"system": null}), update).is_err());
+        }
+        assert!(apply_client_update(&current, &json!("text"), Update::Replace).is_err());
+    }
+
+    #[test]
+    fn caps_are_enforced() {
+        let many: Map = (0..=MAX_KEYS)
+            .map(|i| (format!("k{i}"), json!(i)))
+            .collect();
+        assert!(validate(&many).is_err());
+        let big = map(json!({"blob": "x".repeat(MAX_BYTES)}));
+        assert!(validate(&big).is_err());
+        assert!(validate(&map(json!({"": 1}))).is_err());
+        assert!(validate(&map(json!({"ok": true}))).is_ok());
+    }
+}
@@ -0,0 +1,22 @@
+-- Quota threshold notifications. New table, so `CREATE TABLE IF NOT EXISTS`
+-- materializes it on both fresh installs and existing databases.
+--
+-- `kind` is `quota_threshold` (one row per user per crossed threshold per
+-- quota reset period; `period_start` is '' when the quota never resets) or
+-- `admin_digest` (one row per admin digest sent, `user_id` = '').
+CREATE TABLE IF NOT EXISTS suppers_ai__files__quota_notifications (
+    id            TEXT PRIMARY KEY,
+    user_id       TEXT NOT NULL DEFAULT '',
+    kind          TEXT NOT NULL DEFAULT 'quota_threshold',
+    threshold     INTEGER NOT NULL DEFAULT 0,
+    period_start  TEXT NOT NULL DEFAULT '',
+    used_bytes    INTEGER NOT NULL DEFAULT 0,
+    limit_bytes   INTEGER NOT NULL DEFAULT 0,
+    dismissed_at  TEXT,
+    created_at    TEXT NOT NULL,
+    updated_at    TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quota_notifications_once
+    ON suppers_ai__files__quota_notifications (user_id, kind, threshold, period_start);
+CREATE INDEX IF NOT EXISTS idx_quota_notifications_kind_created
+    ON suppers_ai__files__quota_notifications (kind, created_at);
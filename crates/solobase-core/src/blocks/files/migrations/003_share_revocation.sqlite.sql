@@ -0,0 +1,10 @@
+-- Share-link revocation. Adds `revoked_at` to cloud_shares so an admin can
+-- kill a leaked link without deleting its row (the direct-access path
+-- answers 410 Gone for a revoked token instead of 404) and an index on
+-- `expires_at` for the expired-share sweep in `share::sweep_stale_shares`.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__cloud_shares ADD COLUMN revoked_at TEXT;
+CREATE INDEX IF NOT EXISTS idx_cloud_shares_expires_at
+    ON suppers_ai__files__cloud_shares (expires_at);
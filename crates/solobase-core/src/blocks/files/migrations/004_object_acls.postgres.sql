@@ -0,0 +1,19 @@
+-- Per-user grants on a bucket path, so an owner can share a folder (or a
+-- single object, or the whole bucket) with another account without a
+-- public link. `path` is `''` for the whole bucket, a folder prefix ending
+-- in `/`, or an exact object key; a grant covers everything beneath it
+-- (`acl::covering_paths`). `permission` is `read` or `write`.
+CREATE TABLE IF NOT EXISTS suppers_ai__files__object_acls (
+    id               TEXT PRIMARY KEY,
+    bucket           TEXT NOT NULL,
+    path             TEXT NOT NULL DEFAULT '',
+    grantee_user_id  TEXT NOT NULL,
+    permission       TEXT NOT NULL DEFAULT 'read',
+    granted_by       TEXT NOT NULL DEFAULT '',
+    created_at       TEXT NOT NULL,
+    updated_at       TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_object_acls_grant
+    ON suppers_ai__files__object_acls (bucket, path, grantee_user_id);
+CREATE INDEX IF NOT EXISTS idx_object_acls_grantee
+    ON suppers_ai__files__object_acls (grantee_user_id);
@@ -0,0 +1,22 @@
+-- Mirror of 005_lifecycle.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__files__buckets
+    ADD COLUMN IF NOT EXISTS lifecycle_rules TEXT NOT NULL DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS suppers_ai__files__lifecycle_runs (
+    id          TEXT PRIMARY KEY,
+    run_id      TEXT NOT NULL,
+    bucket      TEXT NOT NULL,
+    rule_id     TEXT NOT NULL,
+    action      TEXT NOT NULL,
+    matched     INTEGER NOT NULL DEFAULT 0,
+    affected    INTEGER NOT NULL DEFAULT 0,
+    errors      INTEGER NOT NULL DEFAULT 0,
+    capped      INTEGER NOT NULL DEFAULT 0,
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_lifecycle_runs_created_at
+    ON suppers_ai__files__lifecycle_runs (created_at);
+
+CREATE INDEX IF NOT EXISTS idx_objects_bucket_uploaded_at
+    ON suppers_ai__files__objects (bucket, uploaded_at);
@@ -0,0 +1,29 @@
+-- Object lifecycle rules. `lifecycle_rules` on a bucket is a JSON array of
+-- rules (`lifecycle::LifecycleRule`); `'[]'` means none. Objects a rule
+-- archives keep their row and blob but move to `status = 'archived'`,
+-- which listings and search skip. `lifecycle_runs` holds one summary row
+-- per rule per evaluation, for the admin run history.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__buckets ADD COLUMN lifecycle_rules TEXT NOT NULL DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS suppers_ai__files__lifecycle_runs (
+    id          TEXT PRIMARY KEY,
+    run_id      TEXT NOT NULL,
+    bucket      TEXT NOT NULL,
+    rule_id     TEXT NOT NULL,
+    action      TEXT NOT NULL,
+    matched     INTEGER NOT NULL DEFAULT 0,
+    affected    INTEGER NOT NULL DEFAULT 0,
+    errors      INTEGER NOT NULL DEFAULT 0,
+    capped      INTEGER NOT NULL DEFAULT 0,
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_lifecycle_runs_created_at
+    ON suppers_ai__files__lifecycle_runs (created_at);
+
+-- Candidate scan: objects in a bucket ordered by upload time.
+CREATE INDEX IF NOT EXISTS idx_objects_bucket_uploaded_at
+    ON suppers_ai__files__objects (bucket, uploaded_at);
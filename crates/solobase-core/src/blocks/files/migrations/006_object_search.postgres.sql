@@ -0,0 +1,5 @@
+-- Mirror of 006_object_search.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__files__objects
+    ADD COLUMN IF NOT EXISTS key_lower TEXT NOT NULL DEFAULT '';
+
+UPDATE suppers_ai__files__objects SET key_lower = LOWER(key) WHERE key_lower = '';
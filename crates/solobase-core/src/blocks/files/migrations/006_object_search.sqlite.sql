@@ -0,0 +1,10 @@
+-- Case-insensitive object search. `key_lower` holds the lowercased object
+-- key so search can match with a plain `LIKE` on every backend (SQLite's
+-- `LIKE` folds ASCII case, Postgres' does not). New rows are written by
+-- `repo::objects::insert_pending`; existing rows are backfilled here.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__objects ADD COLUMN key_lower TEXT NOT NULL DEFAULT '';
+
+UPDATE suppers_ai__files__objects SET key_lower = LOWER(key) WHERE key_lower = '';
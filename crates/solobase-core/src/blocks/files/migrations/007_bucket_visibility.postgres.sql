@@ -0,0 +1,3 @@
+-- Mirror of 007_bucket_visibility.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__files__buckets
+    ADD COLUMN IF NOT EXISTS visible_to_users INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- Per-bucket visibility. `visible_to_users = 1` lists a bucket for every
+-- signed-in user and lets them read its objects; otherwise only the owner
+-- (and admins) see it. Set by admins via
+-- `PUT /admin/storage/buckets/{name}/visibility`.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__buckets ADD COLUMN visible_to_users INTEGER NOT NULL DEFAULT 0;
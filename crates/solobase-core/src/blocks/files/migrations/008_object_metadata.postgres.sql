@@ -0,0 +1,3 @@
+-- Mirror of 008_object_metadata.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__files__objects
+    ADD COLUMN IF NOT EXISTS metadata TEXT NOT NULL DEFAULT '{}';
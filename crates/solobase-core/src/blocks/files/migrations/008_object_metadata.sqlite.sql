@@ -0,0 +1,10 @@
+-- Structured per-object metadata: a serialized JSON object, validated and
+-- merged by `files::metadata`. Server-written keys live under `system`.
+--
+-- The column is new, so there are no legacy free-form values to convert;
+-- reads still go through `metadata::parse_stored`, which wraps any value
+-- that is not a JSON object as {"legacy": "<raw>"} instead of dropping it.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__objects ADD COLUMN metadata TEXT NOT NULL DEFAULT '{}';
@@ -0,0 +1,32 @@
+-- Mirror of 009_teams.sqlite.sql for PostgreSQL.
+CREATE TABLE IF NOT EXISTS suppers_ai__files__teams (
+    id                 TEXT PRIMARY KEY,
+    name               TEXT NOT NULL,
+    created_by         TEXT NOT NULL DEFAULT '',
+    max_storage_bytes  BIGINT,
+    created_at         TEXT NOT NULL,
+    updated_at         TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__files__team_members (
+    id          TEXT PRIMARY KEY,
+    team_id     TEXT NOT NULL,
+    user_id     TEXT NOT NULL,
+    role        TEXT NOT NULL DEFAULT 'member',
+    added_by    TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_team_members_member
+    ON suppers_ai__files__team_members (team_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_team_members_user_id
+    ON suppers_ai__files__team_members (user_id);
+
+ALTER TABLE suppers_ai__files__buckets
+    ADD COLUMN IF NOT EXISTS team_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__files__objects
+    ADD COLUMN IF NOT EXISTS team_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_buckets_team_id
+    ON suppers_ai__files__buckets (team_id);
+CREATE INDEX IF NOT EXISTS idx_objects_team_id
+    ON suppers_ai__files__objects (team_id);
@@ -0,0 +1,41 @@
+-- Teams: a shared storage space owned by a group instead of one user.
+-- `team_members.role` is `owner` (exactly one per team) or `member`; every
+-- member has the owner's rights on the team's buckets, while membership
+-- and deleting the team stay with the owner.
+--
+-- A bucket with a non-empty `team_id` belongs to that team: membership
+-- replaces the `created_by` check. Objects uploaded into it carry the same
+-- `team_id` so they count against the team's quota
+-- (`max_storage_bytes`, NULL = `SUPPERS_AI__FILES__TEAM_STORAGE_BYTES`)
+-- rather than the uploader's. Existing rows default to `''` (personal).
+CREATE TABLE IF NOT EXISTS suppers_ai__files__teams (
+    id                 TEXT PRIMARY KEY,
+    name               TEXT NOT NULL,
+    created_by         TEXT NOT NULL DEFAULT '',
+    max_storage_bytes  INTEGER,
+    created_at         TEXT NOT NULL,
+    updated_at         TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__files__team_members (
+    id          TEXT PRIMARY KEY,
+    team_id     TEXT NOT NULL,
+    user_id     TEXT NOT NULL,
+    role        TEXT NOT NULL DEFAULT 'member',
+    added_by    TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_team_members_member
+    ON suppers_ai__files__team_members (team_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_team_members_user_id
+    ON suppers_ai__files__team_members (user_id);
+
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__buckets ADD COLUMN team_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__files__objects ADD COLUMN team_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_buckets_team_id
+    ON suppers_ai__files__buckets (team_id);
+CREATE INDEX IF NOT EXISTS idx_objects_team_id
+    ON suppers_ai__files__objects (team_id);
@@ -0,0 +1,9 @@
+-- Resumable share downloads. `bytes_served` accumulates across attempts
+-- (ranged retries included) and `completed_at` is stamped once it reaches
+-- the object's size; until then a ranged resume doesn't spend an access.
+-- `serving_until` is the lease that keeps one attempt per link in flight
+-- (empty when idle). Access-log rows record each attempt's byte count.
+ALTER TABLE suppers_ai__files__cloud_shares ADD COLUMN IF NOT EXISTS bytes_served BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE suppers_ai__files__cloud_shares ADD COLUMN IF NOT EXISTS completed_at TEXT;
+ALTER TABLE suppers_ai__files__cloud_shares ADD COLUMN IF NOT EXISTS serving_until TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__files__cloud_access_logs ADD COLUMN IF NOT EXISTS bytes_served BIGINT NOT NULL DEFAULT 0;
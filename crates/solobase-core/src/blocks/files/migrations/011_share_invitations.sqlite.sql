@@ -0,0 +1,20 @@
+-- Sharing with an address that has no account yet, and answering a share.
+-- A pending grant has `grantee_user_id = ''` and the invitee's lowercased
+-- `grantee_email`; it gives no access until an account confirms that exact
+-- address (`acl::attach_pending_grants`). `status` is `pending`,
+-- `received` (attached or granted, not yet answered) or `accepted`; rows
+-- from before this migration were already in use and count as accepted.
+-- Declining deletes the row.
+--
+-- Pending rows share the empty grantee id, so the unique grant index gains
+-- `grantee_email` to let one path be offered to several addresses.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__object_acls ADD COLUMN grantee_email TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__files__object_acls ADD COLUMN status TEXT NOT NULL DEFAULT 'accepted';
+DROP INDEX IF EXISTS idx_object_acls_grant;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_object_acls_grant_email
+    ON suppers_ai__files__object_acls (bucket, path, grantee_user_id, grantee_email);
+CREATE INDEX IF NOT EXISTS idx_object_acls_pending_email
+    ON suppers_ai__files__object_acls (grantee_email);
@@ -0,0 +1,11 @@
+-- Id-addressed blobs. `storage_key` is where an object's bytes live in its
+-- bucket: `{first two id chars}/{id}` (see `files::blobs`), so the object
+-- key is metadata only and a rename or move rewrites the row alone. Rows
+-- from before this migration keep `storage_key = ''`: their blob is still
+-- at the object key until the relayout job moves it.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__objects ADD COLUMN storage_key TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_objects_storage_key
+    ON suppers_ai__files__objects (storage_key);
@@ -0,0 +1,28 @@
+-- Object history. One row per change to an object: `action` is `create`,
+-- `move` or `metadata` while it exists, then `delete`. `before_state` and
+-- `after_state` are JSON summaries of what changed (the old and new key, the
+-- changed metadata keys); `batch_id` is shared by every row one bulk
+-- request wrote. Rows outlive their object, so a deleted object's history
+-- can still be read by its id; the `object_history` retention policy ages
+-- them out.
+--
+-- `modified_by` on the object row is the actor of its newest change.
+CREATE TABLE IF NOT EXISTS suppers_ai__files__object_events (
+    id            TEXT PRIMARY KEY,
+    object_id     TEXT NOT NULL,
+    bucket        TEXT NOT NULL,
+    key           TEXT NOT NULL DEFAULT '',
+    action        TEXT NOT NULL,
+    actor_id      TEXT NOT NULL DEFAULT '',
+    batch_id      TEXT NOT NULL DEFAULT '',
+    before_state  TEXT NOT NULL DEFAULT '{}',
+    after_state   TEXT NOT NULL DEFAULT '{}',
+    created_at    TEXT NOT NULL,
+    updated_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_object_events_object
+    ON suppers_ai__files__object_events (bucket, object_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_object_events_created_at
+    ON suppers_ai__files__object_events (created_at);
+
+ALTER TABLE suppers_ai__files__objects ADD COLUMN IF NOT EXISTS modified_by TEXT NOT NULL DEFAULT '';
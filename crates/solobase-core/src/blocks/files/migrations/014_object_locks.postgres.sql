@@ -0,0 +1,24 @@
+-- Object locks (legal holds). While `locked_until` is in the future the
+-- path cannot be deleted, overwritten, moved or have its metadata changed,
+-- by anyone — the bucket owner and admins included. `path` is a folder
+-- prefix ending in `/` or an exact object key; a lock covers everything
+-- beneath it (`acl::covering_paths`). Folders are key prefixes, not rows,
+-- so locks live in their own table like grants do rather than as a column
+-- on the object row. `locked_by` is the account that set (or last
+-- extended) the lock.
+--
+-- `lifecycle_runs.locked` counts the candidates a rule skipped because a
+-- lock covered them.
+CREATE TABLE IF NOT EXISTS suppers_ai__files__object_locks (
+    id            TEXT PRIMARY KEY,
+    bucket        TEXT NOT NULL,
+    path          TEXT NOT NULL DEFAULT '',
+    locked_until  TEXT NOT NULL,
+    locked_by     TEXT NOT NULL DEFAULT '',
+    created_at    TEXT NOT NULL,
+    updated_at    TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_object_locks_path
+    ON suppers_ai__files__object_locks (bucket, path);
+
+ALTER TABLE suppers_ai__files__lifecycle_runs ADD COLUMN IF NOT EXISTS locked INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,40 @@
+-- Upload widget sessions. A backend holding an API key mints one for a
+-- page it is about to render; the embedded widget then uploads with the
+-- session's signed token and nothing else. `created_by` is the account the
+-- files are stored as (its quota, its bucket). `allowed_origins` and
+-- `allowed_types` are JSON arrays; an empty types list allows any type.
+-- `max_bytes` = 0 falls back to the owner's per-file cap. `upload_count`
+-- only grows, and stops at `max_uploads`.
+--
+-- `widget_uploads` records the objects each session stored, for the
+-- backend to collect by polling the session.
+CREATE TABLE IF NOT EXISTS suppers_ai__files__widget_sessions (
+    id               TEXT PRIMARY KEY,
+    created_by       TEXT NOT NULL,
+    bucket           TEXT NOT NULL,
+    folder           TEXT NOT NULL DEFAULT '',
+    allowed_origins  TEXT NOT NULL DEFAULT '[]',
+    allowed_types    TEXT NOT NULL DEFAULT '[]',
+    max_bytes        INTEGER NOT NULL DEFAULT 0,
+    max_uploads      INTEGER NOT NULL DEFAULT 1,
+    upload_count     INTEGER NOT NULL DEFAULT 0,
+    expires_at       TEXT NOT NULL,
+    created_at       TEXT NOT NULL,
+    updated_at       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_widget_sessions_created_by
+    ON suppers_ai__files__widget_sessions (created_by);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__files__widget_uploads (
+    id            TEXT PRIMARY KEY,
+    session_id    TEXT NOT NULL,
+    object_id     TEXT NOT NULL,
+    key           TEXT NOT NULL,
+    size          INTEGER NOT NULL DEFAULT 0,
+    content_type  TEXT NOT NULL DEFAULT '',
+    origin        TEXT NOT NULL DEFAULT '',
+    created_at    TEXT NOT NULL,
+    updated_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_widget_uploads_session_id
+    ON suppers_ai__files__widget_uploads (session_id);
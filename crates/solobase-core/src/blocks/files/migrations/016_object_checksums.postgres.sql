@@ -0,0 +1,5 @@
+-- Mirror of 016_object_checksums.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__files__objects
+    ADD COLUMN IF NOT EXISTS sha256 TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__files__widget_sessions
+    ADD COLUMN IF NOT EXISTS sha256 TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- Content checksums. `sha256` is the lowercase hex SHA-256 of an object's
+-- bytes, recorded when they are stored (see `files::checksum`); rows from
+-- before this migration keep '' and never match an expected checksum.
+-- A widget session may pin the checksum its uploads must have.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__objects ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__files__widget_sessions ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
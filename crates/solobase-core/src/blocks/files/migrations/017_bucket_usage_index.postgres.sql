@@ -0,0 +1,5 @@
+-- Mirror of 017_bucket_usage_index.sqlite.sql for PostgreSQL, where it
+-- allows an index-only scan of the grouped query once the table is
+-- vacuumed.
+CREATE INDEX IF NOT EXISTS idx_objects_bucket_usage
+    ON suppers_ai__files__objects (bucket, team_id, status, size, updated_at);
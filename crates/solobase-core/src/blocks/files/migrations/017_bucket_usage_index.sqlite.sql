@@ -0,0 +1,7 @@
+-- Covering index for the per-bucket usage aggregate (`repo::objects::
+-- bucket_usage`), which the bucket listing and the admin storage report
+-- share: it groups stored rows by (bucket, team_id) and reads only
+-- `status`, `size` and `updated_at`, so the whole query is answered from
+-- the index instead of scanning the table.
+CREATE INDEX IF NOT EXISTS idx_objects_bucket_usage
+    ON suppers_ai__files__objects (bucket, team_id, status, size, updated_at);
@@ -0,0 +1,3 @@
+-- Mirror of 018_object_checksum_index.sqlite.sql for PostgreSQL.
+CREATE INDEX IF NOT EXISTS idx_objects_sha256
+    ON suppers_ai__files__objects (sha256);
@@ -0,0 +1,6 @@
+-- Exact-checksum lookups for the admin object search
+-- (`files::admin_search`): abuse reports name content by its SHA-256, and
+-- each lookup must be a single index probe rather than a table scan.
+-- Rows without a checksum share the '' key and are never searched for.
+CREATE INDEX IF NOT EXISTS idx_objects_sha256
+    ON suppers_ai__files__objects (sha256);
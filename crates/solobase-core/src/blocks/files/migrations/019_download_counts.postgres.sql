@@ -0,0 +1,7 @@
+-- Mirror of 019_download_counts.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__files__objects
+    ADD COLUMN IF NOT EXISTS download_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE suppers_ai__files__objects
+    ADD COLUMN IF NOT EXISTS last_downloaded_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__files__cloud_access_logs
+    ADD COLUMN IF NOT EXISTS counted INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,13 @@
+-- Download counters. `download_count` counts the times an object's bytes
+-- were served in full or from their first byte (storage API, S3, share
+-- links); `last_downloaded_at` is the newest of those ('' if never). Both
+-- are written through the files block's coalescing counter buffer, so they
+-- may trail live traffic by a flush interval.
+-- `counted` marks share access-log rows that were counted as a download;
+-- a ranged resume of an unfinished attempt logs its bytes with 0.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__objects ADD COLUMN download_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE suppers_ai__files__objects ADD COLUMN last_downloaded_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__files__cloud_access_logs ADD COLUMN counted INTEGER NOT NULL DEFAULT 1;
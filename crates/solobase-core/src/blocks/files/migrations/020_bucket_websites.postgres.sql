@@ -0,0 +1,3 @@
+-- Mirror of 020_bucket_websites.sqlite.sql for PostgreSQL.
+ALTER TABLE suppers_ai__files__buckets
+    ADD COLUMN IF NOT EXISTS website TEXT NOT NULL DEFAULT '{}';
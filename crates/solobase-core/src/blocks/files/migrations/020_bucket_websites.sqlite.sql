@@ -0,0 +1,8 @@
+-- Static website hosting. `website` on a bucket is a JSON object
+-- (`website::WebsiteConfig`); `'{}'` means website mode is off. Set by the
+-- bucket's owner via `PUT /b/storage/api/buckets/{name}/website`, and only
+-- on public buckets.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__files__buckets ADD COLUMN website TEXT NOT NULL DEFAULT '{}';
@@ -0,0 +1,41 @@
+-- Mirror of 021_consistency_checks.sqlite.sql for PostgreSQL.
+CREATE TABLE IF NOT EXISTS suppers_ai__files__consistency_runs (
+    id                 TEXT PRIMARY KEY,
+    status             TEXT NOT NULL DEFAULT 'running',
+    repairs            TEXT NOT NULL DEFAULT '{}',
+    started_by         TEXT NOT NULL DEFAULT '',
+    position           TEXT NOT NULL DEFAULT '',
+    blobs_scanned      INTEGER NOT NULL DEFAULT 0,
+    rows_scanned       INTEGER NOT NULL DEFAULT 0,
+    orphans            INTEGER NOT NULL DEFAULT 0,
+    orphan_bytes       BIGINT NOT NULL DEFAULT 0,
+    reclaimable        INTEGER NOT NULL DEFAULT 0,
+    reclaimable_bytes  BIGINT NOT NULL DEFAULT 0,
+    dangling           INTEGER NOT NULL DEFAULT 0,
+    deleted            INTEGER NOT NULL DEFAULT 0,
+    adopted            INTEGER NOT NULL DEFAULT 0,
+    marked             INTEGER NOT NULL DEFAULT 0,
+    restored           INTEGER NOT NULL DEFAULT 0,
+    finished_at        TEXT NOT NULL DEFAULT '',
+    created_at         TEXT NOT NULL,
+    updated_at         TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_consistency_runs_created_at
+    ON suppers_ai__files__consistency_runs (created_at);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__files__consistency_findings (
+    id               TEXT PRIMARY KEY,
+    run_id           TEXT NOT NULL,
+    bucket           TEXT NOT NULL,
+    kind             TEXT NOT NULL,
+    storage_key      TEXT NOT NULL DEFAULT '',
+    object_id        TEXT NOT NULL DEFAULT '',
+    object_key       TEXT NOT NULL DEFAULT '',
+    size             BIGINT NOT NULL DEFAULT 0,
+    blob_modified_at TEXT NOT NULL DEFAULT '',
+    repair           TEXT NOT NULL DEFAULT '',
+    created_at       TEXT NOT NULL,
+    updated_at       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_consistency_findings_run
+    ON suppers_ai__files__consistency_findings (run_id, kind, created_at);
@@ -0,0 +1,53 @@
+-- Storage consistency checks (`files::consistency`). A run walks every
+-- bucket's blobs against its object rows and back: `consistency_runs` holds
+-- one row per run with its running totals (`status` is `running`, then
+-- `done`), `consistency_findings` one row per problem found. `kind` is
+-- `orphan_blob` (a blob no row points at; `size` and `blob_modified_at`
+-- come from the storage listing) or `dangling_row` (a row whose blob is
+-- gone), and `repair` what the run did about it: `deleted`, `adopted`,
+-- `marked`, or empty when it only reported it. `reclaimable` and
+-- `reclaimable_bytes` count the orphans a run left in place. `position` is
+-- where the run's next batch starts, so a batch delivered twice is noticed.
+--
+-- An object row whose blob is gone may be moved to `status = 'missing_blob'`,
+-- which listings and the storage report skip.
+CREATE TABLE IF NOT EXISTS suppers_ai__files__consistency_runs (
+    id                 TEXT PRIMARY KEY,
+    status             TEXT NOT NULL DEFAULT 'running',
+    repairs            TEXT NOT NULL DEFAULT '{}',
+    started_by         TEXT NOT NULL DEFAULT '',
+    position           TEXT NOT NULL DEFAULT '',
+    blobs_scanned      INTEGER NOT NULL DEFAULT 0,
+    rows_scanned       INTEGER NOT NULL DEFAULT 0,
+    orphans            INTEGER NOT NULL DEFAULT 0,
+    orphan_bytes       INTEGER NOT NULL DEFAULT 0,
+    reclaimable        INTEGER NOT NULL DEFAULT 0,
+    reclaimable_bytes  INTEGER NOT NULL DEFAULT 0,
+    dangling           INTEGER NOT NULL DEFAULT 0,
+    deleted            INTEGER NOT NULL DEFAULT 0,
+    adopted            INTEGER NOT NULL DEFAULT 0,
+    marked             INTEGER NOT NULL DEFAULT 0,
+    restored           INTEGER NOT NULL DEFAULT 0,
+    finished_at        TEXT NOT NULL DEFAULT '',
+    created_at         TEXT NOT NULL,
+    updated_at         TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_consistency_runs_created_at
+    ON suppers_ai__files__consistency_runs (created_at);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__files__consistency_findings (
+    id               TEXT PRIMARY KEY,
+    run_id           TEXT NOT NULL,
+    bucket           TEXT NOT NULL,
+    kind             TEXT NOT NULL,
+    storage_key      TEXT NOT NULL DEFAULT '',
+    object_id        TEXT NOT NULL DEFAULT '',
+    object_key       TEXT NOT NULL DEFAULT '',
+    size             INTEGER NOT NULL DEFAULT 0,
+    blob_modified_at TEXT NOT NULL DEFAULT '',
+    repair           TEXT NOT NULL DEFAULT '',
+    created_at       TEXT NOT NULL,
+    updated_at       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_consistency_findings_run
+    ON suppers_ai__files__consistency_findings (run_id, kind, created_at);
@@ -0,0 +1,8 @@
+-- Mirror of 022_listing_indexes.sqlite.sql for PostgreSQL, where the same
+-- column order lets both listings read the index backwards for their
+-- newest-first pages.
+CREATE INDEX IF NOT EXISTS idx_objects_uploader_status_uploaded_at
+    ON suppers_ai__files__objects (uploaded_by, status, uploaded_at);
+DROP INDEX IF EXISTS idx_objects_uploaded_by;
+CREATE INDEX IF NOT EXISTS idx_objects_status_updated_at
+    ON suppers_ai__files__objects (status, updated_at);
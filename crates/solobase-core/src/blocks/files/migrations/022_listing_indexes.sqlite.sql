@@ -0,0 +1,19 @@
+-- Composite indexes for the per-user and per-status object listings, which
+-- otherwise pick a single-column index (or none) and sort the matches:
+--
+-- * `repo::objects::search_completed` filters `uploaded_by` + `status` and
+--   pages newest upload first. (uploaded_by, status, uploaded_at) walks the
+--   user's stored objects already in order and stops at the page size; it
+--   also serves every `uploaded_by`-only lookup, so the old single-column
+--   index goes.
+-- * `repo::objects::list_by_status` (the admin quarantine listing) filters
+--   on `status` and orders by `updated_at`, and had no index at all.
+--
+-- Bucket listings (`list_under`, `list_for_bucket`) already walk
+-- idx_objects_bucket_key in key order. The query-plan tests in
+-- `repo::objects` pin all of these.
+CREATE INDEX IF NOT EXISTS idx_objects_uploader_status_uploaded_at
+    ON suppers_ai__files__objects (uploaded_by, status, uploaded_at);
+DROP INDEX IF EXISTS idx_objects_uploaded_by;
+CREATE INDEX IF NOT EXISTS idx_objects_status_updated_at
+    ON suppers_ai__files__objects (status, updated_at);
@@ -3,10 +3,98 @@
 
 const SQL_001_SQLITE: &str = include_str!("001_initial_schema.sqlite.sql");
 const SQL_001_POSTGRES: &str = include_str!("001_initial_schema.postgres.sql");
+const SQL_002_SQLITE: &str = include_str!("002_quota_notifications.sqlite.sql");
+const SQL_002_POSTGRES: &str = include_str!("002_quota_notifications.postgres.sql");
+const SQL_003_SQLITE: &str = include_str!("003_share_revocation.sqlite.sql");
+const SQL_003_POSTGRES: &str = include_str!("003_share_revocation.postgres.sql");
+const SQL_004_SQLITE: &str = include_str!("004_object_acls.sqlite.sql");
+const SQL_004_POSTGRES: &str = include_str!("004_object_acls.postgres.sql");
+const SQL_005_SQLITE: &str = include_str!("005_lifecycle.sqlite.sql");
+const SQL_005_POSTGRES: &str = include_str!("005_lifecycle.postgres.sql");
+const SQL_006_SQLITE: &str = include_str!("006_object_search.sqlite.sql");
+const SQL_006_POSTGRES: &str = include_str!("006_object_search.postgres.sql");
+const SQL_007_SQLITE: &str = include_str!("007_bucket_visibility.sqlite.sql");
+const SQL_007_POSTGRES: &str = include_str!("007_bucket_visibility.postgres.sql");
+const SQL_008_SQLITE: &str = include_str!("008_object_metadata.sqlite.sql");
+const SQL_008_POSTGRES: &str = include_str!("008_object_metadata.postgres.sql");
+const SQL_009_SQLITE: &str = include_str!("009_teams.sqlite.sql");
+const SQL_009_POSTGRES: &str = include_str!("009_teams.postgres.sql");
+const SQL_010_SQLITE: &str = include_str!("010_resumable_shares.sqlite.sql");
+const SQL_010_POSTGRES: &str = include_str!("010_resumable_shares.postgres.sql");
+const SQL_011_SQLITE: &str = include_str!("011_share_invitations.sqlite.sql");
+const SQL_011_POSTGRES: &str = include_str!("011_share_invitations.postgres.sql");
+const SQL_012_SQLITE: &str = include_str!("012_blob_layout.sqlite.sql");
+const SQL_012_POSTGRES: &str = include_str!("012_blob_layout.postgres.sql");
+const SQL_013_SQLITE: &str = include_str!("013_object_events.sqlite.sql");
+const SQL_013_POSTGRES: &str = include_str!("013_object_events.postgres.sql");
+const SQL_014_SQLITE: &str = include_str!("014_object_locks.sqlite.sql");
+const SQL_014_POSTGRES: &str = include_str!("014_object_locks.postgres.sql");
+const SQL_015_SQLITE: &str = include_str!("015_widget_sessions.sqlite.sql");
+const SQL_015_POSTGRES: &str = include_str!("015_widget_sessions.postgres.sql");
+const SQL_016_SQLITE: &str = include_str!("016_object_checksums.sqlite.sql");
+const SQL_016_POSTGRES: &str = include_str!("016_object_checksums.postgres.sql");
+const SQL_017_SQLITE: &str = include_str!("017_bucket_usage_index.sqlite.sql");
+const SQL_017_POSTGRES: &str = include_str!("017_bucket_usage_index.postgres.sql");
+const SQL_018_SQLITE: &str = include_str!("018_object_checksum_index.sqlite.sql");
+const SQL_018_POSTGRES: &str = include_str!("018_object_checksum_index.postgres.sql");
+const SQL_019_SQLITE: &str = include_str!("019_download_counts.sqlite.sql");
+const SQL_019_POSTGRES: &str = include_str!("019_download_counts.postgres.sql");
+const SQL_020_SQLITE: &str = include_str!("020_bucket_websites.sqlite.sql");
+const SQL_020_POSTGRES: &str = include_str!("020_bucket_websites.postgres.sql");
+const SQL_021_SQLITE: &str = include_str!("021_consistency_checks.sqlite.sql");
+const SQL_021_POSTGRES: &str = include_str!("021_consistency_checks.postgres.sql");
+const SQL_022_SQLITE: &str = include_str!("022_listing_indexes.sqlite.sql");
+const SQL_022_POSTGRES: &str = include_str!("022_listing_indexes.postgres.sql");
 
 /// Ordered SQLite migration scripts for this block, as `(basename, content)`
 /// pairs. Feeds the runtime `lifecycle_init` apply path.
-pub(crate) const SQLITE_MIGRATIONS: &[(&str, &str)] = &[("001_initial_schema", SQL_001_SQLITE)];
+pub(crate) const SQLITE_MIGRATIONS: &[(&str, &str)] = &[
+    ("001_initial_schema", SQL_001_SQLITE),
+    ("002_quota_notifications", SQL_002_SQLITE),
+    ("003_share_revocation", SQL_003_SQLITE),
+    ("004_object_acls", SQL_004_SQLITE),
+    ("005_lifecycle", SQL_005_SQLITE),
+    ("006_object_search", SQL_006_SQLITE),
+    ("007_bucket_visibility", SQL_007_SQLITE),
+    ("008_object_metadata", SQL_008_SQLITE),
+    ("009_teams", SQL_009_SQLITE),
+    ("010_resumable_shares", SQL_010_SQLITE),
+    ("011_share_invitations", SQL_011_SQLITE),
+    ("012_blob_layout", SQL_012_SQLITE),
+    ("013_object_events", SQL_013_SQLITE),
+    ("014_object_locks", SQL_014_SQLITE),
+    ("015_widget_sessions", SQL_015_SQLITE),
+    ("016_object_checksums", SQL_016_SQLITE),
+    ("017_bucket_usage_index", SQL_017_SQLITE),
+    ("018_object_checksum_index", SQL_018_SQLITE),
+    ("019_download_counts", SQL_019_SQLITE),
+    ("020_bucket_websites", SQL_020_SQLITE),
+    ("021_consistency_checks", SQL_021_SQLITE),
+    ("022_listing_indexes", SQL_022_SQLITE),
+];
 
 /// Ordered PostgreSQL migration scripts, matching [`SQLITE_MIGRATIONS`].
-pub(crate) const POSTGRES_MIGRATIONS: &[&str] = &[SQL_001_POSTGRES];
+pub(crate) const POSTGRES_MIGRATIONS: &[&str] = &[
+    SQL_001_POSTGRES,
+    SQL_002_POSTGRES,
+    SQL_003_POSTGRES,
+    SQL_004_POSTGRES,
+    SQL_005_POSTGRES,
+    SQL_006_POSTGRES,
+    SQL_007_POSTGRES,
+    SQL_008_POSTGRES,
+    SQL_009_POSTGRES,
+    SQL_010_POSTGRES,
+    SQL_011_POSTGRES,
+    SQL_012_POSTGRES,
+    SQL_013_POSTGRES,
+    SQL_014_POSTGRES,
+    SQL_015_POSTGRES,
+    SQL_016_POSTGRES,
+    SQL_017_POSTGRES,
+    SQL_018_POSTGRES,
+    SQL_019_POSTGRES,
+    SQL_020_POSTGRES,
+    SQL_021_POSTGRES,
+    SQL_022_POSTGRES,
+];
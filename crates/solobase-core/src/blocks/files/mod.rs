@@ -1,18 +1,198 @@
+mod acl;
+mod admin_search;
+mod archive;
+mod blobs;
+mod breadcrumbs;
+mod bucket_stats;
+mod checksum;
 mod cloud;
+mod consistency;
+mod downloads;
+mod folder_tree;
+mod history;
+mod info;
+mod lifecycle;
+mod locks;
+mod metadata;
 pub(crate) mod migrations;
 pub(crate) mod models;
+mod moves;
 mod pages_admin;
 pub(crate) mod pages_user;
+mod preview;
 mod quota;
 pub(crate) mod repo;
+mod report;
+mod s3;
+pub mod scan;
 mod share;
 pub(crate) mod storage;
+mod teams;
+mod transfers;
+mod upload_check;
+mod user_purge;
+mod website;
+mod widget;
 
-use wafer_run::{BlockEndpoint, BlockInfo, InstanceMode};
+pub(crate) use acl::attach_pending_grants;
+pub(crate) use consistency::{
+    CHECK_JOB as CONSISTENCY_CHECK_JOB, SCHEDULE as CONSISTENCY_SCHEDULE,
+};
+pub(crate) use lifecycle::{RUN_JOB as LIFECYCLE_RUN_JOB, SCHEDULE as LIFECYCLE_SCHEDULE};
+pub(crate) use quota::{DIGEST_JOB as QUOTA_DIGEST_JOB, DIGEST_SCHEDULE as QUOTA_DIGEST_SCHEDULE};
+pub(crate) use share::SHARE_TOKEN_TTL;
+pub(crate) use transfers::CONCURRENCY_RESOURCES;
+pub(crate) use user_purge::USER_PURGE_JOB;
+use wafer_run::{BlockEndpoint, BlockInfo, ConfigVar, InputType, InstanceMode};
 
 use super::rate_limit::{check_user_rate_limit_with, RateLimit, RateLimitOutcome, UserRateLimiter};
 use crate::http::err_not_found;
 
+/// The files block's declared config vars.
+pub(crate) fn config_vars() -> Vec<ConfigVar> {
+    vec![
+        ConfigVar::new(
+            quota::THRESHOLDS_KEY,
+            "Comma-separated storage usage percentages that notify the user (once per threshold per quota period)",
+            quota::DEFAULT_THRESHOLDS,
+        )
+        .name("Quota Alert Thresholds"),
+        ConfigVar::new(
+            quota::DIGEST_RECIPIENTS_KEY,
+            "Comma-separated admin addresses for a daily digest of users over the highest threshold. Empty disables the digest.",
+            "",
+        )
+        .name("Quota Digest Recipients")
+        .optional(),
+        ConfigVar::new(
+            quota::TEAM_STORAGE_KEY,
+            "Storage cap, in bytes, for a team without its own limit. Team files never count against members' personal quotas",
+            quota::DEFAULT_TEAM_STORAGE,
+        )
+        .name("Team Storage Quota"),
+        ConfigVar::new(
+            archive::BLOCKED_EXTENSIONS_KEY,
+            "Comma-separated file extensions skipped when expanding an uploaded zip archive. Empty allows all",
+            "",
+        )
+        .name("Archive Blocked Extensions")
+        .optional(),
+        ConfigVar::new(
+            lifecycle::BATCH_SIZE_KEY,
+            "Most objects one lifecycle run may delete or archive across all buckets; the rest wait for the next run",
+            lifecycle::DEFAULT_BATCH_SIZE,
+        )
+        .name("Lifecycle Batch Size"),
+        ConfigVar::new(
+            consistency::BATCH_SIZE_KEY,
+            "Blobs or object rows one consistency check batch compares",
+            consistency::DEFAULT_BATCH_SIZE,
+        )
+        .name("Consistency Check Batch Size"),
+        ConfigVar::new(
+            consistency::BATCH_DELAY_KEY,
+            "Seconds a consistency check waits between batches, to keep its storage reads from crowding out uploads and downloads",
+            consistency::DEFAULT_BATCH_DELAY,
+        )
+        .name("Consistency Check Batch Delay"),
+        ConfigVar::new(
+            consistency::MIN_AGE_KEY,
+            "Hours a blob with no object row must have gone unmodified before a consistency repair deletes or re-adopts it",
+            consistency::DEFAULT_MIN_AGE,
+        )
+        .name("Orphan Minimum Age"),
+        ConfigVar::new(
+            consistency::AUTO_REPAIR_KEY,
+            "Let the weekly consistency check delete old orphaned blobs and hide objects whose blob is missing. When off, it only reports",
+            "false",
+        )
+        .name("Consistency Auto-Repair")
+        .input_type(InputType::Toggle),
+        ConfigVar::new(
+            folder_tree::MAX_DEPTH_KEY,
+            "Deepest folder level the sidebar's folder tree includes; deeper folders load as the user browses",
+            folder_tree::DEFAULT_MAX_DEPTH,
+        )
+        .name("Folder Tree Depth"),
+        ConfigVar::new(
+            folder_tree::MAX_NODES_KEY,
+            "Most folders one folder tree response includes, shallowest first",
+            folder_tree::DEFAULT_MAX_NODES,
+        )
+        .name("Folder Tree Size"),
+        ConfigVar::new(
+            storage::USER_BUCKETS_KEY,
+            "Allow users to create and delete their own buckets. When off, only admins manage buckets",
+            "true",
+        )
+        .name("User Buckets")
+        .input_type(InputType::Toggle),
+        ConfigVar::new(
+            scan::SYNC_MAX_BYTES_KEY,
+            "Largest upload, in bytes, scanned for malware before the upload answers. Larger files stay unavailable to others until a background scan clears them. Only used when a scanner is configured",
+            scan::DEFAULT_SYNC_MAX_BYTES,
+        )
+        .name("Inline Scan Limit"),
+        ConfigVar::new(
+            share::ACCESS_LOG_KEY,
+            "Record each share-link download in the access log, which object download stats draw their daily series from. Download counters keep counting when off",
+            "true",
+        )
+        .name("Share Access Log")
+        .input_type(InputType::Toggle),
+        ConfigVar::new(
+            transfers::MAX_DOWNLOADS_KEY,
+            "Downloads one user (or, for share links, one IP) may run at once. An IAM quota on storage.concurrent_downloads overrides it per user or role. 0 disables the cap",
+            transfers::DEFAULT_MAX_DOWNLOADS,
+        )
+        .name("Concurrent Downloads"),
+        ConfigVar::new(
+            transfers::MAX_UPLOADS_KEY,
+            "Uploads one user (or, for the upload widget, one IP) may run at once. An IAM quota on storage.concurrent_uploads overrides it per user or role. 0 disables the cap",
+            transfers::DEFAULT_MAX_UPLOADS,
+        )
+        .name("Concurrent Uploads"),
+    ]
+}
+
+/// How the admin UI's generic settings form groups [`config_vars`] (see
+/// [`crate::config_ui`]).
+fn settings_sections() -> Vec<crate::config_ui::Section> {
+    use crate::config_ui::Section;
+    vec![
+        Section::new(
+            "Quotas",
+            &[
+                quota::THRESHOLDS_KEY,
+                quota::DIGEST_RECIPIENTS_KEY,
+                quota::TEAM_STORAGE_KEY,
+            ],
+        ),
+        Section::new(
+            "Buckets",
+            &[
+                storage::USER_BUCKETS_KEY,
+                lifecycle::BATCH_SIZE_KEY,
+                consistency::BATCH_SIZE_KEY,
+                consistency::BATCH_DELAY_KEY,
+                consistency::MIN_AGE_KEY,
+                consistency::AUTO_REPAIR_KEY,
+                folder_tree::MAX_DEPTH_KEY,
+                folder_tree::MAX_NODES_KEY,
+            ],
+        ),
+        Section::new(
+            "Uploads",
+            &[scan::SYNC_MAX_BYTES_KEY, archive::BLOCKED_EXTENSIONS_KEY],
+        ),
+        Section::new("Sharing", &[share::ACCESS_LOG_KEY]),
+        Section::new(
+            "Transfers",
+            &[transfers::MAX_DOWNLOADS_KEY, transfers::MAX_UPLOADS_KEY],
+        ),
+    ]
+}
+
 crate::solobase_feature_block! {
     /// File storage: buckets, objects, shares, quotas (`suppers-ai/files`).
     pub struct FilesBlock;
@@ -40,13 +220,64 @@ crate::solobase_feature_block! {
                 CollectionSchema::new(repo::shares::TABLE),
                 CollectionSchema::new(repo::shares::ACCESS_LOGS_TABLE),
                 CollectionSchema::new(repo::quota::TABLE),
+                CollectionSchema::new(repo::notifications::TABLE),
+                CollectionSchema::new(repo::acls::TABLE),
+                CollectionSchema::new(repo::events::TABLE),
+                CollectionSchema::new(repo::locks::TABLE),
+                CollectionSchema::new(repo::widgets::TABLE),
+                CollectionSchema::new(repo::widgets::UPLOADS_TABLE),
+                CollectionSchema::new(repo::consistency::RUNS_TABLE),
+                CollectionSchema::new(repo::consistency::FINDINGS_TABLE),
+            ])
+            // Products attaches objects a user already uploaded as product
+            // media: it reads the row to check `uploaded_by`, then fetches
+            // the bytes through this block's own object API.
+            .grants(vec![
+                wafer_run::ResourceGrant::read("suppers-ai/products", repo::objects::TABLE),
+                // Auth-ui hands pending shares to the account that confirms
+                // their address (`acl::attach_pending_grants`).
+                wafer_run::ResourceGrant::read_write(
+                    crate::blocks::auth_ui::AUTH_UI_BLOCK_ID,
+                    repo::acls::TABLE,
+                ),
+                // The admin block's `access_logs` retention policy deletes
+                // aged share-link access rows.
+                wafer_run::ResourceGrant::read_write(
+                    crate::blocks::admin::ADMIN_BLOCK_ID,
+                    repo::shares::ACCESS_LOGS_TABLE,
+                ),
+                // ...and its `object_history` policy, aged object events.
+                wafer_run::ResourceGrant::read_write(
+                    crate::blocks::admin::ADMIN_BLOCK_ID,
+                    repo::events::TABLE,
+                ),
+                // ...and its `dead_shares` policy, expired and revoked shares.
+                wafer_run::ResourceGrant::read_write(
+                    crate::blocks::admin::ADMIN_BLOCK_ID,
+                    repo::shares::TABLE,
+                ),
+                // Buffered views are flushed on the admin block's context
+                // (`crate::write_buffer`).
+                wafer_run::ResourceGrant::read_write(
+                    crate::blocks::admin::ADMIN_BLOCK_ID,
+                    repo::views::TABLE,
+                ),
+                // ...and so are buffered download counts.
+                wafer_run::ResourceGrant::read_write(
+                    crate::blocks::admin::ADMIN_BLOCK_ID,
+                    repo::objects::TABLE,
+                ),
             ])
+            .config_keys(config_vars())
             .category(wafer_run::BlockCategory::Feature)
             .description("File storage and management with bucket-based organization. Supports file upload, download, deletion, search, and sharing via public links with expiration and access counting. Includes per-user storage quotas.")
             .endpoints(vec![
                 BlockEndpoint::get("/b/storage/").summary("Bucket list (user)").auth(AuthLevel::Authenticated),
                 BlockEndpoint::get("/b/storage/{bucket}/").summary("Object list").auth(AuthLevel::Authenticated),
                 BlockEndpoint::get("/b/storage/{bucket}/{prefix...}/").summary("Object list (nested)").auth(AuthLevel::Authenticated),
+                // `stats` maps each bucket to its object_count, total_bytes
+                // and last_activity_at (cached 60s; `?include_stats=false`
+                // leaves it out) — see `bucket_stats.rs`.
                 BlockEndpoint::get("/b/storage/api/buckets").summary("List buckets").auth(AuthLevel::Authenticated),
                 BlockEndpoint::post("/b/storage/api/buckets").summary("Create bucket").auth(AuthLevel::Authenticated),
                 // Schemas below mirror the real shapes read from
@@ -85,7 +316,11 @@ crate::solobase_feature_block! {
                                         "key": {"type": "string"},
                                         "size": {"type": "integer", "description": "Size in bytes"},
                                         "content_type": {"type": "string"},
-                                        "last_modified": {"type": "string", "format": "date-time"}
+                                        "sha256": {"type": ["string", "null"], "description": "Hex SHA-256 of the content; null for objects stored before checksums were recorded"},
+                                        "last_modified": {"type": "string", "format": "date-time"},
+                                        "modified_by": {"type": "string", "description": "User id of the newest change; empty for server changes"},
+                                        "locked_until": {"type": ["string", "null"], "format": "date-time", "description": "End of the lock covering the object; null when unlocked"},
+                                        "locked_by": {"type": ["string", "null"], "description": "User id that set the covering lock"}
                                     }
                                 }
                             },
@@ -93,7 +328,11 @@ crate::solobase_feature_block! {
                         }
                     }))
                     .tags(&["storage"]),
-                BlockEndpoint::post("/b/storage/api/buckets/{name}/objects").summary("Upload file").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/buckets/{name}/objects").summary("Upload file (?sha256= makes it conditional)").auth(AuthLevel::Authenticated),
+                // Sorts `{"entries": [{path, checksum, size}]}` into
+                // `missing`/`changed`/`identical` — see `checksum.rs`.
+                BlockEndpoint::post("/b/storage/api/buckets/{name}/objects/diff").summary("Compare local checksums with the bucket").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/buckets/{name}/upload-archive").summary("Upload a zip and expand it into folders (?prefix=dir/)").auth(AuthLevel::Authenticated),
                 // No output_schema: the success response is the raw object
                 // body (`Content-Type` set from the stored object's MIME
                 // type), not JSON — see `handle_get_object`'s
@@ -115,8 +354,86 @@ crate::solobase_feature_block! {
                     }))
                     .tags(&["storage"]),
                 BlockEndpoint::delete("/b/storage/api/buckets/{name}/objects/{key}").summary("Delete file").auth(AuthLevel::Authenticated),
-                BlockEndpoint::get("/b/storage/direct/{token}").summary("Access shared file"),
+                // Inline preview (`preview.rs`): sandboxed, nosniff; text is
+                // capped at 256 KiB and unsupported types answer 415 with a
+                // JSON descriptor carrying the download URL.
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/preview/{key}")
+                    .summary("Preview file inline")
+                    .auth(AuthLevel::Authenticated)
+                    .tags(&["storage"]),
+                // Structured JSON metadata (`metadata.rs`): PUT replaces the
+                // client keys, PATCH is a JSON merge patch; `system` is
+                // server-written and read-only.
+                // `?fields=` / `?expand=shares,history` (`info.rs`).
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/info/{key}").summary("Object info without its bytes").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/metadata/{key}").summary("Object metadata").auth(AuthLevel::Authenticated),
+                BlockEndpoint::patch("/b/storage/api/buckets/{name}/metadata/{key}").summary("Replace (PUT) or merge-patch (PATCH) object metadata").auth(AuthLevel::Authenticated),
+                // Per-user folder sharing (`acl.rs`): the owner manages grants;
+                // grantees read (or write) through the object routes above.
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/acl").summary("List bucket grants").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/buckets/{name}/acl").summary("Grant access to a path").auth(AuthLevel::Authenticated),
+                BlockEndpoint::delete("/b/storage/api/buckets/{name}/acl/{id}").summary("Revoke a grant").auth(AuthLevel::Authenticated),
+                // Object and folder locks (`locks.rs`): the owner and admins
+                // set them by breadcrumb id; a covered path answers 423.
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/locks").summary("List bucket locks").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/buckets/{name}/locks").summary("Lock an object or folder until a time").auth(AuthLevel::Authenticated),
+                BlockEndpoint::delete("/b/storage/api/buckets/{name}/locks/{id}").summary("Lift a lock (its owner or an admin; admin only once expired)").auth(AuthLevel::Authenticated),
+                // Pre-upload check (`upload_check.rs`): the upload's own lock
+                // and quota evaluation, without the body.
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/upload-check").summary("Check whether an upload of a size would be accepted").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/paths").summary("Resolve breadcrumbs for object ids").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/paths/{id}").summary("Resolve breadcrumbs for one object").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/folders/tree").summary("Nested folder tree with direct file counts (ETag)").auth(AuthLevel::Authenticated),
+                // Owner-only (`moves.rs`): ids are breadcrumb ids — an object
+                // row id or a folder prefix; `ids` moves several at once.
+                BlockEndpoint::post("/b/storage/api/buckets/{name}/move").summary("Move objects or folders to another folder").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/buckets/{name}/objects/{id}/move").summary("Move one object to another folder").auth(AuthLevel::Authenticated),
+                // Owner and admins (`history.rs`): `id` is an object row id,
+                // still valid after the object is deleted.
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/history/{id}").summary("Object change history").auth(AuthLevel::Authenticated),
+                // Owner and admins (`downloads.rs`): download count, and the
+                // share access log's daily series while it is on.
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/stats/{id}").summary("Object download stats").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/buckets/{name}/website").summary("Bucket website configuration").auth(AuthLevel::Authenticated),
+                BlockEndpoint::patch("/b/storage/api/buckets/{name}/website").summary("Set bucket website configuration (PUT; public buckets only)").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/users/search").summary("Find accounts to share with (?q=, a whole address up to its @ at least)").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/shared-with-me").summary("Paths shared with me").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/shares/incoming").summary("Shares awaiting my answer").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/shares/incoming/{id}/accept").summary("Accept a share").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/shares/incoming/{id}/decline").summary("Decline a share (revokes my access)").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/teams").summary("My teams").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/teams").summary("Create team").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/teams/{id}").summary("Team with members").auth(AuthLevel::Authenticated),
+                BlockEndpoint::delete("/b/storage/api/teams/{id}").summary("Delete team (owner; ?cascade=true deletes its buckets)").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/teams/{id}/buckets").summary("Team buckets").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/teams/{id}/members").summary("Add team member (owner)").auth(AuthLevel::Authenticated),
+                BlockEndpoint::delete("/b/storage/api/teams/{id}/members/{user_id}").summary("Remove team member or leave").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/storage/api/teams/{id}/transfer").summary("Transfer team ownership").auth(AuthLevel::Authenticated),
+                // Upload widget (`widget.rs`): a backend mints a session with
+                // its API key; the page's widget uploads with the session's
+                // token from one of its origins.
+                BlockEndpoint::post("/b/storage/api/widgets/upload-sessions").summary("Mint an upload widget session").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/widgets/upload-sessions/{id}").summary("Upload widget session and its uploads").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/storage/api/widgets/upload.js").summary("Upload widget script"),
+                BlockEndpoint::post("/b/storage/api/widgets/upload").summary("Upload one file with a widget token (?token=; Origin must be allowed)"),
+                BlockEndpoint::get("/b/storage/direct/{token}").summary("Access shared file (honors Range; 409 while another download of the link is in flight)"),
+                // S3-compatible gateway (`s3.rs`): signed with an S3
+                // credential and checked in the handler, so unsigned calls
+                // get S3's XML AccessDenied rather than a JSON 401.
+                BlockEndpoint::get("/s3/").summary("S3 ListBuckets"),
+                BlockEndpoint::get("/s3/{bucket}/").summary("S3 ListObjectsV2 (?list-type=2)"),
+                BlockEndpoint::get("/s3/{bucket}/{key}").summary("S3 GetObject / HeadObject (honors Range)"),
+                BlockEndpoint::patch("/s3/{bucket}/{key}").summary("S3 PutObject (PUT)"),
+                BlockEndpoint::delete("/s3/{bucket}/{key}").summary("S3 DeleteObject"),
+                // Static websites (`website.rs`): public buckets with website
+                // mode on, served to anyone.
+                BlockEndpoint::get("/sites/{bucket}/{path}").summary("Serve a bucket website (index and error documents, clean URLs)"),
                 BlockEndpoint::get("/b/cloudstorage/").summary("Shares + quota page").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/cloudstorage/shares").summary("My share links").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/cloudstorage/shares").summary("Create share link").auth(AuthLevel::Authenticated),
+                BlockEndpoint::delete("/b/cloudstorage/shares/{id}").summary("Delete share link").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/cloudstorage/quota").summary("My quota and usage").auth(AuthLevel::Authenticated),
+                BlockEndpoint::get("/b/cloudstorage/teams/{id}/quota").summary("Team quota and usage").auth(AuthLevel::Authenticated),
                 // Admin SSR pages — declared `Admin` so the central router
                 // enforces the tier (the block dropped its inline `is_admin`
                 // check for `/b/storage/admin/*`).
@@ -140,6 +457,26 @@ crate::solobase_feature_block! {
             .can_disable(true)
     },
     handle: |this, ctx, msg, input| {
+        // Deferred work queued by this block (see `blocks::jobs`).
+        if msg.kind == crate::blocks::jobs::RUN_KIND {
+            use crate::blocks::jobs;
+            return match jobs::job_type(&msg) {
+                quota::NOTIFY_JOB => jobs::respond(quota::run_notify_job(ctx, input).await),
+                quota::DIGEST_JOB => jobs::respond(quota::run_digest_job(ctx).await),
+                lifecycle::RUN_JOB => jobs::respond(lifecycle::run_job(ctx).await),
+                scan::SCAN_JOB => jobs::respond(scan::run_scan_job(ctx, input).await),
+                blobs::RELAYOUT_JOB => jobs::respond(blobs::run_relayout_job(ctx).await),
+                consistency::CHECK_JOB | consistency::LEGACY_SCRUB_JOB => {
+                    jobs::respond(consistency::run_job(ctx, input).await)
+                }
+                user_purge::USER_PURGE_JOB => {
+                    jobs::respond(user_purge::run_purge_job(ctx, input).await)
+                }
+                archive::EXPAND_JOB => jobs::respond(archive::run_expand_job(ctx, input).await),
+                _ => jobs::unknown_type(&msg),
+            };
+        }
+
         let path = msg.path().to_string();
 
         // Admin-block delegation: when the Admin block routes a request for
@@ -170,11 +507,38 @@ crate::solobase_feature_block! {
             };
         }
 
+        // Concurrent transfer cap (see `transfers`): every body-moving route
+        // below holds a slot until its handler returns (a share link until
+        // its body is sent). The slot is freed when dropped, so a disconnect
+        // or panic gives it back too.
+        let transfer = match transfers::acquire(ctx, &msg).await {
+            Ok(slot) => slot,
+            Err(busy) => return busy,
+        };
+
         // Direct share access (public, no auth required) — still rate-limited
         // per remote IP inside the handler to stop token enumeration / DOS.
         // Matches the REAL on-the-wire path (no `req.resource` rewrite).
         if path.starts_with("/b/storage/direct/") {
-            return share::handle_direct_access(ctx, &msg, &this.limiter).await;
+            let out = share::handle_direct_access(ctx, &msg, &this.limiter).await;
+            return transfers::hold_until_sent(out, transfer);
+        }
+
+        // Upload widget script and token uploads (public; the token and
+        // the request's Origin are checked in the handler).
+        if widget::is_public_path(&path) {
+            return widget::handle_public(ctx, &msg, input, &this.limiter).await;
+        }
+
+        // S3-compatible gateway: signed by the pipeline, answers in S3's
+        // XML (including its own AccessDenied for unsigned requests).
+        if path == "/s3" || path.starts_with("/s3/") {
+            return s3::handle(ctx, msg, input, &this.limiter).await;
+        }
+
+        // Bucket websites (public; the bucket must have website mode on).
+        if path.starts_with(website::SITES_PREFIX) {
+            return website::handle_site(ctx, &msg, &this.limiter).await;
         }
 
         // Require authentication for all non-public endpoints
@@ -257,7 +621,16 @@ crate::solobase_feature_block! {
             migrations::SQLITE_MIGRATIONS,
             migrations::POSTGRES_MIGRATIONS,
         )
-        .await
+        .await?;
+        // Move blobs still at their object keys into the id layout, in the
+        // background (see `blobs`).
+        if matches!(event.event_type, wafer_run::LifecycleType::Init) {
+            blobs::queue_relayout(ctx).await;
+            crate::services::Services::new(ctx, "suppers-ai/files")
+                .settings()
+                .register_sections(settings_sections());
+        }
+        Ok(())
     },
 }
 
@@ -335,3 +708,18 @@ mod grant_tests {
         );
     }
 }
+
+#[cfg(test)]
+mod settings_tests {
+    #[test]
+    fn settings_sections_place_every_config_var_once() {
+        let mut placed: Vec<String> = super::settings_sections()
+            .into_iter()
+            .flat_map(|s| s.keys)
+            .collect();
+        let mut declared: Vec<String> = super::config_vars().into_iter().map(|v| v.key).collect();
+        placed.sort();
+        declared.sort();
+        assert_eq!(placed, declared);
+    }
+}
@@ -0,0 +1,760 @@
+//! Moving objects and folders between folders of one bucket.
+//!
+//! `POST /b/storage/api/buckets/{name}/move` takes the same ids the
+//! breadcrumbs hand out — an object's row id, or a folder prefix ending in
+//! `/` — and a `target` folder prefix (`""` or `null` for the bucket root):
+//!
+//! - `{"id": ..., "target": ...}` moves one item and answers with its new
+//!   key, or an error response.
+//! - `{"ids": [...], "target": ...}` moves each item independently and
+//!   answers `{"results": {id: {key, new_key, moved} | {error: {code,
+//!   message}}}}`.
+//!
+//! `POST /b/storage/api/buckets/{name}/objects/{id}/move` is the same
+//! single-item move addressed by object row id, with only `target` in the
+//! body.
+//!
+//! Folders are key prefixes, so a move rewrites keys: every object under
+//! the moved path gets the new prefix, along with its metadata row, the ACL
+//! grants on it and its share links. Blobs are stored by row id (see
+//! `blobs`), so none of them is copied — except a legacy blob still at its
+//! object key, which is relaid out first. The database has no
+//! transactions, so when one of those rewrites fails the ones already made
+//! are renamed back: an item moves whole or not at all.
+//!
+//! Checked per item, before anything is written: the target is an existing
+//! folder, a folder is not moved into itself or a descendant, and nothing
+//! already exists at the new path (`object_exists`, so the UI can offer a
+//! rename), and no lock (`locks`) covers the item, anything inside a
+//! folder, or the destination (`object_locked`). Moving deletes from the
+//! source, so like deletion it is owner-only.
+//!
+//! Each moved object gets a `move` history event; the objects of one
+//! request share a batch id (see `history`).
+
+use wafer_core::clients::database::Record;
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::{blobs, history, locks, repo, storage};
+use crate::{
+    blocks::errors::{self, ErrorCode},
+    http::{err_bad_request, err_forbidden, err_internal, ok_json},
+    util::RecordExt,
+};
+
+/// Most ids one bulk request may move.
+const MAX_BATCH_IDS: usize = 100;
+
+/// Most objects one folder move may rewrite.
+const MAX_FOLDER_OBJECTS: usize = 1000;
+
+/// Registered name of this block, for the storage access log.
+const FILES_BLOCK: &str = "suppers-ai/files";
+
+#[derive(serde::Deserialize)]
+struct MoveRequest {
+    #[serde(default)]
+    id: Option<String>,
+    #[serde(default)]
+    ids: Option<Vec<String>>,
+    #[serde(default)]
+    target: Option<String>,
+}
+
+/// Why one item was not moved.
+#[derive(Debug)]
+struct MoveError {
+    code: ErrorCode,
+    message: String,
+    details: Option<serde_json::Value>,
+}
+
+impl MoveError {
+    fn new(code: ErrorCode, message: impl Into<String>) -> Self {
+        Self {
+            code,
+            message: message.into(),
+            details: None,
+        }
+    }
+
+    fn locked(lock: &locks::Lock) -> Self {
+        Self {
+            code: ErrorCode::ObjectLocked,
+            message: lock.message(),
+            details: Some(lock.details()),
+        }
+    }
+
+    fn response(&self) -> OutputStream {
+        errors::error_json(self.code, &self.message, self.details.clone())
+    }
+
+    fn entry(&self) -> serde_json::Value {
+        let mut error = serde_json::json!({"code": self.code.as_str(), "message": self.message});
+        if let Some(details) = &self.details {
+            error["details"] = details.clone();
+        }
+        serde_json::json!({ "error": error })
+    }
+}
+
+/// A completed move.
+#[derive(Debug, serde::Serialize)]
+struct Moved {
+    key: String,
+    new_key: String,
+    /// Objects rewritten: 1 for a file, the object count for a folder.
+    moved: usize,
+}
+
+/// The path `key` (an object key, or a folder prefix ending in `/`) takes
+/// when moved into `target`: `target` plus the last segment of `key`.
+fn destination(key: &str, target: &str) -> String {
+    let trimmed = key.strip_suffix('/').unwrap_or(key);
+    let name = &key[trimmed.rfind('/').map_or(0, |i| i + 1)..];
+    format!("{target}{name}")
+}
+
+/// Validate a move of `key` into `target` without touching storage.
+fn check_paths(key: &str, target: &str) -> Result<String, MoveError> {
+    if key.ends_with('/') && target.starts_with(key) {
+        return Err(MoveError::new(
+            ErrorCode::ValidationFailed,
+            "A folder cannot be moved into itself or one of its subfolders",
+        ));
+    }
+    let new_key = destination(key, target);
+    if new_key == key {
+        return Err(MoveError::new(
+            ErrorCode::ValidationFailed,
+            "Already in the target folder",
+        ));
+    }
+    Ok(new_key)
+}
+
+/// Up to `limit` object keys under `prefix`, in key order.
+async fn keys_under(
+    ctx: &dyn Context,
+    bucket: &str,
+    prefix: &str,
+    limit: usize,
+) -> Result<Vec<String>, wafer_run::WaferError> {
+    Ok(
+        repo::objects::list_under(ctx, bucket, prefix, false, limit as i64, 0)
+            .await?
+            .records
+            .iter()
+            .map(|r| r.str_field("key").to_string())
+            .collect(),
+    )
+}
+
+/// Whether an object exists at `key` (an exact key, or any key under a
+/// folder prefix). Keys list in order and a key sorts before its
+/// extensions, so the first key under `key` is `key` itself if it exists.
+pub(super) async fn path_exists(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+) -> Result<bool, wafer_run::WaferError> {
+    let first = keys_under(ctx, bucket, key, 1).await?;
+    Ok(match first.first() {
+        Some(found) if key.ends_with('/') => found.starts_with(key),
+        Some(found) => found == key,
+        None => false,
+    })
+}
+
+fn internal(e: wafer_run::WaferError) -> MoveError {
+    tracing::warn!(error = %e, "storage move failed");
+    MoveError::new(ErrorCode::InternalError, "Storage error")
+}
+
+/// Resolve an id (object row id or folder prefix) to the key it names.
+async fn resolve(ctx: &dyn Context, bucket: &str, id: &str) -> Result<String, MoveError> {
+    if id.ends_with('/') {
+        if !storage::is_valid_storage_key(id) {
+            return Err(MoveError::new(
+                ErrorCode::ValidationFailed,
+                "Invalid folder path",
+            ));
+        }
+        if !path_exists(ctx, bucket, id).await.map_err(internal)? {
+            return Err(MoveError::new(
+                ErrorCode::ObjectNotFound,
+                "Folder not found",
+            ));
+        }
+        return Ok(id.to_string());
+    }
+    let rows = repo::objects::find_in_bucket_by_ids(ctx, bucket, &[id.to_string()])
+        .await
+        .map_err(internal)?;
+    rows.first()
+        .map(|row| row.str_field("key").to_string())
+        .ok_or_else(|| MoveError::new(ErrorCode::ObjectNotFound, "Object not found"))
+}
+
+/// Rewrite one object from `from` to `to`: metadata row, exact-path grants
+/// and share links. A legacy blob is moved to its id key first; renaming
+/// its row alone would strand it under the old key. Returns the row as it
+/// was before the move.
+async fn relocate_object(
+    ctx: &dyn Context,
+    bucket: &str,
+    from: &str,
+    to: &str,
+) -> Result<Option<Record>, wafer_run::WaferError> {
+    let row = repo::objects::find_by_bucket_key(ctx, bucket, from).await?;
+    if let Some(row) = &row {
+        if row.str_field("storage_key").is_empty() {
+            blobs::relayout(ctx, row).await?;
+        }
+    }
+    repo::objects::rename_key(ctx, bucket, from, to).await?;
+    repo::acls::rename_path(ctx, bucket, from, to).await?;
+    repo::shares::rename_key(ctx, bucket, from, to).await?;
+    Ok(row)
+}
+
+/// Undo [`relocate_object`] for an object that was headed from `from` to
+/// `to`, after a later step of its move failed. Each rewrite is a rename,
+/// so renaming back is exact and a rename that never ran matches nothing.
+/// Failures are logged and the remaining renames still run.
+async fn restore_object(ctx: &dyn Context, bucket: &str, from: &str, to: &str) {
+    let results = [
+        repo::shares::rename_key(ctx, bucket, to, from).await,
+        repo::acls::rename_path(ctx, bucket, to, from).await,
+        repo::objects::rename_key(ctx, bucket, to, from).await,
+    ];
+    for e in results.into_iter().filter_map(Result::err) {
+        tracing::error!(error = %e, bucket = %bucket, key = %from, "storage move rollback failed");
+    }
+}
+
+/// Move the item named by `id` into `target` (already validated as an
+/// existing folder) on behalf of `actor`, recording a `move` event per
+/// object under `batch_id` — or, for a folder moved alone, a batch of its
+/// own.
+async fn move_one(
+    ctx: &dyn Context,
+    bucket: &str,
+    id: &str,
+    target: &str,
+    actor: &str,
+    batch_id: &str,
+) -> Result<Moved, MoveError> {
+    let key = resolve(ctx, bucket, id).await?;
+    let new_key = check_paths(&key, target)?;
+    // Neither a locked item (or a folder holding one) nor anything landing
+    // in a locked folder.
+    let locks = locks::LockSet::load(ctx, bucket).await.map_err(internal)?;
+    if let Some(lock) = locks.blocking(&key).or_else(|| locks.blocking(&new_key)) {
+        return Err(MoveError::locked(lock));
+    }
+    if path_exists(ctx, bucket, &new_key).await.map_err(internal)? {
+        return Err(MoveError::new(
+            ErrorCode::ObjectExists,
+            format!("'{new_key}' already exists"),
+        ));
+    }
+
+    let keys = if key.ends_with('/') {
+        let keys = keys_under(ctx, bucket, &key, MAX_FOLDER_OBJECTS + 1)
+            .await
+            .map_err(internal)?;
+        if keys.len() > MAX_FOLDER_OBJECTS {
+            return Err(MoveError::new(
+                ErrorCode::ValidationFailed,
+                format!("Folders with more than {MAX_FOLDER_OBJECTS} objects cannot be moved"),
+            ));
+        }
+        keys
+    } else {
+        vec![key.clone()]
+    };
+
+    let batch_id = if batch_id.is_empty() && key.ends_with('/') {
+        history::new_batch_id()
+    } else {
+        batch_id.to_string()
+    };
+    // There are no transactions, so a failure part way through puts back
+    // every object already rewritten rather than leaving a folder split
+    // across both prefixes. History is written only once all of it landed.
+    let mut done: Vec<(String, String, Option<Record>)> = Vec::with_capacity(keys.len());
+    let mut result = Ok(());
+    for from in &keys {
+        let to = format!("{new_key}{}", &from[key.len()..]);
+        match relocate_object(ctx, bucket, from, &to).await {
+            Ok(row) => done.push((from.clone(), to, row)),
+            Err(e) => {
+                // Some of this object's rewrites may have landed already.
+                done.push((from.clone(), to, None));
+                result = Err(e);
+                break;
+            }
+        }
+    }
+    let mut folder_renamed = false;
+    if result.is_ok() && key.ends_with('/') {
+        folder_renamed = true;
+        result = repo::acls::rename_folder(ctx, bucket, &key, &new_key).await;
+    }
+    match &result {
+        Ok(()) => {
+            for (from, to, row) in &done {
+                if let Some(row) = row {
+                    let event = history::Event::moved(row, actor, from, to).batch(&batch_id);
+                    history::record(ctx, event).await;
+                }
+            }
+        }
+        Err(_) => {
+            if folder_renamed {
+                if let Err(e) = repo::acls::rename_folder(ctx, bucket, &new_key, &key).await {
+                    tracing::error!(error = %e, bucket = %bucket, folder = %key, "storage move rollback failed");
+                }
+            }
+            for (from, to, _) in done.iter().rev() {
+                restore_object(ctx, bucket, from, to).await;
+            }
+        }
+    }
+
+    let status = match &result {
+        Ok(()) => "OK".to_string(),
+        Err(e) => format!("ERROR: {}", e.message),
+    };
+    let path = format!("{FILES_BLOCK}/{bucket}/{key} -> {new_key}");
+    if let Err(e) =
+        crate::blocks::storage::log_storage_access(ctx, FILES_BLOCK, "storage.move", &path, status)
+            .await
+    {
+        tracing::warn!(error = %e, "storage move not logged");
+    }
+
+    result.map_err(internal)?;
+    Ok(Moved {
+        key,
+        new_key,
+        moved: keys.len(),
+    })
+}
+
+/// The checks both move endpoints share: bucket access, the body, and its
+/// `target`. Answers with the body and target, or the error response.
+async fn read_request(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    input: InputStream,
+) -> Result<(MoveRequest, String), OutputStream> {
+    if !storage::is_safe_bucket_name(bucket) {
+        return Err(err_bad_request("Invalid bucket name"));
+    }
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return Err(err_forbidden("Access denied to this bucket"));
+    }
+    let mut req: MoveRequest = crate::body::decode(msg, input).await?;
+
+    let target = req.target.take().unwrap_or_default();
+    if !target.is_empty() {
+        if !target.ends_with('/') || !storage::is_valid_storage_key(&target) {
+            return Err(errors::validation_error(
+                "Invalid move target",
+                &[(
+                    "target",
+                    "must be a folder path ending in '/', or empty for the root",
+                )],
+            ));
+        }
+        match path_exists(ctx, bucket, &target).await {
+            Ok(true) => {}
+            Ok(false) => {
+                return Err(errors::error_json(
+                    ErrorCode::ObjectNotFound,
+                    "Target folder not found",
+                    None,
+                ))
+            }
+            Err(e) => return Err(err_internal("Storage error", e)),
+        }
+    }
+    Ok((req, target))
+}
+
+/// `POST /b/storage/api/buckets/{name}/objects/{id}/move`: move the one
+/// object whose row id is `id`. The body carries only `target`; folders,
+/// whose ids end in `/`, move through [`handle`].
+pub(super) async fn handle_object(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    id: &str,
+    input: InputStream,
+) -> OutputStream {
+    let (req, target) = match read_request(ctx, msg, bucket, input).await {
+        Ok(read) => read,
+        Err(r) => return r,
+    };
+    if req.id.is_some() || req.ids.is_some() {
+        return errors::validation_error(
+            "Unexpected ids",
+            &[("id", "the object id is taken from the path")],
+        );
+    }
+    match move_one(ctx, bucket, id, &target, msg.user_id(), "").await {
+        Ok(moved) => ok_json(&moved),
+        Err(e) => e.response(),
+    }
+}
+
+/// `POST /b/storage/api/buckets/{name}/move`.
+pub(super) async fn handle(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    input: InputStream,
+) -> OutputStream {
+    let (req, target) = match read_request(ctx, msg, bucket, input).await {
+        Ok(read) => read,
+        Err(r) => return r,
+    };
+
+    match (req.id, req.ids) {
+        (Some(id), None) if !id.is_empty() => {
+            match move_one(ctx, bucket, &id, &target, msg.user_id(), "").await {
+                Ok(moved) => ok_json(&moved),
+                Err(e) => e.response(),
+            }
+        }
+        (None, Some(ids)) => {
+            let mut unique: Vec<String> = Vec::new();
+            for id in ids.iter().map(|id| id.trim()) {
+                if !id.is_empty() && !unique.iter().any(|seen| seen == id) {
+                    unique.push(id.to_string());
+                }
+            }
+            if unique.is_empty() {
+                return errors::validation_error("No ids given", &[("ids", "required")]);
+            }
+            if unique.len() > MAX_BATCH_IDS {
+                return errors::validation_error("Too many ids", &[("ids", "at most 100 ids")]);
+            }
+            let batch_id = history::new_batch_id();
+            let mut results = serde_json::Map::new();
+            for id in unique {
+                let entry =
+                    match move_one(ctx, bucket, &id, &target, msg.user_id(), &batch_id).await {
+                        Ok(moved) => serde_json::json!(moved),
+                        Err(e) => e.entry(),
+                    };
+                results.insert(id, entry);
+            }
+            ok_json(&serde_json::json!({"results": results}))
+        }
+        _ => errors::validation_error(
+            "Nothing to move",
+            &[("id", "give exactly one of 'id' or 'ids'")],
+        ),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use wafer_core::clients::storage as store;
+
+    use super::*;
+    use crate::test_support::{auth_msg, output_is_error, output_json, output_status, TestContext};
+
+    async fn ctx() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let data = crate::util::json_map(serde_json::json!({
+            "name": "team",
+            "public": false,
+            "created_by": "alice",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(&ctx, data).await.expect("seed bucket");
+        ctx
+    }
+
+    /// Store a blob and its `complete` row; returns the row id.
+    async fn put(ctx: &TestContext, key: &str) -> String {
+        blobs::seed(ctx, "team", key, key.as_bytes(), "text/plain", "alice")
+            .await
+            .id
+    }
+
+    fn move_msg(user: &str) -> Message {
+        let mut msg = auth_msg("create", "/b/storage/api/buckets/team/move", user);
+        msg.set_meta("req.param.name", "team");
+        msg
+    }
+
+    async fn run(ctx: &TestContext, user: &str, body: serde_json::Value) -> OutputStream {
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        handle(ctx, &move_msg(user), "team", input).await
+    }
+
+    async fn keys(ctx: &TestContext) -> Vec<String> {
+        keys_under(ctx, "team", "", 100).await.unwrap()
+    }
+
+    #[test]
+    fn destination_keeps_the_last_segment() {
+        assert_eq!(destination("docs/a.txt", "archive/"), "archive/a.txt");
+        assert_eq!(destination("docs/q1/", "archive/"), "archive/q1/");
+        assert_eq!(destination("a.txt", ""), "a.txt");
+        assert_eq!(destination("docs/q1/", ""), "q1/");
+    }
+
+    #[test]
+    fn folders_cannot_move_into_themselves() {
+        assert!(check_paths("docs/", "docs/").is_err());
+        assert!(check_paths("docs/", "docs/q1/").is_err());
+        assert!(check_paths("docs/a.txt", "docs/").is_err(), "already there");
+        assert_eq!(check_paths("docs/", "docsets/").unwrap(), "docsets/docs/");
+        assert_eq!(check_paths("docs/q1/", "").unwrap(), "q1/");
+    }
+
+    #[tokio::test]
+    async fn moves_a_file_with_its_row_grants_and_shares() {
+        let ctx = ctx().await;
+        let id = put(&ctx, "docs/a.txt").await;
+        put(&ctx, "archive/old.txt").await;
+        repo::acls::upsert(
+            &ctx,
+            repo::acls::NewGrant {
+                bucket: "team",
+                path: "docs/a.txt",
+                grantee_user_id: "bob",
+                permission: "read",
+                granted_by: "alice",
+            },
+        )
+        .await
+        .unwrap();
+
+        let out = run(
+            &ctx,
+            "alice",
+            serde_json::json!({"id": id, "target": "archive/"}),
+        )
+        .await;
+        let body = output_json(out).await;
+        assert_eq!(body["new_key"], "archive/a.txt");
+        assert_eq!(body["moved"], 1);
+
+        assert_eq!(keys(&ctx).await, ["archive/a.txt", "archive/old.txt"]);
+        let row = repo::objects::get(&ctx, &id).await.unwrap();
+        assert_eq!(row.str_field("key"), "archive/a.txt");
+        assert_eq!(row.str_field("key_lower"), "archive/a.txt");
+        let grants = repo::acls::list_for_bucket(&ctx, "team", None)
+            .await
+            .unwrap();
+        assert_eq!(grants[0].str_field("path"), "archive/a.txt");
+        let (data, _) = blobs::get(&ctx, "team", "archive/a.txt").await.unwrap();
+        assert_eq!(data, b"docs/a.txt");
+        assert_eq!(row.str_field("storage_key"), repo::objects::blob_key(&id));
+
+        let logged = wafer_core::clients::database::list_all(
+            &ctx,
+            crate::blocks::admin::STORAGE_ACCESS_LOGS_TABLE,
+            vec![],
+        )
+        .await
+        .unwrap();
+        assert!(logged
+            .iter()
+            .any(|r| r.str_field("operation") == "storage.move" && r.str_field("status") == "OK"));
+    }
+
+    /// A blob still at its object key moves to its id key along with the
+    /// row, instead of being stranded under the old name.
+    #[tokio::test]
+    async fn moving_a_legacy_object_relays_its_blob_out() {
+        let ctx = ctx().await;
+        store::put(&ctx, "team", "a.txt", b"old", "text/plain")
+            .await
+            .unwrap();
+        let row = repo::objects::insert_legacy(&ctx, "team", "a.txt", 3, "text/plain", "alice", "")
+            .await
+            .unwrap();
+        put(&ctx, "inbox/keep.txt").await;
+
+        let out = run(
+            &ctx,
+            "alice",
+            serde_json::json!({"id": row.id, "target": "inbox/"}),
+        )
+        .await;
+        assert_eq!(output_json(out).await["new_key"], "inbox/a.txt");
+        let (data, _) = blobs::get(&ctx, "team", "inbox/a.txt").await.unwrap();
+        assert_eq!(data, b"old");
+        assert!(store::get(&ctx, "team", "a.txt").await.is_err());
+    }
+
+    #[tokio::test]
+    async fn moves_a_folder_and_rejects_cycles() {
+        let ctx = ctx().await;
+        put(&ctx, "docs/q1/a.txt").await;
+        put(&ctx, "docs/q1/deep/b.txt").await;
+        put(&ctx, "archive/x.txt").await;
+
+        let out = run(
+            &ctx,
+            "alice",
+            serde_json::json!({"id": "docs/", "target": "docs/q1/"}),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 400);
+
+        let out = run(
+            &ctx,
+            "alice",
+            serde_json::json!({"id": "docs/q1/", "target": "archive/"}),
+        )
+        .await;
+        assert_eq!(output_json(out).await["moved"], 2);
+        assert_eq!(
+            keys(&ctx).await,
+            ["archive/q1/a.txt", "archive/q1/deep/b.txt", "archive/x.txt"]
+        );
+    }
+
+    #[tokio::test]
+    async fn target_must_exist_and_names_must_not_collide() {
+        let ctx = ctx().await;
+        let id = put(&ctx, "docs/a.txt").await;
+        put(&ctx, "archive/a.txt").await;
+
+        let out = run(
+            &ctx,
+            "alice",
+            serde_json::json!({"id": id, "target": "missing/"}),
+        )
+        .await;
+        assert_eq!(output_json(out).await["code"], "object_not_found");
+        let out = run(
+            &ctx,
+            "alice",
+            serde_json::json!({"id": id, "target": "archive/a.txt"}),
+        )
+        .await;
+        assert_eq!(output_status(out).await, 400, "target must be a folder");
+
+        let out = run(
+            &ctx,
+            "alice",
+            serde_json::json!({"id": id, "target": "archive/"}),
+        )
+        .await;
+        let body = output_json(out).await;
+        assert_eq!(body["code"], "object_exists");
+        assert_eq!(
+            keys(&ctx).await,
+            ["archive/a.txt", "docs/a.txt"],
+            "nothing moved"
+        );
+
+        let out = run(&ctx, "bob", serde_json::json!({"id": id, "target": ""})).await;
+        assert!(output_is_error(out, "PermissionDenied").await);
+    }
+
+    #[tokio::test]
+    async fn bulk_move_reports_each_id() {
+        let ctx = ctx().await;
+        let a = put(&ctx, "a.txt").await;
+        let b = put(&ctx, "b.txt").await;
+        put(&ctx, "inbox/b.txt").await;
+        put(&ctx, "inbox/keep.txt").await;
+
+        let out = run(
+            &ctx,
+            "alice",
+            serde_json::json!({"ids": [a, b, "missing"], "target": "inbox/"}),
+        )
+        .await;
+        let results = output_json(out).await["results"].clone();
+        assert_eq!(results[&a]["new_key"], "inbox/a.txt");
+        assert_eq!(results[&b]["error"]["code"], "object_exists");
+        assert_eq!(results["missing"]["error"]["code"], "object_not_found");
+        assert_eq!(
+            keys(&ctx).await,
+            ["b.txt", "inbox/a.txt", "inbox/b.txt", "inbox/keep.txt"]
+        );
+    }
+
+    #[tokio::test]
+    async fn moves_one_object_by_the_id_in_its_path() {
+        let ctx = ctx().await;
+        let id = put(&ctx, "docs/a.txt").await;
+        put(&ctx, "archive/old.txt").await;
+
+        let path = format!("/b/storage/api/buckets/team/objects/{id}/move");
+        let msg = auth_msg("create", &path, "alice");
+        let body = serde_json::json!({"target": "archive/"});
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        let out = handle_object(&ctx, &msg, "team", &id, input).await;
+        assert_eq!(output_json(out).await["new_key"], "archive/a.txt");
+
+        let body = serde_json::json!({"ids": [id], "target": ""});
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        let out = handle_object(&ctx, &msg, "team", &id, input).await;
+        assert_eq!(output_status(out).await, 400);
+        assert_eq!(keys(&ctx).await, ["archive/a.txt", "archive/old.txt"]);
+    }
+
+    /// A rewrite that fails part way through a folder move puts back the
+    /// objects and grants already moved, so the folder stays whole.
+    #[tokio::test]
+    async fn a_failed_folder_move_leaves_the_folder_where_it_was() {
+        let ctx = ctx().await;
+        put(&ctx, "docs/a.txt").await;
+        put(&ctx, "docs/b.txt").await;
+        put(&ctx, "archive/old.txt").await;
+        repo::acls::upsert(
+            &ctx,
+            repo::acls::NewGrant {
+                bucket: "team",
+                path: "docs/a.txt",
+                grantee_user_id: "bob",
+                permission: "read",
+                granted_by: "alice",
+            },
+        )
+        .await
+        .unwrap();
+        // Acting as another block that may rewrite rows and grants but only
+        // read share links, so the first object's share rewrite is refused.
+        let mover = "suppers-ai/products";
+        let ctx = ctx.with_wrap(
+            mover,
+            vec![
+                wafer_run::ResourceGrant::read_write(mover, repo::objects::TABLE),
+                wafer_run::ResourceGrant::read_write(mover, repo::acls::TABLE),
+                wafer_run::ResourceGrant::read(mover, repo::locks::TABLE),
+                wafer_run::ResourceGrant::read(mover, repo::shares::TABLE),
+            ],
+            crate::blocks::admin::ADMIN_BLOCK_ID,
+        );
+
+        let err = move_one(&ctx, "team", "docs/", "archive/", "alice", "")
+            .await
+            .unwrap_err();
+        assert_eq!(err.code, ErrorCode::InternalError);
+        assert_eq!(
+            keys(&ctx).await,
+            ["archive/old.txt", "docs/a.txt", "docs/b.txt"]
+        );
+        let grants = repo::acls::list_for_bucket(&ctx, "team", None)
+            .await
+            .unwrap();
+        assert_eq!(grants[0].str_field("path"), "docs/a.txt");
+    }
+}
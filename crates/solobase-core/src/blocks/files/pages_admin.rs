@@ -3,7 +3,7 @@
 use maud::{html, Markup};
 use wafer_run::{context::Context, Message, OutputStream};
 
-use super::repo;
+use super::{consistency::RunSummary, repo};
 use crate::{
     ui::{self, components, icons, shell::Crumb},
     util::{format_bytes, RecordExt},
@@ -134,10 +134,34 @@ pub fn render_admin_overview_quotas_hint(quotas_count: i64) -> Markup {
     }
 }
 
+/// Render the last consistency check's findings as a hint card: orphaned
+/// blobs still taking space, and objects whose blob is missing. Empty
+/// markup when no check has finished or it found nothing. Pure helper.
+pub fn render_admin_overview_consistency_hint(run: Option<&RunSummary>) -> Markup {
+    let Some(run) = run.filter(|r| r.totals.orphans > 0 || r.totals.dangling > 0) else {
+        return html! {};
+    };
+    html! {
+        div .card style="padding:1rem" {
+            p .text-muted style="font-size:0.875rem" {
+                "Last consistency check: " (run.totals.orphans) " orphaned blob(s), "
+                (format_bytes(run.totals.reclaimable_bytes)) " reclaimable; "
+                (run.totals.dangling) " file(s) missing from storage."
+            }
+        }
+    }
+}
+
 pub async fn overview(ctx: &dyn Context, msg: &Message) -> OutputStream {
     use crate::ui::templates::{list_page, PageHeader};
 
     let stats = load_admin_stats(ctx).await;
+    let check = super::consistency::last_finished(ctx)
+        .await
+        .unwrap_or_else(|e| {
+            tracing::warn!(error = %e.message, "admin overview: consistency run lookup failed");
+            None
+        });
 
     // Tabs go in the `filters` slot (their padding gutter matches
     // /b/admin/users); stats live in the body. Keeping them in separate
@@ -154,6 +178,7 @@ pub async fn overview(ctx: &dyn Context, msg: &Message) -> OutputStream {
             (render_admin_overview_stats(&stats))
             (render_admin_overview_empty_cta(stats.buckets))
             (render_admin_overview_quotas_hint(stats.quotas_count))
+            (render_admin_overview_consistency_hint(check.as_ref()))
         },
         None,
     );
@@ -219,6 +244,8 @@ pub struct AdminBucketRow {
     pub owner_short: String,
     pub public: bool,
     pub created_at_short: String,
+    pub object_count: i64,
+    pub total_bytes: i64,
 }
 
 /// Render the admin Buckets table (or empty state).
@@ -234,6 +261,8 @@ pub fn render_admin_buckets_table(rows: &[AdminBucketRow]) -> Markup {
                 th { "Name" }
                 th { "Owner" }
                 th { "Public" }
+                th { "Objects" }
+                th { "Size" }
                 th { "Created" }
             } }
             tbody {
@@ -244,6 +273,8 @@ pub fn render_admin_buckets_table(rows: &[AdminBucketRow]) -> Markup {
                         td data-label="Public" {
                             (components::status_badge(if r.public { "public" } else { "private" }))
                         }
+                        td data-label="Objects" { (r.object_count) }
+                        td data-label="Size" { (format_bytes(r.total_bytes)) }
                         td data-label="Created" .text-muted .text-sm { (r.created_at_short) }
                     }
                 }
@@ -255,23 +286,33 @@ pub fn render_admin_buckets_table(rows: &[AdminBucketRow]) -> Markup {
 pub async fn buckets(ctx: &dyn Context, msg: &Message) -> OutputStream {
     use crate::ui::templates::{list_page, PageHeader};
 
+    // The same cached aggregate the bucket listing reports.
+    let stats = super::bucket_stats::all(ctx).await.unwrap_or_else(|e| {
+        tracing::warn!(error = %e.message, "admin bucket stats failed");
+        Default::default()
+    });
     let rows: Vec<AdminBucketRow> = match repo::buckets::list_recent(ctx, 100).await {
         Ok(list) => list
             .records
             .into_iter()
-            .map(|r| AdminBucketRow {
-                name: r.str_field("name").to_string(),
-                owner_short: r
-                    .str_field("created_by")
-                    .get(..8)
-                    .unwrap_or("—")
-                    .to_string(),
-                public: r.str_field("public") == "true",
-                created_at_short: r
-                    .str_field("created_at")
-                    .get(..10)
-                    .unwrap_or("")
-                    .to_string(),
+            .map(|r| {
+                let usage = stats.get(r.str_field("name")).cloned().unwrap_or_default();
+                AdminBucketRow {
+                    name: r.str_field("name").to_string(),
+                    owner_short: r
+                        .str_field("created_by")
+                        .get(..8)
+                        .unwrap_or("—")
+                        .to_string(),
+                    public: r.str_field("public") == "true",
+                    created_at_short: r
+                        .str_field("created_at")
+                        .get(..10)
+                        .unwrap_or("")
+                        .to_string(),
+                    object_count: usage.object_count,
+                    total_bytes: usage.total_bytes,
+                }
             })
             .collect(),
         Err(e) => {
@@ -628,6 +669,29 @@ mod tests {
         );
     }
 
+    #[test]
+    fn render_admin_overview_consistency_hint_shows_findings() {
+        let mut run = RunSummary {
+            id: "r1".into(),
+            status: "done".into(),
+            repairs: Default::default(),
+            started_by: String::new(),
+            totals: Default::default(),
+            created_at: String::new(),
+            finished_at: String::new(),
+        };
+        assert!(render_admin_overview_consistency_hint(Some(&run))
+            .into_string()
+            .is_empty());
+
+        run.totals.orphans = 2;
+        run.totals.reclaimable_bytes = 2048;
+        run.totals.dangling = 1;
+        let html = render_admin_overview_consistency_hint(Some(&run)).into_string();
+        assert!(html.contains("2 orphaned blob(s)"), "{html}");
+        assert!(html.contains("1 file(s) missing"), "{html}");
+    }
+
     #[test]
     fn render_admin_buckets_table_empty_state() {
         let html = render_admin_buckets_table(&[]).into_string();
@@ -642,12 +706,16 @@ mod tests {
                 owner_short: "admin_1".into(),
                 public: true,
                 created_at_short: "2026-05-06".into(),
+                object_count: 12,
+                total_bytes: 2048,
             },
             AdminBucketRow {
                 name: "docs".into(),
                 owner_short: "user_42".into(),
                 public: false,
                 created_at_short: "2026-05-05".into(),
+                object_count: 0,
+                total_bytes: 0,
             },
         ];
         let html = render_admin_buckets_table(&rows).into_string();
@@ -658,6 +726,8 @@ mod tests {
         assert!(html.contains("public"));
         assert!(html.contains("private"));
         assert!(html.contains("2026-05-06"));
+        assert!(html.contains(">12<"), "object count missing: {html}");
+        assert!(html.contains("2.0 KB"));
     }
 
     #[test]
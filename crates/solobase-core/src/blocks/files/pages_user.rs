@@ -667,7 +667,7 @@ pub async fn cloudstorage_page(ctx: &dyn Context, msg: &Message) -> OutputStream
     }
 
     let shares = list_shares_for_user(ctx, &user_id).await;
-    // Same quota source as upload enforcement (`quota::check_quota`), so
+    // Same quota source as upload enforcement (`upload_check`), so
     // the card can never disagree with what the API enforces.
     let quota = QuotaInfo {
         used_bytes: super::quota::get_used_bytes(ctx, &user_id).await,
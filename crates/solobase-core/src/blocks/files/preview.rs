@@ -0,0 +1,296 @@
+//! Inline previews for the storage API.
+//!
+//! `GET /b/storage/api/buckets/{name}/preview/{key...}` serves an object so
+//! the browser renders it instead of downloading it. It uses the same
+//! owner/admin/ACL check as the download route and records a view the same
+//! way. Bytes are fetched through the same `blobs::get` path, so preview
+//! traffic appears in the storage access log like downloads.
+//!
+//! User-uploaded content is served from the app origin, so every preview
+//! carries `X-Content-Type-Options: nosniff` and a `sandbox` CSP (the
+//! document gets a unique opaque origin and cannot run script against the
+//! admin session). What is previewable:
+//!
+//! - text (incl. HTML, JSON, XML, CSS, JS): the first [`TEXT_PREVIEW_LIMIT`]
+//!   bytes as `text/plain` with a detected charset — markup is shown as
+//!   source, never rendered;
+//! - raster images and PDFs: the full object with its stored type;
+//! - SVG: always `Content-Disposition: attachment` (SVG is a script-capable
+//!   document format);
+//! - anything else: 415 `preview_unsupported` with a descriptor the UI uses
+//!   to fall back to the download link.
+
+use wafer_run::{context::Context, ErrorCode, Message, OutputStream};
+
+use super::{
+    acl::{self, Access},
+    blobs, repo,
+    storage::is_valid_storage_key,
+};
+use crate::{
+    blocks::errors,
+    http::{err_bad_request, err_forbidden, err_internal, ResponseBuilder},
+    util::urlencode,
+};
+
+/// Bytes of a text object a preview returns.
+pub(super) const TEXT_PREVIEW_LIMIT: usize = 256 * 1024;
+
+/// CSP for previewed documents: no script, no network, opaque origin.
+const SANDBOX_CSP: &str = "sandbox; default-src 'none'; img-src 'self' data:; \
+                           style-src 'unsafe-inline'";
+
+/// CSP for PDFs. Chromium's PDF viewer refuses to load in a `sandbox`ed
+/// document, so PDFs get a lockdown without it; the viewer runs PDF
+/// JavaScript (if any) in its own isolated context, not the app origin.
+const PDF_CSP: &str = "default-src 'none'; object-src 'self'; plugin-types application/pdf";
+
+/// How an object is previewed.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(super) enum PreviewKind {
+    Text,
+    Image,
+    Pdf,
+    /// Served only as a download (`attachment`).
+    Svg,
+    Unsupported,
+}
+
+/// Classify by stored content type, falling back to the key's extension
+/// when the type is missing or generic (`application/octet-stream`).
+pub(super) fn classify(content_type: &str, key: &str) -> PreviewKind {
+    let mime = content_type
+        .split(';')
+        .next()
+        .unwrap_or("")
+        .trim()
+        .to_ascii_lowercase();
+    let mime = if mime.is_empty() || mime == "application/octet-stream" {
+        mime_from_extension(key).to_string()
+    } else {
+        mime
+    };
+    match mime.as_str() {
+        "image/svg+xml" => PreviewKind::Svg,
+        "application/pdf" => PreviewKind::Pdf,
+        "image/png" | "image/jpeg" | "image/gif" | "image/webp" | "image/avif" | "image/bmp" => {
+            PreviewKind::Image
+        }
+        "application/json"
+        | "application/xml"
+        | "application/javascript"
+        | "application/x-yaml"
+        | "application/yaml"
+        | "application/toml" => PreviewKind::Text,
+        m if m.starts_with("text/") => PreviewKind::Text,
+        _ => PreviewKind::Unsupported,
+    }
+}
+
+/// The MIME type of `key`'s extension, or `""` for one not listed.
+pub(super) fn mime_from_extension(key: &str) -> &'static str {
+    let ext = key
+        .rsplit('/')
+        .next()
+        .and_then(|name| name.rsplit_once('.'))
+        .map(|(_, ext)| ext.to_ascii_lowercase())
+        .unwrap_or_default();
+    match ext.as_str() {
+        "txt" | "log" | "md" | "csv" | "tsv" | "ini" | "conf" | "rs" | "go" | "py" | "sh" => {
+            "text/plain"
+        }
+        "html" | "htm" => "text/html",
+        "css" => "text/css",
+        "js" | "mjs" => "application/javascript",
+        "json" => "application/json",
+        "xml" => "application/xml",
+        "yaml" | "yml" => "application/yaml",
+        "toml" => "application/toml",
+        "pdf" => "application/pdf",
+        "png" => "image/png",
+        "jpg" | "jpeg" => "image/jpeg",
+        "gif" => "image/gif",
+        "webp" => "image/webp",
+        "avif" => "image/avif",
+        "bmp" => "image/bmp",
+        "svg" => "image/svg+xml",
+        "ico" => "image/x-icon",
+        "woff" => "font/woff",
+        "woff2" => "font/woff2",
+        _ => "",
+    }
+}
+
+/// Cut `data` to at most `limit` bytes and name its charset. A UTF-8/UTF-16
+/// BOM wins; otherwise valid UTF-8 (ignoring a sequence split by the cut)
+/// is `utf-8` and anything else is treated as `windows-1252`, the
+/// browser's own fallback for unlabeled Latin text. Returns the bytes,
+/// the charset, and whether the cut dropped anything.
+pub(super) fn text_excerpt(data: &[u8], limit: usize) -> (&[u8], &'static str, bool) {
+    let truncated = data.len() > limit;
+    let mut cut = &data[..data.len().min(limit)];
+    let charset = if cut.starts_with(&[0xEF, 0xBB, 0xBF]) {
+        "utf-8"
+    } else if cut.starts_with(&[0xFF, 0xFE]) {
+        "utf-16le"
+    } else if cut.starts_with(&[0xFE, 0xFF]) {
+        "utf-16be"
+    } else {
+        match std::str::from_utf8(cut) {
+            Ok(_) => "utf-8",
+            // Only an incomplete sequence at the very end (the cut landed
+            // mid-character): drop those bytes and keep UTF-8.
+            Err(e) if truncated && e.error_len().is_none() => {
+                cut = &cut[..e.valid_up_to()];
+                "utf-8"
+            }
+            Err(_) => "windows-1252",
+        }
+    };
+    if truncated && charset.starts_with("utf-16") && cut.len() % 2 == 1 {
+        cut = &cut[..cut.len() - 1];
+    }
+    (cut, charset, truncated)
+}
+
+fn disposition(kind: &str, key: &str) -> String {
+    let name = key.rsplit('/').next().unwrap_or(key);
+    let ascii: String = name
+        .chars()
+        .map(|c| {
+            if c.is_ascii_graphic() || c == ' ' {
+                c
+            } else {
+                '_'
+            }
+        })
+        .filter(|c| *c != '"' && *c != '\\')
+        .collect();
+    format!(
+        "{kind}; filename=\"{ascii}\"; filename*=UTF-8''{}",
+        urlencode(name)
+    )
+}
+
+fn preview_response(kind: &str, key: &str, csp: &str) -> ResponseBuilder {
+    ResponseBuilder::new()
+        .set_header("Content-Disposition", &disposition(kind, key))
+        .set_header("X-Content-Type-Options", "nosniff")
+        .set_header("Content-Security-Policy", csp)
+        .set_header("Cache-Control", "private, no-cache")
+}
+
+/// `GET /b/storage/api/buckets/{name}/preview/{key...}`.
+pub(super) async fn handle_preview(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    key: &str,
+) -> OutputStream {
+    if bucket.is_empty() || key.is_empty() {
+        return err_bad_request("Missing bucket name or object key");
+    }
+    if !is_valid_storage_key(key) {
+        return err_bad_request("Invalid object key");
+    }
+    if acl::is_access_denied(ctx, msg, bucket, key, Access::Read).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    if let Some(blocked) = super::scan::read_blocked(ctx, msg.user_id(), bucket, key).await {
+        return blocked;
+    }
+
+    let (data, content_type) = match blobs::get(ctx, bucket, key).await {
+        Ok(found) => found,
+        Err(e) if e.code == ErrorCode::NotFound => {
+            return errors::error_response(errors::ErrorCode::ObjectNotFound, "Object not found")
+        }
+        Err(e) => return err_internal("Storage error", e),
+    };
+
+    let kind = classify(&content_type, key);
+    if kind == PreviewKind::Unsupported {
+        return errors::error_json(
+            errors::ErrorCode::PreviewUnsupported,
+            "No inline preview for this file type",
+            Some(serde_json::json!({
+                "content_type": content_type,
+                "size": data.len(),
+                "download_url": format!(
+                    "/b/storage/api/buckets/{bucket}/objects/{}",
+                    key.split('/').map(urlencode).collect::<Vec<_>>().join("/")
+                ),
+            })),
+        );
+    }
+
+    repo::views::record(ctx, bucket, key, msg.user_id()).await;
+
+    match kind {
+        PreviewKind::Text => {
+            let (excerpt, charset, truncated) = text_excerpt(&data, TEXT_PREVIEW_LIMIT);
+            preview_response("inline", key, SANDBOX_CSP)
+                .set_header(
+                    "X-Preview-Truncated",
+                    if truncated { "true" } else { "false" },
+                )
+                .body(excerpt.to_vec(), &format!("text/plain; charset={charset}"))
+        }
+        PreviewKind::Image => {
+            preview_response("inline", key, SANDBOX_CSP).body(data, &content_type)
+        }
+        PreviewKind::Pdf => preview_response("inline", key, PDF_CSP).body(data, "application/pdf"),
+        PreviewKind::Svg => {
+            preview_response("attachment", key, SANDBOX_CSP).body(data, "image/svg+xml")
+        }
+        PreviewKind::Unsupported => unreachable!("handled above"),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn classify_uses_type_then_extension() {
+        assert_eq!(
+            classify("text/plain; charset=utf-8", "a"),
+            PreviewKind::Text
+        );
+        assert_eq!(classify("text/html", "a.html"), PreviewKind::Text);
+        assert_eq!(classify("application/pdf", "a"), PreviewKind::Pdf);
+        assert_eq!(classify("image/png", "a"), PreviewKind::Image);
+        assert_eq!(classify("image/svg+xml", "a"), PreviewKind::Svg);
+        assert_eq!(
+            classify("application/octet-stream", "x/y.svg"),
+            PreviewKind::Svg
+        );
+        assert_eq!(classify("", "notes.md"), PreviewKind::Text);
+        assert_eq!(
+            classify("application/zip", "a.zip"),
+            PreviewKind::Unsupported
+        );
+        assert_eq!(
+            classify("application/octet-stream", "blob"),
+            PreviewKind::Unsupported
+        );
+    }
+
+    #[test]
+    fn text_excerpt_detects_charset_and_cuts_on_char_boundary() {
+        assert_eq!(text_excerpt(b"hello", 10), (&b"hello"[..], "utf-8", false));
+        assert_eq!(text_excerpt(b"caf\xe9", 10).1, "windows-1252");
+        assert_eq!(text_excerpt(b"\xff\xfeh\0i\0", 10).1, "utf-16le");
+        // "é" is two bytes; a cut after its first byte drops it.
+        let (cut, charset, truncated) = text_excerpt("aé".as_bytes(), 2);
+        assert_eq!((cut, charset, truncated), (&b"a"[..], "utf-8", true));
+    }
+
+    #[test]
+    fn disposition_quotes_safely() {
+        assert_eq!(
+            disposition("inline", "dir/r\u{e9}sum\"e.txt"),
+            "inline; filename=\"r_sume.txt\"; filename*=UTF-8''r%C3%A9sum%22e.txt"
+        );
+    }
+}
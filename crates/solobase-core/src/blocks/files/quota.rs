@@ -1,8 +1,17 @@
-use wafer_core::clients::database::Record;
-use wafer_run::{context::Context, OutputStream};
+use wafer_core::clients::{config, database::Record};
+use wafer_run::{context::Context, InputStream, OutputStream};
 
 use super::{models::QuotaConfig, repo};
-use crate::{http::err_bad_request, util::RecordExt};
+use crate::{
+    blocks::{
+        errors::{error_json, ErrorCode},
+        jobs::JobError,
+        notifications::NotificationPayload,
+    },
+    events::{Event, QuotaThresholdCrossed},
+    services::Services,
+    util::RecordExt,
+};
 
 /// Map a quota-override row onto a `QuotaConfig`, falling back to the
 /// block defaults field-by-field. Numeric fields accept both JSON numbers
@@ -27,12 +36,19 @@ fn quota_from_record(record: &Record) -> QuotaConfig {
     }
 }
 
+/// The user's quota (their override row, else the block defaults), with the
+/// per-file size capped by the live `SOLOBASE_MAX_UPLOAD_BYTES` so an
+/// operator can tighten uploads for everyone without a restart.
 pub async fn get_user_quota(ctx: &dyn Context, user_id: &str) -> QuotaConfig {
     // Check for user-specific override
-    match repo::quota::find_for_user(ctx, user_id).await {
+    let mut quota = match repo::quota::find_for_user(ctx, user_id).await {
         Ok(record) => quota_from_record(&record),
         Err(_) => QuotaConfig::default(),
+    };
+    if let Some(cap) = crate::runtime_config::load().max_upload_bytes {
+        quota.max_file_size_bytes = quota.max_file_size_bytes.min(cap);
     }
+    quota
 }
 
 /// Total bytes used by `user_id`, computed as `SUM(size)` over the user's
@@ -59,35 +75,138 @@ pub async fn get_user_usage(ctx: &dyn Context, user_id: &str) -> serde_json::Val
     })
 }
 
-pub async fn check_quota(
-    ctx: &dyn Context,
-    user_id: &str,
-    file_size: i64,
-) -> Result<(), OutputStream> {
-    let quota = get_user_quota(ctx, user_id).await;
+/// One reason an upload would be refused, as the upload itself would
+/// answer it.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Refusal {
+    pub code: ErrorCode,
+    pub message: String,
+    pub details: Option<serde_json::Value>,
+}
+
+impl Refusal {
+    pub fn new(code: ErrorCode, message: impl Into<String>) -> Self {
+        Self {
+            code,
+            message: message.into(),
+            details: None,
+        }
+    }
 
+    /// The error response the upload returns for this refusal.
+    pub fn response(&self) -> OutputStream {
+        error_json(self.code, &self.message, self.details.clone())
+    }
+
+    /// `{code, message, details?}` for the upload check.
+    pub fn to_json(&self) -> serde_json::Value {
+        let mut out = serde_json::json!({
+            "code": self.code.as_str(),
+            "message": self.message,
+        });
+        if let Some(details) = &self.details {
+            out["details"] = details.clone();
+        }
+        out
+    }
+}
+
+/// Every limit on `quota` that one more file of `file_size` bytes would
+/// break, given the current usage, in the order an upload reports them.
+pub fn refusals(
+    quota: &QuotaConfig,
+    file_size: i64,
+    used_bytes: i64,
+    file_count: i64,
+) -> Vec<Refusal> {
+    let mut out = Vec::new();
     if file_size > quota.max_file_size_bytes {
-        return Err(err_bad_request(&format!(
-            "File exceeds maximum size of {} bytes",
-            quota.max_file_size_bytes
-        )));
+        out.push(Refusal::new(
+            ErrorCode::FileTooLarge,
+            format!(
+                "File exceeds maximum size of {} bytes",
+                quota.max_file_size_bytes
+            ),
+        ));
     }
 
-    let current_bytes = get_used_bytes(ctx, user_id).await;
-    if current_bytes + file_size > quota.max_storage_bytes {
-        return Err(err_bad_request("Storage quota exceeded"));
+    if used_bytes.saturating_add(file_size) > quota.max_storage_bytes {
+        out.push(Refusal::new(
+            ErrorCode::QuotaExceeded,
+            "Storage quota exceeded",
+        ));
     }
 
-    if quota.max_files_per_bucket > 0
-        && get_file_count(ctx, user_id).await >= quota.max_files_per_bucket
-    {
-        return Err(err_bad_request(&format!(
-            "File count limit reached (max {})",
-            quota.max_files_per_bucket
-        )));
+    if quota.max_files_per_bucket > 0 && file_count >= quota.max_files_per_bucket {
+        out.push(Refusal::new(
+            ErrorCode::QuotaExceeded,
+            format!(
+                "File count limit reached (max {})",
+                quota.max_files_per_bucket
+            ),
+        ));
     }
+    out
+}
 
-    Ok(())
+/// Config key: storage cap, in bytes, for a team without its own
+/// `max_storage_bytes`.
+pub(crate) const TEAM_STORAGE_KEY: &str = "SUPPERS_AI__FILES__TEAM_STORAGE_BYTES";
+/// Default for [`TEAM_STORAGE_KEY`]: 10 GiB.
+pub(crate) const DEFAULT_TEAM_STORAGE: &str = "10737418240";
+
+/// The team's quota: its own `max_storage_bytes`, else
+/// [`TEAM_STORAGE_KEY`]. The per-file and file-count caps are the block
+/// defaults, with the per-file size capped like [`get_user_quota`]. Team
+/// usage never counts against a member's personal quota.
+pub async fn get_team_quota(ctx: &dyn Context, team_id: &str) -> QuotaConfig {
+    let default_bytes = config::get_default(ctx, TEAM_STORAGE_KEY, DEFAULT_TEAM_STORAGE)
+        .await
+        .parse::<i64>()
+        .unwrap_or(10 * QuotaConfig::DEFAULT_MAX_STORAGE_BYTES);
+    let own = match repo::teams::find(ctx, team_id).await {
+        Ok(Some(team)) => team.opt_i64_field("max_storage_bytes"),
+        _ => None,
+    };
+    let mut quota = QuotaConfig {
+        max_storage_bytes: own.unwrap_or(default_bytes),
+        ..QuotaConfig::default()
+    };
+    if let Some(cap) = crate::runtime_config::load().max_upload_bytes {
+        quota.max_file_size_bytes = quota.max_file_size_bytes.min(cap);
+    }
+    quota
+}
+
+/// Usage counted toward `team_id`, in the shape of [`get_user_usage`].
+pub async fn get_team_usage(ctx: &dyn Context, team_id: &str) -> serde_json::Value {
+    serde_json::json!({
+        "total_bytes": repo::objects::sum_size_for_team(ctx, team_id).await.unwrap_or(0.0) as i64,
+        "file_count": repo::objects::count_for_team(ctx, team_id).await.unwrap_or(0),
+    })
+}
+
+/// The quota governing uploads into a bucket of `team_id` (`""` for the
+/// uploader's personal storage) with that space's current used bytes and
+/// file count.
+pub async fn space_usage(
+    ctx: &dyn Context,
+    user_id: &str,
+    team_id: &str,
+) -> (QuotaConfig, i64, i64) {
+    if team_id.is_empty() {
+        let quota = get_user_quota(ctx, user_id).await;
+        let used = get_used_bytes(ctx, user_id).await;
+        (quota, used, get_file_count(ctx, user_id).await)
+    } else {
+        let quota = get_team_quota(ctx, team_id).await;
+        let usage = get_team_usage(ctx, team_id).await;
+        (
+            quota,
+            usage["total_bytes"].as_i64().unwrap_or(0),
+            usage["file_count"].as_i64().unwrap_or(0),
+        )
+    }
 }
 
 /// Sweep `pending`-status object rows older than `older_than_seconds` for
@@ -101,12 +220,255 @@ pub async fn check_quota(
 /// inside that window, and anything still pending afterward is almost
 /// certainly an orphan.
 pub async fn sweep_stale_pending(ctx: &dyn Context, user_id: &str, older_than_seconds: i64) {
-    let cutoff = (chrono::Utc::now() - chrono::Duration::seconds(older_than_seconds)).to_rfc3339();
+    let cutoff = crate::util::format_rfc3339(
+        crate::clock::now() - chrono::Duration::seconds(older_than_seconds),
+    );
     if let Err(e) = repo::objects::delete_stale_pending(ctx, user_id, &cutoff).await {
         tracing::warn!(error = %e, user_id = %user_id, "failed to sweep stale pending uploads");
     }
 }
 
+/// Config key: comma-separated usage percentages that trigger a quota
+/// notification (e.g. `80,95`).
+pub(crate) const THRESHOLDS_KEY: &str = "SUPPERS_AI__FILES__QUOTA_ALERT_THRESHOLDS";
+/// Default for [`THRESHOLDS_KEY`].
+pub(crate) const DEFAULT_THRESHOLDS: &str = "80,95";
+/// Config key: comma-separated admin addresses for the daily digest of
+/// users over the highest threshold. Empty disables the digest.
+pub(crate) const DIGEST_RECIPIENTS_KEY: &str = "SUPPERS_AI__FILES__QUOTA_DIGEST_RECIPIENTS";
+
+/// Parse a threshold list, keeping percentages in `1..=100`, sorted and
+/// deduplicated. Junk entries are skipped rather than failing the upload
+/// path that reads them.
+pub(crate) fn parse_thresholds(raw: &str) -> Vec<i64> {
+    let mut out: Vec<i64> = raw
+        .split(',')
+        .filter_map(|t| t.trim().trim_end_matches('%').parse::<i64>().ok())
+        .filter(|t| (1..=100).contains(t))
+        .collect();
+    out.sort_unstable();
+    out.dedup();
+    out
+}
+
+/// Configured notification thresholds (see [`THRESHOLDS_KEY`]).
+pub async fn alert_thresholds(ctx: &dyn Context) -> Vec<i64> {
+    parse_thresholds(&config::get_default(ctx, THRESHOLDS_KEY, DEFAULT_THRESHOLDS).await)
+}
+
+/// The highest threshold that `used` out of `limit` bytes has reached, if
+/// any. An unlimited (non-positive) cap never crosses a threshold.
+pub(crate) fn highest_crossed(used: i64, limit: i64, thresholds: &[i64]) -> Option<i64> {
+    if limit <= 0 {
+        return None;
+    }
+    let pct = (used as i128 * 100 / limit as i128) as i64;
+    thresholds.iter().rev().copied().find(|t| pct >= *t)
+}
+
+/// Start of the quota reset period containing `now`, as an RFC 3339 date
+/// (periods are aligned to the Unix epoch). `reset_period_days <= 0` means
+/// the quota never resets, so every notification belongs to the one
+/// lifetime period `""`.
+pub(crate) fn period_start(reset_period_days: i64, now: chrono::DateTime<chrono::Utc>) -> String {
+    if reset_period_days <= 0 {
+        return String::new();
+    }
+    let days = now.timestamp().div_euclid(86_400);
+    let start_day = days - days.rem_euclid(reset_period_days);
+    chrono::DateTime::from_timestamp(start_day * 86_400, 0)
+        .map(|d| d.format("%Y-%m-%d").to_string())
+        .unwrap_or_default()
+}
+
+/// Job type for [`notify_thresholds`], enqueued after each upload so the
+/// lookups and notification never hold up the upload response.
+pub const NOTIFY_JOB: &str = "files.quota.notify";
+
+/// Notification type posted when a user crosses a quota threshold.
+pub const NOTIFICATION_TYPE: &str = QuotaThresholdCrossed::NAME;
+
+/// Run a [`NOTIFY_JOB`]: `{"user_id": …}`. Safe to repeat — each threshold
+/// notifies at most once per period. (Jobs queued by older versions also
+/// carry an `email`, which is ignored.)
+pub async fn run_notify_job(ctx: &dyn Context, input: InputStream) -> Result<(), JobError> {
+    #[derive(serde::Deserialize)]
+    struct Payload {
+        user_id: String,
+    }
+    let raw = input.collect_to_bytes().await;
+    let payload: Payload = serde_json::from_slice(&raw)
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+    if payload.user_id.is_empty() {
+        return Err(JobError::permanent("missing user_id"));
+    }
+    notify_thresholds(ctx, &payload.user_id).await;
+    Ok(())
+}
+
+/// Record any quota threshold `user_id` has newly crossed and tell them.
+///
+/// Run as a [`NOTIFY_JOB`] after a successful upload. Each configured
+/// threshold notifies at most once per reset period; the ledger rows in
+/// `repo::notifications` are that guarantee and the admin digest's source.
+/// When one upload jumps past several thresholds, a row is written for
+/// each (so the lower one doesn't fire later) but only the highest reaches
+/// the user's inbox — and, unless they turned it off, their email.
+/// Best-effort throughout: nothing here may fail the upload that triggered
+/// it.
+pub async fn notify_thresholds(ctx: &dyn Context, user_id: &str) {
+    let thresholds = alert_thresholds(ctx).await;
+    let quota = get_user_quota(ctx, user_id).await;
+    let used = get_used_bytes(ctx, user_id).await;
+    let Some(highest) = highest_crossed(used, quota.max_storage_bytes, &thresholds) else {
+        return;
+    };
+    let period = period_start(quota.reset_period_days, crate::clock::now());
+
+    let mut newly_crossed = None;
+    for threshold in thresholds.iter().copied().filter(|t| *t <= highest) {
+        match repo::notifications::exists_for_period(ctx, user_id, threshold, &period).await {
+            Ok(true) => continue,
+            Ok(false) => {}
+            Err(e) => {
+                tracing::warn!(error = %e, user_id = %user_id, "quota notification lookup failed");
+                return;
+            }
+        }
+        let row = repo::notifications::NewThreshold {
+            user_id,
+            threshold,
+            period_start: &period,
+            used_bytes: used,
+            limit_bytes: quota.max_storage_bytes,
+        };
+        // Losing the unique-index race to a concurrent upload means the
+        // other request already notified — not an error worth surfacing.
+        if repo::notifications::insert_threshold(ctx, row)
+            .await
+            .is_ok()
+        {
+            newly_crossed = Some(threshold);
+        }
+    }
+
+    let Some(threshold) = newly_crossed else {
+        return;
+    };
+    let payload = NotificationPayload {
+        title: format!("You've used {threshold}% of your storage"),
+        body: "Uploads will be rejected once the quota is full. Delete files you no longer \
+               need or ask an administrator for more space."
+            .to_string(),
+        email_by_default: true,
+        email_template: Some(serde_json::json!({
+            "template": "quota_threshold",
+            "threshold": threshold,
+        })),
+    };
+    let event = QuotaThresholdCrossed {
+        threshold,
+        used_bytes: used,
+        limit_bytes: quota.max_storage_bytes,
+        url: "/b/cloudstorage/".to_string(),
+    };
+    if let Err(e) = Services::new(ctx, "suppers-ai/files")
+        .notifications()
+        .notify_event(user_id, &event, payload)
+        .await
+    {
+        tracing::warn!(error = %e, user_id = %user_id, "quota notification failed");
+    }
+}
+
+/// Name of the built-in schedule that sends the admin digest.
+pub const DIGEST_SCHEDULE: &str = "files.quota_digest";
+/// Job type for [`send_admin_digest`], queued daily by [`DIGEST_SCHEDULE`].
+pub const DIGEST_JOB: &str = "files.quota.digest";
+
+/// Run a [`DIGEST_JOB`]. Safe to repeat: a second delivery finds no
+/// crossings newer than the digest the first one recorded.
+pub async fn run_digest_job(ctx: &dyn Context) -> Result<(), JobError> {
+    send_admin_digest(ctx).await;
+    Ok(())
+}
+
+/// Email the admin digest of users who crossed the highest threshold since
+/// the previous digest. Returns the number of users summarized (0 when
+/// disabled or there is nothing to report).
+pub async fn send_admin_digest(ctx: &dyn Context) -> usize {
+    let recipients = config::get_default(ctx, DIGEST_RECIPIENTS_KEY, "").await;
+    let recipients: Vec<&str> = recipients
+        .split(',')
+        .map(str::trim)
+        .filter(|r| !r.is_empty())
+        .collect();
+    let Some(&threshold) = alert_thresholds(ctx).await.last() else {
+        return 0;
+    };
+    if recipients.is_empty() {
+        return 0;
+    }
+
+    let last = repo::notifications::last_digest_at(ctx)
+        .await
+        .ok()
+        .flatten();
+    let since = last.unwrap_or_default();
+    let crossings =
+        match repo::notifications::list_crossings_since(ctx, threshold, &since, 500).await {
+            Ok(list) => list.records,
+            Err(e) => {
+                tracing::warn!(error = %e, "quota digest: listing crossings failed");
+                return 0;
+            }
+        };
+    if crossings.is_empty() {
+        return 0;
+    }
+
+    let mut rows = String::new();
+    for r in &crossings {
+        rows.push_str(&format!(
+            "<tr><td>{}</td><td>{}</td><td>{} / {}</td></tr>",
+            r.str_field("user_id"),
+            crate::util::format_timestamp(r.str_field("created_at")),
+            crate::util::format_bytes(r.i64_field("used_bytes")),
+            crate::util::format_bytes(r.i64_field("limit_bytes")),
+        ));
+    }
+    let html = format!(
+        "<p>{} user(s) crossed {threshold}% of their storage quota since the last digest.</p>\
+         <table><tr><th>User</th><th>Crossed at</th><th>Usage</th></tr>{rows}</table>",
+        crossings.len()
+    );
+    for to in &recipients {
+        send_email(
+            ctx,
+            "email.send",
+            serde_json::json!({
+                "to": to,
+                "subject": format!("Storage quota digest: {} user(s) over {threshold}%", crossings.len()),
+                "html": html,
+            }),
+        )
+        .await;
+    }
+    if let Err(e) = repo::notifications::insert_digest(ctx, threshold).await {
+        tracing::warn!(error = %e, "quota digest: recording digest failed");
+    }
+    crossings.len()
+}
+
+/// Fire one `suppers-ai/email` op. Delivery is best-effort: a failure is
+/// logged and swallowed.
+async fn send_email(ctx: &dyn Context, kind: &str, body: serde_json::Value) {
+    Services::new(ctx, "suppers-ai/files")
+        .mailer()
+        .send(kind, body)
+        .await;
+}
+
 #[cfg(test)]
 mod tests {
     use std::collections::HashMap;
@@ -126,6 +488,43 @@ mod tests {
         }
     }
 
+    /// The messages of every refusal for a `size`-byte upload into the
+    /// space of `user_id` / `team_id`.
+    async fn refused(ctx: &TestContext, user_id: &str, team_id: &str, size: i64) -> Vec<String> {
+        let (quota, used, count) = space_usage(ctx, user_id, team_id).await;
+        refusals(&quota, size, used, count)
+            .into_iter()
+            .map(|r| r.message)
+            .collect()
+    }
+
+    #[test]
+    fn refusals_list_every_broken_limit_in_upload_order() {
+        let quota = QuotaConfig {
+            max_storage_bytes: 4096,
+            max_file_size_bytes: 2048,
+            max_files_per_bucket: 2,
+            ..QuotaConfig::default()
+        };
+        assert!(refusals(&quota, 1024, 0, 0).is_empty());
+        let codes: Vec<_> = refusals(&quota, 3000, 2048, 2)
+            .iter()
+            .map(|r| r.code)
+            .collect();
+        assert_eq!(
+            codes,
+            vec![
+                ErrorCode::FileTooLarge,
+                ErrorCode::QuotaExceeded,
+                ErrorCode::QuotaExceeded
+            ]
+        );
+        assert_eq!(
+            refusals(&quota, 10, 0, 2)[0].message,
+            "File count limit reached (max 2)"
+        );
+    }
+
     /// Regression: the SQLite service returns TEXT-stored columns as JSON
     /// strings. `get_user_quota` used to read overrides with a bare
     /// `as_i64()`, so a TEXT-stored `max_storage_bytes` override silently
@@ -229,20 +628,115 @@ mod tests {
         assert_eq!(get_file_count(&ctx, "u1").await, 2);
     }
 
+    #[tokio::test]
+    async fn team_uploads_count_toward_the_team_not_the_uploader() {
+        let ctx = TestContext::with_files().await;
+        let team = repo::teams::insert(&ctx, "Design", "u1").await.unwrap();
+        repo::teams::set_max_storage_bytes(&ctx, &team.id, Some(3000))
+            .await
+            .unwrap();
+        for (key, size, team_id) in [("a", 1024, ""), ("b", 2048, team.id.as_str())] {
+            let mut row: HashMap<String, serde_json::Value> = HashMap::new();
+            row.insert("bucket".into(), json!("photos"));
+            row.insert("key".into(), json!(key));
+            row.insert("size".into(), json!(size));
+            row.insert("uploaded_by".into(), json!("u1"));
+            row.insert("team_id".into(), json!(team_id));
+            repo::objects::seed(&ctx, row).await.expect("seed");
+        }
+
+        assert_eq!(get_used_bytes(&ctx, "u1").await, 1024);
+        assert_eq!(get_file_count(&ctx, "u1").await, 1);
+        assert_eq!(get_team_usage(&ctx, &team.id).await["total_bytes"], 2048);
+        assert!(refused(&ctx, "u1", &team.id, 512).await.is_empty());
+        assert_eq!(
+            refused(&ctx, "u1", &team.id, 1024).await,
+            vec!["Storage quota exceeded"]
+        );
+        assert_eq!(
+            get_team_quota(&ctx, "no-override").await.max_storage_bytes,
+            10 * QuotaConfig::DEFAULT_MAX_STORAGE_BYTES
+        );
+    }
+
     /// End-to-end: an override row caps enforcement, so a file that fits
     /// the default 1 GiB quota but not the override is rejected.
     #[tokio::test]
-    async fn check_quota_enforces_override_storage_cap() {
+    async fn override_storage_cap_is_enforced() {
         let ctx = TestContext::with_files().await;
         let mut row: HashMap<String, serde_json::Value> = HashMap::new();
         row.insert("user_id".into(), json!("u1"));
         row.insert("max_storage_bytes".into(), json!(2048));
         repo::quota::seed(&ctx, row).await.expect("seed quota");
 
-        assert!(check_quota(&ctx, "u1", 1024).await.is_ok());
+        assert!(refused(&ctx, "u1", "", 1024).await.is_empty());
         assert!(
-            check_quota(&ctx, "u1", 4096).await.is_err(),
+            !refused(&ctx, "u1", "", 4096).await.is_empty(),
             "file above the override cap must be rejected"
         );
     }
+
+    #[test]
+    fn parse_thresholds_sorts_dedups_and_skips_junk() {
+        assert_eq!(parse_thresholds("95, 80%,80,abc,0,150"), vec![80, 95]);
+        assert!(parse_thresholds("").is_empty());
+    }
+
+    #[test]
+    fn highest_crossed_picks_the_top_reached_threshold() {
+        let t = [80, 95];
+        assert_eq!(highest_crossed(790, 1000, &t), None);
+        assert_eq!(highest_crossed(800, 1000, &t), Some(80));
+        assert_eq!(highest_crossed(999, 1000, &t), Some(95));
+        assert_eq!(highest_crossed(5000, 0, &t), None, "no cap, no threshold");
+    }
+
+    #[test]
+    fn period_start_aligns_to_reset_period() {
+        let now = chrono::DateTime::parse_from_rfc3339("2026-10-15T12:00:00Z")
+            .unwrap()
+            .with_timezone(&chrono::Utc);
+        assert_eq!(period_start(0, now), "");
+        assert_eq!(period_start(1, now), "2026-10-15");
+        // Day 20_741 since the epoch; 30-day periods start on multiples of 30.
+        assert_eq!(period_start(30, now), "2026-10-04");
+        assert_eq!(
+            period_start(30, now + chrono::Duration::days(19)),
+            "2026-11-03"
+        );
+    }
+
+    /// A threshold notifies once per period: a second upload past the same
+    /// threshold adds no row, and jumping straight past 95% records 80% too
+    /// so it can't fire afterwards. Only the highest reaches the inbox.
+    #[tokio::test]
+    async fn notify_thresholds_records_each_threshold_once() {
+        let ctx = TestContext::with_files().await;
+        let mut row: HashMap<String, serde_json::Value> = HashMap::new();
+        row.insert("user_id".into(), json!("u1"));
+        row.insert("max_storage_bytes".into(), json!(1000));
+        repo::quota::seed(&ctx, row).await.expect("seed quota");
+        let mut obj: HashMap<String, serde_json::Value> = HashMap::new();
+        obj.insert("bucket".into(), json!("photos"));
+        obj.insert("key".into(), json!("a"));
+        obj.insert("size".into(), json!(960));
+        obj.insert("uploaded_by".into(), json!("u1"));
+        repo::objects::seed(&ctx, obj).await.expect("seed object");
+
+        notify_thresholds(&ctx, "u1").await;
+        notify_thresholds(&ctx, "u1").await;
+
+        for threshold in [80, 95] {
+            assert!(
+                repo::notifications::exists_for_period(&ctx, "u1", threshold, "")
+                    .await
+                    .expect("lookup"),
+                "{threshold}% recorded"
+            );
+        }
+        let inbox = crate::blocks::notifications::unread_count(&ctx, "u1")
+            .await
+            .expect("count");
+        assert_eq!(inbox, 1);
+    }
 }
@@ -0,0 +1,337 @@
+//! Row-level access over `suppers_ai__files__object_acls`.
+//!
+//! One row grants `grantee_user_id` `read` or `write` on a bucket `path`
+//! (`''` = whole bucket, `dir/` = folder, or an exact key). The
+//! `(bucket, path, grantee_user_id)` triple is unique, so re-granting
+//! updates the permission in place ([`upsert`]). Which paths cover a key
+//! is policy and lives in `files::acl`.
+//!
+//! A grant offered to an address with no account is stored pending
+//! ([`upsert_pending`]): empty `grantee_user_id`, the lowercased
+//! `grantee_email`, and no access until [`attach`] hands it to the account
+//! that confirms that address. `status` tracks the grantee's answer.
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, WaferError};
+
+use crate::util::RecordExt;
+
+/// Object ACL table — one row per (bucket, path, grantee) grant.
+pub const TABLE: &str = "suppers_ai__files__object_acls";
+
+/// Offered to an address with no account yet; grants nothing.
+pub const STATUS_PENDING: &str = "pending";
+/// Held by an account that hasn't accepted or declined it yet.
+pub const STATUS_RECEIVED: &str = "received";
+/// Accepted by the grantee.
+pub const STATUS_ACCEPTED: &str = "accepted";
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(value.to_string()),
+    }
+}
+
+/// Insert payload for [`upsert`].
+#[derive(Debug, Clone, Copy)]
+pub struct NewGrant<'a> {
+    pub bucket: &'a str,
+    pub path: &'a str,
+    pub grantee_user_id: &'a str,
+    /// `read` or `write`.
+    pub permission: &'a str,
+    pub granted_by: &'a str,
+}
+
+/// Create the grant (awaiting the grantee's answer), or update the
+/// permission of an existing grant for the same `(bucket, path, grantee)`.
+/// Returns the stored row.
+pub async fn upsert(ctx: &dyn Context, grant: NewGrant<'_>) -> Result<Record, WaferError> {
+    let existing = db::list_all(
+        ctx,
+        TABLE,
+        vec![
+            eq("bucket", grant.bucket),
+            eq("path", grant.path),
+            eq("grantee_user_id", grant.grantee_user_id),
+        ],
+    )
+    .await?;
+    if let Some(row) = existing.into_iter().next() {
+        let data = crate::util::json_map(serde_json::json!({
+            "permission": grant.permission,
+            "granted_by": grant.granted_by,
+            "updated_at": crate::util::now_rfc3339(),
+        }));
+        return db::update(ctx, TABLE, &row.id, data).await;
+    }
+    let data = crate::util::json_map(serde_json::json!({
+        "bucket": grant.bucket,
+        "path": grant.path,
+        "grantee_user_id": grant.grantee_user_id,
+        "permission": grant.permission,
+        "granted_by": grant.granted_by,
+        "status": STATUS_RECEIVED,
+    }));
+    db::create(ctx, TABLE, data).await
+}
+
+/// Insert payload for [`upsert_pending`].
+#[derive(Debug, Clone, Copy)]
+pub struct NewPendingGrant<'a> {
+    pub bucket: &'a str,
+    pub path: &'a str,
+    /// Lowercased; matched exactly by [`list_pending_for_email`].
+    pub grantee_email: &'a str,
+    /// `read` or `write`.
+    pub permission: &'a str,
+    pub granted_by: &'a str,
+}
+
+/// Create a pending grant for an address with no account, or update the
+/// permission of the one already pending for the same `(bucket, path,
+/// email)`. Returns the stored row.
+pub async fn upsert_pending(
+    ctx: &dyn Context,
+    grant: NewPendingGrant<'_>,
+) -> Result<Record, WaferError> {
+    let existing = db::list_all(
+        ctx,
+        TABLE,
+        vec![
+            eq("bucket", grant.bucket),
+            eq("path", grant.path),
+            eq("grantee_user_id", ""),
+            eq("grantee_email", grant.grantee_email),
+        ],
+    )
+    .await?;
+    if let Some(row) = existing.into_iter().next() {
+        let data = crate::util::json_map(serde_json::json!({
+            "permission": grant.permission,
+            "granted_by": grant.granted_by,
+            "updated_at": crate::util::now_rfc3339(),
+        }));
+        return db::update(ctx, TABLE, &row.id, data).await;
+    }
+    let data = crate::util::json_map(serde_json::json!({
+        "bucket": grant.bucket,
+        "path": grant.path,
+        "grantee_user_id": "",
+        "grantee_email": grant.grantee_email,
+        "permission": grant.permission,
+        "granted_by": grant.granted_by,
+        "status": STATUS_PENDING,
+    }));
+    db::create(ctx, TABLE, data).await
+}
+
+/// Pending grants offered to exactly `email` (already lowercased).
+pub async fn list_pending_for_email(
+    ctx: &dyn Context,
+    email: &str,
+) -> Result<Vec<Record>, WaferError> {
+    db::list_all(
+        ctx,
+        TABLE,
+        vec![eq("grantee_user_id", ""), eq("grantee_email", email)],
+    )
+    .await
+}
+
+/// Hand a pending grant to `user_id`; it then awaits their answer.
+pub async fn attach(ctx: &dyn Context, id: &str, user_id: &str) -> Result<Record, WaferError> {
+    let mut data = crate::util::json_map(serde_json::json!({
+        "grantee_user_id": user_id,
+        "status": STATUS_RECEIVED,
+    }));
+    crate::util::stamp_updated(&mut data);
+    db::update(ctx, TABLE, id, data).await
+}
+
+/// Record the grantee's acceptance.
+pub async fn accept(ctx: &dyn Context, id: &str) -> Result<Record, WaferError> {
+    let mut data = crate::util::json_map(serde_json::json!({ "status": STATUS_ACCEPTED }));
+    crate::util::stamp_updated(&mut data);
+    db::update(ctx, TABLE, id, data).await
+}
+
+/// Look up a grant by its primary `id`.
+pub async fn find_by_id(ctx: &dyn Context, id: &str) -> Result<Record, WaferError> {
+    db::get(ctx, TABLE, id).await
+}
+
+/// Hard-delete a grant by id.
+pub async fn delete(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
+    db::delete(ctx, TABLE, id).await
+}
+
+/// Every grant on `bucket`, optionally narrowed to one exact `path`,
+/// ordered by path (the owner's "who has access" view).
+pub async fn list_for_bucket(
+    ctx: &dyn Context,
+    bucket: &str,
+    path: Option<&str>,
+) -> Result<Vec<Record>, WaferError> {
+    let mut filters = vec![eq("bucket", bucket)];
+    if let Some(path) = path {
+        filters.push(eq("path", path));
+    }
+    db::list_sorted(
+        ctx,
+        TABLE,
+        filters,
+        vec![SortField {
+            field: "path".to_string(),
+            desc: false,
+        }],
+    )
+    .await
+}
+
+/// Grants held by `user_id`, newest first (the "Shared with me" listing).
+pub async fn list_for_grantee(
+    ctx: &dyn Context,
+    user_id: &str,
+    limit: i64,
+    offset: i64,
+) -> Result<RecordList, WaferError> {
+    let opts = ListOptions {
+        filters: vec![eq("grantee_user_id", user_id)],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit,
+        offset,
+        ..Default::default()
+    };
+    db::list(ctx, TABLE, &opts).await
+}
+
+/// Every grant `user_id` holds on `bucket`, ordered by path (the grantee's
+/// folder tree).
+pub async fn list_for_grantee_in_bucket(
+    ctx: &dyn Context,
+    bucket: &str,
+    user_id: &str,
+) -> Result<Vec<Record>, WaferError> {
+    db::list_sorted(
+        ctx,
+        TABLE,
+        vec![eq("bucket", bucket), eq("grantee_user_id", user_id)],
+        vec![SortField {
+            field: "path".to_string(),
+            desc: false,
+        }],
+    )
+    .await
+}
+
+/// `user_id`'s grants on `bucket` whose `path` is one of `paths` — the
+/// candidates that could cover a key (see `files::acl::covering_paths`).
+pub async fn find_covering(
+    ctx: &dyn Context,
+    bucket: &str,
+    user_id: &str,
+    paths: &[String],
+) -> Result<Vec<Record>, WaferError> {
+    let values = paths
+        .iter()
+        .map(|p| serde_json::Value::String(p.clone()))
+        .collect();
+    db::list_all(
+        ctx,
+        TABLE,
+        vec![
+            eq("bucket", bucket),
+            eq("grantee_user_id", user_id),
+            Filter {
+                field: "path".to_string(),
+                operator: FilterOp::In,
+                value: serde_json::Value::Array(values),
+            },
+        ],
+    )
+    .await
+}
+
+/// Grants held by `user_id` that they haven't answered yet, newest first.
+pub async fn list_received(ctx: &dyn Context, user_id: &str) -> Result<Vec<Record>, WaferError> {
+    db::list_sorted(
+        ctx,
+        TABLE,
+        vec![
+            eq("grantee_user_id", user_id),
+            eq("status", STATUS_RECEIVED),
+        ],
+        vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+    )
+    .await
+}
+
+/// Delete every grant `user_id` holds (account purge).
+pub async fn delete_for_grantee(ctx: &dyn Context, user_id: &str) -> Result<(), WaferError> {
+    db::delete_by_filters(ctx, TABLE, vec![eq("grantee_user_id", user_id)]).await
+}
+
+/// Delete every grant on `bucket` (bucket deletion).
+pub async fn delete_for_bucket(ctx: &dyn Context, bucket: &str) -> Result<(), WaferError> {
+    db::delete_by_filters(ctx, TABLE, vec![eq("bucket", bucket)]).await
+}
+
+/// Delete grants on exactly `path` in `bucket` (object deletion). Grants on
+/// enclosing folders are untouched.
+pub async fn delete_for_path(
+    ctx: &dyn Context,
+    bucket: &str,
+    path: &str,
+) -> Result<(), WaferError> {
+    db::delete_by_filters(ctx, TABLE, vec![eq("bucket", bucket), eq("path", path)]).await
+}
+
+/// Re-point grants on exactly `from` to `to` (object move).
+pub async fn rename_path(
+    ctx: &dyn Context,
+    bucket: &str,
+    from: &str,
+    to: &str,
+) -> Result<(), WaferError> {
+    let mut data = crate::util::json_map(serde_json::json!({ "path": to }));
+    crate::util::stamp_updated(&mut data);
+    db::update_by_filters(
+        ctx,
+        TABLE,
+        vec![eq("bucket", bucket), eq("path", from)],
+        data,
+    )
+    .await
+    .map(|_| ())
+}
+
+/// Re-point grants on the folder `from` and every folder beneath it to the
+/// same place under `to` (folder move). Grants on exact keys move with
+/// their objects via [`rename_path`].
+pub async fn rename_folder(
+    ctx: &dyn Context,
+    bucket: &str,
+    from: &str,
+    to: &str,
+) -> Result<(), WaferError> {
+    for grant in list_for_bucket(ctx, bucket, None).await? {
+        let path = grant.str_field("path");
+        if let Some(rest) = path.strip_prefix(from).filter(|_| path.ends_with('/')) {
+            let mut data =
+                crate::util::json_map(serde_json::json!({ "path": format!("{to}{rest}") }));
+            crate::util::stamp_updated(&mut data);
+            db::update(ctx, TABLE, &grant.id, data).await?;
+        }
+    }
+    Ok(())
+}
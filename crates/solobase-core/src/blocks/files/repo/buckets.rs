@@ -6,10 +6,18 @@
 //! [`find_owned`] is *the* ownership lookup every access-control caller
 //! derives from (`storage::bucket_owned_by` → `is_bucket_access_denied`,
 //! the SSR portal's owner check, and the share-creation path).
+//!
+//! Every write goes through this module and invalidates the cached
+//! [`list_visible`] results (see [`crate::cache`]).
 
 use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
 use wafer_core::clients::database::{self as db, Record, RecordList};
-use wafer_run::{context::Context, WaferError};
+use wafer_run::{context::Context, ErrorCode, WaferError};
+
+use crate::{
+    cache::{self, LruCache},
+    util::RecordExt,
+};
 
 /// Buckets table — user-created storage containers (one row per bucket).
 pub const TABLE: &str = "suppers_ai__files__buckets";
@@ -22,6 +30,16 @@ fn created_by_filter(user_id: &str) -> Filter {
     }
 }
 
+/// Matches personal buckets: a team bucket belongs to its team, not to the
+/// member who created it.
+fn personal_filter() -> Filter {
+    Filter {
+        field: "team_id".to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(String::new()),
+    }
+}
+
 /// Look up the bucket named `name` owned by `user_id`. Returns `Ok(None)`
 /// when no such row exists (unknown bucket OR a bucket owned by someone
 /// else — callers cannot distinguish the two, by design).
@@ -47,22 +65,97 @@ pub async fn find_owned(
     Ok(records.into_iter().next())
 }
 
-/// List bucket rows visible to `owner`: `Some(user_id)` restricts to that
-/// user's buckets (`created_by` filter), `None` returns every bucket (the
-/// admin view). Unsorted, unpaginated — mirrors the JSON API listing.
+/// List bucket rows visible to `owner`: `Some(user_id)` returns that
+/// user's personal buckets plus every bucket marked `visible_to_users`
+/// (team buckets are listed per team by [`list_for_team`]), `None`
+/// returns every bucket (the admin view). Unsorted, unpaginated — mirrors
+/// the JSON API listing. Cached per owner.
 pub async fn list_visible(
     ctx: &dyn Context,
     owner: Option<&str>,
 ) -> Result<Vec<Record>, WaferError> {
-    let filters = match owner {
-        Some(user_id) => vec![created_by_filter(user_id)],
-        None => Vec::new(),
+    VISIBLE
+        .get_or_try_load(owner.map(str::to_string), || load_visible(ctx, owner))
+        .await
+}
+
+/// [`list_visible`] results by owner. Any bucket write can change any
+/// owner's list (a bucket shown to every user), so writes drop them all.
+static VISIBLE: LruCache<Option<String>, Vec<Record>> = LruCache::new(
+    "files.visible_buckets",
+    &[TABLE],
+    512,
+    std::time::Duration::from_secs(60),
+);
+
+async fn load_visible(ctx: &dyn Context, owner: Option<&str>) -> Result<Vec<Record>, WaferError> {
+    let Some(user_id) = owner else {
+        return db::list_all(ctx, TABLE, Vec::new()).await;
     };
-    db::list_all(ctx, TABLE, filters).await
+    // Filters are AND-only, so "owned OR shared" is two reads merged by name.
+    let mut records = db::list_all(
+        ctx,
+        TABLE,
+        vec![created_by_filter(user_id), personal_filter()],
+    )
+    .await?;
+    for record in db::list_all(ctx, TABLE, vec![visible_to_users_filter()]).await? {
+        if !records
+            .iter()
+            .any(|r| r.str_field("name") == record.str_field("name"))
+        {
+            records.push(record);
+        }
+    }
+    Ok(records)
+}
+
+fn visible_to_users_filter() -> Filter {
+    Filter {
+        field: "visible_to_users".to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::json!(1),
+    }
+}
+
+/// Whether bucket `name` is marked `visible_to_users` (unknown buckets are
+/// not).
+pub async fn is_visible_to_users(ctx: &dyn Context, name: &str) -> Result<bool, WaferError> {
+    match db::get_by_field(ctx, TABLE, "name", serde_json::json!(name)).await {
+        Ok(r) => Ok(r.bool_field("visible_to_users")),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(false),
+        Err(e) => Err(e),
+    }
+}
+
+/// Set bucket `name`'s `visible_to_users` flag. Returns the number of rows
+/// updated (0 for an unknown bucket).
+pub async fn set_visible_to_users(
+    ctx: &dyn Context,
+    name: &str,
+    visible: bool,
+) -> Result<i64, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({
+        "visible_to_users": i64::from(visible),
+        "updated_at": crate::util::now_rfc3339(),
+    }));
+    let result = db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![Filter {
+            field: "name".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(name.to_string()),
+        }],
+        data,
+    )
+    .await;
+    cache::invalidate_table(TABLE);
+    result
 }
 
-/// List `user_id`'s buckets sorted by `name` ascending (the SSR bucket-list
-/// page order).
+/// List `user_id`'s personal buckets sorted by `name` ascending (the SSR
+/// bucket-list page order).
 pub async fn list_owned_sorted(
     ctx: &dyn Context,
     user_id: &str,
@@ -70,7 +163,7 @@ pub async fn list_owned_sorted(
     db::list_sorted(
         ctx,
         TABLE,
-        vec![created_by_filter(user_id)],
+        vec![created_by_filter(user_id), personal_filter()],
         vec![SortField {
             field: "name".to_string(),
             desc: false,
@@ -93,31 +186,171 @@ pub async fn list_recent(ctx: &dyn Context, limit: i64) -> Result<RecordList, Wa
 }
 
 /// Insert a bucket row (`created_at` stamped with
-/// [`crate::util::now_rfc3339`]) and return it.
+/// [`crate::util::now_rfc3339`]) and return it. A non-empty `team_id`
+/// makes it a team bucket.
 pub async fn insert(
     ctx: &dyn Context,
     name: &str,
     public: bool,
     created_by: &str,
+    team_id: &str,
 ) -> Result<Record, WaferError> {
     let data = crate::util::json_map(serde_json::json!({
         "name": name,
         "public": public,
         "created_by": created_by,
+        "team_id": team_id,
         "created_at": crate::util::now_rfc3339(),
     }));
-    db::create(ctx, TABLE, data).await
+    let result = db::create(ctx, TABLE, data).await;
+    cache::invalidate_table(TABLE);
+    result
+}
+
+/// The team that owns bucket `name`, or `None` for a personal (or
+/// unknown) bucket.
+pub async fn team_of(ctx: &dyn Context, name: &str) -> Result<Option<String>, WaferError> {
+    match db::get_by_field(ctx, TABLE, "name", serde_json::json!(name)).await {
+        Ok(r) => Ok(Some(r.str_field("team_id").to_string()).filter(|t| !t.is_empty())),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// The user who owns personal bucket `name`, or `None` for a team (or
+/// unknown) bucket.
+pub async fn owner_of(ctx: &dyn Context, name: &str) -> Result<Option<String>, WaferError> {
+    match db::get_by_field(ctx, TABLE, "name", serde_json::json!(name)).await {
+        Ok(r) if r.str_field("team_id").is_empty() => {
+            Ok(Some(r.str_field("created_by").to_string()).filter(|u| !u.is_empty()))
+        }
+        Ok(_) => Ok(None),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// Buckets owned by `team_id`, sorted by name.
+pub async fn list_for_team(ctx: &dyn Context, team_id: &str) -> Result<Vec<Record>, WaferError> {
+    db::list_sorted(
+        ctx,
+        TABLE,
+        vec![Filter {
+            field: "team_id".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(team_id.to_string()),
+        }],
+        vec![SortField {
+            field: "name".to_string(),
+            desc: false,
+        }],
+    )
+    .await
+}
+
+/// Whether any bucket (any owner) is named `name`. Bucket names map 1:1 to
+/// storage folders, so they are unique across users.
+pub async fn name_exists(ctx: &dyn Context, name: &str) -> Result<bool, WaferError> {
+    let filters = vec![Filter {
+        field: "name".to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(name.to_string()),
+    }];
+    db::count(ctx, TABLE, &filters).await.map(|n| n > 0)
 }
 
 /// Delete the bucket row named `name` (bucket names are unique).
 pub async fn delete_by_name(ctx: &dyn Context, name: &str) -> Result<(), WaferError> {
-    db::delete_by_field(
+    let result = db::delete_by_field(
         ctx,
         TABLE,
         "name",
         serde_json::Value::String(name.to_string()),
     )
-    .await
+    .await;
+    cache::invalidate_table(TABLE);
+    result
+}
+
+/// The raw `lifecycle_rules` JSON of bucket `name`, or `None` when no such
+/// bucket exists.
+pub async fn lifecycle_rules(ctx: &dyn Context, name: &str) -> Result<Option<String>, WaferError> {
+    match db::get_by_field(ctx, TABLE, "name", serde_json::json!(name)).await {
+        Ok(r) => Ok(Some(r.str_field("lifecycle_rules").to_string())),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// Replace bucket `name`'s `lifecycle_rules` JSON. Returns the number of
+/// rows updated (0 for an unknown bucket).
+pub async fn set_lifecycle_rules(
+    ctx: &dyn Context,
+    name: &str,
+    rules_json: &str,
+) -> Result<i64, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({
+        "lifecycle_rules": rules_json,
+        "updated_at": crate::util::now_rfc3339(),
+    }));
+    let result = db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![Filter {
+            field: "name".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(name.to_string()),
+        }],
+        data,
+    )
+    .await;
+    cache::invalidate_table(TABLE);
+    result
+}
+
+/// Replace bucket `name`'s `website` JSON (see `files::website`). Returns
+/// the number of rows updated (0 for an unknown bucket).
+pub async fn set_website(
+    ctx: &dyn Context,
+    name: &str,
+    website_json: &str,
+) -> Result<i64, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({
+        "website": website_json,
+        "updated_at": crate::util::now_rfc3339(),
+    }));
+    let result = db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![Filter {
+            field: "name".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(name.to_string()),
+        }],
+        data,
+    )
+    .await;
+    cache::invalidate_table(TABLE);
+    result
+}
+
+/// The bucket row named `name`, or `None` when no such bucket exists.
+pub async fn find_by_name(ctx: &dyn Context, name: &str) -> Result<Option<Record>, WaferError> {
+    match db::get_by_field(ctx, TABLE, "name", serde_json::json!(name)).await {
+        Ok(r) => Ok(Some(r)),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// Bucket rows that carry at least one lifecycle rule.
+pub async fn list_with_lifecycle_rules(ctx: &dyn Context) -> Result<Vec<Record>, WaferError> {
+    let filters = vec![Filter {
+        field: "lifecycle_rules".to_string(),
+        operator: FilterOp::NotEqual,
+        value: serde_json::Value::String("[]".to_string()),
+    }];
+    db::list_all(ctx, TABLE, filters).await
 }
 
 /// Total number of bucket rows (admin stats).
@@ -132,7 +365,9 @@ pub async fn seed(
     ctx: &dyn Context,
     data: std::collections::HashMap<String, serde_json::Value>,
 ) -> Result<Record, WaferError> {
-    db::create(ctx, TABLE, data).await
+    let result = db::create(ctx, TABLE, data).await;
+    cache::invalidate_table(TABLE);
+    result
 }
 
 #[cfg(test)]
@@ -146,8 +381,10 @@ mod tests {
     #[tokio::test]
     async fn find_owned_matches_only_the_name_owner_pair() {
         let ctx = TestContext::with_files().await;
-        insert(&ctx, "photos", false, "alice").await.expect("seed");
-        insert(&ctx, "docs", true, "bob").await.expect("seed");
+        insert(&ctx, "photos", false, "alice", "")
+            .await
+            .expect("seed");
+        insert(&ctx, "docs", true, "bob", "").await.expect("seed");
 
         let hit = find_owned(&ctx, "photos", "alice")
             .await
@@ -173,4 +410,61 @@ mod tests {
             .expect("find_owned")
             .is_none());
     }
+
+    async fn listed_names(ctx: &TestContext, owner: &str) -> Vec<String> {
+        let mut names: Vec<String> = list_visible(ctx, Some(owner))
+            .await
+            .unwrap()
+            .iter()
+            .map(|r| r.str_field("name").to_string())
+            .collect();
+        names.sort();
+        names
+    }
+
+    /// Every write path drops cached listings — a stale listing would show
+    /// a deleted bucket or hide a shared one.
+    #[tokio::test]
+    async fn writes_invalidate_cached_listings() {
+        crate::cache::enable_for_current_thread();
+        let ctx = TestContext::with_files().await;
+        // Cache keys are process-wide; a unique owner keeps parallel tests apart.
+        let owner = format!("cache_{}", uuid::Uuid::now_v7());
+
+        assert!(listed_names(&ctx, &owner).await.is_empty());
+        // A write that bypasses this module isn't seen: the listing is cached.
+        let data = crate::util::json_map(serde_json::json!({
+            "name": format!("{owner}_raw"),
+            "created_by": owner,
+            "team_id": "",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        db::create(&ctx, TABLE, data).await.unwrap();
+        assert!(listed_names(&ctx, &owner).await.is_empty());
+
+        let mine = format!("{owner}_mine");
+        insert(&ctx, &mine, false, &owner, "").await.unwrap();
+        assert_eq!(
+            listed_names(&ctx, &owner).await,
+            [format!("{owner}_raw"), mine.clone()]
+        );
+
+        let shared = format!("{owner}_shared");
+        insert(&ctx, &shared, false, "someone_else", "")
+            .await
+            .unwrap();
+        assert_eq!(listed_names(&ctx, &owner).await.len(), 2);
+        set_visible_to_users(&ctx, &shared, true).await.unwrap();
+        assert_eq!(listed_names(&ctx, &owner).await.len(), 3);
+
+        set_lifecycle_rules(&ctx, &mine, "[{\"days\":1}]")
+            .await
+            .unwrap();
+        let rows = list_visible(&ctx, Some(&owner)).await.unwrap();
+        let row = rows.iter().find(|r| r.str_field("name") == mine).unwrap();
+        assert_eq!(row.str_field("lifecycle_rules"), "[{\"days\":1}]");
+
+        delete_by_name(&ctx, &mine).await.unwrap();
+        assert!(!listed_names(&ctx, &owner).await.contains(&mine));
+    }
 }
@@ -0,0 +1,96 @@
+//! Row-level access over `suppers_ai__files__consistency_runs` and
+//! `suppers_ai__files__consistency_findings`.
+//!
+//! One run row per storage consistency check, rewritten with its running
+//! totals after every batch, and one finding row per orphaned blob or
+//! dangling object row the check came across (see `files::consistency`).
+//! Findings belong to their run; both outlive the objects they name.
+
+use std::collections::HashMap;
+
+use wafer_block::db::{Filter, FilterOp, SortField};
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, ErrorCode, WaferError};
+
+/// Consistency run table.
+pub const RUNS_TABLE: &str = "suppers_ai__files__consistency_runs";
+/// Consistency finding table.
+pub const FINDINGS_TABLE: &str = "suppers_ai__files__consistency_findings";
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(value.to_string()),
+    }
+}
+
+/// Start a run. Caller supplies the full field map.
+pub async fn insert_run(
+    ctx: &dyn Context,
+    data: HashMap<String, serde_json::Value>,
+) -> Result<Record, WaferError> {
+    db::create(ctx, RUNS_TABLE, data).await
+}
+
+/// Overwrite run `id`'s status and totals with `data`.
+pub async fn update_run(
+    ctx: &dyn Context,
+    id: &str,
+    mut data: HashMap<String, serde_json::Value>,
+) -> Result<(), WaferError> {
+    crate::util::stamp_updated(&mut data);
+    db::update(ctx, RUNS_TABLE, id, data).await.map(|_| ())
+}
+
+/// Run `id`, if there is one.
+pub async fn get_run(ctx: &dyn Context, id: &str) -> Result<Option<Record>, WaferError> {
+    match db::get(ctx, RUNS_TABLE, id).await {
+        Ok(row) => Ok(Some(row)),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// The newest run with `status` (any status when empty).
+pub async fn latest_run(ctx: &dyn Context, status: &str) -> Result<Option<Record>, WaferError> {
+    let filters = if status.is_empty() {
+        vec![]
+    } else {
+        vec![eq("status", status)]
+    };
+    let sort = vec![SortField {
+        field: "created_at".to_string(),
+        desc: true,
+    }];
+    let page = db::paginated_list(ctx, RUNS_TABLE, 1, 1, filters, sort).await?;
+    Ok(page.records.into_iter().next())
+}
+
+/// Record one finding. Caller supplies the full field map.
+pub async fn insert_finding(
+    ctx: &dyn Context,
+    data: HashMap<String, serde_json::Value>,
+) -> Result<Record, WaferError> {
+    db::create(ctx, FINDINGS_TABLE, data).await
+}
+
+/// A page of run `run_id`'s findings, oldest first, only of `kind` when it
+/// is non-empty.
+pub async fn list_findings(
+    ctx: &dyn Context,
+    run_id: &str,
+    kind: &str,
+    page: i64,
+    page_size: i64,
+) -> Result<RecordList, WaferError> {
+    let mut filters = vec![eq("run_id", run_id)];
+    if !kind.is_empty() {
+        filters.push(eq("kind", kind));
+    }
+    let sort = vec![SortField {
+        field: "created_at".to_string(),
+        desc: false,
+    }];
+    db::paginated_list(ctx, FINDINGS_TABLE, page, page_size, filters, sort).await
+}
@@ -0,0 +1,64 @@
+//! Row-level access over `suppers_ai__files__object_events`.
+//!
+//! Object history — one row per change to an object, written by
+//! `files::history` and read back newest-first by the history endpoint.
+//! Rows are keyed by object id and outlive the object row; the
+//! `object_history` retention policy deletes them by age.
+
+use std::collections::HashMap;
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, WaferError};
+
+/// Object history table.
+pub const TABLE: &str = "suppers_ai__files__object_events";
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(value.to_string()),
+    }
+}
+
+/// Insert one event. Caller supplies the full field map.
+pub async fn insert(
+    ctx: &dyn Context,
+    data: HashMap<String, serde_json::Value>,
+) -> Result<Record, WaferError> {
+    db::create(ctx, TABLE, data).await
+}
+
+/// Object `object_id`'s events in `bucket`, newest first.
+pub async fn list_for_object(
+    ctx: &dyn Context,
+    bucket: &str,
+    object_id: &str,
+    limit: i64,
+    offset: i64,
+) -> Result<RecordList, WaferError> {
+    let opts = ListOptions {
+        filters: vec![eq("bucket", bucket), eq("object_id", object_id)],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit,
+        offset,
+        skip_count: false,
+        ..Default::default()
+    };
+    db::list(ctx, TABLE, &opts).await
+}
+
+/// Delete every event in `bucket` (bucket-deletion cleanup).
+pub async fn delete_for_bucket(ctx: &dyn Context, bucket: &str) -> Result<(), WaferError> {
+    db::delete_by_field(
+        ctx,
+        TABLE,
+        "bucket",
+        serde_json::Value::String(bucket.to_string()),
+    )
+    .await
+}
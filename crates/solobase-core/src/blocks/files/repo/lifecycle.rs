@@ -0,0 +1,69 @@
+//! Row-level access over `suppers_ai__files__lifecycle_runs`.
+//!
+//! One summary row per rule per lifecycle evaluation, grouped by `run_id`.
+//! The newest row also tells `files::lifecycle` when the last scheduled
+//! run happened. Rules themselves live on the bucket row
+//! ([`super::buckets::lifecycle_rules`]).
+
+use wafer_block::db::SortField;
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, WaferError};
+
+use crate::util::RecordExt;
+
+/// Lifecycle run summary table.
+pub const RUNS_TABLE: &str = "suppers_ai__files__lifecycle_runs";
+
+/// Fields of a run summary row.
+pub struct NewRun<'a> {
+    pub run_id: &'a str,
+    pub bucket: &'a str,
+    pub rule_id: &'a str,
+    pub action: &'a str,
+    pub matched: i64,
+    pub affected: i64,
+    pub errors: i64,
+    pub locked: i64,
+    pub capped: bool,
+}
+
+/// Record one rule's outcome within a run.
+pub async fn insert_run(ctx: &dyn Context, r: NewRun<'_>) -> Result<Record, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "run_id": r.run_id,
+        "bucket": r.bucket,
+        "rule_id": r.rule_id,
+        "action": r.action,
+        "matched": r.matched,
+        "affected": r.affected,
+        "errors": r.errors,
+        "locked": r.locked,
+        "capped": i64::from(r.capped),
+        "created_at": &now,
+        "updated_at": &now,
+    }));
+    db::create(ctx, RUNS_TABLE, data).await
+}
+
+/// Run summaries, newest first.
+pub async fn list_runs(
+    ctx: &dyn Context,
+    page: i64,
+    page_size: i64,
+) -> Result<RecordList, WaferError> {
+    let sort = vec![SortField {
+        field: "created_at".to_string(),
+        desc: true,
+    }];
+    db::paginated_list(ctx, RUNS_TABLE, page, page_size, vec![], sort).await
+}
+
+/// `created_at` of the newest summary row, if any run has recorded one.
+pub async fn last_run_at(ctx: &dyn Context) -> Result<Option<String>, WaferError> {
+    let newest = list_runs(ctx, 1, 1).await?;
+    Ok(newest
+        .records
+        .first()
+        .map(|r| r.str_field("created_at").to_string()))
+}
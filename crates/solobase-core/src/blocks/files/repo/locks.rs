@@ -0,0 +1,125 @@
+//! Row-level access over `suppers_ai__files__object_locks`.
+//!
+//! One row holds a bucket `path` (`dir/` = folder, or an exact key) until
+//! `locked_until`. The `(bucket, path)` pair is unique, so locking a path
+//! again updates its row in place ([`upsert`]). Rows are kept after they
+//! expire; an expired row locks nothing. Which locks cover a key is policy
+//! and lives in `files::locks`.
+
+use wafer_block::db::{Filter, FilterOp, SortField};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, WaferError};
+
+/// Object lock table — one row per locked (bucket, path).
+pub const TABLE: &str = "suppers_ai__files__object_locks";
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(value.to_string()),
+    }
+}
+
+/// Lock `path` in `bucket` until `locked_until` (RFC 3339, as written by
+/// `util::format_rfc3339` so it compares as text), or move the end of the
+/// existing lock on the same path. Returns the stored row.
+pub async fn upsert(
+    ctx: &dyn Context,
+    bucket: &str,
+    path: &str,
+    locked_until: &str,
+    locked_by: &str,
+) -> Result<Record, WaferError> {
+    let existing = db::list_all(ctx, TABLE, vec![eq("bucket", bucket), eq("path", path)]).await?;
+    if let Some(row) = existing.into_iter().next() {
+        let mut data = crate::util::json_map(serde_json::json!({
+            "locked_until": locked_until,
+            "locked_by": locked_by,
+        }));
+        crate::util::stamp_updated(&mut data);
+        return db::update(ctx, TABLE, &row.id, data).await;
+    }
+    let data = crate::util::json_map(serde_json::json!({
+        "bucket": bucket,
+        "path": path,
+        "locked_until": locked_until,
+        "locked_by": locked_by,
+    }));
+    db::create(ctx, TABLE, data).await
+}
+
+/// Look up a lock by its primary `id`.
+pub async fn find_by_id(ctx: &dyn Context, id: &str) -> Result<Record, WaferError> {
+    db::get(ctx, TABLE, id).await
+}
+
+/// The lock on exactly `path` in `bucket`, expired or not.
+pub async fn find_by_path(
+    ctx: &dyn Context,
+    bucket: &str,
+    path: &str,
+) -> Result<Option<Record>, WaferError> {
+    let rows = db::list_all(ctx, TABLE, vec![eq("bucket", bucket), eq("path", path)]).await?;
+    Ok(rows.into_iter().next())
+}
+
+/// Hard-delete a lock by id.
+pub async fn delete(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
+    db::delete(ctx, TABLE, id).await
+}
+
+/// Locks on `bucket` still in force at `now`, ordered by path.
+pub async fn list_active(
+    ctx: &dyn Context,
+    bucket: &str,
+    now: &str,
+) -> Result<Vec<Record>, WaferError> {
+    db::list_sorted(
+        ctx,
+        TABLE,
+        vec![
+            eq("bucket", bucket),
+            Filter {
+                field: "locked_until".to_string(),
+                operator: FilterOp::GreaterThan,
+                value: serde_json::Value::String(now.to_string()),
+            },
+        ],
+        vec![SortField {
+            field: "path".to_string(),
+            desc: false,
+        }],
+    )
+    .await
+}
+
+/// Every lock on `bucket`, expired ones included, ordered by path.
+pub async fn list_for_bucket(ctx: &dyn Context, bucket: &str) -> Result<Vec<Record>, WaferError> {
+    db::list_sorted(
+        ctx,
+        TABLE,
+        vec![eq("bucket", bucket)],
+        vec![SortField {
+            field: "path".to_string(),
+            desc: false,
+        }],
+    )
+    .await
+}
+
+/// Delete every lock on `bucket` (bucket deletion, which active locks
+/// refuse — only expired rows are left by then).
+pub async fn delete_for_bucket(ctx: &dyn Context, bucket: &str) -> Result<(), WaferError> {
+    db::delete_by_filters(ctx, TABLE, vec![eq("bucket", bucket)]).await
+}
+
+/// Delete the lock on exactly `path` in `bucket` (object deletion, likewise
+/// only ever an expired one).
+pub async fn delete_for_path(
+    ctx: &dyn Context,
+    bucket: &str,
+    path: &str,
+) -> Result<(), WaferError> {
+    db::delete_by_filters(ctx, TABLE, vec![eq("bucket", bucket), eq("path", path)]).await
+}
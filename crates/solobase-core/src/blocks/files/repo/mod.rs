@@ -9,16 +9,35 @@
 //! default, `err_internal`) keeps its exact previous behavior.
 //!
 //! Submodule → table map:
+//! - [`acls`] — `suppers_ai__files__object_acls`
 //! - [`buckets`] — `suppers_ai__files__buckets`
+//! - [`consistency`] — `suppers_ai__files__consistency_runs` +
+//!   `suppers_ai__files__consistency_findings`
 //! - [`objects`] — `suppers_ai__files__objects`
+//! - [`events`] — `suppers_ai__files__object_events`
+//! - [`lifecycle`] — `suppers_ai__files__lifecycle_runs`
+//! - [`locks`] — `suppers_ai__files__object_locks`
 //! - [`views`] — `suppers_ai__files__views`
 //! - [`shares`] — `suppers_ai__files__cloud_shares` +
 //!   `suppers_ai__files__cloud_access_logs` (the access log is a child
 //!   audit table of shares; one submodule owns both)
 //! - [`quota`] — `suppers_ai__files__cloud_quotas`
+//! - [`teams`] — `suppers_ai__files__teams` +
+//!   `suppers_ai__files__team_members`
+//! - [`notifications`] — `suppers_ai__files__quota_notifications`
+//! - [`widgets`] — `suppers_ai__files__widget_sessions` +
+//!   `suppers_ai__files__widget_uploads` (a session's stored objects)
 
+pub mod acls;
 pub mod buckets;
+pub mod consistency;
+pub mod events;
+pub mod lifecycle;
+pub mod locks;
+pub mod notifications;
 pub mod objects;
 pub mod quota;
 pub mod shares;
+pub mod teams;
 pub mod views;
+pub mod widgets;
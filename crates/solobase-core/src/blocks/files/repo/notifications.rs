@@ -0,0 +1,150 @@
+//! Row-level access over `suppers_ai__files__quota_notifications`.
+//!
+//! One `quota_threshold` row per user per crossed quota threshold per reset
+//! period (the unique `(user_id, kind, threshold, period_start)` index is
+//! the at-most-once guarantee), plus one `admin_digest` row per admin digest
+//! sent. The threshold logic itself lives in `files::quota`.
+//!
+//! This is a ledger, not an inbox: users see threshold crossings through
+//! the shared notification inbox (`blocks::notifications`), and the
+//! `dismissed_at` column from the block's own inbox is no longer written.
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, WaferError};
+
+/// Quota threshold / admin digest notification table.
+pub const TABLE: &str = "suppers_ai__files__quota_notifications";
+
+/// `kind` of a per-user threshold-crossing row.
+pub const KIND_THRESHOLD: &str = "quota_threshold";
+/// `kind` of an admin digest bookkeeping row.
+pub const KIND_ADMIN_DIGEST: &str = "admin_digest";
+
+fn eq(field: &str, value: serde_json::Value) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value,
+    }
+}
+
+/// Delete `user_id`'s threshold rows (account purge).
+pub async fn delete_for_user(ctx: &dyn Context, user_id: &str) -> Result<(), WaferError> {
+    db::delete_by_filters(ctx, TABLE, vec![eq("user_id", serde_json::json!(user_id))]).await
+}
+
+/// Whether `user_id` already has a row for `threshold` in `period_start`.
+pub async fn exists_for_period(
+    ctx: &dyn Context,
+    user_id: &str,
+    threshold: i64,
+    period_start: &str,
+) -> Result<bool, WaferError> {
+    let filters = vec![
+        eq("user_id", serde_json::json!(user_id)),
+        eq("kind", serde_json::json!(KIND_THRESHOLD)),
+        eq("threshold", serde_json::json!(threshold)),
+        eq("period_start", serde_json::json!(period_start)),
+    ];
+    db::count(ctx, TABLE, &filters).await.map(|n| n > 0)
+}
+
+/// Fields of a new threshold-crossing row.
+pub struct NewThreshold<'a> {
+    pub user_id: &'a str,
+    pub threshold: i64,
+    pub period_start: &'a str,
+    pub used_bytes: i64,
+    pub limit_bytes: i64,
+}
+
+/// Insert a threshold-crossing row. A concurrent upload that crossed the
+/// same threshold loses the race on the unique index and gets an error,
+/// which callers treat as "already notified".
+pub async fn insert_threshold(
+    ctx: &dyn Context,
+    n: NewThreshold<'_>,
+) -> Result<Record, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "user_id": n.user_id,
+        "kind": KIND_THRESHOLD,
+        "threshold": n.threshold,
+        "period_start": n.period_start,
+        "used_bytes": n.used_bytes,
+        "limit_bytes": n.limit_bytes,
+        "created_at": &now,
+        "updated_at": &now,
+    }));
+    db::create(ctx, TABLE, data).await
+}
+
+/// Threshold rows at or above `threshold` created since `since` (RFC 3339),
+/// oldest first — the admin digest's body.
+pub async fn list_crossings_since(
+    ctx: &dyn Context,
+    threshold: i64,
+    since: &str,
+    limit: i64,
+) -> Result<RecordList, WaferError> {
+    let opts = ListOptions {
+        filters: vec![
+            eq("kind", serde_json::json!(KIND_THRESHOLD)),
+            Filter {
+                field: "threshold".to_string(),
+                operator: FilterOp::GreaterEqual,
+                value: serde_json::json!(threshold),
+            },
+            Filter {
+                field: "created_at".to_string(),
+                operator: FilterOp::GreaterThan,
+                value: serde_json::json!(since),
+            },
+        ],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: false,
+        }],
+        limit,
+        ..Default::default()
+    };
+    db::list(ctx, TABLE, &opts).await
+}
+
+/// `created_at` of the most recent admin digest, if one was ever sent.
+pub async fn last_digest_at(ctx: &dyn Context) -> Result<Option<String>, WaferError> {
+    let opts = ListOptions {
+        filters: vec![eq("kind", serde_json::json!(KIND_ADMIN_DIGEST))],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit: 1,
+        skip_count: true,
+        ..Default::default()
+    };
+    let list = db::list(ctx, TABLE, &opts).await?;
+    Ok(list.records.first().and_then(|r| {
+        r.data
+            .get("created_at")
+            .and_then(|v| v.as_str())
+            .map(str::to_string)
+    }))
+}
+
+/// Record that an admin digest covering `threshold` was sent now.
+/// `period_start` carries the send timestamp so each digest row is distinct
+/// under the unique index.
+pub async fn insert_digest(ctx: &dyn Context, threshold: i64) -> Result<Record, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "user_id": "",
+        "kind": KIND_ADMIN_DIGEST,
+        "threshold": threshold,
+        "period_start": &now,
+        "created_at": &now,
+        "updated_at": &now,
+    }));
+    db::create(ctx, TABLE, data).await
+}
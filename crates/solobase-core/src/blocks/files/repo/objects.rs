@@ -4,9 +4,27 @@
 //! storage blob in `wafer-run/storage`). Tracks size, content type,
 //! status, uploader and timestamps. Rows are inserted `pending` *before*
 //! the storage upload (to close the quota TOCTOU window) and flipped to
-//! `complete` afterward; quota accounting sums/counts by `uploaded_by`
-//! (including in-flight `pending` reservations), while user-facing search
-//! and admin stats only see `complete` rows.
+//! `complete` afterward; quota accounting sums/counts by `uploaded_by`, or
+//! by `team_id` for uploads into a team bucket (including in-flight
+//! `pending` reservations either way), while user-facing search
+//! and admin stats only see `complete` rows. Lifecycle rules may move a
+//! `complete` row to `archived`: still stored and still counted toward
+//! quota, but hidden from listings and search. With a malware scanner
+//! registered (see `files::scan`), a stored upload may land in
+//! `pending_scan` instead of `complete` until its scan job runs, and an
+//! infected one moves on to `quarantined` — hidden and unreadable, but
+//! counted until an admin releases or deletes it. A row whose blob has gone
+//! missing from storage may be moved to `missing_blob` by the consistency
+//! check (`files::consistency`): hidden from listings and left out of the
+//! storage report, but still counted toward quota until it is deleted.
+//!
+//! A row's `storage_key` names its blob: [`blob_key`] of the row id, so the
+//! object key is metadata only (see `files::blobs`). Rows from before that
+//! layout carry an empty `storage_key` — their blob is at the object key.
+//!
+//! `download_count` and `last_downloaded_at` are bumped by
+//! [`record_download`] through a [`CounterBuffer`], so they may trail live
+//! traffic by a [`crate::write_buffer::FLUSH_INTERVAL`].
 
 use std::collections::HashMap;
 
@@ -17,13 +35,34 @@ use wafer_block::{
 use wafer_core::clients::database::{self as db, Record, RecordList};
 use wafer_run::{context::Context, WaferError};
 
-use crate::util::RecordExt;
+use crate::{
+    util::{escape_like, RecordExt},
+    write_buffer::CounterBuffer,
+};
 
 /// Object metadata table — one row per uploaded file (sibling of the raw
 /// storage blob in `wafer-run/storage`). Tracks size, content type, status,
 /// uploader and timestamps.
 pub const TABLE: &str = "suppers_ai__files__objects";
 
+/// Status of a stored upload still waiting for its asynchronous scan.
+pub const STATUS_PENDING_SCAN: &str = "pending_scan";
+/// Status of an upload the scanner flagged as infected.
+pub const STATUS_QUARANTINED: &str = "quarantined";
+/// Status of a row whose blob the consistency check found missing.
+pub const STATUS_MISSING_BLOB: &str = "missing_blob";
+
+static DOWNLOADS: CounterBuffer = CounterBuffer::new(TABLE, "download_count");
+
+/// Count one download of the object row `id` and stamp its
+/// `last_downloaded_at`. Best-effort: a failed write is logged.
+pub async fn record_download(ctx: &dyn Context, id: &str) {
+    let set = crate::util::json_map(serde_json::json!({
+        "last_downloaded_at": crate::util::now_rfc3339(),
+    }));
+    DOWNLOADS.record(ctx, id.to_string(), 1, set).await;
+}
+
 /// Filter matching only fully uploaded rows (`status = 'complete'`),
 /// excluding in-flight `pending` reservations.
 fn complete_filter() -> [Filter; 1] {
@@ -34,61 +73,147 @@ fn complete_filter() -> [Filter; 1] {
     }]
 }
 
-/// Filter matching all objects uploaded by `user_id` (the rows that count
-/// toward that user's quota, including in-flight `pending` reservations).
+/// Filter matching all personal objects uploaded by `user_id` (the rows
+/// that count toward that user's quota, including in-flight `pending`
+/// reservations). Uploads into a team bucket count toward the team instead.
 fn owned_objects_filter(user_id: &str) -> Vec<Filter> {
-    vec![Filter {
-        field: "uploaded_by".to_string(),
+    vec![
+        Filter {
+            field: "uploaded_by".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(user_id.to_string()),
+        },
+        team_filter(""),
+    ]
+}
+
+/// Filter matching the objects that count toward `team_id`'s quota (`""`
+/// matches personal objects).
+fn team_filter(team_id: &str) -> Filter {
+    Filter {
+        field: "team_id".to_string(),
         operator: FilterOp::Equal,
-        value: serde_json::Value::String(user_id.to_string()),
-    }]
+        value: serde_json::Value::String(team_id.to_string()),
+    }
 }
 
-/// Escape SQL LIKE wildcards (`%`, `_`) and the escape char itself (`\`) in
-/// user-supplied search terms so a user searching for `100% off` doesn't
-/// also match arbitrary characters.
-///
-/// SQLite's `LIKE` has *no* default escape character — a bare backslash is
-/// just a literal byte, so escaping here would be silently inert on its own.
-/// What makes it effective is the `wafer-sql-utils` `FilterOp::Like` builder
-/// (used by [`search_completed`]'s query below), which renders an explicit
-/// `ESCAPE '\'` clause on every backend (SQLite/D1 and Postgres) — see
-/// `wafer-sql-utils::query::leaf_expr`. Without that clause, a query
-/// containing `_` or `%` would match as a wildcard instead of a literal
-/// character.
-fn escape_like(input: &str) -> String {
-    let mut out = String::with_capacity(input.len());
-    for c in input.chars() {
-        match c {
-            '\\' | '%' | '_' => {
-                out.push('\\');
-                out.push(c);
-            }
-            other => out.push(other),
-        }
+/// Storage key of the blob belonging to row `id`: `{first two id
+/// chars}/{id}`, spreading blobs over 256 directories.
+pub fn blob_key(id: &str) -> String {
+    format!("{}/{id}", id.get(..2).unwrap_or(id))
+}
+
+/// Where `row`'s bytes live: its `storage_key`, or the object key itself
+/// for a row written before the id layout.
+pub fn storage_key(row: &Record) -> &str {
+    match row.str_field("storage_key") {
+        "" => row.str_field("key"),
+        key => key,
     }
-    out
 }
 
 /// Insert the `pending` reservation row written BEFORE the storage upload,
 /// so concurrent quota checks see the in-flight size (closes the
-/// check-quota → upload TOCTOU race). `uploaded_at` is stamped with
-/// [`crate::util::now_rfc3339`].
+/// check-quota → upload TOCTOU race). The row is created with its id, so
+/// its `storage_key` ([`blob_key`]) is known before the upload. `uploaded_at`
+/// is stamped with [`crate::util::now_rfc3339`]; `sha256` is the content's
+/// hex digest (see `files::checksum`).
+#[allow(clippy::too_many_arguments)]
 pub async fn insert_pending(
     ctx: &dyn Context,
     bucket: &str,
     key: &str,
     size: usize,
+    sha256: &str,
     content_type: &str,
     uploaded_by: &str,
+    team_id: &str,
 ) -> Result<Record, WaferError> {
+    // Random (v4), not v7: a v7 id starts with its timestamp, which would
+    // put every recent blob in the same `blob_key` directory.
+    let id = crate::clock::new_id();
     let data = crate::util::json_map(serde_json::json!({
+        "id": id,
         "bucket": bucket,
         "key": key,
+        "key_lower": key.to_lowercase(),
+        "storage_key": blob_key(&id),
         "size": size,
+        "sha256": sha256,
         "content_type": content_type,
         "status": "pending",
         "uploaded_by": uploaded_by,
+        "modified_by": uploaded_by,
+        "team_id": team_id,
+        "uploaded_at": crate::util::now_rfc3339(),
+        // Server-written keys live under `system` (see `files::metadata`).
+        "metadata": serde_json::json!({
+            "system": {
+                "detected_content_type":
+                    wafer_core::mime::mime_for_ext(std::path::Path::new(key)).to_string(),
+            },
+        })
+        .to_string(),
+    }));
+    db::create(ctx, TABLE, data).await
+}
+
+/// Insert a `complete` row at `key` for a row-less blob already stored at
+/// `storage_key` (re-adopted by the consistency check). `id` is the blob's
+/// own row id for an id-layout blob, so the row and its blob agree again;
+/// `None` mints one.
+#[allow(clippy::too_many_arguments)]
+pub async fn insert_recovered(
+    ctx: &dyn Context,
+    id: Option<&str>,
+    bucket: &str,
+    key: &str,
+    storage_key: &str,
+    size: i64,
+    content_type: &str,
+    uploaded_by: &str,
+    team_id: &str,
+) -> Result<Record, WaferError> {
+    let id = id.map_or_else(crate::clock::new_id, str::to_string);
+    let data = crate::util::json_map(serde_json::json!({
+        "id": id,
+        "bucket": bucket,
+        "key": key,
+        "key_lower": key.to_lowercase(),
+        "storage_key": storage_key,
+        "size": size,
+        "content_type": content_type,
+        "status": "complete",
+        "uploaded_by": uploaded_by,
+        "modified_by": uploaded_by,
+        "team_id": team_id,
+        "uploaded_at": crate::util::now_rfc3339(),
+    }));
+    db::create(ctx, TABLE, data).await
+}
+
+/// Test fixture: a `complete` row for a blob already stored at `key`, as
+/// written before the id layout — an empty `storage_key` — until the
+/// relayout job moves the blob.
+#[cfg(test)]
+pub async fn insert_legacy(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+    size: i64,
+    content_type: &str,
+    uploaded_by: &str,
+    team_id: &str,
+) -> Result<Record, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({
+        "bucket": bucket,
+        "key": key,
+        "key_lower": key.to_lowercase(),
+        "size": size,
+        "content_type": content_type,
+        "status": "complete",
+        "uploaded_by": uploaded_by,
+        "team_id": team_id,
         "uploaded_at": crate::util::now_rfc3339(),
     }));
     db::create(ctx, TABLE, data).await
@@ -101,6 +226,121 @@ pub async fn mark_complete(ctx: &dyn Context, id: &str) -> Result<(), WaferError
     db::update(ctx, TABLE, id, data).await.map(|_| ())
 }
 
+/// Flip a `pending` row to `status = 'pending_scan'` after its storage
+/// upload succeeded but before its scan has run.
+pub async fn mark_pending_scan(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
+    let data = crate::util::json_map(serde_json::json!({ "status": STATUS_PENDING_SCAN }));
+    db::update(ctx, TABLE, id, data).await.map(|_| ())
+}
+
+/// Move row `id` from status `from` to `to`, writing `metadata` with it.
+/// Returns `false` when the row was no longer in `from` (deleted or moved
+/// concurrently).
+pub async fn transition(
+    ctx: &dyn Context,
+    id: &str,
+    from: &str,
+    to: &str,
+    metadata: &str,
+) -> Result<bool, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({
+        "status": to,
+        "metadata": metadata,
+        "updated_at": crate::util::now_rfc3339(),
+    }));
+    let filters = vec![
+        Filter {
+            field: "id".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(id.to_string()),
+        },
+        Filter {
+            field: "status".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(from.to_string()),
+        },
+    ];
+    db::update_by_filters_count(ctx, TABLE, filters, data)
+        .await
+        .map(|n| n > 0)
+}
+
+/// Rows with `status`, most recently updated first — the admin quarantine
+/// listing.
+pub async fn list_by_status(
+    ctx: &dyn Context,
+    status: &str,
+    page: i64,
+    page_size: i64,
+) -> Result<RecordList, WaferError> {
+    let filters = vec![Filter {
+        field: "status".to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(status.to_string()),
+    }];
+    let sort = vec![SortField {
+        field: "updated_at".to_string(),
+        desc: true,
+    }];
+    db::paginated_list(ctx, TABLE, page, page_size, filters, sort).await
+}
+
+/// Fetch one object row by id. Other blocks (products media) read this
+/// under a read grant to check ownership before copying an object.
+pub async fn get(ctx: &dyn Context, id: &str) -> Result<Record, WaferError> {
+    db::get(ctx, TABLE, id).await
+}
+
+/// The row for `(bucket, key)`, in any status.
+pub async fn find_by_bucket_key(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+) -> Result<Option<Record>, WaferError> {
+    let rows = db::list_all(
+        ctx,
+        TABLE,
+        vec![
+            Filter {
+                field: "bucket".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(bucket.to_string()),
+            },
+            Filter {
+                field: "key".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(key.to_string()),
+            },
+        ],
+    )
+    .await?;
+    Ok(rows.into_iter().next())
+}
+
+/// Overwrite one row's serialized `metadata` object. Callers validate it
+/// (see `files::metadata`).
+pub async fn set_metadata(ctx: &dyn Context, id: &str, metadata: &str) -> Result<(), WaferError> {
+    let data = crate::util::json_map(serde_json::json!({ "metadata": metadata }));
+    db::update(ctx, TABLE, id, data).await.map(|_| ())
+}
+
+/// Record `actor` as the last to change row `id` (see `files::history`).
+pub async fn set_modified_by(ctx: &dyn Context, id: &str, actor: &str) -> Result<(), WaferError> {
+    let mut data = crate::util::json_map(serde_json::json!({ "modified_by": actor }));
+    crate::util::stamp_updated(&mut data);
+    db::update(ctx, TABLE, id, data).await.map(|_| ())
+}
+
+/// Record that row `id`'s blob now lives at `storage_key`.
+pub async fn set_storage_key(
+    ctx: &dyn Context,
+    id: &str,
+    storage_key: &str,
+) -> Result<(), WaferError> {
+    let data = crate::util::json_map(serde_json::json!({ "storage_key": storage_key }));
+    db::update(ctx, TABLE, id, data).await.map(|_| ())
+}
+
 /// Hard-delete one object row by id (the compensating delete when a
 /// storage upload fails after its `pending` row was inserted).
 pub async fn delete(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
@@ -144,6 +384,40 @@ pub async fn delete_by_bucket_key(
     .await
 }
 
+/// Point the row for `(bucket, from)` at `to` (object move). The blob is
+/// addressed by `storage_key` and stays where it is.
+pub async fn rename_key(
+    ctx: &dyn Context,
+    bucket: &str,
+    from: &str,
+    to: &str,
+) -> Result<(), WaferError> {
+    let mut data = crate::util::json_map(serde_json::json!({
+        "key": to,
+        "key_lower": to.to_lowercase(),
+    }));
+    crate::util::stamp_updated(&mut data);
+    db::update_by_filters(
+        ctx,
+        TABLE,
+        vec![
+            Filter {
+                field: "bucket".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(bucket.to_string()),
+            },
+            Filter {
+                field: "key".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(from.to_string()),
+            },
+        ],
+        data,
+    )
+    .await
+    .map(|_| ())
+}
+
 /// Delete `user_id`'s `pending`-status rows with `uploaded_at` strictly
 /// before `cutoff` (an RFC 3339 timestamp, string-compared the same way the
 /// column is written). See `quota::sweep_stale_pending` for the policy and
@@ -173,8 +447,8 @@ pub async fn delete_stale_pending(
     db::delete_by_filters(ctx, TABLE, filters).await
 }
 
-/// Search `user_id`'s `complete` objects whose key contains `query`
-/// (case rules per backend `LIKE`), newest upload first. `query` is
+/// Search `user_id`'s `complete` objects whose key contains `query`,
+/// ignoring case, newest upload first. `query` is
 /// LIKE-escaped here ([`escape_like`]) so `%`/`_` match literally.
 pub async fn search_completed(
     ctx: &dyn Context,
@@ -185,10 +459,15 @@ pub async fn search_completed(
 ) -> Result<RecordList, WaferError> {
     let opts = ListOptions {
         filters: vec![
+            // `key_lower` (migration 006) makes the match case-insensitive
+            // on Postgres too, whose `LIKE` is case-sensitive.
             Filter {
-                field: "key".to_string(),
+                field: "key_lower".to_string(),
                 operator: FilterOp::Like,
-                value: serde_json::Value::String(format!("%{}%", escape_like(query))),
+                value: serde_json::Value::String(format!(
+                    "%{}%",
+                    escape_like(&query.to_lowercase())
+                )),
             },
             // Only show the current user's files
             Filter {
@@ -216,20 +495,269 @@ pub async fn search_completed(
 }
 
 /// List up to `limit` object rows in `bucket`, sorted by `key` ascending
-/// (the SSR object-browser order).
+/// (the SSR object-browser order). Archived, quarantined and missing-blob
+/// rows are left out.
 pub async fn list_for_bucket(
     ctx: &dyn Context,
     bucket: &str,
     limit: i64,
 ) -> Result<RecordList, WaferError> {
     let opts = ListOptions {
-        filters: vec![Filter {
-            field: "bucket".to_string(),
+        filters: vec![
+            Filter {
+                field: "bucket".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(bucket.to_string()),
+            },
+            Filter {
+                field: "status".to_string(),
+                operator: FilterOp::NotEqual,
+                value: serde_json::Value::String("archived".to_string()),
+            },
+            Filter {
+                field: "status".to_string(),
+                operator: FilterOp::NotEqual,
+                value: serde_json::Value::String(STATUS_QUARANTINED.to_string()),
+            },
+            Filter {
+                field: "status".to_string(),
+                operator: FilterOp::NotEqual,
+                value: serde_json::Value::String(STATUS_MISSING_BLOB.to_string()),
+            },
+        ],
+        sort: vec![SortField {
+            field: "key".to_string(),
+            desc: false,
+        }],
+        limit,
+        ..Default::default()
+    };
+    db::list(ctx, TABLE, &opts).await
+}
+
+/// Statuses an object listing shows: stored and not hidden by lifecycle
+/// rules or the scanner.
+const LISTED_STATUSES: [&str; 2] = ["complete", STATUS_PENDING_SCAN];
+
+/// A page of the rows in `bucket` whose key starts with `prefix`, in key
+/// order, with the total — only the [`LISTED_STATUSES`] when `listed_only`
+/// (object listings), else every row (moves, bucket deletion). `prefix` is
+/// LIKE-escaped here; SQLite's `LIKE` folds ASCII case, so the page is
+/// re-checked with an exact prefix match.
+pub async fn list_under(
+    ctx: &dyn Context,
+    bucket: &str,
+    prefix: &str,
+    listed_only: bool,
+    limit: i64,
+    offset: i64,
+) -> Result<RecordList, WaferError> {
+    let mut filters = vec![Filter {
+        field: "bucket".to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(bucket.to_string()),
+    }];
+    if !prefix.is_empty() {
+        filters.push(Filter {
+            field: "key".to_string(),
+            operator: FilterOp::Like,
+            value: serde_json::Value::String(format!("{}%", escape_like(prefix))),
+        });
+    }
+    if listed_only {
+        filters.push(Filter {
+            field: "status".to_string(),
+            operator: FilterOp::In,
+            value: serde_json::json!(LISTED_STATUSES),
+        });
+    }
+    let opts = ListOptions {
+        filters,
+        sort: vec![SortField {
+            field: "key".to_string(),
+            desc: false,
+        }],
+        limit,
+        offset,
+        ..Default::default()
+    };
+    let mut list = db::list(ctx, TABLE, &opts).await?;
+    let before = list.records.len();
+    list.records
+        .retain(|r| r.str_field("key").starts_with(prefix));
+    list.total_count -= (before - list.records.len()) as i64;
+    Ok(list)
+}
+
+/// Filters matching the rows whose blob is still at its object key
+/// (written before the id layout). Pending reservations are left out —
+/// they have no blob yet.
+fn legacy_filters() -> Vec<Filter> {
+    vec![
+        Filter {
+            field: "storage_key".to_string(),
             operator: FilterOp::Equal,
-            value: serde_json::Value::String(bucket.to_string()),
+            value: serde_json::Value::String(String::new()),
+        },
+        Filter {
+            field: "status".to_string(),
+            operator: FilterOp::NotEqual,
+            value: serde_json::Value::String("pending".to_string()),
+        },
+    ]
+}
+
+/// Up to `limit` legacy-layout rows, in id order — the relayout job's
+/// batch.
+pub async fn list_legacy(ctx: &dyn Context, limit: i64) -> Result<Vec<Record>, WaferError> {
+    let opts = ListOptions {
+        filters: legacy_filters(),
+        sort: vec![SortField {
+            field: "id".to_string(),
+            desc: false,
         }],
+        limit,
+        skip_count: true,
+        ..Default::default()
+    };
+    Ok(db::list(ctx, TABLE, &opts).await?.records)
+}
+
+/// Number of legacy-layout rows left to move.
+pub async fn count_legacy(ctx: &dyn Context) -> Result<i64, WaferError> {
+    db::count(ctx, TABLE, &legacy_filters()).await
+}
+
+/// The rows in `bucket`, in any status, whose id is one of `ids`.
+pub async fn find_by_ids(
+    ctx: &dyn Context,
+    bucket: &str,
+    ids: &[String],
+) -> Result<Vec<Record>, WaferError> {
+    if ids.is_empty() {
+        return Ok(Vec::new());
+    }
+    db::list_all(
+        ctx,
+        TABLE,
+        vec![
+            Filter {
+                field: "bucket".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(bucket.to_string()),
+            },
+            Filter {
+                field: "id".to_string(),
+                operator: FilterOp::In,
+                value: serde_json::json!(ids),
+            },
+        ],
+    )
+    .await
+}
+
+/// The rows in `bucket`, in any status, whose `storage_key` is one of
+/// `storage_keys` — the named blobs that re-adopted rows point at.
+pub async fn find_by_storage_keys(
+    ctx: &dyn Context,
+    bucket: &str,
+    storage_keys: &[String],
+) -> Result<Vec<Record>, WaferError> {
+    if storage_keys.is_empty() {
+        return Ok(Vec::new());
+    }
+    db::list_all(
+        ctx,
+        TABLE,
+        vec![
+            Filter {
+                field: "bucket".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(bucket.to_string()),
+            },
+            Filter {
+                field: "storage_key".to_string(),
+                operator: FilterOp::In,
+                value: serde_json::json!(storage_keys),
+            },
+        ],
+    )
+    .await
+}
+
+/// A page of the rows in `bucket` that should have a blob — every status
+/// but `pending` — in id order, for the consistency check's row pass.
+pub async fn list_for_check(
+    ctx: &dyn Context,
+    bucket: &str,
+    limit: i64,
+    offset: i64,
+) -> Result<Vec<Record>, WaferError> {
+    let opts = ListOptions {
+        filters: vec![
+            Filter {
+                field: "bucket".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(bucket.to_string()),
+            },
+            Filter {
+                field: "status".to_string(),
+                operator: FilterOp::NotEqual,
+                value: serde_json::Value::String("pending".to_string()),
+            },
+        ],
         sort: vec![SortField {
+            field: "id".to_string(),
+            desc: false,
+        }],
+        limit,
+        offset,
+        skip_count: true,
+        ..Default::default()
+    };
+    Ok(db::list(ctx, TABLE, &opts).await?.records)
+}
+
+/// Rows in `bucket` under `prefix` uploaded strictly before `cutoff` (an
+/// RFC 3339 timestamp, string-compared like [`delete_stale_pending`]) with
+/// one of `statuses`, oldest first, at most `limit` — the lifecycle
+/// candidate scan. `prefix` is LIKE-escaped here.
+pub async fn list_uploaded_before(
+    ctx: &dyn Context,
+    bucket: &str,
+    prefix: &str,
+    cutoff: &str,
+    statuses: &[&str],
+    limit: i64,
+) -> Result<RecordList, WaferError> {
+    let mut filters = vec![
+        Filter {
+            field: "bucket".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(bucket.to_string()),
+        },
+        Filter {
+            field: "uploaded_at".to_string(),
+            operator: FilterOp::LessThan,
+            value: serde_json::Value::String(cutoff.to_string()),
+        },
+        Filter {
+            field: "status".to_string(),
+            operator: FilterOp::In,
+            value: serde_json::json!(statuses),
+        },
+    ];
+    if !prefix.is_empty() {
+        filters.push(Filter {
             field: "key".to_string(),
+            operator: FilterOp::Like,
+            value: serde_json::Value::String(format!("{}%", escape_like(prefix))),
+        });
+    }
+    let opts = ListOptions {
+        filters,
+        sort: vec![SortField {
+            field: "uploaded_at".to_string(),
             desc: false,
         }],
         limit,
@@ -238,6 +766,90 @@ pub async fn list_for_bucket(
     db::list(ctx, TABLE, &opts).await
 }
 
+/// Move a `complete` row to `status = 'archived'`. Returns `false` when the
+/// row was no longer `complete` (deleted or archived concurrently).
+pub async fn mark_archived(ctx: &dyn Context, id: &str) -> Result<bool, WaferError> {
+    let [complete] = complete_filter();
+    let data = crate::util::json_map(serde_json::json!({
+        "status": "archived",
+        "updated_at": crate::util::now_rfc3339(),
+    }));
+    let filters = vec![
+        Filter {
+            field: "id".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(id.to_string()),
+        },
+        complete,
+    ];
+    db::update_by_filters_count(ctx, TABLE, filters, data)
+        .await
+        .map(|n| n > 0)
+}
+
+/// The rows for `keys` in `bucket`, in any status, in one `IN` query —
+/// used to drop archived objects from storage listings and attach each
+/// object's metadata.
+pub async fn find_by_keys(
+    ctx: &dyn Context,
+    bucket: &str,
+    keys: &[String],
+) -> Result<Vec<Record>, WaferError> {
+    if keys.is_empty() {
+        return Ok(Vec::new());
+    }
+    db::list_all(
+        ctx,
+        TABLE,
+        vec![
+            Filter {
+                field: "bucket".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(bucket.to_string()),
+            },
+            Filter {
+                field: "key".to_string(),
+                operator: FilterOp::In,
+                value: serde_json::json!(keys),
+            },
+        ],
+    )
+    .await
+}
+
+/// The `complete` rows in `bucket` whose id is one of `ids`, in one
+/// `IN` query. Ids from other buckets (or still `pending`) are simply
+/// absent from the result.
+pub async fn find_in_bucket_by_ids(
+    ctx: &dyn Context,
+    bucket: &str,
+    ids: &[String],
+) -> Result<Vec<Record>, WaferError> {
+    let values = ids
+        .iter()
+        .map(|id| serde_json::Value::String(id.clone()))
+        .collect();
+    let [complete] = complete_filter();
+    db::list_all(
+        ctx,
+        TABLE,
+        vec![
+            Filter {
+                field: "bucket".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(bucket.to_string()),
+            },
+            Filter {
+                field: "id".to_string(),
+                operator: FilterOp::In,
+                value: serde_json::Value::Array(values),
+            },
+            complete,
+        ],
+    )
+    .await
+}
+
 /// Object counts per bucket for the given bucket names, via a single
 /// GROUP BY aggregate (one row per bucket) — avoids an N+1 `db::count` per
 /// bucket. Counts ALL rows in each bucket regardless of `uploaded_by` or
@@ -299,6 +911,205 @@ pub async fn sum_size_for_uploader(ctx: &dyn Context, user_id: &str) -> Result<f
     db::sum(ctx, TABLE, "size", &owned_objects_filter(user_id)).await
 }
 
+/// Buckets holding objects that count toward `user_id`'s own quota (the
+/// account purge's reassignment candidates).
+pub async fn buckets_with_uploads(
+    ctx: &dyn Context,
+    user_id: &str,
+) -> Result<Vec<String>, WaferError> {
+    let leaf = |field: &str, value: &str| {
+        wire::FilterNode::Leaf(wire::FilterDef {
+            field: field.into(),
+            operator: "eq".into(),
+            value: serde_json::Value::String(value.to_string()),
+        })
+    };
+    let req = wire::AggregateRequest {
+        collection: TABLE.to_string(),
+        select_columns: vec!["bucket".into()],
+        aggregates: vec![wire::AggregateColumnDef::Count {
+            alias: "cnt".into(),
+        }],
+        filters: vec![leaf("uploaded_by", user_id), leaf("team_id", "")],
+        group_by: vec![wire::GroupByDef::Column("bucket".into())],
+        sort: vec![],
+        limit: 0,
+    };
+    let rows = db::aggregate(ctx, req).await?;
+    Ok(rows
+        .iter()
+        .map(|r| r.str_field("bucket").to_string())
+        .filter(|b| !b.is_empty())
+        .collect())
+}
+
+/// Move the objects in `bucket` that count toward `from`'s quota over to
+/// `to`. Returns the number of rows moved.
+pub async fn reassign_uploads(
+    ctx: &dyn Context,
+    bucket: &str,
+    from: &str,
+    to: &str,
+) -> Result<i64, WaferError> {
+    let mut filters = owned_objects_filter(from);
+    filters.push(Filter {
+        field: "bucket".to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(bucket.to_string()),
+    });
+    let data = crate::util::json_map(serde_json::json!({ "uploaded_by": to }));
+    db::update_by_filters_count(ctx, TABLE, filters, data).await
+}
+
+/// Number of object rows counted toward `team_id` (includes `pending`
+/// reservations).
+pub async fn count_for_team(ctx: &dyn Context, team_id: &str) -> Result<i64, WaferError> {
+    db::count(ctx, TABLE, &[team_filter(team_id)]).await
+}
+
+/// `SUM(size)` over the rows counted toward `team_id` (includes `pending`
+/// reservations).
+pub async fn sum_size_for_team(ctx: &dyn Context, team_id: &str) -> Result<f64, WaferError> {
+    db::sum(ctx, TABLE, "size", &[team_filter(team_id)]).await
+}
+
+/// Statuses of rows whose blob is in storage.
+const STORED_STATUSES: [&str; 4] = [
+    "complete",
+    "archived",
+    STATUS_PENDING_SCAN,
+    STATUS_QUARANTINED,
+];
+
+/// Wire filter matching the rows that occupy storage: `complete`,
+/// `archived` and the scan-held statuses (pending reservations are not
+/// stored yet).
+fn stored_filter() -> wire::FilterNode {
+    wire::FilterNode::Leaf(wire::FilterDef {
+        field: "status".into(),
+        operator: "in".into(),
+        value: serde_json::json!(STORED_STATUSES),
+    })
+}
+
+/// The aggregate columns of a usage row: `objects` (count), `avg_size` and
+/// `largest` (max size). Byte totals are `avg_size × objects`; see
+/// [`usage_bytes`].
+fn usage_aggregates() -> Vec<wire::AggregateColumnDef> {
+    vec![
+        wire::AggregateColumnDef::Count {
+            alias: "objects".into(),
+        },
+        wire::AggregateColumnDef::Avg {
+            field: "size".into(),
+            alias: "avg_size".into(),
+        },
+        wire::AggregateColumnDef::Max {
+            field: "size".into(),
+            alias: "largest".into(),
+        },
+    ]
+}
+
+/// Total bytes of a usage row (`avg_size × objects`, exact while totals
+/// stay below 2^53).
+pub fn usage_bytes(row: &Record) -> i64 {
+    let avg = row
+        .data
+        .get("avg_size")
+        .and_then(|v| v.as_f64().or_else(|| v.as_str()?.parse().ok()))
+        .unwrap_or(0.0);
+    (avg * row.i64_field("objects") as f64).round() as i64
+}
+
+/// Stored-object usage grouped by `columns` (e.g. `["bucket"]`,
+/// `["team_id", "uploaded_by"]`) in one GROUP BY. Each row carries the
+/// group columns plus `objects`, `avg_size` and `largest`.
+pub async fn usage_by(ctx: &dyn Context, columns: &[&str]) -> Result<Vec<Record>, WaferError> {
+    let req = wire::AggregateRequest {
+        collection: TABLE.to_string(),
+        select_columns: columns.iter().map(|c| (*c).to_string()).collect(),
+        aggregates: usage_aggregates(),
+        filters: vec![stored_filter()],
+        group_by: columns
+            .iter()
+            .map(|c| wire::GroupByDef::Column((*c).to_string()))
+            .collect(),
+        sort: vec![],
+        limit: 0,
+    };
+    db::aggregate(ctx, req).await
+}
+
+/// Stored-object usage per bucket, in one GROUP BY over `(bucket,
+/// team_id)`: [`usage_by`]'s columns plus `last_activity` (the newest
+/// `updated_at`). The bucket listing and the admin storage report both read
+/// this, so their numbers agree.
+pub async fn bucket_usage(ctx: &dyn Context) -> Result<Vec<Record>, WaferError> {
+    let mut aggregates = usage_aggregates();
+    aggregates.push(wire::AggregateColumnDef::Max {
+        field: "updated_at".into(),
+        alias: "last_activity".into(),
+    });
+    let req = wire::AggregateRequest {
+        collection: TABLE.to_string(),
+        select_columns: vec!["bucket".into(), "team_id".into()],
+        aggregates,
+        filters: vec![stored_filter()],
+        group_by: vec![
+            wire::GroupByDef::Column("bucket".into()),
+            wire::GroupByDef::Column("team_id".into()),
+        ],
+        sort: vec![],
+        limit: 0,
+    };
+    db::aggregate(ctx, req).await
+}
+
+/// Stored-object usage per day of `created_at` since `since` (RFC 3339),
+/// bucketed by the database (one row per day with objects, keyed by
+/// `created_at` = `YYYY-MM-DD`).
+pub async fn daily_usage_since(ctx: &dyn Context, since: &str) -> Result<Vec<Record>, WaferError> {
+    let req = wire::AggregateRequest {
+        collection: TABLE.to_string(),
+        select_columns: vec![],
+        aggregates: usage_aggregates(),
+        filters: vec![
+            stored_filter(),
+            wire::FilterNode::Leaf(wire::FilterDef {
+                field: "created_at".into(),
+                operator: "gte".into(),
+                value: serde_json::json!(since),
+            }),
+        ],
+        group_by: vec![wire::GroupByDef::DateBucket {
+            field: "created_at".into(),
+        }],
+        sort: vec![],
+        limit: 0,
+    };
+    db::aggregate(ctx, req).await
+}
+
+/// The `limit` largest stored objects, biggest first.
+pub async fn largest(ctx: &dyn Context, limit: i64) -> Result<Vec<Record>, WaferError> {
+    let opts = ListOptions {
+        filters: vec![Filter {
+            field: "status".to_string(),
+            operator: FilterOp::In,
+            value: serde_json::json!(STORED_STATUSES),
+        }],
+        sort: vec![SortField {
+            field: "size".to_string(),
+            desc: true,
+        }],
+        limit,
+        skip_count: true,
+        ..Default::default()
+    };
+    Ok(db::list(ctx, TABLE, &opts).await?.records)
+}
+
 /// Test-fixture seeding: insert a raw row map exactly as given (no stamped
 /// columns), so tests control the precise row shape.
 #[cfg(test)]
@@ -314,3 +1125,69 @@ pub async fn seed(
 pub async fn list_all(ctx: &dyn Context) -> Result<Vec<Record>, WaferError> {
     db::list_all(ctx, TABLE, vec![]).await
 }
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+
+    use super::{STATUS_MISSING_BLOB, STATUS_PENDING_SCAN, STATUS_QUARANTINED, TABLE};
+    use crate::test_support::{assert_uses_index, TestContext};
+
+    #[tokio::test]
+    async fn object_listings_read_through_their_indexes() {
+        let ctx = TestContext::with_files().await;
+        // `list_under`: a folder page in key order.
+        assert_uses_index(
+            &ctx,
+            &format!(
+                "SELECT * FROM {TABLE} WHERE bucket = ? AND key LIKE ? AND status IN (?, ?) \
+                 ORDER BY key ASC LIMIT 50 OFFSET 0"
+            ),
+            &[
+                json!("docs"),
+                json!("reports/%"),
+                json!("complete"),
+                json!(STATUS_PENDING_SCAN),
+            ],
+            "idx_objects_bucket_key",
+        )
+        .await;
+        // `list_for_bucket`: the SSR object browser.
+        assert_uses_index(
+            &ctx,
+            &format!(
+                "SELECT * FROM {TABLE} WHERE bucket = ? AND status != ? AND status != ? \
+                 AND status != ? ORDER BY key ASC LIMIT 200"
+            ),
+            &[
+                json!("docs"),
+                json!("archived"),
+                json!(STATUS_QUARANTINED),
+                json!(STATUS_MISSING_BLOB),
+            ],
+            "idx_objects_bucket_key",
+        )
+        .await;
+        // `search_completed`: a user's files, newest first.
+        assert_uses_index(
+            &ctx,
+            &format!(
+                "SELECT * FROM {TABLE} WHERE key_lower LIKE ? AND uploaded_by = ? AND status = ? \
+                 ORDER BY uploaded_at DESC LIMIT 50 OFFSET 0"
+            ),
+            &[json!("%report%"), json!("user-1"), json!("complete")],
+            "idx_objects_uploader_status_uploaded_at",
+        )
+        .await;
+        // `list_by_status`: the quarantine listing.
+        assert_uses_index(
+            &ctx,
+            &format!(
+                "SELECT * FROM {TABLE} WHERE status = ? ORDER BY updated_at DESC LIMIT 20 OFFSET 0"
+            ),
+            &[json!(STATUS_QUARANTINED)],
+            "idx_objects_status_updated_at",
+        )
+        .await;
+    }
+}
@@ -29,6 +29,17 @@ pub async fn find_for_user(ctx: &dyn Context, user_id: &str) -> Result<Record, W
     .await
 }
 
+/// Delete `user_id`'s override row, if any (account purge).
+pub async fn delete_for_user(ctx: &dyn Context, user_id: &str) -> Result<(), WaferError> {
+    db::delete_by_field(
+        ctx,
+        TABLE,
+        "user_id",
+        serde_json::Value::String(user_id.to_string()),
+    )
+    .await
+}
+
 /// Up to `limit` override rows, unsorted (admin JSON listing).
 pub async fn list(ctx: &dyn Context, limit: i64) -> Result<RecordList, WaferError> {
     let opts = ListOptions {
@@ -71,7 +82,7 @@ pub async fn upsert_for_user(
     );
     fields.insert(
         "updated_at".to_string(),
-        serde_json::Value::String(chrono::Utc::now().to_rfc3339()),
+        serde_json::Value::String(crate::util::now_rfc3339()),
     );
     db::upsert_by_field(
         ctx,
@@ -43,6 +43,7 @@ pub async fn insert(ctx: &dyn Context, new: NewShare<'_>) -> Result<Record, Wafe
         "created_by": new.created_by,
         "created_at": new.created_at,
         "access_count": 0,
+        "serving_until": "",
     }));
     if let Some(exp) = new.expires_at {
         data.insert(
@@ -78,6 +79,33 @@ pub async fn delete(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
     db::delete(ctx, TABLE, id).await
 }
 
+/// Point the share links on `(bucket, from)` at `to` (object move), so
+/// existing links keep resolving.
+pub async fn rename_key(
+    ctx: &dyn Context,
+    bucket: &str,
+    from: &str,
+    to: &str,
+) -> Result<(), WaferError> {
+    let filters = vec![
+        Filter {
+            field: "bucket".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(bucket.to_string()),
+        },
+        Filter {
+            field: "key".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(from.to_string()),
+        },
+    ];
+    let mut data = crate::util::json_map(serde_json::json!({ "key": to }));
+    crate::util::stamp_updated(&mut data);
+    db::update_by_filters(ctx, TABLE, filters, data)
+        .await
+        .map(|_| ())
+}
+
 /// Up to `limit` of `user_id`'s shares, newest first (JSON API listing).
 pub async fn list_for_user(
     ctx: &dyn Context,
@@ -100,6 +128,37 @@ pub async fn list_for_user(
     db::list(ctx, TABLE, &opts).await
 }
 
+/// Up to `limit` share links to `key` in `bucket`, newest first (the
+/// object info `shares` expansion). Revoked and expired rows included.
+pub async fn list_for_object(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+    limit: i64,
+) -> Result<RecordList, WaferError> {
+    let opts = ListOptions {
+        filters: vec![
+            Filter {
+                field: "bucket".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(bucket.to_string()),
+            },
+            Filter {
+                field: "key".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(key.to_string()),
+            },
+        ],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit,
+        ..Default::default()
+    };
+    db::list(ctx, TABLE, &opts).await
+}
+
 /// ALL of `user_id`'s shares, newest first, unpaginated (the SSR shares
 /// page).
 pub async fn list_all_for_user(
@@ -145,6 +204,155 @@ pub async fn count_all(ctx: &dyn Context) -> Result<i64, WaferError> {
     db::count(ctx, TABLE, &[]).await
 }
 
+/// Shares not yet revoked, newest first (admin token listing). Expired rows
+/// are included until [`delete_stale`] sweeps them; callers flag them.
+pub async fn list_unrevoked(
+    ctx: &dyn Context,
+    limit: i64,
+    offset: i64,
+) -> Result<RecordList, WaferError> {
+    let opts = ListOptions {
+        filters: vec![is_null("revoked_at")],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit,
+        offset,
+        ..Default::default()
+    };
+    db::list(ctx, TABLE, &opts).await
+}
+
+/// Revoke share `id` by stamping `revoked_at`. Returns rows affected — 0
+/// means no such share or it was already revoked.
+pub async fn revoke(ctx: &dyn Context, id: &str) -> Result<i64, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "revoked_at": &now,
+        "updated_at": &now,
+    }));
+    db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![
+            Filter {
+                field: "id".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(id.to_string()),
+            },
+            is_null("revoked_at"),
+        ],
+        data,
+    )
+    .await
+}
+
+/// Revoke every unrevoked share `user_id` created (account purge).
+/// Returns rows affected.
+pub async fn revoke_all_for_user(ctx: &dyn Context, user_id: &str) -> Result<i64, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "revoked_at": &now,
+        "updated_at": &now,
+    }));
+    db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![
+            Filter {
+                field: "created_by".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(user_id.to_string()),
+            },
+            is_null("revoked_at"),
+        ],
+        data,
+    )
+    .await
+}
+
+/// Hard-delete shares that can no longer be used: `expires_at` before
+/// `expired_before`, `revoked_at` before `expired_before`, or — for shares
+/// with no explicit expiry — `created_at` before `created_before` (the
+/// signed token itself has lapsed by then). All cutoffs are RFC 3339,
+/// string-compared the same way the columns are written. Access-log rows
+/// are kept as the audit trail. Returns the number of shares deleted.
+pub async fn delete_stale(
+    ctx: &dyn Context,
+    expired_before: &str,
+    created_before: &str,
+) -> Result<i64, WaferError> {
+    let before = |field: &str, cutoff: &str| Filter {
+        field: field.to_string(),
+        operator: FilterOp::LessThan,
+        value: serde_json::Value::String(cutoff.to_string()),
+    };
+    let expired =
+        db::delete_by_filters_count(ctx, TABLE, vec![before("expires_at", expired_before)]).await?;
+    let revoked =
+        db::delete_by_filters_count(ctx, TABLE, vec![before("revoked_at", expired_before)]).await?;
+    let lapsed = db::delete_by_filters_count(
+        ctx,
+        TABLE,
+        vec![is_null("expires_at"), before("created_at", created_before)],
+    )
+    .await?;
+    Ok(expired + revoked + lapsed)
+}
+
+/// Share counts by state, for the admin token listing and metrics.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, serde::Serialize)]
+pub struct ShareCounts {
+    /// Not revoked and not past `expires_at`.
+    pub active: i64,
+    /// Not revoked but past `expires_at` (awaiting the sweep).
+    pub expired: i64,
+    /// Revoked by an admin (awaiting the sweep).
+    pub revoked: i64,
+}
+
+/// Count shares by state as of `now` (RFC 3339).
+pub async fn counts(ctx: &dyn Context, now: &str) -> Result<ShareCounts, WaferError> {
+    let total = db::count(ctx, TABLE, &[]).await?;
+    let revoked = db::count(
+        ctx,
+        TABLE,
+        &[Filter {
+            field: "revoked_at".to_string(),
+            operator: FilterOp::IsNotNull,
+            value: serde_json::Value::Null,
+        }],
+    )
+    .await?;
+    let expired = db::count(
+        ctx,
+        TABLE,
+        &[
+            is_null("revoked_at"),
+            Filter {
+                field: "expires_at".to_string(),
+                operator: FilterOp::LessThan,
+                value: serde_json::Value::String(now.to_string()),
+            },
+        ],
+    )
+    .await?;
+    Ok(ShareCounts {
+        active: (total - revoked - expired).max(0),
+        expired,
+        revoked,
+    })
+}
+
+fn is_null(field: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::IsNull,
+        value: serde_json::Value::Null,
+    }
+}
+
 /// CAS-style increment of `access_count` for a share row. Returns `Ok(true)`
 /// if a row was updated (and the cap, if any, still allowed the access),
 /// `Ok(false)` if the row was already at its cap, or `Err` on DB failure.
@@ -177,23 +385,142 @@ pub async fn increment_access_count_capped(
     Ok(rows > 0)
 }
 
+/// Take the download lease on share `id` until `until`, provided no other
+/// attempt holds an unexpired one as of `now` (both RFC 3339; an idle row
+/// holds `''`, which sorts before any instant). `Ok(false)` means another
+/// attempt is in flight.
+pub async fn begin_attempt(
+    ctx: &dyn Context,
+    id: &str,
+    now: &str,
+    until: &str,
+) -> Result<bool, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({ "serving_until": until }));
+    let rows = db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![
+            Filter {
+                field: "id".to_string(),
+                operator: FilterOp::Equal,
+                value: serde_json::Value::String(id.to_string()),
+            },
+            Filter {
+                field: "serving_until".to_string(),
+                operator: FilterOp::LessThan,
+                value: serde_json::Value::String(now.to_string()),
+            },
+        ],
+        data,
+    )
+    .await?;
+    Ok(rows > 0)
+}
+
+/// Push the lease taken by [`begin_attempt`] out to `until`, for a
+/// download still streaming when half of it has run.
+pub async fn extend_attempt(ctx: &dyn Context, id: &str, until: &str) -> Result<(), WaferError> {
+    let data = crate::util::json_map(serde_json::json!({ "serving_until": until }));
+    db::update(ctx, TABLE, id, data).await.map(|_| ())
+}
+
+/// Release the lease taken by [`begin_attempt`].
+pub async fn end_attempt(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
+    let data = crate::util::json_map(serde_json::json!({ "serving_until": "" }));
+    db::update(ctx, TABLE, id, data).await.map(|_| ())
+}
+
+/// Add the `bytes` one attempt actually sent to share `id`'s running
+/// `bytes_served`, and stamp `completed_at` the first time the total
+/// reaches `size`.
+pub async fn record_bytes(
+    ctx: &dyn Context,
+    id: &str,
+    bytes: i64,
+    size: i64,
+) -> Result<(), WaferError> {
+    let by_id = Filter {
+        field: "id".to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(id.to_string()),
+    };
+    db::increment_field_where(ctx, TABLE, "bytes_served", bytes, &[by_id.clone()]).await?;
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "completed_at": &now,
+        "updated_at": &now,
+    }));
+    db::update_by_filters_count(
+        ctx,
+        TABLE,
+        vec![
+            by_id,
+            is_null("completed_at"),
+            Filter {
+                field: "bytes_served".to_string(),
+                operator: FilterOp::GreaterEqual,
+                value: serde_json::json!(size),
+            },
+        ],
+        data,
+    )
+    .await
+    .map(|_| ())
+}
+
 /// Append an access-log row for `share_id` (`accessed_at` stamped with
-/// [`crate::util::now_rfc3339`]).
+/// [`crate::util::now_rfc3339`]). `bytes_served` is what this one attempt
+/// sent, so a resumed download's rows add up to the bytes actually moved;
+/// `counted` is false for a ranged resume, which isn't another download.
 pub async fn log_access(
     ctx: &dyn Context,
     share_id: &str,
     ip_address: &str,
     user_agent: &str,
+    bytes_served: i64,
+    counted: bool,
 ) -> Result<Record, WaferError> {
     let data = crate::util::json_map(serde_json::json!({
         "share_id": share_id,
         "accessed_at": crate::util::now_rfc3339(),
         "ip_address": ip_address,
         "user_agent": user_agent,
+        "bytes_served": bytes_served,
+        "counted": i64::from(counted),
     }));
     db::create(ctx, ACCESS_LOGS_TABLE, data).await
 }
 
+/// Access-log rows of `share_ids` that counted as a download, logged at
+/// or after `since` (download stats).
+pub async fn list_counted_access_since(
+    ctx: &dyn Context,
+    share_ids: &[&str],
+    since: &str,
+) -> Result<Vec<Record>, WaferError> {
+    if share_ids.is_empty() {
+        return Ok(Vec::new());
+    }
+    let filters = vec![
+        Filter {
+            field: "share_id".to_string(),
+            operator: FilterOp::In,
+            value: serde_json::json!(share_ids),
+        },
+        Filter {
+            field: "accessed_at".to_string(),
+            operator: FilterOp::GreaterEqual,
+            value: serde_json::Value::String(since.to_string()),
+        },
+        Filter {
+            field: "counted".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::json!(1),
+        },
+    ];
+    db::list_all(ctx, ACCESS_LOGS_TABLE, filters).await
+}
+
 /// Access-log rows, newest first, optionally restricted to one share
 /// (admin audit listing).
 pub async fn list_access_logs(
@@ -0,0 +1,246 @@
+//! Row-level access over `suppers_ai__files__teams` and
+//! `suppers_ai__files__team_members`.
+//!
+//! A team is a named group with one `owner` member and any number of
+//! `member`s; the `(team_id, user_id)` pair is unique. Team-owned buckets
+//! and team usage live on the bucket / object rows (`team_id`, see
+//! [`super::buckets`] and [`super::objects`]); what membership allows is
+//! policy and lives in `files::teams`.
+
+use wafer_block::db::{Filter, FilterOp, SortField};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, ErrorCode, WaferError};
+
+use crate::util::RecordExt;
+
+/// Teams table — one row per team.
+pub const TABLE: &str = "suppers_ai__files__teams";
+/// Team membership table — one row per (team, user).
+pub const MEMBERS_TABLE: &str = "suppers_ai__files__team_members";
+
+/// The one member who manages membership and may delete the team.
+pub const ROLE_OWNER: &str = "owner";
+/// Every other member.
+pub const ROLE_MEMBER: &str = "member";
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(value.to_string()),
+    }
+}
+
+/// Create team `name` with `owner` as its owner member. Returns the team
+/// row.
+pub async fn insert(ctx: &dyn Context, name: &str, owner: &str) -> Result<Record, WaferError> {
+    let team = db::create(
+        ctx,
+        TABLE,
+        crate::util::json_map(serde_json::json!({
+            "name": name,
+            "created_by": owner,
+        })),
+    )
+    .await?;
+    if let Err(e) = add_member(ctx, &team.id, owner, ROLE_OWNER, owner).await {
+        // A team without an owner could never be managed or deleted.
+        db::delete(ctx, TABLE, &team.id).await.ok();
+        return Err(e);
+    }
+    Ok(team)
+}
+
+/// The team row `id`, or `None` when it does not exist.
+pub async fn find(ctx: &dyn Context, id: &str) -> Result<Option<Record>, WaferError> {
+    match db::get(ctx, TABLE, id).await {
+        Ok(r) => Ok(Some(r)),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// `user_id`'s role in `team_id`, or `None` when they are not a member.
+pub async fn role_of(
+    ctx: &dyn Context,
+    team_id: &str,
+    user_id: &str,
+) -> Result<Option<String>, WaferError> {
+    if team_id.is_empty() || user_id.is_empty() {
+        return Ok(None);
+    }
+    let rows = db::list_all(
+        ctx,
+        MEMBERS_TABLE,
+        vec![eq("team_id", team_id), eq("user_id", user_id)],
+    )
+    .await?;
+    Ok(rows
+        .into_iter()
+        .next()
+        .map(|r| r.str_field("role").to_string()))
+}
+
+/// Teams `user_id` belongs to, by name, each with a `role` field holding
+/// the caller's role.
+pub async fn list_for_user(ctx: &dyn Context, user_id: &str) -> Result<Vec<Record>, WaferError> {
+    let memberships = db::list_all(ctx, MEMBERS_TABLE, vec![eq("user_id", user_id)]).await?;
+    if memberships.is_empty() {
+        return Ok(Vec::new());
+    }
+    let ids = memberships
+        .iter()
+        .map(|m| serde_json::json!(m.str_field("team_id")))
+        .collect();
+    let mut teams = db::list_sorted(
+        ctx,
+        TABLE,
+        vec![Filter {
+            field: "id".to_string(),
+            operator: FilterOp::In,
+            value: serde_json::Value::Array(ids),
+        }],
+        vec![SortField {
+            field: "name".to_string(),
+            desc: false,
+        }],
+    )
+    .await?;
+    for team in &mut teams {
+        if let Some(m) = memberships
+            .iter()
+            .find(|m| m.str_field("team_id") == team.id)
+        {
+            team.data
+                .insert("role".to_string(), serde_json::json!(m.str_field("role")));
+        }
+    }
+    Ok(teams)
+}
+
+/// Members of `team_id`, oldest first (the owner is normally first).
+pub async fn list_members(ctx: &dyn Context, team_id: &str) -> Result<Vec<Record>, WaferError> {
+    db::list_sorted(
+        ctx,
+        MEMBERS_TABLE,
+        vec![eq("team_id", team_id)],
+        vec![SortField {
+            field: "created_at".to_string(),
+            desc: false,
+        }],
+    )
+    .await
+}
+
+/// Add `user_id` to `team_id` with `role`. A second row for the same pair
+/// is rejected by the unique index.
+pub async fn add_member(
+    ctx: &dyn Context,
+    team_id: &str,
+    user_id: &str,
+    role: &str,
+    added_by: &str,
+) -> Result<Record, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({
+        "team_id": team_id,
+        "user_id": user_id,
+        "role": role,
+        "added_by": added_by,
+    }));
+    db::create(ctx, MEMBERS_TABLE, data).await
+}
+
+/// Remove `user_id` from `team_id`. Returns whether a row was removed.
+pub async fn remove_member(
+    ctx: &dyn Context,
+    team_id: &str,
+    user_id: &str,
+) -> Result<bool, WaferError> {
+    let filters = vec![eq("team_id", team_id), eq("user_id", user_id)];
+    let n = db::count(ctx, MEMBERS_TABLE, &filters).await?;
+    if n == 0 {
+        return Ok(false);
+    }
+    db::delete_by_filters(ctx, MEMBERS_TABLE, filters).await?;
+    Ok(true)
+}
+
+/// Set `user_id`'s role in `team_id`. Returns the number of rows updated
+/// (0 when they are not a member).
+pub async fn set_role(
+    ctx: &dyn Context,
+    team_id: &str,
+    user_id: &str,
+    role: &str,
+) -> Result<i64, WaferError> {
+    let mut data = crate::util::json_map(serde_json::json!({ "role": role }));
+    crate::util::stamp_updated(&mut data);
+    db::update_by_filters_count(
+        ctx,
+        MEMBERS_TABLE,
+        vec![eq("team_id", team_id), eq("user_id", user_id)],
+        data,
+    )
+    .await
+}
+
+/// Set (or with `None`, clear back to the default) the team's storage cap.
+/// Returns the number of rows updated (0 for an unknown team).
+pub async fn set_max_storage_bytes(
+    ctx: &dyn Context,
+    team_id: &str,
+    max_storage_bytes: Option<i64>,
+) -> Result<i64, WaferError> {
+    let mut data =
+        crate::util::json_map(serde_json::json!({ "max_storage_bytes": max_storage_bytes }));
+    crate::util::stamp_updated(&mut data);
+    db::update_by_filters_count(ctx, TABLE, vec![eq("id", team_id)], data).await
+}
+
+/// Delete `team_id` and all of its membership rows. The caller deals with
+/// the team's buckets first.
+pub async fn delete(ctx: &dyn Context, team_id: &str) -> Result<(), WaferError> {
+    db::delete_by_filters(ctx, MEMBERS_TABLE, vec![eq("team_id", team_id)]).await?;
+    db::delete(ctx, TABLE, team_id).await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    #[tokio::test]
+    async fn creator_becomes_owner_and_roles_are_per_team() {
+        let ctx = TestContext::with_files().await;
+        let design = insert(&ctx, "Design", "alice").await.unwrap();
+        let ops = insert(&ctx, "Ops", "bob").await.unwrap();
+        add_member(&ctx, &ops.id, "alice", ROLE_MEMBER, "bob")
+            .await
+            .unwrap();
+
+        assert_eq!(
+            role_of(&ctx, &design.id, "alice").await.unwrap().as_deref(),
+            Some(ROLE_OWNER)
+        );
+        assert_eq!(
+            role_of(&ctx, &ops.id, "alice").await.unwrap().as_deref(),
+            Some(ROLE_MEMBER)
+        );
+        assert_eq!(role_of(&ctx, &design.id, "bob").await.unwrap(), None);
+        assert!(add_member(&ctx, &ops.id, "alice", ROLE_MEMBER, "bob")
+            .await
+            .is_err());
+
+        let teams = list_for_user(&ctx, "alice").await.unwrap();
+        let names: Vec<&str> = teams.iter().map(|t| t.str_field("name")).collect();
+        assert_eq!(names, ["Design", "Ops"]);
+        assert_eq!(teams[1].str_field("role"), ROLE_MEMBER);
+        assert!(list_for_user(&ctx, "carol").await.unwrap().is_empty());
+
+        assert!(remove_member(&ctx, &ops.id, "alice").await.unwrap());
+        assert!(!remove_member(&ctx, &ops.id, "alice").await.unwrap());
+        delete(&ctx, &design.id).await.unwrap();
+        assert!(find(&ctx, &design.id).await.unwrap().is_none());
+        assert_eq!(role_of(&ctx, &design.id, "alice").await.unwrap(), None);
+    }
+}
@@ -1,32 +1,42 @@
 //! Row-level access over `suppers_ai__files__views`.
 //!
 //! Object-view audit table — one row per tracked object download
-//! ([`insert`], written best-effort by `storage::handle_get_object`), read
+//! ([`record`], written best-effort by `storage::handle_get_object`), read
 //! back newest-first by the `/b/storage/api/recent` endpoint
 //! ([`list_recent_for_user`]).
+//!
+//! Views go through a [`WriteBuffer`]: a user opening the same object
+//! again before the next flush updates the pending row's `viewed_at`
+//! rather than adding a row, so a browsing session costs a write per
+//! object per [`crate::write_buffer::FLUSH_INTERVAL`], not one per open.
 
 use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
-use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_core::clients::database::{self as db, RecordList};
 use wafer_run::{context::Context, WaferError};
 
+use crate::write_buffer::WriteBuffer;
+
 /// Object-view audit table.
 pub const TABLE: &str = "suppers_ai__files__views";
 
+static VIEWS: WriteBuffer = WriteBuffer::new(TABLE);
+
 /// Record that `user_id` viewed `(bucket, key)` (`viewed_at` stamped with
-/// [`crate::util::now_rfc3339`]).
-pub async fn insert(
-    ctx: &dyn Context,
-    bucket: &str,
-    key: &str,
-    user_id: &str,
-) -> Result<Record, WaferError> {
-    let data = crate::util::json_map(serde_json::json!({
+/// [`crate::util::now_rfc3339`]). Best-effort: a failed write is logged.
+pub async fn record(ctx: &dyn Context, bucket: &str, key: &str, user_id: &str) {
+    let (coalesce_key, row) = view_row(bucket, key, user_id);
+    VIEWS.record(ctx, coalesce_key, row).await;
+}
+
+/// The buffer key and row for one view.
+fn view_row(bucket: &str, key: &str, user_id: &str) -> (String, crate::write_buffer::Row) {
+    let row = crate::util::json_map(serde_json::json!({
         "bucket": bucket,
         "key": key,
         "user_id": user_id,
         "viewed_at": crate::util::now_rfc3339(),
     }));
-    db::create(ctx, TABLE, data).await
+    (format!("{user_id}\n{bucket}\n{key}"), row)
 }
 
 /// `user_id`'s most recent views, newest first.
@@ -50,3 +60,44 @@ pub async fn list_recent_for_user(
     };
     db::list(ctx, TABLE, &opts).await
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{test_support::TestContext, write_buffer::FLUSH_INTERVAL};
+
+    /// A simulated minute of browsing a 100-file folder: the UI re-renders
+    /// the 20 visible thumbnails every half second while the user scrolls
+    /// one file per second, each render recording a view.
+    #[tokio::test]
+    async fn a_browsing_session_writes_once_per_object_per_flush() {
+        static BUFFER: WriteBuffer = WriteBuffer::new(TABLE);
+        let ctx = TestContext::with_files().await;
+        let ticks_per_flush = FLUSH_INTERVAL.as_millis() as usize / 500;
+
+        let mut opens = 0;
+        for tick in 0..120 {
+            let first = tick / 2;
+            for file in first..first + 20 {
+                let (key, row) = view_row("photos", &format!("img{file:03}.jpg"), "u1");
+                BUFFER.buffer(key, row).unwrap();
+                opens += 1;
+            }
+            if (tick + 1) % ticks_per_flush == 0 {
+                BUFFER.flush(&ctx).await;
+            }
+        }
+
+        let written = db::count(&ctx, TABLE, &[]).await.unwrap();
+        let stats = BUFFER.stats();
+        assert_eq!(opens, 2400, "one write per open without the buffer");
+        assert_eq!(stats.written, written as u64);
+        // Each 5-second flush covers the 20 visible files plus the 4 more
+        // scrolled into view: 12 flushes x 24 writes.
+        assert_eq!(written, 288);
+        assert_eq!(stats.recorded - stats.coalesced, stats.written);
+
+        let recent = list_recent_for_user(&ctx, "u1", 1).await.unwrap();
+        assert_eq!(recent.records[0].data["user_id"], "u1");
+    }
+}
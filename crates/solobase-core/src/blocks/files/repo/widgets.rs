@@ -0,0 +1,166 @@
+//! Row-level access over `suppers_ai__files__widget_sessions` and its child
+//! table `suppers_ai__files__widget_uploads`.
+//!
+//! A session row is one minted upload-widget token's limits (target bucket
+//! and folder, origins, types, size, upload count, expiry); each object the
+//! widget stores appends an upload row ([`record_upload`]). Both tables are
+//! owned here because the upload rows are meaningless without their
+//! session. What the limits mean is policy and lives in `files::widget`.
+
+use wafer_block::db::{Filter, FilterOp, SortField};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, ErrorCode, WaferError};
+
+/// Widget session table — one row per minted widget token.
+pub const TABLE: &str = "suppers_ai__files__widget_sessions";
+
+/// Widget upload table — one row per object a session stored.
+pub const UPLOADS_TABLE: &str = "suppers_ai__files__widget_uploads";
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::Value::String(value.to_string()),
+    }
+}
+
+/// Insert payload for [`insert`]. Borrowed fields — the caller keeps
+/// ownership.
+#[derive(Debug, Clone, Copy)]
+pub struct NewSession<'a> {
+    pub created_by: &'a str,
+    pub bucket: &'a str,
+    /// Folder prefix ending in `/`, or `""` for the bucket root.
+    pub folder: &'a str,
+    pub allowed_origins: &'a [String],
+    pub allowed_types: &'a [String],
+    /// Per-file cap in bytes; 0 falls back to the owner's quota.
+    pub max_bytes: i64,
+    pub max_uploads: i64,
+    /// RFC 3339 end of the session (and of its token).
+    pub expires_at: &'a str,
+    /// Hex SHA-256 every upload must have, or `""` for any content.
+    pub sha256: &'a str,
+}
+
+/// Insert a session row (`upload_count` starts at 0) and return it.
+pub async fn insert(ctx: &dyn Context, new: NewSession<'_>) -> Result<Record, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({
+        "created_by": new.created_by,
+        "bucket": new.bucket,
+        "folder": new.folder,
+        "allowed_origins": serde_json::to_string(new.allowed_origins).unwrap_or_default(),
+        "allowed_types": serde_json::to_string(new.allowed_types).unwrap_or_default(),
+        "max_bytes": new.max_bytes,
+        "max_uploads": new.max_uploads,
+        "upload_count": 0,
+        "expires_at": new.expires_at,
+        "sha256": new.sha256,
+    }));
+    db::create(ctx, TABLE, data).await
+}
+
+/// Look up a session by its primary `id`; `None` when there is none.
+pub async fn find(ctx: &dyn Context, id: &str) -> Result<Option<Record>, WaferError> {
+    match db::get(ctx, TABLE, id).await {
+        Ok(row) => Ok(Some(row)),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// Take one of session `id`'s uploads, provided fewer than `max` are
+/// taken. Atomic (`upload_count < max` sits in the UPDATE's WHERE), so
+/// concurrent uploads can't overshoot the cap. `Ok(false)` means the cap
+/// is reached.
+pub async fn claim_upload(ctx: &dyn Context, id: &str, max: i64) -> Result<bool, WaferError> {
+    let filters = vec![
+        eq("id", id),
+        Filter {
+            field: "upload_count".to_string(),
+            operator: FilterOp::LessThan,
+            value: serde_json::json!(max),
+        },
+    ];
+    let rows = db::increment_field_where(ctx, TABLE, "upload_count", 1, &filters).await?;
+    Ok(rows > 0)
+}
+
+/// Give back an upload [`claim_upload`] took for a file that wasn't
+/// stored.
+pub async fn release_upload(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
+    db::increment_field_where(ctx, TABLE, "upload_count", -1, &[eq("id", id)]).await?;
+    Ok(())
+}
+
+/// Record that session `session_id` stored object `object_id` at `key`,
+/// from a page on `origin`.
+pub async fn record_upload(
+    ctx: &dyn Context,
+    session_id: &str,
+    object: &Record,
+    origin: &str,
+) -> Result<Record, WaferError> {
+    let field = |name: &str| object.data.get(name).cloned().unwrap_or_default();
+    let data = crate::util::json_map(serde_json::json!({
+        "session_id": session_id,
+        "object_id": object.id,
+        "key": field("key"),
+        "size": field("size"),
+        "content_type": field("content_type"),
+        "origin": origin,
+    }));
+    db::create(ctx, UPLOADS_TABLE, data).await
+}
+
+/// Whether session `session_id` already stored an object at `key`.
+pub async fn has_upload_at(
+    ctx: &dyn Context,
+    session_id: &str,
+    key: &str,
+) -> Result<bool, WaferError> {
+    let n = db::count(
+        ctx,
+        UPLOADS_TABLE,
+        &[eq("session_id", session_id), eq("key", key)],
+    )
+    .await?;
+    Ok(n > 0)
+}
+
+/// The uploads of session `session_id`, oldest first.
+pub async fn list_uploads(ctx: &dyn Context, session_id: &str) -> Result<Vec<Record>, WaferError> {
+    db::list_sorted(
+        ctx,
+        UPLOADS_TABLE,
+        vec![eq("session_id", session_id)],
+        vec![SortField {
+            field: "created_at".to_string(),
+            desc: false,
+        }],
+    )
+    .await
+}
+
+/// Delete every session `user_id` minted, with their upload rows (account
+/// purge). The stored objects are not touched here.
+pub async fn delete_for_user(ctx: &dyn Context, user_id: &str) -> Result<(), WaferError> {
+    let sessions = db::list_all(ctx, TABLE, vec![eq("created_by", user_id)]).await?;
+    for session in &sessions {
+        db::delete_by_field(
+            ctx,
+            UPLOADS_TABLE,
+            "session_id",
+            serde_json::Value::String(session.id.clone()),
+        )
+        .await?;
+    }
+    db::delete_by_field(
+        ctx,
+        TABLE,
+        "created_by",
+        serde_json::Value::String(user_id.to_string()),
+    )
+    .await
+}
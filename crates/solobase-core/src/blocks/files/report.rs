@@ -0,0 +1,425 @@
+//! Admin storage usage report: `GET /admin/storage/report`, reached as
+//! `/b/admin/api/storage/report` through the admin block's storage
+//! delegation.
+//!
+//! Breaks stored bytes down per bucket and per team (`team_id = ""` is
+//! personal storage) with each team's top uploaders, lists the largest
+//! objects, and charts the last [`GROWTH_DAYS`] days of uploads by
+//! `created_at`. Everything comes from the objects table through a few
+//! GROUP BY aggregates (the daily series uses the driver's per-dialect date
+//! bucketing), so it works whether or not the cloudstorage routes are used.
+//! It also carries the last finished consistency check's totals (see
+//! `files::consistency`), among them the orphaned blobs it left in place
+//! — space the objects table can't see. The report is cached for
+//! [`CACHE_TTL`].
+//!
+//! `?format=csv` streams the same report as CSV, one row per line item with
+//! its kind in the `section` column.
+
+use std::{collections::HashMap, sync::Arc, time::Duration};
+
+use wafer_core::clients::database::Record;
+use wafer_run::{
+    context::Context, Message, MetaEntry, OutputStream, WaferError, META_RESP_CONTENT_TYPE,
+};
+
+use super::{consistency::RunSummary, repo};
+use crate::{
+    cache::TtlCache,
+    http::{err_bad_request, err_internal, ok_json},
+    util::{now_rfc3339, write_csv_row, RecordExt},
+};
+
+/// Top uploaders listed per team.
+const TOP_USERS: usize = 5;
+/// Largest objects listed.
+const LARGEST_OBJECTS: i64 = 20;
+/// Length of the daily growth series, ending today.
+const GROWTH_DAYS: i64 = 30;
+
+#[derive(Debug, Default, serde::Serialize)]
+pub(super) struct Report {
+    generated_at: String,
+    totals: Usage,
+    buckets: Vec<BucketUsage>,
+    teams: Vec<TeamUsage>,
+    largest_objects: Vec<LargeObject>,
+    growth: Vec<DayUsage>,
+    /// The last finished consistency check, if one has run.
+    consistency: Option<RunSummary>,
+}
+
+#[derive(Debug, Default, Clone, Copy, PartialEq, Eq, serde::Serialize)]
+struct Usage {
+    objects: i64,
+    bytes: i64,
+}
+
+impl Usage {
+    fn of(row: &Record) -> Self {
+        Self {
+            objects: row.i64_field("objects"),
+            bytes: repo::objects::usage_bytes(row),
+        }
+    }
+}
+
+#[derive(Debug, serde::Serialize)]
+struct BucketUsage {
+    bucket: String,
+    team_id: String,
+    #[serde(flatten)]
+    usage: Usage,
+    largest_object_bytes: i64,
+}
+
+#[derive(Debug, serde::Serialize)]
+struct TeamUsage {
+    /// `""` for personal storage.
+    team_id: String,
+    #[serde(flatten)]
+    usage: Usage,
+    top_users: Vec<UserUsage>,
+}
+
+#[derive(Debug, serde::Serialize)]
+struct UserUsage {
+    user_id: String,
+    #[serde(flatten)]
+    usage: Usage,
+}
+
+#[derive(Debug, serde::Serialize)]
+struct LargeObject {
+    bucket: String,
+    key: String,
+    size: i64,
+    uploaded_by: String,
+    created_at: String,
+}
+
+#[derive(Debug, serde::Serialize)]
+struct DayUsage {
+    day: String,
+    #[serde(flatten)]
+    usage: Usage,
+}
+
+/// The report as last built. Tests disable it: every `TestContext` has its
+/// own database but this cache is process-wide.
+static CACHE: TtlCache<Report> = TtlCache::new(CACHE_TTL);
+
+#[cfg(not(test))]
+const CACHE_TTL: Duration = Duration::from_secs(300);
+#[cfg(test)]
+const CACHE_TTL: Duration = Duration::ZERO;
+
+/// Sort by bytes, biggest first; ties by name for a stable order.
+fn by_bytes<T>(items: &mut [T], usage: impl Fn(&T) -> (i64, &str)) {
+    items.sort_by(|a, b| {
+        let (a_bytes, a_name) = usage(a);
+        let (b_bytes, b_name) = usage(b);
+        b_bytes.cmp(&a_bytes).then_with(|| a_name.cmp(b_name))
+    });
+}
+
+async fn build(ctx: &dyn Context) -> Result<Report, WaferError> {
+    let mut buckets: Vec<BucketUsage> = repo::objects::bucket_usage(ctx)
+        .await?
+        .iter()
+        .map(|r| BucketUsage {
+            bucket: r.str_field("bucket").to_string(),
+            team_id: r.str_field("team_id").to_string(),
+            usage: Usage::of(r),
+            largest_object_bytes: r.i64_field("largest"),
+        })
+        .collect();
+    by_bytes(&mut buckets, |b| (b.usage.bytes, &b.bucket));
+
+    let mut top_users: HashMap<String, Vec<UserUsage>> = HashMap::new();
+    for r in repo::objects::usage_by(ctx, &["team_id", "uploaded_by"]).await? {
+        top_users
+            .entry(r.str_field("team_id").to_string())
+            .or_default()
+            .push(UserUsage {
+                user_id: r.str_field("uploaded_by").to_string(),
+                usage: Usage::of(&r),
+            });
+    }
+    let mut teams: Vec<TeamUsage> = repo::objects::usage_by(ctx, &["team_id"])
+        .await?
+        .iter()
+        .map(|r| {
+            let team_id = r.str_field("team_id").to_string();
+            let mut users = top_users.remove(&team_id).unwrap_or_default();
+            by_bytes(&mut users, |u| (u.usage.bytes, &u.user_id));
+            users.truncate(TOP_USERS);
+            TeamUsage {
+                team_id,
+                usage: Usage::of(r),
+                top_users: users,
+            }
+        })
+        .collect();
+    by_bytes(&mut teams, |t| (t.usage.bytes, &t.team_id));
+
+    let largest_objects = repo::objects::largest(ctx, LARGEST_OBJECTS)
+        .await?
+        .iter()
+        .map(|r| LargeObject {
+            bucket: r.str_field("bucket").to_string(),
+            key: r.str_field("key").to_string(),
+            size: r.i64_field("size"),
+            uploaded_by: r.str_field("uploaded_by").to_string(),
+            created_at: r.str_field("created_at").to_string(),
+        })
+        .collect();
+
+    let today = chrono::Utc::now().date_naive();
+    let start = today - chrono::Duration::days(GROWTH_DAYS - 1);
+    let daily: HashMap<String, Usage> =
+        repo::objects::daily_usage_since(ctx, &format!("{start}T00:00:00"))
+            .await?
+            .iter()
+            .map(|r| (r.str_field("created_at").to_string(), Usage::of(r)))
+            .collect();
+    let growth = start
+        .iter_days()
+        .take(GROWTH_DAYS as usize)
+        .map(|day| {
+            let day = day.to_string();
+            let usage = daily.get(&day).copied().unwrap_or_default();
+            DayUsage { day, usage }
+        })
+        .collect();
+
+    let totals = teams.iter().fold(Usage::default(), |acc, t| Usage {
+        objects: acc.objects + t.usage.objects,
+        bytes: acc.bytes + t.usage.bytes,
+    });
+    Ok(Report {
+        generated_at: now_rfc3339(),
+        totals,
+        buckets,
+        teams,
+        largest_objects,
+        growth,
+        consistency: super::consistency::last_finished(ctx).await?,
+    })
+}
+
+/// [`build`] through the cache. `Instant` panics on wasm32, so the browser
+/// and Cloudflare builds rebuild the report on every call instead.
+async fn cached(ctx: &dyn Context) -> Result<Arc<Report>, WaferError> {
+    if cfg!(target_arch = "wasm32") {
+        return build(ctx).await.map(Arc::new);
+    }
+    let mut failed = None;
+    let slot = &mut failed;
+    let hit = CACHE
+        .get_or_load(|| async move {
+            build(ctx).await.unwrap_or_else(|e| {
+                *slot = Some(e);
+                Report::default()
+            })
+        })
+        .await;
+    if let Some(e) = failed {
+        // Don't pin a transient backend failure for a whole TTL.
+        CACHE.invalidate();
+        return Err(e);
+    }
+    Ok(hit)
+}
+
+pub(super) async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let format = msg.query("format");
+    let csv = format.eq_ignore_ascii_case("csv");
+    if !format.is_empty() && !csv && !format.eq_ignore_ascii_case("json") {
+        return err_bad_request("Unsupported report format (expected json or csv)");
+    }
+    let report = match cached(ctx).await {
+        Ok(report) => report,
+        Err(e) => return err_internal("Database error", e),
+    };
+    if csv {
+        csv_stream(report)
+    } else {
+        ok_json(&*report)
+    }
+}
+
+/// CSV columns; cells that don't apply to a section are empty.
+const CSV_HEADER: [&str; 8] = [
+    "section", "bucket", "team_id", "user_id", "day", "key", "objects", "bytes",
+];
+
+fn csv_rows(report: &Report) -> Vec<[String; 8]> {
+    let row =
+        |section: &str, bucket: &str, team: &str, user: &str, day: &str, key: &str, u: Usage| {
+            [section, bucket, team, user, day, key]
+                .map(str::to_string)
+                .into_iter()
+                .chain([u.objects.to_string(), u.bytes.to_string()])
+                .collect::<Vec<_>>()
+                .try_into()
+                .unwrap_or_else(|_| unreachable!("eight cells"))
+        };
+    let mut rows = vec![row("total", "", "", "", "", "", report.totals)];
+    for b in &report.buckets {
+        rows.push(row("bucket", &b.bucket, &b.team_id, "", "", "", b.usage));
+    }
+    for t in &report.teams {
+        rows.push(row("team", "", &t.team_id, "", "", "", t.usage));
+        for u in &t.top_users {
+            rows.push(row("user", "", &t.team_id, &u.user_id, "", "", u.usage));
+        }
+    }
+    for o in &report.largest_objects {
+        let usage = Usage {
+            objects: 1,
+            bytes: o.size,
+        };
+        rows.push(row(
+            "object",
+            &o.bucket,
+            "",
+            &o.uploaded_by,
+            "",
+            &o.key,
+            usage,
+        ));
+    }
+    for d in &report.growth {
+        rows.push(row("day", "", "", "", &d.day, "", d.usage));
+    }
+    if let Some(run) = &report.consistency {
+        let usage = Usage {
+            objects: run.totals.reclaimable,
+            bytes: run.totals.reclaimable_bytes,
+        };
+        rows.push(row("reclaimable", "", "", "", "", "", usage));
+    }
+    rows
+}
+
+fn csv_stream(report: Arc<Report>) -> OutputStream {
+    OutputStream::from_producer(move |sink, _cancel| async move {
+        let _ = sink
+            .send_meta(MetaEntry {
+                key: META_RESP_CONTENT_TYPE.to_string(),
+                value: "text/csv; charset=utf-8".to_string(),
+            })
+            .await;
+        let _ = sink
+            .send_meta(MetaEntry {
+                key: "resp.header.Content-Disposition".to_string(),
+                value: "attachment; filename=\"storage-report.csv\"".to_string(),
+            })
+            .await;
+        let mut header = String::new();
+        write_csv_row(&mut header, CSV_HEADER.into_iter());
+        if sink.send_chunk(header.into_bytes()).await.is_err() {
+            return;
+        }
+        for chunk in csv_rows(&report).chunks(500) {
+            let mut out = String::new();
+            for cells in chunk {
+                write_csv_row(&mut out, cells.iter().map(String::as_str));
+            }
+            if sink.send_chunk(out.into_bytes()).await.is_err() {
+                return;
+            }
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{admin_msg, output_body, output_json, output_status, TestContext};
+
+    async fn seed(ctx: &TestContext, bucket: &str, key: &str, size: i64, user: &str, team: &str) {
+        let data = crate::util::json_map(serde_json::json!({
+            "bucket": bucket,
+            "key": key,
+            "key_lower": key.to_lowercase(),
+            "size": size,
+            "content_type": "application/octet-stream",
+            "status": "complete",
+            "uploaded_by": user,
+            "team_id": team,
+            "created_at": now_rfc3339(),
+        }));
+        repo::objects::seed(ctx, data).await.expect("seed object");
+    }
+
+    fn report_msg(format: &str) -> Message {
+        let mut msg = admin_msg("retrieve", "/admin/storage/report");
+        msg.set_meta("req.query.format", format);
+        msg
+    }
+
+    #[tokio::test]
+    async fn report_breaks_usage_down_by_bucket_team_and_user() {
+        let ctx = TestContext::with_files().await;
+        seed(&ctx, "photos", "a.jpg", 1000, "alice", "").await;
+        seed(&ctx, "photos", "b.jpg", 3000, "bob", "").await;
+        seed(&ctx, "design", "logo.svg", 500, "alice", "team-1").await;
+        let pending = crate::util::json_map(serde_json::json!({
+            "bucket": "photos", "key": "c.jpg", "key_lower": "c.jpg", "size": 9000,
+            "status": "pending", "uploaded_by": "alice",
+        }));
+        repo::objects::seed(&ctx, pending).await.unwrap();
+
+        let out = output_json(handle(&ctx, &report_msg("")).await).await;
+        assert_eq!(
+            out["totals"],
+            serde_json::json!({"objects": 3, "bytes": 4500})
+        );
+        assert_eq!(out["buckets"][0]["bucket"], "photos");
+        assert_eq!(out["buckets"][0]["bytes"], 4000);
+        assert_eq!(out["buckets"][0]["largest_object_bytes"], 3000);
+        assert_eq!(out["buckets"][1]["team_id"], "team-1");
+
+        assert_eq!(out["teams"][0]["team_id"], "");
+        let users: Vec<&str> = out["teams"][0]["top_users"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|u| u["user_id"].as_str().unwrap())
+            .collect();
+        assert_eq!(users, ["bob", "alice"]);
+        assert_eq!(out["largest_objects"][0]["key"], "b.jpg");
+
+        let growth = out["growth"].as_array().unwrap();
+        assert_eq!(growth.len(), GROWTH_DAYS as usize);
+        assert_eq!(growth.last().unwrap()["objects"], 3);
+        assert_eq!(growth.last().unwrap()["bytes"], 4500);
+        assert_eq!(growth[0]["objects"], 0);
+    }
+
+    #[tokio::test]
+    async fn csv_export_has_one_row_per_line_item() {
+        let ctx = TestContext::with_files().await;
+        seed(&ctx, "photos", "a,b.jpg", 1000, "alice", "").await;
+
+        let body = output_body(handle(&ctx, &report_msg("csv")).await).await;
+        let text = String::from_utf8(body).unwrap();
+        let lines: Vec<&str> = text.split("\r\n").filter(|l| !l.is_empty()).collect();
+        assert_eq!(
+            lines[0],
+            "section,bucket,team_id,user_id,day,key,objects,bytes"
+        );
+        assert_eq!(lines[1], "total,,,,,,1,1000");
+        assert!(lines.contains(&"bucket,photos,,,,,1,1000"));
+        assert!(lines.contains(&"object,photos,,alice,,\"a,b.jpg\",1,1000"));
+        // header + total + bucket + team + user + object + 30 days
+        assert_eq!(lines.len(), 6 + GROWTH_DAYS as usize);
+
+        assert_eq!(
+            output_status(handle(&ctx, &report_msg("xml")).await).await,
+            400
+        );
+    }
+}
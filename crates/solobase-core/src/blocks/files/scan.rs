@@ -0,0 +1,666 @@
+//! Malware scanning of uploads.
+//!
+//! Scanning is off until the host registers a [`ScanProvider`] — on native,
+//! `SolobaseBuilder::scan_provider` with the ClamAV client. The provider
+//! runs behind its own block, [`ScannerBlock`] (`suppers-ai/scanner`), so
+//! this block reaches it through `ctx.call_block` like any other service
+//! and tests register a fake. Without it, uploads behave exactly as before.
+//!
+//! - Uploads up to [`SYNC_MAX_BYTES_KEY`] are scanned inline. An infected
+//!   file is rejected with `malware_detected` (422) and never stored.
+//! - Larger uploads, and ones the scanner couldn't read inline, are stored
+//!   as `pending_scan` and scanned by a [`SCAN_JOB`]. Until the scan clears
+//!   them only the uploader can read them: no shares, grants or public
+//!   bucket access.
+//! - A `pending_scan` object found infected is quarantined: hidden from
+//!   listings, unreadable, and its uploader is emailed. Admins list,
+//!   release or delete quarantined objects under
+//!   `/admin/storage/quarantine`.
+//!
+//! Scan results are recorded under the object's `system.scan` metadata.
+
+use std::sync::Arc;
+
+use wafer_block::{MaybeSend, MaybeSync};
+use wafer_core::clients::{config, storage as store};
+use wafer_run::{
+    context::Context, Block, BlockInfo, ErrorCode, InputStream, InstanceMode, Message, OutputStream,
+};
+
+use super::{
+    history, metadata, repo, repo::objects::STATUS_PENDING_SCAN, repo::objects::STATUS_QUARANTINED,
+};
+use crate::{
+    blocks::{admin::audit_log, errors, jobs::JobError},
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+    services::Services,
+    util::RecordExt,
+};
+
+/// Name the scanner block is registered under.
+pub const SCANNER_BLOCK: &str = "suppers-ai/scanner";
+
+/// Message kind the scanner block answers: the body is the file, the
+/// [`META_FILENAME`] meta its name, the response a JSON [`Verdict`].
+pub const SCAN_KIND: &str = "scanner.scan";
+/// Meta key carrying the scanned file's name.
+pub const META_FILENAME: &str = "scan.filename";
+
+/// Config key: largest upload, in bytes, scanned inline. Larger ones are
+/// held as `pending_scan` for a [`SCAN_JOB`].
+pub(crate) const SYNC_MAX_BYTES_KEY: &str = "SUPPERS_AI__FILES__SCAN_SYNC_MAX_BYTES";
+pub(crate) const DEFAULT_SYNC_MAX_BYTES: &str = "10485760";
+
+/// Job type scanning one `pending_scan` object: `{"object_id": …}`.
+pub const SCAN_JOB: &str = "files.scan";
+
+/// What a scanner made of one file.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+#[serde(tag = "verdict", rename_all = "snake_case")]
+pub enum Verdict {
+    Clean,
+    Infected {
+        signature: String,
+    },
+    /// The scanner couldn't decide — unreachable, timed out, or the file is
+    /// over its size limit. Never treated as clean.
+    Unscannable {
+        reason: String,
+    },
+}
+
+/// A malware scanner. Implementations report failures as
+/// [`Verdict::Unscannable`] rather than erroring.
+#[cfg_attr(target_arch = "wasm32", async_trait::async_trait(?Send))]
+#[cfg_attr(not(target_arch = "wasm32"), async_trait::async_trait)]
+pub trait ScanProvider: MaybeSend + MaybeSync {
+    async fn scan(&self, data: &[u8], filename: &str) -> Verdict;
+}
+
+/// Serves a [`ScanProvider`] as the `suppers-ai/scanner` block.
+pub struct ScannerBlock {
+    provider: Arc<dyn ScanProvider>,
+}
+
+impl ScannerBlock {
+    pub fn new(provider: Arc<dyn ScanProvider>) -> Self {
+        Self { provider }
+    }
+}
+
+#[wafer_block::wafer_async_trait]
+impl Block for ScannerBlock {
+    fn info(&self) -> BlockInfo {
+        BlockInfo::new(
+            SCANNER_BLOCK,
+            "0.0.1",
+            "scanner@v1",
+            "Malware scanning for uploaded files",
+        )
+        .instance_mode(InstanceMode::Singleton)
+        .category(wafer_run::BlockCategory::Service)
+    }
+
+    async fn handle(&self, _ctx: &dyn Context, msg: Message, input: InputStream) -> OutputStream {
+        if msg.kind != SCAN_KIND {
+            return err_not_found("not found");
+        }
+        let data = input.collect_to_bytes().await;
+        ok_json(&self.provider.scan(&data, msg.get_meta(META_FILENAME)).await)
+    }
+}
+
+/// Whether a scanner is registered.
+fn enabled(ctx: &dyn Context) -> bool {
+    ctx.registered_blocks()
+        .iter()
+        .any(|b| b.name == SCANNER_BLOCK)
+}
+
+/// Scan `data` through the scanner block. A failed call is `Unscannable`.
+async fn scan(ctx: &dyn Context, data: &[u8], filename: &str) -> Verdict {
+    let mut msg = Message::new(SCAN_KIND);
+    msg.set_meta(META_FILENAME, filename);
+    let out = ctx
+        .call_block(SCANNER_BLOCK, msg, InputStream::from_bytes(data.to_vec()))
+        .await;
+    match out.collect_buffered().await {
+        Ok(buf) => serde_json::from_slice(&buf.body).unwrap_or_else(|e| Verdict::Unscannable {
+            reason: format!("invalid scanner response: {e}"),
+        }),
+        Err(e) => Verdict::Unscannable {
+            reason: format!("scanner call failed: {e:?}"),
+        },
+    }
+}
+
+/// How an upload may be stored.
+#[derive(Debug, PartialEq, Eq)]
+pub(super) enum Admission {
+    /// Store as `complete`: scanned clean, or scanning is off.
+    Store,
+    /// Store as `pending_scan` and leave the verdict to a [`SCAN_JOB`].
+    Hold,
+    /// Infected: don't store it.
+    Reject { signature: String },
+}
+
+/// Decide how the upload `key` with `content` is stored, scanning it
+/// inline when it is small enough.
+pub(super) async fn admit(ctx: &dyn Context, content: &[u8], key: &str) -> Admission {
+    if !enabled(ctx) {
+        return Admission::Store;
+    }
+    let sync_max: usize = config::get_default(ctx, SYNC_MAX_BYTES_KEY, DEFAULT_SYNC_MAX_BYTES)
+        .await
+        .parse()
+        .unwrap_or(10 * 1024 * 1024);
+    if content.len() > sync_max {
+        return Admission::Hold;
+    }
+    match scan(ctx, content, key).await {
+        Verdict::Clean => Admission::Store,
+        Verdict::Infected { signature } => {
+            tracing::warn!(key, signature = %signature, "upload rejected by malware scan");
+            Admission::Reject { signature }
+        }
+        Verdict::Unscannable { reason } => {
+            tracing::warn!(key, reason = %reason, "inline scan failed; holding upload for a scan job");
+            Admission::Hold
+        }
+    }
+}
+
+/// The response for an upload [`admit`] rejected.
+pub(super) fn rejected(signature: &str) -> OutputStream {
+    errors::error_json(
+        errors::ErrorCode::MalwareDetected,
+        "File rejected by the malware scanner",
+        Some(serde_json::json!({ "signature": signature })),
+    )
+}
+
+/// Queue the [`SCAN_JOB`] for a freshly stored `pending_scan` row.
+pub(super) async fn queue(ctx: &dyn Context, object_id: &str) {
+    let jobs = Services::new(ctx, "suppers-ai/files").jobs();
+    let payload = serde_json::json!({ "object_id": object_id });
+    if let Err(e) = jobs.enqueue(SCAN_JOB, &payload, Default::default()).await {
+        tracing::warn!(error = %e, object_id, "failed to queue malware scan");
+    }
+}
+
+/// Why `user_id` may not read `(bucket, key)` right now, as the response
+/// to send; `None` when the scan status doesn't stand in the way.
+/// Quarantined objects read as missing; `pending_scan` ones are readable by
+/// their uploader only (`user_id` is empty for share links).
+pub(super) async fn read_blocked(
+    ctx: &dyn Context,
+    user_id: &str,
+    bucket: &str,
+    key: &str,
+) -> Option<OutputStream> {
+    let row = match repo::objects::find_by_bucket_key(ctx, bucket, key).await {
+        Ok(row) => row?,
+        Err(e) => return Some(err_internal("Database error", e)),
+    };
+    match row.str_field("status") {
+        STATUS_QUARANTINED => Some(errors::error_response(
+            errors::ErrorCode::ObjectNotFound,
+            "Object not found",
+        )),
+        STATUS_PENDING_SCAN if user_id.is_empty() || row.str_field("uploaded_by") != user_id => {
+            Some(errors::error_response(
+                errors::ErrorCode::ScanPending,
+                "Object is waiting for its malware scan",
+            ))
+        }
+        _ => None,
+    }
+}
+
+/// `row`'s metadata with `system.scan` set to `scan`, serialized.
+fn with_scan_record(
+    row: &wafer_core::clients::database::Record,
+    scan: serde_json::Value,
+) -> String {
+    let mut stored = metadata::parse_stored(row.str_field("metadata"));
+    let system = stored
+        .entry(metadata::SYSTEM_KEY.to_string())
+        .or_insert_with(|| serde_json::json!({}));
+    if !system.is_object() {
+        *system = serde_json::json!({});
+    }
+    system["scan"] = scan;
+    serde_json::Value::Object(stored).to_string()
+}
+
+/// Run a [`SCAN_JOB`]. A row that was deleted, or already left
+/// `pending_scan`, is done; an unscannable verdict retries.
+pub async fn run_scan_job(ctx: &dyn Context, input: InputStream) -> Result<(), JobError> {
+    #[derive(serde::Deserialize)]
+    struct Payload {
+        object_id: String,
+    }
+    let raw = input.collect_to_bytes().await;
+    let payload: Payload = serde_json::from_slice(&raw)
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+
+    let row = match repo::objects::get(ctx, &payload.object_id).await {
+        Ok(row) => row,
+        Err(e) if e.code == ErrorCode::NotFound => return Ok(()),
+        Err(e) => return Err(JobError::transient(format!("object lookup failed: {e}"))),
+    };
+    if row.str_field("status") != STATUS_PENDING_SCAN {
+        return Ok(());
+    }
+    if !enabled(ctx) {
+        return Err(JobError::transient("no malware scanner is registered"));
+    }
+    let (bucket, key) = (row.str_field("bucket"), row.str_field("key"));
+    let data = match store::get(ctx, bucket, repo::objects::storage_key(&row)).await {
+        Ok((data, _)) => data,
+        Err(e) if e.code == ErrorCode::NotFound => return Ok(()),
+        Err(e) => return Err(JobError::transient(format!("reading object failed: {e}"))),
+    };
+
+    let scanned_at = crate::util::now_rfc3339();
+    let (status, record) = match scan(ctx, &data, key).await {
+        Verdict::Clean => (
+            "complete",
+            serde_json::json!({ "verdict": "clean", "scanned_at": scanned_at }),
+        ),
+        Verdict::Infected { signature } => (
+            STATUS_QUARANTINED,
+            serde_json::json!({
+                "verdict": "infected",
+                "signature": signature,
+                "scanned_at": scanned_at,
+            }),
+        ),
+        Verdict::Unscannable { reason } => return Err(JobError::transient(reason)),
+    };
+    let metadata = with_scan_record(&row, record.clone());
+    let moved = repo::objects::transition(ctx, &row.id, STATUS_PENDING_SCAN, status, &metadata)
+        .await
+        .map_err(|e| JobError::transient(format!("recording scan result failed: {e}")))?;
+    if moved && status == STATUS_QUARANTINED {
+        tracing::warn!(bucket, key, signature = %record["signature"], "object quarantined by malware scan");
+        notify_uploader(ctx, &row, record["signature"].as_str().unwrap_or("")).await;
+    }
+    Ok(())
+}
+
+/// Email the uploader of a newly quarantined object. Best-effort.
+async fn notify_uploader(
+    ctx: &dyn Context,
+    row: &wafer_core::clients::database::Record,
+    signature: &str,
+) {
+    let services = Services::new(ctx, "suppers-ai/files");
+    let email = match services
+        .users()
+        .find_by_id(row.str_field("uploaded_by"))
+        .await
+    {
+        Ok(Some(user)) => user.email,
+        Ok(None) => return,
+        Err(e) => {
+            tracing::warn!(error = %e, "quarantine notice: uploader lookup failed");
+            return;
+        }
+    };
+    let body = serde_json::json!({
+        "template": "file_quarantined",
+        "to": email,
+        "file": format!("{}/{}", row.str_field("bucket"), row.str_field("key")),
+        "signature": signature,
+    });
+    services.mailer().send("email.send_template", body).await;
+}
+
+// ---------------------------------------------------------------------------
+// Admin API
+// ---------------------------------------------------------------------------
+
+/// Route the quarantine part of the admin storage API; `None` when `path`
+/// is not a quarantine path.
+pub async fn handle_admin(ctx: &dyn Context, msg: &Message) -> Option<OutputStream> {
+    let rest = msg.path().strip_prefix("/admin/storage/quarantine")?;
+    Some(match (msg.action(), rest) {
+        ("retrieve", "") => handle_list(ctx, msg).await,
+        ("delete", _) => match rest.strip_prefix('/') {
+            Some(id) if !id.is_empty() && !id.contains('/') => handle_delete(ctx, msg, id).await,
+            _ => err_not_found("not found"),
+        },
+        ("create", _) => match rest
+            .strip_prefix('/')
+            .and_then(|r| r.strip_suffix("/release"))
+        {
+            Some(id) if !id.is_empty() && !id.contains('/') => handle_release(ctx, msg, id).await,
+            _ => err_not_found("not found"),
+        },
+        _ => err_not_found("not found"),
+    })
+}
+
+/// `GET /admin/storage/quarantine?page=&page_size=` — quarantined objects,
+/// most recently flagged first.
+async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let (page, page_size, _) = msg.pagination_params(50);
+    match repo::objects::list_by_status(ctx, STATUS_QUARANTINED, page as i64, page_size as i64)
+        .await
+    {
+        Ok(mut list) => {
+            for record in &mut list.records {
+                metadata::expand(record);
+            }
+            ok_json(&list)
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// The quarantined row `id`, or the response to send instead.
+async fn quarantined(
+    ctx: &dyn Context,
+    id: &str,
+) -> Result<wafer_core::clients::database::Record, OutputStream> {
+    match repo::objects::get(ctx, id).await {
+        Ok(row) if row.str_field("status") == STATUS_QUARANTINED => Ok(row),
+        Ok(_) => Err(err_bad_request("Object is not quarantined")),
+        Err(e) if e.code == ErrorCode::NotFound => Err(err_not_found("Object not found")),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// `POST /admin/storage/quarantine/{id}/release` — the admin judged the
+/// verdict a false positive: the object becomes a normal `complete` one.
+async fn handle_release(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    let row = match quarantined(ctx, id).await {
+        Ok(row) => row,
+        Err(r) => return r,
+    };
+    let mut record = metadata::parse_stored(row.str_field("metadata"))
+        .get(metadata::SYSTEM_KEY)
+        .and_then(|s| s.get("scan"))
+        .cloned()
+        .unwrap_or_else(|| serde_json::json!({}));
+    record["released_by"] = serde_json::json!(msg.user_id());
+    record["released_at"] = serde_json::json!(crate::util::now_rfc3339());
+    let metadata = with_scan_record(&row, record);
+    match repo::objects::transition(ctx, id, STATUS_QUARANTINED, "complete", &metadata).await {
+        Ok(true) => {}
+        Ok(false) => return err_bad_request("Object is not quarantined"),
+        Err(e) => return err_internal("Database error", e),
+    }
+    let event = history::Event::new(history::ACTION_RESTORE, &row, msg.user_id()).change(
+        serde_json::json!({ "status": STATUS_QUARANTINED }),
+        serde_json::json!({ "status": "complete" }),
+    );
+    history::record(ctx, event).await;
+    let (bucket, key) = (row.str_field("bucket"), row.str_field("key"));
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "storage.quarantine.release",
+        &format!("object:{bucket}/{key}"),
+        msg.remote_addr(),
+    )
+    .await;
+    ok_json(&serde_json::json!({ "released": true, "bucket": bucket, "key": key }))
+}
+
+/// `DELETE /admin/storage/quarantine/{id}` — remove the blob and its row.
+async fn handle_delete(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    let row = match quarantined(ctx, id).await {
+        Ok(row) => row,
+        Err(r) => return r,
+    };
+    let (bucket, key) = (row.str_field("bucket"), row.str_field("key"));
+    if let Err(r) = super::locks::guard(ctx, bucket, &[key]).await {
+        return r;
+    }
+    match super::storage::delete_object_and_metadata(ctx, bucket, key).await {
+        Ok(()) => {}
+        // The blob is already gone; drop the row so it stops counting.
+        Err(e) if e.code == ErrorCode::NotFound => {
+            super::storage::delete_object_metadata(ctx, bucket, key).await;
+        }
+        Err(e) => return err_internal("Delete failed", e),
+    }
+    history::record(ctx, history::Event::deleted(&row, msg.user_id())).await;
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "storage.quarantine.delete",
+        &format!("object:{bucket}/{key}"),
+        msg.remote_addr(),
+    )
+    .await;
+    ok_json(&serde_json::json!({ "deleted": true }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{
+        admin_msg, auth_msg, output_body, output_is_error, output_json, output_status, TestContext,
+    };
+
+    /// Flags anything containing `EICAR`; can't read anything containing
+    /// `UNREADABLE`.
+    struct FakeScanner;
+
+    #[async_trait::async_trait]
+    impl ScanProvider for FakeScanner {
+        async fn scan(&self, data: &[u8], _filename: &str) -> Verdict {
+            let text = String::from_utf8_lossy(data);
+            if text.contains("EICAR") {
+                Verdict::Infected {
+                    signature: "Eicar-Test-Signature".to_string(),
+                }
+            } else if text.contains("UNREADABLE") {
+                Verdict::Unscannable {
+                    reason: "scanner offline".to_string(),
+                }
+            } else {
+                Verdict::Clean
+            }
+        }
+    }
+
+    /// Files + storage with the fake scanner registered; uploads over 16
+    /// bytes are scanned asynchronously.
+    async fn ctx_with_scanner() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        ctx.register_block(
+            SCANNER_BLOCK,
+            Arc::new(ScannerBlock::new(Arc::new(FakeScanner))),
+        );
+        ctx.set_config(SYNC_MAX_BYTES_KEY, "16");
+        let bucket = crate::util::json_map(serde_json::json!({
+            "name": "docs",
+            "public": false,
+            "created_by": "alice",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(&ctx, bucket)
+            .await
+            .expect("seed bucket");
+        ctx
+    }
+
+    async fn upload(ctx: &TestContext, key: &str, body: &[u8]) -> OutputStream {
+        let mut msg = auth_msg("create", "/b/storage/api/buckets/docs/objects", "alice");
+        msg.set_meta("req.query.key", key);
+        msg.set_meta("req.content_type", "text/plain");
+        super::super::storage::handle(ctx, msg, InputStream::from_bytes(body.to_vec())).await
+    }
+
+    async fn download(ctx: &TestContext, user: &str, key: &str) -> OutputStream {
+        let path = format!("/b/storage/api/buckets/docs/objects/{key}");
+        super::super::storage::handle(ctx, auth_msg("retrieve", &path, user), InputStream::empty())
+            .await
+    }
+
+    async fn row(ctx: &TestContext, key: &str) -> wafer_core::clients::database::Record {
+        repo::objects::find_by_bucket_key(ctx, "docs", key)
+            .await
+            .unwrap()
+            .expect("object row")
+    }
+
+    async fn run_job(ctx: &TestContext, object_id: &str) -> Result<(), JobError> {
+        let payload = serde_json::json!({ "object_id": object_id });
+        run_scan_job(
+            ctx,
+            InputStream::from_bytes(payload.to_string().into_bytes()),
+        )
+        .await
+    }
+
+    #[tokio::test]
+    async fn small_infected_upload_is_rejected_and_not_stored() {
+        let ctx = ctx_with_scanner().await;
+        assert_eq!(
+            output_status(upload(&ctx, "bad.txt", b"EICAR").await).await,
+            422
+        );
+        assert!(repo::objects::find_by_bucket_key(&ctx, "docs", "bad.txt")
+            .await
+            .unwrap()
+            .is_none());
+        let opts = store::ListOptions {
+            prefix: String::new(),
+            limit: 10,
+            offset: 0,
+        };
+        assert!(store::list(&ctx, "docs", &opts)
+            .await
+            .unwrap()
+            .objects
+            .is_empty());
+
+        output_json(upload(&ctx, "ok.txt", b"hello").await).await;
+        assert_eq!(row(&ctx, "ok.txt").await.str_field("status"), "complete");
+    }
+
+    #[tokio::test]
+    async fn large_upload_waits_for_its_scan_and_clears() {
+        let ctx = ctx_with_scanner().await;
+        output_json(upload(&ctx, "big.txt", b"a perfectly ordinary file").await).await;
+        let held = row(&ctx, "big.txt").await;
+        assert_eq!(held.str_field("status"), STATUS_PENDING_SCAN);
+
+        // The uploader may read it; nobody else may yet.
+        assert_eq!(
+            output_body(download(&ctx, "alice", "big.txt").await).await,
+            b"a perfectly ordinary file"
+        );
+        assert!(read_blocked(&ctx, "", "docs", "big.txt").await.is_some());
+
+        run_job(&ctx, &held.id).await.expect("scan job");
+        let cleared = row(&ctx, "big.txt").await;
+        assert_eq!(cleared.str_field("status"), "complete");
+        assert!(cleared
+            .str_field("metadata")
+            .contains("\"verdict\":\"clean\""));
+        assert!(read_blocked(&ctx, "", "docs", "big.txt").await.is_none());
+    }
+
+    #[tokio::test]
+    async fn unscannable_upload_is_held_and_retried() {
+        let ctx = ctx_with_scanner().await;
+        output_json(upload(&ctx, "odd.txt", b"UNREADABLE").await).await;
+        let held = row(&ctx, "odd.txt").await;
+        assert_eq!(held.str_field("status"), STATUS_PENDING_SCAN);
+        assert!(run_job(&ctx, &held.id).await.is_err());
+        assert_eq!(
+            row(&ctx, "odd.txt").await.str_field("status"),
+            STATUS_PENDING_SCAN
+        );
+    }
+
+    #[tokio::test]
+    async fn infected_large_upload_is_quarantined_until_released() {
+        let ctx = ctx_with_scanner().await;
+        output_json(upload(&ctx, "late.txt", b"padding padding EICAR").await).await;
+        let held = row(&ctx, "late.txt").await;
+        run_job(&ctx, &held.id).await.expect("scan job");
+        assert_eq!(
+            row(&ctx, "late.txt").await.str_field("status"),
+            STATUS_QUARANTINED
+        );
+
+        // Hidden from the uploader too, and from listings.
+        assert!(output_is_error(download(&ctx, "alice", "late.txt").await, "NotFound").await);
+        let listing = super::super::storage::handle(
+            &ctx,
+            auth_msg("retrieve", "/b/storage/api/buckets/docs/objects", "alice"),
+            InputStream::empty(),
+        )
+        .await;
+        let listing = output_json(listing).await;
+        assert_eq!(listing["objects"].as_array().map(Vec::len), Some(0));
+
+        let quarantine = super::super::storage::handle_admin(
+            &ctx,
+            admin_msg("retrieve", "/admin/storage/quarantine"),
+            InputStream::empty(),
+        )
+        .await;
+        let quarantine = output_json(quarantine).await;
+        assert_eq!(quarantine["records"][0]["id"], held.id.as_str());
+        assert_eq!(
+            quarantine["records"][0]["metadata"]["system"]["scan"]["signature"],
+            "Eicar-Test-Signature"
+        );
+
+        let path = format!("/admin/storage/quarantine/{}/release", held.id);
+        let released = super::super::storage::handle_admin(
+            &ctx,
+            admin_msg("create", &path),
+            InputStream::empty(),
+        )
+        .await;
+        assert_eq!(output_json(released).await["released"], true);
+        assert_eq!(
+            output_body(download(&ctx, "alice", "late.txt").await).await,
+            b"padding padding EICAR"
+        );
+    }
+
+    #[tokio::test]
+    async fn admin_deletes_a_quarantined_object() {
+        let ctx = ctx_with_scanner().await;
+        output_json(upload(&ctx, "late.txt", b"padding padding EICAR").await).await;
+        let held = row(&ctx, "late.txt").await;
+
+        // Only quarantined objects go through the quarantine API.
+        let path = format!("/admin/storage/quarantine/{}", held.id);
+        let early = super::super::storage::handle_admin(
+            &ctx,
+            admin_msg("delete", &path),
+            InputStream::empty(),
+        )
+        .await;
+        assert!(output_is_error(early, "InvalidArgument").await);
+
+        run_job(&ctx, &held.id).await.expect("scan job");
+        let deleted = super::super::storage::handle_admin(
+            &ctx,
+            admin_msg("delete", &path),
+            InputStream::empty(),
+        )
+        .await;
+        assert_eq!(output_json(deleted).await["deleted"], true);
+        assert!(!super::super::blobs::exists(&ctx, "docs", &held.id)
+            .await
+            .unwrap());
+        assert!(repo::objects::find_by_bucket_key(&ctx, "docs", "late.txt")
+            .await
+            .unwrap()
+            .is_none());
+    }
+}
@@ -1,34 +1,79 @@
-use std::time::Duration;
+use std::{sync::Arc, time::Duration};
 
-use wafer_core::clients::{crypto, storage as store};
-use wafer_run::{context::Context, ErrorCode, Message, OutputStream};
+use chrono::{DateTime, Utc};
+use wafer_core::clients::{crypto, database::Record};
+use wafer_run::{
+    context::Context, streams::output::OutputSink, ErrorCode, Message, MetaEntry, OutputStream,
+    META_RESP_CONTENT_TYPE, META_RESP_STATUS,
+};
 
-use super::repo;
+use super::{blobs, repo};
 use crate::{
-    blocks::rate_limit::{check_rate_limit, RateLimit, RateLimitOutcome, UserRateLimiter},
+    blocks::{
+        errors, nonces,
+        rate_limit::{check_rate_limit, RateLimit, RateLimitOutcome, UserRateLimiter},
+    },
     http::{
-        err_bad_request, err_forbidden, err_internal, err_internal_no_cause, err_not_found,
-        ResponseBuilder,
+        err_bad_request, err_conflict, err_forbidden, err_internal, err_internal_no_cause,
+        err_not_found, ResponseBuilder,
     },
+    pipeline::META_RESP_STREAM,
     util::{json_map, RecordExt},
 };
 
+// SEC-055: share JWT lifetime — 30 days. The previous 1-year default
+// gave any leaked share URL effectively unbounded validity. Users who
+// need longer-lived shares can re-share; the typical use case (send
+// a link, recipient downloads within hours/days) fits well under 30d.
+pub(crate) const SHARE_TOKEN_TTL: Duration = Duration::from_secs(30 * 24 * 3600);
+
+/// How long an expired or revoked share row is kept before an admin's
+/// [`sweep_stale_shares`] deletes it; the scheduled `dead_shares` retention
+/// policy defaults to the same.
+const STALE_SHARE_GRACE: Duration = Duration::from_secs(7 * 24 * 3600);
+
+/// How long one direct-access attempt holds its share's download lease. A
+/// download still streaming renews it every half lease.
+const ATTEMPT_LEASE: Duration = Duration::from_secs(60);
+
+/// Body bytes per chunk of a share download. Tests send small chunks so a
+/// download can be cut off part way.
+#[cfg(not(test))]
+const SEND_CHUNK: usize = 64 * 1024;
+#[cfg(test)]
+const SEND_CHUNK: usize = 4;
+
+/// Whether share-link attempts are written to the access log. Off stops
+/// new rows (and the daily series in download stats); access and download
+/// counters keep counting.
+pub(super) const ACCESS_LOG_KEY: &str = "SUPPERS_AI__FILES__SHARE_ACCESS_LOG";
+
+pub(super) async fn access_log_enabled(ctx: &dyn Context) -> bool {
+    wafer_core::clients::config::get_default(ctx, ACCESS_LOG_KEY, "true").await != "false"
+}
+
+/// Nonce-store scope of links minted with `max_uses`.
+const NONCE_SCOPE: &str = "share_link";
+
+/// Sign a share token for `bucket`/`key`. With `max_uses`, the token also
+/// carries a fresh nonce, and each new access spends one of its uses in
+/// the nonce store ([`crate::blocks::nonces`]).
 pub async fn generate_share_token(
     ctx: &dyn Context,
     bucket: &str,
     key: &str,
+    max_uses: Option<i64>,
 ) -> Result<String, OutputStream> {
-    let claims = json_map(serde_json::json!({
+    let mut claims = json_map(serde_json::json!({
         "bucket": bucket,
         "key": key,
         "type": "share",
     }));
+    if let Some(max_uses) = max_uses {
+        claims.insert("nonce".into(), crate::clock::new_id().into());
+        claims.insert("max_uses".into(), max_uses.into());
+    }
 
-    // SEC-055: share JWT lifetime — 30 days. The previous 1-year default
-    // gave any leaked share URL effectively unbounded validity. Users who
-    // need longer-lived shares can re-share; the typical use case (send
-    // a link, recipient downloads within hours/days) fits well under 30d.
-    const SHARE_TOKEN_TTL: Duration = Duration::from_secs(30 * 24 * 3600);
     crypto::sign(ctx, &claims, SHARE_TOKEN_TTL)
         .await
         .map_err(|e| err_internal("Token generation failed", e))
@@ -71,46 +116,93 @@ pub async fn handle_direct_access(
     // at issue time (`generate_share_token`), so an invalid signature means
     // the token wasn't minted by us — short-circuit before the DB lookup so
     // attackers can't enumerate the shares table via random tokens.
-    if crypto::verify(ctx, token).await.is_err() {
+    let Ok(claims) = crypto::verify(ctx, token).await else {
         return err_not_found("Share not found or expired");
-    }
+    };
+    let uses = claims
+        .get("nonce")
+        .and_then(|v| v.as_str())
+        .filter(|nonce| !nonce.is_empty())
+        .map(|nonce| {
+            let max_uses = claims.get("max_uses").and_then(|v| v.as_i64());
+            (nonce, max_uses.unwrap_or(1))
+        });
 
     // Look up share by token
     let Ok(share) = repo::shares::find_by_token(ctx, token).await else {
         return err_not_found("Share not found or expired");
     };
 
+    // Revoked links answer 410 rather than 404 so the recipient learns the
+    // link was deliberately killed, not mistyped.
+    if !share.str_field("revoked_at").is_empty() {
+        return errors::error_json(
+            errors::ErrorCode::ShareRevoked,
+            "Share link has been revoked",
+            None,
+        );
+    }
+
     // Check expiry
     if let Some(expires) = share.data.get("expires_at").and_then(|v| v.as_str()) {
         if !expires.is_empty() {
-            if let Ok(exp_time) = chrono::DateTime::parse_from_rfc3339(expires) {
-                if exp_time < chrono::Utc::now() {
+            if let Some(exp_time) = crate::util::parse_timestamp(expires) {
+                if exp_time < crate::clock::now() {
                     return err_forbidden("Share link has expired");
                 }
             }
         }
     }
 
-    // Atomic access-count increment + cap enforcement via a CAS UPDATE:
-    //   UPDATE shares SET access_count = access_count + 1
-    //   WHERE id = ? AND access_count < max_access_count
-    // The read-then-write pattern previously here let two concurrent
-    // accesses with `max_access_count = 1` both pass the check and double-
-    // serve the file. With the cap inside the WHERE clause, at most one
-    // updater wins per row and rowcount 0 ⇒ cap reached.
-    let max = share.i64_field("max_access_count");
-    match repo::shares::increment_access_count_capped(ctx, &share.id, max).await {
+    // One attempt per link at a time keeps `bytes_served` accounting
+    // simple. The download releases the lease once its body has gone out
+    // (an early answer releases it here); it only outlives the attempt if
+    // the process dies part way, and then lapses on its own.
+    let now = crate::clock::now();
+    match repo::shares::begin_attempt(
+        ctx,
+        &share.id,
+        &crate::util::format_rfc3339(now),
+        &crate::util::format_rfc3339(lease_end(now)),
+    )
+    .await
+    {
         Ok(true) => {}
-        Ok(false) => return err_forbidden("Share link access limit reached"),
-        Err(e) => {
-            // Don't block a legitimate access on a transient DB blip — log and
-            // continue. Counters drifting low is preferable to denying paid-
-            // for downloads. (The cap check above ran on a stale read but
-            // covers the common case.)
-            tracing::warn!(error = %e, share_id = %share.id, "Failed to increment share access count");
+        Ok(false) => return err_conflict("Share link is already being downloaded"),
+        Err(e) => return err_internal("Database error", e),
+    }
+    match serve_attempt(ctx, msg, &share, uses).await {
+        Ok(download) => download,
+        Err(early) => {
+            if let Err(e) = repo::shares::end_attempt(ctx, &share.id).await {
+                tracing::warn!(error = %e, share_id = %share.id, "Failed to release share download lease");
+            }
+            early
         }
     }
+}
+
+/// When a lease taken (or renewed) at `now` lapses.
+fn lease_end(now: DateTime<Utc>) -> DateTime<Utc> {
+    now + chrono::Duration::seconds(ATTEMPT_LEASE.as_secs() as i64)
+}
 
+/// Serve one attempt on a live, leased share: the whole object, or the
+/// `Range` it asks for as a 206. Expiry was checked before the lease, so an
+/// attempt that started in time finishes even if the link lapses meanwhile;
+/// the next range request is refused.
+///
+/// `uses` is the token's nonce and `max_uses`, for links minted with one.
+///
+/// `Ok` is the download, which streams the body and then charges the share
+/// for the bytes the client took and releases the lease. `Err` answers
+/// before anything is sent, and the caller releases the lease.
+async fn serve_attempt(
+    ctx: &dyn Context,
+    msg: &Message,
+    share: &Record,
+    uses: Option<(&str, i64)>,
+) -> Result<OutputStream, OutputStream> {
     let bucket = share
         .data
         .get("bucket")
@@ -119,29 +211,283 @@ pub async fn handle_direct_access(
     let key = share.data.get("key").and_then(|v| v.as_str()).unwrap_or("");
 
     if bucket.is_empty() || key.is_empty() {
-        return err_internal_no_cause("Invalid share data");
+        return Err(err_internal_no_cause("Invalid share data"));
+    }
+    // Links never serve an object that is quarantined or still waiting
+    // for its malware scan.
+    if let Some(blocked) = super::scan::read_blocked(ctx, "", bucket, key).await {
+        return Err(blocked);
     }
 
-    // Log access
-    if let Err(e) =
-        repo::shares::log_access(ctx, &share.id, msg.remote_addr(), msg.header("User-Agent")).await
-    {
-        tracing::warn!("Failed to log share access: {e}");
-    }
-
-    // Serve the file
-    match store::get(ctx, bucket, key).await {
-        Ok((data, info)) => ResponseBuilder::new()
-            .set_header(
-                "Content-Disposition",
-                &format!(
-                    "inline; filename=\"{}\"",
-                    key.replace(['"', '\n', '\r'], "")
-                ),
+    let (data, content_type, object_id) = match blobs::get_with_id(ctx, bucket, key).await {
+        Ok(found) => found,
+        Err(e) if e.code == ErrorCode::NotFound => return Err(err_not_found("File not found")),
+        Err(e) => return Err(err_internal("Storage error", e)),
+    };
+    let size = data.len() as u64;
+    let Ok(range) = requested_range(msg.header("Range"), size) else {
+        return Err(ResponseBuilder::new()
+            .status(416)
+            .set_header("Content-Range", &format!("bytes */{size}"))
+            .body(Vec::new(), "text/plain"));
+    };
+
+    // A range that starts inside what earlier attempts already sent
+    // resumes one of them, so it doesn't spend an access until the link
+    // has been served in full once. Anything else — including a suffix or
+    // open range past that offset — is a new access.
+    let served = share.i64_field("bytes_served").max(0) as u64;
+    let resuming = range.is_some_and(|(start, _)| start > 0 && start <= served)
+        && share.str_field("completed_at").is_empty();
+    if !resuming {
+        if let Some((nonce, max_uses)) = uses {
+            // The token lives at most SHARE_TOKEN_TTL, so its nonce can go
+            // once that has passed from now.
+            let ttl = chrono::Duration::seconds(SHARE_TOKEN_TTL.as_secs() as i64);
+            let expires_at = crate::util::format_rfc3339(crate::clock::now() + ttl);
+            match nonces::claim(ctx, NONCE_SCOPE, nonce, max_uses, &expires_at).await {
+                Ok(nonces::Claim::Accepted) => {}
+                Ok(nonces::Claim::Reused) => {
+                    return Err(errors::error_json(
+                        errors::ErrorCode::Conflict,
+                        "Share link has been used up",
+                        None,
+                    ))
+                }
+                Err(e) => return Err(err_internal("Database error", e)),
+            }
+        }
+        // Atomic access-count increment + cap enforcement via a CAS UPDATE:
+        //   UPDATE shares SET access_count = access_count + 1
+        //   WHERE id = ? AND access_count < max_access_count
+        // The read-then-write pattern previously here let two concurrent
+        // accesses with `max_access_count = 1` both pass the check and double-
+        // serve the file. With the cap inside the WHERE clause, at most one
+        // updater wins per row and rowcount 0 ⇒ cap reached.
+        let max = share.i64_field("max_access_count");
+        match repo::shares::increment_access_count_capped(ctx, &share.id, max).await {
+            Ok(true) => {}
+            Ok(false) => return Err(err_forbidden("Share link access limit reached")),
+            Err(e) => {
+                // Don't block a legitimate access on a transient DB blip — log and
+                // continue. Counters drifting low is preferable to denying paid-
+                // for downloads. (The cap check above ran on a stale read but
+                // covers the common case.)
+                tracing::warn!(error = %e, share_id = %share.id, "Failed to increment share access count");
+            }
+        }
+        if let Some(object_id) = &object_id {
+            repo::objects::record_download(ctx, object_id).await;
+        }
+    }
+
+    let (status, body) = match range {
+        Some((start, end)) => (206, data[start as usize..=end as usize].to_vec()),
+        None => (200, data),
+    };
+    let mut meta = vec![
+        (META_RESP_STREAM, "true".to_string()),
+        (META_RESP_STATUS, status.to_string()),
+        (META_RESP_CONTENT_TYPE, content_type),
+        (
+            "resp.header.Content-Disposition",
+            format!(
+                "inline; filename=\"{}\"",
+                key.replace(['"', '\n', '\r'], "")
+            ),
+        ),
+        (
+            "resp.header.Cache-Control",
+            "private, max-age=3600".to_string(),
+        ),
+        ("resp.header.Accept-Ranges", "bytes".to_string()),
+    ];
+    if let Some((start, end)) = range {
+        meta.push((
+            "resp.header.Content-Range",
+            format!("bytes {start}-{end}/{size}"),
+        ));
+    }
+
+    let attempt = Attempt {
+        ctx: ctx.clone_arc(),
+        share_id: share.id.clone(),
+        remote_addr: msg.remote_addr().to_string(),
+        user_agent: msg.header("User-Agent").to_string(),
+        new_access: !resuming,
+        size: size as i64,
+    };
+    let download = OutputStream::from_producer(move |sink, _cancel| async move {
+        let sent = send_body(&sink, &attempt, meta, &body).await;
+        attempt.finish(sent).await;
+    });
+    Ok(download)
+}
+
+/// What a download needs once its body has gone out.
+struct Attempt {
+    ctx: Arc<dyn Context>,
+    share_id: String,
+    remote_addr: String,
+    user_agent: String,
+    new_access: bool,
+    size: i64,
+}
+
+impl Attempt {
+    /// Charge the share for the `sent` bytes the client took, log the
+    /// access and release the lease. A download cut off part way is
+    /// charged only what it sent, so it doesn't complete the link.
+    async fn finish(&self, sent: i64) {
+        let ctx = self.ctx.as_ref();
+        if access_log_enabled(ctx).await {
+            if let Err(e) = repo::shares::log_access(
+                ctx,
+                &self.share_id,
+                &self.remote_addr,
+                &self.user_agent,
+                sent,
+                self.new_access,
             )
-            .set_header("Cache-Control", "private, max-age=3600")
-            .body(data, &info.content_type),
-        Err(e) if e.code == ErrorCode::NotFound => err_not_found("File not found"),
-        Err(e) => err_internal("Storage error", e),
+            .await
+            {
+                tracing::warn!("Failed to log share access: {e}");
+            }
+        }
+        if let Err(e) = repo::shares::record_bytes(ctx, &self.share_id, sent, self.size).await {
+            tracing::warn!(error = %e, share_id = %self.share_id, "Failed to record share bytes served");
+        }
+        if let Err(e) = repo::shares::end_attempt(ctx, &self.share_id).await {
+            tracing::warn!(error = %e, share_id = %self.share_id, "Failed to release share download lease");
+        }
+    }
+}
+
+/// Send the response meta and then `body` in [`SEND_CHUNK`] pieces,
+/// renewing the lease while a long download runs. Returns how many body
+/// bytes the consumer accepted before it went away.
+async fn send_body(
+    sink: &OutputSink,
+    attempt: &Attempt,
+    meta: Vec<(&str, String)>,
+    body: &[u8],
+) -> i64 {
+    for (key, value) in meta {
+        let entry = MetaEntry {
+            key: key.to_string(),
+            value,
+        };
+        if sink.send_meta(entry).await.is_err() {
+            return 0;
+        }
+    }
+    let renew_every = chrono::Duration::seconds(ATTEMPT_LEASE.as_secs() as i64 / 2);
+    let mut renew_at = crate::clock::now() + renew_every;
+    let mut sent = 0;
+    for chunk in body.chunks(SEND_CHUNK) {
+        if sink.send_chunk(chunk.to_vec()).await.is_err() {
+            break;
+        }
+        sent += chunk.len() as i64;
+        let now = crate::clock::now();
+        if now >= renew_at {
+            let until = crate::util::format_rfc3339(lease_end(now));
+            let ctx = attempt.ctx.as_ref();
+            if let Err(e) = repo::shares::extend_attempt(ctx, &attempt.share_id, &until).await {
+                tracing::warn!(error = %e, share_id = %attempt.share_id, "Failed to renew share download lease");
+            }
+            renew_at = now + renew_every;
+        }
+    }
+    sent
+}
+
+/// The inclusive byte range a `Range` header asks for within `size` bytes.
+/// `Ok(None)` serves the whole object: no header, or one this endpoint
+/// ignores as RFC 9110 allows (another unit, several ranges, malformed).
+/// `Err(())` means unsatisfiable (416).
+pub(super) fn requested_range(header: &str, size: u64) -> Result<Option<(u64, u64)>, ()> {
+    let Some(spec) = header.trim().strip_prefix("bytes=") else {
+        return Ok(None);
+    };
+    let Some((first, last)) = spec.split_once('-') else {
+        return Ok(None);
+    };
+    if spec.contains(',') {
+        return Ok(None);
+    }
+    let (first, last) = (first.trim(), last.trim());
+    if first.is_empty() {
+        // `bytes=-N`: the last N bytes.
+        let Ok(n) = last.parse::<u64>() else {
+            return Ok(None);
+        };
+        if n == 0 || size == 0 {
+            return Err(());
+        }
+        return Ok(Some((size.saturating_sub(n), size - 1)));
+    }
+    let Ok(start) = first.parse::<u64>() else {
+        return Ok(None);
+    };
+    let end = match last {
+        "" => u64::MAX,
+        last => match last.parse::<u64>() {
+            Ok(end) if end >= start => end,
+            _ => return Ok(None),
+        },
+    };
+    if start >= size {
+        return Err(());
+    }
+    Ok(Some((start, end.min(size - 1))))
+}
+
+/// Delete share rows that expired or were revoked more than
+/// [`STALE_SHARE_GRACE`] ago, plus no-expiry shares whose signed token has
+/// outlived [`SHARE_TOKEN_TTL`]. Runs on demand from the admin cleanup
+/// endpoint (the `dead_shares` retention policy does the same on a
+/// schedule); returns the number deleted.
+pub async fn sweep_stale_shares(ctx: &dyn Context) -> i64 {
+    let now = crate::clock::now();
+    let grace = chrono::Duration::seconds(STALE_SHARE_GRACE.as_secs() as i64);
+    let ttl = chrono::Duration::seconds(SHARE_TOKEN_TTL.as_secs() as i64);
+    let expired_before = crate::util::format_rfc3339(now - grace);
+    let created_before = crate::util::format_rfc3339(now - ttl - grace);
+    match repo::shares::delete_stale(ctx, &expired_before, &created_before).await {
+        Ok(n) => {
+            if n > 0 {
+                tracing::info!(deleted = n, "swept stale share links");
+            }
+            n
+        }
+        Err(e) => {
+            tracing::warn!(error = %e, "failed to sweep stale share links");
+            0
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn requested_range_follows_rfc_9110() {
+        assert_eq!(requested_range("", 9), Ok(None));
+        assert_eq!(requested_range("bytes=0-3", 9), Ok(Some((0, 3))));
+        assert_eq!(requested_range("bytes=4-", 9), Ok(Some((4, 8))));
+        assert_eq!(requested_range("bytes=4-100", 9), Ok(Some((4, 8))));
+        assert_eq!(requested_range("bytes=-3", 9), Ok(Some((6, 8))));
+        assert_eq!(requested_range("bytes=-30", 9), Ok(Some((0, 8))));
+        // Ignored: the whole object is served.
+        assert_eq!(requested_range("bytes=0-1,4-5", 9), Ok(None));
+        assert_eq!(requested_range("items=0-1", 9), Ok(None));
+        assert_eq!(requested_range("bytes=5-2", 9), Ok(None));
+        assert_eq!(requested_range("bytes=x-", 9), Ok(None));
+        // Unsatisfiable.
+        assert_eq!(requested_range("bytes=9-", 9), Err(()));
+        assert_eq!(requested_range("bytes=-0", 9), Err(()));
+        assert_eq!(requested_range("bytes=0-", 0), Err(()));
     }
 }
@@ -1,10 +1,24 @@
-use wafer_core::clients::storage as store;
-use wafer_run::{context::Context, ErrorCode, HttpMethod, InputStream, Message, OutputStream};
+use wafer_core::clients::{database::Record, storage as store};
+use wafer_run::{
+    context::Context, ErrorCode, HttpMethod, InputStream, Message, OutputStream, WaferError,
+};
 
-use super::repo;
+use super::{
+    acl::{self, Access},
+    archive, blobs, breadcrumbs, bucket_stats, checksum, downloads, folder_tree, history, info,
+    locks, metadata, moves, preview, repo,
+    scan::{self, Admission},
+    teams,
+    upload_check::{self, UploadCheck},
+    website, widget,
+};
 use crate::{
+    blocks::{admin::audit_log, errors},
     endpoint_match::{self, EndpointRoute},
     http::{err_bad_request, err_forbidden, err_internal, err_not_found, ok_json, ResponseBuilder},
+    pagination::{self, ListSpec},
+    services::Services,
+    util::RecordExt,
 };
 
 /// In-block dispatch targets for the user storage API.
@@ -15,10 +29,46 @@ enum Route {
     ListObjects,
     GetObject,
     UploadObject,
+    UploadArchive,
+    UploadCheck,
+    DiffObjects,
     DeleteObject,
     DeleteBucket,
     Search,
+    SearchUsers,
     Recent,
+    ListAcl,
+    GrantAcl,
+    RevokeAcl,
+    ListLocks,
+    Lock,
+    Unlock,
+    SharedWithMe,
+    IncomingShares,
+    AcceptShare,
+    DeclineShare,
+    ObjectPath,
+    ObjectPaths,
+    FolderTree,
+    Move,
+    MoveObject,
+    History,
+    DownloadStats,
+    Website,
+    SetWebsite,
+    Preview,
+    Info,
+    Metadata,
+    ListTeams,
+    CreateTeam,
+    GetTeam,
+    DeleteTeam,
+    TeamBuckets,
+    AddTeamMember,
+    RemoveTeamMember,
+    TransferTeam,
+    CreateWidgetSession,
+    GetWidgetSession,
 }
 
 /// Dispatch table over the REAL on-the-wire `/b/storage/api/...` suffixes —
@@ -39,7 +89,145 @@ const ROUTES: &[EndpointRoute<Route>] = &[
         Route::CreateBucket,
     ),
     EndpointRoute::new(HttpMethod::Get, "/b/storage/api/search", Route::Search),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/users/search",
+        Route::SearchUsers,
+    ),
     EndpointRoute::new(HttpMethod::Get, "/b/storage/api/recent", Route::Recent),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/shared-with-me",
+        Route::SharedWithMe,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/shares/incoming",
+        Route::IncomingShares,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/shares/incoming/{id}/accept",
+        Route::AcceptShare,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/shares/incoming/{id}/decline",
+        Route::DeclineShare,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/acl",
+        Route::ListAcl,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/acl",
+        Route::GrantAcl,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/storage/api/buckets/{name}/acl/{id}",
+        Route::RevokeAcl,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/locks",
+        Route::ListLocks,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/locks",
+        Route::Lock,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/storage/api/buckets/{name}/locks/{id}",
+        Route::Unlock,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/paths/{id}",
+        Route::ObjectPath,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/paths",
+        Route::ObjectPaths,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/folders/tree",
+        Route::FolderTree,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/move",
+        Route::Move,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/objects/{id}/move",
+        Route::MoveObject,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/history/{id}",
+        Route::History,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/stats/{id}",
+        Route::DownloadStats,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/website",
+        Route::Website,
+    ),
+    // PUT arrives as `update`, like PATCH.
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/b/storage/api/buckets/{name}/website",
+        Route::SetWebsite,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/upload-archive",
+        Route::UploadArchive,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/upload-check",
+        Route::UploadCheck,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/preview/{key...}",
+        Route::Preview,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/info/{key...}",
+        Route::Info,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/metadata/{key...}",
+        Route::Metadata,
+    ),
+    // PUT (replace) and PATCH (merge) both arrive as `update`; the handler
+    // tells them apart by `http.method`.
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/b/storage/api/buckets/{name}/metadata/{key...}",
+        Route::Metadata,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/objects/diff",
+        Route::DiffObjects,
+    ),
     EndpointRoute::new(
         HttpMethod::Get,
         "/b/storage/api/buckets/{name}/objects/{key...}",
@@ -65,6 +253,142 @@ const ROUTES: &[EndpointRoute<Route>] = &[
         "/b/storage/api/buckets/{name}",
         Route::DeleteBucket,
     ),
+    EndpointRoute::new(HttpMethod::Get, "/b/storage/api/teams", Route::ListTeams),
+    EndpointRoute::new(HttpMethod::Post, "/b/storage/api/teams", Route::CreateTeam),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/teams/{id}/buckets",
+        Route::TeamBuckets,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/teams/{id}/members",
+        Route::AddTeamMember,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/storage/api/teams/{id}/members/{user_id}",
+        Route::RemoveTeamMember,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/teams/{id}/transfer",
+        Route::TransferTeam,
+    ),
+    EndpointRoute::new(HttpMethod::Get, "/b/storage/api/teams/{id}", Route::GetTeam),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/storage/api/teams/{id}",
+        Route::DeleteTeam,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/widgets/upload-sessions",
+        Route::CreateWidgetSession,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/widgets/upload-sessions/{id}",
+        Route::GetWidgetSession,
+    ),
+];
+
+/// Usage-quota tags for the routes above (see [`crate::blocks::api_quota`]):
+/// admins define limits per tag, and only routes listed here are counted.
+/// Previews read the same bytes as downloads, so they share the tag.
+pub(crate) const QUOTA_ROUTES: &[EndpointRoute<&str>] = &[
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/objects",
+        "storage.upload",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/upload-archive",
+        "storage.upload",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/objects/{key...}",
+        "storage.download",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/preview/{key...}",
+        "storage.download",
+    ),
+    EndpointRoute::new(HttpMethod::Patch, "/s3/{bucket}/{key...}", "storage.upload"),
+    EndpointRoute::new(HttpMethod::Get, "/s3/{bucket}/{key...}", "storage.download"),
+];
+
+/// Permissions for the routes above (see [`crate::blocks::permissions`]):
+/// every route that creates, changes or removes a bucket or object needs
+/// `storage.write`. Reads stay governed by bucket ownership and ACLs alone.
+pub(crate) const PERMISSION_ROUTES: &[EndpointRoute<&str>] = &[
+    EndpointRoute::new(HttpMethod::Post, "/b/storage/api/buckets", "storage.write"),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/storage/api/buckets/{name}",
+        "storage.write",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/objects",
+        "storage.write",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/storage/api/buckets/{name}/objects/{key...}",
+        "storage.write",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/upload-archive",
+        "storage.write",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/upload-check",
+        "storage.write",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/move",
+        "storage.write",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/objects/{id}/move",
+        "storage.write",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/b/storage/api/buckets/{name}/metadata/{key...}",
+        "storage.write",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/b/storage/api/buckets/{name}/website",
+        "storage.write",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/storage/api/widgets/upload-sessions",
+        "storage.write",
+    ),
+    EndpointRoute::new(HttpMethod::Patch, "/s3/{bucket}/{key...}", "storage.write"),
+    EndpointRoute::new(HttpMethod::Delete, "/s3/{bucket}/{key...}", "storage.write"),
+];
+
+/// Permissions for the storage admin pages: `storage.admin` opens them to
+/// non-admins who hold it. The admin JSON API behind them is annotated on
+/// the admin block's delegate routes.
+pub(crate) const ADMIN_PERMISSION_ROUTES: &[EndpointRoute<&str>] = &[
+    EndpointRoute::new(HttpMethod::Get, "/b/storage/admin", "storage.admin"),
+    EndpointRoute::new(HttpMethod::Get, "/b/storage/admin/", "storage.admin"),
+    EndpointRoute::new(HttpMethod::Get, "/b/storage/admin/buckets", "storage.admin"),
+    EndpointRoute::new(HttpMethod::Get, "/b/storage/admin/shares", "storage.admin"),
+    EndpointRoute::new(HttpMethod::Get, "/b/storage/admin/quotas", "storage.admin"),
 ];
 
 pub async fn handle(ctx: &dyn Context, mut msg: Message, input: InputStream) -> OutputStream {
@@ -77,23 +401,115 @@ pub async fn handle(ctx: &dyn Context, mut msg: Message, input: InputStream) ->
         Route::ListObjects => handle_list_objects(ctx, &msg).await,
         Route::GetObject => handle_get_object(ctx, &msg).await,
         Route::UploadObject => handle_upload_object(ctx, &msg, input).await,
+        Route::UploadArchive => {
+            archive::handle_upload(ctx, &msg, &extract_bucket_name(&msg), input).await
+        }
+        Route::UploadCheck => upload_check::handle(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::DiffObjects => {
+            checksum::handle_diff(ctx, &msg, &extract_bucket_name(&msg), input).await
+        }
         Route::DeleteObject => handle_delete_object(ctx, &msg).await,
         Route::DeleteBucket => handle_delete_bucket(ctx, &msg).await,
         Route::Search => handle_search(ctx, &msg).await,
+        Route::SearchUsers => acl::handle_user_search(ctx, &msg).await,
         Route::Recent => handle_recent(ctx, &msg).await,
+        Route::ListAcl => acl::handle_list(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::GrantAcl => acl::handle_grant(ctx, &msg, &extract_bucket_name(&msg), input).await,
+        Route::RevokeAcl => acl::handle_revoke(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::ListLocks => locks::handle_list(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::Lock => locks::handle_lock(ctx, &msg, &extract_bucket_name(&msg), input).await,
+        Route::Unlock => locks::handle_unlock(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::SharedWithMe => acl::handle_shared_with_me(ctx, &msg).await,
+        Route::IncomingShares => acl::handle_incoming(ctx, &msg).await,
+        Route::AcceptShare => acl::handle_accept(ctx, &msg).await,
+        Route::DeclineShare => acl::handle_decline(ctx, &msg).await,
+        Route::ObjectPath => breadcrumbs::handle_one(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::ObjectPaths => {
+            breadcrumbs::handle_batch(ctx, &msg, &extract_bucket_name(&msg)).await
+        }
+        Route::FolderTree => folder_tree::handle(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::Move => moves::handle(ctx, &msg, &extract_bucket_name(&msg), input).await,
+        Route::MoveObject => {
+            let (bucket, id) = (extract_bucket_name(&msg), msg.var("id").to_string());
+            moves::handle_object(ctx, &msg, &bucket, &id, input).await
+        }
+        Route::History => history::handle(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::DownloadStats => downloads::handle(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::Website => website::handle_get(ctx, &msg, &extract_bucket_name(&msg)).await,
+        Route::SetWebsite => {
+            website::handle_put(ctx, &msg, &extract_bucket_name(&msg), input).await
+        }
+        Route::Preview => {
+            let (bucket, key) = (extract_bucket_name(&msg), extract_object_key(&msg));
+            preview::handle_preview(ctx, &msg, &bucket, &key).await
+        }
+        Route::Info => {
+            let (bucket, key) = (extract_bucket_name(&msg), extract_object_key(&msg));
+            info::handle(ctx, &msg, &bucket, &key).await
+        }
+        Route::Metadata => {
+            let (bucket, key) = (extract_bucket_name(&msg), extract_object_key(&msg));
+            metadata::handle(ctx, &msg, &bucket, &key, input).await
+        }
+        Route::ListTeams => teams::handle_list(ctx, &msg).await,
+        Route::CreateTeam => teams::handle_create(ctx, &msg, input).await,
+        Route::GetTeam => teams::handle_get(ctx, &msg).await,
+        Route::DeleteTeam => teams::handle_delete(ctx, &msg).await,
+        Route::TeamBuckets => teams::handle_buckets(ctx, &msg).await,
+        Route::AddTeamMember => teams::handle_add_member(ctx, &msg, input).await,
+        Route::RemoveTeamMember => teams::handle_remove_member(ctx, &msg).await,
+        Route::TransferTeam => teams::handle_transfer(ctx, &msg, input).await,
+        Route::CreateWidgetSession => widget::handle_create_session(ctx, &msg, input).await,
+        Route::GetWidgetSession => widget::handle_get_session(ctx, &msg).await,
     }
 }
 
 /// Admin storage API, delegated from the admin block via `call_block` on the
 /// real `/admin/storage/...` paths. Authorization is enforced by the admin
 /// block's central tier before delegation.
-pub async fn handle_admin(ctx: &dyn Context, msg: Message, _input: InputStream) -> OutputStream {
+pub async fn handle_admin(ctx: &dyn Context, msg: Message, input: InputStream) -> OutputStream {
     let action = msg.action();
     let path = msg.path();
     match (action, path) {
-        ("retrieve", "/admin/storage/buckets") => handle_list_buckets(ctx, &msg).await,
-        ("retrieve", "/admin/storage/stats") => handle_stats(ctx, &msg).await,
-        _ => err_not_found("not found"),
+        ("retrieve", "/admin/storage/buckets") => return handle_list_buckets(ctx, &msg).await,
+        ("create", "/admin/storage/buckets") => {
+            return handle_create_bucket(ctx, &msg, input).await
+        }
+        ("retrieve", "/admin/storage/stats") => return handle_stats(ctx, &msg).await,
+        ("retrieve", "/admin/storage/report") => return super::report::handle(ctx, &msg).await,
+        ("retrieve", "/admin/storage/search") => {
+            return super::admin_search::handle(ctx, &msg).await
+        }
+        ("update", _) if bucket_sub_path(path) == Some("visibility") => {
+            return handle_set_visibility(ctx, &msg, input).await
+        }
+        ("delete", _) if bucket_sub_path(path) == Some("") => {
+            return handle_delete_bucket(ctx, &msg).await
+        }
+        _ => {}
+    }
+    if let Some(out) = scan::handle_admin(ctx, &msg).await {
+        return out;
+    }
+    let input = match super::consistency::handle_admin(ctx, &msg, input).await {
+        Ok(out) => return out,
+        Err(input) => input,
+    };
+    match super::lifecycle::handle_admin(ctx, &msg, input).await {
+        Some(out) => out,
+        None => err_not_found("not found"),
+    }
+}
+
+/// What follows the bucket segment of `/admin/storage/buckets/{name}[/…]`:
+/// `Some("")` for the bucket itself, `Some("visibility")` for
+/// `…/{name}/visibility`, `None` for other paths.
+fn bucket_sub_path(path: &str) -> Option<&str> {
+    let rest = path.strip_prefix("/admin/storage/buckets/")?;
+    match rest.split_once('/') {
+        Some((name, sub)) if !name.is_empty() => Some(sub),
+        None if !rest.is_empty() => Some(""),
+        _ => None,
     }
 }
 
@@ -129,8 +545,9 @@ fn extract_object_key(msg: &Message) -> String {
     }
 }
 
-/// True when `user_id` owns a bucket named `bucket` (i.e.
-/// [`repo::buckets::find_owned`] finds a matching row). DB errors are
+/// True when `user_id` owns a bucket named `bucket`: a personal bucket
+/// they created ([`repo::buckets::find_owned`]), or a team bucket of a
+/// team they belong to ([`teams::is_team_bucket_member`]). DB errors are
 /// logged and treated as "not owned" (fail closed).
 ///
 /// This is the single ownership predicate for the files block. Callers
@@ -143,7 +560,10 @@ fn extract_object_key(msg: &Message) -> String {
 ///   inspection happens via the admin pages instead.
 pub(super) async fn bucket_owned_by(ctx: &dyn Context, user_id: &str, bucket: &str) -> bool {
     match repo::buckets::find_owned(ctx, bucket, user_id).await {
-        Ok(record) => record.is_some(),
+        Ok(Some(record)) if record.str_field("team_id").is_empty() => true,
+        // A team bucket belongs to the team, whether or not this user
+        // created it.
+        Ok(_) => teams::is_team_bucket_member(ctx, user_id, bucket).await,
         Err(e) => {
             tracing::warn!(error = %e, bucket = %bucket, "bucket-ownership check failed");
             false
@@ -154,6 +574,8 @@ pub(super) async fn bucket_owned_by(ctx: &dyn Context, user_id: &str, bucket: &s
 /// Check if the current user owns the given bucket (or is admin).
 /// Returns true if access is denied. See [`bucket_owned_by`] for the
 /// admin-bypass policy split between the JSON API and the SSR portal.
+/// Routes open to grantees use [`acl::is_access_denied`], which consults
+/// the object ACL after this check fails.
 pub(super) async fn is_bucket_access_denied(
     ctx: &dyn Context,
     msg: &Message,
@@ -202,7 +624,10 @@ pub(super) const BUCKET_NAME_PATTERN: &str = "[a-z0-9]([a-z0-9-]*[a-z0-9])?";
 /// digit. This rejects path traversal (`..`, `/`, `\`), NUL, uppercase, and
 /// leading/trailing hyphens by construction.
 ///
-/// `pub(super)` so the share path uses the identical rule.
+/// This is the rule for *new* buckets (and the one S3 enforces, so a bucket
+/// that passes it survives a switch to the S3 provider). Requests naming an
+/// existing bucket check [`is_safe_bucket_name`] instead, so buckets created
+/// before the rule existed keep working; the listing flags them.
 pub(super) fn is_valid_bucket_name(name: &str) -> bool {
     let len = name.len();
     if !(BUCKET_NAME_MIN_LEN..=BUCKET_NAME_MAX_LEN).contains(&len) {
@@ -218,34 +643,92 @@ pub(super) fn is_valid_bucket_name(name: &str) -> bool {
     bytes.iter().all(|&b| is_alnum(b) || b == b'-')
 }
 
-/// Collect an `InputStream` into `Vec<u8>` with a hard size cap. Errors out
-/// as soon as the running total exceeds `cap_bytes`, so a multi-GB body
-/// can't OOM the process before we check quota. Returns `Err(())` when
-/// the cap is exceeded.
-async fn collect_with_cap(
-    mut input: wafer_run::InputStream,
-    cap_bytes: i64,
-) -> Result<Vec<u8>, ()> {
-    use futures::StreamExt;
-    let cap = if cap_bytes <= 0 {
-        usize::MAX
-    } else {
-        cap_bytes as usize
-    };
-    let mut out = Vec::new();
-    while let Some(chunk) = input.next().await {
-        if out.len().saturating_add(chunk.len()) > cap {
-            return Err(());
+/// Longest bucket name a request may name (see [`is_safe_bucket_name`]).
+const BUCKET_NAME_SAFE_MAX_LEN: usize = 255;
+
+/// Whether `name` can safely name an existing bucket: non-empty, bounded,
+/// and unable to escape the blob namespace (no `/`, `\`, NUL or other
+/// control characters, not `.` or `..`). Looser than
+/// [`is_valid_bucket_name`] on purpose — buckets created before the S3 rule
+/// was enforced must stay readable and writable.
+pub(super) fn is_safe_bucket_name(name: &str) -> bool {
+    !name.is_empty()
+        && name.len() <= BUCKET_NAME_SAFE_MAX_LEN
+        && name != "."
+        && name != ".."
+        && !name
+            .chars()
+            .any(|c| c == '/' || c == '\\' || c.is_control())
+}
+
+/// Bucket names no one may create: folders the server uses itself, names
+/// that read as system-owned, and (see [`is_reserved_bucket_name`]) the
+/// app's own id.
+const RESERVED_BUCKET_NAMES: &[&str] = &[
+    "int_storage",
+    "int-storage",
+    "public",
+    "system",
+    crate::diagnostics::PROBE_FOLDER,
+    #[cfg(feature = "block-products")]
+    crate::blocks::products::media::BUCKET,
+];
+
+/// The app name as a bucket-style id: lowercase, runs of anything but
+/// letters and digits collapsed to one `-`, trimmed (`"My App"` → `"my-app"`).
+fn app_id(app_name: &str) -> String {
+    let mut id = String::with_capacity(app_name.len());
+    for c in app_name.chars().flat_map(char::to_lowercase) {
+        if c.is_ascii_alphanumeric() {
+            id.push(c);
+        } else if !id.is_empty() && !id.ends_with('-') {
+            id.push('-');
         }
-        out.extend_from_slice(&chunk);
     }
-    Ok(out)
+    id.trim_end_matches('-').to_string()
+}
+
+const APP_NAME_KEY: &str = "SOLOBASE_SHARED__APP_NAME";
+
+/// Whether `name` is reserved ([`RESERVED_BUCKET_NAMES`], or the id of the
+/// configured `SOLOBASE_SHARED__APP_NAME`).
+async fn is_reserved_bucket_name(ctx: &dyn Context, name: &str) -> bool {
+    if RESERVED_BUCKET_NAMES.contains(&name) {
+        return true;
+    }
+    let app_name = wafer_core::clients::config::get_default(ctx, APP_NAME_KEY, "").await;
+    let id = app_id(&app_name);
+    !id.is_empty() && id == name
+}
+
+/// Why `name` won't survive a move to S3, for buckets that predate
+/// [`is_valid_bucket_name`]; `None` for portable names.
+fn portability_warning(name: &str) -> Option<&'static str> {
+    (!is_valid_bucket_name(name)).then_some(
+        "Not a portable bucket name: S3 requires 3-63 lowercase letters, digits or hyphens, \
+         starting and ending alphanumeric. Move its objects to a bucket with a portable name \
+         before switching to S3 storage.",
+    )
+}
+
+/// When `"false"`, only admins may create or delete buckets; users keep
+/// working inside the buckets they own or can see.
+pub(super) const USER_BUCKETS_KEY: &str = "SUPPERS_AI__FILES__USER_BUCKETS";
+
+/// True when bucket management is admin-only ([`USER_BUCKETS_KEY`]) and
+/// the caller is not an admin.
+async fn is_bucket_management_denied(ctx: &dyn Context, msg: &Message) -> bool {
+    if crate::util::is_admin(msg) {
+        return false;
+    }
+    wafer_core::clients::config::get_default(ctx, USER_BUCKETS_KEY, "true").await == "false"
 }
 
 async fn handle_list_buckets(ctx: &dyn Context, msg: &Message) -> OutputStream {
     // [`repo::buckets::TABLE`] is the single source of truth for bucket
     // existence / ownership / visibility. Both the admin and user branches
-    // read it (the admin sees every bucket, the user only their own) —
+    // read it (the admin sees every bucket, the user their own plus those
+    // marked `visible_to_users`) —
     // storage folders are a blob namespace, not a directory we enumerate
     // here, so the admin list no longer diverges from `store::list_folders`.
     let owner = if crate::util::is_admin(msg) {
@@ -259,7 +742,19 @@ async fn handle_list_buckets(ctx: &dyn Context, msg: &Message) -> OutputStream {
                 .iter()
                 .filter_map(|r| r.data.get("name").and_then(|v| v.as_str()))
                 .collect();
-            ok_json(&serde_json::json!({"buckets": names}))
+            // Buckets that predate the S3 naming rule, keyed by name.
+            let warnings: serde_json::Map<String, serde_json::Value> = names
+                .iter()
+                .filter_map(|name| Some((name.to_string(), portability_warning(name)?.into())))
+                .collect();
+            let mut body = serde_json::json!({"buckets": names, "warnings": warnings});
+            if msg.query("include_stats") != "false" {
+                match bucket_stats::all(ctx).await {
+                    Ok(stats) => body["stats"] = bucket_stats::for_buckets(&stats, &names).into(),
+                    Err(e) => return err_internal("Database error", e),
+                }
+            }
+            crate::etag::ok_json(msg, &body)
         }
         Err(e) => err_internal("Database error", e),
     }
@@ -275,18 +770,69 @@ async fn handle_create_bucket(
         name: String,
         #[serde(default)]
         public: bool,
+        /// Create the bucket for this team instead of the caller.
+        #[serde(default)]
+        team_id: String,
+    }
+    if is_bucket_management_denied(ctx, msg).await {
+        return err_forbidden("Bucket management is restricted to admins");
     }
-    let raw = input.collect_to_bytes().await;
-    let body: Req = match serde_json::from_slice(&raw) {
+    let body: Req = match crate::body::decode(msg, input).await {
         Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+        Err(r) => return r,
     };
 
-    if body.name.is_empty() {
-        return err_bad_request("Bucket name is required");
+    if let Err(r) = crate::body::require(&[("name", &body.name)]) {
+        return r;
+    }
+    // Before the syntax check, so `int_storage` reports why it's refused.
+    if is_reserved_bucket_name(ctx, &body.name).await {
+        return errors::error_json(
+            errors::ErrorCode::BucketNameReserved,
+            "This bucket name is reserved",
+            Some(serde_json::json!({ "name": body.name })),
+        );
     }
     if !is_valid_bucket_name(&body.name) {
-        return err_bad_request("Invalid bucket name");
+        return errors::validation_error(
+            "Invalid bucket name",
+            &[(
+                "name",
+                "3-63 lowercase letters, digits or hyphens, starting and ending alphanumeric",
+            )],
+        );
+    }
+
+    if !body.team_id.is_empty() {
+        match repo::teams::find(ctx, &body.team_id).await {
+            Ok(Some(_)) => {}
+            Ok(None) => {
+                return errors::validation_error("Unknown team", &[("team_id", "no such team")])
+            }
+            Err(e) => return err_internal("Database error", e),
+        }
+        if !crate::util::is_admin(msg)
+            && !matches!(
+                repo::teams::role_of(ctx, &body.team_id, msg.user_id()).await,
+                Ok(Some(_))
+            )
+        {
+            return err_forbidden("Not a member of this team");
+        }
+    }
+
+    // Folder names are global, so a bucket name is too. Check before
+    // touching storage: the rollback below would otherwise delete the folder
+    // that belongs to the existing bucket.
+    match repo::buckets::name_exists(ctx, &body.name).await {
+        Ok(false) => {}
+        Ok(true) => {
+            return errors::error_response(
+                errors::ErrorCode::BucketAlreadyExists,
+                "Bucket already exists",
+            );
+        }
+        Err(e) => return errors::db_error_response("Bucket", e),
     }
 
     // Create the blob-namespace folder first, then record the metadata row.
@@ -299,7 +845,17 @@ async fn handle_create_bucket(
     // If it fails, compensate by deleting the just-created folder rather than
     // warn-and-continue (which would leave an orphan folder invisible to every
     // listing path, which now all read the table).
-    if let Err(e) = repo::buckets::insert(ctx, &body.name, body.public, msg.user_id()).await {
+    if let Err(e) =
+        repo::buckets::insert(ctx, &body.name, body.public, msg.user_id(), &body.team_id).await
+    {
+        // A concurrent create of the same name lost the race to the
+        // `name_exists` check above; its folder is the winner's, so leave it.
+        if errors::is_unique_violation(&e) {
+            return errors::error_response(
+                errors::ErrorCode::BucketAlreadyExists,
+                "Bucket already exists",
+            );
+        }
         if let Err(cleanup) = store::delete_folder(ctx, &body.name).await {
             tracing::error!(
                 bucket = %body.name,
@@ -307,61 +863,339 @@ async fn handle_create_bucket(
                 "failed to roll back orphan storage folder after bucket metadata insert failed",
             );
         }
-        return err_internal("Failed to create bucket", e);
+        return errors::db_error_response("Bucket", e);
     }
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "storage.bucket.create",
+        &format!("bucket:{}", body.name),
+        msg.remote_addr(),
+    )
+    .await;
     ok_json(&serde_json::json!({"name": body.name, "created": true}))
 }
 
+/// Delete a bucket. Only empty buckets can be deleted, unless an admin
+/// passes `?force=true`: then every object goes through the same delete
+/// path as a single-object delete (blob, metadata row, grants) so quota
+/// usage reconciles before the bucket itself is removed.
 async fn handle_delete_bucket(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let bucket = extract_bucket_name(msg);
     let bucket = bucket.as_str();
     if bucket.is_empty() {
         return err_bad_request("Missing bucket name");
     }
-    if !is_valid_bucket_name(bucket) {
+    if !is_safe_bucket_name(bucket) {
         return err_bad_request("Invalid bucket name");
     }
+    if is_bucket_management_denied(ctx, msg).await {
+        return err_forbidden("Bucket management is restricted to admins");
+    }
     if is_bucket_access_denied(ctx, msg, bucket).await {
         return err_forbidden("Access denied to this bucket");
     }
 
+    let force = msg.query("force") == "true";
+    if force && !crate::util::is_admin(msg) {
+        return err_forbidden("Only admins can force-delete a bucket");
+    }
+    // A lock anywhere in the bucket holds all of it, forced or not.
+    if let Err(r) = locks::guard(ctx, bucket, &[""]).await {
+        return r;
+    }
+    if force {
+        match delete_all_objects(ctx, bucket).await {
+            Ok(failed) if failed.is_empty() => {}
+            // The bucket stays, holding what's left, for a retry.
+            Ok(failed) => return partial_delete_response(&failed),
+            Err(e) => return err_internal("Failed to delete bucket objects", e),
+        }
+    } else {
+        match repo::objects::count_by_bucket(ctx, &[bucket.to_string()]).await {
+            Ok(counts) if counts.get(bucket).is_some_and(|n| *n > 0) => {
+                return errors::error_json(
+                    errors::ErrorCode::BucketNotEmpty,
+                    "Bucket is not empty",
+                    None,
+                );
+            }
+            Ok(_) => {}
+            Err(e) => return err_internal("Database error", e),
+        }
+    }
+
     match store::delete_folder(ctx, bucket).await {
         Ok(()) => {
-            // Clean up DB metadata for the bucket and its objects
-            repo::buckets::delete_by_name(ctx, bucket).await.ok();
-            repo::objects::delete_for_bucket(ctx, bucket).await.ok();
+            delete_bucket_rows(ctx, bucket).await;
+            audit_log(
+                ctx,
+                msg.user_id(),
+                if force {
+                    "storage.bucket.force_delete"
+                } else {
+                    "storage.bucket.delete"
+                },
+                &format!("bucket:{bucket}"),
+                msg.remote_addr(),
+            )
+            .await;
             ok_json(&serde_json::json!({"deleted": true}))
         }
         Err(e) => err_internal("Failed to delete bucket", e),
     }
 }
 
+/// Clean up DB metadata for a bucket whose folder is gone: the bucket row,
+/// its object rows, their history, its grants and its expired locks.
+pub(super) async fn delete_bucket_rows(ctx: &dyn Context, bucket: &str) {
+    repo::buckets::delete_by_name(ctx, bucket).await.ok();
+    repo::objects::delete_for_bucket(ctx, bucket).await.ok();
+    repo::events::delete_for_bucket(ctx, bucket).await.ok();
+    repo::acls::delete_for_bucket(ctx, bucket).await.ok();
+    repo::locks::delete_for_bucket(ctx, bucket).await.ok();
+}
+
+/// An object a bulk delete could not remove, and why.
+#[derive(Debug, serde::Serialize)]
+pub(super) struct DeleteFailure {
+    pub key: String,
+    pub error: String,
+}
+
+/// Delete every object row in `bucket`, with its blob, one by one via
+/// [`delete_object_and_metadata`]. An object that fails doesn't stop the
+/// rest: the failures come back per key, and a caller that got any keeps
+/// the bucket so a retry can finish. Rows list in key order, so each round
+/// pages from past the failures (the deleted rows are gone); a round that
+/// makes no progress is an error. Blobs without a row go with the bucket's
+/// storage folder.
+pub(super) async fn delete_all_objects(
+    ctx: &dyn Context,
+    bucket: &str,
+) -> Result<Vec<DeleteFailure>, WaferError> {
+    let mut failed: Vec<DeleteFailure> = Vec::new();
+    loop {
+        let offset = failed.len() as i64;
+        let list = repo::objects::list_under(ctx, bucket, "", false, 500, offset).await?;
+        if list.records.is_empty() {
+            return Ok(failed);
+        }
+        let mut deleted = 0;
+        for row in &list.records {
+            let key = row.str_field("key");
+            match delete_object_and_metadata(ctx, bucket, key).await {
+                Ok(()) => deleted += 1,
+                Err(e) if e.code == ErrorCode::NotFound => {
+                    delete_object_metadata(ctx, bucket, key).await;
+                    deleted += 1;
+                }
+                Err(e) => {
+                    tracing::warn!(
+                        bucket = %bucket,
+                        key = %key,
+                        error = %e,
+                        "bulk delete: object not deleted"
+                    );
+                    failed.push(DeleteFailure {
+                        key: key.to_string(),
+                        error: e.message,
+                    });
+                }
+            }
+        }
+        if deleted == 0 && failed.len() as i64 == offset {
+            return Err(WaferError::new(
+                ErrorCode::Internal,
+                "bucket objects could not be deleted",
+            ));
+        }
+    }
+}
+
+/// [`delete_all_objects`] for callers that stop at any failure: the
+/// failures as one error naming the keys.
+pub(super) async fn delete_all_objects_or_fail(
+    ctx: &dyn Context,
+    bucket: &str,
+) -> Result<(), WaferError> {
+    let failed = delete_all_objects(ctx, bucket).await?;
+    if failed.is_empty() {
+        return Ok(());
+    }
+    let keys: Vec<&str> = failed.iter().take(5).map(|f| f.key.as_str()).collect();
+    Err(WaferError::new(
+        ErrorCode::Internal,
+        format!(
+            "{} objects in bucket {bucket} could not be deleted ({}{})",
+            failed.len(),
+            keys.join(", "),
+            if failed.len() > keys.len() {
+                ", ..."
+            } else {
+                ""
+            },
+        ),
+    ))
+}
+
+/// The answer to a bulk delete that left objects behind: which, and why.
+fn partial_delete_response(failed: &[DeleteFailure]) -> OutputStream {
+    errors::error_json(
+        errors::ErrorCode::InternalError,
+        &format!("{} objects could not be deleted", failed.len()),
+        Some(serde_json::json!({ "failed": failed })),
+    )
+}
+
+/// `PUT /admin/storage/buckets/{name}/visibility` — body
+/// `{"visible_to_users": bool}`. Visible buckets are listed for every user
+/// and readable by them; writes stay with the owner and grantees.
+async fn handle_set_visibility(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        visible_to_users: bool,
+    }
+    let bucket = extract_bucket_name(msg);
+    let body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    match repo::buckets::set_visible_to_users(ctx, &bucket, body.visible_to_users).await {
+        Ok(0) => err_not_found("Bucket not found"),
+        Ok(_) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "storage.bucket.visibility",
+                &format!("bucket:{bucket}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&serde_json::json!({
+                "name": bucket,
+                "visible_to_users": body.visible_to_users,
+            }))
+        }
+        Err(e) => errors::db_error_response("Bucket", e),
+    }
+}
+
+/// Page-size limits for object listings. Paging is offset-based in key
+/// order (see [`pagination::parse_offset`]).
+const OBJECT_LIST_SPEC: ListSpec = ListSpec {
+    default_limit: 50,
+    max_limit: 1000,
+    sortable: &["key"],
+    default_sort: "key",
+    default_desc: false,
+};
+
 async fn handle_list_objects(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let bucket = extract_bucket_name(msg);
     let bucket = bucket.as_str();
     if bucket.is_empty() {
         return err_bad_request("Missing bucket name");
     }
-    if !is_valid_bucket_name(bucket) {
+    if !is_safe_bucket_name(bucket) {
         return err_bad_request("Invalid bucket name");
     }
-    if is_bucket_access_denied(ctx, msg, bucket).await {
-        return err_forbidden("Access denied to this bucket");
-    }
 
+    // Grantees may list only inside a shared folder, so the prefix itself
+    // must be covered by a folder (or whole-bucket) grant.
     let prefix = msg.query("prefix").to_string();
-    let (_, page_size, offset) = msg.pagination_params(50);
-
-    let opts = store::ListOptions {
-        prefix,
-        limit: page_size as i64,
-        offset: offset as i64,
+    let folders = acl::listing_grants(ctx, msg, bucket, &prefix).await;
+    if folders.as_ref().is_some_and(Vec::is_empty) {
+        return err_forbidden("Access denied to this bucket");
+    }
+    let (query, offset) = match pagination::parse_offset(msg, &OBJECT_LIST_SPEC) {
+        Ok(parsed) => parsed,
+        Err(e) => return e.response(),
     };
+    // Keys list in one fixed order.
+    if query.desc {
+        return errors::validation_error(
+            "Invalid list parameters",
+            &[("sort", "objects can only be listed in ascending key order")],
+        );
+    }
 
-    match store::list(ctx, bucket, &opts).await {
-        Ok(list) => ok_json(&list),
-        Err(e) => err_internal("Storage error", e),
+    // Names live on the object rows; the storage folder holds blobs by id
+    // (see `blobs`). Archived objects (lifecycle rules) keep their blob but
+    // leave normal listings, as do quarantined ones (`scan`).
+    let list = match repo::objects::list_under(
+        ctx,
+        bucket,
+        &prefix,
+        true,
+        i64::from(query.limit),
+        offset as i64,
+    )
+    .await
+    {
+        Ok(list) => list,
+        Err(e) => return err_internal("Database error", e),
+    };
+    // Lock state per object, so the UI can show a padlock.
+    let locks = match locks::LockSet::load(ctx, bucket).await {
+        Ok(locks) => locks,
+        Err(e) => return err_internal("Database error", e),
+    };
+    // Download counts are the owner's (and admins'); grantees don't see
+    // how often a file is fetched.
+    let owner = !is_bucket_access_denied(ctx, msg, bucket).await;
+    let end = offset as i64 + list.records.len() as i64;
+    let has_more = end < list.total_count;
+    let objects: Vec<serde_json::Value> = list
+        .records
+        .iter()
+        // The covering grant holds for everything under the prefix; this
+        // only keeps a listing from ever outrunning it.
+        .filter(|row| {
+            folders.as_ref().map_or(true, |folders| {
+                let key = row.str_field("key");
+                folders.iter().any(|f| key.starts_with(f.as_str()))
+            })
+        })
+        .map(|row| {
+            let key = row.str_field("key");
+            let lock = locks.covering(key);
+            let mut object = serde_json::json!({
+                "key": key,
+                "size": row.i64_field("size"),
+                "content_type": row.str_field("content_type"),
+                "sha256": Some(row.str_field("sha256")).filter(|s| !s.is_empty()),
+                "last_modified": row.str_field("uploaded_at"),
+                "modified_by": row.str_field("modified_by"),
+                "metadata": metadata::parse_stored(row.str_field("metadata")),
+                "locked_until": lock.map(|l| l.locked_until.as_str()),
+                "locked_by": lock.map(|l| l.locked_by.as_str()),
+            });
+            if owner {
+                object["download_count"] = row.i64_field("download_count").into();
+            }
+            if let Some(fields) = &query.fields {
+                pagination::project_object(&mut object, fields, "key");
+            }
+            object
+        })
+        .collect();
+    if !query.envelope {
+        return ok_json(&serde_json::json!({
+            "objects": objects,
+            "total_count": list.total_count,
+        }));
     }
+    let next_cursor = has_more.then(|| pagination::encode_offset_cursor(end as u64));
+    ok_json(&pagination::envelope(
+        serde_json::Value::Array(objects),
+        next_cursor,
+        Some(list.total_count),
+    ))
 }
 
 async fn handle_get_object(ctx: &dyn Context, msg: &Message) -> OutputStream {
@@ -375,18 +1209,26 @@ async fn handle_get_object(ctx: &dyn Context, msg: &Message) -> OutputStream {
     if !is_valid_storage_key(key) {
         return err_bad_request("Invalid object key");
     }
-    if is_bucket_access_denied(ctx, msg, bucket).await {
+    if acl::is_access_denied(ctx, msg, bucket, key, Access::Read).await {
         return err_forbidden("Access denied to this bucket");
     }
+    if let Some(blocked) = scan::read_blocked(ctx, msg.user_id(), bucket, key).await {
+        return blocked;
+    }
 
     // Track view in DB
-    if let Err(e) = repo::views::insert(ctx, bucket, key, msg.user_id()).await {
-        tracing::warn!("Failed to track storage object view: {e}");
-    }
+    repo::views::record(ctx, bucket, key, msg.user_id()).await;
 
-    match store::get(ctx, bucket, key).await {
-        Ok((data, info)) => ResponseBuilder::new().body(data, &info.content_type),
-        Err(e) if e.code == ErrorCode::NotFound => err_not_found("Object not found"),
+    match blobs::get_with_id(ctx, bucket, key).await {
+        Ok((data, content_type, object_id)) => {
+            if let Some(object_id) = object_id {
+                repo::objects::record_download(ctx, &object_id).await;
+            }
+            ResponseBuilder::new().body(data, &content_type)
+        }
+        Err(e) if e.code == ErrorCode::NotFound => {
+            errors::error_response(errors::ErrorCode::ObjectNotFound, "Object not found")
+        }
         Err(e) => err_internal("Storage error", e),
     }
 }
@@ -415,9 +1257,27 @@ async fn handle_upload_object(
     if !query_key.is_empty() && !is_valid_storage_key(&query_key) {
         return err_bad_request("Invalid object key");
     }
-    if is_bucket_access_denied(ctx, msg, bucket).await {
+    let expected = match checksum::parse_expected(msg.query("sha256")) {
+        Ok(expected) => expected,
+        Err(reason) => return errors::validation_error("Invalid upload", &[("sha256", reason)]),
+    };
+    // Check the write grant before buffering when the key is known up
+    // front; a multipart upload naming its key only in the file part is
+    // checked once the part is parsed, below.
+    if !query_key.is_empty()
+        && acl::is_access_denied(ctx, msg, bucket, &query_key, Access::Write).await
+    {
         return err_forbidden("Access denied to this bucket");
     }
+    // Conditional upload: content the object already has is not read at
+    // all (see `checksum`).
+    if let (false, Some(expected)) = (query_key.is_empty(), &expected) {
+        match checksum::unchanged(ctx, bucket, &query_key, expected).await {
+            Ok(Some(row)) => return ok_json(&checksum::not_modified_json(&row)),
+            Ok(None) => {}
+            Err(e) => return err_internal("Database error", e),
+        }
+    }
 
     // Best-effort sweep before quota check: orphan `pending` rows (from
     // previous uploads where the storage put failed AND the compensating
@@ -436,11 +1296,16 @@ async fn handle_upload_object(
     // applies to the envelope — a slight over-estimate (the extracted file
     // is always smaller than its envelope), never an under-estimate.
     let quota = super::quota::get_user_quota(ctx, msg.user_id()).await;
-    let Ok(body_bytes) = collect_with_cap(input, quota.max_file_size_bytes).await else {
-        return err_bad_request(&format!(
-            "File exceeds maximum size of {} bytes",
-            quota.max_file_size_bytes
-        ));
+    let Ok(body_bytes) = crate::util::collect_with_cap(input, quota.max_file_size_bytes).await
+    else {
+        return errors::error_json(
+            errors::ErrorCode::FileTooLarge,
+            &format!(
+                "File exceeds maximum size of {} bytes",
+                quota.max_file_size_bytes
+            ),
+            None,
+        );
     };
 
     // Browser uploads (`FormData` + fetch) arrive as `multipart/form-data`:
@@ -455,7 +1320,8 @@ async fn handle_upload_object(
         else {
             return err_bad_request("Multipart body contains no file part");
         };
-        let key = if query_key.is_empty() {
+        let query_key_empty = query_key.is_empty();
+        let key = if query_key_empty {
             file.filename.unwrap_or_default()
         } else {
             query_key
@@ -466,6 +1332,9 @@ async fn handle_upload_object(
         if !is_valid_storage_key(&key) {
             return err_bad_request("Invalid object key");
         }
+        if query_key_empty && acl::is_access_denied(ctx, msg, bucket, &key, Access::Write).await {
+            return err_forbidden("Access denied to this bucket");
+        }
         // The part's own Content-Type wins; fall back to extension-based
         // detection on the key (which itself falls back to octet-stream).
         let content_type = file
@@ -483,41 +1352,148 @@ async fn handle_upload_object(
         };
         (body_bytes, query_key, content_type)
     };
-
-    if let Err(r) = super::quota::check_quota(ctx, msg.user_id(), content.len() as i64).await {
-        return r;
+    if let Some(expected) = &expected {
+        let actual = checksum::digest(&content);
+        if *expected != actual {
+            return checksum::mismatch(expected, &actual).response();
+        }
+        // The key may only have been known from the file part.
+        match checksum::unchanged(ctx, bucket, &key, expected).await {
+            Ok(Some(row)) => return ok_json(&checksum::not_modified_json(&row)),
+            Ok(None) => {}
+            Err(e) => return err_internal("Database error", e),
+        }
     }
 
-    // Insert a pending record BEFORE uploading so concurrent quota checks see it.
-    // This closes the TOCTOU race between check_quota and the actual upload.
-    let pending_record = match repo::objects::insert_pending(
+    // Locks, then the size, storage and file-count limits — the same
+    // evaluation the upload check answers from.
+    let team_id = match UploadCheck::run(ctx, msg.user_id(), bucket, &key, content.len() as i64)
+        .await
+        .and_then(UploadCheck::into_result)
+    {
+        Ok(team_id) => team_id,
+        Err(r) => return r,
+    };
+
+    let held = match scan::admit(ctx, &content, &key).await {
+        Admission::Store => false,
+        Admission::Hold => true,
+        Admission::Reject { signature } => return scan::rejected(&signature),
+    };
+    let sha256 = match put_object(
         ctx,
         bucket,
         &key,
-        content.len(),
+        &content,
         &content_type,
         msg.user_id(),
+        &team_id,
+        held,
     )
     .await
     {
-        Ok(record) => record,
-        Err(e) => return err_internal("Failed to reserve upload slot", e),
+        Ok(row) => {
+            history::record(ctx, history::Event::created(&row, msg.user_id())).await;
+            row.str_field("sha256").to_string()
+        }
+        Err((what, e)) => return err_internal(what, e),
     };
+    after_upload(ctx, msg.user_id(), &team_id).await;
+    let mut body = serde_json::json!({
+        "bucket": bucket,
+        "key": key,
+        "sha256": sha256,
+        "uploaded": true,
+    });
+    if held {
+        body["status"] = serde_json::json!(repo::objects::STATUS_PENDING_SCAN);
+    }
+    if let Some(expires_at) = super::lifecycle::expiry_for_upload(ctx, bucket, &key).await {
+        body["expires_at"] = serde_json::json!(expires_at);
+    }
+    ok_json(&body)
+}
+
+/// Store one quota-checked object: reserve its `pending` row (recording the
+/// content's checksum, see `checksum`), write the
+/// blob at the row's id key (see `blobs`), then mark the row complete — or, when `held` for a malware scan,
+/// `pending_scan` with its scan job queued. A failed write removes the
+/// reservation and any partly written blob again. Returns the object's
+/// row; on error, the client-facing message with the cause. Shared by
+/// single uploads and archive expansion, which record the `create` event
+/// (see `history`).
+#[allow(clippy::too_many_arguments)]
+pub(super) async fn put_object(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+    content: &[u8],
+    content_type: &str,
+    user_id: &str,
+    team_id: &str,
+    held: bool,
+) -> Result<Record, (&'static str, WaferError)> {
+    // Insert a pending record BEFORE uploading so concurrent quota checks see it.
+    // This closes the TOCTOU race between the quota check and the actual upload.
+    let pending_record = repo::objects::insert_pending(
+        ctx,
+        bucket,
+        key,
+        content.len(),
+        &checksum::digest(content),
+        content_type,
+        user_id,
+        team_id,
+    )
+    .await
+    .map_err(|e| ("Failed to reserve upload slot", e))?;
 
-    match store::put(ctx, bucket, &key, &content, &content_type).await {
+    let storage_key = repo::objects::blob_key(&pending_record.id);
+    match store::put(ctx, bucket, &storage_key, content, content_type).await {
+        Ok(()) if held => {
+            if let Err(e) = repo::objects::mark_pending_scan(ctx, &pending_record.id).await {
+                tracing::warn!("Failed to mark upload as pending scan: {e}");
+            }
+            scan::queue(ctx, &pending_record.id).await;
+            Ok(pending_record)
+        }
         Ok(()) => {
             // Upload succeeded — mark the pending record as complete.
             if let Err(e) = repo::objects::mark_complete(ctx, &pending_record.id).await {
                 tracing::warn!("Failed to mark upload as complete: {e}");
             }
-            ok_json(&serde_json::json!({"bucket": bucket, "key": key, "uploaded": true}))
+            Ok(pending_record)
         }
         Err(e) => {
-            // Upload failed — delete the pending record so it doesn't block quota.
+            // Upload failed — delete the pending record so it doesn't block
+            // quota, and whatever part of the blob the write left behind (no
+            // row points at it any more; the consistency check would only find it
+            // later).
             if let Err(del_err) = repo::objects::delete(ctx, &pending_record.id).await {
                 tracing::warn!("Failed to clean up pending record: {del_err}");
             }
-            err_internal("Upload failed", e)
+            match store::delete(ctx, bucket, &storage_key).await {
+                Ok(()) => {}
+                Err(del_err) if del_err.code == ErrorCode::NotFound => {}
+                Err(del_err) => tracing::warn!("Failed to clean up partial upload: {del_err}"),
+            }
+            Err(("Upload failed", e))
+        }
+    }
+}
+
+/// Follow-up work after a request stored new objects: queue the quota
+/// threshold check.
+pub(super) async fn after_upload(ctx: &dyn Context, user_id: &str, team_id: &str) {
+    // Threshold notifications track personal usage only.
+    if team_id.is_empty() {
+        let notify = serde_json::json!({ "user_id": user_id });
+        let jobs = Services::new(ctx, "suppers-ai/files").jobs();
+        if let Err(e) = jobs
+            .enqueue(super::quota::NOTIFY_JOB, &notify, Default::default())
+            .await
+        {
+            tracing::warn!(error = %e, "failed to queue quota notification");
         }
     }
 }
@@ -536,20 +1512,51 @@ async fn handle_delete_object(ctx: &dyn Context, msg: &Message) -> OutputStream
     if is_bucket_access_denied(ctx, msg, bucket).await {
         return err_forbidden("Access denied to this bucket");
     }
+    if let Err(r) = locks::guard(ctx, bucket, &[key]).await {
+        return r;
+    }
 
-    match store::delete(ctx, bucket, key).await {
+    let row = match repo::objects::find_by_bucket_key(ctx, bucket, key).await {
+        Ok(row) => row,
+        Err(e) => return err_internal("Database error", e),
+    };
+    match delete_object_and_metadata(ctx, bucket, key).await {
         Ok(()) => {
-            // Clean up metadata
-            repo::objects::delete_by_bucket_key(ctx, bucket, key)
-                .await
-                .ok();
+            if let Some(row) = row {
+                history::record(ctx, history::Event::deleted(&row, msg.user_id())).await;
+            }
             ok_json(&serde_json::json!({"deleted": true}))
         }
-        Err(e) if e.code == ErrorCode::NotFound => err_not_found("Object not found"),
+        Err(e) if e.code == ErrorCode::NotFound => {
+            errors::error_response(errors::ErrorCode::ObjectNotFound, "Object not found")
+        }
         Err(e) => err_internal("Delete failed", e),
     }
 }
 
+/// Delete the blob, then its metadata row and ACL grants. Shared by the
+/// delete endpoint and lifecycle expiry so both keep quota usage (summed
+/// from object rows) in step with storage.
+pub(super) async fn delete_object_and_metadata(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+) -> Result<(), WaferError> {
+    store::delete(ctx, bucket, &blobs::locate(ctx, bucket, key).await?).await?;
+    delete_object_metadata(ctx, bucket, key).await;
+    Ok(())
+}
+
+/// Best-effort removal of an object's metadata row, ACL grants and expired
+/// lock.
+pub(super) async fn delete_object_metadata(ctx: &dyn Context, bucket: &str, key: &str) {
+    repo::objects::delete_by_bucket_key(ctx, bucket, key)
+        .await
+        .ok();
+    repo::acls::delete_for_path(ctx, bucket, key).await.ok();
+    repo::locks::delete_for_path(ctx, bucket, key).await.ok();
+}
+
 async fn handle_search(ctx: &dyn Context, msg: &Message) -> OutputStream {
     let query = msg.query("q").to_string();
     if query.is_empty() {
@@ -566,11 +1573,58 @@ async fn handle_search(ctx: &dyn Context, msg: &Message) -> OutputStream {
     )
     .await
     {
-        Ok(result) => ok_json(&result),
+        Ok(mut result) => {
+            for record in &mut result.records {
+                record.data.insert("type".to_string(), "file".into());
+                super::metadata::expand(record);
+            }
+            let mut records = folder_hits(&result.records, &query);
+            records.append(&mut result.records);
+            result.records = records;
+            ok_json(&result)
+        }
         Err(e) => err_internal("Search failed", e),
     }
 }
 
+/// Folders are key prefixes, not rows, so folder hits are derived from the
+/// matched objects: every parent prefix of a result whose own segment
+/// contains `query` (ignoring case) becomes a `type: "folder"` record,
+/// once per bucket/prefix. They lead the page; `total_count` still counts
+/// files only.
+fn folder_hits(objects: &[Record], query: &str) -> Vec<Record> {
+    let needle = query.to_lowercase();
+    let mut seen = std::collections::HashSet::new();
+    let mut folders = Vec::new();
+    for object in objects {
+        let bucket = object.str_field("bucket");
+        let key = object.str_field("key");
+        let mut end = 0;
+        while let Some(slash) = key[end..].find('/') {
+            let prefix = &key[..end + slash + 1];
+            let name = &key[end..end + slash];
+            end += slash + 1;
+            if name.is_empty() || !name.to_lowercase().contains(&needle) {
+                continue;
+            }
+            if !seen.insert((bucket.to_string(), prefix.to_string())) {
+                continue;
+            }
+            let data = crate::util::json_map(serde_json::json!({
+                "type": "folder",
+                "bucket": bucket,
+                "key": prefix,
+                "name": name,
+            }));
+            folders.push(Record {
+                id: format!("folder:{bucket}/{prefix}"),
+                data,
+            });
+        }
+    }
+    folders
+}
+
 async fn handle_recent(ctx: &dyn Context, msg: &Message) -> OutputStream {
     match repo::views::list_recent_for_user(ctx, msg.user_id(), 20).await {
         Ok(result) => ok_json(&result),
@@ -593,6 +1647,28 @@ mod tests {
         m
     }
 
+    #[test]
+    fn folder_hits_lists_matching_prefixes_once() {
+        let object = |key: &str| Record {
+            id: key.to_string(),
+            data: crate::util::json_map(serde_json::json!({"bucket": "b", "key": key})),
+        };
+        let objects = [
+            object("Projects/2026/plan.txt"),
+            object("Projects/2026/budget.txt"),
+            object("archive/projects-old/x.txt"),
+            object("plan.txt"),
+        ];
+        let keys: Vec<String> = folder_hits(&objects, "PROJECT")
+            .iter()
+            .map(|r| r.str_field("key").to_string())
+            .collect();
+        assert_eq!(keys, ["Projects/", "archive/projects-old/"]);
+        assert!(folder_hits(&objects, "2026")
+            .iter()
+            .all(|r| r.str_field("type") == "folder"));
+    }
+
     #[test]
     fn test_extract_bucket_name_from_param() {
         // Router-populated path var wins (the normal dispatch path).
@@ -720,113 +1796,16 @@ mod tests {
 
 #[cfg(test)]
 mod integration_tests {
-    use std::{
-        collections::HashMap,
-        sync::{Arc, Mutex},
-    };
-
-    use async_trait::async_trait;
     use serde_json::json;
-    use wafer_core::{
-        interfaces::storage::service::{
-            FolderInfo, ListOptions as StoreListOptions, ObjectInfo, ObjectList, StorageError,
-            StorageService,
-        },
-        service_blocks::storage::StorageBlock,
-    };
 
     use super::*;
     use crate::test_support::{admin_msg, auth_msg, output_json, TestContext};
 
-    /// `(folder, key)` → `(bytes, content_type)`.
-    type MemObjects = HashMap<(String, String), (Vec<u8>, String)>;
-
-    /// In-memory [`StorageService`] so upload tests exercise the production
-    /// `wafer-run/storage` [`StorageBlock`] wire protocol end-to-end (the
-    /// typed `store::put`/`store::get` clients round-trip through the real
-    /// handler) without touching the filesystem.
-    #[derive(Default)]
-    struct MemStorage {
-        objects: Mutex<MemObjects>,
-    }
-
-    #[async_trait]
-    impl StorageService for MemStorage {
-        async fn put(
-            &self,
-            folder: &str,
-            key: &str,
-            data: &[u8],
-            content_type: &str,
-        ) -> Result<(), StorageError> {
-            self.objects.lock().unwrap().insert(
-                (folder.to_string(), key.to_string()),
-                (data.to_vec(), content_type.to_string()),
-            );
-            Ok(())
-        }
-
-        async fn get(
-            &self,
-            folder: &str,
-            key: &str,
-        ) -> Result<(Vec<u8>, ObjectInfo), StorageError> {
-            let guard = self.objects.lock().unwrap();
-            let (data, content_type) = guard
-                .get(&(folder.to_string(), key.to_string()))
-                .ok_or(StorageError::NotFound)?;
-            Ok((
-                data.clone(),
-                ObjectInfo {
-                    key: key.to_string(),
-                    size: data.len() as i64,
-                    content_type: content_type.clone(),
-                    last_modified: chrono::DateTime::<chrono::Utc>::from_timestamp(0, 0)
-                        .expect("epoch"),
-                },
-            ))
-        }
-
-        async fn delete(&self, folder: &str, key: &str) -> Result<(), StorageError> {
-            self.objects
-                .lock()
-                .unwrap()
-                .remove(&(folder.to_string(), key.to_string()));
-            Ok(())
-        }
-
-        async fn list(
-            &self,
-            _folder: &str,
-            _opts: &StoreListOptions,
-        ) -> Result<ObjectList, StorageError> {
-            Ok(ObjectList {
-                objects: vec![],
-                total_count: 0,
-            })
-        }
-
-        async fn create_folder(&self, _name: &str, _public: bool) -> Result<(), StorageError> {
-            Ok(())
-        }
-
-        async fn delete_folder(&self, _name: &str) -> Result<(), StorageError> {
-            Ok(())
-        }
-
-        async fn list_folders(&self) -> Result<Vec<FolderInfo>, StorageError> {
-            Ok(vec![])
-        }
-    }
-
     /// `TestContext::with_files()` plus a real `wafer-run/storage` block over
-    /// [`MemStorage`], so `handle_upload_object` can complete its `store::put`.
+    /// [`crate::test_support::MemStorage`], so `handle_upload_object` can complete its `store::put`.
     async fn ctx_with_storage() -> TestContext {
         let mut ctx = TestContext::with_files().await;
-        ctx.register_block(
-            "wafer-run/storage",
-            Arc::new(StorageBlock::new(Arc::new(MemStorage::default()))),
-        );
+        ctx.register_mem_storage();
         ctx
     }
 
@@ -921,7 +1900,7 @@ mod integration_tests {
             "upload failed: {resp}"
         );
 
-        let (stored, info) = store::get(&ctx, "site-assets", "index.html")
+        let (stored, content_type) = blobs::get(&ctx, "site-assets", "index.html")
             .await
             .expect("stored object");
         assert_eq!(
@@ -929,7 +1908,7 @@ mod integration_tests {
             "stored content must be the file bytes, not the multipart envelope"
         );
         assert_eq!(
-            info.content_type, "text/html",
+            content_type, "text/html",
             "stored content type must come from the file part, not the multipart request header"
         );
 
@@ -961,11 +1940,11 @@ mod integration_tests {
             "upload failed: {resp}"
         );
 
-        let (stored, info) = store::get(&ctx, "raw-bucket", "notes.txt")
+        let (stored, content_type) = blobs::get(&ctx, "raw-bucket", "notes.txt")
             .await
             .expect("stored object");
         assert_eq!(stored, body, "raw body must be stored unchanged");
-        assert_eq!(info.content_type, "text/plain");
+        assert_eq!(content_type, "text/plain");
 
         let (size, content_type, status) = sole_object_row(&ctx).await;
         assert_eq!(size, body.len() as i64);
@@ -973,6 +1952,41 @@ mod integration_tests {
         assert_eq!(status, "complete");
     }
 
+    /// `?sha256=` makes an upload conditional: a key already holding that
+    /// content answers `not_modified`, and bytes that don't hash to it are
+    /// refused.
+    #[tokio::test]
+    async fn upload_with_expected_checksum_is_conditional() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "sync", "alice").await;
+        let body: &[u8] = b"v1";
+        let sum = checksum::digest(body);
+
+        let mut msg = upload_msg("sync", "a.txt", "text/plain");
+        msg.set_meta("req.query.sha256", &sum);
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(body.to_vec())).await;
+        let resp = output_json(out).await;
+        assert_eq!(resp["uploaded"], true, "{resp}");
+        assert_eq!(resp["sha256"], sum.as_str());
+
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(body.to_vec())).await;
+        let resp = output_json(out).await;
+        assert_eq!(resp["not_modified"], true, "{resp}");
+        assert_eq!(resp["uploaded"], false);
+
+        let mut msg = upload_msg("sync", "b.txt", "text/plain");
+        msg.set_meta("req.query.sha256", &sum);
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"v2".to_vec())).await;
+        let resp = output_json(out).await;
+        assert_eq!(resp["code"], "checksum_mismatch", "{resp}");
+        assert_eq!(resp["details"]["actual"], checksum::digest(b"v2").as_str());
+        assert!(blobs::get(&ctx, "sync", "b.txt").await.is_err());
+
+        msg.set_meta("req.query.sha256", "abc");
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"v2".to_vec())).await;
+        assert_eq!(output_json(out).await["code"], "validation_failed");
+    }
+
     /// A multipart upload without `?key=` falls back to the file part's
     /// `filename` as the object key (the URL query param still wins when
     /// present).
@@ -998,7 +2012,7 @@ mod integration_tests {
             "key must fall back to the part filename: {resp}"
         );
 
-        let (stored, _) = store::get(&ctx, "site-assets", "from-part.html")
+        let (stored, _) = blobs::get(&ctx, "site-assets", "from-part.html")
             .await
             .expect("stored object");
         assert_eq!(stored, file_bytes);
@@ -1032,6 +2046,7 @@ mod integration_tests {
         let data = crate::util::json_map(json!({
             "bucket": bucket,
             "key": key,
+            "key_lower": key.to_lowercase(),
             "size": 0,
             "content_type": "application/octet-stream",
             "status": "complete",
@@ -1086,6 +2101,210 @@ mod integration_tests {
         assert_eq!(names, vec!["alice-bucket"]);
     }
 
+    /// The listing carries each bucket's stats unless `?include_stats=false`.
+    #[tokio::test]
+    async fn list_buckets_reports_stats_per_bucket() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "alice-bucket", "alice").await;
+        blobs::seed(
+            &ctx,
+            "alice-bucket",
+            "a.txt",
+            b"hello",
+            "text/plain",
+            "alice",
+        )
+        .await;
+
+        let out =
+            handle_list_buckets(&ctx, &auth_msg("retrieve", "/storage/buckets", "alice")).await;
+        let stats = &output_json(out).await["stats"]["alice-bucket"];
+        assert_eq!(stats["object_count"], 1, "{stats}");
+        assert_eq!(stats["total_bytes"], 5);
+        assert!(stats["last_activity_at"].is_string());
+
+        let mut msg = auth_msg("retrieve", "/storage/buckets", "alice");
+        msg.set_meta("req.query.include_stats", "false");
+        let body = output_json(handle_list_buckets(&ctx, &msg).await).await;
+        assert!(body.get("stats").is_none(), "{body}");
+    }
+
+    /// Buckets an admin marks `visible_to_users` join every user's list
+    /// (and become readable), without exposing other private buckets.
+    #[tokio::test]
+    async fn user_list_buckets_includes_visible_buckets_only() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "alice-bucket", "alice").await;
+        seed_bucket(&ctx, "bob-private", "bob").await;
+        seed_bucket(&ctx, "handbook", "admin").await;
+        store::put(&ctx, "handbook", "intro.txt", b"hi", "text/plain")
+            .await
+            .expect("put");
+
+        let out = handle_admin(
+            &ctx,
+            admin_msg("update", "/admin/storage/buckets/handbook/visibility"),
+            InputStream::from_bytes(br#"{"visible_to_users":true}"#.to_vec()),
+        )
+        .await;
+        assert_eq!(output_json(out).await["visible_to_users"], true);
+
+        let out =
+            handle_list_buckets(&ctx, &auth_msg("retrieve", "/storage/buckets", "alice")).await;
+        let mut names = bucket_names(&output_json(out).await);
+        names.sort();
+        assert_eq!(names, vec!["alice-bucket", "handbook"]);
+
+        let mut get = auth_msg(
+            "retrieve",
+            "/b/storage/api/buckets/handbook/objects/intro.txt",
+            "alice",
+        );
+        get.set_meta("req.param.name", "handbook");
+        get.set_meta("req.param.key", "intro.txt");
+        let out = handle_get_object(&ctx, &get).await;
+        assert_eq!(out.collect_buffered().await.expect("body").body, b"hi");
+
+        let out = handle_list_buckets(&ctx, &admin_msg("retrieve", "/admin/storage/buckets")).await;
+        assert_eq!(bucket_names(&output_json(out).await).len(), 3);
+    }
+
+    fn delete_bucket_msg(bucket: &str, user: &str, force: bool) -> Message {
+        let mut msg = auth_msg("delete", &format!("/b/storage/api/buckets/{bucket}"), user);
+        msg.set_meta("req.param.name", bucket);
+        if force {
+            msg.set_meta("req.query.force", "true");
+        }
+        msg
+    }
+
+    async fn audit_actions(ctx: &TestContext) -> Vec<String> {
+        wafer_core::clients::database::list_all(ctx, crate::blocks::admin::AUDIT_LOGS_TABLE, vec![])
+            .await
+            .expect("audit rows")
+            .iter()
+            .map(|r| r.str_field("action").to_string())
+            .collect()
+    }
+
+    /// Non-owners get 403; owners may only delete empty buckets; only an
+    /// admin can force-delete, which removes each object (and its quota
+    /// row) first. Every deletion is audited.
+    #[tokio::test]
+    async fn delete_bucket_enforces_ownership_emptiness_and_force() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        let msg = upload_msg("team", "a.txt", "text/plain");
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"a".to_vec())).await;
+        assert!(output_json(out).await["uploaded"].as_bool().unwrap());
+
+        let out = handle_delete_bucket(&ctx, &delete_bucket_msg("team", "bob", false)).await;
+        assert!(crate::test_support::output_is_error(out, "PermissionDenied").await);
+
+        let out = handle_delete_bucket(&ctx, &delete_bucket_msg("team", "alice", false)).await;
+        assert_eq!(output_json(out).await["code"], "bucket_not_empty");
+
+        let out = handle_delete_bucket(&ctx, &delete_bucket_msg("team", "alice", true)).await;
+        assert!(crate::test_support::output_is_error(out, "PermissionDenied").await);
+
+        let mut admin = admin_msg("delete", "/admin/storage/buckets/team");
+        admin.set_meta("req.query.force", "true");
+        let out = handle_admin(&ctx, admin, InputStream::from_bytes(Vec::new())).await;
+        assert_eq!(output_json(out).await["deleted"], true);
+        assert!(repo::objects::list_all(&ctx).await.unwrap().is_empty());
+        assert!(!repo::buckets::name_exists(&ctx, "team").await.unwrap());
+        assert_eq!(audit_actions(&ctx).await, ["storage.bucket.force_delete"]);
+    }
+
+    /// A storage write that fails after the quota reservation — outright or
+    /// part way through — answers 500 and leaves neither the `pending` row
+    /// (which would hold quota) nor a partial blob behind.
+    #[tokio::test]
+    async fn failed_upload_releases_its_reservation() {
+        for mode in ["error", "partial_write"] {
+            let mut ctx = TestContext::with_files().await;
+            ctx.register_faulty_storage();
+            seed_bucket(&ctx, "docs", "alice").await;
+            let rule = json!({ "op": "put", "key": "docs/*", "mode": mode, "count": 1 });
+            crate::test_support::inject_storage_fault(rule).await;
+
+            let msg = upload_msg("docs", "a.txt", "text/plain");
+            let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"abcd".to_vec()));
+            assert_eq!(
+                crate::test_support::output_status(out.await).await,
+                500,
+                "{mode}"
+            );
+            assert!(
+                repo::objects::list_all(&ctx).await.unwrap().is_empty(),
+                "{mode}"
+            );
+            assert_eq!(super::super::quota::get_used_bytes(&ctx, "alice").await, 0);
+            let opts = store::ListOptions {
+                prefix: String::new(),
+                limit: 10,
+                offset: 0,
+            };
+            let blobs = store::list(&ctx, "docs", &opts).await.unwrap().objects;
+            assert!(blobs.is_empty(), "{mode}: partial blob left behind");
+        }
+    }
+
+    /// A force delete that can't remove some objects still removes the
+    /// rest, then reports each failure and keeps the bucket for a retry.
+    #[tokio::test]
+    async fn force_delete_reports_objects_it_could_not_delete() {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_faulty_storage();
+        seed_bucket(&ctx, "team", "alice").await;
+        for key in ["a.txt", "b.txt"] {
+            let msg = upload_msg("team", key, "text/plain");
+            let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"x".to_vec()));
+            assert_eq!(output_json(out.await).await["uploaded"], true);
+        }
+        let b = repo::objects::find_by_bucket_key(&ctx, "team", "b.txt")
+            .await
+            .unwrap()
+            .expect("b.txt row");
+        let blob = format!("team/{}", repo::objects::blob_key(&b.id));
+        let rule = json!({ "op": "delete", "key": blob, "mode": "error" });
+        crate::test_support::inject_storage_fault(rule).await;
+
+        let mut admin = admin_msg("delete", "/admin/storage/buckets/team");
+        admin.set_meta("req.query.force", "true");
+        let out = handle_admin(&ctx, admin, InputStream::from_bytes(Vec::new())).await;
+        let resp = output_json(out).await;
+        assert_eq!(resp["details"]["failed"][0]["key"], "b.txt", "{resp}");
+        assert_eq!(resp["details"]["failed"].as_array().unwrap().len(), 1);
+        let left = repo::objects::list_all(&ctx).await.unwrap();
+        assert_eq!(left.len(), 1);
+        assert_eq!(left[0].str_field("key"), "b.txt");
+        assert!(repo::buckets::name_exists(&ctx, "team").await.unwrap());
+        assert!(audit_actions(&ctx).await.is_empty());
+    }
+
+    /// With user bucket management switched off, a regular user can
+    /// neither create nor delete buckets — even their own.
+    #[tokio::test]
+    async fn user_bucket_management_can_be_restricted_to_admins() {
+        let mut ctx = ctx_with_storage().await;
+        ctx.set_config(USER_BUCKETS_KEY, "false");
+        seed_bucket(&ctx, "mine", "alice").await;
+
+        let body = InputStream::from_bytes(br#"{"name":"another"}"#.to_vec());
+        let msg = auth_msg("create", "/b/storage/api/buckets", "alice");
+        let out = handle_create_bucket(&ctx, &msg, body).await;
+        assert!(crate::test_support::output_is_error(out, "PermissionDenied").await);
+
+        let out = handle_delete_bucket(&ctx, &delete_bucket_msg("mine", "alice", false)).await;
+        assert!(crate::test_support::output_is_error(out, "PermissionDenied").await);
+
+        let body = InputStream::from_bytes(br#"{"name":"another"}"#.to_vec());
+        let out = handle_admin(&ctx, admin_msg("create", "/admin/storage/buckets"), body).await;
+        assert_eq!(output_json(out).await["created"], true);
+        assert_eq!(audit_actions(&ctx).await, ["storage.bucket.create"]);
+    }
+
     /// `handle_stats` counts buckets from [`repo::buckets::TABLE`] (the same source
     /// admin SSR overview uses), not by enumerating storage folders.
     #[tokio::test]
@@ -1135,6 +2354,518 @@ mod integration_tests {
             "underscore in query must be escaped as a literal, not treated as a wildcard (got: {keys:?})"
         );
     }
+
+    /// Regression: an object uploaded through the normal handler must be
+    /// found by a plain `?q=` search — no extra params — regardless of case,
+    /// with its matching parent folder listed as `type: "folder"`.
+    #[tokio::test]
+    async fn uploaded_object_is_found_by_case_insensitive_search() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        let msg = upload_msg("docs", "Reports/Q1-Summary.TXT", "text/plain");
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"q1".to_vec())).await;
+        assert!(output_json(out).await.get("key").is_some());
+
+        for q in ["summary", "REPORTS", "q1-summary.txt"] {
+            let mut msg = auth_msg("retrieve", "/b/storage/api/search", "alice");
+            msg.set_meta("req.query.q", q);
+            let body = output_json(handle_search(&ctx, &msg).await).await;
+            let files: Vec<&serde_json::Value> = body["records"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .filter(|r| r["data"]["type"] == "file")
+                .collect();
+            assert_eq!(files.len(), 1, "query {q}: {body}");
+            assert_eq!(files[0]["data"]["key"], "Reports/Q1-Summary.TXT");
+        }
+
+        let mut msg = auth_msg("retrieve", "/b/storage/api/search", "alice");
+        msg.set_meta("req.query.q", "report");
+        let body = output_json(handle_search(&ctx, &msg).await).await;
+        assert_eq!(body["records"][0]["data"]["type"], "folder", "{body}");
+        assert_eq!(body["records"][0]["data"]["key"], "Reports/");
+
+        // Search is scoped to the caller's own uploads.
+        let mut msg = auth_msg("retrieve", "/b/storage/api/search", "bob");
+        msg.set_meta("req.query.q", "summary");
+        let body = output_json(handle_search(&ctx, &msg).await).await;
+        assert_eq!(body["records"], json!([]), "{body}");
+    }
+
+    /// Bucket names are global: creating one that another user already owns
+    /// is a `bucket_already_exists` conflict, and the existing owner's row is
+    /// untouched.
+    #[tokio::test]
+    async fn create_bucket_rejects_taken_name_with_stable_code() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "shared-name", "alice").await;
+
+        let body = InputStream::from_bytes(br#"{"name":"shared-name"}"#.to_vec());
+        let msg = auth_msg("create", "/b/storage/api/buckets", "bob");
+        match handle_create_bucket(&ctx, &msg, body)
+            .await
+            .collect_buffered()
+            .await
+        {
+            Err(wafer_run::TerminalNotResponse::Error(e)) => {
+                assert_eq!(e.code, ErrorCode::AlreadyExists);
+                assert_eq!(e.detail_code(), Some("bucket_already_exists"));
+            }
+            other => panic!("expected an error stream, got {other:?}"),
+        }
+        assert_eq!(repo::buckets::count_all(&ctx).await.expect("count"), 1);
+    }
+
+    /// An invalid bucket name is a `validation_failed` 400 naming the field.
+    #[tokio::test]
+    async fn create_bucket_invalid_name_reports_field_details() {
+        let ctx = ctx_with_storage().await;
+        let body = InputStream::from_bytes(br#"{"name":"Not_Valid"}"#.to_vec());
+        let msg = auth_msg("create", "/b/storage/api/buckets", "bob");
+        let out = handle_create_bucket(&ctx, &msg, body).await;
+        let json = output_json(out).await;
+        assert_eq!(json["code"], "validation_failed");
+        assert!(json["details"]["name"].is_string(), "details: {json}");
+    }
+
+    /// Reserved names are refused with their own code, before the syntax
+    /// rule (so `int_storage` says why), including the app's own id.
+    #[tokio::test]
+    async fn create_bucket_refuses_reserved_names() {
+        let mut ctx = ctx_with_storage().await;
+        ctx.set_config(APP_NAME_KEY, "My App");
+        for name in [
+            "int_storage",
+            "public",
+            "system",
+            "solobase-preflight",
+            "my-app",
+        ] {
+            let body = InputStream::from_bytes(json!({ "name": name }).to_string().into_bytes());
+            let msg = auth_msg("create", "/b/storage/api/buckets", "bob");
+            let json = output_json(handle_create_bucket(&ctx, &msg, body).await).await;
+            assert_eq!(json["code"], "bucket_name_reserved", "{name}: {json}");
+        }
+        assert_eq!(repo::buckets::count_all(&ctx).await.expect("count"), 0);
+    }
+
+    /// A bucket created before the naming rule keeps working and is
+    /// flagged in the listing.
+    #[tokio::test]
+    async fn legacy_bucket_names_stay_usable_and_are_flagged() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "Legacy_Photos", "alice").await;
+        seed_bucket(&ctx, "photos", "alice").await;
+        blobs::seed(&ctx, "Legacy_Photos", "a.jpg", b"x", "image/jpeg", "alice").await;
+
+        let out =
+            handle_list_buckets(&ctx, &auth_msg("retrieve", "/storage/buckets", "alice")).await;
+        let listed = output_json(out).await;
+        assert!(listed["warnings"]["Legacy_Photos"].is_string(), "{listed}");
+        assert!(listed["warnings"].get("photos").is_none());
+
+        let mut msg = auth_msg(
+            "retrieve",
+            "/b/storage/api/buckets/Legacy_Photos/objects",
+            "alice",
+        );
+        msg.set_meta("req.param.name", "Legacy_Photos");
+        let objects = output_json(handle_list_objects(&ctx, &msg).await).await;
+        assert_eq!(objects["total_count"], 1, "{objects}");
+    }
+
+    #[test]
+    fn safe_names_allow_legacy_buckets_but_not_traversal() {
+        assert!(is_safe_bucket_name("Legacy_Photos"));
+        assert!(is_safe_bucket_name("ab"));
+        for name in [
+            "",
+            ".",
+            "..",
+            "a/b",
+            "a\\b",
+            "a\0b",
+            "a".repeat(256).as_str(),
+        ] {
+            assert!(!is_safe_bucket_name(name), "{name:?}");
+        }
+        assert_eq!(app_id("My App!"), "my-app");
+        assert_eq!(app_id("  --Acme__Files 2 "), "acme-files-2");
+        assert_eq!(app_id("???"), "");
+    }
+
+    /// A read grant on `docs/` lets the grantee download inside the folder
+    /// but not elsewhere in the bucket, and not upload.
+    #[tokio::test]
+    async fn folder_grantee_reads_inside_share_only() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "team", "alice").await;
+        store::put(&ctx, "team", "docs/a.txt", b"shared", "text/plain")
+            .await
+            .expect("put shared");
+        store::put(&ctx, "team", "private/b.txt", b"secret", "text/plain")
+            .await
+            .expect("put private");
+        repo::acls::upsert(
+            &ctx,
+            repo::acls::NewGrant {
+                bucket: "team",
+                path: "docs/",
+                grantee_user_id: "bob",
+                permission: "read",
+                granted_by: "alice",
+            },
+        )
+        .await
+        .expect("grant");
+
+        let get = |key: &str| {
+            let mut msg = auth_msg(
+                "retrieve",
+                &format!("/b/storage/api/buckets/team/objects/{key}"),
+                "bob",
+            );
+            msg.set_meta("req.param.name", "team");
+            msg.set_meta("req.param.key", key);
+            msg
+        };
+        let out = handle_get_object(&ctx, &get("docs/a.txt")).await;
+        assert_eq!(out.collect_buffered().await.expect("body").body, b"shared");
+
+        let out = handle_get_object(&ctx, &get("private/b.txt")).await;
+        assert!(crate::test_support::output_is_error(out, "PermissionDenied").await);
+
+        let mut msg = upload_msg("team", "docs/new.txt", "text/plain");
+        msg.set_meta("auth.user_id", "bob");
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"x".to_vec())).await;
+        assert!(crate::test_support::output_is_error(out, "PermissionDenied").await);
+    }
+
+    async fn preview(ctx: &TestContext, user: &str, key: &str) -> OutputStream {
+        let path = format!("/b/storage/api/buckets/docs/preview/{key}");
+        let msg = auth_msg("retrieve", &path, user);
+        preview::handle_preview(ctx, &msg, "docs", key).await
+    }
+
+    #[tokio::test]
+    async fn preview_serves_html_as_sandboxed_text() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        store::put(
+            &ctx,
+            "docs",
+            "page.html",
+            b"<script>x()</script>",
+            "text/html",
+        )
+        .await
+        .expect("put");
+
+        let buf = preview(&ctx, "alice", "page.html")
+            .await
+            .collect_buffered()
+            .await
+            .expect("response");
+        let meta = |key: &str| {
+            buf.meta
+                .iter()
+                .find(|m| m.key == key)
+                .map(|m| m.value.clone())
+                .unwrap_or_default()
+        };
+        assert_eq!(buf.body, b"<script>x()</script>");
+        assert_eq!(meta("resp.content_type"), "text/plain; charset=utf-8");
+        assert_eq!(meta("resp.header.X-Content-Type-Options"), "nosniff");
+        assert!(meta("resp.header.Content-Security-Policy").starts_with("sandbox"));
+        assert!(meta("resp.header.Content-Disposition").starts_with("inline;"));
+    }
+
+    #[tokio::test]
+    async fn preview_refuses_svg_inline_and_unknown_types() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        store::put(
+            &ctx,
+            "docs",
+            "logo.svg",
+            b"<svg onload='x()'/>",
+            "image/svg+xml",
+        )
+        .await
+        .expect("put svg");
+        store::put(&ctx, "docs", "a.zip", b"PK", "application/zip")
+            .await
+            .expect("put zip");
+
+        let out = preview(&ctx, "alice", "logo.svg").await;
+        let disposition = crate::test_support::output_header(out, "Content-Disposition").await;
+        assert!(disposition.unwrap_or_default().starts_with("attachment;"));
+
+        let out = preview(&ctx, "alice", "a.zip").await;
+        let body = output_json(out).await;
+        assert_eq!(body["code"], "preview_unsupported");
+        assert_eq!(
+            body["details"]["download_url"],
+            "/b/storage/api/buckets/docs/objects/a.zip"
+        );
+    }
+
+    #[tokio::test]
+    async fn preview_applies_the_download_access_check() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        store::put(&ctx, "docs", "a.txt", b"secret", "text/plain")
+            .await
+            .expect("put");
+        let out = preview(&ctx, "mallory", "a.txt").await;
+        assert!(crate::test_support::output_is_error(out, "PermissionDenied").await);
+    }
+
+    /// Seed a `complete` object row (plus its blob) uploaded `days_ago`;
+    /// returns the row id.
+    async fn seed_aged_object(ctx: &TestContext, bucket: &str, key: &str, days_ago: i64) -> String {
+        let row = blobs::seed(ctx, bucket, key, b"x", "text/plain", "alice").await;
+        let uploaded_at =
+            crate::util::format_rfc3339(chrono::Utc::now() - chrono::Duration::days(days_ago));
+        let data = crate::util::json_map(json!({
+            "status": "complete",
+            "uploaded_at": uploaded_at,
+        }));
+        wafer_core::clients::database::update(ctx, repo::objects::TABLE, &row.id, data)
+            .await
+            .expect("age row");
+        row.id
+    }
+
+    async fn lifecycle_admin(
+        ctx: &TestContext,
+        action: &str,
+        path: &str,
+        body: serde_json::Value,
+    ) -> serde_json::Value {
+        let msg = admin_msg(action, path);
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        output_json(handle_admin(ctx, msg, input).await).await
+    }
+
+    /// Rules saved through the admin API delete and archive old objects on
+    /// a forced run, leave young ones alone, and record a summary per rule;
+    /// the dry run reports matches without changing anything.
+    #[tokio::test]
+    async fn lifecycle_rules_expire_and_archive_old_objects() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "logs", "alice").await;
+        let old = seed_aged_object(&ctx, "logs", "tmp/old.txt", 10).await;
+        seed_aged_object(&ctx, "logs", "tmp/new.txt", 1).await;
+        seed_aged_object(&ctx, "logs", "reports/q1.txt", 40).await;
+
+        let saved = lifecycle_admin(
+            &ctx,
+            "update",
+            "/admin/storage/buckets/logs/lifecycle",
+            json!({"rules": [
+                {"id": "tmp", "prefix": "tmp/", "action": "delete", "after_days": 7},
+                {"id": "cold", "prefix": "reports/", "action": "archive", "after_days": 30},
+            ]}),
+        )
+        .await;
+        assert_eq!(saved["rules"].as_array().map(Vec::len), Some(2), "{saved}");
+
+        let invalid = lifecycle_admin(
+            &ctx,
+            "update",
+            "/admin/storage/buckets/logs/lifecycle",
+            json!({"rules": [{"id": "x", "action": "delete", "after_days": 0}]}),
+        )
+        .await;
+        assert_eq!(invalid["code"], "validation_failed");
+
+        let dry = lifecycle_admin(
+            &ctx,
+            "create",
+            "/admin/storage/buckets/logs/lifecycle/test",
+            json!({"id": "probe", "prefix": "tmp/", "action": "delete", "after_days": 7}),
+        )
+        .await;
+        let keys: Vec<&str> = dry["objects"]
+            .as_array()
+            .expect("objects")
+            .iter()
+            .filter_map(|o| o["key"].as_str())
+            .collect();
+        assert_eq!(keys, ["tmp/old.txt"]);
+        assert_eq!(repo::objects::list_all(&ctx).await.expect("rows").len(), 3);
+
+        let run = lifecycle_admin(&ctx, "create", "/admin/storage/lifecycle/run", json!({})).await;
+        let results = run["results"].as_array().expect("results");
+        assert_eq!(results.len(), 2, "{run}");
+        assert_eq!(results[0]["affected"], 1);
+        assert_eq!(results[1]["affected"], 1);
+
+        let rows = repo::objects::list_all(&ctx).await.expect("rows");
+        let status = |key: &str| {
+            rows.iter()
+                .find(|r| r.data.get("key").and_then(|v| v.as_str()) == Some(key))
+                .and_then(|r| r.data.get("status").and_then(|v| v.as_str()))
+                .map(str::to_string)
+        };
+        assert_eq!(status("tmp/old.txt"), None);
+        assert_eq!(status("tmp/new.txt").as_deref(), Some("complete"));
+        assert_eq!(status("reports/q1.txt").as_deref(), Some("archived"));
+        assert!(!blobs::exists(&ctx, "logs", &old).await.unwrap());
+
+        let runs =
+            lifecycle_admin(&ctx, "retrieve", "/admin/storage/lifecycle/runs", json!({})).await;
+        assert_eq!(runs["total_count"], 2, "{runs}");
+    }
+
+    /// Object listings keep the legacy `{objects, total_count}` body by
+    /// default and switch to the `{data, pagination}` envelope with an
+    /// opaque offset cursor once `limit` or `cursor` is sent.
+    #[tokio::test]
+    async fn list_objects_supports_cursor_envelope_and_fields() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "docs", "alice").await;
+        for key in ["a.txt", "b.txt", "c.txt"] {
+            blobs::seed(&ctx, "docs", key, b"x", "text/plain", "alice").await;
+        }
+        let list = |params: &[(&str, &str)]| {
+            let mut msg = auth_msg("retrieve", "/b/storage/api/buckets/docs/objects", "alice");
+            msg.set_meta("req.param.name", "docs");
+            for (k, v) in params {
+                msg.set_meta(format!("req.query.{k}"), *v);
+            }
+            msg
+        };
+
+        let legacy = output_json(handle_list_objects(&ctx, &list(&[])).await).await;
+        assert_eq!(legacy["total_count"], 3, "{legacy}");
+        assert_eq!(legacy["objects"].as_array().unwrap().len(), 3);
+
+        let first = output_json(
+            handle_list_objects(&ctx, &list(&[("limit", "2"), ("fields", "size")])).await,
+        )
+        .await;
+        let data = first["data"].as_array().unwrap();
+        assert_eq!(data.len(), 2);
+        assert_eq!(data[0], json!({"key": "a.txt", "size": 1}));
+        assert_eq!(first["pagination"]["total"], 3);
+        let cursor = first["pagination"]["next_cursor"].as_str().unwrap();
+
+        let second = output_json(
+            handle_list_objects(&ctx, &list(&[("limit", "2"), ("cursor", cursor)])).await,
+        )
+        .await;
+        assert_eq!(second["data"][0]["key"], "c.txt");
+        assert!(second["pagination"]["next_cursor"].is_null());
+
+        let out = handle_list_objects(&ctx, &list(&[("cursor", "garbage")])).await;
+        assert_eq!(output_json(out).await["code"], "validation_failed");
+        let out = handle_list_objects(&ctx, &list(&[("sort", "key:desc")])).await;
+        assert_eq!(output_json(out).await["code"], "validation_failed");
+    }
+
+    /// Uploads into a bucket with a matching delete rule report when the
+    /// object will expire.
+    #[tokio::test]
+    async fn upload_response_includes_lifecycle_expiry() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "logs", "alice").await;
+        repo::buckets::set_lifecycle_rules(
+            &ctx,
+            "logs",
+            r#"[{"id":"tmp","prefix":"tmp/","action":"delete","after_days":7}]"#,
+        )
+        .await
+        .expect("set rules");
+
+        let msg = upload_msg("logs", "tmp/a.txt", "text/plain");
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"a".to_vec())).await;
+        assert!(output_json(out).await["expires_at"].is_string());
+
+        let msg = upload_msg("logs", "keep/a.txt", "text/plain");
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"a".to_vec())).await;
+        assert!(output_json(out).await.get("expires_at").is_none());
+    }
+
+    /// Object metadata is a JSON object: PATCH merges (null deletes), PUT
+    /// replaces the client keys, `system` stays server-owned, and listings
+    /// and search return the parsed object.
+    #[tokio::test]
+    async fn object_metadata_round_trip() {
+        let ctx = ctx_with_storage().await;
+        seed_bucket(&ctx, "photos", "alice").await;
+        let msg = upload_msg("photos", "trip/beach.png", "image/png");
+        let out = handle_upload_object(&ctx, &msg, InputStream::from_bytes(b"png".to_vec())).await;
+        assert_eq!(output_json(out).await["uploaded"], true);
+
+        let path = "/b/storage/api/buckets/photos/metadata/trip/beach.png";
+        let send = |method: &str, body: serde_json::Value| {
+            let action = if method == "GET" {
+                "retrieve"
+            } else {
+                "update"
+            };
+            let mut msg = auth_msg(action, path, "alice");
+            msg.set_meta("http.method", method);
+            let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+            let ctx = &ctx;
+            async move { output_json(handle(ctx, msg, input).await).await }
+        };
+
+        let got = send("GET", json!(null)).await;
+        assert_eq!(
+            got["metadata"],
+            json!({"system": {"detected_content_type": "image/png"}}),
+            "{got}"
+        );
+
+        let patched = send(
+            "PATCH",
+            json!({"camera": "x100", "tags": {"place": "beach"}}),
+        )
+        .await;
+        assert_eq!(patched["metadata"]["camera"], "x100", "{patched}");
+        let patched = send("PATCH", json!({"camera": null, "tags": {"year": 2026}})).await;
+        assert_eq!(
+            patched["metadata"],
+            json!({
+                "system": {"detected_content_type": "image/png"},
+                "tags": {"place": "beach", "year": 2026},
+            })
+        );
+
+        let replaced = send("PUT", json!({"title": "Sunset"})).await;
+        assert_eq!(
+            replaced["metadata"],
+            json!({"system": {"detected_content_type": "image/png"}, "title": "Sunset"})
+        );
+
+        let rejected = send("PATCH", json!({"system": {"thumbnail_id": "t1"}})).await;
+        assert_eq!(rejected["code"], "validation_failed", "{rejected}");
+        let rejected = send("PUT", json!({"blob": "x".repeat(9000)})).await;
+        assert_eq!(rejected["code"], "validation_failed", "{rejected}");
+
+        let mut list = auth_msg("retrieve", "/b/storage/api/buckets/photos/objects", "alice");
+        list.set_meta("req.param.name", "photos");
+        let listed = output_json(handle_list_objects(&ctx, &list).await).await;
+        assert_eq!(
+            listed["objects"][0]["metadata"]["title"], "Sunset",
+            "{listed}"
+        );
+
+        let mut search = auth_msg("retrieve", "/b/storage/api/search", "alice");
+        search.set_meta("req.query.q", "beach");
+        let found = output_json(handle_search(&ctx, &search).await).await;
+        let file = found["records"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|r| r["data"]["type"] == "file")
+            .expect("file hit");
+        assert_eq!(file["data"]["metadata"]["title"], "Sunset", "{found}");
+    }
 }
 
 async fn handle_stats(ctx: &dyn Context, _msg: &Message) -> OutputStream {
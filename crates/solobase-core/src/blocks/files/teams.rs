@@ -0,0 +1,459 @@
+//! Teams: shared storage owned by a group of users.
+//!
+//! A team bucket (`buckets.team_id` set at creation) is owned by the team:
+//! every member has the rights a personal bucket's creator has, checked by
+//! [`is_team_bucket_member`] from `storage::bucket_owned_by`. Objects
+//! uploaded into it count toward the team's quota (`upload_check`), never
+//! a member's personal one.
+//!
+//! The owner manages membership, transfers ownership and deletes the team;
+//! any member may leave. Deleting a team that still has buckets needs
+//! `?cascade=true`, which deletes the buckets and everything in them.
+//! Membership changes are audit-logged as `storage.team.*`.
+//!
+//! With no teams, every bucket and object has an empty `team_id` and none
+//! of this changes personal storage.
+
+use wafer_core::clients::storage as store;
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::{repo, storage};
+use crate::{
+    blocks::{admin::audit_log, errors},
+    http::{err_conflict, err_forbidden, err_internal, err_not_found, ok_json},
+    services::Services,
+    util::RecordExt,
+};
+
+use repo::teams::{ROLE_MEMBER, ROLE_OWNER};
+
+/// Longest team name, in characters.
+const MAX_NAME_LEN: usize = 100;
+
+/// Whether `user_id` belongs to the team that owns `bucket`. Personal and
+/// unknown buckets are not team buckets. DB errors fail closed.
+pub(super) async fn is_team_bucket_member(ctx: &dyn Context, user_id: &str, bucket: &str) -> bool {
+    let team_id = match repo::buckets::team_of(ctx, bucket).await {
+        Ok(Some(team_id)) => team_id,
+        Ok(None) => return false,
+        Err(e) => {
+            tracing::warn!(error = %e, bucket = %bucket, "team-bucket lookup failed");
+            return false;
+        }
+    };
+    match repo::teams::role_of(ctx, &team_id, user_id).await {
+        Ok(role) => role.is_some(),
+        Err(e) => {
+            tracing::warn!(error = %e, team_id = %team_id, "team membership check failed");
+            false
+        }
+    }
+}
+
+/// The caller's role in team `{id}`, or the response to send instead: 404
+/// for an unknown team or a non-member (so team ids can't be probed).
+async fn caller_role(
+    ctx: &dyn Context,
+    msg: &Message,
+    team_id: &str,
+) -> Result<String, OutputStream> {
+    match repo::teams::role_of(ctx, team_id, msg.user_id()).await {
+        Ok(Some(role)) => Ok(role),
+        Ok(None) => Err(err_not_found("Team not found")),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// [`caller_role`], requiring the owner.
+async fn require_owner(
+    ctx: &dyn Context,
+    msg: &Message,
+    team_id: &str,
+) -> Result<(), OutputStream> {
+    match caller_role(ctx, msg, team_id).await?.as_str() {
+        ROLE_OWNER => Ok(()),
+        _ => Err(err_forbidden("Only the team owner can do this")),
+    }
+}
+
+async fn audit(ctx: &dyn Context, msg: &Message, action: &str, resource: String) {
+    audit_log(ctx, msg.user_id(), action, &resource, msg.remote_addr()).await;
+}
+
+/// `GET /b/storage/api/teams` — the caller's teams, each with their `role`.
+pub(super) async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    match repo::teams::list_for_user(ctx, msg.user_id()).await {
+        Ok(teams) => ok_json(&serde_json::json!({ "teams": teams })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `POST /b/storage/api/teams` — body `{name}`; the caller becomes owner.
+pub(super) async fn handle_create(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        name: String,
+    }
+    let body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    let name = body.name.trim();
+    if name.is_empty() || name.chars().count() > MAX_NAME_LEN {
+        return errors::validation_error("Invalid team", &[("name", "must be 1-100 characters")]);
+    }
+    match repo::teams::insert(ctx, name, msg.user_id()).await {
+        Ok(team) => {
+            audit(ctx, msg, "storage.team.create", format!("team:{}", team.id)).await;
+            ok_json(&team)
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `GET /b/storage/api/teams/{id}` — the team, its members and the
+/// caller's role. Members only.
+pub(super) async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let team_id = msg.var("id");
+    let role = match caller_role(ctx, msg, team_id).await {
+        Ok(role) => role,
+        Err(r) => return r,
+    };
+    let team = match repo::teams::find(ctx, team_id).await {
+        Ok(Some(team)) => team,
+        Ok(None) => return err_not_found("Team not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    match repo::teams::list_members(ctx, team_id).await {
+        Ok(members) => ok_json(&serde_json::json!({
+            "team": team,
+            "role": role,
+            "members": members,
+        })),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `GET /b/storage/api/teams/{id}/buckets` — the team's buckets ("Team
+/// files"), browsed with the regular bucket and object endpoints.
+pub(super) async fn handle_buckets(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let team_id = msg.var("id");
+    if let Err(r) = caller_role(ctx, msg, team_id).await {
+        return r;
+    }
+    match repo::buckets::list_for_team(ctx, team_id).await {
+        Ok(rows) => {
+            let names: Vec<&str> = rows.iter().map(|r| r.str_field("name")).collect();
+            ok_json(&serde_json::json!({ "team_id": team_id, "buckets": names }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `POST /b/storage/api/teams/{id}/members` — owner only. Body
+/// `{user_id | email}`; an email is resolved through auth's users
+/// directory. Adds the account as a `member`.
+pub(super) async fn handle_add_member(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        #[serde(default)]
+        user_id: String,
+        #[serde(default)]
+        email: String,
+    }
+    let team_id = msg.var("id");
+    if let Err(r) = require_owner(ctx, msg, team_id).await {
+        return r;
+    }
+    let mut body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    if body.user_id.is_empty() && !body.email.is_empty() {
+        match Services::new(ctx, "suppers-ai/files")
+            .users()
+            .find_by_email(body.email.trim())
+            .await
+        {
+            Ok(Some(entry)) => body.user_id = entry.id,
+            Ok(None) => {
+                return errors::validation_error(
+                    "Invalid member",
+                    &[("email", "no account with this email")],
+                )
+            }
+            Err(e) => return err_internal("Database error", e),
+        }
+    }
+    if body.user_id.is_empty() {
+        return errors::validation_error("Invalid member", &[("user_id", "required")]);
+    }
+    match repo::teams::role_of(ctx, team_id, &body.user_id).await {
+        Ok(None) => {}
+        Ok(Some(_)) => return err_conflict("Already a member of this team"),
+        Err(e) => return err_internal("Database error", e),
+    }
+    match repo::teams::add_member(ctx, team_id, &body.user_id, ROLE_MEMBER, msg.user_id()).await {
+        Ok(member) => {
+            audit(
+                ctx,
+                msg,
+                "storage.team.member.add",
+                format!("team:{team_id}:user:{}", body.user_id),
+            )
+            .await;
+            ok_json(&member)
+        }
+        Err(e) => errors::db_error_response("Member", e),
+    }
+}
+
+/// `DELETE /b/storage/api/teams/{id}/members/{user_id}` — the owner removes
+/// a member, or a member removes themself (leaves). The owner can't leave
+/// without transferring ownership first.
+pub(super) async fn handle_remove_member(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let team_id = msg.var("id");
+    let user_id = msg.var("user_id");
+    let role = match caller_role(ctx, msg, team_id).await {
+        Ok(role) => role,
+        Err(r) => return r,
+    };
+    let leaving = user_id == msg.user_id();
+    if leaving && role == ROLE_OWNER {
+        return err_conflict("Transfer ownership before leaving the team");
+    }
+    if !leaving && role != ROLE_OWNER {
+        return err_forbidden("Only the team owner can do this");
+    }
+    match repo::teams::remove_member(ctx, team_id, user_id).await {
+        Ok(true) => {
+            audit(
+                ctx,
+                msg,
+                "storage.team.member.remove",
+                format!("team:{team_id}:user:{user_id}"),
+            )
+            .await;
+            ok_json(&serde_json::json!({ "removed": true }))
+        }
+        Ok(false) => err_not_found("Member not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `POST /b/storage/api/teams/{id}/transfer` — owner only. Body
+/// `{user_id}` names an existing member, who becomes the owner; the
+/// previous owner stays on as a member.
+pub(super) async fn handle_transfer(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        user_id: String,
+    }
+    let team_id = msg.var("id");
+    if let Err(r) = require_owner(ctx, msg, team_id).await {
+        return r;
+    }
+    let body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    if body.user_id == msg.user_id() {
+        return errors::validation_error("Invalid transfer", &[("user_id", "already the owner")]);
+    }
+    match repo::teams::set_role(ctx, team_id, &body.user_id, ROLE_OWNER).await {
+        Ok(0) => {
+            return errors::validation_error(
+                "Invalid transfer",
+                &[("user_id", "not a member of this team")],
+            )
+        }
+        Ok(_) => {}
+        Err(e) => return err_internal("Database error", e),
+    }
+    if let Err(e) = repo::teams::set_role(ctx, team_id, msg.user_id(), ROLE_MEMBER).await {
+        return err_internal("Database error", e);
+    }
+    audit(
+        ctx,
+        msg,
+        "storage.team.transfer",
+        format!("team:{team_id}:user:{}", body.user_id),
+    )
+    .await;
+    ok_json(&serde_json::json!({ "owner": body.user_id }))
+}
+
+/// `DELETE /b/storage/api/teams/{id}[?cascade=true]` — owner only. A team
+/// with buckets is refused unless `cascade` is set, which first deletes
+/// each bucket with all of its objects — and refused outright while any of
+/// them holds a lock (see `locks`).
+pub(super) async fn handle_delete(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let team_id = msg.var("id");
+    if let Err(r) = require_owner(ctx, msg, team_id).await {
+        return r;
+    }
+    let buckets = match repo::buckets::list_for_team(ctx, team_id).await {
+        Ok(rows) => rows,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let cascade = msg.query("cascade") == "true";
+    if !buckets.is_empty() && !cascade {
+        return err_conflict("Team still has buckets; delete them or pass ?cascade=true");
+    }
+    // A lock in any of them holds the whole cascade before anything goes.
+    for bucket in &buckets {
+        if let Err(r) = super::locks::guard(ctx, bucket.str_field("name"), &[""]).await {
+            return r;
+        }
+    }
+    for bucket in &buckets {
+        let name = bucket.str_field("name");
+        if let Err(e) = storage::delete_all_objects_or_fail(ctx, name).await {
+            return err_internal("Failed to delete team bucket objects", e);
+        }
+        if let Err(e) = store::delete_folder(ctx, name).await {
+            return err_internal("Failed to delete team bucket", e);
+        }
+        storage::delete_bucket_rows(ctx, name).await;
+        audit(ctx, msg, "storage.bucket.delete", format!("bucket:{name}")).await;
+    }
+    if let Err(e) = repo::teams::delete(ctx, team_id).await {
+        return err_internal("Database error", e);
+    }
+    audit(ctx, msg, "storage.team.delete", format!("team:{team_id}")).await;
+    ok_json(&serde_json::json!({ "deleted": true, "buckets_deleted": buckets.len() }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{auth_msg, output_is_error, output_json, output_status, TestContext};
+
+    fn team_msg(action: &str, user: &str, team_id: &str, sub: &str) -> Message {
+        let mut msg = auth_msg(
+            action,
+            &format!("/b/storage/api/teams/{team_id}{sub}"),
+            user,
+        );
+        msg.set_meta("req.param.id", team_id);
+        msg
+    }
+
+    fn body(v: serde_json::Value) -> InputStream {
+        InputStream::from_bytes(serde_json::to_vec(&v).unwrap())
+    }
+
+    async fn create_team(ctx: &TestContext, owner: &str) -> String {
+        let msg = auth_msg("create", "/b/storage/api/teams", owner);
+        let out = handle_create(ctx, &msg, body(serde_json::json!({"name": "Design"}))).await;
+        output_json(out).await["id"].as_str().unwrap().to_string()
+    }
+
+    async fn add(ctx: &TestContext, team_id: &str, actor: &str, user: &str) -> OutputStream {
+        let msg = team_msg("create", actor, team_id, "/members");
+        handle_add_member(ctx, &msg, body(serde_json::json!({"user_id": user}))).await
+    }
+
+    async fn seed_team_bucket(ctx: &TestContext, name: &str, creator: &str, team_id: &str) {
+        repo::buckets::insert(ctx, name, false, creator, team_id)
+            .await
+            .expect("seed bucket");
+    }
+
+    #[tokio::test]
+    async fn members_own_team_buckets_until_they_leave() {
+        let ctx = TestContext::with_files().await;
+        let team = create_team(&ctx, "alice").await;
+        output_json(add(&ctx, &team, "alice", "bob").await).await;
+        seed_team_bucket(&ctx, "design-assets", "bob", &team).await;
+
+        assert!(storage::bucket_owned_by(&ctx, "alice", "design-assets").await);
+        assert!(storage::bucket_owned_by(&ctx, "bob", "design-assets").await);
+        assert!(!storage::bucket_owned_by(&ctx, "carol", "design-assets").await);
+
+        // Team buckets stay out of the creator's personal listing.
+        let personal = repo::buckets::list_visible(&ctx, Some("bob"))
+            .await
+            .unwrap();
+        assert!(personal.is_empty());
+        let msg = team_msg("retrieve", "alice", &team, "/buckets");
+        let listed = output_json(handle_buckets(&ctx, &msg).await).await;
+        assert_eq!(listed["buckets"], serde_json::json!(["design-assets"]));
+
+        let mut leave = team_msg("delete", "bob", &team, "/members/bob");
+        leave.set_meta("req.param.user_id", "bob");
+        output_json(handle_remove_member(&ctx, &leave).await).await;
+        assert!(!storage::bucket_owned_by(&ctx, "bob", "design-assets").await);
+    }
+
+    #[tokio::test]
+    async fn only_the_owner_manages_membership() {
+        let ctx = TestContext::with_files().await;
+        let team = create_team(&ctx, "alice").await;
+        output_json(add(&ctx, &team, "alice", "bob").await).await;
+
+        assert!(output_is_error(add(&ctx, &team, "bob", "carol").await, "PermissionDenied").await);
+        assert!(output_is_error(add(&ctx, &team, "mallory", "carol").await, "NotFound").await);
+        assert!(output_is_error(add(&ctx, &team, "alice", "bob").await, "AlreadyExists").await);
+
+        let mut kick = team_msg("delete", "bob", &team, "/members/alice");
+        kick.set_meta("req.param.user_id", "alice");
+        assert!(output_is_error(handle_remove_member(&ctx, &kick).await, "PermissionDenied").await);
+
+        let mut leave = team_msg("delete", "alice", &team, "/members/alice");
+        leave.set_meta("req.param.user_id", "alice");
+        assert!(output_is_error(handle_remove_member(&ctx, &leave).await, "AlreadyExists").await);
+    }
+
+    #[tokio::test]
+    async fn transfer_swaps_owner_and_member() {
+        let ctx = TestContext::with_files().await;
+        let team = create_team(&ctx, "alice").await;
+        output_json(add(&ctx, &team, "alice", "bob").await).await;
+
+        let msg = team_msg("create", "alice", &team, "/transfer");
+        let out = handle_transfer(&ctx, &msg, body(serde_json::json!({"user_id": "carol"}))).await;
+        assert_eq!(
+            output_json(out).await["details"]["user_id"],
+            "not a member of this team"
+        );
+        let out = handle_transfer(&ctx, &msg, body(serde_json::json!({"user_id": "bob"}))).await;
+        assert_eq!(output_json(out).await["owner"], "bob");
+
+        let bob = repo::teams::role_of(&ctx, &team, "bob").await.unwrap();
+        let alice = repo::teams::role_of(&ctx, &team, "alice").await.unwrap();
+        assert_eq!(bob.as_deref(), Some(ROLE_OWNER));
+        assert_eq!(alice.as_deref(), Some(ROLE_MEMBER));
+    }
+
+    #[tokio::test]
+    async fn delete_requires_empty_team_or_cascade() {
+        let ctx = TestContext::with_files().await;
+        let team = create_team(&ctx, "alice").await;
+        seed_team_bucket(&ctx, "design-assets", "alice", &team).await;
+
+        let msg = team_msg("delete", "alice", &team, "");
+        assert_eq!(output_status(handle_delete(&ctx, &msg).await).await, 409);
+
+        let mut msg = team_msg("delete", "alice", &team, "");
+        msg.set_meta("req.query.cascade", "true");
+        let out = output_json(handle_delete(&ctx, &msg).await).await;
+        assert_eq!(out["buckets_deleted"], 1);
+        assert!(repo::teams::find(&ctx, &team).await.unwrap().is_none());
+        assert!(!repo::buckets::name_exists(&ctx, "design-assets")
+            .await
+            .unwrap());
+    }
+}
@@ -0,0 +1,314 @@
+//! Concurrent transfer limits: how many uploads and downloads one caller
+//! may have running at once (see [`crate::transfers`]).
+//!
+//! Every route that moves an object body counts: the storage API's
+//! uploads, archive uploads, downloads and previews, the S3 gateway, share
+//! links and upload-widget posts. A signed-in caller is keyed by user id,
+//! so switching from the API to a share link or S3 doesn't buy more
+//! slots; anonymous share-link and widget traffic is keyed by IP. Bucket
+//! websites aren't counted: a page load fetches its assets in parallel.
+//!
+//! The caps are the [`MAX_DOWNLOADS_KEY`] / [`MAX_UPLOADS_KEY`] settings,
+//! and an admin can set a user's or role's own with an IAM quota on
+//! `storage.concurrent_downloads` / `storage.concurrent_uploads` (its
+//! `limit` is the cap; the window is unused). One over the cap answers 429
+//! `too_many_transfers` with `Retry-After`.
+//!
+//! Most bodies are buffered, so a transfer ends when its handler returns;
+//! the block holds the slot across the handler call. Share links stream
+//! theirs, and keep the slot until the body has gone out
+//! ([`hold_until_sent`]).
+
+use futures::StreamExt;
+use wafer_core::clients::config;
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::{storage, widget};
+use crate::{
+    blocks::{
+        api_quota,
+        errors::{self, ErrorCode},
+        rate_limit::ip_identity,
+    },
+    endpoint_match,
+    http::ResponseBuilder,
+    transfers::{self, Direction, Slot},
+};
+
+const BLOCK: &str = "suppers-ai/files";
+
+/// Downloads one caller may have running at once; 0 for no cap.
+pub(super) const MAX_DOWNLOADS_KEY: &str = "SUPPERS_AI__FILES__MAX_CONCURRENT_DOWNLOADS";
+pub(super) const DEFAULT_MAX_DOWNLOADS: &str = "5";
+/// Uploads one caller may have running at once; 0 for no cap.
+pub(super) const MAX_UPLOADS_KEY: &str = "SUPPERS_AI__FILES__MAX_CONCURRENT_UPLOADS";
+pub(super) const DEFAULT_MAX_UPLOADS: &str = "3";
+
+/// IAM quota resources whose definitions override the caps per user or
+/// role (see [`api_quota`]).
+pub(crate) const CONCURRENCY_RESOURCES: &[&str] =
+    &["storage.concurrent_downloads", "storage.concurrent_uploads"];
+
+/// Seconds a refused caller is told to wait; transfers have no window to
+/// count down, so this is a polite guess.
+const RETRY_AFTER_SECS: u64 = 5;
+
+fn resource(direction: Direction) -> &'static str {
+    match direction {
+        Direction::Download => CONCURRENCY_RESOURCES[0],
+        Direction::Upload => CONCURRENCY_RESOURCES[1],
+    }
+}
+
+/// Whether the request moves an object body, and which way.
+fn direction(msg: &Message) -> Option<Direction> {
+    let (action, path) = (msg.action(), msg.path());
+    if path.starts_with("/b/storage/direct/") {
+        return Some(Direction::Download);
+    }
+    if action == "create" && path == widget::UPLOAD_PATH {
+        return Some(Direction::Upload);
+    }
+    let tag = storage::QUOTA_ROUTES.iter().find_map(|route| {
+        (endpoint_match::action_for_method(route.method) == action
+            && endpoint_match::match_template(route.template, path).is_some())
+        .then_some(route.handler)
+    })?;
+    match tag {
+        "storage.upload" => Some(Direction::Upload),
+        "storage.download" => Some(Direction::Download),
+        _ => None,
+    }
+}
+
+/// The caller's key: their user id, else `ip:{addr}`.
+fn principal(msg: &Message) -> String {
+    match msg.user_id() {
+        "" => format!("ip:{}", ip_identity(msg)),
+        user_id => user_id.to_string(),
+    }
+}
+
+/// The caller's cap: their IAM definition when one applies, else the
+/// configured default.
+async fn limit(ctx: &dyn Context, msg: &Message, direction: Direction) -> u32 {
+    if let Some(limit) = api_quota::concurrency_limit(ctx, msg, resource(direction)).await {
+        return u32::try_from(limit).unwrap_or(u32::MAX);
+    }
+    let (key, default) = match direction {
+        Direction::Download => (MAX_DOWNLOADS_KEY, DEFAULT_MAX_DOWNLOADS),
+        Direction::Upload => (MAX_UPLOADS_KEY, DEFAULT_MAX_UPLOADS),
+    };
+    let raw = config::get_default(ctx, key, default).await;
+    raw.trim()
+        .parse()
+        .unwrap_or_else(|_| default.parse().unwrap_or(0))
+}
+
+/// Take a transfer slot for the request. `Ok(None)` when it moves no
+/// object body; `Err` is the 429 to answer when the caller's slots are
+/// all in use. Hold the slot until the handler returns.
+pub(super) async fn acquire(
+    ctx: &dyn Context,
+    msg: &Message,
+) -> Result<Option<Slot>, OutputStream> {
+    let Some(direction) = direction(msg) else {
+        return Ok(None);
+    };
+    let principal = principal(msg);
+    let limit = limit(ctx, msg, direction).await;
+    match transfers::try_acquire(BLOCK, &principal, direction, limit) {
+        Some(slot) => Ok(Some(slot)),
+        None => Err(busy(direction, limit)),
+    }
+}
+
+/// Keep `slot` until `out` has been sent or the client went away, for a
+/// response that streams its body after the handler returns.
+pub(super) fn hold_until_sent(out: OutputStream, slot: Option<Slot>) -> OutputStream {
+    let Some(slot) = slot else {
+        return out;
+    };
+    OutputStream::from_producer(move |sink, _cancel| async move {
+        let _slot = slot;
+        let (mut out, mut sink) = (out, sink);
+        while let Some(ev) = out.next().await {
+            match crate::pipeline::forward_event(sink, ev).await {
+                Some(s) => sink = s,
+                None => return,
+            }
+        }
+    })
+}
+
+/// 429 `too_many_transfers`, with the cap in `details`.
+fn busy(direction: Direction, limit: u32) -> OutputStream {
+    let code = ErrorCode::TooManyTransfers;
+    let message = format!("Too many {}s at once (limit {limit})", direction.as_str());
+    ResponseBuilder::new()
+        .status(code.status_code())
+        .set_header("Retry-After", &RETRY_AFTER_SECS.to_string())
+        .json(&errors::error_body(
+            code,
+            &message,
+            Some(serde_json::json!({
+                "direction": direction.as_str(),
+                "limit": limit,
+            })),
+        ))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        blocks::api_quota::{QuotaDefinition, QuotaScope},
+        test_support::{auth_msg, output_header, output_json, output_status, TestContext},
+    };
+
+    fn request(action: &str, path: &str, user_id: &str, roles: &str) -> Message {
+        let mut msg = auth_msg(action, path, user_id);
+        msg.set_meta("auth.user_roles", roles);
+        msg.set_meta("req.client.ip", "198.51.100.7");
+        msg
+    }
+
+    #[test]
+    fn body_moving_routes_are_classified() {
+        let cases = [
+            (
+                "retrieve",
+                "/b/storage/api/buckets/docs/objects/a/b.pdf",
+                Some(Direction::Download),
+            ),
+            (
+                "retrieve",
+                "/b/storage/api/buckets/docs/preview/a.txt",
+                Some(Direction::Download),
+            ),
+            (
+                "retrieve",
+                "/b/storage/direct/tok.en",
+                Some(Direction::Download),
+            ),
+            ("retrieve", "/s3/docs/a.txt", Some(Direction::Download)),
+            (
+                "create",
+                "/b/storage/api/buckets/docs/objects",
+                Some(Direction::Upload),
+            ),
+            (
+                "create",
+                "/b/storage/api/buckets/docs/upload-archive",
+                Some(Direction::Upload),
+            ),
+            (
+                "create",
+                "/b/storage/api/widgets/upload",
+                Some(Direction::Upload),
+            ),
+            ("update", "/s3/docs/a.txt", Some(Direction::Upload)),
+            ("retrieve", "/b/storage/api/buckets/docs/objects", None),
+            ("retrieve", "/b/storage/api/widgets/upload.js", None),
+        ];
+        for (action, path, want) in cases {
+            assert_eq!(
+                direction(&request(action, path, "u1", "")),
+                want,
+                "{action} {path}"
+            );
+        }
+        assert_eq!(principal(&request("retrieve", "/", "u1", "")), "u1");
+        assert_eq!(
+            principal(&request("retrieve", "/", "", "")),
+            "ip:198.51.100.7"
+        );
+    }
+
+    async fn admitted(ctx: &TestContext, msg: &Message) -> Option<Slot> {
+        match acquire(ctx, msg).await {
+            Ok(slot) => slot,
+            Err(_) => panic!("refused {}", msg.path()),
+        }
+    }
+
+    async fn refused(ctx: &TestContext, msg: &Message) -> OutputStream {
+        match acquire(ctx, msg).await {
+            Ok(_) => panic!("admitted {}", msg.path()),
+            Err(out) => out,
+        }
+    }
+
+    #[tokio::test]
+    async fn callers_over_the_cap_get_a_structured_429() {
+        let mut ctx = TestContext::with_admin().await;
+        ctx.set_config(MAX_UPLOADS_KEY, "1");
+        ctx.set_config(MAX_DOWNLOADS_KEY, "1");
+        let upload = request(
+            "create",
+            "/b/storage/api/buckets/docs/objects",
+            "cap-u1",
+            "user",
+        );
+
+        let held = admitted(&ctx, &upload).await.expect("a slot");
+        let out = refused(&ctx, &upload).await;
+        assert_eq!(
+            output_header(out, "Retry-After").await.as_deref(),
+            Some("5")
+        );
+        let body = output_json(refused(&ctx, &upload).await).await;
+        assert_eq!(body["code"], "too_many_transfers");
+        assert_eq!(body["details"]["limit"], 1);
+        drop(held);
+        assert!(admitted(&ctx, &upload).await.is_some());
+
+        // A share link draws on the same download slots as the API.
+        let download = request(
+            "retrieve",
+            "/b/storage/api/buckets/docs/objects/a",
+            "cap-u1",
+            "",
+        );
+        let _held = admitted(&ctx, &download).await.expect("a slot");
+        let share = request("retrieve", "/b/storage/direct/tok.en", "cap-u1", "user");
+        refused(&ctx, &share).await;
+
+        let listing = request("retrieve", "/b/storage/api/buckets", "cap-u1", "user");
+        assert!(admitted(&ctx, &listing).await.is_none());
+    }
+
+    #[tokio::test]
+    async fn iam_quotas_set_a_roles_own_cap() {
+        let mut ctx = TestContext::with_admin().await;
+        ctx.set_config(MAX_DOWNLOADS_KEY, "1");
+        api_quota::replace_definitions(
+            &ctx,
+            &[QuotaDefinition {
+                resource: "storage.concurrent_downloads".into(),
+                scope: QuotaScope::Role,
+                subject: "bulk".into(),
+                limit: 3,
+                window_secs: 0,
+            }],
+        )
+        .await
+        .unwrap();
+
+        let path = "/b/storage/api/buckets/docs/objects/a.bin";
+        let bulk = request("retrieve", path, "cap-bulk", "user,bulk");
+        let mut held = Vec::new();
+        for _ in 0..3 {
+            held.push(admitted(&ctx, &bulk).await.expect("within the role's cap"));
+        }
+        assert_eq!(output_status(refused(&ctx, &bulk).await).await, 429);
+
+        let plain = request("retrieve", path, "cap-plain", "user");
+        let _one = admitted(&ctx, &plain).await;
+        refused(&ctx, &plain).await;
+
+        let report = transfers::snapshot(BLOCK);
+        let bulk = report.iter().find(|p| p.principal == "cap-bulk").unwrap();
+        assert_eq!((bulk.downloads, bulk.peak_downloads), (3, 3));
+    }
+}
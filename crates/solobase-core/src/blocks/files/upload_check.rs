@@ -0,0 +1,248 @@
+//! Upload admission: whether a file of a given size may be stored at a key,
+//! evaluated once for both the upload itself and the pre-upload check.
+//!
+//! `GET /b/storage/api/buckets/{name}/upload-check?key=&size=` answers
+//! `{allowed, reasons, remaining_bytes, max_upload_size}` without a body,
+//! so a client can refuse a file before sending it. `reasons` lists every
+//! refusal the upload would give, in the order it checks them — a lock on
+//! the key, the per-file size cap, the storage quota, the file-count cap —
+//! as `{code, message, details?}`; the upload answers with the first one.
+//! The quota is the bucket team's for a team bucket, else the caller's.
+//!
+//! The check is advice, not a reservation: usage can change between it and
+//! the upload, which evaluates again.
+
+use wafer_run::{context::Context, Message, OutputStream};
+
+use super::{
+    acl::{self, Access},
+    locks::LockSet,
+    models::QuotaConfig,
+    quota::{self, Refusal},
+    repo, storage,
+};
+use crate::{
+    blocks::errors::{self, ErrorCode},
+    http::{err_bad_request, err_forbidden, err_internal, ok_json},
+};
+
+/// The outcome of evaluating one upload.
+#[derive(Debug)]
+pub(super) struct UploadCheck {
+    /// Owning team of the bucket, empty for a personal bucket.
+    pub(super) team_id: String,
+    pub(super) quota: QuotaConfig,
+    pub(super) used_bytes: i64,
+    pub(super) refusals: Vec<Refusal>,
+}
+
+impl UploadCheck {
+    /// Evaluate storing `size` bytes at `key` in `bucket` for `user_id`.
+    /// A failed lookup is an error response, not a refusal.
+    pub(super) async fn run(
+        ctx: &dyn Context,
+        user_id: &str,
+        bucket: &str,
+        key: &str,
+        size: i64,
+    ) -> Result<Self, OutputStream> {
+        // Uploads into a team bucket count toward the team's quota, not the
+        // uploader's.
+        let team_id = match repo::buckets::team_of(ctx, bucket).await {
+            Ok(team_id) => team_id.unwrap_or_default(),
+            Err(e) => return Err(err_internal("Database error", e)),
+        };
+        let locks = match LockSet::load(ctx, bucket).await {
+            Ok(locks) => locks,
+            Err(e) => return Err(err_internal("Database error", e)),
+        };
+
+        let mut refusals = Vec::new();
+        // Neither an overwrite of a locked object nor a new one in a locked
+        // folder.
+        if let Some(lock) = locks.blocking(key) {
+            refusals.push(Refusal {
+                code: ErrorCode::ObjectLocked,
+                message: lock.message(),
+                details: Some(lock.details()),
+            });
+        }
+        let (quota, used_bytes, file_count) = quota::space_usage(ctx, user_id, &team_id).await;
+        refusals.extend(quota::refusals(&quota, size, used_bytes, file_count));
+
+        Ok(Self {
+            team_id,
+            quota,
+            used_bytes,
+            refusals,
+        })
+    }
+
+    /// Storage left under the quota, never negative.
+    pub(super) fn remaining_bytes(&self) -> i64 {
+        self.quota
+            .max_storage_bytes
+            .saturating_sub(self.used_bytes)
+            .max(0)
+    }
+
+    /// The bucket's team id when the upload may go ahead, else the first
+    /// refusal as the upload's response.
+    pub(super) fn into_result(self) -> Result<String, OutputStream> {
+        match self.refusals.first() {
+            Some(refusal) => Err(refusal.response()),
+            None => Ok(self.team_id),
+        }
+    }
+
+    /// The upload-check response body.
+    pub(super) fn to_json(&self) -> serde_json::Value {
+        serde_json::json!({
+            "allowed": self.refusals.is_empty(),
+            "reasons": self.refusals.iter().map(Refusal::to_json).collect::<Vec<_>>(),
+            "remaining_bytes": self.remaining_bytes(),
+            "max_upload_size": self.quota.max_file_size_bytes,
+        })
+    }
+}
+
+/// `GET /b/storage/api/buckets/{name}/upload-check?key=&size=`.
+pub(super) async fn handle(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    if !storage::is_safe_bucket_name(bucket) {
+        return err_bad_request("Invalid bucket name");
+    }
+
+    let key = msg.query("key").to_string();
+    let raw_size = msg.query("size").to_string();
+    let size = raw_size
+        .trim()
+        .parse::<i64>()
+        .ok()
+        .filter(|size| *size >= 0);
+    let mut invalid = Vec::new();
+    if key.is_empty() {
+        invalid.push(("key", "required"));
+    } else if !storage::is_valid_storage_key(&key) {
+        invalid.push(("key", "invalid object key"));
+    }
+    if raw_size.trim().is_empty() {
+        invalid.push(("size", "required"));
+    } else if size.is_none() {
+        invalid.push(("size", "must be a non-negative integer"));
+    }
+    if !invalid.is_empty() {
+        return errors::validation_error("Invalid upload check", &invalid);
+    }
+
+    if acl::is_access_denied(ctx, msg, bucket, &key, Access::Write).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+    match UploadCheck::run(ctx, msg.user_id(), bucket, &key, size.unwrap_or(0)).await {
+        Ok(check) => ok_json(&check.to_json()),
+        Err(r) => r,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashMap;
+
+    use serde_json::json;
+
+    use super::*;
+    use crate::test_support::TestContext;
+
+    async fn seed_object(ctx: &TestContext, key: &str, size: i64) {
+        let mut row: HashMap<String, serde_json::Value> = HashMap::new();
+        row.insert("bucket".into(), json!("docs"));
+        row.insert("key".into(), json!(key));
+        row.insert("size".into(), json!(size));
+        row.insert("uploaded_by".into(), json!("u1"));
+        repo::objects::seed(ctx, row).await.expect("seed object");
+    }
+
+    async fn seed_quota(ctx: &TestContext, max_storage: i64, max_file: i64) {
+        let mut row: HashMap<String, serde_json::Value> = HashMap::new();
+        row.insert("user_id".into(), json!("u1"));
+        row.insert("max_storage_bytes".into(), json!(max_storage));
+        row.insert("max_file_size_bytes".into(), json!(max_file));
+        repo::quota::seed(ctx, row).await.expect("seed quota");
+    }
+
+    fn codes(check: &UploadCheck) -> Vec<&'static str> {
+        check.refusals.iter().map(|r| r.code.as_str()).collect()
+    }
+
+    #[tokio::test]
+    async fn a_file_that_fits_is_allowed() {
+        let ctx = TestContext::with_files().await;
+        seed_quota(&ctx, 4096, 2048).await;
+        seed_object(&ctx, "a.txt", 1024).await;
+
+        let check = UploadCheck::run(&ctx, "u1", "docs", "b.txt", 1024)
+            .await
+            .expect("check");
+        assert!(check.refusals.is_empty());
+        let body = check.to_json();
+        assert_eq!(body["allowed"], json!(true));
+        assert_eq!(body["reasons"], json!([]));
+        assert_eq!(body["remaining_bytes"], json!(3072));
+        assert_eq!(body["max_upload_size"], json!(2048));
+        assert!(check.into_result().is_ok());
+    }
+
+    #[tokio::test]
+    async fn every_broken_limit_is_listed_in_upload_order() {
+        let ctx = TestContext::with_files().await;
+        seed_quota(&ctx, 4096, 2048).await;
+        seed_object(&ctx, "a.txt", 3072).await;
+
+        let check = UploadCheck::run(&ctx, "u1", "docs", "b.txt", 3000)
+            .await
+            .expect("check");
+        assert_eq!(codes(&check), vec!["file_too_large", "quota_exceeded"]);
+        let body = check.to_json();
+        assert_eq!(body["allowed"], json!(false));
+        assert_eq!(
+            body["reasons"][0]["message"],
+            json!("File exceeds maximum size of 2048 bytes")
+        );
+        assert_eq!(body["remaining_bytes"], json!(1024));
+    }
+
+    #[tokio::test]
+    async fn remaining_bytes_never_goes_negative() {
+        let ctx = TestContext::with_files().await;
+        seed_quota(&ctx, 1024, 2048).await;
+        seed_object(&ctx, "a.txt", 2048).await;
+
+        let check = UploadCheck::run(&ctx, "u1", "docs", "b.txt", 0)
+            .await
+            .expect("check");
+        assert_eq!(check.remaining_bytes(), 0);
+        assert_eq!(codes(&check), vec!["quota_exceeded"]);
+    }
+
+    #[tokio::test]
+    async fn a_locked_key_is_refused_first() {
+        let ctx = TestContext::with_files().await;
+        seed_quota(&ctx, 4096, 2048).await;
+        let until = crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::hours(1));
+        repo::locks::upsert(&ctx, "docs", "legal/", &until, "owner")
+            .await
+            .expect("lock");
+
+        let check = UploadCheck::run(&ctx, "u1", "docs", "legal/brief.pdf", 4000)
+            .await
+            .expect("check");
+        assert_eq!(codes(&check), vec!["object_locked", "file_too_large"]);
+        let body = check.to_json();
+        assert_eq!(body["reasons"][0]["details"]["path"], json!("legal/"));
+        assert!(body["reasons"][1].get("details").is_none());
+
+        let elsewhere = UploadCheck::run(&ctx, "u1", "docs", "public/brief.pdf", 1024)
+            .await
+            .expect("check");
+        assert!(elsewhere.refusals.is_empty());
+    }
+}
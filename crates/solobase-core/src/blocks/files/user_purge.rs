@@ -0,0 +1,186 @@
+//! What happens to a purged account's storage.
+//!
+//! When the `deleted_users` retention policy hard-deletes an account
+//! (`blocks::retention`), it queues a [`USER_PURGE_JOB`] here. The rule:
+//!
+//! - Their personal buckets are deleted with everything in them — except a
+//!   bucket under an active lock, which is kept as it is for an admin.
+//! - Objects they uploaded into another user's personal bucket stay, and
+//!   now count toward that bucket owner's quota (`uploaded_by` moves to
+//!   them). Objects in team buckets already belong to the team.
+//! - They leave every team. A team they owned passes to its
+//!   longest-standing remaining member; one with nobody left keeps its
+//!   buckets for an admin to delete.
+//! - Share links they created are revoked, grants they held are deleted,
+//!   and so are their quota override, threshold ledger rows and upload
+//!   widget sessions.
+//!
+//! The job is idempotent: a second run finds nothing left to do.
+
+use wafer_core::clients::storage as store;
+use wafer_run::{context::Context, InputStream, WaferError};
+
+use super::{locks::LockSet, repo, storage};
+use crate::{blocks::jobs::JobError, util::RecordExt};
+
+/// Job type queued by the `deleted_users` retention policy, payload
+/// `{"user_id": …}`.
+pub const USER_PURGE_JOB: &str = "files.user.purge";
+
+/// What a purge did.
+#[derive(Debug, Default, PartialEq, Eq)]
+pub(super) struct Purged {
+    pub(super) buckets_deleted: usize,
+    /// Personal buckets kept because a lock holds them.
+    pub(super) buckets_locked: usize,
+    pub(super) objects_reassigned: i64,
+    pub(super) teams_left: usize,
+}
+
+/// Run a [`USER_PURGE_JOB`].
+pub async fn run_purge_job(ctx: &dyn Context, input: InputStream) -> Result<(), JobError> {
+    #[derive(serde::Deserialize)]
+    struct Payload {
+        user_id: String,
+    }
+    let raw = input.collect_to_bytes().await;
+    let payload: Payload = serde_json::from_slice(&raw)
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+    if payload.user_id.is_empty() {
+        return Err(JobError::permanent("missing user_id"));
+    }
+    let purged = purge(ctx, &payload.user_id).await?;
+    tracing::info!(user_id = %payload.user_id, ?purged, "purged a deleted user's storage");
+    Ok(())
+}
+
+/// Apply the module's rule to `user_id`'s storage.
+pub(super) async fn purge(ctx: &dyn Context, user_id: &str) -> Result<Purged, WaferError> {
+    let mut purged = Purged::default();
+
+    for bucket in repo::buckets::list_owned_sorted(ctx, user_id).await? {
+        let name = bucket.str_field("name");
+        if LockSet::load(ctx, name).await?.blocking("").is_some() {
+            purged.buckets_locked += 1;
+            continue;
+        }
+        storage::delete_all_objects_or_fail(ctx, name).await?;
+        store::delete_folder(ctx, name).await?;
+        storage::delete_bucket_rows(ctx, name).await;
+        purged.buckets_deleted += 1;
+    }
+
+    for bucket in repo::objects::buckets_with_uploads(ctx, user_id).await? {
+        let Some(owner) = repo::buckets::owner_of(ctx, &bucket).await? else {
+            continue;
+        };
+        // Their own locked buckets keep their objects as they are.
+        if owner != user_id {
+            purged.objects_reassigned +=
+                repo::objects::reassign_uploads(ctx, &bucket, user_id, &owner).await?;
+        }
+    }
+
+    for team in repo::teams::list_for_user(ctx, user_id).await? {
+        if team.str_field("role") == repo::teams::ROLE_OWNER {
+            let heir = repo::teams::list_members(ctx, &team.id)
+                .await?
+                .into_iter()
+                .map(|m| m.str_field("user_id").to_string())
+                .find(|member| member != user_id);
+            if let Some(heir) = heir {
+                repo::teams::set_role(ctx, &team.id, &heir, repo::teams::ROLE_OWNER).await?;
+            }
+        }
+        repo::teams::remove_member(ctx, &team.id, user_id).await?;
+        purged.teams_left += 1;
+    }
+
+    repo::shares::revoke_all_for_user(ctx, user_id).await?;
+    repo::acls::delete_for_grantee(ctx, user_id).await?;
+    repo::quota::delete_for_user(ctx, user_id).await?;
+    repo::notifications::delete_for_user(ctx, user_id).await?;
+    repo::widgets::delete_for_user(ctx, user_id).await?;
+    Ok(purged)
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashMap;
+
+    use serde_json::json;
+
+    use super::*;
+    use crate::test_support::TestContext;
+
+    async fn seed_object(ctx: &TestContext, bucket: &str, key: &str, uploader: &str) {
+        let mut row: HashMap<String, serde_json::Value> = HashMap::new();
+        row.insert("bucket".into(), json!(bucket));
+        row.insert("key".into(), json!(key));
+        row.insert("size".into(), json!(10));
+        row.insert("uploaded_by".into(), json!(uploader));
+        repo::objects::seed(ctx, row).await.expect("seed object");
+    }
+
+    #[tokio::test]
+    async fn purge_deletes_personal_storage_and_hands_on_the_rest() {
+        let ctx = TestContext::with_files().await;
+        for (name, owner) in [
+            ("gone-docs", "gone"),
+            ("gone-legal", "gone"),
+            ("alice-box", "alice"),
+        ] {
+            repo::buckets::insert(&ctx, name, false, owner, "")
+                .await
+                .expect("seed bucket");
+        }
+        seed_object(&ctx, "alice-box", "from-gone.txt", "gone").await;
+        seed_object(&ctx, "alice-box", "own.txt", "alice").await;
+        let until = crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::days(1));
+        repo::locks::upsert(&ctx, "gone-legal", "", &until, "admin")
+            .await
+            .unwrap();
+        let team = repo::teams::insert(&ctx, "Design", "gone").await.unwrap();
+        repo::teams::add_member(&ctx, &team.id, "bob", repo::teams::ROLE_MEMBER, "gone")
+            .await
+            .unwrap();
+
+        let purged = purge(&ctx, "gone").await.unwrap();
+        assert_eq!(
+            purged,
+            Purged {
+                buckets_deleted: 1,
+                buckets_locked: 1,
+                objects_reassigned: 1,
+                teams_left: 1,
+            }
+        );
+        assert!(!repo::buckets::name_exists(&ctx, "gone-docs").await.unwrap());
+        assert!(repo::buckets::name_exists(&ctx, "gone-legal")
+            .await
+            .unwrap());
+        assert_eq!(
+            repo::objects::count_for_uploader(&ctx, "alice")
+                .await
+                .unwrap(),
+            2
+        );
+        assert_eq!(
+            repo::teams::role_of(&ctx, &team.id, "bob")
+                .await
+                .unwrap()
+                .as_deref(),
+            Some(repo::teams::ROLE_OWNER)
+        );
+        assert!(repo::teams::role_of(&ctx, &team.id, "gone")
+            .await
+            .unwrap()
+            .is_none());
+
+        // Nothing is left to do the second time.
+        let again = purge(&ctx, "gone").await.unwrap();
+        assert_eq!(again.buckets_deleted, 0);
+        assert_eq!(again.objects_reassigned, 0);
+        assert_eq!(again.teams_left, 0);
+    }
+}
@@ -0,0 +1,505 @@
+//! Static website hosting from a public bucket, the way S3 website hosting
+//! works.
+//!
+//! The bucket's owner (or an admin) configures it through
+//! `GET|PUT /b/storage/api/buckets/{name}/website`, a [`WebsiteConfig`]:
+//! `enabled`, `index_document` (default `index.html`) and `error_document`
+//! (none by default), stored as JSON on the bucket row (`website`). Only a
+//! public bucket may enable website mode; a private one is refused with a
+//! validation error.
+//!
+//! `GET /sites/{bucket}/{path...}` then serves the bucket to anyone:
+//!
+//! - an empty path, or one ending in `/`, serves that folder's index
+//!   document;
+//! - a path without an extension that isn't an object falls back to
+//!   `{path}.html` (clean URLs), then to the folder `{path}/` — redirected
+//!   to, like `/sites/{bucket}` to `/sites/{bucket}/`, so the page's
+//!   relative links resolve inside it;
+//! - anything else missing serves the error document with a 404, or a
+//!   plain 404 without one.
+//!
+//! Folders are key prefixes, so nested folders need nothing more. Only
+//! `complete` objects are served: one still pending, waiting for its scan,
+//! quarantined or archived reads as missing. A served page counts as a
+//! download of its object (see `downloads`) and as one use of
+//! `storage.download` charged to the bucket's owner — the team owner for a
+//! team bucket — through [`api_quota::charge`]; once the owner's quota is
+//! spent, visitors get its 429. Role-scoped quota definitions don't apply
+//! here, since the visit carries no owner roles; user and default ones do.
+//!
+//! Everything served carries a `sandbox` CSP ([`SITE_CSP`]) and `nosniff`,
+//! like previews: the pages share the app's origin, and must not run with
+//! the session of whoever opens them.
+
+use wafer_core::clients::database::Record;
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
+
+use super::{blobs, preview, repo, storage};
+use crate::{
+    blocks::{
+        admin::audit_log,
+        api_quota::{self, QuotaOutcome},
+        errors,
+        rate_limit::{check_rate_limit, RateLimit, RateLimitOutcome, UserRateLimiter},
+    },
+    http::{
+        err_bad_request, err_forbidden, err_internal, err_not_found, ok_json, redirect,
+        ResponseBuilder,
+    },
+    util::RecordExt,
+};
+
+/// Path prefix websites are served under.
+pub(super) const SITES_PREFIX: &str = "/sites/";
+
+/// `Cache-Control` of a served page or asset. Short, since the owner may
+/// replace it at any time; the `ETag` makes revalidation cheap.
+const CACHE_CONTROL: &str = "public, max-age=300";
+
+/// `Content-Security-Policy` of every served page and asset. Sites are
+/// served from the app's own origin, next to `/admin`, `/api` and the
+/// session cookie, so a page runs sandboxed — scripts and forms work, but
+/// without `allow-same-origin` it gets an opaque origin and can neither
+/// read the cookie nor make credentialed same-origin requests.
+const SITE_CSP: &str = "sandbox allow-scripts allow-forms";
+
+/// A bucket's website configuration.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+#[serde(default)]
+pub(super) struct WebsiteConfig {
+    pub enabled: bool,
+    /// Served for a folder path, e.g. `index.html` for `docs/`.
+    pub index_document: String,
+    /// Served with a 404 for a miss; empty for a plain 404.
+    pub error_document: String,
+}
+
+impl Default for WebsiteConfig {
+    fn default() -> Self {
+        Self {
+            enabled: false,
+            index_document: "index.html".to_string(),
+            error_document: String::new(),
+        }
+    }
+}
+
+impl WebsiteConfig {
+    /// The configuration stored on bucket row `bucket` (the default, off,
+    /// when there is none).
+    pub fn of(bucket: &Record) -> Self {
+        serde_json::from_str(bucket.str_field("website")).unwrap_or_default()
+    }
+
+    /// Field problems, as `(field, reason)`.
+    fn problems(&self) -> Vec<(&'static str, &'static str)> {
+        let mut problems = Vec::new();
+        let index = &self.index_document;
+        if !storage::is_valid_storage_key(index) || index.contains('/') {
+            problems.push(("index_document", "must be a file name without '/'"));
+        }
+        let error = &self.error_document;
+        if !error.is_empty() && !storage::is_valid_storage_key(error) {
+            problems.push(("error_document", "must be a valid object key"));
+        }
+        problems
+    }
+}
+
+/// `GET /b/storage/api/buckets/{name}/website` — the bucket's
+/// [`WebsiteConfig`], for its owner and admins.
+pub(super) async fn handle_get(ctx: &dyn Context, msg: &Message, bucket: &str) -> OutputStream {
+    let row = match owned_bucket(ctx, msg, bucket).await {
+        Ok(row) => row,
+        Err(r) => return r,
+    };
+    ok_json(&WebsiteConfig::of(&row))
+}
+
+/// `PUT /b/storage/api/buckets/{name}/website` — replace the bucket's
+/// [`WebsiteConfig`]. Missing fields take their defaults.
+pub(super) async fn handle_put(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+    input: InputStream,
+) -> OutputStream {
+    let row = match owned_bucket(ctx, msg, bucket).await {
+        Ok(row) => row,
+        Err(r) => return r,
+    };
+    let config: WebsiteConfig = match crate::body::decode(msg, input).await {
+        Ok(c) => c,
+        Err(r) => return r,
+    };
+    let mut problems = config.problems();
+    if config.enabled && !row.bool_field("public") {
+        problems.push(("enabled", "only a public bucket can serve a website"));
+    }
+    if !problems.is_empty() {
+        return errors::validation_error("Invalid website configuration", &problems);
+    }
+    let json = serde_json::to_string(&config).unwrap_or_default();
+    match repo::buckets::set_website(ctx, bucket, &json).await {
+        Ok(0) => err_not_found("Bucket not found"),
+        Ok(_) => {
+            audit_log(
+                ctx,
+                msg.user_id(),
+                "storage.bucket.website",
+                &format!("bucket:{bucket}"),
+                msg.remote_addr(),
+            )
+            .await;
+            ok_json(&config)
+        }
+        Err(e) => errors::db_error_response("Bucket", e),
+    }
+}
+
+/// The row of `bucket`, if the caller owns it or is an admin.
+async fn owned_bucket(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &str,
+) -> Result<Record, OutputStream> {
+    if !storage::is_safe_bucket_name(bucket) {
+        return Err(err_bad_request("Invalid bucket name"));
+    }
+    if storage::is_bucket_access_denied(ctx, msg, bucket).await {
+        return Err(err_forbidden("Access denied to this bucket"));
+    }
+    match repo::buckets::find_by_name(ctx, bucket).await {
+        Ok(Some(row)) => Ok(row),
+        Ok(None) => Err(err_not_found("Bucket not found")),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// `GET /sites/{bucket}/{path...}` — serve a website bucket. Public, and
+/// rate-limited per remote IP like share links.
+pub(super) async fn handle_site(
+    ctx: &dyn Context,
+    msg: &Message,
+    limiter: &UserRateLimiter,
+) -> OutputStream {
+    if msg.action() != "retrieve" {
+        return err_not_found("not found");
+    }
+    let identity = match msg.remote_addr() {
+        "" => "unknown",
+        addr => addr,
+    };
+    match check_rate_limit(limiter, ctx, identity, "site", RateLimit::API_READ).await {
+        RateLimitOutcome::Limited(r) => return r,
+        RateLimitOutcome::Allowed(_) | RateLimitOutcome::Disabled => {}
+    }
+
+    let rest = msg.path().strip_prefix(SITES_PREFIX).unwrap_or("");
+    let (bucket, path) = match rest.split_once('/') {
+        Some((bucket, path)) => (bucket, Some(path)),
+        None => (rest, None),
+    };
+    if !storage::is_safe_bucket_name(bucket) {
+        return not_found();
+    }
+    let row = match repo::buckets::find_by_name(ctx, bucket).await {
+        Ok(Some(row)) => row,
+        Ok(None) => return not_found(),
+        Err(e) => return err_internal("Database error", e),
+    };
+    let config = WebsiteConfig::of(&row);
+    // A bucket made private after the fact stops serving too.
+    if !config.enabled || !row.bool_field("public") {
+        return not_found();
+    }
+    let Some(path) = path else {
+        // Relative to `/sites/{bucket}`, this is `/sites/{bucket}/`, also
+        // behind a prefix the pipeline strips (`/api`).
+        return redirect(301, &format!("{bucket}/"));
+    };
+    let path = percent_encoding::percent_decode_str(path)
+        .decode_utf8_lossy()
+        .into_owned();
+
+    let found = match resolve(ctx, bucket, &path, &config.index_document).await {
+        Ok(found) => found,
+        Err(e) => return err_internal("Database error", e),
+    };
+    match found {
+        Some(Resolved::Object(object)) => serve(ctx, msg, &row, &object, 200).await,
+        Some(Resolved::Folder) => {
+            let name = path.rsplit('/').next().unwrap_or_default();
+            redirect(301, &format!("{name}/"))
+        }
+        None if config.error_document.is_empty() => not_found(),
+        None => match servable(ctx, bucket, &config.error_document).await {
+            Ok(Some(object)) => serve(ctx, msg, &row, &object, 404).await,
+            Ok(None) => not_found(),
+            Err(e) => err_internal("Database error", e),
+        },
+    }
+}
+
+/// What a site path resolves to.
+enum Resolved {
+    Object(Record),
+    /// A folder asked for without its trailing `/`.
+    Folder,
+}
+
+/// Resolve `path` in `bucket` as described in the module docs.
+async fn resolve(
+    ctx: &dyn Context,
+    bucket: &str,
+    path: &str,
+    index: &str,
+) -> Result<Option<Resolved>, wafer_run::WaferError> {
+    if path.is_empty() || path.ends_with('/') {
+        let object = servable(ctx, bucket, &format!("{path}{index}")).await?;
+        return Ok(object.map(Resolved::Object));
+    }
+    if let Some(object) = servable(ctx, bucket, path).await? {
+        return Ok(Some(Resolved::Object(object)));
+    }
+    let name = path.rsplit('/').next().unwrap_or_default();
+    if name.contains('.') {
+        return Ok(None);
+    }
+    if let Some(object) = servable(ctx, bucket, &format!("{path}.html")).await? {
+        return Ok(Some(Resolved::Object(object)));
+    }
+    let folder_index = servable(ctx, bucket, &format!("{path}/{index}")).await?;
+    Ok(folder_index.map(|_| Resolved::Folder))
+}
+
+/// The row of `key` in `bucket` if it can be served: a valid key whose
+/// object is `complete`.
+async fn servable(
+    ctx: &dyn Context,
+    bucket: &str,
+    key: &str,
+) -> Result<Option<Record>, wafer_run::WaferError> {
+    if !storage::is_valid_storage_key(key) {
+        return Ok(None);
+    }
+    let row = repo::objects::find_by_bucket_key(ctx, bucket, key).await?;
+    Ok(row.filter(|r| r.str_field("status") == "complete"))
+}
+
+/// Serve `object` of website bucket `bucket` with `status`, once it is
+/// charged to the owner's quota.
+async fn serve(
+    ctx: &dyn Context,
+    msg: &Message,
+    bucket: &Record,
+    object: &Record,
+    status: u16,
+) -> OutputStream {
+    let name = bucket.str_field("name");
+    let key = object.str_field("key");
+    let etag = format!("\"{}\"", object.id);
+    let owner = owner_of(ctx, bucket).await;
+    if !owner.is_empty() {
+        if let QuotaOutcome::Exceeded(r) =
+            api_quota::charge(ctx, &owner, &[], "storage.download").await
+        {
+            return r;
+        }
+    }
+    if status == 200 && msg.header("If-None-Match") == etag {
+        return ResponseBuilder::new()
+            .status(304)
+            .set_header("ETag", &etag)
+            .set_header("Cache-Control", CACHE_CONTROL)
+            .body(Vec::new(), "text/plain");
+    }
+    let (data, stored_type) = match blobs::get(ctx, name, key).await {
+        Ok(found) => found,
+        Err(e) if e.code == ErrorCode::NotFound => return not_found(),
+        Err(e) => return err_internal("Storage error", e),
+    };
+    repo::objects::record_download(ctx, &object.id).await;
+    ResponseBuilder::new()
+        .status(status)
+        .set_header("ETag", &etag)
+        .set_header("Cache-Control", CACHE_CONTROL)
+        .set_header("Content-Security-Policy", SITE_CSP)
+        .set_header("X-Content-Type-Options", "nosniff")
+        .body(data, &content_type(&stored_type, key))
+}
+
+/// The stored content type, or the key's extension's when the stored one
+/// is missing or generic; HTML and other text get `charset=utf-8` unless
+/// they name one.
+fn content_type(stored: &str, key: &str) -> String {
+    let mime = stored.split(';').next().unwrap_or("").trim();
+    let mime = match mime {
+        "" | "application/octet-stream" => match preview::mime_from_extension(key) {
+            "" => return "application/octet-stream".to_string(),
+            guessed => guessed,
+        },
+        _ if stored.contains("charset=") => return stored.to_string(),
+        mime => mime,
+    };
+    if mime.starts_with("text/") || mime == "application/javascript" {
+        format!("{mime}; charset=utf-8")
+    } else {
+        mime.to_string()
+    }
+}
+
+/// The user whose quota pays for `bucket`'s traffic: its creator, or the
+/// team owner for a team bucket. Empty when there is none to charge.
+async fn owner_of(ctx: &dyn Context, bucket: &Record) -> String {
+    let team_id = bucket.str_field("team_id");
+    if team_id.is_empty() {
+        return bucket.str_field("created_by").to_string();
+    }
+    match repo::teams::list_members(ctx, team_id).await {
+        Ok(members) => members
+            .iter()
+            .find(|m| m.str_field("role") == repo::teams::ROLE_OWNER)
+            .map(|m| m.str_field("user_id").to_string())
+            .unwrap_or_default(),
+        Err(e) => {
+            tracing::warn!(error = %e, team_id = %team_id, "website owner lookup failed");
+            String::new()
+        }
+    }
+}
+
+fn not_found() -> OutputStream {
+    ResponseBuilder::new()
+        .status(404)
+        .set_header("Cache-Control", "no-cache")
+        .body(b"Not Found".to_vec(), "text/plain; charset=utf-8")
+}
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+    use wafer_run::{streams::output::BufferedResponse, MetaGet, META_RESP_STATUS};
+
+    use super::*;
+    use crate::test_support::{
+        anon_msg, auth_msg, collect_or_panic, output_json, output_status, TestContext,
+    };
+
+    async fn site_ctx(public: bool) -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let bucket = crate::util::json_map(json!({
+            "name": "docs",
+            "public": public,
+            "created_by": "alice",
+            "team_id": "",
+            "created_at": crate::util::now_rfc3339(),
+        }));
+        repo::buckets::seed(&ctx, bucket)
+            .await
+            .expect("seed bucket");
+        ctx
+    }
+
+    async fn configure(ctx: &TestContext, user: &str, body: serde_json::Value) -> OutputStream {
+        let mut msg = auth_msg("update", "/b/storage/api/buckets/docs/website", user);
+        msg.set_meta("req.param.name", "docs");
+        let input = InputStream::from_bytes(serde_json::to_vec(&body).unwrap());
+        handle_put(ctx, &msg, "docs", input).await
+    }
+
+    async fn visit(ctx: &TestContext, path: &str) -> BufferedResponse {
+        let limiter = UserRateLimiter::new();
+        let msg = anon_msg("retrieve", &format!("/sites/{path}"));
+        collect_or_panic(handle_site(ctx, &msg, &limiter).await).await
+    }
+
+    fn status(out: &BufferedResponse) -> Option<&str> {
+        MetaGet::get(&out.meta, META_RESP_STATUS)
+    }
+
+    fn header<'a>(out: &'a BufferedResponse, name: &str) -> Option<&'a str> {
+        MetaGet::get(&out.meta, &format!("resp.header.{name}"))
+    }
+
+    #[tokio::test]
+    async fn private_buckets_cannot_enable_website_mode() {
+        let ctx = site_ctx(false).await;
+        let out = configure(&ctx, "alice", json!({ "enabled": true })).await;
+        let body = output_json(out).await;
+        assert_eq!(body["code"], "validation_failed", "{body}");
+        assert!(body["details"]["enabled"].is_string());
+
+        let out = configure(&ctx, "alice", json!({ "enabled": false })).await;
+        assert_eq!(output_status(out).await, 200);
+        let out = configure(&ctx, "bob", json!({ "enabled": false })).await;
+        assert_eq!(output_status(out).await, 403);
+        let out = configure(&ctx, "alice", json!({ "index_document": "a/b.html" })).await;
+        assert_eq!(output_json(out).await["code"], "validation_failed");
+    }
+
+    #[tokio::test]
+    async fn sites_resolve_index_clean_urls_and_error_documents() {
+        let ctx = site_ctx(true).await;
+        for (key, body, content_type) in [
+            ("index.html", "home", "text/html"),
+            ("about.html", "about", "text/html"),
+            ("guide/index.html", "guide", "text/html"),
+            ("app.js", "js", "application/octet-stream"),
+            ("404.html", "missing", "text/html"),
+        ] {
+            blobs::seed(&ctx, "docs", key, body.as_bytes(), content_type, "alice").await;
+        }
+
+        assert_eq!(
+            status(&visit(&ctx, "docs/").await),
+            Some("404"),
+            "off by default"
+        );
+        let body = json!({ "enabled": true, "error_document": "404.html" });
+        assert_eq!(
+            output_status(configure(&ctx, "alice", body).await).await,
+            200
+        );
+
+        let out = visit(&ctx, "docs").await;
+        assert_eq!(status(&out), Some("301"));
+        assert_eq!(header(&out, "Location"), Some("docs/"));
+
+        let out = visit(&ctx, "docs/").await;
+        assert_eq!(out.body, b"home");
+        assert_eq!(header(&out, "Cache-Control"), Some(CACHE_CONTROL));
+        assert_eq!(header(&out, "Content-Security-Policy"), Some(SITE_CSP));
+        assert!(!SITE_CSP.contains("allow-same-origin"));
+        assert_eq!(header(&out, "X-Content-Type-Options"), Some("nosniff"));
+        assert_eq!(visit(&ctx, "docs/about").await.body, b"about");
+        assert_eq!(visit(&ctx, "docs/guide/").await.body, b"guide");
+        let out = visit(&ctx, "docs/guide").await;
+        assert_eq!(header(&out, "Location"), Some("guide/"));
+
+        let out = visit(&ctx, "docs/app.js").await;
+        assert_eq!(
+            MetaGet::get(&out.meta, "resp.content_type"),
+            Some("application/javascript; charset=utf-8")
+        );
+
+        let out = visit(&ctx, "docs/nope.png").await;
+        assert_eq!(status(&out), Some("404"));
+        assert_eq!(out.body, b"missing");
+        assert_eq!(header(&out, "Content-Security-Policy"), Some(SITE_CSP));
+    }
+
+    #[test]
+    fn content_type_prefers_the_stored_one() {
+        assert_eq!(content_type("image/png", "a.bin"), "image/png");
+        assert_eq!(content_type("text/html", "a"), "text/html; charset=utf-8");
+        assert_eq!(
+            content_type("text/plain; charset=latin1", "a"),
+            "text/plain; charset=latin1"
+        );
+        assert_eq!(content_type("", "style.css"), "text/css; charset=utf-8");
+        assert_eq!(content_type("", "data"), "application/octet-stream");
+    }
+}
@@ -0,0 +1,931 @@
+//! Upload widget for third-party sites.
+//!
+//! A site built on Solobase drops `<script src=…/b/storage/api/widgets/upload.js>`
+//! into a page and gets an upload dropzone that stores into one of its
+//! buckets, without handing the browser any long-lived credential:
+//!
+//! 1. Its backend calls `POST /b/storage/api/widgets/upload-sessions` with
+//!    its API key, naming the bucket and folder, the page origins allowed to
+//!    upload, the accepted types, the per-file size and the number of files.
+//!    The answer carries a signed token that expires with the session
+//!    (15 minutes by default, an hour at most).
+//! 2. The page renders the widget with that token. Each file is one
+//!    multipart `POST /b/storage/api/widgets/upload?token=…` — a CORS
+//!    "simple" request, so there is no preflight. The request's `Origin`
+//!    must be one of the session's; responses echo it back in
+//!    `Access-Control-Allow-Origin` so the widget can read them.
+//! 3. The backend polls `GET /b/storage/api/widgets/upload-sessions/{id}`
+//!    for the object ids the session stored.
+//!
+//! A session minted with a `sha256` takes only content with that checksum,
+//! and an upload may name one with `?sha256=`; a retried file the session
+//! already stored with it answers `not_modified` (see `checksum`).
+//!
+//! Files are stored as the account that minted the session, through the
+//! same path as a normal upload: its quota, locks, malware scan and object
+//! history apply. Each lands at `{folder}{session id}/{file name}`, so two
+//! visitors' `photo.jpg` never collide; a name already used in the session
+//! is refused. A session stops taking files at its upload count, which is
+//! claimed atomically before the body is stored. A single-use session (the
+//! default count of one) is also spent in the nonce store
+//! ([`crate::blocks::nonces`]) under the session id its token signs, so a
+//! replayed upload request is refused there first.
+
+use std::time::Duration;
+
+use wafer_core::clients::crypto;
+use wafer_run::{context::Context, InputStream, Message, OutputStream};
+
+use super::{
+    acl::{self, Access},
+    checksum, history,
+    quota::{self, Refusal},
+    repo,
+    scan::{self, Admission},
+    storage,
+    upload_check::UploadCheck,
+};
+use crate::{
+    blocks::{
+        admin::audit_log,
+        errors::{self, ErrorCode},
+        nonces,
+        rate_limit::{check_rate_limit, RateLimit, RateLimitOutcome, UserRateLimiter},
+    },
+    http::{err_forbidden, err_internal, err_not_found, ok_json, ResponseBuilder},
+    util::{json_map, RecordExt},
+};
+
+/// The widget script.
+pub(super) const SCRIPT_PATH: &str = "/b/storage/api/widgets/upload.js";
+/// Where the widget posts each file.
+pub(super) const UPLOAD_PATH: &str = "/b/storage/api/widgets/upload";
+
+/// Nonce-store scope of single-use sessions.
+const NONCE_SCOPE: &str = "widget_upload";
+
+/// `type` claim of a widget token.
+const TOKEN_TYPE: &str = "widget_upload";
+
+/// Session lifetime when the request names none, and the longest allowed.
+const DEFAULT_TTL_SECS: i64 = 15 * 60;
+const MAX_TTL_SECS: i64 = 60 * 60;
+const MIN_TTL_SECS: i64 = 60;
+
+/// Most files one session may store, and most origins it may name.
+const MAX_UPLOADS: i64 = 100;
+const MAX_ORIGINS: usize = 10;
+
+/// Room for the multipart envelope around the file when capping the body;
+/// the file itself is held to the session's size exactly.
+const ENVELOPE_SLACK: i64 = 16 * 1024;
+
+/// Whether `path` is one of the widget's public routes, served without a
+/// signed-in caller.
+pub(super) fn is_public_path(path: &str) -> bool {
+    path == SCRIPT_PATH || path == UPLOAD_PATH
+}
+
+/// The public routes: the script, and uploads with a widget token.
+pub(super) async fn handle_public(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+    limiter: &UserRateLimiter,
+) -> OutputStream {
+    match (msg.action(), msg.path()) {
+        ("retrieve", SCRIPT_PATH) => ResponseBuilder::new()
+            // Unhashed URL: short enough that a new release reaches pages
+            // within minutes.
+            .set_header("Cache-Control", "public, max-age=300")
+            .body(
+                crate::ui::assets::upload_widget_js().as_bytes().to_vec(),
+                "application/javascript; charset=utf-8",
+            ),
+        ("create", UPLOAD_PATH) => handle_upload(ctx, msg, input, limiter).await,
+        _ => err_not_found("not found"),
+    }
+}
+
+/// `POST /b/storage/api/widgets/upload-sessions`.
+pub(super) async fn handle_create_session(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        #[serde(default)]
+        bucket: String,
+        #[serde(default)]
+        folder: String,
+        #[serde(default)]
+        allowed_origins: Vec<String>,
+        #[serde(default)]
+        allowed_types: Vec<String>,
+        #[serde(default)]
+        max_bytes: i64,
+        #[serde(default)]
+        max_uploads: Option<i64>,
+        #[serde(default)]
+        expires_in: Option<i64>,
+        #[serde(default)]
+        sha256: String,
+    }
+
+    let body: Req = match crate::body::decode(msg, input).await {
+        Ok(b) => b,
+        Err(r) => return r,
+    };
+    let origins: Option<Vec<String>> = body
+        .allowed_origins
+        .iter()
+        .map(|o| normalize_origin(o))
+        .collect();
+    let types: Vec<String> = body
+        .allowed_types
+        .iter()
+        .map(|t| t.trim().to_ascii_lowercase())
+        .collect();
+    let max_uploads = body.max_uploads.unwrap_or(1);
+    let ttl = body.expires_in.unwrap_or(DEFAULT_TTL_SECS);
+    let sha256 = checksum::parse_expected(&body.sha256);
+
+    let mut invalid = Vec::new();
+    if !storage::is_safe_bucket_name(&body.bucket) {
+        invalid.push(("bucket", "must be a bucket name"));
+    }
+    if !body.folder.is_empty()
+        && !(body.folder.ends_with('/') && storage::is_valid_storage_key(&body.folder))
+    {
+        invalid.push(("folder", "must be a folder path ending in '/'"));
+    }
+    match &origins {
+        _ if body.allowed_origins.is_empty() => invalid.push(("allowed_origins", "required")),
+        _ if body.allowed_origins.len() > MAX_ORIGINS => {
+            invalid.push(("allowed_origins", "at most 10 origins"))
+        }
+        None => invalid.push((
+            "allowed_origins",
+            "each must be an http(s) origin like https://example.com",
+        )),
+        Some(_) => {}
+    }
+    if !types.iter().all(|t| is_valid_type_pattern(t)) {
+        invalid.push((
+            "allowed_types",
+            "each must be a MIME type like image/png or image/*",
+        ));
+    }
+    if body.max_bytes < 0 {
+        invalid.push(("max_bytes", "must not be negative"));
+    }
+    if !(1..=MAX_UPLOADS).contains(&max_uploads) {
+        invalid.push(("max_uploads", "must be between 1 and 100"));
+    }
+    if !(MIN_TTL_SECS..=MAX_TTL_SECS).contains(&ttl) {
+        invalid.push(("expires_in", "must be between 60 and 3600 seconds"));
+    }
+    if let Err(reason) = sha256 {
+        invalid.push(("sha256", reason));
+    }
+    if !invalid.is_empty() {
+        return errors::validation_error("Invalid upload session", &invalid);
+    }
+
+    match repo::buckets::name_exists(ctx, &body.bucket).await {
+        Ok(true) => {}
+        Ok(false) => return err_not_found("Bucket not found"),
+        Err(e) => return err_internal("Database error", e),
+    }
+    if acl::is_access_denied(ctx, msg, &body.bucket, &body.folder, Access::Write).await {
+        return err_forbidden("Access denied to this bucket");
+    }
+
+    let expires_at =
+        crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::seconds(ttl));
+    let row = match repo::widgets::insert(
+        ctx,
+        repo::widgets::NewSession {
+            created_by: msg.user_id(),
+            bucket: &body.bucket,
+            folder: &body.folder,
+            allowed_origins: &origins.unwrap_or_default(),
+            allowed_types: &types,
+            max_bytes: body.max_bytes,
+            max_uploads,
+            expires_at: &expires_at,
+            sha256: sha256.ok().flatten().as_deref().unwrap_or(""),
+        },
+    )
+    .await
+    {
+        Ok(row) => row,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let claims = json_map(serde_json::json!({ "type": TOKEN_TYPE, "sid": row.id }));
+    let token = match crypto::sign(ctx, &claims, Duration::from_secs(ttl as u64)).await {
+        Ok(token) => token,
+        Err(e) => return err_internal("Token generation failed", e),
+    };
+    audit_log(
+        ctx,
+        msg.user_id(),
+        "storage.widget.session",
+        &format!("bucket:{}/{}", body.bucket, body.folder),
+        msg.remote_addr(),
+    )
+    .await;
+
+    let mut out = session_json(&row, &[]);
+    out["token"] = serde_json::json!(token);
+    out["script_url"] = serde_json::json!(crate::base_path::url(msg, SCRIPT_PATH));
+    out["upload_url"] = serde_json::json!(crate::base_path::url(msg, UPLOAD_PATH));
+    ok_json(&out)
+}
+
+/// `GET /b/storage/api/widgets/upload-sessions/{id}` — the session and the
+/// objects it stored so far, for the account that minted it.
+pub(super) async fn handle_get_session(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let id = msg.var("id");
+    let row = match repo::widgets::find(ctx, id).await {
+        Ok(Some(row)) if row.str_field("created_by") == msg.user_id() => row,
+        Ok(_) => return err_not_found("Upload session not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    match repo::widgets::list_uploads(ctx, &row.id).await {
+        Ok(uploads) => ok_json(&session_json(&row, &uploads)),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+fn session_json(
+    row: &wafer_core::clients::database::Record,
+    uploads: &[wafer_core::clients::database::Record],
+) -> serde_json::Value {
+    let list = |field: &str| -> Vec<String> {
+        serde_json::from_str(row.str_field(field)).unwrap_or_default()
+    };
+    let expires_at = row.str_field("expires_at");
+    serde_json::json!({
+        "id": row.id,
+        "bucket": row.str_field("bucket"),
+        "folder": row.str_field("folder"),
+        "allowed_origins": list("allowed_origins"),
+        "allowed_types": list("allowed_types"),
+        "max_bytes": row.i64_field("max_bytes"),
+        "max_uploads": row.i64_field("max_uploads"),
+        "upload_count": row.i64_field("upload_count"),
+        "expires_at": expires_at,
+        "sha256": Some(row.str_field("sha256")).filter(|s| !s.is_empty()),
+        "expired": expires_at <= crate::util::now_rfc3339().as_str(),
+        "uploads": uploads.iter().map(|u| serde_json::json!({
+            "object_id": u.str_field("object_id"),
+            "key": u.str_field("key"),
+            "size": u.i64_field("size"),
+            "content_type": u.str_field("content_type"),
+            "uploaded_at": u.str_field("created_at"),
+        })).collect::<Vec<_>>(),
+    })
+}
+
+/// `POST /b/storage/api/widgets/upload?token=…` from a page on one of the
+/// session's origins.
+async fn handle_upload(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+    limiter: &UserRateLimiter,
+) -> OutputStream {
+    let origin = normalize_origin(msg.header("origin")).unwrap_or_default();
+
+    // Public route: limit per remote address, like share links.
+    let identity = match msg.remote_addr() {
+        "" => "unknown".to_string(),
+        addr => addr.to_string(),
+    };
+    if let RateLimitOutcome::Limited(r) =
+        check_rate_limit(limiter, ctx, &identity, "widget_upload", RateLimit::UPLOAD).await
+    {
+        return r;
+    }
+
+    let result = upload(ctx, msg, input, &origin).await;
+    // Errors carry the CORS headers too, so the widget can show why.
+    let (status, body) = match result {
+        Ok(body) => (200, body),
+        Err(refusal) => (
+            refusal.code.status_code(),
+            errors::error_body(refusal.code, &refusal.message, refusal.details),
+        ),
+    };
+    let mut resp = ResponseBuilder::new().status(status);
+    if !origin.is_empty() {
+        resp = resp
+            .set_header("Access-Control-Allow-Origin", &origin)
+            .set_header("Vary", "Origin");
+    }
+    resp.json(&body)
+}
+
+async fn upload(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+    origin: &str,
+) -> Result<serde_json::Value, Refusal> {
+    let session = session_for_token(ctx, msg.query("token")).await?;
+    if origin.is_empty() || !allowed(&session, "allowed_origins", |o| o == origin) {
+        return Err(Refusal::new(
+            ErrorCode::OriginNotAllowed,
+            "This page may not upload with this token",
+        ));
+    }
+    let owner = session.str_field("created_by").to_string();
+    let expected = expected_checksum(&session, msg.query("sha256"))?;
+
+    let content_type_header = msg.get_meta("req.content_type").to_string();
+    if crate::multipart::multipart_boundary(&content_type_header).is_none() {
+        return Err(Refusal::new(
+            ErrorCode::InvalidInput,
+            "Send the file as multipart/form-data",
+        ));
+    }
+    quota::sweep_stale_pending(ctx, &owner, 3600).await;
+    let file_cap = quota::get_user_quota(ctx, &owner).await.max_file_size_bytes;
+    let max_bytes = match session.i64_field("max_bytes") {
+        n if n > 0 => n.min(file_cap),
+        _ => file_cap,
+    };
+    let Ok(body) = crate::util::collect_with_cap(input, max_bytes + ENVELOPE_SLACK).await else {
+        return Err(too_large(max_bytes));
+    };
+    let Some(file) = crate::multipart::extract_multipart_file(&body, &content_type_header) else {
+        return Err(Refusal::new(
+            ErrorCode::InvalidInput,
+            "Multipart body contains no file part",
+        ));
+    };
+    if file.content.len() as i64 > max_bytes {
+        return Err(too_large(max_bytes));
+    }
+    if let Some(expected) = &expected {
+        let actual = checksum::digest(&file.content);
+        if *expected != actual {
+            return Err(checksum::mismatch(expected, &actual));
+        }
+    }
+
+    let name = file_name(file.filename.as_deref().unwrap_or(""));
+    let key = format!("{}{}/{}", session.str_field("folder"), session.id, name);
+    if name.is_empty() || !storage::is_valid_storage_key(&key) {
+        return Err(Refusal::new(ErrorCode::InvalidInput, "Invalid file name"));
+    }
+    let content_type = file
+        .content_type
+        .filter(|ct| !ct.is_empty())
+        .unwrap_or_else(|| wafer_core::mime::mime_for_ext(std::path::Path::new(&name)).to_string());
+    if !allowed_type(&session, &content_type) {
+        return Err(Refusal::new(
+            ErrorCode::UnsupportedMediaType,
+            format!("Files of type {content_type} are not accepted here"),
+        ));
+    }
+    let bucket = session.str_field("bucket");
+    match repo::widgets::has_upload_at(ctx, &session.id, &key).await {
+        Ok(false) => {}
+        Ok(true) => {
+            // A retry of a file that arrived: answer with what is stored.
+            if let Some(expected) = &expected {
+                match checksum::unchanged(ctx, bucket, &key, expected).await {
+                    Ok(Some(row)) => {
+                        return Ok(serde_json::json!({
+                            "object_id": row.id,
+                            "key": key,
+                            "size": row.i64_field("size"),
+                            "content_type": row.str_field("content_type"),
+                            "sha256": expected,
+                            "session_id": session.id,
+                            "not_modified": true,
+                        }))
+                    }
+                    Ok(None) => {}
+                    Err(e) => return Err(internal("Database error", e)),
+                }
+            }
+            return Err(Refusal::new(
+                ErrorCode::ObjectExists,
+                format!("A file named {name} was already uploaded"),
+            ));
+        }
+        Err(e) => return Err(internal("Database error", e)),
+    }
+
+    let check = UploadCheck::run(ctx, &owner, bucket, &key, file.content.len() as i64)
+        .await
+        .map_err(|_| Refusal::new(ErrorCode::InternalError, "Database error"))?;
+    if let Some(refusal) = check.refusals.into_iter().next() {
+        return Err(refusal);
+    }
+    let team_id = check.team_id;
+
+    let spent = || {
+        Refusal::new(
+            ErrorCode::UploadLimitReached,
+            "This upload session takes no more files",
+        )
+    };
+    let single_use = session.i64_field("max_uploads") == 1;
+    if single_use {
+        let expires_at = session.str_field("expires_at");
+        match nonces::claim(ctx, NONCE_SCOPE, &session.id, 1, expires_at).await {
+            Ok(nonces::Claim::Accepted) => {}
+            Ok(nonces::Claim::Reused) => return Err(spent()),
+            Err(e) => return Err(internal("Database error", e)),
+        }
+    }
+    match repo::widgets::claim_upload(ctx, &session.id, session.i64_field("max_uploads")).await {
+        Ok(true) => {}
+        Ok(false) => return Err(spent()),
+        Err(e) => {
+            if single_use {
+                release_nonce(ctx, &session.id).await;
+            }
+            return Err(internal("Database error", e));
+        }
+    }
+    let stored = store(
+        ctx,
+        &session.id,
+        bucket,
+        &key,
+        &file.content,
+        &content_type,
+        &owner,
+        &team_id,
+    )
+    .await;
+    let (row, held) = match stored {
+        Ok(stored) => stored,
+        Err(refusal) => {
+            if let Err(e) = repo::widgets::release_upload(ctx, &session.id).await {
+                tracing::warn!(error = %e, session = %session.id, "failed to release a widget upload");
+            }
+            if single_use {
+                release_nonce(ctx, &session.id).await;
+            }
+            return Err(refusal);
+        }
+    };
+    if let Err(e) = repo::widgets::record_upload(ctx, &session.id, &row, origin).await {
+        tracing::warn!(error = %e, session = %session.id, "failed to record a widget upload");
+    }
+    storage::after_upload(ctx, &owner, &team_id).await;
+
+    let mut out = serde_json::json!({
+        "object_id": row.id,
+        "key": key,
+        "size": file.content.len(),
+        "content_type": content_type,
+        "sha256": row.str_field("sha256"),
+        "session_id": session.id,
+    });
+    if held {
+        out["status"] = serde_json::json!(repo::objects::STATUS_PENDING_SCAN);
+    }
+    Ok(out)
+}
+
+/// Scan and store one widget file as `owner`. Returns its row and whether
+/// it is held for a background scan.
+#[allow(clippy::too_many_arguments)]
+async fn store(
+    ctx: &dyn Context,
+    session_id: &str,
+    bucket: &str,
+    key: &str,
+    content: &[u8],
+    content_type: &str,
+    owner: &str,
+    team_id: &str,
+) -> Result<(wafer_core::clients::database::Record, bool), Refusal> {
+    let held = match scan::admit(ctx, content, key).await {
+        Admission::Store => false,
+        Admission::Hold => true,
+        Admission::Reject { signature } => {
+            return Err(Refusal {
+                code: ErrorCode::MalwareDetected,
+                message: "File rejected by the malware scanner".to_string(),
+                details: Some(serde_json::json!({ "signature": signature })),
+            })
+        }
+    };
+    let row = storage::put_object(
+        ctx,
+        bucket,
+        key,
+        content,
+        content_type,
+        owner,
+        team_id,
+        held,
+    )
+    .await
+    .map_err(|(what, e)| {
+        tracing::error!(error = %e, session = %session_id, "{what}");
+        Refusal::new(ErrorCode::InternalError, what)
+    })?;
+    history::record(ctx, history::Event::created(&row, owner)).await;
+    Ok((row, held))
+}
+
+/// Give back a single-use session's nonce after its upload failed, so
+/// the widget's retry is accepted.
+async fn release_nonce(ctx: &dyn Context, session_id: &str) {
+    if let Err(e) = nonces::release(ctx, NONCE_SCOPE, session_id).await {
+        tracing::warn!(error = %e, session = %session_id, "failed to release a widget upload nonce");
+    }
+}
+
+/// The live session a widget token names.
+async fn session_for_token(
+    ctx: &dyn Context,
+    token: &str,
+) -> Result<wafer_core::clients::database::Record, Refusal> {
+    let invalid = || Refusal::new(ErrorCode::InvalidToken, "Invalid upload token");
+    if token.is_empty() {
+        return Err(invalid());
+    }
+    // Signature first, so random tokens never reach the database.
+    let claims = crypto::verify(ctx, token).await.map_err(|_| invalid())?;
+    let claim = |k: &str| claims.get(k).and_then(|v| v.as_str()).unwrap_or("");
+    if claim("type") != TOKEN_TYPE || claim("sid").is_empty() {
+        return Err(invalid());
+    }
+    let session = match repo::widgets::find(ctx, claim("sid")).await {
+        Ok(Some(session)) => session,
+        Ok(None) => return Err(invalid()),
+        Err(e) => return Err(internal("Database error", e)),
+    };
+    if session.str_field("expires_at") <= crate::util::now_rfc3339().as_str() {
+        return Err(Refusal::new(
+            ErrorCode::TokenExpired,
+            "This upload session has expired",
+        ));
+    }
+    Ok(session)
+}
+
+/// The checksum an upload must have: the session's, or the one `query`
+/// names. Both may be given only when they agree.
+fn expected_checksum(
+    session: &wafer_core::clients::database::Record,
+    query: &str,
+) -> Result<Option<String>, Refusal> {
+    let named = checksum::parse_expected(query).map_err(|reason| Refusal {
+        code: ErrorCode::ValidationFailed,
+        message: "Invalid upload".to_string(),
+        details: Some(serde_json::json!({ "sha256": reason })),
+    })?;
+    match (session.str_field("sha256"), named) {
+        ("", named) => Ok(named),
+        (pinned, Some(named)) if named != pinned => Err(Refusal::new(
+            ErrorCode::ChecksumMismatch,
+            "The sha256 parameter differs from the one this upload session requires",
+        )),
+        (pinned, _) => Ok(Some(pinned.to_string())),
+    }
+}
+
+fn internal(what: &str, e: impl std::fmt::Display) -> Refusal {
+    tracing::error!(error = %e, "widget upload: {what}");
+    Refusal::new(ErrorCode::InternalError, what)
+}
+
+fn too_large(max_bytes: i64) -> Refusal {
+    Refusal::new(
+        ErrorCode::FileTooLarge,
+        format!("File exceeds maximum size of {max_bytes} bytes"),
+    )
+}
+
+/// Whether any entry of the session's JSON list `field` passes `pred`.
+fn allowed(
+    session: &wafer_core::clients::database::Record,
+    field: &str,
+    pred: impl Fn(&str) -> bool,
+) -> bool {
+    serde_json::from_str::<Vec<String>>(session.str_field(field))
+        .unwrap_or_default()
+        .iter()
+        .any(|v| pred(v))
+}
+
+/// Whether the session accepts `content_type`: any type when it lists none.
+fn allowed_type(session: &wafer_core::clients::database::Record, content_type: &str) -> bool {
+    let types: Vec<String> =
+        serde_json::from_str(session.str_field("allowed_types")).unwrap_or_default();
+    types.is_empty() || types.iter().any(|p| type_matches(p, content_type))
+}
+
+/// `pattern` (`type/subtype` or `type/*`) against a content type, ignoring
+/// parameters and case.
+fn type_matches(pattern: &str, content_type: &str) -> bool {
+    let mime = content_type
+        .split(';')
+        .next()
+        .unwrap_or("")
+        .trim()
+        .to_ascii_lowercase();
+    match pattern.strip_suffix("/*") {
+        Some(top) => mime.split('/').next() == Some(top) && mime.contains('/'),
+        None => mime == pattern,
+    }
+}
+
+fn is_valid_type_pattern(pattern: &str) -> bool {
+    let token = |s: &str| {
+        !s.is_empty()
+            && s.bytes()
+                .all(|b| b.is_ascii_alphanumeric() || b"!#$&-^_.+".contains(&b))
+    };
+    match pattern.split_once('/') {
+        Some((top, "*")) => token(top),
+        Some((top, sub)) => token(top) && token(sub),
+        None => false,
+    }
+}
+
+/// `scheme://host[:port]` of an http(s) origin, lowercased; `None` for
+/// anything else (paths, `null`, other schemes).
+fn normalize_origin(value: &str) -> Option<String> {
+    let value = value.trim();
+    let parsed = url::Url::parse(value).ok()?;
+    if !matches!(parsed.scheme(), "http" | "https")
+        || parsed.host_str().is_none()
+        || !matches!(parsed.path(), "" | "/")
+        || parsed.query().is_some()
+        || parsed.fragment().is_some()
+        || !parsed.username().is_empty()
+    {
+        return None;
+    }
+    Some(parsed.origin().ascii_serialization())
+}
+
+/// The last path segment of a browser-supplied file name, trimmed.
+fn file_name(raw: &str) -> String {
+    raw.rsplit(['/', '\\'])
+        .next()
+        .unwrap_or("")
+        .trim()
+        .to_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use serde_json::json;
+
+    use super::*;
+    use crate::test_support::{auth_msg, output_json, output_status, TestContext};
+
+    const SHOP: &str = "https://shop.example";
+
+    async fn ctx_with_bucket() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_mem_storage();
+        let crypto_svc = Arc::new(
+            wafer_block_crypto::service::Argon2JwtCryptoService::new(
+                "test-jwt-secret-padded-to-min-32-bytes-aaaa".to_string(),
+            )
+            .expect("test secret is long enough"),
+        );
+        ctx.register_block(
+            "wafer-run/crypto",
+            Arc::new(wafer_core::service_blocks::crypto::CryptoBlock::new(
+                crypto_svc,
+            )),
+        );
+        repo::buckets::insert(&ctx, "site", false, "alice", "")
+            .await
+            .expect("seed bucket");
+        ctx
+    }
+
+    async fn mint(ctx: &TestContext, body: serde_json::Value) -> OutputStream {
+        let msg = auth_msg("create", "/b/storage/api/widgets/upload-sessions", "alice");
+        handle_create_session(
+            ctx,
+            &msg,
+            InputStream::from_bytes(body.to_string().into_bytes()),
+        )
+        .await
+    }
+
+    /// Post `name` (`content_type`) from a page on `origin`; the status,
+    /// the echoed `Access-Control-Allow-Origin` and the JSON body.
+    async fn upload_from(
+        ctx: &TestContext,
+        token: &str,
+        origin: &str,
+        name: &str,
+        content_type: &str,
+    ) -> (u16, Option<String>, serde_json::Value) {
+        let mut body = Vec::new();
+        body.extend_from_slice(b"--xyz\r\n");
+        body.extend_from_slice(
+            format!("Content-Disposition: form-data; name=\"file\"; filename=\"{name}\"\r\n")
+                .as_bytes(),
+        );
+        body.extend_from_slice(format!("Content-Type: {content_type}\r\n\r\n").as_bytes());
+        body.extend_from_slice(b"bytes");
+        body.extend_from_slice(b"\r\n--xyz--\r\n");
+
+        let mut msg = crate::test_support::anon_msg("create", UPLOAD_PATH);
+        msg.set_meta("req.query.token", token);
+        msg.set_meta("http.header.origin", origin);
+        msg.set_meta("req.content_type", "multipart/form-data; boundary=xyz");
+        let limiter = UserRateLimiter::new();
+        let out = handle_public(ctx, &msg, InputStream::from_bytes(body), &limiter).await;
+        let buf = out.collect_buffered().await.unwrap();
+        let meta = |key: &str| {
+            buf.meta
+                .iter()
+                .find(|m| m.key == key)
+                .map(|m| m.value.clone())
+        };
+        let status = meta("resp.status")
+            .and_then(|s| s.parse().ok())
+            .unwrap_or(200);
+        let allow = meta("resp.header.Access-Control-Allow-Origin");
+        (status, allow, serde_json::from_slice(&buf.body).unwrap())
+    }
+
+    #[test]
+    fn origins_and_types_normalize() {
+        assert_eq!(
+            normalize_origin("HTTPS://Shop.Example:443/").as_deref(),
+            Some(SHOP)
+        );
+        assert_eq!(
+            normalize_origin("http://localhost:3000").as_deref(),
+            Some("http://localhost:3000")
+        );
+        assert!(normalize_origin("https://shop.example/upload").is_none());
+        assert!(normalize_origin("ftp://shop.example").is_none());
+        assert!(normalize_origin("null").is_none());
+
+        assert!(type_matches("image/*", "image/png"));
+        assert!(type_matches(
+            "application/pdf",
+            "Application/PDF; charset=binary"
+        ));
+        assert!(!type_matches("image/*", "text/plain"));
+        assert!(is_valid_type_pattern("image/*"));
+        assert!(!is_valid_type_pattern("*/*"));
+        assert!(!is_valid_type_pattern("image"));
+        assert_eq!(file_name("C:\\Users\\a\\cat.png"), "cat.png");
+    }
+
+    #[tokio::test]
+    async fn mint_rejects_bad_limits_per_field() {
+        let ctx = ctx_with_bucket().await;
+        let out = mint(
+            &ctx,
+            json!({
+                "bucket": "site",
+                "folder": "inbox",
+                "allowed_origins": ["https://shop.example/page"],
+                "allowed_types": ["images"],
+                "max_uploads": 0,
+                "expires_in": 10,
+            }),
+        )
+        .await;
+        let details = output_json(out).await["details"].clone();
+        for field in [
+            "folder",
+            "allowed_origins",
+            "allowed_types",
+            "max_uploads",
+            "expires_in",
+        ] {
+            assert!(details[field].is_string(), "{field}: {details}");
+        }
+        assert!(details.get("bucket").is_none());
+
+        let out = mint(&ctx, json!({"bucket": "nope", "allowed_origins": [SHOP]})).await;
+        assert_eq!(output_status(out).await, 404);
+    }
+
+    /// A session stores from its origin only, within its types and count,
+    /// and its creator sees what was stored.
+    #[tokio::test]
+    async fn widget_uploads_are_limited_and_reported() {
+        let ctx = ctx_with_bucket().await;
+        let session = output_json(
+            mint(
+                &ctx,
+                json!({
+                    "bucket": "site",
+                    "folder": "inbox/",
+                    "allowed_origins": [SHOP],
+                    "allowed_types": ["image/*"],
+                    "max_uploads": 1,
+                }),
+            )
+            .await,
+        )
+        .await;
+        let token = session["token"].as_str().unwrap();
+        let id = session["id"].as_str().unwrap();
+
+        let (status, allow, body) =
+            upload_from(&ctx, token, "https://evil.example", "a.png", "image/png").await;
+        assert_eq!(
+            (status, body["code"].as_str()),
+            (403, Some("origin_not_allowed"))
+        );
+        assert_eq!(allow.as_deref(), Some("https://evil.example"));
+
+        let (status, _, _) = upload_from(&ctx, token, SHOP, "a.txt", "text/plain").await;
+        assert_eq!(status, 415);
+        let (status, _, _) = upload_from(&ctx, "forged", SHOP, "a.png", "image/png").await;
+        assert_eq!(status, 401);
+
+        let (status, allow, stored) = upload_from(&ctx, token, SHOP, "a.png", "image/png").await;
+        assert_eq!(status, 200);
+        assert_eq!(allow.as_deref(), Some(SHOP));
+        assert_eq!(stored["key"], format!("inbox/{id}/a.png"));
+
+        let (status, _, body) = upload_from(&ctx, token, SHOP, "b.png", "image/png").await;
+        assert_eq!(
+            (status, body["code"].as_str()),
+            (409, Some("upload_limit_reached"))
+        );
+        let replay = nonces::claim(&ctx, NONCE_SCOPE, id, 1, "2999-01-01T00:00:00Z").await;
+        assert_eq!(replay.unwrap(), nonces::Claim::Reused);
+
+        let mut msg = auth_msg(
+            "retrieve",
+            &format!("/b/storage/api/widgets/upload-sessions/{id}"),
+            "alice",
+        );
+        msg.set_meta("req.param.id", id);
+        let got = output_json(handle_get_session(&ctx, &msg).await).await;
+        assert_eq!(got["upload_count"], 1);
+        assert_eq!(got["uploads"][0]["object_id"], stored["object_id"]);
+
+        let mut msg = auth_msg(
+            "retrieve",
+            &format!("/b/storage/api/widgets/upload-sessions/{id}"),
+            "bob",
+        );
+        msg.set_meta("req.param.id", id);
+        assert_eq!(
+            output_status(handle_get_session(&ctx, &msg).await).await,
+            404
+        );
+    }
+
+    /// A session pinned to a checksum stores only that content, and a
+    /// retried file it already stored answers `not_modified`.
+    #[tokio::test]
+    async fn pinned_checksum_sessions_take_only_that_content() {
+        let ctx = ctx_with_bucket().await;
+        let pinned = |sha256: String| {
+            json!({
+                "bucket": "site",
+                "allowed_origins": [SHOP],
+                "max_uploads": 1,
+                "sha256": sha256,
+            })
+        };
+        let session = output_json(mint(&ctx, pinned(checksum::digest(b"bytes"))).await).await;
+        assert_eq!(session["sha256"], checksum::digest(b"bytes").as_str());
+        let token = session["token"].as_str().unwrap();
+
+        let (status, _, stored) = upload_from(&ctx, token, SHOP, "a.png", "image/png").await;
+        assert_eq!(status, 200, "{stored}");
+        let (status, _, again) = upload_from(&ctx, token, SHOP, "a.png", "image/png").await;
+        assert_eq!(status, 200, "{again}");
+        assert_eq!(again["not_modified"], true);
+        assert_eq!(again["object_id"], stored["object_id"]);
+
+        let session = output_json(mint(&ctx, pinned(checksum::digest(b"other"))).await).await;
+        let token = session["token"].as_str().unwrap();
+        let (status, _, body) = upload_from(&ctx, token, SHOP, "b.png", "image/png").await;
+        assert_eq!(
+            (status, body["code"].as_str()),
+            (400, Some("checksum_mismatch"))
+        );
+
+        let out = mint(&ctx, pinned("abc".to_string())).await;
+        assert_eq!(
+            output_json(out).await["details"]["sha256"],
+            checksum::INVALID_CHECKSUM
+        );
+    }
+}
@@ -0,0 +1,846 @@
+//! Persistent background jobs: "run this later, retry on failure".
+//!
+//! A block enqueues a job through [`crate::services::Services::jobs`]. The
+//! row in `suppers_ai__admin__jobs` records the job type, a JSON payload
+//! and the enqueuing block, which is also the block that runs it: the
+//! runner sends that block a [`RUN_KIND`] message carrying the type in
+//! [`META_JOB_TYPE`] and the payload as the body, and the block answers
+//! through [`respond`]:
+//!
+//! ```ignore
+//! if msg.kind == jobs::RUN_KIND {
+//!     return match jobs::job_type(&msg) {
+//!         quota::NOTIFY_JOB => jobs::respond(quota::run_notify_job(ctx, input).await),
+//!         _ => jobs::unknown_type(&msg),
+//!     };
+//! }
+//! ```
+//!
+//! # Handler contract
+//!
+//! Delivery is **at least once**. A job whose process dies mid-run is
+//! handed out again once its lease ([`LEASE_SECS`]) expires, and a run
+//! that did its work but failed to report success is retried. Handlers
+//! must be idempotent — check whether the work is already done before
+//! doing it again.
+//!
+//! A failed run is retried with exponential backoff ([`backoff_after`])
+//! until `max_attempts`, then parked as `dead` for an admin to inspect and
+//! retry (`/b/admin/api/jobs`). [`JobError::permanent`] skips the retries.
+//!
+//! Recurring work is a job too: [`super::schedules`] queues one each time a
+//! cron schedule comes due. Work a user waits on is started as an
+//! operation ([`super::operations`]), which reports its job's progress and
+//! outcome back to them.
+//!
+//! # Where jobs run
+//!
+//! Native servers run [`run_worker`] next to the HTTP listener: it polls
+//! for due jobs and runs up to `SUPPERS_AI__ADMIN__JOB_CONCURRENCY` of
+//! them at once. Jobs survive restarts: pending ones are picked up on the
+//! first poll, and ones cut off mid-run once their lease expires. Cloudflare Workers and the browser build have no long-lived
+//! task, so there jobs are drained opportunistically instead — a few after
+//! each enqueue, and on demand from the admin API — like the email outbox.
+
+use std::{
+    collections::HashMap,
+    sync::atomic::{AtomicBool, Ordering},
+};
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{
+    context::Context, streams::output::TerminalNotResponse, ErrorCode, InputStream, Message,
+    OutputStream, WaferError,
+};
+
+use super::admin::JOBS_TABLE;
+use crate::{
+    http::{err_bad_request, err_internal_no_cause, ok_json},
+    util::RecordExt,
+};
+
+/// Message kind the runner sends to a job's owning block.
+pub const RUN_KIND: &str = "jobs.run";
+/// Meta key carrying the job type on a [`RUN_KIND`] message.
+pub const META_JOB_TYPE: &str = "job.type";
+/// Meta key carrying the job id on a [`RUN_KIND`] message.
+pub const META_JOB_ID: &str = "job.id";
+/// Meta key carrying the 1-based attempt number on a [`RUN_KIND`] message.
+pub const META_JOB_ATTEMPT: &str = "job.attempt";
+
+pub const STATUS_PENDING: &str = "pending";
+pub const STATUS_RUNNING: &str = "running";
+pub const STATUS_SUCCEEDED: &str = "succeeded";
+pub const STATUS_DEAD: &str = "dead";
+
+/// Attempts before a failing job is parked as `dead`.
+pub const DEFAULT_MAX_ATTEMPTS: i64 = 5;
+/// Delay before the first retry; doubled for each retry after it.
+const BACKOFF_BASE_SECS: i64 = 30;
+/// Longest delay between two attempts.
+const BACKOFF_MAX_SECS: i64 = 3600;
+/// How long a `running` job may go without finishing before it counts as
+/// abandoned (its process died) and is handed out again.
+pub const LEASE_SECS: i64 = 900;
+/// Due jobs drained after each enqueue when no worker is running.
+const DRAIN_BATCH: i64 = 3;
+
+/// Config key for how many jobs the native worker runs at once.
+pub const CONCURRENCY_KEY: &str = "SUPPERS_AI__ADMIN__JOB_CONCURRENCY";
+pub const DEFAULT_CONCURRENCY: &str = "4";
+/// Seconds between worker polls for due jobs.
+#[cfg(not(target_arch = "wasm32"))]
+const POLL_SECS: u64 = 2;
+
+/// The admin block's context, captured at its `Init` for [`run_worker`].
+#[cfg(not(target_arch = "wasm32"))]
+static WORKER_CTX: std::sync::OnceLock<std::sync::Arc<dyn Context>> = std::sync::OnceLock::new();
+/// Set while [`run_worker`] is polling, so enqueues leave the work to it.
+static WORKER_RUNNING: AtomicBool = AtomicBool::new(false);
+
+/// Why a job run failed.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct JobError {
+    pub message: String,
+    /// No point retrying (bad payload, target gone): go straight to `dead`.
+    pub permanent: bool,
+}
+
+impl JobError {
+    /// A failure worth retrying later.
+    pub fn transient(message: impl Into<String>) -> Self {
+        Self {
+            message: message.into(),
+            permanent: false,
+        }
+    }
+
+    /// A failure that a retry cannot fix.
+    pub fn permanent(message: impl Into<String>) -> Self {
+        Self {
+            message: message.into(),
+            permanent: true,
+        }
+    }
+}
+
+impl From<WaferError> for JobError {
+    fn from(e: WaferError) -> Self {
+        JobError::transient(e.message)
+    }
+}
+
+/// When and how often to run a job.
+#[derive(Debug, Clone, Default)]
+pub struct EnqueueOptions {
+    /// Run no earlier than this long from now.
+    pub delay: Option<chrono::Duration>,
+    /// Attempts before the job is parked as `dead`
+    /// (default [`DEFAULT_MAX_ATTEMPTS`]).
+    pub max_attempts: Option<i64>,
+}
+
+/// A stored job.
+#[derive(Debug, Clone, PartialEq, serde::Serialize)]
+pub struct Job {
+    pub id: String,
+    pub job_type: String,
+    /// The block that enqueued the job and runs it.
+    pub block: String,
+    pub payload: serde_json::Value,
+    pub status: String,
+    pub attempts: i64,
+    pub max_attempts: i64,
+    pub next_run_at: String,
+    pub started_at: String,
+    pub finished_at: String,
+    pub last_error: String,
+    pub created_at: String,
+    pub updated_at: String,
+}
+
+impl Job {
+    fn from_record(r: &Record) -> Self {
+        Self {
+            id: r.id.clone(),
+            job_type: r.str_field("job_type").to_string(),
+            block: r.str_field("block").to_string(),
+            payload: serde_json::from_str(r.str_field("payload"))
+                .unwrap_or(serde_json::Value::Null),
+            status: r.str_field("status").to_string(),
+            attempts: r.i64_field("attempts"),
+            max_attempts: r.i64_field("max_attempts"),
+            next_run_at: r.str_field("next_run_at").to_string(),
+            started_at: r.str_field("started_at").to_string(),
+            finished_at: r.str_field("finished_at").to_string(),
+            last_error: r.str_field("last_error").to_string(),
+            created_at: r.str_field("created_at").to_string(),
+            updated_at: r.str_field("updated_at").to_string(),
+        }
+    }
+}
+
+/// Delay before the retry that follows attempt `attempt` (1-based):
+/// 30s, 1m, 2m, … capped at an hour.
+pub fn backoff_after(attempt: i64) -> chrono::Duration {
+    let doublings = (attempt.max(1) - 1).min(20) as u32;
+    let secs = BACKOFF_BASE_SECS
+        .saturating_mul(2_i64.saturating_pow(doublings))
+        .min(BACKOFF_MAX_SECS);
+    chrono::Duration::seconds(secs)
+}
+
+fn eq(field: &str, value: impl Into<serde_json::Value>) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: value.into(),
+    }
+}
+
+fn timestamp(at: chrono::DateTime<chrono::Utc>) -> String {
+    crate::util::format_rfc3339(at)
+}
+
+/// Queue a `job_type` job owned by `block`. When no worker is running the
+/// caller also drains a few due jobs. Returns the job id.
+pub async fn enqueue(
+    ctx: &dyn Context,
+    block: &str,
+    job_type: &str,
+    payload: &serde_json::Value,
+    opts: EnqueueOptions,
+) -> Result<String, WaferError> {
+    let now = crate::clock::now();
+    let run_at = now + opts.delay.unwrap_or_else(chrono::Duration::zero);
+    let data = crate::util::json_map(serde_json::json!({
+        "job_type": job_type,
+        "block": block,
+        "payload": payload.to_string(),
+        "status": STATUS_PENDING,
+        "attempts": 0,
+        "max_attempts": opts.max_attempts.unwrap_or(DEFAULT_MAX_ATTEMPTS).max(1),
+        "next_run_at": timestamp(run_at),
+        "created_at": timestamp(now),
+        "updated_at": timestamp(now),
+    }));
+    let row = db::create(ctx, JOBS_TABLE, data).await?;
+    if !WORKER_RUNNING.load(Ordering::Relaxed) {
+        run_due(ctx, DRAIN_BATCH).await;
+    }
+    Ok(row.id)
+}
+
+/// Hand abandoned `running` jobs (lease expired) back to the queue.
+async fn requeue_abandoned(ctx: &dyn Context) -> Result<i64, WaferError> {
+    let cutoff = timestamp(crate::clock::now() - chrono::Duration::seconds(LEASE_SECS));
+    let mut data = HashMap::new();
+    data.insert("status".to_string(), serde_json::json!(STATUS_PENDING));
+    data.insert(
+        "last_error".to_string(),
+        serde_json::json!("lease expired while running"),
+    );
+    data.insert(
+        "updated_at".to_string(),
+        serde_json::json!(crate::util::now_rfc3339()),
+    );
+    db::update_by_filters_count(
+        ctx,
+        JOBS_TABLE,
+        vec![
+            eq("status", STATUS_RUNNING),
+            Filter {
+                field: "started_at".to_string(),
+                operator: FilterOp::LessEqual,
+                value: serde_json::json!(cutoff),
+            },
+        ],
+        data,
+    )
+    .await
+}
+
+async fn due_jobs(ctx: &dyn Context, limit: i64) -> Result<Vec<Job>, WaferError> {
+    let mut filters = vec![
+        eq("status", STATUS_PENDING),
+        Filter {
+            field: "next_run_at".to_string(),
+            operator: FilterOp::LessEqual,
+            value: serde_json::json!(timestamp(crate::clock::now())),
+        },
+    ];
+    // Jobs for a disabled block stay pending until it is enabled again.
+    for block in crate::features::disabled_now(ctx) {
+        filters.push(Filter {
+            field: "block".to_string(),
+            operator: FilterOp::NotEqual,
+            value: serde_json::json!(block),
+        });
+    }
+    let opts = ListOptions {
+        filters,
+        sort: vec![SortField {
+            field: "next_run_at".to_string(),
+            desc: false,
+        }],
+        limit,
+        ..Default::default()
+    };
+    let list = db::list(ctx, JOBS_TABLE, &opts).await?;
+    Ok(list.records.iter().map(Job::from_record).collect())
+}
+
+/// Move `job` from `pending` to `running`. `false` when another runner got
+/// there first.
+async fn claim(ctx: &dyn Context, job: &Job) -> Result<bool, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let mut data = HashMap::new();
+    data.insert("status".to_string(), serde_json::json!(STATUS_RUNNING));
+    data.insert("attempts".to_string(), serde_json::json!(job.attempts + 1));
+    data.insert("started_at".to_string(), serde_json::json!(&now));
+    data.insert("updated_at".to_string(), serde_json::json!(&now));
+    let claimed = db::update_by_filters_count(
+        ctx,
+        JOBS_TABLE,
+        vec![
+            eq("id", job.id.as_str()),
+            eq("status", STATUS_PENDING),
+            eq("attempts", job.attempts),
+        ],
+        data,
+    )
+    .await?;
+    Ok(claimed == 1)
+}
+
+/// Send `job` to its owning block and read back the outcome.
+async fn dispatch(ctx: &dyn Context, job: &Job, attempt: i64) -> Result<(), JobError> {
+    let mut msg = Message {
+        kind: RUN_KIND.to_string(),
+        meta: Vec::new(),
+    };
+    msg.set_meta(META_JOB_TYPE, job.job_type.as_str());
+    msg.set_meta(META_JOB_ID, job.id.as_str());
+    msg.set_meta(META_JOB_ATTEMPT, attempt.to_string());
+    let body = serde_json::to_vec(&job.payload).unwrap_or_default();
+    let out = ctx
+        .call_block(&job.block, msg, InputStream::from_bytes(body))
+        .await;
+    match out.collect_buffered().await {
+        Ok(_) => Ok(()),
+        Err(TerminalNotResponse::Error(e)) if e.code == ErrorCode::InvalidArgument => {
+            Err(JobError::permanent(e.message))
+        }
+        Err(TerminalNotResponse::Error(e)) => Err(JobError::transient(e.message)),
+        Err(other) => Err(JobError::transient(format!(
+            "job handler returned {other:?}"
+        ))),
+    }
+}
+
+/// Record the outcome of attempt `attempt` of `job`, and of the operation
+/// it runs, if any.
+async fn finish(ctx: &dyn Context, job: &Job, attempt: i64, result: &Result<(), JobError>) {
+    let now = crate::clock::now();
+    let mut data = HashMap::new();
+    data.insert("updated_at".to_string(), serde_json::json!(timestamp(now)));
+    let retried = matches!(result, Err(e) if !e.permanent && attempt < job.max_attempts);
+    match result {
+        Ok(()) => {
+            data.insert("status".to_string(), serde_json::json!(STATUS_SUCCEEDED));
+            data.insert("finished_at".to_string(), serde_json::json!(timestamp(now)));
+            data.insert("last_error".to_string(), serde_json::json!(""));
+        }
+        Err(e) if !e.permanent && attempt < job.max_attempts => {
+            data.insert("status".to_string(), serde_json::json!(STATUS_PENDING));
+            data.insert(
+                "next_run_at".to_string(),
+                serde_json::json!(timestamp(now + backoff_after(attempt))),
+            );
+            data.insert("last_error".to_string(), serde_json::json!(e.message));
+        }
+        Err(e) => {
+            data.insert("status".to_string(), serde_json::json!(STATUS_DEAD));
+            data.insert("finished_at".to_string(), serde_json::json!(timestamp(now)));
+            data.insert("last_error".to_string(), serde_json::json!(e.message));
+            tracing::warn!(
+                job_id = %job.id,
+                job_type = %job.job_type,
+                attempt,
+                error = %e.message,
+                "job moved to dead-letter"
+            );
+        }
+    }
+    if let Err(e) = db::update(ctx, JOBS_TABLE, &job.id, data).await {
+        tracing::warn!(job_id = %job.id, error = %e, "failed to record job outcome");
+    }
+    super::operations::after_job_run(ctx, &job.payload, result, retried).await;
+}
+
+/// Claim and run one job. Returns whether it ran and succeeded.
+async fn run_one(ctx: &dyn Context, job: Job) -> bool {
+    match claim(ctx, &job).await {
+        Ok(true) => {}
+        Ok(false) => return false,
+        Err(e) => {
+            tracing::warn!(job_id = %job.id, error = %e, "failed to claim job");
+            return false;
+        }
+    }
+    let attempt = job.attempts + 1;
+    let result = dispatch(ctx, &job, attempt).await;
+    if let Err(e) = &result {
+        tracing::warn!(
+            job_id = %job.id,
+            job_type = %job.job_type,
+            attempt,
+            error = %e.message,
+            "job failed"
+        );
+    }
+    finish(ctx, &job, attempt, &result).await;
+    result.is_ok()
+}
+
+/// Run up to `limit` due jobs concurrently. Returns how many succeeded.
+pub async fn run_due(ctx: &dyn Context, limit: i64) -> usize {
+    if let Err(e) = requeue_abandoned(ctx).await {
+        tracing::warn!(error = %e, "failed to requeue abandoned jobs");
+    }
+    let due = match due_jobs(ctx, limit).await {
+        Ok(due) => due,
+        Err(e) => {
+            tracing::warn!(error = %e, "failed to list due jobs");
+            return 0;
+        }
+    };
+    futures::future::join_all(due.into_iter().map(|job| run_one(ctx, job)))
+        .await
+        .into_iter()
+        .filter(|ok| *ok)
+        .count()
+}
+
+#[cfg(not(target_arch = "wasm32"))]
+async fn concurrency(ctx: &dyn Context) -> i64 {
+    wafer_core::clients::config::get_default(ctx, CONCURRENCY_KEY, DEFAULT_CONCURRENCY)
+        .await
+        .trim()
+        .parse::<i64>()
+        .ok()
+        .filter(|n| *n > 0)
+        .unwrap_or(4)
+}
+
+/// Capture the context [`run_worker`] runs jobs with. Called from the
+/// admin block's `Init`, after its migrations created the jobs table.
+#[cfg(not(target_arch = "wasm32"))]
+pub fn set_worker_context(ctx: &dyn Context) {
+    let _ = WORKER_CTX.set(ctx.clone_arc());
+}
+
+/// The context captured by [`set_worker_context`], for other native
+/// background tasks; `None` until the admin block has initialized.
+#[cfg(not(target_arch = "wasm32"))]
+pub fn worker_context() -> Option<&'static dyn Context> {
+    WORKER_CTX.get().map(|ctx| ctx.as_ref())
+}
+
+/// Poll for due jobs until the process exits; never returns. Native
+/// servers run this alongside the HTTP listener once the runtime has
+/// started; `sleep` is
+/// the platform timer, as for [`crate::pipeline::set_request_timer`].
+#[cfg(not(target_arch = "wasm32"))]
+pub async fn run_worker(sleep: crate::pipeline::RequestTimer) {
+    let Some(ctx) = WORKER_CTX.get() else {
+        // Never resolve: callers race this against the listener.
+        tracing::warn!("job worker not started: admin block was not initialized");
+        return std::future::pending().await;
+    };
+    let ctx = ctx.as_ref();
+    WORKER_RUNNING.store(true, Ordering::Relaxed);
+    tracing::info!("job worker started");
+    loop {
+        let limit = concurrency(ctx).await;
+        // A full batch means more may be waiting: poll again right away.
+        let ran = run_due(ctx, limit).await;
+        super::schedules::run_if_due(ctx).await;
+        crate::version::check_if_due(ctx).await;
+        if (ran as i64) < limit {
+            sleep(std::time::Duration::from_secs(POLL_SECS)).await;
+        }
+    }
+}
+
+/// The job type of a [`RUN_KIND`] message.
+pub fn job_type(msg: &Message) -> &str {
+    msg.get_meta(META_JOB_TYPE)
+}
+
+/// Answer a [`RUN_KIND`] message with a handler's outcome. A permanent
+/// error is sent as `InvalidArgument`, which the runner does not retry.
+pub fn respond(result: Result<(), JobError>) -> OutputStream {
+    match result {
+        Ok(()) => ok_json(&serde_json::json!({ "ok": true })),
+        Err(e) if e.permanent => err_bad_request(&e.message),
+        Err(e) => err_internal_no_cause(&e.message),
+    }
+}
+
+/// Answer a [`RUN_KIND`] message for a job type the block does not handle.
+pub fn unknown_type(msg: &Message) -> OutputStream {
+    err_bad_request(&format!("unknown job type: {}", job_type(msg)))
+}
+
+/// The `limit` most recent jobs, newest first, optionally narrowed to one
+/// `status` and/or `job_type`.
+pub async fn recent(
+    ctx: &dyn Context,
+    status: Option<&str>,
+    job_type: Option<&str>,
+    limit: i64,
+) -> Result<Vec<Job>, WaferError> {
+    let mut filters = Vec::new();
+    if let Some(status) = status {
+        filters.push(eq("status", status));
+    }
+    if let Some(job_type) = job_type {
+        filters.push(eq("job_type", job_type));
+    }
+    let opts = ListOptions {
+        filters,
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit,
+        ..Default::default()
+    };
+    let list = db::list(ctx, JOBS_TABLE, &opts).await?;
+    Ok(list.records.iter().map(Job::from_record).collect())
+}
+
+/// Job `id`, or `None` if there is no such job.
+pub async fn get(ctx: &dyn Context, id: &str) -> Result<Option<Job>, WaferError> {
+    match db::get(ctx, JOBS_TABLE, id).await {
+        Ok(row) => Ok(Some(Job::from_record(&row))),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// How many jobs are `(pending, running)`; pending ones include those
+/// waiting out a retry backoff.
+pub async fn counts(ctx: &dyn Context) -> Result<(i64, i64), WaferError> {
+    let pending = db::count(ctx, JOBS_TABLE, &[eq("status", STATUS_PENDING)]).await?;
+    let running = db::count(ctx, JOBS_TABLE, &[eq("status", STATUS_RUNNING)]).await?;
+    Ok((pending, running))
+}
+
+/// Why [`retry`] did not requeue a job.
+#[derive(Debug)]
+pub enum RetryError {
+    NotFound,
+    NotDead,
+    Db(WaferError),
+}
+
+/// Requeue a `dead` job with a fresh attempt budget, due now.
+pub async fn retry(ctx: &dyn Context, id: &str) -> Result<Job, RetryError> {
+    let row = match db::get(ctx, JOBS_TABLE, id).await {
+        Ok(row) => row,
+        Err(e) if e.code == ErrorCode::NotFound => return Err(RetryError::NotFound),
+        Err(e) => return Err(RetryError::Db(e)),
+    };
+    if row.str_field("status") != STATUS_DEAD {
+        return Err(RetryError::NotDead);
+    }
+    let now = crate::util::now_rfc3339();
+    let mut data = HashMap::new();
+    data.insert("status".to_string(), serde_json::json!(STATUS_PENDING));
+    data.insert("attempts".to_string(), serde_json::json!(0));
+    data.insert("next_run_at".to_string(), serde_json::json!(&now));
+    data.insert("finished_at".to_string(), serde_json::json!(""));
+    data.insert("updated_at".to_string(), serde_json::json!(&now));
+    db::update(ctx, JOBS_TABLE, id, data)
+        .await
+        .map(|r| Job::from_record(&r))
+        .map_err(RetryError::Db)
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::{
+        atomic::{AtomicUsize, Ordering},
+        Arc,
+    };
+
+    use wafer_run::{Block, BlockInfo, LifecycleEvent};
+
+    use super::*;
+    use crate::test_support::TestContext;
+
+    /// Stands in for a block that runs jobs: fails `fail_times` runs
+    /// (transiently), then succeeds; `bad-payload` jobs fail permanently.
+    struct Worker {
+        runs: AtomicUsize,
+        fail_times: usize,
+    }
+
+    #[async_trait::async_trait]
+    impl Block for Worker {
+        fn info(&self) -> BlockInfo {
+            BlockInfo::new("test/worker", "0.0.1", "http-handler@v1", "jobs")
+        }
+        async fn handle(
+            &self,
+            _ctx: &dyn Context,
+            msg: Message,
+            input: InputStream,
+        ) -> OutputStream {
+            assert_eq!(msg.kind, RUN_KIND);
+            let payload: serde_json::Value =
+                serde_json::from_slice(&input.collect_to_bytes().await).unwrap();
+            match job_type(&msg) {
+                "test.flaky" => {
+                    let n = self.runs.fetch_add(1, Ordering::SeqCst);
+                    assert_eq!(payload["n"], 7);
+                    if n < self.fail_times {
+                        respond(Err(JobError::transient("not yet")))
+                    } else {
+                        respond(Ok(()))
+                    }
+                }
+                "test.bad-payload" => respond(Err(JobError::permanent("bad payload"))),
+                _ => unknown_type(&msg),
+            }
+        }
+        async fn lifecycle(
+            &self,
+            _ctx: &dyn Context,
+            _e: LifecycleEvent,
+        ) -> Result<(), WaferError> {
+            Ok(())
+        }
+    }
+
+    async fn ctx_with_worker(fail_times: usize) -> (TestContext, Arc<Worker>) {
+        let mut ctx = TestContext::with_admin().await;
+        let worker = Arc::new(Worker {
+            runs: AtomicUsize::new(0),
+            fail_times,
+        });
+        ctx.register_block("test/worker", worker.clone());
+        (ctx, worker)
+    }
+
+    async fn job(ctx: &TestContext, id: &str) -> Job {
+        Job::from_record(&db::get(ctx, JOBS_TABLE, id).await.unwrap())
+    }
+
+    /// Make a pending job due now, skipping its backoff.
+    async fn make_due(ctx: &TestContext, id: &str) {
+        let mut data = HashMap::new();
+        data.insert(
+            "next_run_at".to_string(),
+            serde_json::json!(crate::util::now_rfc3339()),
+        );
+        db::update(ctx, JOBS_TABLE, id, data).await.unwrap();
+    }
+
+    #[test]
+    fn backoff_doubles_up_to_an_hour() {
+        let secs: Vec<i64> = (1..=9).map(|n| backoff_after(n).num_seconds()).collect();
+        assert_eq!(secs, [30, 60, 120, 240, 480, 960, 1920, 3600, 3600]);
+        assert_eq!(backoff_after(0).num_seconds(), 30);
+        assert_eq!(backoff_after(i64::MAX).num_seconds(), 3600);
+    }
+
+    #[tokio::test]
+    async fn enqueue_runs_the_job_on_its_block() {
+        let (ctx, worker) = ctx_with_worker(0).await;
+        let id = enqueue(
+            &ctx,
+            "test/worker",
+            "test.flaky",
+            &serde_json::json!({ "n": 7 }),
+            EnqueueOptions::default(),
+        )
+        .await
+        .unwrap();
+
+        let done = job(&ctx, &id).await;
+        assert_eq!(done.status, STATUS_SUCCEEDED);
+        assert_eq!(done.attempts, 1);
+        assert!(!done.finished_at.is_empty());
+        assert_eq!(worker.runs.load(Ordering::SeqCst), 1);
+    }
+
+    #[tokio::test]
+    async fn delayed_job_waits_until_due() {
+        let (ctx, worker) = ctx_with_worker(0).await;
+        let opts = EnqueueOptions {
+            delay: Some(chrono::Duration::minutes(5)),
+            ..Default::default()
+        };
+        let id = enqueue(
+            &ctx,
+            "test/worker",
+            "test.flaky",
+            &serde_json::json!({ "n": 7 }),
+            opts,
+        )
+        .await
+        .unwrap();
+        assert_eq!(job(&ctx, &id).await.status, STATUS_PENDING);
+        assert_eq!(run_due(&ctx, 10).await, 0);
+        assert_eq!(worker.runs.load(Ordering::SeqCst), 0);
+
+        make_due(&ctx, &id).await;
+        assert_eq!(run_due(&ctx, 10).await, 1);
+        assert_eq!(job(&ctx, &id).await.status, STATUS_SUCCEEDED);
+    }
+
+    #[tokio::test]
+    async fn jobs_for_a_disabled_block_stay_pending() {
+        let (mut ctx, worker) = ctx_with_worker(0).await;
+        ctx.set_config(
+            crate::features::BLOCK_SETTINGS_CONFIG_KEY,
+            r#"{"test/worker":{"enabled":false}}"#,
+        );
+        let id = enqueue(
+            &ctx,
+            "test/worker",
+            "test.flaky",
+            &serde_json::json!({ "n": 7 }),
+            EnqueueOptions::default(),
+        )
+        .await
+        .unwrap();
+        assert_eq!(run_due(&ctx, 10).await, 0);
+        let waiting = job(&ctx, &id).await;
+        assert_eq!(waiting.status, STATUS_PENDING);
+        assert_eq!(waiting.attempts, 0);
+        assert_eq!(worker.runs.load(Ordering::SeqCst), 0);
+
+        ctx.set_config(crate::features::BLOCK_SETTINGS_CONFIG_KEY, "{}");
+        assert_eq!(run_due(&ctx, 10).await, 1);
+        assert_eq!(job(&ctx, &id).await.status, STATUS_SUCCEEDED);
+    }
+
+    #[tokio::test]
+    async fn failures_back_off_then_dead_letter_and_retry_requeues() {
+        let (ctx, worker) = ctx_with_worker(usize::MAX).await;
+        let opts = EnqueueOptions {
+            max_attempts: Some(2),
+            ..Default::default()
+        };
+        let id = enqueue(
+            &ctx,
+            "test/worker",
+            "test.flaky",
+            &serde_json::json!({ "n": 7 }),
+            opts,
+        )
+        .await
+        .unwrap();
+
+        let first = job(&ctx, &id).await;
+        assert_eq!(first.status, STATUS_PENDING);
+        assert_eq!(first.attempts, 1);
+        assert_eq!(first.last_error, "not yet");
+        assert!(first.next_run_at > crate::util::now_rfc3339());
+
+        make_due(&ctx, &id).await;
+        run_due(&ctx, 10).await;
+        let dead = job(&ctx, &id).await;
+        assert_eq!(dead.status, STATUS_DEAD);
+        assert_eq!(dead.attempts, 2);
+        assert_eq!(worker.runs.load(Ordering::SeqCst), 2);
+
+        // A dead job stays put until an admin retries it.
+        assert_eq!(run_due(&ctx, 10).await, 0);
+        let retried = retry(&ctx, &id).await.unwrap();
+        assert_eq!(retried.status, STATUS_PENDING);
+        assert_eq!(retried.attempts, 0);
+        assert!(matches!(retry(&ctx, &id).await, Err(RetryError::NotDead)));
+        assert!(matches!(
+            retry(&ctx, "missing").await,
+            Err(RetryError::NotFound)
+        ));
+    }
+
+    #[tokio::test]
+    async fn permanent_failures_and_unknown_types_skip_retries() {
+        let (ctx, _) = ctx_with_worker(0).await;
+        for job_type in ["test.bad-payload", "test.unknown"] {
+            let id = enqueue(
+                &ctx,
+                "test/worker",
+                job_type,
+                &serde_json::json!({}),
+                EnqueueOptions::default(),
+            )
+            .await
+            .unwrap();
+            let dead = job(&ctx, &id).await;
+            assert_eq!(dead.status, STATUS_DEAD, "{job_type}");
+            assert_eq!(dead.attempts, 1, "{job_type}");
+        }
+    }
+
+    #[tokio::test]
+    async fn abandoned_running_jobs_are_picked_up_again() {
+        let (ctx, worker) = ctx_with_worker(0).await;
+        // A job claimed by a process that died before finishing it.
+        let stale = timestamp(crate::clock::now() - chrono::Duration::seconds(LEASE_SECS + 60));
+        let row = db::create(
+            &ctx,
+            JOBS_TABLE,
+            crate::util::json_map(serde_json::json!({
+                "job_type": "test.flaky",
+                "block": "test/worker",
+                "payload": "{\"n\":7}",
+                "status": STATUS_RUNNING,
+                "attempts": 1,
+                "max_attempts": 5,
+                "next_run_at": &stale,
+                "started_at": &stale,
+                "created_at": &stale,
+                "updated_at": &stale,
+            })),
+        )
+        .await
+        .unwrap();
+
+        assert_eq!(run_due(&ctx, 10).await, 1);
+        let done = job(&ctx, &row.id).await;
+        assert_eq!(done.status, STATUS_SUCCEEDED);
+        assert_eq!(done.attempts, 2);
+        assert_eq!(worker.runs.load(Ordering::SeqCst), 1);
+    }
+
+    #[tokio::test]
+    async fn recent_filters_by_status_and_type() {
+        let (ctx, _) = ctx_with_worker(0).await;
+        for job_type in ["test.flaky", "test.bad-payload"] {
+            enqueue(
+                &ctx,
+                "test/worker",
+                job_type,
+                &serde_json::json!({ "n": 7 }),
+                EnqueueOptions::default(),
+            )
+            .await
+            .unwrap();
+        }
+        assert_eq!(recent(&ctx, None, None, 10).await.unwrap().len(), 2);
+        let dead = recent(&ctx, Some(STATUS_DEAD), None, 10).await.unwrap();
+        assert_eq!(dead.len(), 1);
+        assert_eq!(dead[0].job_type, "test.bad-payload");
+        let flaky = recent(&ctx, None, Some("test.flaky"), 10).await.unwrap();
+        assert_eq!(flaky[0].status, STATUS_SUCCEEDED);
+    }
+}
@@ -111,6 +111,13 @@ const ROUTES: &[EndpointRoute<Route>] = &[
     EndpointRoute::new(HttpMethod::Post, "/b/llm/api/config", Route::PostConfig),
 ];
 
+/// Usage-quota tags (see [`crate::blocks::api_quota`]). Both chat routes
+/// spend one provider completion, so they count against one tag.
+pub(crate) const QUOTA_ROUTES: &[EndpointRoute<&str>] = &[
+    EndpointRoute::new(HttpMethod::Post, "/b/llm/api/chat", "llm.chat"),
+    EndpointRoute::new(HttpMethod::Post, "/b/llm/api/chat/stream", "llm.chat"),
+];
+
 /// LLM feature block. Owns the provider admin UI + chat thread persistence.
 ///
 /// Chat requests go through `ctx.call_block("wafer-run/llm", ...)` — the
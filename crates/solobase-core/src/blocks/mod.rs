@@ -1,11 +1,16 @@
 pub mod admin;
+pub mod announcements;
+pub mod api_quota;
 pub mod auth;
 pub mod auth_ui;
 pub mod crud;
+pub mod declarative;
+pub mod directory;
 pub mod email;
 pub mod errors;
 #[macro_use]
 pub mod feature_block;
+pub mod feature_flags;
 // `native-embedding` always implies `block-fastembed` (see Cargo.toml), so
 // the native build still gets this module. wafer-site / wasm32 builds with
 // neither feature drop the ONNX-runtime dep entirely.
@@ -13,6 +18,7 @@ pub mod feature_block;
 pub mod fastembed;
 #[cfg(feature = "block-files")]
 pub mod files;
+pub mod jobs;
 #[cfg(feature = "block-legalpages")]
 pub mod legalpages;
 // The LLM feature block compiles on every target that enables `block-llm`,
@@ -29,10 +35,16 @@ pub mod legalpages;
 pub mod llm;
 #[cfg(feature = "block-messages")]
 pub mod messages;
+pub mod nonces;
+pub mod notifications;
+pub mod operations;
+pub mod permissions;
 #[cfg(feature = "block-products")]
 pub mod products;
 pub mod rate_limit;
+pub mod retention;
 pub mod router;
+pub mod schedules;
 pub mod storage;
 pub mod system;
 #[cfg(target_arch = "wasm32")]
@@ -155,6 +167,28 @@ feature_block_manifest! {
     fastembed::FastembedBlock,
 }
 
+/// Whether the block named `name` is part of this build. False only for a
+/// feature block whose `block-*` Cargo feature is off (a minimal build, see
+/// docs/minimal-build.md); core blocks, extensions and unknown names count as
+/// present, since the runtime decides those.
+///
+/// The route table is static and lists every feature block's prefix, so
+/// routing checks this to answer 404 for a block that was never compiled in
+/// rather than dispatching to a name nothing registered.
+pub fn is_compiled_in(name: &str) -> bool {
+    match name {
+        "suppers-ai/files" => cfg!(feature = "block-files"),
+        "suppers-ai/legalpages" => cfg!(feature = "block-legalpages"),
+        "suppers-ai/llm" => cfg!(feature = "block-llm"),
+        "suppers-ai/messages" => cfg!(feature = "block-messages"),
+        "suppers-ai/products" => cfg!(feature = "block-products"),
+        "suppers-ai/userportal" => cfg!(feature = "block-userportal"),
+        "suppers-ai/vector" => cfg!(feature = "block-vector"),
+        "suppers-ai/fastembed" => cfg!(feature = "block-fastembed"),
+        _ => true,
+    }
+}
+
 /// Register the LLM feature block with the WAFER runtime.
 ///
 /// LlmBlock is not in the feature-block manifest because its constructor takes
@@ -0,0 +1,217 @@
+//! Replay protection for signed requests: a store of spent nonces.
+//!
+//! A signature proves who made a request, not that it is the first time
+//! anyone sent it; a captured request replays just as well. A feature that
+//! must act once per request puts a nonce in what it signs and calls
+//! [`claim`] before acting. The first claim of a nonce succeeds and every
+//! later one answers [`Claim::Reused`] until the nonce expires. A nonce may
+//! also be allowed a few uses (`max_uses`), counted in the same row.
+//!
+//! Rows live in `suppers_ai__admin__used_nonces`, unique per `(scope,
+//! nonce)`, so the check and the record are one insert: two concurrent
+//! claims of a fresh nonce can't both win. Further uses are counted with a
+//! compare-and-set on `uses`. The store stays bounded because each nonce
+//! only needs to outlive what it signs — a caller sets `expires_at` to
+//! when the signature stops being accepted anyway (a token's expiry, a
+//! timestamp's tolerance) — and the `expired_tokens` policy of
+//! [`super::retention`] deletes rows past it. An expired row still present
+//! is reused as if it were gone.
+//!
+//! The store is the database, so instances sharing one database share it.
+//! Instances that each have their own database (separate SQLite files
+//! behind one load balancer) do not: a request replayed to another
+//! instance is accepted there once.
+//!
+//! Users today:
+//! - inbound webhook endpoints with `single_delivery` set, by delivery id;
+//! - single-use widget upload sessions (`max_uploads` of 1), by the
+//!   session id their token signs;
+//! - share links minted with `max_uses`, by a nonce their token signs.
+//!   Other links stay multi-use; `max_access_count` on the share row still
+//!   caps them.
+
+use std::collections::HashMap;
+
+use wafer_block::db::{Filter, FilterOp, ListOptions};
+use wafer_core::clients::database as db;
+use wafer_run::{context::Context, WaferError};
+
+use super::{admin::USED_NONCES_TABLE, errors::is_unique_violation};
+use crate::util::RecordExt;
+
+/// Longest accepted scope or nonce, in bytes.
+pub const MAX_NONCE_LEN: usize = 200;
+
+/// Insert-or-update attempts before a contended claim gives up.
+const MAX_ATTEMPTS: usize = 3;
+
+/// What [`claim`] found.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Claim {
+    /// First use (or a use within `max_uses`); go ahead.
+    Accepted,
+    /// The nonce was already spent; refuse the request.
+    Reused,
+}
+
+fn eq(field: &str, value: impl Into<serde_json::Value>) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: value.into(),
+    }
+}
+
+/// Spend one use of `nonce` within `scope` (e.g. `webhook:{endpoint id}`),
+/// allowing `max_uses` in all (at least one) until `expires_at` (RFC 3339).
+pub async fn claim(
+    ctx: &dyn Context,
+    scope: &str,
+    nonce: &str,
+    max_uses: i64,
+    expires_at: &str,
+) -> Result<Claim, WaferError> {
+    let max_uses = max_uses.max(1);
+    let now = crate::util::now_rfc3339();
+    let row = crate::util::json_map(serde_json::json!({
+        "scope": scope,
+        "nonce": nonce,
+        "uses": 1,
+        "max_uses": max_uses,
+        "expires_at": expires_at,
+        "created_at": now,
+    }));
+    for _ in 0..MAX_ATTEMPTS {
+        match db::create(ctx, USED_NONCES_TABLE, row.clone()).await {
+            Ok(_) => return Ok(Claim::Accepted),
+            Err(e) if is_unique_violation(&e) => {}
+            Err(e) => return Err(e),
+        }
+        let opts = ListOptions {
+            filters: vec![eq("scope", scope), eq("nonce", nonce)],
+            limit: 1,
+            skip_count: true,
+            ..Default::default()
+        };
+        let Some(existing) = db::list(ctx, USED_NONCES_TABLE, &opts)
+            .await?
+            .records
+            .into_iter()
+            .next()
+        else {
+            // Deleted by retention since the insert failed, so the nonce
+            // is free again: insert it once more.
+            continue;
+        };
+        let uses = existing.i64_field("uses");
+        let expired = existing.str_field("expires_at") <= now.as_str();
+        let mut data = HashMap::new();
+        if expired {
+            data.insert("uses".to_string(), serde_json::json!(1));
+            data.insert("max_uses".to_string(), serde_json::json!(max_uses));
+            data.insert("expires_at".to_string(), serde_json::json!(expires_at));
+            data.insert("created_at".to_string(), serde_json::json!(now));
+        } else if uses < existing.i64_field("max_uses") {
+            data.insert("uses".to_string(), serde_json::json!(uses + 1));
+        } else {
+            return Ok(Claim::Reused);
+        }
+        let updated = db::update_by_filters_count(
+            ctx,
+            USED_NONCES_TABLE,
+            vec![
+                eq("id", existing.id.as_str()),
+                eq("uses", uses),
+                eq("expires_at", existing.str_field("expires_at")),
+            ],
+            data,
+        )
+        .await?;
+        if updated == 1 {
+            return Ok(Claim::Accepted);
+        }
+    }
+    Ok(Claim::Reused)
+}
+
+/// Give back a single-use `nonce` whose request failed before it acted, so
+/// the sender's retry is accepted.
+pub async fn release(ctx: &dyn Context, scope: &str, nonce: &str) -> Result<(), WaferError> {
+    db::delete_by_filters(
+        ctx,
+        USED_NONCES_TABLE,
+        vec![eq("scope", scope), eq("nonce", nonce)],
+    )
+    .await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    fn in_secs(secs: i64) -> String {
+        crate::util::format_rfc3339(chrono::Utc::now() + chrono::Duration::seconds(secs))
+    }
+
+    #[tokio::test]
+    async fn a_nonce_is_accepted_once_per_scope() {
+        let ctx = TestContext::with_admin().await;
+        let later = in_secs(300);
+        assert_eq!(
+            claim(&ctx, "a", "n1", 1, &later).await.unwrap(),
+            Claim::Accepted
+        );
+        assert_eq!(
+            claim(&ctx, "a", "n1", 1, &later).await.unwrap(),
+            Claim::Reused
+        );
+        assert_eq!(
+            claim(&ctx, "b", "n1", 1, &later).await.unwrap(),
+            Claim::Accepted
+        );
+
+        release(&ctx, "a", "n1").await.unwrap();
+        assert_eq!(
+            claim(&ctx, "a", "n1", 1, &later).await.unwrap(),
+            Claim::Accepted
+        );
+    }
+
+    #[tokio::test]
+    async fn max_uses_are_counted_on_one_row() {
+        let ctx = TestContext::with_admin().await;
+        let later = in_secs(300);
+        for _ in 0..3 {
+            assert_eq!(
+                claim(&ctx, "s", "n", 3, &later).await.unwrap(),
+                Claim::Accepted
+            );
+        }
+        assert_eq!(
+            claim(&ctx, "s", "n", 3, &later).await.unwrap(),
+            Claim::Reused
+        );
+        let rows = db::list_all(&ctx, USED_NONCES_TABLE, vec![]).await.unwrap();
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0].i64_field("uses"), 3);
+    }
+
+    #[tokio::test]
+    async fn an_expired_row_is_reused() {
+        let ctx = TestContext::with_admin().await;
+        assert_eq!(
+            claim(&ctx, "s", "n", 1, &in_secs(-1)).await.unwrap(),
+            Claim::Accepted
+        );
+        let later = in_secs(300);
+        assert_eq!(
+            claim(&ctx, "s", "n", 1, &later).await.unwrap(),
+            Claim::Accepted
+        );
+        assert_eq!(
+            claim(&ctx, "s", "n", 1, &later).await.unwrap(),
+            Claim::Reused
+        );
+    }
+}
@@ -0,0 +1,588 @@
+//! Per-user notification inbox: "tell the user the next time they're in
+//! the app".
+//!
+//! Blocks and extensions post through
+//! [`crate::services::Services::notifications`]; the row lands in
+//! `suppers_ai__admin__notifications` and the user reads their inbox at
+//! `GET /b/auth/api/notifications` (paged, with the unread count), marking
+//! rows read one at a time or all at once.
+//!
+//! # Email fan-out
+//!
+//! A notifier marks its type as emailed by default or not
+//! ([`NotificationPayload::email_by_default`]); a user can flip that per
+//! type, stored in `suppers_ai__admin__notification_preferences`. Wanted
+//! emails are sent by an [`EMAIL_JOB`] that runs on the admin block — it
+//! holds the users-directory grant needed to resolve the address, so
+//! notifiers don't each need one — and `emailed_at` keeps a retried job
+//! from mailing twice.
+//!
+//! # Payload schemas
+//!
+//! A type listed in [`crate::events`] has a published schema, and
+//! [`notify`] refuses a payload whose `data` doesn't match it. Post such
+//! types with [`notify_event`], which builds `data` from the typed event.
+//!
+//! # Retention
+//!
+//! Read notifications older than [`RETENTION_DAYS`] are deleted by the
+//! `notifications` policy of [`super::retention`]. Unread rows are kept
+//! however old they are.
+
+use std::collections::{BTreeMap, HashMap};
+
+use wafer_block::db::{Filter, FilterOp, ListOptions};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, ErrorCode, InputStream, WaferError};
+
+use super::{
+    admin::{ADMIN_BLOCK_ID, NOTIFICATIONS_TABLE, NOTIFICATION_PREFERENCES_TABLE},
+    auth::repo::directory,
+    jobs::{self, JobError},
+};
+use crate::{
+    events::{self, Event},
+    pagination::{self, ListPage, ListQuery},
+    services::Services,
+    util::RecordExt,
+};
+
+/// Job type that emails one notification; runs on the admin block.
+pub const EMAIL_JOB: &str = "notifications.email";
+/// Read notifications are deleted this many days after they were created,
+/// unless an admin changes the `notifications` retention policy.
+pub const RETENTION_DAYS: i64 = 90;
+/// Longest accepted title, in characters.
+pub const MAX_TITLE_CHARS: usize = 200;
+/// Longest accepted body, in characters.
+pub const MAX_BODY_CHARS: usize = 4000;
+
+/// What a notifier sends. `data` is handed to the UI untouched (links,
+/// ids); `Null` is stored as `{}`.
+#[derive(Debug, Clone, Default)]
+pub struct NotificationPayload {
+    pub title: String,
+    pub body: String,
+    pub data: serde_json::Value,
+    /// Email the notification unless the user turned email off for its
+    /// type.
+    pub email_by_default: bool,
+    /// An `email.send_template` body (without `to`) to send instead of the
+    /// plain title-and-body email.
+    pub email_template: Option<serde_json::Value>,
+}
+
+/// A stored notification.
+#[derive(Debug, Clone, PartialEq, serde::Serialize)]
+pub struct Notification {
+    pub id: String,
+    pub user_id: String,
+    #[serde(rename = "type")]
+    pub kind: String,
+    pub title: String,
+    pub body: String,
+    pub data: serde_json::Value,
+    /// RFC 3339; `None` while unread.
+    pub read_at: Option<String>,
+    pub created_at: String,
+}
+
+impl Notification {
+    fn from_record(r: &Record) -> Self {
+        let read_at = r.str_field("read_at");
+        Self {
+            id: r.id.clone(),
+            user_id: r.str_field("user_id").to_string(),
+            kind: r.str_field("type").to_string(),
+            title: r.str_field("title").to_string(),
+            body: r.str_field("body").to_string(),
+            data: parse_data(r.str_field("data")),
+            read_at: (!read_at.is_empty()).then(|| read_at.to_string()),
+            created_at: r.str_field("created_at").to_string(),
+        }
+    }
+}
+
+fn parse_data(raw: &str) -> serde_json::Value {
+    serde_json::from_str(raw).unwrap_or_else(|_| serde_json::json!({}))
+}
+
+fn eq(field: &str, value: impl Into<serde_json::Value>) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: value.into(),
+    }
+}
+
+fn unread() -> Filter {
+    Filter {
+        field: "read_at".to_string(),
+        operator: FilterOp::IsNull,
+        value: serde_json::Value::Null,
+    }
+}
+
+fn invalid(message: &str) -> WaferError {
+    WaferError::new(ErrorCode::InvalidArgument, message)
+}
+
+fn truncate(s: &str, max: usize) -> String {
+    s.trim().chars().take(max).collect()
+}
+
+// ---------------------------------------------------------------------------
+// Posting
+// ---------------------------------------------------------------------------
+
+/// Store a `kind` notification for `user_id` and, when the user wants
+/// email for `kind`, queue its [`EMAIL_JOB`]. Overlong titles and bodies
+/// are cut, not rejected. A failure to queue the email is logged; the
+/// notification still stands. When `kind` is in the [`events`] catalog,
+/// `data` must match its schema.
+pub async fn notify(
+    ctx: &dyn Context,
+    user_id: &str,
+    kind: &str,
+    payload: &NotificationPayload,
+) -> Result<Notification, WaferError> {
+    if user_id.is_empty() {
+        return Err(invalid("notification needs a user_id"));
+    }
+    if kind.trim().is_empty() {
+        return Err(invalid("notification needs a type"));
+    }
+    let title = truncate(&payload.title, MAX_TITLE_CHARS);
+    if title.is_empty() {
+        return Err(invalid("notification needs a title"));
+    }
+    let data = match &payload.data {
+        serde_json::Value::Null => serde_json::json!({}),
+        other => other.clone(),
+    };
+    events::validate(kind.trim(), &data).map_err(|e| invalid(&e))?;
+    let row = crate::util::json_map(serde_json::json!({
+        "user_id": user_id,
+        "type": kind.trim(),
+        "title": title,
+        "body": truncate(&payload.body, MAX_BODY_CHARS),
+        "data": data.to_string(),
+        "created_at": crate::util::now_rfc3339(),
+    }));
+    let stored = Notification::from_record(&db::create(ctx, NOTIFICATIONS_TABLE, row).await?);
+
+    if wants_email(ctx, user_id, &stored.kind, payload.email_by_default).await {
+        let job = serde_json::json!({
+            "notification_id": stored.id,
+            "template": payload.email_template,
+        });
+        if let Err(e) =
+            jobs::enqueue(ctx, ADMIN_BLOCK_ID, EMAIL_JOB, &job, Default::default()).await
+        {
+            tracing::warn!(error = %e, notification_id = %stored.id, "failed to queue notification email");
+        }
+    }
+    Ok(stored)
+}
+
+/// [`notify`] `user_id` of `event`: its type is the notification's and
+/// the event is its `data`; `payload.data` is ignored.
+pub async fn notify_event<E: Event>(
+    ctx: &dyn Context,
+    user_id: &str,
+    event: &E,
+    payload: NotificationPayload,
+) -> Result<Notification, WaferError> {
+    let data = serde_json::to_value(event)
+        .map_err(|e| WaferError::new(ErrorCode::Internal, e.to_string()))?;
+    notify(
+        ctx,
+        user_id,
+        E::NAME,
+        &NotificationPayload { data, ..payload },
+    )
+    .await
+}
+
+/// Whether `user_id` gets `kind` by email: their stored preference, else
+/// `default`. An unreadable preference falls back to `default` too.
+async fn wants_email(ctx: &dyn Context, user_id: &str, kind: &str, default: bool) -> bool {
+    let opts = ListOptions {
+        filters: vec![eq("user_id", user_id), eq("type", kind)],
+        limit: 1,
+        skip_count: true,
+        ..Default::default()
+    };
+    match db::list(ctx, NOTIFICATION_PREFERENCES_TABLE, &opts).await {
+        Ok(list) => list
+            .records
+            .first()
+            .map_or(default, |r| r.i64_field("email") != 0),
+        Err(e) => {
+            tracing::warn!(error = %e, "notification preference lookup failed");
+            default
+        }
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Inbox
+// ---------------------------------------------------------------------------
+
+/// Sort and page-size limits for the inbox (see [`crate::pagination`]).
+pub const LIST_SPEC: pagination::ListSpec = pagination::ListSpec {
+    default_limit: 20,
+    max_limit: 100,
+    sortable: &["created_at"],
+    default_sort: "created_at",
+    default_desc: true,
+};
+
+/// One page of `user_id`'s inbox per `query`, optionally unread only,
+/// rendered in the page's shape with each row as a [`Notification`].
+/// `?fields=` projection is not offered: rows are always whole.
+pub async fn list(
+    ctx: &dyn Context,
+    user_id: &str,
+    unread_only: bool,
+    query: &ListQuery,
+) -> Result<serde_json::Value, WaferError> {
+    let mut filters = vec![eq("user_id", user_id)];
+    if unread_only {
+        filters.push(unread());
+    }
+    let mut page = pagination::fetch(ctx, NOTIFICATIONS_TABLE, filters, query).await?;
+    let rows: Vec<Notification> = page
+        .records_mut()
+        .drain(..)
+        .map(|r| Notification::from_record(&r))
+        .collect();
+    let key = match &page {
+        ListPage::Legacy(_) => "records",
+        ListPage::Cursor { .. } => "data",
+    };
+    let mut body = page.into_json(None);
+    body[key] = serde_json::json!(rows);
+    Ok(body)
+}
+
+/// How many of `user_id`'s notifications are unread.
+pub async fn unread_count(ctx: &dyn Context, user_id: &str) -> Result<i64, WaferError> {
+    db::count(
+        ctx,
+        NOTIFICATIONS_TABLE,
+        &[eq("user_id", user_id), unread()],
+    )
+    .await
+}
+
+/// Mark notification `id` read for `user_id`. Marking a read notification
+/// again is a no-op; `false` means `user_id` has no notification `id`.
+pub async fn mark_read(ctx: &dyn Context, user_id: &str, id: &str) -> Result<bool, WaferError> {
+    let mut data = HashMap::new();
+    data.insert(
+        "read_at".to_string(),
+        serde_json::json!(crate::util::now_rfc3339()),
+    );
+    let updated = db::update_by_filters_count(
+        ctx,
+        NOTIFICATIONS_TABLE,
+        vec![eq("id", id), eq("user_id", user_id), unread()],
+        data,
+    )
+    .await?;
+    if updated > 0 {
+        return Ok(true);
+    }
+    let owned = db::count(
+        ctx,
+        NOTIFICATIONS_TABLE,
+        &[eq("id", id), eq("user_id", user_id)],
+    )
+    .await?;
+    Ok(owned > 0)
+}
+
+/// Mark every unread notification of `user_id` read. Returns how many
+/// changed.
+pub async fn mark_all_read(ctx: &dyn Context, user_id: &str) -> Result<i64, WaferError> {
+    let mut data = HashMap::new();
+    data.insert(
+        "read_at".to_string(),
+        serde_json::json!(crate::util::now_rfc3339()),
+    );
+    db::update_by_filters_count(
+        ctx,
+        NOTIFICATIONS_TABLE,
+        vec![eq("user_id", user_id), unread()],
+        data,
+    )
+    .await
+}
+
+// ---------------------------------------------------------------------------
+// Preferences
+// ---------------------------------------------------------------------------
+
+/// `user_id`'s stored email choices, by type. Types without an entry use
+/// their notifier's default.
+pub async fn email_preferences(
+    ctx: &dyn Context,
+    user_id: &str,
+) -> Result<BTreeMap<String, bool>, WaferError> {
+    let rows = db::list_all(
+        ctx,
+        NOTIFICATION_PREFERENCES_TABLE,
+        vec![eq("user_id", user_id)],
+    )
+    .await?;
+    Ok(rows
+        .iter()
+        .map(|r| (r.str_field("type").to_string(), r.i64_field("email") != 0))
+        .collect())
+}
+
+/// Store whether `user_id` wants `kind` notifications by email.
+pub async fn set_email_preference(
+    ctx: &dyn Context,
+    user_id: &str,
+    kind: &str,
+    email: bool,
+) -> Result<(), WaferError> {
+    let mut data = HashMap::new();
+    data.insert("email".to_string(), serde_json::json!(i64::from(email)));
+    data.insert(
+        "updated_at".to_string(),
+        serde_json::json!(crate::util::now_rfc3339()),
+    );
+    let filters = vec![eq("user_id", user_id), eq("type", kind)];
+    if db::update_by_filters_count(ctx, NOTIFICATION_PREFERENCES_TABLE, filters, data.clone())
+        .await?
+        > 0
+    {
+        return Ok(());
+    }
+    data.insert("user_id".to_string(), serde_json::json!(user_id));
+    data.insert("type".to_string(), serde_json::json!(kind));
+    match db::create(ctx, NOTIFICATION_PREFERENCES_TABLE, data).await {
+        Ok(_) => Ok(()),
+        // UNIQUE (user_id, type): a concurrent request stored it first.
+        Err(e) if e.code == ErrorCode::AlreadyExists => Ok(()),
+        Err(e) => Err(e),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Background work
+// ---------------------------------------------------------------------------
+
+/// Run an [`EMAIL_JOB`]: `{"notification_id": …, "template": …}`. A
+/// notification that was pruned, already emailed, or whose user is gone
+/// is done, not failed.
+pub async fn run_email_job(ctx: &dyn Context, input: InputStream) -> Result<(), JobError> {
+    #[derive(serde::Deserialize)]
+    struct Payload {
+        notification_id: String,
+        #[serde(default)]
+        template: Option<serde_json::Value>,
+    }
+    let raw = input.collect_to_bytes().await;
+    let payload: Payload = serde_json::from_slice(&raw)
+        .map_err(|e| JobError::permanent(format!("invalid payload: {e}")))?;
+
+    let row = match db::get(ctx, NOTIFICATIONS_TABLE, &payload.notification_id).await {
+        Ok(row) => row,
+        Err(e) if e.code == ErrorCode::NotFound => return Ok(()),
+        Err(e) => return Err(e.into()),
+    };
+    if !row.str_field("emailed_at").is_empty() {
+        return Ok(());
+    }
+    let notification = Notification::from_record(&row);
+    let to = match directory::find_by_id(ctx, &notification.user_id).await {
+        Ok(Some(entry)) if !entry.email.is_empty() => entry.email,
+        Ok(_) => return Ok(()),
+        Err(e) => return Err(JobError::transient(e.to_string())),
+    };
+
+    let (kind, body) = email_body(&notification, payload.template, &to);
+    if !Services::new(ctx, ADMIN_BLOCK_ID)
+        .mailer()
+        .send(kind, body)
+        .await
+    {
+        return Err(JobError::transient("email block refused the notification"));
+    }
+    let mut data = HashMap::new();
+    data.insert(
+        "emailed_at".to_string(),
+        serde_json::json!(crate::util::now_rfc3339()),
+    );
+    db::update(ctx, NOTIFICATIONS_TABLE, &notification.id, data).await?;
+    Ok(())
+}
+
+/// The `suppers-ai/email` op and body for emailing `n` to `to`: the
+/// notifier's template when it gave one, else the title as subject over
+/// the escaped body.
+fn email_body(
+    n: &Notification,
+    template: Option<serde_json::Value>,
+    to: &str,
+) -> (&'static str, serde_json::Value) {
+    if let Some(serde_json::Value::Object(mut template)) = template {
+        template.insert("to".to_string(), serde_json::json!(to));
+        return ("email.send_template", serde_json::Value::Object(template));
+    }
+    let html = maud::html! {
+        h2 { (n.title) }
+        @if !n.body.is_empty() { p { (n.body) } }
+    };
+    let text = if n.body.is_empty() {
+        n.title.clone()
+    } else {
+        format!("{}\n\n{}", n.title, n.body)
+    };
+    (
+        "email.send",
+        serde_json::json!({
+            "to": to,
+            "subject": n.title,
+            "html": html.into_string(),
+            "text": text,
+        }),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    fn payload(title: &str) -> NotificationPayload {
+        NotificationPayload {
+            title: title.into(),
+            body: "details".into(),
+            data: serde_json::json!({ "bucket": "photos" }),
+            ..Default::default()
+        }
+    }
+
+    fn first_page() -> ListQuery {
+        pagination::parse(
+            &crate::test_support::auth_msg("retrieve", "/b/auth/api/notifications", "u1"),
+            &LIST_SPEC,
+        )
+        .unwrap()
+    }
+
+    #[tokio::test]
+    async fn notify_validates_and_stores_data_as_json() {
+        let ctx = TestContext::with_auth().await;
+        assert!(notify(&ctx, "", "photos.added", &payload("x"))
+            .await
+            .is_err());
+        assert!(notify(&ctx, "u1", " ", &payload("x")).await.is_err());
+        assert!(notify(&ctx, "u1", "photos.added", &payload("  "))
+            .await
+            .is_err());
+
+        let long = "t".repeat(MAX_TITLE_CHARS + 10);
+        let n = notify(&ctx, "u1", "photos.added", &payload(&long))
+            .await
+            .unwrap();
+        assert_eq!(n.title.chars().count(), MAX_TITLE_CHARS);
+        assert_eq!(n.data["bucket"], "photos");
+        assert_eq!(n.read_at, None);
+
+        let body = list(&ctx, "u1", false, &first_page()).await.unwrap();
+        assert_eq!(body["records"][0]["data"]["bucket"], "photos");
+        assert_eq!(body["records"][0]["type"], "photos.added");
+    }
+
+    #[tokio::test]
+    async fn catalog_types_need_data_matching_their_schema() {
+        let ctx = TestContext::with_auth().await;
+        let drifted = notify(&ctx, "u1", events::ShareDeclined::NAME, &payload("x")).await;
+        assert_eq!(drifted.unwrap_err().code, ErrorCode::InvalidArgument);
+
+        let event = events::ShareDeclined {
+            bucket: "photos".into(),
+            path: "2024/".into(),
+        };
+        let n = notify_event(&ctx, "u1", &event, payload("x"))
+            .await
+            .unwrap();
+        assert_eq!(n.kind, "files.share_declined");
+        assert_eq!(
+            n.data,
+            serde_json::json!({ "bucket": "photos", "path": "2024/" })
+        );
+    }
+
+    #[tokio::test]
+    async fn read_state_is_per_user() {
+        let ctx = TestContext::with_auth().await;
+        let a = notify(&ctx, "u1", "t", &payload("a")).await.unwrap();
+        notify(&ctx, "u1", "t", &payload("b")).await.unwrap();
+        let other = notify(&ctx, "u2", "t", &payload("c")).await.unwrap();
+        assert_eq!(unread_count(&ctx, "u1").await.unwrap(), 2);
+
+        assert!(mark_read(&ctx, "u1", &a.id).await.unwrap());
+        assert!(mark_read(&ctx, "u1", &a.id).await.unwrap(), "idempotent");
+        assert!(!mark_read(&ctx, "u1", &other.id).await.unwrap());
+        assert!(!mark_read(&ctx, "u1", "missing").await.unwrap());
+        assert_eq!(unread_count(&ctx, "u1").await.unwrap(), 1);
+
+        let body = list(&ctx, "u1", true, &first_page()).await.unwrap();
+        assert_eq!(body["records"].as_array().unwrap().len(), 1);
+
+        assert_eq!(mark_all_read(&ctx, "u1").await.unwrap(), 1);
+        assert_eq!(unread_count(&ctx, "u1").await.unwrap(), 0);
+        assert_eq!(unread_count(&ctx, "u2").await.unwrap(), 1);
+    }
+
+    #[tokio::test]
+    async fn preferences_override_the_type_default() {
+        let ctx = TestContext::with_auth().await;
+        assert!(wants_email(&ctx, "u1", "t", true).await);
+        assert!(!wants_email(&ctx, "u1", "t", false).await);
+
+        set_email_preference(&ctx, "u1", "t", false).await.unwrap();
+        set_email_preference(&ctx, "u1", "t", false).await.unwrap();
+        assert!(!wants_email(&ctx, "u1", "t", true).await);
+        set_email_preference(&ctx, "u1", "t", true).await.unwrap();
+        assert!(wants_email(&ctx, "u1", "t", false).await);
+        assert!(wants_email(&ctx, "u2", "t", true).await);
+
+        let prefs = email_preferences(&ctx, "u1").await.unwrap();
+        assert_eq!(prefs.into_iter().collect::<Vec<_>>(), [("t".into(), true)]);
+    }
+
+    #[test]
+    fn email_body_prefers_the_notifier_template() {
+        let n = Notification {
+            id: "n1".into(),
+            user_id: "u1".into(),
+            kind: "t".into(),
+            title: "Storage <almost> full".into(),
+            body: "Delete something".into(),
+            data: serde_json::json!({}),
+            read_at: None,
+            created_at: String::new(),
+        };
+        let (kind, body) = email_body(&n, None, "a@b.c");
+        assert_eq!(kind, "email.send");
+        assert_eq!(body["subject"], "Storage <almost> full");
+        assert!(body["html"]
+            .as_str()
+            .unwrap()
+            .contains("Storage &lt;almost&gt; full"));
+
+        let template = serde_json::json!({ "template": "quota_threshold", "threshold": 80 });
+        let (kind, body) = email_body(&n, Some(template), "a@b.c");
+        assert_eq!(kind, "email.send_template");
+        assert_eq!(body["to"], "a@b.c");
+        assert_eq!(body["threshold"], 80);
+    }
+}
@@ -0,0 +1,592 @@
+//! Long-running operations: "this will take a while, come back for the
+//! result".
+//!
+//! A request whose work may take more than a couple of seconds (expanding
+//! a big archive, a large user import) hands it to a job with [`start`]
+//! and answers `202` through [`accepted`]. The row in
+//! `suppers_ai__admin__operations` records who started it; the job's
+//! payload carries its id under [`OPERATION_ID_KEY`], and the handler
+//! reports through a [`Progress`]:
+//!
+//! ```ignore
+//! let mut progress = operations::Progress::begin(ctx, &payload).await;
+//! for (done, item) in items.iter().enumerate() {
+//!     work(item).await?;
+//!     progress.update(done as u64 + 1, items.len() as u64).await;
+//! }
+//! progress.succeed(&summary).await;
+//! ```
+//!
+//! [`Progress::update`] writes at most once per [`UPDATE_INTERVAL_SECS`],
+//! so a handler can report every item without its progress writes
+//! becoming a load of their own; [`Progress::succeed`] flushes the last
+//! counts with the result. A run that fails is settled by the job runner:
+//! the operation goes back to `queued` while retries remain, and to
+//! `failed` with the error once they don't.
+//!
+//! The caller polls `GET /api/operations/{id}` and lists their own at
+//! `GET /api/operations`. Only the user who started an operation, or an
+//! admin, can see it, and only for [`RETENTION_HOURS`] after it started;
+//! the `expired_tokens` retention policy deletes the rows after that.
+
+use std::collections::HashMap;
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, ErrorCode, Message, OutputStream, WaferError};
+
+use super::{
+    admin::OPERATIONS_TABLE,
+    jobs::{self, EnqueueOptions, JobError},
+};
+use crate::{
+    http::{err_internal, err_not_found, ok_json, ResponseBuilder},
+    util::RecordExt,
+};
+
+/// Payload key carrying the operation id to the job that runs it.
+pub const OPERATION_ID_KEY: &str = "operation_id";
+
+pub const STATUS_QUEUED: &str = "queued";
+pub const STATUS_RUNNING: &str = "running";
+pub const STATUS_SUCCEEDED: &str = "succeeded";
+pub const STATUS_FAILED: &str = "failed";
+
+/// How long an operation can be polled after it started.
+pub const RETENTION_HOURS: i64 = 24;
+/// Shortest gap between two progress writes of one run.
+pub const UPDATE_INTERVAL_SECS: i64 = 2;
+/// Most operations one `GET /api/operations` returns.
+const MAX_LIST: i64 = 100;
+
+/// A stored operation.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Operation {
+    pub id: String,
+    pub user_id: String,
+    /// What the operation does, e.g. `files.archive`.
+    pub kind: String,
+    pub status: String,
+    pub job_id: String,
+    pub processed: i64,
+    pub total: i64,
+    /// The handler's result once succeeded; `Null` before.
+    pub result: serde_json::Value,
+    pub error: String,
+    pub created_at: String,
+    pub updated_at: String,
+    pub started_at: String,
+    pub finished_at: String,
+    pub expires_at: String,
+}
+
+impl Operation {
+    fn from_record(r: &Record) -> Self {
+        Self {
+            id: r.id.clone(),
+            user_id: r.str_field("user_id").to_string(),
+            kind: r.str_field("kind").to_string(),
+            status: r.str_field("status").to_string(),
+            job_id: r.str_field("job_id").to_string(),
+            processed: r.i64_field("processed"),
+            total: r.i64_field("total"),
+            result: serde_json::from_str(r.str_field("result")).unwrap_or(serde_json::Value::Null),
+            error: r.str_field("error").to_string(),
+            created_at: r.str_field("created_at").to_string(),
+            updated_at: r.str_field("updated_at").to_string(),
+            started_at: r.str_field("started_at").to_string(),
+            finished_at: r.str_field("finished_at").to_string(),
+            expires_at: r.str_field("expires_at").to_string(),
+        }
+    }
+
+    /// Where the operation is polled.
+    pub fn url(&self) -> String {
+        format!("{}/operations/{}", crate::api_version::V1_PREFIX, self.id)
+    }
+
+    /// Whole percent done, or `None` before the handler reported a total.
+    pub fn percent(&self) -> Option<i64> {
+        (self.total > 0).then(|| (self.processed.clamp(0, self.total) * 100) / self.total)
+    }
+
+    /// The polling shape of the operation.
+    pub fn view(&self) -> serde_json::Value {
+        serde_json::json!({
+            "id": self.id,
+            "kind": self.kind,
+            "status": self.status,
+            "processed": self.processed,
+            "total": self.total,
+            "percent": self.percent(),
+            "result": self.result,
+            "error": self.error,
+            "created_at": self.created_at,
+            "updated_at": self.updated_at,
+            "started_at": self.started_at,
+            "finished_at": self.finished_at,
+            "expires_at": self.expires_at,
+            "url": self.url(),
+        })
+    }
+}
+
+fn eq(field: &str, value: impl Into<serde_json::Value>) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: value.into(),
+    }
+}
+
+fn not_expired() -> Filter {
+    Filter {
+        field: "expires_at".to_string(),
+        operator: FilterOp::GreaterThan,
+        value: serde_json::json!(crate::util::now_rfc3339()),
+    }
+}
+
+/// Start a `kind` operation for `user_id`, run by a `job_type` job on
+/// `block`. `payload` (an object) gets the operation id under
+/// [`OPERATION_ID_KEY`]. The operation is returned as it stands after the
+/// enqueue, which may already have run it where no worker is running.
+pub async fn start(
+    ctx: &dyn Context,
+    user_id: &str,
+    block: &str,
+    kind: &str,
+    job_type: &str,
+    mut payload: serde_json::Value,
+) -> Result<Operation, WaferError> {
+    let now = crate::clock::now();
+    let expires = now + chrono::Duration::hours(RETENTION_HOURS);
+    let data = crate::util::json_map(serde_json::json!({
+        "user_id": user_id,
+        "kind": kind,
+        "status": STATUS_QUEUED,
+        "created_at": crate::util::format_rfc3339(now),
+        "updated_at": crate::util::format_rfc3339(now),
+        "expires_at": crate::util::format_rfc3339(expires),
+    }));
+    let row = db::create(ctx, OPERATIONS_TABLE, data).await?;
+    payload[OPERATION_ID_KEY] = serde_json::json!(row.id);
+    let job_id =
+        match jobs::enqueue(ctx, block, job_type, &payload, EnqueueOptions::default()).await {
+            Ok(job_id) => job_id,
+            Err(e) => {
+                // Nothing would ever run it.
+                let _ = db::delete(ctx, OPERATIONS_TABLE, &row.id).await;
+                return Err(e);
+            }
+        };
+    let mut data = HashMap::new();
+    data.insert("job_id".to_string(), serde_json::json!(job_id));
+    let row = db::update(ctx, OPERATIONS_TABLE, &row.id, data).await?;
+    Ok(Operation::from_record(&row))
+}
+
+/// The `202` answer for a request that started `op`: `body` (an object)
+/// with the operation under `operation`, and its polling URL in
+/// `Location`.
+pub fn accepted(op: &Operation, mut body: serde_json::Value) -> OutputStream {
+    body["operation"] = op.view();
+    ResponseBuilder::new()
+        .status(202)
+        .set_header("Location", &op.url())
+        .json(&body)
+}
+
+/// Operation `id` as the caller of `msg` may see it: their own, or any
+/// for an admin, and not yet expired.
+pub async fn get_for(
+    ctx: &dyn Context,
+    msg: &Message,
+    id: &str,
+) -> Result<Option<Operation>, WaferError> {
+    let row = match db::get(ctx, OPERATIONS_TABLE, id).await {
+        Ok(row) => row,
+        Err(e) if e.code == ErrorCode::NotFound => return Ok(None),
+        Err(e) => return Err(e),
+    };
+    let op = Operation::from_record(&row);
+    let visible = op.expires_at > crate::util::now_rfc3339()
+        && (op.user_id == msg.user_id() || crate::util::is_admin(msg));
+    Ok(visible.then_some(op))
+}
+
+/// `user_id`'s unexpired operations, newest first.
+pub async fn list_for(ctx: &dyn Context, user_id: &str) -> Result<Vec<Operation>, WaferError> {
+    let opts = ListOptions {
+        filters: vec![eq("user_id", user_id), not_expired()],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit: MAX_LIST,
+        ..Default::default()
+    };
+    let list = db::list(ctx, OPERATIONS_TABLE, &opts).await?;
+    Ok(list.records.iter().map(Operation::from_record).collect())
+}
+
+/// `GET /operations` and `GET /operations/{id}` (`/api/...` once the
+/// pipeline strips the prefix).
+pub async fn handle(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let path = msg.path();
+    if msg.action() != "retrieve" {
+        return err_not_found("not found");
+    }
+    if path == "/operations" {
+        return match list_for(ctx, msg.user_id()).await {
+            Ok(ops) => ok_json(&serde_json::json!({
+                "operations": ops.iter().map(Operation::view).collect::<Vec<_>>(),
+            })),
+            Err(e) => err_internal("Database error", e),
+        };
+    }
+    let Some(id) = path
+        .strip_prefix("/operations/")
+        .filter(|id| !id.is_empty() && !id.contains('/'))
+    else {
+        return err_not_found("not found");
+    };
+    match get_for(ctx, msg, id).await {
+        Ok(Some(op)) => ok_json(&op.view()),
+        Ok(None) => err_not_found("Operation not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// A job handler's line back to its operation.
+pub struct Progress<'a> {
+    ctx: &'a dyn Context,
+    /// `None` for a job started without an operation, whose reports go
+    /// nowhere.
+    id: Option<String>,
+    processed: u64,
+    total: u64,
+    last_write: Option<chrono::DateTime<chrono::Utc>>,
+    done: bool,
+}
+
+impl<'a> Progress<'a> {
+    /// Mark the operation named in a job's `payload` as running. A payload
+    /// without one (a job queued before its kind became an operation)
+    /// gets a progress that reports nowhere.
+    pub async fn begin(ctx: &'a dyn Context, payload: &serde_json::Value) -> Progress<'a> {
+        let id = payload[OPERATION_ID_KEY]
+            .as_str()
+            .filter(|id| !id.is_empty())
+            .map(str::to_string);
+        let mut progress = Progress {
+            ctx,
+            id,
+            processed: 0,
+            total: 0,
+            last_write: None,
+            done: false,
+        };
+        let Some(id) = &progress.id else {
+            return progress;
+        };
+        // A run after an admin retried the dead job revives a failed
+        // operation; one after it succeeded leaves it alone.
+        let now = crate::util::now_rfc3339();
+        let filters = vec![
+            eq("id", id.as_str()),
+            Filter {
+                field: "status".to_string(),
+                operator: FilterOp::NotEqual,
+                value: serde_json::json!(STATUS_SUCCEEDED),
+            },
+        ];
+        let data = crate::util::json_map(serde_json::json!({
+            "status": STATUS_RUNNING,
+            "started_at": now,
+            "finished_at": "",
+            "error": "",
+            "updated_at": now,
+        }));
+        match db::update_by_filters_count(ctx, OPERATIONS_TABLE, filters, data).await {
+            Ok(updated) => progress.done = updated == 0,
+            Err(e) => {
+                tracing::warn!(operation_id = %id, error = %e, "failed to start operation");
+            }
+        }
+        progress
+    }
+
+    /// Whether the operation succeeded already (the job was delivered
+    /// again) or is gone; either way there is nothing left to do.
+    pub fn already_done(&self) -> bool {
+        self.done
+    }
+
+    /// Record `processed` of `total` done. Written only if the last write
+    /// is [`UPDATE_INTERVAL_SECS`] old; the counts are kept either way for
+    /// [`Self::succeed`].
+    pub async fn update(&mut self, processed: u64, total: u64) {
+        self.processed = processed;
+        self.total = total;
+        let now = crate::clock::now();
+        let interval = chrono::Duration::seconds(UPDATE_INTERVAL_SECS);
+        if self.last_write.is_some_and(|last| now - last < interval) {
+            return;
+        }
+        self.last_write = Some(now);
+        self.write(serde_json::json!({
+            "processed": processed,
+            "total": total,
+            "updated_at": crate::util::format_rfc3339(now),
+        }))
+        .await;
+    }
+
+    /// Finish the operation with `result` and the last reported counts.
+    pub async fn succeed(self, result: &serde_json::Value) {
+        let now = crate::util::now_rfc3339();
+        self.write(serde_json::json!({
+            "status": STATUS_SUCCEEDED,
+            "processed": self.processed,
+            "total": self.total,
+            "result": result.to_string(),
+            "error": "",
+            "finished_at": now,
+            "updated_at": now,
+        }))
+        .await;
+    }
+
+    /// Best effort: a lost progress write leaves a stale percentage, which
+    /// is no reason to fail the work it describes.
+    async fn write(&self, data: serde_json::Value) {
+        if let Some(id) = &self.id {
+            write_unfinished(self.ctx, id, data).await;
+        }
+    }
+}
+
+/// Update operation `id` unless it has finished.
+async fn write_unfinished(ctx: &dyn Context, id: &str, data: serde_json::Value) {
+    let filters = vec![eq("id", id), eq("finished_at", "")];
+    let data = crate::util::json_map(data);
+    if let Err(e) = db::update_by_filters_count(ctx, OPERATIONS_TABLE, filters, data).await {
+        tracing::warn!(
+            operation_id = %id,
+            error = %e,
+            "failed to record operation progress"
+        );
+    }
+}
+
+/// Settle the operation of a job run that ended with `result`, called by
+/// the job runner. A failure goes back to `queued` while the job will be
+/// `retried`, and to `failed` when it won't; a success the handler didn't
+/// report ends the operation without a result.
+pub(crate) async fn after_job_run(
+    ctx: &dyn Context,
+    payload: &serde_json::Value,
+    result: &Result<(), JobError>,
+    retried: bool,
+) {
+    let Some(id) = payload[OPERATION_ID_KEY]
+        .as_str()
+        .filter(|id| !id.is_empty())
+    else {
+        return;
+    };
+    let now = crate::util::now_rfc3339();
+    let data = match result {
+        Ok(()) => serde_json::json!({
+            "status": STATUS_SUCCEEDED,
+            "finished_at": now,
+            "updated_at": now,
+        }),
+        Err(e) if retried => serde_json::json!({
+            "status": STATUS_QUEUED,
+            "error": e.message,
+            "updated_at": now,
+        }),
+        Err(e) => serde_json::json!({
+            "status": STATUS_FAILED,
+            "error": e.message,
+            "finished_at": now,
+            "updated_at": now,
+        }),
+    };
+    write_unfinished(ctx, id, data).await;
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use wafer_run::{Block, BlockInfo, InputStream, LifecycleEvent};
+
+    use super::*;
+    use crate::test_support::{admin_msg, auth_msg, output_json, output_status, TestContext};
+
+    /// Runs `test.count` jobs as three reported steps, and fails
+    /// `test.broken` ones for good.
+    struct Worker;
+
+    #[async_trait::async_trait]
+    impl Block for Worker {
+        fn info(&self) -> BlockInfo {
+            BlockInfo::new("test/worker", "0.0.1", "http-handler@v1", "operations")
+        }
+        async fn handle(
+            &self,
+            ctx: &dyn Context,
+            msg: Message,
+            input: InputStream,
+        ) -> OutputStream {
+            let payload: serde_json::Value =
+                serde_json::from_slice(&input.collect_to_bytes().await).unwrap();
+            match jobs::job_type(&msg) {
+                "test.count" => {
+                    let mut progress = Progress::begin(ctx, &payload).await;
+                    for done in 1..=3 {
+                        progress.update(done, 3).await;
+                    }
+                    progress.succeed(&serde_json::json!({ "counted": 3 })).await;
+                    jobs::respond(Ok(()))
+                }
+                "test.broken" => jobs::respond(Err(JobError::permanent("broken input"))),
+                _ => jobs::unknown_type(&msg),
+            }
+        }
+        async fn lifecycle(
+            &self,
+            _ctx: &dyn Context,
+            _e: LifecycleEvent,
+        ) -> Result<(), WaferError> {
+            Ok(())
+        }
+    }
+
+    async fn ctx_with_worker() -> TestContext {
+        let mut ctx = TestContext::with_admin().await;
+        ctx.register_block("test/worker", Arc::new(Worker));
+        ctx
+    }
+
+    async fn status(ctx: &TestContext, msg: Message) -> u16 {
+        output_status(handle(ctx, &msg).await).await
+    }
+
+    async fn view(ctx: &TestContext, msg: Message) -> serde_json::Value {
+        output_json(handle(ctx, &msg).await).await
+    }
+
+    async fn stored(ctx: &TestContext, id: &str) -> Operation {
+        Operation::from_record(&db::get(ctx, OPERATIONS_TABLE, id).await.unwrap())
+    }
+
+    #[tokio::test]
+    async fn started_operations_report_their_result_to_their_owner_only() {
+        let ctx = ctx_with_worker().await;
+        let op = start(
+            &ctx,
+            "alice",
+            "test/worker",
+            "test.count",
+            "test.count",
+            serde_json::json!({}),
+        )
+        .await
+        .unwrap();
+        assert!(!op.job_id.is_empty());
+
+        let path = format!("/operations/{}", op.id);
+        let polled = view(&ctx, auth_msg("retrieve", &path, "alice")).await;
+        assert_eq!(polled["status"], STATUS_SUCCEEDED);
+        assert_eq!(polled["processed"], 3);
+        assert_eq!(polled["percent"], 100);
+        assert_eq!(polled["result"]["counted"], 3);
+        assert_eq!(polled["url"], format!("/api/v1/operations/{}", op.id));
+
+        assert_eq!(status(&ctx, auth_msg("retrieve", &path, "bob")).await, 404);
+        assert_eq!(status(&ctx, admin_msg("retrieve", &path)).await, 200);
+
+        let mine = view(&ctx, auth_msg("retrieve", "/operations", "alice")).await;
+        assert_eq!(mine["operations"].as_array().unwrap().len(), 1);
+        let theirs = view(&ctx, auth_msg("retrieve", "/operations", "bob")).await;
+        assert!(theirs["operations"].as_array().unwrap().is_empty());
+    }
+
+    #[tokio::test]
+    async fn failed_jobs_fail_their_operation() {
+        let ctx = ctx_with_worker().await;
+        let op = start(
+            &ctx,
+            "alice",
+            "test/worker",
+            "test.broken",
+            "test.broken",
+            serde_json::json!({}),
+        )
+        .await
+        .unwrap();
+        assert_eq!(op.status, STATUS_FAILED);
+        assert_eq!(op.error, "broken input");
+        assert!(!op.finished_at.is_empty());
+        assert_eq!(op.percent(), None);
+    }
+
+    #[tokio::test]
+    async fn progress_writes_are_rate_limited() {
+        let ctx = TestContext::with_admin().await;
+        crate::clock::freeze(crate::clock::at("2026-03-01T00:00:00Z"));
+        let row = db::create(
+            &ctx,
+            OPERATIONS_TABLE,
+            crate::util::json_map(serde_json::json!({
+                "user_id": "alice",
+                "kind": "test.count",
+                "status": STATUS_QUEUED,
+                "created_at": crate::util::now_rfc3339(),
+                "updated_at": crate::util::now_rfc3339(),
+                "expires_at": "2026-03-02T00:00:00.000000Z",
+            })),
+        )
+        .await
+        .unwrap();
+        let payload = serde_json::json!({ OPERATION_ID_KEY: row.id });
+        let mut progress = Progress::begin(&ctx, &payload).await;
+        assert!(!progress.already_done());
+        assert_eq!(stored(&ctx, &row.id).await.status, STATUS_RUNNING);
+        progress.update(1, 10).await;
+        progress.update(2, 10).await;
+        assert_eq!(
+            stored(&ctx, &row.id).await.processed,
+            1,
+            "second write is too soon"
+        );
+        crate::clock::advance(chrono::Duration::seconds(UPDATE_INTERVAL_SECS));
+        progress.update(3, 10).await;
+        progress.update(4, 10).await;
+        assert_eq!(stored(&ctx, &row.id).await.processed, 3);
+        progress.succeed(&serde_json::Value::Null).await;
+        let done = stored(&ctx, &row.id).await;
+        assert_eq!(
+            (done.status.as_str(), done.processed),
+            (STATUS_SUCCEEDED, 4)
+        );
+
+        // Delivered again, a succeeded operation is left alone.
+        assert!(Progress::begin(&ctx, &payload).await.already_done());
+        assert_eq!(stored(&ctx, &row.id).await.status, STATUS_SUCCEEDED);
+
+        // A day on, it can no longer be polled.
+        crate::clock::advance(chrono::Duration::hours(RETENTION_HOURS));
+        let path = format!("/operations/{}", row.id);
+        assert_eq!(
+            status(&ctx, auth_msg("retrieve", &path, "alice")).await,
+            404
+        );
+        assert!(list_for(&ctx, "alice").await.unwrap().is_empty());
+        crate::clock::thaw();
+    }
+}
@@ -0,0 +1,418 @@
+//! Route-level permissions (IAM): which routes need which permission, and
+//! which roles hold it.
+//!
+//! A permission is a `{resource}.{action}` name such as `storage.write`. A
+//! core route requires one by appearing in its block's `PERMISSION_ROUTES`
+//! table — `EndpointRoute`s whose handler is the permission, the same shape
+//! as the usage-quota tags in [`super::api_quota`]. The router checks them
+//! after the access tier (see [`crate::routing::route_to_block`]). A
+//! declarative extension names the permission on the manifest route and
+//! checks it itself before dispatching (see [`require`]).
+//!
+//! Roles hold permissions in their `permissions` array: `*` holds every
+//! permission and `storage.*` every `storage` one. Admins hold everything
+//! whatever their roles say. A permission on an admin-tier core route opens
+//! that route to non-admins who hold it, so `logs.read` hands out the log
+//! viewer without the rest of the admin panel.
+//!
+//! The admin panel is split the same way: `users.manage`, `storage.admin`,
+//! `billing.manage` and `logs.read` each open one slice of it, and the
+//! built-in [`SCOPED_ROLES`] hold one each. The `admin` role still opens
+//! everything. A scoped admin can't act on a user, or hand out a role, that
+//! holds permissions they don't (see [`covers`]).
+//!
+//! Every permission is catalogued in the admin permissions table. Core ones
+//! are seeded at admin init by [`seed`], and the first time one appears it
+//! is granted to the default roles that could already use its routes — an
+//! upgrade takes nobody's access away, and later role edits stick.
+//! Extensions catalogue theirs at init; no role holds them until an admin
+//! grants them.
+//!
+//! A caller without the permission gets 403 `permission_denied` naming it in
+//! `details.permission`.
+
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::database as db;
+use wafer_run::{context::Context, ErrorCode, Message, OutputStream, WaferError};
+
+use super::admin::{PERMISSIONS_TABLE, ROLES_TABLE};
+use crate::endpoint_match::{self, EndpointRoute};
+
+/// Held by a role, grants every permission.
+pub const ALL: &str = "*";
+
+/// A permission the core routes require, and the default roles that get it
+/// when it is first seeded.
+pub struct CorePermission {
+    pub name: &'static str,
+    pub default_roles: &'static [&'static str],
+}
+
+/// Every core permission. `default_roles` reproduces who could use the
+/// routes before they were annotated: any signed-in user could write to
+/// their own storage, only admins could read the logs or manage users,
+/// storage and billing.
+pub const CORE: &[CorePermission] = &[
+    CorePermission {
+        name: "storage.write",
+        default_roles: &["user"],
+    },
+    CorePermission {
+        name: "logs.read",
+        default_roles: &[],
+    },
+    CorePermission {
+        name: "users.manage",
+        default_roles: &[],
+    },
+    CorePermission {
+        name: "storage.admin",
+        default_roles: &[],
+    },
+    CorePermission {
+        name: "billing.manage",
+        default_roles: &[],
+    },
+];
+
+/// A built-in role holding one slice of the admin panel.
+pub struct ScopedRole {
+    pub name: &'static str,
+    pub description: &'static str,
+    pub permissions: &'static [&'static str],
+}
+
+/// The scoped admin roles, seeded at admin init wherever a role of that
+/// name is missing.
+pub const SCOPED_ROLES: &[ScopedRole] = &[
+    ScopedRole {
+        name: "user_admin",
+        description: "Manage users, invitations and password resets",
+        permissions: &["users.manage"],
+    },
+    ScopedRole {
+        name: "storage_admin",
+        description: "Manage buckets, quotas and storage reports",
+        permissions: &["storage.admin"],
+    },
+    ScopedRole {
+        name: "billing_admin",
+        description: "Manage products, purchases and refunds",
+        permissions: &["billing.manage"],
+    },
+    ScopedRole {
+        name: "log_viewer",
+        description: "Read the request and audit logs",
+        permissions: &["logs.read"],
+    },
+];
+
+// ---------------------------------------------------------------------------
+// Route annotations
+// ---------------------------------------------------------------------------
+
+/// Every block's `PERMISSION_ROUTES` table, for the blocks compiled in.
+fn route_tables() -> Vec<&'static [EndpointRoute<&'static str>]> {
+    let mut tables: Vec<&'static [EndpointRoute<&'static str>]> = vec![
+        super::admin::LOGS_PERMISSION_ROUTES,
+        super::admin::USERS_PERMISSION_ROUTES,
+        super::admin::STORAGE_PERMISSION_ROUTES,
+    ];
+    #[cfg(feature = "block-files")]
+    {
+        tables.push(super::files::storage::PERMISSION_ROUTES);
+        tables.push(super::files::storage::ADMIN_PERMISSION_ROUTES);
+    }
+    #[cfg(feature = "block-products")]
+    tables.push(super::products::PERMISSION_ROUTES);
+    tables
+}
+
+/// The permission the core route `(action, path)` requires, if any.
+pub fn required(action: &str, path: &str) -> Option<&'static str> {
+    route_tables().into_iter().flatten().find_map(|route| {
+        (endpoint_match::action_for_method(route.method) == action
+            && endpoint_match::match_template(route.template, path).is_some())
+        .then_some(route.handler)
+    })
+}
+
+/// Whether `name` is a well-formed `{resource}.{action}`: two non-empty
+/// segments of a-z, 0-9, `_` and `-`.
+pub fn is_valid_name(name: &str) -> bool {
+    let segment = |s: &str| {
+        !s.is_empty()
+            && s.bytes()
+                .all(|b| matches!(b, b'a'..=b'z' | b'0'..=b'9' | b'_' | b'-'))
+    };
+    matches!(name.split_once('.'), Some((resource, action)) if segment(resource) && segment(action))
+}
+
+// ---------------------------------------------------------------------------
+// Checks
+// ---------------------------------------------------------------------------
+
+/// Whether a role set holding `held` has `permission`, directly or through
+/// `*` or a `{resource}.*` wildcard.
+pub fn holds(held: &[String], permission: &str) -> bool {
+    let resource = permission.split_once('.').map_or("", |(r, _)| r);
+    held.iter().any(|p| {
+        p == ALL
+            || p == permission
+            || p.strip_suffix(".*")
+                .is_some_and(|r| !r.is_empty() && r == resource)
+    })
+}
+
+/// A role's `permissions` column: a JSON array, or the array encoded as a
+/// string by backends that don't parse JSON text.
+fn string_list(value: Option<&serde_json::Value>) -> Vec<String> {
+    match value {
+        Some(serde_json::Value::Array(items)) => items
+            .iter()
+            .filter_map(|v| v.as_str().map(str::to_string))
+            .collect(),
+        Some(serde_json::Value::String(s)) => serde_json::from_str(s).unwrap_or_default(),
+        _ => Vec::new(),
+    }
+}
+
+/// Every permission held through `roles`.
+pub async fn held_by(ctx: &dyn Context, roles: &[&str]) -> Result<Vec<String>, WaferError> {
+    if roles.is_empty() {
+        return Ok(Vec::new());
+    }
+    let filters = vec![Filter {
+        field: "name".to_string(),
+        operator: FilterOp::In,
+        value: serde_json::json!(roles),
+    }];
+    let rows = db::list_all(ctx, ROLES_TABLE, filters).await?;
+    Ok(rows
+        .iter()
+        .flat_map(|r| string_list(r.data.get("permissions")))
+        .collect())
+}
+
+/// `None` when the caller of `msg` may go on to a route that requires
+/// `permission`; otherwise the response to answer with. Anonymous callers
+/// are sent to sign in. A roles read failure denies rather than lets the
+/// request through.
+pub async fn require(ctx: &dyn Context, msg: &Message, permission: &str) -> Option<OutputStream> {
+    if msg.user_id().is_empty() {
+        return Some(crate::ui::unauthenticated_response(msg));
+    }
+    if crate::util::is_admin(msg) {
+        return None;
+    }
+    match held_by(ctx, &super::feature_flags::roles_of(msg)).await {
+        Ok(held) if holds(&held, permission) => None,
+        Ok(_) => Some(crate::ui::permission_denied_response(msg, permission)),
+        Err(e) => Some(crate::http::err_internal("Database error", e)),
+    }
+}
+
+/// Whether the caller of `msg` holds every permission `roles` grant, so
+/// that acting on a user with those roles, or handing them out, raises
+/// nobody above the caller. Only admins cover the `admin` role.
+pub async fn covers(ctx: &dyn Context, msg: &Message, roles: &[&str]) -> Result<bool, WaferError> {
+    if crate::util::is_admin(msg) {
+        return Ok(true);
+    }
+    if roles.contains(&"admin") {
+        return Ok(false);
+    }
+    let granted = held_by(ctx, roles).await?;
+    if granted.is_empty() {
+        return Ok(true);
+    }
+    let held = held_by(ctx, &super::feature_flags::roles_of(msg)).await?;
+    Ok(granted.iter().all(|p| holds(&held, p)))
+}
+
+// ---------------------------------------------------------------------------
+// Catalogue
+// ---------------------------------------------------------------------------
+
+/// Catalogue `name` unless it already is. `Ok(true)` when this call added
+/// it.
+pub async fn register(ctx: &dyn Context, name: &str) -> Result<bool, WaferError> {
+    let found = db::get_by_field(ctx, PERMISSIONS_TABLE, "name", serde_json::json!(name)).await;
+    match found {
+        Ok(_) => return Ok(false),
+        Err(e) if e.code == ErrorCode::NotFound => {}
+        Err(e) => return Err(e),
+    }
+    let (resource, action) = name.split_once('.').unwrap_or((name, ""));
+    let mut data = crate::util::json_map(serde_json::json!({
+        "name": name,
+        "resource": resource,
+        "actions": [action],
+    }));
+    crate::util::stamp_created(&mut data);
+    match db::create(ctx, PERMISSIONS_TABLE, data).await {
+        Ok(_) => Ok(true),
+        // Registered concurrently by another isolate.
+        Err(e) if super::errors::is_unique_violation(&e) => Ok(false),
+        Err(e) => Err(e),
+    }
+}
+
+/// Add `permission` to the role named `role`. A missing role is skipped.
+async fn grant_to_role(ctx: &dyn Context, role: &str, permission: &str) -> Result<(), WaferError> {
+    let row = match db::get_by_field(ctx, ROLES_TABLE, "name", serde_json::json!(role)).await {
+        Ok(row) => row,
+        Err(e) if e.code == ErrorCode::NotFound => return Ok(()),
+        Err(e) => return Err(e),
+    };
+    let mut held = string_list(row.data.get("permissions"));
+    if held.iter().any(|p| p == permission) {
+        return Ok(());
+    }
+    held.push(permission.to_string());
+    let mut data = crate::util::json_map(serde_json::json!({ "permissions": held }));
+    crate::util::stamp_updated(&mut data);
+    db::update(ctx, ROLES_TABLE, &row.id, data).await?;
+    Ok(())
+}
+
+/// Catalogue the [`CORE`] permissions, granting each newly added one to its
+/// default roles. Runs on every admin init; failures are logged.
+pub async fn seed(ctx: &dyn Context) {
+    for permission in CORE {
+        match register(ctx, permission.name).await {
+            Ok(true) => {
+                for role in permission.default_roles {
+                    if let Err(e) = grant_to_role(ctx, role, permission.name).await {
+                        tracing::warn!(
+                            "Failed to grant permission '{}' to role '{role}': {e}",
+                            permission.name
+                        );
+                    }
+                }
+            }
+            Ok(false) => {}
+            Err(e) => tracing::warn!("Failed to seed permission '{}': {e}", permission.name),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{admin_msg, auth_msg, output_json, output_status, TestContext};
+
+    fn held(names: &[&str]) -> Vec<String> {
+        names.iter().map(|n| n.to_string()).collect()
+    }
+
+    #[test]
+    fn holds_matches_exact_and_wildcard_grants() {
+        assert!(holds(&held(&["storage.write"]), "storage.write"));
+        assert!(holds(&held(&["storage.*"]), "storage.write"));
+        assert!(holds(&held(&[ALL]), "logs.read"));
+        assert!(!holds(&held(&["storage.read"]), "storage.write"));
+        assert!(!holds(&held(&["storage.*"]), "logs.read"));
+        assert!(!holds(&held(&[".*"]), "storage.write"));
+        assert!(!holds(&[], "storage.write"));
+    }
+
+    #[test]
+    fn core_routes_carry_their_permissions() {
+        assert_eq!(required("retrieve", "/b/admin/api/logs"), Some("logs.read"));
+        assert_eq!(
+            required("retrieve", "/b/admin/api/users"),
+            Some("users.manage")
+        );
+        assert_eq!(
+            required("create", "/b/admin/api/users/u1/reset-password"),
+            Some("users.manage")
+        );
+        assert_eq!(
+            required("delete", "/b/admin/api/storage/buckets/docs"),
+            Some("storage.admin")
+        );
+        // The rest of the panel stays admin-only.
+        assert_eq!(required("retrieve", "/b/admin/api/database/tables"), None);
+        assert_eq!(required("create", "/b/admin/api/iam/user-roles"), None);
+        assert_eq!(required("retrieve", "/b/admin/"), None);
+        #[cfg(feature = "block-files")]
+        {
+            let upload = "/b/storage/api/buckets/docs/objects";
+            assert_eq!(required("create", upload), Some("storage.write"));
+            assert_eq!(required("retrieve", upload), None);
+            assert_eq!(
+                required("retrieve", "/b/storage/admin/"),
+                Some("storage.admin")
+            );
+        }
+        #[cfg(feature = "block-products")]
+        {
+            let refund = "/b/products/api/admin/purchases/p1/refund";
+            assert_eq!(required("update", refund), Some("billing.manage"));
+            assert_eq!(required("retrieve", "/b/products/catalog"), None);
+        }
+        for permission in CORE {
+            assert!(is_valid_name(permission.name), "{}", permission.name);
+        }
+        // Every scoped role holds only catalogued core permissions.
+        for role in SCOPED_ROLES {
+            for held in role.permissions {
+                assert!(CORE.iter().any(|p| p.name == *held), "{}", role.name);
+            }
+        }
+        assert!(!is_valid_name("storage"));
+        assert!(!is_valid_name("Storage.write"));
+    }
+
+    #[tokio::test]
+    async fn require_names_the_missing_permission() {
+        let ctx = TestContext::with_admin().await;
+        let mut data = crate::util::json_map(serde_json::json!({
+            "name": "user",
+            "permissions": ["storage.write"],
+        }));
+        crate::util::stamp_created(&mut data);
+        db::create(&ctx, ROLES_TABLE, data).await.unwrap();
+
+        let mut msg = auth_msg("retrieve", "/b/admin/api/logs", "u1");
+        msg.set_meta("auth.user_roles", "user");
+        let out = require(&ctx, &msg, "logs.read").await.expect("denied");
+        assert_eq!(output_status(out).await, 403);
+        let out = require(&ctx, &msg, "logs.read").await.unwrap();
+        let body = output_json(out).await;
+        assert_eq!(body["code"], "permission_denied");
+        assert_eq!(body["details"]["permission"], "logs.read");
+
+        assert!(require(&ctx, &msg, "storage.write").await.is_none());
+        let admin = admin_msg("retrieve", "/b/admin/api/logs");
+        assert!(require(&ctx, &admin, "logs.read").await.is_none());
+    }
+
+    #[tokio::test]
+    async fn covers_refuses_roles_beyond_the_caller() {
+        let ctx = TestContext::with_admin().await;
+        for role in SCOPED_ROLES {
+            let mut data = crate::util::json_map(serde_json::json!({
+                "name": role.name,
+                "permissions": role.permissions,
+            }));
+            crate::util::stamp_created(&mut data);
+            db::create(&ctx, ROLES_TABLE, data).await.unwrap();
+        }
+
+        let mut msg = auth_msg("create", "/b/admin/api/invitations", "u1");
+        msg.set_meta("auth.user_roles", "user_admin");
+        assert!(covers(&ctx, &msg, &["user_admin"]).await.unwrap());
+        assert!(covers(&ctx, &msg, &["no_such_role"]).await.unwrap());
+        assert!(!covers(&ctx, &msg, &["user_admin", "storage_admin"])
+            .await
+            .unwrap());
+        assert!(!covers(&ctx, &msg, &["admin"]).await.unwrap());
+
+        let admin = admin_msg("create", "/b/admin/api/invitations");
+        assert!(covers(&ctx, &admin, &["admin", "storage_admin"])
+            .await
+            .unwrap());
+    }
+}
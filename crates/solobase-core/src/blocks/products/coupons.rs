@@ -0,0 +1,768 @@
+//! Coupon and discount codes.
+//!
+//! Codes are matched case-insensitively (stored upper-cased). A coupon takes
+//! a whole percentage or a fixed number of cents off the cart lines whose
+//! product template it applies to (all lines when it lists no templates).
+//! The discount is split across those lines in proportion to their totals
+//! and recorded on each line item and on the purchase.
+//!
+//! A redemption is claimed when the purchase is created, with a conditional
+//! increment (`redemption_count < max_redemptions` in the WHERE) so a
+//! 100-use coupon can't be spent 105 times by concurrent checkouts. It is
+//! handed back when the checkout is abandoned, and on refund only when
+//! `SUPPERS_AI__PRODUCTS__COUPON_RELEASE_ON_REFUND` is on. The per-user limit
+//! is a count check before the claim, so two simultaneous checkouts by the
+//! same user may both pass it.
+
+use std::collections::HashMap;
+
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::{config, database::Record};
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
+
+use super::{purchase::PricedLine, repo, repo::coupons::NewRedemption};
+use crate::{
+    blocks::errors::{self, error_json},
+    http::{err_bad_request, err_conflict, err_internal, err_not_found, ok_json},
+    util::RecordExt,
+};
+
+/// `discount_type` values. `value` is a whole percentage (1-100) for
+/// [`PERCENT`] coupons and cents for [`FIXED`] ones.
+pub(crate) const PERCENT: &str = "percent";
+pub(crate) const FIXED: &str = "fixed";
+
+/// Longest accepted coupon code.
+const MAX_CODE_LEN: usize = 64;
+
+/// Canonical form of a coupon code: trimmed and upper-cased.
+pub(crate) fn normalize_code(code: &str) -> String {
+    code.trim().to_uppercase()
+}
+
+/// A coupon row.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub(crate) struct Coupon {
+    pub id: String,
+    pub code: String,
+    pub discount_type: String,
+    pub value: i64,
+    /// 0 = unlimited.
+    pub max_redemptions: i64,
+    /// 0 = unlimited.
+    pub per_user_limit: i64,
+    pub redemption_count: i64,
+    /// RFC 3339; empty = no bound.
+    pub valid_from: String,
+    /// RFC 3339; empty = no bound.
+    pub valid_to: String,
+    /// Empty = applies to every product.
+    pub product_template_ids: Vec<String>,
+    pub active: bool,
+}
+
+impl Coupon {
+    pub(crate) fn from_record(record: &Record) -> Self {
+        // Stored as JSON text; tolerate a backend that hands back the array.
+        let product_template_ids = match record.data.get("product_template_ids") {
+            Some(serde_json::Value::Array(ids)) => ids
+                .iter()
+                .filter_map(|v| v.as_str().map(str::to_string))
+                .collect(),
+            Some(serde_json::Value::String(s)) => serde_json::from_str(s).unwrap_or_default(),
+            _ => Vec::new(),
+        };
+        Self {
+            id: record.id.clone(),
+            code: record.str_field("code").to_string(),
+            discount_type: record.str_field("discount_type").to_string(),
+            value: record.i64_field("value"),
+            max_redemptions: record.i64_field("max_redemptions"),
+            per_user_limit: record.i64_field("per_user_limit"),
+            redemption_count: record.i64_field("redemption_count"),
+            valid_from: record.str_field("valid_from").to_string(),
+            valid_to: record.str_field("valid_to").to_string(),
+            product_template_ids,
+            active: record.bool_field("active"),
+        }
+    }
+
+    /// The admin-editable columns, for create and update writes.
+    /// `redemption_count` is only ever changed by [`repo::coupons::claim`]
+    /// and [`repo::coupons::unclaim`].
+    fn fields(&self) -> HashMap<String, serde_json::Value> {
+        let ids = serde_json::to_string(&self.product_template_ids).unwrap_or_default();
+        crate::util::json_map(serde_json::json!({
+            "code": self.code,
+            "discount_type": self.discount_type,
+            "value": self.value,
+            "max_redemptions": self.max_redemptions,
+            "per_user_limit": self.per_user_limit,
+            "valid_from": self.valid_from,
+            "valid_to": self.valid_to,
+            "product_template_ids": ids,
+            "active": i64::from(self.active),
+        }))
+    }
+
+    /// Field-level problems with an admin-supplied coupon, for
+    /// `validation_failed` details.
+    pub(crate) fn problems(&self) -> Vec<(&'static str, &'static str)> {
+        let mut problems = Vec::new();
+        if self.code.is_empty() {
+            problems.push(("code", "is required"));
+        } else if self.code.len() > MAX_CODE_LEN
+            || !self
+                .code
+                .chars()
+                .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_')
+        {
+            problems.push((
+                "code",
+                "must be at most 64 letters, digits, hyphens, or underscores",
+            ));
+        }
+        match self.discount_type.as_str() {
+            PERCENT if !(1..=100).contains(&self.value) => {
+                problems.push(("value", "must be a percentage from 1 to 100"));
+            }
+            FIXED if self.value < 1 => {
+                problems.push(("value", "must be a positive number of cents"));
+            }
+            PERCENT | FIXED => {}
+            _ => problems.push(("discount_type", "must be percent or fixed")),
+        }
+        if self.max_redemptions < 0 {
+            problems.push(("max_redemptions", "must be 0 (unlimited) or more"));
+        }
+        if self.per_user_limit < 0 {
+            problems.push(("per_user_limit", "must be 0 (unlimited) or more"));
+        }
+        let from = parse_bound(&self.valid_from);
+        let to = parse_bound(&self.valid_to);
+        if from.is_err() {
+            problems.push(("valid_from", "must be an RFC 3339 timestamp"));
+        }
+        if to.is_err() {
+            problems.push(("valid_to", "must be an RFC 3339 timestamp"));
+        }
+        if let (Ok(Some(from)), Ok(Some(to))) = (from, to) {
+            if to <= from {
+                problems.push(("valid_to", "must be after valid_from"));
+            }
+        }
+        if self
+            .product_template_ids
+            .iter()
+            .any(|id| id.trim().is_empty())
+        {
+            problems.push(("product_template_ids", "must not contain empty ids"));
+        }
+        problems
+    }
+
+    /// Whether the coupon can be used at `now`, ignoring per-user limits.
+    /// The error is the code and message to answer with.
+    pub(crate) fn check_usable(
+        &self,
+        now: chrono::DateTime<chrono::Utc>,
+    ) -> Result<(), (errors::ErrorCode, &'static str)> {
+        if !self.active {
+            return Err((errors::ErrorCode::CouponInvalid, "Coupon code is not valid"));
+        }
+        if let Ok(Some(from)) = parse_bound(&self.valid_from) {
+            if now < from {
+                return Err((
+                    errors::ErrorCode::CouponNotApplicable,
+                    "Coupon is not valid yet",
+                ));
+            }
+        }
+        if let Ok(Some(to)) = parse_bound(&self.valid_to) {
+            if now > to {
+                return Err((errors::ErrorCode::CouponExpired, "Coupon has expired"));
+            }
+        }
+        if self.max_redemptions > 0 && self.redemption_count >= self.max_redemptions {
+            return Err((
+                errors::ErrorCode::CouponExhausted,
+                "Coupon has been fully redeemed",
+            ));
+        }
+        Ok(())
+    }
+
+    /// Whether a product with `product_template_id` is eligible.
+    pub(crate) fn applies_to(&self, product_template_id: &str) -> bool {
+        self.product_template_ids.is_empty()
+            || self
+                .product_template_ids
+                .iter()
+                .any(|id| id == product_template_id)
+    }
+}
+
+/// Parse an optional timestamp bound. `Ok(None)` for an empty string,
+/// `Err(())` when [`crate::util::parse_timestamp`] can't read it.
+fn parse_bound(s: &str) -> Result<Option<chrono::DateTime<chrono::Utc>>, ()> {
+    if s.is_empty() {
+        return Ok(None);
+    }
+    crate::util::parse_timestamp(s).map(Some).ok_or(())
+}
+
+/// Store a bound in the canonical layout; unreadable input is kept as typed
+/// so [`Coupon::problems`] can report it.
+fn normalize_bound(s: &str) -> String {
+    let s = s.trim();
+    match parse_bound(s) {
+        Ok(Some(t)) => crate::util::format_rfc3339(t),
+        _ => s.to_string(),
+    }
+}
+
+/// A coupon priced against a cart.
+#[derive(Debug, Clone, PartialEq)]
+pub(crate) struct Discount {
+    /// Sum of the eligible lines' totals.
+    pub eligible_cents: i64,
+    pub discount_cents: i64,
+    /// The discount split across the cart lines, index-aligned with the
+    /// input; 0 for ineligible lines. Sums to `discount_cents`.
+    pub per_line: Vec<i64>,
+}
+
+/// Price `coupon` against cart lines given as `(product_template_id,
+/// line_total_cents)`. `None` when no line is eligible.
+///
+/// A percentage is rounded to the nearest cent; a fixed amount is capped at
+/// the eligible total. The split gives each eligible line its proportional
+/// share rounded down, then hands the leftover cents to the lines with the
+/// largest remainders, so no line is discounted below zero.
+pub(crate) fn compute_discount(coupon: &Coupon, lines: &[(&str, i64)]) -> Option<Discount> {
+    let eligible: Vec<usize> = (0..lines.len())
+        .filter(|&i| lines[i].1 > 0 && coupon.applies_to(lines[i].0))
+        .collect();
+    if eligible.is_empty() {
+        return None;
+    }
+    let eligible_cents: i64 = eligible.iter().map(|&i| lines[i].1).sum();
+    let discount_cents = match coupon.discount_type.as_str() {
+        PERCENT => {
+            let pct = i128::from(coupon.value.clamp(0, 100));
+            ((i128::from(eligible_cents) * pct + 50) / 100) as i64
+        }
+        _ => coupon.value.clamp(0, eligible_cents),
+    };
+
+    let mut per_line = vec![0i64; lines.len()];
+    let mut remainders = Vec::with_capacity(eligible.len());
+    let mut allotted = 0i64;
+    for &i in &eligible {
+        let scaled = i128::from(discount_cents) * i128::from(lines[i].1);
+        let share = (scaled / i128::from(eligible_cents)) as i64;
+        per_line[i] = share;
+        allotted += share;
+        remainders.push((scaled % i128::from(eligible_cents), i));
+    }
+    remainders.sort_by(|a, b| b.0.cmp(&a.0).then(a.1.cmp(&b.1)));
+    for &(_, i) in remainders.iter().take((discount_cents - allotted) as usize) {
+        per_line[i] += 1;
+    }
+
+    Some(Discount {
+        eligible_cents,
+        discount_cents,
+        per_line,
+    })
+}
+
+/// A usable coupon and what it takes off a specific cart.
+pub(crate) struct Quote {
+    pub coupon: Coupon,
+    pub discount: Discount,
+}
+
+/// Look up `code`, check it is usable by `user_id` right now, and price it
+/// against `lines`. The error is the response to send.
+pub(super) async fn quote(
+    ctx: &dyn Context,
+    code: &str,
+    user_id: &str,
+    lines: &[PricedLine],
+) -> Result<Quote, OutputStream> {
+    let coupon = match repo::coupons::get_by_code(ctx, &normalize_code(code)).await {
+        Ok(record) => Coupon::from_record(&record),
+        Err(e) if e.code == ErrorCode::NotFound => {
+            return Err(error_json(
+                errors::ErrorCode::CouponInvalid,
+                "Coupon code is not valid",
+                None,
+            ))
+        }
+        Err(e) => return Err(err_internal("Database error", e)),
+    };
+    if let Err((code, message)) = coupon.check_usable(crate::clock::now()) {
+        return Err(error_json(code, message, None));
+    }
+
+    if coupon.per_user_limit > 0 {
+        let used = match repo::coupons::user_redemption_count(ctx, &coupon.id, user_id).await {
+            Ok(n) => n,
+            Err(e) => return Err(err_internal("Failed to read coupon redemptions", e)),
+        };
+        if used >= coupon.per_user_limit {
+            return Err(error_json(
+                errors::ErrorCode::CouponExhausted,
+                "You have already used this coupon the maximum number of times",
+                Some(serde_json::json!({"per_user_limit": coupon.per_user_limit})),
+            ));
+        }
+    }
+
+    let cents: Vec<(&str, i64)> = lines
+        .iter()
+        .map(|l| (l.product_template_id.as_str(), l.total_cents()))
+        .collect();
+    let Some(discount) = compute_discount(&coupon, &cents) else {
+        return Err(error_json(
+            errors::ErrorCode::CouponNotApplicable,
+            "Coupon does not apply to any item in the cart",
+            None,
+        ));
+    };
+    Ok(Quote { coupon, discount })
+}
+
+/// Claim one redemption of the quoted coupon for `purchase_id`. Answers
+/// `coupon_exhausted` when the last redemption went to a concurrent
+/// checkout (or the coupon was deactivated) since [`quote`] ran.
+pub(super) async fn redeem(
+    ctx: &dyn Context,
+    quote: &Quote,
+    user_id: &str,
+    purchase_id: &str,
+) -> Result<(), OutputStream> {
+    let coupon = &quote.coupon;
+    match repo::coupons::claim(ctx, &coupon.id, coupon.max_redemptions).await {
+        Ok(true) => {}
+        Ok(false) => {
+            return Err(error_json(
+                errors::ErrorCode::CouponExhausted,
+                "Coupon has been fully redeemed",
+                None,
+            ))
+        }
+        Err(e) => return Err(err_internal("Failed to redeem coupon", e)),
+    }
+    let recorded = repo::coupons::record_redemption(
+        ctx,
+        NewRedemption {
+            coupon_id: &coupon.id,
+            code: &coupon.code,
+            user_id,
+            purchase_id,
+            discount_cents: quote.discount.discount_cents,
+        },
+    )
+    .await;
+    if let Err(e) = recorded {
+        if let Err(undo) = repo::coupons::unclaim(ctx, &coupon.id).await {
+            tracing::error!(error = %undo, coupon_id = %coupon.id, "coupon count not restored");
+        }
+        return Err(err_internal("Failed to record coupon redemption", e));
+    }
+    Ok(())
+}
+
+/// Hand `purchase_id`'s coupon redemption back (abandoned checkout, or a
+/// refund with release enabled). Idempotent: each redemption row flips to
+/// `released` at most once, and only that flip decrements the count.
+pub(crate) async fn release_purchase(
+    ctx: &dyn Context,
+    purchase_id: &str,
+) -> Result<(), wafer_run::WaferError> {
+    for redemption in repo::coupons::active_for_purchase(ctx, purchase_id).await? {
+        if repo::coupons::mark_released(ctx, &redemption.id).await? {
+            repo::coupons::unclaim(ctx, redemption.str_field("coupon_id")).await?;
+        }
+    }
+    Ok(())
+}
+
+/// Release `purchase_id`'s redemption after a refund when
+/// `SUPPERS_AI__PRODUCTS__COUPON_RELEASE_ON_REFUND` is on. Best-effort: the
+/// refund itself has already happened.
+pub(crate) async fn release_on_refund(ctx: &dyn Context, purchase_id: &str) {
+    let enabled = config::get_default(
+        ctx,
+        "SUPPERS_AI__PRODUCTS__COUPON_RELEASE_ON_REFUND",
+        "false",
+    )
+    .await;
+    if enabled != "true" {
+        return;
+    }
+    if let Err(e) = release_purchase(ctx, purchase_id).await {
+        tracing::error!(error = %e, purchase_id = %purchase_id, "coupon not released on refund");
+    }
+}
+
+// --- Admin CRUD ---
+
+/// Admin create/update body. Every field is optional so PATCH can send a
+/// subset; on create, a missing `code`, `discount_type`, or `value` fails
+/// validation.
+#[derive(serde::Deserialize, Default)]
+struct CouponInput {
+    code: Option<String>,
+    discount_type: Option<String>,
+    value: Option<i64>,
+    max_redemptions: Option<i64>,
+    per_user_limit: Option<i64>,
+    valid_from: Option<String>,
+    valid_to: Option<String>,
+    product_template_ids: Option<Vec<String>>,
+    active: Option<bool>,
+}
+
+impl CouponInput {
+    fn apply(self, coupon: &mut Coupon) {
+        if let Some(code) = self.code {
+            coupon.code = normalize_code(&code);
+        }
+        if let Some(discount_type) = self.discount_type {
+            coupon.discount_type = discount_type.trim().to_lowercase();
+        }
+        if let Some(value) = self.value {
+            coupon.value = value;
+        }
+        if let Some(max) = self.max_redemptions {
+            coupon.max_redemptions = max;
+        }
+        if let Some(limit) = self.per_user_limit {
+            coupon.per_user_limit = limit;
+        }
+        if let Some(from) = self.valid_from {
+            coupon.valid_from = normalize_bound(&from);
+        }
+        if let Some(to) = self.valid_to {
+            coupon.valid_to = normalize_bound(&to);
+        }
+        if let Some(ids) = self.product_template_ids {
+            coupon.product_template_ids = ids;
+        }
+        if let Some(active) = self.active {
+            coupon.active = active;
+        }
+    }
+}
+
+/// Path prefix preceding a coupon id in admin requests.
+const PATH_PREFIX: &str = "/admin/b/products/coupons/";
+
+fn coupon_id(msg: &Message) -> String {
+    crate::util::path_param(msg, "id", PATH_PREFIX).to_string()
+}
+
+async fn read_input(input: InputStream) -> Result<CouponInput, OutputStream> {
+    let raw = input.collect_to_bytes().await;
+    serde_json::from_slice(&raw).map_err(|e| err_bad_request(&format!("Invalid body: {e}")))
+}
+
+/// Refuse a code already used by a coupon other than `except_id`. The unique
+/// index still backstops a concurrent create.
+async fn ensure_code_free(
+    ctx: &dyn Context,
+    code: &str,
+    except_id: &str,
+) -> Result<(), OutputStream> {
+    match repo::coupons::get_by_code(ctx, code).await {
+        Ok(existing) if existing.id != except_id => {
+            Err(err_conflict(&format!("Coupon code {code} already exists")))
+        }
+        Ok(_) => Ok(()),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(()),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// `GET /admin/b/products/coupons` — paginated, alphabetical by code;
+/// `?active=true|false` filters.
+pub async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let (page, page_size, _) = msg.pagination_params(20);
+    let mut filters = Vec::new();
+    let active = msg.query("active");
+    if active == "true" || active == "false" {
+        filters.push(Filter {
+            field: "active".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::json!(i64::from(active == "true")),
+        });
+    }
+    match repo::coupons::list_paginated(ctx, filters, page as i64, page_size as i64).await {
+        Ok(result) => ok_json(&result),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `GET /admin/b/products/coupons/{id}`.
+pub async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let id = coupon_id(msg);
+    if id.is_empty() {
+        return err_bad_request("Missing coupon ID");
+    }
+    match repo::coupons::get(ctx, &id).await {
+        Ok(record) => ok_json(&record),
+        Err(e) if e.code == ErrorCode::NotFound => err_not_found("Coupon not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `POST /admin/b/products/coupons`.
+pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let body = match read_input(input).await {
+        Ok(b) => b,
+        Err(resp) => return resp,
+    };
+    let mut coupon = Coupon {
+        active: true,
+        ..Default::default()
+    };
+    body.apply(&mut coupon);
+    let problems = coupon.problems();
+    if !problems.is_empty() {
+        return errors::validation_error("Invalid coupon", &problems);
+    }
+    if let Err(resp) = ensure_code_free(ctx, &coupon.code, "").await {
+        return resp;
+    }
+
+    let mut data = coupon.fields();
+    data.insert("created_by".to_string(), serde_json::json!(msg.user_id()));
+    crate::util::stamp_created(&mut data);
+    match repo::coupons::create(ctx, data).await {
+        Ok(record) => ok_json(&record),
+        Err(e) => err_internal("Failed to create coupon", e),
+    }
+}
+
+/// `PATCH /admin/b/products/coupons/{id}` — the merged coupon is validated
+/// as a whole, so a partial update can't leave it inconsistent.
+pub async fn handle_update(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let id = coupon_id(msg);
+    if id.is_empty() {
+        return err_bad_request("Missing coupon ID");
+    }
+    let body = match read_input(input).await {
+        Ok(b) => b,
+        Err(resp) => return resp,
+    };
+    let mut coupon = match repo::coupons::get(ctx, &id).await {
+        Ok(record) => Coupon::from_record(&record),
+        Err(e) if e.code == ErrorCode::NotFound => return err_not_found("Coupon not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    body.apply(&mut coupon);
+    let problems = coupon.problems();
+    if !problems.is_empty() {
+        return errors::validation_error("Invalid coupon", &problems);
+    }
+    if let Err(resp) = ensure_code_free(ctx, &coupon.code, &id).await {
+        return resp;
+    }
+
+    let mut data = coupon.fields();
+    crate::util::stamp_updated(&mut data);
+    match repo::coupons::update(ctx, &id, data).await {
+        Ok(record) => ok_json(&record),
+        Err(e) => err_internal("Failed to update coupon", e),
+    }
+}
+
+/// `DELETE /admin/b/products/coupons/{id}`. Purchases keep their recorded
+/// `coupon_code` and discount.
+pub async fn handle_delete(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let id = coupon_id(msg);
+    if id.is_empty() {
+        return err_bad_request("Missing coupon ID");
+    }
+    if let Err(e) = repo::coupons::get(ctx, &id).await {
+        if e.code == ErrorCode::NotFound {
+            return err_not_found("Coupon not found");
+        }
+        return err_internal("Database error", e);
+    }
+    match repo::coupons::delete(ctx, &id).await {
+        Ok(()) => ok_json(&serde_json::json!({"deleted": true})),
+        Err(e) => err_internal("Failed to delete coupon", e),
+    }
+}
+
+// --- Cart validation ---
+
+/// `POST /b/products/coupons/validate` — what `code` would take off the
+/// given cart for the caller, without claiming a redemption.
+pub async fn handle_validate(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct ValidateReq {
+        code: String,
+        items: Vec<super::purchase::CartItem>,
+    }
+
+    let raw = input.collect_to_bytes().await;
+    let body: ValidateReq = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+    if body.items.is_empty() {
+        return err_bad_request("No items in cart");
+    }
+    let lines = match super::purchase::price_cart(ctx, &body.items).await {
+        Ok(lines) => lines,
+        Err(resp) => return resp,
+    };
+    let subtotal_cents = match super::purchase::cart_total_cents(&lines) {
+        Ok(n) => n,
+        Err(resp) => return resp,
+    };
+    let quoted = match quote(ctx, &body.code, msg.user_id(), &lines).await {
+        Ok(q) => q,
+        Err(resp) => return resp,
+    };
+
+    let line_discounts: Vec<serde_json::Value> = lines
+        .iter()
+        .zip(&quoted.discount.per_line)
+        .map(|(line, discount)| {
+            serde_json::json!({
+                "product_id": line.product_id,
+                "total_cents": line.total_cents(),
+                "discount_cents": discount,
+            })
+        })
+        .collect();
+    ok_json(&serde_json::json!({
+        "code": quoted.coupon.code,
+        "discount_type": quoted.coupon.discount_type,
+        "value": quoted.coupon.value,
+        "subtotal_cents": subtotal_cents,
+        "eligible_cents": quoted.discount.eligible_cents,
+        "discount_cents": quoted.discount.discount_cents,
+        "total_cents": subtotal_cents - quoted.discount.discount_cents,
+        "lines": line_discounts,
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn coupon(discount_type: &str, value: i64) -> Coupon {
+        Coupon {
+            id: "c1".to_string(),
+            code: "SAVE".to_string(),
+            discount_type: discount_type.to_string(),
+            value,
+            active: true,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn percent_discount_rounds_and_splits_proportionally() {
+        let c = coupon(PERCENT, 15);
+        let d = compute_discount(&c, &[("t", 1000), ("t", 333)]).unwrap();
+        // 15% of 1333 = 199.95 -> 200.
+        assert_eq!(d.discount_cents, 200);
+        assert_eq!(d.per_line.iter().sum::<i64>(), 200);
+        assert_eq!(d.per_line, vec![150, 50]);
+    }
+
+    #[test]
+    fn fixed_discount_is_capped_at_eligible_total() {
+        let c = coupon(FIXED, 5000);
+        let d = compute_discount(&c, &[("t", 1200)]).unwrap();
+        assert_eq!(d.discount_cents, 1200);
+        assert_eq!(d.per_line, vec![1200]);
+    }
+
+    #[test]
+    fn template_restriction_skips_other_lines() {
+        let mut c = coupon(PERCENT, 50);
+        c.product_template_ids = vec!["books".to_string()];
+        let d = compute_discount(&c, &[("games", 1000), ("books", 400)]).unwrap();
+        assert_eq!(d.eligible_cents, 400);
+        assert_eq!(d.per_line, vec![0, 200]);
+        assert!(compute_discount(&c, &[("games", 1000)]).is_none());
+    }
+
+    #[test]
+    fn leftover_cents_go_to_the_largest_remainders() {
+        let c = coupon(FIXED, 100);
+        let d = compute_discount(&c, &[("t", 1), ("t", 1), ("t", 1)]).unwrap();
+        // Capped at the 3-cent total, one cent per line.
+        assert_eq!(d.per_line, vec![1, 1, 1]);
+
+        let c = coupon(FIXED, 10);
+        let d = compute_discount(&c, &[("t", 300), ("t", 300), ("t", 300)]).unwrap();
+        assert_eq!(d.per_line.iter().sum::<i64>(), 10);
+        assert!(d.per_line.iter().all(|&n| n == 3 || n == 4));
+    }
+
+    #[test]
+    fn usability_checks_map_to_specific_codes() {
+        let now = crate::clock::now();
+        let mut c = coupon(PERCENT, 10);
+        assert!(c.check_usable(now).is_ok());
+
+        c.valid_to = crate::util::format_rfc3339(now - chrono::Duration::days(1));
+        assert_eq!(
+            c.check_usable(now).unwrap_err().0,
+            errors::ErrorCode::CouponExpired
+        );
+
+        c.valid_to = String::new();
+        c.valid_from = crate::util::format_rfc3339(now + chrono::Duration::days(1));
+        assert_eq!(
+            c.check_usable(now).unwrap_err().0,
+            errors::ErrorCode::CouponNotApplicable
+        );
+
+        c.valid_from = String::new();
+        c.max_redemptions = 2;
+        c.redemption_count = 2;
+        assert_eq!(
+            c.check_usable(now).unwrap_err().0,
+            errors::ErrorCode::CouponExhausted
+        );
+
+        c.redemption_count = 0;
+        c.active = false;
+        assert_eq!(
+            c.check_usable(now).unwrap_err().0,
+            errors::ErrorCode::CouponInvalid
+        );
+    }
+
+    #[test]
+    fn problems_flag_bad_admin_input() {
+        let mut c = coupon("bogus", 10);
+        c.code = "NO SPACES".to_string();
+        c.valid_from = "2026-02-01T00:00:00Z".to_string();
+        c.valid_to = "2026-01-01T00:00:00Z".to_string();
+        let fields: Vec<&str> = c.problems().iter().map(|(f, _)| *f).collect();
+        assert_eq!(fields, vec!["code", "discount_type", "valid_to"]);
+
+        assert!(coupon(PERCENT, 101)
+            .problems()
+            .iter()
+            .any(|(f, _)| *f == "value"));
+        assert!(coupon(FIXED, 250).problems().is_empty());
+    }
+
+    #[test]
+    fn codes_are_case_insensitive() {
+        assert_eq!(normalize_code("  summer-24 "), "SUMMER-24");
+    }
+}
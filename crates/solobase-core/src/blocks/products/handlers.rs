@@ -1,25 +1,32 @@
 //! Admin- and user-facing HTTP handlers for the suppers-ai/products block.
 //!
 //! Dispatches under `/admin/b/products/...` (admin CRUD on products, groups,
-//! types, pricing templates, purchases, stats) and `/b/products/...` (catalog,
-//! user-owned products/groups when `SOLOBASE_SHARED__ALLOW_USER_PRODUCTS` is
-//! enabled, calculate-price, purchases, checkout, subscription status).
+//! types, pricing templates, coupons, purchases, stats, configuration
+//! export/import) and `/b/products/...` (catalog, user-owned products/groups
+//! when `SOLOBASE_SHARED__ALLOW_USER_PRODUCTS` is enabled, calculate-price,
+//! coupon validation, purchases, checkout, subscription status).
 //! Stripe webhook + checkout-session flows live in the sibling `stripe` module.
 
 use std::collections::HashMap;
 
 use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
 use wafer_core::clients::{config, database as db};
-use wafer_run::{context::Context, ErrorCode, HttpMethod, InputStream, Message, OutputStream};
+use wafer_run::{
+    context::Context, ErrorCode, HttpMethod, InputStream, Message, OutputStream, WaferError,
+};
 
 use super::PRICING_TABLE;
 use crate::{
     blocks::crud,
+    cache::LruCache,
+    detail::{self, DetailQuery, DetailSpec},
     endpoint_match::{self, EndpointRoute},
+    etag,
     http::{
         err_bad_request, err_forbidden, err_internal, err_not_found, err_unauthorized, ok_json,
     },
-    util::{field_as_string, stamp_created, RecordExt},
+    pagination::{self, ListSpec},
+    util::{escape_like, field_as_string, path_param, stamp_created, RecordExt},
 };
 
 /// Admin JSON-API dispatch targets (normalized `/admin/b/products/...`).
@@ -30,6 +37,12 @@ pub(crate) enum AdminRoute {
     CreateProduct,
     UpdateProduct,
     DeleteProduct,
+    GetStock,
+    AdjustStock,
+    ListMedia,
+    AddMedia,
+    ReorderMedia,
+    DeleteMedia,
     ListGroups,
     CreateGroup,
     UpdateGroup,
@@ -49,12 +62,25 @@ pub(crate) enum AdminRoute {
     RefundPurchase,
     GetPurchase,
     Stats,
+    ListCoupons,
+    CreateCoupon,
+    GetCoupon,
+    UpdateCoupon,
+    DeleteCoupon,
+    ListTaxRates,
+    CreateTaxRate,
+    GetTaxRate,
+    UpdateTaxRate,
+    DeleteTaxRate,
+    ExportConfig,
+    ImportConfig,
 }
 
 /// Admin dispatch table over the normalized `/admin/b/products/...` paths.
 /// The `purchases/{id}/refund` template precedes the generic
 /// `purchases/{id}` so the refund route wins (the old `ends_with("/refund")`
-/// guard).
+/// guard); likewise `products/{id}/stock` and `products/{id}/media`
+/// precede `products/{id}`.
 const ADMIN_ROUTES: &[EndpointRoute<AdminRoute>] = &[
     EndpointRoute::new(
         HttpMethod::Get,
@@ -66,6 +92,36 @@ const ADMIN_ROUTES: &[EndpointRoute<AdminRoute>] = &[
         "/admin/b/products/products",
         AdminRoute::CreateProduct,
     ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/admin/b/products/products/{id}/stock",
+        AdminRoute::GetStock,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/admin/b/products/products/{id}/stock",
+        AdminRoute::AdjustStock,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/admin/b/products/products/{id}/media",
+        AdminRoute::ListMedia,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/admin/b/products/products/{id}/media",
+        AdminRoute::AddMedia,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/admin/b/products/products/{id}/media",
+        AdminRoute::ReorderMedia,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/admin/b/products/products/{id}/media/{media_id}",
+        AdminRoute::DeleteMedia,
+    ),
     EndpointRoute::new(
         HttpMethod::Get,
         "/admin/b/products/products/{id}",
@@ -176,6 +232,66 @@ const ADMIN_ROUTES: &[EndpointRoute<AdminRoute>] = &[
         "/admin/b/products/stats",
         AdminRoute::Stats,
     ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/admin/b/products/coupons",
+        AdminRoute::ListCoupons,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/admin/b/products/coupons",
+        AdminRoute::CreateCoupon,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/admin/b/products/coupons/{id}",
+        AdminRoute::GetCoupon,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/admin/b/products/coupons/{id}",
+        AdminRoute::UpdateCoupon,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/admin/b/products/coupons/{id}",
+        AdminRoute::DeleteCoupon,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/admin/b/products/tax-rates",
+        AdminRoute::ListTaxRates,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/admin/b/products/tax-rates",
+        AdminRoute::CreateTaxRate,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/admin/b/products/tax-rates/{id}",
+        AdminRoute::GetTaxRate,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/admin/b/products/tax-rates/{id}",
+        AdminRoute::UpdateTaxRate,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/admin/b/products/tax-rates/{id}",
+        AdminRoute::DeleteTaxRate,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/admin/b/products/export",
+        AdminRoute::ExportConfig,
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/admin/b/products/import",
+        AdminRoute::ImportConfig,
+    ),
 ];
 
 /// User-facing dispatch targets (normalized `/b/products/...`).
@@ -196,7 +312,9 @@ pub(crate) enum UserRoute {
     GroupTemplates,
     Catalog,
     CatalogItem,
+    Media,
     CalculatePrice,
+    ValidateCoupon,
     CreatePurchase,
     ListPurchases,
     GetPurchase,
@@ -299,12 +417,22 @@ const USER_ROUTES: &[EndpointRoute<UserRoute>] = &[
         "/b/products/catalog/{id}",
         UserRoute::CatalogItem,
     ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/products/media/{media_id}",
+        UserRoute::Media,
+    ),
     // Pricing / purchases / checkout
     EndpointRoute::new(
         HttpMethod::Post,
         "/b/products/calculate-price",
         UserRoute::CalculatePrice,
     ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/products/coupons/validate",
+        UserRoute::ValidateCoupon,
+    ),
     EndpointRoute::new(
         HttpMethod::Post,
         "/b/products/purchases",
@@ -360,29 +488,28 @@ pub(super) async fn user_products_enabled(ctx: &dyn Context) -> bool {
 /// real (UUIDv7) row instead of the literal integer `1` (which never
 /// matches the seeded record and breaks any FK constraint).
 async fn default_template_id(ctx: &dyn Context, table: &str) -> Option<String> {
-    db::get_by_field(ctx, table, "name", serde_json::json!("default"))
+    DEFAULT_TEMPLATES
+        .get_or_try_load(table.to_string(), || async {
+            match db::get_by_field(ctx, table, "name", serde_json::json!("default")).await {
+                Ok(r) => Ok(Some(r.id)),
+                Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+                Err(e) => Err(e),
+            }
+        })
         .await
         .ok()
-        .map(|r| r.id)
-}
-
-/// Escape SQL LIKE wildcards (`%`, `_`) and the escape char (`\`) in user
-/// input so a user searching for `100% off` doesn't also match arbitrary
-/// characters.
-fn escape_like(input: &str) -> String {
-    let mut out = String::with_capacity(input.len());
-    for c in input.chars() {
-        match c {
-            '\\' | '%' | '_' => {
-                out.push('\\');
-                out.push(c);
-            }
-            other => out.push(other),
-        }
-    }
-    out
+        .flatten()
 }
 
+/// The `default` template's id per template table. Template rows are only
+/// written by migrations and the products import, which invalidates them.
+static DEFAULT_TEMPLATES: LruCache<String, Option<String>> = LruCache::new(
+    "products.default_templates",
+    &[GROUP_TEMPLATES_TABLE, PRODUCT_TEMPLATES_TABLE],
+    8,
+    std::time::Duration::from_secs(300),
+);
+
 /// Build a `name LIKE %search%` filter with LIKE wildcards escaped.
 /// Returns `None` for an empty search term.
 pub(super) fn name_like_filter(search: &str) -> Option<Filter> {
@@ -460,6 +587,12 @@ pub async fn handle_admin(
         AdminRoute::CreateProduct => handle_create_product(ctx, msg, input).await,
         AdminRoute::UpdateProduct => handle_update_product(ctx, msg, input).await,
         AdminRoute::DeleteProduct => handle_delete_product(ctx, msg).await,
+        AdminRoute::GetStock => super::inventory::handle_get_stock(ctx, msg).await,
+        AdminRoute::AdjustStock => super::inventory::handle_adjust_stock(ctx, msg, input).await,
+        AdminRoute::ListMedia => super::media::handle_list(ctx, msg).await,
+        AdminRoute::AddMedia => super::media::handle_add(ctx, msg, input).await,
+        AdminRoute::ReorderMedia => super::media::handle_reorder(ctx, msg, input).await,
+        AdminRoute::DeleteMedia => super::media::handle_delete(ctx, msg).await,
         AdminRoute::ListGroups => handle_list_groups(ctx, msg).await,
         AdminRoute::CreateGroup => handle_create_group(ctx, msg, input).await,
         AdminRoute::UpdateGroup => handle_update_group(ctx, msg, input).await,
@@ -479,6 +612,18 @@ pub async fn handle_admin(
         AdminRoute::RefundPurchase => super::purchase::handle_refund(ctx, msg, input).await,
         AdminRoute::GetPurchase => super::purchase::handle_get(ctx, msg).await,
         AdminRoute::Stats => handle_stats(ctx, msg).await,
+        AdminRoute::ListCoupons => super::coupons::handle_list(ctx, msg).await,
+        AdminRoute::CreateCoupon => super::coupons::handle_create(ctx, msg, input).await,
+        AdminRoute::GetCoupon => super::coupons::handle_get(ctx, msg).await,
+        AdminRoute::UpdateCoupon => super::coupons::handle_update(ctx, msg, input).await,
+        AdminRoute::DeleteCoupon => super::coupons::handle_delete(ctx, msg).await,
+        AdminRoute::ListTaxRates => super::tax::handle_list(ctx, msg).await,
+        AdminRoute::CreateTaxRate => super::tax::handle_create(ctx, input).await,
+        AdminRoute::GetTaxRate => super::tax::handle_get(ctx, msg).await,
+        AdminRoute::UpdateTaxRate => super::tax::handle_update(ctx, msg, input).await,
+        AdminRoute::DeleteTaxRate => super::tax::handle_delete(ctx, msg).await,
+        AdminRoute::ExportConfig => super::transfer::handle_export(ctx).await,
+        AdminRoute::ImportConfig => super::transfer::handle_import(ctx, msg, input).await,
     }
 }
 
@@ -524,7 +669,9 @@ pub async fn handle_user(
         UserRoute::GroupTemplates => handle_user_list_group_templates(ctx, msg).await,
         UserRoute::Catalog => handle_catalog(ctx, msg).await,
         UserRoute::CatalogItem => handle_get_product_public(ctx, msg).await,
+        UserRoute::Media => super::media::handle_serve(ctx, msg).await,
         UserRoute::CalculatePrice => super::pricing::handle_calculate(ctx, input).await,
+        UserRoute::ValidateCoupon => super::coupons::handle_validate(ctx, msg, input).await,
         UserRoute::CreatePurchase => super::purchase::handle_create(ctx, msg, input).await,
         UserRoute::ListPurchases => super::purchase::handle_list_user(ctx, msg).await,
         UserRoute::GetPurchase => super::purchase::handle_get(ctx, msg).await,
@@ -536,18 +683,142 @@ pub async fn handle_user(
 // --- Product CRUD ---
 
 async fn handle_list_products(ctx: &dyn Context, msg: &Message) -> OutputStream {
-    crud::crud_list(ctx, msg, PRODUCTS_TABLE, product_filters(msg), None).await
+    list_products_annotated(ctx, msg, product_filters(msg), None).await
+}
+
+/// Sort and page-size limits shared by the admin product list and the
+/// public catalog (see [`crate::pagination`]).
+const PRODUCT_LIST_SPEC: ListSpec = ListSpec {
+    default_limit: 20,
+    max_limit: 100,
+    sortable: &["created_at", "updated_at", "name"],
+    default_sort: "created_at",
+    default_desc: true,
+};
+
+/// Paginated product rows, with each record stamped with the derived
+/// `out_of_stock` flag and its resolved `media`. `default_sort` overrides
+/// the spec's default order (the catalog lists by name); an explicit
+/// `?sort=` still wins.
+async fn list_products_annotated(
+    ctx: &dyn Context,
+    msg: &Message,
+    filters: Vec<Filter>,
+    default_sort: Option<(&'static str, bool)>,
+) -> OutputStream {
+    let mut spec = PRODUCT_LIST_SPEC;
+    if let Some((field, desc)) = default_sort {
+        spec.default_sort = field;
+        spec.default_desc = desc;
+    }
+    let query = match pagination::parse(msg, &spec) {
+        Ok(q) => q,
+        Err(e) => return e.response(),
+    };
+    match pagination::fetch(ctx, PRODUCTS_TABLE, filters, &query).await {
+        Ok(mut page) => {
+            page.records_mut()
+                .iter_mut()
+                .for_each(super::inventory::annotate);
+            super::media::annotate_all(ctx, page.records_mut()).await;
+            ok_json(&page.into_json(query.fields.as_deref()))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `?fields=` / `?expand=` on product detail (admin and catalog). `media`
+/// was always part of the body, so it stays a default expansion.
+const PRODUCT_DETAIL_SPEC: DetailSpec = DetailSpec {
+    id_field: "id",
+    fields: &[
+        "name",
+        "description",
+        "slug",
+        "base_price",
+        "currency",
+        "status",
+        "category",
+        "tags",
+        "metadata",
+        "image_url",
+        "stock",
+        "track_inventory",
+        "out_of_stock",
+        "group_id",
+        "type_id",
+        "group_template_id",
+        "product_template_id",
+        "pricing_template_id",
+        "requires",
+        "created_by",
+        "deleted_at",
+        "created_at",
+        "updated_at",
+    ],
+    expand: &["media", "template", "group"],
+    default_expand: &["media"],
+};
+
+/// Load the expansions `query` asks for onto `record`, trim it and respond.
+/// `template` and `group` are the rows the product's `product_template_id`
+/// and `group_id` point at, `null` when unset or gone.
+async fn product_detail(
+    ctx: &dyn Context,
+    msg: &Message,
+    query: &DetailQuery,
+    mut record: db::Record,
+) -> OutputStream {
+    if query.expands("media") {
+        super::media::annotate(ctx, &mut record).await;
+    }
+    for (name, table, field) in [
+        ("template", PRODUCT_TEMPLATES_TABLE, "product_template_id"),
+        ("group", GROUPS_TABLE, "group_id"),
+    ] {
+        if !query.expands(name) {
+            continue;
+        }
+        let related = match related_row(ctx, table, record.str_field(field)).await {
+            Ok(related) => related,
+            Err(e) => return err_internal("Database error", e),
+        };
+        record.data.insert(name.to_string(), related);
+    }
+    query.project_record(&mut record);
+    etag::ok_json(msg, &record)
+}
+
+/// Row `id` of `table` as JSON; `null` when `id` is empty or not found.
+async fn related_row(
+    ctx: &dyn Context,
+    table: &str,
+    id: &str,
+) -> Result<serde_json::Value, WaferError> {
+    if id.is_empty() {
+        return Ok(serde_json::Value::Null);
+    }
+    match db::get(ctx, table, id).await {
+        Ok(row) => Ok(serde_json::to_value(row).unwrap_or_default()),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(serde_json::Value::Null),
+        Err(e) => Err(e),
+    }
 }
 
 async fn handle_get_product(ctx: &dyn Context, msg: &Message) -> OutputStream {
-    crud::crud_get(
-        ctx,
-        msg,
-        PRODUCTS_TABLE,
-        "/admin/b/products/products/",
-        "Product",
-    )
-    .await
+    let id = path_param(msg, "id", "/admin/b/products/products/");
+    if id.is_empty() {
+        return err_bad_request("Missing product ID");
+    }
+    let query = match detail::parse(msg, &PRODUCT_DETAIL_SPEC) {
+        Ok(query) => query,
+        Err(r) => return r,
+    };
+    match db::get(ctx, PRODUCTS_TABLE, id).await {
+        Ok(record) => product_detail(ctx, msg, &query, record).await,
+        Err(e) if e.code == ErrorCode::NotFound => err_not_found("Product not found"),
+        Err(e) => err_internal("Database error", e),
+    }
 }
 
 async fn handle_create_product(
@@ -583,15 +854,28 @@ async fn handle_update_product(
     .await
 }
 
+/// Delete a product and its media. `?delete_objects=true` also deletes the
+/// files objects the media was copied from.
 async fn handle_delete_product(ctx: &dyn Context, msg: &Message) -> OutputStream {
-    crud::crud_delete(
-        ctx,
-        msg,
-        PRODUCTS_TABLE,
-        "/admin/b/products/products/",
-        "Product",
-    )
-    .await
+    let id = path_param(msg, "id", "/admin/b/products/products/");
+    if id.is_empty() {
+        return err_bad_request("Missing product ID");
+    }
+    delete_product_with_media(ctx, msg, id).await
+}
+
+/// Shared tail of the admin and owner product deletes: remove the row, then
+/// its media (see [`super::media::remove_for_product`]).
+async fn delete_product_with_media(ctx: &dyn Context, msg: &Message, id: &str) -> OutputStream {
+    match db::delete(ctx, PRODUCTS_TABLE, id).await {
+        Ok(()) => {
+            let delete_objects = msg.query("delete_objects") == "true";
+            let removed = super::media::remove_for_product(ctx, msg, id, delete_objects).await;
+            ok_json(&serde_json::json!({"deleted": true, "media_removed": removed}))
+        }
+        Err(e) if e.code == ErrorCode::NotFound => err_not_found("Product not found"),
+        Err(e) => err_internal("Database error", e),
+    }
 }
 
 // --- Groups ---
@@ -688,11 +972,7 @@ async fn handle_catalog(ctx: &dyn Context, msg: &Message) -> OutputStream {
         operator: FilterOp::Equal,
         value: serde_json::Value::String("active".to_string()),
     }];
-    let sort = vec![SortField {
-        field: "name".to_string(),
-        desc: false,
-    }];
-    crud::crud_list(ctx, msg, PRODUCTS_TABLE, filters, Some(sort)).await
+    list_products_annotated(ctx, msg, filters, Some(("name", false))).await
 }
 
 async fn handle_get_product_public(ctx: &dyn Context, msg: &Message) -> OutputStream {
@@ -709,14 +989,19 @@ async fn handle_get_product_public(ctx: &dyn Context, msg: &Message) -> OutputSt
     if id.is_empty() {
         return err_bad_request("Missing product ID");
     }
+    let query = match detail::parse(msg, &PRODUCT_DETAIL_SPEC) {
+        Ok(query) => query,
+        Err(r) => return r,
+    };
 
     match db::get(ctx, PRODUCTS_TABLE, id).await {
-        Ok(record) => {
+        Ok(mut record) => {
             let status = record.str_field("status");
             if status != "active" {
                 return err_not_found("Product not found");
             }
-            ok_json(&record)
+            super::inventory::annotate(&mut record);
+            product_detail(ctx, msg, &query, record).await
         }
         Err(e) if e.code == ErrorCode::NotFound => err_not_found("Product not found"),
         Err(e) => err_internal("Database error", e),
@@ -818,7 +1103,23 @@ async fn handle_user_update_product(
 }
 
 async fn handle_user_delete_product(ctx: &dyn Context, msg: &Message) -> OutputStream {
-    crud::crud_delete_owned(ctx, msg, &USER_PRODUCT).await
+    let id = path_param(msg, "id", USER_PRODUCT.path_prefix);
+    if id.is_empty() {
+        return err_bad_request("Missing product ID");
+    }
+    if let Err(resp) = crud::verify_owner(
+        ctx,
+        PRODUCTS_TABLE,
+        id,
+        USER_PRODUCT.owner_field,
+        msg.user_id(),
+        USER_PRODUCT.label,
+    )
+    .await
+    {
+        return resp;
+    }
+    delete_product_with_media(ctx, msg, id).await
 }
 
 // --- User's own groups ---
@@ -0,0 +1,395 @@
+//! Stock tracking for products with `track_inventory` set.
+//!
+//! `stock` is on-hand quantity. A pending purchase holds its quantities in
+//! `stock_reservations` for [`RESERVATION_TTL_MINUTES`]; available stock is
+//! `stock` minus unexpired reservations, and the built-in
+//! [`RELEASE_SCHEDULE`] deletes expired holds. Payment commits the purchase
+//! with a conditional decrement (`stock >= qty` in the WHERE), so
+//! concurrent completions can never oversell; a refund puts back exactly
+//! what the commit took. Every change is recorded in `stock_adjustments`.
+//!
+//! Untracked products (the default) skip all of this.
+
+use std::collections::BTreeMap;
+
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
+
+use super::{repo, repo::inventory::NewAdjustment, PRODUCTS_TABLE};
+use crate::{
+    blocks::{
+        errors::{self, error_json},
+        jobs::JobError,
+    },
+    http::{err_bad_request, err_internal, err_not_found, ok_json},
+    util::RecordExt,
+};
+
+/// How long a pending purchase holds its stock before it is released back
+/// to other buyers.
+pub(crate) const RESERVATION_TTL_MINUTES: i64 = 30;
+
+/// Name of the built-in schedule that releases expired holds.
+pub const RELEASE_SCHEDULE: &str = "products.reservations";
+/// Job type for [`run_release_job`], queued by [`RELEASE_SCHEDULE`].
+pub const RELEASE_JOB: &str = "products.reservations.release";
+
+/// Adjustment reasons. `purchase`/`refund` rows double as the at-most-once
+/// guard for committing and restoring each product of a purchase.
+const REASON_MANUAL: &str = "manual";
+const REASON_PURCHASE: &str = "purchase";
+const REASON_REFUND: &str = "refund";
+
+/// Whether stock is enforced for this product.
+pub(crate) fn tracks(product: &Record) -> bool {
+    product.bool_field("track_inventory")
+}
+
+/// Whether the catalog should show this product as sold out. Reservations
+/// are ignored here: a held unit may still come back.
+pub(crate) fn out_of_stock(product: &Record) -> bool {
+    tracks(product) && product.i64_field("stock") <= 0
+}
+
+/// Stamp the derived `out_of_stock` flag onto a product record for list
+/// and detail responses.
+pub(crate) fn annotate(product: &mut Record) {
+    let flag = out_of_stock(product);
+    product
+        .data
+        .insert("out_of_stock".to_string(), serde_json::json!(flag));
+}
+
+/// A purchase's line-item quantities summed per product (a product may
+/// appear on several lines).
+async fn purchase_quantities(
+    ctx: &dyn Context,
+    purchase_id: &str,
+) -> Result<BTreeMap<String, i64>, wafer_run::WaferError> {
+    let items = repo::purchases::list_line_items(ctx, purchase_id).await?;
+    let mut totals = BTreeMap::new();
+    for item in &items {
+        let product_id = item.str_field("product_id");
+        let qty = item.i64_field("quantity");
+        if product_id.is_empty() || qty <= 0 {
+            continue;
+        }
+        *totals.entry(product_id.to_string()).or_insert(0) += qty;
+    }
+    Ok(totals)
+}
+
+/// Check and hold stock for every tracked product on `purchase_id`,
+/// replacing any earlier hold by the same purchase. Returns the response to
+/// send on shortfall (`insufficient_stock`, 409, with the product and the
+/// quantity still available).
+pub(crate) async fn reserve_for_purchase(
+    ctx: &dyn Context,
+    purchase_id: &str,
+) -> Result<(), OutputStream> {
+    let quantities = match purchase_quantities(ctx, purchase_id).await {
+        Ok(q) => q,
+        Err(e) => return Err(err_internal("Failed to read purchase items", e)),
+    };
+    let now = crate::clock::now();
+    let now_str = crate::util::format_rfc3339(now);
+
+    let mut holds = Vec::new();
+    for (product_id, qty) in quantities {
+        let product = match db::get(ctx, PRODUCTS_TABLE, &product_id).await {
+            Ok(p) => p,
+            Err(e) if e.code == ErrorCode::NotFound => {
+                return Err(err_not_found(&format!("Product {product_id} not found")))
+            }
+            Err(e) => return Err(err_internal("Database error", e)),
+        };
+        if !tracks(&product) {
+            continue;
+        }
+        let reserved =
+            match repo::inventory::reserved_quantity(ctx, &product_id, &now_str, Some(purchase_id))
+                .await
+            {
+                Ok(n) => n,
+                Err(e) => return Err(err_internal("Failed to read stock reservations", e)),
+            };
+        let available = (product.i64_field("stock") - reserved).max(0);
+        if qty > available {
+            return Err(error_json(
+                errors::ErrorCode::InsufficientStock,
+                &format!("Only {available} of product {product_id} available"),
+                Some(serde_json::json!({
+                    "product_id": product_id,
+                    "requested": qty,
+                    "available": available,
+                })),
+            ));
+        }
+        holds.push((product_id, qty));
+    }
+
+    if holds.is_empty() {
+        return Ok(());
+    }
+    let expires_at =
+        crate::util::format_rfc3339(now + chrono::Duration::minutes(RESERVATION_TTL_MINUTES));
+    repo::inventory::replace_reservations(ctx, purchase_id, &holds, &expires_at)
+        .await
+        .map_err(|e| err_internal("Failed to reserve stock", e))
+}
+
+/// Release `purchase_id`'s hold early (checkout expired or abandoned).
+/// Best-effort: the hold lapses on its own at `expires_at` anyway.
+pub(crate) async fn release_purchase(ctx: &dyn Context, purchase_id: &str) {
+    if let Err(e) = repo::inventory::release_reservations(ctx, purchase_id).await {
+        tracing::warn!(
+            error = %e,
+            purchase_id = %purchase_id,
+            "failed to release stock reservation"
+        );
+    }
+}
+
+/// Take a paid purchase's quantities out of stock and drop its hold.
+/// Idempotent across webhook retries and concurrent deliveries: each
+/// product's `purchase` adjustment row is claimed before its stock is
+/// touched, and only one commit can claim it (see
+/// [`repo::inventory::claim_adjustment`]), so a retry after a commit that
+/// failed part-way takes only the products it didn't reach.
+///
+/// Payment has already been captured, so a failed decrement (stock sold
+/// out from under an expired hold) can't refuse the sale; it is logged for
+/// an admin to resolve and the remaining lines still commit.
+pub(crate) async fn commit_purchase(
+    ctx: &dyn Context,
+    purchase_id: &str,
+) -> Result<(), wafer_run::WaferError> {
+    for (product_id, qty) in purchase_quantities(ctx, purchase_id).await? {
+        let Ok(product) = db::get(ctx, PRODUCTS_TABLE, &product_id).await else {
+            continue;
+        };
+        if !tracks(&product) {
+            continue;
+        }
+        let claim = NewAdjustment {
+            product_id: &product_id,
+            delta: -qty,
+            reason: REASON_PURCHASE,
+            purchase_id,
+            actor: "",
+            note: "",
+        };
+        let Some(claim) = repo::inventory::claim_adjustment(ctx, claim).await? else {
+            continue;
+        };
+        let taken = repo::inventory::decrement_stock(ctx, &product_id, qty).await;
+        if !matches!(taken, Ok(true)) {
+            // The stock wasn't taken, so drop the guard row: a retry tries
+            // again and a refund doesn't put back what never left.
+            unclaim(ctx, &claim, purchase_id).await;
+        }
+        if !taken? {
+            tracing::error!(
+                purchase_id = %purchase_id,
+                product_id = %product_id,
+                quantity = qty,
+                "paid purchase exceeds remaining stock; not decremented"
+            );
+        }
+    }
+    repo::inventory::release_reservations(ctx, purchase_id).await
+}
+
+/// Put back what [`commit_purchase`] took for a refunded or cancelled
+/// purchase. Driven by the `purchase` adjustment rows rather than the
+/// current `track_inventory` flags, so it restores exactly what was taken;
+/// a product's `refund` row, claimed like the `purchase` one, makes it a
+/// no-op for that product on repeat.
+pub(crate) async fn restore_purchase(
+    ctx: &dyn Context,
+    purchase_id: &str,
+    actor: &str,
+) -> Result<(), wafer_run::WaferError> {
+    let taken =
+        repo::inventory::list_purchase_adjustments(ctx, purchase_id, REASON_PURCHASE).await?;
+    for adj in &taken {
+        let product_id = adj.str_field("product_id");
+        let qty = -adj.i64_field("delta");
+        if product_id.is_empty() || qty <= 0 {
+            continue;
+        }
+        let claim = NewAdjustment {
+            product_id,
+            delta: qty,
+            reason: REASON_REFUND,
+            purchase_id,
+            actor,
+            note: "",
+        };
+        let Some(claim) = repo::inventory::claim_adjustment(ctx, claim).await? else {
+            continue;
+        };
+        let restored = repo::inventory::increment_stock(ctx, product_id, qty).await;
+        if !matches!(restored, Ok(true)) {
+            // Product deleted since the sale (nothing to restore into), or
+            // the update failed and a repeat should try again.
+            unclaim(ctx, &claim, purchase_id).await;
+        }
+        restored?;
+    }
+    // A cancelled checkout may still hold stock.
+    repo::inventory::release_reservations(ctx, purchase_id).await
+}
+
+/// Drop a guard row whose stock change didn't happen. Logged on failure:
+/// the row then claims a change that was never made.
+async fn unclaim(ctx: &dyn Context, claim: &Record, purchase_id: &str) {
+    if let Err(e) = repo::inventory::delete_adjustment(ctx, &claim.id).await {
+        tracing::error!(
+            error = %e,
+            purchase_id = %purchase_id,
+            product_id = %claim.str_field("product_id"),
+            "failed to drop a stock adjustment whose change didn't happen"
+        );
+    }
+}
+
+/// Run a [`RELEASE_JOB`]: delete holds past their `expires_at`. They
+/// already stopped counting against stock, so this only keeps the
+/// reservations table small; a repeat finds nothing left to delete.
+pub async fn run_release_job(ctx: &dyn Context) -> Result<(), JobError> {
+    let now = crate::util::now_rfc3339();
+    let released = repo::inventory::delete_expired_reservations(ctx, &now).await?;
+    if released > 0 {
+        tracing::info!(released, "released expired stock reservations");
+    }
+    Ok(())
+}
+
+fn product_id_var(msg: &Message) -> String {
+    let var = msg.var("id");
+    if !var.is_empty() {
+        return var.to_string();
+    }
+    msg.path()
+        .strip_prefix("/admin/b/products/products/")
+        .and_then(|s| s.strip_suffix("/stock"))
+        .unwrap_or("")
+        .to_string()
+}
+
+async fn stock_summary(ctx: &dyn Context, product: &Record) -> OutputStream {
+    let now = crate::util::now_rfc3339();
+    let reserved = match repo::inventory::reserved_quantity(ctx, &product.id, &now, None).await {
+        Ok(n) => n,
+        Err(e) => return err_internal("Failed to read stock reservations", e),
+    };
+    let adjustments = match repo::inventory::list_adjustments(ctx, &product.id, 50).await {
+        Ok(list) => list.records,
+        Err(e) => return err_internal("Failed to read stock adjustments", e),
+    };
+    let stock = product.i64_field("stock");
+    ok_json(&serde_json::json!({
+        "product_id": product.id,
+        "track_inventory": tracks(product),
+        "stock": stock,
+        "reserved": reserved,
+        "available": (stock - reserved).max(0),
+        "out_of_stock": out_of_stock(product),
+        "adjustments": adjustments,
+    }))
+}
+
+async fn load_product(ctx: &dyn Context, id: &str) -> Result<Record, OutputStream> {
+    match db::get(ctx, PRODUCTS_TABLE, id).await {
+        Ok(p) => Ok(p),
+        Err(e) if e.code == ErrorCode::NotFound => Err(err_not_found("Product not found")),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// `GET /admin/b/products/products/{id}/stock` — on-hand, reserved, and
+/// available quantities plus the 50 most recent adjustments.
+pub async fn handle_get_stock(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let id = product_id_var(msg);
+    if id.is_empty() {
+        return err_bad_request("Missing product ID");
+    }
+    match load_product(ctx, &id).await {
+        Ok(product) => stock_summary(ctx, &product).await,
+        Err(resp) => resp,
+    }
+}
+
+/// `POST /admin/b/products/products/{id}/stock` — apply a signed `delta`
+/// (restock, shrinkage, correction) and record who did it and why. A
+/// decrement below zero is refused with `insufficient_stock`.
+pub async fn handle_adjust_stock(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct AdjustReq {
+        delta: i64,
+        #[serde(default)]
+        note: String,
+    }
+
+    let id = product_id_var(msg);
+    if id.is_empty() {
+        return err_bad_request("Missing product ID");
+    }
+    let raw = input.collect_to_bytes().await;
+    let body: AdjustReq = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+    if body.delta == 0 {
+        return errors::validation_error(
+            "Invalid stock adjustment",
+            &[("delta", "must be non-zero")],
+        );
+    }
+    if let Err(resp) = load_product(ctx, &id).await {
+        return resp;
+    }
+
+    let applied = if body.delta < 0 {
+        repo::inventory::decrement_stock(ctx, &id, -body.delta).await
+    } else {
+        repo::inventory::increment_stock(ctx, &id, body.delta).await
+    };
+    match applied {
+        Ok(true) => {}
+        Ok(false) => {
+            return error_json(
+                errors::ErrorCode::InsufficientStock,
+                "Adjustment would make stock negative",
+                None,
+            )
+        }
+        Err(e) => return err_internal("Failed to adjust stock", e),
+    }
+
+    if let Err(e) = repo::inventory::record_adjustment(
+        ctx,
+        NewAdjustment {
+            product_id: &id,
+            delta: body.delta,
+            reason: REASON_MANUAL,
+            purchase_id: "",
+            actor: msg.user_id(),
+            note: &body.note,
+        },
+    )
+    .await
+    {
+        tracing::error!(error = %e, product_id = %id, "stock adjusted but audit row not written");
+    }
+
+    match load_product(ctx, &id).await {
+        Ok(product) => stock_summary(ctx, &product).await,
+        Err(resp) => resp,
+    }
+}
@@ -0,0 +1,580 @@
+//! Product media: images and attachments shown with a product.
+//!
+//! Every item's bytes live in this block's own storage namespace (bucket
+//! [`BUCKET`], key `{product_id}/{media_id}`), whether they arrive as a
+//! direct upload or are copied from a files object the caller uploaded. The
+//! catalog then serves them at [`media_url`] under the product's own
+//! visibility (active products are public) instead of depending on a files
+//! share link, which expires, or on the uploader's bucket staying intact.
+//!
+//! A copied item remembers the object it came from, so deleting the product
+//! (or the item) with `?delete_objects=true` removes the original as well,
+//! through the files block's own object API.
+
+use std::collections::HashSet;
+
+use wafer_core::clients::{config, database::Record, storage as store};
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
+
+use super::{repo, repo::media::NewMedia, PRODUCTS_TABLE};
+use crate::{
+    blocks::errors,
+    http::{err_bad_request, err_internal, err_not_found, ok_json, ResponseBuilder},
+    util::{is_admin, path_param, RecordExt},
+};
+
+/// Products-owned bucket holding every media item's bytes.
+pub(crate) const BUCKET: &str = "product-media";
+
+/// Shown in the product's image gallery; must be an `image/*` file.
+pub(crate) const KIND_IMAGE: &str = "image";
+/// Any other downloadable file (manuals, spec sheets, ...).
+pub(crate) const KIND_ATTACHMENT: &str = "attachment";
+
+/// Config key capping how many media items one product may carry.
+pub(crate) const MAX_PER_PRODUCT_KEY: &str = "SUPPERS_AI__PRODUCTS__MAX_MEDIA_PER_PRODUCT";
+pub(crate) const DEFAULT_MAX_PER_PRODUCT: i64 = 20;
+
+/// Largest single media item. The runtime upload cap
+/// (`SOLOBASE_MAX_UPLOAD_BYTES`) lowers it further when set.
+const MAX_MEDIA_BYTES: i64 = 25 * 1024 * 1024;
+
+const FILES_BLOCK: &str = "suppers-ai/files";
+
+/// Public URL serving a media item's bytes.
+pub(crate) fn media_url(media_id: &str) -> String {
+    format!("/b/products/media/{media_id}")
+}
+
+/// API shape of one media row, with its resolved URL.
+pub(crate) fn media_json(row: &Record) -> serde_json::Value {
+    serde_json::json!({
+        "id": row.id,
+        "kind": row.str_field("kind"),
+        "position": row.i64_field("position"),
+        "url": media_url(&row.id),
+        "filename": row.str_field("filename"),
+        "content_type": row.str_field("content_type"),
+        "size": row.i64_field("size"),
+        "object_id": row.str_field("object_id"),
+    })
+}
+
+fn max_media_bytes() -> i64 {
+    match crate::runtime_config::load().max_upload_bytes {
+        Some(cap) => cap.min(MAX_MEDIA_BYTES),
+        None => MAX_MEDIA_BYTES,
+    }
+}
+
+async fn max_per_product(ctx: &dyn Context) -> i64 {
+    config::get_default(ctx, MAX_PER_PRODUCT_KEY, "")
+        .await
+        .trim()
+        .parse()
+        .unwrap_or(DEFAULT_MAX_PER_PRODUCT)
+}
+
+fn product_id_var(msg: &Message) -> String {
+    path_param(msg, "id", "/admin/b/products/products/").to_string()
+}
+
+async fn load_product(ctx: &dyn Context, id: &str) -> Result<Record, OutputStream> {
+    match wafer_core::clients::database::get(ctx, PRODUCTS_TABLE, id).await {
+        Ok(p) => Ok(p),
+        Err(e) if e.code == ErrorCode::NotFound => Err(err_not_found("Product not found")),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// Stamp `media` (resolved, in display order) onto each product record.
+/// A lookup failure is logged and leaves the lists empty rather than
+/// failing the whole product response.
+pub(crate) async fn annotate_all(ctx: &dyn Context, products: &mut [Record]) {
+    let ids: Vec<String> = products.iter().map(|p| p.id.clone()).collect();
+    let mut grouped = match repo::media::list_for_products(ctx, &ids).await {
+        Ok(g) => g,
+        Err(e) => {
+            tracing::warn!(error = %e, "failed to load product media");
+            Default::default()
+        }
+    };
+    for product in products.iter_mut() {
+        let media: Vec<serde_json::Value> = grouped
+            .remove(&product.id)
+            .unwrap_or_default()
+            .iter()
+            .map(media_json)
+            .collect();
+        product
+            .data
+            .insert("media".to_string(), serde_json::Value::Array(media));
+    }
+}
+
+/// Single-record form of [`annotate_all`], for detail responses.
+pub(crate) async fn annotate(ctx: &dyn Context, product: &mut Record) {
+    annotate_all(ctx, std::slice::from_mut(product)).await;
+}
+
+// --- Admin handlers ---
+
+/// `GET /admin/b/products/products/{id}/media` — the product's media in
+/// display order.
+pub async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let product_id = product_id_var(msg);
+    if product_id.is_empty() {
+        return err_bad_request("Missing product ID");
+    }
+    if let Err(resp) = load_product(ctx, &product_id).await {
+        return resp;
+    }
+    match repo::media::list_for_product(ctx, &product_id).await {
+        Ok(rows) => {
+            let media: Vec<serde_json::Value> = rows.iter().map(media_json).collect();
+            ok_json(&serde_json::json!({ "media": media }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// Bytes and provenance of an item about to be attached.
+struct Incoming {
+    content: Vec<u8>,
+    filename: String,
+    content_type: String,
+    kind: Option<String>,
+    object_id: String,
+    source_bucket: String,
+    source_key: String,
+}
+
+/// `POST /admin/b/products/products/{id}/media` — attach one item, appended
+/// after the existing ones.
+///
+/// A JSON body `{"object_id", "kind"?}` copies a files object the caller
+/// uploaded. Any other body is a direct upload (raw bytes, or
+/// `multipart/form-data` with one file part), with `?kind=` and
+/// `?filename=` in the query. `kind` defaults to `image` for `image/*`
+/// content and `attachment` otherwise.
+pub async fn handle_add(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let product_id = product_id_var(msg);
+    if product_id.is_empty() {
+        return err_bad_request("Missing product ID");
+    }
+    if let Err(resp) = load_product(ctx, &product_id).await {
+        return resp;
+    }
+
+    let count = match repo::media::count_for_product(ctx, &product_id).await {
+        Ok(n) => n,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let limit = max_per_product(ctx).await;
+    if count >= limit {
+        return errors::validation_error(
+            &format!("A product can have at most {limit} media items"),
+            &[("media", "limit reached")],
+        );
+    }
+
+    let request_content_type = msg.get_meta("req.content_type");
+    let is_json = request_content_type
+        .split(';')
+        .next()
+        .is_some_and(|mime| mime.trim().eq_ignore_ascii_case("application/json"));
+    let incoming = if is_json {
+        from_object(ctx, msg, input).await
+    } else {
+        from_upload(msg, input).await
+    };
+    let incoming = match incoming {
+        Ok(i) => i,
+        Err(resp) => return resp,
+    };
+
+    let is_image = incoming.content_type.starts_with("image/");
+    let kind = incoming.kind.clone().unwrap_or_else(|| {
+        if is_image {
+            KIND_IMAGE
+        } else {
+            KIND_ATTACHMENT
+        }
+        .to_string()
+    });
+    if kind != KIND_IMAGE && kind != KIND_ATTACHMENT {
+        return errors::validation_error(
+            "Invalid media kind",
+            &[("kind", "must be image or attachment")],
+        );
+    }
+    if kind == KIND_IMAGE && !is_image {
+        return errors::validation_error(
+            "Only images can go in the image slot",
+            &[("kind", "requires an image/* file")],
+        );
+    }
+
+    let media_id = uuid::Uuid::now_v7().to_string();
+    let storage_key = format!("{product_id}/{media_id}");
+    if let Err(e) = store::put(
+        ctx,
+        BUCKET,
+        &storage_key,
+        &incoming.content,
+        &incoming.content_type,
+    )
+    .await
+    {
+        return err_internal("Failed to store media", e);
+    }
+
+    let new = NewMedia {
+        id: &media_id,
+        product_id: &product_id,
+        kind: &kind,
+        position: count,
+        object_id: &incoming.object_id,
+        source_bucket: &incoming.source_bucket,
+        source_key: &incoming.source_key,
+        storage_key: &storage_key,
+        filename: &incoming.filename,
+        content_type: &incoming.content_type,
+        size: incoming.content.len() as i64,
+        created_by: msg.user_id(),
+    };
+    match repo::media::insert(ctx, new).await {
+        Ok(row) => ok_json(&media_json(&row)),
+        Err(e) => {
+            if let Err(cleanup) = store::delete(ctx, BUCKET, &storage_key).await {
+                tracing::warn!(error = %cleanup, key = %storage_key, "failed to remove orphaned product media");
+            }
+            err_internal("Failed to save media", e)
+        }
+    }
+}
+
+fn too_large(cap: i64) -> OutputStream {
+    errors::error_json(
+        errors::ErrorCode::FileTooLarge,
+        &format!("File exceeds maximum size of {cap} bytes"),
+        None,
+    )
+}
+
+/// Read a direct upload (raw body or a single multipart file part).
+async fn from_upload(msg: &Message, input: InputStream) -> Result<Incoming, OutputStream> {
+    let cap = max_media_bytes();
+    let Ok(body) = crate::util::collect_with_cap(input, cap).await else {
+        return Err(too_large(cap));
+    };
+    let request_content_type = msg.get_meta("req.content_type").to_string();
+    let query_filename = msg.query("filename").to_string();
+
+    let (content, filename, content_type) =
+        if crate::multipart::multipart_boundary(&request_content_type).is_some() {
+            let Some(file) = crate::multipart::extract_multipart_file(&body, &request_content_type)
+            else {
+                return Err(err_bad_request("Multipart body contains no file part"));
+            };
+            let filename = if query_filename.is_empty() {
+                file.filename.unwrap_or_default()
+            } else {
+                query_filename
+            };
+            let content_type = file
+                .content_type
+                .filter(|ct| !ct.is_empty())
+                .unwrap_or_else(|| {
+                    wafer_core::mime::mime_for_ext(std::path::Path::new(&filename)).to_string()
+                });
+            (file.content, filename, content_type)
+        } else {
+            let content_type = if request_content_type.is_empty() {
+                "application/octet-stream".to_string()
+            } else {
+                request_content_type
+            };
+            (body, query_filename, content_type)
+        };
+    if content.is_empty() {
+        return Err(err_bad_request("Empty upload"));
+    }
+
+    let kind = msg.query("kind");
+    Ok(Incoming {
+        content,
+        filename,
+        content_type,
+        kind: (!kind.is_empty()).then(|| kind.to_string()),
+        object_id: String::new(),
+        source_bucket: String::new(),
+        source_key: String::new(),
+    })
+}
+
+/// Copy a files object the caller uploaded. Ownership is checked against
+/// the object row; the bytes are then read through the files object API
+/// with the caller's identity, so the files block's own access rules apply
+/// too.
+#[cfg(feature = "block-files")]
+async fn from_object(
+    ctx: &dyn Context,
+    msg: &Message,
+    input: InputStream,
+) -> Result<Incoming, OutputStream> {
+    use crate::blocks::files::repo::objects;
+
+    #[derive(serde::Deserialize)]
+    struct AttachReq {
+        object_id: String,
+        #[serde(default)]
+        kind: Option<String>,
+    }
+
+    let raw = input.collect_to_bytes().await;
+    let req: AttachReq = match serde_json::from_slice(&raw) {
+        Ok(r) => r,
+        Err(e) => return Err(err_bad_request(&format!("Invalid body: {e}"))),
+    };
+    if req.object_id.is_empty() {
+        return Err(errors::validation_error(
+            "Missing object_id",
+            &[("object_id", "required")],
+        ));
+    }
+
+    let object = match objects::get(ctx, &req.object_id).await {
+        Ok(o) => o,
+        Err(e) if e.code == ErrorCode::NotFound => return Err(err_not_found("Object not found")),
+        Err(e) => return Err(err_internal("Database error", e)),
+    };
+    if object.str_field("uploaded_by") != msg.user_id() {
+        return Err(crate::http::err_forbidden(
+            "You can only attach objects you uploaded",
+        ));
+    }
+    match object.str_field("status") {
+        "pending" => return Err(err_bad_request("Object upload has not finished")),
+        // Product media is public; an object must clear its malware scan
+        // before it can become one.
+        objects::STATUS_PENDING_SCAN => {
+            return Err(errors::error_response(
+                errors::ErrorCode::ScanPending,
+                "Object is waiting for its malware scan",
+            ))
+        }
+        objects::STATUS_QUARANTINED => return Err(err_not_found("Object not found")),
+        _ => {}
+    }
+    let cap = max_media_bytes();
+    if object.i64_field("size") > cap {
+        return Err(too_large(cap));
+    }
+
+    let bucket = object.str_field("bucket").to_string();
+    let key = object.str_field("key").to_string();
+    let resource = format!("/b/storage/api/buckets/{bucket}/objects/{key}");
+    let request = crate::util::block_request("retrieve", "GET", &resource, msg);
+    let out = ctx
+        .call_block(FILES_BLOCK, request, InputStream::empty())
+        .await;
+    let content = match out.collect_buffered().await {
+        Ok(buf) => buf.body,
+        Err(e) => {
+            tracing::warn!(object_id = %req.object_id, "reading object for product media failed: {e:?}");
+            return Err(err_bad_request("Object could not be read from storage"));
+        }
+    };
+
+    let content_type = match object.str_field("content_type") {
+        "" => "application/octet-stream".to_string(),
+        ct => ct.to_string(),
+    };
+    Ok(Incoming {
+        content,
+        filename: key.rsplit('/').next().unwrap_or_default().to_string(),
+        content_type,
+        kind: req.kind.filter(|k| !k.is_empty()),
+        object_id: object.id.clone(),
+        source_bucket: bucket,
+        source_key: key,
+    })
+}
+
+#[cfg(not(feature = "block-files"))]
+async fn from_object(
+    _ctx: &dyn Context,
+    _msg: &Message,
+    _input: InputStream,
+) -> Result<Incoming, OutputStream> {
+    Err(errors::validation_error(
+        "Attaching stored objects requires the files block",
+        &[("object_id", "files block is not enabled")],
+    ))
+}
+
+/// `PATCH /admin/b/products/products/{id}/media` — `{"order": [media ids]}`
+/// listing every item on the product exactly once, in the new display
+/// order.
+pub async fn handle_reorder(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct ReorderReq {
+        order: Vec<String>,
+    }
+
+    let product_id = product_id_var(msg);
+    if product_id.is_empty() {
+        return err_bad_request("Missing product ID");
+    }
+    let raw = input.collect_to_bytes().await;
+    let req: ReorderReq = match serde_json::from_slice(&raw) {
+        Ok(r) => r,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+    if let Err(resp) = load_product(ctx, &product_id).await {
+        return resp;
+    }
+
+    let rows = match repo::media::list_for_product(ctx, &product_id).await {
+        Ok(rows) => rows,
+        Err(e) => return err_internal("Database error", e),
+    };
+    let current: HashSet<&str> = rows.iter().map(|r| r.id.as_str()).collect();
+    let requested: HashSet<&str> = req.order.iter().map(String::as_str).collect();
+    if requested.len() != req.order.len() || requested != current {
+        return errors::validation_error(
+            "order must list each of the product's media ids exactly once",
+            &[("order", "does not match the product's media")],
+        );
+    }
+
+    for (position, id) in req.order.iter().enumerate() {
+        if let Err(e) = repo::media::set_position(ctx, id, position as i64).await {
+            return err_internal("Failed to reorder media", e);
+        }
+    }
+    handle_list(ctx, msg).await
+}
+
+/// `DELETE /admin/b/products/products/{id}/media/{media_id}` — remove one
+/// item. `?delete_objects=true` also deletes the files object it was
+/// copied from.
+pub async fn handle_delete(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let product_id = product_id_var(msg);
+    let media_id = msg.var("media_id").to_string();
+    if product_id.is_empty() || media_id.is_empty() {
+        return err_bad_request("Missing product or media ID");
+    }
+    let row = match repo::media::get(ctx, &media_id).await {
+        Ok(r) if r.str_field("product_id") == product_id => r,
+        Ok(_) => return err_not_found("Media not found"),
+        Err(e) if e.code == ErrorCode::NotFound => return err_not_found("Media not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    remove_stored(ctx, msg, &row, msg.query("delete_objects") == "true").await;
+    match repo::media::delete(ctx, &media_id).await {
+        Ok(()) => ok_json(&serde_json::json!({"deleted": true})),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// Drop every media item of a deleted product: the stored copies, the rows,
+/// and with `delete_objects` the source files objects. Best-effort per item
+/// (the product is already gone); returns how many items were removed.
+pub(crate) async fn remove_for_product(
+    ctx: &dyn Context,
+    msg: &Message,
+    product_id: &str,
+    delete_objects: bool,
+) -> usize {
+    let rows = match repo::media::list_for_product(ctx, product_id).await {
+        Ok(rows) => rows,
+        Err(e) => {
+            tracing::warn!(error = %e, product_id = %product_id, "failed to list product media for cleanup");
+            return 0;
+        }
+    };
+    for row in &rows {
+        remove_stored(ctx, msg, row, delete_objects).await;
+    }
+    if let Err(e) = repo::media::delete_for_product(ctx, product_id).await {
+        tracing::warn!(error = %e, product_id = %product_id, "failed to delete product media rows");
+    }
+    rows.len()
+}
+
+/// Delete an item's stored copy and, when asked, its source object.
+async fn remove_stored(ctx: &dyn Context, msg: &Message, row: &Record, delete_object: bool) {
+    let key = row.str_field("storage_key");
+    if let Err(e) = store::delete(ctx, BUCKET, key).await {
+        if e.code != ErrorCode::NotFound {
+            tracing::warn!(error = %e, key = %key, "failed to delete product media bytes");
+        }
+    }
+
+    let (bucket, source_key) = (row.str_field("source_bucket"), row.str_field("source_key"));
+    if !delete_object || bucket.is_empty() || source_key.is_empty() {
+        return;
+    }
+    let resource = format!("/b/storage/api/buckets/{bucket}/objects/{source_key}");
+    let request = crate::util::block_request("delete", "DELETE", &resource, msg);
+    let out = ctx
+        .call_block(FILES_BLOCK, request, InputStream::empty())
+        .await;
+    if let Err(e) = out.collect_buffered().await {
+        tracing::warn!(
+            object_id = %row.str_field("object_id"),
+            "failed to delete source object of product media: {e:?}"
+        );
+    }
+}
+
+// --- Public ---
+
+/// `GET /b/products/media/{media_id}` — serve an item's bytes. Media of an
+/// active product is public like the catalog; anything else is visible to
+/// admins only.
+pub async fn handle_serve(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let media_id = path_param(msg, "media_id", "/b/products/media/").to_string();
+    if media_id.is_empty() {
+        return err_bad_request("Missing media ID");
+    }
+    let row = match repo::media::get(ctx, &media_id).await {
+        Ok(r) => r,
+        Err(e) if e.code == ErrorCode::NotFound => return err_not_found("Media not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    let product = match load_product(ctx, row.str_field("product_id")).await {
+        Ok(p) => p,
+        Err(_) => return err_not_found("Media not found"),
+    };
+    if product.str_field("status") != "active" && !is_admin(msg) {
+        return err_not_found("Media not found");
+    }
+
+    match store::get(ctx, BUCKET, row.str_field("storage_key")).await {
+        Ok((data, info)) => {
+            let disposition = if row.str_field("kind") == KIND_ATTACHMENT {
+                "attachment"
+            } else {
+                "inline"
+            };
+            let filename = row
+                .str_field("filename")
+                .replace(['"', '\\', '\r', '\n'], "");
+            let mut builder = ResponseBuilder::new();
+            builder = if filename.is_empty() {
+                builder.set_header("Content-Disposition", disposition)
+            } else {
+                builder.set_header(
+                    "Content-Disposition",
+                    &format!("{disposition}; filename=\"{filename}\""),
+                )
+            };
+            builder.body(data, &info.content_type)
+        }
+        Err(e) if e.code == ErrorCode::NotFound => err_not_found("Media not found"),
+        Err(e) => err_internal("Storage error", e),
+    }
+}
@@ -0,0 +1,43 @@
+-- Inventory tracking. Opt-in per product via `track_inventory`; the
+-- existing `stock` column holds on-hand quantity. New tables are
+-- materialized by `CREATE TABLE IF NOT EXISTS` on both fresh installs and
+-- existing databases.
+--
+-- `stock_reservations` holds quantity for a pending purchase until
+-- `expires_at` (an expired row simply stops counting against available
+-- stock). `stock_adjustments` is the append-only audit trail of every
+-- stock change: admin adjustments, paid purchases, refunds.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__products__products ADD COLUMN track_inventory INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS suppers_ai__products__stock_reservations (
+    id            TEXT PRIMARY KEY,
+    product_id    TEXT NOT NULL,
+    purchase_id   TEXT NOT NULL,
+    quantity      INTEGER NOT NULL DEFAULT 0,
+    expires_at    TEXT NOT NULL,
+    created_at    TEXT NOT NULL,
+    updated_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__products__stock_reservations_product_idx
+    ON suppers_ai__products__stock_reservations (product_id, expires_at);
+CREATE INDEX IF NOT EXISTS suppers_ai__products__stock_reservations_purchase_idx
+    ON suppers_ai__products__stock_reservations (purchase_id);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__products__stock_adjustments (
+    id            TEXT PRIMARY KEY,
+    product_id    TEXT NOT NULL,
+    delta         INTEGER NOT NULL DEFAULT 0,
+    reason        TEXT NOT NULL DEFAULT 'manual',
+    purchase_id   TEXT NOT NULL DEFAULT '',
+    actor         TEXT NOT NULL DEFAULT '',
+    note          TEXT NOT NULL DEFAULT '',
+    created_at    TEXT NOT NULL,
+    updated_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__products__stock_adjustments_product_idx
+    ON suppers_ai__products__stock_adjustments (product_id, created_at);
+CREATE INDEX IF NOT EXISTS suppers_ai__products__stock_adjustments_purchase_idx
+    ON suppers_ai__products__stock_adjustments (purchase_id);
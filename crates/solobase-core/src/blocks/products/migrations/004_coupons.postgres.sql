@@ -0,0 +1,52 @@
+-- Coupons and discount codes.
+--
+-- `code` is stored upper-cased so the unique index makes codes
+-- case-insensitive. `value` is a whole percentage (1-100) for `percent`
+-- coupons and cents for `fixed` ones. `max_redemptions` /
+-- `per_user_limit` of 0 mean unlimited. `redemption_count` is only ever
+-- changed with a conditional increment (`redemption_count <
+-- max_redemptions` in the WHERE), so concurrent checkouts can't overspend
+-- a coupon. `product_template_ids` is a JSON array; empty applies the
+-- coupon to every product.
+--
+-- `coupon_redemptions` has one row per purchase that used a coupon;
+-- `status` flips to `released` when the purchase is abandoned (or
+-- refunded, when configured) and its count is handed back.
+ALTER TABLE suppers_ai__products__purchases ADD COLUMN IF NOT EXISTS discount_cents INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE suppers_ai__products__purchases ADD COLUMN IF NOT EXISTS coupon_code TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__products__line_items ADD COLUMN IF NOT EXISTS discount_cents INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS suppers_ai__products__coupons (
+    id                    TEXT PRIMARY KEY,
+    code                  TEXT NOT NULL,
+    discount_type         TEXT NOT NULL DEFAULT 'percent',
+    value                 INTEGER NOT NULL DEFAULT 0,
+    max_redemptions       INTEGER NOT NULL DEFAULT 0,
+    per_user_limit        INTEGER NOT NULL DEFAULT 0,
+    redemption_count      INTEGER NOT NULL DEFAULT 0,
+    valid_from            TEXT NOT NULL DEFAULT '',
+    valid_to              TEXT NOT NULL DEFAULT '',
+    product_template_ids  TEXT NOT NULL DEFAULT '[]',
+    active                INTEGER NOT NULL DEFAULT 1,
+    created_by            TEXT NOT NULL DEFAULT '',
+    created_at            TEXT NOT NULL,
+    updated_at            TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__products__coupons_code_idx
+    ON suppers_ai__products__coupons (code);
+
+CREATE TABLE IF NOT EXISTS suppers_ai__products__coupon_redemptions (
+    id              TEXT PRIMARY KEY,
+    coupon_id       TEXT NOT NULL,
+    code            TEXT NOT NULL,
+    user_id         TEXT NOT NULL,
+    purchase_id     TEXT NOT NULL,
+    discount_cents  INTEGER NOT NULL DEFAULT 0,
+    status          TEXT NOT NULL DEFAULT 'active',
+    created_at      TEXT NOT NULL,
+    updated_at      TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__products__coupon_redemptions_coupon_user_idx
+    ON suppers_ai__products__coupon_redemptions (coupon_id, user_id, status);
+CREATE INDEX IF NOT EXISTS suppers_ai__products__coupon_redemptions_purchase_idx
+    ON suppers_ai__products__coupon_redemptions (purchase_id);
@@ -0,0 +1,26 @@
+-- Product media: images and attachments attached to a product, in display
+-- order. The bytes live in the products block's own storage namespace
+-- (bucket `product-media`, key `storage_key`), so a media URL never
+-- depends on another block's sharing rules.
+--
+-- `object_id` / `source_bucket` / `source_key` record the files object a
+-- row was attached from (empty for direct uploads), so deleting a product
+-- can optionally remove the original as well.
+CREATE TABLE IF NOT EXISTS suppers_ai__products__product_media (
+    id            TEXT PRIMARY KEY,
+    product_id    TEXT NOT NULL,
+    kind          TEXT NOT NULL DEFAULT 'image',
+    position      INTEGER NOT NULL DEFAULT 0,
+    object_id     TEXT NOT NULL DEFAULT '',
+    source_bucket TEXT NOT NULL DEFAULT '',
+    source_key    TEXT NOT NULL DEFAULT '',
+    storage_key   TEXT NOT NULL,
+    filename      TEXT NOT NULL DEFAULT '',
+    content_type  TEXT NOT NULL DEFAULT '',
+    size          INTEGER NOT NULL DEFAULT 0,
+    created_by    TEXT NOT NULL DEFAULT '',
+    created_at    TEXT NOT NULL,
+    updated_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS suppers_ai__products__product_media_product_idx
+    ON suppers_ai__products__product_media (product_id, position);
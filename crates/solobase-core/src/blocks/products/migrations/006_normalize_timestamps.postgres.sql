@@ -0,0 +1,272 @@
+-- Mirror of the SQLite migration for PostgreSQL: rewrite timestamp columns
+-- to the `crate::util::format_rfc3339` layout (`2026-07-11T19:13:45.123456Z`).
+--
+-- Values with an offset are cast through TIMESTAMPTZ; offset-less values are
+-- read as UTC (not the session time zone). Only rows that look like a
+-- timestamp are touched, so empty and malformed values stay as stored.
+
+UPDATE suppers_ai__products__products SET deleted_at = to_char(
+        (CASE WHEN deleted_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN deleted_at::timestamptz
+              ELSE deleted_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE deleted_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND deleted_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__products SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__products SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__groups SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__groups SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__types SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__types SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__pricing_templates SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__pricing_templates SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__purchases SET approved_at = to_char(
+        (CASE WHEN approved_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN approved_at::timestamptz
+              ELSE approved_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE approved_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND approved_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__purchases SET refunded_at = to_char(
+        (CASE WHEN refunded_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN refunded_at::timestamptz
+              ELSE refunded_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE refunded_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND refunded_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__purchases SET payment_at = to_char(
+        (CASE WHEN payment_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN payment_at::timestamptz
+              ELSE payment_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE payment_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND payment_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__purchases SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__purchases SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__line_items SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__line_items SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__group_templates SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__group_templates SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__product_templates SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__product_templates SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__variables SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__variables SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__subscriptions SET grace_period_end = to_char(
+        (CASE WHEN grace_period_end ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN grace_period_end::timestamptz
+              ELSE grace_period_end::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE grace_period_end ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND grace_period_end !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__subscriptions SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__subscriptions SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__stock_reservations SET expires_at = to_char(
+        (CASE WHEN expires_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN expires_at::timestamptz
+              ELSE expires_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE expires_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND expires_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__stock_reservations SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__stock_reservations SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__stock_adjustments SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__stock_adjustments SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__coupons SET valid_from = to_char(
+        (CASE WHEN valid_from ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN valid_from::timestamptz
+              ELSE valid_from::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE valid_from ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND valid_from !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__coupons SET valid_to = to_char(
+        (CASE WHEN valid_to ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN valid_to::timestamptz
+              ELSE valid_to::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE valid_to ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND valid_to !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__coupons SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__coupons SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__coupon_redemptions SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__coupon_redemptions SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__product_media SET created_at = to_char(
+        (CASE WHEN created_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN created_at::timestamptz
+              ELSE created_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE created_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
+
+UPDATE suppers_ai__products__product_media SET updated_at = to_char(
+        (CASE WHEN updated_at ~ '(Z|[+-]\d{2}(:?\d{2})?)$' THEN updated_at::timestamptz
+              ELSE updated_at::timestamp AT TIME ZONE 'UTC' END) AT TIME ZONE 'UTC',
+        'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+    WHERE updated_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}(:?\d{2})?)?$'
+      AND updated_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z$';
@@ -0,0 +1,163 @@
+-- Normalize stored timestamps to the single layout `crate::util::format_rfc3339`
+-- writes: UTC, microsecond precision, literal `Z`
+-- (`2026-07-11T19:13:45.123456Z`). Older rows mix `…Z` seconds,
+-- `to_rfc3339()`'s `+00:00` nanoseconds and SQL `CURRENT_TIMESTAMP`'s
+-- `2026-07-11 19:13:45`, which breaks the string comparisons the cleanup and
+-- expiry queries rely on.
+--
+-- SQLite's `strftime` converts any offset to UTC but only keeps milliseconds,
+-- so rewritten rows are padded to six digits. Values `strftime` can't read
+-- are left as stored — `crate::util::parse_timestamp` still reads most of
+-- them. Already-canonical rows are skipped, so re-runs are no-ops.
+
+UPDATE suppers_ai__products__products SET deleted_at = strftime('%Y-%m-%dT%H:%M:%f', deleted_at) || '000Z'
+    WHERE deleted_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', deleted_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__products SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__products SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__groups SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__groups SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__types SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__types SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__pricing_templates SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__pricing_templates SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__purchases SET approved_at = strftime('%Y-%m-%dT%H:%M:%f', approved_at) || '000Z'
+    WHERE approved_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', approved_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__purchases SET refunded_at = strftime('%Y-%m-%dT%H:%M:%f', refunded_at) || '000Z'
+    WHERE refunded_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', refunded_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__purchases SET payment_at = strftime('%Y-%m-%dT%H:%M:%f', payment_at) || '000Z'
+    WHERE payment_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', payment_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__purchases SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__purchases SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__line_items SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__line_items SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__group_templates SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__group_templates SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__product_templates SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__product_templates SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__variables SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__variables SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__subscriptions SET grace_period_end = strftime('%Y-%m-%dT%H:%M:%f', grace_period_end) || '000Z'
+    WHERE grace_period_end NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', grace_period_end) IS NOT NULL;
+
+UPDATE suppers_ai__products__subscriptions SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__subscriptions SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__stock_reservations SET expires_at = strftime('%Y-%m-%dT%H:%M:%f', expires_at) || '000Z'
+    WHERE expires_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', expires_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__stock_reservations SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__stock_reservations SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__stock_adjustments SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__stock_adjustments SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__coupons SET valid_from = strftime('%Y-%m-%dT%H:%M:%f', valid_from) || '000Z'
+    WHERE valid_from NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', valid_from) IS NOT NULL;
+
+UPDATE suppers_ai__products__coupons SET valid_to = strftime('%Y-%m-%dT%H:%M:%f', valid_to) || '000Z'
+    WHERE valid_to NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', valid_to) IS NOT NULL;
+
+UPDATE suppers_ai__products__coupons SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__coupons SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__coupon_redemptions SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__coupon_redemptions SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__product_media SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000Z'
+    WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+UPDATE suppers_ai__products__product_media SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000Z'
+    WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9]Z'
+      AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
@@ -0,0 +1,34 @@
+-- Tax: per-region rates and the breakdown recorded on each purchase.
+--
+-- `region` is an upper-cased ISO 3166 country code (`DE`) or country
+-- subdivision (`US-CA`); a buyer in `US-CA` is taxed at the `US-CA` rate
+-- when there is one, else at `US`, else not at all. `rate_ppm` is the rate
+-- in parts per million (8.875% = 88750) so it stays exact. `inclusive`
+-- rates are already part of the price; exclusive ones are added on top.
+--
+-- `tax_items` on a purchase is the JSON breakdown the tax calculator
+-- produced, one entry per taxed line. `tax_cents` is the sum of every
+-- entry, inclusive or not. `refunded_cents` / `refunded_tax_cents` record
+-- how much of the total, and of the tax within it, has been refunded.
+--
+-- SQLite has no `ADD COLUMN IF NOT EXISTS`; re-runs raise "duplicate column
+-- name", which `migration_helper` tolerates as an idempotent no-op.
+ALTER TABLE suppers_ai__products__purchases ADD COLUMN tax_cents INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE suppers_ai__products__purchases ADD COLUMN tax_items TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE suppers_ai__products__purchases ADD COLUMN billing_country TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppers_ai__products__purchases ADD COLUMN refunded_cents INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE suppers_ai__products__purchases ADD COLUMN refunded_tax_cents INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE suppers_ai__products__line_items ADD COLUMN tax_cents INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS suppers_ai__products__tax_rates (
+    id          TEXT PRIMARY KEY,
+    region      TEXT NOT NULL,
+    rate_ppm    INTEGER NOT NULL DEFAULT 0,
+    label       TEXT NOT NULL DEFAULT '',
+    inclusive   INTEGER NOT NULL DEFAULT 0,
+    active      INTEGER NOT NULL DEFAULT 1,
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__products__tax_rates_region_idx
+    ON suppers_ai__products__tax_rates (region);
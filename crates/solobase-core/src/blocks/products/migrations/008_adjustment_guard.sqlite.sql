@@ -0,0 +1,24 @@
+-- One `purchase` and one `refund` adjustment per product per purchase.
+--
+-- `commit_purchase` and `restore_purchase` claim a product by inserting its
+-- adjustment row before touching stock, and this index is what makes the
+-- claim exclusive across webhook retries and concurrent deliveries. Manual
+-- adjustments carry no purchase and are left out.
+--
+-- Rows a racing retry may already have doubled up are kept for the stock
+-- trail but turned into manual adjustments that name their purchase in the
+-- note, so the index can be built.
+UPDATE suppers_ai__products__stock_adjustments
+    SET reason = 'manual',
+        note = 'duplicate ' || reason || ' for purchase ' || purchase_id,
+        purchase_id = ''
+    WHERE purchase_id <> ''
+      AND id NOT IN (
+          SELECT MIN(id) FROM suppers_ai__products__stock_adjustments
+          WHERE purchase_id <> ''
+          GROUP BY purchase_id, product_id, reason
+      );
+
+CREATE UNIQUE INDEX IF NOT EXISTS suppers_ai__products__stock_adjustments_claim_idx
+    ON suppers_ai__products__stock_adjustments (purchase_id, product_id, reason)
+    WHERE purchase_id <> '';
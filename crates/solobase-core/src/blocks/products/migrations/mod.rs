@@ -16,6 +16,18 @@ const SQL_001_SQLITE: &str = include_str!("001_products_schema.sqlite.sql");
 const SQL_001_POSTGRES: &str = include_str!("001_products_schema.postgres.sql");
 const SQL_002_SQLITE: &str = include_str!("002_default_templates.sqlite.sql");
 const SQL_002_POSTGRES: &str = include_str!("002_default_templates.postgres.sql");
+const SQL_003_SQLITE: &str = include_str!("003_inventory.sqlite.sql");
+const SQL_003_POSTGRES: &str = include_str!("003_inventory.postgres.sql");
+const SQL_004_SQLITE: &str = include_str!("004_coupons.sqlite.sql");
+const SQL_004_POSTGRES: &str = include_str!("004_coupons.postgres.sql");
+const SQL_005_SQLITE: &str = include_str!("005_product_media.sqlite.sql");
+const SQL_005_POSTGRES: &str = include_str!("005_product_media.postgres.sql");
+const SQL_006_SQLITE: &str = include_str!("006_normalize_timestamps.sqlite.sql");
+const SQL_006_POSTGRES: &str = include_str!("006_normalize_timestamps.postgres.sql");
+const SQL_007_SQLITE: &str = include_str!("007_tax.sqlite.sql");
+const SQL_007_POSTGRES: &str = include_str!("007_tax.postgres.sql");
+const SQL_008_SQLITE: &str = include_str!("008_adjustment_guard.sqlite.sql");
+const SQL_008_POSTGRES: &str = include_str!("008_adjustment_guard.postgres.sql");
 
 /// Ordered SQLite migration scripts for this block, as `(basename, content)`
 /// pairs. Feeds the runtime `lifecycle_init` apply path.
@@ -23,7 +35,22 @@ const SQL_002_POSTGRES: &str = include_str!("002_default_templates.postgres.sql"
 pub(crate) const SQLITE_MIGRATIONS: &[(&str, &str)] = &[
     ("001_products_schema", SQL_001_SQLITE),
     ("002_default_templates", SQL_002_SQLITE),
+    ("003_inventory", SQL_003_SQLITE),
+    ("004_coupons", SQL_004_SQLITE),
+    ("005_product_media", SQL_005_SQLITE),
+    ("006_normalize_timestamps", SQL_006_SQLITE),
+    ("007_tax", SQL_007_SQLITE),
+    ("008_adjustment_guard", SQL_008_SQLITE),
 ];
 
 /// Ordered PostgreSQL migration scripts, matching [`SQLITE_MIGRATIONS`].
-pub(crate) const POSTGRES_MIGRATIONS: &[&str] = &[SQL_001_POSTGRES, SQL_002_POSTGRES];
+pub(crate) const POSTGRES_MIGRATIONS: &[&str] = &[
+    SQL_001_POSTGRES,
+    SQL_002_POSTGRES,
+    SQL_003_POSTGRES,
+    SQL_004_POSTGRES,
+    SQL_005_POSTGRES,
+    SQL_006_POSTGRES,
+    SQL_007_POSTGRES,
+    SQL_008_POSTGRES,
+];
@@ -1,10 +1,15 @@
+mod coupons;
 mod handlers;
+mod inventory;
+pub(crate) mod media;
 pub(crate) mod migrations;
 mod pages;
 mod pricing;
 mod purchase;
 mod repo;
 mod stripe;
+pub mod tax;
+mod transfer;
 mod variables;
 
 #[cfg(test)]
@@ -13,13 +18,54 @@ mod tests;
 pub(crate) use handlers::{
     GROUPS_TABLE, GROUP_TEMPLATES_TABLE, PRODUCTS_TABLE, PRODUCT_TEMPLATES_TABLE, TYPES_TABLE,
 };
+pub(crate) use inventory::{
+    RELEASE_JOB as RESERVATION_RELEASE_JOB, RELEASE_SCHEDULE as RESERVATION_RELEASE_SCHEDULE,
+};
 pub(crate) use pricing::TABLE as PRICING_TABLE;
 pub(crate) use repo::purchases::{LINE_ITEMS_TABLE, PURCHASES_TABLE};
 pub(crate) use variables::TABLE as VARIABLES_TABLE;
-use wafer_run::{BlockEndpoint, BlockInfo, ConfigVar, InputType, InstanceMode};
+use wafer_run::{BlockEndpoint, BlockInfo, ConfigVar, HttpMethod, InputType, InstanceMode};
 
 use super::rate_limit::{check_user_rate_limit, RateLimitOutcome, UserRateLimiter};
-use crate::http::err_not_found;
+use crate::{endpoint_match::EndpointRoute, http::err_not_found};
+
+/// Permissions for the admin pages and API (see
+/// [`crate::blocks::permissions`]): `billing.manage` opens the catalogue,
+/// purchases and refunds to non-admins who hold it.
+pub(crate) const PERMISSION_ROUTES: &[EndpointRoute<&str>] = &[
+    EndpointRoute::new(HttpMethod::Get, "/b/products/admin", "billing.manage"),
+    EndpointRoute::new(HttpMethod::Get, "/b/products/admin/", "billing.manage"),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/products/admin/{page}",
+        "billing.manage",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/products/admin/settings",
+        "billing.manage",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Get,
+        "/b/products/api/admin/{rest...}",
+        "billing.manage",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Post,
+        "/b/products/api/admin/{rest...}",
+        "billing.manage",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Patch,
+        "/b/products/api/admin/{rest...}",
+        "billing.manage",
+    ),
+    EndpointRoute::new(
+        HttpMethod::Delete,
+        "/b/products/api/admin/{rest...}",
+        "billing.manage",
+    ),
+];
 
 /// The products block's own declared config vars. Single source of truth for
 /// both `BlockInfo::config_keys` and the admin settings page (which renders
@@ -65,6 +111,20 @@ pub(crate) fn config_vars() -> Vec<ConfigVar> {
         .name("Billing Webhook Secret")
         .input_type(InputType::Password)
         .auto_generate(),
+        ConfigVar::new(
+            "SUPPERS_AI__PRODUCTS__COUPON_RELEASE_ON_REFUND",
+            "Give a coupon redemption back when its purchase is refunded",
+            "false",
+        )
+        .name("Release Coupons on Refund")
+        .input_type(InputType::Toggle),
+        ConfigVar::new(
+            media::MAX_PER_PRODUCT_KEY,
+            "Maximum images and attachments one product may carry",
+            "20",
+        )
+        .name("Max Media per Product")
+        .input_type(InputType::Text),
     ]
 }
 
@@ -94,7 +154,26 @@ crate::solobase_feature_block! {
                 "tags": {"type": "array", "items": {"type": "string"}},
                 "metadata": {"type": "object"},
                 "image_url": {"type": "string"},
+                "media": {
+                    "type": "array",
+                    "description": "Derived: images and attachments in display order, each with a resolved `url`. Read-only.",
+                    "items": {
+                        "type": "object",
+                        "properties": {
+                            "id": {"type": "string"},
+                            "kind": {"type": "string", "description": "image | attachment"},
+                            "position": {"type": "integer"},
+                            "url": {"type": "string"},
+                            "filename": {"type": "string"},
+                            "content_type": {"type": "string"},
+                            "size": {"type": "integer"},
+                            "object_id": {"type": "string", "description": "Files object this was copied from; empty for direct uploads."}
+                        }
+                    }
+                },
                 "stock": {"type": "integer"},
+                "track_inventory": {"type": "boolean", "description": "Enforce `stock` at purchase time."},
+                "out_of_stock": {"type": "boolean", "description": "Derived: tracked and `stock` <= 0. Read-only."},
                 "group_id": {"type": "string"},
                 "type_id": {"type": "string"},
                 "group_template_id": {"type": "string"},
@@ -110,7 +189,7 @@ crate::solobase_feature_block! {
 
         BlockInfo::new("suppers-ai/products", "0.0.1", "http-handler@v1", "Products, pricing, purchases, and payment integration")
             .instance_mode(InstanceMode::Singleton)
-            .requires(vec!["wafer-run/database".into(), "wafer-run/config".into(), "wafer-run/network".into()])
+            .requires(vec!["wafer-run/database".into(), "wafer-run/storage".into(), "wafer-run/config".into(), "wafer-run/network".into()])
             // Advisory table list — admin "Database tables" discovery + the
             // WRAP grant-UI read only `CollectionSchema::name`. The schema
             // itself (columns, indexes, FKs) lives solely in the block's
@@ -127,6 +206,12 @@ crate::solobase_feature_block! {
                 CollectionSchema::new(GROUP_TEMPLATES_TABLE),
                 CollectionSchema::new(PRODUCT_TEMPLATES_TABLE),
                 CollectionSchema::new(VARIABLES_TABLE),
+                CollectionSchema::new(repo::inventory::RESERVATIONS_TABLE),
+                CollectionSchema::new(repo::inventory::ADJUSTMENTS_TABLE),
+                CollectionSchema::new(repo::coupons::COUPONS_TABLE),
+                CollectionSchema::new(repo::coupons::REDEMPTIONS_TABLE),
+                CollectionSchema::new(repo::media::TABLE),
+                CollectionSchema::new(repo::tax::TAX_RATES_TABLE),
             ])
             .category(wafer_run::BlockCategory::Feature)
             .description("Product catalog, pricing engine, and payment processing. Manages products, groups, pricing templates with formula evaluation, purchases, and Stripe integration for checkout and recurring subscriptions.")
@@ -165,6 +250,12 @@ crate::solobase_feature_block! {
                 BlockEndpoint::get("/b/products/api/admin/products/{id}").summary("Get product").auth(AuthLevel::Admin),
                 BlockEndpoint::patch("/b/products/api/admin/products/{id}").summary("Update product").auth(AuthLevel::Admin),
                 BlockEndpoint::delete("/b/products/api/admin/products/{id}").summary("Delete product").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/products/api/admin/products/{id}/stock").summary("Get product stock and adjustments").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/products/api/admin/products/{id}/stock").summary("Adjust product stock").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/products/api/admin/products/{id}/media").summary("List product media").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/products/api/admin/products/{id}/media").summary("Upload or attach product media").auth(AuthLevel::Admin),
+                BlockEndpoint::patch("/b/products/api/admin/products/{id}/media").summary("Reorder product media").auth(AuthLevel::Admin),
+                BlockEndpoint::delete("/b/products/api/admin/products/{id}/media/{media_id}").summary("Delete product media").auth(AuthLevel::Admin),
                 // JSON admin API — groups
                 BlockEndpoint::get("/b/products/api/admin/groups").summary("List groups").auth(AuthLevel::Admin),
                 BlockEndpoint::post("/b/products/api/admin/groups").summary("Create group").auth(AuthLevel::Admin),
@@ -189,6 +280,33 @@ crate::solobase_feature_block! {
                 BlockEndpoint::get("/b/products/api/admin/purchases/{id}").summary("Get purchase").auth(AuthLevel::Admin),
                 BlockEndpoint::patch("/b/products/api/admin/purchases/{id}/refund").summary("Refund purchase").auth(AuthLevel::Admin),
                 BlockEndpoint::get("/b/products/api/admin/stats").summary("Stats").auth(AuthLevel::Admin),
+                // JSON admin API — coupons
+                BlockEndpoint::get("/b/products/api/admin/coupons").summary("List coupons").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/products/api/admin/coupons").summary("Create coupon").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/products/api/admin/coupons/{id}").summary("Get coupon").auth(AuthLevel::Admin),
+                BlockEndpoint::patch("/b/products/api/admin/coupons/{id}").summary("Update coupon").auth(AuthLevel::Admin),
+                BlockEndpoint::delete("/b/products/api/admin/coupons/{id}").summary("Delete coupon").auth(AuthLevel::Admin),
+                // JSON admin API — tax rates
+                BlockEndpoint::get("/b/products/api/admin/tax-rates").summary("List tax rates").auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/products/api/admin/tax-rates").summary("Create tax rate").auth(AuthLevel::Admin),
+                BlockEndpoint::get("/b/products/api/admin/tax-rates/{id}").summary("Get tax rate").auth(AuthLevel::Admin),
+                BlockEndpoint::patch("/b/products/api/admin/tax-rates/{id}").summary("Update tax rate").auth(AuthLevel::Admin),
+                BlockEndpoint::delete("/b/products/api/admin/tax-rates/{id}").summary("Delete tax rate").auth(AuthLevel::Admin),
+                // JSON admin API — configuration promotion (see `transfer`)
+                BlockEndpoint::get("/b/products/api/admin/export")
+                    .summary("Export configuration")
+                    .description("Variables and group, product and pricing templates as one JSON document, cross-referenced by name. Products, groups and purchases are not included.")
+                    .auth(AuthLevel::Admin),
+                BlockEndpoint::post("/b/products/api/admin/import")
+                    .summary("Import configuration")
+                    .description("Merge an export document by name: creates missing rows, updates changed ones, never deletes. Reports each item as created, updated or skipped; `?dry_run=true` reports without writing.")
+                    .query_params_schema(serde_json::json!({
+                        "type": "object",
+                        "properties": {
+                            "dry_run": {"type": "boolean", "default": false}
+                        }
+                    }))
+                    .auth(AuthLevel::Admin),
                 // Public + authenticated user surface
                 // Public catalog — highest-value developer-facing surface of
                 // this block; accurate shapes read from `handlers.rs`
@@ -234,6 +352,13 @@ crate::solobase_feature_block! {
                             "id": {"type": "string"}
                         }
                     }))
+                    .query_params_schema(serde_json::json!({
+                        "type": "object",
+                        "properties": {
+                            "fields": {"type": "string", "description": "Comma-separated fields to keep"},
+                            "expand": {"type": "string", "description": "Comma-separated: media, template, group"}
+                        }
+                    }))
                     .output_schema(serde_json::json!({
                         "type": "object",
                         "properties": {
@@ -242,7 +367,12 @@ crate::solobase_feature_block! {
                         }
                     }))
                     .tags(&["products"]),
+                BlockEndpoint::get("/b/products/media/{media_id}")
+                    .summary("Product media file")
+                    .description("Bytes of one product image or attachment. Public for active products.")
+                    .tags(&["products"]),
                 BlockEndpoint::post("/b/products/checkout").summary("Stripe checkout").auth(AuthLevel::Authenticated),
+                BlockEndpoint::post("/b/products/coupons/validate").summary("Quote a coupon against a cart").auth(AuthLevel::Authenticated),
                 BlockEndpoint::post("/b/products/purchases").summary("Create purchase").auth(AuthLevel::Authenticated),
                 BlockEndpoint::get("/b/products/purchases").summary("List purchases").auth(AuthLevel::Authenticated),
                 BlockEndpoint::get("/b/products/purchases/{id}").summary("Get purchase").auth(AuthLevel::Authenticated),
@@ -253,6 +383,15 @@ crate::solobase_feature_block! {
             .can_disable(true)
     },
     handle: |this, ctx, msg, input| {
+        // Deferred work queued by this block (see `blocks::jobs`).
+        if msg.kind == crate::blocks::jobs::RUN_KIND {
+            use crate::blocks::jobs;
+            return match jobs::job_type(&msg) {
+                inventory::RELEASE_JOB => jobs::respond(inventory::run_release_job(ctx).await),
+                _ => jobs::unknown_type(&msg),
+            };
+        }
+
         let path = msg.path().to_string();
         let action = msg.action().to_string();
 
@@ -341,6 +480,12 @@ crate::solobase_feature_block! {
             migrations::SQLITE_MIGRATIONS,
             migrations::POSTGRES_MIGRATIONS,
         )
-        .await
+        .await?;
+        if matches!(event.event_type, wafer_run::LifecycleType::Init) {
+            crate::services::Services::new(ctx, "suppers-ai/products")
+                .settings()
+                .register_sections(pages::settings_sections());
+        }
+        Ok(())
     },
 }
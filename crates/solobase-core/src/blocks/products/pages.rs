@@ -7,7 +7,7 @@ use wafer_run::{context::Context, InputStream, Message, OutputStream};
 
 use super::{repo, GROUPS_TABLE, PRICING_TABLE, PRODUCTS_TABLE};
 use crate::{
-    config_vars,
+    config_ui, config_vars,
     ui::{self, components, icons, settings_form, settings_form::SettingsSection},
     util::RecordExt,
 };
@@ -381,16 +381,19 @@ pub async fn purchases(ctx: &dyn Context, msg: &Message) -> OutputStream {
                         components::TableCol { label: "User", width: None },
                         components::TableCol { label: "Status", width: None },
                         components::TableCol { label: "Total", width: None },
+                        components::TableCol { label: "Tax", width: None },
                         components::TableCol { label: "Provider", width: None },
                         components::TableCol { label: "Date", width: None },
                     ];
                     @let rows: Vec<Vec<maud::Markup>> = list.records.iter().map(|r| {
                         let total_cents = r.i64_field("total_cents");
                         let amount = format!("{:.2}", total_cents as f64 / 100.0);
+                        let tax = format!("{:.2}", r.i64_field("tax_cents") as f64 / 100.0);
                         vec![
                             html! { span .text-sm { (r.str_field("user_id").get(..8).unwrap_or("—")) } },
                             components::status_badge(r.str_field("status")),
                             html! { span .font-medium { (amount) " " span .text-muted { (r.str_field("currency")) } } },
+                            html! { span .text-sm { (tax) " " span .text-muted { (r.str_field("billing_country")) } } },
                             html! { span .text-muted .text-sm { (r.str_field("provider")) } },
                             html! { span .text-muted .text-sm { (r.str_field("created_at").get(..10).unwrap_or("")) } },
                         ]
@@ -568,6 +571,23 @@ struct SettingsVars {
     webhooks: Vec<wafer_run::ConfigVar>,
 }
 
+/// The settings page's layout, for the admin UI's generic settings form
+/// (registered from `Init`; see [`crate::config_ui`]).
+pub(crate) fn settings_sections() -> Vec<config_ui::Section> {
+    let vars = settings_vars();
+    [
+        ("Features", &vars.features),
+        ("Stripe", &vars.stripe),
+        ("Webhooks", &vars.webhooks),
+    ]
+    .into_iter()
+    .map(|(title, vars)| config_ui::Section {
+        title: title.to_string(),
+        keys: vars.iter().map(|v| v.key.clone()).collect(),
+    })
+    .collect()
+}
+
 impl SettingsVars {
     /// Flatten to a single allowlist for the save handler.
     fn all(&self) -> Vec<wafer_run::ConfigVar> {
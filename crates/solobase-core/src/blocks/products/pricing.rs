@@ -1,10 +1,11 @@
-use std::collections::HashMap;
+use std::{collections::HashMap, time::Duration};
 
 use wafer_core::clients::database::{self as db, Record};
-use wafer_run::{context::Context, InputStream, OutputStream};
+use wafer_run::{context::Context, ErrorCode, InputStream, OutputStream, WaferError};
 
 use super::{PRICING_TABLE, PRODUCTS_TABLE};
 use crate::{
+    cache::LruCache,
     http::{err_bad_request, err_internal_no_cause, err_not_found, ok_json},
     util::RecordExt,
 };
@@ -67,9 +68,8 @@ pub async fn resolve_unit_price(
     let (price, formula) = if template_id.is_empty() {
         (base_price(product), None)
     } else {
-        match db::get(ctx, PRICING_TABLE, template_id).await {
-            Ok(template) => {
-                let formula = template.str_field("price_formula").to_string();
+        match template_formula(ctx, template_id).await {
+            Ok(Some(formula)) => {
                 if formula.is_empty() {
                     return Err("Empty pricing formula".to_string());
                 }
@@ -77,7 +77,7 @@ pub async fn resolve_unit_price(
                     .map_err(|e| format!("Formula evaluation error: {e}"))?;
                 (price, Some(formula))
             }
-            Err(_) => match on_missing_template {
+            Ok(None) | Err(_) => match on_missing_template {
                 MissingTemplate::Error => return Err("Pricing template not found".to_string()),
                 MissingTemplate::FallBackToBase => (base_price(product), None),
             },
@@ -91,6 +91,31 @@ pub async fn resolve_unit_price(
     })
 }
 
+/// `price_formula` by pricing-template id; `None` for a missing template.
+/// Pricing writes go through `crud` and the products import, which
+/// invalidate the table.
+static FORMULAS: LruCache<String, Option<String>> = LruCache::new(
+    "products.pricing_formulas",
+    &[PRICING_TABLE],
+    512,
+    Duration::from_secs(300),
+);
+
+async fn template_formula(
+    ctx: &dyn Context,
+    template_id: &str,
+) -> Result<Option<String>, WaferError> {
+    FORMULAS
+        .get_or_try_load(template_id.to_string(), || async {
+            match db::get(ctx, PRICING_TABLE, template_id).await {
+                Ok(template) => Ok(Some(template.str_field("price_formula").to_string())),
+                Err(e) if e.code == ErrorCode::NotFound => Ok(None),
+                Err(e) => Err(e),
+            }
+        })
+        .await
+}
+
 pub async fn handle_calculate(ctx: &dyn Context, input: InputStream) -> OutputStream {
     #[derive(serde::Deserialize)]
     struct CalcReq {
@@ -192,6 +217,23 @@ pub fn evaluate_formula(formula: &str, variables: &HashMap<String, f64>) -> Resu
     Ok(result)
 }
 
+/// The distinct variable names `formula` reads, in first-use order, after
+/// checking that it parses. Every name is bound to NaN for the check: NaN
+/// never equals zero, so only a literal `/ 0` fails as division by zero.
+pub fn formula_variables(formula: &str) -> Result<Vec<String>, String> {
+    let mut names: Vec<String> = Vec::new();
+    for token in tokenize(formula)? {
+        if let Token::Ident(name) = token {
+            if !names.contains(&name) {
+                names.push(name);
+            }
+        }
+    }
+    let bound = names.iter().map(|n| (n.clone(), f64::NAN)).collect();
+    evaluate_formula(formula, &bound)?;
+    Ok(names)
+}
+
 #[derive(Debug, Clone)]
 enum Token {
     Number(f64),
@@ -392,4 +434,15 @@ mod tests {
             );
         }
     }
+
+    #[test]
+    fn formula_variables_lists_names_once() {
+        assert_eq!(
+            formula_variables("base_price * quantity + base_price / (quantity - 1)").unwrap(),
+            vec!["base_price", "quantity"]
+        );
+        assert_eq!(formula_variables("2 * 3").unwrap(), Vec::<String>::new());
+        assert!(formula_variables("base_price *").is_err());
+        assert!(formula_variables("1 / 0").is_err());
+    }
 }
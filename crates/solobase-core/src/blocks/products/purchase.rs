@@ -6,53 +6,58 @@ use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream}
 
 use super::{repo, PRODUCTS_TABLE};
 use crate::{
+    blocks::errors,
     http::{
         err_bad_request, err_forbidden, err_internal, err_not_found, err_unauthorized, ok_json,
     },
     util::RecordExt,
 };
 
-pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
-    #[derive(serde::Deserialize)]
-    struct CreateReq {
-        items: Vec<PurchaseItem>,
-        currency: Option<String>,
-    }
-    #[derive(serde::Deserialize)]
-    struct PurchaseItem {
-        product_id: String,
-        quantity: i64,
-        #[serde(default)]
-        variables: HashMap<String, f64>,
-    }
-
-    let raw = input.collect_to_bytes().await;
-    let body: CreateReq = match serde_json::from_slice(&raw) {
-        Ok(b) => b,
-        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
-    };
+/// One requested cart line, shared by purchase creation and coupon
+/// validation.
+#[derive(serde::Deserialize)]
+pub(super) struct CartItem {
+    pub product_id: String,
+    pub quantity: i64,
+    #[serde(default)]
+    pub variables: HashMap<String, f64>,
+}
 
-    if body.items.is_empty() {
-        return err_bad_request("No items in purchase");
-    }
+/// A cart line after product lookup and price resolution.
+pub(super) struct PricedLine {
+    pub product_id: String,
+    pub product_name: String,
+    pub product_template_id: String,
+    pub quantity: i64,
+    pub unit_price: f64,
+    pub line_total: f64,
+    pub variables: HashMap<String, f64>,
+}
 
-    let currency = body.currency.unwrap_or_else(|| "USD".to_string());
-    let now = chrono::Utc::now().to_rfc3339();
-    let user_id = msg.user_id().to_string();
-    if user_id.is_empty() {
-        return err_unauthorized("Authentication required to create a purchase");
+impl PricedLine {
+    /// The line total in cents. `line_total` comes from a validated unit
+    /// price, so it is finite.
+    pub fn total_cents(&self) -> i64 {
+        (self.line_total * 100.0).round() as i64
     }
+}
 
-    // Calculate totals
-    let mut total_amount = 0.0;
-    let mut line_items_data = Vec::new();
-
-    for item in &body.items {
+/// Look up and price every cart line, refusing unknown, unavailable, or
+/// badly-priced products with the response to send.
+pub(super) async fn price_cart(
+    ctx: &dyn Context,
+    items: &[CartItem],
+) -> Result<Vec<PricedLine>, OutputStream> {
+    let mut lines = Vec::with_capacity(items.len());
+    for item in items {
         if item.quantity <= 0 {
-            return err_bad_request("Quantity must be positive");
+            return Err(err_bad_request("Quantity must be positive"));
         }
         let Ok(product) = db::get(ctx, PRODUCTS_TABLE, &item.product_id).await else {
-            return err_not_found(&format!("Product {} not found", item.product_id));
+            return Err(err_not_found(&format!(
+                "Product {} not found",
+                item.product_id
+            )));
         };
 
         // Reject draft/deleted/inactive products
@@ -62,10 +67,10 @@ pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream)
             .and_then(|v| v.as_str())
             .unwrap_or("active");
         if product_status != "active" {
-            return err_bad_request(&format!(
+            return Err(err_bad_request(&format!(
                 "Product {} is not available for purchase (status: {})",
                 item.product_id, product_status
-            ));
+            )));
         }
         if !product
             .data
@@ -74,7 +79,10 @@ pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream)
             .unwrap_or("")
             .is_empty()
         {
-            return err_not_found(&format!("Product {} not found", item.product_id));
+            return Err(err_not_found(&format!(
+                "Product {} not found",
+                item.product_id
+            )));
         }
 
         let product_name = product
@@ -100,42 +108,128 @@ pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream)
         {
             Ok(resolved) => resolved.unit_price,
             Err(e) => {
-                return err_bad_request(&format!(
+                return Err(err_bad_request(&format!(
                     "Invalid price for product {}: {e}",
                     item.product_id
-                ))
+                )))
             }
         };
 
-        let line_total = unit_price * item.quantity as f64;
-        total_amount += line_total;
-
-        line_items_data.push((
-            item.product_id.clone(),
+        lines.push(PricedLine {
+            product_id: item.product_id.clone(),
             product_name,
-            item.quantity,
+            product_template_id: product.str_field("product_template_id").to_string(),
+            quantity: item.quantity,
             unit_price,
-            line_total,
-            &item.variables,
-        ));
+            line_total: unit_price * item.quantity as f64,
+            variables: item.variables.clone(),
+        });
     }
+    Ok(lines)
+}
+
+/// The cart total in cents, refusing a total that can't be charged.
+pub(super) fn cart_total_cents(lines: &[PricedLine]) -> Result<i64, OutputStream> {
+    let total_amount: f64 = lines.iter().map(|l| l.line_total).sum();
 
     // `as i64` saturates on overflow / NaN — both would silently produce a
     // bogus i64. Validate first so a pathological formula result (NaN, ±inf,
     // > i64::MAX cents ≈ $9.2e16) can't sneak through.
     let total_cents_f = total_amount * 100.0;
     if !total_cents_f.is_finite() {
-        return err_bad_request("Purchase total is not a finite number");
+        return Err(err_bad_request("Purchase total is not a finite number"));
     }
     let rounded = total_cents_f.round();
     if rounded < 1.0 || rounded > i64::MAX as f64 {
-        return err_bad_request("Purchase total is out of range");
+        return Err(err_bad_request("Purchase total is out of range"));
+    }
+    Ok(rounded as i64)
+}
+
+pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct CreateReq {
+        items: Vec<CartItem>,
+        currency: Option<String>,
+        coupon_code: Option<String>,
+        /// Country (`DE`) or subdivision (`US-CA`) the buyer is taxed in.
+        billing_country: Option<String>,
+    }
+
+    let raw = input.collect_to_bytes().await;
+    let body: CreateReq = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+
+    if body.items.is_empty() {
+        return err_bad_request("No items in purchase");
     }
-    let total_cents = rounded as i64;
-    if total_cents <= 0 {
+
+    let currency = body.currency.unwrap_or_else(|| "USD".to_string());
+    let billing_country = match body.billing_country.as_deref().map(str::trim) {
+        Some(region) if !region.is_empty() => match super::tax::normalize_region(region) {
+            Some(region) => region,
+            None => {
+                return errors::validation_error(
+                    "Invalid purchase",
+                    &[(
+                        "billing_country",
+                        "must be a country code (DE) or country subdivision (US-CA)",
+                    )],
+                )
+            }
+        },
+        _ => String::new(),
+    };
+    let now = crate::util::now_rfc3339();
+    let user_id = msg.user_id().to_string();
+    if user_id.is_empty() {
+        return err_unauthorized("Authentication required to create a purchase");
+    }
+
+    // Calculate totals
+    let lines = match price_cart(ctx, &body.items).await {
+        Ok(lines) => lines,
+        Err(resp) => return resp,
+    };
+    let subtotal_cents = match cart_total_cents(&lines) {
+        Ok(n) => n,
+        Err(resp) => return resp,
+    };
+
+    // Price the coupon against the cart. The redemption itself is claimed
+    // only once the purchase and its stock hold exist, so a failure before
+    // then has nothing to hand back.
+    let quote = match body.coupon_code.as_deref().map(str::trim) {
+        Some(code) if !code.is_empty() => {
+            match super::coupons::quote(ctx, code, &user_id, &lines).await {
+                Ok(q) => Some(q),
+                Err(resp) => return resp,
+            }
+        }
+        _ => None,
+    };
+    let discount_cents = quote.as_ref().map_or(0, |q| q.discount.discount_cents);
+    if subtotal_cents - discount_cents <= 0 {
         return err_bad_request("Purchase total must be greater than zero");
     }
 
+    // Tax what's left after the discount. Exclusive tax is added to the
+    // total the buyer is charged; inclusive tax is already inside it.
+    let line_discounts = quote
+        .as_ref()
+        .map(|q| q.discount.per_line.clone())
+        .unwrap_or_default();
+    let tax = match super::tax::compute(ctx, &billing_country, &currency, &lines, &line_discounts)
+        .await
+    {
+        Ok(t) => t,
+        Err(resp) => return resp,
+    };
+    let total_cents = subtotal_cents - discount_cents + tax.added_cents;
+    let tax_items = serde_json::to_string(&tax.items).unwrap_or_else(|_| "[]".to_string());
+
     // Create purchase
     let mut purchase_data = HashMap::new();
     purchase_data.insert(
@@ -148,6 +242,20 @@ pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream)
     );
     purchase_data.insert("total_cents".to_string(), serde_json::json!(total_cents));
     purchase_data.insert("amount_cents".to_string(), serde_json::json!(total_cents));
+    purchase_data.insert(
+        "discount_cents".to_string(),
+        serde_json::json!(discount_cents),
+    );
+    purchase_data.insert(
+        "coupon_code".to_string(),
+        serde_json::json!(quote.as_ref().map_or("", |q| q.coupon.code.as_str())),
+    );
+    purchase_data.insert("tax_cents".to_string(), serde_json::json!(tax.tax_cents));
+    purchase_data.insert("tax_items".to_string(), serde_json::json!(tax_items));
+    purchase_data.insert(
+        "billing_country".to_string(),
+        serde_json::json!(billing_country),
+    );
     purchase_data.insert("currency".to_string(), serde_json::Value::String(currency));
     purchase_data.insert(
         "provider".to_string(),
@@ -168,7 +276,8 @@ pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream)
     };
 
     // Create line items — roll back purchase on failure
-    for (product_id, product_name, qty, unit_price, line_total, variables) in &line_items_data {
+    for (idx, line) in lines.iter().enumerate() {
+        let line_discount = quote.as_ref().map_or(0, |q| q.discount.per_line[idx]);
         let mut item_data = HashMap::new();
         item_data.insert(
             "purchase_id".to_string(),
@@ -176,16 +285,27 @@ pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream)
         );
         item_data.insert(
             "product_id".to_string(),
-            serde_json::Value::String(product_id.clone()),
+            serde_json::Value::String(line.product_id.clone()),
         );
         item_data.insert(
             "product_name".to_string(),
-            serde_json::Value::String(product_name.clone()),
+            serde_json::Value::String(line.product_name.clone()),
+        );
+        item_data.insert("quantity".to_string(), serde_json::json!(line.quantity));
+        item_data.insert("unit_price".to_string(), serde_json::json!(line.unit_price));
+        item_data.insert(
+            "total_price".to_string(),
+            serde_json::json!(line.line_total),
+        );
+        item_data.insert(
+            "discount_cents".to_string(),
+            serde_json::json!(line_discount),
         );
-        item_data.insert("quantity".to_string(), serde_json::json!(qty));
-        item_data.insert("unit_price".to_string(), serde_json::json!(unit_price));
-        item_data.insert("total_price".to_string(), serde_json::json!(line_total));
-        item_data.insert("variables".to_string(), serde_json::json!(variables));
+        item_data.insert(
+            "tax_cents".to_string(),
+            serde_json::json!(tax.per_line[idx]),
+        );
+        item_data.insert("variables".to_string(), serde_json::json!(line.variables));
         item_data.insert(
             "created_at".to_string(),
             serde_json::Value::String(now.clone()),
@@ -196,15 +316,39 @@ pub async fn handle_create(ctx: &dyn Context, msg: &Message, input: InputStream)
         }
     }
 
+    // Hold stock for tracked products; refuse the purchase outright when any
+    // line exceeds what's available.
+    if let Err(resp) = super::inventory::reserve_for_purchase(ctx, &purchase.id).await {
+        rollback_purchase(ctx, &purchase.id).await;
+        return resp;
+    }
+
+    // Take the coupon redemption last, so losing the race for a coupon's
+    // final redemption only has to undo the purchase and its stock hold.
+    if let Some(q) = &quote {
+        if let Err(resp) = super::coupons::redeem(ctx, q, &user_id, &purchase.id).await {
+            super::inventory::release_purchase(ctx, &purchase.id).await;
+            rollback_purchase(ctx, &purchase.id).await;
+            return resp;
+        }
+    }
+
     ok_json(&serde_json::json!({
         "id": purchase.id,
         "status": "pending",
+        "subtotal_cents": subtotal_cents,
         "total_cents": total_cents,
-        "item_count": line_items_data.len()
+        "discount_cents": discount_cents,
+        "tax_cents": tax.tax_cents,
+        "tax_items": tax.items,
+        "billing_country": billing_country,
+        "coupon_code": quote.as_ref().map_or("", |q| q.coupon.code.as_str()),
+        "item_count": lines.len()
     }))
 }
 
-/// Roll back a partially-created purchase after a line-item insert fails.
+/// Roll back a partially-created purchase after a line-item insert or the
+/// stock reservation fails.
 ///
 /// Tries to delete the purchase header first; if the delete fails (transient
 /// DB error, foreign-key constraints from already-inserted siblings, etc.),
@@ -226,7 +370,7 @@ async fn rollback_purchase(ctx: &dyn Context, purchase_id: &str) {
         );
         fail_data.insert(
             "updated_at".to_string(),
-            serde_json::Value::String(chrono::Utc::now().to_rfc3339()),
+            serde_json::Value::String(crate::util::now_rfc3339()),
         );
         if let Err(mark_err) = repo::purchases::update(ctx, purchase_id, fail_data).await {
             tracing::error!(
@@ -319,6 +463,7 @@ pub async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
         .unwrap_or_default();
 
     ok_json(&serde_json::json!({
+        "tax_items": super::tax::items_of(&purchase),
         "purchase": purchase,
         "line_items": line_items
     }))
@@ -351,12 +496,11 @@ pub async fn handle_refund(ctx: &dyn Context, msg: &Message, input: InputStream)
     let body: RefundReq = serde_json::from_slice(&raw).unwrap_or_default();
 
     // Verify purchase exists
-    if let Err(e) = repo::purchases::get(ctx, &id).await {
-        if e.code == ErrorCode::NotFound {
-            return err_not_found("Purchase not found");
-        }
-        return err_internal("Database error", e);
-    }
+    let purchase = match repo::purchases::get(ctx, &id).await {
+        Ok(p) => p,
+        Err(e) if e.code == ErrorCode::NotFound => return err_not_found("Purchase not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
 
     // Atomic status transition: completed → refunded (prevents double-refund race)
     let refunded_by = msg.user_id().to_string();
@@ -372,6 +516,25 @@ pub async fn handle_refund(ctx: &dyn Context, msg: &Message, input: InputStream)
         );
     }
 
+    if let Err(e) = super::inventory::restore_purchase(ctx, &id, &refunded_by).await {
+        tracing::error!(error = %e, purchase_id = %id, "refunded but stock not restored");
+    }
+    super::coupons::release_on_refund(ctx, &id).await;
+
+    // An admin refund returns the whole purchase, tax included.
+    let total_cents = purchase.i64_field("total_cents");
+    let tax_cents = purchase.i64_field("tax_cents");
+    if let Err(e) = repo::purchases::record_refund_amounts(
+        ctx,
+        &id,
+        total_cents,
+        super::tax::refund_tax_cents(tax_cents, total_cents, total_cents),
+    )
+    .await
+    {
+        tracing::error!(error = %e, purchase_id = %id, "refunded but amounts not recorded");
+    }
+
     // Fetch the updated record for the response
     match repo::purchases::get(ctx, &id).await {
         Ok(record) => ok_json(&record),
@@ -0,0 +1,197 @@
+//! Data access for coupons and their per-purchase redemptions. Policy
+//! (validity windows, discount maths, when a redemption is claimed or
+//! released) lives in `products::coupons`.
+
+use std::collections::HashMap;
+
+use wafer_block::db::{Filter, FilterOp, SortField};
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, WaferError};
+
+/// Admin-defined discount codes.
+pub(crate) const COUPONS_TABLE: &str = "suppers_ai__products__coupons";
+
+/// One row per purchase that used a coupon.
+pub(crate) const REDEMPTIONS_TABLE: &str = "suppers_ai__products__coupon_redemptions";
+
+/// Redemption row states. Only `active` rows count against limits.
+pub(crate) const REDEMPTION_ACTIVE: &str = "active";
+pub(crate) const REDEMPTION_RELEASED: &str = "released";
+
+fn eq(field: &str, value: serde_json::Value) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value,
+    }
+}
+
+/// Fetch a coupon by id.
+pub(crate) async fn get(ctx: &dyn Context, id: &str) -> Result<Record, WaferError> {
+    db::get(ctx, COUPONS_TABLE, id).await
+}
+
+/// Fetch a coupon by its normalized (upper-cased) code.
+pub(crate) async fn get_by_code(ctx: &dyn Context, code: &str) -> Result<Record, WaferError> {
+    db::get_by_field(ctx, COUPONS_TABLE, "code", serde_json::json!(code)).await
+}
+
+/// Paginated coupon list, alphabetical by code.
+pub(crate) async fn list_paginated(
+    ctx: &dyn Context,
+    filters: Vec<Filter>,
+    page: i64,
+    page_size: i64,
+) -> Result<RecordList, WaferError> {
+    let sort = vec![SortField {
+        field: "code".to_string(),
+        desc: false,
+    }];
+    db::paginated_list(ctx, COUPONS_TABLE, page, page_size, filters, sort).await
+}
+
+/// Insert a coupon. Caller supplies the full field map.
+pub(crate) async fn create(
+    ctx: &dyn Context,
+    data: HashMap<String, serde_json::Value>,
+) -> Result<Record, WaferError> {
+    db::create(ctx, COUPONS_TABLE, data).await
+}
+
+/// Apply a field update to a coupon by id.
+pub(crate) async fn update(
+    ctx: &dyn Context,
+    id: &str,
+    data: HashMap<String, serde_json::Value>,
+) -> Result<Record, WaferError> {
+    db::update(ctx, COUPONS_TABLE, id, data).await
+}
+
+/// Delete a coupon. Redemption rows are kept as history.
+pub(crate) async fn delete(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
+    db::delete(ctx, COUPONS_TABLE, id).await
+}
+
+/// Atomically take one redemption from an active coupon:
+///   UPDATE coupons SET redemption_count = redemption_count + 1
+///   WHERE id = ? AND active = 1 [AND redemption_count < max_redemptions]
+/// Returns `Ok(false)` when the coupon is exhausted or was deactivated, so
+/// concurrent checkouts can never push the count past `max_redemptions`.
+/// `max_redemptions` of 0 means unlimited.
+pub(crate) async fn claim(
+    ctx: &dyn Context,
+    coupon_id: &str,
+    max_redemptions: i64,
+) -> Result<bool, WaferError> {
+    let mut filters = vec![
+        eq("id", serde_json::json!(coupon_id)),
+        eq("active", serde_json::json!(1)),
+    ];
+    if max_redemptions > 0 {
+        filters.push(Filter {
+            field: "redemption_count".to_string(),
+            operator: FilterOp::LessThan,
+            value: serde_json::json!(max_redemptions),
+        });
+    }
+    let rows =
+        db::increment_field_where(ctx, COUPONS_TABLE, "redemption_count", 1, &filters).await?;
+    Ok(rows > 0)
+}
+
+/// Hand one redemption back to a coupon. Never drives the count negative.
+pub(crate) async fn unclaim(ctx: &dyn Context, coupon_id: &str) -> Result<(), WaferError> {
+    let filters = vec![
+        eq("id", serde_json::json!(coupon_id)),
+        Filter {
+            field: "redemption_count".to_string(),
+            operator: FilterOp::GreaterThan,
+            value: serde_json::json!(0),
+        },
+    ];
+    db::increment_field_where(ctx, COUPONS_TABLE, "redemption_count", -1, &filters).await?;
+    Ok(())
+}
+
+/// Fields of a redemption row.
+pub(crate) struct NewRedemption<'a> {
+    pub coupon_id: &'a str,
+    pub code: &'a str,
+    pub user_id: &'a str,
+    pub purchase_id: &'a str,
+    pub discount_cents: i64,
+}
+
+/// Record that `purchase_id` used a coupon.
+pub(crate) async fn record_redemption(
+    ctx: &dyn Context,
+    r: NewRedemption<'_>,
+) -> Result<Record, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "coupon_id": r.coupon_id,
+        "code": r.code,
+        "user_id": r.user_id,
+        "purchase_id": r.purchase_id,
+        "discount_cents": r.discount_cents,
+        "status": REDEMPTION_ACTIVE,
+        "created_at": &now,
+        "updated_at": &now,
+    }));
+    db::create(ctx, REDEMPTIONS_TABLE, data).await
+}
+
+/// Active redemptions of `coupon_id` by `user_id` — the per-user limit
+/// count.
+pub(crate) async fn user_redemption_count(
+    ctx: &dyn Context,
+    coupon_id: &str,
+    user_id: &str,
+) -> Result<i64, WaferError> {
+    let filters = [
+        eq("coupon_id", serde_json::json!(coupon_id)),
+        eq("user_id", serde_json::json!(user_id)),
+        eq("status", serde_json::json!(REDEMPTION_ACTIVE)),
+    ];
+    db::count(ctx, REDEMPTIONS_TABLE, &filters).await
+}
+
+/// `purchase_id`'s active redemptions (at most one in practice).
+pub(crate) async fn active_for_purchase(
+    ctx: &dyn Context,
+    purchase_id: &str,
+) -> Result<Vec<Record>, WaferError> {
+    db::list_all(
+        ctx,
+        REDEMPTIONS_TABLE,
+        vec![
+            eq("purchase_id", serde_json::json!(purchase_id)),
+            eq("status", serde_json::json!(REDEMPTION_ACTIVE)),
+        ],
+    )
+    .await
+}
+
+/// Atomically flip a redemption `active` -> `released`. Returns `Ok(false)`
+/// when another caller already released it, so the coupon count is handed
+/// back at most once.
+pub(crate) async fn mark_released(
+    ctx: &dyn Context,
+    redemption_id: &str,
+) -> Result<bool, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({
+        "status": REDEMPTION_RELEASED,
+        "updated_at": crate::util::now_rfc3339(),
+    }));
+    let rows = db::update_by_filters_count(
+        ctx,
+        REDEMPTIONS_TABLE,
+        vec![
+            eq("id", serde_json::json!(redemption_id)),
+            eq("status", serde_json::json!(REDEMPTION_ACTIVE)),
+        ],
+        data,
+    )
+    .await?;
+    Ok(rows > 0)
+}
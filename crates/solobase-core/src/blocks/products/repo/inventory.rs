@@ -0,0 +1,227 @@
+//! Data access for product stock: the conditional `stock` updates on the
+//! products table, pending-checkout reservations, and the adjustment audit
+//! trail. Policy (which purchases reserve, when stock is committed or
+//! restored) lives in `products::inventory`.
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, WaferError};
+
+use super::super::PRODUCTS_TABLE;
+use crate::blocks::errors;
+
+/// Quantity held for a pending purchase until `expires_at`.
+pub(crate) const RESERVATIONS_TABLE: &str = "suppers_ai__products__stock_reservations";
+
+/// Append-only audit trail of every stock change.
+pub(crate) const ADJUSTMENTS_TABLE: &str = "suppers_ai__products__stock_adjustments";
+
+fn eq(field: &str, value: serde_json::Value) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value,
+    }
+}
+
+/// Atomically take `qty` units from a product's `stock`:
+///   UPDATE products SET stock = stock - qty WHERE id = ? AND stock >= qty
+/// Returns `Ok(false)` when the row lacks enough stock, so two concurrent
+/// purchases can never drive it negative.
+pub(crate) async fn decrement_stock(
+    ctx: &dyn Context,
+    product_id: &str,
+    qty: i64,
+) -> Result<bool, WaferError> {
+    let filters = vec![
+        eq("id", serde_json::json!(product_id)),
+        Filter {
+            field: "stock".to_string(),
+            operator: FilterOp::GreaterEqual,
+            value: serde_json::json!(qty),
+        },
+    ];
+    let rows = db::increment_field_where(ctx, PRODUCTS_TABLE, "stock", -qty, &filters).await?;
+    Ok(rows > 0)
+}
+
+/// Atomically add `qty` units to a product's `stock`. Returns `Ok(false)`
+/// when the product no longer exists.
+pub(crate) async fn increment_stock(
+    ctx: &dyn Context,
+    product_id: &str,
+    qty: i64,
+) -> Result<bool, WaferError> {
+    let filters = vec![eq("id", serde_json::json!(product_id))];
+    let rows = db::increment_field_where(ctx, PRODUCTS_TABLE, "stock", qty, &filters).await?;
+    Ok(rows > 0)
+}
+
+/// Units of `product_id` held by unexpired reservations, excluding those of
+/// `exclude_purchase` (so re-reserving a purchase doesn't count itself).
+pub(crate) async fn reserved_quantity(
+    ctx: &dyn Context,
+    product_id: &str,
+    now: &str,
+    exclude_purchase: Option<&str>,
+) -> Result<i64, WaferError> {
+    let mut filters = vec![
+        eq("product_id", serde_json::json!(product_id)),
+        Filter {
+            field: "expires_at".to_string(),
+            operator: FilterOp::GreaterThan,
+            value: serde_json::json!(now),
+        },
+    ];
+    if let Some(purchase_id) = exclude_purchase {
+        filters.push(Filter {
+            field: "purchase_id".to_string(),
+            operator: FilterOp::NotEqual,
+            value: serde_json::json!(purchase_id),
+        });
+    }
+    let total = db::sum(ctx, RESERVATIONS_TABLE, "quantity", &filters).await?;
+    Ok(total.round() as i64)
+}
+
+/// Replace `purchase_id`'s reservations with one row per `(product_id, qty)`
+/// held until `expires_at`.
+pub(crate) async fn replace_reservations(
+    ctx: &dyn Context,
+    purchase_id: &str,
+    items: &[(String, i64)],
+    expires_at: &str,
+) -> Result<(), WaferError> {
+    release_reservations(ctx, purchase_id).await?;
+    let now = crate::util::now_rfc3339();
+    for (product_id, qty) in items {
+        let data = crate::util::json_map(serde_json::json!({
+            "product_id": product_id,
+            "purchase_id": purchase_id,
+            "quantity": qty,
+            "expires_at": expires_at,
+            "created_at": &now,
+            "updated_at": &now,
+        }));
+        db::create(ctx, RESERVATIONS_TABLE, data).await?;
+    }
+    Ok(())
+}
+
+/// Drop every reservation held by `purchase_id` (paid, cancelled, or about
+/// to be re-reserved).
+pub(crate) async fn release_reservations(
+    ctx: &dyn Context,
+    purchase_id: &str,
+) -> Result<(), WaferError> {
+    db::delete_by_filters(
+        ctx,
+        RESERVATIONS_TABLE,
+        vec![eq("purchase_id", serde_json::json!(purchase_id))],
+    )
+    .await
+}
+
+/// Delete reservations that expired before `cutoff`. They already stopped
+/// counting against stock; this only keeps the table small.
+pub(crate) async fn delete_expired_reservations(
+    ctx: &dyn Context,
+    cutoff: &str,
+) -> Result<i64, WaferError> {
+    db::delete_by_filters_count(
+        ctx,
+        RESERVATIONS_TABLE,
+        vec![Filter {
+            field: "expires_at".to_string(),
+            operator: FilterOp::LessEqual,
+            value: serde_json::json!(cutoff),
+        }],
+    )
+    .await
+}
+
+/// Fields of a stock audit row.
+pub(crate) struct NewAdjustment<'a> {
+    pub product_id: &'a str,
+    /// Signed change applied to `stock`.
+    pub delta: i64,
+    /// `manual`, `purchase`, or `refund`.
+    pub reason: &'a str,
+    pub purchase_id: &'a str,
+    pub actor: &'a str,
+    pub note: &'a str,
+}
+
+/// Append a stock audit row.
+pub(crate) async fn record_adjustment(
+    ctx: &dyn Context,
+    adj: NewAdjustment<'_>,
+) -> Result<Record, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "product_id": adj.product_id,
+        "delta": adj.delta,
+        "reason": adj.reason,
+        "purchase_id": adj.purchase_id,
+        "actor": adj.actor,
+        "note": adj.note,
+        "created_at": &now,
+        "updated_at": &now,
+    }));
+    db::create(ctx, ADJUSTMENTS_TABLE, data).await
+}
+
+/// Append a purchase's audit row as the at-most-once guard for committing
+/// or restoring one of its products. A unique index on `(purchase_id,
+/// product_id, reason)` lets only one caller claim each; `Ok(None)` means
+/// another already did.
+pub(crate) async fn claim_adjustment(
+    ctx: &dyn Context,
+    adj: NewAdjustment<'_>,
+) -> Result<Option<Record>, WaferError> {
+    match record_adjustment(ctx, adj).await {
+        Ok(rec) => Ok(Some(rec)),
+        Err(e) if errors::is_unique_violation(&e) => Ok(None),
+        Err(e) => Err(e),
+    }
+}
+
+/// Give back a [`claim_adjustment`] whose stock change didn't happen.
+pub(crate) async fn delete_adjustment(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
+    db::delete(ctx, ADJUSTMENTS_TABLE, id).await.map(|_| ())
+}
+
+/// `purchase_id`'s adjustments with `reason`.
+pub(crate) async fn list_purchase_adjustments(
+    ctx: &dyn Context,
+    purchase_id: &str,
+    reason: &str,
+) -> Result<Vec<Record>, WaferError> {
+    db::list_all(
+        ctx,
+        ADJUSTMENTS_TABLE,
+        vec![
+            eq("purchase_id", serde_json::json!(purchase_id)),
+            eq("reason", serde_json::json!(reason)),
+        ],
+    )
+    .await
+}
+
+/// A product's adjustments, newest first.
+pub(crate) async fn list_adjustments(
+    ctx: &dyn Context,
+    product_id: &str,
+    limit: i64,
+) -> Result<RecordList, WaferError> {
+    let opts = ListOptions {
+        filters: vec![eq("product_id", serde_json::json!(product_id))],
+        sort: vec![SortField {
+            field: "created_at".to_string(),
+            desc: true,
+        }],
+        limit,
+        ..Default::default()
+    };
+    db::list(ctx, ADJUSTMENTS_TABLE, &opts).await
+}
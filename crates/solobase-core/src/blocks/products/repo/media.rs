@@ -0,0 +1,160 @@
+//! Data access for product media rows. The stored bytes and the rules for
+//! attaching, ordering and removing media live in `products::media`.
+
+use std::collections::HashMap;
+
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, WaferError};
+
+use crate::util::RecordExt;
+
+/// One row per image or attachment on a product.
+pub(crate) const TABLE: &str = "suppers_ai__products__product_media";
+
+fn eq(field: &str, value: serde_json::Value) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value,
+    }
+}
+
+/// Sort rows into display order: `position`, then oldest first.
+fn sort_by_position(rows: &mut [Record]) {
+    rows.sort_by(|a, b| {
+        a.i64_field("position")
+            .cmp(&b.i64_field("position"))
+            .then_with(|| a.str_field("created_at").cmp(b.str_field("created_at")))
+    });
+}
+
+/// Fetch a media row by id.
+pub(crate) async fn get(ctx: &dyn Context, id: &str) -> Result<Record, WaferError> {
+    db::get(ctx, TABLE, id).await
+}
+
+/// A product's media in display order.
+pub(crate) async fn list_for_product(
+    ctx: &dyn Context,
+    product_id: &str,
+) -> Result<Vec<Record>, WaferError> {
+    let mut rows = db::list_all(
+        ctx,
+        TABLE,
+        vec![eq("product_id", serde_json::json!(product_id))],
+    )
+    .await?;
+    sort_by_position(&mut rows);
+    Ok(rows)
+}
+
+/// Media for several products at once, grouped by `product_id`, each group
+/// in display order. Used to stamp a whole list page with one query.
+pub(crate) async fn list_for_products(
+    ctx: &dyn Context,
+    product_ids: &[String],
+) -> Result<HashMap<String, Vec<Record>>, WaferError> {
+    let mut grouped: HashMap<String, Vec<Record>> = HashMap::new();
+    if product_ids.is_empty() {
+        return Ok(grouped);
+    }
+    let filters = vec![Filter {
+        field: "product_id".to_string(),
+        operator: FilterOp::In,
+        value: serde_json::json!(product_ids),
+    }];
+    for row in db::list_all(ctx, TABLE, filters).await? {
+        grouped
+            .entry(row.str_field("product_id").to_string())
+            .or_default()
+            .push(row);
+    }
+    grouped.values_mut().for_each(|rows| sort_by_position(rows));
+    Ok(grouped)
+}
+
+/// Number of media rows on a product.
+pub(crate) async fn count_for_product(
+    ctx: &dyn Context,
+    product_id: &str,
+) -> Result<i64, WaferError> {
+    db::count(
+        ctx,
+        TABLE,
+        &[eq("product_id", serde_json::json!(product_id))],
+    )
+    .await
+}
+
+/// Fields of a new media row.
+pub(crate) struct NewMedia<'a> {
+    pub id: &'a str,
+    pub product_id: &'a str,
+    /// `image` or `attachment`.
+    pub kind: &'a str,
+    pub position: i64,
+    /// Files object this was attached from; empty for direct uploads.
+    pub object_id: &'a str,
+    pub source_bucket: &'a str,
+    pub source_key: &'a str,
+    /// Key of the copy in the products-owned media bucket.
+    pub storage_key: &'a str,
+    pub filename: &'a str,
+    pub content_type: &'a str,
+    pub size: i64,
+    pub created_by: &'a str,
+}
+
+/// Insert a media row.
+pub(crate) async fn insert(ctx: &dyn Context, media: NewMedia<'_>) -> Result<Record, WaferError> {
+    let now = crate::util::now_rfc3339();
+    let data = crate::util::json_map(serde_json::json!({
+        "id": media.id,
+        "product_id": media.product_id,
+        "kind": media.kind,
+        "position": media.position,
+        "object_id": media.object_id,
+        "source_bucket": media.source_bucket,
+        "source_key": media.source_key,
+        "storage_key": media.storage_key,
+        "filename": media.filename,
+        "content_type": media.content_type,
+        "size": media.size,
+        "created_by": media.created_by,
+        "created_at": &now,
+        "updated_at": &now,
+    }));
+    db::create(ctx, TABLE, data).await
+}
+
+/// Move a media row to `position`.
+pub(crate) async fn set_position(
+    ctx: &dyn Context,
+    id: &str,
+    position: i64,
+) -> Result<Record, WaferError> {
+    let data = crate::util::json_map(serde_json::json!({
+        "position": position,
+        "updated_at": crate::util::now_rfc3339(),
+    }));
+    db::update(ctx, TABLE, id, data).await
+}
+
+/// Delete one media row.
+pub(crate) async fn delete(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
+    db::delete(ctx, TABLE, id).await
+}
+
+/// Delete every media row on a product.
+pub(crate) async fn delete_for_product(
+    ctx: &dyn Context,
+    product_id: &str,
+) -> Result<(), WaferError> {
+    db::delete_by_filters(
+        ctx,
+        TABLE,
+        vec![eq("product_id", serde_json::json!(product_id))],
+    )
+    .await
+}
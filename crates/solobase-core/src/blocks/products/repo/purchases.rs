@@ -86,7 +86,7 @@ pub(crate) async fn complete_atomic(
     purchase_id: &str,
     payment_intent: &str,
 ) -> Result<i64, WaferError> {
-    let now = chrono::Utc::now().to_rfc3339();
+    let now = crate::util::now_rfc3339();
     let mut data: HashMap<String, serde_json::Value> = HashMap::new();
     data.insert("status".into(), serde_json::json!("completed"));
     data.insert(
@@ -125,7 +125,7 @@ pub(crate) async fn claim_for_checkout(
     data.insert("status".into(), serde_json::json!("checkout_started"));
     data.insert(
         "updated_at".into(),
-        serde_json::json!(chrono::Utc::now().to_rfc3339()),
+        serde_json::json!(crate::util::now_rfc3339()),
     );
     db::update_by_filters_count(
         ctx,
@@ -157,7 +157,7 @@ pub(crate) async fn revert_checkout_claim(
     data.insert("status".into(), serde_json::json!("pending"));
     data.insert(
         "updated_at".into(),
-        serde_json::json!(chrono::Utc::now().to_rfc3339()),
+        serde_json::json!(crate::util::now_rfc3339()),
     );
     db::update_by_filters_count(
         ctx,
@@ -187,7 +187,7 @@ pub(crate) async fn refund_atomic(
     refunded_by: &str,
     reason: &str,
 ) -> Result<i64, WaferError> {
-    let now = chrono::Utc::now().to_rfc3339();
+    let now = crate::util::now_rfc3339();
     let mut data: HashMap<String, serde_json::Value> = HashMap::new();
     data.insert("status".into(), serde_json::json!("refunded"));
     data.insert("refunded_at".into(), serde_json::json!(&now));
@@ -214,6 +214,31 @@ pub(crate) async fn refund_atomic(
     .await
 }
 
+/// Record how much of a refunded purchase went back, and how much of that
+/// was tax. Set after the status transition, by the admin refund and by
+/// each `charge.refunded` (whose amount is the running total).
+pub(crate) async fn record_refund_amounts(
+    ctx: &dyn Context,
+    id: &str,
+    refunded_cents: i64,
+    refunded_tax_cents: i64,
+) -> Result<Record, WaferError> {
+    let mut data = HashMap::new();
+    data.insert(
+        "refunded_cents".to_string(),
+        serde_json::json!(refunded_cents),
+    );
+    data.insert(
+        "refunded_tax_cents".to_string(),
+        serde_json::json!(refunded_tax_cents),
+    );
+    data.insert(
+        "updated_at".to_string(),
+        serde_json::json!(crate::util::now_rfc3339()),
+    );
+    db::update(ctx, PURCHASES_TABLE, id, data).await
+}
+
 /// Find a purchase by its provider payment-intent id (`charge.refunded`).
 pub(crate) async fn find_by_payment_intent(
     ctx: &dyn Context,
@@ -231,7 +256,7 @@ pub(crate) async fn find_by_payment_intent(
 /// Mark a purchase refunded by id (webhook `charge.refunded` path — sets
 /// status/refunded_at/updated_at, mirrors the original `db::update`).
 pub(crate) async fn mark_refunded(ctx: &dyn Context, id: &str) -> Result<Record, WaferError> {
-    let now = chrono::Utc::now().to_rfc3339();
+    let now = crate::util::now_rfc3339();
     let mut data = HashMap::new();
     data.insert(
         "status".to_string(),
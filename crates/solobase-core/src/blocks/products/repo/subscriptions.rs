@@ -23,7 +23,7 @@ pub(crate) async fn upsert_platform(
     stripe_subscription_id: &str,
     plan: &str,
 ) -> Result<i64, WaferError> {
-    let now = chrono::Utc::now().to_rfc3339();
+    let now = crate::util::now_rfc3339();
     let sub_id = format!("sub_{user_id}");
     db::upsert(
         ctx,
@@ -64,7 +64,7 @@ pub(crate) async fn update_status_plan(
     status: &str,
     plan: Option<&str>,
 ) -> Result<i64, WaferError> {
-    let now = chrono::Utc::now().to_rfc3339();
+    let now = crate::util::now_rfc3339();
     let mut data: HashMap<String, serde_json::Value> = HashMap::new();
     data.insert("status".into(), serde_json::json!(status));
     data.insert("updated_at".into(), serde_json::json!(&now));
@@ -90,9 +90,9 @@ pub(crate) async fn mark_past_due(
     ctx: &dyn Context,
     stripe_subscription_id: &str,
 ) -> Result<i64, WaferError> {
-    let now = chrono::Utc::now();
-    let grace_end = (now + chrono::Duration::days(7)).to_rfc3339();
-    let now = now.to_rfc3339();
+    let now = crate::clock::now();
+    let grace_end = crate::util::format_rfc3339(now + chrono::Duration::days(7));
+    let now = crate::util::format_rfc3339(now);
     let mut data: HashMap<String, serde_json::Value> = HashMap::new();
     data.insert("status".into(), serde_json::json!("past_due"));
     data.insert("grace_period_end".into(), serde_json::json!(&grace_end));
@@ -116,7 +116,7 @@ pub(crate) async fn cancel_and_reset_addons(
     ctx: &dyn Context,
     stripe_subscription_id: &str,
 ) -> Result<i64, WaferError> {
-    let now = chrono::Utc::now().to_rfc3339();
+    let now = crate::util::now_rfc3339();
     let mut data: HashMap<String, serde_json::Value> = HashMap::new();
     data.insert("status".into(), serde_json::json!("cancelled"));
     data.insert("addon_projects".into(), serde_json::json!(0));
@@ -148,7 +148,7 @@ pub(crate) async fn set_addon_totals(
     r2_bytes: i64,
     d1_bytes: i64,
 ) -> Result<i64, WaferError> {
-    let now = chrono::Utc::now().to_rfc3339();
+    let now = crate::util::now_rfc3339();
     let mut data: HashMap<String, serde_json::Value> = HashMap::new();
     data.insert("addon_projects".into(), serde_json::json!(projects));
     data.insert("addon_requests".into(), serde_json::json!(requests));
@@ -0,0 +1,85 @@
+//! Data access for per-region tax rates. Policy (region matching, the tax
+//! maths, pluggable calculators) lives in `products::tax`.
+
+use std::collections::HashMap;
+
+use wafer_block::db::{Filter, FilterOp, SortField};
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, WaferError};
+
+/// Admin-configured tax rates, one per region.
+pub(crate) const TAX_RATES_TABLE: &str = "suppers_ai__products__tax_rates";
+
+fn eq(field: &str, value: serde_json::Value) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value,
+    }
+}
+
+/// Fetch a tax rate by id.
+pub(crate) async fn get(ctx: &dyn Context, id: &str) -> Result<Record, WaferError> {
+    db::get(ctx, TAX_RATES_TABLE, id).await
+}
+
+/// Fetch the rate for a normalized (upper-cased) region.
+pub(crate) async fn get_by_region(ctx: &dyn Context, region: &str) -> Result<Record, WaferError> {
+    db::get_by_field(ctx, TAX_RATES_TABLE, "region", serde_json::json!(region)).await
+}
+
+/// The active rates for any of `regions`.
+pub(crate) async fn active_for_regions(
+    ctx: &dyn Context,
+    regions: &[&str],
+) -> Result<Vec<Record>, WaferError> {
+    db::list_all(
+        ctx,
+        TAX_RATES_TABLE,
+        vec![
+            Filter {
+                field: "region".to_string(),
+                operator: FilterOp::In,
+                value: serde_json::json!(regions),
+            },
+            eq("active", serde_json::json!(1)),
+        ],
+    )
+    .await
+}
+
+/// Paginated rate list, alphabetical by region.
+pub(crate) async fn list_paginated(
+    ctx: &dyn Context,
+    filters: Vec<Filter>,
+    page: i64,
+    page_size: i64,
+) -> Result<RecordList, WaferError> {
+    let sort = vec![SortField {
+        field: "region".to_string(),
+        desc: false,
+    }];
+    db::paginated_list(ctx, TAX_RATES_TABLE, page, page_size, filters, sort).await
+}
+
+/// Insert a tax rate. Caller supplies the full field map.
+pub(crate) async fn create(
+    ctx: &dyn Context,
+    data: HashMap<String, serde_json::Value>,
+) -> Result<Record, WaferError> {
+    db::create(ctx, TAX_RATES_TABLE, data).await
+}
+
+/// Apply a field update to a tax rate by id.
+pub(crate) async fn update(
+    ctx: &dyn Context,
+    id: &str,
+    data: HashMap<String, serde_json::Value>,
+) -> Result<Record, WaferError> {
+    db::update(ctx, TAX_RATES_TABLE, id, data).await
+}
+
+/// Delete a tax rate. Purchases keep the breakdown they were charged.
+pub(crate) async fn delete(ctx: &dyn Context, id: &str) -> Result<(), WaferError> {
+    db::delete(ctx, TAX_RATES_TABLE, id).await
+}
@@ -2,7 +2,7 @@ use std::collections::HashMap;
 
 use wafer_block_crypto::primitives;
 use wafer_core::clients::{config, database as db, network};
-use wafer_run::{context::Context, InputStream, Message, OutputStream};
+use wafer_run::{context::Context, ErrorCode, InputStream, Message, OutputStream};
 
 use super::{repo, PRODUCTS_TABLE};
 use crate::{
@@ -10,7 +10,7 @@ use crate::{
         err_bad_request, err_forbidden, err_internal, err_internal_no_cause, err_not_found,
         err_unauthorized, ok_json,
     },
-    util::hex_encode,
+    util::{hex_encode, RecordExt},
 };
 
 pub async fn handle_checkout(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
@@ -83,6 +83,12 @@ pub async fn handle_checkout(ctx: &dyn Context, msg: &Message, input: InputStrea
         }
     }
 
+    // Re-check and refresh the stock hold taken at purchase creation — it
+    // may have lapsed while the purchase sat pending.
+    if let Err(resp) = super::inventory::reserve_for_purchase(ctx, &body.purchase_id).await {
+        return resp;
+    }
+
     // Atomic status transition: pending -> checkout_started (prevents double-checkout race)
     let rows = match repo::purchases::claim_for_checkout(ctx, &body.purchase_id).await {
         Ok(n) => n,
@@ -215,7 +221,7 @@ pub async fn handle_checkout(ctx: &dyn Context, msg: &Message, input: InputStrea
     );
     upd.insert(
         "updated_at".to_string(),
-        serde_json::Value::String(chrono::Utc::now().to_rfc3339()),
+        serde_json::Value::String(crate::util::now_rfc3339()),
     );
     if let Err(e) = repo::purchases::update(ctx, &body.purchase_id, upd).await {
         tracing::warn!("Failed to update purchase with Stripe session ID: {e}");
@@ -284,11 +290,27 @@ pub async fn handle_webhook(ctx: &dyn Context, msg: &Message, input: InputStream
                     // "purchase complete" transition.
                     Err(e) => return err_internal("Failed to complete purchase", e),
                 };
-                if rows == 0 {
+                let completed = if rows == 0 {
                     tracing::warn!(
                         "Purchase {} not updated — already completed or refunded",
                         purchase_id
                     );
+                    match repo::purchases::get(ctx, purchase_id).await {
+                        Ok(p) => p.str_field("status") == "completed",
+                        Err(e) if e.code == ErrorCode::NotFound => false,
+                        Err(e) => return err_internal("Failed to read purchase", e),
+                    }
+                } else {
+                    true
+                };
+                // Stock is committed whenever the purchase is completed, not
+                // only by the delivery that completed it: a failed commit
+                // answers 500, and Stripe's retry finishes it (the commit is
+                // idempotent per product).
+                if completed {
+                    if let Err(e) = super::inventory::commit_purchase(ctx, purchase_id).await {
+                        return err_internal("Failed to commit stock for purchase", e);
+                    }
                 }
             }
 
@@ -334,6 +356,27 @@ pub async fn handle_webhook(ctx: &dyn Context, msg: &Message, input: InputStream
             }
         }
 
+        "checkout.session.expired" => {
+            // Abandoned checkout — hand its held stock back immediately
+            // instead of waiting out the reservation TTL.
+            let purchase_id = data_object
+                .pointer("/metadata/purchase_id")
+                .and_then(|v| v.as_str())
+                .unwrap_or("");
+            if !purchase_id.is_empty() {
+                super::inventory::release_purchase(ctx, purchase_id).await;
+                // The purchase can't be checked out again (only `pending`
+                // is claimable), so its coupon use goes back too.
+                if let Err(e) = super::coupons::release_purchase(ctx, purchase_id).await {
+                    tracing::warn!(
+                        error = %e,
+                        purchase_id = %purchase_id,
+                        "failed to release coupon redemption"
+                    );
+                }
+            }
+        }
+
         "customer.subscription.updated" => {
             let stripe_sub_id = data_object.get("id").and_then(|v| v.as_str()).unwrap_or("");
             let status = data_object
@@ -439,6 +482,36 @@ pub async fn handle_webhook(ctx: &dyn Context, msg: &Message, input: InputStream
                         tracing::error!("Failed to mark purchase as refunded: {e}");
                         return err_internal("Failed to update purchase", e);
                     }
+                    if let Err(e) =
+                        super::inventory::restore_purchase(ctx, &purchase.id, "stripe").await
+                    {
+                        tracing::error!(error = %e, "failed to restore stock for refund");
+                        return err_internal("Failed to restore stock", e);
+                    }
+                    super::coupons::release_on_refund(ctx, &purchase.id).await;
+
+                    // `amount_refunded` is the charge's running total, so a
+                    // later partial refund overwrites an earlier one.
+                    let total_cents = purchase.i64_field("total_cents");
+                    let refunded_cents = data_object
+                        .get("amount_refunded")
+                        .and_then(|v| v.as_i64())
+                        .unwrap_or(total_cents);
+                    let refunded_tax_cents = super::tax::refund_tax_cents(
+                        purchase.i64_field("tax_cents"),
+                        total_cents,
+                        refunded_cents,
+                    );
+                    if let Err(e) = repo::purchases::record_refund_amounts(
+                        ctx,
+                        &purchase.id,
+                        refunded_cents,
+                        refunded_tax_cents,
+                    )
+                    .await
+                    {
+                        tracing::error!(error = %e, "failed to record refund amounts");
+                    }
                 }
             }
         }
@@ -463,7 +536,7 @@ async fn fire_products_webhook(ctx: &dyn Context, event: &str, data: &serde_json
 
     let body = serde_json::json!({
         "event": event,
-        "timestamp": chrono::Utc::now().to_rfc3339(),
+        "timestamp": crate::util::now_rfc3339(),
         "data": data
     });
     // Silent `unwrap_or_default` would sign and send an empty body on
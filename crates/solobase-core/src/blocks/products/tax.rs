@@ -0,0 +1,651 @@
+//! Sales tax.
+//!
+//! A purchase is taxed when it is created, against the buyer's
+//! `billing_country` from the request: an ISO 3166 country code (`DE`) or
+//! country subdivision (`US-CA`). A [`TaxCalculator`] turns the cart lines —
+//! each already net of its coupon discount — into a breakdown of
+//! [`TaxItem`]s, which is stored on the purchase as `tax_items` with the sum
+//! as `tax_cents`, and each line's share on its line item.
+//!
+//! By default the breakdown comes from the admin-managed rate table: the
+//! buyer's subdivision rate when there is one, else the country rate, else
+//! no tax. An embedder with an external tax service registers its own
+//! [`TaxCalculator`] instead — `SolobaseBuilder::tax_calculator` — which
+//! runs behind its own block, [`TaxCalculatorBlock`]
+//! (`suppers-ai/tax-calculator`), and is reached through `ctx.call_block`.
+//! It gets the same lines and returns the same breakdown. A failed
+//! calculation refuses the purchase rather than selling it untaxed.
+//!
+//! An inclusive rate is already inside the price, so it is carved out of
+//! the line and leaves the total alone; an exclusive rate is added on top.
+//! Every amount is rounded to the cent per line with banker's rounding
+//! (half to even), so a run of half-cent results doesn't drift upward. A
+//! refund takes the same share of the tax as it does of the total.
+
+use std::sync::Arc;
+
+use wafer_block::{
+    db::{Filter, FilterOp},
+    MaybeSend, MaybeSync,
+};
+use wafer_core::clients::database::Record;
+use wafer_run::{
+    context::Context, Block, BlockInfo, ErrorCode, InputStream, InstanceMode, Message,
+    OutputStream, WaferError,
+};
+
+use super::{purchase::PricedLine, repo};
+use crate::{
+    blocks::errors,
+    http::{
+        err_bad_request, err_conflict, err_internal, err_internal_no_cause, err_not_found, ok_json,
+    },
+    util::RecordExt,
+};
+
+/// Parts per million in a whole: a `rate_ppm` of 1 000 000 is 100%.
+pub const PPM: i64 = 1_000_000;
+
+/// `a / b` rounded to the nearest integer, ties to even. `b` must be
+/// positive.
+pub fn div_round_half_even(a: i128, b: i128) -> i64 {
+    debug_assert!(b > 0);
+    let (quotient, remainder) = (a.div_euclid(b), a.rem_euclid(b));
+    let rounded = match (remainder * 2).cmp(&b) {
+        std::cmp::Ordering::Less => quotient,
+        std::cmp::Ordering::Greater => quotient + 1,
+        std::cmp::Ordering::Equal => quotient + (quotient & 1),
+    };
+    rounded as i64
+}
+
+/// The tax in `amount_cents` at `rate_ppm`: on top of it when `inclusive`
+/// is false, inside it when true.
+pub fn tax_on(amount_cents: i64, rate_ppm: i64, inclusive: bool) -> i64 {
+    let (amount, rate) = (i128::from(amount_cents), i128::from(rate_ppm));
+    if inclusive {
+        div_round_half_even(amount * rate, i128::from(PPM) + rate)
+    } else {
+        div_round_half_even(amount * rate, i128::from(PPM))
+    }
+}
+
+/// The tax inside `refunded_cents` of a purchase that charged `tax_cents`
+/// out of `total_cents`: the same share, capped at the tax charged.
+pub fn refund_tax_cents(tax_cents: i64, total_cents: i64, refunded_cents: i64) -> i64 {
+    if total_cents <= 0 || tax_cents <= 0 || refunded_cents <= 0 {
+        return 0;
+    }
+    let refunded = refunded_cents.min(total_cents);
+    div_round_half_even(
+        i128::from(tax_cents) * i128::from(refunded),
+        i128::from(total_cents),
+    )
+    .min(tax_cents)
+}
+
+/// Canonical form of a region code: trimmed and upper-cased. `None` unless
+/// it is a two-letter country optionally followed by `-` and a one-to-three
+/// character subdivision.
+pub(crate) fn normalize_region(region: &str) -> Option<String> {
+    let region = region.trim().to_uppercase();
+    let (country, subdivision) = match region.split_once('-') {
+        Some((c, s)) => (c, Some(s)),
+        None => (region.as_str(), None),
+    };
+    let country_ok = country.len() == 2 && country.bytes().all(|b| b.is_ascii_uppercase());
+    let subdivision_ok = subdivision.map_or(true, |s| {
+        (1..=3).contains(&s.len()) && s.bytes().all(|b| b.is_ascii_alphanumeric())
+    });
+    (country_ok && subdivision_ok).then_some(region)
+}
+
+// ---------------------------------------------------------------------------
+// Calculators
+// ---------------------------------------------------------------------------
+
+/// Name an external tax calculator's block is registered under.
+pub const TAX_CALCULATOR_BLOCK: &str = "suppers-ai/tax-calculator";
+
+/// Message kind the calculator block answers: the body is a JSON
+/// [`TaxRequest`], the response a JSON array of [`TaxItem`]s.
+pub const TAX_KIND: &str = "tax.calculate";
+
+/// One cart line to tax.
+#[derive(Debug, Clone, PartialEq, serde::Serialize, serde::Deserialize)]
+pub struct TaxLine {
+    pub product_id: String,
+    pub product_template_id: String,
+    pub quantity: i64,
+    /// The line total after its coupon discount.
+    pub amount_cents: i64,
+}
+
+/// What a calculator is asked to tax.
+#[derive(Debug, Clone, PartialEq, serde::Serialize, serde::Deserialize)]
+pub struct TaxRequest {
+    /// Normalized region code; empty when the buyer gave none.
+    pub billing_country: String,
+    pub currency: String,
+    pub lines: Vec<TaxLine>,
+}
+
+/// One tax charged on one line.
+#[derive(Debug, Clone, PartialEq, serde::Serialize, serde::Deserialize)]
+pub struct TaxItem {
+    /// Index into [`TaxRequest::lines`].
+    pub line: usize,
+    pub product_id: String,
+    /// The region whose rate applied.
+    pub region: String,
+    pub label: String,
+    pub rate_ppm: i64,
+    pub inclusive: bool,
+    pub taxable_cents: i64,
+    pub tax_cents: i64,
+}
+
+/// An external tax calculator (a tax service's API, say). The error is a
+/// message for the log; the purchase is refused.
+#[cfg_attr(target_arch = "wasm32", async_trait::async_trait(?Send))]
+#[cfg_attr(not(target_arch = "wasm32"), async_trait::async_trait)]
+pub trait TaxCalculator: MaybeSend + MaybeSync {
+    /// The breakdown for `request`; an empty list is no tax.
+    async fn calculate(&self, request: &TaxRequest) -> Result<Vec<TaxItem>, String>;
+}
+
+/// Serves a [`TaxCalculator`] as the `suppers-ai/tax-calculator` block.
+pub struct TaxCalculatorBlock {
+    calculator: Arc<dyn TaxCalculator>,
+}
+
+impl TaxCalculatorBlock {
+    pub fn new(calculator: Arc<dyn TaxCalculator>) -> Self {
+        Self { calculator }
+    }
+}
+
+#[wafer_block::wafer_async_trait]
+impl Block for TaxCalculatorBlock {
+    fn info(&self) -> BlockInfo {
+        BlockInfo::new(
+            TAX_CALCULATOR_BLOCK,
+            "0.0.1",
+            "tax-calculator@v1",
+            "Sales tax for product purchases",
+        )
+        .instance_mode(InstanceMode::Singleton)
+        .category(wafer_run::BlockCategory::Service)
+    }
+
+    async fn handle(&self, _ctx: &dyn Context, msg: Message, input: InputStream) -> OutputStream {
+        if msg.kind != TAX_KIND {
+            return err_not_found("not found");
+        }
+        let raw = input.collect_to_bytes().await;
+        let request: TaxRequest = match serde_json::from_slice(&raw) {
+            Ok(r) => r,
+            Err(e) => return err_bad_request(&format!("Invalid tax request: {e}")),
+        };
+        match self.calculator.calculate(&request).await {
+            Ok(items) => ok_json(&items),
+            Err(e) => err_internal_no_cause(&format!("Tax calculation failed: {e}")),
+        }
+    }
+}
+
+/// The breakdown from the rate table: the buyer's subdivision rate, else
+/// their country's, else none.
+async fn table_tax(ctx: &dyn Context, request: &TaxRequest) -> Result<Vec<TaxItem>, WaferError> {
+    let region = request.billing_country.as_str();
+    if region.is_empty() {
+        return Ok(Vec::new());
+    }
+    let country = region.split('-').next().unwrap_or(region);
+    let rates = repo::tax::active_for_regions(ctx, &[region, country]).await?;
+    let rate = rates
+        .iter()
+        .find(|r| r.str_field("region") == region)
+        .or_else(|| rates.iter().find(|r| r.str_field("region") == country))
+        .map(TaxRate::from_record);
+    let Some(rate) = rate.filter(|r| r.rate_ppm > 0) else {
+        return Ok(Vec::new());
+    };
+    Ok(request
+        .lines
+        .iter()
+        .enumerate()
+        .filter(|(_, line)| line.amount_cents > 0)
+        .map(|(i, line)| TaxItem {
+            line: i,
+            product_id: line.product_id.clone(),
+            region: rate.region.clone(),
+            label: rate.label.clone(),
+            rate_ppm: rate.rate_ppm,
+            inclusive: rate.inclusive,
+            taxable_cents: line.amount_cents,
+            tax_cents: tax_on(line.amount_cents, rate.rate_ppm, rate.inclusive),
+        })
+        .collect())
+}
+
+/// The breakdown from the registered calculator block, or from the rate
+/// table when there is none.
+async fn calculate(ctx: &dyn Context, request: &TaxRequest) -> Result<Vec<TaxItem>, String> {
+    let external = ctx
+        .registered_blocks()
+        .iter()
+        .any(|b| b.name == TAX_CALCULATOR_BLOCK);
+    if !external {
+        return table_tax(ctx, request).await.map_err(|e| e.message);
+    }
+    let body = serde_json::to_vec(request).map_err(|e| e.to_string())?;
+    let out = ctx
+        .call_block(
+            TAX_CALCULATOR_BLOCK,
+            Message::new(TAX_KIND),
+            InputStream::from_bytes(body),
+        )
+        .await;
+    let buf = out
+        .collect_buffered()
+        .await
+        .map_err(|e| format!("calculator call failed: {e:?}"))?;
+    serde_json::from_slice(&buf.body).map_err(|e| format!("invalid calculator response: {e}"))
+}
+
+/// A cart's tax, as stored on the purchase.
+#[derive(Debug, Default)]
+pub(crate) struct Breakdown {
+    pub items: Vec<TaxItem>,
+    /// Every line's tax, index-aligned with the cart.
+    pub per_line: Vec<i64>,
+    /// All tax, inclusive and exclusive.
+    pub tax_cents: i64,
+    /// The exclusive part, which is added to the total.
+    pub added_cents: i64,
+}
+
+/// Tax `lines` (with their coupon discounts, index-aligned). The error is
+/// the response to send: a failing calculator or a malformed breakdown
+/// refuses the purchase.
+pub(super) async fn compute(
+    ctx: &dyn Context,
+    billing_country: &str,
+    currency: &str,
+    lines: &[PricedLine],
+    discounts: &[i64],
+) -> Result<Breakdown, OutputStream> {
+    let request = TaxRequest {
+        billing_country: billing_country.to_string(),
+        currency: currency.to_string(),
+        lines: lines
+            .iter()
+            .enumerate()
+            .map(|(i, l)| TaxLine {
+                product_id: l.product_id.clone(),
+                product_template_id: l.product_template_id.clone(),
+                quantity: l.quantity,
+                amount_cents: l.total_cents() - discounts.get(i).copied().unwrap_or(0),
+            })
+            .collect(),
+    };
+    let items = match calculate(ctx, &request).await {
+        Ok(items) => items,
+        Err(e) => {
+            tracing::error!(error = %e, "tax calculation failed");
+            return Err(err_internal_no_cause("Tax calculation failed"));
+        }
+    };
+
+    let mut breakdown = Breakdown {
+        per_line: vec![0; lines.len()],
+        ..Default::default()
+    };
+    for item in &items {
+        if item.line >= lines.len() || item.tax_cents < 0 {
+            tracing::error!(?item, "tax calculator returned an invalid item");
+            return Err(err_internal_no_cause("Tax calculation failed"));
+        }
+        breakdown.per_line[item.line] += item.tax_cents;
+        breakdown.tax_cents += item.tax_cents;
+        if !item.inclusive {
+            breakdown.added_cents += item.tax_cents;
+        }
+    }
+    breakdown.items = items;
+    Ok(breakdown)
+}
+
+/// A purchase's stored breakdown as JSON. Stored as text; tolerate a
+/// backend that hands back the array.
+pub(crate) fn items_of(purchase: &Record) -> serde_json::Value {
+    match purchase.data.get("tax_items") {
+        Some(serde_json::Value::Array(items)) => serde_json::Value::Array(items.clone()),
+        Some(serde_json::Value::String(s)) => {
+            serde_json::from_str(s).unwrap_or_else(|_| serde_json::json!([]))
+        }
+        _ => serde_json::json!([]),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Rate table
+// ---------------------------------------------------------------------------
+
+/// A tax rate row.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub(crate) struct TaxRate {
+    pub region: String,
+    pub rate_ppm: i64,
+    pub label: String,
+    pub inclusive: bool,
+    pub active: bool,
+}
+
+impl TaxRate {
+    pub(crate) fn from_record(record: &Record) -> Self {
+        Self {
+            region: record.str_field("region").to_string(),
+            rate_ppm: record.i64_field("rate_ppm"),
+            label: record.str_field("label").to_string(),
+            inclusive: record.bool_field("inclusive"),
+            active: record.bool_field("active"),
+        }
+    }
+
+    /// The admin-editable columns, for create and update writes.
+    fn fields(&self) -> std::collections::HashMap<String, serde_json::Value> {
+        crate::util::json_map(serde_json::json!({
+            "region": self.region,
+            "rate_ppm": self.rate_ppm,
+            "label": self.label,
+            "inclusive": i64::from(self.inclusive),
+            "active": i64::from(self.active),
+        }))
+    }
+
+    /// Field-level problems with an admin-supplied rate, for
+    /// `validation_failed` details.
+    pub(crate) fn problems(&self) -> Vec<(&'static str, &'static str)> {
+        let mut problems = Vec::new();
+        if self.region.is_empty() {
+            problems.push(("region", "is required"));
+        } else if normalize_region(&self.region).as_deref() != Some(self.region.as_str()) {
+            problems.push((
+                "region",
+                "must be a country code (DE) or country subdivision (US-CA)",
+            ));
+        }
+        if !(0..=PPM).contains(&self.rate_ppm) {
+            problems.push(("rate", "must be a percentage from 0 to 100"));
+        }
+        if self.label.trim().is_empty() {
+            problems.push(("label", "is required"));
+        }
+        problems
+    }
+
+    /// The record as the admin API shows it, with `rate_ppm` also given
+    /// as `rate`, a percentage.
+    fn to_json(record: &Record) -> serde_json::Value {
+        let mut json = serde_json::json!(record);
+        json["data"]["rate"] = serde_json::json!(record.i64_field("rate_ppm") as f64 / 10_000.0);
+        json
+    }
+}
+
+/// Admin create/update body. Every field is optional so PATCH can send a
+/// subset; on create, a missing `region`, `rate`, or `label` fails
+/// validation. `rate` is a percentage, kept to four decimal places.
+#[derive(serde::Deserialize, Default)]
+struct TaxRateInput {
+    region: Option<String>,
+    rate: Option<f64>,
+    label: Option<String>,
+    inclusive: Option<bool>,
+    active: Option<bool>,
+}
+
+impl TaxRateInput {
+    fn apply(self, rate: &mut TaxRate) {
+        if let Some(region) = self.region {
+            rate.region = region.trim().to_uppercase();
+        }
+        if let Some(percent) = self.rate {
+            // Non-finite or out-of-range input lands outside 0..=PPM, which
+            // `problems` reports.
+            rate.rate_ppm = if percent.is_finite() {
+                (percent * 10_000.0).round().clamp(-1.0, (PPM + 1) as f64) as i64
+            } else {
+                -1
+            };
+        }
+        if let Some(label) = self.label {
+            rate.label = label.trim().to_string();
+        }
+        if let Some(inclusive) = self.inclusive {
+            rate.inclusive = inclusive;
+        }
+        if let Some(active) = self.active {
+            rate.active = active;
+        }
+    }
+}
+
+/// Path prefix preceding a tax rate id in admin requests.
+const PATH_PREFIX: &str = "/admin/b/products/tax-rates/";
+
+fn rate_id(msg: &Message) -> String {
+    crate::util::path_param(msg, "id", PATH_PREFIX).to_string()
+}
+
+async fn read_input(input: InputStream) -> Result<TaxRateInput, OutputStream> {
+    let raw = input.collect_to_bytes().await;
+    serde_json::from_slice(&raw).map_err(|e| err_bad_request(&format!("Invalid body: {e}")))
+}
+
+/// Refuse a region already configured by a rate other than `except_id`. The
+/// unique index still backstops a concurrent create.
+async fn ensure_region_free(
+    ctx: &dyn Context,
+    region: &str,
+    except_id: &str,
+) -> Result<(), OutputStream> {
+    match repo::tax::get_by_region(ctx, region).await {
+        Ok(existing) if existing.id != except_id => Err(err_conflict(&format!(
+            "A tax rate for {region} already exists"
+        ))),
+        Ok(_) => Ok(()),
+        Err(e) if e.code == ErrorCode::NotFound => Ok(()),
+        Err(e) => Err(err_internal("Database error", e)),
+    }
+}
+
+/// `GET /admin/b/products/tax-rates` — paginated, alphabetical by region;
+/// `?active=true|false` filters.
+pub async fn handle_list(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let (page, page_size, _) = msg.pagination_params(50);
+    let mut filters = Vec::new();
+    let active = msg.query("active");
+    if active == "true" || active == "false" {
+        filters.push(Filter {
+            field: "active".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::json!(i64::from(active == "true")),
+        });
+    }
+    match repo::tax::list_paginated(ctx, filters, page as i64, page_size as i64).await {
+        Ok(result) => {
+            let records: Vec<serde_json::Value> =
+                result.records.iter().map(TaxRate::to_json).collect();
+            ok_json(&serde_json::json!({
+                "records": records,
+                "total_count": result.total_count,
+                "page": result.page,
+                "page_size": result.page_size,
+            }))
+        }
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `GET /admin/b/products/tax-rates/{id}`.
+pub async fn handle_get(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let id = rate_id(msg);
+    if id.is_empty() {
+        return err_bad_request("Missing tax rate ID");
+    }
+    match repo::tax::get(ctx, &id).await {
+        Ok(record) => ok_json(&TaxRate::to_json(&record)),
+        Err(e) if e.code == ErrorCode::NotFound => err_not_found("Tax rate not found"),
+        Err(e) => err_internal("Database error", e),
+    }
+}
+
+/// `POST /admin/b/products/tax-rates`.
+pub async fn handle_create(ctx: &dyn Context, input: InputStream) -> OutputStream {
+    let body = match read_input(input).await {
+        Ok(b) => b,
+        Err(resp) => return resp,
+    };
+    let mut rate = TaxRate {
+        active: true,
+        rate_ppm: -1,
+        ..Default::default()
+    };
+    body.apply(&mut rate);
+    let problems = rate.problems();
+    if !problems.is_empty() {
+        return errors::validation_error("Invalid tax rate", &problems);
+    }
+    if let Err(resp) = ensure_region_free(ctx, &rate.region, "").await {
+        return resp;
+    }
+
+    let mut data = rate.fields();
+    crate::util::stamp_created(&mut data);
+    match repo::tax::create(ctx, data).await {
+        Ok(record) => ok_json(&TaxRate::to_json(&record)),
+        Err(e) => err_internal("Failed to create tax rate", e),
+    }
+}
+
+/// `PATCH /admin/b/products/tax-rates/{id}` — the merged rate is validated
+/// as a whole. Purchases already taxed keep their breakdown.
+pub async fn handle_update(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let id = rate_id(msg);
+    if id.is_empty() {
+        return err_bad_request("Missing tax rate ID");
+    }
+    let body = match read_input(input).await {
+        Ok(b) => b,
+        Err(resp) => return resp,
+    };
+    let mut rate = match repo::tax::get(ctx, &id).await {
+        Ok(record) => TaxRate::from_record(&record),
+        Err(e) if e.code == ErrorCode::NotFound => return err_not_found("Tax rate not found"),
+        Err(e) => return err_internal("Database error", e),
+    };
+    body.apply(&mut rate);
+    let problems = rate.problems();
+    if !problems.is_empty() {
+        return errors::validation_error("Invalid tax rate", &problems);
+    }
+    if let Err(resp) = ensure_region_free(ctx, &rate.region, &id).await {
+        return resp;
+    }
+
+    let mut data = rate.fields();
+    crate::util::stamp_updated(&mut data);
+    match repo::tax::update(ctx, &id, data).await {
+        Ok(record) => ok_json(&TaxRate::to_json(&record)),
+        Err(e) => err_internal("Failed to update tax rate", e),
+    }
+}
+
+/// `DELETE /admin/b/products/tax-rates/{id}`. The region is untaxed from
+/// then on.
+pub async fn handle_delete(ctx: &dyn Context, msg: &Message) -> OutputStream {
+    let id = rate_id(msg);
+    if id.is_empty() {
+        return err_bad_request("Missing tax rate ID");
+    }
+    if let Err(e) = repo::tax::get(ctx, &id).await {
+        if e.code == ErrorCode::NotFound {
+            return err_not_found("Tax rate not found");
+        }
+        return err_internal("Database error", e);
+    }
+    match repo::tax::delete(ctx, &id).await {
+        Ok(()) => ok_json(&serde_json::json!({"deleted": true})),
+        Err(e) => err_internal("Failed to delete tax rate", e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn rounds_half_to_even() {
+        assert_eq!(div_round_half_even(5, 2), 2); // 2.5
+        assert_eq!(div_round_half_even(7, 2), 4); // 3.5
+        assert_eq!(div_round_half_even(9, 4), 2); // 2.25
+        assert_eq!(div_round_half_even(11, 4), 3); // 2.75
+        assert_eq!(div_round_half_even(-5, 2), -2); // -2.5
+        assert_eq!(div_round_half_even(0, 7), 0);
+    }
+
+    #[test]
+    fn exclusive_and_inclusive_tax_round_to_the_cent() {
+        // 10% of 125 = 12.5 -> 12; of 135 = 13.5 -> 14.
+        assert_eq!(tax_on(125, 100_000, false), 12);
+        assert_eq!(tax_on(135, 100_000, false), 14);
+        // 8.875% of 1999 = 177.41 -> 177.
+        assert_eq!(tax_on(1999, 88_750, false), 177);
+        // 20% inside 1200 is 200 (1200 = 1000 + 200).
+        assert_eq!(tax_on(1200, 200_000, true), 200);
+        // 19% inside 1000 = 159.66 -> 160.
+        assert_eq!(tax_on(1000, 190_000, true), 160);
+        assert_eq!(tax_on(1000, 0, false), 0);
+    }
+
+    #[test]
+    fn refunds_take_a_proportional_share_of_the_tax() {
+        assert_eq!(refund_tax_cents(200, 1200, 1200), 200);
+        assert_eq!(refund_tax_cents(200, 1200, 600), 100);
+        // 200 * 300 / 1200 = 50; 199 * 300 / 1200 = 49.75 -> 50.
+        assert_eq!(refund_tax_cents(199, 1200, 300), 50);
+        // 10 * 1 / 4 = 2.5 -> 2 (half to even).
+        assert_eq!(refund_tax_cents(10, 4, 1), 2);
+        assert_eq!(refund_tax_cents(200, 1200, 5000), 200);
+        assert_eq!(refund_tax_cents(0, 1200, 600), 0);
+    }
+
+    #[test]
+    fn regions_are_normalized_and_checked() {
+        assert_eq!(normalize_region(" de "), Some("DE".to_string()));
+        assert_eq!(normalize_region("us-ca"), Some("US-CA".to_string()));
+        assert_eq!(normalize_region("USA"), None);
+        assert_eq!(normalize_region("US-"), None);
+        assert_eq!(normalize_region("U1"), None);
+        assert_eq!(normalize_region(""), None);
+    }
+
+    #[test]
+    fn problems_flag_bad_admin_input() {
+        let mut rate = TaxRate {
+            region: "GERMANY".to_string(),
+            rate_ppm: PPM + 1,
+            ..Default::default()
+        };
+        let fields: Vec<&str> = rate.problems().iter().map(|(f, _)| *f).collect();
+        assert_eq!(fields, vec!["region", "rate", "label"]);
+
+        rate.region = "DE".to_string();
+        rate.rate_ppm = 190_000;
+        rate.label = "VAT".to_string();
+        assert!(rate.problems().is_empty());
+    }
+}
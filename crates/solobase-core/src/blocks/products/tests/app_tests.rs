@@ -0,0 +1,102 @@
+//! Products through the full request pipeline ([`TestApp`]): real access
+//! tokens, the route table's access tiers and the block's own migrations.
+//! The handler suites cover behavior; these cover the wiring.
+
+use std::sync::Arc;
+
+use crate::{
+    blocks::products::{migrations, ProductsBlock},
+    test_support::{TestApp, TestRequest},
+};
+
+async fn app() -> TestApp {
+    let mut app = TestApp::new().await;
+    app.load_block(
+        "suppers-ai/products",
+        Arc::new(ProductsBlock::new()),
+        migrations::SQLITE_MIGRATIONS,
+        migrations::POSTGRES_MIGRATIONS,
+    )
+    .await
+    .expect("load products block");
+    app
+}
+
+#[tokio::test]
+async fn admin_created_product_appears_in_public_catalog() {
+    let app = app().await;
+    let admin = app.create_user("admin@example.com", "admin").await;
+
+    let res = app
+        .request(
+            TestRequest::post("/b/products/api/admin/products")
+                .json(&serde_json::json!({
+                    "name": "Cloud Hosting",
+                    "base_price": 29.99,
+                    "status": "active",
+                }))
+                .as_user(&admin),
+        )
+        .await;
+    assert_eq!(res.status, 200, "{}", res.text());
+    assert_eq!(res.json()["data"]["created_by"], admin.id.as_str());
+
+    let res = app.request(TestRequest::get("/b/products/catalog")).await;
+    assert_eq!(res.status, 200);
+    let body = res.json();
+    let records = body["records"].as_array().unwrap();
+    assert_eq!(records.len(), 1);
+    assert_eq!(records[0]["data"]["name"], "Cloud Hosting");
+}
+
+#[tokio::test]
+async fn admin_api_requires_the_admin_role() {
+    let app = app().await;
+    let user = app.create_user("user@example.com", "user").await;
+    let create = || {
+        TestRequest::post("/b/products/api/admin/products")
+            .json(&serde_json::json!({ "name": "Nope", "base_price": 1 }))
+    };
+
+    assert_eq!(app.request(create()).await.status, 403);
+    assert_eq!(app.request(create().as_user(&user)).await.status, 403);
+
+    let admin = app.create_user("admin@example.com", "admin").await;
+    let res = app
+        .request(TestRequest::get("/b/products/api/admin/products").as_user(&admin))
+        .await;
+    assert_eq!(res.status, 200);
+    assert!(res.json()["records"].as_array().unwrap().is_empty());
+}
+
+#[tokio::test]
+async fn token_from_another_deployment_is_anonymous() {
+    let app = app().await;
+    let other = TestApp::new().await;
+    let mut admin = other.create_user("admin@example.com", "admin").await;
+    // Same user id and role, but signed under the other app's secret.
+    admin.token = other.token(&admin.id, &admin.email, "admin").await;
+
+    let res = app
+        .request(TestRequest::get("/b/products/api/admin/products").as_user(&admin))
+        .await;
+    assert_eq!(res.status, 403);
+}
+
+#[tokio::test]
+async fn load_block_rejects_migrations_outside_the_namespace() {
+    let mut app = TestApp::new().await;
+    let err = app
+        .load_block(
+            "acme/crm",
+            Arc::new(ProductsBlock::new()),
+            &[(
+                "001_init.sql",
+                "CREATE TABLE suppers_ai__auth__leads (id TEXT)",
+            )],
+            &[],
+        )
+        .await
+        .unwrap_err();
+    assert!(err.contains("suppers_ai__auth__leads"), "{err}");
+}
@@ -0,0 +1,384 @@
+use std::collections::HashMap;
+
+use wafer_core::clients::database as db;
+use wafer_run::{InputStream, Message};
+
+use super::harness::*;
+use crate::{
+    blocks::products::{coupons, purchase, repo, LINE_ITEMS_TABLE, PRODUCTS_TABLE},
+    test_support::{output_status, TestContext},
+    util::RecordExt,
+};
+
+async fn seed_product(ctx: &TestContext, id: &str, price: f64, template: &str) {
+    let mut product = HashMap::new();
+    product.insert("name".to_string(), serde_json::json!("Widget"));
+    product.insert("base_price".to_string(), serde_json::json!(price));
+    product.insert("status".to_string(), serde_json::json!("active"));
+    product.insert(
+        "product_template_id".to_string(),
+        serde_json::json!(template),
+    );
+    seed(ctx, PRODUCTS_TABLE, id, product).await;
+}
+
+/// Create a coupon through the admin API and return its id.
+async fn create_coupon(ctx: &TestContext, body: serde_json::Value) -> String {
+    let (msg, input) = admin_create_msg("/admin/b/products/coupons", body);
+    let created = output_to_json(dispatch_admin(ctx, msg, input).await).await;
+    created["id"]
+        .as_str()
+        .unwrap_or_else(|| panic!("coupon not created: {created}"))
+        .to_string()
+}
+
+async fn redemption_count(ctx: &TestContext, id: &str) -> i64 {
+    repo::coupons::get(ctx, id)
+        .await
+        .unwrap()
+        .i64_field("redemption_count")
+}
+
+fn purchase_msg(user: &str, product_id: &str, qty: i64, code: &str) -> (Message, InputStream) {
+    create_msg(
+        "/b/products/purchases",
+        user,
+        serde_json::json!({
+            "items": [{"product_id": product_id, "quantity": qty}],
+            "coupon_code": code,
+        }),
+    )
+}
+
+// ============================================================
+// Admin CRUD
+// ============================================================
+
+#[tokio::test]
+async fn codes_are_stored_upper_cased_and_unique_case_insensitively() {
+    let ctx = ctx().await;
+    create_coupon(
+        &ctx,
+        serde_json::json!({"code": "summer", "discount_type": "percent", "value": 10}),
+    )
+    .await;
+
+    let (msg, input) = admin_create_msg(
+        "/admin/b/products/coupons",
+        serde_json::json!({"code": "SUMMER", "discount_type": "fixed", "value": 500}),
+    );
+    assert_eq!(
+        output_status(dispatch_admin(&ctx, msg, input).await).await,
+        409
+    );
+
+    let stored = repo::coupons::get_by_code(&ctx, "SUMMER").await.unwrap();
+    assert_eq!(stored.str_field("code"), "SUMMER");
+    assert!(stored.bool_field("active"));
+}
+
+#[tokio::test]
+async fn invalid_coupon_is_rejected_with_field_details() {
+    let ctx = ctx().await;
+    let (msg, input) = admin_create_msg(
+        "/admin/b/products/coupons",
+        serde_json::json!({"code": "X", "discount_type": "percent", "value": 150}),
+    );
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["code"], "validation_failed");
+    assert!(body["details"]["value"].is_string());
+}
+
+#[tokio::test]
+async fn patch_revalidates_the_merged_coupon() {
+    let ctx = ctx().await;
+    let id = create_coupon(
+        &ctx,
+        serde_json::json!({"code": "FLAT", "discount_type": "fixed", "value": 500}),
+    )
+    .await;
+
+    // Switching to percent without a sane value fails as a whole.
+    let (mut msg, input) = update_msg(
+        &format!("/admin/b/products/coupons/{id}"),
+        "admin_1",
+        serde_json::json!({"discount_type": "percent"}),
+    );
+    msg.set_meta("auth.user_roles", "admin");
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["code"], "validation_failed");
+
+    let (mut msg, input) = update_msg(
+        &format!("/admin/b/products/coupons/{id}"),
+        "admin_1",
+        serde_json::json!({"discount_type": "percent", "value": 20, "active": false}),
+    );
+    msg.set_meta("auth.user_roles", "admin");
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["data"]["discount_type"], "percent");
+    assert_eq!(body["data"]["value"], 20);
+}
+
+// ============================================================
+// Validation endpoint
+// ============================================================
+
+#[tokio::test]
+async fn validate_quotes_discount_for_eligible_lines_only() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_book", 20.0, "books").await;
+    seed_product(&ctx, "p_game", 50.0, "games").await;
+    create_coupon(
+        &ctx,
+        serde_json::json!({
+            "code": "BOOKS25",
+            "discount_type": "percent",
+            "value": 25,
+            "product_template_ids": ["books"],
+        }),
+    )
+    .await;
+
+    let (msg, input) = create_msg(
+        "/b/products/coupons/validate",
+        "user_1",
+        serde_json::json!({
+            "code": "books25",
+            "items": [
+                {"product_id": "p_book", "quantity": 2},
+                {"product_id": "p_game", "quantity": 1},
+            ],
+        }),
+    );
+    let body = output_to_json(dispatch_user(&ctx, msg, input).await).await;
+    assert_eq!(body["subtotal_cents"], 9000);
+    assert_eq!(body["eligible_cents"], 4000);
+    assert_eq!(body["discount_cents"], 1000);
+    assert_eq!(body["total_cents"], 8000);
+    assert_eq!(body["lines"][0]["discount_cents"], 1000);
+    assert_eq!(body["lines"][1]["discount_cents"], 0);
+
+    // Quoting never spends a redemption.
+    let coupon = repo::coupons::get_by_code(&ctx, "BOOKS25").await.unwrap();
+    assert_eq!(coupon.i64_field("redemption_count"), 0);
+}
+
+#[tokio::test]
+async fn validate_reports_specific_codes() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_1", 10.0, "").await;
+    let past = crate::util::format_rfc3339(chrono::Utc::now() - chrono::Duration::days(1));
+    create_coupon(
+        &ctx,
+        serde_json::json!({
+            "code": "OLD",
+            "discount_type": "fixed",
+            "value": 100,
+            "valid_to": past,
+        }),
+    )
+    .await;
+
+    let validate = |code: &str| {
+        create_msg(
+            "/b/products/coupons/validate",
+            "user_1",
+            serde_json::json!({"code": code, "items": [{"product_id": "p_1", "quantity": 1}]}),
+        )
+    };
+
+    let (msg, input) = validate("OLD");
+    let out = dispatch_user(&ctx, msg, input).await;
+    assert_eq!(output_status(out).await, 410);
+    let (msg, input) = validate("OLD");
+    let body = output_to_json(dispatch_user(&ctx, msg, input).await).await;
+    assert_eq!(body["code"], "coupon_expired");
+
+    let (msg, input) = validate("NOPE");
+    let body = output_to_json(dispatch_user(&ctx, msg, input).await).await;
+    assert_eq!(body["code"], "coupon_invalid");
+}
+
+// ============================================================
+// Purchase integration
+// ============================================================
+
+#[tokio::test]
+async fn purchase_records_discount_on_header_and_lines() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_1", 10.0, "").await;
+    let id = create_coupon(
+        &ctx,
+        serde_json::json!({"code": "TENOFF", "discount_type": "percent", "value": 10}),
+    )
+    .await;
+
+    let (msg, input) = purchase_msg("user_1", "p_1", 3, "tenoff");
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(body["total_cents"], 2700);
+    assert_eq!(body["discount_cents"], 300);
+    assert_eq!(body["coupon_code"], "TENOFF");
+
+    let purchase_id = body["id"].as_str().unwrap();
+    let header = repo::purchases::get(&ctx, purchase_id).await.unwrap();
+    assert_eq!(header.i64_field("discount_cents"), 300);
+    assert_eq!(header.str_field("coupon_code"), "TENOFF");
+    let lines = db::get_by_field(
+        &ctx,
+        LINE_ITEMS_TABLE,
+        "purchase_id",
+        serde_json::json!(purchase_id),
+    )
+    .await
+    .unwrap();
+    assert_eq!(lines.i64_field("discount_cents"), 300);
+
+    assert_eq!(redemption_count(&ctx, &id).await, 1);
+}
+
+#[tokio::test]
+async fn exhausted_coupon_is_refused_and_purchase_not_created() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_1", 10.0, "").await;
+    create_coupon(
+        &ctx,
+        serde_json::json!({
+            "code": "ONCE",
+            "discount_type": "fixed",
+            "value": 100,
+            "max_redemptions": 1,
+        }),
+    )
+    .await;
+
+    let (msg, input) = purchase_msg("user_1", "p_1", 1, "ONCE");
+    let first = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(first["status"], "pending");
+
+    let (msg, input) = purchase_msg("user_2", "p_1", 1, "ONCE");
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(body["code"], "coupon_exhausted");
+
+    let (msg, _) = get_msg("/b/products/purchases", "user_2");
+    let mine = output_to_json(purchase::handle_list_user(&ctx, &msg).await).await;
+    assert_eq!(mine["total_count"], 0);
+}
+
+#[tokio::test]
+async fn per_user_limit_applies_per_user() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_1", 10.0, "").await;
+    create_coupon(
+        &ctx,
+        serde_json::json!({
+            "code": "WELCOME",
+            "discount_type": "fixed",
+            "value": 100,
+            "per_user_limit": 1,
+        }),
+    )
+    .await;
+
+    let (msg, input) = purchase_msg("user_1", "p_1", 1, "WELCOME");
+    purchase::handle_create(&ctx, &msg, input).await;
+    let (msg, input) = purchase_msg("user_1", "p_1", 1, "WELCOME");
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(body["code"], "coupon_exhausted");
+
+    let (msg, input) = purchase_msg("user_2", "p_1", 1, "WELCOME");
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(body["status"], "pending");
+}
+
+#[tokio::test]
+async fn concurrent_purchases_never_exceed_max_redemptions() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_1", 10.0, "").await;
+    let id = create_coupon(
+        &ctx,
+        serde_json::json!({
+            "code": "FLASH",
+            "discount_type": "percent",
+            "value": 50,
+            "max_redemptions": 3,
+        }),
+    )
+    .await;
+
+    let attempts = (0..8).map(|i| {
+        let ctx = &ctx;
+        async move {
+            let (msg, input) = purchase_msg(&format!("user_{i}"), "p_1", 1, "FLASH");
+            output_to_json(purchase::handle_create(ctx, &msg, input).await).await
+        }
+    });
+    let results = futures::future::join_all(attempts).await;
+
+    let won = results.iter().filter(|r| r["status"] == "pending").count();
+    let lost = results
+        .iter()
+        .filter(|r| r["code"] == "coupon_exhausted")
+        .count();
+    assert_eq!(won, 3);
+    assert_eq!(lost, 5);
+    assert_eq!(redemption_count(&ctx, &id).await, 3);
+}
+
+// ============================================================
+// Release on abandon / refund
+// ============================================================
+
+async fn completed_coupon_purchase(ctx: &TestContext, code: &str) -> String {
+    let (msg, input) = purchase_msg("user_1", "p_1", 1, code);
+    let body = output_to_json(purchase::handle_create(ctx, &msg, input).await).await;
+    let purchase_id = body["id"].as_str().unwrap().to_string();
+    repo::purchases::complete_atomic(ctx, &purchase_id, "pi_1")
+        .await
+        .unwrap();
+    purchase_id
+}
+
+async fn refund(ctx: &TestContext, purchase_id: &str) {
+    let (mut msg, input) = create_msg(
+        &format!("/admin/b/products/purchases/{purchase_id}/refund"),
+        "admin_1",
+        serde_json::json!({}),
+    );
+    msg.set_meta("auth.user_roles", "admin");
+    let body = output_to_json(purchase::handle_refund(ctx, &msg, input).await).await;
+    assert_eq!(body["data"]["status"], "refunded");
+}
+
+#[tokio::test]
+async fn refund_keeps_redemption_unless_configured() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_1", 10.0, "").await;
+    let id = create_coupon(
+        &ctx,
+        serde_json::json!({"code": "KEEP", "discount_type": "fixed", "value": 100}),
+    )
+    .await;
+
+    let purchase_id = completed_coupon_purchase(&ctx, "KEEP").await;
+    refund(&ctx, &purchase_id).await;
+    assert_eq!(redemption_count(&ctx, &id).await, 1);
+}
+
+#[tokio::test]
+async fn refund_releases_redemption_once_when_configured() {
+    let ctx = ctx_with(&[("SUPPERS_AI__PRODUCTS__COUPON_RELEASE_ON_REFUND", "true")]).await;
+    seed_product(&ctx, "p_1", 10.0, "").await;
+    let id = create_coupon(
+        &ctx,
+        serde_json::json!({"code": "GIVEBACK", "discount_type": "fixed", "value": 100}),
+    )
+    .await;
+
+    let purchase_id = completed_coupon_purchase(&ctx, "GIVEBACK").await;
+    refund(&ctx, &purchase_id).await;
+    assert_eq!(redemption_count(&ctx, &id).await, 0);
+
+    // A second release (webhook after admin refund) is a no-op.
+    coupons::release_purchase(&ctx, &purchase_id).await.unwrap();
+    assert_eq!(redemption_count(&ctx, &id).await, 0);
+}
@@ -598,6 +598,57 @@ async fn catalog_get_hides_non_active() {
     assert!(output_is_error(out, ErrorCode::NotFound).await);
 }
 
+#[tokio::test]
+async fn catalog_get_projects_fields_and_expands_related_rows() {
+    let ctx = ctx().await;
+    let mut d = HashMap::new();
+    d.insert("name".to_string(), serde_json::json!("Starter"));
+    seed(&ctx, "suppers_ai__products__groups", "g1", d).await;
+    let mut d = HashMap::new();
+    d.insert("name".to_string(), serde_json::json!("Widget"));
+    d.insert("status".to_string(), serde_json::json!("active"));
+    d.insert("base_price".to_string(), serde_json::json!(5.0));
+    d.insert("group_id".to_string(), serde_json::json!("g1"));
+    d.insert("product_template_id".to_string(), serde_json::json!("gone"));
+    seed(&ctx, "suppers_ai__products__products", "p1", d).await;
+
+    // No parameters: the full row plus the media it always carried.
+    let (msg, input) = get_msg("/b/products/catalog/p1", "");
+    let body = output_to_json(dispatch_user(&ctx, msg, input).await).await;
+    assert_eq!(body["data"]["name"], "Widget");
+    assert!(body["data"]["media"].is_array());
+    assert!(body["data"].get("group").is_none());
+
+    let (mut msg, input) = get_msg("/b/products/catalog/p1", "");
+    msg.set_meta("req.query.fields", "base_price");
+    msg.set_meta("req.query.expand", "group,template");
+    let body = output_to_json(dispatch_user(&ctx, msg, input).await).await;
+    assert_eq!(body["id"], "p1");
+    let mut keys: Vec<&String> = body["data"].as_object().unwrap().keys().collect();
+    keys.sort();
+    assert_eq!(keys, ["base_price", "group", "template"], "{body}");
+    assert_eq!(body["data"]["group"]["data"]["name"], "Starter");
+    assert!(body["data"]["template"].is_null());
+}
+
+#[tokio::test]
+async fn product_detail_rejects_unknown_names() {
+    let ctx = ctx().await;
+    let mut d = HashMap::new();
+    d.insert("name".to_string(), serde_json::json!("Widget"));
+    seed(&ctx, "suppers_ai__products__products", "p1", d).await;
+
+    let (mut msg, input) = admin_get_msg("/admin/b/products/products/p1");
+    msg.set_meta("req.query.expand", "owner");
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["code"], "validation_failed");
+    let reason = body["details"]["expand"].as_str().unwrap();
+    assert!(
+        reason.ends_with("valid: media, template, group"),
+        "{reason}"
+    );
+}
+
 // ============================================================
 // Group products endpoint
 // ============================================================
@@ -0,0 +1,313 @@
+use std::collections::HashMap;
+
+use wafer_core::clients::database as db;
+use wafer_run::{InputStream, Message};
+
+use super::harness::*;
+use crate::{
+    blocks::products::{inventory, purchase, repo, PRODUCTS_TABLE},
+    test_support::{output_status, TestContext},
+    util::RecordExt,
+};
+
+async fn seed_product(ctx: &TestContext, id: &str, stock: i64, tracked: bool) {
+    let mut product = HashMap::new();
+    product.insert("name".to_string(), serde_json::json!("Widget"));
+    product.insert("base_price".to_string(), serde_json::json!(10.0));
+    product.insert("status".to_string(), serde_json::json!("active"));
+    product.insert("stock".to_string(), serde_json::json!(stock));
+    product.insert(
+        "track_inventory".to_string(),
+        serde_json::json!(i64::from(tracked)),
+    );
+    seed(ctx, PRODUCTS_TABLE, id, product).await;
+}
+
+async fn stock_of(ctx: &TestContext, id: &str) -> i64 {
+    db::get(ctx, PRODUCTS_TABLE, id)
+        .await
+        .unwrap()
+        .i64_field("stock")
+}
+
+fn purchase_msg(user: &str, product_id: &str, qty: i64) -> (Message, InputStream) {
+    create_msg(
+        "/b/products/purchases",
+        user,
+        serde_json::json!({"items": [{"product_id": product_id, "quantity": qty}]}),
+    )
+}
+
+// ============================================================
+// Reservations at purchase time
+// ============================================================
+
+#[tokio::test]
+async fn pending_purchase_reserves_stock_against_other_buyers() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_inv", 3, true).await;
+
+    let (msg, input) = purchase_msg("user_1", "p_inv", 2);
+    let first = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(first["status"], "pending");
+
+    // Only 1 left once user_1's hold is counted.
+    let (msg, input) = purchase_msg("user_2", "p_inv", 2);
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(body["code"], "insufficient_stock");
+    assert_eq!(body["details"]["available"], 1);
+    assert_eq!(body["details"]["requested"], 2);
+
+    // Reserving doesn't touch on-hand stock.
+    assert_eq!(stock_of(&ctx, "p_inv").await, 3);
+}
+
+#[tokio::test]
+async fn insufficient_stock_is_409() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_inv", 1, true).await;
+
+    let (msg, input) = purchase_msg("user_1", "p_inv", 2);
+    let out = purchase::handle_create(&ctx, &msg, input).await;
+    assert_eq!(output_status(out).await, 409);
+}
+
+#[tokio::test]
+async fn untracked_product_ignores_stock() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_free", 0, false).await;
+
+    let (msg, input) = purchase_msg("user_1", "p_free", 5);
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(body["status"], "pending");
+}
+
+#[tokio::test]
+async fn expired_reservation_stops_counting() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_inv", 2, true).await;
+
+    let past = crate::util::format_rfc3339(chrono::Utc::now() - chrono::Duration::minutes(1));
+    let holds = [("p_inv".to_string(), 2)];
+    repo::inventory::replace_reservations(&ctx, "stale_purchase", &holds, &past)
+        .await
+        .unwrap();
+
+    let (msg, input) = purchase_msg("user_1", "p_inv", 2);
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(body["status"], "pending");
+}
+
+// ============================================================
+// Commit on payment / restore on refund
+// ============================================================
+
+#[tokio::test]
+async fn commit_decrements_once_and_restore_puts_it_back_once() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_inv", 5, true).await;
+
+    let (msg, input) = purchase_msg("user_1", "p_inv", 2);
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    let purchase_id = body["id"].as_str().unwrap().to_string();
+
+    inventory::commit_purchase(&ctx, &purchase_id)
+        .await
+        .unwrap();
+    // Webhook retry — must not decrement again.
+    inventory::commit_purchase(&ctx, &purchase_id)
+        .await
+        .unwrap();
+    assert_eq!(stock_of(&ctx, "p_inv").await, 3);
+
+    // The hold was released on commit.
+    let now = crate::util::now_rfc3339();
+    let reserved = repo::inventory::reserved_quantity(&ctx, "p_inv", &now, None)
+        .await
+        .unwrap();
+    assert_eq!(reserved, 0);
+
+    for _ in 0..2 {
+        inventory::restore_purchase(&ctx, &purchase_id, "admin_1")
+            .await
+            .unwrap();
+    }
+    assert_eq!(stock_of(&ctx, "p_inv").await, 5);
+
+    let audit = repo::inventory::list_adjustments(&ctx, "p_inv", 10)
+        .await
+        .unwrap();
+    let reasons: Vec<&str> = audit
+        .records
+        .iter()
+        .map(|r| r.str_field("reason"))
+        .collect();
+    assert_eq!(reasons.len(), 2);
+    assert!(reasons.contains(&"purchase"));
+    assert!(reasons.contains(&"refund"));
+}
+
+#[tokio::test]
+async fn commit_retry_takes_only_products_a_failed_commit_missed() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_a", 5, true).await;
+    seed_product(&ctx, "p_b", 5, true).await;
+    let (msg, input) = create_msg(
+        "/b/products/purchases",
+        "user_1",
+        serde_json::json!({"items": [
+            {"product_id": "p_a", "quantity": 1},
+            {"product_id": "p_b", "quantity": 2},
+        ]}),
+    );
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    let purchase_id = body["id"].as_str().unwrap().to_string();
+
+    // An earlier attempt took p_a, then failed before reaching p_b.
+    assert!(repo::inventory::decrement_stock(&ctx, "p_a", 1)
+        .await
+        .unwrap());
+    repo::inventory::record_adjustment(
+        &ctx,
+        repo::inventory::NewAdjustment {
+            product_id: "p_a",
+            delta: -1,
+            reason: "purchase",
+            purchase_id: &purchase_id,
+            actor: "",
+            note: "",
+        },
+    )
+    .await
+    .unwrap();
+
+    inventory::commit_purchase(&ctx, &purchase_id)
+        .await
+        .unwrap();
+    assert_eq!(stock_of(&ctx, "p_a").await, 4);
+    assert_eq!(stock_of(&ctx, "p_b").await, 3);
+}
+
+#[tokio::test]
+async fn only_one_commit_claims_each_product_of_a_purchase() {
+    let ctx = ctx().await;
+    let adjustment = |reason, purchase_id| repo::inventory::NewAdjustment {
+        product_id: "p_inv",
+        delta: -1,
+        reason,
+        purchase_id,
+        actor: "",
+        note: "",
+    };
+    let claim = repo::inventory::claim_adjustment(&ctx, adjustment("purchase", "pur_1"))
+        .await
+        .unwrap();
+    assert!(claim.is_some());
+    let again = repo::inventory::claim_adjustment(&ctx, adjustment("purchase", "pur_1"))
+        .await
+        .unwrap();
+    assert!(again.is_none());
+    let refund = repo::inventory::claim_adjustment(&ctx, adjustment("refund", "pur_1"))
+        .await
+        .unwrap();
+    assert!(refund.is_some());
+
+    // Manual adjustments carry no purchase and aren't limited.
+    for _ in 0..2 {
+        repo::inventory::record_adjustment(&ctx, adjustment("manual", ""))
+            .await
+            .unwrap();
+    }
+}
+
+#[tokio::test]
+async fn release_job_deletes_only_expired_holds() {
+    let ctx = ctx().await;
+    let at = |minutes: i64| {
+        crate::util::format_rfc3339(crate::clock::now() + chrono::Duration::minutes(minutes))
+    };
+    repo::inventory::replace_reservations(&ctx, "stale", &[("p_inv".to_string(), 2)], &at(-1))
+        .await
+        .unwrap();
+    repo::inventory::replace_reservations(&ctx, "live", &[("p_inv".to_string(), 1)], &at(10))
+        .await
+        .unwrap();
+
+    inventory::run_release_job(&ctx).await.unwrap();
+
+    let left = db::list_all(&ctx, repo::inventory::RESERVATIONS_TABLE, vec![])
+        .await
+        .unwrap();
+    assert_eq!(left.len(), 1);
+    assert_eq!(left[0].str_field("purchase_id"), "live");
+}
+
+#[tokio::test]
+async fn decrement_never_drives_stock_negative() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_inv", 1, true).await;
+
+    assert!(!repo::inventory::decrement_stock(&ctx, "p_inv", 2)
+        .await
+        .unwrap());
+    assert!(repo::inventory::decrement_stock(&ctx, "p_inv", 1)
+        .await
+        .unwrap());
+    assert!(!repo::inventory::decrement_stock(&ctx, "p_inv", 1)
+        .await
+        .unwrap());
+    assert_eq!(stock_of(&ctx, "p_inv").await, 0);
+}
+
+// ============================================================
+// Admin stock endpoints + catalog flag
+// ============================================================
+
+#[tokio::test]
+async fn admin_adjust_stock_records_audit_and_refuses_negative() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_inv", 2, true).await;
+
+    let (msg, input) = admin_create_msg(
+        "/admin/b/products/products/p_inv/stock",
+        serde_json::json!({"delta": -5}),
+    );
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["code"], "insufficient_stock");
+    assert_eq!(stock_of(&ctx, "p_inv").await, 2);
+
+    let (msg, input) = admin_create_msg(
+        "/admin/b/products/products/p_inv/stock",
+        serde_json::json!({"delta": 5, "note": "restock"}),
+    );
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["stock"], 7);
+    assert_eq!(body["available"], 7);
+    assert_eq!(body["adjustments"][0]["data"]["reason"], "manual");
+    assert_eq!(body["adjustments"][0]["data"]["actor"], "admin_1");
+    assert_eq!(body["adjustments"][0]["data"]["note"], "restock");
+}
+
+#[tokio::test]
+async fn catalog_flags_out_of_stock_products() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_gone", 0, true).await;
+    seed_product(&ctx, "p_untracked", 0, false).await;
+
+    let (msg, input) = get_msg("/b/products/catalog", "");
+    let body = output_to_json(dispatch_user(&ctx, msg, input).await).await;
+    let records = body["records"].as_array().unwrap();
+    let flag = |id: &str| {
+        records
+            .iter()
+            .find(|r| r["id"] == id)
+            .map(|r| r["data"]["out_of_stock"].clone())
+            .unwrap()
+    };
+    assert_eq!(flag("p_gone"), true);
+    assert_eq!(flag("p_untracked"), false);
+
+    let (msg, input) = get_msg("/b/products/catalog/p_gone", "");
+    let body = output_to_json(dispatch_user(&ctx, msg, input).await).await;
+    assert_eq!(body["data"]["out_of_stock"], true);
+}
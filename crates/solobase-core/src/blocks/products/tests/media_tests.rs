@@ -0,0 +1,277 @@
+use std::collections::HashMap;
+
+use wafer_core::clients::storage as store;
+use wafer_run::{ErrorCode, InputStream, Message};
+
+use super::harness::*;
+use crate::{
+    blocks::products::{media, repo, PRODUCTS_TABLE},
+    test_support::{output_body, TestContext},
+};
+
+async fn media_ctx(config: &[(&str, &str)]) -> TestContext {
+    let mut ctx = ctx_with(config).await;
+    ctx.register_mem_storage();
+    ctx
+}
+
+async fn seed_product(ctx: &TestContext, id: &str, status: &str) {
+    let mut product = HashMap::new();
+    product.insert("name".to_string(), serde_json::json!("Widget"));
+    product.insert("base_price".to_string(), serde_json::json!(10.0));
+    product.insert("status".to_string(), serde_json::json!(status));
+    seed(ctx, PRODUCTS_TABLE, id, product).await;
+}
+
+/// Admin raw-body upload to `products/{id}/media`.
+fn upload_msg(
+    product_id: &str,
+    content_type: &str,
+    query: &[(&str, &str)],
+    body: &[u8],
+) -> (Message, InputStream) {
+    let mut msg = Message::new("http.request");
+    msg.set_meta("req.action", "create");
+    msg.set_meta(
+        "req.resource",
+        format!("/admin/b/products/products/{product_id}/media"),
+    );
+    msg.set_meta("req.content_type", content_type);
+    msg.set_meta("auth.user_id", "admin_1");
+    msg.set_meta("auth.user_roles", "admin");
+    for (k, v) in query {
+        msg.set_meta(format!("req.query.{k}"), *v);
+    }
+    (msg, InputStream::from_bytes(body.to_vec()))
+}
+
+async fn upload(
+    ctx: &TestContext,
+    product_id: &str,
+    content_type: &str,
+    filename: &str,
+) -> serde_json::Value {
+    let (msg, input) = upload_msg(
+        product_id,
+        content_type,
+        &[("filename", filename)],
+        b"bytes",
+    );
+    output_to_json(dispatch_admin(ctx, msg, input).await).await
+}
+
+#[tokio::test]
+async fn upload_is_stored_and_listed_with_its_url() {
+    let ctx = media_ctx(&[]).await;
+    seed_product(&ctx, "p1", "active").await;
+
+    let body = upload(&ctx, "p1", "image/png", "front.png").await;
+    assert_eq!(body["kind"], "image");
+    assert_eq!(body["filename"], "front.png");
+    let id = body["id"].as_str().unwrap().to_string();
+    assert_eq!(body["url"], format!("/b/products/media/{id}"));
+
+    let (stored, _) = store::get(&ctx, media::BUCKET, &format!("p1/{id}"))
+        .await
+        .unwrap();
+    assert_eq!(stored, b"bytes");
+
+    let (msg, input) = get_msg("/b/products/catalog/p1", "");
+    let detail = output_to_json(dispatch_user(&ctx, msg, input).await).await;
+    assert_eq!(detail["data"]["media"][0]["id"], id.as_str());
+
+    let (msg, input) = get_msg("/b/products/catalog", "");
+    let list = output_to_json(dispatch_user(&ctx, msg, input).await).await;
+    assert_eq!(list["records"][0]["data"]["media"][0]["id"], id.as_str());
+}
+
+#[tokio::test]
+async fn image_slot_rejects_non_images() {
+    let ctx = media_ctx(&[]).await;
+    seed_product(&ctx, "p1", "active").await;
+
+    let (msg, input) = upload_msg(
+        "p1",
+        "application/pdf",
+        &[("kind", "image"), ("filename", "spec.pdf")],
+        b"%PDF",
+    );
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["code"], "validation_failed");
+
+    // Without an explicit kind a PDF lands in the attachment slot.
+    let body = upload(&ctx, "p1", "application/pdf", "spec.pdf").await;
+    assert_eq!(body["kind"], "attachment");
+}
+
+#[tokio::test]
+async fn media_count_is_capped_by_config() {
+    let ctx = media_ctx(&[(media::MAX_PER_PRODUCT_KEY, "1")]).await;
+    seed_product(&ctx, "p1", "active").await;
+
+    upload(&ctx, "p1", "image/png", "a.png").await;
+    let body = upload(&ctx, "p1", "image/png", "b.png").await;
+    assert_eq!(body["code"], "validation_failed");
+    assert_eq!(repo::media::count_for_product(&ctx, "p1").await.unwrap(), 1);
+}
+
+#[tokio::test]
+async fn reorder_requires_every_item_once() {
+    let ctx = media_ctx(&[]).await;
+    seed_product(&ctx, "p1", "active").await;
+    let a = upload(&ctx, "p1", "image/png", "a.png").await["id"].clone();
+    let b = upload(&ctx, "p1", "image/png", "b.png").await["id"].clone();
+
+    let (msg, input) = update_msg(
+        "/admin/b/products/products/p1/media",
+        "admin_1",
+        serde_json::json!({"order": [a]}),
+    );
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["code"], "validation_failed");
+
+    let (msg, input) = update_msg(
+        "/admin/b/products/products/p1/media",
+        "admin_1",
+        serde_json::json!({"order": [b, a]}),
+    );
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["media"][0]["id"], b);
+    assert_eq!(body["media"][1]["id"], a);
+}
+
+#[tokio::test]
+async fn media_of_a_draft_product_is_hidden_from_the_public() {
+    let ctx = media_ctx(&[]).await;
+    seed_product(&ctx, "p1", "draft").await;
+    let id = upload(&ctx, "p1", "image/png", "a.png").await["id"]
+        .as_str()
+        .unwrap()
+        .to_string();
+
+    let (msg, input) = get_msg(&format!("/b/products/media/{id}"), "");
+    assert!(output_is_error(dispatch_user(&ctx, msg, input).await, ErrorCode::NotFound).await);
+
+    let (msg, input) = admin_get_msg(&format!("/b/products/media/{id}"));
+    assert_eq!(
+        output_body(dispatch_user(&ctx, msg, input).await).await,
+        b"bytes"
+    );
+}
+
+#[tokio::test]
+async fn deleting_a_product_removes_its_media() {
+    let ctx = media_ctx(&[]).await;
+    seed_product(&ctx, "p1", "active").await;
+    let id = upload(&ctx, "p1", "image/png", "a.png").await["id"]
+        .as_str()
+        .unwrap()
+        .to_string();
+
+    let (mut msg, input) = delete_msg("/admin/b/products/products/p1", "admin_1");
+    msg.set_meta("auth.user_roles", "admin");
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["deleted"], true);
+    assert_eq!(body["media_removed"], 1);
+
+    assert_eq!(repo::media::count_for_product(&ctx, "p1").await.unwrap(), 0);
+    assert!(store::get(&ctx, media::BUCKET, &format!("p1/{id}"))
+        .await
+        .is_err());
+}
+
+#[tokio::test]
+async fn unknown_product_is_not_found() {
+    let ctx = media_ctx(&[]).await;
+    let (msg, input) = upload_msg("nope", "image/png", &[], b"x");
+    let out = dispatch_admin(&ctx, msg, input).await;
+    assert!(output_is_error(out, ErrorCode::NotFound).await);
+}
+
+#[cfg(feature = "block-files")]
+mod attach {
+    use std::sync::Arc;
+
+    use super::*;
+    use crate::blocks::files::{repo::objects, FilesBlock};
+
+    /// Files + products schemas, in-memory storage, and the real files
+    /// block for the object read `from_object` delegates.
+    async fn files_ctx() -> TestContext {
+        let mut ctx = TestContext::with_files().await;
+        ctx.apply_block_migrations(
+            "suppers-ai/products",
+            crate::blocks::products::migrations::SQLITE_MIGRATIONS,
+            crate::blocks::products::migrations::POSTGRES_MIGRATIONS,
+        )
+        .await;
+        ctx.register_mem_storage();
+        ctx.register_block("suppers-ai/files", Arc::new(FilesBlock::new()));
+        ctx
+    }
+
+    async fn seed_object(ctx: &TestContext, uploaded_by: &str) -> String {
+        let row = objects::insert_pending(
+            ctx,
+            "docs",
+            "photo.png",
+            4,
+            &crate::util::sha256_hex(b"png!"),
+            "image/png",
+            uploaded_by,
+            "",
+        )
+        .await
+        .unwrap();
+        store::put(
+            ctx,
+            "docs",
+            &objects::blob_key(&row.id),
+            b"png!",
+            "image/png",
+        )
+        .await
+        .unwrap();
+        objects::mark_complete(ctx, &row.id).await.unwrap();
+        row.id
+    }
+
+    fn attach_msg(object_id: &str) -> (Message, InputStream) {
+        let (mut msg, input) = admin_create_msg(
+            "/admin/b/products/products/p1/media",
+            serde_json::json!({"object_id": object_id}),
+        );
+        msg.set_meta("req.content_type", "application/json");
+        (msg, input)
+    }
+
+    #[tokio::test]
+    async fn owned_object_is_copied_into_product_media() {
+        let ctx = files_ctx().await;
+        seed_product(&ctx, "p1", "active").await;
+        let object_id = seed_object(&ctx, "admin_1").await;
+
+        let (msg, input) = attach_msg(&object_id);
+        let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+        assert_eq!(body["object_id"], object_id.as_str());
+        assert_eq!(body["kind"], "image");
+
+        let id = body["id"].as_str().unwrap();
+        let (stored, _) = store::get(&ctx, media::BUCKET, &format!("p1/{id}"))
+            .await
+            .unwrap();
+        assert_eq!(stored, b"png!");
+    }
+
+    #[tokio::test]
+    async fn someone_elses_object_is_refused() {
+        let ctx = files_ctx().await;
+        seed_product(&ctx, "p1", "active").await;
+        let object_id = seed_object(&ctx, "user_2").await;
+
+        let (msg, input) = attach_msg(&object_id);
+        let out = dispatch_admin(&ctx, msg, input).await;
+        assert!(output_is_error(out, ErrorCode::PermissionDenied).await);
+        assert_eq!(repo::media::count_for_product(&ctx, "p1").await.unwrap(), 0);
+    }
+}
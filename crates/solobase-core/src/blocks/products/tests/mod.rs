@@ -1,6 +1,13 @@
+mod app_tests;
+mod coupon_tests;
 mod handler_tests;
 mod harness;
+mod inventory_tests;
+mod media_tests;
 mod pricing_tests;
 mod purchase_tests;
 mod repo_tests;
 mod stripe_tests;
+mod tax_tests;
+mod timestamp_tests;
+mod transfer_tests;
@@ -1,6 +1,6 @@
 use std::collections::HashMap;
 
-use wafer_run::ErrorCode;
+use wafer_run::{ErrorCode, OutputStream};
 
 use super::harness::*;
 use crate::blocks::products::pricing::{evaluate_formula, validate_price, MIN_PRICE};
@@ -401,6 +401,68 @@ async fn calculate_price_product_not_found() {
     assert!(output_is_error(out, ErrorCode::NotFound).await);
 }
 
+/// `handle_calculate` for `product_id` with no variables.
+async fn calculate(ctx: &crate::test_support::TestContext, product_id: &str) -> OutputStream {
+    use crate::blocks::products::pricing;
+
+    let (_msg, input) = create_msg(
+        "/b/products/calculate-price",
+        "user_1",
+        serde_json::json!({ "product_id": product_id }),
+    );
+    pricing::handle_calculate(ctx, input).await
+}
+
+async fn unit_price(ctx: &crate::test_support::TestContext, product_id: &str) -> serde_json::Value {
+    output_to_json(calculate(ctx, product_id).await).await["unit_price"].clone()
+}
+
+#[tokio::test]
+async fn cached_formulas_follow_template_writes() {
+    use wafer_core::clients::database as db;
+
+    crate::cache::enable_for_current_thread();
+    let ctx = ctx().await;
+    let (create, input) = admin_create_msg(
+        "/admin/b/products/pricing",
+        serde_json::json!({ "name": "flat", "price_formula": "10" }),
+    );
+    let template = output_to_json(dispatch_admin(&ctx, create, input).await).await;
+    let id = template["id"].as_str().unwrap().to_string();
+    let mut product = HashMap::new();
+    product.insert("name".to_string(), serde_json::json!("Flat"));
+    product.insert("pricing_template_id".to_string(), serde_json::json!(id));
+    seed(&ctx, "suppers_ai__products__products", "prod_flat", product).await;
+
+    assert_eq!(unit_price(&ctx, "prod_flat").await, 10.0);
+    // Written behind the cache's back: the cached formula still answers.
+    let mut raw = HashMap::new();
+    raw.insert("price_formula".to_string(), serde_json::json!("20"));
+    db::update(&ctx, "suppers_ai__products__pricing_templates", &id, raw)
+        .await
+        .unwrap();
+    assert_eq!(unit_price(&ctx, "prod_flat").await, 10.0);
+
+    let path = format!("/admin/b/products/pricing/{id}");
+    let (mut update, input) = update_msg(
+        &path,
+        "admin_1",
+        serde_json::json!({ "price_formula": "30" }),
+    );
+    update.set_meta("auth.user_roles", "admin");
+    dispatch_admin(&ctx, update, input).await;
+    assert_eq!(unit_price(&ctx, "prod_flat").await, 30.0);
+
+    let (mut del, input) = delete_msg(&path, "admin_1");
+    del.set_meta("auth.user_roles", "admin");
+    dispatch_admin(&ctx, del, input).await;
+    let out = calculate(&ctx, "prod_flat").await;
+    assert!(
+        output_is_error(out, ErrorCode::Internal).await,
+        "template is gone"
+    );
+}
+
 // ============================================================
 // SEC-063: validate_price guard (regression test)
 // ============================================================
@@ -111,6 +111,50 @@ async fn webhook_checkout_completed_empty_purchase_id() {
     assert_eq!(body["received"], true);
 }
 
+/// A delivery for a purchase that is already `completed` still commits its
+/// stock, so a retry after a failed commit finishes it; repeats take
+/// nothing more.
+#[tokio::test]
+async fn webhook_redelivery_commits_stock_for_a_completed_purchase() {
+    let ctx = ctx_with(&[(
+        "SUPPERS_AI__PRODUCTS__STRIPE_WEBHOOK_SECRET",
+        WEBHOOK_SECRET,
+    )])
+    .await;
+    let mut product = HashMap::new();
+    product.insert("name".to_string(), serde_json::json!("Widget"));
+    product.insert("base_price".to_string(), serde_json::json!(10.0));
+    product.insert("status".to_string(), serde_json::json!("active"));
+    product.insert("stock".to_string(), serde_json::json!(5));
+    product.insert("track_inventory".to_string(), serde_json::json!(1));
+    seed(&ctx, "suppers_ai__products__products", "p_inv", product).await;
+
+    let (msg, input) = create_msg(
+        "/b/products/purchases",
+        "user_1",
+        serde_json::json!({"items": [{"product_id": "p_inv", "quantity": 2}]}),
+    );
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    let purchase_id = body["id"].as_str().expect("purchase created").to_string();
+    // An earlier delivery completed the purchase, then failed to commit.
+    let mut done = HashMap::new();
+    done.insert("status".to_string(), serde_json::json!("completed"));
+    db::update(&ctx, "suppers_ai__products__purchases", &purchase_id, done)
+        .await
+        .unwrap();
+
+    for _ in 0..2 {
+        let event = checkout_completed_event(&purchase_id, "pi_redelivered");
+        let (msg, input) = webhook_msg(&event, WEBHOOK_SECRET);
+        let body = output_to_json(stripe::handle_webhook(&ctx, &msg, input).await).await;
+        assert_eq!(body["received"], true);
+    }
+    let product = db::get(&ctx, "suppers_ai__products__products", "p_inv")
+        .await
+        .unwrap();
+    assert_eq!(product.data["stock"], 3);
+}
+
 // ============================================================
 // Webhook — charge.refunded
 // ============================================================
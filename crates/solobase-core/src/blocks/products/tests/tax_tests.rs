@@ -0,0 +1,319 @@
+use std::{collections::HashMap, sync::Arc};
+
+use wafer_run::InputStream;
+
+use super::harness::*;
+use crate::{
+    blocks::products::{
+        purchase, repo, stripe,
+        tax::{TaxCalculator, TaxCalculatorBlock, TaxItem, TaxRequest, TAX_CALCULATOR_BLOCK},
+        PRODUCTS_TABLE, PURCHASES_TABLE,
+    },
+    test_support::{output_status, TestContext},
+    util::RecordExt,
+};
+
+async fn seed_product(ctx: &TestContext, id: &str, price: f64) {
+    let mut product = HashMap::new();
+    product.insert("name".to_string(), serde_json::json!("Widget"));
+    product.insert("base_price".to_string(), serde_json::json!(price));
+    product.insert("status".to_string(), serde_json::json!("active"));
+    seed(ctx, PRODUCTS_TABLE, id, product).await;
+}
+
+/// Create a tax rate through the admin API and return its id.
+async fn create_rate(ctx: &TestContext, body: serde_json::Value) -> String {
+    let (msg, input) = admin_create_msg("/admin/b/products/tax-rates", body);
+    let created = output_to_json(dispatch_admin(ctx, msg, input).await).await;
+    created["id"]
+        .as_str()
+        .unwrap_or_else(|| panic!("tax rate not created: {created}"))
+        .to_string()
+}
+
+async fn buy(ctx: &TestContext, product_id: &str, qty: i64, country: &str) -> serde_json::Value {
+    let (msg, input) = create_msg(
+        "/b/products/purchases",
+        "user_1",
+        serde_json::json!({
+            "items": [{"product_id": product_id, "quantity": qty}],
+            "billing_country": country,
+        }),
+    );
+    output_to_json(purchase::handle_create(ctx, &msg, input).await).await
+}
+
+// ============================================================
+// Admin CRUD
+// ============================================================
+
+#[tokio::test]
+async fn rates_are_stored_per_normalized_region() {
+    let ctx = ctx().await;
+    let id = create_rate(
+        &ctx,
+        serde_json::json!({"region": "de", "rate": 19, "label": "VAT", "inclusive": true}),
+    )
+    .await;
+
+    let stored = repo::tax::get(&ctx, &id).await.unwrap();
+    assert_eq!(stored.str_field("region"), "DE");
+    assert_eq!(stored.i64_field("rate_ppm"), 190_000);
+    assert!(stored.bool_field("inclusive"));
+    assert!(stored.bool_field("active"));
+
+    // One rate per region.
+    let (msg, input) = admin_create_msg(
+        "/admin/b/products/tax-rates",
+        serde_json::json!({"region": "DE", "rate": 7, "label": "Reduced VAT"}),
+    );
+    assert_eq!(
+        output_status(dispatch_admin(&ctx, msg, input).await).await,
+        409
+    );
+
+    let (msg, input) = admin_get_msg("/admin/b/products/tax-rates");
+    let list = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(list["total_count"], 1);
+    assert_eq!(list["records"][0]["data"]["rate"], 19.0);
+}
+
+#[tokio::test]
+async fn invalid_rate_is_rejected_with_field_details() {
+    let ctx = ctx().await;
+    let (msg, input) = admin_create_msg(
+        "/admin/b/products/tax-rates",
+        serde_json::json!({"region": "Germany", "rate": 120}),
+    );
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["code"], "validation_failed");
+    assert!(body["details"]["region"].is_string());
+    assert!(body["details"]["rate"].is_string());
+    assert!(body["details"]["label"].is_string());
+}
+
+#[tokio::test]
+async fn patch_and_delete_a_rate() {
+    let ctx = ctx().await;
+    let id = create_rate(
+        &ctx,
+        serde_json::json!({"region": "US-CA", "rate": 7.25, "label": "Sales tax"}),
+    )
+    .await;
+
+    let (mut msg, input) = update_msg(
+        &format!("/admin/b/products/tax-rates/{id}"),
+        "admin_1",
+        serde_json::json!({"rate": 8.25, "active": false}),
+    );
+    msg.set_meta("auth.user_roles", "admin");
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["data"]["rate_ppm"], 82_500);
+    assert_eq!(body["data"]["active"], 0);
+
+    let (mut msg, input) = delete_msg(&format!("/admin/b/products/tax-rates/{id}"), "admin_1");
+    msg.set_meta("auth.user_roles", "admin");
+    let body = output_to_json(dispatch_admin(&ctx, msg, input).await).await;
+    assert_eq!(body["deleted"], true);
+    assert!(repo::tax::get(&ctx, &id).await.is_err());
+}
+
+// ============================================================
+// Purchases
+// ============================================================
+
+#[tokio::test]
+async fn exclusive_tax_is_added_to_the_total() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_1", 12.50).await;
+    create_rate(
+        &ctx,
+        serde_json::json!({"region": "US", "rate": 10, "label": "Sales tax"}),
+    )
+    .await;
+
+    // 10% of 1250 = 125.
+    let body = buy(&ctx, "p_1", 1, "us").await;
+    assert_eq!(body["subtotal_cents"], 1250);
+    assert_eq!(body["tax_cents"], 125);
+    assert_eq!(body["total_cents"], 1375);
+    assert_eq!(body["billing_country"], "US");
+    assert_eq!(body["tax_items"][0]["label"], "Sales tax");
+
+    let id = body["id"].as_str().unwrap();
+    let stored = repo::purchases::get(&ctx, id).await.unwrap();
+    assert_eq!(stored.i64_field("tax_cents"), 125);
+    assert_eq!(stored.str_field("billing_country"), "US");
+    let lines = repo::purchases::list_line_items(&ctx, id).await.unwrap();
+    assert_eq!(lines[0].i64_field("tax_cents"), 125);
+
+    let (msg, _) = get_msg(&format!("/b/products/purchases/{id}"), "user_1");
+    let detail = output_to_json(purchase::handle_get(&ctx, &msg).await).await;
+    assert_eq!(detail["tax_items"][0]["tax_cents"], 125);
+}
+
+#[tokio::test]
+async fn inclusive_tax_leaves_the_total_alone() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_1", 12.00).await;
+    create_rate(
+        &ctx,
+        serde_json::json!({"region": "GB", "rate": 20, "label": "VAT", "inclusive": true}),
+    )
+    .await;
+
+    let body = buy(&ctx, "p_1", 1, "GB").await;
+    assert_eq!(body["total_cents"], 1200);
+    assert_eq!(body["tax_cents"], 200);
+}
+
+#[tokio::test]
+async fn subdivision_falls_back_to_country_then_to_none() {
+    let ctx = ctx().await;
+    seed_product(&ctx, "p_1", 10.00).await;
+    create_rate(
+        &ctx,
+        serde_json::json!({"region": "US", "rate": 5, "label": "Federal"}),
+    )
+    .await;
+    create_rate(
+        &ctx,
+        serde_json::json!({"region": "US-CA", "rate": 7.25, "label": "California"}),
+    )
+    .await;
+
+    // 7.25% of 1000 = 72.5 -> 72 (half to even).
+    let body = buy(&ctx, "p_1", 1, "US-CA").await;
+    assert_eq!(body["tax_cents"], 72);
+    assert_eq!(body["tax_items"][0]["region"], "US-CA");
+
+    let body = buy(&ctx, "p_1", 1, "US-NY").await;
+    assert_eq!(body["tax_cents"], 50);
+    assert_eq!(body["tax_items"][0]["region"], "US");
+
+    let body = buy(&ctx, "p_1", 1, "FR").await;
+    assert_eq!(body["tax_cents"], 0);
+    assert_eq!(body["total_cents"], 1000);
+
+    let (msg, input) = create_msg(
+        "/b/products/purchases",
+        "user_1",
+        serde_json::json!({
+            "items": [{"product_id": "p_1", "quantity": 1}],
+            "billing_country": "United States",
+        }),
+    );
+    let body = output_to_json(purchase::handle_create(&ctx, &msg, input).await).await;
+    assert_eq!(body["code"], "validation_failed");
+    assert!(body["details"]["billing_country"].is_string());
+}
+
+/// Charges a flat 99 cents on every line.
+struct FlatCalculator;
+
+#[async_trait::async_trait]
+impl TaxCalculator for FlatCalculator {
+    async fn calculate(&self, request: &TaxRequest) -> Result<Vec<TaxItem>, String> {
+        Ok(request
+            .lines
+            .iter()
+            .enumerate()
+            .map(|(i, line)| TaxItem {
+                line: i,
+                product_id: line.product_id.clone(),
+                region: request.billing_country.clone(),
+                label: "Flat".to_string(),
+                rate_ppm: 0,
+                inclusive: false,
+                taxable_cents: line.amount_cents,
+                tax_cents: 99,
+            })
+            .collect())
+    }
+}
+
+#[tokio::test]
+async fn registered_calculator_replaces_the_rate_table() {
+    let mut ctx = ctx().await;
+    ctx.register_block(
+        TAX_CALCULATOR_BLOCK,
+        Arc::new(TaxCalculatorBlock::new(Arc::new(FlatCalculator))),
+    );
+    seed_product(&ctx, "p_1", 10.00).await;
+    create_rate(
+        &ctx,
+        serde_json::json!({"region": "DE", "rate": 19, "label": "VAT"}),
+    )
+    .await;
+
+    let body = buy(&ctx, "p_1", 2, "DE").await;
+    assert_eq!(body["tax_cents"], 99);
+    assert_eq!(body["total_cents"], 2099);
+    assert_eq!(body["tax_items"][0]["label"], "Flat");
+}
+
+// ============================================================
+// Refunds
+// ============================================================
+
+async fn seed_taxed_purchase(ctx: &TestContext, id: &str) {
+    let mut pd = HashMap::new();
+    pd.insert("user_id".to_string(), serde_json::json!("user_1"));
+    pd.insert("status".to_string(), serde_json::json!("completed"));
+    pd.insert("total_cents".to_string(), serde_json::json!(1200));
+    pd.insert("tax_cents".to_string(), serde_json::json!(200));
+    pd.insert(
+        "provider_payment_intent_id".to_string(),
+        serde_json::json!(format!("pi_{id}")),
+    );
+    seed(ctx, PURCHASES_TABLE, id, pd).await;
+}
+
+#[tokio::test]
+async fn admin_refund_returns_all_the_tax() {
+    let ctx = ctx().await;
+    seed_taxed_purchase(&ctx, "pur_tax").await;
+
+    let (mut msg, input) = create_msg(
+        "/admin/b/products/purchases/pur_tax/refund",
+        "admin_1",
+        serde_json::json!({}),
+    );
+    msg.set_meta("auth.user_roles", "admin");
+    let body = output_to_json(purchase::handle_refund(&ctx, &msg, input).await).await;
+    assert_eq!(body["data"]["refunded_cents"], 1200);
+    assert_eq!(body["data"]["refunded_tax_cents"], 200);
+}
+
+#[tokio::test]
+async fn partial_stripe_refund_takes_a_proportional_share_of_the_tax() {
+    let secret = "whsec_tax";
+    let ctx = ctx_with(&[("SUPPERS_AI__PRODUCTS__STRIPE_WEBHOOK_SECRET", secret)]).await;
+    seed_taxed_purchase(&ctx, "pur_part").await;
+
+    let event = serde_json::json!({
+        "type": "charge.refunded",
+        "data": {"object": {"payment_intent": "pi_pur_part", "amount_refunded": 300}},
+    });
+    let payload = serde_json::to_vec(&event).unwrap();
+    let timestamp = chrono::Utc::now().timestamp();
+    let signed = format!("{timestamp}.{}", String::from_utf8_lossy(&payload));
+    let sig = crate::util::hex_encode(&wafer_block_crypto::primitives::hmac_sha256(
+        secret.as_bytes(),
+        signed.as_bytes(),
+    ));
+    let mut msg = wafer_run::Message::new("http.request");
+    msg.set_meta("req.action", "create");
+    msg.set_meta("req.resource", "/b/products/webhooks");
+    msg.set_meta(
+        "http.header.stripe-signature",
+        &format!("t={timestamp},v1={sig}"),
+    );
+    let out = stripe::handle_webhook(&ctx, &msg, InputStream::from_bytes(payload)).await;
+    assert_eq!(output_to_json(out).await["received"], true);
+
+    // 200 * 300 / 1200 = 50.
+    let stored = repo::purchases::get(&ctx, "pur_part").await.unwrap();
+    assert_eq!(stored.i64_field("refunded_cents"), 300);
+    assert_eq!(stored.i64_field("refunded_tax_cents"), 50);
+}
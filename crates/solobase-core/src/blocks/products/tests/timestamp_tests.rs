@@ -0,0 +1,108 @@
+//! Timestamps round-trip through the database in the single
+//! `util::format_rfc3339` layout, and migration 006 rewrites legacy rows
+//! into it.
+//!
+//! These run on the SQLite test backend only — `TestContext` has no
+//! PostgreSQL service, so the Postgres half of migration 006 is covered by
+//! review rather than by a test here.
+
+use std::collections::HashMap;
+
+use wafer_core::clients::database as db;
+
+use super::harness::*;
+use crate::{
+    blocks::products::{migrations, PRODUCTS_TABLE},
+    util::{self, RecordExt},
+};
+
+/// Instants around the 2026 US and EU DST switches plus sub-second values,
+/// in chronological order. The fall-back pair is the same local wall time
+/// an hour apart.
+const MATRIX: &[&str] = &[
+    "2026-03-08T06:59:59.999999Z",
+    "2026-03-08T07:00:00Z",
+    "2026-03-29T00:59:59.5Z",
+    "2026-03-29T03:00:00+02:00",
+    "2026-11-01T01:30:00-04:00",
+    "2026-11-01T01:30:00-05:00",
+    "2026-12-31T23:59:59.123456Z",
+];
+
+#[tokio::test]
+async fn timestamps_round_trip_in_the_canonical_layout() {
+    let ctx = ctx_with(&[]).await;
+    let mut stored = Vec::new();
+    for (i, raw) in MATRIX.iter().enumerate() {
+        let t = util::parse_timestamp(raw).unwrap();
+        let stamp = util::format_rfc3339(t);
+        let id = format!("p{i}");
+
+        let mut product = HashMap::new();
+        product.insert("name".to_string(), serde_json::json!("Widget"));
+        product.insert("created_at".to_string(), serde_json::json!(&stamp));
+        product.insert("updated_at".to_string(), serde_json::json!(&stamp));
+        seed(&ctx, PRODUCTS_TABLE, &id, product).await;
+
+        let row = db::get(&ctx, PRODUCTS_TABLE, &id).await.unwrap();
+        assert_eq!(row.str_field("created_at"), stamp, "{raw}");
+        assert_eq!(util::parse_timestamp(row.str_field("created_at")), Some(t));
+        stored.push(stamp);
+    }
+
+    // Text order is time order, which the `<` / `>` string filters rely on.
+    let mut sorted = stored.clone();
+    sorted.sort();
+    assert_eq!(sorted, stored);
+}
+
+#[tokio::test]
+async fn migration_normalizes_legacy_rows() {
+    let ctx = ctx_with(&[]).await;
+    let legacy = [
+        ("a", "2026-07-11 19:13:45"),
+        ("b", "2026-07-11T21:13:45.25+02:00"),
+        ("c", "2026-07-11T19:13:45.123456789+00:00"),
+        ("d", "not a date"),
+    ];
+    for (id, stamp) in legacy {
+        db::exec_raw(
+            &ctx,
+            &format!(
+                "INSERT INTO {PRODUCTS_TABLE} (id, name, created_at, updated_at) \
+                 VALUES (?, 'Widget', ?, ?)"
+            ),
+            &[
+                serde_json::json!(id),
+                serde_json::json!(stamp),
+                serde_json::json!(stamp),
+            ],
+        )
+        .await
+        .unwrap();
+    }
+
+    let (_, sql) = migrations::SQLITE_MIGRATIONS
+        .iter()
+        .find(|(name, _)| *name == "006_normalize_timestamps")
+        .unwrap();
+    // Run it twice: already-canonical rows must be left alone.
+    for _ in 0..2 {
+        for stmt in sql.split(';').filter(|s| s.contains("UPDATE")) {
+            db::exec_raw(&ctx, stmt, &[]).await.unwrap();
+        }
+    }
+
+    let expect = [
+        ("a", "2026-07-11T19:13:45.000000Z"),
+        ("b", "2026-07-11T19:13:45.250000Z"),
+        // SQLite keeps milliseconds; the rest is padded.
+        ("c", "2026-07-11T19:13:45.123000Z"),
+        ("d", "not a date"),
+    ];
+    for (id, want) in expect {
+        let row = db::get(&ctx, PRODUCTS_TABLE, id).await.unwrap();
+        assert_eq!(row.str_field("created_at"), want, "{id}");
+        assert_eq!(row.str_field("updated_at"), want, "{id}");
+    }
+}
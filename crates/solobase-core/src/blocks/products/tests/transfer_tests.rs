@@ -0,0 +1,212 @@
+use std::collections::HashMap;
+
+use wafer_core::clients::database as db;
+
+use super::harness::*;
+use crate::{
+    blocks::products::{PRICING_TABLE, PRODUCT_TEMPLATES_TABLE, VARIABLES_TABLE},
+    test_support::{output_status, TestContext},
+    util::RecordExt,
+};
+
+async fn seed_variable(ctx: &TestContext, id: &str, name: &str, product_id: &str) {
+    let mut data = HashMap::new();
+    data.insert("name".to_string(), serde_json::json!(name));
+    data.insert("var_type".to_string(), serde_json::json!("number"));
+    data.insert("default_value".to_string(), serde_json::json!("1"));
+    data.insert("scope".to_string(), serde_json::json!("system"));
+    data.insert("product_id".to_string(), serde_json::json!(product_id));
+    seed(ctx, VARIABLES_TABLE, id, data).await;
+}
+
+async fn seed_pricing(ctx: &TestContext, id: &str, name: &str, formula: &str) {
+    let mut data = HashMap::new();
+    data.insert("name".to_string(), serde_json::json!(name));
+    data.insert("price_formula".to_string(), serde_json::json!(formula));
+    seed(ctx, PRICING_TABLE, id, data).await;
+}
+
+async fn export(ctx: &TestContext) -> serde_json::Value {
+    let (msg, input) = admin_get_msg("/admin/b/products/export");
+    output_to_json(dispatch_admin(ctx, msg, input).await).await
+}
+
+async fn import(ctx: &TestContext, query: &str, doc: serde_json::Value) -> serde_json::Value {
+    let (mut msg, input) = admin_create_msg("/admin/b/products/import", doc);
+    if !query.is_empty() {
+        msg.set_meta("req.query.dry_run", query);
+    }
+    output_to_json(dispatch_admin(ctx, msg, input).await).await
+}
+
+fn action_of<'a>(report: &'a serde_json::Value, kind: &str, name: &str) -> &'a str {
+    report["items"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .find(|i| i["kind"] == kind && i["name"] == name)
+        .and_then(|i| i["action"].as_str())
+        .unwrap_or_else(|| panic!("{kind} {name} missing from {report}"))
+}
+
+async fn names(ctx: &TestContext, table: &str) -> Vec<String> {
+    let mut names: Vec<String> = db::list_all(ctx, table, vec![])
+        .await
+        .unwrap()
+        .iter()
+        .map(|r| r.str_field("name").to_string())
+        .collect();
+    names.sort();
+    names
+}
+
+#[tokio::test]
+async fn export_names_references_and_leaves_out_user_data() {
+    let ctx = ctx().await;
+    seed_variable(&ctx, "v1", "seats", "").await;
+    seed_variable(&ctx, "v2", "engraving", "prod_1").await;
+    seed_pricing(&ctx, "p1", "per-seat", "seats * 12").await;
+
+    let doc = export(&ctx).await;
+    assert_eq!(doc["format"], "suppers-ai/products.config");
+    assert_eq!(doc["version"], 1);
+    assert_eq!(doc["variables"].as_array().unwrap().len(), 1);
+    assert_eq!(doc["variables"][0]["name"], "seats");
+    assert_eq!(
+        doc["pricing_templates"][0]["variables"],
+        serde_json::json!(["seats"])
+    );
+    assert_eq!(doc["product_templates"][0]["name"], "default");
+    assert!(!doc.to_string().contains("\"id\""), "ids leaked: {doc}");
+}
+
+#[tokio::test]
+async fn export_round_trips_into_another_instance() {
+    let staging = ctx().await;
+    seed_variable(&staging, "v1", "seats", "").await;
+    seed_pricing(&staging, "p1", "per-seat", "seats * 12").await;
+    let doc = export(&staging).await;
+
+    let prod = ctx().await;
+    let report = import(&prod, "", doc.clone()).await;
+    assert_eq!(report["dry_run"], false);
+    assert_eq!(action_of(&report, "variable", "seats"), "created");
+    assert_eq!(
+        action_of(&report, "pricing_template", "per-seat"),
+        "created"
+    );
+    assert_eq!(action_of(&report, "group_template", "default"), "skipped");
+    let formula = db::get_by_field(&prod, PRICING_TABLE, "name", serde_json::json!("per-seat"))
+        .await
+        .unwrap();
+    assert_eq!(formula.str_field("price_formula"), "seats * 12");
+
+    // A second run finds nothing to do.
+    let again = import(&prod, "", doc).await;
+    assert_eq!(
+        (again["created"].as_i64(), again["updated"].as_i64()),
+        (Some(0), Some(0))
+    );
+    assert_eq!(again["items"][0]["reason"], "unchanged");
+}
+
+#[tokio::test]
+async fn dry_run_reports_the_plan_without_writing() {
+    let ctx = ctx().await;
+    let doc = serde_json::json!({
+        "format": "suppers-ai/products.config",
+        "version": 1,
+        "product_templates": [
+            {"name": "default", "display_name": "Standard"},
+            {"name": "bundle", "display_name": "Bundle"}
+        ]
+    });
+
+    let report = import(&ctx, "true", doc).await;
+    assert_eq!(report["dry_run"], true);
+    assert_eq!(action_of(&report, "product_template", "default"), "updated");
+    assert_eq!(
+        report["items"][0]["changes"],
+        serde_json::json!(["display_name"])
+    );
+    assert_eq!(action_of(&report, "product_template", "bundle"), "created");
+    assert_eq!(names(&ctx, PRODUCT_TEMPLATES_TABLE).await, ["default"]);
+    let default = db::get(&ctx, PRODUCT_TEMPLATES_TABLE, "default")
+        .await
+        .unwrap();
+    assert_eq!(default.str_field("display_name"), "Default");
+}
+
+#[tokio::test]
+async fn import_updates_by_name_and_never_deletes() {
+    let ctx = ctx().await;
+    seed_variable(&ctx, "v1", "seats", "").await;
+    seed_pricing(&ctx, "p1", "legacy", "seats * 5").await;
+    let doc = serde_json::json!({
+        "format": "suppers-ai/products.config",
+        "version": 1,
+        "variables": [{"name": "seats", "var_type": "number", "default_value": "10"}],
+    });
+
+    let report = import(&ctx, "", doc).await;
+    assert_eq!(action_of(&report, "variable", "seats"), "updated");
+    let seats = db::get(&ctx, VARIABLES_TABLE, "v1").await.unwrap();
+    assert_eq!(seats.str_field("default_value"), "10");
+    assert_eq!(names(&ctx, PRICING_TABLE).await, ["legacy"]);
+}
+
+#[tokio::test]
+async fn unresolved_and_ambiguous_items_are_skipped_with_a_reason() {
+    let ctx = ctx().await;
+    seed_pricing(&ctx, "p1", "twin", "2").await;
+    seed_pricing(&ctx, "p2", "twin", "3").await;
+    let doc = serde_json::json!({
+        "format": "suppers-ai/products.config",
+        "version": 1,
+        "pricing_templates": [
+            {"name": "twin", "price_formula": "4"},
+            {"name": "per-mile", "price_formula": "miles * 2"}
+        ]
+    });
+
+    let report = import(&ctx, "", doc).await;
+    assert_eq!(report["skipped"], 2);
+    assert_eq!(
+        report["items"][0]["reason"],
+        "2 existing rows share this name; rename the duplicates first"
+    );
+    assert_eq!(
+        report["items"][1]["reason"],
+        "price_formula reads unknown variable(s): miles"
+    );
+    assert_eq!(names(&ctx, PRICING_TABLE).await, ["twin", "twin"]);
+}
+
+#[tokio::test]
+async fn invalid_documents_are_rejected_whole() {
+    let ctx = ctx().await;
+    let doc = serde_json::json!({
+        "format": "suppers-ai/products.config",
+        "version": 1,
+        "variables": [{"name": "seats"}],
+        "pricing_templates": [
+            {"name": "ok", "price_formula": "seats"},
+            {"name": "broken", "price_formula": "seats *"}
+        ]
+    });
+    let (msg, input) = admin_create_msg("/admin/b/products/import", doc);
+    assert_eq!(
+        output_status(dispatch_admin(&ctx, msg, input).await).await,
+        400
+    );
+    assert!(names(&ctx, VARIABLES_TABLE).await.is_empty());
+
+    let (msg, input) = admin_create_msg(
+        "/admin/b/products/import",
+        serde_json::json!({"format": "something-else", "version": 1}),
+    );
+    assert_eq!(
+        output_status(dispatch_admin(&ctx, msg, input).await).await,
+        400
+    );
+}
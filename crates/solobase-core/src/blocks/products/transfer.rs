@@ -0,0 +1,528 @@
+//! Configuration export/import, for promoting a products setup from one
+//! environment to another (e.g. staging to production).
+//!
+//! - `GET  /b/products/api/admin/export` returns one JSON document holding
+//!   every variable, group template, product template and pricing template.
+//!   Rows carry no ids: each is identified by its `name`, and a pricing
+//!   template lists the variables its formula reads by name.
+//! - `POST /b/products/api/admin/import` validates such a document and
+//!   merges it by name: a row whose `name` matches exactly one existing row
+//!   updates it, an unmatched row is created, and rows absent from the
+//!   document are never deleted. The response reports every item as
+//!   created, updated or skipped (with the reason). `?dry_run=true` returns
+//!   the same report without writing.
+//!
+//! Products, groups, purchases and product-scoped variables are user data
+//! and stay out of the document.
+//!
+//! The database client exposes no multi-statement transaction, so the
+//! import is all-or-nothing the way `purchase::rollback_purchase` is: the
+//! whole document is validated and planned before the first write, and if a
+//! write fails the ones already applied are undone (created rows deleted,
+//! updated rows restored) before the error is returned.
+
+use std::collections::{HashMap, HashSet};
+
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, InputStream, Message, OutputStream, WaferError};
+
+use super::{
+    pricing, GROUP_TEMPLATES_TABLE, PRICING_TABLE, PRODUCT_TEMPLATES_TABLE, VARIABLES_TABLE,
+};
+use crate::{
+    cache,
+    http::{err_bad_request, err_internal, ok_json},
+    util::{now_rfc3339, stamp_created, stamp_updated, RecordExt},
+};
+
+/// `format` of an export document.
+pub(crate) const FORMAT: &str = "suppers-ai/products.config";
+
+/// Document version this build reads and writes.
+pub(crate) const VERSION: u64 = 1;
+
+#[derive(serde::Serialize, serde::Deserialize)]
+struct Document {
+    format: String,
+    version: u64,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    exported_at: String,
+    #[serde(default)]
+    variables: Vec<VariableDef>,
+    #[serde(default)]
+    group_templates: Vec<TemplateDef>,
+    #[serde(default)]
+    product_templates: Vec<TemplateDef>,
+    #[serde(default)]
+    pricing_templates: Vec<PricingDef>,
+}
+
+#[derive(serde::Serialize, serde::Deserialize)]
+struct VariableDef {
+    name: String,
+    #[serde(default = "default_var_type")]
+    var_type: String,
+    #[serde(default)]
+    default_value: serde_json::Value,
+    #[serde(default = "default_scope")]
+    scope: String,
+}
+
+fn default_var_type() -> String {
+    "number".to_string()
+}
+
+fn default_scope() -> String {
+    "system".to_string()
+}
+
+#[derive(serde::Serialize, serde::Deserialize)]
+struct TemplateDef {
+    name: String,
+    #[serde(default)]
+    display_name: String,
+}
+
+#[derive(serde::Serialize, serde::Deserialize)]
+struct PricingDef {
+    name: String,
+    price_formula: String,
+    #[serde(default = "empty_object")]
+    template_data: serde_json::Value,
+    /// Variables `price_formula` reads. Written for readers of the document;
+    /// import derives them from the formula itself.
+    #[serde(default)]
+    variables: Vec<String>,
+}
+
+fn empty_object() -> serde_json::Value {
+    serde_json::json!({})
+}
+
+pub async fn handle_export(ctx: &dyn Context) -> OutputStream {
+    let mut doc = Document {
+        format: FORMAT.to_string(),
+        version: VERSION,
+        exported_at: now_rfc3339(),
+        variables: Vec::new(),
+        group_templates: Vec::new(),
+        product_templates: Vec::new(),
+        pricing_templates: Vec::new(),
+    };
+
+    let variables = match sorted_rows(ctx, VARIABLES_TABLE).await {
+        Ok(rows) => rows,
+        Err(e) => return err_internal("Database error", e),
+    };
+    doc.variables = variables
+        .iter()
+        .filter(|r| r.str_field("product_id").is_empty())
+        .map(|r| VariableDef {
+            name: r.str_field("name").to_string(),
+            var_type: r.str_field("var_type").to_string(),
+            default_value: r
+                .data
+                .get("default_value")
+                .cloned()
+                .unwrap_or(serde_json::Value::Null),
+            scope: r.str_field("scope").to_string(),
+        })
+        .collect();
+
+    for (table, out) in [
+        (GROUP_TEMPLATES_TABLE, &mut doc.group_templates),
+        (PRODUCT_TEMPLATES_TABLE, &mut doc.product_templates),
+    ] {
+        match sorted_rows(ctx, table).await {
+            Ok(rows) => {
+                *out = rows
+                    .iter()
+                    .map(|r| TemplateDef {
+                        name: r.str_field("name").to_string(),
+                        display_name: r.str_field("display_name").to_string(),
+                    })
+                    .collect()
+            }
+            Err(e) => return err_internal("Database error", e),
+        }
+    }
+
+    let pricing_rows = match sorted_rows(ctx, PRICING_TABLE).await {
+        Ok(rows) => rows,
+        Err(e) => return err_internal("Database error", e),
+    };
+    doc.pricing_templates = pricing_rows
+        .iter()
+        .map(|r| {
+            let formula = r.str_field("price_formula").to_string();
+            PricingDef {
+                name: r.str_field("name").to_string(),
+                // A stored formula that no longer parses is exported as-is;
+                // the import validation reports it.
+                variables: pricing::formula_variables(&formula).unwrap_or_default(),
+                price_formula: formula,
+                template_data: r
+                    .data
+                    .get("template_data")
+                    .map(canonical)
+                    .unwrap_or_else(empty_object),
+            }
+        })
+        .collect();
+
+    ok_json(&doc)
+}
+
+async fn sorted_rows(ctx: &dyn Context, table: &str) -> Result<Vec<Record>, WaferError> {
+    let mut rows = db::list_all(ctx, table, vec![]).await?;
+    rows.sort_by(|a, b| a.str_field("name").cmp(b.str_field("name")));
+    Ok(rows)
+}
+
+pub async fn handle_import(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let dry_run = matches!(msg.query("dry_run"), "true" | "1");
+    let raw = input.collect_to_bytes().await;
+    let doc: Document = match serde_json::from_slice(&raw) {
+        Ok(d) => d,
+        Err(e) => return err_bad_request(&format!("Invalid document: {e}")),
+    };
+    let items = match validate(doc) {
+        Ok(items) => items,
+        Err(errors) => return err_bad_request(&errors.join("; ")),
+    };
+    let plan = match plan(ctx, items).await {
+        Ok(plan) => plan,
+        Err(e) => return err_internal("Database error", e),
+    };
+    if !dry_run {
+        if let Err(e) = apply(ctx, &plan).await {
+            return err_internal("Import failed; applied changes were rolled back", e);
+        }
+    }
+    ok_json(&report(&plan, dry_run))
+}
+
+/// One row of the document, as the columns it should have.
+struct Item {
+    kind: &'static str,
+    table: &'static str,
+    name: String,
+    /// Column values to write, `name` excluded.
+    fields: HashMap<String, serde_json::Value>,
+    /// Variable names a pricing formula reads.
+    references: Vec<String>,
+}
+
+impl Item {
+    fn new(kind: &'static str, table: &'static str, name: &str) -> Self {
+        Self {
+            kind,
+            table,
+            name: name.trim().to_string(),
+            fields: HashMap::new(),
+            references: Vec::new(),
+        }
+    }
+
+    fn field(mut self, key: &str, value: serde_json::Value) -> Self {
+        self.fields.insert(key.to_string(), text(value));
+        self
+    }
+}
+
+/// Check the whole document up front, returning its items in apply order
+/// (variables before the pricing templates that read them) or every problem
+/// found.
+fn validate(doc: Document) -> Result<Vec<Item>, Vec<String>> {
+    let mut errors = Vec::new();
+    if doc.format != FORMAT {
+        errors.push(format!("format must be \"{FORMAT}\""));
+    }
+    if doc.version != VERSION {
+        errors.push(format!(
+            "unsupported version {} (expected {VERSION})",
+            doc.version
+        ));
+    }
+
+    let mut items = Vec::new();
+    for v in doc.variables {
+        items.push(
+            Item::new("variable", VARIABLES_TABLE, &v.name)
+                .field("var_type", v.var_type.into())
+                .field("default_value", v.default_value)
+                .field("scope", v.scope.into()),
+        );
+    }
+    for t in doc.group_templates {
+        items.push(
+            Item::new("group_template", GROUP_TEMPLATES_TABLE, &t.name)
+                .field("display_name", t.display_name.into()),
+        );
+    }
+    for t in doc.product_templates {
+        items.push(
+            Item::new("product_template", PRODUCT_TEMPLATES_TABLE, &t.name)
+                .field("display_name", t.display_name.into()),
+        );
+    }
+    for p in doc.pricing_templates {
+        let mut item = Item::new("pricing_template", PRICING_TABLE, &p.name);
+        match pricing::formula_variables(&p.price_formula) {
+            Ok(names) => item.references = names,
+            Err(e) => errors.push(format!(
+                "pricing_template \"{}\": invalid price_formula: {e}",
+                item.name
+            )),
+        }
+        items.push(
+            item.field("price_formula", p.price_formula.into())
+                .field("template_data", p.template_data),
+        );
+    }
+
+    let mut seen = HashSet::new();
+    for item in &items {
+        if item.name.is_empty() {
+            errors.push(format!("{}: name is required", item.kind));
+        } else if !seen.insert((item.kind, item.name.as_str())) {
+            errors.push(format!(
+                "{} \"{}\" appears more than once",
+                item.kind, item.name
+            ));
+        }
+    }
+
+    if errors.is_empty() {
+        Ok(items)
+    } else {
+        Err(errors)
+    }
+}
+
+enum Step {
+    Create,
+    Update {
+        id: String,
+        /// Changed columns, sorted.
+        changes: Vec<String>,
+        /// The changed columns' current values, for rollback.
+        previous: HashMap<String, serde_json::Value>,
+    },
+    Skip(String),
+}
+
+struct Planned {
+    item: Item,
+    step: Step,
+}
+
+/// Decide what happens to each item against the current rows.
+async fn plan(ctx: &dyn Context, items: Vec<Item>) -> Result<Vec<Planned>, WaferError> {
+    let mut existing: HashMap<&str, Vec<Record>> = HashMap::new();
+    for table in [
+        VARIABLES_TABLE,
+        GROUP_TEMPLATES_TABLE,
+        PRODUCT_TEMPLATES_TABLE,
+        PRICING_TABLE,
+    ] {
+        existing.insert(table, db::list_all(ctx, table, vec![]).await?);
+    }
+
+    // A formula may read any variable the target will have once the import
+    // lands, product-scoped ones included.
+    let known_variables: HashSet<String> = existing[VARIABLES_TABLE]
+        .iter()
+        .map(|r| r.str_field("name").to_string())
+        .chain(
+            items
+                .iter()
+                .filter(|i| i.table == VARIABLES_TABLE)
+                .map(|i| i.name.clone()),
+        )
+        .collect();
+
+    Ok(items
+        .into_iter()
+        .map(|item| {
+            let unknown: Vec<&str> = item
+                .references
+                .iter()
+                .filter(|name| !known_variables.contains(*name))
+                .map(String::as_str)
+                .collect();
+            let step = if !unknown.is_empty() {
+                Step::Skip(format!(
+                    "price_formula reads unknown variable(s): {}",
+                    unknown.join(", ")
+                ))
+            } else {
+                let matches: Vec<&Record> = existing[item.table]
+                    .iter()
+                    .filter(|r| r.str_field("name") == item.name)
+                    // Only global variables are configuration.
+                    .filter(|r| {
+                        item.table != VARIABLES_TABLE || r.str_field("product_id").is_empty()
+                    })
+                    .collect();
+                step_for(&item, &matches)
+            };
+            Planned { item, step }
+        })
+        .collect())
+}
+
+fn step_for(item: &Item, matches: &[&Record]) -> Step {
+    let row = match matches {
+        [] => return Step::Create,
+        [row] => row,
+        rows => {
+            return Step::Skip(format!(
+                "{} existing rows share this name; rename the duplicates first",
+                rows.len()
+            ))
+        }
+    };
+    let null = serde_json::Value::Null;
+    let mut changes: Vec<String> = item
+        .fields
+        .iter()
+        .filter(|(k, v)| canonical(row.data.get(*k).unwrap_or(&null)) != canonical(v))
+        .map(|(k, _)| k.clone())
+        .collect();
+    if changes.is_empty() {
+        return Step::Skip("unchanged".to_string());
+    }
+    changes.sort();
+    let previous = changes
+        .iter()
+        .map(String::as_str)
+        .chain(["updated_at"])
+        .map(|k| (k.to_string(), row.data.get(k).cloned().unwrap_or_default()))
+        .collect();
+    Step::Update {
+        id: row.id.clone(),
+        changes,
+        previous,
+    }
+}
+
+/// A write `apply` made, and how to take it back.
+enum Undo {
+    Delete(&'static str, String),
+    Restore(&'static str, String, HashMap<String, serde_json::Value>),
+}
+
+async fn apply(ctx: &dyn Context, plan: &[Planned]) -> Result<(), WaferError> {
+    let mut undo = Vec::new();
+    for Planned { item, step } in plan {
+        let result = match step {
+            Step::Create => {
+                let mut data = item.fields.clone();
+                data.insert("name".to_string(), item.name.clone().into());
+                stamp_created(&mut data);
+                db::create(ctx, item.table, data)
+                    .await
+                    .map(|r| undo.push(Undo::Delete(item.table, r.id)))
+            }
+            Step::Update {
+                id,
+                changes,
+                previous,
+            } => {
+                let mut data: HashMap<String, serde_json::Value> = changes
+                    .iter()
+                    .map(|k| (k.clone(), item.fields[k].clone()))
+                    .collect();
+                stamp_updated(&mut data);
+                db::update(ctx, item.table, id, data)
+                    .await
+                    .map(|_| undo.push(Undo::Restore(item.table, id.clone(), previous.clone())))
+            }
+            Step::Skip(_) => Ok(()),
+        };
+        if let Err(e) = result {
+            rollback(ctx, undo).await;
+            invalidate_caches(plan);
+            return Err(e);
+        }
+    }
+    invalidate_caches(plan);
+    Ok(())
+}
+
+/// Drop cached reads of every table `plan` writes to.
+fn invalidate_caches(plan: &[Planned]) {
+    for Planned { item, .. } in plan {
+        cache::invalidate_table(item.table);
+    }
+}
+
+/// Undo applied writes, newest first. Best-effort: a failure is logged and
+/// the rest are still attempted.
+async fn rollback(ctx: &dyn Context, undo: Vec<Undo>) {
+    for step in undo.into_iter().rev() {
+        let (table, id, result) = match step {
+            Undo::Delete(table, id) => {
+                let r = db::delete(ctx, table, &id).await;
+                (table, id, r)
+            }
+            Undo::Restore(table, id, previous) => {
+                let r = db::update(ctx, table, &id, previous).await.map(|_| ());
+                (table, id, r)
+            }
+        };
+        if let Err(e) = result {
+            tracing::error!(error = %e, table = %table, id = %id, "products import rollback failed");
+        }
+    }
+}
+
+fn report(plan: &[Planned], dry_run: bool) -> serde_json::Value {
+    let mut counts = HashMap::from([("created", 0), ("updated", 0), ("skipped", 0)]);
+    let items: Vec<serde_json::Value> = plan
+        .iter()
+        .map(|Planned { item, step }| {
+            let mut entry = serde_json::json!({"kind": item.kind, "name": item.name});
+            let action = match step {
+                Step::Create => "created",
+                Step::Update { changes, .. } => {
+                    entry["changes"] = serde_json::json!(changes);
+                    "updated"
+                }
+                Step::Skip(reason) => {
+                    entry["reason"] = serde_json::json!(reason);
+                    "skipped"
+                }
+            };
+            entry["action"] = serde_json::json!(action);
+            *counts.entry(action).or_default() += 1;
+            entry
+        })
+        .collect();
+    serde_json::json!({
+        "dry_run": dry_run,
+        "created": counts["created"],
+        "updated": counts["updated"],
+        "skipped": counts["skipped"],
+        "items": items,
+    })
+}
+
+/// A value as written to a TEXT column: JSON objects and numbers are
+/// stored serialized, strings and null as they are.
+fn text(value: serde_json::Value) -> serde_json::Value {
+    match value {
+        serde_json::Value::Null | serde_json::Value::String(_) => value,
+        other => serde_json::Value::String(other.to_string()),
+    }
+}
+
+/// A TEXT column value in comparable form: strings holding JSON are parsed,
+/// so `"{}"` read back from the database equals a `{}` in the document.
+fn canonical(value: &serde_json::Value) -> serde_json::Value {
+    match value {
+        serde_json::Value::String(s) => serde_json::from_str(s).unwrap_or_else(|_| value.clone()),
+        other => other.clone(),
+    }
+}
@@ -66,16 +66,35 @@ impl RateLimit {
         max_requests: 60,
         window: Duration::from_secs(60),
     };
+    /// Inbound webhook deliveries: 60 requests per 60 seconds per endpoint.
+    pub const INBOUND_WEBHOOK: Self = Self {
+        max_requests: 60,
+        window: Duration::from_secs(60),
+    };
+    /// Admin-issued password resets: 5 per hour per target user.
+    pub const PASSWORD_RESET: Self = Self {
+        max_requests: 5,
+        window: Duration::from_secs(3600),
+    };
 
     /// Read config override for this rate limit category.
     ///
     /// Looks up `RATE_LIMIT_{name}` in config. Format: `requests/seconds` (e.g. `50/60`).
     /// Set to `0` to disable rate limiting for this category.
-    /// Returns `None` if disabled, otherwise the resolved limit.
+    /// Returns `None` if disabled, otherwise the resolved limit scaled by the
+    /// live `SOLOBASE_RATE_LIMIT_MULTIPLIER` (see [`crate::runtime_config`]).
     pub async fn resolve(self, ctx: &dyn Context, name: &str) -> Option<Self> {
         let key = format!("SOLOBASE_SHARED__RATE_LIMIT_{}", name.to_uppercase());
         let default = format!("{}/{}", self.max_requests, self.window.as_secs());
         let value = config::get_default(ctx, &key, &default).await;
+        self.parse(&value)
+    }
+
+    /// Read a `requests/seconds` (or bare `requests`) value, keeping `self`'s
+    /// part wherever `value`'s doesn't parse. `None` when it disables the
+    /// category. Scaled like [`Self::resolve`].
+    pub fn parse(self, value: &str) -> Option<Self> {
+        let runtime = crate::runtime_config::load();
 
         // "0" disables this category
         if value.trim() == "0" {
@@ -92,7 +111,7 @@ impl RateLimit {
                 .parse::<u64>()
                 .unwrap_or(self.window.as_secs());
             Some(Self {
-                max_requests: max,
+                max_requests: runtime.scale_rate_limit(max),
                 window: Duration::from_secs(secs),
             })
         } else {
@@ -102,7 +121,7 @@ impl RateLimit {
                 return None;
             }
             Some(Self {
-                max_requests: max,
+                max_requests: runtime.scale_rate_limit(max),
                 window: self.window,
             })
         }
@@ -369,6 +388,36 @@ pub enum RateLimitOutcome {
     Limited(OutputStream),
 }
 
+/// True when `value` is a well-formed [`RateLimit::parse`] value: `0`,
+/// `requests`, or `requests/seconds` with a non-zero window.
+pub fn is_valid_limit(value: &str) -> bool {
+    let value = value.trim();
+    match value.split_once('/') {
+        Some((max, secs)) => {
+            max.trim().parse::<u32>().is_ok()
+                && secs.trim().parse::<u64>().is_ok_and(|secs| secs > 0)
+        }
+        None => value.parse::<u32>().is_ok(),
+    }
+}
+
+/// Meta key carrying the caller's own per-category limits — a JSON object
+/// of [`RateLimit::parse`] values keyed by category. Set by
+/// `auth::authenticate_api_key` for service accounts that have overrides;
+/// a category it names replaces the `RATE_LIMIT_*` config for user-keyed
+/// buckets.
+pub const META_RATE_LIMITS: &str = "auth.rate_limits";
+
+/// The caller's override for `category`, from [`META_RATE_LIMITS`].
+fn caller_override(msg: &wafer_run::Message, category: &str) -> Option<String> {
+    let raw = msg.get_meta(META_RATE_LIMITS);
+    if raw.is_empty() {
+        return None;
+    }
+    let overrides: serde_json::Value = serde_json::from_str(raw).ok()?;
+    overrides.get(category)?.as_str().map(str::to_owned)
+}
+
 /// Check a per-user/identity rate limit and return an `OutputStream` if blocked,
 /// or rate-limit headers to attach to the success response.
 pub async fn check_rate_limit(
@@ -378,7 +427,34 @@ pub async fn check_rate_limit(
     category: &str,
     default: RateLimit,
 ) -> RateLimitOutcome {
-    let Some(limit) = default.resolve(ctx, category).await else {
+    let limit = default.resolve(ctx, category).await;
+    check_resolved(limiter, ctx, identity, category, limit).await
+}
+
+/// As [`check_rate_limit`] for a bucket keyed by the caller's user id, where
+/// the caller's [`META_RATE_LIMITS`] override wins over config.
+async fn check_caller_rate_limit(
+    limiter: &UserRateLimiter,
+    ctx: &dyn wafer_run::context::Context,
+    msg: &wafer_run::Message,
+    category: &str,
+    default: RateLimit,
+) -> RateLimitOutcome {
+    let limit = match caller_override(msg, category) {
+        Some(value) => default.parse(&value),
+        None => default.resolve(ctx, category).await,
+    };
+    check_resolved(limiter, ctx, msg.user_id(), category, limit).await
+}
+
+async fn check_resolved(
+    limiter: &UserRateLimiter,
+    ctx: &dyn wafer_run::context::Context,
+    identity: &str,
+    category: &str,
+    limit: Option<RateLimit>,
+) -> RateLimitOutcome {
+    let Some(limit) = limit else {
         return RateLimitOutcome::Disabled;
     };
     let key = UserRateLimiter::key(identity, category);
@@ -417,8 +493,7 @@ pub async fn check_user_rate_limit_with(
     msg: &wafer_run::Message,
     create_override: Option<(RateLimit, &str)>,
 ) -> RateLimitOutcome {
-    let user_id = msg.user_id().to_string();
-    if user_id.is_empty() {
+    if msg.user_id().is_empty() {
         return RateLimitOutcome::Disabled;
     }
     let action = msg.action();
@@ -427,7 +502,7 @@ pub async fn check_user_rate_limit_with(
         "create" => create_override.unwrap_or((RateLimit::API_WRITE, "api_write")),
         _ => (RateLimit::API_WRITE, "api_write"),
     };
-    check_rate_limit(limiter, ctx, &user_id, category, default).await
+    check_caller_rate_limit(limiter, ctx, msg, category, default).await
 }
 
 /// The identity an IP-keyed rate-limit bucket uses for a request: the remote
@@ -482,17 +557,17 @@ pub async fn check_route_limits(
     rules: &[RouteLimit],
 ) -> Option<RateLimitOutcome> {
     let rule = rules.iter().find(|r| (r.matches)(action, path))?;
-    let identity = match rule.key {
-        LimitKey::Ip => ip_identity(msg),
+    Some(match rule.key {
+        LimitKey::Ip => {
+            check_rate_limit(limiter, ctx, &ip_identity(msg), rule.category, rule.limit).await
+        }
         LimitKey::User => {
-            let uid = msg.user_id();
-            if uid.is_empty() {
+            if msg.user_id().is_empty() {
                 return None;
             }
-            uid.to_string()
+            check_caller_rate_limit(limiter, ctx, msg, rule.category, rule.limit).await
         }
-    };
-    Some(check_rate_limit(limiter, ctx, &identity, rule.category, rule.limit).await)
+    })
 }
 
 #[cfg(test)]
@@ -618,6 +693,7 @@ mod tests {
         assert_eq!(RateLimit::API_READ.max_requests, 300);
         assert_eq!(RateLimit::API_WRITE.max_requests, 120);
         assert_eq!(RateLimit::UPLOAD.max_requests, 60);
+        assert_eq!(RateLimit::INBOUND_WEBHOOK.max_requests, 60);
     }
 
     #[tokio::test]
@@ -729,6 +805,69 @@ mod tests {
         .is_none());
     }
 
+    async fn update_me(limiter: &UserRateLimiter, msg: &Message) -> Option<RateLimitOutcome> {
+        check_route_limits(
+            limiter,
+            &TestCtx,
+            msg,
+            "update",
+            "/auth/api/me",
+            TEST_ROUTES,
+        )
+        .await
+    }
+
+    #[tokio::test]
+    async fn caller_overrides_replace_the_category_limit() {
+        let ctx = TestCtx;
+        let limiter = UserRateLimiter::new();
+        let mut sa = msg_with("update", "sa_1", "");
+        sa.set_meta(
+            META_RATE_LIMITS,
+            r#"{"auth_write": "1/60", "api_read": "0"}"#,
+        );
+        assert!(matches!(
+            update_me(&limiter, &sa).await,
+            Some(RateLimitOutcome::Allowed(_))
+        ));
+        assert!(matches!(
+            update_me(&limiter, &sa).await,
+            Some(RateLimitOutcome::Limited(_))
+        ));
+
+        // Another caller keeps the rule's own limit of two.
+        let user = msg_with("update", "u1", "");
+        assert!(matches!(
+            update_me(&limiter, &user).await,
+            Some(RateLimitOutcome::Allowed(_))
+        ));
+        assert!(matches!(
+            update_me(&limiter, &user).await,
+            Some(RateLimitOutcome::Allowed(_))
+        ));
+
+        // "0" turns the category off for that caller.
+        let read = {
+            let mut m = msg_with("retrieve", "sa_1", "");
+            m.set_meta(META_RATE_LIMITS, r#"{"api_read": "0"}"#);
+            m
+        };
+        assert!(matches!(
+            check_user_rate_limit(&limiter, &ctx, &read).await,
+            RateLimitOutcome::Disabled
+        ));
+    }
+
+    #[test]
+    fn limit_values_are_validated() {
+        for ok in ["0", "50", "50/60", " 10 / 1 "] {
+            assert!(is_valid_limit(ok), "{ok}");
+        }
+        for bad in ["", "-1", "ten", "10/0", "10/", "/60"] {
+            assert!(!is_valid_limit(bad), "{bad}");
+        }
+    }
+
     // -- decide_rate_limit (wasm32 D1-backend decision logic) --------------
     //
     // `UserRateLimiter::check`'s wasm32 arm only compiles under
@@ -0,0 +1,793 @@
+//! Data retention: how long each kind of accumulating row is kept, and the
+//! runner that deletes what has aged out.
+//!
+//! Every kind of data is one policy in [`POLICIES`], with a day count and
+//! an enabled flag. An admin changes them through `GET|PUT
+//! /b/admin/api/retention`; a changed policy is stored as a row in
+//! `suppers_ai__admin__retention_policies`, and a policy without a row
+//! uses its built-in defaults. Each policy has a floor (`min_days`) so an
+//! audit trail can't be wiped by a typo. Defaults keep what the ad-hoc
+//! prunes this replaced did: succeeded jobs go after a week and read
+//! notifications after [`super::notifications::RETENTION_DAYS`]. Audit
+//! and access logs, and storage object history, are kept until an admin
+//! turns their policy on. So are soft-deleted accounts: `deleted_users`
+//! purges them, cascading to what they own, rather than deleting rows of
+//! one table.
+//!
+//! # Runs
+//!
+//! The built-in [`SCHEDULE`] (see [`super::schedules`]) queues a
+//! [`RUN_JOB`] every hour, which runs every enabled policy; an admin can
+//! also run it by hand through `POST
+//! /b/admin/api/jobs/schedules/admin.retention/run`. A policy deletes in
+//! batches of [`BATCH_SIZE`] rows, oldest first, and at most
+//! [`MAX_BATCHES`] batches per run; the rest waits for the next run. Each
+//! run records one row per policy in `suppers_ai__admin__retention_runs`,
+//! and `GET /b/admin/api/retention/last-run` reports the newest run.
+
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, ErrorCode, WaferError};
+
+use super::{
+    admin::{
+        AUDIT_LOGS_TABLE, JOBS_TABLE, NOTIFICATIONS_TABLE, OPERATIONS_TABLE, REQUEST_LOGS_TABLE,
+        RETENTION_POLICIES_TABLE, RETENTION_RUNS_TABLE, STORAGE_ACCESS_LOGS_TABLE,
+        USED_NONCES_TABLE, USER_ROLES_TABLE,
+    },
+    auth::{
+        repo::{jwt_blocklist, oauth_pkce, sessions, tokens, users},
+        USERS_TABLE,
+    },
+    jobs::JobError,
+};
+use crate::util::RecordExt;
+
+/// Rows one batch deletes.
+pub const BATCH_SIZE: i64 = 500;
+/// Batches one policy may delete per run.
+pub const MAX_BATCHES: i64 = 20;
+/// Longest accepted retention (ten years).
+pub const MAX_DAYS: i64 = 3650;
+/// Run rows are kept this long.
+const KEEP_RUNS_DAYS: i64 = 30;
+
+/// Name of the built-in schedule that runs the policies.
+pub const SCHEDULE: &str = "admin.retention";
+/// Job type for a run of every enabled policy, run by the admin block.
+pub const RUN_JOB: &str = "retention.run";
+
+/// One table a policy deletes from: rows whose `column` is older than the
+/// cutoff and that match `filters`.
+struct Target {
+    table: &'static str,
+    column: &'static str,
+    filters: Vec<Filter>,
+    /// Days added to the policy's own for this table's cutoff.
+    extra_days: i64,
+}
+
+impl Target {
+    fn new(table: &'static str, column: &'static str) -> Self {
+        Self {
+            table,
+            column,
+            filters: Vec::new(),
+            extra_days: 0,
+        }
+    }
+
+    fn only(mut self, filter: Filter) -> Self {
+        self.filters.push(filter);
+        self
+    }
+
+    fn plus_days(mut self, days: i64) -> Self {
+        self.extra_days = days;
+        self
+    }
+}
+
+/// What a policy deletes.
+enum Deletes {
+    /// Aged rows of these tables.
+    Rows(fn() -> Vec<Target>),
+    /// Accounts soft-deleted before the cutoff, with what they own
+    /// ([`purge_deleted_users`]).
+    DeletedUsers,
+}
+
+/// A built-in retention policy.
+pub struct PolicySpec {
+    pub name: &'static str,
+    pub description: &'static str,
+    pub default_days: i64,
+    /// Lowest day count an admin may set.
+    pub min_days: i64,
+    pub default_enabled: bool,
+    deletes: Deletes,
+}
+
+/// Every retention policy.
+pub const POLICIES: &[PolicySpec] = &[
+    PolicySpec {
+        name: "request_logs",
+        description: "Request log entries, by age",
+        default_days: 30,
+        min_days: 1,
+        default_enabled: false,
+        deletes: Deletes::Rows(|| vec![Target::new(REQUEST_LOGS_TABLE, "created_at")]),
+    },
+    PolicySpec {
+        name: "audit_logs",
+        description: "Audit log entries, by age",
+        default_days: 365,
+        min_days: 90,
+        default_enabled: false,
+        deletes: Deletes::Rows(|| vec![Target::new(AUDIT_LOGS_TABLE, "created_at")]),
+    },
+    PolicySpec {
+        name: "access_logs",
+        description: "Storage and share-link access log entries, by age",
+        default_days: 90,
+        min_days: 7,
+        default_enabled: false,
+        deletes: Deletes::Rows(access_log_targets),
+    },
+    PolicySpec {
+        name: "object_history",
+        description: "Storage object change events, by age",
+        default_days: 365,
+        min_days: 30,
+        default_enabled: false,
+        deletes: Deletes::Rows(object_history_targets),
+    },
+    PolicySpec {
+        name: "notifications",
+        description: "Read notifications, by age; unread ones are kept",
+        default_days: super::notifications::RETENTION_DAYS,
+        min_days: 7,
+        default_enabled: true,
+        deletes: Deletes::Rows(|| {
+            vec![Target::new(NOTIFICATIONS_TABLE, "created_at").only(Filter {
+                field: "read_at".to_string(),
+                operator: FilterOp::IsNotNull,
+                value: serde_json::Value::Null,
+            })]
+        }),
+    },
+    PolicySpec {
+        name: "finished_jobs",
+        description: "Succeeded background jobs, by finish time",
+        default_days: 7,
+        min_days: 1,
+        default_enabled: true,
+        deletes: Deletes::Rows(|| {
+            vec![Target::new(JOBS_TABLE, "finished_at")
+                .only(eq("status", super::jobs::STATUS_SUCCEEDED))]
+        }),
+    },
+    PolicySpec {
+        name: "expired_tokens",
+        description: "Sessions, refresh tokens, revoked-token, OAuth state, spent-nonce and operation rows, days after they expired",
+        default_days: 7,
+        min_days: 0,
+        default_enabled: true,
+        deletes: Deletes::Rows(|| {
+            [
+                sessions::TABLE,
+                tokens::TABLE,
+                jwt_blocklist::TABLE,
+                oauth_pkce::TABLE,
+                USED_NONCES_TABLE,
+                OPERATIONS_TABLE,
+            ]
+            .into_iter()
+            .map(|table| Target::new(table, "expires_at"))
+            .collect()
+        }),
+    },
+    PolicySpec {
+        name: "dead_shares",
+        description: "Expired and revoked share links, days after they stopped working",
+        default_days: 7,
+        min_days: 0,
+        default_enabled: true,
+        deletes: Deletes::Rows(dead_share_targets),
+    },
+    PolicySpec {
+        name: "deleted_users",
+        description: "Soft-deleted accounts, purged with their sessions, keys, roles and storage, days after deletion",
+        default_days: 30,
+        min_days: 7,
+        default_enabled: false,
+        deletes: Deletes::DeletedUsers,
+    },
+];
+
+fn access_log_targets() -> Vec<Target> {
+    #[allow(unused_mut)]
+    let mut targets = vec![Target::new(STORAGE_ACCESS_LOGS_TABLE, "created_at")];
+    #[cfg(feature = "block-files")]
+    targets.push(Target::new(
+        super::files::repo::shares::ACCESS_LOGS_TABLE,
+        "created_at",
+    ));
+    targets
+}
+
+fn object_history_targets() -> Vec<Target> {
+    #[allow(unused_mut)]
+    let mut targets = Vec::new();
+    #[cfg(feature = "block-files")]
+    targets.push(Target::new(super::files::repo::events::TABLE, "created_at"));
+    targets
+}
+
+/// Share rows past `expires_at` or `revoked_at`, and no-expiry shares whose
+/// signed token has lapsed — the admin listing keeps them for the policy's
+/// days so a recently dead link can still be explained.
+fn dead_share_targets() -> Vec<Target> {
+    #[allow(unused_mut)]
+    let mut targets = Vec::new();
+    #[cfg(feature = "block-files")]
+    {
+        use super::files::{repo::shares::TABLE, SHARE_TOKEN_TTL};
+        targets.push(Target::new(TABLE, "expires_at"));
+        targets.push(Target::new(TABLE, "revoked_at"));
+        targets.push(
+            Target::new(TABLE, "created_at")
+                .only(Filter {
+                    field: "expires_at".to_string(),
+                    operator: FilterOp::IsNull,
+                    value: serde_json::Value::Null,
+                })
+                .plus_days((SHARE_TOKEN_TTL.as_secs() / 86_400) as i64),
+        );
+    }
+    targets
+}
+
+fn eq(field: &str, value: &str) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::json!(value),
+    }
+}
+
+fn spec(name: &str) -> Option<&'static PolicySpec> {
+    POLICIES.iter().find(|p| p.name == name)
+}
+
+// ---------------------------------------------------------------------------
+// Policies
+// ---------------------------------------------------------------------------
+
+/// A policy as it currently applies.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct Policy {
+    pub name: String,
+    pub description: String,
+    pub days: i64,
+    pub enabled: bool,
+    pub default_days: i64,
+    pub min_days: i64,
+}
+
+/// An admin's change to one policy.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Deserialize)]
+pub struct PolicyUpdate {
+    pub name: String,
+    pub days: i64,
+    pub enabled: bool,
+}
+
+impl PolicyUpdate {
+    /// Per-field problems, keyed `policies[{index}].{field}` for the admin
+    /// API's validation response.
+    pub fn problems(&self, index: usize) -> Vec<(String, &'static str)> {
+        let field = |name: &str| format!("policies[{index}].{name}");
+        let Some(spec) = spec(&self.name) else {
+            return vec![(field("name"), "unknown retention policy")];
+        };
+        let mut out = Vec::new();
+        if self.days < spec.min_days {
+            out.push((field("days"), "below the policy's min_days"));
+        } else if self.days > MAX_DAYS {
+            out.push((field("days"), "must be at most 3650"));
+        }
+        out
+    }
+}
+
+/// Every policy, with the admin's overrides applied, in [`POLICIES`] order.
+pub async fn policies(ctx: &dyn Context) -> Result<Vec<Policy>, WaferError> {
+    let rows = db::list_all(ctx, RETENTION_POLICIES_TABLE, vec![]).await?;
+    Ok(POLICIES
+        .iter()
+        .map(|spec| {
+            let row = rows.iter().find(|r| r.str_field("name") == spec.name);
+            Policy {
+                name: spec.name.to_string(),
+                description: spec.description.to_string(),
+                days: row.map_or(spec.default_days, |r| r.i64_field("days")),
+                enabled: row.map_or(spec.default_enabled, |r| r.i64_field("enabled") != 0),
+                default_days: spec.default_days,
+                min_days: spec.min_days,
+            }
+        })
+        .collect())
+}
+
+/// Store `updates` (already validated). Policies not named keep their
+/// current setting.
+pub async fn save(ctx: &dyn Context, updates: &[PolicyUpdate]) -> Result<(), WaferError> {
+    for update in updates {
+        let mut data = crate::util::json_map(serde_json::json!({
+            "days": update.days,
+            "enabled": i64::from(update.enabled),
+        }));
+        crate::util::stamp_updated(&mut data);
+        let filters = vec![eq("name", &update.name)];
+        if db::update_by_filters_count(ctx, RETENTION_POLICIES_TABLE, filters, data.clone()).await?
+            > 0
+        {
+            continue;
+        }
+        data.insert("name".to_string(), serde_json::json!(update.name));
+        crate::util::stamp_created(&mut data);
+        match db::create(ctx, RETENTION_POLICIES_TABLE, data).await {
+            Ok(_) => {}
+            // UNIQUE (name): a concurrent save stored it first.
+            Err(e) if e.code == ErrorCode::AlreadyExists => {}
+            Err(e) => return Err(e),
+        }
+    }
+    Ok(())
+}
+
+// ---------------------------------------------------------------------------
+// Runs
+// ---------------------------------------------------------------------------
+
+/// What one policy deleted in a run.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct PolicyRun {
+    pub policy: String,
+    pub days: i64,
+    pub deleted: i64,
+    /// Stopped at [`MAX_BATCHES`] with rows left over.
+    pub capped: bool,
+    /// Empty when every target was swept.
+    pub error: String,
+}
+
+impl PolicyRun {
+    fn from_record(row: &Record) -> Self {
+        Self {
+            policy: row.str_field("policy").to_string(),
+            days: row.i64_field("days"),
+            deleted: row.i64_field("deleted"),
+            capped: row.i64_field("capped") != 0,
+            error: row.str_field("error").to_string(),
+        }
+    }
+}
+
+/// Run a [`RUN_JOB`]. A repeat only deletes what has aged out since, so a
+/// job delivered twice is harmless.
+pub async fn run_job(ctx: &dyn Context) -> Result<(), JobError> {
+    run(ctx).await?;
+    Ok(())
+}
+
+/// Run every enabled policy now and record the outcome. A policy that
+/// fails part-way still records what it deleted; the others go on.
+pub async fn run(ctx: &dyn Context) -> Result<Vec<PolicyRun>, WaferError> {
+    let now = crate::clock::now();
+    let run_id = crate::clock::new_id();
+    let mut outcomes = Vec::new();
+    for policy in policies(ctx).await?.into_iter().filter(|p| p.enabled) {
+        let Some(spec) = spec(&policy.name) else {
+            continue;
+        };
+        let cutoff = crate::util::format_rfc3339(now - chrono::Duration::days(policy.days));
+        let mut outcome = PolicyRun {
+            policy: policy.name.clone(),
+            days: policy.days,
+            deleted: 0,
+            capped: false,
+            error: String::new(),
+        };
+        match spec.deletes {
+            Deletes::Rows(targets) => {
+                for target in targets() {
+                    let cutoff = crate::util::format_rfc3339(
+                        now - chrono::Duration::days(policy.days + target.extra_days),
+                    );
+                    match sweep(ctx, &target, &cutoff).await {
+                        Ok((deleted, capped)) => {
+                            outcome.deleted += deleted;
+                            outcome.capped |= capped;
+                        }
+                        Err((deleted, e)) => {
+                            outcome.deleted += deleted;
+                            tracing::warn!(policy = %policy.name, table = target.table, error = %e, "retention sweep failed");
+                            outcome.error = format!("{}: {}", target.table, e.message);
+                        }
+                    }
+                }
+            }
+            Deletes::DeletedUsers => match purge_deleted_users(ctx, &cutoff).await {
+                Ok((deleted, capped)) => {
+                    outcome.deleted = deleted;
+                    outcome.capped = capped;
+                }
+                Err((deleted, e)) => {
+                    outcome.deleted = deleted;
+                    tracing::warn!(policy = %policy.name, error = %e, "deleted-user purge failed");
+                    outcome.error = format!("{}: {e}", USERS_TABLE);
+                }
+            },
+        }
+        record(ctx, &run_id, &outcome).await?;
+        outcomes.push(outcome);
+    }
+    let keep_from = crate::util::format_rfc3339(now - chrono::Duration::days(KEEP_RUNS_DAYS));
+    let old_runs = vec![Filter {
+        field: "created_at".to_string(),
+        operator: FilterOp::LessThan,
+        value: serde_json::json!(keep_from),
+    }];
+    if let Err(e) = db::delete_by_filters(ctx, RETENTION_RUNS_TABLE, old_runs).await {
+        tracing::warn!(error = %e, "failed to prune retention run history");
+    }
+    Ok(outcomes)
+}
+
+/// Delete `target`'s rows older than `cutoff`, oldest first, in batches.
+/// Returns the rows deleted and whether [`MAX_BATCHES`] cut it short; a
+/// failure still reports what the earlier batches deleted.
+async fn sweep(
+    ctx: &dyn Context,
+    target: &Target,
+    cutoff: &str,
+) -> Result<(i64, bool), (i64, WaferError)> {
+    let mut filters = target.filters.clone();
+    filters.push(Filter {
+        field: target.column.to_string(),
+        operator: FilterOp::LessThan,
+        value: serde_json::json!(cutoff),
+    });
+    let mut deleted = 0;
+    for _ in 0..MAX_BATCHES {
+        let opts = ListOptions {
+            columns: Some(vec!["id".to_string()]),
+            filters: filters.clone(),
+            sort: vec![SortField {
+                field: target.column.to_string(),
+                desc: false,
+            }],
+            limit: BATCH_SIZE,
+            skip_count: true,
+            ..Default::default()
+        };
+        let batch = db::list(ctx, target.table, &opts)
+            .await
+            .map_err(|e| (deleted, e))?;
+        let ids: Vec<&str> = batch.records.iter().map(|r| r.id.as_str()).collect();
+        if ids.is_empty() {
+            return Ok((deleted, false));
+        }
+        let by_id = vec![Filter {
+            field: "id".to_string(),
+            operator: FilterOp::In,
+            value: serde_json::json!(ids),
+        }];
+        deleted += db::delete_by_filters_count(ctx, target.table, by_id)
+            .await
+            .map_err(|e| (deleted, e))?;
+        if (ids.len() as i64) < BATCH_SIZE {
+            return Ok((deleted, false));
+        }
+    }
+    Ok((deleted, true))
+}
+
+/// Purge accounts soft-deleted before `cutoff`, longest-deleted first, at
+/// most [`BATCH_SIZE`] per run. Each one loses, in order: its stored files
+/// (queued for the files block, see `files::user_purge` for what is kept),
+/// its role assignments, then its auth rows and the account itself
+/// (`users::purge`). The address becomes free to sign up with again.
+/// Returns the accounts purged and whether more were left waiting.
+async fn purge_deleted_users(
+    ctx: &dyn Context,
+    cutoff: &str,
+) -> Result<(i64, bool), (i64, String)> {
+    let due = users::list_deleted_before(ctx, cutoff, BATCH_SIZE)
+        .await
+        .map_err(|e| (0, e.to_string()))?;
+    let mut purged = 0;
+    for user in &due {
+        #[cfg(feature = "block-files")]
+        super::jobs::enqueue(
+            ctx,
+            "suppers-ai/files",
+            super::files::USER_PURGE_JOB,
+            &serde_json::json!({ "user_id": user.id }),
+            super::jobs::EnqueueOptions::default(),
+        )
+        .await
+        .map_err(|e| (purged, e.to_string()))?;
+        db::delete_by_filters(ctx, USER_ROLES_TABLE, vec![eq("user_id", &user.id)])
+            .await
+            .map_err(|e| (purged, e.to_string()))?;
+        crate::cache::invalidate_table(USER_ROLES_TABLE);
+        users::purge(ctx, &user.id)
+            .await
+            .map_err(|e| (purged, e.to_string()))?;
+        super::admin::audit_log(ctx, "", "user.purge", &format!("users/{}", user.id), "").await;
+        purged += 1;
+    }
+    Ok((purged, due.len() as i64 == BATCH_SIZE))
+}
+
+async fn record(ctx: &dyn Context, run_id: &str, outcome: &PolicyRun) -> Result<(), WaferError> {
+    let mut data = crate::util::json_map(serde_json::json!({
+        "run_id": run_id,
+        "policy": outcome.policy,
+        "days": outcome.days,
+        "deleted": outcome.deleted,
+        "capped": i64::from(outcome.capped),
+        "error": outcome.error,
+    }));
+    crate::util::stamp_created(&mut data);
+    db::create(ctx, RETENTION_RUNS_TABLE, data).await.map(drop)
+}
+
+/// The newest recorded run: its id, when it ran, and each policy's
+/// outcome. `None` before the first run.
+pub async fn last_run(ctx: &dyn Context) -> Result<Option<serde_json::Value>, WaferError> {
+    let newest = db::list(
+        ctx,
+        RETENTION_RUNS_TABLE,
+        &ListOptions {
+            sort: vec![SortField {
+                field: "created_at".to_string(),
+                desc: true,
+            }],
+            limit: 1,
+            skip_count: true,
+            ..Default::default()
+        },
+    )
+    .await?;
+    let Some(newest) = newest.records.first() else {
+        return Ok(None);
+    };
+    let run_id = newest.str_field("run_id");
+    let mut rows = db::list_all(ctx, RETENTION_RUNS_TABLE, vec![eq("run_id", run_id)]).await?;
+    rows.sort_by(|a, b| a.str_field("created_at").cmp(b.str_field("created_at")));
+    let ran_at = rows
+        .first()
+        .map_or("", |r| r.str_field("created_at"))
+        .to_string();
+    let policies: Vec<PolicyRun> = rows.iter().map(PolicyRun::from_record).collect();
+    Ok(Some(serde_json::json!({
+        "run_id": run_id,
+        "ran_at": ran_at,
+        "deleted": policies.iter().map(|p| p.deleted).sum::<i64>(),
+        "policies": policies,
+    })))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    async fn seed_log(ctx: &TestContext, table: &str, days_ago: i64) {
+        let at =
+            crate::util::format_rfc3339(crate::clock::now() - chrono::Duration::days(days_ago));
+        let mut data = crate::util::json_map(serde_json::json!({
+            "user_id": "u1",
+            "created_at": &at,
+            "updated_at": &at,
+        }));
+        if table == AUDIT_LOGS_TABLE {
+            data.insert("action".to_string(), serde_json::json!("test"));
+        }
+        db::create(ctx, table, data).await.unwrap();
+    }
+
+    fn update(name: &str, days: i64, enabled: bool) -> PolicyUpdate {
+        PolicyUpdate {
+            name: name.to_string(),
+            days,
+            enabled,
+        }
+    }
+
+    #[test]
+    fn updates_respect_the_policy_floor() {
+        assert!(update("audit_logs", 90, true).problems(0).is_empty());
+        assert_eq!(
+            update("audit_logs", 0, true).problems(2),
+            vec![(
+                "policies[2].days".to_string(),
+                "below the policy's min_days"
+            )]
+        );
+        assert_eq!(update("request_logs", 4000, true).problems(0).len(), 1);
+        assert_eq!(update("trash", 30, true).problems(0).len(), 1);
+    }
+
+    #[tokio::test]
+    async fn saved_overrides_replace_the_defaults() {
+        let ctx = TestContext::with_admin().await;
+        let before = policies(&ctx).await.unwrap();
+        assert_eq!(before.len(), POLICIES.len());
+        let audit = before.iter().find(|p| p.name == "audit_logs").unwrap();
+        assert_eq!((audit.days, audit.enabled), (365, false));
+
+        save(&ctx, &[update("audit_logs", 120, true)])
+            .await
+            .unwrap();
+        save(&ctx, &[update("audit_logs", 180, true)])
+            .await
+            .unwrap();
+        let after = policies(&ctx).await.unwrap();
+        let audit = after.iter().find(|p| p.name == "audit_logs").unwrap();
+        assert_eq!((audit.days, audit.enabled), (180, true));
+        let jobs = after.iter().find(|p| p.name == "finished_jobs").unwrap();
+        assert_eq!((jobs.days, jobs.enabled), (7, true));
+    }
+
+    #[tokio::test]
+    async fn run_deletes_only_aged_rows_of_enabled_policies() {
+        let ctx = TestContext::with_admin().await;
+        seed_log(&ctx, AUDIT_LOGS_TABLE, 400).await;
+        seed_log(&ctx, AUDIT_LOGS_TABLE, 10).await;
+        seed_log(&ctx, REQUEST_LOGS_TABLE, 400).await;
+        save(&ctx, &[update("audit_logs", 90, true)]).await.unwrap();
+
+        assert!(last_run(&ctx).await.unwrap().is_none());
+        let outcomes = run(&ctx).await.unwrap();
+        let audit = outcomes.iter().find(|o| o.policy == "audit_logs").unwrap();
+        assert_eq!((audit.deleted, audit.capped), (1, false));
+        assert!(!outcomes.iter().any(|o| o.policy == "request_logs"));
+
+        assert_eq!(db::count(&ctx, AUDIT_LOGS_TABLE, &[]).await.unwrap(), 1);
+        assert_eq!(db::count(&ctx, REQUEST_LOGS_TABLE, &[]).await.unwrap(), 1);
+
+        let report = last_run(&ctx).await.unwrap().expect("recorded");
+        assert_eq!(report["policies"].as_array().unwrap().len(), outcomes.len());
+        assert_eq!(report["deleted"], 1);
+    }
+
+    #[tokio::test]
+    async fn deleted_users_policy_purges_accounts_past_retention() {
+        let ctx = TestContext::with_auth().await;
+        let mut ids = Vec::new();
+        for (email, days_ago) in [("old@example.com", 40), ("recent@example.com", 2)] {
+            let user = users::insert(
+                &ctx,
+                users::NewUser {
+                    email: email.to_string(),
+                    display_name: String::new(),
+                    avatar_url: None,
+                    role: "user".to_string(),
+                },
+            )
+            .await
+            .unwrap();
+            let at =
+                crate::util::format_rfc3339(crate::clock::now() - chrono::Duration::days(days_ago));
+            let data = crate::util::json_map(serde_json::json!({ "deleted_at": at }));
+            db::update(&ctx, USERS_TABLE, &user.id, data).await.unwrap();
+            let mut role = crate::util::json_map(serde_json::json!({
+                "user_id": user.id,
+                "role": "editor",
+            }));
+            crate::util::stamp_created(&mut role);
+            db::create(&ctx, USER_ROLES_TABLE, role).await.unwrap();
+            ids.push(user.id);
+        }
+        save(&ctx, &[update("deleted_users", 30, true)])
+            .await
+            .unwrap();
+
+        let outcomes = run(&ctx).await.unwrap();
+        let purge = outcomes
+            .iter()
+            .find(|o| o.policy == "deleted_users")
+            .unwrap();
+        assert_eq!((purge.deleted, purge.capped), (1, false));
+        assert_eq!(purge.error, "");
+
+        assert!(users::find_by_id(&ctx, &ids[0]).await.unwrap().is_none());
+        assert!(users::find_by_id(&ctx, &ids[1]).await.unwrap().is_some());
+        let roles = db::list_all(&ctx, USER_ROLES_TABLE, vec![]).await.unwrap();
+        assert_eq!(roles.len(), 1);
+        assert_eq!(roles[0].str_field("user_id"), ids[1]);
+    }
+
+    #[cfg(feature = "block-files")]
+    #[tokio::test]
+    async fn dead_shares_policy_keeps_recently_dead_links() {
+        use super::super::files::repo::shares;
+
+        let ctx = TestContext::with_files().await;
+        let days_ago = |days: i64| {
+            crate::util::format_rfc3339(crate::clock::now() - chrono::Duration::days(days))
+        };
+        for (token, created, expires) in [
+            ("live", 1, Some(-1)),
+            ("recently-expired", 5, Some(3)),
+            ("long-expired", 20, Some(10)),
+            ("open", 20, None),
+            ("lapsed", 40, None),
+        ] {
+            let mut data = crate::util::json_map(serde_json::json!({
+                "token": token,
+                "bucket": "b",
+                "key": "k",
+                "created_by": "u1",
+                "created_at": days_ago(created),
+                "updated_at": days_ago(created),
+                "access_count": 0,
+            }));
+            if let Some(expires) = expires {
+                data.insert("expires_at".into(), serde_json::json!(days_ago(expires)));
+            }
+            shares::seed(&ctx, data).await.unwrap();
+        }
+
+        let outcomes = run(&ctx).await.unwrap();
+        let dead = outcomes.iter().find(|o| o.policy == "dead_shares").unwrap();
+        assert_eq!((dead.deleted, dead.error.as_str()), (2, ""));
+        let mut left: Vec<String> = db::list_all(&ctx, shares::TABLE, vec![])
+            .await
+            .unwrap()
+            .iter()
+            .map(|r| r.str_field("token").to_string())
+            .collect();
+        left.sort();
+        assert_eq!(left, ["live", "open", "recently-expired"]);
+    }
+
+    #[tokio::test]
+    async fn notifications_policy_keeps_unread_rows() {
+        let ctx = TestContext::with_auth().await;
+        let payload = |title: &str| super::super::notifications::NotificationPayload {
+            title: title.to_string(),
+            ..Default::default()
+        };
+        let read = super::super::notifications::notify(&ctx, "u1", "t", &payload("read"))
+            .await
+            .unwrap();
+        super::super::notifications::notify(&ctx, "u1", "t", &payload("unread"))
+            .await
+            .unwrap();
+        super::super::notifications::mark_read(&ctx, "u1", &read.id)
+            .await
+            .unwrap();
+        let Deletes::Rows(targets) = spec("notifications").unwrap().deletes else {
+            unreachable!("notifications deletes rows")
+        };
+        let target = &targets()[0];
+
+        // A cutoff in the past keeps everything.
+        let past = crate::util::format_rfc3339(crate::clock::now() - chrono::Duration::days(1));
+        assert_eq!(sweep(&ctx, target, &past).await.unwrap(), (0, false));
+
+        let future = crate::util::format_rfc3339(crate::clock::now() + chrono::Duration::days(1));
+        assert_eq!(sweep(&ctx, target, &future).await.unwrap(), (1, false));
+        let left = db::list_all(&ctx, NOTIFICATIONS_TABLE, vec![])
+            .await
+            .unwrap();
+        assert_eq!(left.len(), 1);
+        assert_eq!(left[0].str_field("title"), "unread");
+    }
+}
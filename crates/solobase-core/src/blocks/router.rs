@@ -27,6 +27,9 @@ pub struct SolobaseRouterBlock {
     /// Runtime-added routes from downstream projects (see `SolobaseBuilder::add_route`).
     /// Built-in `ROUTES` take priority — see `routing::route_to_block`.
     extra_routes: Arc<Vec<ExtraRoute>>,
+    /// Normalized prefix Solobase is mounted under (see `crate::base_path`);
+    /// empty at the root.
+    base_path: String,
 }
 
 impl SolobaseRouterBlock {
@@ -52,8 +55,16 @@ impl SolobaseRouterBlock {
             features,
             block_infos,
             extra_routes: Arc::new(extra_routes),
+            base_path: String::new(),
         }
     }
+
+    /// Serve under `base_path`, already normalized by
+    /// `crate::base_path::normalize` (see `SolobaseBuilder::base_path`).
+    pub fn with_base_path(mut self, base_path: String) -> Self {
+        self.base_path = base_path;
+        self
+    }
 }
 
 #[wafer_block::wafer_async_trait]
@@ -77,7 +88,16 @@ impl Block for SolobaseRouterBlock {
         Ok(()) // No-op — individual blocks handle their own lifecycle
     }
 
-    async fn handle(&self, ctx: &dyn Context, msg: Message, input: InputStream) -> OutputStream {
+    async fn handle(
+        &self,
+        ctx: &dyn Context,
+        mut msg: Message,
+        input: InputStream,
+    ) -> OutputStream {
+        if !self.base_path.is_empty() {
+            msg.set_meta(crate::base_path::META, &self.base_path);
+        }
+
         // Resolve auth token from Authorization header or auth_token cookie.
         let auth_header = msg.header("authorization");
         let auth_value = if !auth_header.is_empty() {
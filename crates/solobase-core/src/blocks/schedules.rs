@@ -0,0 +1,788 @@
+//! Recurring background work on a cron schedule.
+//!
+//! A schedule names a cron expression (see [`crate::cron`]) and the job it
+//! queues: each time the expression comes due, the scheduler enqueues a
+//! `job_type` job owned by `block` through [`super::jobs`], which runs it
+//! with the usual retries. The payload is
+//! `{"schedule": name, "scheduled_for": rfc3339}`.
+//!
+//! Schedules are registered in code — built-in ones in [`builtin`], a
+//! block's own through [`crate::services::Services::schedules`] from its
+//! `Init`, an embedder's through [`register`] — and their state lives in
+//! `suppers_ai__admin__schedules`: one row per name with the next and last
+//! run, the last job queued, whether an admin paused it, and what the last
+//! due time did (`last_outcome`).
+//!
+//! # Ticks
+//!
+//! [`run_if_due`] checks every schedule at most every [`TICK_SECS`] per
+//! instance, from wherever background jobs are driven: the native job
+//! worker's poll loop, and `POST /b/admin/api/jobs/run`. An occurrence is
+//! claimed by moving `next_run_at` forward with a compare-and-set, so two
+//! instances ticking at once queue it once. Then:
+//!
+//! - a run more than [`MISSED_GRACE_SECS`] late (the server was down) is
+//!   skipped and logged, unless the schedule sets `catch_up`, which queues
+//!   one run for the whole gap rather than one per missed occurrence;
+//! - while the job the schedule queued last is still pending or running,
+//!   the occurrence is skipped with a warning, so a slow job never piles
+//!   up behind itself.
+//!
+//! Admins list schedules and run, pause or resume one under
+//! `/b/admin/api/jobs/schedules`. A manual run queues the job now and
+//! leaves `next_run_at` alone.
+//!
+//! Cloudflare Workers and the browser build have no worker loop, so
+//! nothing ticks there on its own: registration works the same, but the
+//! host has to drive the scheduler by calling `POST
+//! /b/admin/api/jobs/run` (on Workers, from a Cron Trigger) at least every
+//! few minutes. Ticks further apart than [`MISSED_GRACE_SECS`] make every
+//! schedule without `catch_up` look missed.
+
+use std::{
+    collections::{BTreeMap, HashMap},
+    sync::{
+        atomic::{AtomicI64, Ordering},
+        Mutex,
+    },
+};
+
+use chrono::{DateTime, Utc};
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, WaferError};
+
+use super::{admin::SCHEDULES_TABLE, jobs};
+use crate::{cron, util::RecordExt};
+
+/// Minimum gap between two ticks on one instance.
+pub const TICK_SECS: i64 = 30;
+/// How late a run may start before it counts as missed.
+pub const MISSED_GRACE_SECS: i64 = 300;
+
+/// `last_outcome`: the occurrence queued a job.
+pub const OUTCOME_QUEUED: &str = "queued";
+/// `last_outcome`: an admin ran the schedule by hand.
+pub const OUTCOME_TRIGGERED: &str = "triggered";
+/// `last_outcome`: skipped because the previous job was still going.
+pub const OUTCOME_OVERLAP: &str = "skipped_overlap";
+/// `last_outcome`: skipped because it was missed and `catch_up` is off.
+pub const OUTCOME_MISSED: &str = "skipped_missed";
+
+/// Longest schedule name.
+const MAX_NAME_LEN: usize = 64;
+
+/// Unix time of this instance's last tick.
+static LAST_TICK: AtomicI64 = AtomicI64::new(0);
+
+/// Schedules registered at runtime, by name.
+static REGISTRY: Mutex<BTreeMap<String, ScheduleSpec>> = Mutex::new(BTreeMap::new());
+
+/// A recurring job: when it runs and what it queues.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ScheduleSpec {
+    /// Unique name, e.g. `files.lifecycle`: lowercase letters, digits,
+    /// `.`, `_` and `-`.
+    pub name: String,
+    /// Cron expression, in UTC.
+    pub cron: String,
+    /// The block that runs the job.
+    pub block: String,
+    pub job_type: String,
+    /// Run once after a missed occurrence instead of skipping it.
+    pub catch_up: bool,
+}
+
+impl ScheduleSpec {
+    pub fn new(
+        name: impl Into<String>,
+        cron: impl Into<String>,
+        block: impl Into<String>,
+        job_type: impl Into<String>,
+    ) -> Self {
+        Self {
+            name: name.into(),
+            cron: cron.into(),
+            block: block.into(),
+            job_type: job_type.into(),
+            catch_up: false,
+        }
+    }
+
+    pub fn catch_up(mut self, catch_up: bool) -> Self {
+        self.catch_up = catch_up;
+        self
+    }
+
+    fn validate(&self) -> Result<(), String> {
+        let name_ok = !self.name.is_empty()
+            && self.name.len() <= MAX_NAME_LEN
+            && self.name.bytes().all(|b| {
+                b.is_ascii_lowercase() || b.is_ascii_digit() || matches!(b, b'.' | b'_' | b'-')
+            });
+        if !name_ok {
+            return Err(format!(
+                "schedule name {:?} must be 1-{MAX_NAME_LEN} lowercase letters, digits, '.', '_' or '-'",
+                self.name
+            ));
+        }
+        if self.block.is_empty() || self.job_type.is_empty() {
+            return Err(format!(
+                "schedule {}: block and job type are required",
+                self.name
+            ));
+        }
+        cron::Schedule::parse(&self.cron)
+            .map(|_| ())
+            .map_err(|e| format!("schedule {}: {e}", self.name))
+    }
+}
+
+/// The schedules every server has.
+pub fn builtin() -> Vec<ScheduleSpec> {
+    #[allow(unused_mut)]
+    let mut specs = vec![
+        ScheduleSpec::new(
+            super::retention::SCHEDULE,
+            "@hourly",
+            super::admin::ADMIN_BLOCK_ID,
+            super::retention::RUN_JOB,
+        ),
+        // Email retries back off from 30 seconds, so drain often.
+        ScheduleSpec::new(
+            super::email::outbox::DRAIN_SCHEDULE,
+            "* * * * *",
+            "suppers-ai/email",
+            super::email::outbox::DRAIN_JOB,
+        ),
+    ];
+    #[cfg(feature = "block-files")]
+    specs.push(
+        ScheduleSpec::new(
+            super::files::LIFECYCLE_SCHEDULE,
+            "@daily",
+            "suppers-ai/files",
+            super::files::LIFECYCLE_RUN_JOB,
+        )
+        // A day's expiries shouldn't wait for tomorrow because the server
+        // was down at midnight.
+        .catch_up(true),
+    );
+    #[cfg(feature = "block-files")]
+    specs.push(ScheduleSpec::new(
+        super::files::CONSISTENCY_SCHEDULE,
+        "@weekly",
+        "suppers-ai/files",
+        super::files::CONSISTENCY_CHECK_JOB,
+    ));
+    #[cfg(feature = "block-files")]
+    specs.push(ScheduleSpec::new(
+        super::files::QUOTA_DIGEST_SCHEDULE,
+        "@daily",
+        "suppers-ai/files",
+        super::files::QUOTA_DIGEST_JOB,
+    ));
+    #[cfg(feature = "block-products")]
+    specs.push(ScheduleSpec::new(
+        super::products::RESERVATION_RELEASE_SCHEDULE,
+        "@hourly",
+        "suppers-ai/products",
+        super::products::RESERVATION_RELEASE_JOB,
+    ));
+    specs
+}
+
+/// Register `spec`, replacing a schedule of the same name (so a block's
+/// `Init` can run again). Built-in names are taken.
+pub fn register(spec: ScheduleSpec) -> Result<(), String> {
+    spec.validate()?;
+    if builtin().iter().any(|b| b.name == spec.name) {
+        return Err(format!("schedule name {:?} is built in", spec.name));
+    }
+    lock().insert(spec.name.clone(), spec);
+    Ok(())
+}
+
+/// Every registered schedule, built-in ones included, by name.
+pub fn registered() -> BTreeMap<String, ScheduleSpec> {
+    let mut specs = lock().clone();
+    for spec in builtin() {
+        specs.insert(spec.name.clone(), spec);
+    }
+    specs
+}
+
+fn lock() -> std::sync::MutexGuard<'static, BTreeMap<String, ScheduleSpec>> {
+    REGISTRY.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// A schedule as the admin API reports it.
+#[derive(Debug, Clone, PartialEq, serde::Serialize)]
+pub struct ScheduleStatus {
+    pub name: String,
+    pub cron: String,
+    pub block: String,
+    pub job_type: String,
+    pub catch_up: bool,
+    pub paused: bool,
+    /// False for a row whose schedule nothing registered this start (its
+    /// block was removed or is disabled); such a row never runs.
+    pub registered: bool,
+    pub next_run_at: String,
+    pub last_run_at: String,
+    pub last_job_id: String,
+    pub last_outcome: String,
+}
+
+impl ScheduleStatus {
+    fn from_record(r: &Record, registered: bool) -> Self {
+        Self {
+            name: r.str_field("name").to_string(),
+            cron: r.str_field("cron").to_string(),
+            block: r.str_field("block").to_string(),
+            job_type: r.str_field("job_type").to_string(),
+            catch_up: r.bool_field("catch_up"),
+            paused: r.bool_field("paused"),
+            registered,
+            next_run_at: r.str_field("next_run_at").to_string(),
+            last_run_at: r.str_field("last_run_at").to_string(),
+            last_job_id: r.str_field("last_job_id").to_string(),
+            last_outcome: r.str_field("last_outcome").to_string(),
+        }
+    }
+}
+
+fn eq(field: &str, value: impl Into<serde_json::Value>) -> Filter {
+    Filter {
+        field: field.to_string(),
+        operator: FilterOp::Equal,
+        value: value.into(),
+    }
+}
+
+fn timestamp(at: DateTime<Utc>) -> String {
+    crate::util::format_rfc3339(at)
+}
+
+/// The occurrence of `spec` after `now`, or `""` for an expression that
+/// never matches.
+fn next_run(spec: &ScheduleSpec, now: DateTime<Utc>) -> String {
+    cron::Schedule::parse(&spec.cron)
+        .ok()
+        .and_then(|s| s.next_after(now))
+        .map(timestamp)
+        .unwrap_or_default()
+}
+
+/// Bring the table in line with the registered schedules: insert a row
+/// for a new name, and pick up a changed expression, block, job type or
+/// `catch_up`. Pause state and run history stay. Returns every row.
+async fn sync(ctx: &dyn Context, now: DateTime<Utc>) -> Result<Vec<Record>, WaferError> {
+    let rows = db::list_all(ctx, SCHEDULES_TABLE, vec![]).await?;
+    let mut changed = false;
+    for spec in registered().values() {
+        match rows.iter().find(|r| r.str_field("name") == spec.name) {
+            None => {
+                let data = crate::util::json_map(serde_json::json!({
+                    "name": spec.name,
+                    "cron": spec.cron,
+                    "block": spec.block,
+                    "job_type": spec.job_type,
+                    "catch_up": i64::from(spec.catch_up),
+                    "paused": 0,
+                    "next_run_at": next_run(spec, now),
+                    "last_run_at": "",
+                    "last_job_id": "",
+                    "last_outcome": "",
+                    "created_at": timestamp(now),
+                    "updated_at": timestamp(now),
+                }));
+                match db::create(ctx, SCHEDULES_TABLE, data).await {
+                    Ok(_) => {}
+                    // Another instance inserted it first.
+                    Err(e) if super::errors::is_unique_violation(&e) => {}
+                    Err(e) => return Err(e),
+                }
+                changed = true;
+                continue;
+            }
+            Some(row) => {
+                let mut data = HashMap::new();
+                if row.str_field("cron") != spec.cron {
+                    data.insert("cron".into(), serde_json::json!(spec.cron));
+                    data.insert("next_run_at".into(), serde_json::json!(next_run(spec, now)));
+                }
+                if row.str_field("block") != spec.block {
+                    data.insert("block".into(), serde_json::json!(spec.block));
+                }
+                if row.str_field("job_type") != spec.job_type {
+                    data.insert("job_type".into(), serde_json::json!(spec.job_type));
+                }
+                if row.bool_field("catch_up") != spec.catch_up {
+                    data.insert(
+                        "catch_up".into(),
+                        serde_json::json!(i64::from(spec.catch_up)),
+                    );
+                }
+                if data.is_empty() {
+                    continue;
+                }
+                data.insert("updated_at".into(), serde_json::json!(timestamp(now)));
+                db::update(ctx, SCHEDULES_TABLE, &row.id, data).await?;
+                changed = true;
+            }
+        }
+    }
+    if changed {
+        db::list_all(ctx, SCHEDULES_TABLE, vec![]).await
+    } else {
+        Ok(rows)
+    }
+}
+
+/// Whether job `id` is still waiting or running.
+async fn still_running(ctx: &dyn Context, id: &str) -> Result<bool, WaferError> {
+    if id.is_empty() {
+        return Ok(false);
+    }
+    Ok(jobs::get(ctx, id)
+        .await?
+        .is_some_and(|j| j.status == jobs::STATUS_PENDING || j.status == jobs::STATUS_RUNNING))
+}
+
+/// Record what a schedule's occurrence did.
+async fn record(
+    ctx: &dyn Context,
+    id: &str,
+    outcome: &str,
+    job_id: Option<&str>,
+    now: DateTime<Utc>,
+) -> Result<(), WaferError> {
+    let mut data = HashMap::new();
+    data.insert("last_outcome".to_string(), serde_json::json!(outcome));
+    if let Some(job_id) = job_id {
+        data.insert("last_job_id".to_string(), serde_json::json!(job_id));
+        data.insert("last_run_at".to_string(), serde_json::json!(timestamp(now)));
+    }
+    data.insert("updated_at".to_string(), serde_json::json!(timestamp(now)));
+    db::update(ctx, SCHEDULES_TABLE, id, data).await.map(|_| ())
+}
+
+/// Queue the job of every schedule due at `now`. Returns how many were
+/// queued.
+pub async fn tick(ctx: &dyn Context, now: DateTime<Utc>) -> Result<usize, WaferError> {
+    let specs = registered();
+    let mut queued = 0;
+    for row in sync(ctx, now).await? {
+        let name = row.str_field("name");
+        let Some(spec) = specs.get(name) else {
+            continue;
+        };
+        let due_at = row.str_field("next_run_at");
+        let Some(due) = crate::util::parse_timestamp(due_at) else {
+            continue;
+        };
+        if row.bool_field("paused") || due > now {
+            continue;
+        }
+
+        // Claim the occurrence: only the instance that moves
+        // `next_run_at` off `due_at` goes on.
+        let mut data = HashMap::new();
+        data.insert(
+            "next_run_at".to_string(),
+            serde_json::json!(next_run(spec, now)),
+        );
+        data.insert("updated_at".to_string(), serde_json::json!(timestamp(now)));
+        let claimed = db::update_by_filters_count(
+            ctx,
+            SCHEDULES_TABLE,
+            vec![eq("id", row.id.as_str()), eq("next_run_at", due_at)],
+            data,
+        )
+        .await?;
+        if claimed == 0 {
+            continue;
+        }
+
+        if (now - due).num_seconds() > MISSED_GRACE_SECS && !spec.catch_up {
+            tracing::info!(
+                schedule = name,
+                due = due_at,
+                "schedule missed its run; skipping"
+            );
+            record(ctx, &row.id, OUTCOME_MISSED, None, now).await?;
+            continue;
+        }
+        let last_job = row.str_field("last_job_id");
+        if still_running(ctx, last_job).await? {
+            tracing::warn!(
+                schedule = name,
+                job = last_job,
+                "previous run of schedule still going; skipping"
+            );
+            record(ctx, &row.id, OUTCOME_OVERLAP, None, now).await?;
+            continue;
+        }
+
+        let payload = serde_json::json!({ "schedule": name, "scheduled_for": due_at });
+        match jobs::enqueue(
+            ctx,
+            &spec.block,
+            &spec.job_type,
+            &payload,
+            Default::default(),
+        )
+        .await
+        {
+            Ok(job_id) => {
+                record(ctx, &row.id, OUTCOME_QUEUED, Some(&job_id), now).await?;
+                queued += 1;
+            }
+            Err(e) => tracing::warn!(schedule = name, error = %e, "queueing scheduled job failed"),
+        }
+    }
+    Ok(queued)
+}
+
+/// [`tick`], at most once per [`TICK_SECS`] on this instance. Failures are
+/// logged.
+pub async fn run_if_due(ctx: &dyn Context) {
+    let now = Utc::now();
+    if now.timestamp() - LAST_TICK.load(Ordering::Relaxed) < TICK_SECS {
+        return;
+    }
+    LAST_TICK.store(now.timestamp(), Ordering::Relaxed);
+    if let Err(e) = tick(ctx, now).await {
+        tracing::warn!(error = %e, "schedule tick failed");
+    }
+}
+
+/// Every schedule row, by name.
+pub async fn list(ctx: &dyn Context) -> Result<Vec<ScheduleStatus>, WaferError> {
+    let specs = registered();
+    let mut list: Vec<_> = sync(ctx, Utc::now())
+        .await?
+        .iter()
+        .map(|r| ScheduleStatus::from_record(r, specs.contains_key(r.str_field("name"))))
+        .collect();
+    list.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(list)
+}
+
+async fn find(ctx: &dyn Context, name: &str) -> Result<Option<Record>, WaferError> {
+    Ok(sync(ctx, Utc::now())
+        .await?
+        .into_iter()
+        .find(|r| r.str_field("name") == name))
+}
+
+/// Why [`trigger`] did not queue a run.
+#[derive(Debug)]
+pub enum TriggerError {
+    /// No registered schedule has that name.
+    NotFound,
+    /// The job the schedule queued last is still pending or running.
+    Running,
+    Db(WaferError),
+}
+
+impl From<WaferError> for TriggerError {
+    fn from(e: WaferError) -> Self {
+        TriggerError::Db(e)
+    }
+}
+
+/// Queue a run of schedule `name` now, outside its schedule. Returns the
+/// job id.
+pub async fn trigger(ctx: &dyn Context, name: &str) -> Result<String, TriggerError> {
+    let Some(spec) = registered().remove(name) else {
+        return Err(TriggerError::NotFound);
+    };
+    let row = find(ctx, name).await?.ok_or(TriggerError::NotFound)?;
+    if still_running(ctx, row.str_field("last_job_id")).await? {
+        return Err(TriggerError::Running);
+    }
+    let now = Utc::now();
+    let payload = serde_json::json!({
+        "schedule": name,
+        "scheduled_for": timestamp(now),
+        "manual": true,
+    });
+    let job_id = jobs::enqueue(
+        ctx,
+        &spec.block,
+        &spec.job_type,
+        &payload,
+        Default::default(),
+    )
+    .await?;
+    record(ctx, &row.id, OUTCOME_TRIGGERED, Some(&job_id), now).await?;
+    Ok(job_id)
+}
+
+/// Pause or resume schedule `name`. Resuming starts from the next
+/// occurrence after now, so the runs missed while paused don't fire.
+/// `None` when no row has that name.
+pub async fn set_paused(
+    ctx: &dyn Context,
+    name: &str,
+    paused: bool,
+) -> Result<Option<ScheduleStatus>, WaferError> {
+    let Some(row) = find(ctx, name).await? else {
+        return Ok(None);
+    };
+    let now = Utc::now();
+    let specs = registered();
+    let mut data = HashMap::new();
+    data.insert("paused".to_string(), serde_json::json!(i64::from(paused)));
+    if !paused {
+        if let Some(spec) = specs.get(name) {
+            data.insert(
+                "next_run_at".to_string(),
+                serde_json::json!(next_run(spec, now)),
+            );
+        }
+    }
+    data.insert("updated_at".to_string(), serde_json::json!(timestamp(now)));
+    let row = db::update(ctx, SCHEDULES_TABLE, &row.id, data).await?;
+    Ok(Some(ScheduleStatus::from_record(
+        &row,
+        specs.contains_key(name),
+    )))
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::{
+        atomic::{AtomicUsize, Ordering},
+        Arc,
+    };
+
+    use wafer_run::{Block, BlockInfo, InputStream, LifecycleEvent, Message, OutputStream};
+
+    use super::*;
+    use crate::test_support::TestContext;
+
+    /// Counts the scheduled jobs it runs. Schedules are process-wide, so
+    /// each test targets its own block name: another test's schedules may
+    /// queue jobs in this test's context, but never for its ticker.
+    struct Ticker {
+        runs: AtomicUsize,
+    }
+
+    #[async_trait::async_trait]
+    impl Block for Ticker {
+        fn info(&self) -> BlockInfo {
+            BlockInfo::new("test/ticker", "0.0.1", "http-handler@v1", "schedules")
+        }
+        async fn handle(
+            &self,
+            _ctx: &dyn Context,
+            msg: Message,
+            input: InputStream,
+        ) -> OutputStream {
+            let payload: serde_json::Value =
+                serde_json::from_slice(&input.collect_to_bytes().await).unwrap();
+            assert_eq!(jobs::job_type(&msg), "test.tick");
+            assert!(payload["schedule"].as_str().unwrap().starts_with("test."));
+            self.runs.fetch_add(1, Ordering::SeqCst);
+            jobs::respond(Ok(()))
+        }
+        async fn lifecycle(
+            &self,
+            _ctx: &dyn Context,
+            _e: LifecycleEvent,
+        ) -> Result<(), WaferError> {
+            Ok(())
+        }
+    }
+
+    async fn ctx_with_ticker(block: &str) -> (TestContext, Arc<Ticker>) {
+        let mut ctx = TestContext::with_admin().await;
+        let ticker = Arc::new(Ticker {
+            runs: AtomicUsize::new(0),
+        });
+        ctx.register_block(block, ticker.clone());
+        (ctx, ticker)
+    }
+
+    fn at(s: &str) -> DateTime<Utc> {
+        DateTime::parse_from_rfc3339(s).unwrap().with_timezone(&Utc)
+    }
+
+    async fn row(ctx: &TestContext, name: &str) -> Record {
+        find(ctx, name).await.unwrap().unwrap()
+    }
+
+    /// Point `name`'s next run at `due`, as if it had been synced then.
+    async fn due_at(ctx: &TestContext, name: &str, due: &str) {
+        let id = row(ctx, name).await.id;
+        let mut data = HashMap::new();
+        data.insert("next_run_at".to_string(), serde_json::json!(due));
+        db::update(ctx, SCHEDULES_TABLE, &id, data).await.unwrap();
+    }
+
+    #[test]
+    fn register_validates_the_spec() {
+        assert!(register(ScheduleSpec::new("Bad Name", "@daily", "b", "t")).is_err());
+        assert!(register(ScheduleSpec::new("test.cron", "61 * * * *", "b", "t")).is_err());
+        assert!(register(ScheduleSpec::new("test.job", "@daily", "b", "")).is_err());
+        let builtin = ScheduleSpec::new(super::super::retention::SCHEDULE, "@daily", "b", "t");
+        assert!(register(builtin).is_err());
+    }
+
+    #[tokio::test]
+    async fn due_schedule_queues_once_and_moves_on() {
+        let (ctx, ticker) = ctx_with_ticker("test/fire").await;
+        register(ScheduleSpec::new(
+            "test.fire",
+            "*/5 * * * *",
+            "test/fire",
+            "test.tick",
+        ))
+        .unwrap();
+
+        // The first sync only sets the next run.
+        let now = at("2026-03-01T10:02:00Z");
+        tick(&ctx, now).await.unwrap();
+        assert_eq!(
+            row(&ctx, "test.fire").await.str_field("next_run_at"),
+            "2026-03-01T10:05:00.000000Z"
+        );
+        assert_eq!(ticker.runs.load(Ordering::SeqCst), 0);
+
+        let now = at("2026-03-01T10:05:30Z");
+        tick(&ctx, now).await.unwrap();
+        tick(&ctx, now).await.unwrap();
+        assert_eq!(ticker.runs.load(Ordering::SeqCst), 1);
+        let r = row(&ctx, "test.fire").await;
+        assert_eq!(r.str_field("next_run_at"), "2026-03-01T10:10:00.000000Z");
+        assert_eq!(r.str_field("last_outcome"), OUTCOME_QUEUED);
+    }
+
+    #[tokio::test]
+    async fn overlapping_run_is_skipped() {
+        // Nothing answers for `test/absent`, so its job stays pending.
+        let ctx = TestContext::with_admin().await;
+        register(ScheduleSpec::new(
+            "test.slow",
+            "* * * * *",
+            "test/absent",
+            "test.tick",
+        ))
+        .unwrap();
+        tick(&ctx, at("2026-03-01T10:00:00Z")).await.unwrap();
+
+        tick(&ctx, at("2026-03-01T10:01:00Z")).await.unwrap();
+        let r = row(&ctx, "test.slow").await;
+        assert_eq!(r.str_field("last_outcome"), OUTCOME_QUEUED);
+        let first_job = r.str_field("last_job_id").to_string();
+        tick(&ctx, at("2026-03-01T10:02:00Z")).await.unwrap();
+        let r = row(&ctx, "test.slow").await;
+        assert_eq!(r.str_field("last_outcome"), OUTCOME_OVERLAP);
+        assert_eq!(r.str_field("last_job_id"), first_job);
+        assert!(matches!(
+            trigger(&ctx, "test.slow").await,
+            Err(TriggerError::Running)
+        ));
+    }
+
+    #[tokio::test]
+    async fn missed_runs_skip_unless_catch_up() {
+        let (ctx, ticker) = ctx_with_ticker("test/missed").await;
+        register(ScheduleSpec::new(
+            "test.skip",
+            "0 * * * *",
+            "test/missed",
+            "test.tick",
+        ))
+        .unwrap();
+        register(
+            ScheduleSpec::new("test.catchup", "0 * * * *", "test/missed", "test.tick")
+                .catch_up(true),
+        )
+        .unwrap();
+        tick(&ctx, at("2026-03-01T10:30:00Z")).await.unwrap();
+
+        // Down from before 11:00 until 13:30: one catch-up run, one skip.
+        tick(&ctx, at("2026-03-01T13:30:00Z")).await.unwrap();
+        assert_eq!(ticker.runs.load(Ordering::SeqCst), 1);
+        assert_eq!(
+            row(&ctx, "test.skip").await.str_field("last_outcome"),
+            OUTCOME_MISSED
+        );
+        for name in ["test.skip", "test.catchup"] {
+            assert_eq!(
+                row(&ctx, name).await.str_field("next_run_at"),
+                "2026-03-01T14:00:00.000000Z"
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn paused_schedule_does_not_run_until_resumed() {
+        let (ctx, ticker) = ctx_with_ticker("test/pause").await;
+        register(ScheduleSpec::new(
+            "test.pause",
+            "* * * * *",
+            "test/pause",
+            "test.tick",
+        ))
+        .unwrap();
+        let status = set_paused(&ctx, "test.pause", true).await.unwrap().unwrap();
+        assert!(status.paused && status.registered);
+
+        due_at(&ctx, "test.pause", "2026-03-01T10:00:00Z").await;
+        tick(&ctx, at("2026-03-01T10:00:30Z")).await.unwrap();
+        assert_eq!(ticker.runs.load(Ordering::SeqCst), 0);
+
+        let status = set_paused(&ctx, "test.pause", false)
+            .await
+            .unwrap()
+            .unwrap();
+        assert!(!status.paused);
+        assert!(crate::util::parse_timestamp(&status.next_run_at).unwrap() > Utc::now());
+        assert!(set_paused(&ctx, "test.nope", true).await.unwrap().is_none());
+    }
+
+    #[tokio::test]
+    async fn manual_trigger_runs_now_and_keeps_the_schedule() {
+        let (ctx, ticker) = ctx_with_ticker("test/manual").await;
+        register(ScheduleSpec::new(
+            "test.manual",
+            "@yearly",
+            "test/manual",
+            "test.tick",
+        ))
+        .unwrap();
+        let next = row(&ctx, "test.manual")
+            .await
+            .str_field("next_run_at")
+            .to_string();
+
+        let job_id = trigger(&ctx, "test.manual").await.unwrap();
+        assert_eq!(ticker.runs.load(Ordering::SeqCst), 1);
+        let r = row(&ctx, "test.manual").await;
+        assert_eq!(r.str_field("last_job_id"), job_id);
+        assert_eq!(r.str_field("last_outcome"), OUTCOME_TRIGGERED);
+        assert_eq!(r.str_field("next_run_at"), next);
+        assert!(matches!(
+            trigger(&ctx, "test.unknown").await,
+            Err(TriggerError::NotFound)
+        ));
+    }
+
+    #[tokio::test]
+    async fn builtin_schedules_are_listed() {
+        let ctx = TestContext::with_admin().await;
+        let listed = list(&ctx).await.unwrap();
+        let retention = listed
+            .iter()
+            .find(|s| s.name == super::super::retention::SCHEDULE)
+            .unwrap();
+        assert_eq!(retention.cron, "@hourly");
+        assert!(retention.registered && !retention.paused);
+        assert!(!retention.next_run_at.is_empty());
+    }
+}
@@ -415,12 +415,13 @@ impl Block for SolobaseStorageBlock {
     }
 }
 
-/// Log a storage access event (best-effort).
+/// Log a storage access event (best-effort). Also used by `suppers-ai/files`
+/// for moves, which the wrapper only sees as separate reads and writes.
 ///
 /// `status` is taken by value so callers in the streaming producer can hand
 /// off an owned, formatted string without the returned future borrowing a
 /// closure-local.
-async fn log_storage_access(
+pub(crate) async fn log_storage_access(
     ctx: &dyn Context,
     source_block: &str,
     operation: &str,
@@ -1,12 +1,20 @@
-use wafer_run::{BlockEndpoint, BlockInfo, InstanceMode};
+use std::time::Duration;
+
+use wafer_run::{BlockEndpoint, BlockInfo, InstanceMode, Message, OutputStream};
 
 use crate::{
+    blocks::errors::{self, ErrorCode},
+    etag,
     http::{err_not_found, ok_json, ResponseBuilder},
-    ui,
+    i18n, revisions, ui,
 };
 
+/// How long `/api/sync` holds a request open before answering 204.
+const SYNC_WAIT: Duration = Duration::from_secs(25);
+
 crate::solobase_feature_block! {
-    /// System health checks and embedded static assets (`suppers-ai/system`).
+    /// System health checks, the build version, the message catalog and
+    /// embedded static assets (`suppers-ai/system`).
     pub struct SystemBlock;
     name: "suppers-ai/system",
     info: |_this| {
@@ -16,6 +24,9 @@ crate::solobase_feature_block! {
             .description("Core system services including health checks and embedded static assets (CSS, JavaScript).")
             .endpoints(vec![
                 BlockEndpoint::get("/health").summary("Health check"),
+                BlockEndpoint::get("/api/version").summary("Version (full build info for admins)"),
+                BlockEndpoint::get("/api/sync").summary("Long-poll for settings, flag and announcement changes"),
+                BlockEndpoint::get("/api/i18n/{locale}").summary("Message catalog for a locale, with admin translations merged in"),
                 BlockEndpoint::get("/b/static/app-{hash}.css").summary("Embedded CSS"),
                 BlockEndpoint::get("/b/static/htmx-{hash}.min.js").summary("Embedded htmx JS"),
                 BlockEndpoint::get("/b/static/marked-{hash}.min.js").summary("Embedded marked.js"),
@@ -28,13 +39,31 @@ crate::solobase_feature_block! {
                 BlockEndpoint::get("/b/static/favicon-{hash}.ico").summary("Embedded Solobase favicon"),
             ])
     },
-    handle: |_this, _ctx, msg, _input| {
+    handle: |_this, ctx, msg, _input| {
         let path = msg.path();
 
         if path == "/health" {
             return ok_json(&serde_json::json!({"status": "ok"}));
         }
 
+        // `/api/version`, with `/api` stripped by the pipeline. Anyone may
+        // learn the version; the commit, toolchain and platform are for
+        // admins.
+        if path == "/version" {
+            if crate::util::is_admin(msg) {
+                return ok_json(&crate::version::build_info());
+            }
+            return ok_json(&serde_json::json!({"version": crate::version::VERSION}));
+        }
+
+        if path == "/sync" {
+            return handle_sync(&msg).await;
+        }
+
+        if let Some(locale) = path.strip_prefix("/i18n/") {
+            return handle_catalog(ctx, &msg, locale).await;
+        }
+
         // Embedded static assets (CSS, JS, fonts) with content-hash URLs for
         // cache busting. The dispatch table replaces a stack of
         // `_ if path.starts_with(...) && path.ends_with(...)` arms — order
@@ -103,6 +132,64 @@ crate::solobase_feature_block! {
     },
 }
 
+/// `GET /api/sync?known={revision}`: which of settings, flags and
+/// announcements changed after `known`. Answers at once when something did;
+/// otherwise waits up to [`SYNC_WAIT`] for a write and answers 204 if none
+/// comes. Either way the revision to send next is in `X-Sync-Revision`.
+///
+/// Without a platform timer (Cloudflare, the browser build) the request
+/// can't be parked, so the 204 comes straight back with a `Retry-After` and
+/// clients fall back to plain polling.
+async fn handle_sync(msg: &Message) -> OutputStream {
+    // A missing or garbled revision reads as "knows nothing".
+    let known = msg.query("known").parse::<u64>().unwrap_or(0);
+    let changes = match crate::pipeline::request_timer() {
+        Some(sleep) => revisions::wait_for_changes(known, SYNC_WAIT, sleep).await,
+        None => revisions::changes_since(known),
+    };
+    let revision = changes.revision.to_string();
+    if !changes.changed.is_empty() {
+        let changed: Vec<&str> = changes.changed.iter().map(|c| c.as_str()).collect();
+        return ResponseBuilder::new()
+            .set_header("X-Sync-Revision", &revision)
+            .set_header("Cache-Control", "no-store")
+            .json(&serde_json::json!({"revision": changes.revision, "changed": changed}));
+    }
+    let mut response = ResponseBuilder::new()
+        .status(204)
+        .set_header("X-Sync-Revision", &revision)
+        .set_header("Cache-Control", "no-store");
+    if crate::pipeline::request_timer().is_none() {
+        response = response.set_header("Retry-After", &SYNC_WAIT.as_secs().to_string());
+    }
+    response.body(Vec::new(), "text/plain")
+}
+
+/// `GET /api/i18n/{locale}`: the catalog the server renders messages from
+/// for `locale` — the embedded English with the admins' translations for
+/// the locale's chain merged over it (see [`i18n::Translations::catalog`]).
+/// `locales` lists every locale that has translations, for a picker.
+async fn handle_catalog(
+    ctx: &dyn wafer_run::context::Context,
+    msg: &Message,
+    locale: &str,
+) -> OutputStream {
+    let Some(locale) = i18n::normalize(locale) else {
+        return errors::error_response(ErrorCode::InvalidInput, "Invalid locale");
+    };
+    let translations = i18n::load(ctx).await;
+    let chain = i18n::chain(&locale);
+    etag::ok_json(
+        msg,
+        &serde_json::json!({
+            "locale": locale,
+            "fallbacks": &chain[1..],
+            "locales": translations.locales(),
+            "messages": translations.catalog(&locale),
+        }),
+    )
+}
+
 #[cfg(test)]
 mod tests {
     use wafer_run::{
@@ -135,6 +222,124 @@ mod tests {
         }
     }
 
+    #[tokio::test]
+    async fn version_hides_build_details_from_non_admins() {
+        use crate::test_support::{admin_msg, output_json};
+
+        let block = SystemBlock::new();
+        let mut msg = Message::new("retrieve:/version");
+        msg.set_meta(wafer_run::META_REQ_ACTION, "retrieve");
+        msg.set_meta(wafer_run::META_REQ_RESOURCE, "/version");
+        let out = block.handle(&NopCtx, msg, InputStream::empty()).await;
+        assert_eq!(
+            output_json(out).await,
+            serde_json::json!({"version": crate::version::VERSION})
+        );
+
+        let msg = admin_msg("retrieve", "/version");
+        let json = output_json(block.handle(&NopCtx, msg, InputStream::empty()).await).await;
+        assert_eq!(json["version"], crate::version::VERSION);
+        assert!(json["commit"].is_string());
+        assert!(json["platform"].is_string());
+    }
+
+    #[tokio::test]
+    async fn sync_reports_categories_changed_after_the_known_revision() {
+        use crate::{
+            revisions::{bump, Category},
+            test_support::output_json,
+        };
+
+        let block = SystemBlock::new();
+        let sync = |known: &str| {
+            let mut msg = Message::new("retrieve:/sync");
+            msg.set_meta(wafer_run::META_REQ_ACTION, "retrieve");
+            msg.set_meta(wafer_run::META_REQ_RESOURCE, "/sync");
+            msg.set_meta("req.query.known", known);
+            block.handle(&NopCtx, msg, InputStream::empty())
+        };
+
+        // A client that knows nothing refetches everything.
+        let json = output_json(sync("").await).await;
+        assert_eq!(
+            json["changed"],
+            serde_json::json!(["settings", "flags", "announcements"])
+        );
+        let known = json["revision"].as_u64().unwrap();
+
+        bump(Category::Flags);
+        let json = output_json(sync(&known.to_string()).await).await;
+        assert_eq!(json["changed"], serde_json::json!(["flags"]));
+        assert_eq!(json["revision"], known + 1);
+    }
+
+    #[tokio::test]
+    async fn catalogs_and_error_messages_follow_the_callers_locale() {
+        use std::{collections::BTreeMap, sync::Arc};
+
+        use crate::{
+            blocks::auth::repo::users,
+            test_support::{TestApp, TestRequest},
+        };
+
+        let mut app = TestApp::new().await;
+        app.load_block("suppers-ai/system", Arc::new(SystemBlock::new()), &[], &[])
+            .await
+            .unwrap();
+        let german: BTreeMap<String, String> = [
+            ("ui.save", "Speichern"),
+            ("errors.invalid_input", "Ungültige Eingabe."),
+        ]
+        .into_iter()
+        .map(|(k, v)| (k.to_string(), v.to_string()))
+        .collect();
+        i18n::put_overrides(app.ctx(), "de", &german, "admin-1")
+            .await
+            .unwrap();
+
+        let res = app.request(TestRequest::get("/api/v1/i18n/de-AT")).await;
+        assert_eq!(res.status, 200, "{}", res.text());
+        let json = res.json();
+        assert_eq!(json["locale"], "de-at");
+        assert_eq!(json["fallbacks"], serde_json::json!(["de", "en"]));
+        assert_eq!(json["locales"], serde_json::json!(["de", "en"]));
+        assert_eq!(json["messages"]["ui.save"], "Speichern");
+        assert_eq!(json["messages"]["ui.cancel"], "Cancel");
+
+        // The error keeps its code; the message follows Accept-Language.
+        let res = app
+            .request(TestRequest::get("/api/v1/i18n/x").header("Accept-Language", "de, en;q=0.5"))
+            .await;
+        assert_eq!(res.status, 400);
+        let text = res.text();
+        assert!(text.contains("Ungültige Eingabe."), "{text}");
+        let res = app
+            .request(TestRequest::get("/api/v1/i18n/x").header("Accept-Language", "fr"))
+            .await;
+        assert!(res.text().contains("Invalid locale"), "{}", res.text());
+
+        // A profile preference beats the header.
+        let user = app.create_user("lena@example.com", "user").await;
+        users::update_profile_fields(
+            app.ctx(),
+            &user.id,
+            users::ProfileUpdate {
+                metadata: Some(serde_json::json!({"user": {"locale": "de"}})),
+                ..Default::default()
+            },
+        )
+        .await
+        .unwrap();
+        let res = app
+            .request(
+                TestRequest::get("/api/v1/i18n/x")
+                    .header("Accept-Language", "fr")
+                    .as_user(&user),
+            )
+            .await;
+        assert!(res.text().contains("Ungültige Eingabe."), "{}", res.text());
+    }
+
     #[tokio::test]
     async fn system_handle_serves_llm_chat_js() {
         let block = SystemBlock::new();
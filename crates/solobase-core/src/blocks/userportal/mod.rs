@@ -50,6 +50,7 @@ crate::solobase_feature_block! {
             BlockEndpoint::get("/b/userportal/sessions").summary("Active sessions").auth(AuthLevel::Authenticated),
             BlockEndpoint::delete("/b/userportal/sessions/:hash").summary("Revoke session").auth(AuthLevel::Authenticated),
             BlockEndpoint::get("/b/userportal/security").summary("Account security").auth(AuthLevel::Authenticated),
+            BlockEndpoint::post("/b/userportal/login-alerts").summary("Turn sign-in alerts on or off").auth(AuthLevel::Authenticated),
             BlockEndpoint::get("/b/userportal/config").summary("Portal configuration"),
             // Admin surface — declared in full so the central router enforces
             // the `Admin` tier (the block no longer hand-checks `is_admin` for
@@ -94,6 +95,7 @@ crate::solobase_feature_block! {
             ("create", "/update-profile") => handle_update_profile(ctx, &msg, input).await,
             ("retrieve", "/sessions") => pages::sessions::sessions_page(ctx, &msg).await,
             ("retrieve", "/security") => pages::security::security_page(ctx, &msg).await,
+            ("create", "/login-alerts") => handle_login_alerts(ctx, &msg, input).await,
             ("delete", s) if s.starts_with("/sessions/") => {
                 pages::sessions::handle_revoke(ctx, &msg, s).await
             }
@@ -265,6 +267,29 @@ async fn handle_update_profile(
     crate::http::redirect(303, "/b/userportal/profile")
 }
 
+// ---------------------------------------------------------------------------
+// User-facing: Sign-in alerts
+// ---------------------------------------------------------------------------
+
+/// Form POST from the security page. An unchecked checkbox is simply absent
+/// from the body, so absence means "off".
+async fn handle_login_alerts(ctx: &dyn Context, msg: &Message, input: InputStream) -> OutputStream {
+    let user_id = msg.user_id().to_string();
+    if user_id.is_empty() {
+        return err_forbidden("Not authenticated");
+    }
+
+    let raw = input.collect_to_bytes().await;
+    let enabled = parse_form_body(&raw).contains_key("login_alerts");
+    if let Err(e) =
+        crate::blocks::auth::repo::users::set_mute_login_alerts(ctx, &user_id, !enabled).await
+    {
+        return err_internal("Failed to update sign-in alerts", e.to_string());
+    }
+
+    crate::http::redirect(303, "/b/userportal/security")
+}
+
 // ---------------------------------------------------------------------------
 // Admin: Branding Settings
 // ---------------------------------------------------------------------------
@@ -1,5 +1,5 @@
 //! `/b/userportal/security` — change password + linked OAuth providers
-//! + email verification status.
+//! + email verification status + new-device sign-in alerts.
 
 use maud::html;
 use wafer_run::{context::Context, Message, OutputStream};
@@ -31,6 +31,10 @@ pub async fn security_page(ctx: &dyn Context, msg: &Message) -> OutputStream {
         }
     };
     let user_email = msg.get_meta("auth.user_email").to_string();
+    let login_alerts = match users::find_active_by_id(ctx, &user_id).await {
+        Ok(Some(u)) => !u.mute_login_alerts,
+        _ => true,
+    };
 
     let body = html! {
         section .account-section {
@@ -84,6 +88,16 @@ pub async fn security_page(ctx: &dyn Context, msg: &Message) -> OutputStream {
                 { "Resend verification email" }
             }
         }
+        section .account-section {
+            h2 .account-section__title { "Sign-in alerts" }
+            form method="post" action="/b/userportal/login-alerts" {
+                label style="display:flex;gap:0.5rem;align-items:center;margin:0 0 0.75rem" {
+                    input type="checkbox" name="login_alerts" value="on" checked[login_alerts];
+                    "Email me when my account is signed in to from a new device"
+                }
+                button .btn .btn-secondary type="submit" style="width:100%" { "Save" }
+            }
+        }
         section .account-section {
             h2 .account-section__title { "Linked accounts" }
             @if links.is_empty() {
@@ -212,6 +226,22 @@ mod tests {
         assert!(html.contains("name=\"new_password\""));
     }
 
+    #[tokio::test]
+    async fn login_alerts_toggle_reflects_preference() {
+        let ctx = TestContext::with_auth().await;
+        seed_user(&ctx, "user-a").await;
+        let msg = auth_msg("retrieve", "/b/userportal/security", "user-a");
+        let html = output_html(security_page(&ctx, &msg).await).await;
+        assert!(html.contains("/b/userportal/login-alerts"));
+        assert!(html.contains(r#"name="login_alerts" value="on" checked"#));
+
+        users::set_mute_login_alerts(&ctx, "user-a", true)
+            .await
+            .unwrap();
+        let html = output_html(security_page(&ctx, &msg).await).await;
+        assert!(!html.contains(r#"name="login_alerts" value="on" checked"#));
+    }
+
     #[tokio::test]
     async fn linked_accounts_empty_state() {
         let ctx = TestContext::with_auth().await;
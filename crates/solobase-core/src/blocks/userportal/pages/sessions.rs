@@ -1,10 +1,17 @@
-//! `/b/userportal/sessions` — list active sessions, revoke individual ones.
+//! `/b/userportal/sessions` — list signed-in devices, revoke individual ones.
+//!
+//! Every refresh rotation writes a new session row in the same refresh
+//! family, so rows are shown one per family (the most recently used) and
+//! revoking one signs the whole device out.
 
 use maud::{html, Markup};
 use wafer_run::{context::Context, Message, OutputStream};
 
 use crate::{
-    blocks::auth::{repo::sessions, service::hash_token},
+    blocks::auth::{
+        repo::{sessions, tokens},
+        service::hash_token,
+    },
     http::{redirect, ResponseBuilder},
     ui::{
         components::{badge, BadgeVariant},
@@ -64,6 +71,28 @@ fn current_session_hash(msg: &Message) -> Option<Vec<u8>> {
     Some(hash_token(cookie))
 }
 
+/// One row per device: rows sharing a refresh family collapse into the
+/// first (most recently used) one, flagged current if any row of the family
+/// matches `current_hash`. Rows from before families were recorded stand
+/// alone.
+fn by_device<'a>(
+    rows: &'a [sessions::SessionRow],
+    current_hash: Option<&[u8]>,
+) -> Vec<(&'a sessions::SessionRow, bool)> {
+    let mut out: Vec<(&sessions::SessionRow, bool)> = Vec::new();
+    for r in rows {
+        let is_current = current_hash.is_some_and(|h| h == r.token_hash.as_slice());
+        let seen = out
+            .iter_mut()
+            .find(|(d, _)| !r.family.is_empty() && d.family == r.family);
+        match seen {
+            Some((_, current)) => *current |= is_current,
+            None => out.push((r, is_current)),
+        }
+    }
+    out
+}
+
 fn render_table(rows: &[sessions::SessionRow], current_hash: Option<&[u8]>) -> Markup {
     if rows.is_empty() {
         return html! {
@@ -74,6 +103,7 @@ fn render_table(rows: &[sessions::SessionRow], current_hash: Option<&[u8]>) -> M
         table .data-table {
             thead {
                 tr {
+                    th { "Device" }
                     th { "Started" }
                     th { "Last used" }
                     th { "Expires" }
@@ -81,16 +111,16 @@ fn render_table(rows: &[sessions::SessionRow], current_hash: Option<&[u8]>) -> M
                 }
             }
             tbody {
-                @for r in rows {
-                    @let is_current = current_hash.is_some_and(|h| h == r.token_hash.as_slice());
+                @for (r, is_current) in by_device(rows, current_hash) {
                     tr .session-row {
-                        td data-label="Started" {
-                            (r.created_at)
+                        td data-label="Device" {
+                            @if r.device_label.is_empty() { "Unknown device" } @else { (r.device_label) }
                             @if is_current {
                                 " "
                                 (badge(BadgeVariant::Success, "Current session"))
                             }
                         }
+                        td data-label="Started" { (r.created_at) }
                         td data-label="Last used" { (r.last_used_at) }
                         td data-label="Expires" { (r.expires_at) }
                         td data-label="" {
@@ -111,6 +141,9 @@ fn render_table(rows: &[sessions::SessionRow], current_hash: Option<&[u8]>) -> M
 /// user_id — refusing to revoke another user's session looks indistinguishable
 /// from "no such session" (returns 200 with no body either way; htmx removes
 /// the row). Returns 401 if anonymous, 400 if hex is malformed.
+///
+/// A row with a refresh family signs out the whole device: every session row
+/// of the family goes, and the family's refresh tokens are revoked.
 pub async fn handle_revoke(ctx: &dyn Context, msg: &Message, sub: &str) -> OutputStream {
     let user_id = msg.user_id().to_string();
     if user_id.is_empty() {
@@ -127,7 +160,18 @@ pub async fn handle_revoke(ctx: &dyn Context, msg: &Message, sub: &str) -> Outpu
                 .body(b"bad token_hash".to_vec(), "text/plain");
         }
     };
-    let _ = sessions::delete_for_user(ctx, &user_id, &hash).await;
+    let family = match sessions::find_by_token_hash(ctx, &hash).await {
+        Ok(Some(row)) if row.user_id == user_id => row.family,
+        _ => String::new(),
+    };
+    if family.is_empty() {
+        let _ = sessions::delete_for_user(ctx, &user_id, &hash).await;
+    } else {
+        let _ = sessions::delete_family_for_user(ctx, &user_id, &family).await;
+        if let Err(e) = tokens::revoke_family(ctx, &family).await {
+            tracing::warn!(user_id = %user_id, "revoking refresh family failed: {e}");
+        }
+    }
     // Empty 200 — htmx swaps the row out via outerHTML.
     ResponseBuilder::new()
         .status(200)
@@ -141,7 +185,7 @@ mod tests {
     use super::*;
     use crate::{
         blocks::auth::{
-            repo::sessions::{insert, NewSession},
+            repo::sessions::{create_for_user, insert, NewSession, SessionOrigin},
             service::hash_token,
         },
         test_support::{anon_msg, auth_msg, output_html, output_status, TestContext},
@@ -219,6 +263,57 @@ mod tests {
         );
     }
 
+    async fn seed_device_session(ctx: &TestContext, hash_byte: u8, family: &str, label: &str) {
+        let origin = SessionOrigin {
+            family: family.into(),
+            device_info: format!("fp-{family}"),
+            device_label: label.into(),
+        };
+        create_for_user(ctx, "user-a", vec![hash_byte; 32], 7, &origin)
+            .await
+            .unwrap();
+    }
+
+    #[tokio::test]
+    async fn rotations_of_one_device_render_as_one_labelled_row() {
+        let ctx = TestContext::with_auth().await;
+        seed_user(&ctx, "user-a").await;
+        seed_device_session(&ctx, 0x01, "fam-laptop", "Firefox on Linux").await;
+        seed_device_session(&ctx, 0x02, "fam-laptop", "Firefox on Linux").await;
+        seed_device_session(&ctx, 0x03, "fam-phone", "Safari on iOS").await;
+        insert(&ctx, fake_session("user-a", 0x04)).await.unwrap();
+
+        let msg = auth_msg("retrieve", "/b/userportal/sessions", "user-a");
+        let html = output_html(sessions_page(&ctx, &msg).await).await;
+
+        assert_eq!(html.matches(">Revoke<").count(), 3);
+        assert_eq!(html.matches("Firefox on Linux").count(), 1);
+        assert!(html.contains("Safari on iOS"));
+        assert!(html.contains("Unknown device"));
+    }
+
+    #[tokio::test]
+    async fn revoking_a_device_removes_its_whole_family() {
+        let ctx = TestContext::with_auth().await;
+        seed_user(&ctx, "user-a").await;
+        seed_device_session(&ctx, 0x01, "fam-laptop", "Firefox on Linux").await;
+        seed_device_session(&ctx, 0x02, "fam-laptop", "Firefox on Linux").await;
+        seed_device_session(&ctx, 0x03, "fam-phone", "Safari on iOS").await;
+
+        let hex_hash: String = (0..32).map(|_| "02".to_string()).collect();
+        let msg = auth_msg(
+            "delete",
+            &format!("/b/userportal/sessions/{hex_hash}"),
+            "user-a",
+        );
+        let resp = handle_revoke(&ctx, &msg, &format!("/sessions/{hex_hash}")).await;
+        assert_eq!(output_status(resp).await, 200);
+
+        let left = sessions::list_for_user(&ctx, "user-a").await.unwrap();
+        assert_eq!(left.len(), 1);
+        assert_eq!(left[0].family, "fam-phone");
+    }
+
     #[tokio::test]
     async fn revoke_anonymous_returns_401() {
         let ctx = TestContext::with_auth().await;
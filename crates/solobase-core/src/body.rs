@@ -0,0 +1,537 @@
+//! Shared JSON request-body decoding for the API handlers.
+//!
+//! [`decode`] replaces the `serde_json::from_slice` + `"Invalid body: {e}"`
+//! pattern. It checks the `Content-Type`, caps the body size, rejects fields
+//! the target type does not declare, and turns every serde failure into the
+//! structured envelope from [`crate::blocks::errors`]:
+//!
+//! | failure | status | `code` | `details` |
+//! |---|---|---|---|
+//! | `Content-Type` not JSON | 415 | `unsupported_media_type` | — |
+//! | body over the cap | 413 | `payload_too_large` | — |
+//! | malformed JSON | 400 | `invalid_input` | `{line, column}` |
+//! | wrong type / bad value | 400 | `validation_failed` | `{"<path>": "expected <type>"}` |
+//! | missing field | 400 | `validation_failed` | `{"<path>": "required"}` |
+//! | undeclared field | 400 | `validation_failed` | `{"<path>": "unknown field"}` |
+//!
+//! Paths are dotted with bracketed indices (`items[2].price`); the
+//! frontend keys its per-field messages off them. A missing
+//! `Content-Type` is accepted: block-to-block calls do not set one.
+//!
+//! Required fields are the ones the request struct declares without
+//! `Option` or `#[serde(default)]`. A present-but-blank string is the
+//! caller's to reject, with [`require`].
+
+use serde::de::DeserializeOwned;
+use wafer_run::{InputStream, Message, OutputStream};
+
+use crate::blocks::errors::{error_json, validation_error, ErrorCode};
+
+/// Body cap used by [`decode`]. JSON requests are small; uploads stream
+/// through their own handlers.
+pub const DEFAULT_MAX_BYTES: i64 = 1024 * 1024;
+
+/// Decode the request body as `T`, capped at [`DEFAULT_MAX_BYTES`]. The
+/// `Err` is the response to return as-is.
+pub async fn decode<T: DeserializeOwned>(
+    msg: &Message,
+    input: InputStream,
+) -> Result<T, OutputStream> {
+    decode_capped(msg, input, DEFAULT_MAX_BYTES).await
+}
+
+/// [`decode`] with an endpoint-specific body cap (`0` = none).
+pub async fn decode_capped<T: DeserializeOwned>(
+    msg: &Message,
+    input: InputStream,
+    max_bytes: i64,
+) -> Result<T, OutputStream> {
+    require_json(msg)?;
+    let Ok(raw) = crate::util::collect_with_cap(input, max_bytes).await else {
+        return Err(error_json(
+            ErrorCode::PayloadTooLarge,
+            "Request body too large",
+            None,
+        ));
+    };
+    decode_bytes(&raw).map_err(|e| e.response())
+}
+
+/// 415 unless the request's `Content-Type` is JSON (see
+/// [`is_json_content_type`]). For handlers that read the body themselves.
+pub fn require_json(msg: &Message) -> Result<(), OutputStream> {
+    if is_json_content_type(msg.get_meta("req.content_type")) {
+        Ok(())
+    } else {
+        Err(error_json(
+            ErrorCode::UnsupportedMediaType,
+            "Content-Type must be application/json",
+            None,
+        ))
+    }
+}
+
+/// Whether `content_type` names JSON (`application/json`, any `+json`
+/// type, or nothing at all). Parameters such as `charset` are ignored.
+pub fn is_json_content_type(content_type: &str) -> bool {
+    let mime = content_type
+        .split(';')
+        .next()
+        .unwrap_or("")
+        .trim()
+        .to_ascii_lowercase();
+    mime.is_empty() || mime == "application/json" || mime.ends_with("+json")
+}
+
+/// Why a body did not decode.
+#[derive(Debug, PartialEq, Eq)]
+pub enum BodyError {
+    /// Not JSON at all; `line`/`column` are serde's (1-based).
+    Malformed {
+        line: usize,
+        column: usize,
+        reason: String,
+    },
+    /// Valid JSON of the wrong shape: `(path, reason)` pairs.
+    Fields(Vec<(String, String)>),
+}
+
+impl BodyError {
+    /// The error envelope for this failure.
+    pub fn response(&self) -> OutputStream {
+        match self {
+            Self::Malformed {
+                line,
+                column,
+                reason,
+            } => error_json(
+                ErrorCode::InvalidInput,
+                &format!("Malformed JSON at line {line} column {column}: {reason}"),
+                Some(serde_json::json!({ "line": line, "column": column })),
+            ),
+            Self::Fields(fields) => {
+                let fields: Vec<(&str, &str)> = fields
+                    .iter()
+                    .map(|(path, reason)| (path.as_str(), reason.as_str()))
+                    .collect();
+                validation_error("Invalid request body", &fields)
+            }
+        }
+    }
+}
+
+/// Decode `raw` as `T`, rejecting undeclared fields. The pure half of
+/// [`decode`].
+pub fn decode_bytes<T: DeserializeOwned>(raw: &[u8]) -> Result<T, BodyError> {
+    let mut unknown = Vec::new();
+    let mut de = serde_json::Deserializer::from_slice(raw);
+    let decoded = serde_ignored::deserialize(&mut de, |path| {
+        unknown.push((ignored_path(&path), "unknown field".to_string()))
+    })
+    .and_then(|value| de.end().map(|()| value));
+    match decoded {
+        Ok(value) if unknown.is_empty() => Ok(value),
+        Ok(_) => Err(BodyError::Fields(unknown)),
+        Err(e) => {
+            let reason = serde_reason(&e);
+            if !e.is_data() {
+                return Err(BodyError::Malformed {
+                    line: e.line(),
+                    column: e.column(),
+                    reason,
+                });
+            }
+            let offset = byte_offset(raw, e.line(), e.column());
+            let field = if let Some(name) = missing_field(&reason) {
+                // serde reports a missing field at the closing `}` of its
+                // object; the object's own path is everything before it.
+                let parent = path_at(&raw[..offset.saturating_sub(1)], false);
+                let path = join(&parent, name);
+                (path, "required".to_string())
+            } else if let Some(name) = unknown_field(&reason) {
+                // A `#[serde(deny_unknown_fields)]` struct stops at the key.
+                let path = join(&path_at(&raw[..offset], true), name);
+                (path, "unknown field".to_string())
+            } else {
+                let path = path_at(&raw[..offset], true);
+                let path = if path.is_empty() {
+                    "body".to_string()
+                } else {
+                    path
+                };
+                (path, expected(&reason))
+            };
+            unknown.push(field);
+            Err(BodyError::Fields(unknown))
+        }
+    }
+}
+
+/// Reject blank values: `fields` pairs a field path with its (trimmed)
+/// value. Every blank one is listed as `required`.
+pub fn require(fields: &[(&str, &str)]) -> Result<(), OutputStream> {
+    let blank: Vec<(&str, &str)> = fields
+        .iter()
+        .filter(|(_, value)| value.trim().is_empty())
+        .map(|(field, _)| (*field, "required"))
+        .collect();
+    if blank.is_empty() {
+        Ok(())
+    } else {
+        Err(validation_error("Invalid request body", &blank))
+    }
+}
+
+/// serde's message without its ` at line L column C` suffix.
+fn serde_reason(e: &serde_json::Error) -> String {
+    let message = e.to_string();
+    match message.rfind(" at line ") {
+        Some(at) => message[..at].to_string(),
+        None => message,
+    }
+}
+
+/// The name in serde's "missing field `name`".
+fn missing_field(reason: &str) -> Option<&str> {
+    reason
+        .strip_prefix("missing field `")
+        .and_then(|rest| rest.strip_suffix('`'))
+}
+
+/// The name in serde's "unknown field `name`, expected ...".
+fn unknown_field(reason: &str) -> Option<&str> {
+    let rest = reason.strip_prefix("unknown field `")?;
+    rest.split_once('`').map(|(name, _)| name)
+}
+
+/// "invalid type: string \"x\", expected i64" → "expected i64"; messages
+/// without an expectation are passed through.
+fn expected(reason: &str) -> String {
+    match reason.rfind(", expected ") {
+        Some(at) => reason[at + 2..].to_string(),
+        None => reason.to_string(),
+    }
+}
+
+fn join(parent: &str, name: &str) -> String {
+    if parent.is_empty() {
+        name.to_string()
+    } else {
+        format!("{parent}.{name}")
+    }
+}
+
+/// Byte offset of serde's 1-based `line` / `column` (the column counts the
+/// bytes consumed on that line).
+fn byte_offset(raw: &[u8], line: usize, column: usize) -> usize {
+    let line_start = if line <= 1 {
+        0
+    } else {
+        raw.iter()
+            .enumerate()
+            .filter(|(_, b)| **b == b'\n')
+            .nth(line - 2)
+            .map_or(0, |(i, _)| i + 1)
+    };
+    (line_start + column).min(raw.len())
+}
+
+enum Frame {
+    Object { key: Option<String>, in_value: bool },
+    Array { index: usize },
+}
+
+/// Path to the value being read at the end of `prefix`, a prefix of a
+/// well-formed JSON document. With `innermost` false, the last open
+/// container's own entry is left off (the path of that container).
+fn path_at(prefix: &[u8], innermost: bool) -> String {
+    let mut stack: Vec<Frame> = Vec::new();
+    let mut i = 0;
+    while i < prefix.len() {
+        match prefix[i] {
+            b'"' => {
+                let start = i;
+                i += 1;
+                while i < prefix.len() && prefix[i] != b'"' {
+                    i += if prefix[i] == b'\\' { 2 } else { 1 };
+                }
+                if let Some(Frame::Object {
+                    key,
+                    in_value: false,
+                }) = stack.last_mut()
+                {
+                    let end = (i + 1).min(prefix.len());
+                    *key = serde_json::from_slice(&prefix[start..end]).ok();
+                }
+            }
+            b':' => {
+                if let Some(Frame::Object { in_value, .. }) = stack.last_mut() {
+                    *in_value = true;
+                }
+            }
+            b',' => match stack.last_mut() {
+                Some(Frame::Object { key, in_value }) => {
+                    *key = None;
+                    *in_value = false;
+                }
+                Some(Frame::Array { index }) => *index += 1,
+                None => {}
+            },
+            b'{' => stack.push(Frame::Object {
+                key: None,
+                in_value: false,
+            }),
+            b'[' => stack.push(Frame::Array { index: 0 }),
+            b'}' | b']' => {
+                stack.pop();
+            }
+            _ => {}
+        }
+        i += 1;
+    }
+    if !innermost {
+        stack.pop();
+    }
+    let mut path = String::new();
+    for frame in &stack {
+        match frame {
+            Frame::Object {
+                key: Some(key),
+                in_value: true,
+            } => path = join(&path, key),
+            Frame::Object { .. } => {}
+            Frame::Array { index } => path.push_str(&format!("[{index}]")),
+        }
+    }
+    path
+}
+
+/// `serde_ignored`'s path in [`decode`]'s notation (`a.b[0].c`, with the
+/// `Option` / newtype hops dropped).
+fn ignored_path(path: &serde_ignored::Path) -> String {
+    use serde_ignored::Path;
+    match path {
+        Path::Root => String::new(),
+        Path::Seq { parent, index } => format!("{}[{index}]", ignored_path(parent)),
+        Path::Map { parent, key } => join(&ignored_path(parent), key),
+        Path::Some { parent }
+        | Path::NewtypeStruct { parent }
+        | Path::NewtypeVariant { parent } => ignored_path(parent),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[derive(Debug, serde::Deserialize)]
+    #[allow(dead_code)]
+    struct Item {
+        sku: String,
+        qty: u32,
+    }
+
+    #[derive(Debug, serde::Deserialize)]
+    #[allow(dead_code)]
+    struct Req {
+        name: String,
+        size: i64,
+        #[serde(default)]
+        items: Vec<Item>,
+        note: Option<String>,
+        meta: Option<serde_json::Value>,
+    }
+
+    #[derive(Debug, serde::Deserialize)]
+    #[serde(deny_unknown_fields)]
+    #[allow(dead_code)]
+    struct Strict {
+        name: String,
+        inner: Option<Inner>,
+    }
+
+    #[derive(Debug, serde::Deserialize)]
+    #[serde(deny_unknown_fields)]
+    #[allow(dead_code)]
+    struct Inner {
+        n: u32,
+    }
+
+    fn fields(pairs: &[(&str, &str)]) -> BodyError {
+        BodyError::Fields(
+            pairs
+                .iter()
+                .map(|(p, r)| (p.to_string(), r.to_string()))
+                .collect(),
+        )
+    }
+
+    #[test]
+    fn translates_decode_failures() {
+        let cases: &[(&str, &str, BodyError)] = &[
+            (
+                "wrong scalar type",
+                r#"{"name":"a","size":"big"}"#,
+                fields(&[("size", "expected i64")]),
+            ),
+            (
+                "wrong type inside an array element",
+                r#"{"name":"a","size":1,"items":[{"sku":"x","qty":1},{"sku":"y","qty":"two"}]}"#,
+                fields(&[("items[1].qty", "expected u32")]),
+            ),
+            (
+                "negative into unsigned",
+                r#"{"name":"a","size":1,"items":[{"sku":"x","qty":-1}]}"#,
+                fields(&[("items[0].qty", "expected u32")]),
+            ),
+            (
+                "missing top-level field",
+                r#"{"name":"a"}"#,
+                fields(&[("size", "required")]),
+            ),
+            (
+                "missing nested field",
+                r#"{"name":"a","size":1,"items":[{"sku":"x"}]}"#,
+                fields(&[("items[0].qty", "required")]),
+            ),
+            (
+                "unknown top-level field",
+                r#"{"name":"a","size":1,"colour":"red"}"#,
+                fields(&[("colour", "unknown field")]),
+            ),
+            (
+                "unknown nested field",
+                r#"{"name":"a","size":1,"items":[{"sku":"x","qty":1,"extra":true}]}"#,
+                fields(&[("items[0].extra", "unknown field")]),
+            ),
+            (
+                "body is not an object",
+                r#""just a string""#,
+                fields(&[("body", "expected struct Req")]),
+            ),
+            (
+                "escaped key",
+                "{\"name\":\"a\",\"si\\u007ae\":true}",
+                fields(&[("size", "expected i64")]),
+            ),
+            (
+                "trailing comma",
+                r#"{"name":"a",}"#,
+                BodyError::Malformed {
+                    line: 1,
+                    column: 13,
+                    reason: "trailing comma".to_string(),
+                },
+            ),
+            (
+                "empty body",
+                "",
+                BodyError::Malformed {
+                    line: 1,
+                    column: 0,
+                    reason: "EOF while parsing a value".to_string(),
+                },
+            ),
+            (
+                "trailing garbage",
+                r#"{"name":"a","size":1} x"#,
+                BodyError::Malformed {
+                    line: 1,
+                    column: 23,
+                    reason: "trailing characters".to_string(),
+                },
+            ),
+        ];
+        for (name, raw, want) in cases {
+            let got = decode_bytes::<Req>(raw.as_bytes()).unwrap_err();
+            assert_eq!(&got, want, "{name}");
+        }
+    }
+
+    #[test]
+    fn deny_unknown_fields_structs_report_the_same_path() {
+        for (raw, path) in [
+            (r#"{"name":"a","colour":"red"}"#, "colour"),
+            (r#"{"name":"a","inner":{"n":1,"m":2}}"#, "inner.m"),
+        ] {
+            assert_eq!(
+                decode_bytes::<Strict>(raw.as_bytes()).unwrap_err(),
+                fields(&[(path, "unknown field")]),
+                "{raw}"
+            );
+        }
+    }
+
+    #[test]
+    fn multi_line_bodies_locate_the_field() {
+        let raw = "{\n  \"name\": \"a\",\n  \"size\": 1,\n  \"items\": [\n    {\"sku\": 5, \"qty\": 1}\n  ]\n}";
+        assert_eq!(
+            decode_bytes::<Req>(raw.as_bytes()).unwrap_err(),
+            fields(&[("items[0].sku", "expected a string")])
+        );
+    }
+
+    #[test]
+    fn accepts_declared_and_free_form_fields() {
+        let req: Req =
+            decode_bytes(br#"{"name":"a","size":2,"note":null,"meta":{"anything":[1]}}"#).unwrap();
+        assert_eq!(req.size, 2);
+        assert!(req.items.is_empty());
+    }
+
+    #[test]
+    fn json_content_types() {
+        for ok in [
+            "",
+            "application/json",
+            "application/json; charset=utf-8",
+            "Application/JSON",
+            "application/merge-patch+json",
+        ] {
+            assert!(is_json_content_type(ok), "{ok}");
+        }
+        for bad in [
+            "text/plain",
+            "application/x-www-form-urlencoded",
+            "multipart/form-data; boundary=x",
+        ] {
+            assert!(!is_json_content_type(bad), "{bad}");
+        }
+    }
+
+    #[tokio::test]
+    async fn decode_checks_content_type_and_size() {
+        use crate::test_support::{output_json, output_status};
+
+        let mut msg = Message::new("create:/b/test");
+        msg.set_meta("req.content_type", "text/plain");
+        let out = decode::<Req>(&msg, InputStream::from_bytes(b"{}".to_vec()))
+            .await
+            .unwrap_err();
+        assert_eq!(output_status(out).await, 415);
+
+        msg.set_meta("req.content_type", "application/json");
+        let big = format!(r#"{{"name":"{}","size":1}}"#, "a".repeat(64));
+        let out = decode_capped::<Req>(&msg, InputStream::from_bytes(big.into_bytes()), 16)
+            .await
+            .unwrap_err();
+        assert_eq!(output_status(out).await, 413);
+
+        let out = decode::<Req>(&msg, InputStream::from_bytes(br#"{"name":"a"}"#.to_vec()))
+            .await
+            .unwrap_err();
+        let body = output_json(out).await;
+        assert_eq!(body["code"], "validation_failed");
+        assert_eq!(body["details"]["size"], "required");
+    }
+
+    #[tokio::test]
+    async fn require_lists_every_blank_field() {
+        assert!(require(&[("name", "x")]).is_ok());
+        let out = require(&[("name", " "), ("email", "a@b.c"), ("token", "")]).unwrap_err();
+        let body = crate::test_support::output_json(out).await;
+        assert_eq!(body["details"]["name"], "required");
+        assert_eq!(body["details"]["token"], "required");
+        assert!(body["details"].get("email").is_none());
+    }
+}
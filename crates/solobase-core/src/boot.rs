@@ -37,7 +37,7 @@ fn build_variable_row(
     sensitive: bool,
     block: &str,
 ) -> HashMap<String, serde_json::Value> {
-    let now = chrono::Utc::now().to_rfc3339();
+    let now = crate::util::now_rfc3339();
     let id = format!("var_{}", uuid::Uuid::new_v4());
     let mut data: HashMap<String, serde_json::Value> = HashMap::new();
     data.insert("id".into(), serde_json::Value::String(id));
@@ -421,7 +421,7 @@ mod wrap_grants_tests {
         // real embedded schema rather than a hand-rolled CREATE TABLE.
         crate::migration_helper::apply_ddl_via_service(
             &db,
-            crate::blocks::admin::migrations::ddl_files("sqlite"),
+            &crate::blocks::admin::migrations::ddl_files("sqlite"),
         )
         .await
         .expect("apply admin migrations");
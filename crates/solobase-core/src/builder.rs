@@ -29,7 +29,8 @@ use wafer_run::{Block, RuntimeError, Wafer};
 use crate::{
     blocks::{router::SolobaseRouterBlock, storage::SolobaseStorageBlock},
     features::{BlockSettings, FeatureConfig},
-    ExtraRoute, RouteAccess,
+    routing::RouteListing,
+    ExtraRoute, RouteAccess, RouteSource,
 };
 
 pub struct SolobaseBuilder {
@@ -81,6 +82,15 @@ pub struct SolobaseBuilder {
     /// should pass `EnvConfigSource`; cloudflare consumers pass
     /// `D1ConfigSource`.
     config_source: Option<Arc<dyn wafer_run::ConfigSource>>,
+    /// Cron schedules registered by downstream projects via `schedule`.
+    /// Validated and registered in `build()`.
+    schedules: Vec<crate::blocks::schedules::ScheduleSpec>,
+    /// Path prefix Solobase is mounted under, as passed to `base_path`.
+    /// Normalized and validated in `build()`.
+    base_path: String,
+    /// Whether the unversioned `/api` alias is served, as passed to
+    /// `api_version_policy`. Parsed in `build()`.
+    api_version_policy: String,
 }
 
 impl Default for SolobaseBuilder {
@@ -110,6 +120,9 @@ impl SolobaseBuilder {
             extra_vector_service: None,
             extra_embedding_service: None,
             config_source: None,
+            schedules: Vec::new(),
+            base_path: String::new(),
+            api_version_policy: String::new(),
         }
     }
 
@@ -246,6 +259,25 @@ impl SolobaseBuilder {
         self
     }
 
+    /// Serve Solobase under a path prefix (e.g. `/solobase`) instead of the
+    /// root. Requests may arrive with or without the prefix; redirects,
+    /// dashboard pages and the URLs handlers hand out carry it. See
+    /// [`crate::base_path`]. `build` fails on a prefix that isn't a plain
+    /// `/segment/...` path.
+    pub fn base_path(mut self, path: impl Into<String>) -> Self {
+        self.base_path = path.into();
+        self
+    }
+
+    /// Whether the API stays reachable under the deprecated, unversioned
+    /// `/api` alias (`alias`, the default) or only under `/api/v1`
+    /// (`versioned-only`, for deployments with no older clients). See
+    /// [`crate::api_version`]. `build` fails on any other value.
+    pub fn api_version_policy(mut self, policy: impl Into<String>) -> Self {
+        self.api_version_policy = policy.into();
+        self
+    }
+
     pub fn block_config(mut self, name: impl Into<String>, config: serde_json::Value) -> Self {
         self.block_configs.push((name.into(), config));
         self
@@ -255,12 +287,24 @@ impl SolobaseBuilder {
     ///
     /// Built-in solobase routes take priority — an extra route with the same
     /// prefix as a built-in (e.g. `/b/auth/`) is ignored. To disable a
-    /// built-in route, turn off its feature flag.
+    /// built-in route, turn off its feature flag. Among extra routes the
+    /// longest matching prefix wins, whatever the registration order.
+    ///
+    /// `prefix` must be a normalized path below `/` (no `//`, `.` or `..`
+    /// segments) and may be registered once; `build` fails otherwise. It is
+    /// served under the versioned API too (`/x/` at `/api/v1/x/`); a prefix
+    /// given as `/api/v1/x/` is registered as `/x/`, and one under the
+    /// unversioned `/api` is refused.
     ///
     /// `access` declares the auth tier:
     /// - [`RouteAccess::Public`] — no auth check.
     /// - [`RouteAccess::Authenticated`] — rejects empty user_id with 403.
     /// - [`RouteAccess::Admin`] — requires the `admin` role or 403.
+    ///
+    /// This is the tier for the whole prefix. Individual endpoints tighten
+    /// it by declaring an [`wafer_run::AuthLevel`] in the block's
+    /// `BlockInfo::endpoints`; the router enforces the stricter of the two,
+    /// as it does for built-in blocks.
     pub fn add_route(
         mut self,
         prefix: impl Into<String>,
@@ -271,10 +315,90 @@ impl SolobaseBuilder {
             prefix: prefix.into(),
             block_name: block_name.into(),
             access,
+            source: RouteSource::Custom,
         });
         self
     }
 
+    /// Register a declarative extension (see [`crate::blocks::declarative`])
+    /// and mount it at `/b/{block}/`, which API clients reach as
+    /// `/api/v1/b/{block}/`.
+    ///
+    /// The prefix itself is [`RouteAccess::Public`]: each route in the
+    /// manifest declares its own access, which the router enforces from the
+    /// block's endpoints, and paths no route declares answer 404.
+    pub fn extension(self, ext: crate::blocks::declarative::Extension) -> Self {
+        let block = crate::blocks::declarative::DeclarativeBlock::new(ext);
+        let name = block.name().to_string();
+        let prefix = block.mount_prefix();
+        let mut builder = self.extra_block(name.clone(), Arc::new(block));
+        builder.extra_routes.push(ExtraRoute {
+            prefix,
+            block_name: name,
+            access: RouteAccess::Public,
+            source: RouteSource::Extension,
+        });
+        builder
+    }
+
+    /// Queue a job on a cron schedule (see [`crate::blocks::schedules`]);
+    /// the spec's block runs it, usually one added with
+    /// [`extra_block`](Self::extra_block). [`build`](Self::build) fails on
+    /// a bad name or expression.
+    pub fn schedule(mut self, spec: crate::blocks::schedules::ScheduleSpec) -> Self {
+        self.schedules.push(spec);
+        self
+    }
+
+    /// Every route the built runtime will serve — built-in, extension and
+    /// [`add_route`](Self::add_route) — without building it (see
+    /// [`crate::routing::route_listing`]). Fails like [`build`](Self::build)
+    /// on an invalid extra route.
+    pub fn routes(&self) -> Result<Vec<RouteListing>, RuntimeError> {
+        let mut block_infos = crate::blocks::all_block_infos();
+        block_infos.extend(self.extra_blocks.iter().map(|(_, block)| block.info()));
+        let extra_routes = crate::routing::prepare_extra_routes(self.extra_routes.clone())
+            .map_err(RuntimeError::Config)?;
+        Ok(crate::routing::route_listing(&block_infos, &extra_routes))
+    }
+
+    /// Scan uploads for malware with `provider` (see
+    /// [`crate::blocks::files::scan`]). Without one, uploads are stored
+    /// unscanned.
+    #[cfg(feature = "block-files")]
+    pub fn scan_provider(
+        self,
+        provider: Arc<dyn crate::blocks::files::scan::ScanProvider>,
+    ) -> Self {
+        use crate::blocks::files::scan::{ScannerBlock, SCANNER_BLOCK};
+        self.extra_block(SCANNER_BLOCK, Arc::new(ScannerBlock::new(provider)))
+    }
+
+    /// Tax purchases with `calculator` instead of the admin-managed rate
+    /// table (see [`crate::blocks::products::tax`]).
+    #[cfg(feature = "block-products")]
+    pub fn tax_calculator(
+        self,
+        calculator: Arc<dyn crate::blocks::products::tax::TaxCalculator>,
+    ) -> Self {
+        use crate::blocks::products::tax::{TaxCalculatorBlock, TAX_CALCULATOR_BLOCK};
+        self.extra_block(
+            TAX_CALCULATOR_BLOCK,
+            Arc::new(TaxCalculatorBlock::new(calculator)),
+        )
+    }
+
+    /// Show users with profiles from `provider` — an embedder's own user
+    /// tables, say — instead of from auth's users directory alone (see
+    /// [`crate::blocks::directory`]).
+    pub fn directory_provider(
+        self,
+        provider: Arc<dyn crate::blocks::directory::DirectoryProvider>,
+    ) -> Self {
+        use crate::blocks::directory::{DirectoryBlock, DIRECTORY_BLOCK};
+        self.extra_block(DIRECTORY_BLOCK, Arc::new(DirectoryBlock::new(provider)))
+    }
+
     /// Set the filesystem path to the SQLite database file.
     ///
     /// Only consumed by the `native-embedding` feature to open a second
@@ -308,6 +432,16 @@ impl SolobaseBuilder {
         let logger = self
             .logger
             .ok_or_else(|| RuntimeError::Config("logger service required".into()))?;
+        for spec in self.schedules {
+            crate::blocks::schedules::register(spec).map_err(RuntimeError::Config)?;
+        }
+        let base_path =
+            crate::base_path::normalize(&self.base_path).map_err(RuntimeError::Config)?;
+        crate::base_path::install(&base_path);
+        crate::api_version::install(
+            crate::api_version::ApiVersionPolicy::parse(&self.api_version_policy)
+                .map_err(RuntimeError::Config)?,
+        );
 
         // 2. Read JWT secret before registering config block
         let jwt_secret = config
@@ -547,12 +681,15 @@ impl SolobaseBuilder {
         let feature_config: Arc<dyn FeatureConfig> = self.block_settings.clone();
         let block_infos = wafer.block_infos();
         let routes_cfg = crate::routing::routes_config(&block_infos);
+        let extra_routes = crate::routing::prepare_extra_routes(self.extra_routes)
+            .map_err(RuntimeError::Config)?;
         let router = SolobaseRouterBlock::with_extra_routes(
             jwt_secret,
             feature_config,
             block_infos,
-            self.extra_routes,
-        );
+            extra_routes,
+        )
+        .with_base_path(base_path.clone());
         // `SolobaseRouterBlock` holds `Arc<dyn FeatureConfig>`, which only
         // requires `MaybeSend + MaybeSync` (real `Send + Sync` on native, a
         // no-op marker on wasm32 — see wafer_block::compat), so this `Arc`
@@ -624,7 +761,7 @@ impl SolobaseBuilder {
         }
 
         // 12. Register site-main flow
-        crate::flows::register_site_main(&mut wafer)?;
+        crate::flows::register_site_main(&mut wafer, &base_path)?;
 
         Ok((wafer, storage_block))
     }
@@ -1,11 +1,32 @@
-//! TTL-based per-instance cache.
+//! In-process read caches.
 //!
-//! Used by solobase-cloudflare to memoize D1 reads across requests within
-//! a Worker isolate's lifetime. Generic so the unit tests live here on the
-//! native target — the cloudflare crate has no `cargo test` surface.
+//! [`TtlCache`] holds a single value. Used by solobase-cloudflare to memoize
+//! D1 reads across requests within a Worker isolate's lifetime. Generic so
+//! the unit tests live here on the native target — the cloudflare crate has
+//! no `cargo test` surface.
+//!
+//! [`LruCache`] is a keyed read-through cache for hot lookups that rarely
+//! change — a user's roles, pricing formulas, bucket listings. Entries
+//! expire after a TTL, the least recently used one is evicted once the
+//! cache is full, and writers invalidate explicitly: [`LruCache::invalidate`]
+//! for one key, [`invalidate_table`] for everything read from a table —
+//! including generic writers such as the CSV import, which don't know what
+//! is cached. A load that overlaps an invalidation is returned to its caller but not stored,
+//! so a write is never papered over by a read that started before it.
+//!
+//! The keyed caches are process memory, so they only run on native: on
+//! wasm32 there is no clock, and one isolate couldn't see another's
+//! invalidations. [`crate::runtime_config::QUERY_CACHE_KEY`] turns them off
+//! for debugging. Block settings aren't cached here — `config::get` already
+//! reads the in-memory variables snapshot, not the database. Hit and miss
+//! counts are reported by [`stats`] (`GET /b/admin/api/config/cache`).
 
 use std::{
-    sync::{Arc, Mutex},
+    collections::BTreeMap,
+    sync::{
+        atomic::{AtomicU64, Ordering},
+        Arc, Mutex, MutexGuard, OnceLock,
+    },
     time::{Duration, Instant},
 };
 
@@ -60,6 +81,265 @@ impl<T> TtlCache<T> {
     }
 }
 
+// ---------------------------------------------------------------------------
+// Keyed read-through cache
+// ---------------------------------------------------------------------------
+
+/// Generation per table name, bumped by [`invalidate_table`].
+#[cfg(not(any(test, feature = "test-support")))]
+static TABLE_GENERATIONS: Mutex<BTreeMap<String, u64>> = Mutex::new(BTreeMap::new());
+/// Counters of every keyed cache used since boot, by name.
+static REGISTRY: Mutex<BTreeMap<&'static str, Arc<Counters>>> = Mutex::new(BTreeMap::new());
+
+// Under test each thread — one per test, each over its own database — gets
+// its own switch and generations, so one test's writes can't invalidate
+// what another is asserting is cached.
+#[cfg(any(test, feature = "test-support"))]
+thread_local! {
+    static TEST_ENABLED: std::cell::Cell<bool> = const { std::cell::Cell::new(false) };
+    static TABLE_GENERATIONS: std::cell::RefCell<BTreeMap<String, u64>> =
+        const { std::cell::RefCell::new(BTreeMap::new()) };
+}
+
+fn lock<T>(m: &Mutex<T>) -> MutexGuard<'_, T> {
+    // Every critical section leaves the data consistent before anything
+    // that could panic, so a poisoned lock is safe to reuse.
+    m.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+#[cfg(not(any(test, feature = "test-support")))]
+fn with_generations<R>(f: impl FnOnce(&mut BTreeMap<String, u64>) -> R) -> R {
+    f(&mut lock(&TABLE_GENERATIONS))
+}
+
+#[cfg(any(test, feature = "test-support"))]
+fn with_generations<R>(f: impl FnOnce(&mut BTreeMap<String, u64>) -> R) -> R {
+    TABLE_GENERATIONS.with(|g| f(&mut g.borrow_mut()))
+}
+
+/// Whether keyed caches read and store entries right now.
+pub fn enabled() -> bool {
+    !cfg!(target_arch = "wasm32") && test_enabled() && crate::runtime_config::load().query_cache
+}
+
+#[cfg(any(test, feature = "test-support"))]
+fn test_enabled() -> bool {
+    TEST_ENABLED.with(std::cell::Cell::get)
+}
+
+#[cfg(not(any(test, feature = "test-support")))]
+fn test_enabled() -> bool {
+    true
+}
+
+/// Turn the keyed caches on for the calling thread. They start off under
+/// test: the caches are process-wide, and tests running side by side over
+/// separate databases would otherwise read each other's rows.
+#[cfg(any(test, feature = "test-support"))]
+pub fn enable_for_current_thread() {
+    TEST_ENABLED.with(|e| e.set(true));
+}
+
+/// Drop every cached entry read from `table`. Call after any write to it.
+pub fn invalidate_table(table: &str) {
+    with_generations(|g| *g.entry(table.to_string()).or_default() += 1);
+}
+
+/// The generation an entry read from `tables` is valid for. Generations
+/// only grow, so any invalidation changes the sum.
+fn stamp(tables: &[&str]) -> u64 {
+    with_generations(|generations| {
+        tables
+            .iter()
+            .filter_map(|t| generations.get(*t))
+            .fold(0, |acc, g| acc.wrapping_add(*g))
+    })
+}
+
+#[derive(Default)]
+struct Counters {
+    hits: AtomicU64,
+    misses: AtomicU64,
+    evictions: AtomicU64,
+    entries: AtomicU64,
+}
+
+/// One keyed cache's counters since boot.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct CacheStats {
+    pub name: &'static str,
+    pub hits: u64,
+    pub misses: u64,
+    /// Entries dropped to make room, not counting expiry or invalidation.
+    pub evictions: u64,
+    pub entries: u64,
+}
+
+/// Every keyed cache used since boot, by name.
+pub fn stats() -> Vec<CacheStats> {
+    lock(&REGISTRY)
+        .iter()
+        .map(|(name, c)| CacheStats {
+            name,
+            hits: c.hits.load(Ordering::Relaxed),
+            misses: c.misses.load(Ordering::Relaxed),
+            evictions: c.evictions.load(Ordering::Relaxed),
+            entries: c.entries.load(Ordering::Relaxed),
+        })
+        .collect()
+}
+
+struct Entry<V> {
+    value: V,
+    stamp: u64,
+    loaded_at: Instant,
+    used: u64,
+}
+
+struct LruState<K, V> {
+    entries: BTreeMap<K, Entry<V>>,
+    /// Use counter; the entry with the smallest `used` is evicted first.
+    tick: u64,
+    /// Bumped by per-key invalidation and [`LruCache::clear`], so a load
+    /// that overlapped one isn't stored.
+    epoch: u64,
+}
+
+/// A bounded, keyed read-through cache. Declare one per lookup as a
+/// `static`, naming the tables its values are read from:
+///
+/// ```ignore
+/// static ROLES: LruCache<String, Vec<String>> =
+///     LruCache::new("auth.roles", &[USER_ROLES_TABLE], 1024, Duration::from_secs(60));
+/// let roles = ROLES.get_or_try_load(user_id.to_string(), || load(ctx, user_id)).await?;
+/// ```
+///
+/// Eviction scans for the least recently used entry, which is cheap at
+/// the few-hundred-entry sizes these caches are meant for.
+pub struct LruCache<K, V> {
+    name: &'static str,
+    tables: &'static [&'static str],
+    capacity: usize,
+    ttl: Duration,
+    state: Mutex<LruState<K, V>>,
+    counters: OnceLock<Arc<Counters>>,
+}
+
+impl<K: Ord + Clone, V: Clone> LruCache<K, V> {
+    /// `name` identifies the cache in [`stats`].
+    pub const fn new(
+        name: &'static str,
+        tables: &'static [&'static str],
+        capacity: usize,
+        ttl: Duration,
+    ) -> Self {
+        Self {
+            name,
+            tables,
+            capacity,
+            ttl,
+            state: Mutex::new(LruState {
+                entries: BTreeMap::new(),
+                tick: 0,
+                epoch: 0,
+            }),
+            counters: OnceLock::new(),
+        }
+    }
+
+    fn counters(&self) -> &Counters {
+        self.counters
+            .get_or_init(|| lock(&REGISTRY).entry(self.name).or_default().clone())
+    }
+
+    /// The cached value for `key` if fresh, otherwise the result of
+    /// `load`, cached when it succeeds. Errors are never cached. With
+    /// caching off this is just `load().await`.
+    pub async fn get_or_try_load<E, F, Fut>(&self, key: K, load: F) -> Result<V, E>
+    where
+        F: FnOnce() -> Fut,
+        Fut: std::future::Future<Output = Result<V, E>>,
+    {
+        if !enabled() {
+            return load().await;
+        }
+        let counters = self.counters();
+        let stamp_before = stamp(self.tables);
+        let epoch = {
+            let mut state = lock(&self.state);
+            state.tick += 1;
+            let tick = state.tick;
+            match state.entries.get_mut(&key) {
+                Some(entry)
+                    if entry.stamp == stamp_before && entry.loaded_at.elapsed() < self.ttl =>
+                {
+                    entry.used = tick;
+                    counters.hits.fetch_add(1, Ordering::Relaxed);
+                    return Ok(entry.value.clone());
+                }
+                Some(_) => {
+                    state.entries.remove(&key);
+                }
+                None => {}
+            }
+            counters
+                .entries
+                .store(state.entries.len() as u64, Ordering::Relaxed);
+            state.epoch
+        };
+        counters.misses.fetch_add(1, Ordering::Relaxed);
+
+        let value = load().await?;
+
+        let mut state = lock(&self.state);
+        if state.epoch == epoch && stamp(self.tables) == stamp_before {
+            state.tick += 1;
+            let used = state.tick;
+            state.entries.insert(
+                key,
+                Entry {
+                    value: value.clone(),
+                    stamp: stamp_before,
+                    loaded_at: Instant::now(),
+                    used,
+                },
+            );
+            while state.entries.len() > self.capacity {
+                let oldest = state
+                    .entries
+                    .iter()
+                    .min_by_key(|(_, e)| e.used)
+                    .map(|(k, _)| k.clone());
+                let Some(oldest) = oldest else { break };
+                state.entries.remove(&oldest);
+                counters.evictions.fetch_add(1, Ordering::Relaxed);
+            }
+            counters
+                .entries
+                .store(state.entries.len() as u64, Ordering::Relaxed);
+        }
+        Ok(value)
+    }
+
+    /// Drop the entry for `key`.
+    pub fn invalidate(&self, key: &K) {
+        let mut state = lock(&self.state);
+        state.entries.remove(key);
+        state.epoch += 1;
+        self.counters()
+            .entries
+            .store(state.entries.len() as u64, Ordering::Relaxed);
+    }
+
+    /// Drop every entry.
+    pub fn clear(&self) {
+        let mut state = lock(&self.state);
+        state.entries.clear();
+        state.epoch += 1;
+        self.counters().entries.store(0, Ordering::Relaxed);
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use std::sync::atomic::{AtomicUsize, Ordering};
@@ -185,4 +465,106 @@ mod tests {
             "same Arc should be returned for cache hits"
         );
     }
+
+    // Each keyed-cache test uses its own cache name and table, so tests
+    // running in parallel don't see each other's counters or invalidations.
+
+    async fn load(cache: &LruCache<u32, u32>, key: u32, value: u32, calls: &AtomicUsize) -> u32 {
+        cache
+            .get_or_try_load(key, || async {
+                calls.fetch_add(1, Ordering::SeqCst);
+                Ok::<_, ()>(value)
+            })
+            .await
+            .unwrap()
+    }
+
+    #[tokio::test]
+    async fn lru_hits_until_invalidated() {
+        enable_for_current_thread();
+        let cache: LruCache<u32, u32> =
+            LruCache::new("test.hits", &["test_hits"], 8, Duration::from_secs(60));
+        let calls = AtomicUsize::new(0);
+
+        assert_eq!(load(&cache, 1, 10, &calls).await, 10);
+        assert_eq!(load(&cache, 1, 11, &calls).await, 10);
+        assert_eq!(calls.load(Ordering::SeqCst), 1);
+
+        cache.invalidate(&1);
+        assert_eq!(load(&cache, 1, 12, &calls).await, 12);
+
+        invalidate_table("test_hits");
+        assert_eq!(load(&cache, 1, 13, &calls).await, 13);
+        assert_eq!(calls.load(Ordering::SeqCst), 3);
+
+        let stats = stats().into_iter().find(|s| s.name == "test.hits").unwrap();
+        assert_eq!((stats.hits, stats.misses, stats.entries), (1, 3, 1));
+    }
+
+    #[tokio::test]
+    async fn lru_evicts_the_least_recently_used() {
+        enable_for_current_thread();
+        let cache: LruCache<u32, u32> =
+            LruCache::new("test.lru", &["test_lru"], 2, Duration::from_secs(60));
+        let calls = AtomicUsize::new(0);
+        load(&cache, 1, 1, &calls).await;
+        load(&cache, 2, 2, &calls).await;
+        load(&cache, 1, 1, &calls).await; // 2 is now the oldest
+        load(&cache, 3, 3, &calls).await;
+        assert_eq!(calls.load(Ordering::SeqCst), 3);
+
+        load(&cache, 1, 1, &calls).await;
+        assert_eq!(calls.load(Ordering::SeqCst), 3, "1 was kept");
+        load(&cache, 2, 2, &calls).await;
+        assert_eq!(calls.load(Ordering::SeqCst), 4, "2 was evicted");
+    }
+
+    #[tokio::test]
+    async fn lru_expires_after_ttl() {
+        enable_for_current_thread();
+        let cache: LruCache<u32, u32> =
+            LruCache::new("test.ttl", &["test_ttl"], 8, Duration::from_millis(10));
+        let calls = AtomicUsize::new(0);
+        load(&cache, 1, 1, &calls).await;
+        tokio::time::sleep(Duration::from_millis(30)).await;
+        assert_eq!(load(&cache, 1, 2, &calls).await, 2);
+    }
+
+    #[tokio::test]
+    async fn lru_does_not_store_a_load_that_raced_a_write() {
+        enable_for_current_thread();
+        let cache: LruCache<u32, u32> =
+            LruCache::new("test.race", &["test_race"], 8, Duration::from_secs(60));
+        let stale = cache
+            .get_or_try_load(1, || async {
+                // A writer commits while this read is in flight.
+                invalidate_table("test_race");
+                Ok::<_, ()>(1)
+            })
+            .await
+            .unwrap();
+        assert_eq!(stale, 1, "the caller still gets what it read");
+
+        let calls = AtomicUsize::new(0);
+        assert_eq!(load(&cache, 1, 2, &calls).await, 2);
+        assert_eq!(calls.load(Ordering::SeqCst), 1, "but it wasn't cached");
+    }
+
+    #[tokio::test]
+    async fn lru_errors_are_not_cached_and_off_means_uncached() {
+        let cache: LruCache<u32, u32> =
+            LruCache::new("test.off", &["test_off"], 8, Duration::from_secs(60));
+        let calls = AtomicUsize::new(0);
+        // Not enabled on this thread: every call loads.
+        load(&cache, 1, 1, &calls).await;
+        load(&cache, 1, 1, &calls).await;
+        assert_eq!(calls.load(Ordering::SeqCst), 2);
+
+        enable_for_current_thread();
+        let err = cache
+            .get_or_try_load(2, || async { Err::<u32, _>("down") })
+            .await;
+        assert_eq!(err, Err("down"));
+        assert_eq!(load(&cache, 2, 5, &calls).await, 5);
+    }
 }
@@ -0,0 +1,112 @@
+//! The current time and fresh ids, with a test seam.
+//!
+//! Code that stamps, expires or schedules reads the time from [`now`]
+//! instead of `chrono::Utc::now()`, and mints ids with [`new_id`] instead of
+//! `uuid::Uuid::new_v4()`. Outside tests both are the real thing. In tests a
+//! thread can [`freeze`] the clock, [`advance`] it, and switch to
+//! [`sequential_ids`], so a test of a token expiry or a quota window asserts
+//! the exact instant it flips instead of sleeping past it, and a test of
+//! generated ids compares them instead of matching a pattern.
+//!
+//! `crate::util::now_rfc3339` and `now_millis` read [`now`], so every
+//! `created_at` stamp follows the frozen clock too. New code that needs
+//! the time or an id should come here (or through those helpers); the
+//! remaining direct `Utc::now()` calls are converted as they're touched.
+
+use chrono::{DateTime, Utc};
+
+/// The current UTC time: the real one, or under test the thread's frozen
+/// clock if it has one.
+pub fn now() -> DateTime<Utc> {
+    #[cfg(test)]
+    if let Some(frozen) = FROZEN.with(|f| f.get()) {
+        return frozen;
+    }
+    Utc::now()
+}
+
+/// A fresh random id (a v4 UUID): under test, the thread's next sequential
+/// id if [`sequential_ids`] is on.
+pub fn new_id() -> String {
+    #[cfg(test)]
+    if let Some(id) = SEQUENCE.with(|s| {
+        s.borrow_mut().as_mut().map(|(prefix, n)| {
+            *n += 1;
+            format!("{prefix}{n}")
+        })
+    }) {
+        return id;
+    }
+    uuid::Uuid::new_v4().to_string()
+}
+
+// Per thread, so tests running in parallel each see only their own clock.
+#[cfg(test)]
+thread_local! {
+    static FROZEN: std::cell::Cell<Option<DateTime<Utc>>> = const { std::cell::Cell::new(None) };
+    static SEQUENCE: std::cell::RefCell<Option<(String, u64)>> =
+        const { std::cell::RefCell::new(None) };
+}
+
+/// Stop this thread's clock at `at` until [`advance`]d or [`thaw`]ed.
+#[cfg(test)]
+pub fn freeze(at: DateTime<Utc>) {
+    FROZEN.with(|f| f.set(Some(at)));
+}
+
+/// Move this thread's frozen clock forward by `by`, freezing it at the
+/// real time first if it isn't frozen.
+#[cfg(test)]
+pub fn advance(by: chrono::Duration) {
+    let at = now() + by;
+    freeze(at);
+}
+
+/// Give this thread back the real clock.
+#[cfg(test)]
+pub fn thaw() {
+    FROZEN.with(|f| f.set(None));
+}
+
+/// Make [`new_id`] return `{prefix}1`, `{prefix}2`, … on this thread.
+#[cfg(test)]
+pub fn sequential_ids(prefix: &str) {
+    SEQUENCE.with(|s| *s.borrow_mut() = Some((prefix.to_string(), 0)));
+}
+
+/// Parse an RFC 3339 instant for [`freeze`].
+#[cfg(test)]
+pub fn at(rfc3339: &str) -> DateTime<Utc> {
+    DateTime::parse_from_rfc3339(rfc3339)
+        .expect("valid RFC 3339 instant")
+        .with_timezone(&Utc)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn frozen_clock_only_moves_when_advanced() {
+        freeze(at("2026-03-01T00:00:00Z"));
+        assert_eq!(now(), at("2026-03-01T00:00:00Z"));
+        assert_eq!(now(), now());
+        advance(chrono::Duration::seconds(90));
+        assert_eq!(now(), at("2026-03-01T00:01:30Z"));
+        assert_eq!(crate::util::now_rfc3339(), "2026-03-01T00:01:30.000000Z");
+
+        thaw();
+        assert!(now() > at("2026-03-02T00:00:00Z"));
+    }
+
+    #[test]
+    fn ids_are_random_until_made_sequential() {
+        let (a, b) = (new_id(), new_id());
+        assert_ne!(a, b);
+        assert!(uuid::Uuid::parse_str(&a).is_ok());
+
+        sequential_ids("obj-");
+        assert_eq!(new_id(), "obj-1");
+        assert_eq!(new_id(), "obj-2");
+    }
+}
@@ -0,0 +1,479 @@
+//! gzip for buffered API responses.
+//!
+//! The pipeline calls [`apply`] on every fully buffered response. A body is
+//! compressed when the client's `Accept-Encoding` allows gzip, its content
+//! type is text-like (JSON, HTML, CSS, JS, XML, CSV, plain text) and it is
+//! at least [`CompressionOptions::min_bytes`] long. Responses that already
+//! carry a `Content-Encoding`, partial (`206`) and body-less statuses,
+//! file downloads (anything with a `Content-Disposition`) and the
+//! [`crate::routing::LONG_RUNNING`] endpoints pass through untouched.
+//! Streamed responses — SSE and byte streams — never reach this module:
+//! the pipeline forwards them as they're produced, and buffering them to
+//! compress would defeat that.
+//!
+//! Compression is platform opt-in, like the handler timeout: native calls
+//! [`install`] at boot, while Cloudflare leaves it off because the edge
+//! already compresses (and a Worker response marked `Content-Encoding`
+//! would be encoded twice). Once installed, [`ENABLED_KEY`] and
+//! [`LEVEL_KEY`] override the installed options per request, so an admin
+//! can turn it off or trade CPU for ratio from the settings page.
+//!
+//! A compressed response's strong `ETag` is made weak, since it no longer
+//! names the bytes sent; see [`crate::etag`] for conditional GETs.
+//!
+//! Only gzip is offered: zstd needs a C library, which the wasm32 builds
+//! can't link.
+
+use std::{io::Write, sync::OnceLock};
+
+use flate2::{write::GzEncoder, Compression};
+use wafer_block::http_codec::{self, ResponseMetaPart};
+use wafer_run::{context::Context, MetaEntry};
+
+/// Settings override for [`CompressionOptions::enabled`].
+pub const ENABLED_KEY: &str = "SOLOBASE_SHARED__COMPRESSION";
+/// Settings override for [`CompressionOptions::level`].
+pub const LEVEL_KEY: &str = "SOLOBASE_SHARED__COMPRESSION_LEVEL";
+
+/// How the platform compresses responses.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct CompressionOptions {
+    pub enabled: bool,
+    /// gzip level, 1 (fastest) to 9 (smallest).
+    pub level: u32,
+    /// Bodies shorter than this are sent as-is; below a packet or two,
+    /// gzip saves nothing the CPU time doesn't cost back.
+    pub min_bytes: usize,
+}
+
+impl Default for CompressionOptions {
+    fn default() -> Self {
+        Self {
+            enabled: true,
+            level: 6,
+            min_bytes: 1024,
+        }
+    }
+}
+
+static OPTIONS: OnceLock<CompressionOptions> = OnceLock::new();
+
+/// Turn on response compression with `options` as the defaults. Only the
+/// first call wins.
+pub fn install(options: CompressionOptions) {
+    let _ = OPTIONS.set(options);
+}
+
+/// The installed options with the settings overrides applied, or `None`
+/// when compression is off.
+fn effective(ctx: &dyn Context) -> Option<CompressionOptions> {
+    let mut options = *OPTIONS.get()?;
+    if let Some(raw) = ctx.config_get(ENABLED_KEY) {
+        match raw.trim() {
+            "true" => options.enabled = true,
+            "false" => options.enabled = false,
+            _ => {}
+        }
+    }
+    if let Some(level) = ctx
+        .config_get(LEVEL_KEY)
+        .and_then(|raw| raw.trim().parse::<u32>().ok())
+        .filter(|l| (1..=9).contains(l))
+    {
+        options.level = level;
+    }
+    options.enabled.then_some(options)
+}
+
+/// Whether an `Accept-Encoding` value admits gzip: listed (or covered by
+/// `*`) with a non-zero `q`. An explicit `gzip;q=0` wins over `*`.
+pub fn accepts_gzip(accept_encoding: &str) -> bool {
+    let mut wildcard = false;
+    for item in accept_encoding.split(',') {
+        let mut parts = item.split(';');
+        let coding = parts.next().unwrap_or("").trim().to_ascii_lowercase();
+        let q = parts
+            .filter_map(|p| p.trim().strip_prefix("q="))
+            .find_map(|q| q.trim().parse::<f32>().ok())
+            .unwrap_or(1.0);
+        match coding.as_str() {
+            "gzip" | "x-gzip" => return q > 0.0,
+            "*" => wildcard = q > 0.0,
+            _ => {}
+        }
+    }
+    wildcard
+}
+
+/// Whether a response of this content type is worth compressing. Media
+/// and archives are already compressed; `text/event-stream` is streamed.
+fn compressible(content_type: &str) -> bool {
+    let essence = content_type
+        .split(';')
+        .next()
+        .unwrap_or("")
+        .trim()
+        .to_ascii_lowercase();
+    if essence == "text/event-stream" {
+        return false;
+    }
+    essence.starts_with("text/")
+        || essence.ends_with("+json")
+        || essence.ends_with("+xml")
+        || matches!(
+            essence.as_str(),
+            "application/json"
+                | "application/x-ndjson"
+                | "application/javascript"
+                | "application/xml"
+                | "image/svg+xml"
+        )
+}
+
+fn header<'a>(meta: &'a [MetaEntry], name: &str) -> Option<&'a str> {
+    meta.iter()
+        .find(|m| {
+            m.key
+                .strip_prefix("resp.header.")
+                .is_some_and(|h| h.eq_ignore_ascii_case(name))
+        })
+        .map(|m| m.value.as_str())
+}
+
+/// Add `Accept-Encoding` to the response's `Vary`, keeping whatever it
+/// already varies on.
+fn add_vary(meta: &mut Vec<MetaEntry>) {
+    let existing = meta.iter_mut().find(|m| {
+        m.key
+            .strip_prefix("resp.header.")
+            .is_some_and(|h| h.eq_ignore_ascii_case("vary"))
+    });
+    match existing {
+        Some(entry) => {
+            let listed = entry
+                .value
+                .split(',')
+                .any(|v| v.trim() == "*" || v.trim().eq_ignore_ascii_case("accept-encoding"));
+            if !listed {
+                entry.value.push_str(", Accept-Encoding");
+            }
+        }
+        None => meta.push(MetaEntry {
+            key: "resp.header.Vary".to_string(),
+            value: "Accept-Encoding".to_string(),
+        }),
+    }
+}
+
+/// A strong `ETag` promises identical bytes, which the gzipped body no
+/// longer is; demote it to weak. [`crate::etag`] tags are weak already.
+fn weaken_etag(meta: &mut [MetaEntry]) {
+    if let Some(entry) = meta.iter_mut().find(|m| {
+        m.key
+            .strip_prefix("resp.header.")
+            .is_some_and(|h| h.eq_ignore_ascii_case("etag"))
+    }) {
+        if !entry.value.starts_with("W/") {
+            entry.value.insert_str(0, "W/");
+        }
+    }
+}
+
+fn gzip(body: &[u8], level: u32) -> std::io::Result<Vec<u8>> {
+    let mut encoder = GzEncoder::new(Vec::with_capacity(body.len() / 4), Compression::new(level));
+    encoder.write_all(body)?;
+    encoder.finish()
+}
+
+/// Compress a buffered response in place if the request and response
+/// allow it (see the module docs). `action` and `path` identify the
+/// request, `accept_encoding` is its `Accept-Encoding` header.
+///
+/// Every response that could have been compressed gets
+/// `Vary: Accept-Encoding`, including small ones and ones the client
+/// didn't ask to have compressed, so a cache never hands a gzip body to a
+/// client that can't read it.
+pub fn apply(
+    ctx: &dyn Context,
+    action: &str,
+    path: &str,
+    accept_encoding: &str,
+    body: &mut Vec<u8>,
+    meta: &mut Vec<MetaEntry>,
+) {
+    let Some(options) = effective(ctx) else {
+        return;
+    };
+    let status = http_codec::resolve_status(meta, 200);
+    if !(200..300).contains(&status) && !(400..600).contains(&status)
+        || status == 204
+        || status == 206
+        || crate::routing::is_long_running(action, path)
+        || header(meta, "content-encoding").is_some()
+        || header(meta, "content-range").is_some()
+        || header(meta, "content-disposition").is_some()
+    {
+        return;
+    }
+    let content_type = http_codec::response_meta_parts(meta).find_map(|part| match part {
+        ResponseMetaPart::ContentType(ct) => Some(ct.to_string()),
+        _ => None,
+    });
+    if !content_type.as_deref().is_some_and(compressible) {
+        return;
+    }
+    add_vary(meta);
+    if body.len() < options.min_bytes || !accepts_gzip(accept_encoding) {
+        return;
+    }
+    match gzip(body, options.level) {
+        // Incompressible data can come out larger; send the original then.
+        Ok(compressed) if compressed.len() < body.len() => {
+            *body = compressed;
+            meta.push(MetaEntry {
+                key: "resp.header.Content-Encoding".to_string(),
+                value: "gzip".to_string(),
+            });
+            weaken_etag(meta);
+        }
+        Ok(_) => {}
+        Err(e) => tracing::warn!(error = %e, "gzip response compression failed"),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::io::Read;
+
+    use flate2::read::GzDecoder;
+
+    use super::*;
+    use crate::test_support::TestContext;
+
+    fn gunzip(body: &[u8]) -> Vec<u8> {
+        let mut out = Vec::new();
+        GzDecoder::new(body).read_to_end(&mut out).unwrap();
+        out
+    }
+
+    fn response(content_type: &str, body: &[u8]) -> (Vec<u8>, Vec<MetaEntry>) {
+        let meta = vec![MetaEntry {
+            key: wafer_run::META_RESP_CONTENT_TYPE.to_string(),
+            value: content_type.to_string(),
+        }];
+        (body.to_vec(), meta)
+    }
+
+    fn with_header(mut meta: Vec<MetaEntry>, name: &str, value: &str) -> Vec<MetaEntry> {
+        meta.push(MetaEntry {
+            key: format!("resp.header.{name}"),
+            value: value.to_string(),
+        });
+        meta
+    }
+
+    fn listing(rows: usize) -> Vec<u8> {
+        let rows: Vec<_> = (0..rows)
+            .map(|i| serde_json::json!({"id": i, "key": format!("docs/report-{i}.pdf"), "size": i * 31}))
+            .collect();
+        serde_json::to_vec(&serde_json::json!({ "items": rows })).unwrap()
+    }
+
+    #[test]
+    fn accept_encoding_is_negotiated_with_q_values() {
+        assert!(accepts_gzip("gzip"));
+        assert!(accepts_gzip("br, gzip;q=0.5, deflate"));
+        assert!(accepts_gzip("GZIP"));
+        assert!(accepts_gzip("*"));
+        assert!(!accepts_gzip(""));
+        assert!(!accepts_gzip("identity"));
+        assert!(!accepts_gzip("br, deflate"));
+        assert!(!accepts_gzip("gzip;q=0"));
+        assert!(!accepts_gzip("*, gzip;q=0"));
+    }
+
+    #[test]
+    fn content_types_are_classified() {
+        for ct in [
+            "application/json",
+            "application/json; charset=utf-8",
+            "application/problem+json",
+            "text/html; charset=utf-8",
+            "text/csv",
+            "image/svg+xml",
+        ] {
+            assert!(compressible(ct), "{ct}");
+        }
+        for ct in [
+            "text/event-stream",
+            "application/octet-stream",
+            "image/png",
+            "application/zip",
+            "application/gzip",
+        ] {
+            assert!(!compressible(ct), "{ct}");
+        }
+    }
+
+    #[tokio::test]
+    async fn large_json_is_gzipped_and_marked() {
+        install(CompressionOptions::default());
+        let ctx = TestContext::new().await;
+        let original = listing(200);
+        let (mut body, mut meta) = response("application/json", &original);
+        apply(
+            &ctx,
+            "retrieve",
+            "/b/storage/api/buckets",
+            "gzip, br",
+            &mut body,
+            &mut meta,
+        );
+
+        assert!(body.len() < original.len());
+        assert_eq!(gunzip(&body), original);
+        assert_eq!(header(&meta, "content-encoding"), Some("gzip"));
+        assert_eq!(header(&meta, "vary"), Some("Accept-Encoding"));
+    }
+
+    #[tokio::test]
+    async fn gzip_keeps_weak_etags_and_weakens_strong_ones() {
+        install(CompressionOptions::default());
+        let ctx = TestContext::new().await;
+        let original = listing(200);
+        let tag = crate::etag::of_body(&original);
+        for (sent, expected) in [
+            (tag.clone(), tag.clone()),
+            ("\"v1\"".into(), "W/\"v1\"".into()),
+        ] {
+            let (mut body, meta) = response("application/json", &original);
+            let mut meta = with_header(meta, "ETag", &sent);
+            apply(
+                &ctx,
+                "retrieve",
+                "/b/storage/api/buckets",
+                "gzip",
+                &mut body,
+                &mut meta,
+            );
+            assert_eq!(header(&meta, "content-encoding"), Some("gzip"));
+            assert_eq!(header(&meta, "etag"), Some(expected.as_str()));
+        }
+    }
+
+    #[tokio::test]
+    async fn ineligible_responses_are_left_alone() {
+        install(CompressionOptions::default());
+        let ctx = TestContext::new().await;
+        let large = listing(200);
+        let path = "/b/storage/api/buckets";
+        let cases: Vec<(&str, &str, Vec<u8>, Vec<MetaEntry>)> = vec![
+            // Client didn't ask.
+            {
+                let (b, m) = response("application/json", &large);
+                ("identity", path, b, m)
+            },
+            // Too small to bother.
+            {
+                let (b, m) = response("application/json", b"{\"ok\":true}");
+                ("gzip", path, b, m)
+            },
+            // Already compressed media.
+            {
+                let (b, m) = response("image/png", &large);
+                ("gzip", path, b, m)
+            },
+            // A file download.
+            {
+                let (b, m) = response("application/json", &large);
+                let m = with_header(m, "Content-Disposition", "attachment; filename=\"a.json\"");
+                ("gzip", path, b, m)
+            },
+            // A range response.
+            {
+                let (b, m) = response("text/plain", &large);
+                let mut m = with_header(m, "Content-Range", "bytes 0-99/1000");
+                m.push(MetaEntry {
+                    key: wafer_run::META_RESP_STATUS.to_string(),
+                    value: "206".to_string(),
+                });
+                ("gzip", path, b, m)
+            },
+            // Already encoded by the block.
+            {
+                let (b, m) = response("application/json", &large);
+                let m = with_header(m, "Content-Encoding", "br");
+                ("gzip", path, b, m)
+            },
+            // A long-running download endpoint.
+            {
+                let (b, m) = response("application/json", &large);
+                ("gzip", "/b/storage/api/buckets/docs/objects/a.json", b, m)
+            },
+        ];
+        for (accept, path, body, meta) in cases {
+            let (mut out_body, mut out_meta) = (body.clone(), meta.clone());
+            apply(&ctx, "retrieve", path, accept, &mut out_body, &mut out_meta);
+            assert_eq!(out_body, body, "{accept} {path} {meta:?}");
+            assert_eq!(
+                header(&out_meta, "content-encoding"),
+                header(&meta, "content-encoding"),
+                "{meta:?}"
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn vary_is_merged_and_settings_override_the_options() {
+        install(CompressionOptions::default());
+        let mut ctx = TestContext::new().await;
+        let original = listing(200);
+
+        let (mut body, meta) = response("application/json", &original);
+        let mut meta = with_header(meta, "Vary", "Origin");
+        apply(&ctx, "retrieve", "/b/x", "gzip", &mut body, &mut meta);
+        assert_eq!(header(&meta, "vary"), Some("Origin, Accept-Encoding"));
+
+        ctx.set_config(ENABLED_KEY, "false");
+        let (mut body, mut meta) = response("application/json", &original);
+        apply(&ctx, "retrieve", "/b/x", "gzip", &mut body, &mut meta);
+        assert_eq!(body, original);
+        assert_eq!(header(&meta, "vary"), None);
+
+        ctx.set_config(ENABLED_KEY, "true");
+        ctx.set_config(LEVEL_KEY, "1");
+        let (mut fast, mut meta) = response("application/json", &original);
+        apply(&ctx, "retrieve", "/b/x", "gzip", &mut fast, &mut meta);
+        ctx.set_config(LEVEL_KEY, "9");
+        let (mut small, mut meta) = response("application/json", &original);
+        apply(&ctx, "retrieve", "/b/x", "gzip", &mut small, &mut meta);
+        assert!(small.len() <= fast.len());
+        assert_eq!(gunzip(&fast), gunzip(&small));
+    }
+
+    /// Size and time for gzipping a ~5 MB JSON listing at each level. Not
+    /// part of the normal run:
+    ///
+    /// ```text
+    /// cargo test -p solobase-core --release compression::tests::bench -- --ignored --nocapture
+    /// ```
+    #[test]
+    #[ignore]
+    fn bench_5mb_json_listing() {
+        let body = listing(60_000);
+        println!("listing: {} bytes", body.len());
+        for level in [1, 6, 9] {
+            let started = std::time::Instant::now();
+            let rounds = 5;
+            let mut size = 0;
+            for _ in 0..rounds {
+                size = gzip(&body, level).unwrap().len();
+            }
+            let per_round = started.elapsed() / rounds;
+            println!(
+                "level {level}: {size} bytes ({:.1}%), {per_round:?} per response",
+                size as f64 * 100.0 / body.len() as f64
+            );
+        }
+    }
+}
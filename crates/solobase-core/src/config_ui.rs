@@ -0,0 +1,223 @@
+//! Settings-form metadata for the admin UI, served by
+//! `GET /b/admin/api/extensions/{block}/config-ui` and saved by a `PUT` to
+//! the same path.
+//!
+//! A block's `config_keys` already say what each variable is called, what
+//! it does, its default and its widget ([`InputType`]). What they don't
+//! say is the layout: which section a field sits in, and in what order. A
+//! block registers that with `Services::settings().register_sections` from
+//! `Init`; [`describe`] serves the two together with the current values,
+//! so one generic form can render any block's settings. A section may name
+//! `SOLOBASE_SHARED__*` variables the block's page shows too. Without a
+//! registered layout the block's variables form one "Settings" section in
+//! declared order; variables a layout leaves out follow in "Other".
+//!
+//! Sensitive fields — `InputType::Password`, or a `_SECRET`/`_KEY` key, the
+//! same rule as [`crate::ui::settings_form`] — are write-only: their value
+//! is served as `"********"` when set and `""` when not, and a save
+//! carrying either leaves the stored secret alone.
+
+use std::{collections::BTreeMap, sync::RwLock};
+
+use wafer_core::clients::config;
+use wafer_run::{context::Context, BlockInfo, ConfigVar, InputType};
+
+use crate::{
+    config_vars,
+    util::{is_sensitive_key, MASKED_VALUE},
+};
+
+/// One titled group of fields, in display order.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Section {
+    pub title: String,
+    pub keys: Vec<String>,
+}
+
+impl Section {
+    pub fn new(title: &str, keys: &[&str]) -> Self {
+        Self {
+            title: title.to_string(),
+            keys: keys.iter().map(|k| k.to_string()).collect(),
+        }
+    }
+}
+
+/// Registered layouts, by block name.
+static LAYOUTS: RwLock<BTreeMap<String, Vec<Section>>> = RwLock::new(BTreeMap::new());
+
+/// Set `block`'s settings layout, replacing any earlier one.
+pub fn register(block: &str, sections: Vec<Section>) {
+    LAYOUTS
+        .write()
+        .unwrap_or_else(|e| e.into_inner())
+        .insert(block.to_string(), sections);
+}
+
+fn layout(block: &str) -> Vec<Section> {
+    LAYOUTS
+        .read()
+        .unwrap_or_else(|e| e.into_inner())
+        .get(block)
+        .cloned()
+        .unwrap_or_default()
+}
+
+/// `info`'s variables grouped by its layout. Keys that are neither the
+/// block's nor shared are skipped.
+fn sections(info: &BlockInfo) -> Vec<(String, Vec<ConfigVar>)> {
+    let shared = config_vars::shared_config_vars();
+    let find = |key: &str| {
+        info.config_keys
+            .iter()
+            .chain(&shared)
+            .find(|v| v.key == key)
+            .cloned()
+    };
+    let mut placed = Vec::new();
+    let mut out: Vec<(String, Vec<ConfigVar>)> = Vec::new();
+    for section in layout(&info.name) {
+        let vars: Vec<ConfigVar> = section
+            .keys
+            .iter()
+            .filter_map(|key| {
+                let var = find(key);
+                if var.is_none() {
+                    tracing::warn!(
+                        block = %info.name,
+                        key = %key,
+                        "settings layout names an unknown variable"
+                    );
+                }
+                var
+            })
+            .collect();
+        placed.extend(vars.iter().map(|v| v.key.clone()));
+        out.push((section.title, vars));
+    }
+    let rest: Vec<ConfigVar> = info
+        .config_keys
+        .iter()
+        .filter(|v| !placed.contains(&v.key))
+        .cloned()
+        .collect();
+    if !rest.is_empty() {
+        let title = if out.is_empty() { "Settings" } else { "Other" };
+        out.push((title.to_string(), rest));
+    }
+    out
+}
+
+/// Every variable `info`'s settings form may write.
+pub fn allowed(info: &BlockInfo) -> Vec<ConfigVar> {
+    sections(info)
+        .into_iter()
+        .flat_map(|(_, vars)| vars)
+        .collect()
+}
+
+fn widget(input_type: &InputType) -> &'static str {
+    match input_type {
+        InputType::Text => "text",
+        InputType::Password => "password",
+        InputType::Url => "url",
+        InputType::Toggle => "toggle",
+        InputType::Color => "color",
+        InputType::Textarea => "textarea",
+    }
+}
+
+/// `info`'s settings form: sections of fields, each with its label,
+/// description, widget, default and current value (defaults applied,
+/// secrets masked).
+pub async fn describe(ctx: &dyn Context, info: &BlockInfo) -> serde_json::Value {
+    let mut out = Vec::new();
+    for (title, vars) in sections(info) {
+        let mut fields = Vec::with_capacity(vars.len());
+        for var in &vars {
+            let sensitive = is_sensitive_key(&var.key, var.is_sensitive() as i64);
+            let value = config::get_default(ctx, &var.key, &var.default).await;
+            let label = if var.name.is_empty() {
+                &var.key
+            } else {
+                &var.name
+            };
+            fields.push(serde_json::json!({
+                "key": var.key,
+                "label": label,
+                "description": var.description,
+                "widget": widget(&var.input_type),
+                "default": if sensitive { "" } else { var.default.as_str() },
+                "optional": var.optional,
+                "sensitive": sensitive,
+                "value": match (sensitive, value.is_empty()) {
+                    (true, false) => MASKED_VALUE,
+                    (true, true) => "",
+                    (false, _) => value.as_str(),
+                },
+            }));
+        }
+        out.push(serde_json::json!({ "title": title, "fields": fields }));
+    }
+    serde_json::json!({ "block": info.name, "sections": out })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    fn info(name: &str) -> BlockInfo {
+        BlockInfo::new(name, "0.0.1", "http-handler@v1", "test").config_keys(vec![
+            ConfigVar::new("TEST__UI__TITLE", "Shown on the page", "Hello").name("Title"),
+            ConfigVar::new("TEST__UI__TOKEN", "API token", "").input_type(InputType::Password),
+            ConfigVar::new("TEST__UI__LIMIT", "Most items", "10"),
+        ])
+    }
+
+    #[tokio::test]
+    async fn fields_follow_the_layout_with_secrets_masked() {
+        let mut ctx = TestContext::new().await;
+        ctx.set_config("TEST__UI__TOKEN", "s3cret");
+        let block = info("test/ui-layout");
+        register(
+            &block.name,
+            vec![
+                Section::new("Display", &["TEST__UI__TITLE", "SOLOBASE_SHARED__APP_NAME"]),
+                Section::new("API", &["TEST__UI__TOKEN", "TEST__UI__MISSING"]),
+            ],
+        );
+
+        let body = describe(&ctx, &block).await;
+        let titles: Vec<&str> = body["sections"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|s| s["title"].as_str().unwrap())
+            .collect();
+        assert_eq!(titles, ["Display", "API", "Other"]);
+        let title = &body["sections"][0]["fields"][0];
+        assert_eq!(title["label"], "Title");
+        assert_eq!(title["value"], "Hello");
+        assert_eq!(
+            body["sections"][0]["fields"][1]["key"],
+            "SOLOBASE_SHARED__APP_NAME"
+        );
+        let token = &body["sections"][1]["fields"][0];
+        assert_eq!(token["sensitive"], true);
+        assert_eq!(token["widget"], "password");
+        assert_eq!(token["value"], MASKED_VALUE);
+        assert!(!body.to_string().contains("s3cret"));
+        assert_eq!(body["sections"][2]["fields"][0]["key"], "TEST__UI__LIMIT");
+        assert_eq!(allowed(&block).len(), 4);
+    }
+
+    #[tokio::test]
+    async fn without_a_layout_every_variable_is_one_section() {
+        let ctx = TestContext::new().await;
+        let body = describe(&ctx, &info("test/ui-plain")).await;
+        assert_eq!(body["sections"][0]["title"], "Settings");
+        assert_eq!(body["sections"][0]["fields"].as_array().unwrap().len(), 3);
+        assert_eq!(body["sections"][0]["fields"][1]["value"], "");
+    }
+}
@@ -131,6 +131,32 @@ pub fn shared_config_vars() -> Vec<ConfigVar> {
         )
         .name("Has Landing Page")
         .input_type(InputType::Toggle),
+        ConfigVar::new(
+            "SOLOBASE_SHARED__DISABLE_UI",
+            "Stop serving the built-in HTML pages (admin, portal, auth). JSON \
+             APIs and static assets keep working, for embedders bringing \
+             their own frontend.",
+            "false",
+        )
+        .name("Disable UI")
+        .input_type(InputType::Toggle),
+        ConfigVar::new(
+            "SOLOBASE_SHARED__ENABLE_AUTH_PAGES",
+            "With Disable UI on, keep the hosted login, signup, password \
+             reset and change-password pages",
+            "false",
+        )
+        .name("Enable Auth Pages")
+        .input_type(InputType::Toggle),
+        ConfigVar::new(
+            "SOLOBASE_SHARED__REDIRECT_ALLOWED_ORIGINS",
+            "Comma-separated origins (e.g. https://app.example.com) that \
+             `?redirect=` and Post-Login Redirect may point at besides this \
+             site",
+            "",
+        )
+        .name("Redirect Allowed Origins")
+        .input_type(InputType::Text),
         ConfigVar::new(
             "SOLOBASE_SHARED__EMBEDDED_SCRIPTS",
             "Comma-separated module-script URLs injected into every SSR page \
@@ -140,6 +166,32 @@ pub fn shared_config_vars() -> Vec<ConfigVar> {
         )
         .name("Embedded Scripts")
         .input_type(InputType::Text),
+        ConfigVar::new(
+            "SOLOBASE_SHARED__REQUEST_TIMEOUT_MS",
+            "Milliseconds a request handler may run before the pipeline answers \
+             504. Uploads, downloads and streaming endpoints are exempt. 0 \
+             disables the timeout; a value that isn't a number keeps the \
+             60000 default.",
+            "60000",
+        )
+        .name("Request Timeout (ms)")
+        .input_type(InputType::Text),
+        ConfigVar::new(
+            crate::compression::ENABLED_KEY,
+            "Gzip JSON and HTML responses for clients that accept it. File \
+             downloads and streams are never compressed. No effect on \
+             Cloudflare, which compresses at the edge.",
+            "true",
+        )
+        .name("Compress Responses")
+        .input_type(InputType::Toggle),
+        ConfigVar::new(
+            crate::compression::LEVEL_KEY,
+            "Gzip level from 1 (fastest) to 9 (smallest responses)",
+            "6",
+        )
+        .name("Compression Level")
+        .input_type(InputType::Text),
     ];
     // Auth-scoped shared vars (suppers-ai/auth reads these; admin writes them).
     // Declared here rather than in the auth block's BlockInfo::config_keys because
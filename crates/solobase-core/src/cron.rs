@@ -0,0 +1,297 @@
+//! Cron expressions: the standard five-field syntax and the `@daily`-style
+//! shortcuts, evaluated in UTC.
+//!
+//! ```text
+//! ┌ minute (0-59)
+//! │ ┌ hour (0-23)
+//! │ │ ┌ day of month (1-31)
+//! │ │ │ ┌ month (1-12 or JAN-DEC)
+//! │ │ │ │ ┌ day of week (0-7 or SUN-SAT; 0 and 7 are Sunday)
+//! * * * * *
+//! ```
+//!
+//! Each field takes `*`, a value, a range `a-b`, a step `*/n`, `a-b/n` or
+//! `a/n`, or a comma-separated list of those. As in Vixie cron, when both
+//! day fields are restricted a time matches if *either* does (`0 0 1 * 1`
+//! is midnight on the 1st and on every Monday). Shortcuts: `@yearly`
+//! (`@annually`), `@monthly`, `@weekly`, `@daily` (`@midnight`) and
+//! `@hourly`.
+
+use chrono::{DateTime, Datelike, Duration, TimeZone, Timelike, Utc};
+
+const MONTH_NAMES: [&str; 12] = [
+    "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec",
+];
+const DAY_NAMES: [&str; 7] = ["sun", "mon", "tue", "wed", "thu", "fri", "sat"];
+
+/// How far ahead [`Schedule::next_after`] looks before deciding an
+/// expression never matches (`0 0 30 2 *`).
+const SEARCH_YEARS: i32 = 5;
+
+/// A parsed cron expression. Each field is a bit set of the values it
+/// matches.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Schedule {
+    minutes: u64,
+    hours: u32,
+    days: u32,
+    months: u16,
+    weekdays: u8,
+    /// Both day fields are restricted, so either one matching is enough.
+    day_or: bool,
+}
+
+impl Schedule {
+    /// Parse `spec`. The error names the field that is wrong.
+    pub fn parse(spec: &str) -> Result<Self, String> {
+        let spec = spec.trim();
+        let expanded = match spec.to_ascii_lowercase().as_str() {
+            "@yearly" | "@annually" => "0 0 1 1 *",
+            "@monthly" => "0 0 1 * *",
+            "@weekly" => "0 0 * * 0",
+            "@daily" | "@midnight" => "0 0 * * *",
+            "@hourly" => "0 * * * *",
+            s if s.starts_with('@') => return Err(format!("unknown shortcut {spec:?}")),
+            _ => spec,
+        };
+        let fields: Vec<&str> = expanded.split_whitespace().collect();
+        let [minute, hour, day, month, weekday] = fields[..] else {
+            return Err(format!(
+                "expected 5 fields (minute hour day month weekday), got {}",
+                fields.len()
+            ));
+        };
+        let weekdays = parse_field("day of week", weekday, 0, 7, &DAY_NAMES, 0)?;
+        Ok(Self {
+            minutes: parse_field("minute", minute, 0, 59, &[], 0)?,
+            hours: parse_field("hour", hour, 0, 23, &[], 0)? as u32,
+            days: parse_field("day of month", day, 1, 31, &[], 0)? as u32,
+            months: parse_field("month", month, 1, 12, &MONTH_NAMES, 1)? as u16,
+            // 7 is another name for Sunday.
+            weekdays: ((weekdays | (weekdays >> 7)) & 0x7f) as u8,
+            day_or: !day.starts_with('*') && !weekday.starts_with('*'),
+        })
+    }
+
+    /// Whether `at` (to the minute) matches.
+    pub fn matches(&self, at: DateTime<Utc>) -> bool {
+        self.minutes & (1 << at.minute()) != 0
+            && self.hours & (1 << at.hour()) != 0
+            && self.months & (1 << at.month()) != 0
+            && self.day_matches(at)
+    }
+
+    fn day_matches(&self, at: DateTime<Utc>) -> bool {
+        let day = self.days & (1 << at.day()) != 0;
+        let weekday = self.weekdays & (1 << at.weekday().num_days_from_sunday()) != 0;
+        if self.day_or {
+            day || weekday
+        } else {
+            day && weekday
+        }
+    }
+
+    /// The first matching minute strictly after `after`, or `None` when
+    /// nothing matches within [`SEARCH_YEARS`].
+    pub fn next_after(&self, after: DateTime<Utc>) -> Option<DateTime<Utc>> {
+        let mut t = after.with_second(0)?.with_nanosecond(0)? + Duration::minutes(1);
+        let limit = after.year() + SEARCH_YEARS;
+        while t.year() <= limit {
+            if self.months & (1 << t.month()) == 0 {
+                let (year, month) = if t.month() == 12 {
+                    (t.year() + 1, 1)
+                } else {
+                    (t.year(), t.month() + 1)
+                };
+                t = Utc.with_ymd_and_hms(year, month, 1, 0, 0, 0).single()?;
+            } else if !self.day_matches(t) {
+                t = start_of_day(t) + Duration::days(1);
+            } else if self.hours & (1 << t.hour()) == 0 {
+                t = start_of_day(t) + Duration::hours(i64::from(t.hour()) + 1);
+            } else if self.minutes & (1 << t.minute()) == 0 {
+                t += Duration::minutes(1);
+            } else {
+                return Some(t);
+            }
+        }
+        None
+    }
+}
+
+fn start_of_day(t: DateTime<Utc>) -> DateTime<Utc> {
+    Utc.with_ymd_and_hms(t.year(), t.month(), t.day(), 0, 0, 0)
+        .single()
+        .unwrap_or(t)
+}
+
+/// Parse one field into a bit set over `min..=max`. `names` are accepted
+/// for values, the first one meaning `first_name`.
+fn parse_field(
+    label: &str,
+    text: &str,
+    min: u32,
+    max: u32,
+    names: &[&str],
+    first_name: u32,
+) -> Result<u64, String> {
+    let value = |s: &str| -> Result<u32, String> {
+        let lower = s.to_ascii_lowercase();
+        if let Some(i) = names.iter().position(|n| *n == lower) {
+            return Ok(first_name + i as u32);
+        }
+        match s.parse::<u32>() {
+            Ok(n) if (min..=max).contains(&n) => Ok(n),
+            _ => Err(format!("{label}: {s:?} is not a value in {min}-{max}")),
+        }
+    };
+
+    let mut bits = 0u64;
+    for item in text.split(',') {
+        let (range, step) = match item.split_once('/') {
+            Some((range, step)) => match step.parse::<u32>() {
+                Ok(n) if n > 0 => (range, n),
+                _ => return Err(format!("{label}: bad step in {item:?}")),
+            },
+            None => (item, 1),
+        };
+        let (lo, hi) = if range == "*" {
+            (min, max)
+        } else if let Some((a, b)) = range.split_once('-') {
+            (value(a)?, value(b)?)
+        } else {
+            let v = value(range)?;
+            // `a/n` runs from `a` to the end of the range.
+            (v, if item.contains('/') { max } else { v })
+        };
+        if lo > hi {
+            return Err(format!("{label}: range {range:?} runs backwards"));
+        }
+        for v in (lo..=hi).step_by(step as usize) {
+            bits |= 1 << v;
+        }
+    }
+    Ok(bits)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn at(s: &str) -> DateTime<Utc> {
+        DateTime::parse_from_rfc3339(s).unwrap().with_timezone(&Utc)
+    }
+
+    fn next(spec: &str, after: &str) -> String {
+        Schedule::parse(spec)
+            .unwrap()
+            .next_after(at(after))
+            .unwrap()
+            .to_rfc3339()
+    }
+
+    #[test]
+    fn shortcuts_expand_to_their_fields() {
+        assert_eq!(
+            Schedule::parse("@daily").unwrap(),
+            Schedule::parse("0 0 * * *").unwrap()
+        );
+        assert_eq!(
+            Schedule::parse("@HOURLY").unwrap(),
+            Schedule::parse("0 * * * *").unwrap()
+        );
+        assert_eq!(
+            next("@hourly", "2026-03-01T10:00:00Z"),
+            "2026-03-01T11:00:00+00:00"
+        );
+        assert_eq!(
+            next("@weekly", "2026-03-04T10:00:00Z"),
+            "2026-03-08T00:00:00+00:00"
+        );
+        assert_eq!(
+            next("@yearly", "2026-03-04T10:00:00Z"),
+            "2027-01-01T00:00:00+00:00"
+        );
+    }
+
+    #[test]
+    fn next_is_strictly_after_and_drops_seconds() {
+        assert_eq!(
+            next("30 2 * * *", "2026-03-01T02:30:00Z"),
+            "2026-03-02T02:30:00+00:00"
+        );
+        assert_eq!(
+            next("* * * * *", "2026-03-01T02:30:45Z"),
+            "2026-03-01T02:31:00+00:00"
+        );
+    }
+
+    #[test]
+    fn lists_ranges_and_steps() {
+        assert_eq!(
+            next("*/15 9-17 * * MON-FRI", "2026-03-06T17:50:00Z"),
+            "2026-03-09T09:00:00+00:00"
+        );
+        assert_eq!(
+            next("5,35 */6 * * *", "2026-03-01T06:06:00Z"),
+            "2026-03-01T06:35:00+00:00"
+        );
+        assert_eq!(
+            next("10/20 * * * *", "2026-03-01T06:31:00Z"),
+            "2026-03-01T06:50:00+00:00"
+        );
+        assert_eq!(
+            next("0 0 1 jan,jul *", "2026-03-01T00:00:00Z"),
+            "2026-07-01T00:00:00+00:00"
+        );
+    }
+
+    #[test]
+    fn seven_is_sunday() {
+        assert_eq!(
+            Schedule::parse("0 0 * * 7").unwrap(),
+            Schedule::parse("0 0 * * 0").unwrap()
+        );
+    }
+
+    #[test]
+    fn restricted_day_fields_match_either() {
+        // The 1st of the month or any Monday.
+        let s = Schedule::parse("0 0 1 * 1").unwrap();
+        assert!(s.matches(at("2026-03-01T00:00:00Z"))); // Sunday the 1st
+        assert!(s.matches(at("2026-03-02T00:00:00Z"))); // Monday
+        assert!(!s.matches(at("2026-03-03T00:00:00Z")));
+        // A starred day of month leaves the weekday alone.
+        let s = Schedule::parse("0 0 * * 1").unwrap();
+        assert!(!s.matches(at("2026-03-01T00:00:00Z")));
+    }
+
+    #[test]
+    fn leap_day_and_impossible_dates() {
+        assert_eq!(
+            next("0 0 29 2 *", "2026-03-01T00:00:00Z"),
+            "2028-02-29T00:00:00+00:00"
+        );
+        let never = Schedule::parse("0 0 30 2 *").unwrap();
+        assert_eq!(never.next_after(at("2026-03-01T00:00:00Z")), None);
+    }
+
+    #[test]
+    fn rejects_malformed_expressions() {
+        for bad in [
+            "",
+            "* * * *",
+            "* * * * * *",
+            "60 * * * *",
+            "* 24 * * *",
+            "* * 0 * *",
+            "* * * 13 *",
+            "* * * * 8",
+            "*/0 * * * *",
+            "5-1 * * * *",
+            "@fortnightly",
+            "a * * * *",
+        ] {
+            assert!(Schedule::parse(bad).is_err(), "{bad:?} parsed");
+        }
+    }
+}
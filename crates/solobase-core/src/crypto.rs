@@ -28,11 +28,17 @@ pub const META_AUTH_JTI: &str = "auth.jti";
 /// `expires_at` (only needs to live as long as the original JWT).
 pub const META_AUTH_EXP: &str = "auth.exp";
 
+/// Meta key set to `"true"` when the access JWT carries the `pwd_change`
+/// claim (the account was flagged `must_change_password` at mint time). The
+/// pipeline's password-change gate re-checks the user row before blocking,
+/// so a token minted before the change does not stay locked out.
+pub const META_AUTH_MUST_CHANGE_PASSWORD: &str = "auth.must_change_password";
+
 /// Extract JWT claims from an `Authorization: Bearer <token>` header and
 /// set auth meta fields on the message.
 ///
 /// Sets: `auth.user_id`, `auth.user_email`, `auth.user_roles`, and (when
-/// present in the JWT) `auth.jti` + `auth.exp`.
+/// present in the JWT) `auth.jti` + `auth.exp` + `auth.must_change_password`.
 ///
 /// Silently does nothing if the token is invalid, fails the issuer
 /// check (SEC-038), is blocklisted (SEC-042), or isn't an `access`
@@ -143,6 +149,9 @@ pub async fn extract_auth_meta(
     if let Some(exp) = claims.get("exp").and_then(|v| v.as_i64()) {
         msg.set_meta(META_AUTH_EXP, exp.to_string());
     }
+    if claims.get("pwd_change").and_then(|v| v.as_bool()) == Some(true) {
+        msg.set_meta(META_AUTH_MUST_CHANGE_PASSWORD, "true");
+    }
 }
 
 // ---------------------------------------------------------------------------
@@ -304,6 +313,29 @@ mod tests {
         assert_eq!(msg.get_meta(wafer_run::META_AUTH_USER_ID), "user-a");
         assert_eq!(msg.get_meta(META_AUTH_JTI), "jti-1");
         assert!(!msg.get_meta(META_AUTH_EXP).is_empty());
+        assert_eq!(msg.get_meta(META_AUTH_MUST_CHANGE_PASSWORD), "");
+    }
+
+    #[tokio::test]
+    async fn extract_auth_meta_flags_pending_password_change() {
+        use wafer_run::Message;
+        let ctx = crate::test_support::TestContext::with_auth().await;
+        let secret = "test-secret";
+        let derived = primitives::derive_block_key(
+            secret.as_bytes(),
+            crate::blocks::auth_ui::AUTH_UI_BLOCK_ID,
+        );
+        let mut claims = HashMap::new();
+        claims.insert("sub".to_string(), serde_json::json!("user-a"));
+        claims.insert("type".to_string(), serde_json::json!("access"));
+        claims.insert("pwd_change".to_string(), serde_json::json!(true));
+        let token =
+            primitives::jwt_sign(claims, Duration::from_secs(3600), derived.as_bytes()).unwrap();
+
+        let mut msg = Message::new("http.request");
+        extract_auth_meta(&ctx, &format!("Bearer {token}"), secret, "", &mut msg).await;
+        assert_eq!(msg.get_meta(wafer_run::META_AUTH_USER_ID), "user-a");
+        assert_eq!(msg.get_meta(META_AUTH_MUST_CHANGE_PASSWORD), "true");
     }
 
     /// Regression test for the bcf96ce → d7107c4 regression: production user
@@ -0,0 +1,206 @@
+//! Shared detail-endpoint conventions: `?fields` and `?expand`.
+//!
+//! An endpoint that returns one resource declares a [`DetailSpec`]: the
+//! top-level fields a caller may keep, and the related resources it may
+//! pull in alongside. [`parse`] reads both from the query:
+//!
+//! - `?fields=a,b` keeps only those fields (the id always stays); without
+//!   it every field is returned.
+//! - `?expand=x,y` adds each named related resource under its own key, so
+//!   a client gets, say, an object and its share links in one round trip.
+//!
+//! Both are allowlists, so a caller can't ask for a join the endpoint
+//! doesn't offer; a name outside them is a `validation_failed` 400 whose
+//! details list the valid names. The handler loads an expansion only when
+//! [`DetailQuery::expands`] asks for it, with one query per expansion, and
+//! trims the body with [`DetailQuery::project`] (or
+//! [`DetailQuery::project_record`] when it returns a database record).
+//!
+//! A resource that always carried some related data before this existed
+//! lists it in `default_expand`: it stays in the body when the request
+//! has no `?fields=`, and with one, only when `fields` or `expand` names
+//! it — so `?fields=base_price` skips that lookup too.
+//!
+//! Respond through [`crate::etag::ok_json`]. Its tag hashes the projected
+//! body, so two requests with different `fields` or `expand` never share a
+//! tag and a `304` always answers for the same shape.
+
+use wafer_core::clients::database::Record;
+use wafer_run::{Message, OutputStream};
+
+use crate::blocks::errors::validation_error;
+
+/// Most names a single `?fields=` or `?expand=` may list.
+const MAX_NAMES: usize = 64;
+
+/// What one detail endpoint lets a caller ask for.
+#[derive(Debug, Clone, Copy)]
+pub struct DetailSpec {
+    /// Key of the identity field every projection keeps.
+    pub id_field: &'static str,
+    /// Top-level fields `?fields=` may name.
+    pub fields: &'static [&'static str],
+    /// Related resources `?expand=` may name.
+    pub expand: &'static [&'static str],
+    /// Expansions returned unasked when there is no `?fields=` (see the
+    /// module docs). Each must also be in `expand`.
+    pub default_expand: &'static [&'static str],
+}
+
+/// The parsed `?fields=` / `?expand=` of one request.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DetailQuery {
+    /// `None` returns every field.
+    pub fields: Option<Vec<String>>,
+    /// Expansions to load: the ones asked for plus the defaults that
+    /// apply.
+    pub expand: Vec<String>,
+}
+
+impl DetailQuery {
+    /// Whether the related resource `name` should be loaded.
+    pub fn expands(&self, name: &str) -> bool {
+        self.expand.iter().any(|e| e == name)
+    }
+
+    /// Keep only the requested fields and expansions (plus the id) of a
+    /// JSON object. No-op without `?fields=`.
+    pub fn project(&self, value: &mut serde_json::Value, spec: &DetailSpec) {
+        let (Some(fields), Some(map)) = (&self.fields, value.as_object_mut()) else {
+            return;
+        };
+        map.retain(|k, _| k == spec.id_field || fields.iter().any(|f| f == k) || self.expands(k));
+    }
+
+    /// [`Self::project`] for a record body: trims `data`; the record's
+    /// `id` always stays.
+    pub fn project_record(&self, record: &mut Record) {
+        if let Some(fields) = &self.fields {
+            record
+                .data
+                .retain(|k, _| fields.iter().any(|f| f == k) || self.expands(k));
+        }
+    }
+}
+
+/// Read `?fields=` and `?expand=` against `spec`.
+pub fn parse(msg: &Message, spec: &DetailSpec) -> Result<DetailQuery, OutputStream> {
+    parse_params(msg.query("fields"), msg.query("expand"), spec)
+}
+
+fn parse_params(
+    fields: &str,
+    expand: &str,
+    spec: &DetailSpec,
+) -> Result<DetailQuery, OutputStream> {
+    // A default expansion may be named in `fields` too: it reads as a
+    // field to the caller.
+    let field_names: Vec<&str> = spec
+        .fields
+        .iter()
+        .chain(spec.default_expand)
+        .copied()
+        .collect();
+    let fields = parse_names("fields", fields, &field_names)?;
+    let mut expanded = parse_names("expand", expand, spec.expand)?.unwrap_or_default();
+    for default in spec.default_expand {
+        let wanted = fields
+            .as_ref()
+            .map_or(true, |f| f.iter().any(|name| name == default));
+        if wanted && !expanded.iter().any(|e| e == default) {
+            expanded.push(default.to_string());
+        }
+    }
+    Ok(DetailQuery {
+        fields,
+        expand: expanded,
+    })
+}
+
+/// A comma-separated list of names from `allowed`, deduplicated. `None`
+/// when the parameter is absent or blank.
+fn parse_names(
+    param: &str,
+    raw: &str,
+    allowed: &[&str],
+) -> Result<Option<Vec<String>>, OutputStream> {
+    if raw.trim().is_empty() {
+        return Ok(None);
+    }
+    let mut names: Vec<String> = Vec::new();
+    for name in raw.split(',').map(str::trim).filter(|n| !n.is_empty()) {
+        if !allowed.contains(&name) {
+            let reason = format!("unknown name {name:?}; valid: {}", allowed.join(", "));
+            return Err(validation_error(
+                "Invalid detail parameters",
+                &[(param, reason.as_str())],
+            ));
+        }
+        if !names.iter().any(|n| n == name) {
+            names.push(name.to_string());
+        }
+    }
+    if names.len() > MAX_NAMES {
+        return Err(validation_error(
+            "Invalid detail parameters",
+            &[(param, "too many names")],
+        ));
+    }
+    Ok(Some(names))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::output_json;
+
+    const SPEC: DetailSpec = DetailSpec {
+        id_field: "id",
+        fields: &["id", "name", "price"],
+        expand: &["group", "media"],
+        default_expand: &["media"],
+    };
+
+    #[test]
+    fn no_params_returns_everything_with_default_expansions() {
+        let q = parse_params("", "", &SPEC).unwrap();
+        assert_eq!(q.fields, None);
+        assert!(q.expands("media") && !q.expands("group"));
+        let mut body = serde_json::json!({"id": "p1", "name": "n", "price": 1, "media": []});
+        let before = body.clone();
+        q.project(&mut body, &SPEC);
+        assert_eq!(body, before);
+    }
+
+    #[test]
+    fn fields_project_and_drop_unnamed_defaults() {
+        let q = parse_params("price, price", "group", &SPEC).unwrap();
+        assert_eq!(q.fields, Some(vec!["price".to_string()]));
+        assert!(q.expands("group") && !q.expands("media"));
+        let mut body =
+            serde_json::json!({"id": "p1", "name": "n", "price": 1, "group": {}, "media": []});
+        q.project(&mut body, &SPEC);
+        assert_eq!(
+            body,
+            serde_json::json!({"id": "p1", "price": 1, "group": {}})
+        );
+
+        // A default expansion named as a field is still loaded.
+        let q = parse_params("price,media", "", &SPEC).unwrap();
+        assert!(q.expands("media"));
+    }
+
+    #[tokio::test]
+    async fn unknown_names_list_the_valid_ones() {
+        let err = parse_params("", "group,owner", &SPEC).unwrap_err();
+        let body = output_json(err).await;
+        assert_eq!(body["code"], "validation_failed");
+        let reason = body["details"]["expand"].as_str().unwrap();
+        assert!(reason.contains("\"owner\"") && reason.ends_with("valid: group, media"));
+
+        let err = parse_params("cost", "", &SPEC).unwrap_err();
+        let body = output_json(err).await;
+        let reason = body["details"]["fields"].as_str().unwrap();
+        assert!(reason.ends_with("valid: id, name, price, media"));
+    }
+}
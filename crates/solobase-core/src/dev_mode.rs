@@ -0,0 +1,571 @@
+//! Developer mode: simulated latency and failures for frontend work.
+//!
+//! Once [`install`]ed, the pipeline serves a `/api/_dev/` namespace:
+//!
+//! - `GET /api/_dev/echo` — the request back as JSON: action, path, query,
+//!   headers (credentials redacted), the resolved caller and the body.
+//! - `POST /api/_dev/latency` — `{"min_ms", "max_ms"}`: every other request
+//!   waits a random delay in that range before it is routed. Zero for both
+//!   turns it off.
+//! - `POST /api/_dev/fail` — `{"path", "status", "count"}`: the next
+//!   `count` requests whose path matches `path` (`*` matches any run of
+//!   characters) answer `status` without reaching their block.
+//! - `POST /api/_dev/storage-faults` — `{"op", "key", "mode",
+//!   "probability", "latency_ms", "count"}`: storage calls of kind `op`
+//!   (`get`, `put`, `delete`, `list`, `create_folder`, `delete_folder`,
+//!   `list_folders` or `*`) whose `folder/key` matches `key` fail as `mode`
+//!   says, with the given probability (default 1), for the next `count`
+//!   matching calls (default: until reset). See [`crate::storage_faults`].
+//! - `GET /api/_dev/state` — the active simulations.
+//!   `POST /api/_dev/reset` clears them.
+//!
+//! Every response a simulation touched carries [`HEADER`], so nobody
+//! mistakes a simulated failure for a real one, and each simulation is
+//! logged at `warn`. Storage faults happen below the response, so they are
+//! only logged, with `injected = true`. The state is process memory and is
+//! gone on restart.
+//!
+//! Native installs it when [`ENABLED_KEY`] is true; [`install`] refuses
+//! when `SOLOBASE_SHARED__ENVIRONMENT` is `production`. The stateless
+//! targets never install it, and without it the namespace doesn't exist:
+//! `/api/_dev/*` 404s like any unknown path. Delays sleep on the pipeline's
+//! request timer, so they only apply where one is installed.
+
+use std::time::Duration;
+
+use wafer_run::{InputStream, Message, MetaEntry, OutputStream};
+
+use crate::{
+    http::{err_bad_request, err_not_found, ok_json, ResponseBuilder},
+    storage_faults::{FaultMode, StorageFault},
+};
+
+/// Boot-time switch (native reads it from the environment).
+pub const ENABLED_KEY: &str = "SOLOBASE_DEV_MODE";
+/// Response header marking a simulated delay or failure.
+pub const HEADER: &str = "X-Solobase-Dev";
+/// Path prefix of the namespace once the pipeline has stripped `/api`.
+const PREFIX: &str = "/_dev";
+/// Longest delay `POST /api/_dev/latency` accepts.
+const MAX_LATENCY_MS: u64 = 30_000;
+/// Most requests one `POST /api/_dev/fail` rule may fail.
+const MAX_FAIL_COUNT: u32 = 1_000;
+/// Storage operations `POST /api/_dev/storage-faults` can target.
+const STORAGE_OPS: &[&str] = &[
+    "get",
+    "put",
+    "delete",
+    "list",
+    "create_folder",
+    "delete_folder",
+    "list_folders",
+];
+
+#[derive(Debug, thiserror::Error)]
+#[error("developer mode is refused when SOLOBASE_SHARED__ENVIRONMENT is production")]
+pub struct RefusedInProduction;
+
+#[derive(Debug, Default)]
+struct State {
+    /// Inclusive delay range in milliseconds.
+    latency: Option<(u64, u64)>,
+    failures: Vec<FailRule>,
+    storage_faults: Vec<StorageFaultRule>,
+}
+
+#[derive(Debug, serde::Serialize)]
+struct FailRule {
+    path: String,
+    status: u16,
+    remaining: u32,
+}
+
+#[derive(Debug, serde::Serialize)]
+struct StorageFaultRule {
+    op: String,
+    key: String,
+    mode: FaultMode,
+    probability: f64,
+    latency_ms: u64,
+    /// `None` fails every matching call until reset.
+    remaining: Option<u32>,
+}
+
+/// `None` until [`install`]: the namespace and simulations are off.
+#[cfg(not(test))]
+static STATE: std::sync::Mutex<Option<State>> = std::sync::Mutex::new(None);
+
+// Each test thread gets its own switch, so a test that installs developer
+// mode can't turn it on under one asserting that it's off.
+#[cfg(test)]
+thread_local! {
+    static STATE: std::cell::RefCell<Option<State>> = const { std::cell::RefCell::new(None) };
+}
+
+#[cfg(not(test))]
+fn with_state<R>(f: impl FnOnce(&mut Option<State>) -> R) -> R {
+    // Every critical section leaves the state consistent, so a poisoned
+    // lock is safe to reuse.
+    f(&mut STATE.lock().unwrap_or_else(|e| e.into_inner()))
+}
+
+#[cfg(test)]
+fn with_state<R>(f: impl FnOnce(&mut Option<State>) -> R) -> R {
+    STATE.with(|s| f(&mut s.borrow_mut()))
+}
+
+/// Turn developer mode on, unless `environment` (the
+/// `SOLOBASE_SHARED__ENVIRONMENT` value) is `production`. Calling it again
+/// keeps the current simulations.
+pub fn install(environment: &str) -> Result<(), RefusedInProduction> {
+    if environment.trim().eq_ignore_ascii_case("production") {
+        return Err(RefusedInProduction);
+    }
+    with_state(|s| {
+        s.get_or_insert_with(State::default);
+    });
+    tracing::warn!(
+        "developer mode is on: /api/_dev/ is mounted and can simulate latency and failures"
+    );
+    Ok(())
+}
+
+/// Whether developer mode is installed.
+pub fn is_enabled() -> bool {
+    with_state(|s| s.is_some())
+}
+
+/// Whether `path` (with `/api` already stripped) belongs to the namespace
+/// and developer mode is on.
+pub(crate) fn serves(path: &str) -> bool {
+    let in_namespace = path
+        .strip_prefix(PREFIX)
+        .is_some_and(|rest| rest.is_empty() || rest.starts_with('/'));
+    in_namespace && is_enabled()
+}
+
+/// Answer a request [`serves`] accepted.
+pub(crate) async fn handle(msg: &Message, input: InputStream) -> OutputStream {
+    match (msg.action(), msg.path()) {
+        (_, "/_dev/echo") => echo(msg, input).await,
+        ("retrieve", "/_dev/state") => state_response(),
+        ("create", "/_dev/latency") => set_latency(input).await,
+        ("create", "/_dev/fail") => add_failure(input).await,
+        ("create", "/_dev/storage-faults") => add_storage_fault(input).await,
+        ("create", "/_dev/reset") => {
+            with_state(|s| {
+                if let Some(s) = s {
+                    *s = State::default();
+                }
+            });
+            tracing::warn!("developer mode: simulations reset");
+            state_response()
+        }
+        _ => err_not_found("not found"),
+    }
+}
+
+async fn echo(msg: &Message, input: InputStream) -> OutputStream {
+    let mut query = serde_json::Map::new();
+    let mut headers = serde_json::Map::new();
+    for entry in &msg.meta {
+        if let Some(name) = entry.key.strip_prefix("req.query.") {
+            query.insert(name.to_string(), entry.value.clone().into());
+        } else if let Some(name) = entry.key.strip_prefix("http.header.") {
+            let value = if matches!(name, "authorization" | "cookie") {
+                "[redacted]".to_string()
+            } else {
+                entry.value.clone()
+            };
+            headers.insert(name.to_string(), value.into());
+        }
+    }
+    let raw = input.collect_to_bytes().await;
+    let body = serde_json::from_slice::<serde_json::Value>(&raw)
+        .unwrap_or_else(|_| String::from_utf8_lossy(&raw).into_owned().into());
+    ok_json(&serde_json::json!({
+        "action": msg.action(),
+        "path": msg.path(),
+        "query": query,
+        "headers": headers,
+        "user_id": msg.user_id(),
+        "remote_addr": msg.remote_addr(),
+        "body": body,
+    }))
+}
+
+async fn set_latency(input: InputStream) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        min_ms: u64,
+        max_ms: u64,
+    }
+    let raw = input.collect_to_bytes().await;
+    let body: Req = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+    if body.min_ms > body.max_ms {
+        return err_bad_request("min_ms cannot exceed max_ms");
+    }
+    if body.max_ms > MAX_LATENCY_MS {
+        return err_bad_request(&format!("max_ms cannot exceed {MAX_LATENCY_MS}"));
+    }
+    let latency = (body.max_ms > 0).then_some((body.min_ms, body.max_ms));
+    with_state(|s| {
+        if let Some(s) = s {
+            s.latency = latency;
+        }
+    });
+    match latency {
+        Some((min, max)) => {
+            tracing::warn!(
+                min_ms = min,
+                max_ms = max,
+                "developer mode: simulating latency"
+            );
+        }
+        None => tracing::warn!("developer mode: latency simulation off"),
+    }
+    state_response()
+}
+
+async fn add_failure(input: InputStream) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        path: String,
+        status: u16,
+        #[serde(default = "one")]
+        count: u32,
+    }
+    fn one() -> u32 {
+        1
+    }
+    let raw = input.collect_to_bytes().await;
+    let body: Req = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+    let path = body.path.trim();
+    if path.is_empty() {
+        return err_bad_request("path is required");
+    }
+    if !(400..=599).contains(&body.status) {
+        return err_bad_request("status must be between 400 and 599");
+    }
+    if body.count == 0 || body.count > MAX_FAIL_COUNT {
+        return err_bad_request(&format!("count must be between 1 and {MAX_FAIL_COUNT}"));
+    }
+    tracing::warn!(
+        path,
+        status = body.status,
+        count = body.count,
+        "developer mode: simulating failures"
+    );
+    with_state(|s| {
+        if let Some(s) = s {
+            s.failures.push(FailRule {
+                path: path.to_string(),
+                status: body.status,
+                remaining: body.count,
+            });
+        }
+    });
+    state_response()
+}
+
+async fn add_storage_fault(input: InputStream) -> OutputStream {
+    #[derive(serde::Deserialize)]
+    struct Req {
+        op: String,
+        #[serde(default = "any")]
+        key: String,
+        mode: FaultMode,
+        #[serde(default = "certain")]
+        probability: f64,
+        #[serde(default)]
+        latency_ms: u64,
+        count: Option<u32>,
+    }
+    fn any() -> String {
+        "*".to_string()
+    }
+    fn certain() -> f64 {
+        1.0
+    }
+    let raw = input.collect_to_bytes().await;
+    let body: Req = match serde_json::from_slice(&raw) {
+        Ok(b) => b,
+        Err(e) => return err_bad_request(&format!("Invalid body: {e}")),
+    };
+    let op = body.op.trim();
+    if op != "*" && !STORAGE_OPS.contains(&op) {
+        return err_bad_request(&format!(
+            "op must be * or one of: {}",
+            STORAGE_OPS.join(", ")
+        ));
+    }
+    let key = body.key.trim();
+    if key.is_empty() {
+        return err_bad_request("key is required");
+    }
+    if !(0.0..=1.0).contains(&body.probability) {
+        return err_bad_request("probability must be between 0 and 1");
+    }
+    match body.mode {
+        FaultMode::PartialWrite if op != "put" => {
+            return err_bad_request("partial_write only applies to put");
+        }
+        FaultMode::Eof if op != "get" => return err_bad_request("eof only applies to get"),
+        FaultMode::Latency if body.latency_ms == 0 || body.latency_ms > MAX_LATENCY_MS => {
+            return err_bad_request(&format!(
+                "latency_ms must be between 1 and {MAX_LATENCY_MS}"
+            ));
+        }
+        _ => {}
+    }
+    if body.count.is_some_and(|n| n == 0 || n > MAX_FAIL_COUNT) {
+        return err_bad_request(&format!("count must be between 1 and {MAX_FAIL_COUNT}"));
+    }
+    tracing::warn!(
+        op,
+        key,
+        mode = ?body.mode,
+        probability = body.probability,
+        "developer mode: injecting storage faults"
+    );
+    with_state(|s| {
+        if let Some(s) = s {
+            s.storage_faults.push(StorageFaultRule {
+                op: op.to_string(),
+                key: key.to_string(),
+                mode: body.mode,
+                probability: body.probability,
+                latency_ms: body.latency_ms,
+                remaining: body.count,
+            });
+        }
+    });
+    state_response()
+}
+
+fn state_response() -> OutputStream {
+    let body = with_state(|s| {
+        let s = s.as_ref();
+        serde_json::json!({
+            "latency": s.and_then(|s| s.latency).map(|(min, max)| {
+                serde_json::json!({ "min_ms": min, "max_ms": max })
+            }),
+            "failures": s.map_or(&[][..], |s| s.failures.as_slice()),
+            "storage_faults": s.map_or(&[][..], |s| s.storage_faults.as_slice()),
+        })
+    });
+    ok_json(&body)
+}
+
+/// Draw the fault, if any, for a storage `op` on `path` (`folder/key`):
+/// the first rule matching both, when its probability comes up. Consumes
+/// one use of a counted rule.
+pub(crate) fn storage_fault(op: &str, path: &str) -> Option<StorageFault> {
+    let fault = with_state(|s| {
+        let s = s.as_mut()?;
+        let rule = s
+            .storage_faults
+            .iter_mut()
+            .find(|rule| (rule.op == "*" || rule.op == op) && glob_match(&rule.key, path))?;
+        if rule.probability < 1.0 && random_below(1_000_000) as f64 >= rule.probability * 1e6 {
+            return None;
+        }
+        if let Some(remaining) = &mut rule.remaining {
+            *remaining -= 1;
+        }
+        let fault = StorageFault {
+            mode: rule.mode,
+            latency: Duration::from_millis(rule.latency_ms),
+        };
+        s.storage_faults.retain(|rule| rule.remaining != Some(0));
+        Some(fault)
+    })?;
+    tracing::warn!(
+        op,
+        path,
+        mode = ?fault.mode,
+        injected = true,
+        "developer mode: injected storage fault"
+    );
+    Some(fault)
+}
+
+/// What developer mode does to one routed request.
+#[derive(Debug, Default, PartialEq, Eq)]
+pub(crate) struct Simulation {
+    pub delay: Option<Duration>,
+    /// Answer with this status instead of routing.
+    pub failure: Option<u16>,
+}
+
+impl Simulation {
+    /// The [`HEADER`] entry for the response, if anything was simulated.
+    pub fn headers(&self) -> Vec<MetaEntry> {
+        let mut parts = Vec::new();
+        if let Some(delay) = self.delay {
+            parts.push(format!("latency={}ms", delay.as_millis()));
+        }
+        if let Some(status) = self.failure {
+            parts.push(format!("failure={status}"));
+        }
+        if parts.is_empty() {
+            return Vec::new();
+        }
+        vec![MetaEntry {
+            key: format!("resp.header.{HEADER}"),
+            value: format!("simulated; {}", parts.join("; ")),
+        }]
+    }
+
+    /// The reply standing in for the block when `failure` is set.
+    pub fn failure_response(&self) -> Option<OutputStream> {
+        let status = self.failure?;
+        let mut response = ResponseBuilder::new().status(status);
+        for entry in self.headers() {
+            let name = entry.key.trim_start_matches("resp.header.");
+            response = response.set_header(name, &entry.value);
+        }
+        Some(response.json(&serde_json::json!({
+            "error": "simulated",
+            "message": "Simulated failure (developer mode)",
+            "code": "dev_simulated_failure",
+        })))
+    }
+}
+
+/// Draw the simulation for a request to `path`, consuming one use of the
+/// first failure rule that matches it.
+pub(crate) fn simulate(path: &str) -> Simulation {
+    let simulation = with_state(|s| {
+        let Some(s) = s else {
+            return Simulation::default();
+        };
+        let delay = s
+            .latency
+            .map(|(min, max)| Duration::from_millis(min + random_below(max - min + 1)));
+        let failure = s
+            .failures
+            .iter_mut()
+            .find(|rule| glob_match(&rule.path, path))
+            .map(|rule| {
+                rule.remaining -= 1;
+                rule.status
+            });
+        s.failures.retain(|rule| rule.remaining > 0);
+        Simulation { delay, failure }
+    });
+    if let Some(status) = simulation.failure {
+        tracing::warn!(path, status, "developer mode: simulated failure");
+    }
+    simulation
+}
+
+/// Uniform-enough value in `0..n` for picking a delay.
+fn random_below(n: u64) -> u64 {
+    let mut bytes = [0u8; 8];
+    if getrandom::getrandom(&mut bytes).is_err() {
+        return 0;
+    }
+    u64::from_le_bytes(bytes) % n
+}
+
+/// `pattern` against `path`, where `*` matches any run of characters.
+fn glob_match(pattern: &str, path: &str) -> bool {
+    let mut parts = pattern.split('*');
+    let first = parts.next().unwrap_or("");
+    let Some(mut rest) = path.strip_prefix(first) else {
+        return false;
+    };
+    let parts: Vec<&str> = parts.collect();
+    let Some((last, middle)) = parts.split_last() else {
+        return rest.is_empty();
+    };
+    for part in middle {
+        match rest.find(part) {
+            Some(i) => rest = &rest[i + part.len()..],
+            None => return false,
+        }
+    }
+    rest.ends_with(last)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{anon_msg, output_json, output_status};
+
+    fn body(v: serde_json::Value) -> InputStream {
+        InputStream::from_bytes(serde_json::to_vec(&v).unwrap())
+    }
+
+    #[test]
+    fn install_is_refused_in_production() {
+        assert!(install("Production").is_err());
+        assert!(!is_enabled());
+        install("development").unwrap();
+        assert!(serves("/_dev/echo"));
+        assert!(!serves("/_devices"));
+    }
+
+    #[test]
+    fn glob_matches_whole_paths() {
+        assert!(glob_match("/b/auth/api/me", "/b/auth/api/me"));
+        assert!(!glob_match("/b/auth/api/me", "/b/auth/api/me/x"));
+        assert!(glob_match("/b/storage/*", "/b/storage/api/buckets"));
+        assert!(glob_match(
+            "*/objects/*",
+            "/b/storage/api/buckets/a/objects/k"
+        ));
+        assert!(!glob_match("/b/*/objects", "/b/storage/api/buckets"));
+        assert!(glob_match("/b/*e*s", "/b/buckets"));
+    }
+
+    #[tokio::test]
+    async fn failure_rules_count_down_and_state_reports_them() {
+        install("development").unwrap();
+        let msg = anon_msg("create", "/_dev/fail");
+        let rule = serde_json::json!({ "path": "/b/storage/*", "status": 503, "count": 2 });
+        let state = output_json(handle(&msg, body(rule)).await).await;
+        assert_eq!(state["failures"][0]["remaining"], 2);
+
+        assert_eq!(simulate("/b/auth/api/me"), Simulation::default());
+        for _ in 0..2 {
+            let simulation = simulate("/b/storage/api/buckets");
+            assert_eq!(simulation.failure, Some(503));
+            let out = simulation.failure_response().unwrap();
+            assert_eq!(output_status(out).await, 503);
+        }
+        assert_eq!(simulate("/b/storage/api/buckets").failure, None);
+
+        let msg = anon_msg("create", "/_dev/latency");
+        let out = handle(&msg, body(serde_json::json!({ "min_ms": 5, "max_ms": 1 }))).await;
+        assert_eq!(output_status(out).await, 400);
+        let out = handle(&msg, body(serde_json::json!({ "min_ms": 5, "max_ms": 9 }))).await;
+        assert_eq!(output_json(out).await["latency"]["max_ms"], 9);
+        let delay = simulate("/b/auth/api/me").delay.unwrap();
+        assert!((5..=9).contains(&delay.as_millis()));
+
+        let out = handle(&anon_msg("create", "/_dev/reset"), InputStream::empty()).await;
+        assert!(output_json(out).await["latency"].is_null());
+        assert_eq!(simulate("/b/auth/api/me"), Simulation::default());
+    }
+
+    #[tokio::test]
+    async fn echo_returns_the_request() {
+        let mut msg = anon_msg("create", "/_dev/echo");
+        msg.set_meta("req.query.page", "2");
+        msg.set_meta("http.header.authorization", "Bearer secret");
+        let out = handle(&msg, body(serde_json::json!({ "hello": "world" }))).await;
+        let echoed = output_json(out).await;
+        assert_eq!(echoed["action"], "create");
+        assert_eq!(echoed["query"]["page"], "2");
+        assert_eq!(echoed["headers"]["authorization"], "[redacted]");
+        assert_eq!(echoed["body"]["hello"], "world");
+    }
+}
@@ -0,0 +1,524 @@
+//! Preflight checks: a structured answer to "will this instance work?".
+//!
+//! A failed start used to be a handful of log lines in whatever order the
+//! boot hit them. [`Preflight`] instead runs a fixed battery — database
+//! connect + write, storage write + delete, JWT secret strength, clock
+//! sanity, and on native the listen port and free disk space (see
+//! `solobase::cli::preflight`) — and collects every result into one
+//! [`Report`], each failure with a remediation hint.
+//!
+//! Native boot runs it before binding, logs the report and keeps it for
+//! `GET /b/admin/api/diagnostics`; `solobase doctor` runs the same
+//! checks against a config without starting anything. The browser build
+//! runs the portable ones after boot. Any check can be skipped by name
+//! ([`SKIP_KEY`]), and each one is a single round trip bounded by
+//! [`CHECK_TIMEOUT`], so the whole battery stays well under two seconds.
+
+use std::{
+    collections::{HashMap, HashSet},
+    future::Future,
+    sync::{Arc, RwLock},
+    time::Duration,
+};
+
+use futures::future::Either;
+use serde::Serialize;
+use wafer_core::interfaces::{
+    database::service::DatabaseService, storage::service::StorageService,
+};
+
+use crate::{blocks::admin::VARIABLES_TABLE, pipeline::RequestTimer};
+
+/// Comma-separated check names to skip, e.g. `port,disk`.
+pub const SKIP_KEY: &str = "SOLOBASE_PREFLIGHT_SKIP";
+
+/// How long one async check may take before it counts as failed.
+pub const CHECK_TIMEOUT: Duration = Duration::from_millis(750);
+
+pub const DATABASE: &str = "database";
+pub const STORAGE: &str = "storage";
+pub const JWT_SECRET: &str = "jwt_secret";
+pub const CLOCK: &str = "clock";
+pub const PORT: &str = "port";
+pub const DISK: &str = "disk";
+
+/// Shortest JWT secret the check accepts.
+pub const MIN_JWT_SECRET_LEN: usize = 32;
+
+const DATABASE_HINT: &str = "Check SOLOBASE_DB_TYPE and SOLOBASE_DB_PATH (or SOLOBASE_DB_URL), and that the database server is reachable";
+const STORAGE_HINT: &str = "Check SOLOBASE_STORAGE_TYPE, and that SOLOBASE_STORAGE_ROOT is writable or the SOLOBASE_S3_* bucket, endpoint and credentials are right";
+
+/// Storage folder the probe object is written to (and removed from).
+/// Reserved as a bucket name (see `files::storage`).
+pub(crate) const PROBE_FOLDER: &str = "solobase-preflight";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Status {
+    Pass,
+    /// Works, but something is worth fixing.
+    Warn,
+    Fail,
+    Skipped,
+}
+
+/// One check's outcome.
+#[derive(Debug, Clone, Serialize)]
+pub struct Check {
+    pub name: &'static str,
+    pub status: Status,
+    pub detail: String,
+    /// What to do about a warning or failure.
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub hint: String,
+    pub duration_ms: u64,
+}
+
+impl Check {
+    pub fn pass(name: &'static str, detail: impl Into<String>) -> Self {
+        Self::new(name, Status::Pass, detail.into(), String::new())
+    }
+
+    pub fn warn(name: &'static str, detail: impl Into<String>, hint: impl Into<String>) -> Self {
+        Self::new(name, Status::Warn, detail.into(), hint.into())
+    }
+
+    pub fn fail(name: &'static str, detail: impl Into<String>, hint: impl Into<String>) -> Self {
+        Self::new(name, Status::Fail, detail.into(), hint.into())
+    }
+
+    pub fn skipped(name: &'static str, reason: impl Into<String>) -> Self {
+        Self::new(name, Status::Skipped, reason.into(), String::new())
+    }
+
+    fn new(name: &'static str, status: Status, detail: String, hint: String) -> Self {
+        Self {
+            name,
+            status,
+            detail,
+            hint,
+            duration_ms: 0,
+        }
+    }
+}
+
+/// Every check of one run, in the order they ran.
+#[derive(Debug, Clone, Serialize)]
+pub struct Report {
+    pub checked_at: String,
+    pub duration_ms: u64,
+    /// No check failed (warnings allowed).
+    pub ok: bool,
+    pub checks: Vec<Check>,
+}
+
+impl Report {
+    pub fn failures(&self) -> impl Iterator<Item = &Check> {
+        self.checks.iter().filter(|c| c.status == Status::Fail)
+    }
+
+    /// Log one line per check: failures at `error`, warnings at `warn`,
+    /// the rest at `info`.
+    pub fn log(&self) {
+        for check in &self.checks {
+            let (name, detail, hint) = (check.name, &check.detail, &check.hint);
+            match check.status {
+                Status::Fail => tracing::error!(check = name, %detail, %hint, "preflight failed"),
+                Status::Warn => tracing::warn!(check = name, %detail, %hint, "preflight warning"),
+                Status::Pass | Status::Skipped => {
+                    tracing::info!(check = name, status = ?check.status, %detail, "preflight")
+                }
+            }
+        }
+        tracing::info!(
+            ok = self.ok,
+            duration_ms = self.duration_ms,
+            "preflight checks done"
+        );
+    }
+}
+
+/// One preflight run: checks are added with [`Preflight::run`] (async,
+/// time-bounded) or [`Preflight::run_sync`], then [`Preflight::finish`]
+/// builds the report.
+pub struct Preflight {
+    skip: HashSet<String>,
+    timer: Option<RequestTimer>,
+    started_ms: u64,
+    checks: Vec<Check>,
+}
+
+impl Preflight {
+    /// `skip` is a [`SKIP_KEY`] value. Without a `timer` (the stateless
+    /// targets have none) async checks run unbounded.
+    pub fn new(skip: &str, timer: Option<RequestTimer>) -> Self {
+        Self {
+            skip: skip
+                .split(',')
+                .map(|s| s.trim().to_ascii_lowercase())
+                .filter(|s| !s.is_empty())
+                .collect(),
+            timer,
+            started_ms: crate::util::now_millis(),
+            checks: Vec::new(),
+        }
+    }
+
+    pub fn skips(&self, name: &str) -> bool {
+        self.skip.contains(name)
+    }
+
+    /// Run the async check `name`, unless skipped; past [`CHECK_TIMEOUT`]
+    /// it fails.
+    pub async fn run<F: Future<Output = Check>>(&mut self, name: &'static str, check: F) {
+        if self.skips(name) {
+            self.checks
+                .push(Check::skipped(name, format!("skipped by {SKIP_KEY}")));
+            return;
+        }
+        let started = crate::util::now_millis();
+        let check = match self.timer {
+            Some(sleep) => {
+                match futures::future::select(std::pin::pin!(check), sleep(CHECK_TIMEOUT)).await {
+                    Either::Left((check, _)) => check,
+                    Either::Right(((), _)) => Check::fail(
+                        name,
+                        format!("no answer within {} ms", CHECK_TIMEOUT.as_millis()),
+                        "The service is unreachable or overloaded; check its address and network",
+                    ),
+                }
+            }
+            None => check.await,
+        };
+        self.push(check, started);
+    }
+
+    /// Run the synchronous check `name`, unless skipped.
+    pub fn run_sync(&mut self, name: &'static str, check: impl FnOnce() -> Check) {
+        if self.skips(name) {
+            self.checks
+                .push(Check::skipped(name, format!("skipped by {SKIP_KEY}")));
+            return;
+        }
+        let started = crate::util::now_millis();
+        let check = check();
+        self.push(check, started);
+    }
+
+    /// Record a check this target can't run (e.g. disk space on wasm).
+    pub fn unsupported(&mut self, name: &'static str) {
+        self.checks
+            .push(Check::skipped(name, "not available on this target"));
+    }
+
+    fn push(&mut self, mut check: Check, started_ms: u64) {
+        check.duration_ms = crate::util::now_millis().saturating_sub(started_ms);
+        self.checks.push(check);
+    }
+
+    /// The portable checks: database, storage, JWT secret and clock. A
+    /// service that couldn't be constructed (`Err`) fails its check; a
+    /// `None` secret is skipped as not set yet (first start generates it).
+    pub async fn run_portable(
+        &mut self,
+        db: Result<&Arc<dyn DatabaseService>, String>,
+        storage: Result<&Arc<dyn StorageService>, String>,
+        jwt_secret: Option<&str>,
+    ) {
+        match db {
+            Ok(db) => self.run(DATABASE, check_database(db)).await,
+            Err(e) => self.run_sync(DATABASE, || {
+                Check::fail(DATABASE, format!("cannot open: {e}"), DATABASE_HINT)
+            }),
+        }
+        match storage {
+            Ok(storage) => self.run(STORAGE, check_storage(storage)).await,
+            Err(e) => self.run_sync(STORAGE, || {
+                Check::fail(STORAGE, format!("cannot open: {e}"), STORAGE_HINT)
+            }),
+        }
+        match jwt_secret {
+            Some(secret) => self.run_sync(JWT_SECRET, || check_jwt_secret(secret)),
+            None => self.run_sync(JWT_SECRET, || {
+                Check::skipped(JWT_SECRET, "not set yet; generated on first start")
+            }),
+        }
+        self.run_sync(CLOCK, || {
+            check_clock(
+                chrono::Utc::now().timestamp(),
+                crate::version::build_epoch(),
+            )
+        });
+    }
+
+    pub fn finish(self) -> Report {
+        Report {
+            checked_at: crate::util::now_rfc3339(),
+            duration_ms: crate::util::now_millis().saturating_sub(self.started_ms),
+            ok: !self.checks.iter().any(|c| c.status == Status::Fail),
+            checks: self.checks,
+        }
+    }
+}
+
+/// Connect, then write and delete a probe row in the admin variables
+/// table. A database without that table is new: connecting is all there
+/// is to check until the first start creates it.
+pub async fn check_database(db: &Arc<dyn DatabaseService>) -> Check {
+    match db.schema_table_exists(VARIABLES_TABLE).await {
+        Err(e) => Check::fail(
+            DATABASE,
+            format!("cannot query the database: {e}"),
+            DATABASE_HINT,
+        ),
+        Ok(false) => Check::pass(
+            DATABASE,
+            "connected; the database is empty and is set up on first start",
+        ),
+        Ok(true) => {
+            let id = format!("preflight_{}", uuid::Uuid::new_v4());
+            let now = crate::util::now_rfc3339();
+            let row: HashMap<String, serde_json::Value> = [
+                ("id", serde_json::json!(id)),
+                ("key", serde_json::json!(id.to_ascii_uppercase())),
+                ("value", serde_json::json!("")),
+                ("created_at", serde_json::json!(now)),
+                ("updated_at", serde_json::json!(now)),
+            ]
+            .into_iter()
+            .map(|(k, v)| (k.to_string(), v))
+            .collect();
+            if let Err(e) = db.create(VARIABLES_TABLE, row).await {
+                return Check::fail(
+                    DATABASE,
+                    format!("connected, but a test write failed: {e}"),
+                    "Make sure the database file and its directory are writable (not a read-only mount) and the disk isn't full",
+                );
+            }
+            match db.delete(VARIABLES_TABLE, &id).await {
+                Ok(()) => Check::pass(DATABASE, "connected; test write and delete succeeded"),
+                Err(e) => Check::warn(
+                    DATABASE,
+                    format!("test row `{id}` was written but not deleted: {e}"),
+                    "Delete the row from the variables table by hand",
+                ),
+            }
+        }
+    }
+}
+
+/// Write, read back and delete a small probe object.
+pub async fn check_storage(storage: &Arc<dyn StorageService>) -> Check {
+    let key = format!("probe-{}", uuid::Uuid::new_v4());
+    let hint = STORAGE_HINT;
+    if let Err(e) = storage
+        .put(PROBE_FOLDER, &key, b"solobase preflight", "text/plain")
+        .await
+    {
+        return Check::fail(STORAGE, format!("test write failed: {e}"), hint);
+    }
+    let read = storage.get(PROBE_FOLDER, &key).await;
+    let deleted = storage.delete(PROBE_FOLDER, &key).await;
+    // Local disk keeps the (now empty) folder otherwise.
+    storage.delete_folder(PROBE_FOLDER).await.ok();
+    match (read, deleted) {
+        (Err(e), _) => Check::fail(
+            STORAGE,
+            format!("wrote a test object but could not read it back: {e}"),
+            hint,
+        ),
+        (Ok(_), Err(e)) => Check::warn(
+            STORAGE,
+            format!("test object `{PROBE_FOLDER}/{key}` was written but not deleted: {e}"),
+            "Grant delete permission on the bucket, and remove the object by hand",
+        ),
+        (Ok(_), Ok(())) => Check::pass(STORAGE, "test write, read and delete succeeded"),
+    }
+}
+
+/// Length, and estimated entropy (character frequencies × length): a
+/// secret can be long and still guessable.
+pub fn check_jwt_secret(secret: &str) -> Check {
+    let hint = format!(
+        "Set {} to a random value of {MIN_JWT_SECRET_LEN}+ characters (e.g. `openssl rand -hex 32`), or unset it to have one generated",
+        crate::blocks::auth::JWT_SECRET_KEY
+    );
+    let len = secret.chars().count();
+    if len < MIN_JWT_SECRET_LEN {
+        return Check::fail(
+            JWT_SECRET,
+            format!("the secret is {len} characters; at least {MIN_JWT_SECRET_LEN} are required"),
+            hint,
+        );
+    }
+    let bits = entropy_bits(secret);
+    if bits < 64.0 {
+        Check::fail(
+            JWT_SECRET,
+            format!("the secret is {len} characters but only about {bits:.0} bits of entropy"),
+            hint,
+        )
+    } else if bits < 128.0 {
+        Check::warn(
+            JWT_SECRET,
+            format!("the secret carries about {bits:.0} bits of entropy; 128+ is recommended"),
+            hint,
+        )
+    } else {
+        Check::pass(
+            JWT_SECRET,
+            format!("{len} characters, about {bits:.0} bits of entropy"),
+        )
+    }
+}
+
+/// Shannon entropy of `s`'s characters times its length.
+fn entropy_bits(s: &str) -> f64 {
+    let mut counts: HashMap<char, usize> = HashMap::new();
+    for c in s.chars() {
+        *counts.entry(c).or_default() += 1;
+    }
+    let len = s.chars().count() as f64;
+    let per_char: f64 = counts
+        .values()
+        .map(|&n| {
+            let p = n as f64 / len;
+            -p * p.log2()
+        })
+        .sum();
+    per_char * len
+}
+
+/// The clock can't be earlier than this build, nor wildly ahead of it.
+/// Tokens, signed links and S3 signatures all depend on it.
+pub fn check_clock(now: i64, build_epoch: Option<i64>) -> Check {
+    const DAY: i64 = 24 * 60 * 60;
+    // 2024-01-01, for builds that don't know when they were made.
+    let floor = build_epoch.unwrap_or(1_704_067_200);
+    let hint =
+        "Synchronize the system clock (NTP); token expiry and request signatures depend on it";
+    let shown = chrono::DateTime::from_timestamp(now, 0)
+        .map(crate::util::format_rfc3339)
+        .unwrap_or_else(|| now.to_string());
+    if now < floor - DAY {
+        Check::fail(
+            CLOCK,
+            format!("the system clock reads {shown}, before this build was made"),
+            hint,
+        )
+    } else if now > floor + 20 * 365 * DAY {
+        Check::fail(
+            CLOCK,
+            format!("the system clock reads {shown}, decades past this build"),
+            hint,
+        )
+    } else {
+        Check::pass(CLOCK, format!("system clock reads {shown}"))
+    }
+}
+
+static STARTUP: RwLock<Option<Report>> = RwLock::new(None);
+
+/// Keep the boot-time report for the diagnostics endpoint.
+pub fn record_startup(report: Report) {
+    *STARTUP.write().unwrap_or_else(|e| e.into_inner()) = Some(report);
+}
+
+/// The report recorded at boot; `None` on targets that don't run one
+/// (Cloudflare builds a runtime per isolate and skips it).
+pub fn startup_report() -> Option<Report> {
+    STARTUP.read().unwrap_or_else(|e| e.into_inner()).clone()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn jwt_secret_needs_length_and_entropy() {
+        assert_eq!(check_jwt_secret("short").status, Status::Fail);
+        assert_eq!(check_jwt_secret(&"a".repeat(64)).status, Status::Fail);
+        assert_eq!(check_jwt_secret(&"ab".repeat(40)).status, Status::Fail);
+        let hex = "3f2a9c1d0b7e4a5f8c6d2e1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a19";
+        assert_eq!(check_jwt_secret(hex).status, Status::Pass);
+    }
+
+    #[test]
+    fn clock_is_checked_against_the_build() {
+        let built = 1_780_000_000;
+        assert_eq!(check_clock(built + 3600, Some(built)).status, Status::Pass);
+        assert_eq!(check_clock(0, Some(built)).status, Status::Fail);
+        assert_eq!(check_clock(built - 3600, Some(built)).status, Status::Pass);
+        assert_eq!(
+            check_clock(4_102_444_800 * 2, Some(built)).status,
+            Status::Fail
+        );
+        assert_eq!(check_clock(1_000_000_000, None).status, Status::Fail);
+    }
+
+    #[tokio::test]
+    async fn skipped_checks_are_reported_and_failures_fail_the_report() {
+        let mut preflight = Preflight::new("Disk, clock,database", None);
+        preflight.run_sync(DISK, || Check::fail(DISK, "full", "free some space"));
+        preflight
+            .run(PORT, async { Check::fail(PORT, "in use", "pick another") })
+            .await;
+        preflight
+            .run_portable(
+                Err("no such file".into()),
+                Err("bad root".into()),
+                Some("short"),
+            )
+            .await;
+        let report = preflight.finish();
+
+        let status = |name: &str| {
+            report
+                .checks
+                .iter()
+                .find(|c| c.name == name)
+                .unwrap()
+                .status
+        };
+        assert_eq!(status(DISK), Status::Skipped);
+        assert_eq!(status(CLOCK), Status::Skipped);
+        assert_eq!(status(DATABASE), Status::Skipped);
+        assert_eq!(status(STORAGE), Status::Fail);
+        assert_eq!(status(PORT), Status::Fail);
+        assert!(!report.ok);
+        let failed: Vec<&str> = report.failures().map(|c| c.name).collect();
+        assert_eq!(failed, [PORT, STORAGE, JWT_SECRET]);
+    }
+
+    #[tokio::test]
+    async fn database_and_storage_probes_clean_up() {
+        let db: Arc<dyn DatabaseService> = Arc::new(
+            wafer_block_sqlite::service::SQLiteDatabaseService::open_in_memory()
+                .expect("open in-memory sqlite"),
+        );
+        let check = check_database(&db).await;
+        assert_eq!(check.status, Status::Pass);
+        assert!(check.detail.contains("empty"), "{}", check.detail);
+
+        crate::migration_helper::apply_ddl_via_service(
+            &db,
+            &crate::blocks::admin::migrations::ddl_files("sqlite"),
+        )
+        .await
+        .unwrap();
+        let check = check_database(&db).await;
+        assert_eq!(check.status, Status::Pass, "{}", check.detail);
+        assert_eq!(db.count(VARIABLES_TABLE, &[]).await.unwrap(), 0);
+
+        let storage: Arc<dyn StorageService> = Arc::new(crate::test_support::MemStorage::default());
+        assert_eq!(check_storage(&storage).await.status, Status::Pass);
+    }
+
+    #[test]
+    fn startup_report_is_kept() {
+        let mut preflight = Preflight::new("", None);
+        preflight.run_sync(CLOCK, || Check::pass(CLOCK, "ok"));
+        record_startup(preflight.finish());
+        assert!(startup_report().is_some_and(|r| r.ok));
+    }
+}
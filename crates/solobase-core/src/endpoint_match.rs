@@ -138,6 +138,26 @@ impl<H: Copy> EndpointRoute<H> {
             handler,
         }
     }
+
+    /// A `GET` route.
+    pub const fn get(template: &'static str, handler: H) -> Self {
+        Self::new(HttpMethod::Get, template, handler)
+    }
+
+    /// A `POST` route.
+    pub const fn post(template: &'static str, handler: H) -> Self {
+        Self::new(HttpMethod::Post, template, handler)
+    }
+
+    /// A `PATCH` route.
+    pub const fn patch(template: &'static str, handler: H) -> Self {
+        Self::new(HttpMethod::Patch, template, handler)
+    }
+
+    /// A `DELETE` route.
+    pub const fn delete(template: &'static str, handler: H) -> Self {
+        Self::new(HttpMethod::Delete, template, handler)
+    }
 }
 
 /// Find the first route in `table` whose method+template matches the request,
@@ -393,6 +413,28 @@ mod tests {
         assert_eq!(dispatch(&mut msg, &table), Some(2u8));
     }
 
+    #[test]
+    fn method_constructors_map_to_actions() {
+        let table = [
+            EndpointRoute::get("/b/x/items/{id}", 1u8),
+            EndpointRoute::post("/b/x/items/{id}", 2u8),
+            EndpointRoute::patch("/b/x/items/{id}", 3u8),
+            EndpointRoute::delete("/b/x/items/{id}", 4u8),
+        ];
+        for (action, expected) in [
+            ("retrieve", 1u8),
+            ("create", 2),
+            ("update", 3),
+            ("delete", 4),
+        ] {
+            let mut msg = Message::new("test");
+            msg.set_meta("req.action", action);
+            msg.set_meta("req.resource", "/b/x/items/7");
+            assert_eq!(dispatch(&mut msg, &table), Some(expected), "{action}");
+            assert_eq!(msg.var("id"), "7");
+        }
+    }
+
     #[test]
     fn dispatch_ordering_specific_first() {
         // A specific template listed first must win over a generic one.
@@ -0,0 +1,170 @@
+//! Conditional GETs for JSON API responses: `ETag` / `If-None-Match`.
+//!
+//! Admin pages poll the same lists over and over; a handler that opts in
+//! answers an unchanged list with `304 Not Modified` and no body instead
+//! of the full payload. Two ways to tag a response:
+//!
+//! - [`ok_json`] hashes the serialized body. Always correct, and cheap for
+//!   the small lists it is used on, but the body is still built.
+//! - [`version`] derives a tag from the rows' newest `updated_at` plus
+//!   their count (so a delete changes it too), and [`not_modified`] checks
+//!   it before the body is built; [`ok_json_tagged`] then sends the body
+//!   under that tag. Only for lists that are exactly their rows — anything
+//!   computed at read time (an announcement's status, metrics) must hash.
+//!
+//! Every tag is weak (`W/"..."`): it names the JSON content, not the bytes
+//! on the wire, so it holds whether or not [`crate::compression`] gzips
+//! the body. `If-None-Match` is compared weakly, as RFC 9110 requires.
+//!
+//! Tagged responses carry `Cache-Control: private, no-cache`: a browser
+//! keeps them but revalidates every time, and shared caches never store
+//! them (they are per-user).
+
+use serde::Serialize;
+use wafer_run::{Message, OutputStream};
+
+use crate::http::{err_internal_no_cause, ResponseBuilder};
+
+/// `Cache-Control` on every tagged response.
+pub const CACHE_CONTROL: &str = "private, no-cache";
+
+/// Weak tag over a serialized body.
+pub fn of_body(body: &[u8]) -> String {
+    let hash = crate::util::sha256_hex(body);
+    format!("W/\"{}\"", &hash[..32])
+}
+
+/// Weak tag for a list from its rows' `updated_at` values: the newest one
+/// and how many there are.
+pub fn version<'a>(updated_at: impl IntoIterator<Item = &'a str>) -> String {
+    let (mut newest, mut count) = ("", 0usize);
+    for stamp in updated_at {
+        newest = newest.max(stamp);
+        count += 1;
+    }
+    of_body(format!("{newest}|{count}").as_bytes())
+}
+
+/// Whether an `If-None-Match` value names `tag` (or is `*`). Weak
+/// comparison: the `W/` prefix is ignored on both sides.
+pub fn matches(if_none_match: &str, tag: &str) -> bool {
+    let opaque = |t: &str| t.trim().trim_start_matches("W/").to_string();
+    let tag = opaque(tag);
+    if_none_match
+        .split(',')
+        .any(|candidate| candidate.trim() == "*" || opaque(candidate) == tag)
+}
+
+/// The `304` for `tag` when the request already holds it, else `None`.
+pub fn not_modified(msg: &Message, tag: &str) -> Option<OutputStream> {
+    let if_none_match = msg.header("if-none-match");
+    (!if_none_match.is_empty() && matches(if_none_match, tag)).then(|| {
+        ResponseBuilder::new()
+            .status(304)
+            .set_header("ETag", tag)
+            .set_header("Cache-Control", CACHE_CONTROL)
+            .body(Vec::new(), "application/json")
+    })
+}
+
+/// `value` as JSON, tagged with a hash of its body; `304` when the
+/// request's `If-None-Match` already names it.
+pub fn ok_json<T: Serialize>(msg: &Message, value: &T) -> OutputStream {
+    match serde_json::to_vec(value) {
+        Ok(body) => respond(msg, &of_body(&body), body),
+        Err(e) => err_internal_no_cause(&format!("Serialization failed: {e}")),
+    }
+}
+
+/// `value` as JSON under a precomputed `tag` (see [`version`]); `304` when
+/// the request's `If-None-Match` already names it.
+pub fn ok_json_tagged<T: Serialize>(msg: &Message, tag: &str, value: &T) -> OutputStream {
+    match serde_json::to_vec(value) {
+        Ok(body) => respond(msg, tag, body),
+        Err(e) => err_internal_no_cause(&format!("Serialization failed: {e}")),
+    }
+}
+
+fn respond(msg: &Message, tag: &str, body: Vec<u8>) -> OutputStream {
+    if let Some(unchanged) = not_modified(msg, tag) {
+        return unchanged;
+    }
+    ResponseBuilder::new()
+        .set_header("ETag", tag)
+        .set_header("Cache-Control", CACHE_CONTROL)
+        .body(body, "application/json")
+}
+
+#[cfg(test)]
+mod tests {
+    use wafer_run::{MetaGet, META_RESP_STATUS};
+
+    use super::*;
+    use crate::test_support::anon_msg;
+
+    fn get(if_none_match: Option<&str>) -> Message {
+        let mut msg = anon_msg("retrieve", "/admin/feature-flags");
+        if let Some(tag) = if_none_match {
+            msg.set_meta("http.header.if-none-match", tag);
+        }
+        msg
+    }
+
+    #[test]
+    fn tags_are_weak_and_compared_weakly() {
+        let tag = of_body(b"{}");
+        assert!(tag.starts_with("W/\"") && tag.ends_with('"'));
+        let strong = tag.trim_start_matches("W/");
+        assert!(matches(&tag, &tag));
+        assert!(matches(strong, &tag));
+        assert!(matches(&format!("W/\"other\", {tag}"), &tag));
+        assert!(matches("*", &tag));
+        assert!(!matches("W/\"other\"", &tag));
+        assert_ne!(of_body(b"{}"), of_body(b"[]"));
+    }
+
+    #[test]
+    fn version_changes_with_the_newest_stamp_and_the_count() {
+        let base = version(["2026-01-01T00:00:00Z", "2026-01-02T00:00:00Z"]);
+        assert_eq!(
+            base,
+            version(["2026-01-02T00:00:00Z", "2026-01-01T00:00:00Z"])
+        );
+        assert_ne!(
+            base,
+            version(["2026-01-01T00:00:00Z", "2026-01-03T00:00:00Z"])
+        );
+        assert_ne!(base, version(["2026-01-02T00:00:00Z"]));
+    }
+
+    #[tokio::test]
+    async fn matching_request_gets_304_without_a_body() {
+        let value = serde_json::json!({"flags": []});
+        let first = ok_json(&get(None), &value)
+            .collect_buffered()
+            .await
+            .unwrap();
+        assert_ne!(MetaGet::get(&first.meta, META_RESP_STATUS), Some("304"));
+        let tag = MetaGet::get(&first.meta, "resp.header.ETag")
+            .unwrap()
+            .to_string();
+        assert_eq!(
+            MetaGet::get(&first.meta, "resp.header.Cache-Control"),
+            Some(CACHE_CONTROL)
+        );
+
+        let again = ok_json(&get(Some(&tag)), &value)
+            .collect_buffered()
+            .await
+            .unwrap();
+        assert_eq!(MetaGet::get(&again.meta, META_RESP_STATUS), Some("304"));
+        assert!(again.body.is_empty());
+
+        let changed = ok_json(&get(Some(&tag)), &serde_json::json!({"flags": [1]}))
+            .collect_buffered()
+            .await
+            .unwrap();
+        assert_ne!(MetaGet::get(&changed.meta, META_RESP_STATUS), Some("304"));
+        assert!(!changed.body.is_empty());
+    }
+}
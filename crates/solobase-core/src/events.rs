@@ -0,0 +1,661 @@
+//! The event catalog: every payload Solobase emits to integrators, named,
+//! versioned and described by a JSON Schema.
+//!
+//! Today the emitted payloads are notification `data` (see
+//! [`crate::blocks::notifications`]): the inbox API and the email job hand
+//! them out as they were posted. Each built-in type is a struct here
+//! implementing [`Event`], and notifiers post them through
+//! `Services::notifications().notify_event`, which takes the struct — so a
+//! payload can't be built by hand. [`validate`] backs that up at runtime:
+//! a notification whose `type` is in the catalog is refused unless its
+//! `data` carries every field the type's schema requires.
+//!
+//! `GET /api/events/schema` serves the catalog (name, version,
+//! description, JSON Schema, example), like `/openapi.json`, without
+//! authentication. Extensions add their own types with
+//! `Services::events().register`, named under their block
+//! (`crm.deal_won` for `acme/crm`), so their consumers find them in the
+//! same place.
+//!
+//! # Compatibility
+//!
+//! Within a version, fields may be added but never removed, renamed or
+//! retyped; doing any of those needs a new version. The `PUBLISHED` list
+//! in this module's tests pins every released version's fields and fails
+//! the build when a change breaks one.
+
+use std::{collections::BTreeMap, sync::RwLock};
+
+use serde::{Deserialize, Serialize};
+
+/// Where the catalog is served.
+pub const SCHEMA_PATH: &str = "/api/events/schema";
+
+/// The JSON type of one payload field.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum FieldType {
+    String,
+    Integer,
+    Number,
+    Boolean,
+    Object,
+    Array,
+}
+
+impl FieldType {
+    fn matches(self, value: &serde_json::Value) -> bool {
+        match self {
+            FieldType::String => value.is_string(),
+            FieldType::Integer => value.is_i64() || value.is_u64(),
+            FieldType::Number => value.is_number(),
+            FieldType::Boolean => value.is_boolean(),
+            FieldType::Object => value.is_object(),
+            FieldType::Array => value.is_array(),
+        }
+    }
+}
+
+/// One field of an event payload.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Field {
+    pub name: String,
+    #[serde(rename = "type")]
+    pub kind: FieldType,
+    pub required: bool,
+    pub description: String,
+}
+
+impl Field {
+    pub fn required(name: &str, kind: FieldType, description: &str) -> Self {
+        Self {
+            name: name.to_string(),
+            kind,
+            required: true,
+            description: description.to_string(),
+        }
+    }
+
+    pub fn optional(name: &str, kind: FieldType, description: &str) -> Self {
+        Self {
+            required: false,
+            ..Self::required(name, kind, description)
+        }
+    }
+}
+
+/// A typed event payload. Its serialized form must carry exactly
+/// [`Event::fields`] (optional ones may be absent).
+pub trait Event: Serialize {
+    /// `{block}.{event}`, e.g. `files.share_received`.
+    const NAME: &'static str;
+    const VERSION: u32;
+    const DESCRIPTION: &'static str;
+
+    fn fields() -> Vec<Field>;
+
+    /// A representative payload, served with the schema.
+    fn example() -> Self;
+}
+
+/// A catalog entry.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct EventType {
+    pub name: String,
+    pub version: u32,
+    pub description: String,
+    /// The block that declared it; `""` for built-ins.
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub block: String,
+    #[serde(skip)]
+    pub fields: Vec<Field>,
+    pub example: serde_json::Value,
+}
+
+impl EventType {
+    /// An entry for a type declared at runtime, e.g. by an extension.
+    pub fn new(name: &str, version: u32, description: &str) -> Self {
+        Self {
+            name: name.to_string(),
+            version,
+            description: description.to_string(),
+            block: String::new(),
+            fields: Vec::new(),
+            example: serde_json::json!({}),
+        }
+    }
+
+    pub fn field(mut self, field: Field) -> Self {
+        self.fields.push(field);
+        self
+    }
+
+    pub fn example(mut self, example: serde_json::Value) -> Self {
+        self.example = example;
+        self
+    }
+
+    /// The entry for a typed [`Event`].
+    pub fn of<E: Event>() -> Self {
+        let example = serde_json::to_value(E::example()).unwrap_or_default();
+        E::fields()
+            .into_iter()
+            .fold(Self::new(E::NAME, E::VERSION, E::DESCRIPTION), Self::field)
+            .example(example)
+    }
+
+    /// The payload's JSON Schema (draft 2020-12). Unknown fields are
+    /// allowed, so consumers keep working as fields are added.
+    pub fn schema(&self) -> serde_json::Value {
+        let properties: serde_json::Map<String, serde_json::Value> = self
+            .fields
+            .iter()
+            .map(|f| {
+                let schema = serde_json::json!({ "type": f.kind, "description": f.description });
+                (f.name.clone(), schema)
+            })
+            .collect();
+        let required: Vec<&str> = self
+            .fields
+            .iter()
+            .filter(|f| f.required)
+            .map(|f| f.name.as_str())
+            .collect();
+        serde_json::json!({
+            "$schema": "https://json-schema.org/draft/2020-12/schema",
+            "$id": format!("solobase:event:{}:v{}", self.name, self.version),
+            "title": self.name,
+            "description": self.description,
+            "type": "object",
+            "properties": properties,
+            "required": required,
+            "additionalProperties": true,
+        })
+    }
+
+    /// Why `data` isn't a payload of this type, if it isn't.
+    pub fn check(&self, data: &serde_json::Value) -> Result<(), String> {
+        let Some(object) = data.as_object() else {
+            return Err("payload must be a JSON object".to_string());
+        };
+        for field in &self.fields {
+            match object.get(&field.name) {
+                None | Some(serde_json::Value::Null) if field.required => {
+                    return Err(format!("missing field `{}`", field.name));
+                }
+                None | Some(serde_json::Value::Null) => {}
+                Some(value) if !field.kind.matches(value) => {
+                    return Err(format!(
+                        "field `{}` must be of type {}",
+                        field.name,
+                        serde_json::json!(field.kind)
+                    ));
+                }
+                Some(_) => {}
+            }
+        }
+        Ok(())
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Built-in events
+// ---------------------------------------------------------------------------
+
+/// A file or folder was shared with the notified user.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ShareReceived {
+    pub grant_id: String,
+    pub bucket: String,
+    /// The shared key, or folder prefix ending in `/`; `""` for the whole
+    /// bucket.
+    pub path: String,
+}
+
+impl Event for ShareReceived {
+    const NAME: &'static str = "files.share_received";
+    const VERSION: u32 = 1;
+    const DESCRIPTION: &'static str = "A file or folder was shared with the user.";
+
+    fn fields() -> Vec<Field> {
+        vec![
+            Field::required(
+                "grant_id",
+                FieldType::String,
+                "The grant to accept or decline",
+            ),
+            Field::required(
+                "bucket",
+                FieldType::String,
+                "Bucket holding the shared item",
+            ),
+            Field::required(
+                "path",
+                FieldType::String,
+                "Shared key or folder prefix; empty for the whole bucket",
+            ),
+        ]
+    }
+
+    fn example() -> Self {
+        Self {
+            grant_id: "3f0c2a4e-6b1d-4c8e-9f57-0a1b2c3d4e5f".into(),
+            bucket: "team".into(),
+            path: "reports/".into(),
+        }
+    }
+}
+
+/// Someone declined an item the notified user shared with them.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ShareDeclined {
+    pub bucket: String,
+    pub path: String,
+}
+
+impl Event for ShareDeclined {
+    const NAME: &'static str = "files.share_declined";
+    const VERSION: u32 = 1;
+    const DESCRIPTION: &'static str = "A share the user made was declined by its recipient.";
+
+    fn fields() -> Vec<Field> {
+        vec![
+            Field::required(
+                "bucket",
+                FieldType::String,
+                "Bucket holding the shared item",
+            ),
+            Field::required(
+                "path",
+                FieldType::String,
+                "Shared key or folder prefix; empty for the whole bucket",
+            ),
+        ]
+    }
+
+    fn example() -> Self {
+        Self {
+            bucket: "team".into(),
+            path: "reports/q3.pdf".into(),
+        }
+    }
+}
+
+/// The notified user's storage use crossed a quota alert threshold.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct QuotaThresholdCrossed {
+    /// Percent of the quota, e.g. `80`.
+    pub threshold: i64,
+    pub used_bytes: i64,
+    pub limit_bytes: i64,
+    /// Where the user can free space.
+    pub url: String,
+}
+
+impl Event for QuotaThresholdCrossed {
+    const NAME: &'static str = "files.quota_threshold";
+    const VERSION: u32 = 1;
+    const DESCRIPTION: &'static str = "The user's storage use crossed a quota alert threshold.";
+
+    fn fields() -> Vec<Field> {
+        vec![
+            Field::required(
+                "threshold",
+                FieldType::Integer,
+                "Percent of the quota crossed",
+            ),
+            Field::required("used_bytes", FieldType::Integer, "Bytes in use"),
+            Field::required("limit_bytes", FieldType::Integer, "The quota, in bytes"),
+            Field::required(
+                "url",
+                FieldType::String,
+                "Page where the user can free space",
+            ),
+        ]
+    }
+
+    fn example() -> Self {
+        Self {
+            threshold: 80,
+            used_bytes: 858_993_459,
+            limit_bytes: 1_073_741_824,
+            url: "/b/cloudstorage/".into(),
+        }
+    }
+}
+
+/// The consistency check found one of the notified user's files missing
+/// from storage; it is hidden until re-uploaded or deleted.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ObjectMissing {
+    pub bucket: String,
+    pub key: String,
+    pub object_id: String,
+}
+
+impl Event for ObjectMissing {
+    const NAME: &'static str = "files.object_missing";
+    const VERSION: u32 = 1;
+    const DESCRIPTION: &'static str =
+        "One of the user's files lost its stored content and was hidden.";
+
+    fn fields() -> Vec<Field> {
+        vec![
+            Field::required("bucket", FieldType::String, "Bucket holding the file"),
+            Field::required("key", FieldType::String, "The file's key"),
+            Field::required("object_id", FieldType::String, "The file's object id"),
+        ]
+    }
+
+    fn example() -> Self {
+        Self {
+            bucket: "team".into(),
+            key: "reports/q3.pdf".into(),
+            object_id: "8d2e4f60-1a3b-4c5d-9e7f-0a1b2c3d4e5f".into(),
+        }
+    }
+}
+
+fn builtin() -> Vec<EventType> {
+    vec![
+        EventType::of::<ShareReceived>(),
+        EventType::of::<ShareDeclined>(),
+        EventType::of::<QuotaThresholdCrossed>(),
+        EventType::of::<ObjectMissing>(),
+    ]
+}
+
+// ---------------------------------------------------------------------------
+// Registry
+// ---------------------------------------------------------------------------
+
+/// Types registered at runtime, by name.
+static REGISTERED: RwLock<BTreeMap<String, EventType>> = RwLock::new(BTreeMap::new());
+
+/// Why [`register`] refused a type.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum RegisterError {
+    /// Not `{prefix}.{event}` in lowercase letters, digits and `_`.
+    InvalidName(String),
+    /// The name isn't under the registering block's prefix.
+    OutsideNamespace { name: String, prefix: String },
+    /// A built-in or another block's type already has the name.
+    Taken(String),
+}
+
+impl std::fmt::Display for RegisterError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Self::InvalidName(name) => write!(f, "invalid event name `{name}`"),
+            Self::OutsideNamespace { name, prefix } => {
+                write!(f, "event `{name}` must be named `{prefix}.…`")
+            }
+            Self::Taken(name) => write!(f, "event `{name}` is already registered"),
+        }
+    }
+}
+
+impl std::error::Error for RegisterError {}
+
+fn is_valid_name(name: &str) -> bool {
+    let segments: Vec<&str> = name.split('.').collect();
+    segments.len() >= 2
+        && segments.iter().all(|s| {
+            !s.is_empty()
+                && s.bytes()
+                    .all(|b| b.is_ascii_lowercase() || b.is_ascii_digit() || b == b'_')
+        })
+}
+
+/// The prefix `block`'s event names start with: its name after the
+/// namespace, with `-` as `_` (`acme/deal-room` → `deal_room`).
+pub fn block_prefix(block: &str) -> String {
+    block.rsplit('/').next().unwrap_or(block).replace('-', "_")
+}
+
+/// Add `block`'s event type to the catalog. Registering the same name
+/// again from the same block replaces it (a block re-running `Init`).
+pub fn register(block: &str, mut event: EventType) -> Result<(), RegisterError> {
+    if !is_valid_name(&event.name) {
+        return Err(RegisterError::InvalidName(event.name));
+    }
+    let prefix = block_prefix(block);
+    if event.name.split('.').next() != Some(prefix.as_str()) {
+        return Err(RegisterError::OutsideNamespace {
+            name: event.name,
+            prefix,
+        });
+    }
+    if builtin().iter().any(|b| b.name == event.name) {
+        return Err(RegisterError::Taken(event.name));
+    }
+    let mut registered = REGISTERED.write().unwrap_or_else(|e| e.into_inner());
+    if registered
+        .get(&event.name)
+        .is_some_and(|e| e.block != block)
+    {
+        return Err(RegisterError::Taken(event.name));
+    }
+    event.block = block.to_string();
+    registered.insert(event.name.clone(), event);
+    Ok(())
+}
+
+/// Every event type, built-ins first, then registered ones by name.
+pub fn catalog() -> Vec<EventType> {
+    let mut all = builtin();
+    all.extend(
+        REGISTERED
+            .read()
+            .unwrap_or_else(|e| e.into_inner())
+            .values()
+            .cloned(),
+    );
+    all
+}
+
+/// The catalog entry named `name`.
+pub fn get(name: &str) -> Option<EventType> {
+    if let Some(event) = builtin().into_iter().find(|e| e.name == name) {
+        return Some(event);
+    }
+    REGISTERED
+        .read()
+        .unwrap_or_else(|e| e.into_inner())
+        .get(name)
+        .cloned()
+}
+
+/// Check `data` against the catalog entry for `name`. Names outside the
+/// catalog pass.
+pub fn validate(name: &str, data: &serde_json::Value) -> Result<(), String> {
+    match get(name) {
+        Some(event) => event
+            .check(data)
+            .map_err(|e| format!("{} v{}: {e}", event.name, event.version)),
+        None => Ok(()),
+    }
+}
+
+/// The body of `GET /api/events/schema`.
+pub fn catalog_json() -> serde_json::Value {
+    let events: Vec<serde_json::Value> = catalog()
+        .iter()
+        .map(|event| {
+            let mut entry = serde_json::json!(event);
+            entry["schema"] = event.schema();
+            entry
+        })
+        .collect();
+    serde_json::json!({ "events": events })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Every released event version and its fields. A field listed here
+    /// must stay, with its type, for as long as the version does: removing
+    /// or renaming one means bumping the event's version and adding the new
+    /// version here. New fields only need adding.
+    const PUBLISHED: &[(&str, u32, &[(&str, FieldType)])] = &[
+        (
+            "files.share_received",
+            1,
+            &[
+                ("grant_id", FieldType::String),
+                ("bucket", FieldType::String),
+                ("path", FieldType::String),
+            ],
+        ),
+        (
+            "files.share_declined",
+            1,
+            &[("bucket", FieldType::String), ("path", FieldType::String)],
+        ),
+        (
+            "files.quota_threshold",
+            1,
+            &[
+                ("threshold", FieldType::Integer),
+                ("used_bytes", FieldType::Integer),
+                ("limit_bytes", FieldType::Integer),
+                ("url", FieldType::String),
+            ],
+        ),
+        (
+            "files.object_missing",
+            1,
+            &[
+                ("bucket", FieldType::String),
+                ("key", FieldType::String),
+                ("object_id", FieldType::String),
+            ],
+        ),
+    ];
+
+    #[test]
+    fn published_versions_keep_their_fields() {
+        for event in builtin() {
+            let published: Vec<_> = PUBLISHED
+                .iter()
+                .filter(|(name, _, _)| *name == event.name)
+                .collect();
+            assert!(
+                !published.is_empty(),
+                "{} is not in PUBLISHED; add it with its fields",
+                event.name
+            );
+            let latest = published.iter().map(|(_, v, _)| *v).max().unwrap();
+            assert!(
+                event.version >= latest,
+                "{} went back from v{latest} to v{}",
+                event.name,
+                event.version
+            );
+            let Some((_, _, fields)) = published.iter().find(|(_, v, _)| *v == event.version)
+            else {
+                panic!("{} v{} is not in PUBLISHED", event.name, event.version);
+            };
+            for (name, kind) in fields.iter() {
+                let field = event.fields.iter().find(|f| f.name == *name);
+                assert!(
+                    field.is_some_and(|f| f.kind == *kind),
+                    "{} v{} removed, renamed or retyped `{name}`; bump its version",
+                    event.name,
+                    event.version
+                );
+            }
+        }
+    }
+
+    #[test]
+    fn examples_serialize_to_exactly_the_declared_fields() {
+        for event in builtin() {
+            event
+                .check(&event.example)
+                .unwrap_or_else(|e| panic!("{}: {e}", event.name));
+            let mut keys: Vec<&str> = event
+                .example
+                .as_object()
+                .unwrap()
+                .keys()
+                .map(String::as_str)
+                .collect();
+            let mut declared: Vec<&str> = event.fields.iter().map(|f| f.name.as_str()).collect();
+            keys.sort_unstable();
+            declared.sort_unstable();
+            assert_eq!(keys, declared, "{} struct and fields differ", event.name);
+        }
+    }
+
+    #[test]
+    fn schemas_list_required_fields_and_types() {
+        let schema = EventType::of::<QuotaThresholdCrossed>().schema();
+        assert_eq!(schema["$id"], "solobase:event:files.quota_threshold:v1");
+        assert_eq!(schema["properties"]["threshold"]["type"], "integer");
+        assert_eq!(
+            schema["required"],
+            serde_json::json!(["threshold", "used_bytes", "limit_bytes", "url"])
+        );
+    }
+
+    #[test]
+    fn payloads_are_checked_against_their_type() {
+        let data = serde_json::json!({ "bucket": "team", "path": "" });
+        assert_eq!(validate(ShareDeclined::NAME, &data), Ok(()));
+        let err = validate(
+            ShareDeclined::NAME,
+            &serde_json::json!({ "bucket": "team" }),
+        );
+        assert_eq!(
+            err.unwrap_err(),
+            "files.share_declined v1: missing field `path`"
+        );
+        let err = validate(
+            QuotaThresholdCrossed::NAME,
+            &serde_json::json!({"threshold": "80", "used_bytes": 1, "limit_bytes": 2, "url": ""}),
+        );
+        assert!(err.unwrap_err().contains("`threshold` must be of type"));
+        assert_eq!(validate("unknown.kind", &serde_json::json!(null)), Ok(()));
+    }
+
+    #[test]
+    fn extensions_register_under_their_own_prefix() {
+        let deal = || {
+            EventType::new("deal_room.deal_won", 1, "A deal was won.")
+                .field(Field::required("deal_id", FieldType::String, "The deal"))
+                .example(serde_json::json!({ "deal_id": "d1" }))
+        };
+        assert_eq!(register("acme/deal-room", deal()), Ok(()));
+        // Re-registering from the same block replaces the entry.
+        assert_eq!(register("acme/deal-room", deal()), Ok(()));
+        assert_eq!(
+            register("other/deal_room", deal()),
+            Err(RegisterError::Taken("deal_room.deal_won".into()))
+        );
+        assert!(matches!(
+            register("acme/crm", deal()),
+            Err(RegisterError::OutsideNamespace { .. })
+        ));
+        assert!(matches!(
+            register("acme/files", EventType::new("files.share_received", 2, "")),
+            Err(RegisterError::Taken(_))
+        ));
+        assert!(matches!(
+            register("acme/crm", EventType::new("crm.Deal", 1, "")),
+            Err(RegisterError::InvalidName(_))
+        ));
+
+        let body = catalog_json();
+        let entry = body["events"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|e| e["name"] == "deal_room.deal_won")
+            .unwrap();
+        assert_eq!(entry["block"], "acme/deal-room");
+        assert_eq!(entry["schema"]["required"], serde_json::json!(["deal_id"]));
+        assert!(validate("deal_room.deal_won", &serde_json::json!({})).is_err());
+    }
+}
@@ -3,7 +3,10 @@
 //! Uses a generic HashMap approach backed by the `block_settings` table.
 //! Each block's enabled/disabled state is keyed by full block name.
 
-use std::collections::HashMap;
+use std::{
+    collections::{BTreeMap, BTreeSet, HashMap},
+    sync::{Mutex, MutexGuard},
+};
 
 /// Synthetic config key carrying the block_settings JSON map.
 ///
@@ -176,11 +179,16 @@ impl FeatureConfig for BlockSettings {
 /// write lock, which would leave the snapshot in an indeterminate state —
 /// surfacing that immediately is preferable to handing the router a stale
 /// "all-enabled" fallback.
+///
+/// A toggle made in this process since boot ([`set_live`]) wins over the
+/// snapshot, so the admin toggle takes effect without a restart.
 impl FeatureConfig for std::sync::RwLock<BlockSettings> {
     fn is_block_enabled(&self, full_name: &str) -> bool {
-        self.read()
-            .expect("BlockSettings RwLock poisoned")
-            .is_block_enabled(full_name)
+        live(full_name).unwrap_or_else(|| {
+            self.read()
+                .expect("BlockSettings RwLock poisoned")
+                .is_block_enabled(full_name)
+        })
     }
 }
 
@@ -452,7 +460,7 @@ async fn apply_seed_decision(
 ) {
     let enabled_val = serde_json::Value::Number(serde_json::Number::from(i64::from(d.enabled)));
     let hash_val = serde_json::Value::String(d.hash.clone());
-    let now = chrono::Utc::now().to_rfc3339();
+    let now = crate::util::now_rfc3339();
     match d.op {
         SeedOp::Insert => {
             let id = format!("bs_{}", uuid::Uuid::new_v4());
@@ -501,6 +509,153 @@ impl FeatureConfig for AllEnabled {
     }
 }
 
+// ---------------------------------------------------------------------------
+// Runtime enablement
+// ---------------------------------------------------------------------------
+//
+// The persisted `enabled` flag is read once at boot into the `BlockSettings`
+// snapshot (and the `BLOCK_SETTINGS_CONFIG_KEY` config copy). Two pieces of
+// process memory, like `schema_status`, carry what happened since:
+//
+// - `LIVE`: toggles made through the admin block since boot. The router's
+//   gate and the job runner consult it before the snapshot.
+// - `DEFERRED`: blocks that were disabled at boot and therefore skipped
+//   their `Init` (migrations, seeds). Enabling one of those can't be done
+//   live — its schema was never applied — so it needs a restart.
+//
+// A restart (or a fresh Worker isolate) drops both and reloads the
+// persisted flags.
+
+static LIVE: Mutex<BTreeMap<String, bool>> = Mutex::new(BTreeMap::new());
+static DEFERRED: Mutex<BTreeSet<String>> = Mutex::new(BTreeSet::new());
+
+fn lock<T>(m: &'static Mutex<T>) -> MutexGuard<'static, T> {
+    // Each mutation is a single insert, so a panic mid-write can't leave
+    // the map half-updated; recover rather than poison every route check.
+    m.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Record a runtime toggle of `block` (the persisted flag is written
+/// separately).
+pub fn set_live(block: &str, enabled: bool) {
+    lock(&LIVE).insert(block.to_string(), enabled);
+}
+
+/// `block`'s enablement as toggled since boot; `None` when it wasn't.
+pub fn live(block: &str) -> Option<bool> {
+    lock(&LIVE).get(block).copied()
+}
+
+/// Whether `block` is enabled right now: the runtime toggle, else the boot
+/// snapshot in `ctx`'s config (enabled when it has no row).
+pub fn is_enabled_now(ctx: &dyn wafer_run::context::Context, block: &str) -> bool {
+    live(block).unwrap_or_else(|| {
+        let json = ctx.config_get(BLOCK_SETTINGS_CONFIG_KEY).unwrap_or("{}");
+        BlockSettings::state_for(json, block).enabled
+    })
+}
+
+/// Every block disabled right now (see [`is_enabled_now`]), sorted.
+pub fn disabled_now(ctx: &dyn wafer_run::context::Context) -> Vec<String> {
+    let json = ctx.config_get(BLOCK_SETTINGS_CONFIG_KEY).unwrap_or("{}");
+    let mut disabled: BTreeSet<String> = BlockSettings::from_config_json(json)
+        .blocks
+        .into_iter()
+        .filter(|(_, state)| !state.enabled)
+        .map(|(name, _)| name)
+        .collect();
+    for (name, enabled) in lock(&LIVE).iter() {
+        if *enabled {
+            disabled.remove(name);
+        } else {
+            disabled.insert(name.clone());
+        }
+    }
+    disabled.into_iter().collect()
+}
+
+/// Whether `block` should skip this lifecycle `event`: an `Init` of a block
+/// the boot snapshot disables, provided it may be disabled at all
+/// (`can_disable` is only evaluated then). A skipped block is remembered
+/// (see [`init_deferred`]). Called from every feature block's `lifecycle`.
+pub fn defer_init(
+    ctx: &dyn wafer_run::context::Context,
+    block: &str,
+    event: &wafer_run::LifecycleEvent,
+    can_disable: impl FnOnce() -> bool,
+) -> bool {
+    if !matches!(event.event_type, wafer_run::LifecycleType::Init)
+        || is_enabled_now(ctx, block)
+        || !can_disable()
+    {
+        return false;
+    }
+    tracing::info!(block = %block, "block disabled; skipping its Init until it is enabled and the server restarted");
+    lock(&DEFERRED).insert(block.to_string());
+    true
+}
+
+/// Whether `block` skipped its `Init` at boot because it was disabled.
+pub fn init_deferred(block: &str) -> bool {
+    lock(&DEFERRED).contains(block)
+}
+
+/// Persist `block`'s `enabled` flag directly through a [`DatabaseService`],
+/// for callers without a runtime (the `solobase blocks` CLI). Marks the row
+/// [`USER_EDITED_SENTINEL`] like the admin toggle, so the boot-time seed
+/// leaves it alone. Takes effect at the next start.
+///
+/// [`DatabaseService`]: wafer_core::interfaces::database::service::DatabaseService
+pub async fn store_enabled(
+    db: &std::sync::Arc<dyn wafer_core::interfaces::database::service::DatabaseService>,
+    block: &str,
+    enabled: bool,
+) -> Result<(), String> {
+    use wafer_block::db::{Filter, FilterOp, ListOptions};
+
+    let opts = ListOptions {
+        filters: vec![Filter {
+            field: "block_name".into(),
+            operator: FilterOp::Equal,
+            value: serde_json::json!(block),
+        }],
+        limit: 1,
+        skip_count: true,
+        ..Default::default()
+    };
+    let existing = db
+        .list(crate::admin_schema::BLOCK_SETTINGS_TABLE, &opts)
+        .await
+        .map_err(|e| format!("read block_settings: {e}"))?;
+    let now = crate::util::now_rfc3339();
+    let mut data: HashMap<String, serde_json::Value> = HashMap::new();
+    data.insert("enabled".into(), serde_json::json!(i64::from(enabled)));
+    data.insert(
+        "seed_defaults_hash".into(),
+        serde_json::json!(USER_EDITED_SENTINEL),
+    );
+    data.insert("updated_at".into(), serde_json::json!(now));
+    let result = match existing.records.first() {
+        Some(row) => {
+            db.update(crate::admin_schema::BLOCK_SETTINGS_TABLE, &row.id, data)
+                .await
+        }
+        None => {
+            data.insert(
+                "id".into(),
+                serde_json::json!(format!("bs_{}", uuid::Uuid::new_v4())),
+            );
+            data.insert("block_name".into(), serde_json::json!(block));
+            data.insert("created_at".into(), serde_json::json!(now));
+            db.create(crate::admin_schema::BLOCK_SETTINGS_TABLE, data)
+                .await
+        }
+    };
+    result
+        .map(|_| ())
+        .map_err(|e| format!("write block_settings: {e}"))
+}
+
 #[cfg(test)]
 mod seed_plan_tests {
     use std::collections::HashMap;
@@ -727,6 +882,42 @@ mod seed_plan_tests {
 /// hash-gate. An `ENABLED_DEFAULTS` change therefore propagated on Cloudflare
 /// and browser boots but silently NOT on native boots. These tests pin that
 /// the unified loader runs the gate, so a native boot now re-seeds stale rows.
+#[cfg(test)]
+mod runtime_tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    // `LIVE` is process-wide, so every test toggles its own block names.
+
+    #[tokio::test]
+    async fn live_toggles_override_the_boot_snapshot() {
+        let mut ctx = TestContext::new().await;
+        ctx.set_config(
+            BLOCK_SETTINGS_CONFIG_KEY,
+            r#"{"test/rt-off":{"enabled":false},"test/rt-on":{"enabled":true}}"#,
+        );
+        assert!(!is_enabled_now(&ctx, "test/rt-off"));
+        assert!(is_enabled_now(&ctx, "test/rt-on"));
+        assert!(is_enabled_now(&ctx, "test/rt-unlisted"));
+
+        set_live("test/rt-off", true);
+        set_live("test/rt-on", false);
+        assert!(is_enabled_now(&ctx, "test/rt-off"));
+        assert!(!is_enabled_now(&ctx, "test/rt-on"));
+
+        let disabled = disabled_now(&ctx);
+        assert!(disabled.contains(&"test/rt-on".to_string()));
+        assert!(!disabled.contains(&"test/rt-off".to_string()));
+
+        let gate = std::sync::RwLock::new(BlockSettings::from_config_json(
+            r#"{"test/rt-gate":{"enabled":false}}"#,
+        ));
+        assert!(!gate.is_block_enabled("test/rt-gate"));
+        set_live("test/rt-gate", true);
+        assert!(gate.is_block_enabled("test/rt-gate"));
+    }
+}
+
 #[cfg(test)]
 mod load_and_seed_tests {
     use std::sync::Arc;
@@ -915,4 +1106,30 @@ mod load_and_seed_tests {
             "steady-state pass must not insert rows",
         );
     }
+
+    /// The CLI's write lands as a user edit, so the next boot's seed pass
+    /// keeps it.
+    #[tokio::test]
+    async fn store_enabled_survives_the_seed_pass() {
+        let db = db_with_block_settings_table().await;
+        let (block_name, default) = ENABLED_DEFAULTS[0];
+        load_and_seed_block_settings(&db).await;
+
+        store_enabled(&db, block_name, !default).await.unwrap();
+        let settings = load_and_seed_block_settings(&db).await;
+        assert_eq!(settings.is_block_enabled(block_name), !default);
+        assert_eq!(
+            read_row(&db, block_name).await,
+            Some((!default, USER_EDITED_SENTINEL.to_string()))
+        );
+
+        // A block with no row yet gets one.
+        store_enabled(&db, "test/no-row", false).await.unwrap();
+        assert_eq!(
+            read_row(&db, "test/no-row")
+                .await
+                .map(|(enabled, _)| enabled),
+            Some(false)
+        );
+    }
 }
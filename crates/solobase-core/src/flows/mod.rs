@@ -9,7 +9,9 @@ pub mod site_main;
 
 use wafer_run::{RuntimeError, Wafer};
 
-/// Register the site-main flow (used with suppers-ai/router).
+/// Register the site-main flow (used with suppers-ai/router). `base_path`
+/// is the normalized prefix Solobase is mounted under, empty at the root
+/// (see `site_main::routes`).
 ///
 /// # Errors
 ///
@@ -17,11 +19,11 @@ use wafer_run::{RuntimeError, Wafer};
 /// generated route config or the embedded `site_main::JSON` (a build-time
 /// invariant — failure here means the bundled flow JSON drifted from the
 /// runtime's flow schema).
-pub fn register_site_main(w: &mut Wafer) -> Result<(), RuntimeError> {
+pub fn register_site_main(w: &mut Wafer, base_path: &str) -> Result<(), RuntimeError> {
     // Inject default routes into the router block config
     w.add_block_config(
         "wafer-run/router",
-        serde_json::json!({ "routes": site_main::default_routes() }),
+        serde_json::json!({ "routes": site_main::routes(base_path) }),
     );
 
     // Configure the web block to serve from the "site" storage bucket as an SPA
@@ -32,13 +32,86 @@ pub const JSON: &str = r#"{
 /// `/` is routed explicitly to `suppers-ai/router` (not the `/**` fallback)
 /// so the root redirect handler in `routing::route_to_block` fires:
 /// anonymous → `/b/auth/login`, authenticated → `/b/userportal/`.
+///
+/// `/api/**` covers the versioned API and its unversioned alias (see
+/// `crate::api_version`); the pipeline strips either prefix.
 pub fn default_routes() -> serde_json::Value {
-    serde_json::json!([
-        { "path": "/",                        "block": "suppers-ai/router" },
-        { "path": "/b/**",                    "block": "suppers-ai/router" },
-        { "path": "/health",                  "block": "suppers-ai/router" },
-        { "path": "/openapi.json",            "block": "suppers-ai/router" },
-        { "path": "/.well-known/agent.json",  "block": "suppers-ai/router" },
-        { "path": "/**",            "block": "wafer-run/web", "config": { "web_root": "site", "web_spa": "true", "web_index": "index.html" } }
-    ])
+    routes("")
+}
+
+/// [`default_routes`] for a deployment mounted under `base_path` (already
+/// normalized, see `crate::base_path`). The router paths are added again
+/// behind the prefix; the unprefixed ones stay for hosts that strip the
+/// prefix before forwarding. The user site keeps the `/**` fallback.
+pub fn routes(base_path: &str) -> serde_json::Value {
+    const ROUTER_PATHS: &[&str] = &[
+        "/",
+        "/b/**",
+        "/health",
+        "/api/**",
+        "/openapi.json",
+        "/.well-known/agent.json",
+    ];
+    let router = |path: String| serde_json::json!({ "path": path, "block": "suppers-ai/router" });
+    let mut routes = Vec::new();
+    if !base_path.is_empty() {
+        // `{base}` and `{base}/` both reach the root redirect.
+        routes.push(router(base_path.to_string()));
+        routes.extend(
+            ROUTER_PATHS
+                .iter()
+                .map(|path| router(format!("{base_path}{path}"))),
+        );
+    }
+    routes.extend(ROUTER_PATHS.iter().map(|path| router(path.to_string())));
+    routes.push(serde_json::json!({
+        "path": "/**",
+        "block": "wafer-run/web",
+        "config": { "web_root": "site", "web_spa": "true", "web_index": "index.html" },
+    }));
+    serde_json::Value::Array(routes)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn paths(routes: &serde_json::Value) -> Vec<&str> {
+        routes
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|r| r["path"].as_str().unwrap())
+            .collect()
+    }
+
+    #[test]
+    fn base_path_adds_prefixed_router_routes_before_the_site_fallback() {
+        let root = default_routes();
+        assert_eq!(
+            paths(&root),
+            [
+                "/",
+                "/b/**",
+                "/health",
+                "/api/**",
+                "/openapi.json",
+                "/.well-known/agent.json",
+                "/**"
+            ]
+        );
+
+        let mounted = routes("/solobase");
+        let mounted_paths = paths(&mounted);
+        assert_eq!(
+            &mounted_paths[..3],
+            ["/solobase", "/solobase/", "/solobase/b/**"]
+        );
+        assert!(mounted_paths.contains(&"/solobase/.well-known/agent.json"));
+        assert!(mounted_paths.contains(&"/solobase/api/**"));
+        assert!(mounted_paths.contains(&"/b/**"));
+        assert_eq!(mounted_paths.last(), Some(&"/**"));
+        let fallback = mounted.as_array().unwrap().last().unwrap();
+        assert_eq!(fallback["block"], "wafer-run/web");
+    }
 }
@@ -0,0 +1,806 @@
+//! Localized messages: the string catalog behind error responses, templated
+//! emails and `GET /api/i18n/{locale}`.
+//!
+//! Catalog keys are dotted: `errors.{code}` for each
+//! [`crate::blocks::errors::ErrorCode`], `email.{template}.{part}` for the
+//! email variants (see `blocks::email`) and `ui.*` for frontend strings.
+//! The `en` catalog is embedded (`en.json`); admins upload per-locale
+//! overrides at `/b/admin/api/translations/{locale}`, one row per locale in
+//! [`TRANSLATIONS_TABLE`], and those are merged over it.
+//!
+//! A lookup for `pt-br` tries `pt-br`, then `pt`, then `en` ([`chain`]).
+//! The caller's locale is their profile preference (`metadata.user.locale`)
+//! when they have one, else the best `Accept-Language` entry the catalog
+//! can serve, else `en` ([`Translations::negotiate`]).
+//!
+//! Error responses keep their stable `code`; the pipeline swaps `message`
+//! for the caller's translation of `errors.{code}`, filling `{field}`
+//! placeholders from the error's `details`. The embedded English entries
+//! are generic, so they never replace a handler's own (more specific)
+//! English message — only an admin's `en` override does. A key with no
+//! translation in the caller's language is logged once per locale and key,
+//! not on every request.
+
+use std::{
+    collections::{BTreeMap, BTreeSet},
+    sync::{Mutex, OnceLock},
+    time::Duration,
+};
+
+use serde_json::Value;
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::database::{self as db, Record};
+use wafer_run::{context::Context, WaferError};
+
+use crate::{
+    blocks::{admin::TRANSLATIONS_TABLE, auth::repo::users},
+    cache::TtlCache,
+    util::RecordExt,
+};
+
+/// The locale every chain ends in, and the one the embedded catalog is in.
+pub const DEFAULT_LOCALE: &str = "en";
+
+/// Key of the caller's preferred locale in the client-writable
+/// `metadata.user` object of their profile.
+pub const PREFERENCE_KEY: &str = "locale";
+
+/// Longest accepted locale tag (BCP 47 allows more; nothing real needs it).
+pub const MAX_LOCALE_CHARS: usize = 35;
+
+/// Longest accepted catalog key.
+pub const MAX_KEY_CHARS: usize = 200;
+
+/// Longest accepted message, in characters.
+pub const MAX_MESSAGE_CHARS: usize = 5000;
+
+/// Most keys one locale's upload may carry.
+pub const MAX_MESSAGES: usize = 5000;
+
+const EN_JSON: &str = include_str!("en.json");
+
+/// The embedded `en` catalog.
+fn embedded() -> &'static BTreeMap<String, String> {
+    static EN: OnceLock<BTreeMap<String, String>> = OnceLock::new();
+    EN.get_or_init(|| serde_json::from_str(EN_JSON).expect("embedded en.json is a string map"))
+}
+
+/// `tag` in canonical lowercase form (`pt_BR` → `pt-br`), or `None` when it
+/// is not a plausible language tag: a 2–3 letter language, then
+/// alphanumeric subtags of up to 8 characters.
+pub fn normalize(tag: &str) -> Option<String> {
+    let tag = tag.trim().replace('_', "-").to_ascii_lowercase();
+    if tag.is_empty() || tag.len() > MAX_LOCALE_CHARS {
+        return None;
+    }
+    let mut parts = tag.split('-');
+    let language = parts.next()?;
+    if !(2..=3).contains(&language.len()) || !language.bytes().all(|b| b.is_ascii_lowercase()) {
+        return None;
+    }
+    for part in parts {
+        if part.is_empty() || part.len() > 8 || !part.bytes().all(|b| b.is_ascii_alphanumeric()) {
+            return None;
+        }
+    }
+    Some(tag)
+}
+
+/// Whether `tag` is English (`en`, `en-gb`, ...).
+fn is_english(tag: &str) -> bool {
+    tag.split('-').next() == Some(DEFAULT_LOCALE)
+}
+
+/// The locales a lookup for `locale` tries, most specific first:
+/// `pt-br` → `[pt-br, pt, en]`. An invalid tag is just `[en]`.
+pub fn chain(locale: &str) -> Vec<String> {
+    let mut out = Vec::new();
+    if let Some(tag) = normalize(locale) {
+        let language = tag.split('-').next().unwrap_or_default().to_string();
+        out.push(tag);
+        if !out.contains(&language) {
+            out.push(language);
+        }
+    }
+    if !out.iter().any(|l| l == DEFAULT_LOCALE) {
+        out.push(DEFAULT_LOCALE.to_string());
+    }
+    out
+}
+
+/// `Accept-Language` entries by preference: highest `q` first, header order
+/// among equals. `*`, `q=0` and malformed tags are dropped.
+fn accepted(header: &str) -> Vec<String> {
+    let mut ranked: Vec<(u16, String)> = header
+        .split(',')
+        .filter_map(|entry| {
+            let mut parts = entry.split(';');
+            let tag = normalize(parts.next()?)?;
+            let q = parts
+                .find_map(|p| p.trim().strip_prefix("q="))
+                .map_or(Some(1.0), |q| q.trim().parse::<f32>().ok())?;
+            let q = (q.clamp(0.0, 1.0) * 1000.0) as u16;
+            (q > 0).then_some((q, tag))
+        })
+        .collect();
+    // Stable, so header order breaks ties.
+    ranked.sort_by(|a, b| b.0.cmp(&a.0));
+    ranked.into_iter().map(|(_, tag)| tag).collect()
+}
+
+/// Every `(locale, key)` already reported missing, so each is logged once.
+static REPORTED: Mutex<BTreeSet<(String, String)>> = Mutex::new(BTreeSet::new());
+
+/// Log that `locale` has no translation for `key` — the first time only.
+/// Returns whether this call logged.
+fn report_missing(locale: &str, key: &str) -> bool {
+    let first = REPORTED
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .insert((locale.to_string(), key.to_string()));
+    if first {
+        tracing::warn!(locale, key, "no translation for message key");
+    }
+    first
+}
+
+/// The embedded catalog plus every admin override, as loaded.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Translations {
+    overrides: BTreeMap<String, BTreeMap<String, String>>,
+}
+
+impl Translations {
+    /// Overrides by locale; keys are normalized locale tags.
+    pub fn new(overrides: BTreeMap<String, BTreeMap<String, String>>) -> Self {
+        Self { overrides }
+    }
+
+    /// Every locale with a catalog: `en` and each overridden locale.
+    pub fn locales(&self) -> Vec<&str> {
+        let mut out: Vec<&str> = self.overrides.keys().map(String::as_str).collect();
+        if !out.contains(&DEFAULT_LOCALE) {
+            out.push(DEFAULT_LOCALE);
+        }
+        out.sort_unstable();
+        out
+    }
+
+    /// Whether the catalog speaks `locale`'s language: English always,
+    /// anything else once its tag or language has overrides.
+    fn serves(&self, locale: &str) -> bool {
+        is_english(locale)
+            || chain(locale)
+                .iter()
+                .any(|l| l != DEFAULT_LOCALE && self.overrides.contains_key(l))
+    }
+
+    /// The locale to answer in: `preference` (from the caller's profile)
+    /// when it is a valid tag the catalog serves, else the first
+    /// `Accept-Language` entry it serves, else `en`. A served tag is kept
+    /// as asked (`de-at` stays `de-at`), so lookups still try it first.
+    pub fn negotiate(&self, preference: &str, accept_language: &str) -> String {
+        normalize(preference)
+            .into_iter()
+            .chain(accepted(accept_language))
+            .find(|tag| self.serves(tag))
+            .unwrap_or_else(|| DEFAULT_LOCALE.to_string())
+    }
+
+    /// The override for `key` in `locale`'s chain, skipping the embedded
+    /// catalog. Logs (once) when the caller's language has none.
+    pub fn translated(&self, locale: &str, key: &str) -> Option<&str> {
+        let chain = chain(locale);
+        let hit = chain
+            .iter()
+            .find_map(|l| Some((l, self.overrides.get(l)?.get(key)?)));
+        let requested = &chain[0];
+        if hit.is_none_or(|(l, _)| l == DEFAULT_LOCALE)
+            && !is_english(requested)
+            && self.serves(requested)
+        {
+            report_missing(requested, key);
+        }
+        hit.map(|(_, text)| text.as_str())
+    }
+
+    /// `key` in `locale`'s chain, ending in the embedded `en` catalog.
+    /// Logs (once) when the key is missing everywhere.
+    pub fn get(&self, locale: &str, key: &str) -> Option<&str> {
+        self.translated(locale, key)
+            .or_else(|| embedded().get(key).map(String::as_str))
+            .or_else(|| {
+                report_missing(DEFAULT_LOCALE, key);
+                None
+            })
+    }
+
+    /// The full catalog for `locale`: the embedded `en` entries with each
+    /// chain locale's overrides applied, least specific first.
+    pub fn catalog(&self, locale: &str) -> BTreeMap<String, String> {
+        let mut out = embedded().clone();
+        for l in chain(locale).iter().rev() {
+            if let Some(messages) = self.overrides.get(l) {
+                out.extend(messages.iter().map(|(k, v)| (k.clone(), v.clone())));
+            }
+        }
+        out
+    }
+
+    /// Translate an error body in place: a JSON object with a string `code`
+    /// gets `message` from `errors.{code}`, its placeholders filled from
+    /// `details`. Returns whether it changed.
+    pub fn localize_error_body(&self, locale: &str, body: &mut Vec<u8>) -> bool {
+        let Ok(mut json) = serde_json::from_slice::<Value>(body) else {
+            return false;
+        };
+        let Some(code) = json.get("code").and_then(Value::as_str) else {
+            return false;
+        };
+        if !json.get("message").is_some_and(Value::is_string) {
+            return false;
+        }
+        let Some(template) = self.translated(locale, &format!("errors.{code}")) else {
+            return false;
+        };
+        let message = render(template, json.get("details").unwrap_or(&Value::Null));
+        json["message"] = Value::String(message);
+        match serde_json::to_vec(&json) {
+            Ok(bytes) => {
+                *body = bytes;
+                true
+            }
+            Err(_) => false,
+        }
+    }
+
+    /// Translate an error terminal's message from its `error.code` meta
+    /// (see [`crate::blocks::errors::error_response`]). Returns whether it
+    /// changed.
+    pub fn localize_error(&self, locale: &str, err: &mut WaferError) -> bool {
+        let Some(code) = err.detail_code().map(str::to_string) else {
+            return false;
+        };
+        match self.translated(locale, &format!("errors.{code}")) {
+            Some(template) => {
+                err.message = render(template, &Value::Null);
+                true
+            }
+            None => false,
+        }
+    }
+}
+
+/// Fill `{name}` placeholders in `template` from the string, number or
+/// boolean fields of `vars`. Unknown placeholders are left as written.
+pub fn render(template: &str, vars: &Value) -> String {
+    let mut out = String::with_capacity(template.len());
+    let mut rest = template;
+    while let Some(open) = rest.find('{') {
+        out.push_str(&rest[..open]);
+        let after = &rest[open + 1..];
+        let value = after.find('}').and_then(|close| {
+            let name = &after[..close];
+            let text = match vars.get(name)? {
+                Value::String(s) => s.clone(),
+                v @ (Value::Number(_) | Value::Bool(_)) => v.to_string(),
+                _ => return None,
+            };
+            Some((text, close))
+        });
+        match value {
+            Some((text, close)) => {
+                out.push_str(&text);
+                rest = &after[close + 1..];
+            }
+            None => {
+                out.push('{');
+                rest = after;
+            }
+        }
+    }
+    out.push_str(rest);
+    out
+}
+
+// ---------------------------------------------------------------------------
+// Loading
+// ---------------------------------------------------------------------------
+
+/// The overrides as last read. Only an admin write on this instance
+/// invalidates it, so the TTL bounds how long another instance serves an
+/// edited translation. Disabled in tests, as for feature flags.
+static CACHE: TtlCache<Translations> = TtlCache::new(CACHE_TTL);
+
+#[cfg(not(test))]
+const CACHE_TTL: Duration = Duration::from_secs(60);
+#[cfg(test)]
+const CACHE_TTL: Duration = Duration::ZERO;
+
+/// Parse a stored `messages` column: a JSON object of strings. Anything
+/// else is dropped rather than failing the whole catalog.
+fn messages_of(r: &Record) -> BTreeMap<String, String> {
+    let parsed = match r.data.get("messages") {
+        Some(Value::String(s)) => serde_json::from_str(s).unwrap_or(Value::Null),
+        Some(v) => v.clone(),
+        None => Value::Null,
+    };
+    parsed
+        .as_object()
+        .map(|m| {
+            m.iter()
+                .filter_map(|(k, v)| Some((k.clone(), v.as_str()?.to_string())))
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+async fn load_all(ctx: &dyn Context) -> Result<Translations, WaferError> {
+    let rows = db::list_all(ctx, TRANSLATIONS_TABLE, vec![]).await?;
+    Ok(Translations::new(
+        rows.iter()
+            .map(|r| (r.str_field("locale").to_string(), messages_of(r)))
+            .collect(),
+    ))
+}
+
+/// Every override, through the cache. A failed read serves the embedded
+/// catalog alone — a message in English beats a failed response — and is
+/// retried on the next call.
+pub async fn load(ctx: &dyn Context) -> std::sync::Arc<Translations> {
+    let mut failed = None;
+    let result = if cfg!(target_arch = "wasm32") {
+        // `Instant` panics on wasm32; read the table every time there.
+        std::sync::Arc::new(load_all(ctx).await.unwrap_or_else(|e| {
+            failed = Some(e);
+            Translations::default()
+        }))
+    } else {
+        let slot = &mut failed;
+        CACHE
+            .get_or_load(|| async move {
+                load_all(ctx).await.unwrap_or_else(|e| {
+                    *slot = Some(e);
+                    Translations::default()
+                })
+            })
+            .await
+    };
+    if let Some(e) = failed {
+        CACHE.invalidate();
+        tracing::warn!(error = %e, "loading translation overrides failed");
+    }
+    result
+}
+
+/// `user_id`'s preferred locale from their profile, or `""` when they have
+/// none (or can't be read).
+pub async fn user_preference(ctx: &dyn Context, user_id: &str) -> String {
+    if user_id.is_empty() {
+        return String::new();
+    }
+    match users::find_profile(ctx, user_id).await {
+        Ok(Some(profile)) => profile.metadata["user"][PREFERENCE_KEY]
+            .as_str()
+            .unwrap_or_default()
+            .to_string(),
+        _ => String::new(),
+    }
+}
+
+/// The preferred locale of the account registered to `email`, or `""`.
+pub async fn recipient_preference(ctx: &dyn Context, email: &str) -> String {
+    match users::find_by_email(ctx, email).await {
+        Ok(Some(user)) => user_preference(ctx, &user.id).await,
+        _ => String::new(),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// Admin overrides
+// ---------------------------------------------------------------------------
+
+/// One locale's stored overrides.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct LocaleOverrides {
+    pub locale: String,
+    pub messages: BTreeMap<String, String>,
+    pub updated_by: String,
+    pub updated_at: String,
+}
+
+impl LocaleOverrides {
+    fn from_record(r: &Record) -> Self {
+        Self {
+            locale: r.str_field("locale").to_string(),
+            messages: messages_of(r),
+            updated_by: r.str_field("updated_by").to_string(),
+            updated_at: r.str_field("updated_at").to_string(),
+        }
+    }
+}
+
+fn locale_filter(locale: &str) -> Filter {
+    Filter {
+        field: "locale".to_string(),
+        operator: FilterOp::Equal,
+        value: serde_json::json!(locale),
+    }
+}
+
+/// Check an uploaded `{key: text}` object, or name the problems.
+pub fn validate_messages(
+    messages: &Value,
+) -> Result<BTreeMap<String, String>, Vec<(&'static str, &'static str)>> {
+    let Some(map) = messages.as_object() else {
+        return Err(vec![("messages", "must be an object of strings")]);
+    };
+    if map.len() > MAX_MESSAGES {
+        return Err(vec![("messages", "must not exceed 5000 keys")]);
+    }
+    let mut out = BTreeMap::new();
+    for (key, text) in map {
+        let valid_key = !key.is_empty()
+            && key.len() <= MAX_KEY_CHARS
+            && key
+                .bytes()
+                .all(|b| b.is_ascii_alphanumeric() || matches!(b, b'.' | b'_' | b'-'));
+        if !valid_key {
+            return Err(vec![(
+                "messages",
+                "keys must be letters, digits, '.', '_' or '-' (up to 200)",
+            )]);
+        }
+        let Some(text) = text.as_str() else {
+            return Err(vec![("messages", "must be an object of strings")]);
+        };
+        if text.chars().count() > MAX_MESSAGE_CHARS {
+            return Err(vec![("messages", "texts must not exceed 5000 characters")]);
+        }
+        out.insert(key.clone(), text.to_string());
+    }
+    Ok(out)
+}
+
+/// Every overridden locale, by tag.
+pub async fn list_overrides(ctx: &dyn Context) -> Result<Vec<LocaleOverrides>, WaferError> {
+    let rows = db::list_all(ctx, TRANSLATIONS_TABLE, vec![]).await?;
+    let mut all: Vec<LocaleOverrides> = rows.iter().map(LocaleOverrides::from_record).collect();
+    all.sort_by(|a, b| a.locale.cmp(&b.locale));
+    Ok(all)
+}
+
+/// `locale`'s overrides, if it has any.
+pub async fn get_overrides(
+    ctx: &dyn Context,
+    locale: &str,
+) -> Result<Option<LocaleOverrides>, WaferError> {
+    let rows = db::list_all(ctx, TRANSLATIONS_TABLE, vec![locale_filter(locale)]).await?;
+    Ok(rows.first().map(LocaleOverrides::from_record))
+}
+
+/// Replace `locale`'s overrides with `messages`.
+pub async fn put_overrides(
+    ctx: &dyn Context,
+    locale: &str,
+    messages: &BTreeMap<String, String>,
+    updated_by: &str,
+) -> Result<LocaleOverrides, WaferError> {
+    let mut data = crate::util::json_map(serde_json::json!({
+        "locale": locale,
+        "messages": serde_json::to_string(messages).unwrap_or_else(|_| "{}".to_string()),
+        "updated_by": updated_by,
+    }));
+    crate::util::stamp_updated(&mut data);
+    let rows = db::list_all(ctx, TRANSLATIONS_TABLE, vec![locale_filter(locale)]).await?;
+    let record = match rows.first() {
+        Some(existing) => db::update(ctx, TRANSLATIONS_TABLE, &existing.id, data).await?,
+        None => {
+            crate::util::stamp_created(&mut data);
+            db::create(ctx, TRANSLATIONS_TABLE, data).await?
+        }
+    };
+    CACHE.invalidate();
+    Ok(LocaleOverrides::from_record(&record))
+}
+
+/// Drop `locale`'s overrides. Returns `false` when it had none.
+pub async fn delete_overrides(ctx: &dyn Context, locale: &str) -> Result<bool, WaferError> {
+    let Some(row) = db::list_all(ctx, TRANSLATIONS_TABLE, vec![locale_filter(locale)])
+        .await?
+        .into_iter()
+        .next()
+    else {
+        return Ok(false);
+    };
+    match db::delete(ctx, TRANSLATIONS_TABLE, &row.id).await {
+        Ok(()) => {}
+        Err(e) if e.code == wafer_run::ErrorCode::NotFound => return Ok(false),
+        Err(e) => return Err(e),
+    }
+    CACHE.invalidate();
+    Ok(true)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::blocks::errors::ErrorCode;
+
+    /// Every [`ErrorCode`] has an `errors.{code}` entry in the embedded catalog;
+    /// the test below keeps it that way.
+    const ALL_CODES: &[ErrorCode] = &[
+        ErrorCode::InvalidCredentials,
+        ErrorCode::EmailAlreadyExists,
+        ErrorCode::AccountDisabled,
+        ErrorCode::NotAuthenticated,
+        ErrorCode::InvalidToken,
+        ErrorCode::TokenExpired,
+        ErrorCode::EmailNotVerified,
+        ErrorCode::SignupClosed,
+        ErrorCode::InvitationRequired,
+        ErrorCode::InvitationInvalid,
+        ErrorCode::PasswordChangeRequired,
+        ErrorCode::PasswordTooShort,
+        ErrorCode::PasswordTooLong,
+        ErrorCode::InvalidEmail,
+        ErrorCode::InvalidInput,
+        ErrorCode::ValidationFailed,
+        ErrorCode::Forbidden,
+        ErrorCode::AdminRequired,
+        ErrorCode::PermissionDenied,
+        ErrorCode::NotFound,
+        ErrorCode::Conflict,
+        ErrorCode::ObjectNotFound,
+        ErrorCode::BucketAlreadyExists,
+        ErrorCode::BucketNotEmpty,
+        ErrorCode::BucketNameReserved,
+        ErrorCode::ObjectExists,
+        ErrorCode::ShareRevoked,
+        ErrorCode::DatabaseError,
+        ErrorCode::PaymentNotConfigured,
+        ErrorCode::InvalidPurchaseStatus,
+        ErrorCode::RefundFailed,
+        ErrorCode::InsufficientStock,
+        ErrorCode::CouponInvalid,
+        ErrorCode::CouponExpired,
+        ErrorCode::CouponExhausted,
+        ErrorCode::CouponNotApplicable,
+        ErrorCode::QuotaExceeded,
+        ErrorCode::FileTooLarge,
+        ErrorCode::PreviewUnsupported,
+        ErrorCode::ScanPending,
+        ErrorCode::MalwareDetected,
+        ErrorCode::ObjectLocked,
+        ErrorCode::UploadLimitReached,
+        ErrorCode::OriginNotAllowed,
+        ErrorCode::ChecksumMismatch,
+        ErrorCode::InternalError,
+        ErrorCode::ConfigurationError,
+        ErrorCode::PayloadTooLarge,
+        ErrorCode::UnsupportedMediaType,
+        ErrorCode::RateLimitExceeded,
+        ErrorCode::UsageQuotaExceeded,
+        ErrorCode::TooManyTransfers,
+        ErrorCode::SchemaNotInitialized,
+        ErrorCode::MaintenanceMode,
+        ErrorCode::RequestTimeout,
+    ];
+
+    fn catalog(entries: &[(&str, &[(&str, &str)])]) -> Translations {
+        Translations::new(
+            entries
+                .iter()
+                .map(|(locale, messages)| {
+                    (
+                        locale.to_string(),
+                        messages
+                            .iter()
+                            .map(|(k, v)| (k.to_string(), v.to_string()))
+                            .collect(),
+                    )
+                })
+                .collect(),
+        )
+    }
+
+    #[test]
+    fn every_error_code_has_an_english_message() {
+        for code in ALL_CODES {
+            let key = format!("errors.{}", code.as_str());
+            assert!(embedded().contains_key(&key), "{key} missing from en.json");
+        }
+        assert_eq!(
+            embedded()
+                .keys()
+                .filter(|k| k.starts_with("errors."))
+                .count(),
+            ALL_CODES.len(),
+            "en.json has errors.* keys for codes that don't exist"
+        );
+    }
+
+    #[test]
+    fn tags_are_normalized_or_refused() {
+        assert_eq!(normalize("pt_BR").as_deref(), Some("pt-br"));
+        assert_eq!(normalize(" de ").as_deref(), Some("de"));
+        assert_eq!(normalize("zh-Hant-TW").as_deref(), Some("zh-hant-tw"));
+        for bad in [
+            "",
+            "x",
+            "english",
+            "de-",
+            "de--at",
+            "12",
+            "de-toolongsubtag",
+            "*",
+        ] {
+            assert_eq!(normalize(bad), None, "{bad}");
+        }
+    }
+
+    #[test]
+    fn chains_run_requested_then_language_then_english() {
+        assert_eq!(chain("pt-BR"), ["pt-br", "pt", "en"]);
+        assert_eq!(chain("de"), ["de", "en"]);
+        assert_eq!(chain("en-GB"), ["en-gb", "en"]);
+        assert_eq!(chain("en"), ["en"]);
+        assert_eq!(chain("??"), ["en"]);
+    }
+
+    #[test]
+    fn lookups_fall_back_along_the_chain() {
+        let t = catalog(&[
+            ("pt", &[("ui.save", "Salvar"), ("ui.cancel", "Cancelar")]),
+            ("pt-br", &[("ui.save", "Gravar")]),
+        ]);
+        assert_eq!(t.get("pt-BR", "ui.save"), Some("Gravar"));
+        assert_eq!(t.get("pt-BR", "ui.cancel"), Some("Cancelar"));
+        assert_eq!(t.get("pt-BR", "ui.close"), Some("Close"));
+        assert_eq!(t.get("pt", "ui.save"), Some("Salvar"));
+        assert_eq!(t.get("pt-br", "ui.nonexistent.key"), None);
+        // The embedded English never counts as a translation.
+        assert_eq!(t.translated("pt-br", "ui.close"), None);
+        assert_eq!(t.translated("en", "ui.close"), None);
+    }
+
+    #[test]
+    fn catalogs_merge_overrides_over_the_embedded_english() {
+        let t = catalog(&[
+            ("en", &[("ui.save", "Save changes")]),
+            ("pt", &[("ui.save", "Salvar"), ("ui.cancel", "Cancelar")]),
+            ("pt-br", &[("ui.save", "Gravar")]),
+        ]);
+        let merged = t.catalog("pt-br");
+        assert_eq!(merged["ui.save"], "Gravar");
+        assert_eq!(merged["ui.cancel"], "Cancelar");
+        assert_eq!(merged["ui.close"], "Close");
+        assert_eq!(merged.len(), embedded().len());
+        assert_eq!(t.catalog("fr")["ui.save"], "Save changes");
+        assert_eq!(t.catalog("en")["errors.not_found"], "Not found.");
+    }
+
+    #[test]
+    fn negotiation_prefers_the_profile_then_accept_language() {
+        let t = catalog(&[("de", &[("ui.save", "Speichern")]), ("fr", &[])]);
+        assert_eq!(t.locales(), ["de", "en", "fr"]);
+        assert_eq!(t.negotiate("", "fr-CH, de;q=0.9, en;q=0.8"), "fr-ch");
+        assert_eq!(t.negotiate("", "es, de;q=0.5"), "de");
+        assert_eq!(t.negotiate("", "es;q=1, de;q=0.4, en;q=0.5"), "en");
+        assert_eq!(t.negotiate("", "de;q=0, *"), "en");
+        assert_eq!(t.negotiate("de-AT", "fr"), "de-at");
+        // An unserved or garbled preference falls through to the header.
+        assert_eq!(t.negotiate("es", "fr"), "fr");
+        assert_eq!(t.negotiate("not a tag", "de"), "de");
+        assert_eq!(t.negotiate("", ""), "en");
+    }
+
+    #[test]
+    fn placeholders_are_filled_from_details() {
+        let vars = serde_json::json!({"locked_until": "2026-11-01", "n": 3, "o": {}});
+        assert_eq!(
+            render("Gesperrt bis {locked_until} ({n})", &vars),
+            "Gesperrt bis 2026-11-01 (3)"
+        );
+        assert_eq!(render("{o} {missing} {", &vars), "{o} {missing} {");
+    }
+
+    #[test]
+    fn error_bodies_get_the_callers_translation() {
+        let t = catalog(&[(
+            "de",
+            &[("errors.object_locked", "Gesperrt bis {locked_until}.")],
+        )]);
+        let original = crate::blocks::errors::error_body(
+            ErrorCode::ObjectLocked,
+            "Object is locked",
+            Some(serde_json::json!({"locked_until": "2026-11-01"})),
+        );
+        let mut body = serde_json::to_vec(&original).unwrap();
+        assert!(t.localize_error_body("de-DE", &mut body));
+        let json: Value = serde_json::from_slice(&body).unwrap();
+        assert_eq!(json["message"], "Gesperrt bis 2026-11-01.");
+        assert_eq!(json["code"], "object_locked");
+        assert_eq!(json["details"]["locked_until"], "2026-11-01");
+
+        // English and untranslated codes keep the handler's message.
+        let mut body = serde_json::to_vec(&original).unwrap();
+        assert!(!t.localize_error_body("en", &mut body));
+        assert!(!t.localize_error_body("fr", &mut body));
+        assert_eq!(serde_json::from_slice::<Value>(&body).unwrap(), original);
+        let mut html = b"<p>not json</p>".to_vec();
+        assert!(!t.localize_error_body("de", &mut html));
+    }
+
+    #[tokio::test]
+    async fn error_terminals_are_translated_by_their_code() {
+        let t = catalog(&[("de", &[("errors.invalid_token", "Ungültiger Link.")])]);
+        let out = crate::blocks::errors::error_response(ErrorCode::InvalidToken, "token is bad");
+        let Err(wafer_run::TerminalNotResponse::Error(mut err)) = out.collect_buffered().await
+        else {
+            panic!("expected an error terminal");
+        };
+        assert!(t.localize_error("de", &mut err));
+        assert_eq!(err.message, "Ungültiger Link.");
+        assert_eq!(err.detail_code(), Some("invalid_token"));
+
+        let mut plain = WaferError::new(wafer_run::ErrorCode::NotFound, "nope");
+        assert!(!t.localize_error("de", &mut plain));
+    }
+
+    #[test]
+    fn missing_keys_are_reported_once() {
+        let t = catalog(&[("nl", &[("ui.save", "Opslaan")])]);
+        assert!(report_missing("nl", "ui.test-once"));
+        assert!(!report_missing("nl", "ui.test-once"));
+        assert!(report_missing("nl-be", "ui.test-once"));
+
+        // Lookups report through the same set: a second miss stays quiet.
+        assert_eq!(t.translated("nl-BE", "ui.test-lookup"), None);
+        assert!(!report_missing("nl-be", "ui.test-lookup"));
+        assert_eq!(t.get("nl", "ui.test-nowhere"), None);
+        assert!(!report_missing("en", "ui.test-nowhere"));
+    }
+
+    #[test]
+    fn uploads_are_validated() {
+        let ok = validate_messages(&serde_json::json!({"ui.save": "Speichern"})).unwrap();
+        assert_eq!(ok["ui.save"], "Speichern");
+        for bad in [
+            serde_json::json!(["ui.save"]),
+            serde_json::json!({"ui.save": 1}),
+            serde_json::json!({"ui save": "x"}),
+            serde_json::json!({"": "x"}),
+            serde_json::json!({"ui.save": "x".repeat(MAX_MESSAGE_CHARS + 1)}),
+        ] {
+            assert!(validate_messages(&bad).is_err(), "{bad}");
+        }
+    }
+
+    #[tokio::test]
+    async fn overrides_round_trip_through_the_table() {
+        let ctx = crate::test_support::TestContext::with_admin().await;
+        let messages: BTreeMap<String, String> =
+            [("ui.save".to_string(), "Speichern".to_string())].into();
+        put_overrides(&ctx, "de", &messages, "admin-1")
+            .await
+            .unwrap();
+        let replaced: BTreeMap<String, String> =
+            [("ui.cancel".to_string(), "Abbrechen".to_string())].into();
+        let stored = put_overrides(&ctx, "de", &replaced, "admin-2")
+            .await
+            .unwrap();
+        assert_eq!(stored.messages, replaced);
+        assert_eq!(stored.updated_by, "admin-2");
+        assert_eq!(list_overrides(&ctx).await.unwrap().len(), 1);
+
+        let loaded = load(&ctx).await;
+        assert_eq!(loaded.get("de", "ui.cancel"), Some("Abbrechen"));
+        assert_eq!(loaded.get("de", "ui.save"), Some("Save"));
+
+        assert!(delete_overrides(&ctx, "de").await.unwrap());
+        assert!(!delete_overrides(&ctx, "de").await.unwrap());
+        assert_eq!(get_overrides(&ctx, "de").await.unwrap(), None);
+        assert_eq!(load(&ctx).await.locales(), ["en"]);
+    }
+}
@@ -6,27 +6,54 @@
 //! native standalone binary.
 
 pub mod admin_schema;
+#[cfg(test)]
+mod api_compat;
+pub mod api_version;
+pub mod base_path;
+pub mod block_metrics;
 pub mod blocks;
+pub mod body;
 pub mod boot;
 pub mod builder;
 pub mod cache;
 pub mod cache_key;
+pub mod clock;
+pub mod compression;
 pub mod config_source;
+pub mod config_ui;
 pub mod config_vars;
+pub mod cron;
 pub mod crypto;
 pub mod deploy_init;
+pub mod detail;
+pub mod dev_mode;
+pub mod diagnostics;
 pub mod endpoint_match;
+pub mod etag;
+pub mod events;
 pub mod features;
 pub mod flows;
 pub mod http;
+pub mod i18n;
 pub mod kv;
 pub mod messages_schema;
 pub mod migration_helper;
 pub mod multipart;
+pub mod pagination;
 pub mod pipeline;
+pub mod revisions;
 pub mod routing;
+pub mod runtime_config;
+pub mod schema_status;
+pub mod services;
+pub mod status;
+pub mod storage_faults;
+pub mod table_scope;
+pub mod transfers;
 pub mod ui;
 pub mod util;
+pub mod version;
+pub mod write_buffer;
 
 // Exposed to the `tests/` integration-test crates (and any consumer that
 // wants the shared `TestContext` harness) behind the `test-support` feature,
@@ -39,4 +66,4 @@ pub mod test_support;
 pub use features::FeatureConfig;
 pub use migration_helper::db_backend;
 pub use pipeline::handle_request;
-pub use routing::{ExtraRoute, RouteAccess};
+pub use routing::{ExtraRoute, RouteAccess, RouteListing, RouteSource};
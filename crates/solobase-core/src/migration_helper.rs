@@ -119,6 +119,9 @@ pub async fn apply_migrations(
 /// 3. mapping the `apply_migrations` `Err(String)` onto
 ///    `WaferError::new(ErrorCode::Internal, "<block> migrations: …")`.
 ///
+/// The outcome is also recorded in [`crate::schema_status`], which keeps a
+/// block whose migrations failed out of routing until an admin retries them.
+///
 /// `sqlite_migrations` is the block's `migrations::SQLITE_MIGRATIONS`
 /// (`(basename, content)`); only the content half is executed (the basename is
 /// for the D1 filename). `postgres_files` is the matching ordered list of
@@ -132,18 +135,54 @@ pub async fn lifecycle_init(
     ctx: &dyn Context,
     event: &LifecycleEvent,
     block_name: &str,
-    sqlite_migrations: &[(&str, &str)],
-    postgres_files: &[&str],
+    sqlite_migrations: &'static [(&'static str, &'static str)],
+    postgres_files: &'static [&'static str],
 ) -> Result<(), WaferError> {
     if !matches!(event.event_type, LifecycleType::Init) {
         return Ok(());
     }
-    let sqlite: Vec<&str> = sqlite_migrations.iter().map(|(_, sql)| *sql).collect();
-    apply_migrations(ctx, block_name, &sqlite, postgres_files)
-        .await
+    let result = apply_scoped(ctx, block_name, sqlite_migrations, postgres_files).await;
+    crate::schema_status::record(block_name, sqlite_migrations, postgres_files, &result);
+    result
         .map_err(|e| WaferError::new(ErrorCode::Internal, format!("{block_name} migrations: {e}")))
 }
 
+/// [`apply_migrations`] for a feature block, refusing the whole set when any
+/// statement (in either dialect) defines, modifies or refers to a table
+/// outside the block's `{org}__{block}__` namespace — see
+/// [`crate::table_scope`]. Shared by
+/// [`lifecycle_init`] and the admin retry path, so a rejected set stays
+/// rejected on retry.
+pub async fn apply_scoped(
+    ctx: &dyn Context,
+    block_name: &str,
+    sqlite_migrations: &[(&str, &str)],
+    postgres_files: &[&str],
+) -> Result<(), String> {
+    let sqlite: Vec<&str> = sqlite_migrations.iter().map(|(_, sql)| *sql).collect();
+    let mut foreign: Vec<String> = Vec::new();
+    for sql in sqlite.iter().chain(postgres_files.iter()) {
+        for table in crate::table_scope::ddl_violations(block_name, sql) {
+            if !foreign.contains(&table) {
+                foreign.push(table);
+            }
+        }
+    }
+    if !foreign.is_empty() {
+        tracing::warn!(
+            block = %block_name,
+            tables = ?foreign,
+            "migration touching tables outside the block's namespace rejected"
+        );
+        return Err(format!(
+            "migrations reach outside {}*: {}",
+            crate::table_scope::table_prefix(block_name),
+            foreign.join(", ")
+        ));
+    }
+    apply_migrations(ctx, block_name, &sqlite, postgres_files).await
+}
+
 /// Apply `sql` against `db::ddl` iff the operator has blessed it or
 /// `SOLOBASE_RUN_MIGRATIONS=1`. Idempotent across calls: returns early
 /// once `current_hash` in the cached `BlockSettings` matches the SQL's hash.
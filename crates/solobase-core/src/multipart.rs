@@ -84,6 +84,33 @@ pub fn multipart_boundary(content_type: &str) -> Option<String> {
 /// filename. Returns `None` when the content type is not multipart, the
 /// framing is malformed, or no file part exists.
 pub fn extract_multipart_file(body: &[u8], content_type: &str) -> Option<MultipartFile> {
+    let part = find_file_part(body, content_type)?;
+    Some(MultipartFile {
+        content: body[part.content].to_vec(),
+        filename: part.filename,
+        content_type: part.content_type,
+    })
+}
+
+/// [`extract_multipart_file`]'s content, cut out of `body` in place rather
+/// than copied — for large uploads, where a second buffer would double the
+/// memory the upload holds.
+pub fn into_multipart_file_content(mut body: Vec<u8>, content_type: &str) -> Option<Vec<u8>> {
+    let range = find_file_part(&body, content_type)?.content;
+    body.truncate(range.end);
+    body.drain(..range.start);
+    Some(body)
+}
+
+/// The file part of a multipart body: where its content lies, and its
+/// filename and content type.
+struct FilePart {
+    content: std::ops::Range<usize>,
+    filename: Option<String>,
+    content_type: Option<String>,
+}
+
+fn find_file_part(body: &[u8], content_type: &str) -> Option<FilePart> {
     let boundary = multipart_boundary(content_type)?;
     let delimiter = format!("--{boundary}");
     let delimiter = delimiter.as_bytes();
@@ -102,7 +129,7 @@ pub fn extract_multipart_file(body: &[u8], content_type: &str) -> Option<Multipa
 
     // Each part spans two consecutive delimiters; the closing `--{boundary}--`
     // is itself found by the scan above, so it terminates the last part.
-    let mut named_file_fallback: Option<MultipartFile> = None;
+    let mut named_file_fallback: Option<FilePart> = None;
     for pair in positions.windows(2) {
         let (start, end) = (pair[0], pair[1]);
         let after = start + delimiter.len();
@@ -136,11 +163,11 @@ pub fn extract_multipart_file(body: &[u8], content_type: &str) -> Option<Multipa
         let part = &body[cursor..content_end];
 
         // Split part headers from part content on the empty line.
-        let (headers_raw, content) = if let Some(rest) = part.strip_prefix(b"\r\n".as_slice()) {
+        let (headers_raw, content_start) = if part.starts_with(b"\r\n") {
             // A (legal, if unusual) part with zero headers.
-            (&[] as &[u8], rest)
+            (&[] as &[u8], cursor + 2)
         } else if let Some(headers_end) = find(part, b"\r\n\r\n", 0) {
-            (&part[..headers_end], &part[headers_end + 4..])
+            (&part[..headers_end], cursor + headers_end + 4)
         } else {
             continue; // No header/content separator — malformed part.
         };
@@ -161,8 +188,8 @@ pub fn extract_multipart_file(body: &[u8], content_type: &str) -> Option<Multipa
 
         let filename = disposition_param(&disposition, "filename");
         let field_name = disposition_param(&disposition, "name");
-        let file = MultipartFile {
-            content: content.to_vec(),
+        let file = FilePart {
+            content: content_start..content_end,
             filename,
             content_type: part_content_type,
         };
@@ -242,6 +269,10 @@ mod tests {
         );
         assert_eq!(file.filename.as_deref(), Some("index.html"));
         assert_eq!(file.content_type.as_deref(), Some("text/html"));
+        assert_eq!(
+            into_multipart_file_content(body, &content_type).as_deref(),
+            Some(file_bytes)
+        );
     }
 
     /// Binary content survives intact: interior CRLFs, NUL bytes, and even a
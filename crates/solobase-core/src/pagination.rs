@@ -0,0 +1,716 @@
+//! Shared list-endpoint conventions: `?limit`, `?cursor`, `?sort` and
+//! `?fields`.
+//!
+//! Each list endpoint declares a [`ListSpec`] (default/max page size, the
+//! columns it allows sorting on, its default order) and hands the request to
+//! [`parse`]. The parsed [`ListQuery`] then drives [`fetch`], which picks one
+//! of two response shapes:
+//!
+//! - **Cursor envelope** — when the caller sends `limit` or `cursor`:
+//!   `{"data": [...], "pagination": {"next_cursor": "...", "total": N}}`.
+//!   Paging is keyset-based on `(sort field, id)`, so rows inserted while a
+//!   client walks the list don't shift later pages. `total` is only counted
+//!   when asked for (`?total=true`).
+//! - **Legacy page shape** — otherwise: the `RecordList` body
+//!   (`records`/`total_count`/`page`/`page_size`) driven by `page` and
+//!   `page_size` (or `per_page`), exactly as before. `sort` and `fields`
+//!   still apply.
+//!
+//! Cursors are opaque URL-safe base64 over a small JSON payload. They are not
+//! signed: a forged cursor can only move the window inside the endpoint's own
+//! filtered query, so it never widens what the caller may see. Anything that
+//! doesn't decode cleanly, or that was issued for a different sort, is
+//! rejected as `validation_failed` rather than silently restarting the list.
+//!
+//! Sources that can't be keyset-paged (the storage service's object listing)
+//! use [`encode_offset_cursor`]/[`decode_offset_cursor`] so clients still see
+//! the same envelope and an opaque `next_cursor`.
+
+use base64ct::{Base64UrlUnpadded, Encoding};
+use serde::{Deserialize, Serialize};
+use wafer_block::db::{Filter, FilterOp, ListOptions, SortField};
+use wafer_core::clients::database::{self as db, Record, RecordList};
+use wafer_run::{context::Context, Message, OutputStream, WaferError};
+
+use crate::blocks::errors::validation_error;
+
+/// Longest accepted `?cursor` value; real cursors are far shorter.
+const MAX_CURSOR_LEN: usize = 1024;
+/// Most columns a single `?fields=` may name.
+const MAX_FIELDS: usize = 64;
+
+/// Per-endpoint list limits and sort allowlist.
+#[derive(Debug, Clone, Copy)]
+pub struct ListSpec {
+    /// Page size when the caller sends none.
+    pub default_limit: u32,
+    /// Upper bound; larger `limit`/`page_size` values are clamped to it.
+    pub max_limit: u32,
+    /// Columns accepted in `?sort=`. Keyset paging needs these to be
+    /// non-null on every row.
+    pub sortable: &'static [&'static str],
+    /// Order used when `?sort` is absent.
+    pub default_sort: &'static str,
+    pub default_desc: bool,
+}
+
+/// A parsed list request.
+#[derive(Debug, Clone, PartialEq)]
+pub struct ListQuery {
+    pub limit: u32,
+    /// 1-based page for the legacy shape.
+    pub page: u32,
+    pub sort_field: String,
+    pub desc: bool,
+    pub cursor: Option<Cursor>,
+    /// `?fields=` projection; `None` returns every column.
+    pub fields: Option<Vec<String>>,
+    pub with_total: bool,
+    /// The caller opted into the `{data, pagination}` envelope.
+    pub envelope: bool,
+}
+
+impl ListQuery {
+    /// Rows to skip for the legacy page shape.
+    pub fn offset(&self) -> u64 {
+        u64::from(self.page.saturating_sub(1)) * u64::from(self.limit)
+    }
+
+    fn sort_token(&self) -> String {
+        sort_token(&self.sort_field, self.desc)
+    }
+}
+
+/// A rejected list parameter, reported as `validation_failed` with the
+/// offending query parameter in `details`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ListQueryError {
+    pub param: &'static str,
+    pub reason: String,
+}
+
+impl ListQueryError {
+    fn new(param: &'static str, reason: impl Into<String>) -> Self {
+        Self {
+            param,
+            reason: reason.into(),
+        }
+    }
+
+    pub fn response(&self) -> OutputStream {
+        validation_error(
+            "Invalid list parameters",
+            &[(self.param, self.reason.as_str())],
+        )
+    }
+}
+
+/// Decoded keyset cursor: the last row's sort value and id, plus the sort
+/// it was issued for.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct Cursor {
+    #[serde(rename = "s")]
+    pub sort: String,
+    #[serde(rename = "k")]
+    pub key: serde_json::Value,
+    #[serde(rename = "i")]
+    pub id: String,
+}
+
+#[derive(Serialize, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct OffsetCursor {
+    #[serde(rename = "o")]
+    offset: u64,
+}
+
+fn sort_token(field: &str, desc: bool) -> String {
+    format!("{field}:{}", if desc { "desc" } else { "asc" })
+}
+
+/// Parse `limit`, `cursor`, `page`/`page_size`/`per_page`, `sort`,
+/// `fields` and `total` from the request query.
+pub fn parse(msg: &Message, spec: &ListSpec) -> Result<ListQuery, ListQueryError> {
+    parse_params(|key| msg.query(key).to_string(), spec)
+}
+
+fn parse_params(
+    query: impl Fn(&str) -> String,
+    spec: &ListSpec,
+) -> Result<ListQuery, ListQueryError> {
+    let (sort_field, desc) = parse_sort(&query("sort"), spec)?;
+
+    let raw_limit = query("limit");
+    let raw_cursor = query("cursor");
+    let envelope = !raw_limit.is_empty() || !raw_cursor.is_empty();
+
+    let limit = if !raw_limit.is_empty() {
+        parse_limit("limit", &raw_limit, spec)?
+    } else if !query("per_page").is_empty() {
+        parse_limit("per_page", &query("per_page"), spec)?
+    } else if !query("page_size").is_empty() {
+        parse_limit("page_size", &query("page_size"), spec)?
+    } else {
+        spec.default_limit.clamp(1, spec.max_limit.max(1))
+    };
+
+    let page = match query("page").as_str() {
+        "" => 1,
+        raw => raw
+            .parse::<u32>()
+            .ok()
+            .filter(|p| *p >= 1)
+            .ok_or_else(|| ListQueryError::new("page", "must be a positive integer"))?,
+    };
+
+    let cursor = if raw_cursor.is_empty() {
+        None
+    } else {
+        Some(
+            decode_cursor(&raw_cursor, &sort_token(&sort_field, desc))
+                .map_err(|reason| ListQueryError::new("cursor", reason))?,
+        )
+    };
+
+    Ok(ListQuery {
+        limit,
+        page,
+        sort_field,
+        desc,
+        cursor,
+        fields: parse_fields(&query("fields"))?,
+        with_total: matches!(query("total").as_str(), "true" | "1"),
+        envelope,
+    })
+}
+
+/// [`parse`] for offset-paged sources: `?cursor` carries an offset (see
+/// [`encode_offset_cursor`]) instead of a keyset position. Returns the query
+/// and the number of rows to skip.
+pub fn parse_offset(msg: &Message, spec: &ListSpec) -> Result<(ListQuery, u64), ListQueryError> {
+    let raw_cursor = msg.query("cursor").to_string();
+    let mut query = parse_params(
+        |key| match key {
+            "cursor" => String::new(),
+            _ => msg.query(key).to_string(),
+        },
+        spec,
+    )?;
+    if raw_cursor.is_empty() {
+        let offset = if query.envelope { 0 } else { query.offset() };
+        return Ok((query, offset));
+    }
+    query.envelope = true;
+    let offset = decode_offset_cursor(&raw_cursor)
+        .map_err(|reason| ListQueryError::new("cursor", reason))?;
+    Ok((query, offset))
+}
+
+/// Non-numeric or zero values are rejected; anything above `max_limit` is
+/// clamped so callers asking for "everything" still get a valid page.
+fn parse_limit(param: &'static str, raw: &str, spec: &ListSpec) -> Result<u32, ListQueryError> {
+    let n = raw
+        .trim()
+        .parse::<u64>()
+        .ok()
+        .filter(|n| *n >= 1)
+        .ok_or_else(|| ListQueryError::new(param, "must be a positive integer"))?;
+    Ok(n.min(u64::from(spec.max_limit.max(1))) as u32)
+}
+
+fn parse_sort(raw: &str, spec: &ListSpec) -> Result<(String, bool), ListQueryError> {
+    let raw = raw.trim();
+    if raw.is_empty() {
+        return Ok((spec.default_sort.to_string(), spec.default_desc));
+    }
+    let (field, dir) = raw.split_once(':').unwrap_or((raw, "asc"));
+    let desc = match dir {
+        "asc" => false,
+        "desc" => true,
+        _ => return Err(ListQueryError::new("sort", "direction must be asc or desc")),
+    };
+    if !spec.sortable.contains(&field) {
+        return Err(ListQueryError::new(
+            "sort",
+            format!(
+                "cannot sort by '{field}'; allowed: {}",
+                spec.sortable.join(", ")
+            ),
+        ));
+    }
+    Ok((field.to_string(), desc))
+}
+
+fn parse_fields(raw: &str) -> Result<Option<Vec<String>>, ListQueryError> {
+    if raw.trim().is_empty() {
+        return Ok(None);
+    }
+    let mut fields: Vec<String> = Vec::new();
+    for name in raw.split(',').map(str::trim) {
+        let valid = !name.is_empty()
+            && name.len() <= 64
+            && name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_');
+        if !valid {
+            return Err(ListQueryError::new("fields", "invalid field name"));
+        }
+        if !fields.iter().any(|f| f == name) {
+            fields.push(name.to_string());
+        }
+    }
+    if fields.len() > MAX_FIELDS {
+        return Err(ListQueryError::new("fields", "too many fields"));
+    }
+    Ok(Some(fields))
+}
+
+fn encode_payload<T: Serialize>(payload: &T) -> String {
+    let json = serde_json::to_vec(payload).unwrap_or_default();
+    Base64UrlUnpadded::encode_string(&json)
+}
+
+fn decode_payload<T: for<'de> Deserialize<'de>>(raw: &str) -> Result<T, &'static str> {
+    if raw.len() > MAX_CURSOR_LEN {
+        return Err("cursor is too long");
+    }
+    let bytes = Base64UrlUnpadded::decode_vec(raw).map_err(|_| "malformed cursor")?;
+    serde_json::from_slice(&bytes).map_err(|_| "malformed cursor")
+}
+
+/// Opaque cursor pointing just past the row with sort value `key` and `id`.
+pub fn encode_cursor(sort: &str, key: serde_json::Value, id: &str) -> String {
+    encode_payload(&Cursor {
+        sort: sort.to_string(),
+        key,
+        id: id.to_string(),
+    })
+}
+
+/// Decode a keyset cursor issued for `expected_sort` (`field:dir`).
+pub fn decode_cursor(raw: &str, expected_sort: &str) -> Result<Cursor, &'static str> {
+    let cursor: Cursor = decode_payload(raw)?;
+    if cursor.sort != expected_sort {
+        return Err("cursor was issued for a different sort");
+    }
+    if !(cursor.key.is_string() || cursor.key.is_number()) {
+        return Err("malformed cursor");
+    }
+    if cursor.id.is_empty() || cursor.id.len() > 128 {
+        return Err("malformed cursor");
+    }
+    Ok(cursor)
+}
+
+/// Opaque cursor for offset-paged sources.
+pub fn encode_offset_cursor(offset: u64) -> String {
+    encode_payload(&OffsetCursor { offset })
+}
+
+pub fn decode_offset_cursor(raw: &str) -> Result<u64, &'static str> {
+    decode_payload::<OffsetCursor>(raw).map(|c| c.offset)
+}
+
+/// One page of records in whichever shape the request asked for.
+#[derive(Debug)]
+pub enum ListPage {
+    Legacy(RecordList),
+    Cursor {
+        records: Vec<Record>,
+        next_cursor: Option<String>,
+        total: Option<i64>,
+    },
+}
+
+impl ListPage {
+    /// The page's rows, for per-endpoint enrichment before rendering.
+    pub fn records_mut(&mut self) -> &mut Vec<Record> {
+        match self {
+            ListPage::Legacy(list) => &mut list.records,
+            ListPage::Cursor { records, .. } => records,
+        }
+    }
+
+    /// Render the response body, applying the `?fields=` projection.
+    pub fn into_json(mut self, fields: Option<&[String]>) -> serde_json::Value {
+        if let Some(fields) = fields {
+            for record in self.records_mut() {
+                project_record(record, fields);
+            }
+        }
+        match self {
+            ListPage::Legacy(list) => serde_json::to_value(&list).unwrap_or_default(),
+            ListPage::Cursor {
+                records,
+                next_cursor,
+                total,
+            } => envelope(serde_json::json!(records), next_cursor, total),
+        }
+    }
+}
+
+/// `{"data": data, "pagination": {"next_cursor": .., "total": ..}}`;
+/// `total` is omitted when it wasn't counted.
+pub fn envelope(
+    data: serde_json::Value,
+    next_cursor: Option<String>,
+    total: Option<i64>,
+) -> serde_json::Value {
+    let mut pagination = serde_json::json!({ "next_cursor": next_cursor });
+    if let Some(total) = total {
+        pagination["total"] = serde_json::json!(total);
+    }
+    serde_json::json!({ "data": data, "pagination": pagination })
+}
+
+/// Keep only `fields` in a record's data. The record id always survives.
+pub fn project_record(record: &mut Record, fields: &[String]) {
+    record.data.retain(|k, _| fields.iter().any(|f| f == k));
+}
+
+/// Keep only `fields` (plus the `keep` identity key) in a JSON object.
+pub fn project_object(value: &mut serde_json::Value, fields: &[String], keep: &str) {
+    if let Some(map) = value.as_object_mut() {
+        map.retain(|k, _| k == keep || fields.iter().any(|f| f == k));
+    }
+}
+
+fn sort_value(record: &Record, field: &str) -> Option<serde_json::Value> {
+    if field == "id" {
+        return Some(serde_json::json!(record.id));
+    }
+    record
+        .data
+        .get(field)
+        .filter(|v| v.is_string() || v.is_number())
+        .cloned()
+}
+
+/// List `table` under `filters` per `q`: keyset paging for the envelope,
+/// `paginated_list` for the legacy shape.
+pub async fn fetch(
+    ctx: &dyn Context,
+    table: &str,
+    filters: Vec<Filter>,
+    q: &ListQuery,
+) -> Result<ListPage, WaferError> {
+    let sort = vec![
+        SortField {
+            field: q.sort_field.clone(),
+            desc: q.desc,
+        },
+        SortField {
+            field: "id".to_string(),
+            desc: q.desc,
+        },
+    ];
+
+    if !q.envelope {
+        let list = db::paginated_list(
+            ctx,
+            table,
+            i64::from(q.page),
+            i64::from(q.limit),
+            filters,
+            sort,
+        )
+        .await?;
+        return Ok(ListPage::Legacy(list));
+    }
+
+    let total = if q.with_total {
+        Some(db::count(ctx, table, &filters).await?)
+    } else {
+        None
+    };
+
+    // Filters are AND-only, so "after (k, id)" becomes a window on the sort
+    // column (`<= k` / `>= k`) plus an offset past the rows that tie on `k`
+    // and sort at or before the cursor's id.
+    let mut window = filters.clone();
+    let mut skip = 0;
+    if let Some(cursor) = &q.cursor {
+        let (bound, id_bound) = if q.desc {
+            (FilterOp::LessEqual, FilterOp::GreaterEqual)
+        } else {
+            (FilterOp::GreaterEqual, FilterOp::LessEqual)
+        };
+        window.push(Filter {
+            field: q.sort_field.clone(),
+            operator: bound,
+            value: cursor.key.clone(),
+        });
+        let mut ties = filters;
+        ties.push(Filter {
+            field: q.sort_field.clone(),
+            operator: FilterOp::Equal,
+            value: cursor.key.clone(),
+        });
+        if q.sort_field != "id" {
+            ties.push(Filter {
+                field: "id".to_string(),
+                operator: id_bound,
+                value: serde_json::json!(cursor.id),
+            });
+        }
+        skip = db::count(ctx, table, &ties).await?;
+    }
+
+    let opts = ListOptions {
+        filters: window,
+        sort,
+        limit: i64::from(q.limit) + 1,
+        offset: skip,
+        ..Default::default()
+    };
+    let mut records = db::list(ctx, table, &opts).await?.records;
+
+    let mut next_cursor = None;
+    if records.len() > q.limit as usize {
+        records.truncate(q.limit as usize);
+        if let Some(last) = records.last() {
+            next_cursor = sort_value(last, &q.sort_field)
+                .map(|key| encode_cursor(&q.sort_token(), key, &last.id));
+        }
+    }
+
+    Ok(ListPage::Cursor {
+        records,
+        next_cursor,
+        total,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashMap;
+
+    use super::*;
+    use crate::test_support::{admin_msg, output_json, output_status, TestContext};
+
+    const SPEC: ListSpec = ListSpec {
+        default_limit: 20,
+        max_limit: 100,
+        sortable: &["created_at", "email"],
+        default_sort: "created_at",
+        default_desc: true,
+    };
+
+    fn parse_with(pairs: &[(&str, &str)]) -> Result<ListQuery, ListQueryError> {
+        let map: HashMap<String, String> = pairs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect();
+        parse_params(|k| map.get(k).cloned().unwrap_or_default(), &SPEC)
+    }
+
+    #[test]
+    fn no_params_keeps_legacy_defaults() {
+        let q = parse_with(&[]).unwrap();
+        assert!(!q.envelope);
+        assert_eq!(q.limit, 20);
+        assert_eq!(q.page, 1);
+        assert_eq!((q.sort_field.as_str(), q.desc), ("created_at", true));
+        assert_eq!(q.fields, None);
+        assert!(!q.with_total);
+    }
+
+    #[test]
+    fn limit_is_clamped_and_validated() {
+        assert_eq!(parse_with(&[("limit", "5")]).unwrap().limit, 5);
+        assert_eq!(parse_with(&[("limit", "100000")]).unwrap().limit, 100);
+        assert_eq!(parse_with(&[("per_page", "500")]).unwrap().limit, 100);
+        assert_eq!(parse_with(&[("page_size", "7")]).unwrap().limit, 7);
+        for bad in ["0", "-1", "ten", "1.5"] {
+            let err = parse_with(&[("limit", bad)]).unwrap_err();
+            assert_eq!(err.param, "limit", "{bad}");
+        }
+        assert_eq!(parse_with(&[("page", "0")]).unwrap_err().param, "page");
+        assert!(parse_with(&[("limit", "5")]).unwrap().envelope);
+        assert!(!parse_with(&[("per_page", "5")]).unwrap().envelope);
+    }
+
+    #[test]
+    fn legacy_offset_follows_page() {
+        let q = parse_with(&[("page", "3"), ("per_page", "10")]).unwrap();
+        assert_eq!(q.offset(), 20);
+    }
+
+    #[test]
+    fn sort_accepts_only_allowlisted_fields() {
+        let q = parse_with(&[("sort", "email:asc")]).unwrap();
+        assert_eq!((q.sort_field.as_str(), q.desc), ("email", false));
+        let q = parse_with(&[("sort", "email")]).unwrap();
+        assert!(!q.desc, "direction defaults to asc");
+
+        let err = parse_with(&[("sort", "password_hash:asc")]).unwrap_err();
+        assert_eq!(err.param, "sort");
+        assert!(err.reason.contains("allowed: created_at, email"));
+        let err = parse_with(&[("sort", "email:sideways")]).unwrap_err();
+        assert_eq!(err.param, "sort");
+    }
+
+    #[test]
+    fn fields_are_parsed_and_validated() {
+        let q = parse_with(&[("fields", "email, name,email")]).unwrap();
+        assert_eq!(
+            q.fields,
+            Some(vec!["email".to_string(), "name".to_string()])
+        );
+        for bad in ["email,", "a b", "data->>'x'", "name;drop"] {
+            assert_eq!(parse_with(&[("fields", bad)]).unwrap_err().param, "fields");
+        }
+    }
+
+    #[test]
+    fn cursor_round_trips_for_the_same_sort() {
+        let raw = encode_cursor("created_at:desc", serde_json::json!("2026-01-01"), "u1");
+        let q = parse_with(&[("cursor", &raw)]).unwrap();
+        assert!(q.envelope);
+        let cursor = q.cursor.unwrap();
+        assert_eq!(cursor.key, "2026-01-01");
+        assert_eq!(cursor.id, "u1");
+    }
+
+    #[test]
+    fn tampered_cursors_are_rejected() {
+        let raw = encode_cursor("created_at:desc", serde_json::json!("2026-01-01"), "u1");
+
+        // Issued for another sort.
+        let err = parse_with(&[("cursor", &raw), ("sort", "email:asc")]).unwrap_err();
+        assert_eq!(err.param, "cursor");
+
+        // Bit-flipped / truncated / not base64 at all.
+        let mut flipped = raw.clone().into_bytes();
+        flipped[3] = if flipped[3] == b'A' { b'B' } else { b'A' };
+        let flipped = String::from_utf8(flipped).unwrap();
+        for bad in [
+            flipped.as_str(),
+            &raw[..raw.len() / 2],
+            "not a cursor!",
+            "%%%",
+        ] {
+            assert!(decode_cursor(bad, "created_at:desc").is_err(), "{bad}");
+        }
+
+        // Well-formed JSON with the wrong shape.
+        for payload in [
+            serde_json::json!({"s": "created_at:desc", "k": {"$gt": 1}, "i": "u1"}),
+            serde_json::json!({"s": "created_at:desc", "k": "x", "i": ""}),
+            serde_json::json!({"s": "created_at:desc", "k": "x", "i": "u1", "x": 1}),
+            serde_json::json!({"o": 10}),
+        ] {
+            let raw = Base64UrlUnpadded::encode_string(payload.to_string().as_bytes());
+            assert!(decode_cursor(&raw, "created_at:desc").is_err(), "{payload}");
+        }
+
+        let long = "A".repeat(MAX_CURSOR_LEN + 1);
+        assert!(decode_cursor(&long, "created_at:desc").is_err());
+    }
+
+    #[test]
+    fn offset_cursor_round_trips() {
+        assert_eq!(decode_offset_cursor(&encode_offset_cursor(150)), Ok(150));
+        let keyset = encode_cursor("created_at:desc", serde_json::json!(1), "u1");
+        assert!(decode_offset_cursor(&keyset).is_err());
+    }
+
+    #[tokio::test]
+    async fn error_response_is_validation_failed() {
+        let err = parse_with(&[("sort", "nope")]).unwrap_err();
+        assert_eq!(output_status(err.response()).await, 400);
+        let body = output_json(err.response()).await;
+        assert_eq!(body["code"], "validation_failed");
+        assert!(body["details"]["sort"].is_string());
+    }
+
+    #[test]
+    fn envelope_omits_uncounted_total() {
+        let body = envelope(serde_json::json!([]), None, None);
+        assert!(body["pagination"]["next_cursor"].is_null());
+        assert!(body["pagination"].get("total").is_none());
+        let body = envelope(serde_json::json!([]), Some("c".into()), Some(3));
+        assert_eq!(body["pagination"]["total"], 3);
+    }
+
+    #[tokio::test]
+    async fn keyset_pages_walk_every_row_once() {
+        let ctx = TestContext::new().await;
+        // Two rows share a rank to exercise the id tie-break.
+        for (email, rank) in [("a", "1"), ("b", "2"), ("c", "3"), ("d", "3"), ("e", "4")] {
+            let mut data = HashMap::new();
+            data.insert("email".to_string(), serde_json::json!(format!("{email}@x")));
+            data.insert("rank".to_string(), serde_json::json!(rank));
+            db::create(&ctx, "pagination_test", data).await.unwrap();
+        }
+        let spec = ListSpec {
+            sortable: &["rank"],
+            default_sort: "rank",
+            ..SPEC
+        };
+
+        let mut seen = Vec::new();
+        let mut cursor = String::new();
+        loop {
+            let mut params = vec![("limit", "2"), ("total", "true")];
+            if !cursor.is_empty() {
+                params.push(("cursor", cursor.as_str()));
+            }
+            let map: HashMap<&str, &str> = params.into_iter().collect();
+            let q = parse_params(|k| map.get(k).unwrap_or(&"").to_string(), &spec).unwrap();
+            let page = fetch(&ctx, "pagination_test", Vec::new(), &q)
+                .await
+                .unwrap();
+            let body = page.into_json(Some(&["email".to_string()][..]));
+            assert_eq!(body["pagination"]["total"], 5);
+            for row in body["data"].as_array().unwrap() {
+                assert!(row["data"].get("rank").is_none(), "projected away");
+                seen.push(row["data"]["email"].as_str().unwrap().to_string());
+            }
+            match body["pagination"]["next_cursor"].as_str() {
+                Some(next) => cursor = next.to_string(),
+                None => break,
+            }
+        }
+        seen.sort();
+        assert_eq!(seen, ["a@x", "b@x", "c@x", "d@x", "e@x"]);
+
+        // Without limit/cursor the legacy RecordList shape comes back.
+        let q = parse_with(&[]).unwrap();
+        let body = fetch(&ctx, "pagination_test", Vec::new(), &q)
+            .await
+            .unwrap()
+            .into_json(None);
+        assert_eq!(body["total_count"], 5);
+        assert_eq!(body["records"].as_array().unwrap().len(), 5);
+    }
+
+    #[test]
+    fn parse_offset_reads_offset_cursors() {
+        let mut msg = admin_msg("retrieve", "/b/storage/buckets/b/objects");
+        msg.set_meta("req.query.cursor", &encode_offset_cursor(40));
+        let (q, offset) = parse_offset(&msg, &SPEC).unwrap();
+        assert!(q.envelope);
+        assert_eq!(offset, 40);
+
+        let mut msg = admin_msg("retrieve", "/b/storage/buckets/b/objects");
+        let keyset = encode_cursor("created_at:desc", serde_json::json!("x"), "u1");
+        msg.set_meta("req.query.cursor", &keyset);
+        assert_eq!(parse_offset(&msg, &SPEC).unwrap_err().param, "cursor");
+
+        let mut msg = admin_msg("retrieve", "/b/storage/buckets/b/objects");
+        msg.set_meta("req.query.page", "3");
+        msg.set_meta("req.query.per_page", "10");
+        let (q, offset) = parse_offset(&msg, &SPEC).unwrap();
+        assert!(!q.envelope);
+        assert_eq!(offset, 20);
+    }
+
+    #[tokio::test]
+    async fn parse_reads_the_request_query() {
+        let mut msg = admin_msg("retrieve", "/admin/users");
+        msg.set_meta("req.query.limit", "3");
+        msg.set_meta("req.query.sort", "email:asc");
+        let q = parse(&msg, &SPEC).unwrap();
+        assert_eq!((q.limit, q.envelope, q.desc), (3, true, false));
+    }
+}
@@ -3,9 +3,17 @@
 //! Both Cloudflare and native adapters call `handle_request()` after
 //! converting their platform-specific HTTP types into a WAFER Message.
 
-use std::cell::{Cell, RefCell};
+use std::{
+    cell::{Cell, RefCell},
+    future::Future,
+    sync::OnceLock,
+    time::Duration,
+};
 
-use futures::StreamExt;
+use futures::{
+    future::{BoxFuture, Either},
+    StreamExt,
+};
 use wafer_block::{
     http_codec::{self, ResponseMetaPart},
     stream::StreamEvent,
@@ -19,6 +27,8 @@ use wafer_run::{
 };
 
 use crate::{
+    block_metrics,
+    blocks::{api_quota::QuotaOutcome, errors},
     features::FeatureConfig,
     http::ResponseBuilder,
     routing::{self, ExtraRoute},
@@ -73,16 +83,137 @@ pub fn drain_queued_request_logs() -> Vec<QueuedRequestLog> {
     REQUEST_LOG_QUEUE.with(|q| std::mem::take(&mut *q.borrow_mut()))
 }
 
+/// Where request-log rows go besides (or instead of) `request_logs`.
+pub struct RequestLogSink {
+    /// Called with every row on the response path; must not block.
+    pub forward: Box<dyn Fn(&std::collections::HashMap<String, serde_json::Value>) + Send + Sync>,
+    /// Whether rows are still written to `request_logs` as well.
+    pub database: bool,
+}
+
+static REQUEST_LOG_SINK: OnceLock<RequestLogSink> = OnceLock::new();
+
+/// Route request-log rows to an external sink. Native installs one at boot
+/// when `SOLOBASE_REQUEST_LOG_SINKS` names anything besides `database`;
+/// without it every row goes to `request_logs`. Only the first call wins.
+pub fn set_request_log_sink(sink: RequestLogSink) {
+    let _ = REQUEST_LOG_SINK.set(sink);
+}
+
+/// Config key for the per-request handler timeout, in milliseconds.
+pub const REQUEST_TIMEOUT_KEY: &str = "SOLOBASE_SHARED__REQUEST_TIMEOUT_MS";
+
+/// Handler timeout applied when [`REQUEST_TIMEOUT_KEY`] is unset.
+pub const DEFAULT_REQUEST_TIMEOUT: Duration = Duration::from_secs(60);
+
+/// Platform sleep used to enforce the handler timeout.
+pub type RequestTimer = fn(Duration) -> BoxFuture<'static, ()>;
+
+static REQUEST_TIMER: OnceLock<RequestTimer> = OnceLock::new();
+
+/// Install the platform's sleep so the pipeline can bound handler time.
+/// solobase-core is runtime-agnostic and has no timer of its own: native
+/// installs `tokio::time::sleep` at boot, while Cloudflare leaves it unset
+/// and relies on the Workers platform's own request limits. Only the first
+/// call wins.
+pub fn set_request_timer(timer: RequestTimer) {
+    let _ = REQUEST_TIMER.set(timer);
+}
+
+/// The timer [`set_request_timer`] installed, if any.
+pub(crate) fn request_timer() -> Option<RequestTimer> {
+    REQUEST_TIMER.get().copied()
+}
+
+/// The handler timeout for `(action, path)`: `None` when no timer is
+/// installed, the timeout is configured as `0`, or the route is one of the
+/// [`routing::LONG_RUNNING`] endpoints. A value that isn't a number of
+/// milliseconds keeps [`DEFAULT_REQUEST_TIMEOUT`] (with a warning) rather
+/// than turning the timeout off.
+fn handler_timeout(ctx: &dyn Context, action: &str, path: &str) -> Option<Duration> {
+    REQUEST_TIMER.get()?;
+    if routing::is_long_running(action, path) {
+        return None;
+    }
+    let limit = match ctx.config_get(REQUEST_TIMEOUT_KEY) {
+        Some(raw) if !raw.trim().is_empty() => match raw.trim().parse() {
+            Ok(ms) => Duration::from_millis(ms),
+            Err(_) => {
+                tracing::warn!(
+                    key = REQUEST_TIMEOUT_KEY,
+                    value = %raw,
+                    "invalid request timeout; using the default"
+                );
+                DEFAULT_REQUEST_TIMEOUT
+            }
+        },
+        _ => DEFAULT_REQUEST_TIMEOUT,
+    };
+    (!limit.is_zero()).then_some(limit)
+}
+
+/// The `maintenance_mode` (503) reply for `msg` while
+/// [`crate::runtime_config::RuntimeConfig::maintenance_mode`] is on, unless
+/// the request is a read, comes from an admin, or belongs to the sign-in
+/// flows an admin needs to get in and lift it.
+fn maintenance_gate(msg: &Message) -> Option<OutputStream> {
+    if !crate::runtime_config::load().maintenance_mode
+        || msg.action() == "retrieve"
+        || crate::util::is_admin(msg)
+        || msg.path().starts_with("/b/auth/")
+    {
+        return None;
+    }
+    Some(errors::error_json(
+        errors::ErrorCode::MaintenanceMode,
+        "The service is in maintenance mode; changes are temporarily disabled",
+        None,
+    ))
+}
+
+/// Run `fut` to completion, or give up after `limit`. Returns `None` on
+/// timeout; `fut` is dropped at that point, which cancels whatever database
+/// or storage call the handler was awaiting.
+async fn within_timeout<F: Future>(limit: Option<Duration>, fut: F) -> Option<F::Output> {
+    let (Some(limit), Some(sleep)) = (limit, REQUEST_TIMER.get()) else {
+        return Some(fut.await);
+    };
+    match futures::future::select(std::pin::pin!(fut), sleep(limit)).await {
+        Either::Left((out, _)) => Some(out),
+        Either::Right(((), _)) => None,
+    }
+}
+
+/// What the routed block answered, as far as the pipeline needs to know
+/// before deciding how to reply.
+enum Routed {
+    /// A streaming response: its leading meta, the first body event and
+    /// the rest of the stream, forwarded without buffering.
+    Streaming(Vec<MetaEntry>, Option<StreamEvent>, OutputStream),
+    /// A fully collected response (or its non-response terminal).
+    Buffered(Result<BufferedResponse, TerminalNotResponse>),
+}
+
 /// Handle a solobase request.
 ///
 /// This is the shared entry point that both CF and native adapters call
 /// after building a Message from the incoming HTTP request.
 ///
 /// Steps:
-/// 1. Strip `/api` prefix (CF convention — native doesn't use it)
-/// 2. Validate JWT and set auth meta
-/// 3. Route to the appropriate solobase block
-/// 4. Log the request to `request_logs` (async, best-effort)
+/// 0. Keep the raw path (see [`routing::META_RAW_PATH`]), then normalize
+///    it (see [`routing::normalize_path`]); a path that can't be
+///    normalized is refused with 400. Under a base path the prefix is taken
+///    off (see [`crate::base_path`])
+/// 1. Strip the `/api/v1` prefix, or the deprecated `/api` alias when the
+///    policy keeps it (see [`crate::api_version`])
+/// 2. Validate JWT and set auth meta, answer the developer-mode namespace
+///    (see [`crate::dev_mode`]), then apply maintenance mode, usage quotas
+///    and any simulated latency or failure
+/// 3. Route to the appropriate solobase block, bounded by the handler
+///    timeout (504 when it runs out)
+/// 4. Translate an error's message into the caller's locale (see
+///    [`crate::i18n`]), prefix redirects and HTML for a base path,
+///    compress, and log the request to `request_logs` (async, best-effort)
 ///
 /// # Errors
 ///
@@ -104,12 +235,31 @@ pub async fn handle_request(
     block_infos: &[BlockInfo],
     extra_routes: &[ExtraRoute],
 ) -> OutputStream {
-    // 0. Discovery endpoints — public, no auth required
-    let path = msg.path();
-    if path == "/openapi.json" || path == "/.well-known/agent.json" {
+    // 0. Every later step matches on the path, so canonicalize it first:
+    //    `//`, `.` and `..` segments must not steer a request past the
+    //    prefix it actually names. What the client sent is kept aside for
+    //    code that must see it verbatim (see `routing::META_RAW_PATH`).
+    let raw_path = msg.path().to_string();
+    msg.set_meta(routing::META_RAW_PATH, &raw_path);
+    let Some(normalized) = routing::normalize_path(msg.path()) else {
+        return crate::http::err_bad_request("Invalid request path");
+    };
+    msg.set_meta(META_REQ_RESOURCE, &normalized);
+    let base_path = msg.get_meta(crate::base_path::META).to_string();
+    if let Some(rest) = crate::base_path::strip(&base_path, &normalized) {
+        msg.set_meta(META_REQ_RESOURCE, rest);
+    }
+
+    // Discovery endpoints — public, no auth required
+    let path = crate::api_version::unversioned(msg.path()).into_owned();
+    if path == "/openapi.json"
+        || path == "/.well-known/agent.json"
+        || path == crate::events::SCHEMA_PATH
+    {
         let is_openapi = path == "/openapi.json";
         let host = msg.header("host").to_string();
-        let server_url = format!("https://{host}");
+        // Clients generated from these documents target the versioned API.
+        let server_url = format!("https://{host}{base_path}{}", crate::api_version::V1_PREFIX);
         // The project/display name for the discovery documents (OpenAPI
         // `info.title` and the agent-card `name`). Previously this was
         // derived from the `Host` header (`host.split('.').next()`), which
@@ -117,13 +267,15 @@ pub async fn handle_request(
         // yielded the literal title `"127"`. `SOLOBASE_SHARED__APP_NAME` is
         // the existing single-sourced display-name config var (already used
         // for emails, the login page, and the browser `<title>` — see
-        // `blocks/email.rs`, `ui/mod.rs`), so discovery documents reuse it
+        // `blocks/email/mod.rs`, `ui/mod.rs`), so discovery documents reuse it
         // instead of inventing a second name knob; it falls back to the
         // constant `"Solobase"`, never to the host.
         let project_name =
             config_client::get_default(ctx, "SOLOBASE_SHARED__APP_NAME", "Solobase").await;
 
-        let body = if is_openapi {
+        let body = if path == crate::events::SCHEMA_PATH {
+            crate::events::catalog_json()
+        } else if is_openapi {
             wafer_core::discovery::generate_openapi(block_infos, &project_name, "", &server_url)
         } else {
             wafer_core::discovery::generate_agent_card(block_infos, &project_name, "", &server_url)
@@ -148,10 +300,29 @@ pub async fn handle_request(
         return resp.json(&body);
     }
 
-    // 1. Strip /api prefix from resource path
+    // 1. Strip the API prefix from the resource path — only as a whole
+    //    segment, so `/apifoo` isn't read as `foo`. The unversioned alias
+    //    is marked deprecated on the way out, or refused when the
+    //    deployment serves only `/api/v1`.
     let resource = msg.path().to_string();
-    if let Some(stripped) = resource.strip_prefix("/api") {
-        msg.set_meta(META_REQ_RESOURCE, stripped);
+    let mut version_headers = Vec::new();
+    match crate::api_version::classify(&resource) {
+        crate::api_version::Addressed::Versioned(rest) => msg.set_meta(META_REQ_RESOURCE, rest),
+        crate::api_version::Addressed::Alias(_)
+            if crate::api_version::policy()
+                == crate::api_version::ApiVersionPolicy::VersionedOnly =>
+        {
+            return errors::error_json(
+                errors::ErrorCode::NotFound,
+                "The API is served under /api/v1",
+                None,
+            );
+        }
+        crate::api_version::Addressed::Alias(rest) => {
+            version_headers = crate::api_version::alias_headers(&msg, rest);
+            msg.set_meta(META_REQ_RESOURCE, rest);
+        }
+        crate::api_version::Addressed::Bare => {}
     }
 
     // 2. Validate JWT or API key and set auth meta
@@ -166,43 +337,140 @@ pub async fn handle_request(
                 .await;
         } else if let Some(api_key) = header.strip_prefix("ApiKey ") {
             crate::blocks::auth::authenticate_api_key(ctx, api_key, &mut msg).await;
+        } else if header.starts_with(crate::blocks::auth::sigv4::ALGORITHM) {
+            // S3 clients calling the storage gateway (`/s3/`).
+            crate::blocks::auth::authenticate_s3(ctx, header, &mut msg).await;
         }
     }
 
+    // Developer mode's `/_dev/` namespace, when it's installed. It runs
+    // after auth so the echo can show who the caller resolved to.
+    if crate::dev_mode::serves(msg.path()) {
+        return crate::dev_mode::handle(&msg, input).await;
+    }
+
+    // 2a. Confine accounts with a pending forced password change (the
+    //     env-bootstrapped admin) to the change-password flow.
+    if let Some(blocked) = crate::blocks::auth::helpers::password_change_gate(ctx, &msg).await {
+        return blocked;
+    }
+
+    // 2b. Maintenance mode: non-admins keep read access and the sign-in
+    //     flows, but every other mutation is refused until it's lifted.
+    if let Some(paused) = maintenance_gate(&msg) {
+        return paused;
+    }
+
+    // 2c. Count quota-tagged routes against the caller's usage quota; the
+    //     X-Quota-* headers ride on whatever the block answers.
+    let mut quota_headers = match crate::blocks::api_quota::enforce(ctx, &msg).await {
+        QuotaOutcome::Exceeded(resp) => return resp,
+        QuotaOutcome::Counted(headers) => headers,
+        QuotaOutcome::Untracked => Vec::new(),
+    };
+
+    // 2d. Developer mode's simulated latency and failures. Both are marked
+    //     on the response so they can't be mistaken for real ones.
+    let simulation = crate::dev_mode::simulate(msg.path());
+    if let (Some(delay), Some(sleep)) = (simulation.delay, REQUEST_TIMER.get()) {
+        sleep(delay).await;
+    }
+    if let Some(failed) = simulation.failure_response() {
+        return failed;
+    }
+    let mut dev_headers = simulation.headers();
+
     // Capture request info before routing (for logging)
     let method = msg.action().to_string();
     let path = msg.path().to_string();
     let client_ip = msg.remote_addr().to_string();
     let user_id = msg.user_id().to_string();
+    let accept_encoding = msg.header("accept-encoding").to_string();
+    let accept_language = msg.header("accept-language").to_string();
     let start_ms = crate::util::now_millis();
+    let metrics_block = routing::block_for(&path, extra_routes);
 
-    // 3. Route to block.
-    let mut stream =
-        routing::route_to_block(ctx, msg, input, features, block_infos, extra_routes).await;
-
-    // 3a. If the block declares a streaming Content-Type up front (SSE, raw
-    //     byte stream), don't drain the response into memory just to grab a
-    //     status code for the audit log. The whole point of those formats is
-    //     bytes flowing while the producer is still working — buffering
-    //     defeats that. Skip request_logs for these responses; the trade is
-    //     intentional and acceptable for v1 (callers reach for SSE for
-    //     long-lived progress / chat streams which aren't the audit-worthy
-    //     short request/responses that request_logs is built for).
-    let (leading_meta, next_event) = drain_leading_meta(&mut stream).await;
-    if let Some(ct) = leading_content_type(&leading_meta) {
-        if is_streaming_content_type(ct) {
+    // 3. Route to block. The handler gets until the first streamed event or
+    //    the complete buffered response to answer; a streaming body is not
+    //    bounded once it has started.
+    //
+    //    If the block declares a streaming Content-Type up front (SSE, raw
+    //    byte stream) or asks for streaming outright, don't drain the response into memory just to grab a
+    //    status code for the audit log. The whole point of those formats is
+    //    bytes flowing while the producer is still working — buffering
+    //    defeats that. Skip request_logs for these responses; the trade is
+    //    intentional and acceptable for v1 (callers reach for SSE for
+    //    long-lived progress / chat streams which aren't the audit-worthy
+    //    short request/responses that request_logs is built for).
+    let timeout = handler_timeout(ctx, &method, &path);
+    let dispatch = async {
+        let mut stream =
+            routing::route_to_block(ctx, msg, input, features, block_infos, extra_routes).await;
+        let (leading_meta, next_event) = drain_leading_meta(&mut stream).await;
+        if is_streaming(&leading_meta) {
+            return Routed::Streaming(leading_meta, next_event, stream);
+        }
+        Routed::Buffered(collect_buffered_with_prelude(stream, leading_meta, next_event).await)
+    };
+    let collected = match within_timeout(timeout, dispatch).await {
+        Some(Routed::Streaming(mut leading_meta, next_event, stream)) => {
+            leading_meta.retain(|m| m.key != META_RESP_STREAM);
+            let status = i64::from(http_codec::resolve_status(&leading_meta, 200));
+            crate::status::record_request(status);
+            if let Some(block) = metrics_block {
+                let elapsed = crate::util::now_millis().saturating_sub(start_ms);
+                block_metrics::record(block, status, elapsed);
+            }
+            leading_meta.append(&mut quota_headers);
+            leading_meta.append(&mut dev_headers);
+            leading_meta.append(&mut version_headers);
             return rebuild_streaming(leading_meta, next_event, stream);
         }
-    }
+        Some(Routed::Buffered(collected)) => Some(collected),
+        None => {
+            tracing::warn!(
+                method = %method,
+                path = %path,
+                elapsed_ms = crate::util::now_millis().saturating_sub(start_ms),
+                "request exceeded the handler timeout"
+            );
+            None
+        }
+    };
 
     let (status_label, status_code, error_message, reply): (
         &'static str,
         i64,
         String,
         OutputStream,
-    ) = match collect_buffered_with_prelude(stream, leading_meta, next_event).await {
-        Ok(buf) => {
+    ) = match collected {
+        None => (
+            "ERROR",
+            i64::from(errors::ErrorCode::RequestTimeout.status_code()),
+            "request timed out".to_string(),
+            errors::error_json(errors::ErrorCode::RequestTimeout, "Request timed out", None),
+        ),
+        Some(Ok(mut buf)) => {
             let code = i64::from(http_codec::resolve_status(&buf.meta, 200));
+            if code >= 400
+                && leading_content_type(&buf.meta)
+                    .is_some_and(|ct| ct.starts_with("application/json"))
+            {
+                let (translations, locale) = request_locale(ctx, &user_id, &accept_language).await;
+                translations.localize_error_body(&locale, &mut buf.body);
+            }
+            buf.meta.append(&mut quota_headers);
+            buf.meta.append(&mut dev_headers);
+            buf.meta.append(&mut version_headers);
+            crate::base_path::rewrite_response(&base_path, &mut buf.body, &mut buf.meta);
+            crate::compression::apply(
+                ctx,
+                &method,
+                &path,
+                &accept_encoding,
+                &mut buf.body,
+                &mut buf.meta,
+            );
             (
                 "OK",
                 code,
@@ -210,15 +478,21 @@ pub async fn handle_request(
                 replay_buffered(buf.body, buf.meta),
             )
         }
-        Err(TerminalNotResponse::Error(err)) => {
+        Some(Err(TerminalNotResponse::Error(mut err))) => {
             let message = err.message.clone();
+            if err.detail_code().is_some() {
+                let (translations, locale) = request_locale(ctx, &user_id, &accept_language).await;
+                translations.localize_error(&locale, &mut err);
+            }
             ("ERROR", 500, message, OutputStream::error(err))
         }
-        Err(TerminalNotResponse::Drop) => ("OK", 204, String::new(), OutputStream::drop_request()),
-        Err(TerminalNotResponse::Continue(m)) => {
+        Some(Err(TerminalNotResponse::Drop)) => {
+            ("OK", 204, String::new(), OutputStream::drop_request())
+        }
+        Some(Err(TerminalNotResponse::Continue(m))) => {
             ("OK", 200, String::new(), OutputStream::continue_with(m))
         }
-        Err(TerminalNotResponse::Malformed) => (
+        Some(Err(TerminalNotResponse::Malformed)) => (
             "ERROR",
             500,
             "stream ended without terminal event".to_string(),
@@ -228,7 +502,7 @@ pub async fn handle_request(
                 meta: vec![],
             }),
         ),
-        Err(TerminalNotResponse::Halt(buf)) => {
+        Some(Err(TerminalNotResponse::Halt(buf))) => {
             let code = i64::from(http_codec::resolve_status(&buf.meta, 200));
             (
                 "OK",
@@ -246,6 +520,22 @@ pub async fn handle_request(
     let duration_ms =
         i64::try_from(crate::util::now_millis().saturating_sub(start_ms)).unwrap_or(i64::MAX);
 
+    crate::status::record_request(status_code);
+    if let Some(block) = metrics_block {
+        block_metrics::record(block, status_code, duration_ms as u64);
+    }
+
+    let slow_ms = crate::runtime_config::load().slow_query_ms;
+    if slow_ms > 0 && duration_ms >= i64::try_from(slow_ms).unwrap_or(i64::MAX) {
+        tracing::warn!(
+            method = %method,
+            path = %path,
+            duration_ms,
+            threshold_ms = slow_ms,
+            "slow request"
+        );
+    }
+
     // Skip logging static asset requests to reduce noise (one request_logs
     // write per CSS/JS/font/logo fetch otherwise). The prefix is the shared
     // `routing::STATIC_PREFIX` const so it can't drift from the routing
@@ -263,15 +553,25 @@ pub async fn handle_request(
         );
         data.insert("client_ip".to_string(), serde_json::json!(client_ip));
         data.insert("user_id".to_string(), serde_json::json!(user_id));
+        data.insert(
+            "actor_type".to_string(),
+            serde_json::json!(crate::blocks::auth::repo::users::actor_type(&user_id)),
+        );
         crate::util::stamp_created(&mut data);
 
-        match request_log_mode() {
-            RequestLogMode::Inline => {
-                // Best-effort: don't fail the request if logging fails
-                let _ = db::create(ctx, crate::blocks::admin::REQUEST_LOGS_TABLE, data).await;
-            }
-            RequestLogMode::Queued => {
-                enqueue_request_log(crate::blocks::admin::REQUEST_LOGS_TABLE, data);
+        let sink = REQUEST_LOG_SINK.get();
+        if let Some(sink) = sink {
+            (sink.forward)(&data);
+        }
+        if sink.is_none_or(|s| s.database) {
+            match request_log_mode() {
+                RequestLogMode::Inline => {
+                    // Best-effort: don't fail the request if logging fails
+                    let _ = db::create(ctx, crate::blocks::admin::REQUEST_LOGS_TABLE, data).await;
+                }
+                RequestLogMode::Queued => {
+                    enqueue_request_log(crate::blocks::admin::REQUEST_LOGS_TABLE, data);
+                }
             }
         }
     }
@@ -279,6 +579,20 @@ pub async fn handle_request(
     reply
 }
 
+/// The catalog and the locale to answer `user_id` in (see [`crate::i18n`]).
+/// Only error responses are translated, so only they pay for the profile
+/// read.
+async fn request_locale(
+    ctx: &dyn Context,
+    user_id: &str,
+    accept_language: &str,
+) -> (std::sync::Arc<crate::i18n::Translations>, String) {
+    let translations = crate::i18n::load(ctx).await;
+    let preference = crate::i18n::user_preference(ctx, user_id).await;
+    let locale = translations.negotiate(&preference, accept_language);
+    (translations, locale)
+}
+
 /// Rebuild an `OutputStream` from an already-collected buffered response.
 /// Used by the pipeline after intercepting the stream for logging.
 fn replay_buffered(body: Vec<u8>, meta: Vec<MetaEntry>) -> OutputStream {
@@ -310,6 +624,18 @@ fn leading_content_type(meta: &[MetaEntry]) -> Option<&str> {
     })
 }
 
+/// Leading response meta that asks for the body to be streamed whatever its
+/// content type. Blocks set it on downloads that account for the bytes the
+/// client actually took (share links); the pipeline drops it before the
+/// response goes out.
+pub const META_RESP_STREAM: &str = "resp.stream";
+
+/// Whether a response with these leading meta entries streams its body.
+fn is_streaming(meta: &[MetaEntry]) -> bool {
+    meta.iter().any(|m| m.key == META_RESP_STREAM)
+        || leading_content_type(meta).is_some_and(is_streaming_content_type)
+}
+
 /// True for content-types that should stream body chunks to the client as
 /// they're produced rather than buffer the entire response. Today: SSE and
 /// generic byte streams (which feature blocks use for downloads / archives).
@@ -321,7 +647,7 @@ fn is_streaming_content_type(ct: &str) -> bool {
 /// Forward one `StreamEvent` into an `OutputSink`. Returns the sink back for
 /// non-terminal events so the caller can keep pumping; terminal events (and
 /// a hung-up consumer) consume it and return `None`.
-async fn forward_event(sink: OutputSink, ev: StreamEvent) -> Option<OutputSink> {
+pub(crate) async fn forward_event(sink: OutputSink, ev: StreamEvent) -> Option<OutputSink> {
     match ev {
         StreamEvent::Chunk(bytes) => sink.send_chunk(bytes).await.ok().map(|()| sink),
         StreamEvent::Meta(entry) => sink.send_meta(entry).await.ok().map(|()| sink),
@@ -525,6 +851,37 @@ mod discovery_tests {
         );
     }
 
+    #[tokio::test]
+    async fn event_schemas_are_served_without_auth() {
+        let ctx = TestContext::new().await;
+        let body = discovery_json(&ctx, "/api/events/schema", "127.0.0.1:8093").await;
+        let quota = body["events"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|e| e["name"] == "files.quota_threshold")
+            .unwrap_or_else(|| panic!("quota event missing: {body}"));
+        assert_eq!(quota["version"], 1);
+        assert_eq!(quota["schema"]["type"], "object");
+        assert_eq!(quota["example"]["threshold"], 80);
+
+        let versioned = discovery_json(&ctx, "/api/v1/events/schema", "127.0.0.1:8093").await;
+        assert_eq!(versioned, body);
+    }
+
+    #[tokio::test]
+    async fn discovery_documents_target_the_versioned_api() {
+        let ctx = TestContext::new().await;
+        for path in ["/openapi.json", "/.well-known/agent.json"] {
+            let body = discovery_json(&ctx, path, "solobase.example.com").await;
+            assert!(
+                body.to_string()
+                    .contains("\"https://solobase.example.com/api/v1\""),
+                "{path} must point clients at /api/v1: {body}"
+            );
+        }
+    }
+
     #[tokio::test]
     async fn openapi_documents_core_auth_endpoints_with_schemas() {
         let ctx = TestContext::new().await;
@@ -671,6 +1028,237 @@ mod discovery_tests {
     }
 }
 
+#[cfg(test)]
+mod request_timeout_tests {
+    use std::{sync::Arc, time::Duration};
+
+    use futures::future::BoxFuture;
+    use wafer_run::{
+        context::Context, Block, BlockCategory, BlockInfo, InputStream, LifecycleEvent, Message,
+        OutputStream, WaferError,
+    };
+
+    use super::{
+        handle_request, handler_timeout, set_request_timer, DEFAULT_REQUEST_TIMEOUT,
+        REQUEST_TIMEOUT_KEY,
+    };
+    use crate::{
+        block_metrics,
+        features::AllEnabled,
+        test_support::{anon_msg, output_json, output_status, TestContext},
+    };
+
+    /// Stands in for `suppers-ai/files`: answers 200 after a fixed delay.
+    struct SlowBlock(Duration);
+
+    #[async_trait::async_trait]
+    impl Block for SlowBlock {
+        fn info(&self) -> BlockInfo {
+            BlockInfo::new("suppers-ai/files", "0.0.1", "http-handler@v1", "slow")
+                .category(BlockCategory::Service)
+        }
+
+        async fn handle(
+            &self,
+            _ctx: &dyn Context,
+            _msg: Message,
+            _in: InputStream,
+        ) -> OutputStream {
+            tokio::time::sleep(self.0).await;
+            crate::http::ok_json(&serde_json::json!({"done": true}))
+        }
+
+        async fn lifecycle(
+            &self,
+            _ctx: &dyn Context,
+            _e: LifecycleEvent,
+        ) -> Result<(), WaferError> {
+            Ok(())
+        }
+    }
+
+    fn tokio_sleep(d: Duration) -> BoxFuture<'static, ()> {
+        Box::pin(tokio::time::sleep(d))
+    }
+
+    async fn slow_ctx(timeout_ms: &str) -> TestContext {
+        set_request_timer(tokio_sleep);
+        let mut ctx = TestContext::new().await;
+        ctx.register_block(
+            "suppers-ai/files",
+            Arc::new(SlowBlock(Duration::from_millis(300))),
+        );
+        ctx.set_config(REQUEST_TIMEOUT_KEY, timeout_ms);
+        ctx
+    }
+
+    async fn send(ctx: &TestContext, action: &str, path: &str) -> OutputStream {
+        handle_request(
+            ctx,
+            anon_msg(action, path),
+            InputStream::empty(),
+            None,
+            "test-jwt-secret",
+            &AllEnabled,
+            &[],
+            &[],
+        )
+        .await
+    }
+
+    #[tokio::test]
+    async fn slow_handler_times_out_with_504_envelope() {
+        let ctx = slow_ctx("50").await;
+        let errors = || block_metrics::get("suppers-ai/files").map_or(0, |m| m.errors);
+        let errors_before = errors();
+        let started = std::time::Instant::now();
+        let out = send(&ctx, "retrieve", "/b/storage/api/buckets").await;
+        assert!(started.elapsed() < Duration::from_millis(300));
+        assert_eq!(output_status(out).await, 504);
+        // Other tests share the process-wide counters, so only a lower bound
+        // holds.
+        assert!(
+            errors() > errors_before,
+            "a timeout counts as a block error"
+        );
+
+        let body = output_json(send(&ctx, "retrieve", "/b/storage/api/buckets").await).await;
+        assert_eq!(body["code"], "request_timeout");
+    }
+
+    #[tokio::test]
+    async fn long_running_routes_are_exempt() {
+        let ctx = slow_ctx("50").await;
+        // A download: uploads need `storage.write`, which anonymous callers
+        // never hold.
+        let path = "/b/storage/api/buckets/docs/objects/report.pdf";
+        let out = send(&ctx, "retrieve", path).await;
+        assert_eq!(output_status(out).await, 200);
+    }
+
+    #[tokio::test]
+    async fn zero_disables_the_timeout() {
+        let ctx = slow_ctx("0").await;
+        let out = send(&ctx, "retrieve", "/b/storage/api/buckets").await;
+        assert_eq!(output_status(out).await, 200);
+    }
+
+    #[tokio::test]
+    async fn an_unreadable_value_keeps_the_default() {
+        for raw in ["50ms", "-1", "off", " "] {
+            let ctx = slow_ctx(raw).await;
+            let limit = handler_timeout(&ctx, "retrieve", "/b/storage/api/buckets");
+            assert_eq!(limit, Some(DEFAULT_REQUEST_TIMEOUT), "{raw:?}");
+        }
+        let ctx = slow_ctx(" 50 ").await;
+        let limit = handler_timeout(&ctx, "retrieve", "/b/storage/api/buckets");
+        assert_eq!(limit, Some(Duration::from_millis(50)));
+    }
+}
+
+#[cfg(test)]
+mod compression_tests {
+    use std::sync::Arc;
+
+    use wafer_run::{
+        context::Context, Block, BlockCategory, BlockInfo, InputStream, LifecycleEvent, Message,
+        MetaEntry, OutputStream, WaferError, META_RESP_CONTENT_TYPE,
+    };
+
+    use super::handle_request;
+    use crate::{
+        compression::{self, CompressionOptions},
+        features::AllEnabled,
+        test_support::{anon_msg, collect_or_panic, TestContext},
+    };
+
+    /// Stands in for `suppers-ai/files`: a large JSON listing, or the same
+    /// bytes as an SSE stream or a byte-stream download.
+    struct BodyBlock;
+
+    fn body() -> Vec<u8> {
+        format!("[{}]", ["{\"key\":\"docs/report.pdf\"}"; 500].join(",")).into_bytes()
+    }
+
+    fn streamed(content_type: &'static str) -> OutputStream {
+        OutputStream::from_producer(move |sink, _cancel| async move {
+            let _ = sink
+                .send_meta(MetaEntry {
+                    key: META_RESP_CONTENT_TYPE.to_string(),
+                    value: content_type.to_string(),
+                })
+                .await;
+            if sink.send_chunk(body()).await.is_ok() {
+                let _ = sink.complete(Vec::new()).await;
+            }
+        })
+    }
+
+    #[async_trait::async_trait]
+    impl Block for BodyBlock {
+        fn info(&self) -> BlockInfo {
+            BlockInfo::new("suppers-ai/files", "0.0.1", "http-handler@v1", "body")
+                .category(BlockCategory::Service)
+        }
+
+        async fn handle(&self, _ctx: &dyn Context, msg: Message, _in: InputStream) -> OutputStream {
+            match msg.path() {
+                "/b/storage/api/events" => streamed("text/event-stream"),
+                "/b/storage/api/download" => streamed("application/octet-stream"),
+                _ => crate::http::ResponseBuilder::new().body(body(), "application/json"),
+            }
+        }
+
+        async fn lifecycle(
+            &self,
+            _ctx: &dyn Context,
+            _e: LifecycleEvent,
+        ) -> Result<(), WaferError> {
+            Ok(())
+        }
+    }
+
+    async fn send(ctx: &TestContext, path: &str) -> (Vec<u8>, Option<String>) {
+        let mut msg = anon_msg("retrieve", path);
+        msg.set_meta("http.header.accept-encoding", "gzip");
+        let out = handle_request(
+            ctx,
+            msg,
+            InputStream::empty(),
+            None,
+            "test-jwt-secret",
+            &AllEnabled,
+            &[],
+            &[],
+        )
+        .await;
+        let buf = collect_or_panic(out).await;
+        let encoding = buf
+            .meta
+            .iter()
+            .find(|m| m.key.eq_ignore_ascii_case("resp.header.content-encoding"))
+            .map(|m| m.value.clone());
+        (buf.body, encoding)
+    }
+
+    #[tokio::test]
+    async fn buffered_json_is_compressed_but_streams_pass_through() {
+        compression::install(CompressionOptions::default());
+        let mut ctx = TestContext::new().await;
+        ctx.register_block("suppers-ai/files", Arc::new(BodyBlock));
+
+        let (listing, encoding) = send(&ctx, "/b/storage/api/buckets").await;
+        assert_eq!(encoding.as_deref(), Some("gzip"));
+        assert!(listing.len() < body().len());
+
+        for path in ["/b/storage/api/events", "/b/storage/api/download"] {
+            let (streamed, encoding) = send(&ctx, path).await;
+            assert_eq!(encoding, None, "{path}");
+            assert_eq!(streamed, body(), "{path}");
+        }
+    }
+}
+
 #[cfg(test)]
 mod request_log_mode_tests {
     use super::{
@@ -701,3 +1289,343 @@ mod request_log_mode_tests {
         set_request_log_mode(RequestLogMode::Inline); // restore for other tests
     }
 }
+
+#[cfg(test)]
+mod route_security_tests {
+    //! Walks every protected route as an anonymous client through the whole
+    //! pipeline, spelling each path the ways a prefix match can be fooled
+    //! with. Every routed block is replaced by [`EchoBlock`], so a protected
+    //! path that gets dispatched at all is a failure, whatever the real block
+    //! would have made of it.
+
+    use std::sync::Arc;
+
+    use wafer_block::http_codec;
+    use wafer_run::{
+        context::Context, streams::output::TerminalNotResponse, AuthLevel, Block, BlockCategory,
+        BlockInfo, InputStream, LifecycleEvent, Message, OutputStream, WaferError,
+    };
+
+    use super::handle_request;
+    use crate::{
+        endpoint_match::action_for_method,
+        features::AllEnabled,
+        routing::{RouteAccess, ROUTES},
+        test_support::{anon_msg, TestContext},
+    };
+
+    /// Answers 200 with the path it was handed.
+    struct EchoBlock;
+
+    #[async_trait::async_trait]
+    impl Block for EchoBlock {
+        fn info(&self) -> BlockInfo {
+            BlockInfo::new("test/echo", "0.0.1", "http-handler@v1", "echo")
+                .category(BlockCategory::Service)
+        }
+
+        async fn handle(&self, _ctx: &dyn Context, msg: Message, _in: InputStream) -> OutputStream {
+            crate::http::ResponseBuilder::new().body(
+                format!("DISPATCHED {}", msg.path()).into_bytes(),
+                "text/plain",
+            )
+        }
+
+        async fn lifecycle(
+            &self,
+            _ctx: &dyn Context,
+            _e: LifecycleEvent,
+        ) -> Result<(), WaferError> {
+            Ok(())
+        }
+    }
+
+    async fn echo_ctx() -> TestContext {
+        let mut ctx = TestContext::new().await;
+        for route in ROUTES {
+            ctx.register_block(route.dispatch_to, Arc::new(EchoBlock));
+        }
+        ctx
+    }
+
+    /// Status and body of an anonymous request.
+    async fn send(
+        ctx: &TestContext,
+        infos: &[BlockInfo],
+        action: &str,
+        path: &str,
+    ) -> (u16, String) {
+        let out = handle_request(
+            ctx,
+            anon_msg(action, path),
+            InputStream::empty(),
+            None,
+            "test-jwt-secret",
+            &AllEnabled,
+            infos,
+            &[],
+        )
+        .await;
+        match out.collect_buffered().await {
+            Ok(buf) | Err(TerminalNotResponse::Halt(buf)) => (
+                http_codec::resolve_status(&buf.meta, 200),
+                String::from_utf8_lossy(&buf.body).into_owned(),
+            ),
+            Err(TerminalNotResponse::Error(e)) => (http_codec::resolve_error_status(&e), e.message),
+            Err(other) => panic!("pipeline returned {other:?} for {action} {path}"),
+        }
+    }
+
+    /// A concrete path for an endpoint template: `{name}` becomes `x`,
+    /// `{name...}` becomes `x/y`.
+    fn fill_params(template: &str) -> String {
+        template
+            .split('/')
+            .map(|seg| {
+                if seg.starts_with('{') && seg.ends_with("...}") {
+                    "x/y"
+                } else if seg.starts_with('{') || seg.starts_with(':') {
+                    "x"
+                } else {
+                    seg
+                }
+            })
+            .collect::<Vec<_>>()
+            .join("/")
+    }
+
+    /// Every (action, path) an anonymous caller must not reach: each
+    /// non-public built-in prefix, and each declared non-public endpoint.
+    fn protected_targets(infos: &[BlockInfo]) -> Vec<(&'static str, String)> {
+        let mut targets = Vec::new();
+        for route in ROUTES.iter().filter(|r| r.access != RouteAccess::Public) {
+            targets.push(("retrieve", route.prefix.to_string()));
+            targets.push((
+                "create",
+                format!("{}/x", route.prefix.trim_end_matches('/')),
+            ));
+        }
+        for info in infos {
+            for ep in &info.endpoints {
+                if !matches!(ep.auth, AuthLevel::Public) {
+                    targets.push((action_for_method(ep.method), fill_params(&ep.path)));
+                }
+            }
+        }
+        targets
+    }
+
+    /// `path` as-is and respelled so that, unnormalized, it would start with
+    /// something other than the protected prefix. Trailing-slash variants are
+    /// left out: the endpoint matcher treats the slash as significant, so
+    /// those are different paths, not respellings.
+    fn variants(path: &str) -> Vec<String> {
+        let rest = path.trim_start_matches('/');
+        vec![
+            path.to_string(),
+            format!("/api{path}"),
+            format!("/api/v1{path}"),
+            path.replace('/', "//"),
+            path.replace('/', "/./"),
+            format!("/b/auth/../../{rest}"),
+            format!("/b/auth/%2e%2e/%2E%2e/{rest}"),
+            format!("/b/products/./../../{rest}"),
+            format!("/api/b/storage/../../{rest}"),
+        ]
+    }
+
+    #[tokio::test]
+    async fn anonymous_callers_never_reach_protected_routes() {
+        let ctx = echo_ctx().await;
+        let infos = crate::blocks::all_block_infos();
+        let targets = protected_targets(&infos);
+        assert!(
+            targets.len() > 20,
+            "expected a real route table, got {targets:?}"
+        );
+
+        let mut reached = Vec::new();
+        for (action, path) in &targets {
+            for variant in variants(path) {
+                let (status, body) = send(&ctx, &infos, action, &variant).await;
+                if status == 200 || body.starts_with("DISPATCHED") {
+                    reached.push(format!("{action} {variant} -> {status} {body}"));
+                }
+            }
+        }
+        assert!(
+            reached.is_empty(),
+            "anonymous requests got through:\n{}",
+            reached.join("\n")
+        );
+    }
+
+    #[tokio::test]
+    async fn public_routes_are_dispatched_with_the_normalized_path() {
+        let ctx = echo_ctx().await;
+        let infos = crate::blocks::all_block_infos();
+        for raw in [
+            "/b/auth/login",
+            "//b//auth/./login",
+            "/api/b/auth/login",
+            "/api/v1/b/auth/login",
+            "/api/v1/b/storage/../auth/login",
+            "/b/storage/../auth/login",
+        ] {
+            let (status, body) = send(&ctx, &infos, "retrieve", raw).await;
+            assert_eq!(
+                (status, body.as_str()),
+                (200, "DISPATCHED /b/auth/login"),
+                "for {raw}"
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn api_prefix_is_stripped_only_as_a_whole_segment() {
+        let ctx = echo_ctx().await;
+        let infos = crate::blocks::all_block_infos();
+        let (status, body) = send(&ctx, &infos, "retrieve", "/api/b/auth/login").await;
+        assert_eq!((status, body.as_str()), (200, "DISPATCHED /b/auth/login"));
+
+        let (status, body) = send(&ctx, &infos, "retrieve", "/apib/auth/login").await;
+        assert_ne!(status, 200);
+        assert!(!body.starts_with("DISPATCHED"), "got {body}");
+    }
+
+    /// Response headers of an anonymous request for the login page.
+    async fn login_headers(
+        ctx: &TestContext,
+        infos: &[BlockInfo],
+        path: &str,
+    ) -> std::collections::HashMap<String, String> {
+        let out = handle_request(
+            ctx,
+            anon_msg("retrieve", path),
+            InputStream::empty(),
+            None,
+            "test-jwt-secret",
+            &AllEnabled,
+            infos,
+            &[],
+        )
+        .await;
+        let buf = crate::test_support::collect_or_panic(out).await;
+        assert_eq!(
+            String::from_utf8_lossy(&buf.body),
+            "DISPATCHED /b/auth/login"
+        );
+        buf.meta
+            .into_iter()
+            .filter_map(|e| Some((e.key.strip_prefix("resp.header.")?.to_string(), e.value)))
+            .collect()
+    }
+
+    #[tokio::test]
+    async fn unversioned_alias_is_marked_deprecated() {
+        let ctx = echo_ctx().await;
+        let infos = crate::blocks::all_block_infos();
+        let alias = login_headers(&ctx, &infos, "/api/b/auth/login").await;
+        assert!(alias.contains_key("Deprecation"), "{alias:?}");
+        assert!(alias.contains_key("Sunset"), "{alias:?}");
+        assert_eq!(
+            alias.get("Link").map(String::as_str),
+            Some("</api/v1/b/auth/login>; rel=\"successor-version\"")
+        );
+
+        for path in ["/api/v1/b/auth/login", "/b/auth/login"] {
+            let current = login_headers(&ctx, &infos, path).await;
+            assert!(!current.contains_key("Deprecation"), "{path}: {current:?}");
+            assert!(!current.contains_key("Sunset"), "{path}: {current:?}");
+        }
+    }
+
+    #[tokio::test]
+    async fn versioned_only_policy_drops_the_alias() {
+        use crate::api_version::{install, ApiVersionPolicy};
+
+        let ctx = echo_ctx().await;
+        let infos = crate::blocks::all_block_infos();
+        install(ApiVersionPolicy::VersionedOnly);
+
+        let (status, body) = send(&ctx, &infos, "retrieve", "/api/b/auth/login").await;
+        assert_eq!(status, 404, "{body}");
+        let (status, body) = send(&ctx, &infos, "retrieve", "/api/v1/b/auth/login").await;
+        assert_eq!((status, body.as_str()), (200, "DISPATCHED /b/auth/login"));
+        let (status, body) = send(&ctx, &infos, "retrieve", "/b/auth/login").await;
+        assert_eq!((status, body.as_str()), (200, "DISPATCHED /b/auth/login"));
+    }
+
+    #[tokio::test]
+    async fn control_characters_in_the_path_are_rejected() {
+        let ctx = echo_ctx().await;
+        let infos = crate::blocks::all_block_infos();
+        for raw in ["/b/auth/login%00", "/b/auth/%0alogin", "/b/auth/log\rin"] {
+            let (status, body) = send(&ctx, &infos, "retrieve", raw).await;
+            assert_eq!(status, 400, "for {raw:?}: {body}");
+        }
+    }
+}
+
+#[cfg(test)]
+mod dev_mode_tests {
+    use wafer_run::{InputStream, OutputStream};
+
+    use super::handle_request;
+    use crate::{
+        dev_mode,
+        features::AllEnabled,
+        test_support::{anon_msg, collect_or_panic, output_status, TestContext},
+    };
+
+    async fn send(ctx: &TestContext, action: &str, path: &str, body: &str) -> OutputStream {
+        handle_request(
+            ctx,
+            anon_msg(action, path),
+            InputStream::from_bytes(body.as_bytes().to_vec()),
+            None,
+            "test-jwt-secret",
+            &AllEnabled,
+            &[],
+            &[],
+        )
+        .await
+    }
+
+    #[tokio::test]
+    async fn namespace_404s_when_dev_mode_is_off() {
+        let ctx = TestContext::new().await;
+        for (action, path) in [
+            ("retrieve", "/api/_dev/echo"),
+            ("retrieve", "/api/_dev/state"),
+            ("create", "/api/_dev/fail"),
+            ("create", "/api/_dev/reset"),
+        ] {
+            let out = send(&ctx, action, path, "{}").await;
+            assert_eq!(output_status(out).await, 404, "{action} {path}");
+        }
+    }
+
+    #[tokio::test]
+    async fn simulated_failures_are_marked() {
+        let ctx = TestContext::new().await;
+        dev_mode::install("development").unwrap();
+        let out = send(&ctx, "retrieve", "/api/_dev/state", "").await;
+        assert_eq!(output_status(out).await, 200);
+
+        let rule = r#"{"path": "/b/auth/*", "status": 503}"#;
+        send(&ctx, "create", "/api/_dev/fail", rule).await;
+        let failed = collect_or_panic(send(&ctx, "retrieve", "/api/b/auth/api/me", "").await).await;
+        assert_eq!(
+            wafer_run::MetaGet::get(&failed.meta, wafer_run::META_RESP_STATUS),
+            Some("503")
+        );
+        assert_eq!(
+            wafer_run::MetaGet::get(&failed.meta, "resp.header.X-Solobase-Dev"),
+            Some("simulated; failure=503")
+        );
+        // The rule was for one request.
+        let out = send(&ctx, "retrieve", "/api/b/auth/api/me", "").await;
+        assert_ne!(output_status(out).await, 503);
+    }
+}
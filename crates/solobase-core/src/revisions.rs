@@ -0,0 +1,224 @@
+//! Change revisions for the data clients cache: settings, feature flags and
+//! announcements.
+//!
+//! Every write bumps one process-wide counter and stamps the new value on its
+//! category. `GET /api/sync?known={revision}` answers with the categories
+//! stamped after `known`, or parks until one is (see
+//! `blocks::system`). Checking for changes and registering a waiter happen
+//! under the same lock as [`bump`], so a write can't slip between the two
+//! and leave a client asleep on stale data.
+//!
+//! Revisions are per process. The counter starts at the boot time in unix
+//! milliseconds, so it keeps increasing across restarts and a client holding
+//! an older revision is told everything changed. A client holding a revision
+//! this process never issued (another instance behind the same load
+//! balancer) is told the same.
+
+use std::time::Duration;
+
+use futures::channel::oneshot;
+
+/// Data that clients cache and [`bump`] invalidates.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Category {
+    Settings,
+    Flags,
+    Announcements,
+}
+
+impl Category {
+    pub const ALL: [Category; 3] = [Category::Settings, Category::Flags, Category::Announcements];
+
+    /// Name used in the `/api/sync` response.
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Category::Settings => "settings",
+            Category::Flags => "flags",
+            Category::Announcements => "announcements",
+        }
+    }
+
+    fn index(self) -> usize {
+        self as usize
+    }
+}
+
+/// What changed after a client's revision.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Changes {
+    /// The revision the client should send next time.
+    pub revision: u64,
+    /// Empty when the client is up to date.
+    pub changed: Vec<Category>,
+}
+
+struct State {
+    revision: u64,
+    changed_at: [u64; 3],
+    waiters: Vec<oneshot::Sender<()>>,
+}
+
+impl State {
+    fn new() -> Self {
+        let start = crate::util::now_millis();
+        Self {
+            revision: start,
+            changed_at: [start; 3],
+            waiters: Vec::new(),
+        }
+    }
+
+    fn changes(&self, known: u64) -> Changes {
+        let changed = if known > self.revision {
+            Category::ALL.to_vec()
+        } else {
+            Category::ALL
+                .into_iter()
+                .filter(|c| self.changed_at[c.index()] > known)
+                .collect()
+        };
+        Changes {
+            revision: self.revision,
+            changed,
+        }
+    }
+}
+
+#[cfg(not(test))]
+static STATE: std::sync::Mutex<Option<State>> = std::sync::Mutex::new(None);
+
+// Each test thread gets its own counter, so one test's writes can't wake or
+// satisfy a waiter in another.
+#[cfg(test)]
+thread_local! {
+    static STATE: std::cell::RefCell<Option<State>> = const { std::cell::RefCell::new(None) };
+}
+
+#[cfg(not(test))]
+fn with_state<R>(f: impl FnOnce(&mut State) -> R) -> R {
+    // Every critical section leaves the state consistent, so a poisoned
+    // lock is safe to reuse.
+    let mut guard = STATE.lock().unwrap_or_else(|e| e.into_inner());
+    f(guard.get_or_insert_with(State::new))
+}
+
+#[cfg(test)]
+fn with_state<R>(f: impl FnOnce(&mut State) -> R) -> R {
+    STATE.with(|s| f(s.borrow_mut().get_or_insert_with(State::new)))
+}
+
+/// Record a write to `category` and wake every parked `/api/sync` request.
+pub fn bump(category: Category) {
+    with_state(|s| {
+        s.revision += 1;
+        s.changed_at[category.index()] = s.revision;
+        for waiter in s.waiters.drain(..) {
+            let _ = waiter.send(());
+        }
+    });
+}
+
+/// The categories that changed after `known`.
+pub fn changes_since(known: u64) -> Changes {
+    with_state(|s| s.changes(known))
+}
+
+/// Like [`changes_since`], but when nothing changed yet, waits up to
+/// `timeout` for a [`bump`]. `sleep` is the platform timer; `Changes` with an
+/// empty `changed` list means the wait ran out.
+pub async fn wait_for_changes(
+    known: u64,
+    timeout: Duration,
+    sleep: crate::pipeline::RequestTimer,
+) -> Changes {
+    let receiver = with_state(|s| {
+        let changes = s.changes(known);
+        if !changes.changed.is_empty() {
+            return Err(changes);
+        }
+        // Requests that already timed out left their sender behind.
+        s.waiters.retain(|w| !w.is_canceled());
+        let (tx, rx) = oneshot::channel();
+        s.waiters.push(tx);
+        Ok(rx)
+    });
+    match receiver {
+        Err(changes) => changes,
+        Ok(rx) => {
+            let _ = futures::future::select(rx, sleep(timeout)).await;
+            changes_since(known)
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use futures::future::BoxFuture;
+
+    use super::*;
+
+    fn tokio_sleep(d: Duration) -> BoxFuture<'static, ()> {
+        Box::pin(tokio::time::sleep(d))
+    }
+
+    #[test]
+    fn bump_marks_only_its_category() {
+        let start = changes_since(0).revision;
+        assert!(changes_since(start).changed.is_empty());
+
+        bump(Category::Flags);
+        let changes = changes_since(start);
+        assert_eq!(changes.revision, start + 1);
+        assert_eq!(changes.changed, vec![Category::Flags]);
+
+        bump(Category::Settings);
+        assert_eq!(
+            changes_since(start).changed,
+            vec![Category::Settings, Category::Flags]
+        );
+        assert_eq!(changes_since(start + 1).changed, vec![Category::Settings]);
+        assert!(changes_since(start + 2).changed.is_empty());
+    }
+
+    #[test]
+    fn unknown_revisions_report_everything() {
+        let current = changes_since(0);
+        assert_eq!(current.changed, Category::ALL.to_vec());
+        // A revision from before this process started, or from another
+        // instance, can't be compared with ours.
+        assert_eq!(
+            changes_since(current.revision + 10).changed,
+            Category::ALL.to_vec()
+        );
+    }
+
+    #[tokio::test]
+    async fn waiter_wakes_on_bump() {
+        let known = changes_since(0).revision;
+        let wait = wait_for_changes(known, Duration::from_secs(30), tokio_sleep);
+        let write = async {
+            tokio::task::yield_now().await;
+            bump(Category::Announcements);
+        };
+        let (changes, ()) = futures::join!(wait, write);
+        assert_eq!(changes.changed, vec![Category::Announcements]);
+        assert_eq!(changes.revision, known + 1);
+    }
+
+    #[tokio::test]
+    async fn waiter_returns_unchanged_on_timeout() {
+        let known = changes_since(0).revision;
+        let changes = wait_for_changes(known, Duration::from_millis(10), tokio_sleep).await;
+        assert_eq!(changes.revision, known);
+        assert!(changes.changed.is_empty());
+    }
+
+    #[tokio::test]
+    async fn change_before_waiting_answers_at_once() {
+        let known = changes_since(0).revision;
+        bump(Category::Settings);
+        // A long timeout: the test would hang if this parked.
+        let changes = wait_for_changes(known, Duration::from_secs(3600), tokio_sleep).await;
+        assert_eq!(changes.changed, vec![Category::Settings]);
+    }
+}
@@ -4,7 +4,9 @@
 //! All solobase blocks are registered in the Wafer registry at boot; routing
 //! dispatches via `ctx.call_block` without any factory indirection.
 
-use wafer_run::{context::Context, AuthLevel, BlockInfo, InputStream, Message, OutputStream};
+use wafer_run::{
+    context::Context, AuthLevel, BlockInfo, HttpMethod, InputStream, Message, OutputStream,
+};
 
 use crate::{endpoint_match, features::FeatureConfig};
 
@@ -63,7 +65,8 @@ impl Route {
 ///
 /// Checked by [`route_to_block`] (via `check_access`) before dispatching to the
 /// target block, for both built-in [`Route`]s and runtime-added [`ExtraRoute`]s.
-#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, serde::Serialize)]
+#[serde(rename_all = "snake_case")]
 #[non_exhaustive]
 pub enum RouteAccess {
     /// No auth check. Anyone can hit this route.
@@ -96,6 +99,36 @@ impl RouteAccess {
     fn max(self, other: RouteAccess) -> RouteAccess {
         std::cmp::max(self, other)
     }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            RouteAccess::Public => "public",
+            RouteAccess::Authenticated => "authenticated",
+            RouteAccess::Admin => "admin",
+        }
+    }
+}
+
+/// Who mounted a route.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, serde::Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum RouteSource {
+    /// A built-in [`ROUTES`] entry or pipeline endpoint.
+    Core,
+    /// A declarative extension, via `SolobaseBuilder::extension`.
+    Extension,
+    /// A project route, via `SolobaseBuilder::add_route`.
+    Custom,
+}
+
+impl RouteSource {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            RouteSource::Core => "core",
+            RouteSource::Extension => "extension",
+            RouteSource::Custom => "custom",
+        }
+    }
 }
 
 /// A runtime-added route registered by a downstream project via
@@ -108,23 +141,44 @@ impl RouteAccess {
 ///
 /// Built-in [`ROUTES`] always win. An extra route with the same prefix as a
 /// built-in is ignored. To disable a built-in route, disable its feature
-/// flag — do not try to override it.
+/// flag — do not try to override it. Among extra routes the longest
+/// matching prefix wins (see [`prepare_extra_routes`]).
 #[derive(Debug, Clone)]
 pub struct ExtraRoute {
     pub prefix: String,
     pub access: RouteAccess,
     pub block_name: String,
+    /// Reported by [`route_listing`]; never affects dispatch.
+    pub source: RouteSource,
 }
 
 /// The shared routing table. Order matters — more specific prefixes before general ones.
 ///
 /// All block routes live under `/b/{block_name}/...`. SSR pages and JSON API
 /// share the same prefix — blocks distinguish by HTTP method and path.
-/// System endpoints (`/health`, `/nav`, `/static/`, `/debug/`) are the only
+/// System endpoints (`/health`, `/version`, `/nav`, `/static/`, `/debug/`),
+/// the S3 gateway (`/s3`) and bucket websites (`/sites/`) are the only
 /// routes outside `/b/`.
 pub const ROUTES: &[Route] = &[
     // System & static assets
     Route::new("/health", RouteAccess::Public, "suppers-ai/system"),
+    // `/api/version` once the pipeline has stripped `/api`.
+    Route::new("/version", RouteAccess::Public, "suppers-ai/system"),
+    // `/api/sync`: long-poll for settings, flag and announcement changes.
+    Route::new("/sync", RouteAccess::Public, "suppers-ai/system"),
+    // `/api/i18n/{locale}`: the message catalog, for frontends to show the
+    // same strings the server renders.
+    Route::new("/i18n/", RouteAccess::Public, "suppers-ai/system"),
+    // `/api/iam/my-permissions`: what the signed-in caller may open, for the
+    // admin navigation.
+    Route::new("/iam/", RouteAccess::Authenticated, "suppers-ai/admin"),
+    // `/api/operations`: progress and results of the caller's long-running
+    // operations.
+    Route::new(
+        "/operations",
+        RouteAccess::Authenticated,
+        "suppers-ai/admin",
+    ),
     Route::new(STATIC_PREFIX, RouteAccess::Public, "suppers-ai/system"),
     // Inspector — runtime debugging UI (admin only). Feature-gated as
     // `suppers-ai/inspector` but dispatches to the `wafer-run/inspector` block.
@@ -141,9 +195,19 @@ pub const ROUTES: &[Route] = &[
     // Admin — SSR pages + API under /b/admin/
     Route::new("/b/admin/", RouteAccess::Admin, "suppers-ai/admin"),
     Route::new("/b/admin", RouteAccess::Admin, "suppers-ai/admin"),
+    // Inbound webhooks — served by the admin block, but the caller is an
+    // external system whose only credential is the HMAC signature the block
+    // verifies.
+    Route::new("/b/hooks/in/", RouteAccess::Public, "suppers-ai/admin"),
     // Feature blocks — SSR + API under /b/{block}/
     Route::new("/b/storage/", RouteAccess::Public, "suppers-ai/files"),
     Route::new("/b/cloudstorage/", RouteAccess::Public, "suppers-ai/files"),
+    // S3-compatible gateway — clients sign with SigV4, verified by the
+    // pipeline; the files block rejects anything unsigned.
+    Route::new("/s3", RouteAccess::Public, "suppers-ai/files"),
+    // Bucket websites — anonymous reads of public buckets with website
+    // mode on; the files block refuses the rest.
+    Route::new("/sites/", RouteAccess::Public, "suppers-ai/files"),
     Route::new("/b/products", RouteAccess::Public, "suppers-ai/products"),
     // Legalpages — public reads + admin writes/UI.
     // Admin and API prefixes must come BEFORE the bare `/b/legalpages` entry
@@ -190,6 +254,214 @@ pub const ROUTES: &[Route] = &[
     Route::new("/b/vector/", RouteAccess::Public, "suppers-ai/vector"),
 ];
 
+/// Request meta carrying the path exactly as the client sent it, before
+/// [`normalize_path`] and the base-path strip. Routing never reads it; it
+/// is for code that must see what the client wrote, like an S3 signature
+/// (signed over the raw path) and the object key it names.
+pub const META_RAW_PATH: &str = "req.raw_path";
+
+/// The canonical form of a request path, or `None` when it must be refused
+/// with a 400.
+///
+/// Prefix matching (here and in each block's own dispatch) only means
+/// something on a canonical path: `/b/auth/../admin/users` starts with the
+/// public `/b/auth/` prefix but names the admin `/b/admin/users`, and
+/// `/b/products/api/admin//products` misses the declared admin endpoint
+/// while the block still answers it. So the pipeline rewrites every path
+/// before anything matches on it:
+///
+/// - runs of `/` collapse to one;
+/// - `.` segments are dropped and `..` segments remove the segment before
+///   them (never climbing above the root), including their percent-encoded
+///   spellings (`%2e`, `%2E%2e`, ...);
+/// - a trailing slash is kept, since the endpoint matcher treats it as
+///   significant;
+/// - a path containing a control character (`\0`-`\x1f`, `\x7f`), raw or
+///   percent-encoded, is rejected.
+///
+/// Paths that don't start with `/` are returned unchanged; no route
+/// matches them.
+pub fn normalize_path(path: &str) -> Option<String> {
+    if percent_encoding::percent_decode_str(path).any(|b| b.is_ascii_control()) {
+        return None;
+    }
+    if !path.starts_with('/') {
+        return Some(path.to_string());
+    }
+
+    let segments: Vec<&str> = path.split('/').skip(1).collect();
+    let last = segments.len() - 1;
+    let mut out: Vec<&str> = Vec::with_capacity(segments.len());
+    let mut trailing_slash = false;
+    for (i, segment) in segments.iter().enumerate() {
+        let dots = segment.to_ascii_lowercase().replace("%2e", ".");
+        match dots.as_str() {
+            "" | "." => {}
+            ".." => {
+                out.pop();
+            }
+            _ => {
+                out.push(segment);
+                continue;
+            }
+        }
+        // `/a/`, `/a/.` and `/a/b/..` all name the directory `/a/`.
+        if i == last {
+            trailing_slash = true;
+        }
+    }
+
+    let mut normalized = format!("/{}", out.join("/"));
+    if trailing_slash && !out.is_empty() {
+        normalized.push('/');
+    }
+    Some(normalized)
+}
+
+/// Check and order the routes registered via `SolobaseBuilder::add_route`.
+///
+/// Each prefix must be an explicit path below the root, already in
+/// [`normalize_path`] form: an empty or `/` prefix would catch every path no
+/// built-in route claims, and a non-canonical one could never match a
+/// normalized request. A prefix may be registered once. The result is
+/// sorted longest prefix first, so a protected `/x/admin` is matched before
+/// a public `/x/` whatever order they were added in — the first match wins
+/// in [`route_to_block`].
+///
+/// Routes are matched after the pipeline strips the API prefix (see
+/// [`crate::api_version`]), so a prefix given under `/api/v1` is stored
+/// without it, and one under the unversioned `/api` is refused: it could
+/// never match.
+pub fn prepare_extra_routes(mut routes: Vec<ExtraRoute>) -> Result<Vec<ExtraRoute>, String> {
+    for route in &mut routes {
+        match crate::api_version::classify(&route.prefix) {
+            crate::api_version::Addressed::Versioned(rest) => route.prefix = rest.to_string(),
+            crate::api_version::Addressed::Alias(_) => {
+                return Err(format!(
+                    "route prefix {:?} for {} is under /api; register it under {} or \
+                     without the API prefix",
+                    route.prefix,
+                    route.block_name,
+                    crate::api_version::V1_PREFIX
+                ));
+            }
+            crate::api_version::Addressed::Bare => {}
+        }
+    }
+    let mut seen = std::collections::HashSet::new();
+    for route in &routes {
+        if route.prefix.len() < 2 || !route.prefix.starts_with('/') {
+            return Err(format!(
+                "route prefix {:?} for {} must be a path below /",
+                route.prefix, route.block_name
+            ));
+        }
+        if normalize_path(&route.prefix).as_deref() != Some(route.prefix.as_str()) {
+            return Err(format!(
+                "route prefix {:?} for {} is not a normalized path",
+                route.prefix, route.block_name
+            ));
+        }
+        if !seen.insert(route.prefix.as_str()) {
+            return Err(format!(
+                "route prefix {:?} is registered twice",
+                route.prefix
+            ));
+        }
+    }
+    routes.sort_by(|a, b| b.prefix.len().cmp(&a.prefix.len()));
+    Ok(routes)
+}
+
+/// A prefix route matched by [`resolve`]: a built-in [`Route`] or an
+/// [`ExtraRoute`], flattened so both are gated by the same code.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Resolved<'a> {
+    prefix: &'a str,
+    access: RouteAccess,
+    block: &'a str,
+    dispatch_to: &'a str,
+    source: RouteSource,
+}
+
+/// The route that serves `path`: the first matching [`ROUTES`] prefix, else
+/// the first matching extra route (longest first, see
+/// [`prepare_extra_routes`]). `None` for unrouted paths, including the root.
+///
+/// The one matcher behind [`route_to_block`], [`block_for`] and
+/// [`route_listing`], so dispatch, metrics and the listing can't disagree
+/// about which route owns a path.
+fn resolve<'a>(path: &str, extra_routes: &'a [ExtraRoute]) -> Option<Resolved<'a>> {
+    let matches = |prefix: &str| path == prefix || path.starts_with(prefix);
+    ROUTES
+        .iter()
+        .find(|r| matches(r.prefix))
+        .map(|r| Resolved {
+            prefix: r.prefix,
+            access: r.access,
+            block: r.block,
+            dispatch_to: r.dispatch_to,
+            source: RouteSource::Core,
+        })
+        .or_else(|| {
+            extra_routes
+                .iter()
+                .find(|r| matches(&r.prefix))
+                .map(|r| Resolved {
+                    prefix: &r.prefix,
+                    access: r.access,
+                    block: &r.block_name,
+                    dispatch_to: &r.block_name,
+                    source: r.source,
+                })
+        })
+}
+
+/// The block [`route_to_block`] sends `path` to. Used to attribute
+/// [`crate::block_metrics`].
+pub fn block_for<'a>(path: &str, extra_routes: &'a [ExtraRoute]) -> Option<&'a str> {
+    resolve(path, extra_routes).map(|r| r.block)
+}
+
+/// Endpoints exempt from the pipeline's handler timeout
+/// (`SOLOBASE_SHARED__REQUEST_TIMEOUT_MS`): uploads and downloads whose
+/// duration scales with the payload, producers that stream for as long as
+/// the work takes, and the `/api/sync` long-poll, which bounds itself.
+/// Matched with the same `(method, template)` syntax blocks use for their
+/// dispatch tables; a new long-running endpoint opts out by being listed
+/// here.
+pub const LONG_RUNNING: &[(HttpMethod, &str)] = &[
+    (HttpMethod::Post, "/b/storage/api/buckets/{name}/objects"),
+    (
+        HttpMethod::Post,
+        "/b/storage/api/buckets/{name}/upload-archive",
+    ),
+    (
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/objects/{key...}",
+    ),
+    (
+        HttpMethod::Get,
+        "/b/storage/api/buckets/{name}/preview/{key...}",
+    ),
+    (HttpMethod::Post, "/b/storage/api/widgets/upload"),
+    (HttpMethod::Get, "/b/storage/direct/{token}"),
+    (HttpMethod::Get, "/s3/{bucket}/{key...}"),
+    (HttpMethod::Patch, "/s3/{bucket}/{key...}"),
+    (HttpMethod::Get, "/sites/{bucket}/{path...}"),
+    (HttpMethod::Post, "/b/llm/api/chat/stream"),
+    (HttpMethod::Post, "/b/vector/api/ingest"),
+    (HttpMethod::Get, "/sync"),
+];
+
+/// Whether `(action, path)` is a [`LONG_RUNNING`] endpoint.
+pub fn is_long_running(action: &str, path: &str) -> bool {
+    LONG_RUNNING.iter().any(|(method, template)| {
+        endpoint_match::action_for_method(*method) == action
+            && endpoint_match::match_template(template, path).is_some()
+    })
+}
+
 /// Generate the routing table as JSON config (same format as wafer-run/router).
 /// Used to expose routes to the inspector.
 ///
@@ -232,6 +504,87 @@ pub fn routes_config(block_infos: &[BlockInfo]) -> serde_json::Value {
     serde_json::json!({ "routes": routes })
 }
 
+/// Paths the pipeline answers itself, before routing (see
+/// [`crate::pipeline::handle_request`]).
+const PIPELINE_ENDPOINTS: &[(HttpMethod, &str)] = &[
+    (HttpMethod::Get, "/"),
+    (HttpMethod::Get, "/openapi.json"),
+    (HttpMethod::Get, "/.well-known/agent.json"),
+];
+
+/// One mounted route, as reported by [`route_listing`].
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct RouteListing {
+    /// `GET`, `POST`, ..., or `*` for a whole prefix.
+    pub method: String,
+    /// An endpoint template (`/b/storage/api/buckets/{name}`) or a prefix
+    /// followed by `**`.
+    pub path: String,
+    /// The tier the router enforces: the stricter of the prefix tier and
+    /// the endpoint's declared level.
+    pub auth: RouteAccess,
+    /// The owning block (for the inspector, its feature-gate name rather
+    /// than the runtime block it dispatches to).
+    pub block: String,
+    pub source: RouteSource,
+}
+
+/// Every route the router serves: the pipeline's own endpoints, then each
+/// prefix in match order, followed by the endpoints its block declares
+/// under it.
+///
+/// An endpoint is listed under the route that actually wins its path, so
+/// one a more specific prefix claims (`/b/admin/settings` before
+/// `/b/admin/`) shows up once, and one shadowed by another block's prefix
+/// is left out — no request could reach it. Feature toggles and the UI
+/// mode are runtime state and don't change the listing.
+pub fn route_listing(block_infos: &[BlockInfo], extra_routes: &[ExtraRoute]) -> Vec<RouteListing> {
+    let mut out: Vec<RouteListing> = PIPELINE_ENDPOINTS
+        .iter()
+        .map(|(method, path)| RouteListing {
+            method: method.to_string(),
+            path: (*path).to_string(),
+            auth: RouteAccess::Public,
+            block: "suppers-ai/router".to_string(),
+            source: RouteSource::Core,
+        })
+        .collect();
+
+    let prefixes = ROUTES
+        .iter()
+        .map(|r| r.prefix)
+        .chain(extra_routes.iter().map(|r| r.prefix.as_str()));
+    for prefix in prefixes {
+        // A built-in prefix an extra route repeats is never reached.
+        let Some(route) = resolve(prefix, extra_routes).filter(|r| r.prefix == prefix) else {
+            continue;
+        };
+        out.push(RouteListing {
+            method: "*".to_string(),
+            path: format!("{prefix}**"),
+            auth: route.access,
+            block: route.block.to_string(),
+            source: route.source,
+        });
+        let Some(info) = block_infos.iter().find(|i| i.name == route.block) else {
+            continue;
+        };
+        for ep in &info.endpoints {
+            if resolve(&ep.path, extra_routes).map(|r| r.prefix) != Some(prefix) {
+                continue;
+            }
+            out.push(RouteListing {
+                method: ep.method.to_string(),
+                path: ep.path.clone(),
+                auth: route.access.max(RouteAccess::from_auth_level(ep.auth)),
+                block: route.block.to_string(),
+                source: route.source,
+            });
+        }
+    }
+    out
+}
+
 /// Resolve the declared per-endpoint access tier for `(msg.action,
 /// msg.path)` from the target block's `BlockInfo::endpoints`, mapped into the
 /// router's [`RouteAccess`] ladder.
@@ -251,7 +604,7 @@ fn declared_access(block_infos: &[BlockInfo], block_name: &str, msg: &Message) -
 
 /// Enforce a route's [`RouteAccess`] tier against the request. Returns
 /// `Some(forbidden_response)` when the caller fails the tier, or `None` to
-/// proceed. Shared by the built-in and extra-route dispatch loops.
+/// proceed.
 fn check_access(access: RouteAccess, msg: &Message) -> Option<OutputStream> {
     match access {
         RouteAccess::Public => None,
@@ -276,6 +629,71 @@ fn check_access(access: RouteAccess, msg: &Message) -> Option<OutputStream> {
     }
 }
 
+/// Which built-in SSR pages the router serves, from the
+/// `SOLOBASE_SHARED__DISABLE_UI` / `SOLOBASE_SHARED__ENABLE_AUTH_PAGES`
+/// toggles. JSON APIs, static assets, OAuth hops and direct downloads are
+/// served in every mode; only page requests are gated (see [`is_ui_page`]).
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum UiMode {
+    /// Every page (the default).
+    Full,
+    /// UI disabled, but the hosted auth flows in [`AUTH_PAGES`] stay up so
+    /// an embedder keeps login/signup/reset without rebuilding them.
+    AuthPagesOnly,
+    /// Headless: no SSR pages at all.
+    Off,
+}
+
+impl UiMode {
+    pub fn from_flags(disable_ui: bool, enable_auth_pages: bool) -> UiMode {
+        match (disable_ui, enable_auth_pages) {
+            (false, _) => UiMode::Full,
+            (true, true) => UiMode::AuthPagesOnly,
+            (true, false) => UiMode::Off,
+        }
+    }
+
+    pub fn from_ctx(ctx: &dyn Context) -> UiMode {
+        let flag = |key: &str| ctx.config_get(key).unwrap_or("false") == "true";
+        UiMode::from_flags(
+            flag("SOLOBASE_SHARED__DISABLE_UI"),
+            flag("SOLOBASE_SHARED__ENABLE_AUTH_PAGES"),
+        )
+    }
+
+    /// Whether `(action, path)` may be served in this mode.
+    pub fn allows(self, action: &str, path: &str) -> bool {
+        match self {
+            UiMode::Full => true,
+            _ if !is_ui_page(action, path) => true,
+            UiMode::AuthPagesOnly => AUTH_PAGES.contains(&path),
+            UiMode::Off => false,
+        }
+    }
+}
+
+/// The hosted auth pages kept by [`UiMode::AuthPagesOnly`]. Email
+/// confirmation is `/b/auth/api/verify` and OAuth is `/b/auth/oauth/*`,
+/// neither of which is a page, so both keep working in every mode.
+pub const AUTH_PAGES: &[&str] = &[
+    "/b/auth/login",
+    "/b/auth/signup",
+    "/b/auth/reset-password",
+    "/b/auth/change-password",
+];
+
+/// Whether `(action, path)` requests a built-in SSR page: a GET under `/b/`
+/// that isn't a JSON API, a static asset, an OAuth browser hop or a direct
+/// download link.
+fn is_ui_page(action: &str, path: &str) -> bool {
+    action == "retrieve"
+        && path.starts_with("/b/")
+        && !path.contains("/api/")
+        && !path.starts_with(STATIC_PREFIX)
+        && !path.starts_with("/b/auth/oauth/")
+        && !path.starts_with("/b/storage/direct/")
+}
+
 /// Route a message to the appropriate solobase block based on request path.
 ///
 /// Checks feature flags and admin role. Dispatches via `ctx.call_block` — all
@@ -305,60 +723,80 @@ pub async fn route_to_block(
         if has_landing_page {
             return ctx.call_block("wafer-run/web", msg, input).await;
         }
-        return root_redirect(msg.user_id().is_empty());
+        return match (UiMode::from_ctx(ctx), msg.user_id().is_empty()) {
+            (UiMode::Full, anonymous) => root_redirect(anonymous),
+            // Only the auth pages are up: anonymous visitors can still log
+            // in; there is no portal to send a signed-in user to.
+            (UiMode::AuthPagesOnly, true) => root_redirect(true),
+            _ => crate::ui::not_found_response(&msg),
+        };
     }
 
-    for route in ROUTES {
-        let matches = path == route.prefix || path.starts_with(route.prefix);
-        if !matches {
-            continue;
-        }
-
-        // Feature gate
-        if !features.is_block_enabled(route.block) {
-            return crate::http::err_not_found("endpoint not found");
-        }
-
-        // Access gate. The coarse prefix tier is a backstop; if the target
-        // block declares an endpoint matching this exact (action, path) we
-        // also enforce that endpoint's declared `AuthLevel` — taking the
-        // stricter of the two. This is what makes `BlockEndpoint::auth`
-        // load-bearing instead of documentation-only, and lets blocks drop
-        // their per-handler `is_admin`/`user_id` preambles.
-        let access = route
-            .access
-            .max(declared_access(block_infos, route.block, &msg));
-        if let Some(denied) = check_access(access, &msg) {
-            return denied;
-        }
+    // Built-ins win on prefix collision; project routes only see paths no
+    // built-in claims. Every gate below applies to both.
+    let Some(route) = resolve(&path, extra_routes) else {
+        return crate::ui::not_found_response(&msg);
+    };
 
-        // Dispatch via call_block so WRAP sees the correct caller identity.
-        return ctx.call_block(route.dispatch_to, msg, input).await;
+    // Feature gate — downstream-registered routes honor the admin disable
+    // toggle exactly like built-ins. A feature block left out of the build
+    // answers the same way.
+    if !crate::blocks::is_compiled_in(route.block) || !features.is_block_enabled(route.block) {
+        return crate::http::err_not_found("endpoint not found");
+    }
+    // The UI toggles switch off the built-in SSR pages; extension and
+    // project pages aren't part of the built-in UI and stay up.
+    if route.source == RouteSource::Core && !UiMode::from_ctx(ctx).allows(msg.action(), &path) {
+        return crate::ui::not_found_response(&msg);
+    }
+    if let Some(unavailable) = schema_gate(route.block) {
+        return unavailable;
     }
 
-    // Fall back to project-registered extra routes. Built-ins above win on
-    // prefix collision — this loop only runs when no built-in matched.
-    for route in extra_routes {
-        let matches = path == route.prefix || path.starts_with(&route.prefix);
-        if !matches {
-            continue;
-        }
-
-        // Feature gate — downstream-registered routes honor the admin disable
-        // toggle exactly like the built-in `ROUTES` loop above (which they
-        // bypassed before). Keep this gate in sync with that one.
-        if !features.is_block_enabled(&route.block_name) {
-            return crate::http::err_not_found("endpoint not found");
-        }
-
-        if let Some(denied) = check_access(route.access, &msg) {
+    // Access gate. The coarse prefix tier is a backstop; if the target
+    // block declares an endpoint matching this exact (action, path) we
+    // also enforce that endpoint's declared `AuthLevel` — taking the
+    // stricter of the two. This is what makes `BlockEndpoint::auth`
+    // load-bearing instead of documentation-only, and lets blocks drop
+    // their per-handler `is_admin`/`user_id` preambles.
+    let access = route
+        .access
+        .max(declared_access(block_infos, route.block, &msg));
+    // Route permission (see `blocks::permissions`). On an admin-tier route
+    // it stands in for the admin role, so a non-admin holding it gets in.
+    let permission = crate::blocks::permissions::required(msg.action(), &path);
+    let access = match permission {
+        Some(_) if access == RouteAccess::Admin => RouteAccess::Authenticated,
+        _ => access,
+    };
+    if let Some(denied) = check_access(access, &msg) {
+        return denied;
+    }
+    if let Some(permission) = permission {
+        if let Some(denied) = crate::blocks::permissions::require(ctx, &msg, permission).await {
             return denied;
         }
-
-        return ctx.call_block(&route.block_name, msg, input).await;
+    }
+    // A scoped API key reaches only what its scopes (and bucket) cover.
+    if let Some(denied) = crate::blocks::auth::key_scopes::confine(&msg, route.block, permission) {
+        return denied;
     }
 
-    crate::ui::not_found_response(&msg)
+    // Dispatch via call_block so WRAP sees the correct caller identity.
+    ctx.call_block(route.dispatch_to, msg, input).await
+}
+
+/// 503 for a block whose startup migrations failed (see
+/// [`crate::schema_status`]). Dispatching would only surface the missing
+/// tables as raw SQL errors; the captured DDL error stays on the admin
+/// extensions API.
+fn schema_gate(block: &str) -> Option<OutputStream> {
+    crate::schema_status::failure(block)?;
+    Some(crate::blocks::errors::error_json(
+        crate::blocks::errors::ErrorCode::SchemaNotInitialized,
+        "extension unavailable: schema not initialized",
+        Some(serde_json::json!({"block": block})),
+    ))
 }
 
 /// Build a root redirect response. Extracted for unit testability.
@@ -388,6 +826,12 @@ mod tests {
         let cases = vec![
             // System endpoints
             ("/health", "suppers-ai/system"),
+            ("/version", "suppers-ai/system"),
+            ("/sync", "suppers-ai/system"),
+            ("/i18n/pt-br", "suppers-ai/system"),
+            ("/iam/my-permissions", "suppers-ai/admin"),
+            ("/operations", "suppers-ai/admin"),
+            ("/operations/op-1", "suppers-ai/admin"),
             ("/b/static/app.css", "suppers-ai/system"),
             // Inspector
             ("/b/inspector", "suppers-ai/inspector"),
@@ -399,8 +843,11 @@ mod tests {
             ("/b/admin/", "suppers-ai/admin"),
             ("/b/admin/users", "suppers-ai/admin"),
             ("/b/admin", "suppers-ai/admin"),
+            ("/b/hooks/in/abc", "suppers-ai/admin"),
             ("/b/storage/buckets", "suppers-ai/files"),
             ("/b/cloudstorage/shares", "suppers-ai/files"),
+            ("/s3/docs/a.txt", "suppers-ai/files"),
+            ("/sites/docs/index.html", "suppers-ai/files"),
             ("/b/products", "suppers-ai/products"),
             ("/b/legalpages", "suppers-ai/legalpages"),
             ("/b/userportal", "suppers-ai/userportal"),
@@ -464,12 +911,16 @@ mod tests {
         // `legalpages_admin_routes_require_admin`.
         let non_admin_prefixes = [
             "/health",
+            "/version",
+            "/sync",
+            "/i18n/",
             "/static/",
             "/b/auth/",
             "/b/storage/",
             "/b/products",
             "/b/userportal",
             "/b/cloudstorage/",
+            "/b/hooks/in/",
         ];
         for route in ROUTES {
             if non_admin_prefixes
@@ -544,39 +995,38 @@ mod tests {
         "suppers-ai/userportal",
     ];
 
+    /// Route target that answers `DISPATCHED` to anything.
+    struct EchoBlock;
+
+    #[async_trait::async_trait]
+    impl wafer_run::Block for EchoBlock {
+        fn info(&self) -> BlockInfo {
+            BlockInfo::new("test/extra", "0.0.1", "echo@v1", "extra route target")
+                .category(wafer_run::BlockCategory::Service)
+        }
+        async fn handle(
+            &self,
+            _ctx: &dyn Context,
+            _msg: Message,
+            _input: InputStream,
+        ) -> OutputStream {
+            crate::http::ResponseBuilder::new()
+                .status(200)
+                .body(b"DISPATCHED".to_vec(), "text/plain")
+        }
+        async fn lifecycle(
+            &self,
+            _ctx: &dyn Context,
+            _e: wafer_run::LifecycleEvent,
+        ) -> Result<(), wafer_run::WaferError> {
+            Ok(())
+        }
+    }
+
     #[tokio::test]
     async fn extra_routes_honor_the_feature_gate() {
-        use async_trait::async_trait;
-        use wafer_run::{Block as RunBlock, BlockCategory, BlockInfo, LifecycleEvent, WaferError};
-
         use crate::test_support::{anon_msg, TestContext};
 
-        struct EchoBlock;
-        #[async_trait]
-        impl RunBlock for EchoBlock {
-            fn info(&self) -> BlockInfo {
-                BlockInfo::new("test/extra", "0.0.1", "echo@v1", "extra route target")
-                    .category(BlockCategory::Service)
-            }
-            async fn handle(
-                &self,
-                _ctx: &dyn Context,
-                _msg: Message,
-                _input: InputStream,
-            ) -> OutputStream {
-                crate::http::ResponseBuilder::new()
-                    .status(200)
-                    .body(b"DISPATCHED".to_vec(), "text/plain")
-            }
-            async fn lifecycle(
-                &self,
-                _ctx: &dyn Context,
-                _e: LifecycleEvent,
-            ) -> Result<(), WaferError> {
-                Ok(())
-            }
-        }
-
         async fn dispatched(features: &dyn FeatureConfig) -> bool {
             let mut ctx = TestContext::new().await;
             ctx.register_block("test/extra", std::sync::Arc::new(EchoBlock));
@@ -584,6 +1034,7 @@ mod tests {
                 prefix: "/x/extra".to_string(),
                 access: RouteAccess::Public,
                 block_name: "test/extra".to_string(),
+                source: RouteSource::Custom,
             }];
             let out = route_to_block(
                 &ctx,
@@ -612,6 +1063,541 @@ mod tests {
         );
     }
 
+    #[tokio::test]
+    async fn route_permissions_open_admin_routes_to_holders_only() {
+        use crate::test_support::{auth_msg, output_json, output_status, TestContext};
+
+        let mut ctx = TestContext::with_admin().await;
+        ctx.register_block("suppers-ai/admin", std::sync::Arc::new(EchoBlock));
+        let mut data = crate::util::json_map(serde_json::json!({
+            "name": "auditor",
+            "permissions": ["logs.read"],
+        }));
+        crate::util::stamp_created(&mut data);
+        wafer_core::clients::database::create(&ctx, crate::blocks::admin::ROLES_TABLE, data)
+            .await
+            .unwrap();
+        let send = |path: &str, roles: &str| {
+            let mut msg = auth_msg("retrieve", path, "u1");
+            msg.set_meta("auth.user_roles", roles);
+            route_to_block(&ctx, msg, InputStream::empty(), &AllEnabled, &[], &[])
+        };
+
+        let body = output_json(send("/b/admin/api/logs", "user").await).await;
+        assert_eq!(body["code"], "permission_denied");
+        assert_eq!(body["details"]["permission"], "logs.read");
+
+        let out = send("/b/admin/api/logs", "user,auditor").await;
+        assert_eq!(out.collect_buffered().await.unwrap().body, b"DISPATCHED");
+        // The permission opens the log viewer, not the rest of the panel.
+        let out = send("/b/admin/api/users", "user,auditor").await;
+        assert_eq!(output_status(out).await, 403);
+    }
+
+    #[tokio::test]
+    async fn scoped_admin_roles_reach_only_their_own_sections() {
+        use crate::{
+            blocks::permissions::SCOPED_ROLES,
+            test_support::{auth_msg, TestContext},
+        };
+
+        let mut ctx = TestContext::with_admin().await;
+        for block in [
+            "suppers-ai/admin",
+            "suppers-ai/files",
+            "suppers-ai/products",
+        ] {
+            ctx.register_block(block, std::sync::Arc::new(EchoBlock));
+        }
+        for role in SCOPED_ROLES {
+            let mut data = crate::util::json_map(serde_json::json!({
+                "name": role.name,
+                "permissions": role.permissions,
+            }));
+            crate::util::stamp_created(&mut data);
+            wafer_core::clients::database::create(&ctx, crate::blocks::admin::ROLES_TABLE, data)
+                .await
+                .unwrap();
+        }
+
+        // (role, requests its permission opens)
+        let mut scopes: Vec<(&str, Vec<(&str, &str)>)> = vec![
+            (
+                "user_admin",
+                vec![
+                    ("retrieve", "/b/admin/users"),
+                    ("retrieve", "/b/admin/api/users"),
+                    ("update", "/b/admin/api/users/u2"),
+                    ("create", "/b/admin/api/users/u2/reset-password"),
+                    ("create", "/b/admin/api/users/import"),
+                    ("create", "/b/admin/api/invitations"),
+                    ("create", "/b/admin/users/u2/disable"),
+                ],
+            ),
+            (
+                "storage_admin",
+                vec![
+                    ("retrieve", "/b/admin/storage"),
+                    ("retrieve", "/b/admin/api/storage/report"),
+                    ("delete", "/b/admin/api/storage/buckets/docs"),
+                    ("retrieve", "/b/admin/api/cloudstorage/shares"),
+                ],
+            ),
+            ("billing_admin", Vec::new()),
+            (
+                "log_viewer",
+                vec![
+                    ("retrieve", "/b/admin/logs"),
+                    ("retrieve", "/b/admin/api/logs"),
+                ],
+            ),
+        ];
+        #[cfg(feature = "block-files")]
+        scopes[1].1.push(("retrieve", "/b/storage/admin/buckets"));
+        #[cfg(feature = "block-products")]
+        scopes[2].1.extend([
+            ("retrieve", "/b/products/admin/purchases"),
+            ("retrieve", "/b/products/api/admin/purchases"),
+            ("update", "/b/products/api/admin/purchases/p1/refund"),
+            ("create", "/b/products/api/admin/products"),
+        ]);
+        // Admin-only whatever the scoped role.
+        let admin_only = [
+            ("retrieve", "/b/admin/"),
+            ("retrieve", "/b/admin/api/database/tables"),
+            ("create", "/b/admin/database/query"),
+            ("create", "/b/admin/api/iam/user-roles"),
+            ("retrieve", "/b/admin/api/settings"),
+        ];
+
+        let send = |action: &str, path: &str, roles: &str| {
+            let mut msg = auth_msg(action, path, "u1");
+            msg.set_meta("auth.user_roles", roles);
+            route_to_block(&ctx, msg, InputStream::empty(), &AllEnabled, &[], &[])
+        };
+        async fn dispatched(out: OutputStream) -> bool {
+            out.collect_buffered()
+                .await
+                .is_ok_and(|b| b.body == b"DISPATCHED")
+        }
+
+        for (role, inside) in &scopes {
+            let roles = format!("user,{role}");
+            for &(action, path) in inside {
+                let out = send(action, path, &roles).await;
+                assert!(dispatched(out).await, "{role} {action} {path}");
+            }
+            let outside = scopes
+                .iter()
+                .filter(|(other, _)| other != role)
+                .flat_map(|(_, requests)| requests.iter())
+                .chain(admin_only.iter());
+            for &(action, path) in outside {
+                let out = send(action, path, &roles).await;
+                assert!(!dispatched(out).await, "{role} {action} {path}");
+            }
+        }
+
+        // The full admin role is still the superset.
+        let all = scopes
+            .iter()
+            .flat_map(|(_, r)| r.iter())
+            .chain(admin_only.iter());
+        for &(action, path) in all {
+            let out = send(action, path, "admin").await;
+            assert!(dispatched(out).await, "admin {action} {path}");
+        }
+    }
+
+    #[test]
+    fn ui_mode_gates_only_pages() {
+        use UiMode::*;
+
+        assert_eq!(UiMode::from_flags(false, false), Full);
+        assert_eq!(UiMode::from_flags(false, true), Full);
+        assert_eq!(UiMode::from_flags(true, true), AuthPagesOnly);
+        assert_eq!(UiMode::from_flags(true, false), Off);
+
+        // (action, path, Full, AuthPagesOnly, Off)
+        let cases = [
+            ("retrieve", "/b/auth/login", true, true, false),
+            ("retrieve", "/b/auth/signup", true, true, false),
+            ("retrieve", "/b/auth/reset-password", true, true, false),
+            ("retrieve", "/b/auth/orgs", true, false, false),
+            ("retrieve", "/b/admin/", true, false, false),
+            ("retrieve", "/b/userportal/", true, false, false),
+            ("retrieve", "/b/storage/docs/", true, false, false),
+            // Not pages: served in every mode.
+            ("create", "/b/auth/api/login", true, true, true),
+            ("retrieve", "/b/auth/api/me", true, true, true),
+            ("retrieve", "/b/auth/oauth/callback", true, true, true),
+            ("retrieve", "/b/static/app.css", true, true, true),
+            ("retrieve", "/b/storage/direct/tok", true, true, true),
+            ("retrieve", "/b/admin/api/users", true, true, true),
+            ("retrieve", "/health", true, true, true),
+        ];
+        for (action, path, full, auth_only, off) in cases {
+            assert_eq!(Full.allows(action, path), full, "Full {action} {path}");
+            assert_eq!(
+                AuthPagesOnly.allows(action, path),
+                auth_only,
+                "AuthPagesOnly {action} {path}"
+            );
+            assert_eq!(Off.allows(action, path), off, "Off {action} {path}");
+        }
+    }
+
+    /// The router applies the UI mode to built-in routes and to `/`, where
+    /// a landing page (the "home") wins over every mode.
+    #[tokio::test]
+    async fn router_applies_ui_mode_and_landing_page() {
+        use std::sync::Arc;
+
+        use crate::test_support::{anon_msg, auth_msg, TestContext};
+
+        /// `"dispatched"`, `"302 {location}"`, or `"not found"`.
+        async fn outcome(
+            disable_ui: bool,
+            auth_pages: bool,
+            landing: bool,
+            msg: Message,
+        ) -> String {
+            let mut ctx = TestContext::new().await;
+            for name in ["suppers-ai/auth-ui", "suppers-ai/admin", "wafer-run/web"] {
+                ctx.register_block(name, Arc::new(EchoBlock));
+            }
+            ctx.set_config("SOLOBASE_SHARED__DISABLE_UI", &disable_ui.to_string());
+            ctx.set_config(
+                "SOLOBASE_SHARED__ENABLE_AUTH_PAGES",
+                &auth_pages.to_string(),
+            );
+            ctx.set_config("SOLOBASE_SHARED__HAS_LANDING_PAGE", &landing.to_string());
+            let out = route_to_block(&ctx, msg, InputStream::empty(), &AllEnabled, &[], &[]).await;
+            let Ok(buf) = out.collect_buffered().await else {
+                return "not found".to_string();
+            };
+            if buf.body == b"DISPATCHED" {
+                return "dispatched".to_string();
+            }
+            let location = buf
+                .meta
+                .iter()
+                .find(|m| m.key == "resp.header.Location")
+                .map_or("", |m| m.value.as_str());
+            format!("302 {location}")
+        }
+
+        let login = || anon_msg("retrieve", "/b/auth/login");
+        let login_api = || anon_msg("create", "/b/auth/api/login");
+        let admin_page = || auth_msg("retrieve", "/b/admin/users", "u1");
+        let root_anon = || anon_msg("retrieve", "/");
+        let root_user = || auth_msg("retrieve", "/", "u1");
+
+        // UI on: ENABLE_AUTH_PAGES is irrelevant.
+        for auth_pages in [false, true] {
+            assert_eq!(
+                outcome(false, auth_pages, false, login()).await,
+                "dispatched"
+            );
+            assert_eq!(
+                outcome(false, auth_pages, false, admin_page()).await,
+                "dispatched"
+            );
+            let root = outcome(false, auth_pages, false, root_anon()).await;
+            assert_eq!(root, "302 /b/auth/login");
+            let root = outcome(false, auth_pages, false, root_user()).await;
+            assert_eq!(root, "302 /b/userportal/");
+        }
+
+        // UI off, auth pages on: login only; `/` still leads anonymous
+        // visitors to it.
+        assert_eq!(outcome(true, true, false, login()).await, "dispatched");
+        assert_eq!(outcome(true, true, false, admin_page()).await, "not found");
+        assert_eq!(
+            outcome(true, true, false, root_anon()).await,
+            "302 /b/auth/login"
+        );
+        assert_eq!(outcome(true, true, false, root_user()).await, "not found");
+
+        // Headless: no pages, APIs unaffected.
+        assert_eq!(outcome(true, false, false, login()).await, "not found");
+        assert_eq!(outcome(true, false, false, login_api()).await, "dispatched");
+        assert_eq!(outcome(true, false, false, root_anon()).await, "not found");
+
+        // A landing page is served at `/` in every mode.
+        for (disable_ui, auth_pages) in [(false, false), (true, true), (true, false)] {
+            let root = outcome(disable_ui, auth_pages, true, root_anon()).await;
+            assert_eq!(
+                root, "dispatched",
+                "landing page with {disable_ui}/{auth_pages}"
+            );
+        }
+    }
+
+    #[test]
+    fn block_for_follows_route_precedence() {
+        let extra = [ExtraRoute {
+            prefix: "/b/custom".to_string(),
+            access: RouteAccess::Public,
+            block_name: "acme/custom".to_string(),
+            source: RouteSource::Custom,
+        }];
+        assert_eq!(
+            block_for("/b/storage/api/buckets", &extra),
+            Some("suppers-ai/files")
+        );
+        assert_eq!(
+            block_for("/b/legalpages/admin/x", &extra),
+            Some("suppers-ai/legalpages")
+        );
+        assert_eq!(block_for("/b/custom/thing", &extra), Some("acme/custom"));
+        assert_eq!(block_for("/", &extra), None);
+        assert_eq!(block_for("/nowhere", &extra), None);
+    }
+
+    /// Route table as the two separate dispatch loops (built-in `ROUTES`,
+    /// then extra routes) resolved it before they were merged into
+    /// [`resolve`]. The merged matcher must give the same answer for every
+    /// path, including an extra route repeating a built-in prefix.
+    #[test]
+    fn resolve_matches_the_former_dispatch_loops() {
+        let extra = prepare_extra_routes(vec![
+            extra("/b/auth/", RouteAccess::Admin),
+            extra("/b/chat/admin", RouteAccess::Admin),
+            ExtraRoute {
+                source: RouteSource::Extension,
+                ..extra("/b/chat/", RouteAccess::Public)
+            },
+        ])
+        .unwrap();
+        let cases: &[(&str, Option<(&str, &str, RouteAccess, RouteSource)>)] = &[
+            (
+                "/health",
+                Some((
+                    "suppers-ai/system",
+                    "suppers-ai/system",
+                    RouteAccess::Public,
+                    RouteSource::Core,
+                )),
+            ),
+            (
+                "/b/inspector/blocks",
+                Some((
+                    "suppers-ai/inspector",
+                    "wafer-run/inspector",
+                    RouteAccess::Admin,
+                    RouteSource::Core,
+                )),
+            ),
+            (
+                "/b/auth/login",
+                Some((
+                    "suppers-ai/auth-ui",
+                    "suppers-ai/auth-ui",
+                    RouteAccess::Public,
+                    RouteSource::Core,
+                )),
+            ),
+            (
+                "/b/admin/settings/x",
+                Some((
+                    "suppers-ai/admin",
+                    "suppers-ai/admin",
+                    RouteAccess::Admin,
+                    RouteSource::Core,
+                )),
+            ),
+            (
+                "/b/hooks/in/abc",
+                Some((
+                    "suppers-ai/admin",
+                    "suppers-ai/admin",
+                    RouteAccess::Public,
+                    RouteSource::Core,
+                )),
+            ),
+            (
+                "/b/legalpages/admin/x",
+                Some((
+                    "suppers-ai/legalpages",
+                    "suppers-ai/legalpages",
+                    RouteAccess::Admin,
+                    RouteSource::Core,
+                )),
+            ),
+            (
+                "/b/chat/admin/rooms",
+                Some((
+                    "test/echo",
+                    "test/echo",
+                    RouteAccess::Admin,
+                    RouteSource::Custom,
+                )),
+            ),
+            (
+                "/b/chat/rooms",
+                Some((
+                    "test/echo",
+                    "test/echo",
+                    RouteAccess::Public,
+                    RouteSource::Extension,
+                )),
+            ),
+            ("/", None),
+            ("/b/nowhere", None),
+        ];
+        for (path, expected) in cases {
+            let got = resolve(path, &extra).map(|r| (r.block, r.dispatch_to, r.access, r.source));
+            assert_eq!(got, *expected, "{path}");
+            assert_eq!(block_for(path, &extra), expected.map(|e| e.0), "{path}");
+        }
+    }
+
+    #[test]
+    fn route_listing_covers_every_prefix_and_declared_endpoint_once() {
+        let infos = crate::blocks::all_block_infos();
+        let listing = route_listing(&infos, &[]);
+
+        let mut seen = std::collections::HashSet::new();
+        for entry in &listing {
+            assert!(
+                seen.insert((entry.method.as_str(), entry.path.as_str())),
+                "{} {} listed twice",
+                entry.method,
+                entry.path
+            );
+            assert_eq!(entry.source, RouteSource::Core);
+        }
+        for route in ROUTES {
+            let path = format!("{}**", route.prefix);
+            assert!(
+                listing.iter().any(|e| e.method == "*" && e.path == path),
+                "{path} missing"
+            );
+        }
+        for (method, path) in PIPELINE_ENDPOINTS {
+            assert!(listing
+                .iter()
+                .any(|e| e.method == method.to_string() && e.path == *path));
+        }
+        // Every endpoint a routed block declares under its own prefix is
+        // listed, at no weaker a tier than it declares.
+        for info in &infos {
+            for ep in &info.endpoints {
+                if block_for(&ep.path, &[]) != Some(info.name.as_str()) {
+                    continue;
+                }
+                let entry = listing
+                    .iter()
+                    .find(|e| e.method == ep.method.to_string() && e.path == ep.path)
+                    .unwrap_or_else(|| panic!("{} {} missing", ep.method, ep.path));
+                assert!(entry.auth >= RouteAccess::from_auth_level(ep.auth));
+            }
+        }
+    }
+
+    #[test]
+    fn route_listing_reports_sources_and_skips_shadowed_routes() {
+        use wafer_run::{AuthLevel, BlockEndpoint};
+
+        let guestbook =
+            BlockInfo::new("acme/guestbook", "1.0.0", "http-handler@v1", "").endpoints(vec![
+                BlockEndpoint::get("/b/guestbook/entries").auth(AuthLevel::Public),
+                BlockEndpoint::delete("/b/guestbook/entries/{id}").auth(AuthLevel::Admin),
+                // Under a built-in prefix: unreachable, so not listed.
+                BlockEndpoint::get("/b/auth/guestbook").auth(AuthLevel::Public),
+            ]);
+        let extra = prepare_extra_routes(vec![
+            ExtraRoute {
+                prefix: "/b/guestbook/".to_string(),
+                access: RouteAccess::Public,
+                block_name: "acme/guestbook".to_string(),
+                source: RouteSource::Extension,
+            },
+            extra("/b/custom", RouteAccess::Authenticated),
+            extra("/b/auth/", RouteAccess::Public),
+        ])
+        .unwrap();
+        let listing = route_listing(&[guestbook], &extra);
+        let find = |method: &str, path: &str| {
+            listing
+                .iter()
+                .find(|e| e.method == method && e.path == path)
+                .map(|e| (e.auth, e.block.as_str(), e.source))
+        };
+
+        assert_eq!(
+            find("*", "/b/guestbook/**"),
+            Some((
+                RouteAccess::Public,
+                "acme/guestbook",
+                RouteSource::Extension
+            ))
+        );
+        assert_eq!(
+            find("DELETE", "/b/guestbook/entries/{id}"),
+            Some((RouteAccess::Admin, "acme/guestbook", RouteSource::Extension))
+        );
+        assert_eq!(
+            find("*", "/b/custom**"),
+            Some((RouteAccess::Authenticated, "test/echo", RouteSource::Custom))
+        );
+        assert_eq!(find("GET", "/b/auth/guestbook"), None);
+        let auth: Vec<_> = listing.iter().filter(|e| e.path == "/b/auth/**").collect();
+        assert_eq!(auth.len(), 1);
+        assert_eq!(auth[0].source, RouteSource::Core);
+    }
+
+    #[test]
+    fn long_running_endpoints_are_matched_by_method_and_template() {
+        assert!(is_long_running(
+            "create",
+            "/b/storage/api/buckets/docs/objects"
+        ));
+        assert!(is_long_running(
+            "retrieve",
+            "/b/storage/api/buckets/docs/objects/a/b/report.pdf"
+        ));
+        assert!(is_long_running("create", "/b/llm/api/chat/stream"));
+        assert!(is_long_running("retrieve", "/sync"));
+        // Same path, different method: listing objects is an ordinary request.
+        assert!(!is_long_running(
+            "retrieve",
+            "/b/storage/api/buckets/docs/objects"
+        ));
+        assert!(!is_long_running("retrieve", "/b/storage/api/buckets"));
+    }
+
+    #[tokio::test]
+    async fn failed_schema_answers_503_instead_of_dispatching() {
+        use crate::test_support::{anon_msg, output_json, output_status, TestContext};
+
+        let block = "test/schema-failed-route";
+        crate::schema_status::record(block, &[], &[], &Err("no such table".into()));
+        let ctx = TestContext::new().await;
+        let extra = vec![ExtraRoute {
+            prefix: "/x/schema-failed".to_string(),
+            access: RouteAccess::Public,
+            block_name: block.to_string(),
+            source: RouteSource::Custom,
+        }];
+        let route = || {
+            route_to_block(
+                &ctx,
+                anon_msg("retrieve", "/x/schema-failed/items"),
+                InputStream::empty(),
+                &AllEnabled,
+                &[],
+                &extra,
+            )
+        };
+
+        assert_eq!(output_status(route().await).await, 503);
+        let body = output_json(route().await).await;
+        assert_eq!(body["code"], "schema_not_initialized");
+        assert_eq!(body["details"]["block"], block);
+    }
+
     #[test]
     fn feature_gating_all_enabled() {
         let all = AllEnabled;
@@ -628,6 +1614,32 @@ mod tests {
         }
     }
 
+    #[test]
+    fn feature_blocks_are_compiled_in_iff_the_build_lists_them() {
+        let infos: Vec<String> = crate::blocks::all_block_infos()
+            .into_iter()
+            .map(|i| i.name)
+            .collect();
+        for block in [
+            "suppers-ai/files",
+            "suppers-ai/legalpages",
+            "suppers-ai/llm",
+            "suppers-ai/messages",
+            "suppers-ai/products",
+            "suppers-ai/userportal",
+            "suppers-ai/vector",
+            "suppers-ai/fastembed",
+        ] {
+            assert_eq!(
+                crate::blocks::is_compiled_in(block),
+                infos.iter().any(|n| n == block),
+                "{block}"
+            );
+        }
+        assert!(crate::blocks::is_compiled_in("suppers-ai/admin"));
+        assert!(crate::blocks::is_compiled_in("acme/extension"));
+    }
+
     #[test]
     fn legalpages_admin_routes_require_admin() {
         let admin_route = ROUTES
@@ -679,7 +1691,10 @@ mod tests {
     fn all_block_routes_are_under_b_prefix() {
         for route in ROUTES {
             let is_system = route.block == "suppers-ai/system";
-            if !is_system {
+            // The S3 gateway and bucket websites keep the paths their
+            // clients expect.
+            let is_storage_root = ["/s3", "/sites/"].contains(&route.prefix);
+            if !is_system && !is_storage_root {
                 assert!(
                     route.prefix.starts_with("/b/"),
                     "block route {} should start with /b/",
@@ -756,4 +1771,159 @@ mod tests {
         assert_eq!(query["auth"], "authenticated");
         assert_eq!(query["block"], "suppers-ai/vector");
     }
+
+    /// End-to-end: the cloudstorage dashboard is reachable through the route
+    /// table, and the content-hashed browser script it references is served
+    /// publicly by the system block with immutable caching headers. The
+    /// `/b/cloudstorage/` prefix is `Public` in [`ROUTES`]; the files block
+    /// declares the page endpoint `Authenticated`, and that declaration is
+    /// what sends anonymous browsers to login (and API callers a 403).
+    #[cfg(feature = "block-files")]
+    #[tokio::test]
+    async fn cloudstorage_dashboard_and_assets_are_served_through_the_router() {
+        use std::sync::Arc;
+
+        use wafer_run::Block as RunBlock;
+
+        use crate::{
+            blocks::{files::FilesBlock, system::SystemBlock},
+            test_support::{
+                anon_msg, auth_msg, output_body, output_header, output_html, output_status,
+                TestContext,
+            },
+        };
+
+        let mut ctx = TestContext::with_files().await;
+        ctx.register_block("suppers-ai/files", Arc::new(FilesBlock::new()));
+        ctx.register_block("suppers-ai/system", Arc::new(SystemBlock::new()));
+        let infos = vec![FilesBlock::new().info(), SystemBlock::new().info()];
+        let get = |msg: Message| {
+            route_to_block(&ctx, msg, InputStream::empty(), &AllEnabled, &infos, &[])
+        };
+        let dashboard = || auth_msg("retrieve", "/b/cloudstorage/", "u1");
+
+        assert_eq!(output_status(get(dashboard()).await).await, 200);
+        let html = output_html(get(dashboard()).await).await;
+        assert!(html.contains("Storage quota"), "dashboard markup: {html}");
+        let script = crate::ui::assets::files_browser_js_url();
+        assert!(html.contains(script), "dashboard must reference {script}");
+
+        let cache = output_header(get(anon_msg("retrieve", script)).await, "Cache-Control").await;
+        assert!(cache.is_some_and(|v| v.contains("immutable")));
+        assert_eq!(
+            output_body(get(anon_msg("retrieve", script)).await).await,
+            crate::ui::assets::files_browser_js().as_bytes()
+        );
+
+        let anon_dashboard = || anon_msg("retrieve", "/b/cloudstorage/");
+        assert_eq!(output_status(get(anon_dashboard()).await).await, 403);
+        let mut browser = anon_dashboard();
+        browser.set_meta("http.header.accept", "text/html");
+        let login = get(browser).await;
+        let location = output_header(login, "Location").await;
+        assert!(
+            location.is_some_and(|l| l.starts_with("/b/auth/login?redirect=")),
+            "anonymous browsers are sent to login"
+        );
+    }
+
+    #[test]
+    fn normalize_path_canonicalizes_segments() {
+        let cases = [
+            ("/", "/"),
+            ("/b/admin", "/b/admin"),
+            ("/b/admin/", "/b/admin/"),
+            ("//b///admin//users", "/b/admin/users"),
+            ("/b/./admin/.", "/b/admin/"),
+            ("/b/auth/../admin/users", "/b/admin/users"),
+            ("/b/auth/%2e%2e/admin/users", "/b/admin/users"),
+            ("/b/auth/.%2E/%2e/admin", "/b/admin"),
+            ("/../../b/admin", "/b/admin"),
+            ("/b/admin/users/..", "/b/admin/"),
+            ("/b/products/..x/y", "/b/products/..x/y"),
+            ("/b/files/a%2fb", "/b/files/a%2fb"),
+            ("", ""),
+        ];
+        for (raw, expected) in cases {
+            assert_eq!(
+                normalize_path(raw).as_deref(),
+                Some(expected),
+                "normalizing {raw:?}"
+            );
+        }
+    }
+
+    #[test]
+    fn normalize_path_rejects_control_characters() {
+        for raw in [
+            "/b/admin/\0users",
+            "/b/auth/login\n",
+            "/b/admin/%00users",
+            "/b/auth/login%0A",
+            "/b/admin/%7f",
+        ] {
+            assert_eq!(normalize_path(raw), None, "{raw:?} should be rejected");
+        }
+    }
+
+    fn extra(prefix: &str, access: RouteAccess) -> ExtraRoute {
+        ExtraRoute {
+            prefix: prefix.to_string(),
+            access,
+            block_name: "test/echo".to_string(),
+            source: RouteSource::Custom,
+        }
+    }
+
+    #[test]
+    fn extra_routes_are_ordered_longest_prefix_first() {
+        let routes = prepare_extra_routes(vec![
+            extra("/b/chat/", RouteAccess::Public),
+            extra("/b/chat/admin", RouteAccess::Admin),
+            extra("/b/other", RouteAccess::Public),
+        ])
+        .unwrap();
+        let prefixes: Vec<&str> = routes.iter().map(|r| r.prefix.as_str()).collect();
+        assert_eq!(prefixes, ["/b/chat/admin", "/b/chat/", "/b/other"]);
+    }
+
+    #[test]
+    fn extra_routes_reject_catch_all_and_unnormalized_prefixes() {
+        for bad in [
+            "",
+            "/",
+            "b/chat",
+            "/b//chat",
+            "/b/chat/../admin",
+            "/b/./chat",
+        ] {
+            assert!(
+                prepare_extra_routes(vec![extra(bad, RouteAccess::Public)]).is_err(),
+                "prefix {bad:?} should be rejected"
+            );
+        }
+        let twice = prepare_extra_routes(vec![
+            extra("/b/chat", RouteAccess::Admin),
+            extra("/b/chat", RouteAccess::Public),
+        ]);
+        assert!(twice.is_err());
+    }
+
+    #[test]
+    fn extra_routes_under_the_versioned_prefix_are_stored_root_relative() {
+        let routes = prepare_extra_routes(vec![extra("/api/v1/b/chat/", RouteAccess::Public)]);
+        assert_eq!(routes.unwrap()[0].prefix, "/b/chat/");
+
+        for bad in ["/api/b/chat/", "/api/v1", "/api/v1/"] {
+            assert!(
+                prepare_extra_routes(vec![extra(bad, RouteAccess::Public)]).is_err(),
+                "prefix {bad:?} should be rejected"
+            );
+        }
+        let twice = prepare_extra_routes(vec![
+            extra("/api/v1/b/chat", RouteAccess::Admin),
+            extra("/b/chat", RouteAccess::Public),
+        ]);
+        assert!(twice.is_err());
+    }
 }
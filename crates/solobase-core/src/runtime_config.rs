@@ -0,0 +1,346 @@
+//! Process-wide snapshot of the few settings that are safe to change on a
+//! running server.
+//!
+//! Everything else in solobase is resolved once — block config at `Init`,
+//! the JWT secret and database at `build()` — so changing it means a
+//! restart. The knobs here (log level, slow-request threshold, maintenance
+//! mode, rate-limit multiplier, upload size cap, query cache) are instead read through
+//! [`load`] on every use, and [`reload`] swaps in a fresh [`RuntimeConfig`]
+//! in one step, so a request never sees half of an old snapshot and half of
+//! a new one.
+//!
+//! Re-reading configuration is platform work: native installs
+//! [`ReloadHooks`] at boot (and re-reads on SIGHUP), while the stateless
+//! targets never call [`install`] and keep the defaults — a Worker redeploy
+//! already restarts every isolate. Without hooks [`reload`] reports
+//! [`ReloadError::Unsupported`].
+//!
+//! The keys are `SOLOBASE_*` infrastructure variables: they come from the
+//! process environment, never from the admin variables table.
+
+use std::{
+    collections::HashMap,
+    sync::{Arc, LazyLock, OnceLock, RwLock},
+};
+
+/// `tracing` filter directives (e.g. `info,solobase=debug`). Unset restores
+/// the filter the process booted with.
+pub const LOG_LEVEL_KEY: &str = "SOLOBASE_LOG_LEVEL";
+/// Requests whose handler takes at least this many milliseconds — almost
+/// always because of the queries they run — are logged at `warn`. `0` is off.
+pub const SLOW_QUERY_MS_KEY: &str = "SOLOBASE_SLOW_QUERY_MS";
+/// When true, only admins may make changes; everyone else gets read-only
+/// access plus the sign-in flows.
+pub const MAINTENANCE_MODE_KEY: &str = "SOLOBASE_MAINTENANCE_MODE";
+/// Scales every per-user rate-limit bucket (e.g. `0.5` halves them).
+pub const RATE_LIMIT_MULTIPLIER_KEY: &str = "SOLOBASE_RATE_LIMIT_MULTIPLIER";
+/// Upper bound on a single upload, applied on top of per-user quotas.
+pub const MAX_UPLOAD_BYTES_KEY: &str = "SOLOBASE_MAX_UPLOAD_BYTES";
+/// Set to false to bypass the in-process query caches (see
+/// [`crate::cache`]) — every lookup then goes to the database.
+pub const QUERY_CACHE_KEY: &str = "SOLOBASE_QUERY_CACHE";
+
+/// Settings that are read once at boot. A reload reports the ones whose
+/// value changed since then instead of silently ignoring them.
+pub const RESTART_REQUIRED_KEYS: &[&str] = &[
+    crate::blocks::auth::JWT_SECRET_KEY,
+    "SOLOBASE_LISTEN",
+    "SOLOBASE_DB_TYPE",
+    "SOLOBASE_DB_PATH",
+    "SOLOBASE_DB_URL",
+    "SOLOBASE_STORAGE_TYPE",
+    "SOLOBASE_STORAGE_ROOT",
+];
+
+/// One immutable snapshot of the hot-reloadable settings.
+#[derive(Debug, Clone, PartialEq)]
+pub struct RuntimeConfig {
+    pub log_level: Option<String>,
+    pub slow_query_ms: u64,
+    pub maintenance_mode: bool,
+    pub rate_limit_multiplier: f64,
+    pub max_upload_bytes: Option<i64>,
+    pub query_cache: bool,
+}
+
+impl Default for RuntimeConfig {
+    fn default() -> Self {
+        Self {
+            log_level: None,
+            slow_query_ms: 0,
+            maintenance_mode: false,
+            rate_limit_multiplier: 1.0,
+            max_upload_bytes: None,
+            query_cache: true,
+        }
+    }
+}
+
+impl RuntimeConfig {
+    /// Build a snapshot from `vars`. Missing or unparseable values fall back
+    /// to the defaults, so a typo never takes a running server down.
+    pub fn from_vars(vars: &HashMap<String, String>) -> Self {
+        let defaults = Self::default();
+        let get = |key: &str| vars.get(key).map(|v| v.trim()).filter(|v| !v.is_empty());
+        Self {
+            log_level: get(LOG_LEVEL_KEY).map(str::to_string),
+            slow_query_ms: get(SLOW_QUERY_MS_KEY)
+                .and_then(|v| v.parse().ok())
+                .unwrap_or(defaults.slow_query_ms),
+            maintenance_mode: get(MAINTENANCE_MODE_KEY).is_some_and(|v| {
+                matches!(v.to_ascii_lowercase().as_str(), "true" | "1" | "yes" | "on")
+            }),
+            rate_limit_multiplier: get(RATE_LIMIT_MULTIPLIER_KEY)
+                .and_then(|v| v.parse::<f64>().ok())
+                .filter(|m| m.is_finite() && *m > 0.0)
+                .unwrap_or(defaults.rate_limit_multiplier),
+            max_upload_bytes: get(MAX_UPLOAD_BYTES_KEY)
+                .and_then(|v| v.parse::<i64>().ok())
+                .filter(|n| *n > 0),
+            query_cache: get(QUERY_CACHE_KEY).map_or(defaults.query_cache, |v| {
+                !matches!(
+                    v.to_ascii_lowercase().as_str(),
+                    "false" | "0" | "no" | "off"
+                )
+            }),
+        }
+    }
+
+    /// Each setting keyed by its variable, rendered for the reload report.
+    fn entries(&self) -> [(&'static str, String); 6] {
+        [
+            (LOG_LEVEL_KEY, self.log_level.clone().unwrap_or_default()),
+            (SLOW_QUERY_MS_KEY, self.slow_query_ms.to_string()),
+            (MAINTENANCE_MODE_KEY, self.maintenance_mode.to_string()),
+            (
+                RATE_LIMIT_MULTIPLIER_KEY,
+                self.rate_limit_multiplier.to_string(),
+            ),
+            (
+                MAX_UPLOAD_BYTES_KEY,
+                self.max_upload_bytes
+                    .map(|n| n.to_string())
+                    .unwrap_or_default(),
+            ),
+            (QUERY_CACHE_KEY, self.query_cache.to_string()),
+        ]
+    }
+
+    /// `max_requests` scaled by the rate-limit multiplier, never below 1 so
+    /// a small multiplier can't turn a limit into "disabled".
+    pub fn scale_rate_limit(&self, max_requests: u32) -> u32 {
+        let scaled = (f64::from(max_requests) * self.rate_limit_multiplier).round();
+        (scaled as u32).max(1)
+    }
+}
+
+static CURRENT: LazyLock<RwLock<Arc<RuntimeConfig>>> =
+    LazyLock::new(|| RwLock::new(Arc::new(RuntimeConfig::default())));
+
+/// The current snapshot. Cheap: one read lock and an `Arc` clone.
+pub fn load() -> Arc<RuntimeConfig> {
+    // Swapping an `Arc` can't leave the value half-written, so recover from
+    // a poisoned lock rather than fail every later read.
+    CURRENT.read().unwrap_or_else(|e| e.into_inner()).clone()
+}
+
+/// Replace the current snapshot, returning the one it replaced.
+pub fn store(config: RuntimeConfig) -> Arc<RuntimeConfig> {
+    let mut current = CURRENT.write().unwrap_or_else(|e| e.into_inner());
+    std::mem::replace(&mut *current, Arc::new(config))
+}
+
+/// How a platform re-reads its configuration.
+pub struct ReloadHooks {
+    /// Current values of the process's configuration variables.
+    pub read_vars: Box<dyn Fn() -> HashMap<String, String> + Send + Sync>,
+    /// Apply [`RuntimeConfig::log_level`] to the live log filter. `None`
+    /// restores the boot filter.
+    pub apply_log_level: fn(Option<&str>) -> Result<(), String>,
+}
+
+struct Installed {
+    hooks: ReloadHooks,
+    /// [`RESTART_REQUIRED_KEYS`] as they were at boot.
+    boot_values: HashMap<&'static str, Option<String>>,
+}
+
+static INSTALLED: OnceLock<Installed> = OnceLock::new();
+
+/// A setting whose value differs between two snapshots.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct Change {
+    pub key: &'static str,
+    pub old: String,
+    pub new: String,
+}
+
+/// What a [`reload`] did.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct ReloadReport {
+    /// Hot-reloadable settings that took effect.
+    pub changed: Vec<Change>,
+    /// Boot-time settings that changed but only apply after a restart.
+    /// Names only — the JWT secret is among them.
+    pub requires_restart: Vec<&'static str>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum ReloadError {
+    /// The platform never called [`install`].
+    Unsupported,
+    /// The new log filter was rejected; nothing was applied.
+    InvalidLogLevel(String),
+}
+
+impl std::fmt::Display for ReloadError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Self::Unsupported => write!(f, "live configuration reload is not supported here"),
+            Self::InvalidLogLevel(e) => write!(f, "invalid {LOG_LEVEL_KEY}: {e}"),
+        }
+    }
+}
+
+/// Install the platform's reload hooks and load the first snapshot from
+/// them. Native calls this once at boot; only the first call wins.
+///
+/// # Errors
+///
+/// Returns [`ReloadError::InvalidLogLevel`] if the configured log level
+/// can't be parsed; the remaining settings are still applied.
+pub fn install(hooks: ReloadHooks) -> Result<(), ReloadError> {
+    let vars = (hooks.read_vars)();
+    let boot_values = RESTART_REQUIRED_KEYS
+        .iter()
+        .map(|key| (*key, vars.get(*key).cloned()))
+        .collect();
+    let mut config = RuntimeConfig::from_vars(&vars);
+    let applied = (hooks.apply_log_level)(config.log_level.as_deref());
+    if applied.is_err() {
+        config.log_level = None;
+    }
+    store(config);
+    let _ = INSTALLED.set(Installed { hooks, boot_values });
+    applied.map_err(ReloadError::InvalidLogLevel)
+}
+
+/// Re-read the configuration through the installed hooks and swap in the
+/// new snapshot.
+///
+/// # Errors
+///
+/// [`ReloadError::Unsupported`] when no hooks are installed, or
+/// [`ReloadError::InvalidLogLevel`] when the new log filter is rejected —
+/// in which case the previous snapshot stays in place untouched.
+pub fn reload() -> Result<ReloadReport, ReloadError> {
+    let installed = INSTALLED.get().ok_or(ReloadError::Unsupported)?;
+    let vars = (installed.hooks.read_vars)();
+    let next = RuntimeConfig::from_vars(&vars);
+    let current = load();
+    if next.log_level != current.log_level {
+        (installed.hooks.apply_log_level)(next.log_level.as_deref())
+            .map_err(ReloadError::InvalidLogLevel)?;
+    }
+    let changed = diff(&current, &next);
+    store(next);
+
+    let requires_restart = RESTART_REQUIRED_KEYS
+        .iter()
+        .copied()
+        .filter(|key| installed.boot_values.get(key).cloned().flatten() != vars.get(*key).cloned())
+        .collect();
+    Ok(ReloadReport {
+        changed,
+        requires_restart,
+    })
+}
+
+/// The settings whose value differs from `old` to `new`.
+pub fn diff(old: &RuntimeConfig, new: &RuntimeConfig) -> Vec<Change> {
+    old.entries()
+        .into_iter()
+        .zip(new.entries())
+        .filter(|((_, a), (_, b))| a != b)
+        .map(|((key, old), (_, new))| Change { key, old, new })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn vars(pairs: &[(&str, &str)]) -> HashMap<String, String> {
+        pairs
+            .iter()
+            .map(|(k, v)| ((*k).to_string(), (*v).to_string()))
+            .collect()
+    }
+
+    #[test]
+    fn from_vars_parses_every_setting() {
+        let cfg = RuntimeConfig::from_vars(&vars(&[
+            (LOG_LEVEL_KEY, "warn,solobase=debug"),
+            (SLOW_QUERY_MS_KEY, "250"),
+            (MAINTENANCE_MODE_KEY, "on"),
+            (RATE_LIMIT_MULTIPLIER_KEY, "2.5"),
+            (MAX_UPLOAD_BYTES_KEY, "1048576"),
+            (QUERY_CACHE_KEY, "off"),
+        ]));
+        assert_eq!(cfg.log_level.as_deref(), Some("warn,solobase=debug"));
+        assert_eq!(cfg.slow_query_ms, 250);
+        assert!(cfg.maintenance_mode);
+        assert_eq!(cfg.rate_limit_multiplier, 2.5);
+        assert_eq!(cfg.max_upload_bytes, Some(1_048_576));
+        assert!(!cfg.query_cache);
+    }
+
+    #[test]
+    fn from_vars_falls_back_on_garbage() {
+        let cfg = RuntimeConfig::from_vars(&vars(&[
+            (LOG_LEVEL_KEY, "  "),
+            (SLOW_QUERY_MS_KEY, "soon"),
+            (MAINTENANCE_MODE_KEY, "maybe"),
+            (RATE_LIMIT_MULTIPLIER_KEY, "-1"),
+            (MAX_UPLOAD_BYTES_KEY, "0"),
+            (QUERY_CACHE_KEY, "maybe"),
+        ]));
+        assert_eq!(cfg, RuntimeConfig::default());
+    }
+
+    #[test]
+    fn scale_rate_limit_rounds_and_never_reaches_zero() {
+        let mut cfg = RuntimeConfig::default();
+        assert_eq!(cfg.scale_rate_limit(120), 120);
+        cfg.rate_limit_multiplier = 0.5;
+        assert_eq!(cfg.scale_rate_limit(120), 60);
+        cfg.rate_limit_multiplier = 0.001;
+        assert_eq!(cfg.scale_rate_limit(120), 1);
+    }
+
+    #[test]
+    fn diff_lists_only_changed_settings() {
+        let old = RuntimeConfig::default();
+        let new = RuntimeConfig {
+            maintenance_mode: true,
+            max_upload_bytes: Some(10),
+            ..RuntimeConfig::default()
+        };
+        assert_eq!(
+            diff(&old, &new),
+            vec![
+                Change {
+                    key: MAINTENANCE_MODE_KEY,
+                    old: "false".into(),
+                    new: "true".into(),
+                },
+                Change {
+                    key: MAX_UPLOAD_BYTES_KEY,
+                    old: String::new(),
+                    new: "10".into(),
+                },
+            ]
+        );
+        assert!(diff(&new, &new).is_empty());
+    }
+}
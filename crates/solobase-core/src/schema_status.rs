@@ -0,0 +1,196 @@
+//! Per-isolate record of each block's schema-migration outcome.
+//!
+//! A block whose `Init` migrations fail used to stay routable: the runtime
+//! logs the `Init` error and boots on, and every request then hit a missing
+//! table and surfaced as an opaque 500. [`record`] (called from
+//! [`crate::migration_helper::lifecycle_init`] and auth's service `init`)
+//! notes the outcome here, the router consults [`failure`] to answer a
+//! failed block's routes with a 503 instead of dispatching, and the admin
+//! extensions API reads [`snapshot`] and drives [`retry`].
+//!
+//! The registry is process memory, like the migration gate's cached
+//! `BlockSettings`: a restart (or a fresh Worker isolate) re-runs every
+//! block's `Init` and rebuilds it. The persisted `enabled` flag is left
+//! alone so an operator's toggle is never overwritten by a transient DDL
+//! failure.
+
+use std::{collections::BTreeMap, sync::Mutex};
+
+use wafer_run::context::Context;
+
+use crate::migration_helper;
+
+/// A block's migration scripts as handed to `lifecycle_init`, retained so
+/// [`retry`] can re-run them without knowing about the block.
+struct Entry {
+    sqlite: &'static [(&'static str, &'static str)],
+    postgres: &'static [&'static str],
+    error: Option<String>,
+}
+
+static REGISTRY: Mutex<BTreeMap<String, Entry>> = Mutex::new(BTreeMap::new());
+
+/// One block's schema state as reported to admins.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct SchemaReport {
+    pub block: String,
+    /// `"applied"` or `"failed"`.
+    pub status: &'static str,
+    /// The DDL error captured from the last attempt, if it failed.
+    pub error: Option<String>,
+    /// Tables the block's migrations create (SQLite dialect).
+    pub expected_tables: Vec<String>,
+}
+
+fn registry() -> std::sync::MutexGuard<'static, BTreeMap<String, Entry>> {
+    // A panic while holding the lock cannot leave an entry half-written
+    // (each mutation is a single insert), so recover rather than poison
+    // every later route check.
+    REGISTRY.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Record the outcome of applying `block`'s migrations.
+pub fn record(
+    block: &str,
+    sqlite: &'static [(&'static str, &'static str)],
+    postgres: &'static [&'static str],
+    result: &Result<(), String>,
+) {
+    let error = result.as_ref().err().cloned();
+    if let Some(e) = &error {
+        tracing::error!(
+            block = %block,
+            err = %e,
+            "schema setup failed; block disabled until migrations are retried"
+        );
+    }
+    registry().insert(
+        block.to_string(),
+        Entry {
+            sqlite,
+            postgres,
+            error,
+        },
+    );
+}
+
+/// The captured error when `block`'s last migration attempt failed. `None`
+/// for blocks that applied cleanly or never reported (blocks without
+/// migrations).
+pub fn failure(block: &str) -> Option<String> {
+    registry().get(block).and_then(|e| e.error.clone())
+}
+
+/// Every block that has reported, sorted by name.
+pub fn snapshot() -> Vec<SchemaReport> {
+    registry()
+        .iter()
+        .map(|(block, entry)| SchemaReport {
+            block: block.clone(),
+            status: if entry.error.is_some() {
+                "failed"
+            } else {
+                "applied"
+            },
+            error: entry.error.clone(),
+            expected_tables: entry
+                .sqlite
+                .iter()
+                .flat_map(|(_, sql)| created_tables(sql))
+                .collect(),
+        })
+        .collect()
+}
+
+/// Re-run `block`'s migrations and record the new outcome. `Err` carries
+/// the DDL error (or says the block never registered migrations).
+pub async fn retry(ctx: &dyn Context, block: &str) -> Result<(), String> {
+    let registered = registry().get(block).map(|e| (e.sqlite, e.postgres));
+    let Some((sqlite, postgres)) = registered else {
+        return Err(format!("{block} has no registered migrations"));
+    };
+    let result = migration_helper::apply_scoped(ctx, block, sqlite, postgres).await;
+    record(block, sqlite, postgres, &result);
+    result
+}
+
+/// Table names declared by `CREATE TABLE [IF NOT EXISTS] <name>` statements
+/// in `sql`, in order.
+pub fn created_tables(sql: &str) -> Vec<String> {
+    let mut out = Vec::new();
+    for stmt in sql.split(';') {
+        let words: Vec<&str> = stmt
+            .lines()
+            .map(|l| l.split("--").next().unwrap_or(""))
+            .flat_map(str::split_whitespace)
+            .collect();
+        let is_create_table =
+            |w: &[&str]| w[0].eq_ignore_ascii_case("create") && w[1].eq_ignore_ascii_case("table");
+        let Some(pos) = words.windows(2).position(is_create_table) else {
+            continue;
+        };
+        let mut rest = &words[pos + 2..];
+        if rest.len() >= 3
+            && rest[0].eq_ignore_ascii_case("if")
+            && rest[1].eq_ignore_ascii_case("not")
+            && rest[2].eq_ignore_ascii_case("exists")
+        {
+            rest = &rest[3..];
+        }
+        if let Some(name) = rest.first() {
+            let name = name.split('(').next().unwrap_or(name).trim_matches('"');
+            if !name.is_empty() && !out.iter().any(|t| t == name) {
+                out.push(name.to_string());
+            }
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    static GOOD: &[(&str, &str)] = &[(
+        "001",
+        "CREATE TABLE IF NOT EXISTS t_schema_status_ok (id TEXT PRIMARY KEY);",
+    )];
+    static BAD: &[(&str, &str)] = &[("001", "CREATE TABLE broken (;")];
+
+    #[test]
+    fn created_tables_reads_create_statements() {
+        let sql = "-- header; with a semicolon\n\
+                   CREATE TABLE IF NOT EXISTS a__b (id TEXT);\n\
+                   CREATE INDEX IF NOT EXISTS idx ON a__b(id);\n\
+                   create table c(id TEXT);";
+        assert_eq!(created_tables(sql), vec!["a__b", "c"]);
+    }
+
+    #[test]
+    fn failure_is_recorded_and_cleared() {
+        let block = "test/schema-status-record";
+        record(block, GOOD, &[], &Err("boom".into()));
+        assert_eq!(failure(block).as_deref(), Some("boom"));
+        let report = snapshot().into_iter().find(|r| r.block == block).unwrap();
+        assert_eq!(report.status, "failed");
+        assert_eq!(report.expected_tables, vec!["t_schema_status_ok"]);
+
+        record(block, GOOD, &[], &Ok(()));
+        assert_eq!(failure(block), None);
+    }
+
+    #[tokio::test]
+    async fn retry_reapplies_registered_migrations() {
+        let ctx = TestContext::with_admin().await;
+        let block = "test/schema-status-retry";
+        record(block, GOOD, &[], &Err("earlier failure".into()));
+        retry(&ctx, block).await.expect("retry applies");
+        assert_eq!(failure(block), None);
+
+        record("test/schema-status-bad", BAD, &[], &Ok(()));
+        assert!(retry(&ctx, "test/schema-status-bad").await.is_err());
+        assert!(failure("test/schema-status-bad").is_some());
+        assert!(retry(&ctx, "test/never-registered").await.is_err());
+    }
+}
@@ -0,0 +1,525 @@
+//! Typed per-block view of the platform services a feature block reaches
+//! through its `Context`.
+//!
+//! Every capability here already exists on `ctx` — WRAP checks every
+//! database and storage call against the calling block's grants, and
+//! `suppers-ai/storage` namespaces object keys per block — so [`Services`]
+//! is not a second permission layer. It gives blocks one place to find the
+//! shared helpers they would otherwise each re-implement: block-prefixed
+//! settings, best-effort email through `suppers-ai/email`, the read-only
+//! users directory, feature-flag checks, background jobs and their cron
+//! schedules, long-running operations users poll, the per-user
+//! notification inbox, the event catalog, and a block-tagged tracing span.
+//!
+//! ```ignore
+//! let svc = Services::new(ctx, "suppers-ai/files");
+//! let warn_at = svc.settings().get("QUOTA_WARN_PERCENT", "80").await;
+//! if let Some(user) = svc.users().find_by_id(&owner_id).await? {
+//!     svc.mailer().send("email.send", body).await;
+//! }
+//! if svc.flags().enabled_for(&msg, "files.resumable-uploads").await {
+//!     // new code path
+//! }
+//! svc.jobs().enqueue("files.quota.notify", &payload, Default::default()).await?;
+//! let op = svc.operations().start(&msg, "files.export", "files.export.run", payload).await?;
+//! return operations::accepted(&op, serde_json::json!({}));
+//! svc.schedules().register("files.digest", "0 8 * * MON", "files.digest.send", false)?;
+//! svc.notifications()
+//!     .notify(&owner_id, "files.digest_ready", &NotificationPayload {
+//!         title: "Your weekly digest is ready".into(),
+//!         ..Default::default()
+//!     })
+//!     .await?;
+//! svc.events().register(
+//!     EventType::new("files.digest_ready", 1, "A weekly digest was built.")
+//!         .field(Field::required("url", FieldType::String, "Where to read it")),
+//! )?;
+//! ```
+
+use wafer_core::clients::config;
+use wafer_run::{context::Context, InputStream, Message, WaferError};
+
+use crate::{
+    blocks::{
+        auth::repo::{
+            directory::{self, DirectoryEntry},
+            RepoError,
+        },
+        feature_flags,
+        jobs::{self, EnqueueOptions},
+        notifications::{self, Notification, NotificationPayload},
+        operations::{self, Operation},
+        schedules::{self, ScheduleSpec},
+    },
+    config_ui,
+    config_vars::screaming_block,
+    events::{self, Event, EventType, RegisterError},
+};
+
+/// The block that delivers mail for every other block.
+const EMAIL_BLOCK: &str = "suppers-ai/email";
+
+/// Platform services as seen by one block.
+#[derive(Clone, Copy)]
+pub struct Services<'a> {
+    ctx: &'a dyn Context,
+    block: &'a str,
+}
+
+impl<'a> Services<'a> {
+    /// `block` is the caller's `{org}/{name}` — the same name its
+    /// `BlockInfo` and WRAP grants use.
+    pub fn new(ctx: &'a dyn Context, block: &'a str) -> Self {
+        Self { ctx, block }
+    }
+
+    /// The underlying context, for `database` / `storage` / `network`
+    /// client calls. Those are already WRAP-scoped to this block.
+    pub fn ctx(&self) -> &'a dyn Context {
+        self.ctx
+    }
+
+    /// The block these services are scoped to.
+    pub fn block(&self) -> &'a str {
+        self.block
+    }
+
+    /// Config variables under this block's `{ORG}__{BLOCK}__` prefix.
+    pub fn settings(&self) -> Settings<'a> {
+        Settings {
+            ctx: self.ctx,
+            block: self.block,
+            prefix: format!("{}__", screaming_block(self.block)),
+        }
+    }
+
+    /// Outbound email via `suppers-ai/email`.
+    pub fn mailer(&self) -> Mailer<'a> {
+        Mailer {
+            ctx: self.ctx,
+            block: self.block,
+        }
+    }
+
+    /// Read-only lookups over the live-accounts directory. The calling
+    /// block needs a read grant on `suppers_ai__auth__users_directory`.
+    pub fn users(&self) -> Users<'a> {
+        Users { ctx: self.ctx }
+    }
+
+    /// Feature-flag checks (see [`crate::blocks::feature_flags`]). Every
+    /// block may read the flag definitions; no grant is needed.
+    pub fn flags(&self) -> Flags<'a> {
+        Flags { ctx: self.ctx }
+    }
+
+    /// Deferred work run later by this block (see [`crate::blocks::jobs`]).
+    /// Every block may enqueue; no grant is needed.
+    pub fn jobs(&self) -> Jobs<'a> {
+        Jobs {
+            ctx: self.ctx,
+            block: self.block,
+        }
+    }
+
+    /// Work a request hands to a job of this block while the caller polls
+    /// for its progress and result (see [`crate::blocks::operations`]).
+    /// Every block may start one; no grant is needed.
+    pub fn operations(&self) -> Operations<'a> {
+        Operations {
+            ctx: self.ctx,
+            block: self.block,
+        }
+    }
+
+    /// Recurring jobs run by this block on a cron schedule (see
+    /// [`crate::blocks::schedules`]). Register from `Init`; no grant is
+    /// needed.
+    pub fn schedules(&self) -> Schedules<'a> {
+        Schedules { block: self.block }
+    }
+
+    /// Posts to a user's notification inbox (see
+    /// [`crate::blocks::notifications`]). Every block may post; no grant is
+    /// needed.
+    pub fn notifications(&self) -> Notifications<'a> {
+        Notifications { ctx: self.ctx }
+    }
+
+    /// The event catalog served at [`events::SCHEMA_PATH`]. Register this
+    /// block's event types from `Init`; no grant is needed.
+    pub fn events(&self) -> Events<'a> {
+        Events { block: self.block }
+    }
+
+    /// A tracing span tagged with this block, for grouping a block's log
+    /// lines under one field.
+    pub fn log_span(&self) -> tracing::Span {
+        tracing::info_span!("block", block = %self.block)
+    }
+}
+
+/// Block-namespaced config. Names are the suffix after the prefix:
+/// `settings().get("WEBHOOK_SECRET", "")` on `suppers-ai/products` reads
+/// `SUPPERS_AI__PRODUCTS__WEBHOOK_SECRET`.
+pub struct Settings<'a> {
+    ctx: &'a dyn Context,
+    block: &'a str,
+    prefix: String,
+}
+
+impl Settings<'_> {
+    /// Lay out this block's settings form in the admin UI (see
+    /// [`crate::config_ui`]): titled sections of full config keys, in
+    /// display order. Call from `Init`.
+    pub fn register_sections(&self, sections: Vec<config_ui::Section>) {
+        config_ui::register(self.block, sections);
+    }
+
+    /// Full config key for `name`.
+    pub fn key(&self, name: &str) -> String {
+        format!("{}{name}", self.prefix)
+    }
+
+    /// The configured value of `name`, or `default` when unset.
+    pub async fn get(&self, name: &str, default: &str) -> String {
+        config::get_default(self.ctx, &self.key(name), default).await
+    }
+
+    /// `name` parsed as a boolean (`true`/`1`/`yes`/`on`).
+    pub async fn get_bool(&self, name: &str, default: bool) -> bool {
+        let raw = self.get(name, if default { "true" } else { "false" }).await;
+        matches!(
+            raw.trim().to_ascii_lowercase().as_str(),
+            "true" | "1" | "yes" | "on"
+        )
+    }
+
+    /// Persist a value for `name`.
+    pub async fn set(&self, name: &str, value: &str) -> Result<(), WaferError> {
+        config::set(self.ctx, &self.key(name), value).await
+    }
+}
+
+/// Best-effort mail delivery. A failed send is logged with the calling
+/// block and reported as `false`; it never fails the caller's request.
+pub struct Mailer<'a> {
+    ctx: &'a dyn Context,
+    block: &'a str,
+}
+
+impl Mailer<'_> {
+    /// Send one `suppers-ai/email` op (`email.send`, `email.send_template`)
+    /// with a JSON body. Returns whether the email block accepted it.
+    pub async fn send(&self, kind: &str, body: serde_json::Value) -> bool {
+        let msg = Message {
+            kind: kind.to_string(),
+            meta: Vec::new(),
+        };
+        let out = self
+            .ctx
+            .call_block(
+                EMAIL_BLOCK,
+                msg,
+                InputStream::from_bytes(serde_json::to_vec(&body).unwrap_or_default()),
+            )
+            .await;
+        match out.collect_buffered().await {
+            Ok(_) => true,
+            Err(e) => {
+                tracing::warn!(block = %self.block, "email op {kind} failed: {e:?}");
+                false
+            }
+        }
+    }
+
+    /// Render and send a named template (`verification`,
+    /// `password_reset`, ...) carrying a single token.
+    pub async fn send_template(&self, template: &str, to: &str, token: &str) -> bool {
+        let body = serde_json::json!({
+            "template": template,
+            "to": to,
+            "token": token,
+        });
+        self.send("email.send_template", body).await
+    }
+}
+
+/// Id / email lookups over live accounts. Disabled and soft-deleted users
+/// are not visible.
+pub struct Users<'a> {
+    ctx: &'a dyn Context,
+}
+
+impl Users<'_> {
+    pub async fn find_by_id(&self, id: &str) -> Result<Option<DirectoryEntry>, RepoError> {
+        directory::find_by_id(self.ctx, id).await
+    }
+
+    pub async fn find_by_email(&self, email: &str) -> Result<Option<DirectoryEntry>, RepoError> {
+        directory::find_by_email(self.ctx, email).await
+    }
+
+    /// Email prefix search from a whole local part and its `@` (see
+    /// [`directory::search`]), capped at [`directory::MAX_SEARCH_RESULTS`].
+    pub async fn search(&self, query: &str, limit: i64) -> Result<Vec<DirectoryEntry>, RepoError> {
+        directory::search(self.ctx, query, limit).await
+    }
+}
+
+/// Whether an admin-defined feature flag is on for a user. Unknown flags,
+/// and every flag while the definitions can't be read, are off.
+pub struct Flags<'a> {
+    ctx: &'a dyn Context,
+}
+
+impl Flags<'_> {
+    /// For `user_id` (empty = anonymous) holding `roles`.
+    pub async fn enabled(&self, key: &str, user_id: &str, roles: &[&str]) -> bool {
+        feature_flags::is_enabled(self.ctx, key, user_id, roles).await
+    }
+
+    /// For the caller of `msg`.
+    pub async fn enabled_for(&self, msg: &Message, key: &str) -> bool {
+        let roles = feature_flags::roles_of(msg);
+        self.enabled(key, msg.user_id(), &roles).await
+    }
+}
+
+/// The background job queue, with this block as the jobs' runner: each
+/// job comes back to it as a [`jobs::RUN_KIND`] message.
+pub struct Jobs<'a> {
+    ctx: &'a dyn Context,
+    block: &'a str,
+}
+
+impl Jobs<'_> {
+    /// Queue a `job_type` job carrying `payload`. Returns the job id.
+    pub async fn enqueue(
+        &self,
+        job_type: &str,
+        payload: &serde_json::Value,
+        opts: EnqueueOptions,
+    ) -> Result<String, WaferError> {
+        jobs::enqueue(self.ctx, self.block, job_type, payload, opts).await
+    }
+}
+
+/// Operations whose jobs this block runs.
+pub struct Operations<'a> {
+    ctx: &'a dyn Context,
+    block: &'a str,
+}
+
+impl Operations<'_> {
+    /// Start a `kind` operation for the caller of `msg`, run by a
+    /// `job_type` job carrying `payload` (an object). Answer the request
+    /// with [`operations::accepted`].
+    pub async fn start(
+        &self,
+        msg: &Message,
+        kind: &str,
+        job_type: &str,
+        payload: serde_json::Value,
+    ) -> Result<Operation, WaferError> {
+        operations::start(self.ctx, msg.user_id(), self.block, kind, job_type, payload).await
+    }
+}
+
+/// Cron schedules that queue jobs for this block.
+pub struct Schedules<'a> {
+    block: &'a str,
+}
+
+impl Schedules<'_> {
+    /// Queue a `job_type` job for this block each time `cron` comes due;
+    /// with `catch_up`, once after downtime too. Registering a name again
+    /// replaces it. Errors for a bad name or expression.
+    pub fn register(
+        &self,
+        name: &str,
+        cron: &str,
+        job_type: &str,
+        catch_up: bool,
+    ) -> Result<(), String> {
+        schedules::register(ScheduleSpec::new(name, cron, self.block, job_type).catch_up(catch_up))
+    }
+}
+
+/// The per-user notification inbox.
+pub struct Notifications<'a> {
+    ctx: &'a dyn Context,
+}
+
+impl Notifications<'_> {
+    /// Post a `kind` notification (`{block}.{event}`, e.g.
+    /// `files.quota_threshold`) to `user_id`, emailing it too when the
+    /// user's preference for `kind` — or else `payload.email_by_default` —
+    /// says so.
+    pub async fn notify(
+        &self,
+        user_id: &str,
+        kind: &str,
+        payload: &NotificationPayload,
+    ) -> Result<Notification, WaferError> {
+        notifications::notify(self.ctx, user_id, kind, payload).await
+    }
+
+    /// Post `event` to `user_id` as an `E::NAME` notification, with the
+    /// event as its `data` (`payload.data` is ignored).
+    pub async fn notify_event<E: Event>(
+        &self,
+        user_id: &str,
+        event: &E,
+        payload: NotificationPayload,
+    ) -> Result<Notification, WaferError> {
+        notifications::notify_event(self.ctx, user_id, event, payload).await
+    }
+}
+
+/// This block's entries in the event catalog.
+pub struct Events<'a> {
+    block: &'a str,
+}
+
+impl Events<'_> {
+    /// Publish an event type. Its name must start with this block's name
+    /// (`deal_room.` for `acme/deal-room`); once registered, notifications
+    /// of that type must carry matching `data`.
+    pub fn register(&self, event: EventType) -> Result<(), RegisterError> {
+        events::register(self.block, event)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{blocks::auth::repo::users, test_support::TestContext};
+
+    async fn seed_user(ctx: &TestContext, email: &str) -> String {
+        users::insert(
+            ctx,
+            users::NewUser {
+                email: email.into(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: "user".into(),
+            },
+        )
+        .await
+        .unwrap()
+        .id
+    }
+
+    #[tokio::test]
+    async fn settings_are_prefixed_with_the_block_name() {
+        let mut ctx = TestContext::new().await;
+        ctx.set_config("SUPPERS_AI__FILES__QUOTA_WARN_PERCENT", "90");
+        let settings = Services::new(&ctx, "suppers-ai/files").settings();
+
+        assert_eq!(
+            settings.key("QUOTA_WARN_PERCENT"),
+            "SUPPERS_AI__FILES__QUOTA_WARN_PERCENT"
+        );
+        assert_eq!(settings.get("QUOTA_WARN_PERCENT", "80").await, "90");
+        assert_eq!(settings.get("UNSET", "fallback").await, "fallback");
+        assert!(settings.get_bool("UNSET", true).await);
+    }
+
+    #[tokio::test]
+    async fn users_directory_lookup_and_search() {
+        let ctx = TestContext::with_auth().await;
+        let alice = seed_user(&ctx, "alice@example.com").await;
+        seed_user(&ctx, "al_ice@example.com").await;
+        seed_user(&ctx, "alice@example.org").await;
+        let svc = Services::new(&ctx, "suppers-ai/files");
+
+        let found = svc.users().find_by_id(&alice).await.unwrap().unwrap();
+        assert_eq!(found.email, "alice@example.com");
+        assert!(svc.users().find_by_id("missing").await.unwrap().is_none());
+
+        let hits = svc.users().search("ALICE@", 10).await.unwrap();
+        let emails: Vec<_> = hits.iter().map(|e| e.email.as_str()).collect();
+        assert_eq!(emails, ["alice@example.com", "alice@example.org"]);
+
+        // `_` is literal, not a single-character wildcard.
+        let hits = svc.users().search("al_ce@", 10).await.unwrap();
+        assert!(hits.is_empty());
+        let hits = svc.users().search("al_ice@", 10).await.unwrap();
+        assert_eq!(hits.len(), 1);
+
+        assert!(svc.users().search("  ", 10).await.unwrap().is_empty());
+        assert!(svc.users().search("example", 10).await.unwrap().is_empty());
+        assert_eq!(svc.users().search("alice@", 1).await.unwrap().len(), 1);
+    }
+
+    #[tokio::test]
+    async fn flags_follow_targets_and_rollout() {
+        let ctx = TestContext::with_auth().await;
+        let input: feature_flags::FlagInput = serde_json::from_value(serde_json::json!({
+            "key": "files.resumable-uploads",
+            "enabled": true,
+            "target_roles": ["beta"],
+        }))
+        .unwrap();
+        feature_flags::create(&ctx, &input.validate(None).unwrap(), "admin_1")
+            .await
+            .unwrap();
+        let flags = Services::new(&ctx, "suppers-ai/files").flags();
+
+        assert!(
+            flags
+                .enabled("files.resumable-uploads", "u1", &["user", "beta"])
+                .await
+        );
+        assert!(
+            !flags
+                .enabled("files.resumable-uploads", "u1", &["user"])
+                .await
+        );
+        assert!(!flags.enabled("missing", "u1", &["beta"]).await);
+
+        let mut msg = crate::test_support::auth_msg("retrieve", "/b/files/api/buckets", "u2");
+        msg.set_meta("auth.user_roles", "beta");
+        assert!(flags.enabled_for(&msg, "files.resumable-uploads").await);
+    }
+
+    #[tokio::test]
+    async fn notifications_land_in_the_users_inbox() {
+        let ctx = TestContext::with_auth().await;
+        let svc = Services::new(&ctx, "suppers-ai/files");
+        let share = events::ShareReceived {
+            grant_id: "g1".into(),
+            bucket: "team".into(),
+            path: "reports/".into(),
+        };
+        let posted = svc
+            .notifications()
+            .notify_event(
+                "u1",
+                &share,
+                NotificationPayload {
+                    title: "A folder was shared with you".into(),
+                    ..Default::default()
+                },
+            )
+            .await
+            .unwrap();
+        assert_eq!(posted.kind, "files.share_received");
+        assert_eq!(posted.data["grant_id"], "g1");
+        assert_eq!(notifications::unread_count(&ctx, "u1").await.unwrap(), 1);
+    }
+
+    #[tokio::test]
+    async fn mailer_reports_undeliverable_mail() {
+        // No email block registered: the send is logged and reported, not
+        // propagated.
+        let ctx = TestContext::new().await;
+        let svc = Services::new(&ctx, "suppers-ai/files");
+        assert!(
+            !svc.mailer()
+                .send_template("verification", "a@b.c", "t")
+                .await
+        );
+    }
+}
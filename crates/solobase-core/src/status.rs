@@ -0,0 +1,282 @@
+//! A machine-readable status snapshot for supervisors.
+//!
+//! [`snapshot`] answers "is this process healthy right now?" in one JSON
+//! object: pid, version, uptime, listening port, the database type and
+//! whether it answers, when a request last succeeded, how many 5xx answers
+//! went out over the last [`ERROR_WINDOW_MINUTES`] minutes, which blocks
+//! failed their migrations (see [`crate::schema_status`]), and how many
+//! jobs are waiting or running.
+//!
+//! `GET /b/admin/api/diagnostics` returns it as `status`; with
+//! `SOLOBASE_STATUS_FILE` set, the native server also rewrites it to that
+//! file every `SOLOBASE_STATUS_INTERVAL_SECS` seconds and leaves a final
+//! `"stopped"` snapshot there on shutdown. The request counters are
+//! process memory, like [`crate::block_metrics`]: a restart starts from
+//! zero.
+
+use std::sync::{
+    atomic::{AtomicU64, Ordering},
+    Mutex, OnceLock,
+};
+
+use serde::Serialize;
+use wafer_run::context::Context;
+
+use crate::{blocks::jobs, schema_status};
+
+/// Minutes of 5xx answers the snapshot counts.
+pub const ERROR_WINDOW_MINUTES: u64 = 5;
+
+/// What the embedder knows about the process; set once at boot.
+#[derive(Debug, Clone)]
+pub struct ProcessInfo {
+    /// The listen address, e.g. `0.0.0.0:8090`.
+    pub listen: String,
+    pub db_type: String,
+}
+
+static PROCESS: OnceLock<(ProcessInfo, u64)> = OnceLock::new();
+
+/// Record the process details and start the uptime clock. Only the first
+/// call counts.
+pub fn set_process(info: ProcessInfo) {
+    let _ = PROCESS.set((info, crate::util::now_millis()));
+}
+
+/// When a request last got a non-5xx answer, in Unix milliseconds; 0 for
+/// never.
+static LAST_SUCCESS_MS: AtomicU64 = AtomicU64::new(0);
+
+static ERRORS: Mutex<ErrorWindow> = Mutex::new(ErrorWindow::new());
+
+/// 5xx answers per minute, one slot per minute of the window, reused as
+/// the minutes come round again.
+struct ErrorWindow([Minute; ERROR_WINDOW_MINUTES as usize]);
+
+#[derive(Clone, Copy)]
+struct Minute {
+    minute: u64,
+    count: u64,
+}
+
+impl ErrorWindow {
+    const fn new() -> Self {
+        Self(
+            [Minute {
+                minute: 0,
+                count: 0,
+            }; ERROR_WINDOW_MINUTES as usize],
+        )
+    }
+
+    fn record(&mut self, now_ms: u64) {
+        let minute = now_ms / 60_000;
+        let slot = &mut self.0[(minute % ERROR_WINDOW_MINUTES) as usize];
+        if slot.minute != minute {
+            *slot = Minute { minute, count: 0 };
+        }
+        slot.count += 1;
+    }
+
+    /// Answers in the window ending at `now_ms`.
+    fn count(&self, now_ms: u64) -> u64 {
+        let minute = now_ms / 60_000;
+        self.0
+            .iter()
+            .filter(|s| s.minute <= minute && minute - s.minute < ERROR_WINDOW_MINUTES)
+            .map(|s| s.count)
+            .sum()
+    }
+}
+
+fn errors() -> std::sync::MutexGuard<'static, ErrorWindow> {
+    ERRORS.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Record one answered request's status. The pipeline calls this for
+/// every request, static assets included.
+pub fn record_request(status_code: i64) {
+    let now = crate::util::now_millis();
+    if status_code < 500 {
+        LAST_SUCCESS_MS.fetch_max(now, Ordering::Relaxed);
+    } else {
+        errors().record(now);
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum State {
+    Running,
+    Stopped,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Database {
+    #[serde(rename = "type")]
+    pub db_type: Option<String>,
+    /// `None` when the snapshot was taken without a context to ask with.
+    pub connected: Option<bool>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Errors {
+    pub window_minutes: u64,
+    /// Requests answered with a 5xx status in the window.
+    pub count: u64,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Extensions {
+    /// Blocks that ran their migrations.
+    pub blocks: usize,
+    /// Blocks whose migrations failed; their routes answer 503.
+    pub failed: Vec<String>,
+    pub healthy: bool,
+}
+
+#[derive(Debug, Clone, Copy, Serialize)]
+pub struct Jobs {
+    pub pending: i64,
+    pub running: i64,
+}
+
+/// One status snapshot; see the module docs.
+#[derive(Debug, Clone, Serialize)]
+pub struct Snapshot {
+    pub status: State,
+    pub written_at: String,
+    /// `None` where there is no process id (wasm).
+    pub pid: Option<u32>,
+    pub version: String,
+    pub started_at: Option<String>,
+    pub uptime_secs: Option<u64>,
+    pub listen_port: Option<u16>,
+    pub database: Database,
+    pub last_success_at: Option<String>,
+    pub errors_5xx: Errors,
+    pub extensions: Extensions,
+    /// `None` when the database didn't answer (or wasn't asked).
+    pub jobs: Option<Jobs>,
+}
+
+impl Snapshot {
+    /// The same snapshot, marked as the process's last.
+    pub fn stopped(mut self) -> Self {
+        self.status = State::Stopped;
+        self
+    }
+}
+
+fn rfc3339(ms: u64) -> Option<String> {
+    let ms = i64::try_from(ms).ok()?;
+    chrono::DateTime::from_timestamp_millis(ms).map(crate::util::format_rfc3339)
+}
+
+/// The port of a `host:port` listen address.
+fn port_of(listen: &str) -> Option<u16> {
+    listen.rsplit_once(':')?.1.parse().ok()
+}
+
+/// Take a snapshot. With a `ctx`, the pending and running job counts are
+/// read, which doubles as the database connectivity probe; without one
+/// (e.g. after shutdown) both are reported as unknown.
+pub async fn snapshot(ctx: Option<&dyn Context>) -> Snapshot {
+    let now = crate::util::now_millis();
+    let process = PROCESS.get();
+
+    let (connected, error, queued) = match ctx {
+        None => (None, None, None),
+        Some(ctx) => match jobs::counts(ctx).await {
+            Ok(counts) => (Some(true), None, Some(counts)),
+            Err(e) => (Some(false), Some(e.message), None),
+        },
+    };
+
+    let reports = schema_status::snapshot();
+    let failed: Vec<String> = reports
+        .iter()
+        .filter(|r| r.error.is_some())
+        .map(|r| r.block.clone())
+        .collect();
+
+    Snapshot {
+        status: State::Running,
+        written_at: crate::util::now_rfc3339(),
+        pid: if cfg!(target_arch = "wasm32") {
+            None
+        } else {
+            Some(std::process::id())
+        },
+        version: crate::version::VERSION.to_string(),
+        started_at: process.and_then(|(_, started)| rfc3339(*started)),
+        uptime_secs: process.map(|(_, started)| now.saturating_sub(*started) / 1000),
+        listen_port: process.and_then(|(info, _)| port_of(&info.listen)),
+        database: Database {
+            db_type: process.map(|(info, _)| info.db_type.clone()),
+            connected,
+            error,
+        },
+        last_success_at: match LAST_SUCCESS_MS.load(Ordering::Relaxed) {
+            0 => None,
+            ms => rfc3339(ms),
+        },
+        errors_5xx: Errors {
+            window_minutes: ERROR_WINDOW_MINUTES,
+            count: errors().count(now),
+        },
+        extensions: Extensions {
+            blocks: reports.len(),
+            healthy: failed.is_empty(),
+            failed,
+        },
+        jobs: queued.map(|(pending, running)| Jobs { pending, running }),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    #[test]
+    fn server_errors_are_counted_per_minute_of_the_window() {
+        let base = 1_000 * 60_000;
+        let mut window = ErrorWindow::new();
+        window.record(base);
+        window.record(base + 30_000);
+        window.record(base + 3 * 60_000);
+        assert_eq!(window.count(base + 3 * 60_000), 3);
+        // The first minute has left the window.
+        assert_eq!(window.count(base + 5 * 60_000), 1);
+        assert_eq!(window.count(base + 9 * 60_000), 0);
+        // Its slot is reused for a later minute.
+        window.record(base + 5 * 60_000);
+        assert_eq!(window.count(base + 5 * 60_000), 2);
+    }
+
+    #[test]
+    fn ports_are_read_from_the_listen_address() {
+        assert_eq!(port_of("0.0.0.0:8090"), Some(8090));
+        assert_eq!(port_of("[::1]:443"), Some(443));
+        assert_eq!(port_of("localhost"), None);
+    }
+
+    #[tokio::test]
+    async fn snapshot_reports_the_database_and_jobs() {
+        let ctx = TestContext::with_admin().await;
+        record_request(200);
+        let snap = snapshot(Some(&ctx)).await;
+        assert_eq!(snap.status, State::Running);
+        assert_eq!(snap.database.connected, Some(true));
+        assert_eq!(snap.jobs.map(|j| (j.pending, j.running)), Some((0, 0)));
+        assert!(snap.last_success_at.is_some());
+
+        let json = serde_json::to_value(snapshot(None).await.stopped()).unwrap();
+        assert_eq!(json["status"], "stopped");
+        assert!(json["database"]["connected"].is_null());
+        assert!(json["jobs"].is_null());
+    }
+}
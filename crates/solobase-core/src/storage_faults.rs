@@ -0,0 +1,217 @@
+//! Storage fault injection for exercising error paths.
+//!
+//! [`wrap`] decorates a [`StorageService`] so that, while developer mode
+//! is on, storage calls matching a rule from `POST /api/_dev/storage-faults`
+//! (see [`crate::dev_mode`]) misbehave on purpose:
+//!
+//! - `error` — the call fails without reaching the provider;
+//! - `latency` — the call waits `latency_ms` first (on the pipeline's
+//!   request timer, so only where one is installed), then proceeds;
+//! - `partial_write` — a `put` stores the first half of the bytes, then
+//!   fails, like a disk filling up mid-write;
+//! - `eof` — a `get` returns the first half of the bytes with the full
+//!   object's size, like a stream cut off mid-read.
+//!
+//! Without developer mode there are no rules, and every call goes straight
+//! to the provider. Native wraps its storage when `SOLOBASE_DEV_MODE` is
+//! set; each injected fault is logged with `injected = true`.
+
+use std::{sync::Arc, time::Duration};
+
+use wafer_core::interfaces::storage::service::{
+    FolderInfo, ListOptions, ObjectInfo, ObjectList, StorageError, StorageService,
+};
+
+/// How a matched storage call misbehaves.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum FaultMode {
+    Error,
+    Latency,
+    PartialWrite,
+    Eof,
+}
+
+/// One drawn fault.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub(crate) struct StorageFault {
+    pub mode: FaultMode,
+    pub latency: Duration,
+}
+
+/// `inner`, with developer mode's storage faults applied around it.
+// See `blocks::storage::create` on `Arc` of a `MaybeSend` service.
+#[allow(clippy::arc_with_non_send_sync)]
+pub fn wrap(inner: Arc<dyn StorageService>) -> Arc<dyn StorageService> {
+    Arc::new(FaultyStorage { inner })
+}
+
+struct FaultyStorage {
+    inner: Arc<dyn StorageService>,
+}
+
+fn injected(op: &str, path: &str) -> StorageError {
+    StorageError::Internal(format!("injected fault: {op} {path}"))
+}
+
+/// Draw the fault for `op` on `path`, sleeping out a `latency` one here;
+/// the other modes are the caller's to act out.
+async fn draw(op: &str, path: &str) -> Option<FaultMode> {
+    let fault = crate::dev_mode::storage_fault(op, path)?;
+    if fault.mode != FaultMode::Latency {
+        return Some(fault.mode);
+    }
+    if let Some(sleep) = crate::pipeline::request_timer() {
+        sleep(fault.latency).await;
+    }
+    None
+}
+
+/// The first half of `data`, where a cut-off write or read stops.
+fn half(data: &[u8]) -> &[u8] {
+    &data[..data.len() / 2]
+}
+
+#[cfg_attr(target_arch = "wasm32", async_trait::async_trait(?Send))]
+#[cfg_attr(not(target_arch = "wasm32"), async_trait::async_trait)]
+impl StorageService for FaultyStorage {
+    async fn put(
+        &self,
+        folder: &str,
+        key: &str,
+        data: &[u8],
+        content_type: &str,
+    ) -> Result<(), StorageError> {
+        let path = format!("{folder}/{key}");
+        match draw("put", &path).await {
+            Some(FaultMode::PartialWrite) => {
+                self.inner
+                    .put(folder, key, half(data), content_type)
+                    .await?;
+                Err(injected("put", &path))
+            }
+            Some(_) => Err(injected("put", &path)),
+            None => self.inner.put(folder, key, data, content_type).await,
+        }
+    }
+
+    async fn get(&self, folder: &str, key: &str) -> Result<(Vec<u8>, ObjectInfo), StorageError> {
+        let path = format!("{folder}/{key}");
+        match draw("get", &path).await {
+            Some(FaultMode::Eof) => {
+                let (data, info) = self.inner.get(folder, key).await?;
+                Ok((half(&data).to_vec(), info))
+            }
+            Some(_) => Err(injected("get", &path)),
+            None => self.inner.get(folder, key).await,
+        }
+    }
+
+    async fn delete(&self, folder: &str, key: &str) -> Result<(), StorageError> {
+        let path = format!("{folder}/{key}");
+        match draw("delete", &path).await {
+            Some(_) => Err(injected("delete", &path)),
+            None => self.inner.delete(folder, key).await,
+        }
+    }
+
+    async fn list(&self, folder: &str, opts: &ListOptions) -> Result<ObjectList, StorageError> {
+        let path = format!("{folder}/{}", opts.prefix);
+        match draw("list", &path).await {
+            Some(_) => Err(injected("list", &path)),
+            None => self.inner.list(folder, opts).await,
+        }
+    }
+
+    async fn create_folder(&self, name: &str, public: bool) -> Result<(), StorageError> {
+        match draw("create_folder", name).await {
+            Some(_) => Err(injected("create_folder", name)),
+            None => self.inner.create_folder(name, public).await,
+        }
+    }
+
+    async fn delete_folder(&self, name: &str) -> Result<(), StorageError> {
+        match draw("delete_folder", name).await {
+            Some(_) => Err(injected("delete_folder", name)),
+            None => self.inner.delete_folder(name).await,
+        }
+    }
+
+    async fn list_folders(&self) -> Result<Vec<FolderInfo>, StorageError> {
+        match draw("list_folders", "").await {
+            Some(_) => Err(injected("list_folders", "")),
+            None => self.inner.list_folders().await,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use wafer_run::InputStream;
+
+    use super::*;
+    use crate::test_support::{
+        anon_msg, inject_storage_fault as inject, output_status, MemStorage,
+    };
+
+    #[tokio::test]
+    async fn faults_apply_to_matching_calls_only() {
+        let storage = wrap(Arc::new(MemStorage::default()));
+        storage
+            .put("f", "a.txt", b"abcd", "text/plain")
+            .await
+            .unwrap();
+        storage
+            .put("f", "b.txt", b"abcd", "text/plain")
+            .await
+            .unwrap();
+
+        inject(serde_json::json!({ "op": "get", "key": "f/a.*", "mode": "eof" })).await;
+        let (data, info) = storage.get("f", "a.txt").await.unwrap();
+        assert_eq!((data.as_slice(), info.size), (&b"ab"[..], 4));
+        assert_eq!(storage.get("f", "b.txt").await.unwrap().0, b"abcd");
+
+        let rule = serde_json::json!({ "op": "delete", "mode": "error", "count": 1 });
+        inject(rule).await;
+        assert!(storage.delete("f", "b.txt").await.is_err());
+        assert!(storage.delete("f", "b.txt").await.is_ok(), "count ran out");
+
+        let rule = serde_json::json!({ "op": "put", "mode": "partial_write", "probability": 0.0 });
+        inject(rule).await;
+        assert!(storage
+            .put("f", "c.txt", b"abcd", "text/plain")
+            .await
+            .is_ok());
+    }
+
+    #[tokio::test]
+    async fn partial_writes_leave_half_the_bytes() {
+        let storage = wrap(Arc::new(MemStorage::default()));
+        inject(serde_json::json!({ "op": "put", "mode": "partial_write" })).await;
+        assert!(storage
+            .put("f", "a.txt", b"abcd", "text/plain")
+            .await
+            .is_err());
+
+        let out = crate::dev_mode::handle(&anon_msg("create", "/_dev/reset"), InputStream::empty());
+        assert_eq!(output_status(out.await).await, 200);
+        assert_eq!(storage.get("f", "a.txt").await.unwrap().0, b"ab");
+    }
+
+    #[tokio::test]
+    async fn rules_are_validated() {
+        crate::dev_mode::install("development").unwrap();
+        for rule in [
+            serde_json::json!({ "op": "rename", "mode": "error" }),
+            serde_json::json!({ "op": "get", "mode": "partial_write" }),
+            serde_json::json!({ "op": "put", "mode": "eof" }),
+            serde_json::json!({ "op": "get", "mode": "latency" }),
+            serde_json::json!({ "op": "*", "mode": "error", "probability": 2 }),
+        ] {
+            let msg = anon_msg("create", "/_dev/storage-faults");
+            let body = InputStream::from_bytes(serde_json::to_vec(&rule).unwrap());
+            let out = crate::dev_mode::handle(&msg, body).await;
+            assert_eq!(output_status(out).await, 400, "{rule}");
+        }
+    }
+}
@@ -0,0 +1,398 @@
+//! Per-block table namespaces: what a block's migrations may define, and
+//! which other tables it has been granted.
+//!
+//! Every block owns the tables under its `{org}__{block}__` prefix
+//! (`suppers-ai/files` → `suppers_ai__files__*`); WRAP self-admits that
+//! namespace and gates every typed `db::*` call outside it against the
+//! owning block's `ResourceGrant`s. Migrations were the gap: they run as
+//! DDL, so a block's `.sql` files could create, alter or drop another
+//! block's tables, or read and write them from a view, a trigger or plain
+//! DML. [`ddl_violations`] closes it — the shared migration runner refuses
+//! a block's migrations when any statement defines, modifies or refers to
+//! a table outside the block's prefix, and the block stays out of routing
+//! (see [`crate::schema_status`]) until the files are fixed.
+//!
+//! [`received_grants`] is the read side for operators: the admin
+//! extensions API and block detail view list exactly which foreign tables
+//! (and other resources) each block can reach.
+
+use wafer_run::{BlockInfo, ResourceGrant};
+
+/// The table-name prefix `block` owns (`suppers-ai/auth-ui` →
+/// `suppers_ai__auth_ui__`).
+pub fn table_prefix(block: &str) -> String {
+    format!("{}__", block.replace('-', "_").replace('/', "__"))
+}
+
+/// Tables outside `block`'s prefix that `sql` defines, modifies or refers
+/// to, in order and deduplicated.
+///
+/// Two checks run on every statement. The DDL target — `CREATE [UNIQUE]
+/// INDEX … ON t`, `CREATE TRIGGER … ON t`, `CREATE [VIRTUAL] TABLE`/`VIEW`,
+/// `ALTER TABLE` and `DROP TABLE`/`VIEW` — must carry the prefix;
+/// schema-qualified names (`main.t`, `public.t`) never do and are always
+/// reported. And any identifier shaped like another block's table
+/// (`{org}__{block}__…`) is reported wherever it appears: a view's `FROM`
+/// or `JOIN`, `INSERT INTO`, `UPDATE`, `DELETE FROM`, or a trigger body.
+/// The one exception is a `REFERENCES` target, since a foreign key reads
+/// and writes nothing of the table it points at. `DROP INDEX`/`DROP
+/// TRIGGER` name only the index or trigger, so their target is not checked;
+/// index and trigger names must still not look like another block's table.
+/// String literals and comments are skipped.
+pub fn ddl_violations(block: &str, sql: &str) -> Vec<String> {
+    let prefix = table_prefix(block);
+    let mut out: Vec<String> = Vec::new();
+    let mut report = |name: String| {
+        if !out.contains(&name) {
+            out.push(name);
+        }
+    };
+    for stmt in split_statements(sql) {
+        if let Some(target) = ddl_target(&stmt) {
+            if !target.starts_with(&prefix) {
+                report(target);
+            }
+        }
+        for name in foreign_references(&stmt, &prefix) {
+            report(name);
+        }
+    }
+    out
+}
+
+/// `sql` without comments, and with each string literal emptied to `''`,
+/// so neither can hide or fake a statement boundary or a table name.
+fn strip_comments_and_strings(sql: &str) -> String {
+    let mut out = String::with_capacity(sql.len());
+    let mut chars = sql.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '-' if chars.peek() == Some(&'-') => {
+                for c in chars.by_ref() {
+                    if c == '\n' {
+                        out.push('\n');
+                        break;
+                    }
+                }
+            }
+            '/' if chars.peek() == Some(&'*') => {
+                chars.next();
+                let mut prev = ' ';
+                for c in chars.by_ref() {
+                    if prev == '*' && c == '/' {
+                        break;
+                    }
+                    prev = c;
+                }
+                out.push(' ');
+            }
+            '\'' => {
+                // `''` inside a literal is an escaped quote: the scan below
+                // closes and reopens, which skips it all the same.
+                for c in chars.by_ref() {
+                    if c == '\'' {
+                        break;
+                    }
+                }
+                out.push_str("''");
+            }
+            c => out.push(c),
+        }
+    }
+    out
+}
+
+/// Statement words, lower-cased, one `Vec` per `;`.
+fn split_statements(sql: &str) -> Vec<Vec<String>> {
+    strip_comments_and_strings(sql)
+        .split(';')
+        .map(|stmt| {
+            stmt.split_whitespace()
+                .map(str::to_ascii_lowercase)
+                .collect::<Vec<_>>()
+        })
+        .filter(|words| !words.is_empty())
+        .collect()
+}
+
+/// Identifiers in `words` shaped like a prefixed table name
+/// (`{org}__{block}__{table}`) that don't start with `prefix`, except the
+/// target of a `REFERENCES`.
+fn foreign_references(words: &[String], prefix: &str) -> Vec<String> {
+    let mut out = Vec::new();
+    let mut after_references = false;
+    for word in words {
+        let idents = word
+            .split(|c: char| !(c.is_ascii_alphanumeric() || c == '_' || c == '$'))
+            .filter(|s| !s.is_empty());
+        for (i, ident) in idents.enumerate() {
+            let skip = after_references && i == 0;
+            if !skip && is_prefixed_name(ident) && !ident.starts_with(prefix) {
+                out.push(ident.to_string());
+            }
+        }
+        after_references = word == "references";
+    }
+    out
+}
+
+/// Whether `ident` has the `{org}__{block}__{table}` shape.
+fn is_prefixed_name(ident: &str) -> bool {
+    let mut parts = ident.splitn(3, "__");
+    matches!(
+        (parts.next(), parts.next(), parts.next()),
+        (Some(org), Some(block), Some(table))
+            if !org.is_empty() && !block.is_empty() && !table.is_empty()
+    )
+}
+
+/// The table a DDL statement defines or modifies, when it names one.
+fn ddl_target(words: &[String]) -> Option<String> {
+    let mut rest = words;
+    let verb = rest.first()?.as_str();
+    rest = &rest[1..];
+    match verb {
+        "create" => {
+            while let Some(w) = rest.first() {
+                match w.as_str() {
+                    "or" | "replace" | "unique" | "temp" | "temporary" | "virtual" => {
+                        rest = &rest[1..]
+                    }
+                    _ => break,
+                }
+            }
+            let kind = rest.first()?.as_str();
+            match kind {
+                "table" | "view" => name_after_kind(&rest[1..]),
+                "index" | "trigger" => {
+                    let on = rest.iter().position(|w| w == "on")?;
+                    rest.get(on + 1).map(|n| clean_name(n))
+                }
+                _ => None,
+            }
+        }
+        "alter" if rest.first().map(String::as_str) == Some("table") => name_after_kind(&rest[1..]),
+        "drop" => match rest.first()?.as_str() {
+            "table" | "view" => name_after_kind(&rest[1..]),
+            _ => None,
+        },
+        _ => None,
+    }
+}
+
+/// The object name after `TABLE`/`VIEW`, skipping `IF [NOT] EXISTS`.
+fn name_after_kind(words: &[String]) -> Option<String> {
+    let mut rest = words;
+    if rest.first().map(String::as_str) == Some("if") {
+        let exists = rest.iter().position(|w| w == "exists")?;
+        rest = &rest[exists + 1..];
+    }
+    rest.first().map(|n| clean_name(n))
+}
+
+fn clean_name(word: &str) -> String {
+    word.split('(')
+        .next()
+        .unwrap_or(word)
+        .trim_matches(|c| c == '"' || c == '`')
+        .to_string()
+}
+
+/// Grants other blocks (and admin-created WRAP rows merged into `extra`)
+/// give `block`, including `*` wildcard grants that apply to every block.
+pub fn received_grants(
+    blocks: &[BlockInfo],
+    extra: &[ResourceGrant],
+    block: &str,
+) -> Vec<ResourceGrant> {
+    blocks
+        .iter()
+        .flat_map(|b| b.grants.iter())
+        .chain(extra.iter())
+        .filter(|g| g.grantee == block || g.grantee == "*")
+        .cloned()
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn prefix_maps_org_and_dashes() {
+        assert_eq!(table_prefix("suppers-ai/files"), "suppers_ai__files__");
+        assert_eq!(table_prefix("suppers-ai/auth-ui"), "suppers_ai__auth_ui__");
+    }
+
+    #[test]
+    fn own_ddl_is_clean() {
+        let sql = "-- a comment; with a semicolon\n\
+                   CREATE TABLE IF NOT EXISTS suppers_ai__files__buckets (id TEXT);\n\
+                   CREATE UNIQUE INDEX IF NOT EXISTS idx_b ON suppers_ai__files__buckets(id);\n\
+                   ALTER TABLE suppers_ai__files__buckets ADD COLUMN x TEXT;\n\
+                   DROP INDEX IF EXISTS idx_old;\n\
+                   INSERT INTO suppers_ai__files__buckets (id) VALUES ('suppers_ai__auth__users; --');\n\
+                   /* suppers_ai__auth__users */\n\
+                   CREATE TABLE suppers_ai__files__grants (\n\
+                       user_id TEXT REFERENCES suppers_ai__auth__users(id) ON DELETE CASCADE);";
+        assert!(ddl_violations("suppers-ai/files", sql).is_empty());
+    }
+
+    #[test]
+    fn foreign_ddl_is_reported() {
+        let sql = "CREATE TABLE suppers_ai__auth__users_copy(id TEXT);\n\
+                   CREATE INDEX i ON suppers_ai__auth__users (email);\n\
+                   ALTER TABLE suppers_ai__auth__users ADD COLUMN pwned TEXT;\n\
+                   DROP TABLE IF EXISTS suppers_ai__admin__variables;\n\
+                   CREATE OR REPLACE VIEW leak AS SELECT * FROM suppers_ai__auth__users;\n\
+                   CREATE TABLE main.suppers_ai__files__x (id TEXT);";
+        assert_eq!(
+            ddl_violations("suppers-ai/files", sql),
+            vec![
+                "suppers_ai__auth__users_copy",
+                "suppers_ai__auth__users",
+                "suppers_ai__admin__variables",
+                "leak",
+                "main.suppers_ai__files__x",
+            ]
+        );
+    }
+
+    #[test]
+    fn foreign_tables_are_reported_wherever_they_appear() {
+        // A view over another block's table.
+        let view = "CREATE VIEW suppers_ai__files__leak AS \
+                    SELECT u.email FROM suppers_ai__files__buckets b \
+                    JOIN suppers_ai__auth__users u ON u.id = b.owner;";
+        assert_eq!(
+            ddl_violations("suppers-ai/files", view),
+            vec!["suppers_ai__auth__users"]
+        );
+
+        // A trigger on an own table whose body writes elsewhere.
+        let trigger = "CREATE TRIGGER suppers_ai__files__t AFTER INSERT \
+                       ON suppers_ai__files__buckets BEGIN \
+                       UPDATE suppers_ai__auth__users SET role = 'admin'; \
+                       DELETE FROM \"suppers_ai__auth__sessions\"; \
+                       END;";
+        assert_eq!(
+            ddl_violations("suppers-ai/files", trigger),
+            vec!["suppers_ai__auth__users", "suppers_ai__auth__sessions"]
+        );
+
+        // Plain DML, including behind a comment marker in a string.
+        let dml = "INSERT INTO suppers_ai__admin__variables (id) VALUES ('x');\n\
+                   SELECT '--' FROM x; DELETE FROM suppers_ai__admin__audit_logs;\n\
+                   INSERT INTO suppers_ai__files__a SELECT * FROM main.suppers_ai__auth__tokens;";
+        assert_eq!(
+            ddl_violations("suppers-ai/files", dml),
+            vec![
+                "suppers_ai__admin__variables",
+                "suppers_ai__admin__audit_logs",
+                "suppers_ai__auth__tokens",
+            ]
+        );
+    }
+
+    #[test]
+    fn received_grants_collects_targeted_and_wildcard_grants() {
+        let auth = BlockInfo::new("suppers-ai/auth", "0.0.1", "service@v1", "auth").grants(vec![
+            ResourceGrant::read("suppers-ai/files", "suppers_ai__auth__users_directory"),
+            ResourceGrant::read_write("suppers-ai/userportal", "suppers_ai__auth__users"),
+        ]);
+        let admin =
+            BlockInfo::new("suppers-ai/admin", "0.0.1", "http-handler@v1", "admin").grants(vec![
+                ResourceGrant::read_write("*", "suppers_ai__admin__request_logs"),
+            ]);
+        let extra = vec![ResourceGrant::read(
+            "suppers-ai/files",
+            "suppers_ai__products__products",
+        )];
+
+        let resources: Vec<String> = received_grants(&[auth, admin], &extra, "suppers-ai/files")
+            .into_iter()
+            .map(|g| g.resource)
+            .collect();
+        assert_eq!(
+            resources,
+            vec![
+                "suppers_ai__auth__users_directory",
+                "suppers_ai__admin__request_logs",
+                "suppers_ai__products__products",
+            ]
+        );
+    }
+
+    #[test]
+    fn shipped_block_migrations_stay_in_their_namespace() {
+        use crate::blocks;
+        type Set = (
+            &'static str,
+            &'static [(&'static str, &'static str)],
+            &'static [&'static str],
+        );
+        let mut sets: Vec<Set> = vec![
+            (
+                "suppers-ai/admin",
+                blocks::admin::migrations::SQLITE_MIGRATIONS,
+                blocks::admin::migrations::POSTGRES_MIGRATIONS,
+            ),
+            (
+                "suppers-ai/email",
+                blocks::email::migrations::SQLITE_MIGRATIONS,
+                blocks::email::migrations::POSTGRES_MIGRATIONS,
+            ),
+        ];
+        #[cfg(feature = "block-files")]
+        sets.push((
+            "suppers-ai/files",
+            blocks::files::migrations::SQLITE_MIGRATIONS,
+            blocks::files::migrations::POSTGRES_MIGRATIONS,
+        ));
+        #[cfg(feature = "block-legalpages")]
+        sets.push((
+            "suppers-ai/legalpages",
+            blocks::legalpages::migrations::SQLITE_MIGRATIONS,
+            blocks::legalpages::migrations::POSTGRES_MIGRATIONS,
+        ));
+        #[cfg(feature = "block-llm")]
+        sets.push((
+            "suppers-ai/llm",
+            blocks::llm::migrations::SQLITE_MIGRATIONS,
+            blocks::llm::migrations::POSTGRES_MIGRATIONS,
+        ));
+        #[cfg(feature = "block-messages")]
+        sets.push((
+            "suppers-ai/messages",
+            blocks::messages::migrations::SQLITE_MIGRATIONS,
+            blocks::messages::migrations::POSTGRES_MIGRATIONS,
+        ));
+        #[cfg(feature = "block-products")]
+        sets.push((
+            "suppers-ai/products",
+            blocks::products::migrations::SQLITE_MIGRATIONS,
+            blocks::products::migrations::POSTGRES_MIGRATIONS,
+        ));
+        #[cfg(feature = "block-userportal")]
+        sets.push((
+            "suppers-ai/userportal",
+            blocks::userportal::migrations::SQLITE_MIGRATIONS,
+            blocks::userportal::migrations::POSTGRES_MIGRATIONS,
+        ));
+        #[cfg(feature = "block-vector")]
+        sets.push((
+            "suppers-ai/vector",
+            blocks::vector::migrations::SQLITE_MIGRATIONS,
+            blocks::vector::migrations::POSTGRES_MIGRATIONS,
+        ));
+        for (block, sqlite, postgres) in sets {
+            for sql in sqlite
+                .iter()
+                .map(|(_, sql)| *sql)
+                .chain(postgres.iter().copied())
+            {
+                assert_eq!(ddl_violations(block, sql), Vec::<String>::new(), "{block}");
+            }
+        }
+    }
+}
@@ -10,9 +10,19 @@
 
 use std::{
     collections::HashMap,
-    sync::{Arc, Mutex},
+    sync::{
+        atomic::{AtomicUsize, Ordering},
+        Arc, Mutex,
+    },
 };
 
+use wafer_core::{
+    interfaces::storage::service::{
+        FolderInfo, ListOptions as StoreListOptions, ObjectInfo, ObjectList, StorageError,
+        StorageService,
+    },
+    service_blocks::storage::StorageBlock,
+};
 use wafer_run::{
     context::Context,
     streams::output::{BufferedResponse, TerminalNotResponse},
@@ -32,6 +42,9 @@ use wafer_run::{
 #[derive(Clone)]
 pub struct TestContext {
     database_block: Arc<dyn Block>,
+    /// Calls routed to `database_block`, shared by every clone. Read via
+    /// [`Self::database_calls`].
+    database_calls: Arc<AtomicUsize>,
     /// Config snapshot used by `config_get`. Immutable after construction so
     /// `config_get` can return `Option<&str>` without holding a lock.
     /// Populated via [`set_config`].
@@ -73,6 +86,7 @@ impl TestContext {
 
         Self {
             database_block,
+            database_calls: Arc::new(AtomicUsize::new(0)),
             config: Arc::new(HashMap::new()),
             blocks: Arc::new(Mutex::new(HashMap::new())),
             block_infos: Vec::new(),
@@ -143,7 +157,7 @@ impl TestContext {
     /// into `migration_helper::lifecycle_init`). Test-fixture setup is an
     /// explicit exception to the no-raw-migration-runner rule; it mirrors the
     /// production gate exactly so fixtures exercise the real schema.
-    async fn apply_block_migrations(
+    pub(crate) async fn apply_block_migrations(
         &self,
         block_name: &str,
         sqlite: &[(&str, &str)],
@@ -194,6 +208,19 @@ impl TestContext {
         ctx
     }
 
+    /// Build a `TestContext` with admin + email (outbox/log) migrations
+    /// applied.
+    pub async fn with_email() -> Self {
+        let ctx = Self::with_admin().await;
+        ctx.apply_block_migrations(
+            "suppers-ai/email",
+            crate::blocks::email::migrations::SQLITE_MIGRATIONS,
+            crate::blocks::email::migrations::POSTGRES_MIGRATIONS,
+        )
+        .await;
+        ctx
+    }
+
     /// Build a `TestContext` with admin + auth + files migrations applied.
     #[cfg(feature = "block-files")]
     pub async fn with_files() -> Self {
@@ -252,6 +279,13 @@ impl TestContext {
         ctx
     }
 
+    /// How many calls have reached `wafer-run/database` through this
+    /// context (or a clone of it) so far. Tests that pin a handler's query
+    /// count read it before and after the call.
+    pub fn database_calls(&self) -> usize {
+        self.database_calls.load(Ordering::SeqCst)
+    }
+
     /// Register a block under `name`. Calls to `ctx.call_block(name, ...)`
     /// will route to this block's `handle()`.
     ///
@@ -276,6 +310,132 @@ impl TestContext {
             .expect("blocks mutex poisoned")
             .insert(name.to_string(), block);
     }
+
+    /// Register a real `wafer-run/storage` block over an empty
+    /// [`MemStorage`], so typed `storage::put`/`get` clients (and handlers
+    /// built on them) run end-to-end without touching the filesystem.
+    pub fn register_mem_storage(&mut self) {
+        self.register_storage(Arc::new(MemStorage::default()));
+    }
+
+    /// [`register_mem_storage`](Self::register_mem_storage) behind the
+    /// developer-mode fault decorator ([`crate::storage_faults`]); add
+    /// rules with [`inject_storage_fault`].
+    pub fn register_faulty_storage(&mut self) {
+        self.register_storage(crate::storage_faults::wrap(Arc::new(MemStorage::default())));
+    }
+
+    fn register_storage(&mut self, service: Arc<dyn StorageService>) {
+        self.register_block("wafer-run/storage", Arc::new(StorageBlock::new(service)));
+    }
+}
+
+/// Install developer mode (on this test's thread) and add one
+/// `POST /api/_dev/storage-faults` rule.
+pub async fn inject_storage_fault(rule: serde_json::Value) {
+    crate::dev_mode::install("development").unwrap();
+    let msg = anon_msg("create", "/_dev/storage-faults");
+    let body = InputStream::from_bytes(serde_json::to_vec(&rule).unwrap());
+    let out = crate::dev_mode::handle(&msg, body).await;
+    assert_eq!(output_status(out).await, 200, "{rule}");
+}
+
+/// `(folder, key)` → `(bytes, content_type, last_modified)`.
+type MemObjects = HashMap<(String, String), (Vec<u8>, String, chrono::DateTime<chrono::Utc>)>;
+
+/// In-memory [`StorageService`] so storage tests exercise the production
+/// `wafer-run/storage` [`StorageBlock`] wire protocol end-to-end (the
+/// typed `store::put`/`store::get` clients round-trip through the real
+/// handler) without touching the filesystem.
+#[derive(Default)]
+pub struct MemStorage {
+    objects: Mutex<MemObjects>,
+}
+
+#[async_trait::async_trait]
+impl StorageService for MemStorage {
+    async fn put(
+        &self,
+        folder: &str,
+        key: &str,
+        data: &[u8],
+        content_type: &str,
+    ) -> Result<(), StorageError> {
+        self.objects.lock().unwrap().insert(
+            (folder.to_string(), key.to_string()),
+            (data.to_vec(), content_type.to_string(), crate::clock::now()),
+        );
+        Ok(())
+    }
+
+    async fn get(&self, folder: &str, key: &str) -> Result<(Vec<u8>, ObjectInfo), StorageError> {
+        let guard = self.objects.lock().unwrap();
+        let (data, content_type, last_modified) = guard
+            .get(&(folder.to_string(), key.to_string()))
+            .ok_or(StorageError::NotFound)?;
+        Ok((
+            data.clone(),
+            ObjectInfo {
+                key: key.to_string(),
+                size: data.len() as i64,
+                content_type: content_type.clone(),
+                last_modified: *last_modified,
+            },
+        ))
+    }
+
+    async fn delete(&self, folder: &str, key: &str) -> Result<(), StorageError> {
+        self.objects
+            .lock()
+            .unwrap()
+            .remove(&(folder.to_string(), key.to_string()));
+        Ok(())
+    }
+
+    async fn list(
+        &self,
+        folder: &str,
+        opts: &StoreListOptions,
+    ) -> Result<ObjectList, StorageError> {
+        let guard = self.objects.lock().unwrap();
+        let mut keys: Vec<&String> = guard
+            .keys()
+            .filter(|(f, k)| f == folder && k.starts_with(&opts.prefix))
+            .map(|(_, k)| k)
+            .collect();
+        keys.sort();
+        let objects = keys
+            .iter()
+            .skip(opts.offset.max(0) as usize)
+            .take(opts.limit.max(0) as usize)
+            .map(|key| {
+                let (data, content_type, last_modified) =
+                    &guard[&(folder.to_string(), (*key).clone())];
+                ObjectInfo {
+                    key: (*key).clone(),
+                    size: data.len() as i64,
+                    content_type: content_type.clone(),
+                    last_modified: *last_modified,
+                }
+            })
+            .collect();
+        Ok(ObjectList {
+            objects,
+            total_count: keys.len() as i64,
+        })
+    }
+
+    async fn create_folder(&self, _name: &str, _public: bool) -> Result<(), StorageError> {
+        Ok(())
+    }
+
+    async fn delete_folder(&self, _name: &str) -> Result<(), StorageError> {
+        Ok(())
+    }
+
+    async fn list_folders(&self) -> Result<Vec<FolderInfo>, StorageError> {
+        Ok(vec![])
+    }
 }
 
 #[async_trait::async_trait]
@@ -335,7 +495,10 @@ impl Context for TestContext {
         }
 
         match name {
-            "wafer-run/database" => self.database_block.handle(self, msg, input).await,
+            "wafer-run/database" => {
+                self.database_calls.fetch_add(1, Ordering::SeqCst);
+                self.database_block.handle(self, msg, input).await
+            }
             other => {
                 // Check the dynamically registered blocks map before giving up.
                 let block = {
@@ -464,6 +627,339 @@ pub async fn output_is_error(out: OutputStream, code: &str) -> bool {
     )
 }
 
+/// SQLite's `EXPLAIN QUERY PLAN` for `sql`, one detail line per step (e.g.
+/// `SEARCH t USING INDEX i (a=?)`).
+pub async fn query_plan(ctx: &dyn Context, sql: &str, args: &[serde_json::Value]) -> Vec<String> {
+    use crate::util::RecordExt;
+
+    wafer_core::clients::database::query_raw(ctx, &format!("EXPLAIN QUERY PLAN {sql}"), args)
+        .await
+        .unwrap_or_else(|e| panic!("explain {sql}: {e}"))
+        .into_iter()
+        .map(|r| r.str_field("detail").to_string())
+        .collect()
+}
+
+/// Query-plan regression check: panics unless the plan for `sql` reads
+/// through `index` and neither scans a table nor sorts — what a hot query
+/// silently degrades to when its filters or order drift from the index.
+/// The statements are written out by hand to match what the repo function
+/// sends.
+pub async fn assert_uses_index(
+    ctx: &dyn Context,
+    sql: &str,
+    args: &[serde_json::Value],
+    index: &str,
+) {
+    let plan = query_plan(ctx, sql, args).await;
+    let uses = plan
+        .iter()
+        .any(|step| step.contains(" INDEX ") && step.split_whitespace().any(|w| w == index));
+    let scans = plan.iter().any(|step| {
+        (step.starts_with("SCAN ") && !step.contains(" INDEX ")) || step.contains("TEMP B-TREE")
+    });
+    assert!(
+        uses && !scans,
+        "expected {sql} to use {index} without a scan or sort, got {plan:?}"
+    );
+}
+
+// ---------------------------------------------------------------------------
+// TestApp: the full request pipeline over a TestContext
+// ---------------------------------------------------------------------------
+
+/// An in-memory solobase app for extension and embedder tests.
+///
+/// Where [`TestContext`] drives one block's handler directly, `TestApp` sends
+/// requests through [`crate::handle_request`] — JWT validation, the route
+/// table's access tiers, maintenance mode, quotas and request logging — so a
+/// test sees what an HTTP client would. It starts with the admin and auth
+/// schemas, a random JWT secret and an empty [`MemStorage`]; blocks under
+/// test are added with [`Self::load_block`]. Everything lives in memory and
+/// is dropped with the app, so there is nothing to clean up.
+pub struct TestApp {
+    ctx: TestContext,
+    jwt_secret: String,
+    base_path: String,
+}
+
+/// A user created by [`TestApp::create_user`], with an access token for it.
+#[derive(Debug, Clone)]
+pub struct TestUser {
+    pub id: String,
+    pub email: String,
+    pub role: String,
+    pub token: String,
+}
+
+/// A request for [`TestApp::request`]. `action` is the WAFER action the
+/// HTTP method maps to (`retrieve`, `create`, `update`, `delete`).
+#[derive(Debug, Clone)]
+pub struct TestRequest {
+    action: String,
+    method: String,
+    path: String,
+    body: Vec<u8>,
+    headers: Vec<(String, String)>,
+    token: Option<String>,
+}
+
+impl TestRequest {
+    pub fn new(action: &str, path: &str) -> Self {
+        let method = match action {
+            "retrieve" => "GET",
+            "create" => "POST",
+            "update" => "PATCH",
+            "delete" => "DELETE",
+            _ => "",
+        };
+        Self {
+            action: action.to_string(),
+            method: method.to_string(),
+            path: path.to_string(),
+            body: Vec::new(),
+            headers: Vec::new(),
+            token: None,
+        }
+    }
+
+    /// `GET path`.
+    pub fn get(path: &str) -> Self {
+        Self::new("retrieve", path)
+    }
+
+    /// `POST path`.
+    pub fn post(path: &str) -> Self {
+        Self::new("create", path)
+    }
+
+    /// `PATCH path`.
+    pub fn patch(path: &str) -> Self {
+        Self::new("update", path)
+    }
+
+    /// `DELETE path`.
+    pub fn delete(path: &str) -> Self {
+        Self::new("delete", path)
+    }
+
+    /// Send `value` as the JSON body.
+    pub fn json(mut self, value: &serde_json::Value) -> Self {
+        self.body = serde_json::to_vec(value).expect("serialize request body");
+        self.header("content-type", "application/json")
+    }
+
+    /// Send raw bytes as the body.
+    pub fn body(mut self, body: impl Into<Vec<u8>>) -> Self {
+        self.body = body.into();
+        self
+    }
+
+    /// Set a request header (names are matched lowercase, as adapters
+    /// normalize them).
+    pub fn header(mut self, name: &str, value: &str) -> Self {
+        self.headers
+            .push((name.to_ascii_lowercase(), value.to_string()));
+        self
+    }
+
+    /// Authenticate as `user` with its `Bearer` access token.
+    pub fn as_user(mut self, user: &TestUser) -> Self {
+        self.token = Some(user.token.clone());
+        self
+    }
+}
+
+/// The recorded outcome of a [`TestApp::request`]. Error terminals are
+/// mapped to the HTTP status the adapters would send.
+#[derive(Debug, Clone)]
+pub struct TestResponse {
+    pub status: u16,
+    pub headers: HashMap<String, String>,
+    pub body: Vec<u8>,
+}
+
+impl TestResponse {
+    /// The body as JSON, or `Value::Null` if it does not parse.
+    pub fn json(&self) -> serde_json::Value {
+        serde_json::from_slice(&self.body).unwrap_or(serde_json::Value::Null)
+    }
+
+    /// The body as text (lossy).
+    pub fn text(&self) -> String {
+        String::from_utf8_lossy(&self.body).into_owned()
+    }
+
+    /// A response header; the lookup is case-insensitive.
+    pub fn header(&self, name: &str) -> Option<&str> {
+        self.headers
+            .iter()
+            .find(|(k, _)| k.eq_ignore_ascii_case(name))
+            .map(|(_, v)| v.as_str())
+    }
+}
+
+impl TestApp {
+    /// A fresh app: admin + auth schemas, a random JWT secret and an empty
+    /// in-memory `wafer-run/storage`.
+    pub async fn new() -> Self {
+        let mut ctx = TestContext::with_auth().await;
+        ctx.register_mem_storage();
+        let secret = wafer_block_crypto::primitives::random_bytes(32).expect("random jwt secret");
+        Self {
+            ctx,
+            jwt_secret: crate::util::hex_encode(&secret),
+            base_path: String::new(),
+        }
+    }
+
+    /// Serve the app under `base_path`, as the router block does for
+    /// [`crate::SolobaseBuilder::base_path`]. Panics on an invalid prefix.
+    pub fn mount_at(&mut self, base_path: &str) {
+        self.base_path = crate::base_path::normalize(base_path).expect("valid base path");
+    }
+
+    /// The underlying context, for seeding rows or calling blocks directly.
+    pub fn ctx(&self) -> &TestContext {
+        &self.ctx
+    }
+
+    /// See [`TestContext::set_config`].
+    pub fn set_config(&mut self, key: &str, value: &str) {
+        self.ctx.set_config(key, value);
+    }
+
+    /// Register `block` under `name` and apply its migrations through the
+    /// same namespace-checked path as a block's `lifecycle(Init)`
+    /// ([`crate::migration_helper::apply_scoped`]). Returns the migration
+    /// error instead of panicking so tests can assert on rejected schemas.
+    pub async fn load_block(
+        &mut self,
+        name: &str,
+        block: Arc<dyn Block>,
+        sqlite: &[(&str, &str)],
+        postgres: &[&str],
+    ) -> Result<(), String> {
+        self.ctx.register_block(name, block);
+        crate::migration_helper::apply_scoped(&self.ctx, name, sqlite, postgres).await
+    }
+
+    /// Insert an active user with `role` (`"user"`, `"admin"`, ...) and mint
+    /// an access token for it.
+    pub async fn create_user(&self, email: &str, role: &str) -> TestUser {
+        use crate::blocks::auth::repo::users;
+
+        let row = users::insert(
+            &self.ctx,
+            users::NewUser {
+                email: email.to_string(),
+                display_name: String::new(),
+                avatar_url: None,
+                role: role.to_string(),
+            },
+        )
+        .await
+        .unwrap_or_else(|e| panic!("create test user {email}: {e}"));
+        let token = self.token(&row.id, email, role).await;
+        TestUser {
+            id: row.id,
+            email: email.to_string(),
+            role: role.to_string(),
+            token,
+        }
+    }
+
+    /// An access JWT the pipeline accepts: signed with the auth-ui-derived
+    /// key and carrying this deployment's issuer.
+    pub async fn token(&self, user_id: &str, email: &str, role: &str) -> String {
+        use wafer_block_crypto::primitives;
+
+        let issuer = crate::blocks::auth::helpers::expected_issuer(&self.ctx).await;
+        let claims: HashMap<String, serde_json::Value> = [
+            ("sub", serde_json::json!(user_id)),
+            ("email", serde_json::json!(email)),
+            ("roles", serde_json::json!([role])),
+            ("type", serde_json::json!("access")),
+            ("iss", serde_json::json!(issuer)),
+        ]
+        .into_iter()
+        .map(|(k, v)| (k.to_string(), v))
+        .collect();
+        let key = primitives::derive_block_key(
+            self.jwt_secret.as_bytes(),
+            crate::blocks::auth_ui::AUTH_UI_BLOCK_ID,
+        );
+        primitives::jwt_sign(claims, std::time::Duration::from_secs(3600), key.as_bytes())
+            .expect("sign test access token")
+    }
+
+    /// Send `req` through the request pipeline and record the response.
+    pub async fn request(&self, req: TestRequest) -> TestResponse {
+        use wafer_block::http_codec;
+
+        let mut msg = anon_msg(&req.action, &req.path);
+        msg.set_meta("http.method", &req.method);
+        msg.set_meta("req.client.ip", "127.0.0.1");
+        if !self.base_path.is_empty() {
+            msg.set_meta(crate::base_path::META, &self.base_path);
+        }
+        for (name, value) in &req.headers {
+            msg.set_meta(&format!("http.header.{name}"), value);
+        }
+        // A token wins; otherwise the `authorization` header goes to the
+        // pipeline as the adapters pass it (`ApiKey ...`, an S3 signature).
+        let auth_header = req
+            .token
+            .as_ref()
+            .map(|t| format!("Bearer {t}"))
+            .or_else(|| {
+                req.headers
+                    .iter()
+                    .find(|(name, _)| name == "authorization")
+                    .map(|(_, value)| value.clone())
+            });
+        let out = crate::handle_request(
+            &self.ctx,
+            msg,
+            InputStream::from_bytes(req.body),
+            auth_header.as_deref(),
+            &self.jwt_secret,
+            &crate::features::AllEnabled,
+            self.ctx.registered_blocks(),
+            &[],
+        )
+        .await;
+
+        let (status, meta, body) = match out.collect_buffered().await {
+            Ok(buf) | Err(TerminalNotResponse::Halt(buf)) => (
+                http_codec::resolve_status(&buf.meta, 200),
+                buf.meta,
+                buf.body,
+            ),
+            Err(TerminalNotResponse::Error(e)) => (
+                http_codec::resolve_error_status(&e),
+                e.meta,
+                e.message.into_bytes(),
+            ),
+            Err(TerminalNotResponse::Drop) => (204, Vec::new(), Vec::new()),
+            Err(other) => panic!("pipeline returned {other:?}"),
+        };
+        let headers = meta
+            .into_iter()
+            .filter_map(|m| {
+                m.key
+                    .strip_prefix("resp.header.")
+                    .map(|name| (name.to_string(), m.value))
+            })
+            .collect();
+        TestResponse {
+            status,
+            headers,
+            body,
+        }
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use wafer_block::db::ListOptions;
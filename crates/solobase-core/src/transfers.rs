@@ -0,0 +1,208 @@
+//! Per-principal concurrent transfer slots, and their current/peak counts.
+//!
+//! A block that serves large bodies calls [`try_acquire`] before the
+//! transfer and holds the returned [`Slot`] until its handler is done. The
+//! slot is released when it is dropped, so the count comes back down on
+//! every way out of the handler: a normal return, an early error, a client
+//! disconnect (the runtime drops the handler's future) or a panic.
+//!
+//! A principal is whatever the caller keys by — the files block uses the
+//! user id, or `ip:{addr}` for anonymous traffic. Counts are kept per
+//! block and principal, and [`snapshot`] feeds the admin extensions API
+//! next to [`crate::block_metrics`]. Like those, this is process memory: a
+//! restart (or a fresh Worker isolate) starts from zero, and on Workers
+//! each isolate counts its own transfers.
+
+use std::{
+    collections::BTreeMap,
+    sync::{Mutex, MutexGuard},
+};
+
+/// Idle principals kept for their peaks; past this, idle entries are
+/// forgotten (busy ones never are).
+pub const MAX_TRACKED: usize = 1000;
+
+/// Which way the body moves.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Direction {
+    Download,
+    Upload,
+}
+
+impl Direction {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Download => "download",
+            Self::Upload => "upload",
+        }
+    }
+
+    fn index(self) -> usize {
+        match self {
+            Self::Download => 0,
+            Self::Upload => 1,
+        }
+    }
+}
+
+#[derive(Default)]
+struct Standing {
+    current: [u32; 2],
+    peak: [u32; 2],
+}
+
+impl Standing {
+    fn busy(&self) -> bool {
+        self.current.iter().any(|&n| n > 0)
+    }
+}
+
+/// Keyed by `(block, principal)`.
+static REGISTRY: Mutex<BTreeMap<(String, String), Standing>> = Mutex::new(BTreeMap::new());
+
+fn lock() -> MutexGuard<'static, BTreeMap<(String, String), Standing>> {
+    // Every mutation is a counter step, so a panic while holding the lock
+    // can't leave an entry half-written.
+    REGISTRY.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// One running transfer; dropping it frees the slot.
+#[derive(Debug)]
+#[must_use = "the slot is released as soon as it is dropped"]
+pub struct Slot {
+    block: String,
+    principal: String,
+    direction: Direction,
+}
+
+impl Drop for Slot {
+    fn drop(&mut self) {
+        let mut registry = lock();
+        let key = (
+            std::mem::take(&mut self.block),
+            std::mem::take(&mut self.principal),
+        );
+        if let Some(standing) = registry.get_mut(&key) {
+            let n = &mut standing.current[self.direction.index()];
+            *n = n.saturating_sub(1);
+        }
+        if registry.len() > MAX_TRACKED {
+            registry.retain(|_, s| s.busy());
+        }
+    }
+}
+
+/// Take one of `principal`'s `limit` slots for `direction` on `block`, or
+/// `None` when all of them are in use. `limit` 0 means unlimited (the
+/// transfer is still counted).
+pub fn try_acquire(block: &str, principal: &str, direction: Direction, limit: u32) -> Option<Slot> {
+    let mut registry = lock();
+    let standing = registry
+        .entry((block.to_string(), principal.to_string()))
+        .or_default();
+    let i = direction.index();
+    if limit > 0 && standing.current[i] >= limit {
+        return None;
+    }
+    standing.current[i] += 1;
+    standing.peak[i] = standing.peak[i].max(standing.current[i]);
+    Some(Slot {
+        block: block.to_string(),
+        principal: principal.to_string(),
+        direction,
+    })
+}
+
+/// Transfers `principal` has running on `block` in `direction`.
+pub fn active(block: &str, principal: &str, direction: Direction) -> u32 {
+    lock()
+        .get(&(block.to_string(), principal.to_string()))
+        .map_or(0, |s| s.current[direction.index()])
+}
+
+/// One principal's transfers on a block, as reported to admins.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct PrincipalTransfers {
+    pub principal: String,
+    pub downloads: u32,
+    pub uploads: u32,
+    /// Most simultaneous downloads seen since boot.
+    pub peak_downloads: u32,
+    pub peak_uploads: u32,
+}
+
+/// Every principal `block` has counted: busiest now first, then by peak.
+pub fn snapshot(block: &str) -> Vec<PrincipalTransfers> {
+    let mut out: Vec<PrincipalTransfers> = lock()
+        .iter()
+        .filter(|((b, _), _)| b == block)
+        .map(|((_, principal), s)| PrincipalTransfers {
+            principal: principal.clone(),
+            downloads: s.current[0],
+            uploads: s.current[1],
+            peak_downloads: s.peak[0],
+            peak_uploads: s.peak[1],
+        })
+        .collect();
+    out.sort_by(|a, b| {
+        let key = |p: &PrincipalTransfers| {
+            (
+                p.downloads + p.uploads,
+                p.peak_downloads.max(p.peak_uploads),
+            )
+        };
+        key(b)
+            .cmp(&key(a))
+            .then_with(|| a.principal.cmp(&b.principal))
+    });
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn slots_are_capped_per_principal_and_direction() {
+        let block = "test/transfers-cap";
+        let a = try_acquire(block, "u1", Direction::Download, 2).unwrap();
+        let _b = try_acquire(block, "u1", Direction::Download, 2).unwrap();
+        assert!(try_acquire(block, "u1", Direction::Download, 2).is_none());
+        // Other principals and the other direction have their own slots.
+        let _other = try_acquire(block, "u2", Direction::Download, 2).unwrap();
+        let _up = try_acquire(block, "u1", Direction::Upload, 1).unwrap();
+
+        drop(a);
+        assert_eq!(active(block, "u1", Direction::Download), 1);
+        assert!(try_acquire(block, "u1", Direction::Download, 2).is_some());
+        // Limit 0 counts without capping.
+        let unlimited: Vec<Slot> = (0..10)
+            .filter_map(|_| try_acquire(block, "u3", Direction::Upload, 0))
+            .collect();
+        assert_eq!(unlimited.len(), 10);
+    }
+
+    #[test]
+    fn panics_release_their_slot_and_peaks_remain() {
+        let block = "test/transfers-panic";
+        let result = std::panic::catch_unwind(|| {
+            let _slot = try_acquire(block, "u1", Direction::Upload, 1).unwrap();
+            let _second = try_acquire(block, "u1", Direction::Download, 5).unwrap();
+            panic!("handler failed mid-transfer");
+        });
+        assert!(result.is_err());
+        assert_eq!(active(block, "u1", Direction::Upload), 0);
+
+        let report = snapshot(block);
+        assert_eq!(
+            report,
+            vec![PrincipalTransfers {
+                principal: "u1".into(),
+                downloads: 0,
+                uploads: 0,
+                peak_downloads: 1,
+                peak_uploads: 1,
+            }]
+        );
+    }
+}
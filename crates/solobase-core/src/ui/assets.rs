@@ -219,6 +219,15 @@ pub fn files_browser_js_url() -> &'static str {
     })
 }
 
+const UPLOAD_WIDGET_JS: &str = include_str!("assets/upload-widget.js");
+
+/// Embedded dependency-free upload widget for third-party pages. Served by
+/// the files block at a stable, unhashed URL (`files::widget`) because
+/// embedders hard-code it in their `<script src>`.
+pub fn upload_widget_js() -> &'static str {
+    UPLOAD_WIDGET_JS
+}
+
 /// Small inline JS for toast notifications (triggered by htmx HX-Trigger).
 pub fn toast_js() -> &'static str {
     r#"
@@ -388,6 +397,46 @@ pub fn drawer_js() -> &'static str {
 "#
 }
 
+/// Vanilla JS that hides the admin sidebar and palette entries a scoped
+/// admin can't open. Asks `GET /api/v1/iam/my-permissions` which sections
+/// the caller may open and drops the links marked `false`, then any group
+/// left empty, so a click never lands on a 403. Leaves the nav alone if
+/// the request fails; the router still refuses what the caller lacks.
+/// The fetch and the links pick up a base path as every page script's do
+/// (see [`crate::base_path`]).
+pub fn nav_permissions_js() -> &'static str {
+    r#"
+(function () {
+  if (window.__navPermsInit) return;
+  window.__navPermsInit = true;
+  fetch('/api/v1/iam/my-permissions', {
+    credentials: 'same-origin',
+    headers: { 'Accept': 'application/json' }
+  })
+    .then(function (r) { return r.ok ? r.json() : null; })
+    .then(function (p) {
+      if (!p || p.admin || !p.sections) return;
+      // Links carry the base path when mounted under one; sections don't.
+      var base = window.SOLOBASE_BASE_PATH || '';
+      function denied(href) {
+        if (base && href && href.indexOf(base + '/') === 0) href = href.slice(base.length);
+        return p.sections[href] === false;
+      }
+      document.querySelectorAll('.sidebar a[href]').forEach(function (a) {
+        if (denied(a.getAttribute('href'))) (a.closest('li') || a).remove();
+      });
+      document.querySelectorAll('.sidebar__group').forEach(function (g) {
+        if (!g.querySelector('li')) g.remove();
+      });
+      document.querySelectorAll('#cmdk-list [data-href]').forEach(function (el) {
+        if (denied(el.getAttribute('data-href'))) el.remove();
+      });
+    })
+    .catch(function () {});
+})();
+"#
+}
+
 #[cfg(test)]
 mod tests {
     #[test]
@@ -488,6 +537,16 @@ mod tests {
         assert!(js.contains("__drawerInit"));
     }
 
+    #[test]
+    fn nav_permissions_js_drops_denied_sidebar_and_palette_entries() {
+        let js = super::nav_permissions_js();
+        assert!(js.contains("/api/v1/iam/my-permissions"));
+        assert!(js.contains(".sidebar a[href]"));
+        assert!(js.contains("[data-href]"));
+        assert!(js.contains("=== false"));
+        assert!(js.contains("__navPermsInit"));
+    }
+
     #[test]
     fn llm_chat_js_is_self_invoking_and_exposes_init() {
         let js = super::llm_chat_js();
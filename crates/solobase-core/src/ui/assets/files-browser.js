@@ -382,7 +382,9 @@
         });
         if (resp.ok) {
           // Redirect into the new bucket so the user can immediately upload.
-          window.location.href = '/b/storage/' + encodeURIComponent(name) + '/';
+          window.location.href = (window.solobaseUrl || String)(
+            '/b/storage/' + encodeURIComponent(name) + '/',
+          );
           return;
         }
         let serverMsg = 'Failed to create bucket.';
@@ -0,0 +1,155 @@
+/*
+ * Solobase upload widget — served at /b/storage/api/widgets/upload.js.
+ *
+ * Embed:
+ *   <script src="https://files.example.com/b/storage/api/widgets/upload.js" defer></script>
+ *   <div data-solobase-upload data-token="TOKEN_FROM_YOUR_BACKEND"></div>
+ *
+ * or mount by hand: SolobaseUpload.mount(el, { token, accept, label }).
+ *
+ * The token comes from POST /b/storage/api/widgets/upload-sessions, called
+ * by your backend with its API key. The element fires
+ * `solobase-upload:done` (detail: the stored object) and
+ * `solobase-upload:error` (detail: the error body) events. Each file is one
+ * multipart POST, so no CORS preflight is needed.
+ */
+(function () {
+  if (window.SolobaseUpload) return;
+
+  var script = document.currentScript;
+  var UPLOAD_PATH = "/b/storage/api/widgets/upload";
+  // Everything before the script's own path, so a deployment mounted
+  // under a prefix (https://example.com/solobase/b/...) keeps it.
+  var base = "";
+  if (script) {
+    var src = new URL(script.src);
+    var at = src.pathname.indexOf(UPLOAD_PATH + ".js");
+    base = src.origin + (at > 0 ? src.pathname.slice(0, at) : "");
+  }
+
+  var STYLE =
+    ".sb-upload{border:2px dashed #b8bcc6;border-radius:8px;padding:16px;text-align:center;" +
+    "font:14px/1.4 system-ui,sans-serif;color:#333;cursor:pointer}" +
+    ".sb-upload[data-over]{border-color:#4f6bed;background:#f3f5ff}" +
+    ".sb-upload button{font:inherit;padding:6px 14px;border-radius:6px;border:1px solid #4f6bed;" +
+    "background:#4f6bed;color:#fff;cursor:pointer}" +
+    ".sb-upload ul{list-style:none;margin:10px 0 0;padding:0;text-align:left}" +
+    ".sb-upload li[data-state=error]{color:#b42318}" +
+    ".sb-upload li[data-state=done]{color:#067647}";
+
+  function injectStyle() {
+    if (document.getElementById("sb-upload-style")) return;
+    var s = document.createElement("style");
+    s.id = "sb-upload-style";
+    s.textContent = STYLE;
+    document.head.appendChild(s);
+  }
+
+  function emit(el, name, detail) {
+    el.dispatchEvent(new CustomEvent("solobase-upload:" + name, { detail: detail, bubbles: true }));
+  }
+
+  function send(el, opts, file, row) {
+    var form = new FormData();
+    form.append("file", file, file.name);
+    var xhr = new XMLHttpRequest();
+    xhr.open("POST", base + UPLOAD_PATH + "?token=" + encodeURIComponent(opts.token));
+    xhr.upload.onprogress = function (e) {
+      if (e.lengthComputable) {
+        row.textContent = file.name + " — " + Math.round((e.loaded / e.total) * 100) + "%";
+      }
+    };
+    xhr.onload = function () {
+      var body = null;
+      try {
+        body = JSON.parse(xhr.responseText);
+      } catch (_) {}
+      if (xhr.status >= 200 && xhr.status < 300 && body) {
+        row.dataset.state = "done";
+        row.textContent = file.name + " — uploaded";
+        emit(el, "done", body);
+      } else {
+        var message = (body && body.message) || "Upload failed";
+        row.dataset.state = "error";
+        row.textContent = file.name + " — " + message;
+        emit(el, "error", body || { message: message, status: xhr.status });
+      }
+    };
+    xhr.onerror = function () {
+      row.dataset.state = "error";
+      row.textContent = file.name + " — network error";
+      emit(el, "error", { message: "network error", status: 0 });
+    };
+    xhr.send(form);
+  }
+
+  function mount(el, opts) {
+    opts = opts || {};
+    opts.token = opts.token || el.getAttribute("data-token") || "";
+    opts.accept = opts.accept || el.getAttribute("data-accept") || "";
+    opts.label = opts.label || el.getAttribute("data-label") || "Drop files here or";
+    if (!opts.token) throw new Error("SolobaseUpload: missing token");
+    injectStyle();
+
+    el.classList.add("sb-upload");
+    el.textContent = "";
+    var input = document.createElement("input");
+    input.type = "file";
+    input.multiple = true;
+    input.hidden = true;
+    if (opts.accept) input.accept = opts.accept;
+    var label = document.createElement("span");
+    label.textContent = opts.label + " ";
+    var button = document.createElement("button");
+    button.type = "button";
+    button.textContent = "Choose files";
+    var list = document.createElement("ul");
+    el.append(input, label, button, list);
+
+    function start(files) {
+      Array.prototype.forEach.call(files, function (file) {
+        var row = document.createElement("li");
+        row.textContent = file.name;
+        list.appendChild(row);
+        send(el, opts, file, row);
+      });
+    }
+
+    // The button and the rest of the dropzone both open the picker; the
+    // picker's own click bubbles here too and is ignored.
+    el.addEventListener("click", function (e) {
+      if (e.target !== input) input.click();
+    });
+    input.addEventListener("change", function () {
+      start(input.files);
+      input.value = "";
+    });
+    el.addEventListener("dragover", function (e) {
+      e.preventDefault();
+      el.dataset.over = "";
+    });
+    el.addEventListener("dragleave", function () {
+      delete el.dataset.over;
+    });
+    el.addEventListener("drop", function (e) {
+      e.preventDefault();
+      delete el.dataset.over;
+      start(e.dataTransfer.files);
+    });
+    return el;
+  }
+
+  function mountAll() {
+    var els = document.querySelectorAll("[data-solobase-upload]");
+    Array.prototype.forEach.call(els, function (el) {
+      if (!el.classList.contains("sb-upload")) mount(el);
+    });
+  }
+
+  window.SolobaseUpload = { mount: mount };
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", mountAll);
+  } else {
+    mountAll();
+  }
+})();
@@ -152,6 +152,10 @@ pub struct Page<'a> {
     pub current_path: &'a str,
     pub topbar: shell::Topbar<'a>,
     pub body: maud::Markup,
+    /// Hide the sidebar and palette entries the caller can't open (see
+    /// [`assets::nav_permissions_js`]). Set when a non-admin, let in by a
+    /// route permission, gets the admin sidebar.
+    pub filter_nav: bool,
 }
 
 impl<'a> Page<'a> {
@@ -164,6 +168,11 @@ impl<'a> Page<'a> {
         } else {
             html! {}
         };
+        let nav_filter = if self.filter_nav {
+            html! { script { (PreEscaped(assets::nav_permissions_js())) } }
+        } else {
+            html! {}
+        };
         layout::page(
             self.title,
             self.config,
@@ -180,6 +189,7 @@ impl<'a> Page<'a> {
                 (palette_markup)
                 script { (PreEscaped(assets::palette_js())) }
                 script { (PreEscaped(assets::drawer_js())) }
+                (nav_filter)
             },
         )
     }
@@ -263,6 +273,7 @@ pub async fn shell_page(
     let user = UserInfo::from_message(msg);
     let groups = shell.nav.groups();
     let path = msg.path().to_string();
+    let filter_nav = shell.nav == NavKind::Admin && user.as_ref().is_some_and(|u| !u.is_admin());
     Page {
         config: &config,
         title: shell.title,
@@ -276,6 +287,7 @@ pub async fn shell_page(
             show_palette: true,
         },
         body,
+        filter_nav,
     }
     .response(msg)
 }
@@ -334,6 +346,32 @@ pub fn forbidden_response(msg: &wafer_run::Message) -> wafer_run::OutputStream {
     }
 }
 
+/// Styled 403 naming the missing `permission` for browser requests; JSON
+/// `permission_denied` with `details.permission` for API requests (see
+/// [`crate::blocks::permissions`]).
+pub fn permission_denied_response(
+    msg: &wafer_run::Message,
+    permission: &str,
+) -> wafer_run::OutputStream {
+    let accept = msg.get_meta("http.header.accept");
+    if accept.contains("text/html") && !accept.contains("application/json") {
+        status_response(
+            403,
+            "Forbidden",
+            "403",
+            "Forbidden",
+            &format!("You need the {permission} permission to open this page."),
+            ("Go home", "/"),
+        )
+    } else {
+        crate::blocks::errors::error_json(
+            crate::blocks::errors::ErrorCode::PermissionDenied,
+            &format!("Missing permission {permission}"),
+            Some(serde_json::json!({ "permission": permission })),
+        )
+    }
+}
+
 /// Anonymous (or stale-session — identical by the time enforcement runs)
 /// browser request on a protected route: send the user to login with a return
 /// path so they land back where they started after signing in. API callers
@@ -456,6 +494,7 @@ mod tests {
                 show_palette: true,
             },
             body,
+            filter_nav: false,
         }
     }
 
@@ -22,6 +22,7 @@ pub fn admin() -> Vec<NavGroup> {
             items: vec![
                 item("Dashboard", "/b/admin/", icons::layout_dashboard),
                 item("Users", "/b/admin/users", icons::users),
+                item("Billing", "/b/products/admin/", icons::dollar_sign),
             ],
         },
         NavGroup {
@@ -107,11 +108,11 @@ mod tests {
     }
 
     #[test]
-    fn admin_workspace_has_dashboard_and_users() {
+    fn admin_workspace_has_dashboard_users_and_billing() {
         let groups = admin();
         let workspace = &groups[0];
         let labels: Vec<&str> = workspace.items.iter().map(|i| i.label.as_str()).collect();
-        assert_eq!(labels, vec!["Dashboard", "Users"]);
+        assert_eq!(labels, vec!["Dashboard", "Users", "Billing"]);
     }
 
     #[test]
@@ -297,6 +297,7 @@ pub async fn save_settings(
             return err_internal(&format!("Failed to save {block_label} settings"), e);
         }
     }
+    crate::revisions::bump(crate::revisions::Category::Settings);
     ok_json(&serde_json::json!({"message": "Settings saved"}))
 }
 
@@ -12,14 +12,75 @@ use wafer_core::clients::database::Record;
 /// sha256_hex}` call sites across the blocks keep one import path.
 pub use wafer_run::{hex_encode, sha256, sha256_hex};
 
-/// Current UTC time as RFC 3339 string.
+/// Current UTC time as RFC 3339 string, in the [`format_rfc3339`] layout.
+/// Reads [`crate::clock::now`], so tests can freeze it.
 pub fn now_rfc3339() -> String {
-    chrono::Utc::now().to_rfc3339()
+    format_rfc3339(crate::clock::now())
+}
+
+/// The single timestamp writer: UTC, microsecond precision, literal `Z`
+/// (`2026-07-11T19:13:45.123456Z`).
+///
+/// Every stored timestamp and every timestamp in a JSON response goes
+/// through here. The fixed width matters as much as the zone — the
+/// cleanup and window queries compare timestamp columns as strings
+/// (`expires_at < cutoff`), which is only correct when every row has the
+/// same layout.
+pub fn format_rfc3339<Tz: chrono::TimeZone>(t: chrono::DateTime<Tz>) -> String {
+    t.with_timezone(&chrono::Utc)
+        .to_rfc3339_opts(chrono::SecondsFormat::Micros, true)
+}
+
+/// Parse a stored timestamp, accepting the legacy layouts older rows were
+/// written in: RFC 3339 with any offset or precision, the space-separated
+/// `2006-01-02 15:04:05[.fff]` of SQL `CURRENT_TIMESTAMP`, the same with a
+/// PostgreSQL `+00` offset, and a bare date. Offset-less values are UTC.
+///
+/// Returns `None` (never panics) for anything else; callers decide whether
+/// that means "expired", "unknown" or a 400.
+pub fn parse_timestamp(s: &str) -> Option<chrono::DateTime<chrono::Utc>> {
+    use chrono::{DateTime, NaiveDate, NaiveDateTime, Utc};
+
+    let s = s.trim();
+    if s.is_empty() {
+        return None;
+    }
+    if let Ok(t) = DateTime::parse_from_rfc3339(s) {
+        return Some(t.with_timezone(&Utc));
+    }
+    if let Ok(t) = DateTime::parse_from_str(s, "%Y-%m-%d %H:%M:%S%.f%#z") {
+        return Some(t.with_timezone(&Utc));
+    }
+    for layout in ["%Y-%m-%d %H:%M:%S%.f", "%Y-%m-%dT%H:%M:%S%.f"] {
+        if let Ok(t) = NaiveDateTime::parse_from_str(s, layout) {
+            return Some(t.and_utc());
+        }
+    }
+    NaiveDate::parse_from_str(s, "%Y-%m-%d")
+        .ok()
+        .and_then(|d| d.and_hms_opt(0, 0, 0))
+        .map(|t| t.and_utc())
+}
+
+/// Re-emit a stored timestamp in the [`format_rfc3339`] layout. Values
+/// [`parse_timestamp`] can't read are logged and passed through unchanged,
+/// so a bad row degrades to its raw value instead of failing the response.
+pub fn normalize_timestamp(s: &str) -> String {
+    match parse_timestamp(s) {
+        Some(t) => format_rfc3339(t),
+        None => {
+            if !s.is_empty() {
+                tracing::warn!("unparseable timestamp {s:?} left as stored");
+            }
+            s.to_string()
+        }
+    }
 }
 
 /// Current time in milliseconds (wasm-safe — uses chrono which uses js_sys on wasm32).
+/// Reads [`crate::clock::now`], so tests can freeze it.
 pub fn now_millis() -> u64 {
-    chrono::Utc::now().timestamp_millis() as u64
+    crate::clock::now().timestamp_millis() as u64
 }
 
 /// Extract a single path id from a request, preferring the router-populated
@@ -122,6 +183,24 @@ pub fn field_as_string(record: &Record, key: &str) -> String {
     }
 }
 
+/// Append one CSV record (RFC 4180) terminated by CRLF. Cells containing a
+/// delimiter, quote, or line break are quoted with embedded quotes doubled.
+pub fn write_csv_row<'a>(out: &mut String, cells: impl Iterator<Item = &'a str>) {
+    for (i, cell) in cells.enumerate() {
+        if i > 0 {
+            out.push(',');
+        }
+        if cell.contains([',', '"', '\r', '\n']) {
+            out.push('"');
+            out.push_str(&cell.replace('"', "\"\""));
+            out.push('"');
+        } else {
+            out.push_str(cell);
+        }
+    }
+    out.push_str("\r\n");
+}
+
 /// Humanize a byte count for table/stat display: `105` → `"105 B"`,
 /// `1_234` → `"1.2 KB"`, and so on up through GB (binary units).
 pub fn format_bytes(bytes: i64) -> String {
@@ -137,18 +216,15 @@ pub fn format_bytes(bytes: i64) -> String {
 }
 
 /// Humanize an RFC 3339 timestamp for visible table text: `"2026-07-11 19:13"`
-/// (UTC, minute precision) instead of the raw nanosecond-resolution string
+/// (UTC, minute precision) instead of the raw microsecond-resolution string
 /// [`now_rfc3339`] produces. Returns the input unchanged when it doesn't
 /// parse, so a malformed stored value degrades to what we have rather than
 /// hiding the row's timestamp — callers keep the full raw value in the
 /// machine-readable `<time datetime=...>` attribute either way.
 pub fn format_timestamp(rfc3339: &str) -> String {
-    match chrono::DateTime::parse_from_rfc3339(rfc3339) {
-        Ok(dt) => dt
-            .with_timezone(&chrono::Utc)
-            .format("%Y-%m-%d %H:%M")
-            .to_string(),
-        Err(_) => rfc3339.to_string(),
+    match parse_timestamp(rfc3339) {
+        Some(dt) => dt.format("%Y-%m-%d %H:%M").to_string(),
+        None => rfc3339.to_string(),
     }
 }
 
@@ -214,6 +290,30 @@ pub fn forward_auth_meta(msg: &mut wafer_run::Message, original: &wafer_run::Mes
     }
 }
 
+/// Collect an `InputStream` into `Vec<u8>` with a hard size cap. Errors out
+/// as soon as the running total exceeds `cap_bytes`, so a multi-GB body
+/// can't OOM the process before the caller checks quota. A `cap_bytes` of 0
+/// or less means no cap. Returns `Err(())` when the cap is exceeded.
+pub async fn collect_with_cap(
+    mut input: wafer_run::InputStream,
+    cap_bytes: i64,
+) -> Result<Vec<u8>, ()> {
+    use futures::StreamExt;
+    let cap = if cap_bytes <= 0 {
+        usize::MAX
+    } else {
+        cap_bytes as usize
+    };
+    let mut out = Vec::new();
+    while let Some(chunk) = input.next().await {
+        if out.len().saturating_add(chunk.len()) > cap {
+            return Err(());
+        }
+        out.extend_from_slice(&chunk);
+    }
+    Ok(out)
+}
+
 /// The RFC 3986 unreserved characters (`A-Z a-z 0-9 - _ . ~`) — the only bytes
 /// [`url_path_encode`] leaves untouched. Built from `NON_ALPHANUMERIC` (which
 /// encodes every non-alphanumeric ASCII byte) by removing the four unreserved
@@ -234,6 +334,31 @@ pub fn url_path_encode(s: &str) -> String {
     percent_encoding::utf8_percent_encode(s, PATH_SEGMENT).to_string()
 }
 
+/// Escape SQL LIKE wildcards (`%`, `_`) and the escape char itself (`\`) in
+/// user-supplied search terms so a user searching for `100% off` doesn't
+/// also match arbitrary characters.
+///
+/// SQLite's `LIKE` has *no* default escape character — a bare backslash is
+/// just a literal byte, so escaping here would be silently inert on its own.
+/// What makes it effective is the `wafer-sql-utils` `FilterOp::Like` builder,
+/// which renders an explicit `ESCAPE '\'` clause on every backend (SQLite/D1
+/// and Postgres) — see `wafer-sql-utils::query::leaf_expr`. Without that
+/// clause, a query containing `_` or `%` would match as a wildcard instead of
+/// a literal character.
+pub fn escape_like(input: &str) -> String {
+    let mut out = String::with_capacity(input.len());
+    for c in input.chars() {
+        match c {
+            '\\' | '%' | '_' => {
+                out.push('\\');
+                out.push(c);
+            }
+            other => out.push(other),
+        }
+    }
+    out
+}
+
 /// Validate a URL-type config value against SSRF attacks.
 ///
 /// Empty values are allowed (clears the setting). Relative paths starting with
@@ -406,6 +531,64 @@ mod tests {
         let _: chrono::DateTime<chrono::Utc> = s.parse().expect("rfc3339 round-trip");
     }
 
+    #[test]
+    fn format_rfc3339_is_fixed_width_utc() {
+        let t = chrono::DateTime::parse_from_rfc3339("2026-03-29T03:30:00.5+02:00").unwrap();
+        assert_eq!(format_rfc3339(t), "2026-03-29T01:30:00.500000Z");
+        assert_eq!(now_rfc3339().len(), "2026-03-29T01:30:00.500000Z".len());
+    }
+
+    #[test]
+    fn parse_timestamp_accepts_legacy_layouts() {
+        let want = "2026-07-11T19:13:45.000000Z";
+        for legacy in [
+            "2026-07-11T19:13:45Z",
+            "2026-07-11T19:13:45+00:00",
+            "2026-07-11T21:13:45+02:00",
+            "2026-07-11 19:13:45",
+            "2026-07-11T19:13:45",
+            "2026-07-11 19:13:45+00",
+            "2026-07-11 15:13:45-04:00",
+        ] {
+            assert_eq!(normalize_timestamp(legacy), want, "{legacy}");
+        }
+        assert_eq!(
+            normalize_timestamp("2026-07-11"),
+            "2026-07-11T00:00:00.000000Z"
+        );
+    }
+
+    #[test]
+    fn parse_timestamp_keeps_sub_second_precision() {
+        assert_eq!(
+            normalize_timestamp("2026-07-11T19:13:45.123456789+00:00"),
+            "2026-07-11T19:13:45.123456Z"
+        );
+        assert_eq!(
+            normalize_timestamp("2026-07-11 19:13:45.25"),
+            "2026-07-11T19:13:45.250000Z"
+        );
+    }
+
+    #[test]
+    fn parse_timestamp_resolves_dst_boundaries_by_offset() {
+        // 01:30 local happens twice on the US fall-back night; the offset
+        // tells the two apart and they stay an hour apart in UTC.
+        let first = parse_timestamp("2026-11-01T01:30:00-04:00").unwrap();
+        let second = parse_timestamp("2026-11-01T01:30:00-05:00").unwrap();
+        assert_eq!(format_rfc3339(first), "2026-11-01T05:30:00.000000Z");
+        assert_eq!((second - first).num_hours(), 1);
+        assert!(format_rfc3339(first) < format_rfc3339(second));
+    }
+
+    #[test]
+    fn parse_timestamp_rejects_garbage_without_panicking() {
+        assert_eq!(parse_timestamp(""), None);
+        assert_eq!(parse_timestamp("yesterday"), None);
+        assert_eq!(parse_timestamp("2026-13-45 99:00:00"), None);
+        assert_eq!(normalize_timestamp("yesterday"), "yesterday");
+    }
+
     #[test]
     fn format_bytes_humanizes_each_magnitude() {
         assert_eq!(format_bytes(0), "0 B");
@@ -418,13 +601,14 @@ mod tests {
 
     #[test]
     fn format_timestamp_humanizes_rfc3339_to_utc_minutes() {
-        // Nanosecond-resolution output of `now_rfc3339` (chrono to_rfc3339).
+        // Nanosecond-resolution rows written before `format_rfc3339`.
         assert_eq!(
             format_timestamp("2026-07-11T19:13:45.123456789+00:00"),
             "2026-07-11 19:13"
         );
         // Z-suffixed and offset forms normalize to UTC.
         assert_eq!(format_timestamp("2026-05-06T10:00:00Z"), "2026-05-06 10:00");
+        assert_eq!(format_timestamp("2026-05-06 10:00:00"), "2026-05-06 10:00");
         assert_eq!(
             format_timestamp("2026-05-06T12:30:00+02:00"),
             "2026-05-06 10:30"
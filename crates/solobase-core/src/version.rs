@@ -0,0 +1,232 @@
+//! Build identity: which Solobase a running instance is, and whether a newer
+//! release is out.
+//!
+//! [`VERSION`] is the crate version. `build.rs` stamps the commit, build time
+//! and compiler; a release pipeline pins them by exporting
+//! `SOLOBASE_GIT_COMMIT`, `SOLOBASE_BUILD_EPOCH` (or `SOURCE_DATE_EPOCH`) and
+//! `SOLOBASE_RUSTC_VERSION` before building. Anything a build couldn't
+//! determine reads `unknown`.
+//!
+//! `GET /api/version` (the system block) answers anonymous callers with the
+//! version alone and admins with the whole [`BuildInfo`].
+//!
+//! The update check is off unless [`UPDATE_CHECK_KEY`] is true. The native
+//! job worker then calls [`check_if_due`], which at most once a day sends a
+//! plain GET to [`UPDATE_URL_KEY`] — GitHub's latest-release endpoint by
+//! default — and keeps the release's `tag_name` in process memory for the
+//! admin dashboard. Nothing about the instance is sent and nothing is
+//! installed. A failed check (offline, a non-2xx answer, a body without a
+//! tag) is logged at `debug` and keeps the previous result. The wasm builds
+//! never check.
+
+use wafer_run::context::Context;
+
+/// The crate version, e.g. `0.1.0`.
+pub const VERSION: &str = env!("CARGO_PKG_VERSION");
+
+/// Turns the daily update check on.
+pub const UPDATE_CHECK_KEY: &str = "SUPPERS_AI__ADMIN__UPDATE_CHECK";
+/// Where the update check fetches the latest release from. The answer must
+/// be a JSON object with a `tag_name`, as GitHub's is.
+pub const UPDATE_URL_KEY: &str = "SUPPERS_AI__ADMIN__UPDATE_CHECK_URL";
+pub const DEFAULT_UPDATE_URL: &str =
+    "https://api.github.com/repos/suppers-ai/solobase/releases/latest";
+
+/// Seconds between update checks.
+#[cfg(not(target_arch = "wasm32"))]
+const CHECK_INTERVAL_SECS: i64 = 24 * 60 * 60;
+
+const UNKNOWN: &str = "unknown";
+
+/// Everything known about this build.
+#[derive(Debug, Clone, serde::Serialize)]
+pub struct BuildInfo {
+    pub version: &'static str,
+    pub commit: &'static str,
+    /// RFC 3339, or `unknown`.
+    pub build_date: String,
+    pub rustc: &'static str,
+    /// `wasm`, or `{os}-{arch}` for a native build.
+    pub platform: String,
+}
+
+/// This build's [`BuildInfo`].
+pub fn build_info() -> BuildInfo {
+    let build_date = build_epoch()
+        .and_then(|secs| chrono::DateTime::from_timestamp(secs, 0))
+        .map(crate::util::format_rfc3339)
+        .unwrap_or_else(|| UNKNOWN.to_string());
+    BuildInfo {
+        version: VERSION,
+        commit: option_env!("SOLOBASE_GIT_COMMIT").unwrap_or(UNKNOWN),
+        build_date,
+        rustc: option_env!("SOLOBASE_RUSTC_VERSION").unwrap_or(UNKNOWN),
+        platform: platform(),
+    }
+}
+
+/// When this build was made, in Unix seconds, if `build.rs` could tell.
+pub fn build_epoch() -> Option<i64> {
+    option_env!("SOLOBASE_BUILD_EPOCH").and_then(|s| s.trim().parse().ok())
+}
+
+/// `wasm` for the browser and Cloudflare builds, `{os}-{arch}` otherwise.
+pub fn platform() -> String {
+    if cfg!(target_arch = "wasm32") {
+        "wasm".to_string()
+    } else {
+        format!("{}-{}", std::env::consts::OS, std::env::consts::ARCH)
+    }
+}
+
+/// The version, commit and platform for a log line, e.g.
+/// `v0.1.0 (3f2a9c1d0b7e, linux-x86_64)`.
+pub fn describe() -> String {
+    let info = build_info();
+    format!("v{} ({}, {})", info.version, info.commit, info.platform)
+}
+
+// ---------------------------------------------------------------------------
+// Update check
+// ---------------------------------------------------------------------------
+
+/// What the last successful update check found.
+#[derive(Debug, Clone, serde::Serialize)]
+pub struct UpdateStatus {
+    pub latest_version: String,
+    pub checked_at: String,
+    pub update_available: bool,
+}
+
+#[cfg(not(target_arch = "wasm32"))]
+static LAST_CHECK: std::sync::atomic::AtomicI64 = std::sync::atomic::AtomicI64::new(0);
+#[cfg(not(target_arch = "wasm32"))]
+static STATUS: std::sync::Mutex<Option<UpdateStatus>> = std::sync::Mutex::new(None);
+
+/// The cached result of the last update check. `None` when the check is off,
+/// hasn't succeeded yet, or the build is wasm.
+pub fn update_status() -> Option<UpdateStatus> {
+    #[cfg(not(target_arch = "wasm32"))]
+    {
+        STATUS.lock().unwrap_or_else(|e| e.into_inner()).clone()
+    }
+    #[cfg(target_arch = "wasm32")]
+    {
+        None
+    }
+}
+
+/// Check for a newer release if the check is on and the last one was a day
+/// ago or more. Called from the native job worker's loop; a no-op on wasm.
+pub async fn check_if_due(ctx: &dyn Context) {
+    #[cfg(not(target_arch = "wasm32"))]
+    {
+        use std::sync::atomic::Ordering;
+
+        let enabled = wafer_core::clients::config::get_default(ctx, UPDATE_CHECK_KEY, "false")
+            .await
+            .trim()
+            .eq_ignore_ascii_case("true");
+        if !enabled {
+            // Turned off: stop reporting what an earlier check found.
+            *STATUS.lock().unwrap_or_else(|e| e.into_inner()) = None;
+            return;
+        }
+        let now = chrono::Utc::now().timestamp();
+        if now - LAST_CHECK.load(Ordering::Relaxed) < CHECK_INTERVAL_SECS {
+            return;
+        }
+        LAST_CHECK.store(now, Ordering::Relaxed);
+        match fetch_latest(ctx).await {
+            Some(latest) => {
+                let status = UpdateStatus {
+                    update_available: is_newer(&latest, VERSION),
+                    latest_version: latest,
+                    checked_at: crate::util::now_rfc3339(),
+                };
+                *STATUS.lock().unwrap_or_else(|e| e.into_inner()) = Some(status);
+            }
+            None => tracing::debug!("update check found no release"),
+        }
+    }
+    #[cfg(target_arch = "wasm32")]
+    let _ = ctx;
+}
+
+/// The latest release's tag, or `None` on any failure.
+#[cfg(not(target_arch = "wasm32"))]
+async fn fetch_latest(ctx: &dyn Context) -> Option<String> {
+    let url =
+        wafer_core::clients::config::get_default(ctx, UPDATE_URL_KEY, DEFAULT_UPDATE_URL).await;
+    let mut headers = std::collections::HashMap::new();
+    headers.insert("Accept".to_string(), "application/json".to_string());
+    // GitHub refuses requests without one.
+    headers.insert("User-Agent".to_string(), "solobase".to_string());
+    let resp = match wafer_core::clients::network::do_request(
+        ctx,
+        "GET",
+        url.trim(),
+        &headers,
+        None,
+    )
+    .await
+    {
+        Ok(r) => r,
+        Err(e) => {
+            tracing::debug!(error = %e, "update check request failed");
+            return None;
+        }
+    };
+    if !(200..300).contains(&resp.status_code) {
+        tracing::debug!(status = resp.status_code, "update check rejected");
+        return None;
+    }
+    let body: serde_json::Value = serde_json::from_slice(&resp.body).ok()?;
+    let tag = body.get("tag_name")?.as_str()?.trim();
+    (!tag.is_empty()).then(|| tag.to_string())
+}
+
+/// Whether release `latest` is newer than `current`. Both are compared as
+/// dot-separated numbers with any leading `v`; a pre-release suffix is
+/// ignored, and anything unparsable is never newer.
+pub fn is_newer(latest: &str, current: &str) -> bool {
+    fn parts(v: &str) -> Option<Vec<u64>> {
+        let v = v.trim().trim_start_matches('v');
+        let core = v.split(['-', '+']).next().unwrap_or(v);
+        core.split('.').map(|p| p.parse().ok()).collect()
+    }
+    match (parts(latest), parts(current)) {
+        (Some(mut latest), Some(mut current)) => {
+            let len = latest.len().max(current.len());
+            latest.resize(len, 0);
+            current.resize(len, 0);
+            latest > current
+        }
+        _ => false,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn compares_release_tags() {
+        assert!(is_newer("v0.2.0", "0.1.9"));
+        assert!(is_newer("0.1.10", "0.1.9"));
+        assert!(is_newer("v1.0", "0.9.9"));
+        assert!(!is_newer("v0.1.0", "0.1.0"));
+        assert!(!is_newer("0.1", "0.1.0"));
+        assert!(!is_newer("v0.1.0-rc.1", "0.1.0"));
+        assert!(!is_newer("nightly", "0.1.0"));
+    }
+
+    #[test]
+    fn build_info_reports_the_crate_version() {
+        let info = build_info();
+        assert_eq!(info.version, env!("CARGO_PKG_VERSION"));
+        assert!(!info.commit.is_empty());
+        assert_ne!(info.platform, "wasm");
+        assert!(describe().starts_with(&format!("v{VERSION} (")));
+    }
+}
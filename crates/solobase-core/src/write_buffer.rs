@@ -0,0 +1,492 @@
+//! Coalescing write buffers for frequent, loss-tolerant rows.
+//!
+//! Some rows are written far more often than anyone reads them back at
+//! that resolution. Every file open records a view (the cloud storage UI
+//! opens one per thumbnail it renders), and on SQLite each of those writes
+//! queues behind the single writer lock. A [`WriteBuffer`] keeps such rows
+//! in process memory, keyed by what they describe — a later row for the
+//! same key replaces the pending one — and a background flusher writes
+//! what has accumulated every [`FLUSH_INTERVAL`]. Rapid repeat views of one
+//! file then cost one write per interval instead of one per open.
+//!
+//! This is the queued request-log idea ([`crate::pipeline::RequestLogMode`])
+//! made reusable: rows are plain data, so they can be written later, off
+//! the response path, by whichever context drains them. Native servers run
+//! [`run_flusher`] beside the job worker and call [`shutdown`] before the
+//! runtime stops, so a clean exit loses nothing. A crash loses up to one
+//! interval of rows — only buffer data where that is acceptable (view
+//! tracking, counters), never anything a user or an audit depends on.
+//!
+//! Without a running flusher (Cloudflare, tests) [`WriteBuffer::record`]
+//! writes inline, as if there were no buffer; so does a buffer holding
+//! [`MAX_PENDING`] rows, which keeps memory bounded under a burst. The
+//! database client has no multi-row insert, so a flush issues one `create`
+//! per pending row: the saving is in the rows coalesced away. The flusher
+//! writes as the admin block, so a buffered table's owner grants it write
+//! access.
+//!
+//! A [`CounterBuffer`] does the same for a counter column: increments for
+//! one row are summed while pending, and a flush applies each row's total
+//! as one `increment_field_where`, then stamps the columns of the latest
+//! increment's `set` row (a "last used" time, say).
+
+use std::{
+    collections::{BTreeMap, HashMap},
+    sync::{
+        atomic::{AtomicBool, AtomicU64, Ordering},
+        Mutex, MutexGuard,
+    },
+    time::Duration,
+};
+
+use serde::Serialize;
+use wafer_block::db::{Filter, FilterOp};
+use wafer_core::clients::database as db;
+use wafer_run::context::Context;
+
+/// How often the flusher writes pending rows.
+pub const FLUSH_INTERVAL: Duration = Duration::from_secs(5);
+
+/// Pending rows one buffer holds before further rows are written inline.
+pub const MAX_PENDING: usize = 10_000;
+
+/// One row, as handed to `db::create`.
+pub type Row = HashMap<String, serde_json::Value>;
+
+/// Set while a flusher is running; until then rows are written inline.
+static FLUSHING: AtomicBool = AtomicBool::new(false);
+
+/// Every buffer that has held a row, for the flusher to drain.
+static BUFFERS: Mutex<Vec<&'static WriteBuffer>> = Mutex::new(Vec::new());
+
+/// Every counter buffer that has held an increment.
+static COUNTERS: Mutex<Vec<&'static CounterBuffer>> = Mutex::new(Vec::new());
+
+/// A buffer of pending rows for one table; declare it as a `static`.
+pub struct WriteBuffer {
+    table: &'static str,
+    pending: Mutex<BTreeMap<String, Row>>,
+    registered: AtomicBool,
+    recorded: AtomicU64,
+    coalesced: AtomicU64,
+    written: AtomicU64,
+    dropped: AtomicU64,
+}
+
+/// A buffer's totals since the process started.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+pub struct BufferStats {
+    /// Rows handed to [`WriteBuffer::record`].
+    pub recorded: u64,
+    /// Rows replaced by a later row for the same key before being written.
+    pub coalesced: u64,
+    /// Rows written, inline or by a flush.
+    pub written: u64,
+    /// Rows whose write failed; they are not retried.
+    pub dropped: u64,
+    /// Rows waiting for the next flush.
+    pub pending: usize,
+}
+
+impl WriteBuffer {
+    pub const fn new(table: &'static str) -> Self {
+        Self {
+            table,
+            pending: Mutex::new(BTreeMap::new()),
+            registered: AtomicBool::new(false),
+            recorded: AtomicU64::new(0),
+            coalesced: AtomicU64::new(0),
+            written: AtomicU64::new(0),
+            dropped: AtomicU64::new(0),
+        }
+    }
+
+    pub fn table(&self) -> &'static str {
+        self.table
+    }
+
+    fn lock(&self) -> MutexGuard<'_, BTreeMap<String, Row>> {
+        self.pending.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    /// Queue `row` under `key` for the next flush, replacing a pending row
+    /// with the same key. Hands the row back when the buffer is full.
+    pub fn buffer(&'static self, key: String, row: Row) -> Result<(), Row> {
+        let mut pending = self.lock();
+        if pending.len() >= MAX_PENDING && !pending.contains_key(&key) {
+            return Err(row);
+        }
+        self.recorded.fetch_add(1, Ordering::Relaxed);
+        if pending.insert(key, row).is_some() {
+            self.coalesced.fetch_add(1, Ordering::Relaxed);
+        }
+        drop(pending);
+        if !self.registered.swap(true, Ordering::Relaxed) {
+            BUFFERS.lock().unwrap_or_else(|e| e.into_inner()).push(self);
+        }
+        Ok(())
+    }
+
+    /// Record `row` under `key`: buffered while a flusher runs, else
+    /// written now. Best-effort either way; a failed write is logged.
+    pub async fn record(&'static self, ctx: &dyn Context, key: String, row: Row) {
+        let row = if FLUSHING.load(Ordering::Relaxed) {
+            match self.buffer(key, row) {
+                Ok(()) => return,
+                Err(row) => row,
+            }
+        } else {
+            row
+        };
+        self.recorded.fetch_add(1, Ordering::Relaxed);
+        self.write(ctx, row).await;
+    }
+
+    async fn write(&self, ctx: &dyn Context, row: Row) {
+        match db::create(ctx, self.table, row).await {
+            Ok(_) => {
+                self.written.fetch_add(1, Ordering::Relaxed);
+            }
+            Err(e) => {
+                self.dropped.fetch_add(1, Ordering::Relaxed);
+                tracing::warn!(table = self.table, error = %e, "buffered write failed");
+            }
+        }
+    }
+
+    /// Write every pending row; returns how many were written.
+    pub async fn flush(&self, ctx: &dyn Context) -> u64 {
+        let rows = std::mem::take(&mut *self.lock());
+        let before = self.written.load(Ordering::Relaxed);
+        for row in rows.into_values() {
+            self.write(ctx, row).await;
+        }
+        self.written.load(Ordering::Relaxed) - before
+    }
+
+    pub fn stats(&self) -> BufferStats {
+        BufferStats {
+            recorded: self.recorded.load(Ordering::Relaxed),
+            coalesced: self.coalesced.load(Ordering::Relaxed),
+            written: self.written.load(Ordering::Relaxed),
+            dropped: self.dropped.load(Ordering::Relaxed),
+            pending: self.lock().len(),
+        }
+    }
+}
+
+/// One row's pending increment.
+struct Increment {
+    by: i64,
+    set: Row,
+}
+
+/// Pending increments of one counter column, keyed by row id; declare it
+/// as a `static`.
+pub struct CounterBuffer {
+    table: &'static str,
+    field: &'static str,
+    pending: Mutex<BTreeMap<String, Increment>>,
+    registered: AtomicBool,
+    recorded: AtomicU64,
+    coalesced: AtomicU64,
+    written: AtomicU64,
+    dropped: AtomicU64,
+}
+
+impl CounterBuffer {
+    pub const fn new(table: &'static str, field: &'static str) -> Self {
+        Self {
+            table,
+            field,
+            pending: Mutex::new(BTreeMap::new()),
+            registered: AtomicBool::new(false),
+            recorded: AtomicU64::new(0),
+            coalesced: AtomicU64::new(0),
+            written: AtomicU64::new(0),
+            dropped: AtomicU64::new(0),
+        }
+    }
+
+    pub fn table(&self) -> &'static str {
+        self.table
+    }
+
+    fn lock(&self) -> MutexGuard<'_, BTreeMap<String, Increment>> {
+        self.pending.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    /// Add `by` to row `id`'s pending increment, and replace its pending
+    /// `set` row. Hands the increment back when the buffer is full.
+    pub fn buffer(&'static self, id: String, by: i64, set: Row) -> Result<(), (i64, Row)> {
+        let mut pending = self.lock();
+        if pending.len() >= MAX_PENDING && !pending.contains_key(&id) {
+            return Err((by, set));
+        }
+        self.recorded.fetch_add(1, Ordering::Relaxed);
+        match pending.get_mut(&id) {
+            Some(increment) => {
+                increment.by += by;
+                increment.set = set;
+                self.coalesced.fetch_add(1, Ordering::Relaxed);
+            }
+            None => {
+                pending.insert(id, Increment { by, set });
+            }
+        }
+        drop(pending);
+        if !self.registered.swap(true, Ordering::Relaxed) {
+            COUNTERS
+                .lock()
+                .unwrap_or_else(|e| e.into_inner())
+                .push(self);
+        }
+        Ok(())
+    }
+
+    /// Add `by` to row `id`'s counter and write `set` on it: buffered
+    /// while a flusher runs, else written now. Best-effort either way.
+    pub async fn record(&'static self, ctx: &dyn Context, id: String, by: i64, set: Row) {
+        let (by, set) = if FLUSHING.load(Ordering::Relaxed) {
+            match self.buffer(id.clone(), by, set) {
+                Ok(()) => return,
+                Err(increment) => increment,
+            }
+        } else {
+            (by, set)
+        };
+        self.recorded.fetch_add(1, Ordering::Relaxed);
+        self.write(ctx, &id, Increment { by, set }).await;
+    }
+
+    async fn write(&self, ctx: &dyn Context, id: &str, increment: Increment) {
+        let by_id = [Filter {
+            field: "id".to_string(),
+            operator: FilterOp::Equal,
+            value: serde_json::Value::String(id.to_string()),
+        }];
+        let result = match db::increment_field_where(
+            ctx,
+            self.table,
+            self.field,
+            increment.by,
+            &by_id,
+        )
+        .await
+        {
+            // The row is gone; there is nothing to stamp.
+            Ok(0) => Ok(()),
+            Ok(_) if increment.set.is_empty() => Ok(()),
+            Ok(_) => db::update(ctx, self.table, id, increment.set)
+                .await
+                .map(|_| ()),
+            Err(e) => Err(e),
+        };
+        match result {
+            Ok(()) => {
+                self.written.fetch_add(1, Ordering::Relaxed);
+            }
+            Err(e) => {
+                self.dropped.fetch_add(1, Ordering::Relaxed);
+                tracing::warn!(
+                    table = self.table,
+                    field = self.field,
+                    error = %e,
+                    "buffered increment failed"
+                );
+            }
+        }
+    }
+
+    /// Apply every pending increment; returns how many rows were written.
+    pub async fn flush(&self, ctx: &dyn Context) -> u64 {
+        let increments = std::mem::take(&mut *self.lock());
+        let before = self.written.load(Ordering::Relaxed);
+        for (id, increment) in increments {
+            self.write(ctx, &id, increment).await;
+        }
+        self.written.load(Ordering::Relaxed) - before
+    }
+
+    pub fn stats(&self) -> BufferStats {
+        BufferStats {
+            recorded: self.recorded.load(Ordering::Relaxed),
+            coalesced: self.coalesced.load(Ordering::Relaxed),
+            written: self.written.load(Ordering::Relaxed),
+            dropped: self.dropped.load(Ordering::Relaxed),
+            pending: self.lock().len(),
+        }
+    }
+}
+
+fn buffers() -> Vec<&'static WriteBuffer> {
+    BUFFERS.lock().unwrap_or_else(|e| e.into_inner()).clone()
+}
+
+fn counters() -> Vec<&'static CounterBuffer> {
+    COUNTERS.lock().unwrap_or_else(|e| e.into_inner()).clone()
+}
+
+/// Flush every buffer; returns the rows written.
+pub async fn flush_all(ctx: &dyn Context) -> u64 {
+    let mut written = 0;
+    for buffer in buffers() {
+        written += buffer.flush(ctx).await;
+    }
+    for counter in counters() {
+        written += counter.flush(ctx).await;
+    }
+    written
+}
+
+/// Flush buffers every [`FLUSH_INTERVAL`] until the process exits; never
+/// returns. Runs on the job worker's context, so it waits for the admin
+/// block like [`crate::blocks::jobs::run_worker`]; `sleep` is the platform
+/// timer.
+#[cfg(not(target_arch = "wasm32"))]
+pub async fn run_flusher(sleep: crate::pipeline::RequestTimer) {
+    let Some(ctx) = crate::blocks::jobs::worker_context() else {
+        // Rows keep being written inline.
+        tracing::warn!("write buffer flusher not started: admin block was not initialized");
+        return std::future::pending().await;
+    };
+    FLUSHING.store(true, Ordering::Relaxed);
+    loop {
+        sleep(FLUSH_INTERVAL).await;
+        flush_all(ctx).await;
+    }
+}
+
+/// Stop buffering and write what is pending. Call before the runtime shuts
+/// down; rows recorded afterwards are written inline.
+#[cfg(not(target_arch = "wasm32"))]
+pub async fn shutdown() {
+    FLUSHING.store(false, Ordering::Relaxed);
+    let Some(ctx) = crate::blocks::jobs::worker_context() else {
+        return;
+    };
+    flush_all(ctx).await;
+    for buffer in buffers() {
+        let stats = buffer.stats();
+        tracing::info!(
+            table = buffer.table,
+            recorded = stats.recorded,
+            coalesced = stats.coalesced,
+            written = stats.written,
+            dropped = stats.dropped,
+            "write buffer totals"
+        );
+    }
+    for counter in counters() {
+        let stats = counter.stats();
+        tracing::info!(
+            table = counter.table,
+            field = counter.field,
+            recorded = stats.recorded,
+            coalesced = stats.coalesced,
+            written = stats.written,
+            dropped = stats.dropped,
+            "counter buffer totals"
+        );
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::TestContext;
+
+    const TABLE: &str = "buffered_counts";
+
+    async fn ctx_with_table() -> TestContext {
+        let ctx = TestContext::new().await;
+        db::exec_raw(
+            &ctx,
+            &format!("CREATE TABLE {TABLE} (id TEXT PRIMARY KEY, k TEXT, n INTEGER)"),
+            &[],
+        )
+        .await
+        .unwrap();
+        ctx
+    }
+
+    fn row(k: &str, n: i64) -> Row {
+        crate::util::json_map(serde_json::json!({ "k": k, "n": n }))
+    }
+
+    #[tokio::test]
+    async fn repeated_keys_coalesce_to_the_latest_row() {
+        static BUFFER: WriteBuffer = WriteBuffer::new(TABLE);
+        let ctx = ctx_with_table().await;
+        for n in 1..=3 {
+            BUFFER.buffer("a".into(), row("a", n)).unwrap();
+        }
+        BUFFER.buffer("b".into(), row("b", 1)).unwrap();
+        assert_eq!(BUFFER.flush(&ctx).await, 2);
+
+        let rows = db::list_all(&ctx, TABLE, vec![]).await.unwrap();
+        let a = rows.iter().find(|r| r.data["k"] == "a").unwrap();
+        assert_eq!(a.data["n"], 3);
+        assert_eq!(
+            BUFFER.stats(),
+            BufferStats {
+                recorded: 4,
+                coalesced: 2,
+                written: 2,
+                dropped: 0,
+                pending: 0,
+            }
+        );
+        assert_eq!(BUFFER.flush(&ctx).await, 0);
+    }
+
+    #[tokio::test]
+    async fn a_full_buffer_refuses_new_keys() {
+        static BUFFER: WriteBuffer = WriteBuffer::new(TABLE);
+        for i in 0..MAX_PENDING {
+            BUFFER.buffer(i.to_string(), Row::new()).unwrap();
+        }
+        assert!(BUFFER.buffer("one more".into(), Row::new()).is_err());
+        // An already-pending key still coalesces.
+        assert!(BUFFER.buffer("0".into(), Row::new()).is_ok());
+    }
+
+    #[tokio::test]
+    async fn increments_sum_per_row_and_stamp_the_latest_set() {
+        static COUNTER: CounterBuffer = CounterBuffer::new(TABLE, "n");
+        let ctx = ctx_with_table().await;
+        for k in ["a", "b"] {
+            db::exec_raw(
+                &ctx,
+                &format!("INSERT INTO {TABLE} (id, k, n) VALUES ('{k}', '', 0)"),
+                &[],
+            )
+            .await
+            .unwrap();
+        }
+        for stamp in ["1", "2", "3"] {
+            let set = crate::util::json_map(serde_json::json!({ "k": stamp }));
+            COUNTER.buffer("a".into(), 1, set).unwrap();
+        }
+        COUNTER.buffer("b".into(), 5, Row::new()).unwrap();
+        COUNTER.buffer("gone".into(), 1, Row::new()).unwrap();
+        assert_eq!(COUNTER.flush(&ctx).await, 3);
+
+        let a = db::get(&ctx, TABLE, "a").await.unwrap();
+        assert_eq!(a.data["n"], 3);
+        assert_eq!(a.data["k"], "3");
+        assert_eq!(db::get(&ctx, TABLE, "b").await.unwrap().data["n"], 5);
+        let stats = COUNTER.stats();
+        assert_eq!((stats.recorded, stats.coalesced, stats.pending), (5, 2, 0));
+    }
+
+    #[tokio::test]
+    async fn failed_writes_are_counted_and_dropped() {
+        static BUFFER: WriteBuffer = WriteBuffer::new("no_such_table");
+        let ctx = TestContext::new().await;
+        BUFFER.buffer("a".into(), row("a", 1)).unwrap();
+        assert_eq!(BUFFER.flush(&ctx).await, 0);
+        assert_eq!(BUFFER.stats().dropped, 1);
+        assert_eq!(BUFFER.stats().pending, 0);
+    }
+}
@@ -1,3 +1,4 @@
 //! Integration test crate for the `suppers-ai/admin` block migrations.
 
 mod migrations_002_variables_block;
+mod migrations_004_quotas;
@@ -0,0 +1,71 @@
+//! Apply admin migrations through 004 and drive usage quotas end to end:
+//! definitions stored through `replace_definitions`, counted by `enforce`
+//! (the pipeline hook) on the `OnConflict::WindowedCounter` upsert, and
+//! read back by `usage_for` (the self-service endpoint).
+
+use solobase_core::{
+    blocks::{
+        admin::migrations,
+        api_quota::{self, QuotaDefinition, QuotaOutcome, QuotaScope, EVERY_USER},
+    },
+    test_support::{auth_msg, TestContext},
+};
+
+fn upload_quota(scope: QuotaScope, subject: &str, limit: i64) -> QuotaDefinition {
+    QuotaDefinition {
+        resource: "storage.upload".to_string(),
+        scope,
+        subject: subject.to_string(),
+        limit,
+        window_secs: 3_600,
+    }
+}
+
+#[tokio::test]
+async fn migration_004_quotas_count_tagged_requests_per_user() {
+    let ctx = TestContext::new().await;
+    migrations::apply(&ctx).await.expect("apply migrations");
+    api_quota::replace_definitions(
+        &ctx,
+        &[
+            upload_quota(QuotaScope::User, EVERY_USER, 2),
+            upload_quota(QuotaScope::Role, "pro", 5),
+        ],
+    )
+    .await
+    .expect("store definitions");
+
+    let upload = auth_msg("create", "/b/storage/api/buckets/docs/objects", "u1");
+    for _ in 0..2 {
+        assert!(matches!(
+            api_quota::enforce(&ctx, &upload).await,
+            QuotaOutcome::Counted(_)
+        ));
+    }
+    assert!(matches!(
+        api_quota::enforce(&ctx, &upload).await,
+        QuotaOutcome::Exceeded(_)
+    ));
+
+    // Untagged routes are never counted.
+    let list = auth_msg("retrieve", "/b/storage/api/buckets", "u1");
+    assert!(matches!(
+        api_quota::enforce(&ctx, &list).await,
+        QuotaOutcome::Untracked
+    ));
+
+    let usage = api_quota::usage_for(&ctx, &upload).await.expect("usage");
+    assert_eq!(usage.len(), 1);
+    assert_eq!((usage[0].used, usage[0].remaining), (3, 0));
+
+    // A role definition replaces the default for its holders; the counter
+    // (per user, per resource) is untouched by who else is counted.
+    let mut pro = auth_msg("create", "/b/storage/api/buckets/docs/objects", "u2");
+    pro.set_meta("auth.user_roles", "user,pro");
+    assert!(matches!(
+        api_quota::enforce(&ctx, &pro).await,
+        QuotaOutcome::Counted(_)
+    ));
+    let usage = api_quota::usage_for(&ctx, &pro).await.expect("usage");
+    assert_eq!((usage[0].limit, usage[0].used), (5, 1));
+}
@@ -1,13 +1,14 @@
-//! `bootstrap::run` — covers email+password, token, empty-config, and
-//! already-seeded paths.
+//! `bootstrap::run` — covers email+password, token, empty-config (setup
+//! token), already-seeded, and bootstrap-once paths.
 
 use solobase_core::blocks::auth::{
     bootstrap,
     config::AuthConfig,
     migrations,
-    repo::{bootstrap_tokens, local_credentials, users},
+    repo::{bootstrap_state, bootstrap_tokens, local_credentials, users},
     service::hash_token,
 };
+use wafer_core::clients::database as db;
 
 use crate::common::MigrationTestCtx;
 
@@ -54,6 +55,11 @@ async fn email_password_path_creates_admin_with_local_credentials() {
         creds.password_hash
     );
     assert!(!creds.must_reset);
+    assert!(
+        u.must_change_password,
+        "env-bootstrapped admin must change the env password on first login"
+    );
+    assert!(bootstrap_state::is_completed(&ctx).await.expect("state"));
 }
 
 #[tokio::test]
@@ -81,15 +87,28 @@ async fn token_path_inserts_bootstrap_token_row() {
 }
 
 #[tokio::test]
-async fn no_config_is_noop() {
+async fn no_config_installs_a_single_setup_token() {
     let ctx = MigrationTestCtx::new().await;
     migrations::apply(&ctx).await.expect("migrations");
 
     bootstrap::run(&ctx, &cfg_empty())
         .await
         .expect("bootstrap run");
+    // A second boot before setup replaces the printed token rather than
+    // accumulating live ones.
+    bootstrap::run(&ctx, &cfg_empty())
+        .await
+        .expect("second bootstrap run");
 
     assert_eq!(users::count(&ctx).await.expect("count"), 0);
+    assert_eq!(
+        db::count(&ctx, bootstrap_tokens::TABLE, &[])
+            .await
+            .expect("count tokens"),
+        1,
+        "exactly one live setup token"
+    );
+    assert!(!bootstrap_state::is_completed(&ctx).await.expect("state"));
 }
 
 #[tokio::test]
@@ -122,3 +141,32 @@ async fn skipped_when_users_already_exist() {
     );
     assert_eq!(users::count(&ctx).await.expect("count"), 1);
 }
+
+#[tokio::test]
+async fn bootstrap_once_refuses_to_reseed_an_emptied_users_table() {
+    let ctx = MigrationTestCtx::new().await;
+    migrations::apply(&ctx).await.expect("migrations");
+
+    let mut cfg = cfg_email_pw("root@x.io", "pw");
+    cfg.bootstrap_once = true;
+    bootstrap::run(&ctx, &cfg).await.expect("first run");
+    let u = users::find_by_email(&ctx, "root@x.io")
+        .await
+        .expect("lookup")
+        .expect("admin created");
+
+    db::delete(&ctx, users::TABLE, &u.id)
+        .await
+        .expect("empty users table");
+    bootstrap::run(&ctx, &cfg).await.expect("second run");
+    assert_eq!(
+        users::count(&ctx).await.expect("count"),
+        0,
+        "BOOTSTRAP_ONCE must not re-create the admin"
+    );
+
+    // Without the toggle the legacy behaviour stands: empty table re-seeds.
+    cfg.bootstrap_once = false;
+    bootstrap::run(&ctx, &cfg).await.expect("third run");
+    assert_eq!(users::count(&ctx).await.expect("count"), 1);
+}
@@ -106,8 +106,8 @@ async fn invoke_login(ctx: &MigrationTestCtx, email: &str, password: &str) -> St
 }
 
 /// Run the login handler and consume the output stream regardless of whether
-/// it terminates with `Complete` or `Error` — used by the wrong-password test
-/// which expects an `Unauthenticated` error stream rather than a body.
+/// it terminates with `Complete` or `Error` — used by the wrong-password test,
+/// which gets a 401 `invalid_credentials` body rather than tokens.
 async fn invoke_login_drain(ctx: &MigrationTestCtx, email: &str, password: &str) {
     let block = AuthUiBlock::default();
     let body = json!({"email": email, "password": password}).to_string();
@@ -116,8 +116,8 @@ async fn invoke_login_drain(ctx: &MigrationTestCtx, email: &str, password: &str)
         .handle(ctx, msg, InputStream::from_bytes(body.into_bytes()))
         .await;
     // Discard the result — we only care about the side-effects (or lack
-    // thereof) on the database. An error stream is the expected outcome on
-    // the wrong-password path.
+    // thereof) on the database. An error response is the expected outcome
+    // on the wrong-password path.
     let _ = out.collect_buffered().await;
 }
 
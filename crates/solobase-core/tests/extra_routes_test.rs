@@ -20,12 +20,12 @@ use std::sync::{Arc, Mutex};
 
 use solobase_core::{
     features::FeatureConfig,
-    routing::{self, ExtraRoute, RouteAccess},
+    routing::{self, ExtraRoute, RouteAccess, RouteSource},
 };
 use wafer_block::http_codec;
 use wafer_run::{
-    context::Context, streams::output::TerminalNotResponse, InputStream, Message, OutputStream,
-    META_AUTH_USER_ID, META_REQ_RESOURCE, META_RESP_STATUS,
+    context::Context, streams::output::TerminalNotResponse, AuthLevel, BlockEndpoint, BlockInfo,
+    InputStream, Message, OutputStream, META_AUTH_USER_ID, META_REQ_RESOURCE, META_RESP_STATUS,
 };
 
 // ---------------------------------------------------------------------------
@@ -166,6 +166,7 @@ async fn built_in_route_wins_over_extra_with_same_prefix() {
         prefix: "/b/auth/".into(),
         access: RouteAccess::Public,
         block_name: "gizza-ai/stolen-auth".into(),
+        source: RouteSource::Custom,
     }];
 
     let msg = make_msg("/b/auth/login");
@@ -190,6 +191,7 @@ async fn public_extra_route_dispatches_without_auth() {
         prefix: "/b/chat/".into(),
         access: RouteAccess::Public,
         block_name: "gizza-ai/chat".into(),
+        source: RouteSource::Custom,
     }];
 
     // No user_id set on the message — Public access should allow it through.
@@ -212,6 +214,7 @@ async fn authenticated_extra_route_forbids_empty_user_id() {
         prefix: "/b/chat/".into(),
         access: RouteAccess::Authenticated,
         block_name: "gizza-ai/chat".into(),
+        source: RouteSource::Custom,
     }];
 
     let msg = make_msg("/b/chat/hello"); // no user_id
@@ -235,6 +238,7 @@ async fn authenticated_extra_route_allows_user() {
         prefix: "/b/chat/".into(),
         access: RouteAccess::Authenticated,
         block_name: "gizza-ai/chat".into(),
+        source: RouteSource::Custom,
     }];
 
     let msg = make_msg_with_user("/b/chat/hello", "user-123");
@@ -255,6 +259,7 @@ async fn admin_extra_route_forbids_non_admin() {
         prefix: "/b/gizza-admin/".into(),
         access: RouteAccess::Admin,
         block_name: "gizza-ai/admin".into(),
+        source: RouteSource::Custom,
     }];
 
     // User is authenticated but lacks the admin role.
@@ -276,6 +281,7 @@ async fn admin_extra_route_allows_admin() {
         prefix: "/b/gizza-admin/".into(),
         access: RouteAccess::Admin,
         block_name: "gizza-ai/admin".into(),
+        source: RouteSource::Custom,
     }];
 
     let msg = make_msg_with_admin("/b/gizza-admin/dash", "admin-1");
@@ -306,6 +312,7 @@ async fn anonymous_html_request_on_authenticated_route_redirects_to_login() {
         prefix: "/b/chat/".into(),
         access: RouteAccess::Authenticated,
         block_name: "gizza-ai/chat".into(),
+        source: RouteSource::Custom,
     }];
     let mut msg = make_msg("/b/chat/hello");
     msg.set_meta("http.header.accept", "text/html,application/xhtml+xml");
@@ -327,6 +334,7 @@ async fn authenticated_non_admin_html_request_on_admin_route_still_403s() {
         prefix: "/b/gizza-admin/".into(),
         access: RouteAccess::Admin,
         block_name: "gizza-ai/admin".into(),
+        source: RouteSource::Custom,
     }];
     // Authenticated (user_id set) but lacking the admin role, asking for HTML.
     // The role-failure case is a genuine 403 — it must NOT redirect to login.
@@ -352,6 +360,7 @@ async fn unmatched_path_falls_through_to_not_found() {
         prefix: "/b/chat/".into(),
         access: RouteAccess::Public,
         block_name: "gizza-ai/chat".into(),
+        source: RouteSource::Custom,
     }];
 
     let msg = make_msg("/some/other/path");
@@ -363,6 +372,56 @@ async fn unmatched_path_falls_through_to_not_found() {
     assert!(ctx.calls().is_empty());
 }
 
+/// A public extra route whose block declares one endpoint `Admin`: the
+/// declared level refines the prefix tier for downstream blocks exactly as
+/// it does for built-ins, and undeclared paths keep the prefix tier.
+#[tokio::test]
+async fn extra_route_enforces_declared_endpoint_access() {
+    let infos = vec![
+        BlockInfo::new("gizza-ai/chat", "0.0.1", "http-handler@v1", "chat").endpoints(vec![
+            BlockEndpoint::get("/b/chat/settings").auth(AuthLevel::Admin),
+            BlockEndpoint::get("/b/chat/rooms/{id}").auth(AuthLevel::Authenticated),
+        ]),
+    ];
+    let extras = vec![ExtraRoute {
+        prefix: "/b/chat/".into(),
+        access: RouteAccess::Public,
+        block_name: "gizza-ai/chat".into(),
+        source: RouteSource::Custom,
+    }];
+    let status = |msg: Message| {
+        let (infos, extras) = (&infos, &extras);
+        async move {
+            let ctx = RecordingContext::new();
+            let stream = routing::route_to_block(
+                &ctx,
+                msg,
+                InputStream::empty(),
+                &AllEnabled,
+                infos,
+                extras,
+            )
+            .await;
+            response_status(stream).await
+        }
+    };
+
+    assert_eq!(
+        status(make_msg_with_user("/b/chat/settings", "user-1")).await,
+        403
+    );
+    assert_eq!(
+        status(make_msg_with_admin("/b/chat/settings", "admin-1")).await,
+        200
+    );
+    assert_eq!(status(make_msg("/b/chat/rooms/r1")).await, 403);
+    assert_eq!(
+        status(make_msg_with_user("/b/chat/rooms/r1", "user-1")).await,
+        200
+    );
+    assert_eq!(status(make_msg("/b/chat/hello")).await, 200);
+}
+
 // ---------------------------------------------------------------------------
 // Central per-endpoint AuthLevel enforcement (S4-U).
 //
@@ -374,8 +433,6 @@ async fn unmatched_path_falls_through_to_not_found() {
 // used to rest only on route-table ordering).
 // ---------------------------------------------------------------------------
 
-use wafer_run::{AuthLevel, BlockEndpoint, BlockInfo};
-
 /// A `block_infos` slice declaring the legalpages admin/api endpoints as
 /// `Admin` and the public terms page as `Public` — exactly as the block's
 /// `info()` does.
@@ -0,0 +1,275 @@
+//! ClamAV client: scans a buffer with a running `clamd` over its
+//! `INSTREAM` command.
+//!
+//! Configured from `SOLOBASE_CLAMAV_*` env vars; unset
+//! `SOLOBASE_CLAMAV_ADDRESS` means no scanner. The address is `host:port`
+//! for clamd's TCP socket, or an absolute path for its unix socket.
+//!
+//! Every connection, write and read runs under one deadline, so a hung
+//! daemon costs at most `SOLOBASE_CLAMAV_TIMEOUT_MS` per scan. Buffers
+//! over `SOLOBASE_CLAMAV_MAX_SCAN_BYTES` are refused before connecting —
+//! keep it at or below clamd's own `StreamMaxLength`, or clamd cuts the
+//! stream off and answers with an error instead.
+
+use std::time::Duration;
+
+use anyhow::{anyhow, Result};
+use tokio::io::{AsyncRead, AsyncReadExt, AsyncWrite, AsyncWriteExt};
+
+/// Bytes sent per `INSTREAM` chunk.
+const CHUNK_BYTES: usize = 64 * 1024;
+
+/// Longest clamd reply read back.
+const MAX_REPLY_BYTES: u64 = 4096;
+
+/// How to reach clamd.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ClamAvOptions {
+    /// `host:port`, or an absolute unix-socket path.
+    pub address: String,
+    /// Deadline for one whole scan.
+    pub timeout: Duration,
+    /// Largest buffer sent for scanning.
+    pub max_scan_bytes: u64,
+}
+
+impl ClamAvOptions {
+    /// Read the `SOLOBASE_CLAMAV_*` env vars. `None` when no address is
+    /// set; an error for a malformed number.
+    pub fn from_env() -> Result<Option<Self>> {
+        Self::from_lookup(|key| std::env::var(key).ok())
+    }
+
+    /// [`Self::from_env`] over an arbitrary lookup, so tests don't have to
+    /// mutate the process environment.
+    pub(crate) fn from_lookup(get: impl Fn(&str) -> Option<String>) -> Result<Option<Self>> {
+        let Some(address) = get("SOLOBASE_CLAMAV_ADDRESS").filter(|a| !a.trim().is_empty()) else {
+            return Ok(None);
+        };
+        let num = |key: &str, default: u64| -> Result<u64> {
+            match get(key) {
+                Some(raw) => raw
+                    .trim()
+                    .parse()
+                    .map_err(|_| anyhow!("{key} must be a whole number, got {raw:?}")),
+                None => Ok(default),
+            }
+        };
+        Ok(Some(Self {
+            address: address.trim().to_string(),
+            timeout: Duration::from_millis(num("SOLOBASE_CLAMAV_TIMEOUT_MS", 30_000)?.max(1)),
+            // clamd's default StreamMaxLength.
+            max_scan_bytes: num("SOLOBASE_CLAMAV_MAX_SCAN_BYTES", 25 * 1024 * 1024)?,
+        }))
+    }
+}
+
+/// What clamd made of a buffer.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum ClamAvVerdict {
+    Clean,
+    /// The signature clamd matched, e.g. `Eicar-Test-Signature`.
+    Infected(String),
+}
+
+/// A clamd client. Cheap to clone; each scan opens its own connection.
+#[derive(Debug, Clone)]
+pub struct ClamAvClient {
+    options: ClamAvOptions,
+}
+
+impl ClamAvClient {
+    pub fn new(options: ClamAvOptions) -> Self {
+        Self { options }
+    }
+
+    pub fn options(&self) -> &ClamAvOptions {
+        &self.options
+    }
+
+    /// Scan `data`. An error means clamd gave no verdict: it is
+    /// unreachable, timed out, refused the stream, or `data` is over
+    /// [`ClamAvOptions::max_scan_bytes`].
+    pub async fn scan(&self, data: &[u8]) -> Result<ClamAvVerdict> {
+        if data.len() as u64 > self.options.max_scan_bytes {
+            return Err(anyhow!(
+                "{} bytes is over the {}-byte scan limit",
+                data.len(),
+                self.options.max_scan_bytes
+            ));
+        }
+        tokio::time::timeout(self.options.timeout, self.scan_unbounded(data))
+            .await
+            .map_err(|_| anyhow!("clamd did not answer within {:?}", self.options.timeout))?
+    }
+
+    async fn scan_unbounded(&self, data: &[u8]) -> Result<ClamAvVerdict> {
+        let address = &self.options.address;
+        #[cfg(unix)]
+        if address.starts_with('/') {
+            let stream = tokio::net::UnixStream::connect(address)
+                .await
+                .map_err(|e| anyhow!("connect to clamd at {address}: {e}"))?;
+            return instream(stream, data).await;
+        }
+        let stream = tokio::net::TcpStream::connect(address)
+            .await
+            .map_err(|e| anyhow!("connect to clamd at {address}: {e}"))?;
+        instream(stream, data).await
+    }
+}
+
+/// Run one `INSTREAM` exchange: the command, length-prefixed chunks, a
+/// zero-length terminator, then one NUL-terminated reply.
+async fn instream<S>(mut stream: S, data: &[u8]) -> Result<ClamAvVerdict>
+where
+    S: AsyncRead + AsyncWrite + Unpin,
+{
+    stream.write_all(b"zINSTREAM\0").await?;
+    for chunk in data.chunks(CHUNK_BYTES) {
+        stream
+            .write_all(&(chunk.len() as u32).to_be_bytes())
+            .await?;
+        stream.write_all(chunk).await?;
+    }
+    stream.write_all(&0u32.to_be_bytes()).await?;
+    stream.flush().await?;
+
+    let mut reply = Vec::new();
+    (&mut stream)
+        .take(MAX_REPLY_BYTES)
+        .read_to_end(&mut reply)
+        .await?;
+    parse_reply(&String::from_utf8_lossy(&reply))
+}
+
+/// Interpret clamd's reply to `INSTREAM`: `stream: OK`,
+/// `stream: <signature> FOUND`, or an error line.
+fn parse_reply(reply: &str) -> Result<ClamAvVerdict> {
+    let reply = reply.trim_end_matches(['\0', '\n', '\r']).trim();
+    let result = reply.strip_prefix("stream:").map(str::trim);
+    match result {
+        Some("OK") => Ok(ClamAvVerdict::Clean),
+        Some(found) if found.ends_with(" FOUND") => Ok(ClamAvVerdict::Infected(
+            found.trim_end_matches(" FOUND").trim().to_string(),
+        )),
+        _ => Err(anyhow!("clamd error: {reply}")),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashMap;
+
+    use tokio::net::TcpListener;
+
+    use super::*;
+
+    fn options(vars: &[(&str, &str)]) -> Result<Option<ClamAvOptions>> {
+        let vars: HashMap<String, String> = vars
+            .iter()
+            .map(|(k, v)| ((*k).to_string(), (*v).to_string()))
+            .collect();
+        ClamAvOptions::from_lookup(|k| vars.get(k).cloned())
+    }
+
+    #[test]
+    fn options_from_env() {
+        assert_eq!(options(&[]).unwrap(), None);
+        assert_eq!(options(&[("SOLOBASE_CLAMAV_ADDRESS", " ")]).unwrap(), None);
+
+        let opts = options(&[("SOLOBASE_CLAMAV_ADDRESS", "127.0.0.1:3310")])
+            .unwrap()
+            .unwrap();
+        assert_eq!(opts.timeout, Duration::from_secs(30));
+        assert_eq!(opts.max_scan_bytes, 25 * 1024 * 1024);
+
+        assert!(options(&[
+            ("SOLOBASE_CLAMAV_ADDRESS", "127.0.0.1:3310"),
+            ("SOLOBASE_CLAMAV_TIMEOUT_MS", "soon"),
+        ])
+        .is_err());
+    }
+
+    #[test]
+    fn replies_are_parsed() {
+        assert_eq!(parse_reply("stream: OK\0").unwrap(), ClamAvVerdict::Clean);
+        assert_eq!(
+            parse_reply("stream: Eicar-Test-Signature FOUND\0").unwrap(),
+            ClamAvVerdict::Infected("Eicar-Test-Signature".into())
+        );
+        assert!(parse_reply("INSTREAM size limit exceeded. ERROR\0").is_err());
+        assert!(parse_reply("").is_err());
+    }
+
+    /// A one-shot clamd stand-in: reads one `INSTREAM` request, answers
+    /// `FOUND` when the streamed bytes contain `EICAR`, and hands back the
+    /// bytes it received.
+    async fn fake_clamd() -> (String, tokio::task::JoinHandle<Vec<u8>>) {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let address = listener.local_addr().unwrap().to_string();
+        let server = tokio::spawn(async move {
+            let (mut socket, _) = listener.accept().await.unwrap();
+            let mut command = [0u8; 10];
+            socket.read_exact(&mut command).await.unwrap();
+            assert_eq!(&command, b"zINSTREAM\0");
+            let mut received = Vec::new();
+            loop {
+                let len = socket.read_u32().await.unwrap() as usize;
+                if len == 0 {
+                    break;
+                }
+                let mut chunk = vec![0u8; len];
+                socket.read_exact(&mut chunk).await.unwrap();
+                received.extend_from_slice(&chunk);
+            }
+            let reply: &[u8] = if String::from_utf8_lossy(&received).contains("EICAR") {
+                b"stream: Eicar-Test-Signature FOUND\0"
+            } else {
+                b"stream: OK\0"
+            };
+            socket.write_all(reply).await.unwrap();
+            received
+        });
+        (address, server)
+    }
+
+    fn client(address: String, max_scan_bytes: u64) -> ClamAvClient {
+        ClamAvClient::new(ClamAvOptions {
+            address,
+            timeout: Duration::from_secs(5),
+            max_scan_bytes,
+        })
+    }
+
+    #[tokio::test]
+    async fn streams_the_buffer_in_chunks() {
+        let (address, server) = fake_clamd().await;
+        let data = vec![b'x'; CHUNK_BYTES * 2 + 17];
+        let verdict = client(address, 1 << 20).scan(&data).await.unwrap();
+        assert_eq!(verdict, ClamAvVerdict::Clean);
+        assert_eq!(server.await.unwrap(), data);
+    }
+
+    #[tokio::test]
+    async fn reports_the_matched_signature() {
+        let (address, _server) = fake_clamd().await;
+        let verdict = client(address, 1 << 20).scan(b"xx EICAR xx").await.unwrap();
+        assert_eq!(
+            verdict,
+            ClamAvVerdict::Infected("Eicar-Test-Signature".into())
+        );
+    }
+
+    #[tokio::test]
+    async fn oversized_buffers_and_dead_daemons_are_errors() {
+        let (address, _server) = fake_clamd().await;
+        assert!(client(address, 4).scan(b"too long").await.is_err());
+
+        // Bind then drop, so nothing listens on the port.
+        let unused = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let address = unused.local_addr().unwrap().to_string();
+        drop(unused);
+        assert!(client(address, 1 << 20).scan(b"data").await.is_err());
+    }
+}
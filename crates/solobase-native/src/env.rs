@@ -28,6 +28,21 @@ pub fn load_dotenv(dir: &Path) {
     }
 }
 
+/// Parse the env file [`load_dotenv`] would load from `dir`, without
+/// touching the process environment. Used to pick up edits on a running
+/// process, where the file is the only source an operator can still change.
+/// Returns an empty map when there is no file or it can't be read.
+pub fn read_env_file(dir: &Path) -> HashMap<String, String> {
+    let iter = match std::env::var("SOLOBASE_ENV_FILE") {
+        Ok(path) => dotenvy::from_filename_iter(path),
+        Err(_) => dotenvy::from_path_iter(dir.join(".env")),
+    };
+    match iter {
+        Ok(iter) => iter.filter_map(Result::ok).collect(),
+        Err(_) => HashMap::new(),
+    }
+}
+
 /// Collect env vars that look like app config — i.e. any key containing
 /// `__`. The workspace convention (per CLAUDE.md) is:
 ///
@@ -93,6 +108,28 @@ mod tests {
         assert!(!out.contains_key("HOME"));
     }
 
+    #[test]
+    fn read_env_file_parses_without_touching_process_env() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(
+            dir.path().join(".env"),
+            "SOLOBASE_TEST_READ_ENV_FILE=debug\n# comment\n",
+        )
+        .unwrap();
+        let vars = read_env_file(dir.path());
+        assert_eq!(
+            vars.get("SOLOBASE_TEST_READ_ENV_FILE").map(String::as_str),
+            Some("debug")
+        );
+        assert!(std::env::var("SOLOBASE_TEST_READ_ENV_FILE").is_err());
+    }
+
+    #[test]
+    fn read_env_file_missing_file_is_empty() {
+        let dir = tempfile::tempdir().unwrap();
+        assert!(read_env_file(dir.path()).is_empty());
+    }
+
     #[test]
     fn filter_app_env_vars_empty_iterator_returns_empty_map() {
         let out = filter_app_env_vars(std::iter::empty());
@@ -107,8 +144,31 @@ pub struct InfraConfig {
     pub db_type: String,
     pub db_path: String,
     pub db_url: Option<String>,
+    /// `SOLOBASE_DB_SLOW_QUERY_MS` — database calls at least this slow are
+    /// logged; `0` turns the slow log off.
+    pub db_slow_query_ms: u64,
     pub storage_type: String,
     pub storage_root: String,
+    /// `SOLOBASE_EXTENSIONS_DIR` — directory of declarative extensions
+    /// loaded at startup, one per subdirectory. Unset loads none.
+    pub extensions_dir: Option<String>,
+    /// `SOLOBASE_DEV_MODE` — mount the developer-mode namespace for
+    /// simulating latency and failures (see `solobase_core::dev_mode`).
+    pub dev_mode: bool,
+    /// `SOLOBASE_BASE_PATH` — path prefix Solobase is served under when a
+    /// host app mounts it below the root (see `solobase_core::base_path`).
+    /// Empty serves at the root.
+    pub base_path: String,
+    /// `SOLOBASE_API_VERSION_POLICY` — `versioned-only` serves the API
+    /// only under `/api/v1`; the default `alias` also keeps the deprecated
+    /// `/api` (see `solobase_core::api_version`).
+    pub api_version_policy: String,
+    /// `SOLOBASE_STATUS_FILE` — path of a JSON status file for supervisors
+    /// (see `solobase_core::status`). Unset writes none.
+    pub status_file: Option<String>,
+    /// `SOLOBASE_STATUS_INTERVAL_SECS` — seconds between status file
+    /// rewrites.
+    pub status_interval_secs: u64,
 }
 
 impl InfraConfig {
@@ -118,8 +178,25 @@ impl InfraConfig {
             db_type: env_or("SOLOBASE_DB_TYPE", "sqlite"),
             db_path: env_or("SOLOBASE_DB_PATH", "data/solobase.db"),
             db_url: std::env::var("SOLOBASE_DB_URL").ok(),
+            db_slow_query_ms: env_or("SOLOBASE_DB_SLOW_QUERY_MS", "250")
+                .parse()
+                .unwrap_or(250),
             storage_type: env_or("SOLOBASE_STORAGE_TYPE", "local"),
             storage_root: env_or("SOLOBASE_STORAGE_ROOT", "data/storage"),
+            extensions_dir: std::env::var("SOLOBASE_EXTENSIONS_DIR")
+                .ok()
+                .filter(|d| !d.is_empty()),
+            dev_mode: matches!(env_or("SOLOBASE_DEV_MODE", "").trim(), "true" | "1"),
+            base_path: env_or("SOLOBASE_BASE_PATH", ""),
+            api_version_policy: env_or("SOLOBASE_API_VERSION_POLICY", "alias"),
+            status_file: std::env::var("SOLOBASE_STATUS_FILE")
+                .ok()
+                .filter(|p| !p.is_empty()),
+            status_interval_secs: env_or("SOLOBASE_STATUS_INTERVAL_SECS", "30")
+                .parse()
+                .ok()
+                .filter(|secs| *secs > 0)
+                .unwrap_or(30),
         }
     }
 }
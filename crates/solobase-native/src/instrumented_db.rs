@@ -0,0 +1,299 @@
+//! `InstrumentedDatabaseService` — wraps a `DatabaseService` and records
+//! per-operation latency and error counts.
+//!
+//! Every call is timed and tallied under an `"{op} {table}"` name (raw
+//! SQL is tallied as `query_raw` / `exec_raw`, never by its text, so the
+//! key set stays bounded). Calls slower than the configured threshold are
+//! logged at `warn` as `slow database operation`. [`DbStats::snapshot`]
+//! exposes the running totals; the native server logs them at shutdown.
+//!
+//! `NotFound` is a normal answer to `get` / `update` / `delete`, so it is
+//! not counted as an error.
+
+use std::{
+    collections::HashMap,
+    sync::{Arc, Mutex},
+    time::{Duration, Instant},
+};
+
+use wafer_block::db::{Filter, ListOptions};
+use wafer_core::interfaces::database::service::{
+    Column, DatabaseError, DatabaseService, Record, RecordList, Table,
+};
+
+/// Running totals for one operation name.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct OpStats {
+    pub calls: u64,
+    pub errors: u64,
+    pub total: Duration,
+    pub max: Duration,
+}
+
+/// Per-operation totals shared between an [`InstrumentedDatabaseService`]
+/// and whoever reports on it.
+#[derive(Debug, Default)]
+pub struct DbStats {
+    ops: Mutex<HashMap<String, OpStats>>,
+}
+
+impl DbStats {
+    fn record(&self, name: String, elapsed: Duration, failed: bool) {
+        let mut ops = self.ops.lock().unwrap_or_else(|e| e.into_inner());
+        let entry = ops.entry(name).or_default();
+        entry.calls += 1;
+        entry.errors += u64::from(failed);
+        entry.total += elapsed;
+        entry.max = entry.max.max(elapsed);
+    }
+
+    /// Every operation seen so far, most total time first.
+    pub fn snapshot(&self) -> Vec<(String, OpStats)> {
+        let ops = self.ops.lock().unwrap_or_else(|e| e.into_inner());
+        let mut out: Vec<(String, OpStats)> =
+            ops.iter().map(|(k, v)| (k.clone(), v.clone())).collect();
+        out.sort_by(|a, b| b.1.total.cmp(&a.1.total).then_with(|| a.0.cmp(&b.0)));
+        out
+    }
+}
+
+/// Wraps a [`DatabaseService`], timing every call into a shared [`DbStats`].
+pub struct InstrumentedDatabaseService {
+    inner: Arc<dyn DatabaseService>,
+    stats: Arc<DbStats>,
+    slow_threshold: Option<Duration>,
+}
+
+impl InstrumentedDatabaseService {
+    /// Wrap `inner`. Calls taking at least `slow_threshold` are logged;
+    /// `None` turns the slow log off (totals are still kept).
+    pub fn new(inner: Arc<dyn DatabaseService>, slow_threshold: Option<Duration>) -> Self {
+        Self {
+            inner,
+            stats: Arc::new(DbStats::default()),
+            slow_threshold,
+        }
+    }
+
+    /// The totals this service records into.
+    pub fn stats(&self) -> Arc<DbStats> {
+        Arc::clone(&self.stats)
+    }
+
+    async fn observe<T>(
+        &self,
+        op: &str,
+        table: &str,
+        fut: impl std::future::Future<Output = Result<T, DatabaseError>>,
+    ) -> Result<T, DatabaseError> {
+        let started = Instant::now();
+        let result = fut.await;
+        let elapsed = started.elapsed();
+        let failed = matches!(&result, Err(e) if !matches!(e, DatabaseError::NotFound));
+        if self.slow_threshold.is_some_and(|t| elapsed >= t) {
+            tracing::warn!(
+                op,
+                table,
+                ms = elapsed.as_millis() as u64,
+                failed,
+                "slow database operation"
+            );
+        }
+        let name = if table.is_empty() {
+            op.to_string()
+        } else {
+            format!("{op} {table}")
+        };
+        self.stats.record(name, elapsed, failed);
+        result
+    }
+}
+
+#[async_trait::async_trait]
+impl DatabaseService for InstrumentedDatabaseService {
+    async fn get(&self, collection: &str, id: &str) -> Result<Record, DatabaseError> {
+        self.observe("get", collection, self.inner.get(collection, id))
+            .await
+    }
+
+    async fn list(
+        &self,
+        collection: &str,
+        opts: &ListOptions,
+    ) -> Result<RecordList, DatabaseError> {
+        self.observe("list", collection, self.inner.list(collection, opts))
+            .await
+    }
+
+    async fn create(
+        &self,
+        collection: &str,
+        data: HashMap<String, serde_json::Value>,
+    ) -> Result<Record, DatabaseError> {
+        self.observe("create", collection, self.inner.create(collection, data))
+            .await
+    }
+
+    async fn update(
+        &self,
+        collection: &str,
+        id: &str,
+        data: HashMap<String, serde_json::Value>,
+    ) -> Result<Record, DatabaseError> {
+        self.observe(
+            "update",
+            collection,
+            self.inner.update(collection, id, data),
+        )
+        .await
+    }
+
+    async fn delete(&self, collection: &str, id: &str) -> Result<(), DatabaseError> {
+        self.observe("delete", collection, self.inner.delete(collection, id))
+            .await
+    }
+
+    async fn count(&self, collection: &str, filters: &[Filter]) -> Result<i64, DatabaseError> {
+        self.observe("count", collection, self.inner.count(collection, filters))
+            .await
+    }
+
+    async fn sum(
+        &self,
+        collection: &str,
+        field: &str,
+        filters: &[Filter],
+    ) -> Result<f64, DatabaseError> {
+        self.observe(
+            "sum",
+            collection,
+            self.inner.sum(collection, field, filters),
+        )
+        .await
+    }
+
+    async fn query_raw(
+        &self,
+        query: &str,
+        args: &[serde_json::Value],
+    ) -> Result<Vec<Record>, DatabaseError> {
+        self.observe("query_raw", "", self.inner.query_raw(query, args))
+            .await
+    }
+
+    async fn exec_raw(
+        &self,
+        query: &str,
+        args: &[serde_json::Value],
+    ) -> Result<i64, DatabaseError> {
+        self.observe("exec_raw", "", self.inner.exec_raw(query, args))
+            .await
+    }
+
+    async fn increment_field_where(
+        &self,
+        collection: &str,
+        col: &str,
+        delta: i64,
+        filters: &[Filter],
+    ) -> Result<i64, DatabaseError> {
+        // MUST override — trait default returns Err(Internal).
+        self.observe(
+            "increment_field_where",
+            collection,
+            self.inner
+                .increment_field_where(collection, col, delta, filters),
+        )
+        .await
+    }
+
+    async fn delete_where(
+        &self,
+        collection: &str,
+        filters: &[Filter],
+    ) -> Result<(), DatabaseError> {
+        self.observe(
+            "delete_where",
+            collection,
+            self.inner.delete_where(collection, filters),
+        )
+        .await
+    }
+
+    async fn update_where(
+        &self,
+        collection: &str,
+        filters: &[Filter],
+        data: HashMap<String, serde_json::Value>,
+    ) -> Result<(), DatabaseError> {
+        self.observe(
+            "update_where",
+            collection,
+            self.inner.update_where(collection, filters, data),
+        )
+        .await
+    }
+
+    // Schema calls run at boot/migration time only; pass them straight
+    // through rather than filling the totals with one-off entries.
+    async fn ensure_schema_table(&self, table: &Table) -> Result<(), DatabaseError> {
+        self.inner.ensure_schema_table(table).await
+    }
+
+    async fn schema_table_exists(&self, name: &str) -> Result<bool, DatabaseError> {
+        self.inner.schema_table_exists(name).await
+    }
+
+    async fn schema_drop_table(&self, name: &str) -> Result<(), DatabaseError> {
+        self.inner.schema_drop_table(name).await
+    }
+
+    async fn schema_add_column(&self, table: &str, column: &Column) -> Result<(), DatabaseError> {
+        self.inner.schema_add_column(table, column).await
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn instrumented() -> InstrumentedDatabaseService {
+        let inner = wafer_block_sqlite::service::SQLiteDatabaseService::open_in_memory()
+            .expect("open in-memory sqlite");
+        InstrumentedDatabaseService::new(Arc::new(inner), None)
+    }
+
+    #[tokio::test]
+    async fn records_calls_and_errors_per_operation() {
+        let db = instrumented();
+        db.exec_raw("CREATE TABLE t (id TEXT PRIMARY KEY, n INTEGER)", &[])
+            .await
+            .unwrap();
+        let mut data = HashMap::new();
+        data.insert("n".to_string(), serde_json::json!(1));
+        let row = db.create("t", data).await.unwrap();
+        db.get("t", &row.id).await.unwrap();
+        // Missing rows are an answer, not a failure.
+        assert!(db.get("t", "missing").await.is_err());
+        assert!(db.query_raw("SELECT * FROM nope", &[]).await.is_err());
+
+        let stats: HashMap<String, OpStats> = db.stats().snapshot().into_iter().collect();
+        assert_eq!(stats["create t"].calls, 1);
+        assert_eq!(stats["get t"].calls, 2);
+        assert_eq!(stats["get t"].errors, 0);
+        assert_eq!(stats["exec_raw"].calls, 1);
+        assert_eq!(stats["query_raw"].errors, 1);
+        assert!(stats["get t"].max <= stats["get t"].total);
+    }
+
+    #[test]
+    fn snapshot_orders_by_total_time() {
+        let stats = DbStats::default();
+        stats.record("list a".into(), Duration::from_millis(5), false);
+        stats.record("get b".into(), Duration::from_millis(20), false);
+        stats.record("list a".into(), Duration::from_millis(5), true);
+        let names: Vec<String> = stats.snapshot().into_iter().map(|(k, _)| k).collect();
+        assert_eq!(names, ["get b", "list a"]);
+        assert_eq!(stats.snapshot()[1].1.errors, 1);
+    }
+}
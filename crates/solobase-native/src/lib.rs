@@ -8,26 +8,32 @@
 //! schema work (reading/seeding `variables` tables, per-block config
 //! JSON, SolobaseBuilder composition) lives in the consumer's binary.
 
+pub mod clamav;
 pub mod crypto;
 pub mod database;
 pub mod env;
 pub mod hooks;
+pub mod instrumented_db;
 pub mod log_init;
+pub mod log_sinks;
 pub mod logger;
 pub mod network;
 pub mod serve;
 pub mod storage;
 
+pub use clamav::{ClamAvClient, ClamAvOptions, ClamAvVerdict};
 pub use crypto::make_jwt_crypto_service;
 #[cfg(feature = "postgres")]
 pub use database::make_postgres_database_service;
 pub use database::{make_database_service, make_sqlite_database_service};
-pub use env::{collect_app_env_vars, load_dotenv, InfraConfig};
+pub use env::{collect_app_env_vars, load_dotenv, read_env_file, InfraConfig};
 pub use hooks::register_observability_hooks;
-pub use log_init::init_tracing;
+pub use instrumented_db::{DbStats, InstrumentedDatabaseService};
+pub use log_init::{init_tracing, set_log_filter};
+pub use log_sinks::{LogSinkConfig, LogSinks, SinkStats};
 pub use logger::make_tracing_logger;
 pub use network::make_fetch_network_service;
-pub use serve::{register_http_listener, serve_until_shutdown};
+pub use serve::{register_http_listener, serve_until_shutdown, spawn_reload_on_sighup};
 pub use storage::{make_local_storage_service, make_storage_service};
 #[cfg(feature = "s3")]
 pub use storage::{make_s3_storage_service, S3Config};
@@ -3,53 +3,120 @@
 //! Called once at startup to install a tracing subscriber. Supports
 //! `text` and `json` formats. OpenTelemetry OTLP export is enabled by
 //! the `otel` feature and auto-activates when
-//! `OTEL_EXPORTER_OTLP_ENDPOINT` is set.
+//! `OTEL_EXPORTER_OTLP_ENDPOINT` is set. The level filter sits behind a
+//! reload handle so [`set_log_filter`] can change it on a running process.
+//! Besides (or instead of) stdout, events go to the application-log sinks
+//! in [`LogSinks`] as JSON lines.
+
+use std::sync::OnceLock;
 
 #[cfg(feature = "otel")]
 use anyhow::Context;
 use anyhow::Result;
-use tracing_subscriber::{fmt, EnvFilter};
+use tracing_subscriber::{
+    filter::filter_fn, fmt, layer::SubscriberExt, registry::LookupSpan, reload,
+    util::SubscriberInitExt, EnvFilter, Layer, Registry,
+};
+
+use crate::log_sinks::LogSinks;
+
+/// Filter used when `RUST_LOG` is unset.
+const DEFAULT_FILTER: &str = "info,wafer=debug,solobase=debug";
+
+static FILTER_HANDLE: OnceLock<reload::Handle<EnvFilter, Registry>> = OnceLock::new();
+
+/// The boot filter: `RUST_LOG` when set and valid, else [`DEFAULT_FILTER`].
+fn boot_filter() -> EnvFilter {
+    EnvFilter::try_from_default_env().unwrap_or_else(|_| EnvFilter::new(DEFAULT_FILTER))
+}
 
-/// Install a `tracing` subscriber for the running process.
+/// The stdout layer (when `sinks` keeps stdout) plus a JSON layer feeding
+/// the application-log sinks (when there are any).
+fn output_layers<S>(log_format: &str, sinks: &LogSinks) -> Vec<Box<dyn Layer<S> + Send + Sync>>
+where
+    S: tracing::Subscriber + for<'a> LookupSpan<'a>,
+{
+    let mut layers = Vec::new();
+    if sinks.app_stdout() {
+        layers.push(if log_format == "json" {
+            fmt::layer()
+                .json()
+                .with_target(true)
+                .with_thread_ids(false)
+                .boxed()
+        } else {
+            fmt::layer()
+                .with_target(true)
+                .with_thread_ids(false)
+                .boxed()
+        });
+    }
+    if let Some(writer) = sinks.app_writer() {
+        layers.push(
+            fmt::layer()
+                .json()
+                .with_ansi(false)
+                .with_target(true)
+                .with_writer(writer)
+                .with_filter(filter_fn(|meta| LogSinks::forwards_target(meta.target())))
+                .boxed(),
+        );
+    }
+    layers
+}
+
+/// Install a `tracing` subscriber for the running process, writing to
+/// stdout and/or the application-log sinks selected in `sinks`.
 ///
 /// # Errors
 ///
 /// Returns an error if the optional OTLP exporter (enabled via the `otel`
 /// feature + `OTEL_EXPORTER_OTLP_ENDPOINT`) fails to construct. Plain
 /// text/JSON subscriber initialisation is infallible.
-pub fn init_tracing(log_format: &str) -> Result<()> {
-    let filter = EnvFilter::try_from_default_env()
-        .unwrap_or_else(|_| EnvFilter::new("info,wafer=debug,solobase=debug"));
+pub fn init_tracing(log_format: &str, sinks: &LogSinks) -> Result<()> {
+    let (filter, handle) = reload::Layer::new(boot_filter());
+    let _ = FILTER_HANDLE.set(handle);
 
     #[cfg(feature = "otel")]
     {
         if std::env::var("OTEL_EXPORTER_OTLP_ENDPOINT").is_ok() {
-            init_tracing_with_otel(log_format, filter)?;
+            init_tracing_with_otel(log_format, sinks, filter)?;
             return Ok(());
         }
     }
 
-    if log_format == "json" {
-        fmt()
-            .json()
-            .with_env_filter(filter)
-            .with_target(true)
-            .with_thread_ids(false)
-            .init();
-    } else {
-        fmt()
-            .with_env_filter(filter)
-            .with_target(true)
-            .with_thread_ids(false)
-            .init();
-    }
+    tracing_subscriber::registry()
+        .with(filter)
+        .with(output_layers(log_format, sinks))
+        .init();
     Ok(())
 }
 
+/// Replace the live level filter with `directives` (`RUST_LOG` syntax), or
+/// restore the boot filter when `None`.
+///
+/// # Errors
+///
+/// Returns the parse error for malformed directives, or an error when
+/// [`init_tracing`] hasn't run. The current filter is left in place.
+pub fn set_log_filter(directives: Option<&str>) -> std::result::Result<(), String> {
+    let handle = FILTER_HANDLE
+        .get()
+        .ok_or_else(|| "tracing is not initialised".to_string())?;
+    let filter = match directives {
+        Some(d) => EnvFilter::try_new(d).map_err(|e| e.to_string())?,
+        None => boot_filter(),
+    };
+    handle.reload(filter).map_err(|e| e.to_string())
+}
+
 #[cfg(feature = "otel")]
-fn init_tracing_with_otel(log_format: &str, filter: EnvFilter) -> Result<()> {
+fn init_tracing_with_otel(
+    log_format: &str,
+    sinks: &LogSinks,
+    filter: reload::Layer<EnvFilter, Registry>,
+) -> Result<()> {
     use opentelemetry::trace::TracerProvider;
-    use tracing_subscriber::{layer::SubscriberExt, util::SubscriberInitExt, Layer};
 
     let exporter = opentelemetry_otlp::SpanExporter::builder()
         .with_tonic()
@@ -67,15 +134,9 @@ fn init_tracing_with_otel(log_format: &str, filter: EnvFilter) -> Result<()> {
     let tracer = provider.tracer("solobase");
     let otel_layer = tracing_opentelemetry::layer().with_tracer(tracer);
 
-    let fmt_layer: Box<dyn Layer<_> + Send + Sync> = if log_format == "json" {
-        Box::new(fmt::layer().json().with_target(true).with_thread_ids(false))
-    } else {
-        Box::new(fmt::layer().with_target(true).with_thread_ids(false))
-    };
-
     tracing_subscriber::registry()
         .with(filter)
-        .with(fmt_layer)
+        .with(output_layers(log_format, sinks))
         .with(otel_layer)
         .init();
 
@@ -0,0 +1,973 @@
+//! External log sinks: ship application logs and request logs to a
+//! rotating file, a syslog collector or an HTTP endpoint.
+//!
+//! Two streams are routed independently:
+//!
+//! - application logs (`tracing` events) — `SOLOBASE_LOG_SINKS`, default
+//!   `stdout`;
+//! - request logs (one row per HTTP request) — `SOLOBASE_REQUEST_LOG_SINKS`,
+//!   default `database` (the `request_logs` table).
+//!
+//! Each is a comma-separated list of `stdout`, `database` (request logs
+//! only), `file`, `syslog` and `http`, so "database + file" or "http only"
+//! are both one env var. Every entry is a single JSON object.
+//!
+//! Each selected sink owns a bounded queue drained by its own worker.
+//! Producers only ever `try_send`: when a sink is slow or down its queue
+//! fills and further entries are dropped and counted ([`SinkStats`]), so
+//! logging never blocks request handling.
+
+use std::{
+    collections::HashMap,
+    fs::{self, File, OpenOptions},
+    io::{self, BufWriter, Write},
+    net::{TcpStream, UdpSocket},
+    path::{Path, PathBuf},
+    sync::{
+        atomic::{AtomicU64, Ordering},
+        Arc,
+    },
+    time::{Duration, Instant},
+};
+
+use anyhow::{anyhow, bail, Result};
+use flate2::{write::GzEncoder, Compression};
+use tokio::sync::mpsc;
+use tracing_subscriber::fmt::MakeWriter;
+
+/// Targets never forwarded to a sink: the HTTP sink's own client stack
+/// would otherwise log about shipping its logs, forever.
+const QUIET_TARGETS: &[&str] = &["hyper", "h2", "reqwest", "rustls", "tokio_util"];
+
+/// Syslog facility for every message (`local0`).
+const SYSLOG_FACILITY: u8 = 16;
+
+/// HTTP delivery attempts per batch before it is dropped.
+const HTTP_ATTEMPTS: u32 = 4;
+
+/// A sink destination, as named in `SOLOBASE_LOG_SINKS` /
+/// `SOLOBASE_REQUEST_LOG_SINKS`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum SinkKind {
+    Stdout,
+    Database,
+    File,
+    Syslog,
+    Http,
+}
+
+impl SinkKind {
+    fn parse(name: &str) -> Option<Self> {
+        match name {
+            "stdout" => Some(Self::Stdout),
+            "database" | "db" => Some(Self::Database),
+            "file" => Some(Self::File),
+            "syslog" => Some(Self::Syslog),
+            "http" => Some(Self::Http),
+            _ => None,
+        }
+    }
+
+    fn as_str(self) -> &'static str {
+        match self {
+            Self::Stdout => "stdout",
+            Self::Database => "database",
+            Self::File => "file",
+            Self::Syslog => "syslog",
+            Self::Http => "http",
+        }
+    }
+}
+
+/// Sink selection and settings, read from `SOLOBASE_LOG_*` env vars.
+#[derive(Debug, Clone)]
+pub struct LogSinkConfig {
+    /// `SOLOBASE_LOG_SINKS` — application log sinks (default `stdout`).
+    pub app: Vec<SinkKind>,
+    /// `SOLOBASE_REQUEST_LOG_SINKS` — request log sinks (default `database`).
+    pub request: Vec<SinkKind>,
+    /// `SOLOBASE_LOG_FILE` — application log file.
+    pub app_file: PathBuf,
+    /// `SOLOBASE_REQUEST_LOG_FILE` — request log file.
+    pub request_file: PathBuf,
+    /// `SOLOBASE_LOG_FILE_MAX_BYTES` — rotate once a file reaches this size.
+    pub file_max_bytes: u64,
+    /// `SOLOBASE_LOG_FILE_MAX_AGE_HOURS` — rotate once a file is this old.
+    pub file_max_age: Duration,
+    /// `SOLOBASE_LOG_FILE_KEEP` — compressed rotations kept per file.
+    pub file_keep: usize,
+    /// `SOLOBASE_LOG_SYSLOG_ADDR` — `udp://host:port` or `tcp://host:port`.
+    pub syslog_addr: Option<String>,
+    /// `SOLOBASE_LOG_HTTP_URL` — NDJSON batches are POSTed here.
+    pub http_url: Option<String>,
+    /// `SOLOBASE_LOG_HTTP_BATCH` — most entries per POST.
+    pub http_batch: usize,
+    /// `SOLOBASE_LOG_HTTP_FLUSH_MS` — longest an entry waits for a batch.
+    pub http_flush: Duration,
+    /// `SOLOBASE_LOG_QUEUE_SIZE` — per-sink queue bound.
+    pub queue_size: usize,
+}
+
+impl LogSinkConfig {
+    /// # Errors
+    ///
+    /// Returns an error for an unknown sink name, `database` in the
+    /// application sinks, or a `syslog` / `http` sink without its address.
+    pub fn from_env() -> Result<Self> {
+        Self::from_lookup(|key| std::env::var(key).ok())
+    }
+
+    /// [`Self::from_env`] over an arbitrary lookup, so tests don't have to
+    /// mutate the process environment.
+    pub(crate) fn from_lookup(get: impl Fn(&str) -> Option<String>) -> Result<Self> {
+        let var = |key: &str, default: &str| get(key).unwrap_or_else(|| default.to_string());
+        let num = |key: &str, default: u64| -> Result<u64> {
+            match get(key) {
+                Some(raw) => raw
+                    .trim()
+                    .parse()
+                    .map_err(|_| anyhow!("{key} must be a whole number, got {raw:?}")),
+                None => Ok(default),
+            }
+        };
+        let cfg = Self {
+            app: parse_kinds("SOLOBASE_LOG_SINKS", &var("SOLOBASE_LOG_SINKS", "stdout"))?,
+            request: parse_kinds(
+                "SOLOBASE_REQUEST_LOG_SINKS",
+                &var("SOLOBASE_REQUEST_LOG_SINKS", "database"),
+            )?,
+            app_file: var("SOLOBASE_LOG_FILE", "data/logs/solobase.log").into(),
+            request_file: var("SOLOBASE_REQUEST_LOG_FILE", "data/logs/requests.log").into(),
+            file_max_bytes: num("SOLOBASE_LOG_FILE_MAX_BYTES", 100 * 1024 * 1024)?,
+            file_max_age: Duration::from_secs(num("SOLOBASE_LOG_FILE_MAX_AGE_HOURS", 24)? * 3600),
+            file_keep: num("SOLOBASE_LOG_FILE_KEEP", 7)? as usize,
+            syslog_addr: get("SOLOBASE_LOG_SYSLOG_ADDR").filter(|s| !s.is_empty()),
+            http_url: get("SOLOBASE_LOG_HTTP_URL").filter(|s| !s.is_empty()),
+            http_batch: num("SOLOBASE_LOG_HTTP_BATCH", 500)?.max(1) as usize,
+            http_flush: Duration::from_millis(num("SOLOBASE_LOG_HTTP_FLUSH_MS", 1000)?.max(10)),
+            queue_size: num("SOLOBASE_LOG_QUEUE_SIZE", 10_000)?.max(1) as usize,
+        };
+        if cfg.app.contains(&SinkKind::Database) {
+            bail!("SOLOBASE_LOG_SINKS: application logs have no database sink");
+        }
+        let all = cfg.app.iter().chain(&cfg.request);
+        let kinds: Vec<SinkKind> = all.copied().collect();
+        if kinds.contains(&SinkKind::Syslog) && cfg.syslog_addr.is_none() {
+            bail!("the syslog log sink needs SOLOBASE_LOG_SYSLOG_ADDR");
+        }
+        if kinds.contains(&SinkKind::Http) && cfg.http_url.is_none() {
+            bail!("the http log sink needs SOLOBASE_LOG_HTTP_URL");
+        }
+        Ok(cfg)
+    }
+}
+
+/// Parse a comma-separated sink list. An empty list (`""` or `"none"`)
+/// discards the stream.
+fn parse_kinds(key: &str, raw: &str) -> Result<Vec<SinkKind>> {
+    let mut kinds = Vec::new();
+    for name in raw.split(',').map(str::trim).filter(|s| !s.is_empty()) {
+        if name == "none" {
+            continue;
+        }
+        let kind =
+            SinkKind::parse(name).ok_or_else(|| anyhow!("{key}: unknown log sink {name:?}"))?;
+        if !kinds.contains(&kind) {
+            kinds.push(kind);
+        }
+    }
+    Ok(kinds)
+}
+
+/// Syslog severity of an entry.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Severity {
+    Error = 3,
+    Warning = 4,
+    Info = 6,
+    Debug = 7,
+}
+
+impl Severity {
+    fn from_level(level: &tracing::Level) -> Self {
+        match *level {
+            tracing::Level::ERROR => Self::Error,
+            tracing::Level::WARN => Self::Warning,
+            tracing::Level::INFO => Self::Info,
+            _ => Self::Debug,
+        }
+    }
+
+    /// Request logs: 5xx are errors, 4xx warnings.
+    fn from_status(status: i64) -> Self {
+        match status {
+            500.. => Self::Error,
+            400..=499 => Self::Warning,
+            _ => Self::Info,
+        }
+    }
+}
+
+/// One log entry: a single JSON object, without a trailing newline.
+#[derive(Debug, Clone)]
+struct Entry {
+    severity: Severity,
+    line: Vec<u8>,
+}
+
+/// Queue depth and losses for one sink.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SinkStats {
+    /// `"{stream}:{sink}"`, e.g. `request:http`.
+    pub name: String,
+    /// Entries accepted but not yet written (or given up on).
+    pub pending: u64,
+    /// Entries dropped: queue full, or delivery failed.
+    pub dropped: u64,
+}
+
+/// The producer side of one sink.
+struct SinkQueue {
+    name: String,
+    tx: mpsc::Sender<Entry>,
+    pending: AtomicU64,
+    dropped: AtomicU64,
+}
+
+impl SinkQueue {
+    fn new(name: String, size: usize) -> (Arc<Self>, mpsc::Receiver<Entry>) {
+        let (tx, rx) = mpsc::channel(size);
+        let queue = Arc::new(Self {
+            name,
+            tx,
+            pending: AtomicU64::new(0),
+            dropped: AtomicU64::new(0),
+        });
+        (queue, rx)
+    }
+
+    /// Enqueue without ever waiting; a full or closed queue drops `entry`.
+    fn push(&self, entry: Entry) {
+        self.pending.fetch_add(1, Ordering::Relaxed);
+        if self.tx.try_send(entry).is_err() {
+            self.pending.fetch_sub(1, Ordering::Relaxed);
+            self.dropped.fetch_add(1, Ordering::Relaxed);
+        }
+    }
+
+    /// The worker finished with `n` entries; `failed` of them were lost.
+    fn done(&self, n: usize, failed: usize) {
+        self.pending.fetch_sub(n as u64, Ordering::Relaxed);
+        self.dropped.fetch_add(failed as u64, Ordering::Relaxed);
+    }
+
+    fn stats(&self) -> SinkStats {
+        SinkStats {
+            name: self.name.clone(),
+            pending: self.pending.load(Ordering::Relaxed),
+            dropped: self.dropped.load(Ordering::Relaxed),
+        }
+    }
+}
+
+/// Reports a worker's failures on stderr — never through `tracing`, which
+/// may be feeding this very sink. Only the first failure after a success
+/// is reported, so a dead collector doesn't flood stderr.
+struct Health {
+    name: String,
+    healthy: bool,
+}
+
+impl Health {
+    fn new(name: &str) -> Self {
+        Self {
+            name: name.to_string(),
+            healthy: true,
+        }
+    }
+
+    fn ok(&mut self) {
+        if !self.healthy {
+            eprintln!("log sink {}: recovered", self.name);
+            self.healthy = true;
+        }
+    }
+
+    fn failed(&mut self, err: &dyn std::fmt::Display) {
+        if self.healthy {
+            eprintln!(
+                "log sink {}: {err}; dropping entries until it recovers",
+                self.name
+            );
+            self.healthy = false;
+        }
+    }
+}
+
+/// The running sinks for both streams.
+pub struct LogSinks {
+    app_stdout: bool,
+    app: Arc<[Arc<SinkQueue>]>,
+    request: Vec<Arc<SinkQueue>>,
+    request_database: bool,
+}
+
+impl LogSinks {
+    /// Start a worker per selected sink. Must run inside a tokio runtime
+    /// (the HTTP sink is async; the others run on their own threads).
+    ///
+    /// # Errors
+    ///
+    /// Returns an error when a file sink's directory can't be created, the
+    /// syslog address doesn't parse, or the HTTP client can't be built.
+    pub fn start(cfg: &LogSinkConfig) -> Result<Self> {
+        let mut app = Vec::new();
+        for &kind in &cfg.app {
+            if kind != SinkKind::Stdout {
+                app.push(spawn_sink(cfg, "app", kind, &cfg.app_file)?);
+            }
+        }
+        let mut request = Vec::new();
+        for &kind in &cfg.request {
+            if kind != SinkKind::Database {
+                request.push(spawn_sink(cfg, "request", kind, &cfg.request_file)?);
+            }
+        }
+        Ok(Self {
+            app_stdout: cfg.app.contains(&SinkKind::Stdout),
+            app: app.into(),
+            request,
+            request_database: cfg.request.contains(&SinkKind::Database),
+        })
+    }
+
+    /// Only stdout, the request logs in the database: the behaviour with no
+    /// sinks configured.
+    pub fn stdout_only() -> Self {
+        Self {
+            app_stdout: true,
+            app: Arc::new([]),
+            request: Vec::new(),
+            request_database: true,
+        }
+    }
+
+    /// Whether application logs still go to stdout.
+    pub fn app_stdout(&self) -> bool {
+        self.app_stdout
+    }
+
+    /// A `tracing` writer feeding the application-log sinks, or `None` when
+    /// there are none besides stdout. Install it with a JSON fmt layer.
+    pub fn app_writer(&self) -> Option<SinkWriter> {
+        (!self.app.is_empty()).then(|| SinkWriter {
+            queues: Arc::clone(&self.app),
+        })
+    }
+
+    /// Whether `target` may be forwarded to the application-log sinks.
+    pub fn forwards_target(target: &str) -> bool {
+        !QUIET_TARGETS
+            .iter()
+            .any(|t| target == *t || target.starts_with(&format!("{t}::")))
+    }
+
+    /// Whether request logs are still written to the `request_logs` table.
+    pub fn request_database(&self) -> bool {
+        self.request_database
+    }
+
+    /// Whether any request-log sink besides the database is selected.
+    pub fn has_request_sinks(&self) -> bool {
+        !self.request.is_empty()
+    }
+
+    /// Hand one request-log row to every request-log sink. Never blocks.
+    pub fn forward_request(&self, row: &HashMap<String, serde_json::Value>) {
+        if self.request.is_empty() {
+            return;
+        }
+        let Ok(line) = serde_json::to_vec(row) else {
+            return;
+        };
+        let status = row
+            .get("status_code")
+            .and_then(serde_json::Value::as_i64)
+            .unwrap_or(0);
+        let entry = Entry {
+            severity: Severity::from_status(status),
+            line,
+        };
+        for queue in &self.request {
+            queue.push(entry.clone());
+        }
+    }
+
+    /// Depth and losses per sink, application sinks first.
+    pub fn stats(&self) -> Vec<SinkStats> {
+        self.app
+            .iter()
+            .chain(&self.request)
+            .map(|q| q.stats())
+            .collect()
+    }
+
+    /// Wait up to `timeout` for every queued entry to be written. Used at
+    /// shutdown; returns whether everything drained in time.
+    pub async fn flush(&self, timeout: Duration) -> bool {
+        let deadline = Instant::now() + timeout;
+        loop {
+            if self.stats().iter().all(|s| s.pending == 0) {
+                return true;
+            }
+            if Instant::now() >= deadline {
+                return false;
+            }
+            tokio::time::sleep(Duration::from_millis(20)).await;
+        }
+    }
+}
+
+fn spawn_sink(
+    cfg: &LogSinkConfig,
+    stream: &str,
+    kind: SinkKind,
+    file: &Path,
+) -> Result<Arc<SinkQueue>> {
+    let name = format!("{stream}:{}", kind.as_str());
+    let (queue, rx) = SinkQueue::new(name.clone(), cfg.queue_size);
+    let worker = Arc::clone(&queue);
+    match kind {
+        SinkKind::Stdout => {
+            spawn_thread(&name, move || run_lines(&worker, rx, io::stdout()))?;
+        }
+        SinkKind::File => {
+            let mut out =
+                RotatingFile::new(file, cfg.file_max_bytes, cfg.file_max_age, cfg.file_keep);
+            out.open()
+                .map_err(|e| anyhow!("open log file {}: {e}", file.display()))?;
+            spawn_thread(&name, move || run_lines(&worker, rx, out))?;
+        }
+        SinkKind::Syslog => {
+            let addr = cfg.syslog_addr.as_deref().unwrap_or_default();
+            let out = Syslog::new(addr, stream)?;
+            spawn_thread(&name, move || run_syslog(&worker, rx, out))?;
+        }
+        SinkKind::Http => {
+            let client = reqwest::Client::builder()
+                .timeout(Duration::from_secs(10))
+                .build()
+                .map_err(|e| anyhow!("build log sink HTTP client: {e}"))?;
+            let http = HttpSink {
+                client,
+                url: cfg.http_url.clone().unwrap_or_default(),
+                batch: cfg.http_batch,
+                flush: cfg.http_flush,
+            };
+            tokio::spawn(async move { http.run(&worker, rx).await });
+        }
+        SinkKind::Database => unreachable!("the database sink has no worker"),
+    }
+    Ok(queue)
+}
+
+fn spawn_thread(name: &str, f: impl FnOnce() + Send + 'static) -> Result<()> {
+    std::thread::Builder::new()
+        .name(format!("log-sink-{name}"))
+        .spawn(f)
+        .map(drop)
+        .map_err(|e| anyhow!("spawn log sink {name}: {e}"))
+}
+
+/// Drain `rx` into `out` as newline-delimited JSON, flushing after each
+/// burst. A write error drops the burst and is reported once.
+fn run_lines(queue: &SinkQueue, mut rx: mpsc::Receiver<Entry>, mut out: impl Write) {
+    let mut health = Health::new(&queue.name);
+    while let Some(first) = rx.blocking_recv() {
+        let mut burst = vec![first];
+        while let Ok(e) = rx.try_recv() {
+            burst.push(e);
+        }
+        let written = burst
+            .iter()
+            .try_for_each(|e| {
+                out.write_all(&e.line)?;
+                out.write_all(b"\n")
+            })
+            .and_then(|()| out.flush());
+        match written {
+            Ok(()) => {
+                health.ok();
+                queue.done(burst.len(), 0);
+            }
+            Err(e) => {
+                health.failed(&e);
+                queue.done(burst.len(), burst.len());
+            }
+        }
+    }
+}
+
+/// A log file rotated by size and age. Rotated files are gzipped next to
+/// it as `{name}.{utc timestamp}.gz`; only the newest `keep` are kept.
+struct RotatingFile {
+    path: PathBuf,
+    max_bytes: u64,
+    max_age: Duration,
+    keep: usize,
+    file: Option<BufWriter<File>>,
+    size: u64,
+    opened: Instant,
+}
+
+impl RotatingFile {
+    fn new(path: &Path, max_bytes: u64, max_age: Duration, keep: usize) -> Self {
+        Self {
+            path: path.to_path_buf(),
+            max_bytes,
+            max_age,
+            keep,
+            file: None,
+            size: 0,
+            opened: Instant::now(),
+        }
+    }
+
+    /// Open (appending to) the current file. An existing file's age counts
+    /// from now.
+    fn open(&mut self) -> io::Result<()> {
+        if let Some(dir) = self.path.parent().filter(|d| !d.as_os_str().is_empty()) {
+            fs::create_dir_all(dir)?;
+        }
+        let file = OpenOptions::new()
+            .create(true)
+            .append(true)
+            .open(&self.path)?;
+        self.size = file.metadata()?.len();
+        self.opened = Instant::now();
+        self.file = Some(BufWriter::new(file));
+        Ok(())
+    }
+
+    fn due(&self, incoming: u64) -> bool {
+        self.size > 0
+            && (self.size + incoming > self.max_bytes || self.opened.elapsed() >= self.max_age)
+    }
+
+    fn rotate(&mut self) -> io::Result<()> {
+        if let Some(mut file) = self.file.take() {
+            file.flush()?;
+        }
+        let file_name = self
+            .path
+            .file_name()
+            .map(|n| n.to_string_lossy().into_owned())
+            .unwrap_or_default();
+        let stamp = chrono::Utc::now().format("%Y%m%dT%H%M%S%3f");
+        let rotated = self.path.with_file_name(format!("{file_name}.{stamp}"));
+        fs::rename(&self.path, &rotated)?;
+        self.open()?;
+
+        let compressed = self.path.with_file_name(format!("{file_name}.{stamp}.gz"));
+        let mut gz = GzEncoder::new(File::create(&compressed)?, Compression::default());
+        io::copy(&mut File::open(&rotated)?, &mut gz)?;
+        gz.finish()?;
+        fs::remove_file(&rotated)?;
+        self.prune(&file_name)
+    }
+
+    /// Remove all but the newest `keep` rotations. Timestamps sort
+    /// lexically, so name order is age order.
+    fn prune(&self, file_name: &str) -> io::Result<()> {
+        let dir = match self.path.parent().filter(|d| !d.as_os_str().is_empty()) {
+            Some(d) => d.to_path_buf(),
+            None => PathBuf::from("."),
+        };
+        let prefix = format!("{file_name}.");
+        let mut rotations: Vec<PathBuf> = fs::read_dir(&dir)?
+            .filter_map(|e| e.ok())
+            .map(|e| e.path())
+            .filter(|p| {
+                p.file_name()
+                    .map(|n| n.to_string_lossy())
+                    .is_some_and(|n| n.starts_with(&prefix) && n.ends_with(".gz"))
+            })
+            .collect();
+        rotations.sort();
+        let excess = rotations.len().saturating_sub(self.keep);
+        for old in &rotations[..excess] {
+            fs::remove_file(old)?;
+        }
+        Ok(())
+    }
+}
+
+impl Write for RotatingFile {
+    /// Callers write one whole line at a time (see [`run_lines`]), so
+    /// rotation only ever happens between lines.
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        if self.due(buf.len() as u64) {
+            self.rotate()?;
+        }
+        if self.file.is_none() {
+            self.open()?;
+        }
+        let n = self.file.as_mut().map_or(Ok(0), |f| f.write(buf))?;
+        self.size += n as u64;
+        Ok(n)
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        self.file.as_mut().map_or(Ok(()), BufWriter::flush)
+    }
+}
+
+/// RFC 5424 syslog over UDP (one datagram per message) or TCP
+/// (octet-counted framing, RFC 6587), reconnecting as needed.
+struct Syslog {
+    transport: SyslogTransport,
+    hostname: String,
+    msgid: String,
+}
+
+enum SyslogTransport {
+    Udp {
+        socket: UdpSocket,
+        addr: String,
+    },
+    Tcp {
+        addr: String,
+        conn: Option<TcpStream>,
+    },
+}
+
+impl Syslog {
+    fn new(addr: &str, stream: &str) -> Result<Self> {
+        let transport = if let Some(addr) = addr.strip_prefix("udp://") {
+            let socket =
+                UdpSocket::bind("0.0.0.0:0").map_err(|e| anyhow!("bind syslog UDP socket: {e}"))?;
+            SyslogTransport::Udp {
+                socket,
+                addr: addr.to_string(),
+            }
+        } else if let Some(addr) = addr.strip_prefix("tcp://") {
+            SyslogTransport::Tcp {
+                addr: addr.to_string(),
+                conn: None,
+            }
+        } else {
+            bail!("SOLOBASE_LOG_SYSLOG_ADDR must start with udp:// or tcp://, got {addr:?}");
+        };
+        let hostname = std::env::var("HOSTNAME")
+            .ok()
+            .filter(|h| !h.is_empty())
+            .unwrap_or_else(|| "-".to_string());
+        Ok(Self {
+            transport,
+            hostname,
+            msgid: stream.to_string(),
+        })
+    }
+
+    /// `<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG`
+    fn format(&self, entry: &Entry) -> Vec<u8> {
+        let pri = SYSLOG_FACILITY * 8 + entry.severity as u8;
+        let mut msg = format!(
+            "<{pri}>1 {} {} solobase {} {} - ",
+            chrono::Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Millis, true),
+            self.hostname,
+            std::process::id(),
+            self.msgid,
+        )
+        .into_bytes();
+        msg.extend_from_slice(&entry.line);
+        msg
+    }
+
+    fn send(&mut self, entry: &Entry) -> io::Result<()> {
+        let msg = self.format(entry);
+        match &mut self.transport {
+            SyslogTransport::Udp { socket, addr } => socket.send_to(&msg, addr.as_str()).map(drop),
+            SyslogTransport::Tcp { addr, conn } => {
+                if conn.is_none() {
+                    *conn = Some(TcpStream::connect(addr.as_str())?);
+                }
+                let framed = [format!("{} ", msg.len()).as_bytes(), &msg].concat();
+                let sent = conn.as_mut().map_or(Ok(()), |c| c.write_all(&framed));
+                if sent.is_err() {
+                    // Reconnect on the next message.
+                    *conn = None;
+                }
+                sent
+            }
+        }
+    }
+}
+
+fn run_syslog(queue: &SinkQueue, mut rx: mpsc::Receiver<Entry>, mut out: Syslog) {
+    let mut health = Health::new(&queue.name);
+    while let Some(entry) = rx.blocking_recv() {
+        match out.send(&entry) {
+            Ok(()) => {
+                health.ok();
+                queue.done(1, 0);
+            }
+            Err(e) => {
+                health.failed(&e);
+                queue.done(1, 1);
+            }
+        }
+    }
+}
+
+/// Batched NDJSON over HTTP: each batch is one gzipped POST, retried with
+/// exponential backoff and dropped after [`HTTP_ATTEMPTS`].
+struct HttpSink {
+    client: reqwest::Client,
+    url: String,
+    batch: usize,
+    flush: Duration,
+}
+
+impl HttpSink {
+    async fn run(&self, queue: &SinkQueue, mut rx: mpsc::Receiver<Entry>) {
+        let mut health = Health::new(&queue.name);
+        while let Some(first) = rx.recv().await {
+            let mut batch = vec![first];
+            let deadline = tokio::time::Instant::now() + self.flush;
+            while batch.len() < self.batch {
+                match tokio::time::timeout_at(deadline, rx.recv()).await {
+                    Ok(Some(e)) => batch.push(e),
+                    Ok(None) | Err(_) => break,
+                }
+            }
+            match self.post(&batch).await {
+                Ok(()) => {
+                    health.ok();
+                    queue.done(batch.len(), 0);
+                }
+                Err(e) => {
+                    health.failed(&e);
+                    queue.done(batch.len(), batch.len());
+                }
+            }
+        }
+    }
+
+    async fn post(&self, batch: &[Entry]) -> Result<()> {
+        let body = gzip_ndjson(batch)?;
+        let mut backoff = Duration::from_millis(500);
+        let mut attempt = 1;
+        loop {
+            let sent = self
+                .client
+                .post(&self.url)
+                .header("content-type", "application/x-ndjson")
+                .header("content-encoding", "gzip")
+                .body(body.clone())
+                .send()
+                .await;
+            let err = match sent {
+                Ok(resp) if resp.status().is_success() => return Ok(()),
+                // A 4xx will not get better by retrying.
+                Ok(resp) if resp.status().is_client_error() => {
+                    bail!("{} answered {}", self.url, resp.status())
+                }
+                Ok(resp) => anyhow!("{} answered {}", self.url, resp.status()),
+                Err(e) => anyhow!("POST {}: {e}", self.url),
+            };
+            if attempt == HTTP_ATTEMPTS {
+                return Err(err);
+            }
+            attempt += 1;
+            tokio::time::sleep(backoff).await;
+            backoff *= 2;
+        }
+    }
+}
+
+fn gzip_ndjson(batch: &[Entry]) -> io::Result<Vec<u8>> {
+    let mut gz = GzEncoder::new(Vec::new(), Compression::default());
+    for e in batch {
+        gz.write_all(&e.line)?;
+        gz.write_all(b"\n")?;
+    }
+    gz.finish()
+}
+
+/// `tracing` [`MakeWriter`] feeding the application-log sinks. Each event
+/// is buffered and enqueued whole when the writer drops.
+#[derive(Clone)]
+pub struct SinkWriter {
+    queues: Arc<[Arc<SinkQueue>]>,
+}
+
+/// Per-event writer handed out by [`SinkWriter`].
+pub struct SinkEvent {
+    queues: Arc<[Arc<SinkQueue>]>,
+    severity: Severity,
+    buf: Vec<u8>,
+}
+
+impl Write for SinkEvent {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        self.buf.extend_from_slice(buf);
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+impl Drop for SinkEvent {
+    fn drop(&mut self) {
+        while self.buf.last() == Some(&b'\n') {
+            self.buf.pop();
+        }
+        if self.buf.is_empty() {
+            return;
+        }
+        let entry = Entry {
+            severity: self.severity,
+            line: std::mem::take(&mut self.buf),
+        };
+        for queue in self.queues.iter() {
+            queue.push(entry.clone());
+        }
+    }
+}
+
+impl<'a> MakeWriter<'a> for SinkWriter {
+    type Writer = SinkEvent;
+
+    fn make_writer(&'a self) -> Self::Writer {
+        self.event(Severity::Info)
+    }
+
+    fn make_writer_for(&'a self, meta: &tracing::Metadata<'_>) -> Self::Writer {
+        self.event(Severity::from_level(meta.level()))
+    }
+}
+
+impl SinkWriter {
+    fn event(&self, severity: Severity) -> SinkEvent {
+        SinkEvent {
+            queues: Arc::clone(&self.queues),
+            severity,
+            buf: Vec::new(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn config(vars: &[(&str, &str)]) -> Result<LogSinkConfig> {
+        let vars: HashMap<String, String> = vars
+            .iter()
+            .map(|(k, v)| ((*k).to_string(), (*v).to_string()))
+            .collect();
+        LogSinkConfig::from_lookup(|k| vars.get(k).cloned())
+    }
+
+    #[test]
+    fn config_defaults_and_validation() {
+        let cfg = config(&[]).unwrap();
+        assert_eq!(cfg.app, [SinkKind::Stdout]);
+        assert_eq!(cfg.request, [SinkKind::Database]);
+
+        let cfg = config(&[
+            ("SOLOBASE_REQUEST_LOG_SINKS", "database, file,file"),
+            ("SOLOBASE_LOG_SINKS", "none"),
+        ])
+        .unwrap();
+        assert!(cfg.app.is_empty());
+        assert_eq!(cfg.request, [SinkKind::Database, SinkKind::File]);
+
+        assert!(config(&[("SOLOBASE_LOG_SINKS", "database")]).is_err());
+        assert!(config(&[("SOLOBASE_LOG_SINKS", "loki")]).is_err());
+        assert!(config(&[("SOLOBASE_REQUEST_LOG_SINKS", "http")]).is_err());
+        assert!(config(&[("SOLOBASE_LOG_SINKS", "syslog")]).is_err());
+        assert!(config(&[("SOLOBASE_LOG_FILE_KEEP", "many")]).is_err());
+    }
+
+    #[test]
+    fn full_queue_drops_and_counts_instead_of_blocking() {
+        let (queue, mut rx) = SinkQueue::new("request:test".into(), 2);
+        for _ in 0..5 {
+            queue.push(Entry {
+                severity: Severity::Info,
+                line: b"{}".to_vec(),
+            });
+        }
+        let stats = queue.stats();
+        assert_eq!((stats.pending, stats.dropped), (2, 3));
+        while rx.try_recv().is_ok() {
+            queue.done(1, 0);
+        }
+        assert_eq!(queue.stats().pending, 0);
+    }
+
+    #[test]
+    fn rotating_file_rotates_compresses_and_prunes() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("app.log");
+        let mut file = RotatingFile::new(&path, 64, Duration::from_secs(3600), 2);
+        for i in 0..20 {
+            file.write_all(format!("{{\"n\":{i:040}}}\n").as_bytes())
+                .unwrap();
+            // Rotation names carry millisecond timestamps.
+            std::thread::sleep(Duration::from_millis(2));
+        }
+        file.flush().unwrap();
+
+        let mut names: Vec<String> = fs::read_dir(dir.path())
+            .unwrap()
+            .map(|e| e.unwrap().file_name().to_string_lossy().into_owned())
+            .collect();
+        names.sort();
+        assert_eq!(names.len(), 3, "{names:?}");
+        assert_eq!(names[0], "app.log");
+        assert!(names[1..].iter().all(|n| n.ends_with(".gz")));
+
+        let mut gz = flate2::read::GzDecoder::new(File::open(dir.path().join(&names[2])).unwrap());
+        let mut text = String::new();
+        io::Read::read_to_string(&mut gz, &mut text).unwrap();
+        assert!(text.starts_with("{\"n\":"));
+    }
+
+    #[test]
+    fn syslog_lines_are_rfc5424() {
+        let out = Syslog::new("udp://127.0.0.1:514", "request").unwrap();
+        let line = out.format(&Entry {
+            severity: Severity::from_status(503),
+            line: b"{\"status_code\":503}".to_vec(),
+        });
+        let line = String::from_utf8(line).unwrap();
+        // local0 (16) * 8 + err (3)
+        assert!(line.starts_with("<131>1 "), "{line}");
+        assert!(line.ends_with(" request - {\"status_code\":503}"), "{line}");
+        assert!(Syslog::new("127.0.0.1:514", "app").is_err());
+    }
+
+    #[test]
+    fn event_writer_enqueues_one_entry_per_event() {
+        let (queue, mut rx) = SinkQueue::new("app:test".into(), 8);
+        let writer = SinkWriter {
+            queues: Arc::from(vec![queue]),
+        };
+        {
+            let mut event = writer.event(Severity::Warning);
+            event.write_all(b"{\"level\":").unwrap();
+            event.write_all(b"\"WARN\"}\n").unwrap();
+        }
+        let entry = rx.try_recv().unwrap();
+        assert_eq!(entry.line, b"{\"level\":\"WARN\"}");
+        assert_eq!(entry.severity, Severity::Warning);
+        assert!(rx.try_recv().is_err());
+        assert!(!LogSinks::forwards_target("hyper::proto::h1"));
+        assert!(LogSinks::forwards_target("solobase::pipeline"));
+    }
+}
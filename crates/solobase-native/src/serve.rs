@@ -6,6 +6,8 @@
 //! awaits a ctrl-c / SIGTERM signal and shuts the runtime down. Splitting
 //! them lets the consumer run post-start hooks (e.g., WRAP grant
 //! injection) between `wafer.start()` and the shutdown wait.
+//! `spawn_reload_on_sighup` lets the consumer re-read its configuration
+//! without a restart.
 
 use std::sync::Arc;
 
@@ -33,7 +35,8 @@ pub fn register_http_listener(wafer: &mut Wafer, listen_addr: &str, flow_id: &st
     );
 }
 
-/// Await a graceful-shutdown signal (ctrl-c or SIGTERM on Unix), then call
+/// Await a graceful-shutdown signal (ctrl-c or SIGTERM on Unix), run
+/// `before_shutdown` (last writes that still need the runtime), then call
 /// `wafer.shutdown().await`. Returns after the shutdown completes.
 ///
 /// # Errors
@@ -41,12 +44,45 @@ pub fn register_http_listener(wafer: &mut Wafer, listen_addr: &str, flow_id: &st
 /// Returns an error if a signal handler fails to install — that surfaces
 /// as a recoverable boot failure rather than a panic, so the caller can
 /// shut down cleanly instead of leaving an unkillable process behind.
-pub async fn serve_until_shutdown(wafer: &Arc<Wafer>) -> Result<()> {
+pub async fn serve_until_shutdown(
+    wafer: &Arc<Wafer>,
+    before_shutdown: impl std::future::Future<Output = ()>,
+) -> Result<()> {
     shutdown_signal().await?;
+    before_shutdown.await;
     wafer.shutdown().await;
     Ok(())
 }
 
+/// Call `on_reload` each time the process receives SIGHUP, the usual
+/// "re-read your configuration" signal. A no-op on non-Unix targets, which
+/// have no SIGHUP. Must be called from within the tokio runtime.
+///
+/// # Errors
+///
+/// Returns an error if the SIGHUP handler fails to install.
+pub fn spawn_reload_on_sighup<F>(on_reload: F) -> Result<()>
+where
+    F: Fn() + Send + 'static,
+{
+    #[cfg(unix)]
+    {
+        let mut sighup = tokio::signal::unix::signal(tokio::signal::unix::SignalKind::hangup())
+            .context("install SIGHUP handler")?;
+        tokio::spawn(async move {
+            while sighup.recv().await.is_some() {
+                tracing::info!("received SIGHUP — reloading configuration");
+                on_reload();
+            }
+        });
+    }
+
+    #[cfg(not(unix))]
+    drop(on_reload);
+
+    Ok(())
+}
+
 async fn shutdown_signal() -> Result<()> {
     #[cfg(unix)]
     {
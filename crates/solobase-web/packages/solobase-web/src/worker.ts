@@ -26,24 +26,33 @@
 import wasmInit, {
   initialize as wasmInitialize,
   handle_request as wasmHandleRequest,
+  init_status as wasmInitStatus,
 } from '../../pkg/solobase_web.js';
 
+let wasmLoaded = false;
 let initialized = false;
 let initPromise: Promise<void> | null = null;
 
 /**
  * Load the WASM module and boot the Solobase runtime.
- * Safe to call multiple times — only the first call does work.
+ * Safe to call multiple times — only the first successful call does work.
+ * A failed boot is retried by the next call while the runtime allows it
+ * (see `initStatus().retrying`).
  */
 export async function initialize(): Promise<void> {
   if (initialized) return;
   if (initPromise) return initPromise;
 
   initPromise = (async () => {
-    await wasmInit();
+    if (!wasmLoaded) {
+      await wasmInit();
+      wasmLoaded = true;
+    }
     await wasmInitialize();
     initialized = true;
-  })();
+  })().finally(() => {
+    initPromise = null;
+  });
 
   return initPromise;
 }
@@ -58,3 +67,12 @@ export async function handleRequest(request: Request): Promise<Response> {
   }
   return wasmHandleRequest(request);
 }
+
+/**
+ * Boot state of the runtime — `{state, reason?, retrying?, attempts,
+ * max_attempts, total_ms, components}` — for health-gating traffic.
+ */
+export function initStatus(): unknown {
+  if (!wasmLoaded) return { state: 'not_started' };
+  return wasmInitStatus();
+}
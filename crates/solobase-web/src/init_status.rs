@@ -0,0 +1,136 @@
+//! Boot bookkeeping for the Service Worker runtime: where `initialize()`
+//! is, how long each phase took, and whether a failed boot may be retried.
+//!
+//! WASM is single-threaded, so the state lives in a `thread_local` like the
+//! runtime itself (`solobase_browser::runtime`). Hosts read it through the
+//! `init_status()` export.
+
+use std::cell::RefCell;
+
+/// Boots attempted before a failure is final. A failed `initialize()` is
+/// retried by the next call (or the next request) until then.
+pub const MAX_ATTEMPTS: u32 = 3;
+
+/// Where the runtime is in its boot.
+#[derive(Debug, Clone, Default)]
+pub enum Stage {
+    #[default]
+    NotStarted,
+    Initializing,
+    Ready,
+    /// `retrying`: the next `initialize()` call boots again. False once
+    /// the failure is permanent or [`MAX_ATTEMPTS`] is used up.
+    Failed {
+        reason: String,
+        retrying: bool,
+    },
+}
+
+/// One boot phase of the last attempt, in the order they ran.
+#[derive(Debug, Clone)]
+pub struct Component {
+    pub name: &'static str,
+    /// `ok`, `failed: <reason>` (the boot stopped here), or `skipped:
+    /// <reason>` (an optional service that failed without stopping it).
+    pub status: String,
+    pub ms: f64,
+}
+
+#[derive(Debug, Default)]
+struct State {
+    stage: Stage,
+    attempts: u32,
+    components: Vec<Component>,
+    total_ms: f64,
+}
+
+thread_local! {
+    static STATE: RefCell<State> = RefCell::new(State::default());
+}
+
+/// Whether `initialize()` should boot now, or the reason it must not: a
+/// boot is already running, or the last failure is final.
+pub fn begin() -> Result<(), String> {
+    STATE.with(|s| {
+        let mut s = s.borrow_mut();
+        match &s.stage {
+            Stage::Initializing => return Err("initialization already in progress".into()),
+            Stage::Failed {
+                reason,
+                retrying: false,
+            } => {
+                return Err(format!(
+                    "initialization failed after {} attempt(s): {reason}",
+                    s.attempts
+                ))
+            }
+            _ => {}
+        }
+        s.stage = Stage::Initializing;
+        s.attempts += 1;
+        s.components.clear();
+        s.total_ms = 0.0;
+        Ok(())
+    })
+}
+
+/// Record a finished phase of the running attempt.
+pub fn component(name: &'static str, status: impl Into<String>, ms: f64) {
+    STATE.with(|s| {
+        s.borrow_mut().components.push(Component {
+            name,
+            status: status.into(),
+            ms,
+        })
+    });
+}
+
+/// The running attempt succeeded.
+pub fn ready(total_ms: f64) {
+    STATE.with(|s| {
+        let mut s = s.borrow_mut();
+        s.stage = Stage::Ready;
+        s.total_ms = total_ms;
+    });
+}
+
+/// The running attempt failed. A `transient` failure (the database was
+/// briefly unavailable) is retried until [`MAX_ATTEMPTS`]; any other is
+/// final.
+pub fn failed(reason: String, transient: bool, total_ms: f64) {
+    STATE.with(|s| {
+        let mut s = s.borrow_mut();
+        let retrying = transient && s.attempts < MAX_ATTEMPTS;
+        s.stage = Stage::Failed { reason, retrying };
+        s.total_ms = total_ms;
+    });
+}
+
+/// `{state, reason?, retrying?, attempts, max_attempts, total_ms,
+/// components: [{name, status, ms}]}` for the `init_status()` export.
+pub fn to_json() -> serde_json::Value {
+    STATE.with(|s| {
+        let s = s.borrow();
+        let mut out = serde_json::json!({
+            "state": match s.stage {
+                Stage::NotStarted => "not_started",
+                Stage::Initializing => "initializing",
+                Stage::Ready => "ready",
+                Stage::Failed { .. } => "failed",
+            },
+            "attempts": s.attempts,
+            "max_attempts": MAX_ATTEMPTS,
+            "total_ms": s.total_ms,
+            "components": s.components.iter().map(|c| serde_json::json!({
+                "name": c.name,
+                "status": c.status,
+                "ms": c.ms,
+            })).collect::<Vec<_>>(),
+        });
+        if let Stage::Failed { reason, retrying } = &s.stage {
+            out["reason"] = serde_json::json!(reason);
+            out["retrying"] = serde_json::json!(retrying);
+        }
+        out
+    })
+}
@@ -3,14 +3,36 @@
 //! Thin wasm-bindgen wrapper around the `solobase-browser` framework. Uses
 //! `SolobaseBuilder` (from `solobase-core`) to wire up the full Solobase
 //! block suite + the app-specific `BrowserLlmService`.
+//!
+//! Host contract:
+//!
+//! - `initialize()` boots the runtime. Call it eagerly from the Service
+//!   Worker's `install` handler so no request pays the cold start; it is a
+//!   no-op once the runtime is up.
+//! - `handle_request(request)` dispatches a fetch. If the runtime isn't up
+//!   it boots first, so a host that never calls `initialize()` still works
+//!   (the first request just pays for it). A request that arrives while a
+//!   boot is running, or after one failed, gets a 503.
+//! - A failed boot is retried by the next `initialize()` / request when the
+//!   failure was transient (the database or the boot funnel's first writes
+//!   failed), up to `init_status::MAX_ATTEMPTS` attempts. A build failure is
+//!   a configuration error and is final.
+//! - `init_status()` reports `{state, reason?, retrying?, attempts,
+//!   max_attempts, total_ms, components}` — `state` is `not_started`,
+//!   `initializing`, `ready` or `failed` — so the host can health-gate
+//!   traffic. `components` times each boot phase of the last attempt.
+//!
+//! Every phase logs its duration to the console (`solobase: boot <phase>
+//! <ms> ms`), so cold-start regressions are visible in the SW console.
 
-use std::sync::Arc;
+use std::{future::Future, sync::Arc};
 
 use solobase_core::builder::{self, SolobaseBuilder};
 use wafer_core::interfaces::config::service::ConfigService;
 use wasm_bindgen::prelude::*;
 
 pub mod config;
+pub mod init_status;
 
 const SOLOBASE_CSP: &str = concat!(
     "default-src 'self'; ",
@@ -25,13 +47,74 @@ const SOLOBASE_CSP: &str = concat!(
     "form-action 'self'",
 );
 
+/// A boot phase that failed, and whether a later attempt may succeed.
+struct BootFailure {
+    reason: String,
+    transient: bool,
+}
+
+/// Run one boot phase, timing it into `init_status` and the console.
+async fn phase<T>(
+    name: &'static str,
+    transient: bool,
+    run: impl Future<Output = Result<T, String>>,
+) -> Result<T, BootFailure> {
+    let started = js_sys::Date::now();
+    let result = run.await;
+    let ms = js_sys::Date::now() - started;
+    match &result {
+        Ok(_) => init_status::component(name, "ok", ms),
+        Err(e) => init_status::component(name, format!("failed: {e}"), ms),
+    }
+    web_sys::console::log_1(&format!("solobase: boot {name} {ms:.0} ms").into());
+    result.map_err(|reason| BootFailure {
+        reason: format!("{name}: {reason}"),
+        transient,
+    })
+}
+
+fn js_error(e: JsValue) -> String {
+    e.as_string().unwrap_or_else(|| format!("{e:?}"))
+}
+
+/// Boot the runtime; see the host contract in the crate docs.
 #[wasm_bindgen]
 pub async fn initialize() -> Result<(), JsValue> {
     if solobase_browser::is_initialized() {
         return Ok(());
     }
+    init_status::begin().map_err(|e| JsValue::from_str(&e))?;
+
+    let started = js_sys::Date::now();
+    let result = boot().await;
+    let ms = js_sys::Date::now() - started;
+    match result {
+        Ok(()) => {
+            init_status::ready(ms);
+            web_sys::console::log_1(&format!("solobase: initialized in {ms:.0} ms").into());
+            Ok(())
+        }
+        Err(failure) => {
+            init_status::failed(failure.reason.clone(), failure.transient, ms);
+            web_sys::console::error_1(
+                &format!("solobase: initialization failed: {}", failure.reason).into(),
+            );
+            Err(JsValue::from_str(&failure.reason))
+        }
+    }
+}
 
-    solobase_browser::db_init().await?;
+/// The boot state for host health-gating; see the crate docs.
+#[wasm_bindgen]
+pub fn init_status() -> JsValue {
+    js_sys::JSON::parse(&init_status::to_json().to_string()).unwrap_or(JsValue::NULL)
+}
+
+async fn boot() -> Result<(), BootFailure> {
+    phase("database", true, async {
+        solobase_browser::db_init().await.map_err(js_error)
+    })
+    .await?;
 
     // ── Phase 1 ─────────────────────────────────────────────────────────────
     // Build the runtime with EMPTY config + EMPTY block_settings + EMPTY
@@ -73,14 +156,27 @@ pub async fn initialize() -> Result<(), JsValue> {
         Arc::new(solobase_browser::image::BrowserImageService::new());
     let browser_vector: Arc<dyn wafer_core::interfaces::vector::service::VectorService> =
         Arc::new(solobase_browser::vector::BrowserVectorService::new());
-    let browser_embedding: Arc<dyn wafer_core::interfaces::vector::service::EmbeddingService> =
-        match solobase_browser::vector::BrowserEmbeddingService::new() {
-            Ok(svc) => Arc::new(svc),
-            Err(e) => {
-                web_sys::console::error_1(&format!("BrowserEmbeddingService init: {e}").into());
-                return Err(JsValue::from_str(&e));
-            }
-        };
+    // Embeddings are optional: without them the runtime comes up with no
+    // `wafer-run/vector` (the builder registers it only with both halves)
+    // and `init_status` reports the component as skipped.
+    let started = js_sys::Date::now();
+    let browser_embedding: Option<
+        Arc<dyn wafer_core::interfaces::vector::service::EmbeddingService>,
+    > = match solobase_browser::vector::BrowserEmbeddingService::new() {
+        Ok(svc) => {
+            init_status::component("embedding", "ok", js_sys::Date::now() - started);
+            Some(Arc::new(svc))
+        }
+        Err(e) => {
+            web_sys::console::warn_1(&format!("BrowserEmbeddingService init: {e}").into());
+            init_status::component(
+                "embedding",
+                format!("skipped: {e}"),
+                js_sys::Date::now() - started,
+            );
+            None
+        }
+    };
 
     // JWT secret can't be loaded yet (variables table doesn't exist).
     // Construct the concrete `BrowserCryptoService` so we keep a typed Arc
@@ -93,7 +189,7 @@ pub async fn initialize() -> Result<(), JsValue> {
     let crypto_svc: Arc<dyn wafer_core::interfaces::crypto::service::CryptoService> =
         crypto_concrete.clone();
 
-    let builder = SolobaseBuilder::new()
+    let mut builder = SolobaseBuilder::new()
         .database(solobase_browser::make_database_service())
         .storage(solobase_browser::make_storage_service())
         .config(config_svc.clone())
@@ -102,19 +198,24 @@ pub async fn initialize() -> Result<(), JsValue> {
         .logger(solobase_browser::make_console_logger())
         .llm_service("browser", browser_llm)
         .image_service("browser", browser_image)
-        .vector_service(browser_vector)
-        .embedding_service(browser_embedding)
         .block_settings(initial_block_settings)
         .block_config(
             "wafer-run/security-headers",
             serde_json::json!({ "csp": SOLOBASE_CSP }),
         )
         .config_source(cfg_source);
+    if let Some(embedding) = browser_embedding {
+        builder = builder
+            .vector_service(browser_vector)
+            .embedding_service(embedding);
+    }
     let block_settings_handle = builder.block_settings_handle();
 
-    let (mut wafer, storage_block) = builder
-        .build()
-        .map_err(|e| JsValue::from_str(&e.to_string()))?;
+    // A build failure is a configuration error: retrying can't fix it.
+    let (mut wafer, storage_block) = phase("build", false, async move {
+        builder.build().map_err(|e| e.to_string())
+    })
+    .await?;
     wafer.set_asset_loader(&solobase_browser::make_sw_asset_loader());
 
     // ── Phase 2 ─────────────────────────────────────────────────────────────
@@ -134,15 +235,46 @@ pub async fn initialize() -> Result<(), JsValue> {
         block_settings_handle: block_settings_handle.clone(),
         crypto: crypto_concrete.clone(),
     };
-    builder::boot(&mut wafer, &storage_block, &hooks)
-        .await
-        .map_err(|e| JsValue::from_str(&format!("boot: {e}")))?;
-
-    web_sys::console::log_1(&"solobase: WAFER runtime started".into());
+    phase("boot", true, async {
+        builder::boot(&mut wafer, &storage_block, &hooks)
+            .await
+            .map_err(|e| e.to_string())
+    })
+    .await?;
 
-    solobase_browser::store_wafer(wafer).map_err(|e| JsValue::from_str(&e.to_string()))?;
+    // Preflight: the portable checks only — the browser has no port to
+    // bind and no disk to measure. Kept for the admin diagnostics page.
+    let report = phase("preflight", false, async {
+        let mut preflight = solobase_core::diagnostics::Preflight::new("", None);
+        preflight
+            .run_portable(
+                Ok(&solobase_browser::make_database_service()),
+                Ok(&solobase_browser::make_storage_service()),
+                config_svc
+                    .get(solobase_core::blocks::auth::JWT_SECRET_KEY)
+                    .as_deref(),
+            )
+            .await;
+        preflight.unsupported(solobase_core::diagnostics::PORT);
+        preflight.unsupported(solobase_core::diagnostics::DISK);
+        Ok(preflight.finish())
+    })
+    .await?;
+    for check in report.failures() {
+        web_sys::console::warn_1(
+            &format!(
+                "solobase: preflight {}: {} ({})",
+                check.name, check.detail, check.hint
+            )
+            .into(),
+        );
+    }
+    solobase_core::diagnostics::record_startup(report);
 
-    Ok(())
+    solobase_browser::store_wafer(wafer).map_err(|e| BootFailure {
+        reason: e.to_string(),
+        transient: false,
+    })
 }
 
 /// [`BootHooks`](solobase_core::builder::BootHooks) impl for the browser
@@ -196,5 +328,10 @@ impl builder::BootHooks for BrowserBootHooks {
 
 #[wasm_bindgen]
 pub async fn handle_request(request: web_sys::Request) -> Result<web_sys::Response, JsValue> {
+    // Boot lazily (or retry a transient failure); dispatch answers 503
+    // while the runtime still isn't up.
+    if !solobase_browser::is_initialized() && initialize().await.is_err() {
+        web_sys::console::warn_1(&"solobase: request arrived before the runtime was up".into());
+    }
     solobase_browser::dispatch_request(request).await
 }
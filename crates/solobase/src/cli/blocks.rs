@@ -0,0 +1,102 @@
+//! `solobase blocks` — list the built-in blocks with their enabled state,
+//! and enable or disable one, against this directory's database.
+//!
+//! Writes the same `block_settings` row the admin blocks page does (marked
+//! as a user edit, so the boot-time seed keeps it). The server reads the
+//! flags at start, so a change made here applies at the next start; to
+//! toggle a running server use the admin blocks page or
+//! `POST /b/admin/api/extensions/{block}/{enable,disable}`.
+
+use std::{path::Path, sync::Arc};
+
+use anyhow::{anyhow, bail};
+use solobase_core::features::{self, FeatureConfig};
+use solobase_native::InfraConfig;
+use wafer_core::interfaces::database::service::DatabaseService;
+
+/// Print every built-in block with whether it is enabled, or a JSON array
+/// with `json`.
+pub async fn list(repo_root: &Path, json: bool) -> anyhow::Result<()> {
+    let database = open(repo_root).await?;
+    // The flags the next start would load, seeding defaults for blocks
+    // with no row yet exactly as the start does.
+    let settings = features::load_and_seed_block_settings(&database).await;
+    let blocks: Vec<_> = solobase_core::blocks::all_block_infos()
+        .into_iter()
+        .map(|b| {
+            serde_json::json!({
+                "name": b.name,
+                "enabled": !b.can_disable || settings.is_block_enabled(&b.name),
+                "can_disable": b.can_disable,
+                "summary": b.summary,
+            })
+        })
+        .collect();
+
+    if json {
+        println!("{}", serde_json::to_string_pretty(&blocks)?);
+        return Ok(());
+    }
+    let width = blocks
+        .iter()
+        .map(|b| b["name"].as_str().unwrap_or("").len())
+        .max()
+        .unwrap_or(0);
+    for b in &blocks {
+        let state = match (b["can_disable"].as_bool(), b["enabled"].as_bool()) {
+            (Some(false), _) => "always on",
+            (_, Some(true)) => "enabled",
+            _ => "disabled",
+        };
+        println!(
+            "{:<width$} {:<9} {}",
+            b["name"].as_str().unwrap_or(""),
+            state,
+            b["summary"].as_str().unwrap_or("")
+        );
+    }
+    Ok(())
+}
+
+/// Persist `name`'s enabled flag. Errors for an unknown block or one that
+/// can't be disabled.
+pub async fn set_enabled(repo_root: &Path, name: &str, enabled: bool) -> anyhow::Result<()> {
+    let Some(info) = solobase_core::blocks::all_block_infos()
+        .into_iter()
+        .find(|b| b.name == name)
+    else {
+        bail!("unknown block {name:?}; `solobase blocks list` shows the block names");
+    };
+    if !info.can_disable {
+        bail!("{name} is a core block and is always enabled");
+    }
+    let database = open(repo_root).await?;
+    features::store_enabled(&database, name, enabled)
+        .await
+        .map_err(|e| anyhow!("{e}"))?;
+    println!(
+        "{name} {}. Takes effect the next time the server starts.",
+        if enabled { "enabled" } else { "disabled" }
+    );
+    Ok(())
+}
+
+/// Open the database a `serve` in `repo_root` would use, with the admin
+/// tables in place (a fresh database gets them, as at first start).
+async fn open(repo_root: &Path) -> anyhow::Result<Arc<dyn DatabaseService>> {
+    solobase_native::load_dotenv(repo_root);
+    let infra = InfraConfig::from_env();
+    let database = solobase_native::make_database_service(
+        &infra.db_type,
+        &infra.db_path,
+        infra.db_url.as_deref(),
+    )
+    .await?;
+    solobase_core::migration_helper::apply_ddl_via_service(
+        &database,
+        &solobase_core::blocks::admin::migrations::ddl_files(&infra.db_type),
+    )
+    .await
+    .map_err(|e| anyhow!("create admin tables: {e}"))?;
+    Ok(database)
+}
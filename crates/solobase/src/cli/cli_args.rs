@@ -67,6 +67,45 @@ pub enum Command {
         #[command(subcommand)]
         action: Option<DeployAction>,
     },
+    /// Work with declarative extensions (directories with a
+    /// `manifest.json`, loaded from `SOLOBASE_EXTENSIONS_DIR` at startup).
+    Extensions {
+        #[command(subcommand)]
+        action: ExtensionsAction,
+    },
+    /// List every route the server mounts: method, path template, the auth
+    /// tier the router enforces, where it comes from (core, extension or
+    /// custom) and the block that serves it.
+    Routes {
+        /// Print a JSON array instead of columns.
+        #[arg(long)]
+        json: bool,
+
+        /// Extensions directory to include. Defaults to
+        /// `SOLOBASE_EXTENSIONS_DIR`; none when unset.
+        #[arg(long)]
+        extensions_dir: Option<std::path::PathBuf>,
+    },
+    /// Run the startup preflight checks (database, storage, JWT secret,
+    /// clock, listen port, disk space) against this directory's config
+    /// without starting the server. Exits non-zero when any check fails.
+    Doctor {
+        /// Print the report as JSON.
+        #[arg(long)]
+        json: bool,
+
+        /// Comma-separated checks to skip, e.g. `port,disk`. Defaults to
+        /// `SOLOBASE_PREFLIGHT_SKIP`.
+        #[arg(long)]
+        skip: Option<String>,
+    },
+    /// List the built-in blocks with their enabled state, or enable or
+    /// disable one. Changes apply at the next server start; the admin
+    /// blocks page toggles a running server.
+    Blocks {
+        #[command(subcommand)]
+        action: BlocksAction,
+    },
 }
 
 /// Subactions of `solobase deploy`.
@@ -81,6 +120,34 @@ pub enum DeployAction {
     Secret,
 }
 
+/// Subactions of `solobase extensions`.
+#[derive(Subcommand, Debug)]
+pub enum ExtensionsAction {
+    /// Check every extension under the directory with the same rules the
+    /// server applies at startup, listing all problems. Exits non-zero when
+    /// any extension is invalid.
+    Validate {
+        /// Extensions directory. Defaults to `SOLOBASE_EXTENSIONS_DIR`,
+        /// then `extensions`.
+        dir: Option<std::path::PathBuf>,
+    },
+}
+
+/// Subactions of `solobase blocks`.
+#[derive(Subcommand, Debug)]
+pub enum BlocksAction {
+    /// Print every built-in block with its enabled state.
+    List {
+        /// Print a JSON array instead of columns.
+        #[arg(long)]
+        json: bool,
+    },
+    /// Enable a block, e.g. `suppers-ai/files`.
+    Enable { name: String },
+    /// Disable a block. Core blocks can't be disabled.
+    Disable { name: String },
+}
+
 #[derive(ValueEnum, Clone, Copy, Debug, PartialEq, Eq)]
 pub enum Target {
     Native,
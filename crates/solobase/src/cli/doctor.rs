@@ -0,0 +1,92 @@
+//! `solobase doctor` — run the startup preflight checks without starting
+//! the server.
+//!
+//! Reads the same `.env` and `SOLOBASE_*` config a `serve` in this
+//! directory would, opens the database and storage it names, and runs
+//! [`super::preflight::run`]. Nothing is migrated or seeded: the JWT
+//! secret is read from the variables table when there is one, else from
+//! the environment, else its check is skipped (first start generates it).
+
+use std::{path::Path, sync::Arc};
+
+use solobase_core::diagnostics::{Report, Status};
+use solobase_native::InfraConfig;
+use wafer_core::interfaces::database::service::DatabaseService;
+
+/// Print every check with its hint, or the report as JSON with `json`.
+/// `skip` overrides `SOLOBASE_PREFLIGHT_SKIP`. Errors when a check fails.
+pub async fn run(repo_root: &Path, json: bool, skip: Option<&str>) -> anyhow::Result<()> {
+    solobase_native::load_dotenv(repo_root);
+    let infra = InfraConfig::from_env();
+
+    // A service that can't even be constructed fails its check rather
+    // than ending the run.
+    let database = solobase_native::make_database_service(
+        &infra.db_type,
+        &infra.db_path,
+        infra.db_url.as_deref(),
+    )
+    .await
+    .map_err(|e| format!("{e:#}"));
+    let storage = solobase_native::make_storage_service(&infra.storage_type, &infra.storage_root)
+        .await
+        .map_err(|e| format!("{e:#}"));
+    let jwt_secret = match &database {
+        Ok(db) => stored_jwt_secret(db).await,
+        Err(_) => None,
+    }
+    .or_else(|| std::env::var(solobase_core::blocks::auth::JWT_SECRET_KEY).ok())
+    .filter(|s| !s.is_empty());
+
+    let report = super::preflight::run(
+        &infra,
+        database.as_ref().map_err(Clone::clone),
+        storage.as_ref().map_err(Clone::clone),
+        jwt_secret.as_deref(),
+        skip,
+    )
+    .await;
+
+    if json {
+        println!("{}", serde_json::to_string_pretty(&report)?);
+    } else {
+        print_report(&report);
+    }
+    let failed = report.failures().count();
+    if failed > 0 {
+        anyhow::bail!("{failed} of {} checks failed", report.checks.len());
+    }
+    Ok(())
+}
+
+/// The JWT secret a previous start stored, if any. A database without the
+/// variables table (never started) has none.
+async fn stored_jwt_secret(db: &Arc<dyn DatabaseService>) -> Option<String> {
+    let vars = solobase_core::boot::load_all_variables(db).await.ok()?;
+    vars.get(solobase_core::blocks::auth::JWT_SECRET_KEY)
+        .cloned()
+}
+
+fn print_report(report: &Report) {
+    let width = report
+        .checks
+        .iter()
+        .map(|c| c.name.len())
+        .max()
+        .unwrap_or(0);
+    for check in &report.checks {
+        let status = match check.status {
+            Status::Pass => "ok",
+            Status::Warn => "warn",
+            Status::Fail => "FAIL",
+            Status::Skipped => "skip",
+        };
+        println!(
+            "{status:<4} {:<width$} {} ({} ms)",
+            check.name, check.detail, check.duration_ms
+        );
+        if !check.hint.is_empty() && matches!(check.status, Status::Warn | Status::Fail) {
+            println!("     {:<width$} → {}", "", check.hint);
+        }
+    }
+}
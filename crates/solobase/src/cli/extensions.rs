@@ -0,0 +1,51 @@
+//! `solobase extensions validate` — check declarative extensions before
+//! deploying them.
+//!
+//! Runs [`solobase_core::blocks::declarative::scan`], the loader the server
+//! uses at startup, so a directory that validates here is one the server
+//! will accept.
+
+use std::path::{Path, PathBuf};
+
+use solobase_core::blocks::declarative;
+
+/// Validate every extension under `dir` (default: `SOLOBASE_EXTENSIONS_DIR`,
+/// then `extensions`), relative to `repo_root`. Prints one line per valid
+/// extension and every problem of each invalid one; errors when any is
+/// invalid.
+pub fn validate(repo_root: &Path, dir: Option<&Path>) -> anyhow::Result<()> {
+    let dir: PathBuf = match dir {
+        Some(dir) => dir.to_path_buf(),
+        None => std::env::var("SOLOBASE_EXTENSIONS_DIR")
+            .ok()
+            .filter(|d| !d.is_empty())
+            .unwrap_or_else(|| "extensions".to_string())
+            .into(),
+    };
+    let dir = repo_root.join(dir);
+
+    let results = declarative::scan(&dir).map_err(|e| anyhow::anyhow!("{e}"))?;
+    if results.is_empty() {
+        println!("no extensions in {}", dir.display());
+        return Ok(());
+    }
+    let mut invalid = 0;
+    for result in &results {
+        match result {
+            Ok(ext) => println!(
+                "ok  {} {} ({})",
+                ext.manifest.name,
+                ext.manifest.version,
+                ext.dir.display()
+            ),
+            Err(e) => {
+                invalid += 1;
+                println!("bad {e}");
+            }
+        }
+    }
+    if invalid > 0 {
+        anyhow::bail!("{invalid} of {} extensions are invalid", results.len());
+    }
+    Ok(())
+}
@@ -7,12 +7,23 @@
 //! the `solobase.toml` schema + walk-up loader. `server` + `server_config`
 //! carry the in-process native server-boot body, invoked today by the
 //! sealed × native flow. `cmd` is the child-process runner used by the
-//! flows that shell out (cargo, wasm-pack, wafer).
+//! flows that shell out (cargo, wasm-pack, wafer). `extensions` backs
+//! `solobase extensions validate`; `routes` backs `solobase routes`.
+//! `preflight` holds the startup checks the server runs before binding;
+//! `doctor` runs them standalone as `solobase doctor`. `blocks` backs
+//! `solobase blocks list|enable|disable`. `status_file` writes the
+//! supervisor status file while the server runs.
+pub mod blocks;
 pub mod cli_args;
 pub mod cmd;
 pub mod config;
+pub mod doctor;
+pub mod extensions;
 pub mod flows;
 pub mod helpers;
 pub mod mode;
+pub mod preflight;
+pub mod routes;
 pub mod server;
 pub mod server_config;
+pub mod status_file;
@@ -0,0 +1,170 @@
+//! Native preflight: the portable checks from
+//! [`solobase_core::diagnostics`] plus the two only a server process can
+//! run — is the listen address free, and is there disk space under the
+//! data directories.
+//!
+//! `server::run` calls [`run`] after constructing its services and before
+//! binding; `solobase doctor` calls it against the same config without
+//! starting anything.
+
+use std::{
+    net::TcpListener,
+    path::{Path, PathBuf},
+    sync::Arc,
+};
+
+use solobase_core::diagnostics::{self, Check, Preflight, Report, DISK, PORT};
+use solobase_native::InfraConfig;
+use wafer_core::interfaces::{
+    database::service::DatabaseService, storage::service::StorageService,
+};
+
+/// Free space below which the disk check fails…
+const DISK_FAIL_BYTES: u64 = 100 * 1024 * 1024;
+/// …and below which it warns.
+const DISK_WARN_BYTES: u64 = 1024 * 1024 * 1024;
+
+/// Run every check against `infra`. Checks named in `skip` (or, when
+/// `None`, in `SOLOBASE_PREFLIGHT_SKIP`) are reported as skipped.
+pub async fn run(
+    infra: &InfraConfig,
+    db: Result<&Arc<dyn DatabaseService>, String>,
+    storage: Result<&Arc<dyn StorageService>, String>,
+    jwt_secret: Option<&str>,
+    skip: Option<&str>,
+) -> Report {
+    let skip = match skip {
+        Some(skip) => skip.to_string(),
+        None => std::env::var(diagnostics::SKIP_KEY).unwrap_or_default(),
+    };
+    let mut preflight = Preflight::new(&skip, Some(super::server::tokio_sleep));
+    preflight.run_portable(db, storage, jwt_secret).await;
+    preflight.run_sync(PORT, || check_port(&infra.listen));
+    preflight.run_sync(DISK, || check_disk(&data_dirs(infra)));
+    preflight.finish()
+}
+
+/// Bind the listen address and let it go again.
+pub fn check_port(listen: &str) -> Check {
+    match TcpListener::bind(listen) {
+        Ok(_) => Check::pass(PORT, format!("{listen} is free")),
+        Err(e) if e.kind() == std::io::ErrorKind::AddrInUse => Check::fail(
+            PORT,
+            format!("{listen} is already in use"),
+            "Stop the other process (e.g. `lsof -i :PORT`) or set SOLOBASE_LISTEN to a free address",
+        ),
+        Err(e) => Check::fail(
+            PORT,
+            format!("cannot bind {listen}: {e}"),
+            "Set SOLOBASE_LISTEN to a valid host:port; ports below 1024 need elevated privileges",
+        ),
+    }
+}
+
+/// The directories Solobase writes to: the SQLite file's and local
+/// storage's.
+fn data_dirs(infra: &InfraConfig) -> Vec<PathBuf> {
+    let mut dirs = Vec::new();
+    if infra.db_type.eq_ignore_ascii_case("sqlite") {
+        let parent = Path::new(&infra.db_path).parent().unwrap_or(Path::new(""));
+        dirs.push(parent.to_path_buf());
+    }
+    if infra.storage_type.eq_ignore_ascii_case("local") {
+        dirs.push(PathBuf::from(&infra.storage_root));
+    }
+    dirs
+}
+
+/// Free space on the filesystem holding each of `dirs` (or its nearest
+/// existing ancestor, for a first start); the tightest one decides.
+pub fn check_disk(dirs: &[PathBuf]) -> Check {
+    if dirs.is_empty() {
+        return Check::skipped(
+            DISK,
+            "no local data directories (remote database and storage)",
+        );
+    }
+    let mut tightest: Option<(u64, &Path)> = None;
+    for dir in dirs {
+        let existing = dir
+            .ancestors()
+            .find(|d| d.exists())
+            .filter(|d| !d.as_os_str().is_empty())
+            .unwrap_or(Path::new("."));
+        let free = match free_bytes(existing) {
+            Ok(free) => free,
+            Err(e) if e.kind() == std::io::ErrorKind::Unsupported => {
+                return Check::skipped(DISK, "not available on this platform")
+            }
+            Err(e) => {
+                return Check::warn(
+                    DISK,
+                    format!("cannot read free space under {}: {e}", dir.display()),
+                    "Check that the data directory is readable",
+                )
+            }
+        };
+        if !matches!(tightest, Some((least, _)) if least <= free) {
+            tightest = Some((free, dir));
+        }
+    }
+    let Some((free, dir)) = tightest else {
+        return Check::skipped(DISK, "no local data directories");
+    };
+    let shown = format!("{} MiB free under {}", free / (1024 * 1024), dir.display());
+    let hint =
+        "Free up disk space or move SOLOBASE_DB_PATH / SOLOBASE_STORAGE_ROOT to a larger volume";
+    if free < DISK_FAIL_BYTES {
+        Check::fail(DISK, shown, hint)
+    } else if free < DISK_WARN_BYTES {
+        Check::warn(DISK, shown, hint)
+    } else {
+        Check::pass(DISK, shown)
+    }
+}
+
+#[cfg(unix)]
+fn free_bytes(dir: &Path) -> std::io::Result<u64> {
+    use std::os::unix::ffi::OsStrExt;
+
+    let path = std::ffi::CString::new(dir.as_os_str().as_bytes())?;
+    // SAFETY: `path` is NUL-terminated and outlives the call; `stat` is a
+    // plain C struct statvfs fills in.
+    let mut stat: libc::statvfs = unsafe { std::mem::zeroed() };
+    if unsafe { libc::statvfs(path.as_ptr(), &mut stat) } != 0 {
+        return Err(std::io::Error::last_os_error());
+    }
+    Ok(stat.f_bavail as u64 * stat.f_frsize as u64)
+}
+
+#[cfg(not(unix))]
+fn free_bytes(_dir: &Path) -> std::io::Result<u64> {
+    Err(std::io::Error::new(
+        std::io::ErrorKind::Unsupported,
+        "free space is only read on unix",
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use solobase_core::diagnostics::Status;
+
+    #[test]
+    fn a_bound_port_fails() {
+        let taken = TcpListener::bind("127.0.0.1:0").unwrap();
+        let addr = taken.local_addr().unwrap().to_string();
+        assert_eq!(check_port(&addr).status, Status::Fail);
+        drop(taken);
+        assert_eq!(check_port(&addr).status, Status::Pass);
+    }
+
+    #[test]
+    fn disk_check_walks_up_to_an_existing_directory() {
+        let tmp = tempfile::tempdir().unwrap();
+        let check = check_disk(&[tmp.path().join("not/yet/created")]);
+        assert_ne!(check.status, Status::Skipped, "{}", check.detail);
+        assert!(check.detail.contains("not/yet/created"), "{}", check.detail);
+        assert_eq!(check_disk(&[]).status, Status::Skipped);
+    }
+}
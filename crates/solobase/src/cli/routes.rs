@@ -0,0 +1,59 @@
+//! `solobase routes` — print every route the server would mount.
+//!
+//! Built from the same [`SolobaseBuilder`] the server boots, with the
+//! declarative extensions from `SOLOBASE_EXTENSIONS_DIR`, so the listing
+//! is what a `serve` in this directory would route — without opening the
+//! database. Block enablement lives in the database and isn't reflected:
+//! a disabled block's routes are listed but answer 404 (`solobase blocks
+//! list` shows which blocks are disabled).
+
+use std::path::{Path, PathBuf};
+
+use anyhow::{anyhow, Context};
+use solobase_core::{blocks::declarative, builder::SolobaseBuilder};
+
+/// Print the route table as aligned columns, or as a JSON array with
+/// `json`. Extensions are loaded from `dir` (default:
+/// `SOLOBASE_EXTENSIONS_DIR`; none when unset), relative to `repo_root`.
+pub fn list(repo_root: &Path, dir: Option<&Path>, json: bool) -> anyhow::Result<()> {
+    solobase_native::load_dotenv(repo_root);
+    let dir: Option<PathBuf> = match dir {
+        Some(dir) => Some(dir.to_path_buf()),
+        None => std::env::var("SOLOBASE_EXTENSIONS_DIR")
+            .ok()
+            .filter(|d| !d.is_empty())
+            .map(PathBuf::from),
+    };
+    let extensions = match dir {
+        Some(dir) => {
+            let dir = repo_root.join(dir);
+            declarative::load_dir(&dir)
+                .map_err(|e| anyhow!("{e}"))
+                .with_context(|| format!("load extensions from {}", dir.display()))?
+        }
+        None => Vec::new(),
+    };
+
+    let routes = extensions
+        .into_iter()
+        .fold(SolobaseBuilder::new(), SolobaseBuilder::extension)
+        .routes()
+        .context("collect routes")?;
+
+    if json {
+        println!("{}", serde_json::to_string_pretty(&routes)?);
+        return Ok(());
+    }
+    let path_width = routes.iter().map(|r| r.path.len()).max().unwrap_or(0);
+    for route in &routes {
+        println!(
+            "{:<7} {:<path_width$} {:<13} {:<9} {}",
+            route.method,
+            route.path,
+            route.auth.as_str(),
+            route.source.as_str(),
+            route.block,
+        );
+    }
+    Ok(())
+}
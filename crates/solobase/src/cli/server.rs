@@ -6,17 +6,24 @@
 //! `solobase_core` seeders, builds the WAFER runtime, registers the HTTP
 //! listener, and runs the `serve_until_shutdown` loop.
 
-use std::{collections::HashMap, path::Path, sync::Arc};
+use std::{collections::HashMap, future::Future, path::Path, pin::Pin, sync::Arc};
 
 use anyhow::{anyhow, Context};
-use solobase_core::builder::{self, SolobaseBuilder};
+use solobase_core::{
+    builder::{self, SolobaseBuilder},
+    runtime_config::{self, ReloadHooks},
+};
 use solobase_native::{
-    collect_app_env_vars, init_tracing, load_dotenv, register_http_listener,
-    register_observability_hooks, serve_until_shutdown, InfraConfig,
+    collect_app_env_vars, init_tracing, load_dotenv, read_env_file, register_http_listener,
+    register_observability_hooks, serve_until_shutdown, set_log_filter, spawn_reload_on_sighup,
+    InfraConfig, LogSinkConfig, LogSinks,
 };
-use wafer_core::interfaces::config::service::ConfigService;
+use wafer_core::interfaces::{config::service::ConfigService, database::service::DatabaseService};
 
-use crate::cli::server_config::{filter_to_declared_keys, load_wrap_grants};
+use crate::cli::{
+    server_config::{filter_to_declared_keys, load_wrap_grants},
+    status_file::StatusFile,
+};
 
 /// Boot the native server end-to-end. The body mirrors the previous
 /// `main()` exactly; the signature is `pub async fn run()` so the new
@@ -35,10 +42,47 @@ pub async fn run(repo_root: &Path, run_migrations: bool) -> anyhow::Result<()> {
     // (or a future caller) spawns.
     load_dotenv(repo_root);
 
-    // 2. Initialize tracing / logging
+    // 2. Initialize tracing / logging. `SOLOBASE_LOG_SINKS` and
+    //    `SOLOBASE_REQUEST_LOG_SINKS` pick where application and request
+    //    logs go (stdout / database by default).
     let log_format = std::env::var("SOLOBASE_LOG_FORMAT").unwrap_or_else(|_| "text".into());
-    init_tracing(&log_format).context("initialize tracing subscriber")?;
+    let log_sinks = LogSinkConfig::from_env()
+        .and_then(|cfg| LogSinks::start(&cfg))
+        .context("configure log sinks")?;
+    let log_sinks = Arc::new(log_sinks);
+    init_tracing(&log_format, &log_sinks).context("initialize tracing subscriber")?;
     tracing::info!("solobase starting (Rust/WAFER runtime)");
+    if log_sinks.has_request_sinks() || !log_sinks.request_database() {
+        let sinks = Arc::clone(&log_sinks);
+        solobase_core::pipeline::set_request_log_sink(solobase_core::pipeline::RequestLogSink {
+            forward: Box::new(move |row| sinks.forward_request(row)),
+            database: log_sinks.request_database(),
+        });
+    }
+
+    // 2a. Load the hot-reloadable settings (log level, maintenance mode, …)
+    //     and re-read them on SIGHUP. On re-reads the env file wins over the
+    //     inherited process environment: it's the only source an operator
+    //     can still edit under a running process.
+    let env_dir = repo_root.to_path_buf();
+    if let Err(e) = runtime_config::install(ReloadHooks {
+        read_vars: Box::new(move || {
+            let mut vars: HashMap<String, String> = std::env::vars().collect();
+            vars.extend(read_env_file(&env_dir));
+            vars
+        }),
+        apply_log_level: set_log_filter,
+    }) {
+        tracing::warn!(err = %e, "keeping the boot log filter");
+    }
+    spawn_reload_on_sighup(|| match runtime_config::reload() {
+        Ok(report) => tracing::info!(
+            changed = ?report.changed,
+            requires_restart = ?report.requires_restart,
+            "runtime configuration reloaded"
+        ),
+        Err(e) => tracing::warn!(err = %e, "runtime configuration reload failed"),
+    })?;
 
     // 3. Read infrastructure config from SOLOBASE_* env vars
     let infra = InfraConfig::from_env();
@@ -49,9 +93,34 @@ pub async fn run(repo_root: &Path, run_migrations: bool) -> anyhow::Result<()> {
         storage = %infra.storage_type,
         "infrastructure config loaded"
     );
+    solobase_core::status::set_process(solobase_core::status::ProcessInfo {
+        listen: infra.listen.clone(),
+        db_type: infra.db_type.clone(),
+    });
+
+    // 3a. Load declarative extensions. Any invalid extension fails the boot
+    //     with its problems listed, rather than starting without it.
+    let extensions = match &infra.extensions_dir {
+        Some(dir) => solobase_core::blocks::declarative::load_dir(Path::new(dir))
+            .map_err(|e| anyhow!("{e}"))
+            .with_context(|| format!("load extensions from {dir}"))?,
+        None => Vec::new(),
+    };
+    for ext in &extensions {
+        tracing::info!(
+            name = %ext.manifest.name,
+            version = %ext.manifest.version,
+            "declarative extension loaded"
+        );
+    }
+    let extension_keys: Vec<String> = extensions
+        .iter()
+        .flat_map(|ext| ext.manifest.config.iter().map(|c| c.key.clone()))
+        .collect();
 
-    // 4. Collect app config vars from env (non-SOLOBASE_* prefixed, filtered to declared keys)
-    let env_vars = filter_to_declared_keys(collect_app_env_vars());
+    // 4. Collect app config vars from env (non-SOLOBASE_* prefixed, filtered
+    //    to declared keys, including the extensions')
+    let env_vars = filter_to_declared_keys(collect_app_env_vars(), &extension_keys);
 
     // 5. Construct the platform database service up front. Native seeds the
     //    variables / block_settings tables BEFORE the wafer exists because its
@@ -68,6 +137,14 @@ pub async fn run(repo_root: &Path, run_migrations: bool) -> anyhow::Result<()> {
     )
     .await
     .context("construct database service")?;
+    // Every call is timed per operation; slow ones are logged as they
+    // happen and the totals are logged at shutdown.
+    let database = solobase_native::InstrumentedDatabaseService::new(
+        database,
+        Some(std::time::Duration::from_millis(infra.db_slow_query_ms)).filter(|d| !d.is_zero()),
+    );
+    let db_stats = database.stats();
+    let database: Arc<dyn DatabaseService> = Arc::new(database);
 
     // Create the admin variables / block_settings tables pre-wafer by running
     // admin's migration-file SQL through the service (migration-file-runner
@@ -75,7 +152,7 @@ pub async fn run(repo_root: &Path, run_migrations: bool) -> anyhow::Result<()> {
     // re-asserts later — single schema source, no hand-rolled CREATE TABLE.
     solobase_core::migration_helper::apply_ddl_via_service(
         &database,
-        solobase_core::blocks::admin::migrations::ddl_files(&infra.db_type),
+        &solobase_core::blocks::admin::migrations::ddl_files(&infra.db_type),
     )
     .await
     .map_err(|e| anyhow!("create admin tables pre-wafer: {e}"))?;
@@ -151,8 +228,41 @@ pub async fn run(repo_root: &Path, run_migrations: bool) -> anyhow::Result<()> {
     let storage = solobase_native::make_storage_service(&infra.storage_type, &infra.storage_root)
         .await
         .context("construct storage service")?;
+    // Developer mode can inject storage faults (`/api/_dev/storage-faults`);
+    // the decorator passes every call through until a rule is added.
+    let storage = if infra.dev_mode {
+        solobase_core::storage_faults::wrap(storage)
+    } else {
+        storage
+    };
+
+    // 6a. Preflight: probe every dependency once and log the results as one
+    //     report (kept for `GET /b/admin/api/diagnostics`). Failures are
+    //     logged with their remediation hints rather than aborting: the
+    //     ones that would stop the server (a bound port) still fail below,
+    //     and the rest leave it running degraded but inspectable.
+    let report =
+        crate::cli::preflight::run(&infra, Ok(&database), Ok(&storage), Some(&jwt_secret), None)
+            .await;
+    report.log();
+    solobase_core::diagnostics::record_startup(report);
 
-    let (mut wafer, storage_block) = SolobaseBuilder::new()
+    let builder = extensions
+        .into_iter()
+        .fold(SolobaseBuilder::new(), SolobaseBuilder::extension);
+    // `SOLOBASE_CLAMAV_ADDRESS` turns on upload scanning through clamd.
+    // Without it uploads are stored unscanned, as before.
+    #[cfg(feature = "block-files")]
+    let builder = match solobase_native::ClamAvOptions::from_env().context("configure ClamAV")? {
+        Some(opts) => {
+            tracing::info!(address = %opts.address, "malware scanning via clamd");
+            builder.scan_provider(Arc::new(ClamAvScanProvider(
+                solobase_native::ClamAvClient::new(opts),
+            )))
+        }
+        None => builder,
+    };
+    let (mut wafer, storage_block) = builder
         .database(database)
         .storage(storage)
         .config(Arc::new(config_service))
@@ -165,6 +275,8 @@ pub async fn run(repo_root: &Path, run_migrations: bool) -> anyhow::Result<()> {
         // feature can open a dedicated connection for `SqliteVecService`.
         // Ignored when the feature is off.
         .sqlite_db_path(&infra.db_path)
+        .base_path(infra.base_path.clone())
+        .api_version_policy(infra.api_version_policy.clone())
         .build()
         .context("build solobase runtime")?;
 
@@ -179,6 +291,24 @@ pub async fn run(repo_root: &Path, run_migrations: bool) -> anyhow::Result<()> {
     //    (see crates/solobase-core/src/flows/site_main.rs).
     register_http_listener(&mut wafer, &infra.listen, "site-main");
 
+    // 8a. Native-only: give the shared pipeline a timer so handlers are
+    //     bounded by `SOLOBASE_SHARED__REQUEST_TIMEOUT_MS` (504 past it).
+    solobase_core::pipeline::set_request_timer(tokio_sleep);
+
+    // 8b. Native-only: gzip buffered API responses for clients that accept
+    //     it (`SOLOBASE_SHARED__COMPRESSION[_LEVEL]` override the defaults).
+    solobase_core::compression::install(solobase_core::compression::CompressionOptions::default());
+
+    // 8c. Native-only: developer mode mounts `/api/_dev/` for frontend work.
+    //     A production environment refuses to boot with it rather than
+    //     quietly ignoring the flag.
+    if infra.dev_mode {
+        let environment = vars
+            .get("SOLOBASE_SHARED__ENVIRONMENT")
+            .map_or("development", String::as_str);
+        solobase_core::dev_mode::install(environment).context("enable developer mode")?;
+    }
+
     // 9. Register observability hooks
     register_observability_hooks(&mut wafer);
 
@@ -215,17 +345,111 @@ pub async fn run(repo_root: &Path, run_migrations: bool) -> anyhow::Result<()> {
         .context("boot WAFER runtime")?;
     wafer.run_start_lifecycle().await;
     let wafer = wafer.bind_all();
-    tracing::info!("WAFER runtime started — all blocks resolved");
+    tracing::info!(
+        "WAFER runtime started — all blocks resolved — solobase {}",
+        solobase_core::version::describe()
+    );
 
-    // 13. Wait for shutdown signal, then graceful shutdown
-    serve_until_shutdown(&wafer)
-        .await
-        .context("await shutdown signal")?;
+    // 13. Wait for shutdown signal, then graceful shutdown. The background
+    //     job worker polls alongside and is dropped with the listener;
+    //     jobs it was running are handed out again once their lease expires.
+    //     So is the status file writer (`SOLOBASE_STATUS_FILE`), which then
+    //     leaves a final "stopped" snapshot, and the write-buffer flusher,
+    //     whose pending rows are written before the runtime stops.
+    let status_file = infra.status_file.as_ref().map(|path| {
+        StatusFile::new(
+            path,
+            std::time::Duration::from_secs(infra.status_interval_secs),
+        )
+    });
+    let write_status = async {
+        match &status_file {
+            Some(file) => file.run().await,
+            None => std::future::pending().await,
+        }
+    };
+    tokio::select! {
+        served = serve_until_shutdown(&wafer, solobase_core::write_buffer::shutdown()) => {
+            served.context("await shutdown signal")?
+        }
+        () = solobase_core::blocks::jobs::run_worker(tokio_sleep) => {}
+        () = solobase_core::write_buffer::run_flusher(tokio_sleep) => {}
+        () = write_status => {}
+    }
+    if let Some(file) = &status_file {
+        file.stop().await;
+    }
+    log_db_stats(&db_stats);
+    log_sink_stats(&log_sinks);
     tracing::info!("solobase shutdown complete");
+    // Give the sinks a moment to deliver what is still queued.
+    log_sinks.flush(std::time::Duration::from_secs(5)).await;
 
     Ok(())
 }
 
+/// Log each external log sink's backlog and losses for this run.
+fn log_sink_stats(sinks: &LogSinks) {
+    for s in sinks.stats() {
+        if s.dropped > 0 {
+            tracing::warn!(
+                sink = %s.name,
+                pending = s.pending,
+                dropped = s.dropped,
+                "log sink dropped entries"
+            );
+        } else {
+            tracing::info!(sink = %s.name, pending = s.pending, "log sink totals");
+        }
+    }
+}
+
+/// Log the busiest database operations of this run, most total time first.
+fn log_db_stats(stats: &solobase_native::DbStats) {
+    for (op, s) in stats.snapshot().into_iter().take(20) {
+        tracing::info!(
+            op = %op,
+            calls = s.calls,
+            errors = s.errors,
+            total_ms = s.total.as_millis() as u64,
+            max_ms = s.max.as_millis() as u64,
+            "database operation totals"
+        );
+    }
+}
+
+/// [`solobase_core::pipeline::RequestTimer`] backed by the tokio runtime.
+pub(crate) fn tokio_sleep(d: std::time::Duration) -> Pin<Box<dyn Future<Output = ()> + Send>> {
+    Box::pin(tokio::time::sleep(d))
+}
+
+/// Adapts the native clamd client to the files block's scanner seam. Any
+/// client error — clamd down, timed out, file over its limit — is
+/// unscannable, which leaves the upload held and the scan job retrying.
+#[cfg(feature = "block-files")]
+struct ClamAvScanProvider(solobase_native::ClamAvClient);
+
+#[cfg(feature = "block-files")]
+#[async_trait::async_trait]
+impl solobase_core::blocks::files::scan::ScanProvider for ClamAvScanProvider {
+    async fn scan(
+        &self,
+        data: &[u8],
+        _filename: &str,
+    ) -> solobase_core::blocks::files::scan::Verdict {
+        use solobase_core::blocks::files::scan::Verdict;
+        match self.0.scan(data).await {
+            Ok(solobase_native::ClamAvVerdict::Clean) => Verdict::Clean,
+            Ok(solobase_native::ClamAvVerdict::Infected(signature)) => {
+                Verdict::Infected { signature }
+            }
+            Err(e) => Verdict::Unscannable {
+                reason: e.to_string(),
+            },
+        }
+    }
+}
+
 /// Native [`BootHooks`](builder::BootHooks). Native seeds the variables /
 /// block_settings tables pre-wafer (its immutable crypto service and config
 /// snapshot need the values at `build()` time), so — like the Cloudflare hook
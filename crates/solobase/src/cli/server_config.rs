@@ -7,12 +7,21 @@
 
 use std::collections::HashMap;
 
-pub fn filter_to_declared_keys(env_vars: HashMap<String, String>) -> Vec<(String, String)> {
+/// `extra_keys` are keys declared outside the compiled-in blocks — the
+/// config of loaded declarative extensions.
+pub fn filter_to_declared_keys(
+    env_vars: HashMap<String, String>,
+    extra_keys: &[String],
+) -> Vec<(String, String)> {
     let block_infos = solobase_core::blocks::all_block_infos();
     let all_vars = solobase_core::config_vars::collect_all_config_vars(&block_infos);
     // Borrow the declared keys directly — the HashSet only lives for the
     // duration of the filter, so there's no need to allocate owned Strings.
-    let known: std::collections::HashSet<&str> = all_vars.iter().map(|v| v.key.as_str()).collect();
+    let known: std::collections::HashSet<&str> = all_vars
+        .iter()
+        .map(|v| v.key.as_str())
+        .chain(extra_keys.iter().map(String::as_str))
+        .collect();
     env_vars
         .into_iter()
         .filter(|(k, _)| known.contains(k.as_str()))
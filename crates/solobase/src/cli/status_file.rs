@@ -0,0 +1,101 @@
+//! The status file: [`solobase_core::status`] snapshots written to
+//! `SOLOBASE_STATUS_FILE` for supervisors that would rather read a file
+//! than poll an authenticated endpoint.
+//!
+//! `server::run` races [`StatusFile::run`] against the listener, then calls
+//! [`StatusFile::stop`] once shutdown completes. Each write goes to a
+//! temporary file beside the target and is renamed over it, so a reader
+//! never sees half a snapshot. A failed write is logged and the next tick
+//! tries again; it never stops the server.
+
+use std::{
+    path::{Path, PathBuf},
+    time::Duration,
+};
+
+use solobase_core::status::{self, Snapshot};
+
+pub struct StatusFile {
+    path: PathBuf,
+    interval: Duration,
+}
+
+impl StatusFile {
+    pub fn new(path: impl Into<PathBuf>, interval: Duration) -> Self {
+        Self {
+            path: path.into(),
+            interval,
+        }
+    }
+
+    /// Rewrite the file every interval; never returns. Until the admin
+    /// block has initialized there is no context to probe the database
+    /// with, and the snapshot reports it as unknown.
+    pub async fn run(&self) {
+        tracing::info!(path = %self.path.display(), "writing status file");
+        loop {
+            let ctx = solobase_core::blocks::jobs::worker_context();
+            self.write(&status::snapshot(ctx).await);
+            tokio::time::sleep(self.interval).await;
+        }
+    }
+
+    /// Leave the final `"stopped"` snapshot. The runtime has shut down by
+    /// now, so the database isn't asked.
+    pub async fn stop(&self) {
+        self.write(&status::snapshot(None).await.stopped());
+    }
+
+    fn write(&self, snapshot: &Snapshot) {
+        if let Err(e) = write_atomic(&self.path, snapshot) {
+            tracing::warn!(path = %self.path.display(), err = %e, "status file not written");
+        }
+    }
+}
+
+/// Write `snapshot` to a temporary file next to `path`, then rename it
+/// into place.
+fn write_atomic(path: &Path, snapshot: &Snapshot) -> std::io::Result<()> {
+    let mut body = serde_json::to_vec_pretty(snapshot)?;
+    body.push(b'\n');
+    let name = path
+        .file_name()
+        .map(|n| n.to_string_lossy().into_owned())
+        .unwrap_or_default();
+    let tmp = path.with_file_name(format!(".{name}.tmp"));
+    std::fs::write(&tmp, &body)?;
+    std::fs::rename(&tmp, path).inspect_err(|_| {
+        std::fs::remove_file(&tmp).ok();
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn snapshots_replace_the_file_whole() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("status.json");
+        let file = StatusFile::new(&path, Duration::from_secs(30));
+
+        file.write(&status::snapshot(None).await);
+        let body: serde_json::Value =
+            serde_json::from_slice(&std::fs::read(&path).unwrap()).unwrap();
+        assert_eq!(body["status"], "running");
+        assert_eq!(body["pid"], std::process::id());
+
+        file.stop().await;
+        let body: serde_json::Value =
+            serde_json::from_slice(&std::fs::read(&path).unwrap()).unwrap();
+        assert_eq!(body["status"], "stopped");
+        // Only the status file is left behind.
+        assert_eq!(std::fs::read_dir(dir.path()).unwrap().count(), 1);
+    }
+
+    #[tokio::test]
+    async fn an_unwritable_path_is_not_fatal() {
+        let file = StatusFile::new("/nonexistent-dir/status.json", Duration::from_secs(30));
+        file.stop().await;
+    }
+}
@@ -11,9 +11,12 @@ use std::process::ExitCode;
 
 use clap::Parser;
 use solobase::cli::{
-    cli_args::{Cli, Command, DeployAction, Target},
+    blocks,
+    cli_args::{BlocksAction, Cli, Command, DeployAction, ExtensionsAction, Target},
+    doctor, extensions,
     flows::{embed_cloudflare, embed_native, embed_web, sealed_native, sealed_web},
     mode::{default_target, detect_mode, Mode, ModeContext},
+    routes,
 };
 
 #[tokio::main]
@@ -73,6 +76,19 @@ async fn run() -> anyhow::Result<()> {
                 None => dispatch_deploy(&ctx, target, release).await,
             }
         }
+        Command::Extensions {
+            action: ExtensionsAction::Validate { dir },
+        } => extensions::validate(&ctx.cwd, dir.as_deref()),
+        Command::Routes {
+            json,
+            extensions_dir,
+        } => routes::list(&ctx.cwd, extensions_dir.as_deref(), json),
+        Command::Doctor { json, skip } => doctor::run(&ctx.cwd, json, skip.as_deref()).await,
+        Command::Blocks { action } => match action {
+            BlocksAction::List { json } => blocks::list(&ctx.cwd, json).await,
+            BlocksAction::Enable { name } => blocks::set_enabled(&ctx.cwd, &name, true).await,
+            BlocksAction::Disable { name } => blocks::set_enabled(&ctx.cwd, &name, false).await,
+        },
     }
 }
 
@@ -2,7 +2,7 @@
 //! design (build/serve, --target native|web, --release, --port).
 
 use clap::Parser;
-use solobase::cli::cli_args::{Cli, Command, DeployAction, Target};
+use solobase::cli::cli_args::{Cli, Command, DeployAction, ExtensionsAction, Target};
 
 #[test]
 fn parses_build_with_target_native() {
@@ -86,3 +86,24 @@ fn bare_solobase_uses_serve_native_default() {
         panic!("expected Serve");
     }
 }
+
+#[test]
+fn parses_extensions_validate_with_optional_dir() {
+    let cli = Cli::parse_from(["solobase", "extensions", "validate", "exts"]);
+    if let Command::Extensions {
+        action: ExtensionsAction::Validate { dir },
+    } = cli.command
+    {
+        assert_eq!(dir.as_deref(), Some(std::path::Path::new("exts")));
+    } else {
+        panic!("expected Extensions");
+    }
+
+    let cli = Cli::parse_from(["solobase", "extensions", "validate"]);
+    assert!(matches!(
+        cli.command,
+        Command::Extensions {
+            action: ExtensionsAction::Validate { dir: None }
+        }
+    ));
+}
@@ -61,7 +61,7 @@ async fn build_runtime(
     // `seed_and_load_variables` and `load_and_seed_block_settings` read them.
     solobase_core::migration_helper::apply_ddl_via_service(
         &database,
-        solobase_core::blocks::admin::migrations::ddl_files("sqlite"),
+        &solobase_core::blocks::admin::migrations::ddl_files("sqlite"),
     )
     .await
     .expect("apply admin tables pre-wafer");
@@ -0,0 +1,195 @@
+//! Boots a real runtime with whatever feature blocks this build compiled in
+//! and checks that the schema and the route table follow them: a block that
+//! isn't compiled in creates no tables and answers no routes, while the core
+//! routes keep working.
+//!
+//! Meaningful in any build, but written for the minimal one — CI runs it as
+//!
+//! ```text
+//! cargo test -p solobase --no-default-features --features minimal --test minimal_build
+//! ```
+//!
+//! (see docs/minimal-build.md). The runtime is built the way
+//! `tests/deploy_init.rs` builds it, which mirrors `cli/server.rs::run`.
+
+use std::{path::Path, sync::Arc};
+
+use solobase_core::{
+    builder::{BootHooks, SolobaseBuilder},
+    deploy_init::deploy_init,
+};
+use wafer_core::interfaces::{config::service::ConfigService, database::service::DatabaseService};
+use wafer_run::{streams::output::TerminalNotResponse, InputStream, Message, Wafer};
+
+struct NoopBootHooks;
+
+#[wafer_block::wafer_async_trait]
+impl BootHooks for NoopBootHooks {
+    async fn seed_after_admin_init(&self, _wafer: &Wafer) -> Result<(), String> {
+        Ok(())
+    }
+}
+
+/// Each optional feature block: whether this build compiled it in, one of
+/// the tables its first migration creates, and one of its routes.
+fn optional_blocks() -> Vec<(&'static str, bool, &'static str, &'static str)> {
+    vec![
+        (
+            "files",
+            cfg!(feature = "block-files"),
+            "suppers_ai__files__buckets",
+            "/b/storage/api/buckets",
+        ),
+        (
+            "legalpages",
+            cfg!(feature = "block-legalpages"),
+            "suppers_ai__legalpages__documents",
+            "/b/legalpages/api/documents",
+        ),
+        (
+            "messages",
+            cfg!(feature = "block-messages"),
+            "suppers_ai__messages__contexts",
+            "/b/messages/api/contexts",
+        ),
+        (
+            "products",
+            cfg!(feature = "block-products"),
+            "suppers_ai__products__products",
+            "/b/products/api/products",
+        ),
+        (
+            "userportal",
+            cfg!(feature = "block-userportal"),
+            "suppers_ai__userportal__buttons",
+            "/b/userportal/",
+        ),
+        (
+            "vector",
+            cfg!(feature = "block-vector"),
+            "suppers_ai__vector__registry",
+            "/b/vector/api/indexes",
+        ),
+        (
+            "llm",
+            cfg!(feature = "block-llm"),
+            "suppers_ai__llm__settings",
+            "/b/llm/",
+        ),
+    ]
+}
+
+async fn boot(db_path: &Path, storage_root: &Path) -> (Wafer, Arc<dyn DatabaseService>) {
+    let db_path = db_path.to_str().expect("db path is valid utf-8");
+    let database = solobase_native::make_database_service("sqlite", db_path, None)
+        .await
+        .expect("construct sqlite database service");
+    solobase_core::migration_helper::apply_ddl_via_service(
+        &database,
+        &solobase_core::blocks::admin::migrations::ddl_files("sqlite"),
+    )
+    .await
+    .expect("apply admin tables pre-wafer");
+    let vars = solobase_core::boot::seed_and_load_variables(&database, &[])
+        .await
+        .expect("seed and load variables");
+    let jwt_secret = vars
+        .get(solobase_core::blocks::auth::JWT_SECRET_KEY)
+        .cloned()
+        .expect("JWT secret auto-seeded");
+    let features = solobase_core::features::load_and_seed_block_settings(&database).await;
+
+    let config_service = wafer_core::service_blocks::config::EnvConfigService::new();
+    for (key, value) in &vars {
+        config_service.set(key, value);
+    }
+    config_service.set(
+        solobase_core::features::BLOCK_SETTINGS_CONFIG_KEY,
+        &features.to_config_json(),
+    );
+    let mut snapshot = vars.clone();
+    snapshot.insert(
+        solobase_core::features::BLOCK_SETTINGS_CONFIG_KEY.to_string(),
+        features.to_config_json(),
+    );
+
+    let storage_root = storage_root.to_str().expect("storage root is valid utf-8");
+    let storage = solobase_native::make_storage_service("local", storage_root)
+        .await
+        .expect("construct local storage service");
+
+    let (mut wafer, storage_block) = SolobaseBuilder::new()
+        .database(database.clone())
+        .storage(storage)
+        .config(Arc::new(config_service))
+        .config_source(Arc::new(wafer_run::StaticConfigSource::new(vars.clone())))
+        .crypto(solobase_native::make_jwt_crypto_service(jwt_secret).expect("jwt crypto service"))
+        .network(solobase_native::make_fetch_network_service())
+        .logger(solobase_native::make_tracing_logger())
+        .block_settings(features)
+        .sqlite_db_path(db_path)
+        .build()
+        .expect("build solobase runtime");
+    wafer.set_config_snapshot(snapshot);
+
+    let report = deploy_init(&mut wafer, &storage_block, &NoopBootHooks)
+        .await
+        .expect("seal");
+    assert!(report.ok, "boot must succeed: {report:?}");
+    (wafer, database)
+}
+
+/// `GET path` through the `site-main` flow, as an anonymous client.
+async fn get(wafer: &Wafer, path: &str) -> u16 {
+    let mut msg = Message::new("http.request");
+    msg.set_meta("req.action", "retrieve");
+    msg.set_meta("req.resource", path);
+    msg.set_meta("req.client.ip", "127.0.0.1");
+    let out = wafer
+        .run("site-main", msg, InputStream::from_bytes(Vec::new()))
+        .await;
+    match out.collect_buffered().await {
+        Ok(buf) | Err(TerminalNotResponse::Halt(buf)) => {
+            wafer_block::http_codec::resolve_status(&buf.meta, 200)
+        }
+        Err(TerminalNotResponse::Error(e)) => wafer_block::http_codec::resolve_error_status(&e),
+        Err(other) => panic!("GET {path}: pipeline returned {other:?}"),
+    }
+}
+
+#[tokio::test]
+async fn only_compiled_in_blocks_create_tables_and_routes() {
+    let tmp = tempfile::tempdir().expect("tempdir");
+    let storage_root = tmp.path().join("storage");
+    std::fs::create_dir_all(&storage_root).expect("create storage root");
+    let (wafer, db) = boot(&tmp.path().join("minimal.sqlite3"), &storage_root).await;
+
+    for table in [
+        "suppers_ai__admin__variables",
+        "suppers_ai__admin__block_settings",
+        "suppers_ai__auth__users",
+    ] {
+        assert!(
+            db.schema_table_exists(table).await.unwrap(),
+            "core table {table} missing"
+        );
+    }
+    assert_eq!(get(&wafer, "/health").await, 200);
+    // Auth answers (and asks for a session) rather than 404ing.
+    assert_eq!(get(&wafer, "/b/auth/api/me").await, 401);
+
+    for (block, compiled, table, route) in optional_blocks() {
+        assert_eq!(
+            db.schema_table_exists(table).await.unwrap(),
+            compiled,
+            "{block}: table {table} present iff the block is compiled in"
+        );
+        if !compiled {
+            assert_eq!(
+                get(&wafer, route).await,
+                404,
+                "{block}: {route} must not resolve without the block"
+            );
+        }
+    }
+}
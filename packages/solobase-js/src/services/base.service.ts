@@ -10,6 +10,9 @@ export interface RequestConfig {
   responseType?: string;
 }
 
+/** The API version the SDK is written against. */
+export const DEFAULT_API_PREFIX = "/api/v1";
+
 export class BaseService {
   protected wafer: WaferClient;
   protected config: SolobaseConfig;
@@ -17,7 +20,7 @@ export class BaseService {
   constructor(config: SolobaseConfig) {
     this.config = config;
     this.wafer = new WaferClient({
-      url: config.url,
+      url: this.apiUrl,
       apiKey: config.apiKey,
       headers: {
         "Content-Type": "application/json",
@@ -29,6 +32,11 @@ export class BaseService {
     });
   }
 
+  /** `config.url` with the API prefix, the base of every request path. */
+  protected get apiUrl(): string {
+    return this.config.url + (this.config.apiPrefix ?? DEFAULT_API_PREFIX);
+  }
+
   protected async request<T>(config: RequestConfig): Promise<T> {
     // Build the full API path
     let path = config.url;
@@ -107,7 +115,7 @@ export class BaseService {
     formData: FormData,
     headers?: Record<string, string>,
   ): Promise<T> {
-    const fullUrl = this.config.url + url;
+    const fullUrl = this.apiUrl + url;
     const fetchHeaders: Record<string, string> = { ...headers };
 
     if (this.config.apiKey) {
@@ -146,7 +154,7 @@ export class BaseService {
    * Fetch a response as a Blob (for file downloads).
    */
   protected async requestBlob(url: string): Promise<Blob> {
-    const fullUrl = this.config.url + url;
+    const fullUrl = this.apiUrl + url;
     const headers: Record<string, string> = {};
 
     if (this.config.apiKey) {
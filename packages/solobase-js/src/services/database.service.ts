@@ -38,7 +38,7 @@ export class DatabaseService extends BaseService {
   ): Promise<Collection> {
     return this.request<Collection>({
       method: "POST",
-      url: "/api/collections",
+      url: "/collections",
       data: { name, schema },
     });
   }
@@ -49,7 +49,7 @@ export class DatabaseService extends BaseService {
   async deleteCollection(name: string): Promise<void> {
     await this.request<void>({
       method: "DELETE",
-      url: `/api/collections/${name}`,
+      url: `/collections/${name}`,
     });
   }
 
@@ -59,7 +59,7 @@ export class DatabaseService extends BaseService {
   async listCollections(): Promise<Collection[]> {
     return this.request<Collection[]>({
       method: "GET",
-      url: "/api/collections",
+      url: "/collections",
     });
   }
 
@@ -69,7 +69,7 @@ export class DatabaseService extends BaseService {
   async getCollection(name: string): Promise<Collection> {
     return this.request<Collection>({
       method: "GET",
-      url: `/api/collections/${name}`,
+      url: `/collections/${name}`,
     });
   }
 
@@ -81,7 +81,7 @@ export class DatabaseService extends BaseService {
   ): Promise<T> {
     return this.request<T>({
       method: "POST",
-      url: `/api/collections/${options.collection}/records`,
+      url: `/collections/${options.collection}/records`,
       data: options.data,
     });
   }
@@ -96,7 +96,7 @@ export class DatabaseService extends BaseService {
     try {
       return await this.request<T>({
         method: "GET",
-        url: `/api/collections/${collection}/records/${id}`,
+        url: `/collections/${collection}/records/${id}`,
       });
     } catch (error) {
       return null;
@@ -111,7 +111,7 @@ export class DatabaseService extends BaseService {
   ): Promise<T> {
     return this.request<T>({
       method: "PATCH",
-      url: `/api/collections/${options.collection}/records/${options.id}`,
+      url: `/collections/${options.collection}/records/${options.id}`,
       data: options.data,
     });
   }
@@ -122,7 +122,7 @@ export class DatabaseService extends BaseService {
   async delete(collection: string, id: string): Promise<void> {
     await this.request<void>({
       method: "DELETE",
-      url: `/api/collections/${collection}/records/${id}`,
+      url: `/collections/${collection}/records/${id}`,
     });
   }
 
@@ -136,7 +136,7 @@ export class DatabaseService extends BaseService {
     const queryString = options ? this.buildQueryString(options) : "";
     return this.request<PaginatedResponse<T>>({
       method: "GET",
-      url: `/api/collections/${collection}/records${queryString ? `?${queryString}` : ""}`,
+      url: `/collections/${collection}/records${queryString ? `?${queryString}` : ""}`,
     });
   }
 
@@ -199,7 +199,7 @@ export class DatabaseService extends BaseService {
       async count(): Promise<number> {
         const response = await self.request<{ count: number }>({
           method: "GET",
-          url: `/api/collections/${collection}/count`,
+          url: `/collections/${collection}/count`,
           params: queryParams.filter,
         });
         return response.count;
@@ -222,7 +222,7 @@ export class DatabaseService extends BaseService {
   ): Promise<T[]> {
     return this.request<T[]>({
       method: "POST",
-      url: "/api/database/transaction",
+      url: "/database/transaction",
       data: { operations },
     });
   }
@@ -233,7 +233,7 @@ export class DatabaseService extends BaseService {
   async rawQuery<T = any>(sql: string, params?: any[]): Promise<T[]> {
     return this.request<T[]>({
       method: "POST",
-      url: "/api/database/query",
+      url: "/database/query",
       data: { sql, params },
     });
   }
@@ -244,7 +244,7 @@ export class DatabaseService extends BaseService {
   async backupCollection(collection: string): Promise<{ url: string }> {
     return this.request<{ url: string }>({
       method: "POST",
-      url: `/api/collections/${collection}/backup`,
+      url: `/collections/${collection}/backup`,
     });
   }
 
@@ -257,7 +257,7 @@ export class DatabaseService extends BaseService {
   ): Promise<void> {
     await this.request<void>({
       method: "POST",
-      url: `/api/collections/${collection}/restore`,
+      url: `/collections/${collection}/restore`,
       data: { backup_url: backupUrl },
     });
   }
@@ -273,7 +273,7 @@ export class DatabaseService extends BaseService {
   }> {
     return this.request({
       method: "GET",
-      url: `/api/collections/${collection}/stats`,
+      url: `/collections/${collection}/stats`,
     });
   }
 
@@ -287,7 +287,7 @@ export class DatabaseService extends BaseService {
   ): Promise<void> {
     await this.request<void>({
       method: "POST",
-      url: `/api/collections/${collection}/indexes`,
+      url: `/collections/${collection}/indexes`,
       data: { field, ...options },
     });
   }
@@ -298,7 +298,7 @@ export class DatabaseService extends BaseService {
   async dropIndex(collection: string, field: string): Promise<void> {
     await this.request<void>({
       method: "DELETE",
-      url: `/api/collections/${collection}/indexes/${field}`,
+      url: `/collections/${collection}/indexes/${field}`,
     });
   }
 }
@@ -148,7 +148,7 @@ export class StorageService extends BaseService {
    * @param objectId - The ID of the object
    */
   getDownloadUrl(bucketName: string, objectId: string): string {
-    return `${this.config.url}/b/storage/api/buckets/${bucketName}/objects/${objectId}/download`;
+    return `${this.apiUrl}/b/storage/api/buckets/${bucketName}/objects/${objectId}/download`;
   }
 
   /**
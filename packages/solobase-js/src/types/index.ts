@@ -12,6 +12,11 @@ export interface SolobaseConfig {
 	url: string;
 	/** URL for the auth UI (login page). Defaults to `url` if not specified. */
 	authUrl?: string;
+	/**
+	 * Path prefix of the API, appended to `url`. Defaults to the current
+	 * version, `/api/v1`; `""` addresses the routes without a prefix.
+	 */
+	apiPrefix?: string;
 	apiKey?: string;
 	headers?: Record<string, string>;
 	timeout?: number;
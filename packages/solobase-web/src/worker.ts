@@ -1,8 +1,13 @@
 // Re-export the WASM module's initialize and handleRequest for composable mode.
 // Developers who have an existing SW can import these directly.
 
-import init, { initialize as wasmInitialize, handle_request as wasmHandleRequest } from './wasm/solobase_web.js';
+import init, {
+  initialize as wasmInitialize,
+  handle_request as wasmHandleRequest,
+  init_status as wasmInitStatus,
+} from './wasm/solobase_web.js';
 
+let wasmLoaded = false;
 let initialized = false;
 let routes: string[] = ['/b/', '/health', '/openapi.json', '/.well-known/agent.json'];
 
@@ -12,21 +17,39 @@ let routes: string[] = ['/b/', '/health', '/openapi.json', '/.well-known/agent.j
  */
 export async function initialize(): Promise<void> {
   if (initialized) return;
-  await init();
+  if (!wasmLoaded) {
+    await init();
+    wasmLoaded = true;
+  }
   await wasmInitialize();
   initialized = true;
 }
 
 /**
  * Handle an incoming fetch request through the Solobase WASM runtime.
+ * Before the runtime is up, each request retries the boot (the runtime
+ * bounds the attempts) and gets a 503 if it still fails.
  */
 export async function handleRequest(request: Request): Promise<Response> {
   if (!initialized) {
-    return new Response('Solobase not initialized', { status: 503 });
+    try {
+      await initialize();
+    } catch {
+      return new Response('Solobase not initialized', { status: 503 });
+    }
   }
   return await wasmHandleRequest(request);
 }
 
+/**
+ * Boot state of the runtime — `{state, reason?, retrying?, attempts,
+ * max_attempts, total_ms, components}` — for health-gating traffic.
+ */
+export function initStatus(): unknown {
+  if (!wasmLoaded) return { state: 'not_started' };
+  return wasmInitStatus();
+}
+
 /**
  * Check if a URL path should be handled by Solobase.
  */